@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Safety is how much an action can change: reading state, changing it, or
+// disrupting a service or losing data. The controller refuses actions above
+// its permitted level, set by "safety" in the config or --read-only.
+type Safety int
+
+// The zero Safety is an untagged action, treated as SafetyWrite.
+const (
+	SafetyRead        Safety = iota + 1 // only looks: checks, reports, the UI
+	SafetyWrite                         // changes state: collects, sends, starts, flushes
+	SafetyDestructive                   // disrupts service or loses data; callers confirm first
+)
+
+// ErrNotPermitted is returned, wrapped, for actions above the permitted safety level.
+var ErrNotPermitted = errors.New("action not permitted")
+
+func (s Safety) String() string {
+	switch s {
+	case SafetyRead:
+		return "read"
+	case SafetyDestructive:
+		return "destructive"
+	}
+	return "write"
+}
+
+// ParseSafety reads a safety level: "read" (or "read-only"), "write" or
+// "destructive". Empty means destructive, i.e. every action is allowed.
+func ParseSafety(s string) (Safety, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "read", "read-only", "readonly":
+		return SafetyRead, nil
+	case "write":
+		return SafetyWrite, nil
+	case "", "destructive":
+		return SafetyDestructive, nil
+	}
+	return 0, fmt.Errorf("invalid safety %q (use read, write or destructive)", s)
+}
+
+// level maps an untagged action to SafetyWrite.
+func (s Safety) level() Safety {
+	if s == 0 {
+		return SafetyWrite
+	}
+	return s
+}
+
+// ActionParam is an argument an action prompts for before it runs.
+type ActionParam struct {
+	Name    string // key passed as name=value in args["args"]
+	Label   string // prompt text
+	Default string
+}
+
+// ActionSpec describes an action a plugin can run against a single host.
+// The controller runs it as OnCommand with args["action"] = Action and
+// args["args"] = "host=<key> address=<address>" followed by any params.
+type ActionSpec struct {
+	Action  string
+	Label   string
+	Safety  Safety // SafetyDestructive actions must be confirmed before running
+	Params  []ActionParam
+	Applies func(host Host) bool // nil means every host
+}
+
+// ActionProvider is implemented by plugins that advertise host actions.
+type ActionProvider interface {
+	Actions() []ActionSpec
+}
+
+// SafetyProvider is implemented by plugins with OnCommand actions they do not
+// advertise, to give their safety level. Actions neither advertised nor
+// classified here are SafetyWrite.
+type SafetyProvider interface {
+	ActionSafety(action string) Safety
+}
+
+// ActionSafety returns the safety level of a plugin's OnCommand action.
+func (c *Controller) ActionSafety(pluginName, action string) Safety {
+	p, ok := c.Plugins[strings.ToLower(pluginName)]
+	if !ok {
+		return SafetyWrite
+	}
+	if ap, ok := p.(ActionProvider); ok {
+		for _, spec := range ap.Actions() {
+			if spec.Action == action {
+				return spec.Safety.level()
+			}
+		}
+	}
+	if sp, ok := p.(SafetyProvider); ok {
+		return sp.ActionSafety(action).level()
+	}
+	return SafetyWrite
+}
+
+// Permits reports whether actions of the given safety level may run.
+func (c *Controller) Permits(s Safety) bool {
+	allowed := c.Safety
+	if allowed == 0 {
+		allowed = SafetyDestructive
+	}
+	return s.level() <= allowed
+}
+
+// checkSafety refuses an action above the permitted level.
+func (c *Controller) checkSafety(pluginName, action string) error {
+	level := c.ActionSafety(pluginName, action)
+	if c.Permits(level) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s %s is a %s action and safety is set to %s (see --read-only and \"safety\" in the config)",
+		ErrNotPermitted, strings.ToLower(pluginName), action, level, c.Safety)
+}
+
+// HostAction is an ActionSpec bound to the plugin that provides it.
+type HostAction struct {
+	Plugin string // controller key of the providing plugin
+	ActionSpec
+}
+
+// HostActions returns the actions applicable to host and permitted by the
+// safety level, ordered by plugin then label.
+func (c *Controller) HostActions(host Host) []HostAction {
+	var actions []HostAction
+	for name, p := range c.Plugins {
+		ap, ok := p.(ActionProvider)
+		if !ok {
+			continue
+		}
+		for _, spec := range ap.Actions() {
+			if (spec.Applies == nil || spec.Applies(host)) && c.Permits(spec.Safety) {
+				actions = append(actions, HostAction{Plugin: name, ActionSpec: spec})
+			}
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].Plugin != actions[j].Plugin {
+			return actions[i].Plugin < actions[j].Plugin
+		}
+		return actions[i].Label < actions[j].Label
+	})
+	return actions
+}
+
+// CollectsWith reports whether any of the host's collect tasks uses the named plugin.
+func (h Host) CollectsWith(pluginName string) bool {
+	for _, t := range h.Collect {
+		name, _, _ := strings.Cut(strings.TrimSpace(t.Metric), ".")
+		if strings.EqualFold(name, pluginName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// svcPlugin advertises an action of every safety level and classifies the
+// ones it does not advertise; it records the actions it runs.
+type svcPlugin struct {
+	BasePlugin
+	ran []string
+}
+
+func (p *svcPlugin) Name() string { return "svc" }
+
+func (p *svcPlugin) Actions() []ActionSpec {
+	return []ActionSpec{
+		{Action: "status", Label: "Status", Safety: SafetyRead},
+		{Action: "start", Label: "Start", Safety: SafetyWrite},
+		{Action: "stop", Label: "Stop", Safety: SafetyDestructive},
+		{Action: "reload", Label: "Reload"}, // untagged
+	}
+}
+
+// ActionSafety is only consulted for actions Actions does not list.
+func (p *svcPlugin) ActionSafety(action string) Safety {
+	switch action {
+	case "report", "stop":
+		return SafetyRead
+	case "purge":
+		return SafetyDestructive
+	}
+	return 0
+}
+
+func (p *svcPlugin) OnCommand(args map[string]string) error {
+	p.ran = append(p.ran, args["action"])
+	return nil
+}
+
+func TestActionSafety(t *testing.T) {
+	c := NewController()
+	c.AddPlugin(&svcPlugin{})
+	c.AddPlugin(&BasePlugin{}) // neither advertises nor classifies
+	for _, tt := range []struct {
+		plugin, action string
+		want           Safety
+	}{
+		{"svc", "status", SafetyRead},
+		{"SVC", "start", SafetyWrite},
+		{"svc", "stop", SafetyDestructive}, // the advertised level wins
+		{"svc", "reload", SafetyWrite},
+		{"svc", "report", SafetyRead},
+		{"svc", "purge", SafetyDestructive},
+		{"svc", "other", SafetyWrite},
+		{"baseplugin", "anything", SafetyWrite},
+		{"missing", "status", SafetyWrite},
+	} {
+		if got := c.ActionSafety(tt.plugin, tt.action); got != tt.want {
+			t.Errorf("%s %s = %s, want %s", tt.plugin, tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestSafetyEnforcement(t *testing.T) {
+	actions := []string{"status", "report", "start", "reload", "other", "stop", "purge"}
+	for _, tt := range []struct {
+		safety  Safety
+		allowed string
+	}{
+		{SafetyRead, "status report"},
+		{SafetyWrite, "status report start reload other"},
+		{SafetyDestructive, "status report start reload other stop purge"},
+		{0, "status report start reload other stop purge"}, // a controller not built by NewController
+	} {
+		p := &svcPlugin{}
+		c := &Controller{Plugins: map[string]Plugin{"svc": p}, Safety: tt.safety}
+		for _, action := range actions {
+			err := c.OnCommand("svc", map[string]string{"action": action})
+			permitted := strings.Contains(" "+tt.allowed+" ", " "+action+" ")
+			if permitted && err != nil {
+				t.Errorf("%s: %s refused: %v", tt.safety, action, err)
+			}
+			if !permitted && !errors.Is(err, ErrNotPermitted) {
+				t.Errorf("%s: %s err = %v, want ErrNotPermitted", tt.safety, action, err)
+			}
+		}
+		if got := strings.Join(p.ran, " "); got != tt.allowed {
+			t.Errorf("%s: ran %q, want %q", tt.safety, got, tt.allowed)
+		}
+	}
+
+	c := NewController()
+	c.AddPlugin(&svcPlugin{})
+	c.Safety = SafetyRead
+	err := c.OnCommand("Svc", map[string]string{"action": "stop"})
+	if err == nil || !strings.Contains(err.Error(), "svc stop is a destructive action and safety is set to read") {
+		t.Errorf("err = %v", err)
+	}
+	// An unknown plugin is reported as such, not as refused.
+	if err := c.OnCommand("missing", map[string]string{"action": "stop"}); err == nil || errors.Is(err, ErrNotPermitted) {
+		t.Errorf("missing plugin: err = %v", err)
+	}
+}
+
+func TestHostActionsHideUnpermitted(t *testing.T) {
+	c := NewController()
+	c.AddPlugin(&svcPlugin{})
+	for safety, want := range map[Safety]string{
+		SafetyRead:        "Status",
+		SafetyWrite:       "Reload Start Status",
+		SafetyDestructive: "Reload Start Status Stop",
+	} {
+		c.Safety = safety
+		var labels []string
+		for _, a := range c.HostActions(Host{}) {
+			labels = append(labels, a.Label)
+		}
+		if got := strings.Join(labels, " "); got != want {
+			t.Errorf("%s: %q, want %q", safety, got, want)
+		}
+	}
+}
+
+func TestParseSafety(t *testing.T) {
+	for s, want := range map[string]Safety{
+		"read": SafetyRead, "read-only": SafetyRead, " ReadOnly ": SafetyRead,
+		"write": SafetyWrite, "destructive": SafetyDestructive, "": SafetyDestructive,
+	} {
+		if got, err := ParseSafety(s); err != nil || got != want {
+			t.Errorf("%q = %s, %v; want %s", s, got, err, want)
+		}
+	}
+	if _, err := ParseSafety("admin"); err == nil {
+		t.Error("admin accepted")
+	}
+	if err := (&Config{Safety: "admin"}).Validate(); err == nil || !strings.Contains(err.Error(), "safety:") {
+		t.Errorf("Validate: %v", err)
+	}
+	for s, want := range map[Safety]string{SafetyRead: "read", SafetyWrite: "write", SafetyDestructive: "destructive", 0: "write"} {
+		if s.String() != want {
+			t.Errorf("%d = %s, want %s", s, s, want)
+		}
+	}
+}
+
+// hostCollection stands in for the collection plugin, recording the hosts it collects.
+type hostCollection struct {
+	BasePlugin
+	hosts []string
+}
+
+func (p *hostCollection) Name() string { return "collection" }
+
+func (p *hostCollection) CollectHost(hostKey string) error {
+	p.hosts = append(p.hosts, hostKey)
+	return nil
+}
+
+func TestCollectHostSafety(t *testing.T) {
+	p := &hostCollection{}
+	c := NewController()
+	c.AddPlugin(p)
+	c.Safety = SafetyRead
+	if err := c.CollectHost("core"); !errors.Is(err, ErrNotPermitted) || len(p.hosts) != 0 {
+		t.Errorf("read-only: err = %v, collected %q", err, p.hosts)
+	}
+	c.Safety = SafetyWrite
+	if err := c.CollectHost("core"); err != nil || len(p.hosts) != 1 {
+		t.Errorf("write: err = %v, collected %q", err, p.hosts)
+	}
+}
@@ -0,0 +1,19 @@
+package plugin
+
+import "os"
+
+// AgentHostKey is the store host key under which nord records metrics about
+// itself (remote delivery health and similar self-observations).
+const AgentHostKey = "nord-agent"
+
+// AgentHostAddress is the address recorded for the agent's own host entry.
+const AgentHostAddress = "127.0.0.1"
+
+// AgentHostName returns the display name for the agent's own host entry.
+// It falls back to AgentHostKey when the hostname cannot be determined.
+func AgentHostName() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return AgentHostKey
+}
@@ -1,34 +1,485 @@
 
 package plugin
 
+import (
+	"fmt"
+	"time"
+
+	"observer/store"
+)
+
 // DatabaseConfig holds the connection URL for the metrics store.
-// Supported URL schemes: sqlite://, postgres://, mysql://
+// Supported URL schemes: sqlite://, postgres://, mysql://, clickhouse://
 // Leave URL empty to disable database persistence.
 type DatabaseConfig struct {
-	URL string `json:"url"`
+	URL          string                 `json:"url"`
+	WriteTimeout string                 `json:"write_timeout"` // Go duration a collection's store write may take; default "30s"
+	Recent       DatabaseRecentConfig   `json:"recent"`
+	Quotas       map[string]store.Quota `json:"quotas"`     // per-plugin write quotas, off when unset
+	BatchSize    int                    `json:"batch_size"` // metric records committed per transaction; default 1000
+}
+
+// DefaultWriteTimeout bounds a collection's store writes when
+// database.write_timeout is unset.
+const DefaultWriteTimeout = 30 * time.Second
+
+// WriteDeadline returns how long a collection's store writes may take.
+func (c DatabaseConfig) WriteDeadline() (time.Duration, error) {
+	if c.WriteTimeout == "" {
+		return DefaultWriteTimeout, nil
+	}
+	d, err := time.ParseDuration(c.WriteTimeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("write_timeout %q is not a positive duration", c.WriteTimeout)
+	}
+	return d, nil
+}
+
+// OpenStore opens the store at rawURL, or at URL when rawURL is empty, with
+// the section's write settings applied: batch_size and quotas. It returns nil,
+// nil when there is no URL. Every binary opens its store through it, so the
+// settings hold wherever records are written.
+func (c DatabaseConfig) OpenStore(rawURL string) (store.Store, error) {
+	if rawURL == "" {
+		rawURL = c.URL
+	}
+	st, err := store.Open(rawURL)
+	if err != nil || st == nil {
+		return nil, err
+	}
+	st = store.WithBatchSize(st, c.BatchSize)
+	return store.WithQuotas(st, c.Quotas), nil
+}
+
+// DatabaseRecentConfig bounds metrics_recent, the ring buffer sub-minute
+// samples are written to. With Enabled, the daemon prunes it every Interval,
+// rolling samples older than Retention up into per-minute samples in metrics.
+type DatabaseRecentConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Retention string `json:"retention"` // Go duration kept at full resolution; default "6h"
+	Interval  string `json:"interval"`  // Go duration between prune runs; default "5m"
+}
+
+// DefaultRecentPruneInterval is how often the daemon prunes metrics_recent
+// when database.recent.interval is unset.
+const DefaultRecentPruneInterval = 5 * time.Minute
+
+// Durations returns the retention and prune interval, defaulted when unset.
+func (r DatabaseRecentConfig) Durations() (retention, interval time.Duration, err error) {
+	retention, interval = store.DefaultRecentRetention, DefaultRecentPruneInterval
+	if r.Retention != "" {
+		if retention, err = time.ParseDuration(r.Retention); err != nil || retention <= 0 {
+			return 0, 0, fmt.Errorf("retention %q is not a positive duration", r.Retention)
+		}
+	}
+	if r.Interval != "" {
+		if interval, err = time.ParseDuration(r.Interval); err != nil || interval <= 0 {
+			return 0, 0, fmt.Errorf("interval %q is not a positive duration", r.Interval)
+		}
+	}
+	return retention, interval, nil
 }
 
 // Config is the root configuration structure.
 type Config struct {
+	ConfigVersion int `json:"config_version"` // format of the document; older ones are upgraded when read
+
 	Hosts       map[string]Host          `json:"hosts"`
 	Credentials map[string]Credential    `json:"credentials"`
 	Remote      RemoteConfig             `json:"remote"`
 	Perception  map[string]PerceptionEnv `json:"perception"`
 	Database    DatabaseConfig           `json:"database"`
+	TextUI      TextUIConfig             `json:"textui"`
+	Local       LocalConfig              `json:"local"`
+	Mail        MailConfig               `json:"mail"`
+	Exec        ExecConfig               `json:"exec"`
+	Syslog      SyslogConfig             `json:"syslog"`
+	MQTT        MQTTConfig               `json:"mqtt"`
+	Alert       AlertConfig              `json:"alert"`
+	Report      ReportConfig             `json:"report"`
+	Daemon      DaemonConfig             `json:"daemon"`
+	Paths       PathsConfig              `json:"paths"`
+	Safety      string                   `json:"safety"`      // highest action level allowed: read, write or destructive (default)
+	Maintenance []MaintenanceWindow      `json:"maintenance"` // maintenance windows of every host
+}
+
+// PathsConfig locates the files nord reads and writes. Relative paths are
+// resolved against the working directory; NORD_DATA_DIR, NORD_STATE_DIR and
+// NORD_DEVICES_DIR override the matching field.
+type PathsConfig struct {
+	DataDir    string `json:"data_dir"`    // collection.json, perception.json, exports; default "data"
+	StateDir   string `json:"state_dir"`   // counters kept between runs; default data_dir
+	DevicesDir string `json:"devices_dir"` // <plugin>/<type>.json device definitions; default plugins/<plugin>/devices
+}
+
+// DaemonConfig selects the components `nord daemon` runs and how it supervises them.
+type DaemonConfig struct {
+	PIDFile     string                 `json:"pidfile"`      // written at startup and removed on exit; none when empty
+	Notify      bool                   `json:"notify"`       // send sd_notify readiness when NOTIFY_SOCKET is set
+	MaxRestarts int                    `json:"max_restarts"` // restarts per component before giving up; default 5
+	Collect     DaemonCollectConfig    `json:"collect"`
+	Perception  DaemonPerceptionConfig `json:"perception"`
+	Flow        DaemonFlowConfig       `json:"flow"`
+	Exporter    DaemonExporterConfig   `json:"exporter"`
+	Services    []string               `json:"services"` // plugins implementing Service to run, by name
+}
+
+// DaemonExporterConfig serves the last collection over HTTP in the Prometheus
+// text format, for Prometheus to scrape.
+type DaemonExporterConfig struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen"` // address to listen on; default ":9464"
+	Path    string `json:"path"`   // default "/metrics"
+}
+
+// DaemonCollectConfig controls the collection scheduler.
+type DaemonCollectConfig struct {
+	Enabled    bool   `json:"enabled"`
+	Interval   string `json:"interval"`   // Go duration between cycle starts; default "5m"
+	Perception bool   `json:"perception"` // run perception before collecting
+	Send       bool   `json:"send"`       // send to remote destinations after collecting
+
+	Publish []string `json:"publish"` // plugins whose "send" action runs after each cycle, e.g. "mqtt"
+
+	// CycleBudget bounds how long a full collection may take (Go duration,
+	// none when empty). Tasks then run Workers at a time, most important
+	// first, and none is started once 80% of the budget is used: the rest
+	// are deferred to the start of the next collection.
+	CycleBudget string `json:"cycle_budget"`
+	Workers     int    `json:"workers"` // tasks run at once under a cycle budget; default 16
+
+	// HostTimeout bounds a host's tasks (Go duration, default "10m"). Tasks
+	// still running then are abandoned and reported as failed, so a plugin
+	// hung on one host does not hold up the collection.
+	HostTimeout string `json:"host_timeout"`
+}
+
+// DefaultHostTimeout bounds a host's tasks when daemon.collect.host_timeout is
+// unset.
+const DefaultHostTimeout = 10 * time.Minute
+
+// HostDeadline returns how long a host's tasks may take.
+func (c DaemonCollectConfig) HostDeadline() (time.Duration, error) {
+	if c.HostTimeout == "" {
+		return DefaultHostTimeout, nil
+	}
+	d, err := time.ParseDuration(c.HostTimeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("host_timeout %q is not a positive duration", c.HostTimeout)
+	}
+	return d, nil
+}
+
+// Budget returns the cycle budget, 0 when none is set.
+func (c DaemonCollectConfig) Budget() (time.Duration, error) {
+	if c.CycleBudget == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.CycleBudget)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("cycle_budget %q is not a positive duration", c.CycleBudget)
+	}
+	return d, nil
+}
+
+// DaemonPerceptionConfig runs perception on its own schedule, so new and
+// vanished hosts are noticed between collections. Every perception run, in the
+// daemon or not, is compared with the previous ones and publishes the changes.
+type DaemonPerceptionConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Interval string `json:"interval"` // Go duration between runs; default "1h"
+	Misses   int    `json:"misses"`   // scans a host may be missing before it is reported gone; default 3
+}
+
+// DaemonFlowConfig controls the IPFlow listeners and the top-talker metrics
+// aggregated from the flows they receive.
+type DaemonFlowConfig struct {
+	Enabled  bool          `json:"enabled"`
+	Interval string        `json:"interval"` // Go duration flows are aggregated over; default "1m"
+	TopN     int           `json:"top_n"`    // top talkers written per exporter and interval; default 10
+	DNS      FlowDNSConfig `json:"dns"`
+}
+
+// FlowDNSConfig turns on reverse-DNS names for top-talker addresses. Names
+// only go into the top-talker metrics' extra, never into stored flows.
+type FlowDNSConfig struct {
+	Enabled     bool              `json:"enabled"`
+	Hosts       map[string]string `json:"hosts"`        // address → name, used before DNS
+	HostsFile   string            `json:"hosts_file"`   // /etc/hosts style file read at start, below hosts
+	CacheSize   int               `json:"cache_size"`   // addresses remembered; default 4096
+	TTL         string            `json:"ttl"`          // how long a name is kept; default "1h"
+	NegativeTTL string            `json:"negative_ttl"` // how long an address without a name is not looked up again; default "10m"
+	Budget      int               `json:"budget"`       // DNS lookups per interval; default 50
+	Timeout     string            `json:"timeout"`      // per lookup; default "1s"
+}
+
+// DefaultFlowInterval is how long flows are aggregated into top talkers when
+// daemon.flow.interval is unset.
+const DefaultFlowInterval = time.Minute
+
+// AggregateInterval returns the aggregation interval, defaulted when unset.
+func (c DaemonFlowConfig) AggregateInterval() (time.Duration, error) {
+	if c.Interval == "" {
+		return DefaultFlowInterval, nil
+	}
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("interval %q is not a positive duration", c.Interval)
+	}
+	return d, nil
+}
+
+// Durations returns the positive and negative cache TTLs and the lookup
+// timeout, defaulted when unset.
+func (c FlowDNSConfig) Durations() (ttl, negativeTTL, timeout time.Duration, err error) {
+	ttl, negativeTTL, timeout = time.Hour, 10*time.Minute, time.Second
+	for _, f := range []struct {
+		name  string
+		value string
+		d     *time.Duration
+	}{{"ttl", c.TTL, &ttl}, {"negative_ttl", c.NegativeTTL, &negativeTTL}, {"timeout", c.Timeout, &timeout}} {
+		if f.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.value)
+		if err != nil || d <= 0 {
+			return 0, 0, 0, fmt.Errorf("%s %q is not a positive duration", f.name, f.value)
+		}
+		*f.d = d
+	}
+	return ttl, negativeTTL, timeout, nil
+}
+
+// ExecConfig holds settings for the exec plugin. Tasks name a command, never a
+// path; it must be found in one of Dirs, so the config cannot run anything else.
+type ExecConfig struct {
+	Dirs           []string `json:"dirs"`             // allowlisted script directories; exec tasks fail when empty
+	MaxOutputBytes int      `json:"max_output_bytes"` // stdout/stderr kept per run; default 4096
+}
+
+// SyslogConfig holds settings for the syslog receiver, run as a daemon service.
+type SyslogConfig struct {
+	UDP       string       `json:"udp"`        // listen address; default ":514", "off" disables
+	TCP       string       `json:"tcp"`        // listen address; default ":514", "off" disables
+	RateLimit float64      `json:"rate_limit"` // messages per second kept per source; default 50
+	Burst     int          `json:"burst"`      // messages a source may send at once; default 200
+	Rules     []SyslogRule `json:"rules"`
+}
+
+// SyslogRule promotes messages matching Match to a status metric named Name.
+// The first capture group, if any, is the metric instance (e.g. the interface).
+type SyslogRule struct {
+	Name   string `json:"name"`
+	Match  string `json:"match"`  // regular expression
+	Status string `json:"status"` // value when Match matches; default "warning"
+	Clear  string `json:"clear"`  // optional regular expression that sets the metric back to "up"
+}
+
+// MQTTConfig holds settings for publishing metrics to an MQTT broker.
+type MQTTConfig struct {
+	Broker          string `json:"broker"`    // e.g. "tcp://broker:1883", "ssl://broker:8883"
+	ClientID        string `json:"client_id"` // default "nord-<agent host name>"
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	CAFile          string `json:"ca_file"`   // PEM roots for ssl:// brokers; default the system roots
+	CertFile        string `json:"cert_file"` // client certificate, with KeyFile
+	KeyFile         string `json:"key_file"`
+	Insecure        bool   `json:"insecure"`         // skip broker certificate verification
+	TopicPrefix     string `json:"topic_prefix"`     // default "nord"
+	QoS             byte   `json:"qos"`              // 0, 1 or 2
+	Retain          bool   `json:"retain"`           // retain metric messages
+	Discovery       bool   `json:"discovery"`        // publish Home Assistant discovery configs
+	DiscoveryPrefix string `json:"discovery_prefix"` // default "homeassistant"
+	QueueSize       int    `json:"queue_size"`       // messages held while disconnected; default 1000
+}
+
+// AlertConfig holds the alert plugin's rules and where their notifications go.
+// Rules are evaluated against the store after every collection.
+type AlertConfig struct {
+	Rules    []AlertRule             `json:"rules"`
+	Channels map[string]AlertChannel `json:"channels"` // referenced by name from rules
+	SMTP     AlertSMTPConfig         `json:"smtp"`     // used by email channels
+	Renotify string                  `json:"renotify"` // Go duration between reminders while firing; default "1h", "0" disables
+	Changes  []string                `json:"changes"`  // channels told when perception finds a host appeared, disappeared or changed services
+}
+
+// AlertRule fires when the selected metrics meet the condition for For.
+// Host and Instance are glob patterns; an empty Plugin or Instance matches any.
+type AlertRule struct {
+	Name     string   `json:"name"`
+	Host     string   `json:"host"` // host key glob; default "*"
+	Plugin   string   `json:"plugin"`
+	Metric   string   `json:"metric"`   // metric name
+	Instance string   `json:"instance"` // instance glob
+	Op       string   `json:"op"`       // ">", ">=", "<", "<=", "==", "!=", "regex" or "not_up"
+	Value    string   `json:"value"`    // compared with value_num when numeric, else with the value
+	For      string   `json:"for"`      // Go duration the condition must hold before firing; default 0
+	Severity string   `json:"severity"` // "warning" (default) or "critical"
+	Channels []string `json:"channels"`
+	Renotify string   `json:"renotify"` // overrides AlertConfig.Renotify
+}
+
+// AlertChannel is one notification destination.
+type AlertChannel struct {
+	Type    string            `json:"type"`    // "webhook", "email" or "exec"
+	URL     string            `json:"url"`     // webhook: JSON is POSTed here
+	Headers map[string]string `json:"headers"` // webhook: extra request headers
+	To      []string          `json:"to"`      // email recipients
+	Command string            `json:"command"` // exec: program run with the JSON notification on stdin
+	Args    []string          `json:"args"`
+}
+
+// AlertSMTPConfig is the mail server email channels send through.
+type AlertSMTPConfig struct {
+	Server   string `json:"server"` // host:port
+	From     string `json:"from"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	StartTLS bool   `json:"starttls"`
+}
+
+// ReportConfig controls the availability reports written by the report plugin.
+type ReportConfig struct {
+	Schedule string `json:"schedule"` // "monthly" (default), "weekly" or "daily" when run as a daemon service
+	Gap      string `json:"gap"`      // Go duration a status sample stays valid; later time is unknown. Default "15m"
+	Top      int    `json:"top"`      // worst offenders listed; default 10
+}
+
+// MailConfig holds settings for the mail plugin.
+type MailConfig struct {
+	MTA  string         `json:"mta"` // "postfix", "exim" or "opensmtpd"; detected from installed tools when empty
+	Log  MailLogConfig  `json:"log"`
+	SMTP MailSMTPConfig `json:"smtp"`
+}
+
+// MailSMTPConfig controls remote SMTP checks, run for hosts that are not this machine.
+type MailSMTPConfig struct {
+	Port      int    `json:"port"`       // default 25
+	StartTLS  bool   `json:"starttls"`   // upgrade and report certificate expiry
+	Probe     bool   `json:"probe"`      // MAIL FROM/RCPT TO/RSET without sending DATA
+	From      string `json:"from"`       // probe sender; default postmaster@<helo>
+	Recipient string `json:"recipient"`  // probe recipient; required for Probe
+	Helo      string `json:"helo"`       // default the local hostname
+	TimeoutMs int    `json:"timeout_ms"` // per step; default 10000
+}
+
+// MailLogConfig enables delivery counting from the MTA's log.
+type MailLogConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`    // default /var/log/mail.log
+	Journal bool   `json:"journal"` // read `journalctl -u postfix` instead of Path
+}
+
+// LocalConfig holds settings for the local plugin's agent-host metrics.
+type LocalConfig struct {
+	Disk LocalDiskConfig `json:"disk"`
+	Net  LocalNetConfig  `json:"net"`
+	CPU  LocalCPUConfig  `json:"cpu"`
+
+	Sensors LocalSensorsConfig `json:"sensors"`
+	Docker  LocalDockerConfig  `json:"docker"`
+	Top     LocalTopConfig     `json:"top"`
+	Clock   LocalClockConfig   `json:"clock"`
+
+	Processes    []ProcessWatch `json:"processes"`
+	SystemdUnits []string       `json:"systemd_units"` // e.g. "postgresql.service"
+	Paths        []PathCheck    `json:"paths"`
+}
+
+// LocalSensorsConfig controls hardware temperature alerts.
+type LocalSensorsConfig struct {
+	WarningCelsius float64 `json:"warning_celsius"` // default 80
+}
+
+// LocalDockerConfig controls container metrics. They are collected whenever the socket exists.
+type LocalDockerConfig struct {
+	Socket        string `json:"socket"`         // default /var/run/docker.sock
+	MaxContainers int    `json:"max_containers"` // default 50
+	Disabled      bool   `json:"disabled"`
+}
+
+// LocalTopConfig enables recording the busiest processes at each collection.
+type LocalTopConfig struct {
+	Enabled       bool `json:"enabled"`
+	N             int  `json:"n"`              // processes per list; default 5
+	IncludeKernel bool `json:"include_kernel"` // also rank kernel threads
+}
+
+// PathCheck alerts when a file is missing, too old, or too small.
+type PathCheck struct {
+	Path    string `json:"path"`     // a glob when Glob is set; the newest match is checked
+	MaxAge  string `json:"max_age"`  // Go duration, e.g. "24h"; empty disables the age check
+	MinSize int64  `json:"min_size"` // bytes; 0 disables the size check
+	Glob    bool   `json:"glob"`
+}
+
+// LocalClockConfig enables the clock synchronization check.
+type LocalClockConfig struct {
+	Enabled   bool    `json:"enabled"`
+	Server    string  `json:"server"`     // NTP server, host or host:port; default pool.ntp.org
+	LocalOnly bool    `json:"local_only"` // skip the NTP query and report only the local daemon's view
+	WarningMs float64 `json:"warning_ms"` // default 500
+}
+
+// ProcessWatch is one process the local plugin counts and checks against a minimum.
+type ProcessWatch struct {
+	Name     string `json:"name"`      // metric instance; matched as a substring of the command line when Match is empty
+	Match    string `json:"match"`     // optional regular expression matched against the command line
+	MinCount int    `json:"min_count"` // fewer running processes is reported down; default 1
+}
+
+// LocalCPUConfig controls CPU utilization sampling.
+type LocalCPUConfig struct {
+	PerCore bool `json:"per_core"` // also emit cpu_percent per core
+}
+
+// LocalNetConfig controls which network interfaces are reported.
+type LocalNetConfig struct {
+	Exclude []string `json:"exclude"` // glob patterns; replaces the default lo, docker*, veth* when set
+}
+
+// LocalDiskConfig controls which filesystems are reported and when they alert.
+type LocalDiskConfig struct {
+	SkipFSTypes     []string `json:"skip_fs_types"`    // replaces the default skip list when set
+	WarningPercent  float64  `json:"warning_percent"`  // default 85
+	CriticalPercent float64  `json:"critical_percent"` // default 95
+}
+
+// TextUIConfig holds settings for the terminal user interface.
+type TextUIConfig struct {
+	RefreshInterval string              `json:"refresh_interval"` // Go duration, e.g. "30s"; empty means the default
+	Theme           string              `json:"theme"`            // "dark" (default), "light", or "mono"
+	Colors          TextUIColors        `json:"colors"`           // per-color overrides on top of the theme
+	Keys            map[string][]string `json:"keys"`             // action → keys, e.g. {"up": ["up", "w"]}
+}
+
+// TextUIColors overrides individual theme colors. Values are ANSI numbers
+// ("9") or hex ("#ff0000"); empty keeps the theme color.
+type TextUIColors struct {
+	Up        string `json:"up"`
+	Down      string `json:"down"`
+	Warning   string `json:"warning"`
+	Selection string `json:"selection"`
 }
 
 // Host defines a single machine to be monitored.
 type Host struct {
-	Address     string        `json:"address"`
-	Name        string        `json:"name"`
-	Collect     []CollectTask `json:"collect"`
-	Credentials []string      `json:"credentials"`
+	Address     string              `json:"address"`
+	Name        string              `json:"name"`
+	Collect     []CollectTask       `json:"collect"`
+	Credentials []string            `json:"credentials"`
+	Groups      []string            `json:"groups,omitempty"`      // e.g. "core switches", "branch routers"
+	Maintenance []MaintenanceWindow `json:"maintenance,omitempty"` // this host's maintenance windows
 }
 
 // CollectTask defines a single collection task for a host.
 type CollectTask struct {
-	Metric      string `json:"metric"`
-	Credentials string `json:"credentials"`
+	Metric      string                 `json:"metric"`
+	Credentials string                 `json:"credentials"`
+	Options     map[string]interface{} `json:"options,omitempty"`  // plugin-specific, passed to OnCollect as "options"
+	Priority    int                    `json:"priority,omitempty"` // under a cycle budget, higher runs first; default by plugin
+	Prefer      string                 `json:"prefer,omitempty"`   // address family to run over: ipv4, ipv6 or both; falls back when missing
+	Require     string                 `json:"require,omitempty"`  // like prefer, but a missing family fails the task
 }
 
 // Credential defines a set of credentials for accessing a device.
@@ -39,7 +490,16 @@ type Credential struct {
 	Port      int    `json:"port"`
 	Type      string `json:"type"` // The device type, e.g., "nokia2425", "generic_snmp"
 	Community string `json:"community"`
-	Version   string `json:"version"` // e.g., "2c", "3"
+	Version   string `json:"version"`            // e.g., "2c", "3"
+	Token     string `json:"token,omitempty"`    // bearer token for HTTP checks
+	Region    string `json:"region,omitempty"`   // S3 signing region for type "s3"; default "us-east-1"
+	Insecure  bool   `json:"insecure,omitempty"` // HTTPS credentials: skip certificate verification
+	CAFile    string `json:"ca_file,omitempty"`  // HTTPS credentials: PEM roots instead of the system's
+
+	// Kubernetes credentials use Host and Token, else this kubeconfig and its
+	// Context (default the current one), else the in-cluster service account.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
 }
 
 // RemoteConfig holds the configuration for sending data to remote servers.
@@ -49,9 +509,27 @@ type RemoteConfig struct {
 
 // Destination defines a single remote server endpoint.
 type Destination struct {
-	Endpoint string `json:"endpoint"`
-	Token    string `json:"token"`
-	Active   bool   `json:"active"`
+	Endpoint   string `json:"endpoint"`
+	Token      string `json:"token"`
+	Active     bool   `json:"active"`
+	Schema     string `json:"schema"`                 // payload shape: "legacy" (default, collection.json's) or "flat"
+	MaxSkipAge string `json:"max_skip_age,omitempty"` // longest an unchanged payload goes unsent (default 1h; 0s always sends)
+}
+
+// DefaultMaxSkipAge is how long an unchanged payload may go unsent when the
+// destination does not set max_skip_age.
+const DefaultMaxSkipAge = time.Hour
+
+// SkipAge returns how long an unchanged payload may go unsent.
+func (d Destination) SkipAge() (time.Duration, error) {
+	if d.MaxSkipAge == "" {
+		return DefaultMaxSkipAge, nil
+	}
+	age, err := time.ParseDuration(d.MaxSkipAge)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("max_skip_age %q is not a duration", d.MaxSkipAge)
+	}
+	return age, nil
 }
 
 // PerceptionEnv defines a network discovery environment.
@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultConfigFile is used when neither --config nor NORD_CONFIG is given.
+const DefaultConfigFile = "data/config.json"
+
+// ConfigFile is the path of the JSON configuration read by every plugin.
+// main sets it from the --config flag or the NORD_CONFIG environment variable.
+var ConfigFile = DefaultConfigFile
+
+// upgradeLogged holds the config files whose in-memory upgrade was reported,
+// so it is reported once per process rather than by every reader.
+var upgradeLogged = struct {
+	sync.Mutex
+	files map[string]bool
+}{files: make(map[string]bool)}
+
+// ReadConfigFile returns the contents of ConfigFile, upgraded to
+// ConfigVersion. The upgrades applied are reported on stderr the first time;
+// the file itself is only changed by `nord config upgrade --write`.
+func ReadConfigFile() ([]byte, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	upgraded, applied, err := UpgradeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if len(applied) > 0 {
+		upgradeLogged.Lock()
+		if !upgradeLogged.files[ConfigFile] {
+			upgradeLogged.files[ConfigFile] = true
+			for _, line := range applied {
+				fmt.Fprintf(os.Stderr, "Warning: %s: upgraded config %s\n", ConfigFile, line)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %s: run `nord config upgrade --write` to save the upgraded config.\n", ConfigFile)
+		}
+		upgradeLogged.Unlock()
+	}
+	return upgraded, nil
+}
+
+// WriteConfigFile replaces ConfigFile with data through a temporary file in
+// the same directory, keeping the file's permissions.
+func WriteConfigFile(data []byte) error {
+	mode := os.FileMode(0600) // credentials live in the config
+	if info, err := os.Stat(ConfigFile); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ConfigFile), ".config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ConfigFile)
+}
@@ -0,0 +1,213 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrHostExists is returned by AddConfigHost when the key is taken or another
+// configured host has the same address.
+var ErrHostExists = errors.New("host already configured")
+
+// HostByAddress returns the key of the configured host with address addr,
+// compared without surrounding space and case.
+func (c *Config) HostByAddress(addr string) (string, bool) {
+	addr = strings.ToLower(strings.TrimSpace(addr))
+	if addr == "" {
+		return "", false
+	}
+	for _, key := range sortedKeys(c.Hosts) {
+		if strings.ToLower(strings.TrimSpace(c.Hosts[key].Address)) == addr {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// AddConfigHost adds a host entry to ConfigFile. The file is not re-encoded:
+// the entry is inserted as text at the end of the hosts object, indented like
+// the rest of the file, so key order and layout stay as written.
+func AddConfigHost(key string, host Host) error {
+	data, err := os.ReadFile(ConfigFile) // as written, not upgraded
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	updated, err := InsertConfigHost(data, key, host)
+	if err != nil {
+		return err
+	}
+	return WriteConfigFile(updated)
+}
+
+// InsertConfigHost returns the config document data with the host added
+// under key. It fails with ErrHostExists on a key or address conflict, and
+// when data is not a JSON object or its hosts member is not an object.
+func InsertConfigHost(data []byte, key string, host Host) ([]byte, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, errors.New("host key is empty")
+	}
+	var cfg Config
+	if err := unmarshalUpgraded(data, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse config file: %w", err)
+	}
+	if _, ok := cfg.Hosts[key]; ok {
+		return nil, fmt.Errorf("%w: %q", ErrHostExists, key)
+	}
+	if other, ok := cfg.HostByAddress(host.Address); ok {
+		return nil, fmt.Errorf("%w: %s is host %q", ErrHostExists, host.Address, other)
+	}
+
+	open, closing, empty, found, err := hostsSpan(data)
+	if err != nil {
+		return nil, err
+	}
+	unit := indentUnit(data)
+	var out []byte
+	if !found {
+		// No hosts object yet: start one as the first member of the document.
+		entry, err := hostEntry(key, host, unit+unit, unit)
+		if err != nil {
+			return nil, err
+		}
+		text := "\n" + unit + `"hosts": {` + "\n" + entry + "\n" + unit + "}"
+		if len(bytes.TrimSpace(data[open:closing])) > 0 {
+			text += ","
+		} else {
+			text += "\n"
+		}
+		out = append(out, data[:open]...)
+		out = append(out, text...)
+		out = append(out, data[open:]...)
+	} else {
+		outer := lineIndent(data, open)
+		entry, err := hostEntry(key, host, outer+unit, unit)
+		if err != nil {
+			return nil, err
+		}
+		if empty {
+			out = append(out, data[:open]...)
+			out = append(out, "\n"+entry+"\n"+outer...)
+			out = append(out, data[closing:]...)
+		} else {
+			last := closing
+			for last > open && isSpace(data[last-1]) {
+				last--
+			}
+			out = append(out, data[:last]...)
+			out = append(out, ",\n"+entry...)
+			out = append(out, data[last:]...)
+		}
+	}
+
+	var check Config
+	if err := unmarshalUpgraded(out, &check); err != nil {
+		return nil, fmt.Errorf("config after adding %q does not parse: %w", key, err)
+	}
+	if _, ok := check.Hosts[key]; !ok {
+		return nil, fmt.Errorf("config after adding %q does not have the host", key)
+	}
+	return out, nil
+}
+
+// unmarshalUpgraded parses a config document as ReadConfigFile would return
+// it, so a file still in an older version's shorthands parses.
+func unmarshalUpgraded(data []byte, cfg *Config) error {
+	upgraded, _, err := UpgradeConfig(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(upgraded, cfg)
+}
+
+// hostsSpan locates the hosts object of a config document: open is the offset
+// just after its '{' and closing that of its '}'. Without a hosts member,
+// found is false and open and closing span the top-level object instead.
+func hostsSpan(data []byte) (open, closing int, empty, found bool, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return 0, 0, false, false, errors.New("config file is not a JSON object")
+	}
+	top := int(dec.InputOffset())
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return 0, 0, false, false, err
+		}
+		if name, _ := tok.(string); name != "hosts" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return 0, 0, false, false, err
+			}
+			continue
+		}
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return 0, 0, false, false, errors.New("hosts in the config file is not an object")
+		}
+		open = int(dec.InputOffset())
+		empty = !dec.More()
+		for dec.More() {
+			if _, err := dec.Token(); err != nil {
+				return 0, 0, false, false, err
+			}
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return 0, 0, false, false, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return 0, 0, false, false, err
+		}
+		return open, int(dec.InputOffset()) - 1, empty, true, nil
+	}
+	if _, err := dec.Token(); err != nil {
+		return 0, 0, false, false, err
+	}
+	return top, int(dec.InputOffset()) - 1, false, false, nil
+}
+
+// hostEntry renders `"key": {...}` indented by indent, nested levels by unit.
+func hostEntry(key string, host Host, indent, unit string) (string, error) {
+	name, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.MarshalIndent(host, indent, unit)
+	if err != nil {
+		return "", err
+	}
+	return indent + string(name) + ": " + string(body), nil
+}
+
+// indentUnit guesses the file's indentation step from its first indented
+// line, two spaces when there is none.
+func indentUnit(data []byte) string {
+	for _, line := range bytes.Split(data, []byte("\n"))[1:] {
+		n := 0
+		for n < len(line) && (line[n] == ' ' || line[n] == '\t') {
+			n++
+		}
+		if n > 0 && n < len(line) {
+			return string(line[:n])
+		}
+	}
+	return "  "
+}
+
+// lineIndent returns the leading white space of the line holding offset.
+func lineIndent(data []byte, offset int) string {
+	start := bytes.LastIndexByte(data[:offset], '\n') + 1
+	end := start
+	for end < len(data) && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
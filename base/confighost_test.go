@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fourSpaces is a config as an operator might keep it: four-space indent,
+// keys in their own order, hosts in the middle.
+const fourSpaces = `{
+    "config_version": 1,
+    "credentials": {
+        "lab": {"user": "admin", "type": "generic_snmp"}
+    },
+    "hosts": {
+        "core": {
+            "address": "192.0.2.1",
+            "collect": [{"metric": "network.ping"}]
+        }
+    },
+    "output": {"directory": "data"}
+}
+`
+
+// insertedHost is what each fixture gets.
+var insertedHost = Host{
+	Address:     "192.0.2.20",
+	Collect:     []CollectTask{{Metric: "network.ping"}, {Metric: "snmp.generic", Credentials: "lab"}},
+	Credentials: []string{"lab"},
+}
+
+func TestInsertConfigHost(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data string
+		want string
+	}{
+		{"four spaces", fourSpaces, `{
+    "config_version": 1,
+    "credentials": {
+        "lab": {"user": "admin", "type": "generic_snmp"}
+    },
+    "hosts": {
+        "core": {
+            "address": "192.0.2.1",
+            "collect": [{"metric": "network.ping"}]
+        },
+        "printer": {
+            "address": "192.0.2.20",
+            "name": "",
+            "collect": [
+                {
+                    "metric": "network.ping",
+                    "credentials": ""
+                },
+                {
+                    "metric": "snmp.generic",
+                    "credentials": "lab"
+                }
+            ],
+            "credentials": [
+                "lab"
+            ]
+        }
+    },
+    "output": {"directory": "data"}
+}
+`},
+		{"tabs", "{\n\t\"hosts\": {\n\t\t\"core\": {\"address\": \"192.0.2.1\"}\n\t}\n}", "{\n\t\"hosts\": {\n\t\t\"core\": {\"address\": \"192.0.2.1\"},\n" +
+			"\t\t\"printer\": {\n\t\t\t\"address\": \"192.0.2.20\",\n\t\t\t\"name\": \"\",\n\t\t\t\"collect\": [\n" +
+			"\t\t\t\t{\n\t\t\t\t\t\"metric\": \"network.ping\",\n\t\t\t\t\t\"credentials\": \"\"\n\t\t\t\t},\n" +
+			"\t\t\t\t{\n\t\t\t\t\t\"metric\": \"snmp.generic\",\n\t\t\t\t\t\"credentials\": \"lab\"\n\t\t\t\t}\n\t\t\t],\n" +
+			"\t\t\t\"credentials\": [\n\t\t\t\t\"lab\"\n\t\t\t]\n\t\t}\n\t}\n}"},
+	} {
+		got, err := InsertConfigHost([]byte(tt.data), "printer", insertedHost)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInsertConfigHostShapes(t *testing.T) {
+	host := Host{Address: "192.0.2.20"}
+	for _, tt := range []struct {
+		name string
+		data string
+	}{
+		{"empty hosts", `{"config_version": 1, "hosts": {}, "output": {"directory": "data"}}`},
+		{"empty hosts on lines", "{\n  \"hosts\": {\n  },\n  \"config_version\": 1\n}\n"},
+		{"no hosts", "{\n  \"config_version\": 1,\n  \"output\": {\"directory\": \"data\"}\n}\n"},
+		{"empty document", "{}"},
+		{"older shorthands", `{"hosts": {"core": {"address": "192.0.2.1", "collect": ["network.ping"]}}}`},
+	} {
+		got, err := InsertConfigHost([]byte(tt.data), "printer", host)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var cfg Config
+		if err := unmarshalUpgraded(got, &cfg); err != nil {
+			t.Errorf("%s: result does not parse: %v\n%s", tt.name, err, got)
+			continue
+		}
+		if cfg.Hosts["printer"].Address != "192.0.2.20" {
+			t.Errorf("%s: hosts %+v", tt.name, cfg.Hosts)
+		}
+		// Nothing else was rewritten: every other member still decodes the same.
+		var before, after map[string]json.RawMessage
+		json.Unmarshal([]byte(tt.data), &before) //nolint:errcheck
+		json.Unmarshal(got, &after)              //nolint:errcheck
+		for name, raw := range before {
+			if name != "hosts" && string(after[name]) != string(raw) {
+				t.Errorf("%s: %s = %s, was %s", tt.name, name, after[name], raw)
+			}
+		}
+	}
+
+	// The original text survives up to the end of the last host.
+	got, err := InsertConfigHost([]byte(fourSpaces), "printer", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := strings.Index(fourSpaces, "]\n        }") + len("]\n        }")
+	if !strings.HasPrefix(string(got), fourSpaces[:last]+",\n        \"printer\": {") ||
+		!strings.HasSuffix(string(got), "    },\n    \"output\": {\"directory\": \"data\"}\n}\n") {
+		t.Errorf("layout changed:\n%s", got)
+	}
+}
+
+func TestInsertConfigHostRejects(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		data   string
+		key    string
+		addr   string
+		exists bool
+		msg    string
+	}{
+		{"taken key", fourSpaces, "core", "192.0.2.20", true, `"core"`},
+		{"same address", fourSpaces, "printer", " 192.0.2.1 ", true, `is host "core"`},
+		{"same name, other case", `{"hosts": {"nas": {"address": "NAS.example"}}}`, "printer", "nas.example", true, `is host "nas"`},
+		{"blank key", fourSpaces, "  ", "192.0.2.20", false, "host key is empty"},
+		{"not an object", `[1, 2]`, "printer", "192.0.2.20", false, "could not parse config file"},
+		{"hosts not an object", `{"config_version": 1, "hosts": []}`, "printer", "192.0.2.20", false, "could not parse config file"},
+		{"broken", `{"hosts": {`, "printer", "192.0.2.20", false, "could not parse config file"},
+	} {
+		_, err := InsertConfigHost([]byte(tt.data), tt.key, Host{Address: tt.addr})
+		if err == nil || errors.Is(err, ErrHostExists) != tt.exists || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.msg)
+		}
+	}
+}
+
+func TestAddConfigHost(t *testing.T) {
+	old := ConfigFile
+	ConfigFile = filepath.Join(t.TempDir(), "config.json")
+	t.Cleanup(func() { ConfigFile = old })
+
+	if err := AddConfigHost("printer", Host{Address: "192.0.2.20"}); err == nil || !strings.Contains(err.Error(), "could not read config file") {
+		t.Errorf("missing file: %v", err)
+	}
+	if err := os.WriteFile(ConfigFile, []byte(fourSpaces), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddConfigHost("printer", insertedHost); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddConfigHost("printer2", Host{Address: "192.0.2.20"}); !errors.Is(err, ErrHostExists) {
+		t.Errorf("second add of the address: %v", err)
+	}
+
+	info, err := os.Stat(ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode %v", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := unmarshalUpgraded(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if h := cfg.Hosts["printer"]; h.Address != "192.0.2.20" || len(h.Collect) != 2 || h.Credentials[0] != "lab" {
+		t.Errorf("printer = %+v", h)
+	}
+	if key, ok := cfg.HostByAddress("192.0.2.1"); !ok || key != "core" {
+		t.Errorf("HostByAddress = %q, %v", key, ok)
+	}
+	if _, ok := cfg.HostByAddress(""); ok {
+		t.Error("an empty address matched")
+	}
+}
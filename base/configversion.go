@@ -0,0 +1,268 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ConfigVersion is the config_version of the documents this build writes.
+// Older documents are upgraded in memory when read; newer ones are refused.
+const ConfigVersion = 1
+
+// ConfigVersionError reports a config written for a newer nord.
+type ConfigVersionError struct {
+	Version int
+}
+
+func (e *ConfigVersionError) Error() string {
+	return fmt.Sprintf("config_version %d is newer than this nord understands (%d); upgrade nord, or restore the config backup made by the upgrade", e.Version, ConfigVersion)
+}
+
+// configUpgrade turns a document of version from into one of version from+1,
+// and returns the paths it changed.
+type configUpgrade struct {
+	from        int
+	description string
+	apply       func(doc *jsonObject) []string
+}
+
+// configUpgrades must hold one upgrade per version below ConfigVersion, in
+// order. Bumping ConfigVersion means appending the upgrade from the old one.
+var configUpgrades = []configUpgrade{
+	{
+		from:        0,
+		description: "lists where single values were accepted; database as an object",
+		apply:       upgradeV0,
+	},
+}
+
+// UpgradeConfig returns a config document brought to ConfigVersion, with a
+// line for each upgrade applied. A document without config_version is version
+// 0. A current document, or one that is not a JSON object, is returned as it
+// is, for the caller's own parse to report on. The upgraded document keeps
+// its key order and indentation.
+func UpgradeConfig(data []byte) ([]byte, []string, error) {
+	var head struct {
+		Version *int `json:"config_version"`
+	}
+	if json.Unmarshal(data, &head) != nil {
+		return data, nil, nil
+	}
+	version := 0
+	if head.Version != nil {
+		version = *head.Version
+	}
+	switch {
+	case version < 0:
+		return nil, nil, fmt.Errorf("config_version %d is not a version", version)
+	case version > ConfigVersion:
+		return nil, nil, &ConfigVersionError{Version: version}
+	case version == ConfigVersion:
+		return data, nil, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, ok := v.(*jsonObject)
+	if !ok {
+		return data, nil, nil
+	}
+
+	var applied []string
+	for _, u := range configUpgrades[version:] {
+		changed := u.apply(doc)
+		line := fmt.Sprintf("v%d to v%d: %s", u.from, u.from+1, u.description)
+		if len(changed) > 0 {
+			line += " (" + strings.Join(changed, ", ") + ")"
+		}
+		applied = append(applied, line)
+	}
+	doc.setFirst("config_version", json.Number(strconv.Itoa(ConfigVersion)))
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indentUnit(data))
+	if err := enc.Encode(doc); err != nil {
+		return nil, nil, err
+	}
+	return out.Bytes(), applied, nil
+}
+
+// upgradeV0 rewrites the shorthands hand-written configs used before
+// config_version: a collect string ("network.ping, snmp") or list of metric
+// names, a credentials or groups string, and database as a bare URL.
+func upgradeV0(doc *jsonObject) []string {
+	var changed []string
+	if url, ok := doc.get("database").(string); ok {
+		db := newJSONObject()
+		db.set("url", url)
+		doc.set("database", db)
+		changed = append(changed, "database")
+	}
+	hosts, _ := doc.get("hosts").(*jsonObject)
+	if hosts == nil {
+		return changed
+	}
+	for _, key := range hosts.keys {
+		host, ok := hosts.values[key].(*jsonObject)
+		if !ok {
+			continue
+		}
+		switch collect := host.get("collect").(type) {
+		case string:
+			host.set("collect", collectTasks(splitConfigList(collect)))
+			changed = append(changed, "hosts."+key+".collect")
+		case []interface{}:
+			if tasks := collectTasks(collect); tasks != nil {
+				host.set("collect", tasks)
+				changed = append(changed, "hosts."+key+".collect")
+			}
+		}
+		for _, field := range []string{"credentials", "groups"} {
+			if s, ok := host.get(field).(string); ok {
+				host.set(field, splitConfigList(s))
+				changed = append(changed, "hosts."+key+"."+field)
+			}
+		}
+	}
+	return changed
+}
+
+// collectTasks turns the metric names among items into task objects. It
+// returns nil when there were none to turn.
+func collectTasks(items []interface{}) []interface{} {
+	tasks := make([]interface{}, len(items))
+	turned := false
+	for i, item := range items {
+		tasks[i] = item
+		if metric, ok := item.(string); ok {
+			task := newJSONObject()
+			task.set("metric", metric)
+			tasks[i] = task
+			turned = true
+		}
+	}
+	if !turned {
+		return nil
+	}
+	return tasks
+}
+
+// splitConfigList splits a comma-separated value into its trimmed, non-empty
+// items.
+func splitConfigList(s string) []interface{} {
+	items := []interface{}{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// jsonObject is a decoded JSON object that remembers its key order, so an
+// upgraded config reads like the original.
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]interface{})}
+}
+
+func (o *jsonObject) get(key string) interface{} {
+	return o.values[key]
+}
+
+// set replaces key's value in place, or adds key last.
+func (o *jsonObject) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+// setFirst is set, adding a new key first.
+func (o *jsonObject) setFirst(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append([]string{key}, o.keys...)
+	}
+	o.values[key] = v
+}
+
+// MarshalJSON writes the members in their original order.
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := enc.Encode(key); err != nil {
+			return nil, err
+		}
+		b.WriteByte(':')
+		if err := enc.Encode(o.values[key]); err != nil {
+			return nil, err
+		}
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// decodeOrdered reads the next JSON value from dec, objects as *jsonObject.
+// dec should use numbers, so they are written back as they were.
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	switch delim {
+	case '{':
+		obj := newJSONObject()
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := tok.(string)
+			if !ok {
+				return nil, errors.New("config: object key is not a string")
+			}
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(key, v)
+		}
+		_, err := dec.Token()
+		return obj, err
+	default: // '['
+		items := []interface{}{}
+		for dec.More() {
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		_, err := dec.Token()
+		return items, err
+	}
+}
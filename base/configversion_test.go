@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// v0Config is a config as written by hand before config_version, using every
+// shorthand upgradeV0 rewrites next to entries already in the current shape.
+const v0Config = `{
+    "database": "sqlite://data/nord.db",
+    "hosts": {
+        "core": {
+            "address": "192.0.2.1",
+            "collect": "network.ping, snmp.system,",
+            "credentials": "snmp-ro",
+            "groups": "core, switches"
+        },
+        "edge": {
+            "address": "192.0.2.2",
+            "collect": ["network.ping", {"metric": "snmp.interfaces", "priority": 10}],
+            "credentials": ["ssh"]
+        },
+        "nas": {"address": "192.0.2.3", "collect": [{"metric": "httpcheck.http"}], "credentials": []}
+    },
+    "output": {"directory": "data", "ratio": 1.50, "note": "a <b> & c"}
+}
+`
+
+// v1Config is v0Config upgraded: key order, indentation, numbers and
+// unknown sections are as they were.
+const v1Config = `{
+    "config_version": 1,
+    "database": {
+        "url": "sqlite://data/nord.db"
+    },
+    "hosts": {
+        "core": {
+            "address": "192.0.2.1",
+            "collect": [
+                {
+                    "metric": "network.ping"
+                },
+                {
+                    "metric": "snmp.system"
+                }
+            ],
+            "credentials": [
+                "snmp-ro"
+            ],
+            "groups": [
+                "core",
+                "switches"
+            ]
+        },
+        "edge": {
+            "address": "192.0.2.2",
+            "collect": [
+                {
+                    "metric": "network.ping"
+                },
+                {
+                    "metric": "snmp.interfaces",
+                    "priority": 10
+                }
+            ],
+            "credentials": [
+                "ssh"
+            ]
+        },
+        "nas": {
+            "address": "192.0.2.3",
+            "collect": [
+                {
+                    "metric": "httpcheck.http"
+                }
+            ],
+            "credentials": []
+        }
+    },
+    "output": {
+        "directory": "data",
+        "ratio": 1.50,
+        "note": "a <b> & c"
+    }
+}
+`
+
+func TestUpgradeConfig(t *testing.T) {
+	out, applied, err := UpgradeConfig([]byte(v0Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != v1Config {
+		t.Errorf("upgraded:\n%s\nwant:\n%s", out, v1Config)
+	}
+	want := []string{"v0 to v1: lists where single values were accepted; database as an object " +
+		"(database, hosts.core.collect, hosts.core.credentials, hosts.core.groups, hosts.edge.collect)"}
+	if !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied %q", applied)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(out, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	core, edge := cfg.Hosts["core"], cfg.Hosts["edge"]
+	if cfg.Database.URL != "sqlite://data/nord.db" ||
+		!reflect.DeepEqual(core.Collect, []CollectTask{{Metric: "network.ping"}, {Metric: "snmp.system"}}) ||
+		!reflect.DeepEqual(core.Credentials, []string{"snmp-ro"}) || !reflect.DeepEqual(core.Groups, []string{"core", "switches"}) ||
+		!reflect.DeepEqual(edge.Collect, []CollectTask{{Metric: "network.ping"}, {Metric: "snmp.interfaces", Priority: 10}}) {
+		t.Errorf("config = %+v", cfg)
+	}
+	// The v0 document does not parse as it is, which is what the upgrade is for.
+	if json.Unmarshal([]byte(v0Config), &Config{}) == nil {
+		t.Error("v0 fixture parses without upgrading")
+	}
+
+	// An upgraded document is current.
+	again, applied, err := UpgradeConfig(out)
+	if err != nil || applied != nil || string(again) != string(out) {
+		t.Errorf("upgrading again: applied %q, err %v", applied, err)
+	}
+}
+
+func TestUpgradeConfigShapes(t *testing.T) {
+	for _, tt := range []struct {
+		name, in, want string
+		applied        bool
+	}{
+		{"tabs", "{\n\t\"hosts\": {\"a\": {\"address\": \"x\", \"credentials\": \" \"}}\n}\n",
+			"{\n\t\"config_version\": 1,\n\t\"hosts\": {\n\t\t\"a\": {\n\t\t\t\"address\": \"x\",\n\t\t\t\"credentials\": []\n\t\t}\n\t}\n}\n", true},
+		{"one line", `{"hosts": {}}`, "{\n  \"config_version\": 1,\n  \"hosts\": {}\n}\n", true},
+		{"nothing to change", "{}", "{\n  \"config_version\": 1\n}\n", true},
+		{"explicit v0", `{"config_version": 0, "database": {"url": "x"}}`,
+			"{\n  \"config_version\": 1,\n  \"database\": {\n    \"url\": \"x\"\n  }\n}\n", true},
+		// Left for the caller's parse to report on.
+		{"current", `{"config_version": 1, "hosts": {"a": {"collect": "x"}}}`, `{"config_version": 1, "hosts": {"a": {"collect": "x"}}}`, false},
+		{"not JSON", `{"hosts": `, `{"hosts": `, false},
+		{"not an object", `["hosts"]`, `["hosts"]`, false},
+	} {
+		out, applied, err := UpgradeConfig([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(out) != tt.want || (len(applied) > 0) != tt.applied {
+			t.Errorf("%s: got %q, applied %q; want %q", tt.name, out, applied, tt.want)
+		}
+	}
+}
+
+func TestUpgradeConfigRefuses(t *testing.T) {
+	_, _, err := UpgradeConfig([]byte(`{"config_version": 2, "hosts": {}}`))
+	var verr *ConfigVersionError
+	if !errors.As(err, &verr) || verr.Version != 2 || !strings.Contains(err.Error(), "config_version 2 is newer than this nord understands (1)") {
+		t.Errorf("newer version: %v", err)
+	}
+	if _, _, err := UpgradeConfig([]byte(`{"config_version": -1}`)); err == nil || !strings.Contains(err.Error(), "not a version") {
+		t.Errorf("negative version: %v", err)
+	}
+}
+
+func TestConfigUpgradesChain(t *testing.T) {
+	if len(configUpgrades) != ConfigVersion {
+		t.Fatalf("%d upgrades for config_version %d", len(configUpgrades), ConfigVersion)
+	}
+	for i, u := range configUpgrades {
+		if u.from != i {
+			t.Errorf("upgrade %d is from v%d", i, u.from)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"sync"
+	"time"
+)
+
+// Topics published on the controller's event bus.
+const (
+	// EventHostsDiscovered carries the hosts found by a perception run,
+	// as a map[string]Host keyed like perception.json.
+	EventHostsDiscovered = "perception.hosts"
+	// EventWarning carries a soft failure as a string: something went wrong
+	// but the command still completed, e.g. one remote destination was down.
+	EventWarning = "warning"
+	// EventCollectionDone carries the keys of the hosts a collection run has
+	// just written to the store, as a []string.
+	EventCollectionDone = "collection.done"
+	// EventHostChange carries a HostChange for each host a perception run
+	// found new, gone or with different services than the previous runs.
+	EventHostChange = "perception.change"
+)
+
+// Host change kinds.
+const (
+	HostAppeared        = "appeared"
+	HostDisappeared     = "disappeared"
+	HostServicesChanged = "services_changed"
+)
+
+// HostChange is a difference between a perception run and the ones before it.
+type HostChange struct {
+	Kind     string    `json:"kind"`
+	Address  string    `json:"address"`  // canonical form
+	Services []string  `json:"services"` // detected now; for a disappeared host, when last seen
+	Added    []string  `json:"added,omitempty"`
+	Removed  []string  `json:"removed,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Event is a message published by a plugin to whoever subscribed to its topic.
+type Event struct {
+	Topic  string
+	Source string // name of the publishing plugin
+	Data   interface{}
+}
+
+// EventHandler receives published events.
+type EventHandler func(Event)
+
+// eventBus is a synchronous publish/subscribe registry keyed by topic.
+type eventBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]EventHandler
+}
+
+// Subscribe registers handler for every later event published on topic.
+func (c *Controller) Subscribe(topic string, handler EventHandler) {
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+	if c.events.handlers == nil {
+		c.events.handlers = make(map[string][]EventHandler)
+	}
+	c.events.handlers[topic] = append(c.events.handlers[topic], handler)
+}
+
+// Publish delivers e to the topic's subscribers, in subscription order,
+// before returning. Handlers must not publish on the same controller.
+func (c *Controller) Publish(e Event) {
+	c.events.mu.RLock()
+	handlers := c.events.handlers[e.Topic]
+	c.events.mu.RUnlock()
+	for _, h := range handlers {
+		h(e)
+	}
+}
+
+// Warn publishes a soft failure on EventWarning.
+func (c *Controller) Warn(source, message string) {
+	c.Publish(Event{Topic: EventWarning, Source: source, Data: message})
+}
+
+// Result topics, published as one-shot commands progress so main can report
+// them in structured form.
+const (
+	// EventTaskResult carries a TaskResult for each collection task.
+	EventTaskResult = "collection.task"
+	// EventDelivery carries a DeliveryResult for each remote destination.
+	EventDelivery = "api.delivery"
+)
+
+// Result statuses used by TaskResult and DeliveryResult.
+const (
+	ResultOK       = "ok"
+	ResultError    = "error"
+	ResultSkipped  = "skipped"
+	ResultDeferred = "deferred" // not run: the cycle budget ran out
+)
+
+// TaskResult is the outcome of one collection task on one host.
+type TaskResult struct {
+	Host    string `json:"host"`
+	Task    string `json:"task"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Metrics int    `json:"metrics"`
+}
+
+// DeliveryResult is the outcome of sending to one remote destination.
+type DeliveryResult struct {
+	Destination string `json:"destination"`
+	Endpoint    string `json:"endpoint"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	Bytes       int    `json:"bytes"`
+	DurationMs  int64  `json:"duration_ms"`
+}
@@ -0,0 +1,14 @@
+package plugin
+
+import "fmt"
+
+// ExitError asks main to exit with Code without printing an error message.
+// Plugins return it when the exit status itself is the result, e.g. a status check
+// whose output has already been written.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
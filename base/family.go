@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Address families a collect task can ask for with "prefer" or "require".
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+	FamilyBoth = "both"
+)
+
+// resolveTimeout bounds the lookup of a host's address for one task.
+const resolveTimeout = 5 * time.Second
+
+// LookupIP resolves a host name for the given network ("ip4" or "ip6"). It is
+// a variable so a stub resolver can stand in for the system's.
+var LookupIP = net.DefaultResolver.LookupIP
+
+// Target is an address a task runs against, of one family. Collection passes
+// it to OnCollect as options["target"]; plugins connect to it rather than
+// resolving the host's address again.
+type Target struct {
+	Family  string `json:"family"` // FamilyIPv4 or FamilyIPv6
+	Address string `json:"address"`
+}
+
+// validFamily reports whether f is a value of "prefer" or "require".
+func validFamily(f string) bool {
+	return f == FamilyIPv4 || f == FamilyIPv6 || f == FamilyBoth
+}
+
+// Targets resolves address, a host name or IP address, for the task's
+// family option. Without "prefer" or "require" it returns nil: the task runs
+// once against the address as configured.
+//
+// With "prefer", a family the address has no record of is left out: "ipv6"
+// falls back to IPv4 and "both" runs the families there are. With "require",
+// a missing family fails the task. Notes describe each fallback.
+func (t CollectTask) Targets(address string) (targets []Target, notes []string, err error) {
+	family, required := t.Prefer, false
+	if t.Require != "" {
+		family, required = t.Require, true
+	}
+	if family == "" {
+		return nil, nil, nil
+	}
+	if !validFamily(family) {
+		return nil, nil, fmt.Errorf("unknown address family %q (use ipv4, ipv6 or both)", family)
+	}
+
+	v4, v6, err := resolveFamilies(strings.TrimSpace(address))
+	if v4 == "" && v6 == "" {
+		if err == nil {
+			err = fmt.Errorf("no address found")
+		}
+		return nil, nil, fmt.Errorf("resolving %s: %w", address, err)
+	}
+	found := map[string]string{FamilyIPv4: v4, FamilyIPv6: v6}
+
+	wanted := []string{family}
+	if family == FamilyBoth {
+		wanted = []string{FamilyIPv4, FamilyIPv6}
+	}
+	for _, f := range wanted {
+		if found[f] != "" {
+			targets = append(targets, Target{Family: f, Address: found[f]})
+			continue
+		}
+		if required {
+			return nil, nil, fmt.Errorf("%s has no %s address", address, f)
+		}
+		notes = append(notes, fmt.Sprintf("%s has no %s address", address, f))
+	}
+	if len(targets) == 0 { // preferred family missing: use the other one
+		other := FamilyIPv4
+		if family == FamilyIPv4 {
+			other = FamilyIPv6
+		}
+		targets = append(targets, Target{Family: other, Address: found[other]})
+		notes[len(notes)-1] += ", using " + other
+	}
+	return targets, notes, nil
+}
+
+// resolveFamilies returns the first IPv4 and IPv6 address of host, either
+// empty when there is none. An IP address is returned as its own family.
+// err is the last lookup error, if any.
+func resolveFamilies(host string) (v4, v6 string, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return ip.String(), "", nil
+		}
+		return "", ip.String(), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	if ips, lookupErr := LookupIP(ctx, "ip4", host); lookupErr == nil && len(ips) > 0 {
+		v4 = ips[0].String()
+	} else if lookupErr != nil {
+		err = lookupErr
+	}
+	if ips, lookupErr := LookupIP(ctx, "ip6", host); lookupErr == nil && len(ips) > 0 {
+		v6 = ips[0].String()
+	} else if lookupErr != nil {
+		err = lookupErr
+	}
+	return v4, v6, err
+}
+
+// TargetAddress returns the address collection resolved for this run of a
+// task, or the host's configured address when the task has no family option.
+func TargetAddress(options map[string]interface{}) string {
+	if t, ok := options["target"].(Target); ok && t.Address != "" {
+		return t.Address
+	}
+	host, _ := options["host"].(map[string]interface{})
+	address, _ := host["address"].(string)
+	return address
+}
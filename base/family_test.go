@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubDNS holds the A and AAAA records of host names.
+var stubDNS = map[string]map[string][]string{
+	"dual.example": {"ip4": {"192.0.2.10", "192.0.2.11"}, "ip6": {"2001:db8::10"}},
+	"v4.example":   {"ip4": {"192.0.2.20"}},
+	"v6.example":   {"ip6": {"2001:db8::30"}},
+}
+
+// useStubResolver answers LookupIP from stubDNS for the test.
+func useStubResolver(t *testing.T) {
+	t.Helper()
+	old := LookupIP
+	LookupIP = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		var ips []net.IP
+		for _, a := range stubDNS[host][network] {
+			ips = append(ips, net.ParseIP(a))
+		}
+		if len(ips) == 0 {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return ips, nil
+	}
+	t.Cleanup(func() { LookupIP = old })
+}
+
+func TestTargets(t *testing.T) {
+	useStubResolver(t)
+	for _, tt := range []struct {
+		address         string
+		prefer, require string
+		targets         []Target
+		notes           []string
+		err             string
+	}{
+		// No family option: run against the address as configured.
+		{address: "dual.example"},
+
+		{address: "dual.example", prefer: "ipv4", targets: []Target{{FamilyIPv4, "192.0.2.10"}}},
+		{address: "dual.example", prefer: "ipv6", targets: []Target{{FamilyIPv6, "2001:db8::10"}}},
+		{address: " dual.example ", prefer: "both", targets: []Target{{FamilyIPv4, "192.0.2.10"}, {FamilyIPv6, "2001:db8::10"}}},
+		{address: "dual.example", require: "both", targets: []Target{{FamilyIPv4, "192.0.2.10"}, {FamilyIPv6, "2001:db8::10"}}},
+
+		// A preferred family that is missing falls back.
+		{address: "v4.example", prefer: "ipv6", targets: []Target{{FamilyIPv4, "192.0.2.20"}},
+			notes: []string{"v4.example has no ipv6 address, using ipv4"}},
+		{address: "v6.example", prefer: "ipv4", targets: []Target{{FamilyIPv6, "2001:db8::30"}},
+			notes: []string{"v6.example has no ipv4 address, using ipv6"}},
+		{address: "v4.example", prefer: "both", targets: []Target{{FamilyIPv4, "192.0.2.20"}},
+			notes: []string{"v4.example has no ipv6 address"}},
+
+		// A required one fails the task.
+		{address: "v4.example", require: "ipv6", err: "v4.example has no ipv6 address"},
+		{address: "v6.example", require: "both", err: "v6.example has no ipv4 address"},
+		{address: "gone.example", prefer: "both", err: "resolving gone.example: lookup gone.example: no such host"},
+
+		// An IP address is its own family, and is not looked up.
+		{address: "192.0.2.99", prefer: "both", targets: []Target{{FamilyIPv4, "192.0.2.99"}},
+			notes: []string{"192.0.2.99 has no ipv6 address"}},
+		{address: "2001:DB8::99", require: "ipv6", targets: []Target{{FamilyIPv6, "2001:db8::99"}}},
+		{address: "192.0.2.99", require: "ipv6", err: "192.0.2.99 has no ipv6 address"},
+
+		{address: "dual.example", prefer: "inet6", err: `unknown address family "inet6"`},
+	} {
+		task := CollectTask{Metric: "httpcheck.http", Prefer: tt.prefer, Require: tt.require}
+		targets, notes, err := task.Targets(tt.address)
+		name := tt.address + " " + tt.prefer + tt.require
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want %q", name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(targets, tt.targets) || !reflect.DeepEqual(notes, tt.notes) {
+			t.Errorf("%s: targets %v, notes %q; want %v, %q", name, targets, notes, tt.targets, tt.notes)
+		}
+	}
+}
+
+func TestTargetsLookupFailure(t *testing.T) {
+	old := LookupIP
+	LookupIP = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		if network == "ip6" {
+			return nil, errors.New("server misbehaving")
+		}
+		return []net.IP{net.ParseIP("192.0.2.40")}, nil
+	}
+	t.Cleanup(func() { LookupIP = old })
+
+	// One family failing to resolve is the same as it being missing.
+	targets, notes, err := CollectTask{Prefer: FamilyBoth}.Targets("flaky.example")
+	if err != nil || len(targets) != 1 || targets[0].Address != "192.0.2.40" || len(notes) != 1 {
+		t.Errorf("targets %v, notes %q, err %v", targets, notes, err)
+	}
+	if _, _, err := (CollectTask{Require: FamilyIPv6}).Targets("flaky.example"); err == nil {
+		t.Error("required ipv6 without an answer")
+	}
+}
+
+func TestTargetAddress(t *testing.T) {
+	host := map[string]interface{}{"address": "dual.example"}
+	for _, tt := range []struct {
+		options map[string]interface{}
+		want    string
+	}{
+		{map[string]interface{}{"host": host}, "dual.example"},
+		{map[string]interface{}{"host": host, "target": Target{FamilyIPv6, "2001:db8::10"}}, "2001:db8::10"},
+		{map[string]interface{}{"host": host, "target": Target{}}, "dual.example"},
+		{map[string]interface{}{}, ""},
+	} {
+		if got := TargetAddress(tt.options); got != tt.want {
+			t.Errorf("%v: %q, want %q", tt.options, got, tt.want)
+		}
+	}
+}
+
+func TestValidateFamilies(t *testing.T) {
+	cfg := &Config{Hosts: map[string]Host{"web": {Address: "dual.example", Collect: []CollectTask{
+		{Metric: "httpcheck.http", Prefer: FamilyBoth},
+		{Metric: "httpcheck.http", Prefer: FamilyIPv4, Require: FamilyIPv6},
+		{Metric: "network.ping", Require: "v6"},
+	}}}}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("accepted")
+	}
+	for _, want := range []string{
+		"hosts.web.collect[1]: set prefer or require, not both",
+		`hosts.web.collect[2]: unknown address family "v6"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%v lacks %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "collect[0]") {
+		t.Errorf("both rejected: %v", err)
+	}
+}
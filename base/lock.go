@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RunLockFile is the lock, in the state directory, held by commands that write
+// collection output or the store, so overlapping cron runs cannot interleave.
+const RunLockFile = "nord.lock"
+
+// lockPollInterval is how often a waiting AcquireRunLock retries.
+const lockPollInterval = 200 * time.Millisecond
+
+// LockedError reports that another process holds the run lock.
+type LockedError struct {
+	PID     int
+	Started time.Time
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return "another run in progress"
+	}
+	return fmt.Sprintf("another run in progress (pid %d, started %s)", e.PID, e.Started.Format(time.RFC3339))
+}
+
+// RunLock is a held advisory lock; Release it when the command is done.
+type RunLock struct {
+	f *os.File
+}
+
+// AcquireRunLock takes the run lock, retrying for up to wait when another
+// process holds it. The lock is an OS file lock, so one left behind by a
+// process that died is released by the kernel and the file is simply reused;
+// the pid and start time written into it only serve the error message.
+func AcquireRunLock(wait time.Duration) (*RunLock, error) {
+	path := StateFile(RunLockFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create %s: %w", filepath.Dir(path), err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		err := tryLock(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLocked) {
+			f.Close()
+			return nil, fmt.Errorf("could not lock %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			holder := readLockHolder(f)
+			f.Close()
+			return nil, holder
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%d %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+		f.Sync() //nolint:errcheck // the contents are informational
+	}
+	return &RunLock{f: f}, nil
+}
+
+// Release unlocks and closes the lock file. The file itself is left in place.
+func (l *RunLock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	unlock(l.f) //nolint:errcheck // closing releases the lock anyway
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// readLockHolder parses the "pid started" line the holder wrote.
+func readLockHolder(f *os.File) *LockedError {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 128))
+	if err != nil && len(data) == 0 {
+		return &LockedError{}
+	}
+	fields := strings.Fields(string(data))
+	holder := &LockedError{}
+	if len(fields) >= 1 {
+		holder.PID, _ = strconv.Atoi(fields[0])
+	}
+	if len(fields) >= 2 {
+		holder.Started, _ = time.Parse(time.RFC3339, fields[1])
+	}
+	return holder
+}
@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useStateDir points the state directory at a fresh temp directory.
+func useStateDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	old := paths
+	t.Cleanup(func() { paths = old })
+	paths = PathsConfig{DataDir: dir, StateDir: dir}
+	return dir
+}
+
+func TestRunLockMutualExclusion(t *testing.T) {
+	useStateDir(t)
+
+	var active, most atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := AcquireRunLock(5 * time.Second)
+			if err != nil {
+				errs <- err
+				return
+			}
+			n := active.Add(1)
+			for {
+				m := most.Load()
+				if n <= m || most.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(100 * time.Millisecond)
+			active.Add(-1)
+			errs <- lock.Release()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if most.Load() != 1 {
+		t.Errorf("%d runs held the lock at once", most.Load())
+	}
+}
+
+func TestRunLockHeld(t *testing.T) {
+	useStateDir(t)
+	lock, err := AcquireRunLock(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = AcquireRunLock(0)
+	var locked *LockedError
+	if !errors.As(err, &locked) || locked.PID != os.Getpid() {
+		t.Fatalf("err = %v, want a LockedError naming this process", err)
+	}
+	if time.Since(locked.Started) > time.Minute {
+		t.Errorf("started %v", locked.Started)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("without --wait the second run waited %v", time.Since(start))
+	}
+	if !strings.HasPrefix(err.Error(), fmt.Sprintf("another run in progress (pid %d, started ", os.Getpid())) {
+		t.Errorf("message = %q", err)
+	}
+
+	// A waiting run gets the lock once the first one releases it.
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		lock.Release()
+	}()
+	second, err := AcquireRunLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("waiting run: %v", err)
+	}
+	second.Release()
+}
+
+func TestRunLockStaleFile(t *testing.T) {
+	useStateDir(t)
+	// A lock file left by a run that is gone holds no lock.
+	if err := os.WriteFile(StateFile(RunLockFile), []byte("424242 2020-01-01T00:00:00Z\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := AcquireRunLock(0)
+	if err != nil {
+		t.Fatalf("stale lock file: %v", err)
+	}
+	defer lock.Release()
+	data, _ := os.ReadFile(StateFile(RunLockFile))
+	if !strings.HasPrefix(string(data), fmt.Sprintf("%d ", os.Getpid())) {
+		t.Errorf("lock file = %q", data)
+	}
+}
+
+// TestLockHolderProcess is not a test: TestRunLockDeadHolder runs the test
+// binary with NORD_LOCK_HOLDER set so this takes the lock in another process.
+func TestLockHolderProcess(t *testing.T) {
+	dir := os.Getenv("NORD_LOCK_HOLDER")
+	if dir == "" {
+		return
+	}
+	paths = PathsConfig{DataDir: dir, StateDir: dir}
+	if _, err := AcquireRunLock(0); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("locked")
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+func TestRunLockDeadHolder(t *testing.T) {
+	dir := useStateDir(t)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHolderProcess$")
+	cmd.Env = append(os.Environ(), "NORD_LOCK_HOLDER="+dir)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	if line, _ := bufio.NewReader(out).ReadString('\n'); line != "locked\n" {
+		t.Fatalf("holder: %q", line)
+	}
+
+	_, err = AcquireRunLock(0)
+	var locked *LockedError
+	if !errors.As(err, &locked) || locked.PID != cmd.Process.Pid {
+		t.Fatalf("err = %v, want the holder's pid %d", err, cmd.Process.Pid)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	lock, err := AcquireRunLock(0)
+	if err != nil {
+		t.Fatalf("after the holder died: %v", err)
+	}
+	lock.Release()
+}
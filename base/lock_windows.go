@@ -0,0 +1,34 @@
+//go:build windows
+
+package plugin
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// errLocked is returned by tryLock when another process holds the lock.
+var errLocked = errors.New("locked")
+
+// lockOffset is the byte LockFileEx locks. It lies far past the end of the
+// file: Windows locks are mandatory, so locking the pid line at offset 0
+// would make it unreadable to a process waiting on the lock.
+const lockOffset = 1 << 31
+
+// tryLock takes an exclusive LockFileEx lock on the byte at lockOffset without
+// blocking.
+func tryLock(f *os.File) error {
+	ol := &windows.Overlapped{Offset: lockOffset}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLocked
+	}
+	return err
+}
+
+func unlock(f *os.File) error {
+	ol := &windows.Overlapped{Offset: lockOffset}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
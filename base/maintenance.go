@@ -0,0 +1,283 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaintenanceFile, in the state directory, holds the ad-hoc maintenance
+// windows set with `collection maintenance`.
+const MaintenanceFile = "maintenance.json"
+
+// MaintenanceWindow is a time hosts are expected to be down for work. A
+// one-off window has Start and End (RFC 3339); a recurring one has a Cron
+// expression for its start ("minute hour day-of-month month day-of-week", in
+// local time) and a Duration. Metrics collected during a window are flagged
+// with the window's name in extra "maintenance", alert rules are not evaluated
+// for the host, and availability reports leave the time out.
+type MaintenanceWindow struct {
+	Name     string `json:"name,omitempty"`
+	Start    string `json:"start,omitempty"`
+	End      string `json:"end,omitempty"`
+	Cron     string `json:"cron,omitempty"`
+	Duration string `json:"duration,omitempty"` // Go duration of each recurring window
+}
+
+// maintenanceWindow is a MaintenanceWindow with its times parsed.
+type maintenanceWindow struct {
+	name       string
+	start, end time.Time // one-off
+	cron       *cronSpec // recurring
+	duration   time.Duration
+}
+
+// compile checks the window and parses its times.
+func (w MaintenanceWindow) compile() (maintenanceWindow, error) {
+	mw := maintenanceWindow{name: w.Name}
+	switch {
+	case w.Cron != "" && (w.Start != "" || w.End != ""):
+		return mw, errors.New("set either cron and duration or start and end, not both")
+	case w.Cron != "":
+		spec, err := parseCron(w.Cron)
+		if err != nil {
+			return mw, err
+		}
+		d, err := time.ParseDuration(w.Duration)
+		if err != nil || d <= 0 {
+			return mw, fmt.Errorf("duration %q is not a positive duration", w.Duration)
+		}
+		mw.cron, mw.duration = spec, d
+		if mw.name == "" {
+			mw.name = "cron " + w.Cron
+		}
+	default:
+		var err error
+		if mw.start, err = time.Parse(time.RFC3339, w.Start); err != nil {
+			return mw, fmt.Errorf("start %q is not an RFC 3339 time", w.Start)
+		}
+		if mw.end, err = time.Parse(time.RFC3339, w.End); err != nil {
+			return mw, fmt.Errorf("end %q is not an RFC 3339 time", w.End)
+		}
+		if !mw.end.After(mw.start) {
+			return mw, errors.New("end is not after start")
+		}
+		if mw.name == "" {
+			mw.name = w.Start + "/" + w.End
+		}
+	}
+	return mw, nil
+}
+
+// active reports whether the window covers t.
+func (w maintenanceWindow) active(t time.Time) bool {
+	if w.cron == nil {
+		return !t.Before(w.start) && t.Before(w.end)
+	}
+	// A window started at a matching minute in (t-duration, t] covers t.
+	t = t.Local()
+	minute := t.Truncate(time.Minute)
+	for m := minute; t.Sub(m) < w.duration; m = m.Add(-time.Minute) {
+		if w.cron.matches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// cronSpec is a parsed five-field cron expression; each field holds the
+// values it matches.
+type cronSpec struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+// parseCron parses "minute hour day-of-month month day-of-week". Fields take
+// *, numbers, ranges (1-5), steps (*/15, 0-30/10) and comma-separated lists;
+// Sunday is 0 or 7.
+func parseCron(expr string) (*cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q does not have 5 fields", expr)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := make([]map[int]bool, 5)
+	for i, f := range fields {
+		set, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return &cronSpec{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField returns the values in [lo, hi] a cron field matches.
+func parseCronField(field string, lo, hi int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return nil, fmt.Errorf("bad value %q", part)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return nil, fmt.Errorf("bad value %q", part)
+				}
+			} else if hasStep {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether the minute t is one the expression fires at. As in
+// cron, when both day fields are restricted either one matching is enough.
+func (c *cronSpec) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// adHocWindow is a window set on one host from the command line.
+type adHocWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// maintenanceState is the content of MaintenanceFile, keyed by host key.
+type maintenanceState struct {
+	Hosts map[string]adHocWindow `json:"hosts"`
+}
+
+func loadMaintenanceState() maintenanceState {
+	var state maintenanceState
+	if data, err := os.ReadFile(StateFile(MaintenanceFile)); err == nil {
+		json.Unmarshal(data, &state) //nolint:errcheck
+	}
+	if state.Hosts == nil {
+		state.Hosts = make(map[string]adHocWindow)
+	}
+	return state
+}
+
+// SetMaintenance puts a host in maintenance from now for d, replacing any
+// ad-hoc window it had; d <= 0 ends its ad-hoc window. Expired windows are
+// dropped from the file.
+func SetMaintenance(hostKey string, d time.Duration, now time.Time) error {
+	state := loadMaintenanceState()
+	for key, w := range state.Hosts {
+		if !now.Before(w.End) {
+			delete(state.Hosts, key)
+		}
+	}
+	if d > 0 {
+		state.Hosts[hostKey] = adHocWindow{Start: now, End: now.Add(d)}
+	} else {
+		delete(state.Hosts, hostKey)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(StateFile(MaintenanceFile), data, 0644)
+}
+
+// MaintenanceSchedule tells which hosts are in maintenance: the global and
+// per-host windows of a config and the ad-hoc windows in the state directory.
+type MaintenanceSchedule struct {
+	global []maintenanceWindow
+	hosts  map[string][]maintenanceWindow
+	adHoc  map[string]adHocWindow
+}
+
+// NewMaintenanceSchedule compiles the windows of cfg and loads the ad-hoc
+// ones. Invalid windows, which Validate reports, are skipped.
+func NewMaintenanceSchedule(cfg *Config) *MaintenanceSchedule {
+	s := &MaintenanceSchedule{hosts: make(map[string][]maintenanceWindow), adHoc: loadMaintenanceState().Hosts}
+	compile := func(windows []MaintenanceWindow) []maintenanceWindow {
+		var out []maintenanceWindow
+		for _, w := range windows {
+			if mw, err := w.compile(); err == nil {
+				out = append(out, mw)
+			}
+		}
+		return out
+	}
+	if cfg != nil {
+		s.global = compile(cfg.Maintenance)
+		for key, h := range cfg.Hosts {
+			if len(h.Maintenance) > 0 {
+				s.hosts[key] = compile(h.Maintenance)
+			}
+		}
+	}
+	return s
+}
+
+// Active returns the name of the window a host is in at t, if any. An ad-hoc
+// window takes precedence over the configured ones.
+func (s *MaintenanceSchedule) Active(hostKey string, t time.Time) (string, bool) {
+	if w, ok := s.adHoc[hostKey]; ok && !t.Before(w.Start) && t.Before(w.End) {
+		return "ad-hoc until " + w.End.Local().Format(time.RFC3339), true
+	}
+	for _, w := range append(s.hosts[hostKey], s.global...) {
+		if w.active(t) {
+			return w.name, true
+		}
+	}
+	return "", false
+}
+
+// AdHoc lists the ad-hoc windows not yet over at t, by host key.
+func (s *MaintenanceSchedule) AdHoc(t time.Time) []string {
+	var lines []string
+	for key, w := range s.adHoc {
+		if t.Before(w.End) {
+			lines = append(lines, fmt.Sprintf("%s until %s", key, w.End.Local().Format(time.RFC3339)))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,211 @@
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// local returns the time in the local zone, which cron windows are in.
+func local(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.Local)
+}
+
+func TestRecurringWindowAcrossMidnight(t *testing.T) {
+	// Saturdays from 23:00 for four hours; 2024-06-01 is a Saturday.
+	w, err := MaintenanceWindow{Name: "patch night", Cron: "0 23 * * 6", Duration: "4h"}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		at   time.Time
+		want bool
+	}{
+		{local(2024, 6, 1, 22, 59), false},
+		{local(2024, 6, 1, 23, 0), true},
+		{local(2024, 6, 1, 23, 59), true},
+		{local(2024, 6, 2, 0, 0), true}, // Sunday, still in Saturday's window
+		{local(2024, 6, 2, 2, 59), true},
+		{local(2024, 6, 2, 3, 0), false},
+		{local(2024, 6, 2, 23, 30), false},
+		{local(2024, 6, 8, 23, 30), true},
+	} {
+		if got := w.active(tt.at); got != tt.want {
+			t.Errorf("%s: active = %v", tt.at.Format("Mon 15:04"), got)
+		}
+	}
+
+	// Over the year end too, with a window started every night.
+	nightly, err := MaintenanceWindow{Cron: "30 22 * * *", Duration: "3h"}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nightly.name != "cron 30 22 * * *" {
+		t.Errorf("name = %q", nightly.name)
+	}
+	if !nightly.active(local(2025, 1, 1, 1, 15)) || nightly.active(local(2025, 1, 1, 1, 30)) {
+		t.Error("nightly window does not cover 01:15 only on new year's day")
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		at   time.Time
+		want bool
+	}{
+		{"*/15 * * * *", local(2024, 6, 3, 10, 45), true},
+		{"*/15 * * * *", local(2024, 6, 3, 10, 50), false},
+		{"0-30/10 8 * * *", local(2024, 6, 3, 8, 30), true},
+		{"0-30/10 8 * * *", local(2024, 6, 3, 8, 40), false},
+		{"0 2 * * 1-5", local(2024, 6, 3, 2, 0), true},  // Monday
+		{"0 2 * * 1-5", local(2024, 6, 2, 2, 0), false}, // Sunday
+		{"0 2 * * 7", local(2024, 6, 2, 2, 0), true},    // 7 is Sunday too
+		{"0 2 1,15 * *", local(2024, 6, 15, 2, 0), true},
+		// Both day fields restricted: either one matches.
+		{"0 2 1 * 1", local(2024, 6, 3, 2, 0), true},
+		{"0 2 1 * 1", local(2024, 6, 1, 2, 0), true},
+		{"0 2 1 * 1", local(2024, 6, 4, 2, 0), false},
+		{"0 2 * 12 *", local(2024, 6, 4, 2, 0), false},
+	} {
+		spec, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.expr, err)
+		}
+		if got := spec.matches(tt.at); got != tt.want {
+			t.Errorf("%q at %s = %v", tt.expr, tt.at.Format("Mon 2 15:04"), got)
+		}
+	}
+
+	for expr, msg := range map[string]string{
+		"0 23 * *":      "does not have 5 fields",
+		"60 23 * * *":   "outside 0-59",
+		"0 23 * * 8":    "outside 0-7",
+		"*/0 * * * *":   "bad step",
+		"a * * * *":     "bad value",
+		"30-10 * * * *": "outside 0-59",
+	} {
+		if _, err := parseCron(expr); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: %v, want %q", expr, err, msg)
+		}
+	}
+}
+
+func TestMaintenanceWindowValidation(t *testing.T) {
+	for _, tt := range []struct {
+		w   MaintenanceWindow
+		msg string
+	}{
+		{MaintenanceWindow{Cron: "0 23 * * 6", Duration: "4h", Start: "2024-06-01T23:00:00Z"}, "not both"},
+		{MaintenanceWindow{Cron: "0 23 * * 6"}, "not a positive duration"},
+		{MaintenanceWindow{Cron: "0 23 * * 6", Duration: "-1h"}, "not a positive duration"},
+		{MaintenanceWindow{Start: "saturday", End: "2024-06-02T03:00:00Z"}, "not an RFC 3339 time"},
+		{MaintenanceWindow{Start: "2024-06-02T03:00:00Z", End: "2024-06-01T23:00:00Z"}, "end is not after start"},
+	} {
+		if _, err := tt.w.compile(); err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%+v: %v, want %q", tt.w, err, tt.msg)
+		}
+	}
+
+	cfg := &Config{
+		Hosts:       map[string]Host{"router": {Address: "192.0.2.1", Maintenance: []MaintenanceWindow{{Cron: "0 25 * * *", Duration: "1h"}}}},
+		Maintenance: []MaintenanceWindow{{Start: "2024-06-01T23:00:00Z"}},
+	}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "hosts.router.maintenance[0]:") || !strings.Contains(err.Error(), "maintenance[0]: end") {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestMaintenanceSchedule(t *testing.T) {
+	t.Setenv(EnvStateDir, t.TempDir())
+	LoadPaths()
+	t.Cleanup(LoadPaths)
+
+	cfg := &Config{
+		Hosts: map[string]Host{
+			"router": {Address: "192.0.2.1", Maintenance: []MaintenanceWindow{{Name: "firmware", Start: "2024-06-03T08:00:00Z", End: "2024-06-03T09:00:00Z"}}},
+			"nas":    {Address: "192.0.2.2"},
+		},
+		Maintenance: []MaintenanceWindow{{Name: "patch night", Cron: "0 23 * * 6", Duration: "4h"}},
+	}
+	s := NewMaintenanceSchedule(cfg)
+	for _, tt := range []struct {
+		host string
+		at   time.Time
+		want string
+	}{
+		{"router", time.Date(2024, 6, 3, 8, 30, 0, 0, time.UTC), "firmware"},
+		{"nas", time.Date(2024, 6, 3, 8, 30, 0, 0, time.UTC), ""},
+		{"nas", local(2024, 6, 2, 1, 0), "patch night"},
+		{"router", local(2024, 6, 2, 1, 0), "patch night"},
+		{"nas", local(2024, 6, 2, 4, 0), ""},
+	} {
+		if got, ok := s.Active(tt.host, tt.at); got != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s at %s: %q, %v", tt.host, tt.at, got, ok)
+		}
+	}
+}
+
+func TestAdHocMaintenance(t *testing.T) {
+	t.Setenv(EnvStateDir, t.TempDir())
+	LoadPaths()
+	t.Cleanup(LoadPaths)
+
+	cfg := &Config{
+		Hosts:       map[string]Host{"router": {Address: "192.0.2.1"}, "nas": {Address: "192.0.2.2"}},
+		Maintenance: []MaintenanceWindow{{Name: "patch night", Cron: "0 23 * * 6", Duration: "4h"}},
+	}
+	now := local(2024, 6, 1, 22, 0)
+	if err := SetMaintenance("router", 2*time.Hour, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMaintenance("nas", 30*time.Minute, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ad-hoc window takes precedence over the recurring one it overlaps.
+	s := NewMaintenanceSchedule(cfg)
+	adHoc := "ad-hoc until " + now.Add(2*time.Hour).Format(time.RFC3339)
+	if got, _ := s.Active("router", now.Add(90*time.Minute)); got != adHoc {
+		t.Errorf("router at 23:30 = %q, want %q", got, adHoc)
+	}
+	if got, _ := s.Active("router", now.Add(3*time.Hour)); got != "patch night" {
+		t.Errorf("router after the ad-hoc window = %q", got)
+	}
+	if _, ok := s.Active("router", now.Add(-time.Minute)); ok {
+		t.Error("router in maintenance before the ad-hoc window")
+	}
+	if got := s.AdHoc(now.Add(time.Hour)); len(got) != 1 || !strings.HasPrefix(got[0], "router until ") {
+		t.Errorf("AdHoc = %q", got)
+	}
+
+	// Setting a window drops the expired ones; duration 0 ends one.
+	if err := SetMaintenance("router", 0, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(StateFile(MaintenanceFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state maintenanceState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Hosts) != 0 {
+		t.Errorf("state = %s", data)
+	}
+	if _, ok := NewMaintenanceSchedule(cfg).Active("router", now.Add(30*time.Minute)); ok {
+		t.Error("router still in maintenance after ending it")
+	}
+
+	// A corrupt file means no ad-hoc windows.
+	if err := os.WriteFile(StateFile(MaintenanceFile), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := NewMaintenanceSchedule(nil).AdHoc(now); len(got) != 0 {
+		t.Errorf("AdHoc from a corrupt file = %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Environment variables overriding the "paths" config section.
+const (
+	EnvDataDir    = "NORD_DATA_DIR"
+	EnvStateDir   = "NORD_STATE_DIR"
+	EnvDevicesDir = "NORD_DEVICES_DIR"
+)
+
+// defaultDataDir holds the config, collection and perception output when nothing else is set.
+const defaultDataDir = "data"
+
+// paths is the active layout, set by LoadPaths at startup. Its directories are
+// created on the first write into them.
+var paths = resolvePaths(PathsConfig{})
+
+// resolvePaths applies the environment overrides and defaults to cfg.
+func resolvePaths(cfg PathsConfig) PathsConfig {
+	for _, o := range []struct {
+		env string
+		dst *string
+	}{
+		{EnvDataDir, &cfg.DataDir},
+		{EnvStateDir, &cfg.StateDir},
+		{EnvDevicesDir, &cfg.DevicesDir},
+	} {
+		if v := os.Getenv(o.env); v != "" {
+			*o.dst = v
+		}
+	}
+	if cfg.DataDir == "" {
+		cfg.DataDir = defaultDataDir
+	}
+	if cfg.StateDir == "" {
+		cfg.StateDir = cfg.DataDir
+	}
+	return cfg
+}
+
+// LoadPaths reads the "paths" section of ConfigFile and applies the
+// environment overrides. A missing or unparsable config leaves the defaults in
+// place. No directory is created here, so commands that write nothing, such
+// as help and version, leave no trace; WriteDataFile and WriteStateFile create
+// theirs when missing.
+func LoadPaths() {
+	var cfg struct {
+		Paths PathsConfig `json:"paths"`
+	}
+	if data, err := ReadConfigFile(); err == nil {
+		json.Unmarshal(data, &cfg) //nolint:errcheck // commands report config errors themselves
+	}
+	paths = resolvePaths(cfg.Paths)
+}
+
+// DataDir is the directory for output files such as collection.json.
+func DataDir() string { return paths.DataDir }
+
+// DataFile returns the path of name in the data directory.
+func DataFile(name string) string { return filepath.Join(paths.DataDir, name) }
+
+// StateFile returns the path of name in the state directory, where plugins keep
+// counters and offsets between runs.
+func StateFile(name string) string { return filepath.Join(paths.StateDir, name) }
+
+// WriteDataFile writes data to name in the data directory, creating the
+// directory first when missing.
+func WriteDataFile(name string, data []byte, perm os.FileMode) error {
+	return writeFile(DataFile(name), data, perm)
+}
+
+// WriteStateFile writes data to name in the state directory, creating the
+// directory first when missing.
+func WriteStateFile(name string, data []byte, perm os.FileMode) error {
+	return writeFile(StateFile(name), data, perm)
+}
+
+// writeFile is os.WriteFile after creating path's directory.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create %s: %w", filepath.Dir(path), err)
+	}
+	return os.WriteFile(path, data, perm)
+}
+
+// DeviceFile returns the device definition file for deviceType used by the
+// named plugin: <devices_dir>/<plugin>/<type>.json, or plugins/<plugin>/devices/<type>.json
+// when no devices directory is configured.
+func DeviceFile(pluginName, deviceType string) string {
+	if paths.DevicesDir == "" {
+		return filepath.Join("plugins", pluginName, "devices", deviceType+".json")
+	}
+	return filepath.Join(paths.DevicesDir, pluginName, deviceType+".json")
+}
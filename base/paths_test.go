@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePaths(t *testing.T) {
+	for _, env := range []string{EnvDataDir, EnvStateDir, EnvDevicesDir} {
+		t.Setenv(env, "")
+	}
+	if got := resolvePaths(PathsConfig{}); got.DataDir != "data" || got.StateDir != "data" || got.DevicesDir != "" {
+		t.Errorf("defaults = %+v", got)
+	}
+	if got := resolvePaths(PathsConfig{DataDir: "/var/lib/nord"}); got.StateDir != "/var/lib/nord" {
+		t.Errorf("state_dir does not follow data_dir: %+v", got)
+	}
+
+	t.Setenv(EnvStateDir, "/run/nord")
+	t.Setenv(EnvDevicesDir, "/etc/nord/devices")
+	got := resolvePaths(PathsConfig{DataDir: "/var/lib/nord", StateDir: "/tmp/state", DevicesDir: "devices"})
+	if got.DataDir != "/var/lib/nord" || got.StateDir != "/run/nord" || got.DevicesDir != "/etc/nord/devices" {
+		t.Errorf("environment overrides = %+v", got)
+	}
+}
+
+func TestWriteFilesCreateDirectories(t *testing.T) {
+	dir := t.TempDir()
+	old := paths
+	t.Cleanup(func() { paths = old })
+	paths = PathsConfig{DataDir: filepath.Join(dir, "data"), StateDir: filepath.Join(dir, "state", "nord"), DevicesDir: filepath.Join(dir, "devices")}
+
+	if err := WriteDataFile("collection.json", []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteStateFile("offsets.json", []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for path, perm := range map[string]os.FileMode{
+		filepath.Join(dir, "data", "collection.json"):       0644,
+		filepath.Join(dir, "state", "nord", "offsets.json"): 0600,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+		} else if info.Mode().Perm() != perm {
+			t.Errorf("%s: mode %v, want %v", path, info.Mode().Perm(), perm)
+		}
+	}
+	if got, want := DeviceFile("snmp", "cisco"), filepath.Join(dir, "devices", "snmp", "cisco.json"); got != want {
+		t.Errorf("DeviceFile = %s, want %s", got, want)
+	}
+	paths.DevicesDir = ""
+	if got, want := DeviceFile("snmp", "cisco"), filepath.Join("plugins", "snmp", "devices", "cisco.json"); got != want {
+		t.Errorf("default DeviceFile = %s, want %s", got, want)
+	}
+}
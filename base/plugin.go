@@ -3,6 +3,9 @@ package plugin
 
 import (
 	"fmt"
+	"io"
+	"log"
+	"os"
 	"strings"
 
 	"observer/store"
@@ -30,6 +33,12 @@ type Plugin interface {
 	GetMenus() map[string]MenuItem
 }
 
+// HostCollector is implemented by plugins that can collect a single host on demand,
+// in-process, rather than running a full collection cycle.
+type HostCollector interface {
+	CollectHost(hostKey string) error
+}
+
 // BasePlugin is a helper struct that plugins can embed for default functionality.
 type BasePlugin struct {
 	Controller *Controller
@@ -74,13 +83,51 @@ func (p *BasePlugin) GetMenus() map[string]MenuItem {
 type Controller struct {
 	Plugins map[string]Plugin
 	Store   store.Store // nil when no database is configured
+	Metrics *Metrics    // metrics about nord itself
+	Safety  Safety      // highest action safety level OnCommand runs
+	Stdout  io.Writer   // where plugins print their progress; os.Stdout when nil
+	Logger  *log.Logger // where plugins log warnings; the standard logger when nil
+	events  eventBus
 }
 
 // NewController creates and returns a new Controller.
 func NewController() *Controller {
-	return &Controller{
+	c := &Controller{
 		Plugins: make(map[string]Plugin),
+		Metrics: &Metrics{},
+		Safety:  SafetyDestructive,
+	}
+	c.countResults()
+	return c
+}
+
+// Out returns the writer plugins print their progress to. It may be called
+// on a nil controller, as plugins built bare in tests have one.
+func (c *Controller) Out() io.Writer {
+	if c == nil || c.Stdout == nil {
+		return os.Stdout
+	}
+	return c.Stdout
+}
+
+// Printf prints plugin progress to Out.
+func (c *Controller) Printf(format string, a ...interface{}) {
+	fmt.Fprintf(c.Out(), format, a...)
+}
+
+// Logf logs a plugin warning or notice to Logger. It may be called on a nil
+// controller.
+func (c *Controller) Logf(format string, a ...interface{}) {
+	if c == nil || c.Logger == nil {
+		log.Printf(format, a...)
+		return
 	}
+	c.Logger.Printf(format, a...)
+}
+
+// Println prints plugin progress to Out.
+func (c *Controller) Println(a ...interface{}) {
+	fmt.Fprintln(c.Out(), a...)
 }
 
 // AddPlugin registers a new plugin with the controller.
@@ -90,11 +137,32 @@ func (c *Controller) AddPlugin(p Plugin) {
 	p.Init(c)
 }
 
-// OnCommand dispatches a command to the specified plugin.
+// OnCommand dispatches a command to the specified plugin, refusing actions
+// above the controller's safety level.
 func (c *Controller) OnCommand(pluginName string, args map[string]string) error {
 	plugin, exists := c.Plugins[strings.ToLower(pluginName)]
 	if !exists {
 		return fmt.Errorf("plugin '%s' not found", pluginName)
 	}
+	if err := c.checkSafety(pluginName, args["action"]); err != nil {
+		return err
+	}
 	return plugin.OnCommand(args)
 }
+
+// CollectHost runs the collect tasks of one host through the collection plugin.
+// It writes to the store like `collection collect`, and is refused alike.
+func (c *Controller) CollectHost(hostKey string) error {
+	p, exists := c.Plugins["collection"]
+	if !exists {
+		return fmt.Errorf("plugin 'collection' not found")
+	}
+	if err := c.checkSafety("collection", "collect"); err != nil {
+		return err
+	}
+	hc, ok := p.(HostCollector)
+	if !ok {
+		return fmt.Errorf("plugin 'collection' does not support per-host collection")
+	}
+	return hc.CollectHost(hostKey)
+}
@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Payload schemas a collection can be rendered in, chosen per destination.
+const (
+	// SchemaLegacy is collection.json's shape, kept for the PHP server:
+	// {host: {"metrics": {"metrics": {label: {name, value, ...}}}}}.
+	SchemaLegacy = "legacy"
+	// SchemaFlat is {host: {"metrics": [...], "collections": {...}, "errors": [...]}},
+	// which adds each metric's plugin and instance and every task's outcome.
+	SchemaFlat = "flat"
+)
+
+// ResultsFile, in the data directory, holds the last collection in SchemaFlat.
+// collection.json holds the same metrics in SchemaLegacy.
+const ResultsFile = "results.json"
+
+// Results is a collection run keyed by host key.
+type Results map[string]*HostResult
+
+// HostResult is everything one collection gathered for a host.
+type HostResult struct {
+	Metrics     []MetricResult        `json:"metrics"`     // sorted by label
+	Collections map[string]TaskResult `json:"collections"` // keyed by task, e.g. "snmp.system"
+	Errors      []string              `json:"errors"`      // "<task>: <error>" for each failed task
+}
+
+// MetricResult is one metric as a plugin returned it. Label is its key in the
+// host's metric map; Extra holds every field without a typed counterpart,
+// such as value_num, dedup or an oid.
+type MetricResult struct {
+	Label    string                 `json:"label"`
+	Plugin   string                 `json:"plugin,omitempty"`
+	Name     string                 `json:"name,omitempty"`
+	Value    interface{}            `json:"value,omitempty"`
+	Type     string                 `json:"type,omitempty"`
+	Category string                 `json:"category,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Unit     string                 `json:"unit,omitempty"`
+	Extra    map[string]interface{} `json:"extra,omitempty"`
+}
+
+// ParseSchema returns the schema named s; "" is SchemaLegacy.
+func ParseSchema(s string) (string, error) {
+	switch s {
+	case "", SchemaLegacy:
+		return SchemaLegacy, nil
+	case SchemaFlat:
+		return SchemaFlat, nil
+	}
+	return "", fmt.Errorf("unknown schema %q (use legacy or flat)", s)
+}
+
+// NewMetricResult types a metric map returned by a plugin's OnCollect.
+// String name, type, category, instance and unit fields and the value are
+// lifted out; everything else is kept in Extra.
+func NewMetricResult(label, pluginName string, m map[string]interface{}) MetricResult {
+	mr := MetricResult{Label: label, Plugin: pluginName}
+	for k, v := range m {
+		s, isString := v.(string)
+		switch {
+		case k == "value":
+			mr.Value = v
+		case k == "name" && isString:
+			mr.Name = s
+		case k == "type" && isString:
+			mr.Type = s
+		case k == "category" && isString:
+			mr.Category = s
+		case k == "instance" && isString:
+			mr.Instance = s
+		case k == "unit" && isString:
+			mr.Unit = s
+		default:
+			if mr.Extra == nil {
+				mr.Extra = make(map[string]interface{})
+			}
+			mr.Extra[k] = v
+		}
+	}
+	return mr
+}
+
+// legacy returns the metric as an entry of collection.json's metric map.
+// Plugin and instance are not part of that shape.
+func (m MetricResult) legacy() map[string]interface{} {
+	out := make(map[string]interface{}, len(m.Extra)+5)
+	for k, v := range m.Extra {
+		out[k] = v
+	}
+	if m.Name != "" {
+		out["name"] = m.Name
+	}
+	if m.Value != nil {
+		out["value"] = m.Value
+	}
+	if m.Type != "" {
+		out["type"] = m.Type
+	}
+	if m.Category != "" {
+		out["category"] = m.Category
+	}
+	if m.Unit != "" {
+		out["unit"] = m.Unit
+	}
+	return out
+}
+
+// Legacy renders the results in SchemaLegacy.
+func (r Results) Legacy() map[string]interface{} {
+	out := make(map[string]interface{}, len(r))
+	for key, h := range r {
+		metrics := make(map[string]interface{}, len(h.Metrics))
+		for _, m := range h.Metrics {
+			metrics[m.Label] = m.legacy()
+		}
+		out[key] = map[string]interface{}{
+			"metrics": map[string]interface{}{"metrics": metrics},
+		}
+	}
+	return out
+}
+
+// Render returns the results in the given schema, ready for json.Marshal.
+func (r Results) Render(schema string) interface{} {
+	if schema == SchemaFlat {
+		return r
+	}
+	return r.Legacy()
+}
+
+// LegacyResults types a collection decoded from SchemaLegacy. Only metrics
+// are recovered: that shape has no plugin, instance, task outcomes or errors.
+func LegacyResults(data map[string]interface{}) Results {
+	r := make(Results, len(data))
+	for key, hostAny := range data {
+		h := &HostResult{Metrics: []MetricResult{}, Collections: map[string]TaskResult{}, Errors: []string{}}
+		r[key] = h
+		hostMap, _ := hostAny.(map[string]interface{})
+		wrapper, _ := hostMap["metrics"].(map[string]interface{})
+		metrics, _ := wrapper["metrics"].(map[string]interface{})
+		for label, mAny := range metrics {
+			if m, ok := mAny.(map[string]interface{}); ok {
+				h.Metrics = append(h.Metrics, NewMetricResult(label, "", m))
+			}
+		}
+		h.SortMetrics()
+	}
+	return r
+}
+
+// SortMetrics orders the host's metrics by label.
+func (h *HostResult) SortMetrics() {
+	sort.Slice(h.Metrics, func(i, j int) bool { return h.Metrics[i].Label < h.Metrics[j].Label })
+}
+
+// ReadResults loads the last collection from ResultsFile, or from
+// collection.json through LegacyResults when there is none, e.g. when it was
+// written by an older nord.
+func ReadResults() (Results, error) {
+	if data, err := os.ReadFile(DataFile(ResultsFile)); err == nil {
+		var r Results
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", ResultsFile, err)
+		}
+		return r, nil
+	}
+	data, err := os.ReadFile(DataFile("collection.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read collection.json: %w", err)
+	}
+	var legacy map[string]interface{}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, fmt.Errorf("could not parse collection.json: %w", err)
+	}
+	return LegacyResults(legacy), nil
+}
@@ -0,0 +1,264 @@
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fixtureResults is a collection of two hosts: a switch with a failed task,
+// and a web server. Numbers are float64, as they decode from JSON.
+func fixtureResults() Results {
+	return Results{
+		"sw1": {
+			Metrics: []MetricResult{
+				{Label: "cpu", Plugin: "snmp", Name: "cpu", Value: "12", Type: "gauge", Category: "system", Unit: "%",
+					Extra: map[string]interface{}{"value_num": 12.0}},
+				{Label: "if_eth0_status", Plugin: "snmp", Name: "if_status", Value: "up", Type: "status",
+					Category: "interfaces", Instance: "eth0",
+					Extra: map[string]interface{}{"oid": "1.3.6.1.2.1.2.2.1.8.1", "dedup": true}},
+			},
+			Collections: map[string]TaskResult{
+				"snmp.system": {Host: "sw1", Task: "snmp.system", Status: ResultOK, Metrics: 1},
+				"ssh.disk":    {Host: "sw1", Task: "ssh.disk", Status: ResultError, Error: "connection refused"},
+			},
+			Errors: []string{"ssh.disk: connection refused"},
+		},
+		"web1": {
+			Metrics: []MetricResult{
+				{Label: "http", Plugin: "httpcheck", Name: "http", Value: "down", Type: "status"},
+			},
+			Collections: map[string]TaskResult{
+				"httpcheck.http": {Host: "web1", Task: "httpcheck.http", Status: ResultOK, Metrics: 1},
+			},
+			Errors: []string{},
+		},
+	}
+}
+
+const legacyGolden = `{
+  "sw1": {
+    "metrics": {
+      "metrics": {
+        "cpu": {
+          "category": "system",
+          "name": "cpu",
+          "type": "gauge",
+          "unit": "%",
+          "value": "12",
+          "value_num": 12
+        },
+        "if_eth0_status": {
+          "category": "interfaces",
+          "dedup": true,
+          "name": "if_status",
+          "oid": "1.3.6.1.2.1.2.2.1.8.1",
+          "type": "status",
+          "value": "up"
+        }
+      }
+    }
+  },
+  "web1": {
+    "metrics": {
+      "metrics": {
+        "http": {
+          "name": "http",
+          "type": "status",
+          "value": "down"
+        }
+      }
+    }
+  }
+}`
+
+const flatGolden = `{
+  "sw1": {
+    "metrics": [
+      {
+        "label": "cpu",
+        "plugin": "snmp",
+        "name": "cpu",
+        "value": "12",
+        "type": "gauge",
+        "category": "system",
+        "unit": "%",
+        "extra": {
+          "value_num": 12
+        }
+      },
+      {
+        "label": "if_eth0_status",
+        "plugin": "snmp",
+        "name": "if_status",
+        "value": "up",
+        "type": "status",
+        "category": "interfaces",
+        "instance": "eth0",
+        "extra": {
+          "dedup": true,
+          "oid": "1.3.6.1.2.1.2.2.1.8.1"
+        }
+      }
+    ],
+    "collections": {
+      "snmp.system": {
+        "host": "sw1",
+        "task": "snmp.system",
+        "status": "ok",
+        "metrics": 1
+      },
+      "ssh.disk": {
+        "host": "sw1",
+        "task": "ssh.disk",
+        "status": "error",
+        "error": "connection refused",
+        "metrics": 0
+      }
+    },
+    "errors": [
+      "ssh.disk: connection refused"
+    ]
+  },
+  "web1": {
+    "metrics": [
+      {
+        "label": "http",
+        "plugin": "httpcheck",
+        "name": "http",
+        "value": "down",
+        "type": "status"
+      }
+    ],
+    "collections": {
+      "httpcheck.http": {
+        "host": "web1",
+        "task": "httpcheck.http",
+        "status": "ok",
+        "metrics": 1
+      }
+    },
+    "errors": []
+  }
+}`
+
+// render returns r in schema as indented JSON.
+func render(t *testing.T, r Results, schema string) string {
+	t.Helper()
+	data, err := json.MarshalIndent(r.Render(schema), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRenderSchemas(t *testing.T) {
+	for schema, want := range map[string]string{SchemaLegacy: legacyGolden, SchemaFlat: flatGolden, "": legacyGolden} {
+		if got := render(t, fixtureResults(), schema); got != want {
+			t.Errorf("%q rendering:\n%s\nwant:\n%s", schema, got, want)
+		}
+	}
+}
+
+func TestSchemaRoundTrips(t *testing.T) {
+	// Flat carries everything.
+	var flat Results
+	if err := json.Unmarshal([]byte(flatGolden), &flat); err != nil {
+		t.Fatal(err)
+	}
+	if want := fixtureResults(); !reflect.DeepEqual(flat, want) {
+		t.Errorf("flat decodes to %+v", flat)
+	}
+
+	// Legacy keeps every metric field but plugin and instance.
+	var legacy map[string]interface{}
+	if err := json.Unmarshal([]byte(legacyGolden), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	got := LegacyResults(legacy)
+	want := fixtureResults()
+	for _, h := range want {
+		for i := range h.Metrics {
+			h.Metrics[i].Plugin, h.Metrics[i].Instance = "", ""
+		}
+		h.Collections, h.Errors = map[string]TaskResult{}, []string{}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("legacy decodes to %+v, want %+v", got, want)
+	}
+	// And renders back to the same document.
+	if again := render(t, got, SchemaLegacy); again != legacyGolden {
+		t.Errorf("legacy round trip:\n%s", again)
+	}
+}
+
+func TestNewMetricResult(t *testing.T) {
+	got := NewMetricResult("uptime", "local", map[string]interface{}{
+		"name": "uptime", "value": 3600.0, "type": "gauge", "unit": "s", "value_num": 3600.0,
+		"category": 7, // not a string, so left in Extra
+	})
+	want := MetricResult{Label: "uptime", Plugin: "local", Name: "uptime", Value: 3600.0, Type: "gauge", Unit: "s",
+		Extra: map[string]interface{}{"category": 7, "value_num": 3600.0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v", got)
+	}
+	if legacy := got.legacy(); legacy["category"] != 7 || legacy["value"] != 3600.0 || len(legacy) != 6 {
+		t.Errorf("legacy = %v", legacy)
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	for s, want := range map[string]string{"": SchemaLegacy, "legacy": SchemaLegacy, "flat": SchemaFlat} {
+		if got, err := ParseSchema(s); err != nil || got != want {
+			t.Errorf("%q = %q, %v", s, got, err)
+		}
+	}
+	if _, err := ParseSchema("Flat"); err == nil {
+		t.Error("Flat accepted")
+	}
+	cfg := &Config{Remote: RemoteConfig{Destinations: map[string]Destination{
+		"central": {Endpoint: "https://nord.example/api", Active: true, Schema: "nested"},
+	}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `remote.destinations.central: unknown schema "nested"`) {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestReadResults(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(EnvDataDir, dir)
+	LoadPaths()
+	t.Cleanup(LoadPaths)
+
+	if _, err := ReadResults(); err == nil {
+		t.Error("read results from an empty data directory")
+	}
+
+	// An older nord wrote only collection.json.
+	if err := os.WriteFile(DataFile("collection.json"), []byte(legacyGolden), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ReadResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r["sw1"].Metrics) != 2 || r["sw1"].Metrics[0].Plugin != "" {
+		t.Errorf("from collection.json: %+v", r["sw1"])
+	}
+
+	// results.json is preferred.
+	if err := os.WriteFile(DataFile(ResultsFile), []byte(flatGolden), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = ReadResults(); err != nil || !reflect.DeepEqual(r, fixtureResults()) {
+		t.Errorf("from %s: %+v, %v", ResultsFile, r, err)
+	}
+	if err := os.WriteFile(DataFile(ResultsFile), []byte(`{"sw1": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadResults(); err == nil || !strings.Contains(err.Error(), "could not parse results.json") {
+		t.Errorf("corrupt %s: %v", ResultsFile, err)
+	}
+}
@@ -0,0 +1,206 @@
+package plugin
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"observer/store"
+)
+
+// Self-metric names. Counters only ever grow within a process; gauges are set.
+const (
+	SelfTasksRun        = "tasks_run"
+	SelfTaskErrors      = "task_errors"
+	SelfDeliveries      = "deliveries"
+	SelfDeliveryErrors  = "delivery_errors"
+	SelfWarnings        = "warnings"
+	SelfStoreWrites     = "store_writes"
+	SelfStoreErrors     = "store_write_errors"
+	SelfStoreWriteMs    = "store_write_ms" // cumulative; divide by store_writes for the mean
+	SelfFlowPackets     = "flow_packets"
+	SelfFlowDecodeFails = "flow_decode_errors"
+	SelfSyslogMessages  = "syslog_messages"
+	SelfSyslogDropped   = "syslog_dropped"        // rate limited or unparseable
+	SelfBudgetExceeded  = "cycle_budget_exceeded" // collections that deferred tasks
+	SelfDeferredTasks   = "deferred_tasks"        // gauge: tasks the last budgeted collection deferred
+)
+
+// SelfMetric is one value read from a Metrics registry.
+type SelfMetric struct {
+	Name  string
+	Type  string // "counter" or "gauge"
+	Value int64
+}
+
+// Metrics is the registry of metrics about nord itself. It is safe for
+// concurrent use, and its methods are no-ops on a nil *Metrics so optional
+// components can hold one without checking.
+type Metrics struct {
+	counters sync.Map // name -> *atomic.Int64
+	gauges   sync.Map // name -> *atomic.Int64
+}
+
+// registryValue returns the named value in m, creating it at zero.
+func registryValue(m *sync.Map, name string) *atomic.Int64 {
+	if v, ok := m.Load(name); ok {
+		return v.(*atomic.Int64)
+	}
+	v, _ := m.LoadOrStore(name, new(atomic.Int64))
+	return v.(*atomic.Int64)
+}
+
+// Add increments a counter by delta.
+func (m *Metrics) Add(name string, delta int64) {
+	if m == nil {
+		return
+	}
+	registryValue(&m.counters, name).Add(delta)
+}
+
+// Set sets a gauge.
+func (m *Metrics) Set(name string, v int64) {
+	if m == nil {
+		return
+	}
+	registryValue(&m.gauges, name).Store(v)
+}
+
+// Snapshot returns every counter and gauge, plus the process's goroutine
+// count and heap size, sorted by name.
+func (m *Metrics) Snapshot() []SelfMetric {
+	if m == nil {
+		return nil
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	m.Set("goroutines", int64(runtime.NumGoroutine()))
+	m.Set("heap_alloc_bytes", int64(mem.HeapAlloc))
+
+	var out []SelfMetric
+	collect := func(kind string) func(k, v interface{}) bool {
+		return func(k, v interface{}) bool {
+			out = append(out, SelfMetric{Name: k.(string), Type: kind, Value: v.(*atomic.Int64).Load()})
+			return true
+		}
+	}
+	m.counters.Range(collect("counter"))
+	m.gauges.Range(collect("gauge"))
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Get returns the current value of a counter or gauge, 0 if never set.
+func (m *Metrics) Get(name string) int64 {
+	if m == nil {
+		return 0
+	}
+	if v, ok := m.counters.Load(name); ok {
+		return v.(*atomic.Int64).Load()
+	}
+	if v, ok := m.gauges.Load(name); ok {
+		return v.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+// Records converts a snapshot to metric records under the agent's own host,
+// plugin "nord", category "self".
+func (m *Metrics) Records(at time.Time) []store.MetricRecord {
+	snap := m.Snapshot()
+	records := make([]store.MetricRecord, 0, len(snap))
+	for _, s := range snap {
+		v := float64(s.Value)
+		records = append(records, store.MetricRecord{
+			HostKey:     AgentHostKey,
+			HostName:    AgentHostName(),
+			HostAddress: AgentHostAddress,
+			Plugin:      "nord",
+			Name:        s.Name,
+			Category:    "self",
+			MetricType:  s.Type,
+			Value:       strconv.FormatInt(s.Value, 10),
+			ValueNum:    &v,
+			CollectedAt: at,
+		})
+	}
+	return records
+}
+
+// countResults keeps the registry's task, delivery and warning counters in
+// step with what plugins publish on the event bus.
+func (c *Controller) countResults() {
+	m := c.Metrics
+	c.Subscribe(EventTaskResult, func(e Event) {
+		m.Add(SelfTasksRun, 1)
+		if tr, ok := e.Data.(TaskResult); ok && tr.Status == ResultError {
+			m.Add(SelfTaskErrors, 1)
+		}
+	})
+	c.Subscribe(EventDelivery, func(e Event) {
+		dr, ok := e.Data.(DeliveryResult)
+		if !ok || dr.Status == ResultSkipped {
+			return
+		}
+		m.Add(SelfDeliveries, 1)
+		if dr.Status == ResultError {
+			m.Add(SelfDeliveryErrors, 1)
+		}
+	})
+	c.Subscribe(EventWarning, func(Event) { m.Add(SelfWarnings, 1) })
+}
+
+// InstrumentStore wraps st so every write is counted and timed in m.
+// A nil st stays nil.
+func InstrumentStore(st store.Store, m *Metrics) store.Store {
+	if st == nil {
+		return nil
+	}
+	return &instrumentedStore{Store: st, metrics: m}
+}
+
+// instrumentedStore times the write methods of the store it embeds.
+type instrumentedStore struct {
+	store.Store
+	metrics *Metrics
+}
+
+func (s *instrumentedStore) observe(start time.Time, err error) {
+	s.metrics.Add(SelfStoreWrites, 1)
+	s.metrics.Add(SelfStoreWriteMs, time.Since(start).Milliseconds())
+	if err != nil {
+		s.metrics.Add(SelfStoreErrors, 1)
+	}
+}
+
+func (s *instrumentedStore) WriteBatch(ctx context.Context, records []store.MetricRecord) error {
+	start := time.Now()
+	err := s.Store.WriteBatch(ctx, records)
+	s.observe(start, err)
+	return err
+}
+
+func (s *instrumentedStore) WriteBatchRecent(ctx context.Context, records []store.MetricRecord) error {
+	start := time.Now()
+	err := s.Store.WriteBatchRecent(ctx, records)
+	s.observe(start, err)
+	return err
+}
+
+func (s *instrumentedStore) WriteFlows(ctx context.Context, records []store.FlowRecord) error {
+	start := time.Now()
+	err := s.Store.WriteFlows(ctx, records)
+	s.observe(start, err)
+	return err
+}
+
+func (s *instrumentedStore) UpsertInterfaces(ctx context.Context, records []store.InterfaceRecord) error {
+	start := time.Now()
+	err := s.Store.UpsertInterfaces(ctx, records)
+	s.observe(start, err)
+	return err
+}
@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"observer/store"
+)
+
+func TestMetricsConcurrentAdds(t *testing.T) {
+	m := &Metrics{}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Add(SelfFlowPackets, 1)
+			}
+			m.Set(SelfDeferredTasks, 7)
+		}()
+	}
+	wg.Wait()
+	if got := m.Get(SelfFlowPackets); got != 5000 {
+		t.Errorf("flow_packets = %d, want 5000", got)
+	}
+
+	types := map[string]string{}
+	for _, s := range m.Snapshot() {
+		types[s.Name] = s.Type
+	}
+	for name, want := range map[string]string{SelfFlowPackets: "counter", SelfDeferredTasks: "gauge", "goroutines": "gauge", "heap_alloc_bytes": "gauge"} {
+		if types[name] != want {
+			t.Errorf("%s: type %q, want %q", name, types[name], want)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.Add(SelfTasksRun, 1)
+	m.Set(SelfDeferredTasks, 1)
+	if m.Get(SelfTasksRun) != 0 || m.Snapshot() != nil || len(m.Records(time.Now())) != 0 {
+		t.Error("a nil registry recorded something")
+	}
+}
+
+func TestControllerCountsResults(t *testing.T) {
+	c := NewController()
+	for _, status := range []string{ResultOK, ResultError, ResultOK} {
+		c.Publish(Event{Topic: EventTaskResult, Source: "collection", Data: TaskResult{Host: "web1", Task: "ping", Status: status}})
+	}
+	for _, status := range []string{ResultOK, ResultSkipped, ResultError} {
+		c.Publish(Event{Topic: EventDelivery, Source: "api", Data: DeliveryResult{Destination: "central", Status: status}})
+	}
+	c.Warn("network", "arp table unreadable")
+
+	for name, want := range map[string]int64{
+		SelfTasksRun:       3,
+		SelfTaskErrors:     1,
+		SelfDeliveries:     2, // a skipped send is not a delivery
+		SelfDeliveryErrors: 1,
+		SelfWarnings:       1,
+	} {
+		if got := c.Metrics.Get(name); got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestMetricsRecords(t *testing.T) {
+	m := &Metrics{}
+	m.Add(SelfTasksRun, 4)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var tasks *store.MetricRecord
+	records := m.Records(at)
+	for i, r := range records {
+		if r.HostKey != AgentHostKey || r.Plugin != "nord" || r.Category != "self" || !r.CollectedAt.Equal(at) {
+			t.Errorf("record %s = %+v", r.Name, r)
+		}
+		if r.Name == SelfTasksRun {
+			tasks = &records[i]
+		}
+	}
+	if tasks == nil || tasks.Value != "4" || tasks.ValueNum == nil || *tasks.ValueNum != 4 || tasks.MetricType != "counter" {
+		t.Errorf("tasks_run = %+v", tasks)
+	}
+}
+
+// failingStore fails every WriteBatch once fail is set.
+type failingStore struct {
+	store.Store
+	fail bool
+}
+
+func (s *failingStore) WriteBatch(ctx context.Context, records []store.MetricRecord) error {
+	if s.fail {
+		return errors.New("database is locked")
+	}
+	return nil
+}
+
+func TestInstrumentStore(t *testing.T) {
+	if InstrumentStore(nil, &Metrics{}) != nil {
+		t.Error("a nil store was wrapped")
+	}
+	m := &Metrics{}
+	inner := &failingStore{}
+	st := InstrumentStore(inner, m)
+	st.WriteBatch(context.Background(), nil)
+	inner.fail = true
+	if err := st.WriteBatch(context.Background(), nil); err == nil {
+		t.Error("the store's error was swallowed")
+	}
+	if m.Get(SelfStoreWrites) != 2 || m.Get(SelfStoreErrors) != 1 {
+		t.Errorf("writes %d, errors %d", m.Get(SelfStoreWrites), m.Get(SelfStoreErrors))
+	}
+}
@@ -0,0 +1,16 @@
+package plugin
+
+import "context"
+
+// Service is implemented by plugins that run a long-lived component, such as a
+// network receiver, under `nord daemon`. Serve blocks until ctx is cancelled and
+// returns nil then; any earlier return is treated as a crash and restarted.
+type Service interface {
+	Serve(ctx context.Context) error
+}
+
+// Reloader is implemented by plugins that can pick up configuration changes in
+// place. The daemon calls Reload on every such plugin when it receives SIGHUP.
+type Reloader interface {
+	Reload() error
+}
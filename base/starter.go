@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// StarterAnswers describe a first configuration: one host, optionally an SNMP
+// or SSH credential for it, a database and a perception range. `nord init`
+// asks for them on the terminal and the TUI in a form.
+type StarterAnswers struct {
+	HostKey     string
+	HostAddress string
+	CredType    string // "snmp", "ssh" or "none"
+	Community   string
+	SNMPVersion string
+	SSHUser     string
+	SSHPass     string
+	SSHPort     string
+	DeviceType  string
+	DatabaseURL string // "none" or empty disables the database
+	ScanRange   string // "none" or empty skips perception
+}
+
+// CredentialPlugin names the plugin that collects with a credential type.
+func CredentialPlugin(credType string) string {
+	if credType == "ssh" {
+		return "sshcollect"
+	}
+	return "snmp"
+}
+
+// StarterDatabaseURL is the database of a starter config: nord.db in the
+// data directory. A relative sqlite:// path is opened from the working
+// directory, so the URL is built from DataDir rather than spelled data/nord.db.
+func StarterDatabaseURL() string {
+	return "sqlite://" + DataFile("nord.db")
+}
+
+// StarterConfig builds the configuration described by the answers and validates it.
+func StarterConfig(a StarterAnswers) (*Config, error) {
+	cfg := &Config{
+		ConfigVersion: ConfigVersion,
+		Hosts:         map[string]Host{},
+		Credentials:   map[string]Credential{},
+		Remote:        RemoteConfig{Destinations: map[string]Destination{}},
+		Perception:    map[string]PerceptionEnv{},
+	}
+
+	host := Host{
+		Address: a.HostAddress,
+		Name:    a.HostKey,
+		Collect: []CollectTask{{Metric: "network.ping"}},
+	}
+	switch a.CredType {
+	case "snmp":
+		credKey := a.HostKey + "_snmp"
+		cfg.Credentials[credKey] = Credential{
+			Host: a.HostAddress, Port: 161, Type: a.DeviceType,
+			Community: a.Community, Version: a.SNMPVersion,
+		}
+		host.Collect = append(host.Collect, CollectTask{Metric: "snmp", Credentials: credKey})
+	case "ssh":
+		port, err := strconv.Atoi(a.SSHPort)
+		if err != nil {
+			return nil, fmt.Errorf("SSH port %q is not a number", a.SSHPort)
+		}
+		credKey := a.HostKey + "_ssh"
+		cfg.Credentials[credKey] = Credential{
+			User: a.SSHUser, Pass: a.SSHPass, Host: a.HostAddress, Port: port, Type: a.DeviceType,
+		}
+		host.Collect = append(host.Collect, CollectTask{Metric: "sshcollect", Credentials: credKey})
+	case "none":
+	default:
+		return nil, fmt.Errorf("credential type %q is not snmp, ssh or none", a.CredType)
+	}
+	cfg.Hosts[a.HostKey] = host
+
+	if a.DatabaseURL != "none" {
+		cfg.Database.URL = a.DatabaseURL
+	}
+	if a.ScanRange != "none" && a.ScanRange != "" {
+		cfg.Perception["local_network"] = PerceptionEnv{
+			Ranges:    []string{a.ScanRange},
+			Method:    "nmap",
+			Enabled:   true,
+			Detection: []string{"network.ping", "network.ssh"},
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// StarterJSON encodes only the sections a starter config fills in, leaving out
+// empty fields so the file is easy to read and extend by hand.
+func StarterJSON(cfg *Config) ([]byte, error) {
+	sections := map[string]interface{}{
+		"config_version": cfg.ConfigVersion,
+		"hosts":          cfg.Hosts,
+		"credentials":    cfg.Credentials,
+		"perception":     cfg.Perception,
+		"remote":         cfg.Remote,
+		"database":       cfg.Database,
+	}
+	raw, err := json.Marshal(sections)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	doc = pruneEmpty(doc)
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	return json.MarshalIndent(doc, "", "    ")
+}
+
+// pruneEmpty drops empty strings, nulls and empty objects or arrays from decoded JSON.
+// It returns nil when v itself ends up empty.
+func pruneEmpty(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if pruned := pruneEmpty(child); pruned == nil {
+				delete(t, k)
+			} else {
+				t[k] = pruned
+			}
+		}
+		if len(t) == 0 {
+			return nil
+		}
+	case []interface{}:
+		kept := t[:0]
+		for _, child := range t {
+			if pruned := pruneEmpty(child); pruned != nil {
+				kept = append(kept, pruned)
+			}
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return kept
+	case string:
+		if t == "" {
+			return nil
+		}
+	case nil:
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Units plugins set on their metrics. Any other unit, or none, is allowed:
+// values in an unknown unit are shown as they are, followed by the unit.
+const (
+	UnitBytes       = "bytes"
+	UnitKilobytes   = "KB"
+	UnitMegabytes   = "MB"
+	UnitGigabytes   = "GB"
+	UnitBytesPerSec = "bytes/s"
+	UnitSeconds     = "s"
+	UnitMillis      = "ms"
+	UnitDays        = "days"
+	UnitPercent     = "%"
+	UnitCelsius     = "°C"
+)
+
+// byteUnits is how many bytes one of each size unit is. Sizes are binary:
+// the local plugin's MB is 1024*1024 bytes.
+var byteUnits = map[string]float64{
+	"b":     1,
+	"bytes": 1,
+	"kb":    1 << 10,
+	"mb":    1 << 20,
+	"gb":    1 << 30,
+	"tb":    1 << 40,
+}
+
+// secondUnits is how many seconds one of each time unit is.
+var secondUnits = map[string]float64{
+	"ms":      0.001,
+	"s":       1,
+	"seconds": 1,
+	"min":     60,
+	"h":       3600,
+	"days":    86400,
+}
+
+// ToBytes converts value in a size unit, such as "MB", to bytes. It reports
+// false when unit is not a size.
+func ToBytes(value float64, unit string) (float64, bool) {
+	f, ok := byteUnits[strings.ToLower(unit)]
+	return value * f, ok
+}
+
+// ToSeconds converts value in a time unit, such as "ms", to seconds. It
+// reports false when unit is not a time.
+func ToSeconds(value float64, unit string) (float64, bool) {
+	f, ok := secondUnits[strings.ToLower(unit)]
+	return value * f, ok
+}
+
+// HumanBytes formats a size in bytes with the largest unit it has at least one
+// of, e.g. "512 B", "3.8 GB".
+func HumanBytes(b float64) string {
+	sign := ""
+	if b < 0 {
+		sign, b = "-", -b
+	}
+	for _, u := range []string{"TB", "GB", "MB", "KB"} {
+		if f := byteUnits[strings.ToLower(u)]; b >= f {
+			return sign + strconv.FormatFloat(b/f, 'f', 1, 64) + " " + u
+		}
+	}
+	return sign + strconv.FormatFloat(b, 'f', 0, 64) + " B"
+}
+
+// HumanDuration formats seconds as e.g. "2d 3h", "4h 12m", "35s" or, under a
+// second, "250ms".
+func HumanDuration(secs float64) string {
+	if secs > -1 && secs < 1 && secs != 0 {
+		return strconv.FormatFloat(math.Round(secs*1e6)/1e3, 'f', -1, 64) + "ms"
+	}
+	d := time.Duration(secs * float64(time.Second)).Round(time.Second)
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	days, hours := int(d/(24*time.Hour)), int(d%(24*time.Hour)/time.Hour)
+	mins, s := int(d%time.Hour/time.Minute), int(d%time.Minute/time.Second)
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%s%dd %dh", sign, days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%s%dh %dm", sign, hours, mins)
+	case mins > 0:
+		return fmt.Sprintf("%s%dm %ds", sign, mins, s)
+	}
+	return fmt.Sprintf("%s%ds", sign, s)
+}
+
+// FormatUnit renders a metric value for display: sizes in bytes, KB, MB, ...
+// are scaled with HumanBytes, times in ms, s, ... with HumanDuration, and
+// other values get their unit appended. Values that are not numbers, and
+// values without a unit, are returned unchanged.
+func FormatUnit(value, unit string) string {
+	if unit == "" {
+		return value
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return value
+	}
+	if b, ok := ToBytes(n, unit); ok {
+		return HumanBytes(b)
+	}
+	if strings.EqualFold(unit, UnitBytesPerSec) {
+		return HumanBytes(n) + "/s"
+	}
+	if s, ok := ToSeconds(n, unit); ok {
+		return HumanDuration(s)
+	}
+	if unit == UnitPercent {
+		return value + "%"
+	}
+	return value + " " + unit
+}
@@ -0,0 +1,98 @@
+package plugin
+
+import "testing"
+
+func TestConvertUnits(t *testing.T) {
+	for _, tt := range []struct {
+		value float64
+		unit  string
+		bytes float64
+		ok    bool
+	}{
+		{512, UnitBytes, 512, true},
+		{2, UnitKilobytes, 2048, true},
+		{3874, UnitMegabytes, 3874 << 20, true},
+		{1, "gb", 1 << 30, true},
+		{1, "TB", 1 << 40, true},
+		{5, UnitSeconds, 0, false},
+		{5, "", 0, false},
+	} {
+		if got, ok := ToBytes(tt.value, tt.unit); ok != tt.ok || (ok && got != tt.bytes) {
+			t.Errorf("ToBytes(%v, %q) = %v, %v", tt.value, tt.unit, got, ok)
+		}
+	}
+	for _, tt := range []struct {
+		value float64
+		unit  string
+		secs  float64
+		ok    bool
+	}{
+		{250, UnitMillis, 0.25, true},
+		{90, UnitSeconds, 90, true},
+		{2, "min", 120, true},
+		{1, "H", 3600, true},
+		{3, UnitDays, 259200, true},
+		{3, UnitBytes, 0, false},
+	} {
+		if got, ok := ToSeconds(tt.value, tt.unit); ok != tt.ok || (ok && got != tt.secs) {
+			t.Errorf("ToSeconds(%v, %q) = %v, %v", tt.value, tt.unit, got, ok)
+		}
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	for b, want := range map[float64]string{
+		0:                "0 B",
+		512:              "512 B",
+		1023:             "1023 B",
+		1024:             "1.0 KB",
+		1536:             "1.5 KB",
+		3874 << 20:       "3.8 GB",
+		5 << 40:          "5.0 TB",
+		-(3 << 20) - 1e5: "-3.1 MB",
+	} {
+		if got := HumanBytes(b); got != want {
+			t.Errorf("HumanBytes(%v) = %q, want %q", b, got, want)
+		}
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	for secs, want := range map[float64]string{
+		0:       "0s",
+		0.25:    "250ms",
+		0.0015:  "1.5ms",
+		35:      "35s",
+		252:     "4m 12s",
+		15120:   "4h 12m",
+		90061:   "1d 1h",
+		-7200.4: "-2h 0m",
+	} {
+		if got := HumanDuration(secs); got != want {
+			t.Errorf("HumanDuration(%v) = %q, want %q", secs, got, want)
+		}
+	}
+}
+
+func TestFormatUnit(t *testing.T) {
+	for _, tt := range []struct {
+		value, unit, want string
+	}{
+		{"3874", UnitMegabytes, "3.8 GB"},
+		{"1073741824", UnitBytes, "1.0 GB"},
+		{"2048", UnitBytesPerSec, "2.0 KB/s"},
+		{"90061", UnitSeconds, "1d 1h"},
+		{"250", UnitMillis, "250ms"},
+		{"42.5", UnitPercent, "42.5%"},
+		{"24", UnitCelsius, "24 °C"},
+		{"230.1", "V", "230.1 V"},
+		{"3874", "", "3874"},          // no unit: as collected
+		{"up", UnitSeconds, "up"},     // not a number
+		{" 512 ", UnitBytes, "512 B"}, // surrounding space
+		{"1d 1h 0m 0s", "s", "1d 1h 0m 0s"},
+	} {
+		if got := FormatUnit(tt.value, tt.unit); got != tt.want {
+			t.Errorf("FormatUnit(%q, %q) = %q, want %q", tt.value, tt.unit, got, tt.want)
+		}
+	}
+}
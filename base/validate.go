@@ -0,0 +1,228 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// supportedDatabaseSchemes mirrors the schemes store.Open accepts.
+var supportedDatabaseSchemes = map[string]bool{
+	"sqlite": true, "sqlite3": true, "mysql": true, "postgres": true, "postgresql": true,
+	"clickhouse": true,
+}
+
+// Validate checks the configuration for mistakes that would only show up at
+// collection time. It returns every problem found, joined, or nil.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	for _, key := range sortedKeys(c.Hosts) {
+		host := c.Hosts[key]
+		if strings.TrimSpace(host.Address) == "" {
+			add("hosts.%s: address is empty", key)
+		}
+		for i, task := range host.Collect {
+			if strings.TrimSpace(task.Metric) == "" {
+				add("hosts.%s.collect[%d]: metric is empty", key, i)
+			}
+			if task.Credentials != "" {
+				if _, ok := c.Credentials[task.Credentials]; !ok {
+					add("hosts.%s.collect[%d]: credentials '%s' are not defined", key, i, task.Credentials)
+				}
+			}
+			if task.Prefer != "" && task.Require != "" {
+				add("hosts.%s.collect[%d]: set prefer or require, not both", key, i)
+			}
+			for _, f := range []string{task.Prefer, task.Require} {
+				if f != "" && !validFamily(f) {
+					add("hosts.%s.collect[%d]: unknown address family %q (use ipv4, ipv6 or both)", key, i, f)
+				}
+			}
+			if raw, ok := task.Options["oids"]; ok {
+				for _, problem := range inlineOIDProblems(raw) {
+					add("hosts.%s.collect[%d]: options.oids%s", key, i, problem)
+				}
+			}
+		}
+		for i, w := range host.Maintenance {
+			if _, err := w.compile(); err != nil {
+				add("hosts.%s.maintenance[%d]: %v", key, i, err)
+			}
+		}
+	}
+
+	for i, w := range c.Maintenance {
+		if _, err := w.compile(); err != nil {
+			add("maintenance[%d]: %v", i, err)
+		}
+	}
+
+	for _, key := range sortedKeys(c.Credentials) {
+		cred := c.Credentials[key]
+		if cred.Port < 0 || cred.Port > 65535 {
+			add("credentials.%s: port %d is out of range", key, cred.Port)
+		}
+		if cred.Type == "" {
+			add("credentials.%s: type is empty", key)
+		}
+	}
+
+	for _, key := range sortedKeys(c.Perception) {
+		env := c.Perception[key]
+		if !env.Enabled {
+			continue
+		}
+		if len(env.Ranges) == 0 {
+			add("perception.%s: no ranges", key)
+		}
+		for _, r := range env.Ranges {
+			if strings.Contains(r, "/") {
+				if _, _, err := net.ParseCIDR(r); err != nil {
+					add("perception.%s: range %q is not a valid CIDR", key, r)
+				}
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(c.Remote.Destinations) {
+		dest := c.Remote.Destinations[key]
+		if !dest.Active {
+			continue
+		}
+		u, err := url.Parse(dest.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("remote.destinations.%s: endpoint %q is not an http(s) URL", key, dest.Endpoint)
+		}
+		if _, err := ParseSchema(dest.Schema); err != nil {
+			add("remote.destinations.%s: %v", key, err)
+		}
+		if _, err := dest.SkipAge(); err != nil {
+			add("remote.destinations.%s: %v", key, err)
+		}
+	}
+
+	if raw := strings.TrimSpace(c.Database.URL); raw != "" {
+		// Only the scheme is checked: a sqlite:// Windows path is not a valid URL.
+		scheme, _, _ := strings.Cut(raw, "://")
+		if !supportedDatabaseSchemes[strings.ToLower(scheme)] {
+			add("database.url: %q is not a sqlite://, mysql:// or postgres:// URL", raw)
+		}
+	}
+
+	if _, err := c.Daemon.Collect.Budget(); err != nil {
+		add("daemon.collect: %v", err)
+	}
+	if _, err := c.Daemon.Collect.HostDeadline(); err != nil {
+		add("daemon.collect: %v", err)
+	}
+	if c.Daemon.Collect.Workers < 0 {
+		add("daemon.collect: workers %d is negative", c.Daemon.Collect.Workers)
+	}
+
+	if p := c.Daemon.Exporter.Path; p != "" && !strings.HasPrefix(p, "/") {
+		add("daemon.exporter: path %q does not start with /", p)
+	}
+
+	if _, err := c.Daemon.Flow.AggregateInterval(); err != nil {
+		add("daemon.flow: %v", err)
+	}
+	if c.Daemon.Flow.TopN < 0 {
+		add("daemon.flow: top_n %d is negative", c.Daemon.Flow.TopN)
+	}
+	if _, _, _, err := c.Daemon.Flow.DNS.Durations(); err != nil {
+		add("daemon.flow.dns: %v", err)
+	}
+	if c.Daemon.Flow.DNS.CacheSize < 0 || c.Daemon.Flow.DNS.Budget < 0 {
+		add("daemon.flow.dns: cache_size and budget must not be negative")
+	}
+
+	if _, err := c.Database.WriteDeadline(); err != nil {
+		add("database: %v", err)
+	}
+	if _, _, err := c.Database.Recent.Durations(); err != nil {
+		add("database.recent: %v", err)
+	}
+	if c.Database.BatchSize < 0 {
+		add("database: batch_size %d is negative", c.Database.BatchSize)
+	}
+	for _, name := range sortedKeys(c.Database.Quotas) {
+		if q := c.Database.Quotas[name]; q.MaxPerBatch < 0 || q.MaxPerHour < 0 {
+			add("database.quotas.%s: max_per_batch and max_per_hour must not be negative", name)
+		}
+	}
+
+	if _, err := ParseSafety(c.Safety); err != nil {
+		add("safety: %v", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// snmpFormats are the value formats the snmp plugin knows; "" reads the
+// value as the device sends it.
+var snmpFormats = map[string]bool{
+	"": true, "string": true, "timeticks": true, "integer": true, "counter": true,
+	"gauge": true, "physaddr": true, "ifstatus": true,
+}
+
+// inlineOIDProblems checks the oids option of an snmp task: a list of OID
+// definitions, each with a numeric OID, a name and a known format.
+func inlineOIDProblems(raw interface{}) []string {
+	list, ok := raw.([]interface{})
+	if !ok {
+		return []string{": not a list"}
+	}
+	var problems []string
+	for i, item := range list {
+		def, ok := item.(map[string]interface{})
+		if !ok {
+			problems = append(problems, fmt.Sprintf("[%d]: not an object", i))
+			continue
+		}
+		oid, _ := def["oid"].(string)
+		if !numericOID(oid) {
+			problems = append(problems, fmt.Sprintf("[%d]: oid %q is not a numeric OID", i, oid))
+		}
+		if name, _ := def["name"].(string); strings.TrimSpace(name) == "" {
+			problems = append(problems, fmt.Sprintf("[%d]: name is empty", i))
+		}
+		if format, ok := def["format"]; ok {
+			if f, ok := format.(string); !ok || !snmpFormats[f] {
+				problems = append(problems, fmt.Sprintf("[%d]: unknown format %v", i, format))
+			}
+		}
+	}
+	return problems
+}
+
+// numericOID reports whether oid is dotted decimal, such as 1.3.6.1.2.1.1.5.0,
+// with an optional leading dot.
+func numericOID(oid string) bool {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return false
+	}
+	for _, p := range parts {
+		if p == "" || strings.Trim(p, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedKeys returns the keys of m in order, so problems are reported stably.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateDatabaseURL(t *testing.T) {
+	for url, ok := range map[string]bool{
+		"sqlite://data/nord.db":                true,
+		`sqlite://C:\ProgramData\nord\nord.db`: true,
+		"sqlite:///C:/nord/nord.db":            true,
+		"postgres://nord@db:5432/nord":         true,
+		"SQLITE://data/nord.db":                true,
+		"redis://cache:6379":                   false,
+		`C:\nord\nord.db`:                      false,
+	} {
+		cfg := &Config{Database: DatabaseConfig{URL: url}}
+		err := cfg.Validate()
+		if ok && err != nil {
+			t.Errorf("%s: %v", url, err)
+		}
+		if !ok && (err == nil || !strings.Contains(err.Error(), "database.url")) {
+			t.Errorf("%s: err = %v", url, err)
+		}
+	}
+}
+
+func TestValidateInlineOIDs(t *testing.T) {
+	for oids, want := range map[string][]string{
+		`[{"oid": ".1.3.6.1.2.1.1.5.0", "name": "System Name", "format": "string"},
+		  {"oid": "1.3.6.1.4.1.9.9.13.1.3.1.3.1006", "name": "Temperature"}]`: nil,
+		`{"oid": ".1.3.6.1.2.1.1.5.0"}`: {"hosts.edge.collect[0]: options.oids: not a list"},
+		`["sysName"]`:                   {"hosts.edge.collect[0]: options.oids[0]: not an object"},
+		`[{"oid": "sysName.0", "name": "System Name"},
+		  {"oid": "1.3..6", "name": " ", "format": "octets"},
+		  {"oid": "1", "name": "x", "format": 7}]`: {
+			`hosts.edge.collect[0]: options.oids[0]: oid "sysName.0" is not a numeric OID`,
+			`hosts.edge.collect[0]: options.oids[1]: oid "1.3..6" is not a numeric OID`,
+			"hosts.edge.collect[0]: options.oids[1]: name is empty",
+			"hosts.edge.collect[0]: options.oids[1]: unknown format octets",
+			`hosts.edge.collect[0]: options.oids[2]: oid "1" is not a numeric OID`,
+			"hosts.edge.collect[0]: options.oids[2]: unknown format 7",
+		},
+	} {
+		var raw interface{}
+		if err := json.Unmarshal([]byte(oids), &raw); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &Config{Hosts: map[string]Host{"edge": {Address: "192.0.2.1", Collect: []CollectTask{
+			{Metric: "snmp.generic", Options: map[string]interface{}{"oids": raw}},
+		}}}}
+		err := cfg.Validate()
+		if want == nil {
+			if err != nil {
+				t.Errorf("%s: %v", oids, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: accepted", oids)
+			continue
+		}
+		for _, line := range want {
+			if !strings.Contains(err.Error(), line) {
+				t.Errorf("%s: %v lacks %q", oids, err, line)
+			}
+		}
+	}
+}
+
+func TestValidateMaxSkipAge(t *testing.T) {
+	for age, ok := range map[string]bool{"": true, "0s": true, "90m": true, "soon": false, "-1h": false} {
+		cfg := &Config{Remote: RemoteConfig{Destinations: map[string]Destination{
+			"central": {Endpoint: "https://nord.example/api", Active: true, MaxSkipAge: age},
+		}}}
+		err := cfg.Validate()
+		if ok && err != nil {
+			t.Errorf("%q: %v", age, err)
+		}
+		if !ok && (err == nil || !strings.Contains(err.Error(), "remote.destinations.central: max_skip_age")) {
+			t.Errorf("%q: err = %v", age, err)
+		}
+	}
+	if d, err := (Destination{}).SkipAge(); d != DefaultMaxSkipAge || err != nil {
+		t.Errorf("default skip age %s, %v", d, err)
+	}
+}
@@ -0,0 +1,38 @@
+package plugin
+
+import "runtime"
+
+// Build metadata, set at link time:
+//
+//	go build -ldflags "-X observer/base.Version=1.2.0 -X observer/base.Commit=$(git rev-parse --short HEAD) -X observer/base.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// `make build` does this from git.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// GOOS is the operating system plugins gate their collectors on. It is
+// runtime.GOOS, but a variable so tests can exercise another OS's path.
+var GOOS = runtime.GOOS
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Build returns the build metadata of the running binary.
+func Build() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+	"observer/plugins/flow"
+)
+
+// Exit codes shared by every subcommand. Commands whose result is itself a
+// status (status, plugin run textui status) return a *plugin.ExitError instead.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 64 // EX_USAGE from sysexits.h; 2 is taken by status for "any down"
+	exitLocked  = 75 // EX_TEMPFAIL: another run holds the lock; cron can simply retry later
+)
+
+// usageError reports a malformed command line; it prints the command's usage.
+type usageError struct {
+	cmd string
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+// cliEnv is what a subcommand runs against.
+type cliEnv struct {
+	controller *plugin.Controller
+	output     string // -o format, for commands that print results
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+}
+
+// command is one `nord <name>` subcommand.
+type command struct {
+	name     string
+	synopsis string // arguments shown after the name in usage
+	summary  string
+	run      func(env *cliEnv, args []string) error
+	details  func(env *cliEnv) string // optional extra help, e.g. plugin actions
+}
+
+// commands lists the subcommands in the order shown by help. It is filled in
+// init because help refers back to the table.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "init", synopsis: "[--force] [--example] [--defaults] [--host name --address addr --cred snmp|ssh|none ...]", summary: "Create a starter config by answering a few questions", run: runInit},
+		{name: "config", synopsis: "upgrade [--write]", summary: "Upgrade an older config to the current config_version (--write saves it after a backup)", run: runConfig},
+		{name: "selftest", synopsis: "[-o json]", summary: "Check the config, store, hosts, tools and destinations without collecting (exit 1 on any failure)", run: runSelftest},
+		{name: "collect", synopsis: "[--wait duration]", summary: "Collect metrics from every configured host", run: exclusive(pluginCommand("collect", "collection", "collect", "Error during collection"))},
+		{name: "perceive", synopsis: "[--wait duration]", summary: "Discover hosts on the configured networks", run: exclusive(pluginCommand("perceive", "network", "perception", "Error during perception"))},
+		{name: "send", synopsis: "[--force] [--wait duration]", summary: "Send collected data to the remote server(s), skipping unchanged payloads unless --force", run: exclusive(runSend)},
+		{name: "run", synopsis: "[--skip-perception] [--skip-collect] [--skip-send] [--wait duration]", summary: "Perceive, collect and send in one process, then print a summary", run: exclusive(runAll)},
+		{name: "daemon", synopsis: "[--wait duration]", summary: "Run the scheduler, flow listeners and service plugins enabled under \"daemon\" in the config", run: exclusive(runDaemon)},
+		{name: "ui", summary: "Start the terminal user interface", run: pluginCommand("ui", "textui", "start", "Error starting TUI")},
+		{name: "flow", summary: "Start the IPFlow (NetFlow/sFlow/IPFIX) UDP collector", run: runFlow},
+		{name: "status", synopsis: "[-o table|json|csv]", summary: "Print device statuses once (exit 0 all up, 1 warnings, 2 any down)", run: runStatus},
+		{name: "store", synopsis: "<action> [key=value ...]", summary: "Run a store maintenance action", run: runStore},
+		{name: "plugin", synopsis: "run <name> <action> [key=value ...] [-o format]", summary: "Run any plugin action", run: runPlugin, details: pluginActionsHelp},
+		{name: "version", summary: "Print version, build information and compiled-in plugins", run: runVersion},
+		{name: "help", synopsis: "[command]", summary: "Show help for nord or one command", run: runHelp},
+	}
+}
+
+// findCommand returns the subcommand called name.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// globalOptions are the flags accepted before the subcommand.
+type globalOptions struct {
+	config   string
+	output   string
+	store    string
+	readOnly bool
+
+	// Deprecated flag interface, kept as aliases for one release.
+	version bool
+
+	pluginName string
+	action     string
+	collect    bool
+	perception bool
+	remote     bool
+	ui         bool
+	flow       bool
+	status     bool
+}
+
+// newGlobalFlags declares the global flags on a fresh FlagSet.
+func newGlobalFlags(opts *globalOptions, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("nord", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.config, "config", "", "Configuration file (default $NORD_CONFIG or "+plugin.DefaultConfigFile+")")
+	fs.StringVar(&opts.store, "store", "", "Database URL overriding the config file, e.g. sqlite:///tmp/nord.db")
+	fs.BoolVar(&opts.version, "version", false, "Print version information and exit")
+	fs.BoolVar(&opts.readOnly, "read-only", false, "Refuse actions that change anything (same as \"safety\": \"read\" in the config)")
+	fs.StringVar(&opts.output, "o", "table", "Output format: table, or json for a single JSON report on stdout (status also takes csv)")
+
+	fs.StringVar(&opts.pluginName, "p", "", "Deprecated: use `nord plugin run <name> <action>`")
+	fs.StringVar(&opts.action, "a", "", "Deprecated: use `nord plugin run <name> <action>`")
+	fs.BoolVar(&opts.collect, "collect", false, "Deprecated: use `nord collect`")
+	fs.BoolVar(&opts.perception, "perception", false, "Deprecated: use `nord perceive`")
+	fs.BoolVar(&opts.remote, "remote", false, "Deprecated: use `nord send`")
+	fs.BoolVar(&opts.ui, "ui", false, "Deprecated: use `nord ui`")
+	fs.BoolVar(&opts.flow, "flow", false, "Deprecated: use `nord flow`")
+	fs.BoolVar(&opts.status, "status", false, "Deprecated: use `nord status`")
+	fs.Usage = func() {}
+	return fs
+}
+
+// route maps the command line to a subcommand and its arguments. The deprecated
+// flags are translated to their subcommand and reported in deprecated.
+func route(opts globalOptions, rest []string) (name string, args []string, deprecated string) {
+	switch {
+	case opts.version:
+		return "version", rest, ""
+	case opts.status:
+		return "status", rest, "--status"
+	case opts.flow:
+		return "flow", rest, "--flow"
+	case opts.ui:
+		return "ui", rest, "--ui"
+	case opts.collect:
+		return "collect", rest, "--collect"
+	case opts.perception:
+		return "perceive", rest, "--perception"
+	case opts.remote:
+		return "send", rest, "--remote"
+	case opts.pluginName != "":
+		args = []string{"run", opts.pluginName}
+		if opts.action != "" {
+			args = append(args, opts.action)
+		}
+		return "plugin", append(args, rest...), "-p/-a"
+	}
+	if len(rest) == 0 {
+		return "help", nil, ""
+	}
+	return rest[0], rest[1:], ""
+}
+
+// configPath resolves the configuration file: --config, then NORD_CONFIG, then
+// config.json in NORD_DATA_DIR, then the default.
+func configPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if env := os.Getenv("NORD_CONFIG"); env != "" {
+		return env
+	}
+	if dir := os.Getenv(plugin.EnvDataDir); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	return plugin.DefaultConfigFile
+}
+
+// interspersed parses fs from args allowing flags after positional arguments,
+// and returns the positionals in order.
+func interspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// commandFlags returns a FlagSet for a subcommand that accepts -o, defaulting to the global value.
+func commandFlags(env *cliEnv, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	fs.StringVar(&env.output, "o", env.output, "Output format: table or json")
+	fs.Usage = func() {}
+	return fs
+}
+
+// exclusive wraps a command that writes collection output or the store so it
+// runs under the run lock. It consumes a --wait duration flag: how long to wait
+// for a run already in progress before giving up (default: fail at once).
+func exclusive(run func(*cliEnv, []string) error) func(*cliEnv, []string) error {
+	return func(env *cliEnv, args []string) error {
+		wait, rest, err := extractWait(args)
+		if err != nil {
+			return &usageError{msg: err.Error()}
+		}
+		lock, err := plugin.AcquireRunLock(wait)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+		return run(env, rest)
+	}
+}
+
+// extractWait removes a --wait flag from args and returns its duration.
+func extractWait(args []string) (time.Duration, []string, error) {
+	var wait time.Duration
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || name != "wait" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return 0, nil, fmt.Errorf("flag needs an argument: --wait")
+			}
+			i++
+			value = args[i]
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return 0, nil, fmt.Errorf("invalid --wait duration %q", value)
+		}
+		wait = d
+	}
+	return wait, rest, nil
+}
+
+// requireSafety refuses a command that does not go through a plugin action
+// (which the controller checks) when its level is not permitted.
+func requireSafety(env *cliEnv, level plugin.Safety, name string) error {
+	if env.controller.Permits(level) {
+		return nil
+	}
+	return fmt.Errorf("%w: nord %s is a %s command and safety is set to %s",
+		plugin.ErrNotPermitted, name, level, env.controller.Safety)
+}
+
+// pluginCommand returns a subcommand that runs a single plugin action.
+func pluginCommand(name, pluginName, action, failure string) func(*cliEnv, []string) error {
+	return func(env *cliEnv, args []string) error {
+		rest, err := interspersed(commandFlags(env, name), args)
+		if err != nil {
+			return &usageError{msg: err.Error()}
+		}
+		if len(rest) > 0 {
+			return &usageError{msg: fmt.Sprintf("unexpected argument %q", rest[0])}
+		}
+		return withReport(env, name, func(*commandReport) error {
+			if err := env.controller.OnCommand(pluginName, map[string]string{"action": action}); err != nil {
+				return fmt.Errorf("%s: %w", failure, err)
+			}
+			return nil
+		})
+	}
+}
+
+// runSend sends to the remote destinations; --force sends payloads they
+// already received.
+func runSend(env *cliEnv, args []string) error {
+	fs := commandFlags(env, "send")
+	force := fs.Bool("force", false, "Send even when a destination already has this payload")
+	rest, err := interspersed(fs, args)
+	if err != nil {
+		return &usageError{msg: err.Error()}
+	}
+	if len(rest) > 0 {
+		return &usageError{msg: fmt.Sprintf("unexpected argument %q", rest[0])}
+	}
+	cmdArgs := map[string]string{"action": "send"}
+	if *force {
+		cmdArgs["args"] = "force=true"
+	}
+	return withReport(env, "send", func(*commandReport) error {
+		if err := env.controller.OnCommand("api", cmdArgs); err != nil {
+			return fmt.Errorf("Error during remote send: %w", err)
+		}
+		return nil
+	})
+}
+
+func runFlow(env *cliEnv, args []string) error {
+	if len(args) > 0 {
+		return &usageError{msg: fmt.Sprintf("unexpected argument %q", args[0])}
+	}
+	if err := requireSafety(env, plugin.SafetyWrite, "flow"); err != nil {
+		return err
+	}
+	fmt.Fprintln(env.stdout, "Initializing IPFlow Collection Engine...")
+	collector := flow.NewCollector(env.controller.Store)
+	collector.Metrics = env.controller.Metrics
+	// Without a config file the listeners run with the default aggregation.
+	if cfg, err := loadDaemonConfig(); err == nil {
+		if err := collector.Configure(cfg.Flow); err != nil {
+			return err
+		}
+	}
+	collector.Start()
+	return nil
+}
+
+func runStatus(env *cliEnv, args []string) error {
+	rest, err := interspersed(commandFlags(env, "status"), args)
+	if err != nil {
+		return &usageError{msg: err.Error()}
+	}
+	if len(rest) > 0 {
+		return &usageError{msg: fmt.Sprintf("unexpected argument %q", rest[0])}
+	}
+	return env.controller.OnCommand("textui", map[string]string{"action": "status", "output": env.output})
+}
+
+func runStore(env *cliEnv, args []string) error {
+	if len(args) == 0 {
+		return &usageError{msg: "missing store action"}
+	}
+	return env.controller.OnCommand("store", map[string]string{"action": args[0], "args": strings.Join(args[1:], " ")})
+}
+
+func runPlugin(env *cliEnv, args []string) error {
+	rest, err := interspersed(commandFlags(env, "plugin"), args)
+	if err != nil {
+		return &usageError{msg: err.Error()}
+	}
+	if len(rest) == 0 || rest[0] != "run" {
+		return &usageError{msg: "expected `plugin run <name> <action>`"}
+	}
+	switch len(rest) {
+	case 1:
+		return &usageError{msg: "missing plugin name"}
+	case 2:
+		return &usageError{msg: "no action specified for the plugin"}
+	}
+	cmdArgs := map[string]string{
+		"action": rest[2],
+		"args":   strings.Join(rest[3:], " "),
+		"output": env.output,
+	}
+	if strings.EqualFold(rest[1], "textui") && rest[2] == "status" {
+		// Status writes its own table, JSON or CSV.
+		return env.controller.OnCommand(rest[1], cmdArgs)
+	}
+	return withReport(env, "plugin run "+rest[1]+" "+rest[2], func(*commandReport) error {
+		return env.controller.OnCommand(rest[1], cmdArgs)
+	})
+}
+
+func runHelp(env *cliEnv, args []string) error {
+	if len(args) == 0 {
+		printUsage(env.stdout)
+		return nil
+	}
+	c, ok := findCommand(args[0])
+	if !ok {
+		return &usageError{msg: fmt.Sprintf("unknown command %q", args[0])}
+	}
+	printCommandUsage(env, env.stdout, c)
+	return nil
+}
+
+func runVersion(env *cliEnv, args []string) error {
+	if len(args) > 0 {
+		return &usageError{msg: fmt.Sprintf("unexpected argument %q", args[0])}
+	}
+	names := make([]string, 0, len(plugins.All))
+	for _, p := range plugins.All {
+		names = append(names, strings.ToLower(p.Name()))
+	}
+	printVersion(env.stdout, plugin.Build(), names)
+	return nil
+}
+
+// printVersion writes the build information and the registered plugin names, sorted.
+func printVersion(w io.Writer, info plugin.BuildInfo, pluginNames []string) {
+	names := append([]string(nil), pluginNames...)
+	sort.Strings(names)
+	fmt.Fprintf(w, "nord %s\n", info.Version)
+	fmt.Fprintf(w, "  commit:  %s\n", info.Commit)
+	fmt.Fprintf(w, "  built:   %s\n", info.BuildDate)
+	fmt.Fprintf(w, "  go:      %s %s\n", info.GoVersion, info.Platform)
+	fmt.Fprintf(w, "  plugins: %s\n", strings.Join(names, ", "))
+}
+
+// printUsage writes the top-level help.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: nord [--config file] [--store url] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global flags:")
+	fmt.Fprintln(w, "  --config file   configuration file (default $NORD_CONFIG or "+plugin.DefaultConfigFile+")")
+	fmt.Fprintln(w, "  --store url     database URL overriding the config file's database.url")
+	fmt.Fprintln(w, "  --read-only     refuse actions that change anything (\"safety\": \"read\")")
+	fmt.Fprintln(w, "  --version       print version information and exit")
+	fmt.Fprintln(w, "  -o format       table, or json for one JSON report on stdout with logs on stderr;")
+	fmt.Fprintln(w, "                  status also takes csv")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run `nord help <command>` for details. The old -p/-a, --collect, --perception,")
+	fmt.Fprintln(w, "--remote, --ui, --flow and --status flags still work but are deprecated.")
+}
+
+// printCommandUsage writes one command's synopsis and any generated details.
+func printCommandUsage(env *cliEnv, w io.Writer, c command) {
+	fmt.Fprintf(w, "Usage: nord %s %s\n\n%s\n", c.name, c.synopsis, c.summary)
+	if c.details != nil {
+		fmt.Fprintln(w)
+		fmt.Fprint(w, c.details(env))
+	}
+}
+
+// pluginActionsHelp lists the actions each plugin advertises, with their parameters.
+func pluginActionsHelp(env *cliEnv) string {
+	names := make([]string, 0, len(env.controller.Plugins))
+	for name := range env.controller.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("Plugins and their advertised actions:\n")
+	for _, name := range names {
+		ap, ok := env.controller.Plugins[name].(plugin.ActionProvider)
+		if !ok {
+			fmt.Fprintf(&b, "  %s\n", name)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s\n", name)
+		for _, spec := range ap.Actions() {
+			var params []string
+			for _, p := range spec.Params {
+				params = append(params, p.Name+"=<"+strings.ToLower(p.Label)+">")
+			}
+			usage := strings.TrimSpace(spec.Action + " " + strings.Join(params, " "))
+			label := spec.Label
+			if level := env.controller.ActionSafety(name, spec.Action); level != plugin.SafetyRead {
+				label += " [" + level.String() + "]"
+			}
+			fmt.Fprintf(&b, "    %-28s %s\n", usage, label)
+		}
+	}
+	return b.String()
+}
+
+// exitCode maps a command error to the process exit code, printing it unless
+// the error is a *plugin.ExitError, whose output has already been written.
+func exitCode(env *cliEnv, err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var exitErr *plugin.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	var locked *plugin.LockedError
+	if errors.As(err, &locked) {
+		fmt.Fprintf(env.stderr, "Error: %v\n", err)
+		return exitLocked
+	}
+	var usage *usageError
+	if errors.As(err, &usage) {
+		fmt.Fprintf(env.stderr, "Error: %v\n", err)
+		if c, ok := findCommand(usage.cmd); ok {
+			printCommandUsage(env, env.stderr, c)
+		} else {
+			printUsage(env.stderr)
+		}
+		return exitUsage
+	}
+	fmt.Fprintf(env.stderr, "Error: %v\n", err)
+	return exitFailure
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+	"observer/plugins"
+)
+
+// fakePlugin records the commands it is sent and fails them with err.
+type fakePlugin struct {
+	plugin.BasePlugin
+	name  string
+	specs []plugin.ActionSpec
+	runs  []map[string]string
+	err   error
+}
+
+func (p *fakePlugin) Name() string                 { return p.name }
+func (p *fakePlugin) Actions() []plugin.ActionSpec { return p.specs }
+
+func (p *fakePlugin) OnCommand(args map[string]string) error {
+	p.runs = append(p.runs, args)
+	return p.err
+}
+
+// testEnv returns an environment whose controller holds only ps, with output captured.
+func testEnv(ps ...*fakePlugin) (*cliEnv, *bytes.Buffer, *bytes.Buffer) {
+	c := plugin.NewController()
+	for _, p := range ps {
+		c.AddPlugin(p)
+	}
+	var stdout, stderr bytes.Buffer
+	return &cliEnv{controller: c, output: "table", stdout: &stdout, stderr: &stderr}, &stdout, &stderr
+}
+
+func TestRoute(t *testing.T) {
+	for _, tc := range []struct {
+		argv       []string
+		name       string
+		args       []string
+		deprecated string
+	}{
+		{nil, "help", nil, ""},
+		{[]string{"collect", "--wait", "1m"}, "collect", []string{"--wait", "1m"}, ""},
+		{[]string{"--config", "/etc/nord.json", "status", "-o", "json"}, "status", []string{"-o", "json"}, ""},
+		{[]string{"--version"}, "version", []string{}, ""},
+		{[]string{"--collect"}, "collect", []string{}, "--collect"},
+		{[]string{"--perception"}, "perceive", []string{}, "--perception"},
+		{[]string{"--remote"}, "send", []string{}, "--remote"},
+		{[]string{"--ui"}, "ui", []string{}, "--ui"},
+		{[]string{"--flow"}, "flow", []string{}, "--flow"},
+		{[]string{"--status"}, "status", []string{}, "--status"},
+		{[]string{"-p", "local", "-a", "collect"}, "plugin", []string{"run", "local", "collect"}, "-p/-a"},
+		{[]string{"-p", "store", "-a", "prune", "days=7"}, "plugin", []string{"run", "store", "prune", "days=7"}, "-p/-a"},
+		{[]string{"-p", "local"}, "plugin", []string{"run", "local"}, "-p/-a"},
+	} {
+		var opts globalOptions
+		fs := newGlobalFlags(&opts, &bytes.Buffer{})
+		if err := fs.Parse(tc.argv); err != nil {
+			t.Fatalf("%q: %v", tc.argv, err)
+		}
+		name, args, deprecated := route(opts, fs.Args())
+		if name != tc.name || deprecated != tc.deprecated || len(args) != len(tc.args) || (len(args) > 0 && !reflect.DeepEqual(args, tc.args)) {
+			t.Errorf("%q: routed to %s %q (deprecated %q), want %s %q (%q)", tc.argv, name, args, deprecated, tc.name, tc.args, tc.deprecated)
+		}
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+	t.Setenv("NORD_CONFIG", "")
+	t.Setenv(plugin.EnvDataDir, "")
+	if got := configPath(""); got != plugin.DefaultConfigFile {
+		t.Errorf("default = %s", got)
+	}
+	t.Setenv(plugin.EnvDataDir, "/var/lib/nord")
+	if got := configPath(""); got != filepath.Join("/var/lib/nord", "config.json") {
+		t.Errorf("from data dir = %s", got)
+	}
+	t.Setenv("NORD_CONFIG", "/etc/nord/env.json")
+	if got := configPath(""); got != "/etc/nord/env.json" {
+		t.Errorf("from NORD_CONFIG = %s", got)
+	}
+	if got := configPath("/etc/nord/flag.json"); got != "/etc/nord/flag.json" {
+		t.Errorf("from --config = %s", got)
+	}
+}
+
+func TestExtractWait(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		wait time.Duration
+		rest []string
+		err  bool
+	}{
+		{[]string{"--force"}, 0, []string{"--force"}, false},
+		{[]string{"--wait", "30s", "--force"}, 30 * time.Second, []string{"--force"}, false},
+		{[]string{"-wait=2m"}, 2 * time.Minute, []string{}, false},
+		{[]string{"--wait"}, 0, nil, true},
+		{[]string{"--wait", "soon"}, 0, nil, true},
+		{[]string{"--wait=-1s"}, 0, nil, true},
+	} {
+		wait, rest, err := extractWait(tc.args)
+		if (err != nil) != tc.err || wait != tc.wait || (!tc.err && !reflect.DeepEqual(rest, tc.rest)) {
+			t.Errorf("extractWait(%q) = %v, %q, %v", tc.args, wait, rest, err)
+		}
+	}
+}
+
+func TestPluginRunDispatch(t *testing.T) {
+	local := &fakePlugin{name: "local"}
+	env, _, _ := testEnv(local)
+	cmd, _ := findCommand("plugin")
+
+	// Flags may follow the positional arguments.
+	if err := cmd.run(env, []string{"run", "Local", "disk", "path=/", "-o", "json", "warn=90"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"action": "disk", "args": "path=/ warn=90", "output": "json"}
+	if len(local.runs) != 1 || !reflect.DeepEqual(local.runs[0], want) {
+		t.Errorf("ran %v, want %v", local.runs, want)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		msg  string
+	}{
+		{nil, "expected `plugin run <name> <action>`"},
+		{[]string{"list"}, "expected `plugin run <name> <action>`"},
+		{[]string{"run"}, "missing plugin name"},
+		{[]string{"run", "local"}, "no action specified for the plugin"},
+	} {
+		var usage *usageError
+		if err := cmd.run(env, tc.args); !errors.As(err, &usage) || usage.msg != tc.msg {
+			t.Errorf("%q: err = %v, want usage error %q", tc.args, err, tc.msg)
+		}
+	}
+	if len(local.runs) != 1 {
+		t.Errorf("refused commands reached the plugin: %v", local.runs[1:])
+	}
+}
+
+func TestSubcommandDispatch(t *testing.T) {
+	api := &fakePlugin{name: "api"}
+	store := &fakePlugin{name: "store"}
+	env, _, _ := testEnv(api, store)
+
+	send, _ := findCommand("send")
+	if err := runSend(env, []string{"--force"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (map[string]string{"action": "send", "args": "force=true"}); !reflect.DeepEqual(api.runs[0], want) {
+		t.Errorf("send --force ran %v", api.runs[0])
+	}
+	var usage *usageError
+	if err := send.run(env, []string{"--wait", "later"}); !errors.As(err, &usage) {
+		t.Errorf("bad --wait: err = %v", err)
+	}
+
+	if err := runStore(env, []string{"prune", "days=30", "dry_run=true"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (map[string]string{"action": "prune", "args": "days=30 dry_run=true"}); !reflect.DeepEqual(store.runs[0], want) {
+		t.Errorf("store ran %v", store.runs[0])
+	}
+	if err := runStore(env, nil); !errors.As(err, &usage) {
+		t.Errorf("store without action: err = %v", err)
+	}
+
+	api.err = errors.New("no destinations")
+	if err := runSend(env, nil); err == nil || !strings.Contains(err.Error(), "Error during remote send: no destinations") {
+		t.Errorf("failed send: err = %v", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		code   int
+		stderr string
+		stdout string
+	}{
+		{nil, exitOK, "", ""},
+		{&plugin.ExitError{Code: 2}, 2, "", ""},
+		{errors.New("boom"), exitFailure, "Error: boom", ""},
+		{&usageError{cmd: "send", msg: "unexpected argument \"x\""}, exitUsage, "Usage: nord send [--force]", ""},
+		{&usageError{msg: "bad"}, exitUsage, "Commands:", ""},
+		{&plugin.LockedError{PID: 42, Started: time.Now()}, exitLocked, "42", ""},
+	} {
+		env, stdout, stderr := testEnv()
+		if code := exitCode(env, tc.err); code != tc.code {
+			t.Errorf("%v: exit %d, want %d", tc.err, code, tc.code)
+		}
+		if !strings.Contains(stderr.String(), tc.stderr) || !strings.Contains(stdout.String(), tc.stdout) {
+			t.Errorf("%v: stdout %q, stderr %q", tc.err, stdout, stderr)
+		}
+	}
+}
+
+func TestRunHelpAndUsage(t *testing.T) {
+	testenv.UseTempDirs(t)
+	for _, tc := range []struct {
+		argv   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{nil, exitOK, "Usage: nord [--config file]", ""},
+		{[]string{"-h"}, exitOK, "Commands:", ""},
+		{[]string{"help", "collect"}, exitOK, "Usage: nord collect [--wait duration]", ""},
+		{[]string{"help", "nope"}, exitUsage, "", `unknown command "nope"`},
+		{[]string{"frobnicate"}, exitUsage, "", `Error: unknown command "frobnicate"`},
+		{[]string{"--bogus"}, exitUsage, "", "flag provided but not defined: -bogus"},
+		{[]string{"version", "extra"}, exitUsage, "", "Usage: nord version"},
+		{[]string{"--remote", "extra"}, exitUsage, "", "Warning: --remote is deprecated; use `nord send` instead."},
+	} {
+		var stdout, stderr bytes.Buffer
+		code := run(tc.argv, strings.NewReader(""), &stdout, &stderr)
+		if code != tc.code || !strings.Contains(stdout.String(), tc.stdout) || !strings.Contains(stderr.String(), tc.stderr) {
+			t.Errorf("%q: exit %d, stdout %q, stderr %q", tc.argv, code, stdout.String(), stderr.String())
+		}
+	}
+}
+
+func TestRunUsesConfigFlag(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	path := filepath.Join(dir, "elsewhere.json")
+	if err := os.WriteFile(path, []byte(`{"config_version": 99}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A config from a newer nord is refused, which shows the flag was honoured.
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"--config", path, "status"}, strings.NewReader(""), &stdout, &stderr); code != exitFailure || !strings.Contains(stderr.String(), "config_version 99") {
+		t.Errorf("exit %d, stderr %q", code, stderr.String())
+	}
+	if plugin.ConfigFile != path {
+		t.Errorf("ConfigFile = %s", plugin.ConfigFile)
+	}
+
+	t.Setenv("NORD_CONFIG", path)
+	stderr.Reset()
+	if code := run([]string{"status"}, strings.NewReader(""), &stdout, &stderr); code != exitFailure || !strings.Contains(stderr.String(), "config_version 99") {
+		t.Errorf("NORD_CONFIG: exit %d, stderr %q", code, stderr.String())
+	}
+}
+
+func TestPluginActionsHelp(t *testing.T) {
+	svc := &fakePlugin{name: "svc", specs: []plugin.ActionSpec{
+		{Action: "restart", Label: "Restart service", Safety: plugin.SafetyDestructive},
+		{Action: "tail", Label: "Tail logs", Safety: plugin.SafetyRead, Params: []plugin.ActionParam{{Name: "lines", Label: "Line count"}}},
+		{Action: "reload", Label: "Reload config"}, // write unless declared otherwise
+	}}
+	env, _, _ := testEnv(svc)
+	help := pluginActionsHelp(env)
+	for _, want := range []string{"  svc\n", "restart", "Restart service [destructive]", "tail lines=<line count>", "Tail logs\n", "Reload config [write]"} {
+		if !strings.Contains(help, want) {
+			t.Errorf("help lacks %q:\n%s", want, help)
+		}
+	}
+}
+
+func TestConfigSafety(t *testing.T) {
+	testenv.UseTempDirs(t)
+	for _, tt := range []struct {
+		config   string
+		readOnly bool
+		want     plugin.Safety
+	}{
+		{`{"config_version": 1}`, false, plugin.SafetyDestructive},
+		{`{"config_version": 1, "safety": "write"}`, false, plugin.SafetyWrite},
+		{`{"config_version": 1, "safety": "write"}`, true, plugin.SafetyRead},
+		{`{"config_version": 1, "safety": "destructive"}`, true, plugin.SafetyRead},
+	} {
+		writeConfig(t, tt.config)
+		if got, err := configSafety(tt.readOnly); err != nil || got != tt.want {
+			t.Errorf("%s, read-only %v: %s, %v; want %s", tt.config, tt.readOnly, got, err, tt.want)
+		}
+	}
+	writeConfig(t, `{"config_version": 1, "safety": "admin"}`)
+	if _, err := configSafety(false); err == nil {
+		t.Error("invalid safety accepted")
+	}
+}
+
+func TestReadOnlyRefusesChanges(t *testing.T) {
+	testenv.UseTempDirs(t)
+	for _, tt := range []struct {
+		config string
+		argv   []string
+		msg    string
+	}{
+		{`{"config_version": 1}`, []string{"--read-only", "plugin", "run", "mail", "stop"},
+			"mail stop is a destructive action and safety is set to read"},
+		{`{"config_version": 1}`, []string{"--read-only", "plugin", "run", "alert", "evaluate"},
+			"alert evaluate is a write action and safety is set to read"},
+		{`{"config_version": 1}`, []string{"--read-only", "flow"},
+			"nord flow is a write command and safety is set to read"},
+		{`{"config_version": 1}`, []string{"--read-only", "daemon"},
+			"nord daemon is a write command and safety is set to read"},
+		{`{"config_version": 1, "safety": "write"}`, []string{"plugin", "run", "mail", "delete", "id=ABC123"},
+			"mail delete is a destructive action and safety is set to write"},
+		{`{"config_version": 1, "safety": "admin"}`, []string{"status"},
+			`invalid safety "admin"`},
+	} {
+		writeConfig(t, tt.config)
+		var stdout, stderr bytes.Buffer
+		code := run(tt.argv, strings.NewReader(""), &stdout, &stderr)
+		if code != exitFailure || !strings.Contains(stdout.String()+stderr.String(), tt.msg) {
+			t.Errorf("%q: exit %d, stdout %q, stderr %q", tt.argv, code, stdout.String(), stderr.String())
+		}
+	}
+
+	// Read actions still run.
+	svc := &fakePlugin{name: "svc", specs: []plugin.ActionSpec{{Action: "tail", Label: "Tail logs", Safety: plugin.SafetyRead}}}
+	env, _, _ := testEnv(svc)
+	env.controller.Safety = plugin.SafetyRead
+	cmd, _ := findCommand("plugin")
+	if err := cmd.run(env, []string{"run", "svc", "tail"}); err != nil || len(svc.runs) != 1 {
+		t.Errorf("read action: %v, ran %v", err, svc.runs)
+	}
+	if err := cmd.run(env, []string{"run", "svc", "restart"}); !errors.Is(err, plugin.ErrNotPermitted) || len(svc.runs) != 1 {
+		t.Errorf("untagged action: %v, ran %v", err, svc.runs)
+	}
+}
+
+// useBuild sets the link-time build variables for the duration of the test.
+func useBuild(t *testing.T, version, commit, date string) {
+	t.Helper()
+	oldVersion, oldCommit, oldDate := plugin.Version, plugin.Commit, plugin.BuildDate
+	plugin.Version, plugin.Commit, plugin.BuildDate = version, commit, date
+	t.Cleanup(func() { plugin.Version, plugin.Commit, plugin.BuildDate = oldVersion, oldCommit, oldDate })
+}
+
+func TestPrintVersion(t *testing.T) {
+	info := plugin.BuildInfo{
+		Version:   "1.2.0",
+		Commit:    "abc1234",
+		BuildDate: "2024-05-01T12:00:00Z",
+		GoVersion: "go1.22.3",
+		Platform:  "linux/arm64",
+	}
+	names := []string{"network", "api", "local"}
+	var out bytes.Buffer
+	printVersion(&out, info, names)
+	want := "nord 1.2.0\n" +
+		"  commit:  abc1234\n" +
+		"  built:   2024-05-01T12:00:00Z\n" +
+		"  go:      go1.22.3 linux/arm64\n" +
+		"  plugins: api, local, network\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if names[0] != "network" {
+		t.Errorf("printVersion sorted the caller's slice: %q", names)
+	}
+}
+
+func TestVersionCommand(t *testing.T) {
+	testenv.UseTempDirs(t)
+	useBuild(t, "1.2.0", "abc1234", "2024-05-01T12:00:00Z")
+	for _, argv := range [][]string{{"version"}, {"--version"}} {
+		var stdout, stderr bytes.Buffer
+		if code := run(argv, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+			t.Fatalf("%q: exit %d, stderr %q", argv, code, stderr.String())
+		}
+		out := stdout.String()
+		for _, want := range []string{"nord 1.2.0\n", "commit:  abc1234\n", "built:   2024-05-01T12:00:00Z\n", "go:      " + runtime.Version(), "plugins: "} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: output lacks %q:\n%s", argv, want, out)
+			}
+		}
+		for _, p := range plugins.All {
+			if !strings.Contains(out, strings.ToLower(p.Name())) {
+				t.Errorf("%q: plugin %s not listed:\n%s", argv, p.Name(), out)
+			}
+		}
+	}
+}
+
+func TestExclusiveCommandsShareTheLock(t *testing.T) {
+	testenv.UseTempDirs(t)
+	writeConfig(t, `{"config_version": 1}`)
+	lock, err := plugin.AcquireRunLock(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+
+	for _, argv := range [][]string{{"collect"}, {"perceive"}, {"send"}, {"daemon"}} {
+		var stdout, stderr bytes.Buffer
+		code := run(argv, strings.NewReader(""), &stdout, &stderr)
+		if code != exitLocked || !strings.Contains(stderr.String()+stdout.String(), fmt.Sprintf("another run in progress (pid %d", os.Getpid())) {
+			t.Errorf("%q: exit %d, stdout %q, stderr %q", argv, code, stdout.String(), stderr.String())
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"collect", "--wait", "100ms"}, strings.NewReader(""), &stdout, &stderr); code != exitLocked {
+		t.Errorf("--wait past the holder: exit %d", code)
+	}
+}
@@ -13,19 +13,33 @@ import (
 	plugin "observer/base"
 	"observer/plugins"
 	// Import all plugins - they self-register via init()
+	_ "observer/plugins/alert"
 	_ "observer/plugins/api"
+	_ "observer/plugins/backupcheck"
+	_ "observer/plugins/certwatch"
 	_ "observer/plugins/collection"
+	_ "observer/plugins/dbcheck"
 	_ "observer/plugins/device"
+	_ "observer/plugins/dns"
+	_ "observer/plugins/execcheck"
 	_ "observer/plugins/flow"
+	_ "observer/plugins/httpcheck"
+	_ "observer/plugins/kubernetes"
 	_ "observer/plugins/local"
 	_ "observer/plugins/mail"
+	_ "observer/plugins/mqtt"
+	_ "observer/plugins/netconf"
 	_ "observer/plugins/network"
 	_ "observer/plugins/periscope"
+	_ "observer/plugins/redfish"
+	_ "observer/plugins/report"
 	_ "observer/plugins/snmp"
 	_ "observer/plugins/sshcollect"
+	_ "observer/plugins/storeadmin"
+	_ "observer/plugins/syslog"
 	_ "observer/plugins/textui"
 	_ "observer/plugins/wasm"
-	"observer/store"
+	_ "observer/plugins/winrm"
 )
 
 type Server struct {
@@ -37,16 +51,19 @@ func main() {
 	port := flag.String("port", "8080", "HTTP server port")
 	flag.Parse()
 
+	plugin.LoadPaths()
+
 	// Create controller
 	controller := plugin.NewController()
 
-	// Open database store if configured
-	if cfgData, err := os.ReadFile("data/config.json"); err == nil {
+	// Open database store if configured, with the same batch size and
+	// quotas as the nord command.
+	if cfgData, err := plugin.ReadConfigFile(); err == nil {
 		var dbCfg struct {
 			Database plugin.DatabaseConfig `json:"database"`
 		}
 		if json.Unmarshal(cfgData, &dbCfg) == nil && dbCfg.Database.URL != "" {
-			st, err := store.Open(dbCfg.Database.URL)
+			st, err := dbCfg.Database.OpenStore("")
 			if err != nil {
 				log.Printf("Warning: could not open database: %v", err)
 			} else if st != nil {
@@ -65,10 +82,19 @@ func main() {
 
 	// Load config as raw JSON
 	var config map[string]interface{}
-	if cfgData, err := os.ReadFile("data/config.json"); err == nil {
+	if cfgData, err := plugin.ReadConfigFile(); err == nil {
 		json.Unmarshal(cfgData, &config)
 	}
 
+	// The same safety level as the CLI: with "safety": "read" the receiver
+	// refuses to store remote data.
+	safety, _ := config["safety"].(string)
+	level, err := plugin.ParseSafety(safety)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	controller.Safety = level
+
 	server := &Server{
 		controller: controller,
 		config:     config,
@@ -315,6 +341,15 @@ func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleAPIServer(w http.ResponseWriter, r *http.Request, action string) {
 	// Handle POST requests (receiving data from remote nodes)
 	if r.Method == "POST" {
+		if !s.controller.Permits(plugin.SafetyWrite) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":  403,
+				"error": "Forbidden: receiving data is a write and safety is set to " + s.controller.Safety.String(),
+			})
+			return
+		}
+
 		// Check authorization header
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -372,9 +407,8 @@ func (s *Server) handleAPIServer(w http.ResponseWriter, r *http.Request, action
 		}
 
 		// Store to file
-		filename := fmt.Sprintf("data/remote_%s.json", authToken)
 		jsonData, _ := json.MarshalIndent(data, "", "  ")
-		if err := os.WriteFile(filename, jsonData, 0644); err != nil {
+		if err := plugin.WriteDataFile(fmt.Sprintf("remote_%s.json", authToken), jsonData, 0644); err != nil {
 			log.Printf("Error writing remote data: %v", err)
 		}
 
@@ -410,7 +444,7 @@ func (s *Server) authenticate(token string) bool {
 func (s *Server) handleDataFiles(w http.ResponseWriter, r *http.Request) {
 	// Map /backend/data/file.json to data/file.json
 	filename := filepath.Base(r.URL.Path)
-	dataPath := filepath.Join("data", filename)
+	dataPath := plugin.DataFile(filename)
 
 	// Security check - only allow .json files
 	if !strings.HasSuffix(filename, ".json") {
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	plugin "observer/base"
+)
+
+// runConfig handles `nord config upgrade [--write]`: it reports the upgrades
+// an older config gets when read and, with --write, saves the upgraded file
+// after copying the old one next to it.
+func runConfig(env *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	fs.Usage = func() {}
+	write := fs.Bool("write", false, "Save the upgraded config, keeping a copy of the old one")
+	rest, err := interspersed(fs, args)
+	if err != nil {
+		return &usageError{msg: err.Error()}
+	}
+	if len(rest) != 1 || rest[0] != "upgrade" {
+		return &usageError{msg: "expected `config upgrade [--write]`"}
+	}
+
+	data, err := os.ReadFile(plugin.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	upgraded, applied, err := plugin.UpgradeConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", plugin.ConfigFile, err)
+	}
+	if len(applied) == 0 {
+		fmt.Fprintf(env.stdout, "%s is at config_version %d already\n", plugin.ConfigFile, plugin.ConfigVersion)
+		return nil
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(upgraded, &cfg); err != nil {
+		return fmt.Errorf("%s does not parse once upgraded, fix it by hand: %w", plugin.ConfigFile, err)
+	}
+
+	fmt.Fprintf(env.stdout, "--- Upgrading %s to config_version %d ---\n", plugin.ConfigFile, plugin.ConfigVersion)
+	for _, line := range applied {
+		fmt.Fprintf(env.stdout, "  |_ %s\n", line)
+	}
+	if !*write {
+		fmt.Fprintln(env.stdout, "Nothing written; run `nord config upgrade --write` to save the upgraded config.")
+		return nil
+	}
+	if err := requireSafety(env, plugin.SafetyWrite, "config upgrade --write"); err != nil {
+		return err
+	}
+	backup := plugin.ConfigFile + "." + time.Now().Format("20060102-150405") + ".bak"
+	if err := os.WriteFile(backup, data, 0600); err != nil { // credentials live in the config
+		return fmt.Errorf("could not back up the config: %w", err)
+	}
+	if err := plugin.WriteConfigFile(upgraded); err != nil {
+		return fmt.Errorf("could not write the upgraded config: %w", err)
+	}
+	fmt.Fprintf(env.stdout, "  |_ wrote %s; the old file is %s\n", plugin.ConfigFile, backup)
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+// oldConfig uses the shorthands of configs written before config_version.
+const oldConfig = `{
+  "database": "sqlite://nord.db",
+  "hosts": {
+    "core": {"address": "192.0.2.1", "collect": "network.ping, snmp.system", "credentials": "snmp-ro"}
+  }
+}
+`
+
+// runConfigUpgrade runs `nord config upgrade args...`.
+func runConfigUpgrade(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"config", "upgrade"}, args...), strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestConfigUpgrade(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	writeConfig(t, oldConfig)
+
+	// Without --write the upgrades are listed and nothing is written.
+	code, stdout, stderr := runConfigUpgrade(t)
+	if code != exitOK || !strings.Contains(stdout, "|_ v0 to v1: lists where single values were accepted") ||
+		!strings.Contains(stdout, "(database, hosts.core.collect, hosts.core.credentials)") || !strings.Contains(stdout, "Nothing written") {
+		t.Fatalf("exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if data, _ := os.ReadFile(plugin.ConfigFile); string(data) != oldConfig {
+		t.Errorf("dry run wrote:\n%s", data)
+	}
+	if files := listFiles(t, dir); len(files) != 1 {
+		t.Errorf("dry run left %q", files)
+	}
+
+	code, stdout, stderr = runConfigUpgrade(t, "--write")
+	if code != exitOK {
+		t.Fatalf("--write: exit %d, stderr %q", code, stderr)
+	}
+	backups, _ := filepath.Glob(plugin.ConfigFile + ".*.bak")
+	if len(backups) != 1 || !strings.Contains(stdout, "the old file is "+backups[0]) {
+		t.Fatalf("backups %q, stdout %q", backups, stdout)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != oldConfig {
+		t.Errorf("backup:\n%s", data)
+	}
+	if info, err := os.Stat(backups[0]); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("backup mode: %v, %v", info.Mode(), err)
+	}
+
+	want := `{
+  "config_version": 1,
+  "database": {
+    "url": "sqlite://nord.db"
+  },
+  "hosts": {
+    "core": {
+      "address": "192.0.2.1",
+      "collect": [
+        {
+          "metric": "network.ping"
+        },
+        {
+          "metric": "snmp.system"
+        }
+      ],
+      "credentials": [
+        "snmp-ro"
+      ]
+    }
+  }
+}
+`
+	data, err := os.ReadFile(plugin.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("written:\n%s\nwant:\n%s", data, want)
+	}
+	if info, err := os.Stat(plugin.ConfigFile); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("config mode: %v, %v", info.Mode(), err)
+	}
+	// The written file is read as it is.
+	read, err := plugin.ReadConfigFile()
+	if err != nil || string(read) != want {
+		t.Errorf("read back: %v\n%s", err, read)
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(read, &cfg); err != nil || cfg.Hosts["core"].Collect[1].Metric != "snmp.system" {
+		t.Errorf("parsed: %+v, %v", cfg.Hosts, err)
+	}
+
+	code, stdout, _ = runConfigUpgrade(t, "--write")
+	if code != exitOK || !strings.Contains(stdout, "is at config_version 1 already") {
+		t.Errorf("current config: exit %d, stdout %q", code, stdout)
+	}
+	if again, _ := filepath.Glob(plugin.ConfigFile + ".*.bak"); len(again) != 1 {
+		t.Errorf("backups after a no-op: %q", again)
+	}
+}
+
+func TestConfigUpgradeRefuses(t *testing.T) {
+	testenv.UseTempDirs(t)
+	write := []string{"config", "upgrade", "--write"}
+	for _, tt := range []struct {
+		config string
+		argv   []string
+		code   int
+		output string
+	}{
+		{`{"config_version": 7}`, write, exitFailure, "config_version 7 is newer than this nord understands (1)"},
+		{`{"hosts": {"core": {"collect": 5}}}`, write, exitFailure, "does not parse once upgraded, fix it by hand"},
+		{oldConfig, append([]string{"--read-only"}, write...), exitFailure, "config upgrade --write is a write command"},
+		{oldConfig, append(write, "--bogus"), exitUsage, "flag provided but not defined"},
+	} {
+		writeConfig(t, tt.config)
+		var stdout, stderr bytes.Buffer
+		code := run(tt.argv, strings.NewReader(""), &stdout, &stderr)
+		// Failures are reported on stdout, usage errors on stderr.
+		if output := stdout.String() + stderr.String(); code != tt.code || !strings.Contains(output, tt.output) {
+			t.Errorf("%s %q: exit %d, output %q", tt.config, tt.argv, code, output)
+		}
+		if data, _ := os.ReadFile(plugin.ConfigFile); string(data) != tt.config {
+			t.Errorf("%q rewrote the config:\n%s", tt.argv, data)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"config", "downgrade"}, strings.NewReader(""), &stdout, &stderr); code != exitUsage ||
+		!strings.Contains(stderr.String(), "expected `config upgrade [--write]`") {
+		t.Errorf("config downgrade: exit %d, stderr %q", code, stderr.String())
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins/flow"
+	"observer/store"
+)
+
+// Daemon defaults used when the config leaves them unset.
+const (
+	defaultCollectInterval    = 5 * time.Minute
+	defaultPerceptionInterval = time.Hour
+	defaultMaxRestarts        = 5
+)
+
+// loadDaemonConfig reads the "daemon" section of the config file.
+func loadDaemonConfig() (plugin.DaemonConfig, error) {
+	data, err := plugin.ReadConfigFile()
+	if err != nil {
+		return plugin.DaemonConfig{}, fmt.Errorf("could not read config file: %w", err)
+	}
+	var cfg struct {
+		Daemon plugin.DaemonConfig `json:"daemon"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return plugin.DaemonConfig{}, fmt.Errorf("could not parse config file: %w", err)
+	}
+	if cfg.Daemon.MaxRestarts <= 0 {
+		cfg.Daemon.MaxRestarts = defaultMaxRestarts
+	}
+	return cfg.Daemon, nil
+}
+
+// daemonComponents builds the components enabled in cfg.
+func daemonComponents(env *cliEnv, cfg plugin.DaemonConfig) ([]component, error) {
+	var components []component
+
+	if cfg.Collect.Enabled {
+		interval := defaultCollectInterval
+		if cfg.Collect.Interval != "" {
+			d, err := time.ParseDuration(cfg.Collect.Interval)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("daemon.collect.interval %q is not a positive duration", cfg.Collect.Interval)
+			}
+			interval = d
+		}
+		skip := map[string]bool{"perception": !cfg.Collect.Perception, "send": !cfg.Collect.Send}
+		stages := append([]stage(nil), pipelineStages...)
+		for _, name := range cfg.Collect.Publish {
+			key := strings.ToLower(name)
+			if _, ok := env.controller.Plugins[key]; !ok {
+				return nil, fmt.Errorf("daemon.collect.publish plugin '%s' not found", name)
+			}
+			stages = append(stages, stage{name: key, plugin: key, action: "send"})
+		}
+		components = append(components, schedulerComponent(newPipeline(env.controller), stages, skip, interval, env.stdout))
+	}
+
+	if cfg.Perception.Enabled {
+		interval := defaultPerceptionInterval
+		if cfg.Perception.Interval != "" {
+			d, err := time.ParseDuration(cfg.Perception.Interval)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("daemon.perception.interval %q is not a positive duration", cfg.Perception.Interval)
+			}
+			interval = d
+		}
+		components = append(components, perceptionComponent(env.controller, interval, env.stdout))
+	}
+
+	recent, err := loadRecentConfig()
+	if err != nil {
+		return nil, err
+	}
+	if recent.Enabled {
+		retention, interval, err := recent.Durations()
+		if err != nil {
+			return nil, fmt.Errorf("database.recent: %w", err)
+		}
+		if env.controller.Store == nil {
+			return nil, errors.New("database.recent.enabled needs a database; set database.url")
+		}
+		components = append(components, recentPruneComponent(env.controller.Store, retention, interval, env.stdout))
+	}
+
+	if cfg.Flow.Enabled {
+		collector := flow.NewCollector(env.controller.Store)
+		collector.Metrics = env.controller.Metrics
+		if err := collector.Configure(cfg.Flow); err != nil {
+			return nil, err
+		}
+		components = append(components, component{name: "flow", run: collector.Serve})
+	}
+
+	if cfg.Exporter.Enabled {
+		components = append(components, exporterComponent(cfg.Exporter, env.controller.Metrics, env.stdout))
+	}
+
+	for _, name := range cfg.Services {
+		p, ok := env.controller.Plugins[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("daemon service plugin '%s' not found", name)
+		}
+		svc, ok := p.(plugin.Service)
+		if !ok {
+			return nil, fmt.Errorf("plugin '%s' does not provide a daemon service", name)
+		}
+		components = append(components, component{name: strings.ToLower(name), run: svc.Serve})
+	}
+	return components, nil
+}
+
+// schedulerComponent runs the collection pipeline every interval, starting at once.
+// A failed cycle is reported in its summary and does not stop the scheduler.
+func schedulerComponent(pl *pipeline, stages []stage, skip map[string]bool, interval time.Duration, out io.Writer) component {
+	return component{name: "collect", run: func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			printRunSummary(out, pl.run(stages, skip), pl.controller.Metrics)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}}
+}
+
+// perceptionComponent runs network perception every interval, starting at once,
+// so hosts appearing or disappearing are reported between collections. A
+// failed run is logged and does not stop the component.
+func perceptionComponent(c *plugin.Controller, interval time.Duration, out io.Writer) component {
+	return component{name: "perception", run: func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := c.OnCommand("network", map[string]string{"action": "perception"}); err != nil {
+				fmt.Fprintf(out, "  !_ perception: %v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}}
+}
+
+// loadRecentConfig reads the "database.recent" section of the config file.
+func loadRecentConfig() (plugin.DatabaseRecentConfig, error) {
+	data, err := plugin.ReadConfigFile()
+	if err != nil {
+		return plugin.DatabaseRecentConfig{}, fmt.Errorf("could not read config file: %w", err)
+	}
+	var cfg struct {
+		Database plugin.DatabaseConfig `json:"database"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return plugin.DatabaseRecentConfig{}, fmt.Errorf("could not parse config file: %w", err)
+	}
+	return cfg.Database.Recent, nil
+}
+
+// recentPruneComponent prunes the metrics_recent ring buffer every interval,
+// starting at once, keeping retention of samples at full resolution. A failed
+// run is logged and does not stop the component.
+func recentPruneComponent(st store.Store, retention, interval time.Duration, out io.Writer) component {
+	return component{name: "recent", run: func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			rolled, pruned, err := st.PruneRecent(ctx, time.Now().Add(-retention))
+			if err != nil {
+				fmt.Fprintf(out, "  !_ %v\n", err)
+			} else if pruned > 0 {
+				fmt.Fprintf(out, "  |_ store: rolled %d recent samples up into %d per-minute samples\n", pruned, rolled)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}}
+}
+
+// runDaemon implements `nord daemon`: it supervises the configured components
+// until SIGINT or SIGTERM, and reloads reloadable plugins on SIGHUP.
+func runDaemon(env *cliEnv, args []string) error {
+	if len(args) > 0 {
+		return &usageError{msg: fmt.Sprintf("unexpected argument %q", args[0])}
+	}
+	if err := requireSafety(env, plugin.SafetyWrite, "daemon"); err != nil {
+		return err
+	}
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		return err
+	}
+	components, err := daemonComponents(env, cfg)
+	if err != nil {
+		return err
+	}
+	if len(components) == 0 {
+		return errors.New("no daemon components enabled; set daemon.collect.enabled, daemon.perception.enabled, daemon.flow.enabled, daemon.exporter.enabled, database.recent.enabled or daemon.services in the config")
+	}
+
+	if cfg.PIDFile != "" {
+		if err := os.WriteFile(cfg.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			return fmt.Errorf("could not write pidfile: %w", err)
+		}
+		defer os.Remove(cfg.PIDFile)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				notify(cfg, "RELOADING=1")
+				reloadPlugins(env)
+				notify(cfg, "READY=1")
+				continue
+			}
+			fmt.Fprintf(env.stdout, "--- Received %v, shutting down ---\n", sig)
+			notify(cfg, "STOPPING=1")
+			cancel()
+			return
+		}
+	}()
+
+	sup := newSupervisor(cfg.MaxRestarts)
+	sup.onChange = func(h componentHealth) { reportHealth(env, h) }
+
+	fmt.Fprintf(env.stdout, "--- Starting daemon with %d components ---\n", len(components))
+	notify(cfg, "READY=1")
+	sup.run(ctx, components)
+
+	health := sup.snapshot()
+	printDaemonSummary(env.stdout, health)
+	if ctx.Err() == nil {
+		return errors.New("all daemon components failed")
+	}
+	return nil
+}
+
+// reloadPlugins calls Reload on every plugin that supports it.
+func reloadPlugins(env *cliEnv) {
+	fmt.Fprintln(env.stdout, "--- Reloading plugins ---")
+	for name, p := range env.controller.Plugins {
+		if r, ok := p.(plugin.Reloader); ok {
+			if err := r.Reload(); err != nil {
+				fmt.Fprintf(env.stdout, "  !_ %s: reload failed: %v\n", name, err)
+			} else {
+				fmt.Fprintf(env.stdout, "  |_ %s: reloaded\n", name)
+			}
+		}
+	}
+}
+
+// componentStatus maps a component state to the status metric value.
+var componentStatus = map[string]string{
+	componentRunning:    "up",
+	componentRestarting: "warning",
+	componentFailed:     "down",
+}
+
+// reportHealth logs a component state change and records it under the agent's
+// host, so it shows up wherever metric statuses are displayed.
+func reportHealth(env *cliEnv, h componentHealth) {
+	switch h.Status {
+	case componentRestarting:
+		fmt.Fprintf(env.stdout, "  !_ daemon: %s crashed (%s), restart %d\n", h.Name, h.LastError, h.Restarts)
+	case componentFailed:
+		fmt.Fprintf(env.stdout, "  !_ daemon: %s failed after %d restarts: %s\n", h.Name, h.Restarts, h.LastError)
+	default:
+		fmt.Fprintf(env.stdout, "  |_ daemon: %s %s\n", h.Name, h.Status)
+	}
+
+	status, ok := componentStatus[h.Status]
+	if !ok || env.controller.Store == nil {
+		return
+	}
+	rec := store.MetricRecord{
+		HostKey:     plugin.AgentHostKey,
+		HostName:    plugin.AgentHostName(),
+		HostAddress: plugin.AgentHostAddress,
+		Plugin:      "daemon",
+		Name:        "component_status",
+		Category:    "daemon",
+		MetricType:  "status",
+		Value:       status,
+		ValueNum:    store.ParseValueNum(status),
+		Instance:    h.Name,
+		Extra:       map[string]interface{}{"state": h.Status, "restarts": h.Restarts},
+		CollectedAt: h.Since,
+	}
+	if h.LastError != "" {
+		rec.Extra["error"] = h.LastError
+	}
+	if err := env.controller.Store.WriteBatch(context.Background(), []store.MetricRecord{rec}); err != nil {
+		fmt.Fprintf(env.stdout, "  !_ store: daemon health WriteBatch error: %v\n", err)
+	}
+}
+
+// printDaemonSummary writes the final state of every component.
+func printDaemonSummary(w io.Writer, health []componentHealth) {
+	fmt.Fprintln(w, "--- Daemon Summary ---")
+	for _, h := range health {
+		marker := "|_"
+		if h.Status == componentFailed {
+			marker = "!_"
+		}
+		line := fmt.Sprintf("  %s %s: %s, %d restarts", marker, h.Name, h.Status, h.Restarts)
+		if h.LastError != "" {
+			line += ", last error: " + h.LastError
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// notify sends a state to systemd when daemon.notify is set and nord runs
+// as a Type=notify service. Failures are ignored, as with sd_notify(3).
+func notify(cfg plugin.DaemonConfig, state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if !cfg.Notify || addr == "" {
+		return
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // abstract socket namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+// Exporter defaults used when daemon.exporter leaves them unset.
+const (
+	defaultExporterListen = ":9464"
+	defaultExporterPath   = "/metrics"
+)
+
+// exporterShutdownTimeout bounds how long a scrape in progress may hold up
+// the daemon's shutdown.
+const exporterShutdownTimeout = 5 * time.Second
+
+// exporterComponent serves the last collection and the self-metrics in m in
+// the Prometheus text format until ctx is done.
+func exporterComponent(cfg plugin.DaemonExporterConfig, m *plugin.Metrics, out io.Writer) component {
+	listen, path := cfg.Listen, cfg.Path
+	if listen == "" {
+		listen = defaultExporterListen
+	}
+	if path == "" {
+		path = defaultExporterPath
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, exporterHandler(m))
+
+	return component{name: "exporter", run: func(ctx context.Context) error {
+		ln, err := net.Listen("tcp", listen)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		fmt.Fprintf(out, "  |_ exporter: serving %s on %s\n", path, ln.Addr())
+
+		served := make(chan error, 1)
+		go func() { served <- srv.Serve(ln) }()
+		select {
+		case err := <-served:
+			return err
+		case <-ctx.Done():
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), exporterShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}}
+}
+
+// exporterHandler answers a scrape with nord's own metrics from m and the
+// collection last saved to results.json, read afresh each time, so it follows
+// the scheduler or a cron-driven `nord collect` alike.
+func exporterHandler(m *plugin.Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results, err := plugin.ReadResults()
+		if err != nil {
+			results = nil // nothing collected yet: only nord_results_ok
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeSelfExposition(w, m.Snapshot())
+		writeExposition(w, results, err == nil)
+	})
+}
+
+// writeExposition renders results in the Prometheus text format: every metric
+// with a numeric value as a sample of the nord_metric gauge, labelled with its
+// host, plugin, name and, when set, instance, category and unit. Status values
+// count as 1 (up), 0.5 (warning) and 0 (down); text and multi-value metrics
+// are left out. Series are sorted, so scrapes of the same results are equal.
+func writeExposition(w io.Writer, results plugin.Results, ok bool) {
+	fmt.Fprintln(w, "# HELP nord_results_ok Whether the last collection could be read (1) or not (0).")
+	fmt.Fprintln(w, "# TYPE nord_results_ok gauge")
+	if ok {
+		fmt.Fprintln(w, "nord_results_ok 1")
+	} else {
+		fmt.Fprintln(w, "nord_results_ok 0")
+	}
+
+	hosts := make([]string, 0, len(results))
+	for key := range results {
+		hosts = append(hosts, key)
+	}
+	sort.Strings(hosts)
+
+	var lines []string
+	seen := make(map[string]bool)
+	for _, key := range hosts {
+		h := results[key]
+		if h == nil {
+			continue
+		}
+		for _, m := range h.Metrics {
+			v, ok := exportValue(m)
+			if !ok {
+				continue
+			}
+			name := m.Name
+			if name == "" {
+				name = m.Label
+			}
+			labels := promLabels(
+				"host", key,
+				"plugin", m.Plugin,
+				"name", name,
+				"instance", m.Instance,
+				"category", m.Category,
+				"unit", m.Unit,
+			)
+			if seen[labels] {
+				continue // a second label for the same series
+			}
+			seen[labels] = true
+			lines = append(lines, "nord_metric"+labels+" "+strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+	sort.Strings(lines)
+
+	fmt.Fprintln(w, "# HELP nord_metric Numeric value of a metric in the last collection.")
+	fmt.Fprintln(w, "# TYPE nord_metric gauge")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// writeSelfExposition renders nord's own metrics, the registry shared with
+// the run summary and the agent host's "nord" metrics: each counter as
+// nord_self_<name>_total, each gauge as nord_self_<name>.
+func writeSelfExposition(w io.Writer, self []plugin.SelfMetric) {
+	for _, m := range self {
+		name, kind := "nord_self_"+promName(m.Name), "gauge"
+		if m.Type == "counter" {
+			name, kind = name+"_total", "counter"
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		fmt.Fprintf(w, "%s %d\n", name, m.Value)
+	}
+}
+
+// promName turns a registry name into a valid metric name part: anything but
+// letters, digits and underscores becomes an underscore.
+func promName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// exportValue returns the numeric value of m: the value_num a plugin kept at
+// full precision, else its value parsed as the store does.
+func exportValue(m plugin.MetricResult) (float64, bool) {
+	if n, ok := m.Extra["value_num"].(float64); ok {
+		return n, true
+	}
+	switch v := m.Value.(type) {
+	case nil, []interface{}, []float64, map[string]interface{}:
+		return 0, false
+	case float64:
+		return v, true
+	default:
+		if n := store.ParseValueNum(fmt.Sprint(v)); n != nil {
+			return *n, true
+		}
+	}
+	return 0, false
+}
+
+// promLabels renders name/value pairs as a Prometheus label set, leaving out
+// empty values.
+func promLabels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(promEscaper.Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	if b.Len() > 0 {
+		b.WriteByte('}')
+	}
+	return b.String()
+}
+
+// promEscaper escapes a label value as the text format requires.
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+	"observer/store"
+)
+
+func fixtureResults() plugin.Results {
+	return plugin.Results{
+		"router": {Metrics: []plugin.MetricResult{
+			{Label: "uptime", Plugin: "snmp", Name: "uptime", Value: "2d 0h 0m 0s", Category: "system"},
+			{Label: "if_in", Plugin: "snmp", Name: "if_in", Value: 1024.0, Instance: `eth0 "wan"`, Unit: plugin.UnitBytes},
+			{Label: "status", Plugin: "ping", Name: "status", Value: "up"},
+			{Label: "descr", Plugin: "snmp", Name: "descr", Value: "RouterOS"},
+			{Label: "load_hist", Plugin: "local", Name: "load_hist", Value: []interface{}{1.0, 2.0}},
+		}},
+		"db": {Metrics: []plugin.MetricResult{
+			{Label: "load", Plugin: "local", Name: "load_1", Value: "0.5", Extra: map[string]interface{}{"value_num": 0.4567}},
+			{Label: "load_again", Plugin: "local", Name: "load_1", Value: "0.6"},
+		}},
+	}
+}
+
+func TestWriteExposition(t *testing.T) {
+	var buf bytes.Buffer
+	writeExposition(&buf, fixtureResults(), true)
+
+	want := `# HELP nord_results_ok Whether the last collection could be read (1) or not (0).
+# TYPE nord_results_ok gauge
+nord_results_ok 1
+# HELP nord_metric Numeric value of a metric in the last collection.
+# TYPE nord_metric gauge
+nord_metric{host="db",plugin="local",name="load_1"} 0.4567
+nord_metric{host="router",plugin="ping",name="status"} 1
+nord_metric{host="router",plugin="snmp",name="if_in",instance="eth0 \"wan\"",unit="bytes"} 1024
+nord_metric{host="router",plugin="snmp",name="uptime",category="system"} 172800
+`
+	if got := buf.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteExpositionWithoutResults(t *testing.T) {
+	var buf bytes.Buffer
+	writeExposition(&buf, nil, false)
+	if !strings.Contains(buf.String(), "nord_results_ok 0\n") {
+		t.Errorf("exposition without results:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "nord_metric{") {
+		t.Errorf("exposition without results has samples:\n%s", buf.String())
+	}
+}
+
+func TestExporterHandlerReadsResults(t *testing.T) {
+	testenv.UseTempDirs(t)
+	data, err := json.Marshal(fixtureResults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plugin.WriteDataFile(plugin.ResultsFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	exporterHandler(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{"nord_results_ok 1", `nord_metric{host="router",plugin="ping",name="status"} 1`} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("scrape lacks %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestExporterComponentServesUntilCancelled(t *testing.T) {
+	testenv.UseTempDirs(t)
+	addr := freeAddr(t)
+	comp := exporterComponent(plugin.DaemonExporterConfig{Listen: addr, Path: "/scrape"}, nil, io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- comp.run(ctx) }()
+
+	var resp *http.Response
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if resp, err = http.Get("http://" + addr + "/scrape"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "nord_results_ok 0") {
+		t.Errorf("scrape = %d:\n%s", resp.StatusCode, body)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("run after cancel = %v, want nil", err)
+		}
+	case <-time.After(exporterShutdownTimeout + time.Second):
+		t.Fatal("exporter did not stop after cancel")
+	}
+	if _, err := os.Stat(plugin.DataFile(plugin.ResultsFile)); !os.IsNotExist(err) {
+		t.Errorf("scrape created %s", plugin.ResultsFile)
+	}
+}
+
+// freeAddr returns a loopback address with a port nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestExporterServesSelfMetrics(t *testing.T) {
+	testenv.UseTempDirs(t)
+	m := &plugin.Metrics{}
+	m.Add(plugin.SelfTasksRun, 3)
+	m.Add(plugin.SelfTaskErrors, 1)
+	m.Set(plugin.SelfDeferredTasks, 2)
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		exporterHandler(m).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+	body := scrape()
+	for _, line := range []string{
+		"# TYPE nord_self_tasks_run_total counter",
+		"nord_self_tasks_run_total 3",
+		"nord_self_task_errors_total 1",
+		"# TYPE nord_self_deferred_tasks gauge",
+		"nord_self_deferred_tasks 2",
+		"# TYPE nord_self_goroutines gauge",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("scrape lacks %q:\n%s", line, body)
+		}
+	}
+
+	m.Add(plugin.SelfTasksRun, 2)
+	if body := scrape(); !strings.Contains(body, "nord_self_tasks_run_total 5\n") {
+		t.Errorf("counter not updated between scrapes:\n%s", body)
+	}
+}
+
+// TestUnitPropagation follows a plugin's unit into the store's unit column
+// and the exporter's label set.
+func TestUnitPropagation(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	m := plugin.NewMetricResult("disk_free_bytes_/", "local", map[string]interface{}{
+		"name":     "free_bytes",
+		"value":    2048.0,
+		"type":     "gauge",
+		"category": "disk",
+		"instance": "/",
+		"unit":     plugin.UnitBytes,
+	})
+	if m.Unit != plugin.UnitBytes {
+		t.Fatalf("NewMetricResult unit = %q", m.Unit)
+	}
+
+	st, err := store.Open("sqlite://" + filepath.Join(dir, "nord.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	err = st.WriteBatch(ctx, []store.MetricRecord{{
+		HostKey: "db", HostName: "db", Plugin: m.Plugin, Name: m.Name, Category: m.Category,
+		MetricType: m.Type, Value: "2048", Unit: m.Unit, Instance: m.Instance, CollectedAt: time.Now(),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := st.LatestMetrics(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Unit != plugin.UnitBytes {
+		t.Fatalf("stored = %+v, want one record in bytes", stored)
+	}
+
+	data, err := json.Marshal(plugin.Results{"db": {Metrics: []plugin.MetricResult{m}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plugin.WriteDataFile(plugin.ResultsFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	exporterHandler(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	want := `nord_metric{host="db",plugin="local",name="free_bytes",instance="/",category="disk",unit="bytes"} 2048`
+	if !strings.Contains(rec.Body.String(), want+"\n") {
+		t.Errorf("scrape lacks %q:\n%s", want, rec.Body.String())
+	}
+}
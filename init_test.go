@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+// runInitWith runs `nord init args...` answering the prompts from script.
+func runInitWith(t *testing.T, script string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"init"}, args...), strings.NewReader(script), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// loadWritten reads the config init wrote the way nord does, failing the test
+// if it needs upgrading, does not parse or does not validate.
+func loadWritten(t *testing.T) *plugin.Config {
+	t.Helper()
+	data, err := os.ReadFile(plugin.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded, applied, err := plugin.UpgradeConfig(data)
+	if err != nil || len(applied) > 0 {
+		t.Fatalf("upgrade: %v, applied %q", err, applied)
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(upgraded, &cfg); err != nil {
+		t.Fatalf("written config does not parse: %v\n%s", err, data)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("written config does not validate: %v\n%s", err, data)
+	}
+	return &cfg
+}
+
+func TestInitSNMPPrompts(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	// Host, address, credential type, community, version (default), device
+	// type, database (default) and range.
+	script := "core1\n10.0.0.1\nsnmp\nsecret\n\ncisco\n\n10.0.0.0/24\n"
+	code, stdout, stderr := runInitWith(t, script)
+	if code != exitOK {
+		t.Fatalf("exit %d, stderr %q", code, stderr)
+	}
+	for _, q := range []string{"First host name [router]: ", "SNMP community [public]: ", "SNMP version [2c]: ", "Perception range in CIDR"} {
+		if !strings.Contains(stdout, q) {
+			t.Errorf("prompt %q not asked:\n%s", q, stdout)
+		}
+	}
+	if strings.Contains(stdout, "SSH user") {
+		t.Errorf("SSH questions asked for an SNMP credential:\n%s", stdout)
+	}
+
+	cfg := loadWritten(t)
+	host := cfg.Hosts["core1"]
+	if host.Address != "10.0.0.1" || len(host.Collect) != 2 || host.Collect[1].Credentials != "core1_snmp" {
+		t.Errorf("host = %+v", host)
+	}
+	cred := cfg.Credentials["core1_snmp"]
+	if cred.Community != "secret" || cred.Version != "2c" || cred.Type != "cisco" || cred.Port != 161 {
+		t.Errorf("credential = %+v", cred)
+	}
+	if cfg.Database.URL != "sqlite://"+filepath.Join(dir, "nord.db") {
+		t.Errorf("database = %q", cfg.Database.URL)
+	}
+	if env := cfg.Perception["local_network"]; len(env.Ranges) != 1 || env.Ranges[0] != "10.0.0.0/24" || !env.Enabled {
+		t.Errorf("perception = %+v", env)
+	}
+	if info, err := os.Stat(plugin.ConfigFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("config mode: %v, %v", info.Mode(), err)
+	}
+}
+
+func TestInitSSHWithFlagsAndShortScript(t *testing.T) {
+	testenv.UseTempDirs(t)
+	// The flags answer the host questions; the script runs out after the SSH
+	// user, so everything after it takes its default.
+	code, stdout, stderr := runInitWith(t, "ops\n", "--host", "web1", "--address", "10.0.0.8", "--cred", "ssh", "--range", "none")
+	if code != exitOK {
+		t.Fatalf("exit %d, stderr %q", code, stderr)
+	}
+	if strings.Contains(stdout, "First host name") || strings.Contains(stdout, "Perception range") {
+		t.Errorf("asked a question answered by a flag:\n%s", stdout)
+	}
+	cfg := loadWritten(t)
+	cred := cfg.Credentials["web1_ssh"]
+	if cred.User != "ops" || cred.Port != 22 || cred.Host != "10.0.0.8" || cred.Type != "generic" {
+		t.Errorf("credential = %+v", cred)
+	}
+	if len(cfg.Perception) != 0 {
+		t.Errorf("perception = %+v", cfg.Perception)
+	}
+}
+
+func TestInitDefaultsAskNothing(t *testing.T) {
+	testenv.UseTempDirs(t)
+	code, stdout, stderr := runInitWith(t, "ignored\n", "--defaults", "--cred", "none", "--database", "none")
+	if code != exitOK {
+		t.Fatalf("exit %d, stderr %q", code, stderr)
+	}
+	if strings.Contains(stdout, "?") || strings.Contains(stdout, "]: ") {
+		t.Errorf("--defaults asked questions:\n%s", stdout)
+	}
+	cfg := loadWritten(t)
+	if _, ok := cfg.Hosts["router"]; !ok || len(cfg.Credentials) != 0 || cfg.Database.URL != "" {
+		t.Errorf("hosts %+v, credentials %+v, database %q", cfg.Hosts, cfg.Credentials, cfg.Database.URL)
+	}
+}
+
+func TestInitRefusesBadAnswers(t *testing.T) {
+	testenv.UseTempDirs(t)
+	for _, tc := range []struct {
+		script string
+		args   []string
+		msg    string
+	}{
+		{"", []string{"--defaults", "--cred", "telnet"}, `credential type "telnet" is not snmp, ssh or none`},
+		{"", []string{"--defaults", "--cred", "ssh", "--ssh-port", "twenty-two"}, `SSH port "twenty-two" is not a number`},
+		{"", []string{"--defaults", "--range", "10.0.0.0/33"}, "the answers do not make a valid config"},
+	} {
+		code, _, stderr := runInitWith(t, tc.script, tc.args...)
+		if code != exitFailure || !strings.Contains(stderr, tc.msg) {
+			t.Errorf("%q: exit %d, stderr %q", tc.args, code, stderr)
+		}
+		if _, err := os.Stat(plugin.ConfigFile); !os.IsNotExist(err) {
+			t.Errorf("%q: a config was written", tc.args)
+		}
+	}
+}
+
+func TestInitOverwrite(t *testing.T) {
+	testenv.UseTempDirs(t)
+	writeConfig(t, `{"config_version": 1, "hosts": {"keep": {"address": "10.0.0.2"}}}`)
+
+	code, _, stderr := runInitWith(t, "", "--defaults")
+	if code != exitFailure || !strings.Contains(stderr, "already exists; use --force") {
+		t.Errorf("exit %d, stderr %q", code, stderr)
+	}
+	if cfg := loadWritten(t); cfg.Hosts["keep"].Address != "10.0.0.2" {
+		t.Errorf("existing config changed: %+v", cfg.Hosts)
+	}
+
+	if code, _, stderr := runInitWith(t, "", "--defaults", "--force"); code != exitOK {
+		t.Fatalf("--force: exit %d, stderr %q", code, stderr)
+	}
+	if cfg := loadWritten(t); len(cfg.Hosts) != 1 || cfg.Hosts["router"].Address != "192.168.1.1" {
+		t.Errorf("hosts = %+v", cfg.Hosts)
+	}
+}
+
+func TestInitExample(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	code, stdout, stderr := runInitWith(t, "", "--example")
+	if code != exitOK {
+		t.Fatalf("exit %d, stderr %q", code, stderr)
+	}
+	path := filepath.Join(dir, "config.example.json")
+	if !strings.Contains(stdout, path) {
+		t.Errorf("stdout = %q", stdout)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded, applied, err := plugin.UpgradeConfig(data)
+	if err != nil || len(applied) > 0 {
+		t.Fatalf("upgrade: %v, applied %q", err, applied)
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(upgraded, &cfg); err != nil {
+		t.Fatalf("example does not parse: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("example does not validate: %v", err)
+	}
+	if cfg.Database.URL != "sqlite://"+filepath.Join(dir, "nord.db") {
+		t.Errorf("database = %q", cfg.Database.URL)
+	}
+	if _, err := os.Stat(plugin.ConfigFile); !os.IsNotExist(err) {
+		t.Error("--example wrote config.json")
+	}
+
+	// Every section of plugin.Config is in the example, and nothing else.
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(upgraded, &sections); err != nil {
+		t.Fatal(err)
+	}
+	delete(sections, "_comment")
+	typ := reflect.TypeOf(plugin.Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		tag, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if _, ok := sections[tag]; !ok {
+			t.Errorf("example lacks %q", tag)
+		}
+		delete(sections, tag)
+	}
+	for key := range sections {
+		t.Errorf("example has %q, which is not a config section", key)
+	}
+}
@@ -0,0 +1,27 @@
+// Package testenv holds fixtures shared by the tests of several packages. It
+// is imported by _test.go files only, so it never links into nord.
+package testenv
+
+import (
+	"path/filepath"
+	"testing"
+
+	plugin "observer/base"
+)
+
+// UseTempDirs points the config file and the data and state directories at a
+// fresh temporary directory for the duration of the test, and returns it.
+func UseTempDirs(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(plugin.EnvDataDir, dir)
+	t.Setenv(plugin.EnvStateDir, dir)
+	oldConfig := plugin.ConfigFile
+	plugin.ConfigFile = filepath.Join(dir, "config.json")
+	t.Cleanup(func() {
+		plugin.ConfigFile = oldConfig
+		plugin.LoadPaths()
+	})
+	plugin.LoadPaths()
+	return dir
+}
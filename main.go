@@ -2,48 +2,87 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 
 	plugin "observer/base"
 	"observer/plugins"
-	"observer/plugins/flow"
 	_ "observer/plugins/textui" // Import for side effect (plugin registration)
 	"observer/store"
 )
 
 func main() {
-	// Define command-line flags
-	pluginName := flag.String("p", "", "Plugin to command")
-	action := flag.String("a", "", "Action to perform on the plugin")
-	collect := flag.Bool("collect", false, "Run data collection using the 'collection' plugin")
-	perception := flag.Bool("perception", false, "Run network discovery (perception) using the 'network' plugin")
-	remote := flag.Bool("remote", false, "Send collected data to remote server(s) using the 'api' plugin")
-	ui := flag.Bool("ui", false, "Start the Text User Interface (TUI)")
-	runFlow := flag.Bool("flow", false, "Start the IPFlow (NetFlow/sFlow/IPFIX) UDP Collector")
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run parses the command line, sets up the controller and dispatches the
+// subcommand. It returns the process exit code so deferred cleanup runs first.
+func run(argv []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var opts globalOptions
+	fs := newGlobalFlags(&opts, stderr)
+	if err := fs.Parse(argv); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printUsage(stdout)
+			return exitOK
+		}
+		printUsage(stderr)
+		return exitUsage
+	}
+
+	name, args, deprecated := route(opts, fs.Args())
+	if deprecated != "" {
+		fmt.Fprintf(stderr, "Warning: %s is deprecated; use `nord %s` instead.\n", deprecated, name)
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(stderr, "Error: unknown command %q\n", name)
+		printUsage(stderr)
+		return exitUsage
+	}
+
+	plugin.ConfigFile = configPath(opts.config)
+	plugin.LoadPaths()
+	// A config written for a newer nord would be misread: refuse it up front.
+	var versionErr *plugin.ConfigVersionError
+	if _, err := plugin.ReadConfigFile(); errors.As(err, &versionErr) && name != "help" && name != "version" && name != "init" {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitFailure
+	}
 
-	flag.Parse()
+	// Status output is meant for scripts; keep informational chatter off stdout.
+	quiet := name == "status" || name == "help" || name == "version" || name == "init" || name == "config" || name == "selftest" || wantsJSON(opts.output, args) ||
+		(name == "plugin" && len(args) >= 3 && args[1] == "textui" && args[2] == "status")
 
 	// Create a new controller
 	controller := plugin.NewController()
+	safety, err := configSafety(opts.readOnly)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitFailure
+	}
+	controller.Safety = safety
 
-	// Open database store if configured.
-	// Parse only the database section to avoid errors from complex collect fields.
-	if cfgData, err := os.ReadFile("data/config.json"); err == nil {
-		var dbCfg struct {
-			Database plugin.DatabaseConfig `json:"database"`
+	// Open the store before any plugin runs so every command sees the same one.
+	// Commands that never touch it skip it, so they create no database file.
+	var st store.Store
+	if name != "help" && name != "version" && name != "init" && name != "config" {
+		st, err = openStore(opts.store, quiet, stdout, stderr)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return exitFailure
 		}
-		if json.Unmarshal(cfgData, &dbCfg) == nil && dbCfg.Database.URL != "" {
-			st, err := store.Open(dbCfg.Database.URL)
-			if err != nil {
-				fmt.Printf("Warning: could not open database: %v\n", err)
-			} else if st != nil {
-				controller.Store = st
-				defer st.Close()
-				fmt.Printf("Database connected: %s\n", dbCfg.Database.URL)
+	}
+	if st != nil {
+		controller.Store = plugin.InstrumentStore(st, controller.Metrics)
+		defer func() {
+			if err := st.Close(); err != nil {
+				fmt.Fprintf(stderr, "Warning: closing database: %v\n", err)
 			}
-		}
+		}()
 	}
 
 	// Register all plugins that have been imported.
@@ -51,75 +90,79 @@ func main() {
 		controller.AddPlugin(p)
 	}
 
-	fmt.Println("Nord Observability, Reliability & Discovery")
-
-	// Handle the --flow flag to start the UDP listeners
-	if *runFlow {
-		fmt.Println("Initializing IPFlow Collection Engine...")
-		collector := flow.NewCollector(controller.Store)
-		collector.Start()
-		os.Exit(0)
+	if !quiet {
+		fmt.Fprintln(stdout, "Nord Observability, Reliability & Discovery")
 	}
 
-	// Handle the --ui flag
-	if *ui {
-		err := controller.OnCommand("textui", map[string]string{"action": "start"})
-		if err != nil {
-			fmt.Printf("Error starting TUI: %v\n", err)
-			os.Exit(1)
-		}
-		os.Exit(0)
+	env := &cliEnv{controller: controller, output: opts.output, stdin: stdin, stdout: stdout, stderr: stderr}
+	err = cmd.run(env, args)
+	var usage *usageError
+	if errors.As(err, &usage) && usage.cmd == "" {
+		usage.cmd = name
 	}
+	return exitCode(env, err)
+}
 
-	// Handle the --collect flag as a shortcut
-	if *collect {
-		err := controller.OnCommand("collection", map[string]string{"action": "collect"})
-		if err != nil {
-			fmt.Printf("Error during collection: %v\n", err)
-			os.Exit(1)
-		}
-		os.Exit(0)
+// openStore opens the metrics store from --store, or else the database URL in
+// the config file, and reports whether persistence is enabled. A bad --store URL
+// is an error; a bad configured URL only disables persistence, as before.
+func openStore(override string, quiet bool, stdout, stderr io.Writer) (store.Store, error) {
+	// Parse only the database section to avoid errors from complex collect fields.
+	var dbCfg struct {
+		Database plugin.DatabaseConfig `json:"database"`
 	}
-
-	// Handle the --perception flag
-	if *perception {
-		err := controller.OnCommand("network", map[string]string{"action": "perception"})
-		if err != nil {
-			fmt.Printf("Error during perception: %v\n", err)
-			os.Exit(1)
+	if cfgData, err := plugin.ReadConfigFile(); err == nil {
+		if json.Unmarshal(cfgData, &dbCfg) != nil {
+			dbCfg.Database = plugin.DatabaseConfig{}
 		}
-		os.Exit(0)
+	}
+	rawURL, source := override, "--store"
+	if rawURL == "" {
+		rawURL, source = dbCfg.Database.URL, plugin.ConfigFile
 	}
 
-	// Handle the --remote flag
-	if *remote {
-		err := controller.OnCommand("api", map[string]string{"action": "send"})
-		if err != nil {
-			fmt.Printf("Error during remote send: %v\n", err)
-			os.Exit(1)
-		}
-		os.Exit(0)
+	info := stdout
+	if quiet {
+		info = io.Discard
+	}
+	if rawURL == "" {
+		fmt.Fprintln(info, "Persistence disabled: no database configured")
+		return nil, nil
 	}
 
-	// Handle plugin-specific commands
-	if *pluginName != "" {
-		if *action == "" {
-			fmt.Println("Error: No action specified for the plugin.")
-			flag.Usage()
-			os.Exit(1)
+	st, err := dbCfg.Database.OpenStore(rawURL)
+	if err != nil {
+		if override != "" {
+			return nil, fmt.Errorf("could not open database: %w", err)
 		}
-		args := make(map[string]string)
-		args["action"] = *action
-		args["args"] = flag.Arg(0)
+		fmt.Fprintf(stderr, "Warning: could not open database, persistence disabled: %v\n", err)
+		return nil, nil
+	}
+	fmt.Fprintf(info, "Persistence enabled: %s (from %s)\n", redactURL(rawURL), source)
+	return st, nil
+}
 
-		err := controller.OnCommand(*pluginName, args)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
-		}
-		os.Exit(0)
+// configSafety returns the highest action safety level commands may run:
+// read with --read-only, else "safety" from the config file (default
+// destructive, i.e. unrestricted).
+func configSafety(readOnly bool) (plugin.Safety, error) {
+	if readOnly {
+		return plugin.SafetyRead, nil
 	}
+	var cfg struct {
+		Safety string `json:"safety"`
+	}
+	if cfgData, err := plugin.ReadConfigFile(); err == nil {
+		json.Unmarshal(cfgData, &cfg)
+	}
+	return plugin.ParseSafety(cfg.Safety)
+}
 
-	// If no commands were handled, print usage
-	flag.Usage()
+// redactURL hides any password in a database URL before it is logged.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+	"observer/store"
+)
+
+func TestOpenStore(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	url := "sqlite://" + filepath.Join(dir, "override.db")
+
+	var stdout, stderr bytes.Buffer
+	st, err := openStore(url, false, &stdout, &stderr)
+	if err != nil || st == nil {
+		t.Fatalf("--store: %v, %v", st, err)
+	}
+	st.Close()
+	if want := "Persistence enabled: " + url + " (from --store)"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("stdout %q lacks %q", stdout.String(), want)
+	}
+
+	// The configured URL is used without --store; a password is never logged.
+	configured := "sqlite://" + filepath.Join(dir, "configured.db")
+	writeConfig(t, `{"config_version": 1, "database": {"url": "`+configured+`"}}`)
+	stdout.Reset()
+	if st, err = openStore("", false, &stdout, &stderr); err != nil || st == nil {
+		t.Fatalf("configured: %v, %v", st, err)
+	}
+	st.Close()
+	if !strings.Contains(stdout.String(), "(from "+plugin.ConfigFile+")") {
+		t.Errorf("configured: stdout %q", stdout.String())
+	}
+	if got := redactURL("postgres://nord:s3cret@db:5432/nord"); strings.Contains(got, "s3cret") {
+		t.Errorf("redactURL = %s", got)
+	}
+
+	// Quiet commands keep the notice off stdout.
+	stdout.Reset()
+	st, _ = openStore(url, true, &stdout, &stderr)
+	st.Close()
+	if stdout.Len() != 0 {
+		t.Errorf("quiet: stdout %q", stdout.String())
+	}
+}
+
+func TestOpenStoreFailures(t *testing.T) {
+	testenv.UseTempDirs(t)
+	var stdout, stderr bytes.Buffer
+
+	if st, err := openStore("", false, &stdout, &stderr); st != nil || err != nil || !strings.Contains(stdout.String(), "Persistence disabled: no database configured") {
+		t.Errorf("no database: %v, %v, stdout %q", st, err, stdout.String())
+	}
+
+	// A bad --store is fatal; a bad configured URL only disables persistence.
+	if _, err := openStore("nosuchdb://x", false, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "could not open database") {
+		t.Errorf("bad --store: err = %v", err)
+	}
+	writeConfig(t, `{"config_version": 1, "database": {"url": "nosuchdb://x"}}`)
+	if st, err := openStore("", false, &stdout, &stderr); st != nil || err != nil || !strings.Contains(stderr.String(), "persistence disabled") {
+		t.Errorf("bad configured URL: %v, %v, stderr %q", st, err, stderr.String())
+	}
+}
+
+func TestRunAttachesStore(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	path := filepath.Join(dir, "nord.db")
+
+	// Without a database the store command has nothing to work on.
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"store", "hosts"}, strings.NewReader(""), &stdout, &stderr); code != exitFailure || !strings.Contains(stderr.String(), "Error: ") || !strings.Contains(stderr.String(), "no database configured") {
+		t.Errorf("no store: exit %d, stderr %q", code, stderr.String())
+	}
+	if strings.Contains(stdout.String(), "Error:") {
+		t.Errorf("no store: error on stdout %q", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"--store", "sqlite://" + path, "store", "hosts"}, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+		t.Fatalf("--store: exit %d, stdout %q, stderr %q", code, stdout.String(), stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "Persistence enabled") {
+		t.Errorf("stdout %q", stdout.String())
+	}
+	// The database was created, migrated and closed; it opens again cleanly.
+	st, err := store.Open("sqlite://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	// Commands that never touch the store create no database file.
+	other := filepath.Join(dir, "untouched.db")
+	run([]string{"--store", "sqlite://" + other, "version"}, strings.NewReader(""), &stdout, &stderr)
+	if _, err := os.Stat(other); !os.IsNotExist(err) {
+		t.Errorf("version created %s: %v", other, err)
+	}
+}
+
+// writeConfig replaces the config file with data.
+func writeConfig(t *testing.T, data string) {
+	t.Helper()
+	if err := os.WriteFile(plugin.ConfigFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listFiles returns every file under root, relative to it.
+func listFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCollectWritesOnlyUnderDataDir(t *testing.T) {
+	root := t.TempDir()
+	dataDir := filepath.Join(root, "var", "data")
+	stateDir := filepath.Join(root, "var", "state")
+	config := filepath.Join(root, "etc", "config.json")
+	if err := os.MkdirAll(filepath.Dir(config), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := `{"config_version": 1,
+  "paths": {"data_dir": "` + dataDir + `", "state_dir": "` + stateDir + `"},
+  "hosts": {"self": {"address": "127.0.0.1", "collect": [{"metric": "local.load"}]}}}`
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A relative default such as data/ would land in the working directory.
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+	t.Setenv(plugin.EnvDataDir, "")
+	t.Setenv(plugin.EnvStateDir, "")
+	oldConfig := plugin.ConfigFile
+	t.Cleanup(func() {
+		plugin.ConfigFile = oldConfig
+		plugin.LoadPaths()
+	})
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"--config", config, "collect"}, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+		t.Fatalf("exit %d\nstdout %s\nstderr %s", code, stdout.String(), stderr.String())
+	}
+
+	if files := listFiles(t, cwd); len(files) != 0 {
+		t.Errorf("files written to the working directory: %q", files)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, plugin.ResultsFile)); err != nil {
+		t.Errorf("no results in the data directory: %v", err)
+	}
+	for _, f := range listFiles(t, root) {
+		if f != "etc/config.json" && !strings.HasPrefix(f, "var/data/") && !strings.HasPrefix(f, "var/state/") {
+			t.Errorf("%s written outside the data and state directories", f)
+		}
+	}
+}
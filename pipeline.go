@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	plugin "observer/base"
+)
+
+// stage is one step of `nord run`, carried out by a plugin action.
+type stage struct {
+	name   string
+	plugin string
+	action string
+}
+
+// pipelineStages are run in order by `nord run`.
+var pipelineStages = []stage{
+	{name: "perception", plugin: "network", action: "perception"},
+	{name: "collect", plugin: "collection", action: "collect"},
+	{name: "send", plugin: "api", action: "send"},
+}
+
+// Stage outcomes reported in the run summary.
+const (
+	stageOK      = "ok"
+	stageWarning = "warning" // completed with soft failures
+	stageFailed  = "failed"
+	stageSkipped = "skipped" // deselected with --skip-<stage>
+	stageNotRun  = "not run" // an earlier stage failed
+)
+
+// stageResult is the outcome of one stage.
+type stageResult struct {
+	stage    stage
+	status   string
+	err      error
+	warnings []string
+	detail   string
+	elapsed  time.Duration
+}
+
+// pipeline runs stages in order within one process. It listens on the
+// controller's event bus for warnings and discoveries made by the running stage.
+type pipeline struct {
+	controller *plugin.Controller
+
+	mu      sync.Mutex
+	current *stageResult // stage being run, nil between stages
+}
+
+// newPipeline subscribes a pipeline to c's events. Create one per process and
+// reuse it, since subscriptions cannot be removed.
+func newPipeline(c *plugin.Controller) *pipeline {
+	p := &pipeline{controller: c}
+	c.Subscribe(plugin.EventWarning, func(e plugin.Event) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.current != nil {
+			p.current.warnings = append(p.current.warnings, fmt.Sprint(e.Data))
+		}
+	})
+	c.Subscribe(plugin.EventHostsDiscovered, func(e plugin.Event) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if hosts, ok := e.Data.(map[string]plugin.Host); ok && p.current != nil {
+			p.current.detail = fmt.Sprintf("%d hosts discovered", len(hosts))
+		}
+	})
+	return p
+}
+
+// run runs the stages in order. A stage returning an error stops the pipeline;
+// warnings published while a stage runs are soft failures and are only reported.
+// Later stages see earlier ones' results through the controller's event bus,
+// e.g. collection picks up the hosts just perceived.
+func (p *pipeline) run(stages []stage, skip map[string]bool) []stageResult {
+	results := make([]stageResult, len(stages))
+	failed := false
+	for i, st := range stages {
+		r := &results[i]
+		r.stage = st
+		switch {
+		case skip[st.name]:
+			r.status = stageSkipped
+			continue
+		case failed:
+			r.status = stageNotRun
+			continue
+		}
+
+		p.mu.Lock()
+		p.current = r
+		p.mu.Unlock()
+
+		start := time.Now()
+		err := p.controller.OnCommand(st.plugin, map[string]string{"action": st.action})
+
+		p.mu.Lock()
+		p.current = nil
+		r.elapsed = time.Since(start)
+		switch {
+		case err != nil:
+			r.status, r.err = stageFailed, err
+			failed = true
+		case len(r.warnings) > 0:
+			r.status = stageWarning
+		default:
+			r.status = stageOK
+		}
+		p.mu.Unlock()
+	}
+	p.recordSelfMetrics()
+	return results
+}
+
+// recordSelfMetrics writes nord's own metrics under the agent's host, once per run.
+func (p *pipeline) recordSelfMetrics() {
+	if p.controller.Store == nil {
+		return
+	}
+	if err := p.controller.Store.WriteBatch(context.Background(), p.controller.Metrics.Records(time.Now())); err != nil {
+		p.controller.Printf("  !_ store: self metrics WriteBatch error: %v\n", err)
+	}
+}
+
+// printRunSummary writes one line per stage, followed by its warnings, then a
+// line of nord's own counters from m.
+func printRunSummary(w io.Writer, results []stageResult, m *plugin.Metrics) {
+	fmt.Fprintln(w, "--- Run Summary ---")
+	for _, r := range results {
+		marker := "|_"
+		if r.status == stageFailed || r.status == stageWarning {
+			marker = "!_"
+		}
+		line := fmt.Sprintf("  %s %s: %s", marker, r.stage.name, r.status)
+		switch r.status {
+		case stageSkipped:
+			line += fmt.Sprintf(" (--skip-%s)", r.stage.name)
+		case stageFailed:
+			line += fmt.Sprintf(" after %s: %v", r.elapsed.Round(time.Millisecond), r.err)
+		case stageOK, stageWarning:
+			line += " in " + r.elapsed.Round(time.Millisecond).String()
+			if r.detail != "" {
+				line += ", " + r.detail
+			}
+		}
+		fmt.Fprintln(w, line)
+		for _, warning := range r.warnings {
+			fmt.Fprintf(w, "      !_ %s\n", warning)
+		}
+	}
+	fmt.Fprintf(w, "  |_ nord: %s\n", selfSummary(m))
+}
+
+// selfSummary formats the registry's main counters on one line.
+func selfSummary(m *plugin.Metrics) string {
+	m.Snapshot() // refresh the runtime gauges
+	line := fmt.Sprintf("%d tasks (%d errors), %d deliveries (%d errors)",
+		m.Get(plugin.SelfTasksRun), m.Get(plugin.SelfTaskErrors),
+		m.Get(plugin.SelfDeliveries), m.Get(plugin.SelfDeliveryErrors))
+	if writes := m.Get(plugin.SelfStoreWrites); writes > 0 {
+		line += fmt.Sprintf(", %d store writes (avg %dms)", writes, m.Get(plugin.SelfStoreWriteMs)/writes)
+	}
+	return line + fmt.Sprintf(", %d goroutines, %.1f MiB heap",
+		m.Get("goroutines"), float64(m.Get("heap_alloc_bytes"))/(1<<20))
+}
+
+// runAll implements `nord run`.
+func runAll(env *cliEnv, args []string) error {
+	fs := commandFlags(env, "run")
+	skip := make(map[string]*bool, len(pipelineStages))
+	for _, st := range pipelineStages {
+		skip[st.name] = fs.Bool("skip-"+st.name, false, "Skip the "+st.name+" stage")
+	}
+	rest, err := interspersed(fs, args)
+	if err != nil {
+		return &usageError{msg: err.Error()}
+	}
+	if len(rest) > 0 {
+		return &usageError{msg: fmt.Sprintf("unexpected argument %q", rest[0])}
+	}
+
+	skipped := make(map[string]bool, len(skip))
+	for name, v := range skip {
+		skipped[name] = *v
+	}
+	pl := newPipeline(env.controller)
+	return withReport(env, "run", func(report *commandReport) error {
+		results := pl.run(pipelineStages, skipped)
+		if report != nil {
+			report.setStages(results)
+			printRunSummary(env.stderr, results, env.controller.Metrics)
+		} else {
+			printRunSummary(env.stdout, results, env.controller.Metrics)
+		}
+
+		for _, r := range results {
+			if r.status == stageFailed {
+				return fmt.Errorf("run stopped at %s", r.stage.name)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+// stagePlugin stands in for a pipeline stage's plugin: it logs its name to
+// order and then runs do, if set, against the controller.
+type stagePlugin struct {
+	plugin.BasePlugin
+	name  string
+	order *[]string
+	do    func(c *plugin.Controller) error
+}
+
+func (p *stagePlugin) Name() string { return p.name }
+
+func (p *stagePlugin) OnCommand(args map[string]string) error {
+	*p.order = append(*p.order, p.name+"."+args["action"])
+	if p.do == nil {
+		return nil
+	}
+	return p.do(p.Controller)
+}
+
+// pipelineEnv returns an environment with the three stage plugins, which log to order.
+func pipelineEnv(order *[]string) (*cliEnv, map[string]*stagePlugin, *bytes.Buffer, *bytes.Buffer) {
+	c := plugin.NewController()
+	stages := map[string]*stagePlugin{}
+	for _, name := range []string{"network", "collection", "api"} {
+		p := &stagePlugin{name: name, order: order}
+		c.AddPlugin(p)
+		stages[name] = p
+	}
+	var stdout, stderr bytes.Buffer
+	return &cliEnv{controller: c, output: "table", stdout: &stdout, stderr: &stderr}, stages, &stdout, &stderr
+}
+
+func TestRunStageOrder(t *testing.T) {
+	var order []string
+	env, stages, stdout, _ := pipelineEnv(&order)
+
+	// Collection sees the hosts perception just published, without a file in between.
+	var seen map[string]plugin.Host
+	env.controller.Subscribe(plugin.EventHostsDiscovered, func(e plugin.Event) {
+		seen, _ = e.Data.(map[string]plugin.Host)
+	})
+	stages["network"].do = func(c *plugin.Controller) error {
+		c.Publish(plugin.Event{Topic: plugin.EventHostsDiscovered, Source: "network", Data: map[string]plugin.Host{
+			"192.0.2.10": {Address: "192.0.2.10"},
+			"192.0.2.11": {Address: "192.0.2.11"},
+		}})
+		return nil
+	}
+	var hostsAtCollect int
+	stages["collection"].do = func(*plugin.Controller) error {
+		hostsAtCollect = len(seen)
+		return nil
+	}
+
+	if err := runAll(env, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, " "); got != "network.perception collection.collect api.send" {
+		t.Errorf("order = %s", got)
+	}
+	if hostsAtCollect != 2 {
+		t.Errorf("collection saw %d hosts", hostsAtCollect)
+	}
+	out := stdout.String()
+	for _, want := range []string{"--- Run Summary ---", "|_ perception: ok in ", ", 2 hosts discovered", "|_ collect: ok", "|_ send: ok", "|_ nord: 0 tasks"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("summary lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunHardFailureStops(t *testing.T) {
+	var order []string
+	env, stages, stdout, _ := pipelineEnv(&order)
+	stages["collection"].do = func(*plugin.Controller) error { return errors.New("collection.json is not writable") }
+
+	err := runAll(env, nil)
+	if err == nil || err.Error() != "run stopped at collect" {
+		t.Errorf("err = %v", err)
+	}
+	if got := strings.Join(order, " "); got != "network.perception collection.collect" {
+		t.Errorf("order = %s", got)
+	}
+	out := stdout.String()
+	for _, want := range []string{"!_ collect: failed after ", ": collection.json is not writable", "|_ send: not run"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("summary lacks %q:\n%s", want, out)
+		}
+	}
+	if code := exitCode(env, err); code != exitFailure {
+		t.Errorf("exit %d", code)
+	}
+}
+
+func TestRunSoftFailureContinues(t *testing.T) {
+	var order []string
+	env, stages, stdout, _ := pipelineEnv(&order)
+	stages["network"].do = func(c *plugin.Controller) error {
+		c.Warn("network", "nmap not found; using ping sweep")
+		return nil
+	}
+	stages["api"].do = func(c *plugin.Controller) error {
+		c.Warn("api", "destination backup: 503 Service Unavailable")
+		return nil
+	}
+
+	if err := runAll(env, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 3 {
+		t.Errorf("order = %v", order)
+	}
+	out := stdout.String()
+	for _, want := range []string{"!_ perception: warning", "!_ nmap not found; using ping sweep", "|_ collect: ok", "!_ send: warning", "!_ destination backup: 503"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("summary lacks %q:\n%s", want, out)
+		}
+	}
+	// A warning belongs to the stage that raised it.
+	if strings.Count(out, "      !_ ") != 2 {
+		t.Errorf("warnings misattributed:\n%s", out)
+	}
+}
+
+func TestRunSkipStages(t *testing.T) {
+	var order []string
+	env, _, stdout, _ := pipelineEnv(&order)
+	if err := runAll(env, []string{"--skip-perception", "--skip-send"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, " "); got != "collection.collect" {
+		t.Errorf("order = %s", got)
+	}
+	for _, want := range []string{"|_ perception: skipped (--skip-perception)", "|_ send: skipped (--skip-send)"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, stdout)
+		}
+	}
+
+	var usage *usageError
+	if err := runAll(env, []string{"--skip-everything"}); !errors.As(err, &usage) {
+		t.Errorf("unknown flag: err = %v", err)
+	}
+	if err := runAll(env, []string{"now"}); !errors.As(err, &usage) {
+		t.Errorf("extra argument: err = %v", err)
+	}
+}
+
+func TestRunJSONReport(t *testing.T) {
+	var order []string
+	env, stages, stdout, stderr := pipelineEnv(&order)
+	env.output = "json"
+	stages["collection"].do = func(*plugin.Controller) error { return errors.New("boom") }
+
+	if err := runAll(env, nil); err == nil {
+		t.Fatal("expected the run to stop")
+	}
+	// The summary goes to stderr so stdout stays a single JSON document.
+	if !strings.Contains(stderr.String(), "--- Run Summary ---") {
+		t.Errorf("stderr %q", stderr.String())
+	}
+	var report commandReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, stdout)
+	}
+	if report.Command != "run" || report.Status != reportFailed || len(report.Stages) != 3 {
+		t.Fatalf("report: command %q, status %q, %d stages", report.Command, report.Status, len(report.Stages))
+	}
+	if s := report.Stages[1]; s.Name != "collect" || s.Status != stageFailed || s.Error != "boom" {
+		t.Errorf("collect stage = %+v", s)
+	}
+	if s := report.Stages[2]; s.Status != stageNotRun {
+		t.Errorf("send stage = %+v", s)
+	}
+}
+
+// batchStore records the batches written to it.
+type batchStore struct {
+	store.Store
+	batches [][]store.MetricRecord
+}
+
+func (s *batchStore) WriteBatch(ctx context.Context, records []store.MetricRecord) error {
+	s.batches = append(s.batches, records)
+	return nil
+}
+
+func TestRunCountsSelfMetrics(t *testing.T) {
+	var order []string
+	env, stages, stdout, _ := pipelineEnv(&order)
+	st := &batchStore{}
+	env.controller.Store = plugin.InstrumentStore(st, env.controller.Metrics)
+
+	stages["collection"].do = func(c *plugin.Controller) error {
+		for _, status := range []string{plugin.ResultOK, plugin.ResultError, plugin.ResultOK} {
+			c.Publish(plugin.Event{Topic: plugin.EventTaskResult, Source: "collection", Data: plugin.TaskResult{Host: "web1", Task: "ping", Status: status}})
+		}
+		return c.Store.WriteBatch(context.Background(), []store.MetricRecord{{HostKey: "web1", Name: "ping"}})
+	}
+	stages["api"].do = func(c *plugin.Controller) error {
+		c.Publish(plugin.Event{Topic: plugin.EventDelivery, Source: "api", Data: plugin.DeliveryResult{Destination: "central", Status: plugin.ResultOK}})
+		return nil
+	}
+
+	if err := runAll(env, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The summary follows the self-metrics write, so it counts two store writes.
+	if !strings.Contains(stdout.String(), "|_ nord: 3 tasks (1 errors), 1 deliveries (0 errors), 2 store writes (avg ") {
+		t.Errorf("summary:\n%s", stdout)
+	}
+
+	// The run ends by writing the registry under the agent's own host.
+	if len(st.batches) != 2 {
+		t.Fatalf("%d batches written", len(st.batches))
+	}
+	self := map[string]string{}
+	for _, r := range st.batches[1] {
+		if r.HostKey != plugin.AgentHostKey || r.Plugin != "nord" {
+			t.Errorf("self record = %+v", r)
+		}
+		self[r.Name] = r.Value
+	}
+	for name, want := range map[string]string{plugin.SelfTasksRun: "3", plugin.SelfTaskErrors: "1", plugin.SelfDeliveries: "1", plugin.SelfStoreWrites: "1"} {
+		if self[name] != want {
+			t.Errorf("%s = %q, want %q", name, self[name], want)
+		}
+	}
+}
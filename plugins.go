@@ -3,13 +3,28 @@ package main
 import (
 	// By importing the plugin packages, we cause their init() functions to run,
 	// which in turn register the plugins with the central registry.
+	_ "observer/plugins/alert"
 	_ "observer/plugins/api"
+	_ "observer/plugins/backupcheck"
+	_ "observer/plugins/certwatch"
 	_ "observer/plugins/collection"
+	_ "observer/plugins/dbcheck"
 	_ "observer/plugins/device"
+	_ "observer/plugins/dns"
+	_ "observer/plugins/execcheck"
+	_ "observer/plugins/httpcheck"
+	_ "observer/plugins/kubernetes"
 	_ "observer/plugins/local"
 	_ "observer/plugins/mail"
+	_ "observer/plugins/mqtt"
+	_ "observer/plugins/netconf"
 	_ "observer/plugins/network"
+	_ "observer/plugins/redfish"
+	_ "observer/plugins/report"
 	_ "observer/plugins/snmp"
 	_ "observer/plugins/sshcollect"
+	_ "observer/plugins/storeadmin"
+	_ "observer/plugins/syslog"
 	_ "observer/plugins/wasm"
+	_ "observer/plugins/winrm"
 )
@@ -0,0 +1,292 @@
+// Package alert evaluates threshold rules against the store after every
+// collection and notifies webhook, email and exec channels when an alert
+// fires or resolves. Each firing and resolution is also stored as a status
+// metric of the "alert" plugin, named after the rule, so alert history can be
+// queried like any other metric.
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+	"observer/store"
+)
+
+const (
+	pluginKey      = "alert"
+	alertStateFile = "alert_state.json"
+)
+
+// alertPlugin evaluates alert rules.
+type alertPlugin struct {
+	plugin.BasePlugin
+	mu sync.Mutex // serializes evaluations and the state file
+}
+
+func init() {
+	plugins.Register(&alertPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *alertPlugin) Name() string {
+	return "Alert"
+}
+
+// Init subscribes to finished collections, so rules are evaluated on fresh
+// data whether the collection was a one-shot run, a daemon cycle or a single
+// host collected through the API, and to perception's host changes, which go
+// to the alert.changes channels.
+func (p *alertPlugin) Init(c *plugin.Controller) {
+	p.BasePlugin.Init(c)
+	c.Subscribe(plugin.EventCollectionDone, func(e plugin.Event) {
+		hosts, _ := e.Data.([]string)
+		if err := p.evaluate(hosts, time.Now()); err != nil {
+			p.Controller.Printf("  !_ alert: %v\n", err)
+		}
+	})
+	c.Subscribe(plugin.EventHostChange, func(e plugin.Event) {
+		if change, ok := e.Data.(plugin.HostChange); ok {
+			if err := p.notifyChange(change); err != nil {
+				p.Controller.Printf("  !_ alert: %v\n", err)
+			}
+		}
+	})
+}
+
+// ActionSafety marks "status" read-only; "evaluate" records and notifies.
+func (p *alertPlugin) ActionSafety(action string) plugin.Safety {
+	if action == "status" {
+		return plugin.SafetyRead
+	}
+	return plugin.SafetyWrite
+}
+
+// OnCommand handles "evaluate", which checks the rules against every host's
+// latest metrics, and "status", which lists pending and firing alerts.
+func (p *alertPlugin) OnCommand(args map[string]string) error {
+	switch args["action"] {
+	case "evaluate":
+		if p.Controller.Store == nil {
+			return errors.New("alert: evaluate needs a database (see database.url)")
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		hosts := []string{plugin.AgentHostKey}
+		for key := range cfg.Hosts {
+			hosts = append(hosts, key)
+		}
+		p.Controller.Println("--- Evaluating alert rules ---")
+		return p.evaluate(hosts, time.Now())
+	case "status":
+		return p.status()
+	}
+	return fmt.Errorf("unknown command for alert plugin: %s", args["action"])
+}
+
+// loadConfig reads the configuration file; only hosts and the alert section are used.
+func loadConfig() (*plugin.Config, error) {
+	data, err := plugin.ReadConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse config file: %w", err)
+	}
+	return &cfg, nil
+}
+
+// evaluate checks the rules against the latest stored metrics of hosts at
+// now, records and sends the resulting notifications, and saves the state.
+func (p *alertPlugin) evaluate(hosts []string, now time.Time) error {
+	st := p.Controller.Store
+	if st == nil || len(hosts) == 0 {
+		return nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if len(cfg.Alert.Rules) == 0 {
+		return nil
+	}
+	rules, err := compileRules(cfg.Alert)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Hosts in maintenance are not evaluated, which leaves their alert states
+	// as they were until the window ends.
+	maintenance := plugin.NewMaintenanceSchedule(cfg)
+	latest := make(map[string][]store.MetricRecord, len(hosts))
+	for _, key := range hosts {
+		if window, ok := maintenance.Active(key, now); ok {
+			p.Controller.Printf("  |_ alert: %s is in maintenance (%s), not evaluated\n", key, window)
+			continue
+		}
+		records, err := st.LatestMetrics(context.Background(), key)
+		if err != nil {
+			return fmt.Errorf("store: %w", err)
+		}
+		latest[key] = records
+	}
+	states := loadState()
+	transitions := evaluate(rules, latest, states, now)
+	if err := saveState(states); err != nil {
+		p.Controller.Printf("  !_ alert: could not save state: %v\n", err)
+	}
+	if len(transitions) == 0 {
+		return nil
+	}
+
+	var records []store.MetricRecord
+	for _, t := range transitions {
+		n := newNotification(t)
+		if t.Kind == kindResolved {
+			p.Controller.Printf("  |_ alert: %s\n", n.Summary)
+		} else {
+			p.Controller.Printf("  !_ alert: %s\n", n.Summary)
+		}
+		if t.Kind != kindRepeat {
+			records = append(records, alertRecord(t, n))
+		}
+		for _, name := range t.Rule.Channels {
+			if err := send(cfg.Alert.Channels[name], cfg.Alert.SMTP, n); err != nil {
+				p.Controller.Printf("  !_ alert: channel %s: %v\n", name, err)
+			}
+		}
+	}
+	if err := st.WriteBatch(context.Background(), records); err != nil {
+		p.Controller.Printf("  !_ store: alert WriteBatch error: %v\n", err)
+	}
+	return nil
+}
+
+// notifyChange sends a perception host change to the alert.changes channels.
+func (p *alertPlugin) notifyChange(c plugin.HostChange) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if len(cfg.Alert.Changes) == 0 {
+		return nil
+	}
+	n := changeNotification(c)
+	for _, name := range cfg.Alert.Changes {
+		ch, ok := cfg.Alert.Channels[name]
+		if !ok {
+			p.Controller.Printf("  !_ alert: changes: no channel %q\n", name)
+			continue
+		}
+		if err := send(ch, cfg.Alert.SMTP, n); err != nil {
+			p.Controller.Printf("  !_ alert: channel %s: %v\n", name, err)
+		}
+	}
+	return nil
+}
+
+// alertRecord is the status metric stored for a firing or resolution: "down"
+// for a critical alert, "warning" otherwise, and "up" once resolved.
+func alertRecord(t transition, n notification) store.MetricRecord {
+	value := "warning"
+	if t.Rule.Severity == "critical" {
+		value = "down"
+	}
+	if t.Kind == kindResolved {
+		value = "up"
+	}
+	return store.MetricRecord{
+		HostKey:     t.State.Host,
+		HostName:    t.HostName,
+		HostAddress: t.Address,
+		Plugin:      pluginKey,
+		Name:        t.Rule.Name,
+		Category:    "Alerts",
+		MetricType:  "status",
+		Value:       value,
+		ValueNum:    store.ParseValueNum(value),
+		Instance:    t.State.Instance,
+		Extra: map[string]interface{}{
+			"state":     n.State,
+			"severity":  n.Severity,
+			"metric":    t.State.Plugin + "/" + t.State.Metric,
+			"value":     n.Value,
+			"condition": n.Condition,
+			"since":     n.Since,
+		},
+		CollectedAt: t.At,
+	}
+}
+
+// status prints the alerts currently pending or firing.
+func (p *alertPlugin) status() error {
+	p.mu.Lock()
+	states := loadState()
+	p.mu.Unlock()
+	keys := make([]string, 0, len(states))
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	p.Controller.Println("--- Alerts ---")
+	if len(keys) == 0 {
+		p.Controller.Println("  |_ none")
+		return nil
+	}
+	for _, key := range keys {
+		s := states[key]
+		target := s.Host
+		if s.Instance != "" {
+			target += " " + s.Instance
+		}
+		if s.Firing {
+			p.Controller.Printf("  !_ FIRING  %s on %s: %s/%s = %s since %s\n", s.Rule, target, s.Plugin, s.Metric, s.Value, s.FiredAt.Local().Format(time.RFC3339))
+		} else {
+			p.Controller.Printf("  |_ pending %s on %s: %s/%s = %s since %s\n", s.Rule, target, s.Plugin, s.Metric, s.Value, s.PendingSince.Local().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+func loadState() map[string]*alertState {
+	states := make(map[string]*alertState)
+	if data, err := os.ReadFile(plugin.StateFile(alertStateFile)); err == nil {
+		var list []*alertState
+		if json.Unmarshal(data, &list) == nil {
+			for _, s := range list {
+				states[stateKey(s.Rule, s.Host, s.Plugin, s.Metric, s.Instance)] = s
+			}
+		}
+	}
+	return states
+}
+
+func saveState(states map[string]*alertState) error {
+	list := make([]*alertState, 0, len(states))
+	for _, s := range states {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Compare(
+			stateKey(list[i].Rule, list[i].Host, list[i].Plugin, list[i].Metric, list[i].Instance),
+			stateKey(list[j].Rule, list[j].Host, list[j].Plugin, list[j].Metric, list[j].Instance)) < 0
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return plugin.WriteStateFile(alertStateFile, data, 0644)
+}
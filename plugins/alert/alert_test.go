@@ -0,0 +1,477 @@
+package alert
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+// fakeStore serves settable latest metrics and records writes.
+type fakeStore struct {
+	store.Store
+	latest  map[string][]store.MetricRecord
+	written []store.MetricRecord
+}
+
+func (s *fakeStore) LatestMetrics(ctx context.Context, hostKey string) ([]store.MetricRecord, error) {
+	return s.latest[hostKey], nil
+}
+
+func (s *fakeStore) WriteBatch(ctx context.Context, records []store.MetricRecord) error {
+	s.written = append(s.written, records...)
+	return nil
+}
+
+// useConfig points the configuration file and the state directory at a temp
+// directory and writes cfg.
+func useConfig(t *testing.T, cfg string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(plugin.EnvStateDir, dir)
+	old := plugin.ConfigFile
+	plugin.ConfigFile = filepath.Join(dir, "config.json")
+	t.Cleanup(func() {
+		plugin.ConfigFile = old
+		plugin.LoadPaths()
+	})
+	plugin.LoadPaths()
+	if err := os.WriteFile(plugin.ConfigFile, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// webhook records the notifications POSTed to it.
+type webhook struct {
+	*httptest.Server
+	mu       sync.Mutex
+	received []notification
+	headers  []http.Header
+}
+
+func newWebhook(t *testing.T, code int) *webhook {
+	w := &webhook{}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		w.mu.Lock()
+		w.received = append(w.received, n)
+		w.headers = append(w.headers, r.Header.Clone())
+		w.mu.Unlock()
+		rw.WriteHeader(code)
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *webhook) notifications() []notification {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]notification(nil), w.received...)
+}
+
+func (w *webhook) summaries() []string {
+	var out []string
+	for _, n := range w.notifications() {
+		out = append(out, n.Summary)
+	}
+	return out
+}
+
+func newPlugin(st store.Store) *alertPlugin {
+	c := plugin.NewController()
+	c.Store = st
+	p := &alertPlugin{}
+	p.Init(c)
+	return p
+}
+
+func TestEvaluateNotifiesAndRecords(t *testing.T) {
+	hook := newWebhook(t, http.StatusOK)
+	useConfig(t, fmt.Sprintf(`{"config_version": 1,
+		"hosts": {"web1": {"name": "Web server", "address": "192.0.2.10"}},
+		"alert": {
+			"channels": {"ops": {"type": "webhook", "url": %q, "headers": {"Authorization": "Bearer t0ken"}}},
+			"rules": [{"name": "web_down", "host": "web*", "plugin": "ping", "metric": "status", "op": "not_up",
+				"for": "2m", "severity": "critical", "channels": ["ops"]}]}}`, hook.URL))
+
+	down := store.MetricRecord{HostKey: "web1", HostName: "Web server", HostAddress: "192.0.2.10", Plugin: "ping", Name: "status", MetricType: "status", Value: "down"}
+	st := &fakeStore{latest: map[string][]store.MetricRecord{"web1": {down}}}
+	p := newPlugin(st)
+
+	// evaluate takes the time to judge the for-duration by, so the
+	// evaluations below run on a fake clock.
+	if err := p.evaluate([]string{"web1"}, start); err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.summaries()) != 0 || len(st.written) != 0 {
+		t.Fatalf("notified while pending: %q, %+v", hook.summaries(), st.written)
+	}
+	// A new plugin instance (a restart) picks the pending state up from the state file.
+	p = newPlugin(st)
+	if err := p.evaluate([]string{"web1"}, start.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	st.latest["web1"] = []store.MetricRecord{{HostKey: "web1", HostName: "Web server", Plugin: "ping", Name: "status", MetricType: "status", Value: "up"}}
+	if err := p.evaluate([]string{"web1"}, start.Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"[CRITICAL] web_down on web1: status is not up (value down)",
+		"[RESOLVED] web_down on web1",
+	}
+	if got := hook.summaries(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("notifications %q, want %q", got, want)
+	}
+	received := hook.notifications()
+	hook.mu.Lock()
+	header := hook.headers[0]
+	hook.mu.Unlock()
+	if fired := received[0]; fired.State != "firing" || fired.HostName != "Web server" || fired.Address != "192.0.2.10" || fired.Since != "2024-05-01T12:02:00Z" ||
+		header.Get("Authorization") != "Bearer t0ken" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("firing notification = %+v, headers %v", fired, header)
+	}
+	if received[1].State != "resolved" || received[1].Value != "up" {
+		t.Errorf("resolved notification = %+v", received[1])
+	}
+
+	if len(st.written) != 2 {
+		t.Fatalf("alert records = %+v", st.written)
+	}
+	for i, want := range []string{"down", "up"} {
+		r := st.written[i]
+		if r.Plugin != "alert" || r.Name != "web_down" || r.HostKey != "web1" || r.MetricType != "status" || r.Value != want ||
+			r.Extra["metric"] != "ping/status" || !r.CollectedAt.Equal(start.Add(time.Duration(2+3*i)*time.Minute)) {
+			t.Errorf("record %d = %+v", i, r)
+		}
+	}
+	if states := loadState(); len(states) != 0 {
+		t.Errorf("state after resolving = %v", states)
+	}
+}
+
+func TestCollectionDoneEventEvaluates(t *testing.T) {
+	hook := newWebhook(t, http.StatusOK)
+	useConfig(t, fmt.Sprintf(`{"config_version": 1, "alert": {
+		"channels": {"ops": {"type": "webhook", "url": %q}},
+		"rules": [{"name": "load", "metric": "load1", "op": ">", "value": "4", "channels": ["ops"]}]}}`, hook.URL))
+	st := &fakeStore{latest: map[string][]store.MetricRecord{"db1": {gauge("db1", "load1", "", 6.5)}}}
+	p := newPlugin(st)
+	p.Controller.Publish(plugin.Event{Topic: plugin.EventCollectionDone, Data: []string{"db1"}})
+	if got := hook.summaries(); len(got) != 1 || got[0] != "[WARNING] load on db1: load1 > 4 (value 6.5)" {
+		t.Errorf("notifications = %q", got)
+	}
+	if len(st.written) != 1 || st.written[0].Value != "warning" {
+		t.Errorf("records = %+v", st.written)
+	}
+}
+
+func TestRepeatIsNotRecorded(t *testing.T) {
+	hook := newWebhook(t, http.StatusOK)
+	useConfig(t, fmt.Sprintf(`{"config_version": 1, "alert": {"renotify": "30m",
+		"channels": {"ops": {"type": "webhook", "url": %q}},
+		"rules": [{"name": "load", "metric": "load1", "op": ">", "value": "4", "channels": ["ops"]}]}}`, hook.URL))
+	st := &fakeStore{latest: map[string][]store.MetricRecord{"db1": {gauge("db1", "load1", "", 6.5)}}}
+	p := newPlugin(st)
+	for _, m := range []int{0, 10, 30} {
+		if err := p.evaluate([]string{"db1"}, start.Add(time.Duration(m)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if received := hook.notifications(); len(received) != 2 || received[0].Repeat || !received[1].Repeat {
+		t.Errorf("notifications = %+v", received)
+	}
+	if len(st.written) != 1 {
+		t.Errorf("records = %+v", st.written)
+	}
+}
+
+func TestMaintenanceSkipsEvaluation(t *testing.T) {
+	hook := newWebhook(t, http.StatusOK)
+	useConfig(t, fmt.Sprintf(`{"config_version": 1,
+		"hosts": {"db1": {"address": "192.0.2.20", "maintenance": [{"name": "patching", "start": "2024-05-01T12:00:00Z", "end": "2024-05-01T13:00:00Z"}]}},
+		"alert": {"channels": {"ops": {"type": "webhook", "url": %q}},
+			"rules": [{"name": "load", "metric": "load1", "op": ">", "value": "4", "channels": ["ops"]}]}}`, hook.URL))
+	st := &fakeStore{latest: map[string][]store.MetricRecord{"db1": {gauge("db1", "load1", "", 6.5)}}}
+	p := newPlugin(st)
+	if err := p.evaluate([]string{"db1"}, start.Add(30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.notifications()) != 0 {
+		t.Errorf("notified during maintenance: %q", hook.summaries())
+	}
+	if err := p.evaluate([]string{"db1"}, start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.notifications()) != 1 {
+		t.Errorf("not notified after maintenance: %q", hook.summaries())
+	}
+}
+
+func TestChannelFailuresDoNotStopEvaluation(t *testing.T) {
+	failing := newWebhook(t, http.StatusBadGateway)
+	ok := newWebhook(t, http.StatusOK)
+	useConfig(t, fmt.Sprintf(`{"config_version": 1, "alert": {
+		"channels": {"broken": {"type": "webhook", "url": %q}, "ops": {"type": "webhook", "url": %q}},
+		"rules": [{"name": "load", "metric": "load1", "op": ">", "value": "4", "channels": ["broken", "ops"]}]}}`, failing.URL, ok.URL))
+	st := &fakeStore{latest: map[string][]store.MetricRecord{"db1": {gauge("db1", "load1", "", 6.5)}}}
+	if err := newPlugin(st).evaluate([]string{"db1"}, start); err != nil {
+		t.Fatal(err)
+	}
+	if len(failing.notifications()) != 1 || len(ok.notifications()) != 1 || len(st.written) != 1 {
+		t.Errorf("%d and %d notifications, %d records", len(failing.notifications()), len(ok.notifications()), len(st.written))
+	}
+	if err := sendWebhook(plugin.AlertChannel{URL: failing.URL}, []byte("{}")); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("sendWebhook = %v", err)
+	}
+}
+
+func TestEvaluateConfigErrors(t *testing.T) {
+	useConfig(t, `{"config_version": 1, "alert": {"rules": [{"name": "x", "metric": "m", "op": "~"}]}}`)
+	st := &fakeStore{}
+	if err := newPlugin(st).evaluate([]string{"db1"}, start); err == nil {
+		t.Error("invalid rule accepted")
+	}
+	if err := newPlugin(nil).OnCommand(map[string]string{"action": "evaluate"}); err == nil || !strings.Contains(err.Error(), "database") {
+		t.Errorf("evaluate without a store: %v", err)
+	}
+	if err := newPlugin(st).OnCommand(map[string]string{"action": "silence"}); err == nil {
+		t.Error("unknown command accepted")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	useConfig(t, `{"config_version": 1, "alert": {"rules": [
+		{"name": "load", "metric": "load1", "op": ">", "value": "4"},
+		{"name": "disk", "metric": "used_percent", "op": ">", "value": "90", "for": "10m"}]}}`)
+	st := &fakeStore{latest: map[string][]store.MetricRecord{"db1": {gauge("db1", "load1", "", 6.5), gauge("db1", "used_percent", "/var", 95)}}}
+	p := newPlugin(st)
+	if err := p.evaluate([]string{"db1"}, start); err != nil {
+		t.Fatal(err)
+	}
+	out := captureStdout(t, func() {
+		if err := p.OnCommand(map[string]string{"action": "status"}); err != nil {
+			t.Error(err)
+		}
+	})
+	since := start.Local().Format(time.RFC3339)
+	want := "--- Alerts ---\n" +
+		"  |_ pending disk on db1 /var: snmp/used_percent = 95 since " + since + "\n" +
+		"  !_ FIRING  load on db1: snmp/load1 = 6.5 since " + since + "\n"
+	if out != want {
+		t.Errorf("status:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestHostChangeNotification(t *testing.T) {
+	hook := newWebhook(t, http.StatusOK)
+	useConfig(t, fmt.Sprintf(`{"config_version": 1, "alert": {"changes": ["ops", "missing"],
+		"channels": {"ops": {"type": "webhook", "url": %q}}}}`, hook.URL))
+	p := newPlugin(&fakeStore{})
+	p.Controller.Publish(plugin.Event{Topic: plugin.EventHostChange, Data: plugin.HostChange{
+		Kind: plugin.HostServicesChanged, Address: "192.0.2.30", Services: []string{"ssh", "https"},
+		Added: []string{"https"}, Removed: []string{"http"}, At: start,
+	}})
+	received := hook.notifications()
+	if len(received) != 1 {
+		t.Fatalf("notifications = %+v", received)
+	}
+	n := received[0]
+	if n.State != "event" || n.Value != plugin.HostServicesChanged || n.Summary != "[CHANGE] host 192.0.2.30 services changed: +https -http" ||
+		n.Condition != "services: ssh, https" {
+		t.Errorf("change notification = %+v", n)
+	}
+	if n := changeNotification(plugin.HostChange{Kind: plugin.HostAppeared, Address: "192.0.2.31"}); n.Summary != "[CHANGE] host 192.0.2.31 appeared" {
+		t.Errorf("appeared = %q", n.Summary)
+	}
+}
+
+func TestExecChannel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "notify.sh")
+	out := filepath.Join(dir, "out.json")
+	os.WriteFile(script, []byte("#!/bin/sh\ncat > \"$1\"\n"), 0755)
+	failing := filepath.Join(dir, "fail.sh")
+	os.WriteFile(failing, []byte("#!/bin/sh\necho 'pager unreachable' >&2\nexit 3\n"), 0755)
+
+	n := notification{State: "firing", Rule: "load", Summary: "s"}
+	if err := send(plugin.AlertChannel{Type: "exec", Command: script, Args: []string{out}}, plugin.AlertSMTPConfig{}, n); err != nil {
+		t.Fatal(err)
+	}
+	var got notification
+	data, _ := os.ReadFile(out)
+	if err := json.Unmarshal(data, &got); err != nil || got != n {
+		t.Errorf("stdin = %s (%v)", data, err)
+	}
+	if err := send(plugin.AlertChannel{Type: "exec", Command: failing}, plugin.AlertSMTPConfig{}, n); err == nil || !strings.Contains(err.Error(), "pager unreachable") {
+		t.Errorf("failing command: %v", err)
+	}
+	if err := send(plugin.AlertChannel{Type: "exec"}, plugin.AlertSMTPConfig{}, n); err == nil {
+		t.Error("exec channel without a command accepted")
+	}
+	if err := send(plugin.AlertChannel{Type: "pager"}, plugin.AlertSMTPConfig{}, n); err == nil {
+		t.Error("unknown channel type accepted")
+	}
+}
+
+// smtpServer accepts one message per connection, with AUTH PLAIN, and
+// records the envelope and data.
+type smtpServer struct {
+	addr string
+	mu   sync.Mutex
+	log  []string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &smtpServer{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	io.WriteString(conn, "220 mail.example.com ESMTP\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO":
+			io.WriteString(conn, "250-mail.example.com\r\n250 AUTH PLAIN\r\n")
+		case "AUTH", "MAIL", "RCPT":
+			s.record(line)
+			if cmd == "AUTH" {
+				io.WriteString(conn, "235 ok\r\n")
+			} else {
+				io.WriteString(conn, "250 ok\r\n")
+			}
+		case "DATA":
+			io.WriteString(conn, "354 go ahead\r\n")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.record(data.String())
+			io.WriteString(conn, "250 queued\r\n")
+		case "QUIT":
+			io.WriteString(conn, "221 bye\r\n")
+			return
+		default:
+			io.WriteString(conn, "502 unsupported\r\n")
+		}
+	}
+}
+
+func (s *smtpServer) record(line string) {
+	s.mu.Lock()
+	s.log = append(s.log, line)
+	s.mu.Unlock()
+}
+
+func TestEmailChannel(t *testing.T) {
+	srv := newSMTPServer(t)
+	cfg := plugin.AlertSMTPConfig{Server: srv.addr, From: "nord@example.com", Username: "nord", Password: "secret"}
+	ch := plugin.AlertChannel{Type: "email", To: []string{"ops@example.com", "oncall@example.com"}}
+	n := notification{State: "firing", Rule: "load", Host: "db1", Plugin: "snmp", Metric: "load1", Value: "6.5", Condition: "load1 > 4",
+		Summary: "[WARNING] load on db1: load1 > 4 (value 6.5)"}
+	// net/smtp only sends credentials over TLS or to localhost.
+	cfg.Server = strings.Replace(srv.addr, "127.0.0.1", "localhost", 1)
+	if err := send(ch, cfg, n); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	log := srv.log
+	srv.mu.Unlock()
+	if len(log) != 5 || !strings.HasPrefix(log[0], "AUTH PLAIN ") || log[1] != "MAIL FROM:<nord@example.com>" ||
+		log[2] != "RCPT TO:<ops@example.com>" || log[3] != "RCPT TO:<oncall@example.com>" {
+		t.Fatalf("session = %q", log)
+	}
+	data := log[4]
+	for _, want := range []string{
+		"To: ops@example.com, oncall@example.com\r\n",
+		"Subject: nord: [WARNING] load on db1: load1 > 4 (value 6.5)\r\n",
+		"Metric:    snmp/load1 \r\n",
+		`"rule":"load"`,
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message lacks %q:\n%s", want, data)
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		ch  plugin.AlertChannel
+		cfg plugin.AlertSMTPConfig
+	}{
+		"no server":     {ch, plugin.AlertSMTPConfig{From: "nord@example.com"}},
+		"no recipients": {plugin.AlertChannel{Type: "email"}, cfg},
+		"no port":       {ch, plugin.AlertSMTPConfig{Server: "localhost", From: "nord@example.com"}},
+	} {
+		if err := send(tc.ch, tc.cfg, n); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+// captureStdout returns what fn prints.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
@@ -0,0 +1,236 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os/exec"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+)
+
+const notifyTimeout = 30 * time.Second
+
+// notification is the JSON sent to webhooks and exec channels.
+type notification struct {
+	State     string `json:"state"` // "firing", "resolved", or "event" for a host change
+	Repeat    bool   `json:"repeat,omitempty"`
+	Rule      string `json:"rule"`
+	Severity  string `json:"severity"`
+	Host      string `json:"host"`
+	HostName  string `json:"host_name,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Plugin    string `json:"plugin"`
+	Metric    string `json:"metric"`
+	Instance  string `json:"instance,omitempty"`
+	Value     string `json:"value"`
+	Condition string `json:"condition"`
+	Since     string `json:"since"`
+	At        string `json:"at"`
+	Summary   string `json:"summary"`
+}
+
+func newNotification(t transition) notification {
+	n := notification{
+		State:     kindFiring,
+		Repeat:    t.Kind == kindRepeat,
+		Rule:      t.Rule.Name,
+		Severity:  t.Rule.Severity,
+		Host:      t.State.Host,
+		HostName:  t.HostName,
+		Address:   t.Address,
+		Plugin:    t.State.Plugin,
+		Metric:    t.State.Metric,
+		Instance:  t.State.Instance,
+		Value:     t.State.Value,
+		Condition: t.Rule.condition(),
+		Since:     t.State.FiredAt.UTC().Format(time.RFC3339),
+		At:        t.At.UTC().Format(time.RFC3339),
+	}
+	if t.Kind == kindResolved {
+		n.State = kindResolved
+	}
+	n.Summary = summary(n)
+	return n
+}
+
+// changeNotification describes a perception host change in the same JSON as
+// alerts, as state "event" of the built-in "host_change" rule, so existing
+// webhook receivers can route it. Value is the kind of change.
+func changeNotification(c plugin.HostChange) notification {
+	n := notification{
+		State:     kindEvent,
+		Rule:      "host_change",
+		Severity:  "info",
+		Host:      c.Address,
+		Address:   c.Address,
+		Plugin:    "network",
+		Metric:    "host_change",
+		Value:     c.Kind,
+		Condition: "services: " + strings.Join(c.Services, ", "),
+		Since:     c.At.UTC().Format(time.RFC3339),
+		At:        c.At.UTC().Format(time.RFC3339),
+	}
+	target := "host " + c.Address
+	switch c.Kind {
+	case plugin.HostServicesChanged:
+		var parts []string
+		for _, s := range c.Added {
+			parts = append(parts, "+"+s)
+		}
+		for _, s := range c.Removed {
+			parts = append(parts, "-"+s)
+		}
+		n.Summary = fmt.Sprintf("[CHANGE] %s services changed: %s", target, strings.Join(parts, " "))
+	default:
+		n.Summary = fmt.Sprintf("[CHANGE] %s %s", target, c.Kind)
+	}
+	return n
+}
+
+// summary is the one-line description used in logs and email subjects.
+func summary(n notification) string {
+	target := n.Host
+	if n.Instance != "" {
+		target += " " + n.Instance
+	}
+	if n.State == kindResolved {
+		return fmt.Sprintf("[RESOLVED] %s on %s", n.Rule, target)
+	}
+	return fmt.Sprintf("[%s] %s on %s: %s (value %s)", strings.ToUpper(n.Severity), n.Rule, target, n.Condition, n.Value)
+}
+
+// send delivers n to one channel.
+func send(ch plugin.AlertChannel, smtpCfg plugin.AlertSMTPConfig, n notification) error {
+	body, _ := json.Marshal(n)
+	switch ch.Type {
+	case "webhook":
+		return sendWebhook(ch, body)
+	case "email":
+		return sendEmail(ch, smtpCfg, n, body)
+	case "exec":
+		return sendExec(ch, body)
+	}
+	return fmt.Errorf("unknown channel type %q", ch.Type)
+}
+
+func sendWebhook(ch plugin.AlertChannel, body []byte) error {
+	if ch.URL == "" {
+		return errors.New("webhook channel has no url")
+	}
+	req, err := http.NewRequest(http.MethodPost, ch.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range ch.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Timeout: notifyTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+func sendEmail(ch plugin.AlertChannel, cfg plugin.AlertSMTPConfig, n notification, body []byte) error {
+	if cfg.Server == "" || cfg.From == "" {
+		return errors.New("email channel needs alert.smtp.server and alert.smtp.from")
+	}
+	if len(ch.To) == 0 {
+		return errors.New("email channel has no recipients")
+	}
+	host, _, err := net.SplitHostPort(cfg.Server)
+	if err != nil {
+		return fmt.Errorf("alert.smtp.server must be host:port: %w", err)
+	}
+	conn, err := net.DialTimeout("tcp", cfg.Server, notifyTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(notifyTimeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if cfg.StartTLS {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, to := range ch.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: nord: %s\r\nDate: %s\r\n",
+		cfg.From, strings.Join(ch.To, ", "), n.Summary, time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n", n.Summary)
+	fmt.Fprintf(&msg, "Host:      %s %s\r\nMetric:    %s/%s %s\r\nValue:     %s\r\nCondition: %s\r\nSince:     %s\r\n\r\n",
+		n.Host, n.Address, n.Plugin, n.Metric, n.Instance, n.Value, n.Condition, n.Since)
+	msg.Write(body)
+	msg.WriteString("\r\n")
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// sendExec runs the channel's command directly, without a shell, with the
+// notification on stdin.
+func sendExec(ch plugin.AlertChannel, body []byte) error {
+	if ch.Command == "" {
+		return errors.New("exec channel has no command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ch.Command, ch.Args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, truncate(msg, 200))
+		}
+		return err
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,292 @@
+package alert
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+const defaultRenotify = time.Hour
+
+// rule is an AlertRule with its durations and pattern parsed.
+type rule struct {
+	plugin.AlertRule
+	forDur   time.Duration
+	renotify time.Duration
+	num      *float64       // Value as a number, for the ordering operators
+	re       *regexp.Regexp // for "regex"
+}
+
+// compileRules checks the configured rules. Channels must name configured
+// channels so a typo is reported at load rather than when the alert fires.
+func compileRules(cfg plugin.AlertConfig) ([]*rule, error) {
+	renotify := defaultRenotify
+	if cfg.Renotify != "" {
+		d, err := time.ParseDuration(cfg.Renotify)
+		if err != nil {
+			return nil, fmt.Errorf("alert: invalid renotify %q: %w", cfg.Renotify, err)
+		}
+		renotify = d
+	}
+	var rules []*rule
+	seen := make(map[string]bool)
+	for i, ar := range cfg.Rules {
+		if ar.Name == "" {
+			return nil, fmt.Errorf("alert: rule %d has no name", i+1)
+		}
+		if seen[ar.Name] {
+			return nil, fmt.Errorf("alert: duplicate rule name %q", ar.Name)
+		}
+		seen[ar.Name] = true
+		if ar.Metric == "" {
+			return nil, fmt.Errorf("alert: rule %q has no metric", ar.Name)
+		}
+		r := &rule{AlertRule: ar, renotify: renotify}
+		if r.Host == "" {
+			r.Host = "*"
+		}
+		for _, pattern := range []string{r.Host, r.Instance} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("alert: rule %q: bad pattern %q", ar.Name, pattern)
+			}
+		}
+		if r.Severity == "" {
+			r.Severity = "warning"
+		}
+		if r.Severity != "warning" && r.Severity != "critical" {
+			return nil, fmt.Errorf("alert: rule %q: severity must be warning or critical", ar.Name)
+		}
+		if ar.For != "" {
+			d, err := time.ParseDuration(ar.For)
+			if err != nil {
+				return nil, fmt.Errorf("alert: rule %q: invalid for %q: %w", ar.Name, ar.For, err)
+			}
+			r.forDur = d
+		}
+		if ar.Renotify != "" {
+			d, err := time.ParseDuration(ar.Renotify)
+			if err != nil {
+				return nil, fmt.Errorf("alert: rule %q: invalid renotify %q: %w", ar.Name, ar.Renotify, err)
+			}
+			r.renotify = d
+		}
+		switch ar.Op {
+		case ">", ">=", "<", "<=":
+			f, err := strconv.ParseFloat(ar.Value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("alert: rule %q: %s needs a numeric value", ar.Name, ar.Op)
+			}
+			r.num = &f
+		case "==", "!=":
+			if f, err := strconv.ParseFloat(ar.Value, 64); err == nil {
+				r.num = &f
+			}
+		case "regex":
+			re, err := regexp.Compile(ar.Value)
+			if err != nil {
+				return nil, fmt.Errorf("alert: rule %q: %w", ar.Name, err)
+			}
+			r.re = re
+		case "not_up":
+		default:
+			return nil, fmt.Errorf("alert: rule %q: unknown op %q", ar.Name, ar.Op)
+		}
+		for _, ch := range ar.Channels {
+			if _, ok := cfg.Channels[ch]; !ok {
+				return nil, fmt.Errorf("alert: rule %q: no channel %q", ar.Name, ch)
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// selects reports whether the rule applies to record r of host hostKey.
+func (r *rule) selects(hostKey string, m store.MetricRecord) bool {
+	if m.Name != r.Metric {
+		return false
+	}
+	if r.Plugin != "" && !strings.EqualFold(m.Plugin, r.Plugin) {
+		return false
+	}
+	// Alerts are recorded as metrics too; never alert on them by accident.
+	if r.Plugin == "" && m.Plugin == pluginKey {
+		return false
+	}
+	if ok, _ := path.Match(r.Host, hostKey); !ok {
+		return false
+	}
+	if r.Instance != "" {
+		if ok, _ := path.Match(r.Instance, m.Instance); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// holds reports whether m meets the rule's condition. Numeric comparisons use
+// value_num; a metric without one never meets them.
+func (r *rule) holds(m store.MetricRecord) bool {
+	switch r.Op {
+	case "not_up":
+		return !strings.EqualFold(m.Value, "up")
+	case "regex":
+		return r.re.MatchString(m.Value)
+	case "==", "!=":
+		equal := m.Value == r.Value
+		if r.num != nil && m.ValueNum != nil {
+			equal = *m.ValueNum == *r.num
+		}
+		return equal == (r.Op == "==")
+	}
+	if m.ValueNum == nil {
+		return false
+	}
+	v, t := *m.ValueNum, *r.num
+	switch r.Op {
+	case ">":
+		return v > t
+	case ">=":
+		return v >= t
+	case "<":
+		return v < t
+	case "<=":
+		return v <= t
+	}
+	return false
+}
+
+// condition describes the rule for notifications, e.g. "disk_used_percent > 90".
+func (r *rule) condition() string {
+	if r.Op == "not_up" {
+		return r.Metric + " is not up"
+	}
+	return fmt.Sprintf("%s %s %s", r.Metric, r.Op, r.Value)
+}
+
+// alertState tracks one rule on one metric between evaluations. It is kept
+// in the state file so a restart neither forgets a firing alert nor restarts
+// its for-duration.
+type alertState struct {
+	Rule         string    `json:"rule"`
+	Host         string    `json:"host"`
+	Plugin       string    `json:"plugin"`
+	Metric       string    `json:"metric"`
+	Instance     string    `json:"instance,omitempty"`
+	Value        string    `json:"value"`
+	PendingSince time.Time `json:"pending_since"`
+	Firing       bool      `json:"firing"`
+	FiredAt      time.Time `json:"fired_at,omitempty"`
+	LastNotified time.Time `json:"last_notified,omitempty"`
+}
+
+func stateKey(rule, host, plugin, metric, instance string) string {
+	return strings.Join([]string{rule, host, plugin, metric, instance}, "|")
+}
+
+// Transition kinds.
+const (
+	kindFiring   = "firing"
+	kindRepeat   = "repeat"
+	kindResolved = "resolved"
+	kindEvent    = "event" // a perception host change, not a rule transition
+)
+
+// transition is a change worth notifying about.
+type transition struct {
+	Kind     string
+	Rule     *rule
+	State    alertState
+	HostName string
+	Address  string
+	At       time.Time
+}
+
+// evaluate applies rules to the latest metrics of the hosts in latest and
+// updates states, returning the alerts that fired, are due a reminder, or
+// resolved. A condition must hold at every evaluation for the rule's
+// for-duration before the alert fires; one that clears earlier is forgotten
+// without a notification. An alert whose metric is no longer reported is
+// resolved. States of hosts not in latest are left alone, so evaluating
+// after a single-host collection does not resolve the others' alerts.
+func evaluate(rules []*rule, latest map[string][]store.MetricRecord, states map[string]*alertState, now time.Time) []transition {
+	var out []transition
+	seen := make(map[string]bool)
+	hosts := make([]string, 0, len(latest))
+	for hostKey := range latest {
+		hosts = append(hosts, hostKey)
+	}
+	sort.Strings(hosts)
+
+	for _, r := range rules {
+		for _, hostKey := range hosts {
+			for _, m := range latest[hostKey] {
+				if !r.selects(hostKey, m) {
+					continue
+				}
+				key := stateKey(r.Name, hostKey, m.Plugin, m.Name, m.Instance)
+				seen[key] = true
+				s := states[key]
+				if !r.holds(m) {
+					if s != nil && s.Firing {
+						s.Value = m.Value
+						out = append(out, transition{Kind: kindResolved, Rule: r, State: *s, HostName: m.HostName, Address: m.HostAddress, At: now})
+					}
+					delete(states, key)
+					continue
+				}
+				if s == nil {
+					s = &alertState{Rule: r.Name, Host: hostKey, Plugin: m.Plugin, Metric: m.Name, Instance: m.Instance, PendingSince: now}
+					states[key] = s
+				}
+				s.Value = m.Value
+				switch {
+				case !s.Firing && now.Sub(s.PendingSince) >= r.forDur:
+					s.Firing, s.FiredAt, s.LastNotified = true, now, now
+					out = append(out, transition{Kind: kindFiring, Rule: r, State: *s, HostName: m.HostName, Address: m.HostAddress, At: now})
+				case s.Firing && r.renotify > 0 && now.Sub(s.LastNotified) >= r.renotify:
+					s.LastNotified = now
+					out = append(out, transition{Kind: kindRepeat, Rule: r, State: *s, HostName: m.HostName, Address: m.HostAddress, At: now})
+				}
+			}
+		}
+	}
+
+	byName := make(map[string]*rule, len(rules))
+	for _, r := range rules {
+		byName[r.Name] = r
+	}
+	keys := make([]string, 0, len(states))
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := states[key]
+		if seen[key] {
+			continue
+		}
+		r, ok := byName[s.Rule]
+		if !ok {
+			// The rule was removed from the config.
+			delete(states, key)
+			continue
+		}
+		if _, evaluated := latest[s.Host]; !evaluated {
+			continue
+		}
+		if s.Firing {
+			s.Value = ""
+			out = append(out, transition{Kind: kindResolved, Rule: r, State: *s, HostName: s.Host, At: now})
+		}
+		delete(states, key)
+	}
+	return out
+}
@@ -0,0 +1,227 @@
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func num(v float64) *float64 { return &v }
+
+func gauge(host, name, instance string, v float64) store.MetricRecord {
+	return store.MetricRecord{HostKey: host, HostName: host, Plugin: "snmp", Name: name, Instance: instance,
+		MetricType: "gauge", Value: fmt.Sprint(v), ValueNum: num(v)}
+}
+
+func status(host, value string) store.MetricRecord {
+	return store.MetricRecord{HostKey: host, HostName: host, Plugin: "ping", Name: "status", MetricType: "status", Value: value}
+}
+
+func mustCompile(t *testing.T, cfg plugin.AlertConfig) []*rule {
+	t.Helper()
+	rules, err := compileRules(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+// stream feeds evaluations at times on a fake clock that starts at start.
+type stream struct {
+	rules  []*rule
+	states map[string]*alertState
+}
+
+func newStream(t *testing.T, rules ...plugin.AlertRule) *stream {
+	return &stream{rules: mustCompile(t, plugin.AlertConfig{Rules: rules}), states: make(map[string]*alertState)}
+}
+
+// at evaluates the records at start+offset and describes the transitions as
+// "<kind> <rule> <host>[ <instance>] <value>".
+func (s *stream) at(offset time.Duration, records ...store.MetricRecord) []string {
+	latest := make(map[string][]store.MetricRecord)
+	for _, r := range records {
+		latest[r.HostKey] = append(latest[r.HostKey], r)
+	}
+	var out []string
+	for _, t := range evaluate(s.rules, latest, s.states, start.Add(offset)) {
+		target := t.State.Host
+		if t.State.Instance != "" {
+			target += " " + t.State.Instance
+		}
+		out = append(out, fmt.Sprintf("%s %s %s %s", t.Kind, t.Rule.Name, target, t.State.Value))
+	}
+	return out
+}
+
+func expect(t *testing.T, when string, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Errorf("%s: transitions %q, want %q", when, got, want)
+	}
+}
+
+func TestFireAfterForAndResolve(t *testing.T) {
+	s := newStream(t, plugin.AlertRule{Name: "cpu_high", Metric: "cpu", Op: ">", Value: "90", For: "5m"})
+
+	expect(t, "first breach", s.at(0, gauge("web1", "cpu", "", 95)))
+	if st := s.states["cpu_high|web1|snmp|cpu|"]; st == nil || st.Firing || !st.PendingSince.Equal(start) {
+		t.Fatalf("pending state = %+v", st)
+	}
+	expect(t, "still pending", s.at(4*time.Minute, gauge("web1", "cpu", "", 97)))
+	expect(t, "for elapsed", s.at(5*time.Minute, gauge("web1", "cpu", "", 98)), "firing cpu_high web1 98")
+	expect(t, "already firing", s.at(6*time.Minute, gauge("web1", "cpu", "", 99)))
+	expect(t, "back to normal", s.at(7*time.Minute, gauge("web1", "cpu", "", 40)), "resolved cpu_high web1 40")
+	if len(s.states) != 0 {
+		t.Errorf("states after resolving = %v", s.states)
+	}
+	expect(t, "normal", s.at(8*time.Minute, gauge("web1", "cpu", "", 40)))
+}
+
+func TestFlapSuppression(t *testing.T) {
+	s := newStream(t, plugin.AlertRule{Name: "cpu_high", Metric: "cpu", Op: ">", Value: "90", For: "5m"})
+
+	// A condition that clears before the for-duration is forgotten, so a
+	// flapping metric never fires and the pending time restarts.
+	for i, v := range []float64{95, 20, 95, 20, 95} {
+		expect(t, fmt.Sprintf("flap %d", i), s.at(time.Duration(i)*2*time.Minute, gauge("web1", "cpu", "", v)))
+	}
+	expect(t, "4m into the last breach", s.at(12*time.Minute, gauge("web1", "cpu", "", 95)))
+	expect(t, "5m into the last breach", s.at(13*time.Minute, gauge("web1", "cpu", "", 95)), "firing cpu_high web1 95")
+
+	// Once firing, each breach is deduplicated until the renotify interval.
+	for m := 14; m < 73; m += 10 {
+		expect(t, fmt.Sprintf("firing at %dm", m), s.at(time.Duration(m)*time.Minute, gauge("web1", "cpu", "", 96)))
+	}
+	expect(t, "an hour after firing", s.at(73*time.Minute, gauge("web1", "cpu", "", 96)), "repeat cpu_high web1 96")
+	expect(t, "after the reminder", s.at(80*time.Minute, gauge("web1", "cpu", "", 96)))
+}
+
+func TestRenotifyInterval(t *testing.T) {
+	rules := mustCompile(t, plugin.AlertConfig{Renotify: "10m", Rules: []plugin.AlertRule{
+		{Name: "default", Metric: "cpu", Op: ">", Value: "90"},
+		{Name: "never", Metric: "cpu", Op: ">", Value: "90", Renotify: "0"},
+	}})
+	s := &stream{rules: rules, states: make(map[string]*alertState)}
+	expect(t, "breach", s.at(0, gauge("web1", "cpu", "", 95)), "firing default web1 95", "firing never web1 95")
+	expect(t, "10m later", s.at(10*time.Minute, gauge("web1", "cpu", "", 95)), "repeat default web1 95")
+	expect(t, "15m later", s.at(15*time.Minute, gauge("web1", "cpu", "", 95)))
+	expect(t, "20m later", s.at(20*time.Minute, gauge("web1", "cpu", "", 95)), "repeat default web1 95")
+}
+
+func TestAlertsPerInstanceAndHost(t *testing.T) {
+	s := newStream(t, plugin.AlertRule{Name: "disk_full", Host: "db*", Metric: "used_percent", Instance: "/var*", Op: ">=", Value: "90", Severity: "critical"})
+	expect(t, "breach", s.at(0,
+		gauge("db1", "used_percent", "/var", 91),
+		gauge("db1", "used_percent", "/var-ssd", 95),
+		gauge("db1", "used_percent", "/var/lib", 99),
+		gauge("db1", "used_percent", "/", 99),
+		gauge("db2", "used_percent", "/var", 90),
+		gauge("web1", "used_percent", "/var", 99),
+	), "firing disk_full db1 /var 91", "firing disk_full db1 /var-ssd 95", "firing disk_full db2 /var 90")
+	expect(t, "one instance clears", s.at(time.Minute,
+		gauge("db1", "used_percent", "/var", 91),
+		gauge("db1", "used_percent", "/var-ssd", 50),
+		gauge("db2", "used_percent", "/var", 90),
+	), "resolved disk_full db1 /var-ssd 50")
+}
+
+func TestMissingMetricResolves(t *testing.T) {
+	s := newStream(t, plugin.AlertRule{Name: "down", Plugin: "ping", Metric: "status", Op: "not_up"})
+	expect(t, "down", s.at(0, status("web1", "down"), status("web2", "down")), "firing down web1 down", "firing down web2 down")
+
+	// web2 is not evaluated (a single-host collection), so its alert stays.
+	expect(t, "web1 only, metric gone", s.at(time.Minute, gauge("web1", "cpu", "", 1)), "resolved down web1 ")
+	if s.states["down|web2|ping|status|"] == nil {
+		t.Error("web2's alert was dropped although web2 was not evaluated")
+	}
+	expect(t, "web2 up", s.at(2*time.Minute, status("web2", "up")), "resolved down web2 up")
+}
+
+func TestRemovedRuleForgetsState(t *testing.T) {
+	s := newStream(t, plugin.AlertRule{Name: "down", Metric: "status", Op: "not_up"})
+	expect(t, "down", s.at(0, status("web1", "down")), "firing down web1 down")
+	s.rules = mustCompile(t, plugin.AlertConfig{Rules: []plugin.AlertRule{{Name: "other", Metric: "status", Op: "regex", Value: "^x"}}})
+	expect(t, "rule removed", s.at(time.Minute, status("web1", "down")))
+	if len(s.states) != 0 {
+		t.Errorf("states = %v", s.states)
+	}
+}
+
+func TestHolds(t *testing.T) {
+	text := store.MetricRecord{Value: "degraded"}
+	for _, tc := range []struct {
+		op, value string
+		m         store.MetricRecord
+		want      bool
+	}{
+		{">", "90", gauge("h", "m", "", 90.5), true},
+		{">", "90", gauge("h", "m", "", 90), false},
+		{">=", "90", gauge("h", "m", "", 90), true},
+		{"<", "10", gauge("h", "m", "", 9), true},
+		{"<=", "10", gauge("h", "m", "", 11), false},
+		{">", "90", text, false},
+		{"==", "1", store.MetricRecord{Value: "1.0", ValueNum: num(1)}, true},
+		{"==", "degraded", text, true},
+		{"!=", "degraded", text, false},
+		{"!=", "0", gauge("h", "m", "", 3), true},
+		{"regex", "^deg", text, true},
+		{"regex", "^ok$", text, false},
+		{"not_up", "", status("h", "UP"), false},
+		{"not_up", "", status("h", "warning"), true},
+	} {
+		rules := mustCompile(t, plugin.AlertConfig{Rules: []plugin.AlertRule{{Name: "r", Metric: "m", Op: tc.op, Value: tc.value}}})
+		if got := rules[0].holds(tc.m); got != tc.want {
+			t.Errorf("%s %s on %q: %v, want %v", tc.op, tc.value, tc.m.Value, got, tc.want)
+		}
+	}
+}
+
+func TestSelects(t *testing.T) {
+	rules := mustCompile(t, plugin.AlertConfig{Rules: []plugin.AlertRule{
+		{Name: "any", Metric: "status", Op: "not_up"},
+		{Name: "ping", Plugin: "PING", Metric: "status", Op: "not_up"},
+	}})
+	all, ping := rules[0], rules[1]
+	if !all.selects("web1", status("web1", "down")) || all.selects("web1", gauge("web1", "cpu", "", 1)) {
+		t.Error("metric name not matched")
+	}
+	if all.selects("web1", store.MetricRecord{Plugin: pluginKey, Name: "status"}) {
+		t.Error("a rule without a plugin selected an alert record")
+	}
+	if !ping.selects("web1", status("web1", "down")) || ping.selects("web1", store.MetricRecord{Plugin: "http", Name: "status"}) {
+		t.Error("plugin not matched case-insensitively")
+	}
+}
+
+func TestCompileRulesErrors(t *testing.T) {
+	channels := map[string]plugin.AlertChannel{"ops": {Type: "webhook", URL: "http://example.com"}}
+	for name, cfg := range map[string]plugin.AlertConfig{
+		"no name":       {Rules: []plugin.AlertRule{{Metric: "m", Op: "not_up"}}},
+		"duplicate":     {Rules: []plugin.AlertRule{{Name: "a", Metric: "m", Op: "not_up"}, {Name: "a", Metric: "m", Op: "not_up"}}},
+		"no metric":     {Rules: []plugin.AlertRule{{Name: "a", Op: "not_up"}}},
+		"bad host":      {Rules: []plugin.AlertRule{{Name: "a", Host: "[", Metric: "m", Op: "not_up"}}},
+		"bad severity":  {Rules: []plugin.AlertRule{{Name: "a", Metric: "m", Op: "not_up", Severity: "page"}}},
+		"bad for":       {Rules: []plugin.AlertRule{{Name: "a", Metric: "m", Op: "not_up", For: "5"}}},
+		"bad renotify":  {Renotify: "hourly", Rules: []plugin.AlertRule{{Name: "a", Metric: "m", Op: "not_up"}}},
+		"not numeric":   {Rules: []plugin.AlertRule{{Name: "a", Metric: "m", Op: ">", Value: "high"}}},
+		"bad regex":     {Rules: []plugin.AlertRule{{Name: "a", Metric: "m", Op: "regex", Value: "("}}},
+		"unknown op":    {Rules: []plugin.AlertRule{{Name: "a", Metric: "m", Op: "~"}}},
+		"unknown chan":  {Channels: channels, Rules: []plugin.AlertRule{{Name: "a", Metric: "m", Op: "not_up", Channels: []string{"pager"}}}},
+		"rule renotify": {Rules: []plugin.AlertRule{{Name: "a", Metric: "m", Op: "not_up", Renotify: "x"}}},
+	} {
+		if _, err := compileRules(cfg); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	rules := mustCompile(t, plugin.AlertConfig{Channels: channels, Rules: []plugin.AlertRule{{Name: "a", Metric: "m", Op: "not_up", Channels: []string{"ops"}}}})
+	if r := rules[0]; r.Host != "*" || r.Severity != "warning" || r.renotify != defaultRenotify || r.forDur != 0 {
+		t.Errorf("defaults = %+v", r)
+	}
+}
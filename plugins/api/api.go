@@ -2,6 +2,9 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,10 +12,33 @@ import (
 	"net/url"
 	"observer/base"
 	"observer/plugins"
+	"observer/store"
 	"strings"
 	"time"
 )
 
+// deliveryStateFile, in the state directory, persists per-destination failure counters between runs.
+const deliveryStateFile = "api_state.json"
+
+// contentHashHeader carries the payload's hash, so receivers can dedupe too.
+const contentHashHeader = "X-Nord-Content-Hash"
+
+// deliveryState tracks remote sync health across invocations.
+type deliveryState struct {
+	Destinations map[string]destinationState `json:"destinations"`
+}
+
+// destinationState holds the counters kept for a single destination, and
+// what it last accepted: the hash of the payload and of the destination's
+// settings at the time.
+type destinationState struct {
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastSend            time.Time `json:"last_send"`
+	ContentHash         string    `json:"content_hash,omitempty"`
+	ConfigHash          string    `json:"config_hash,omitempty"`
+	LastDelivered       time.Time `json:"last_delivered,omitempty"`
+}
+
 // --- Plugin Implementation ---
 
 type apiPlugin struct {
@@ -27,19 +53,30 @@ func (p *apiPlugin) Name() string {
 	return "Api"
 }
 
+// OnCommand handles "send". With force=true every active destination gets
+// the payload, changed or not.
 func (p *apiPlugin) OnCommand(args map[string]string) error {
 	action := args["action"]
 	if action == "send" {
-		return p.sendRemoteData()
+		force := false
+		for _, kv := range strings.Fields(args["args"]) {
+			if k, v, _ := strings.Cut(kv, "="); k == "force" {
+				force = v == "" || v == "true" || v == "1"
+			}
+		}
+		return p.sendRemoteData(force)
 	}
 	return fmt.Errorf("unknown command for Api plugin: %s", action)
 }
 
-func (p *apiPlugin) sendRemoteData() error {
-	fmt.Println("--- Sending data to remote servers ---")
+// sendRemoteData posts the collection to every active destination. A
+// destination that accepted the same payload, with the same settings, less
+// than its max_skip_age ago is skipped unless force is set.
+func (p *apiPlugin) sendRemoteData(force bool) error {
+	p.Controller.Println("--- Sending data to remote servers ---")
 
 	// 1. Load Config
-	configFile, err := ioutil.ReadFile("data/config.json")
+	configFile, err := plugin.ReadConfigFile()
 	if err != nil {
 		return fmt.Errorf("could not read config file: %w", err)
 	}
@@ -49,57 +86,242 @@ func (p *apiPlugin) sendRemoteData() error {
 	}
 
 	// 2. Load collection data
-	collectionFile, err := ioutil.ReadFile("data/collection.json")
+	results, err := plugin.ReadResults()
 	if err != nil {
-		return fmt.Errorf("could not read collection.json: %w", err)
-	}
-	var collectionData interface{}
-	if err := json.Unmarshal(collectionFile, &collectionData); err != nil {
-		return fmt.Errorf("could not parse collection.json: %w", err)
+		return err
 	}
 
+	state := loadDeliveryState()
+	var records []store.MetricRecord
+
 	// 3. Iterate destinations and send data
 	for name, dest := range config.Remote.Destinations {
 		if !dest.Active {
-			fmt.Printf("  |_ Skipping destination '%s' (inactive)\n", name)
+			p.Controller.Printf("  |_ Skipping destination '%s' (inactive)\n", name)
+			p.publishDelivery(plugin.DeliveryResult{Destination: name, Endpoint: dest.Endpoint, Status: plugin.ResultSkipped})
+			continue
+		}
+		p.Controller.Printf("  |_ Contacting destination: %s (%s)\n", name, dest.Endpoint)
+
+		start := time.Now()
+		ds := state.Destinations[name]
+		var body, hash string
+		maxAge, err := dest.SkipAge()
+		if err == nil {
+			body, hash, err = buildPayload(dest, results, config.Hosts)
+		}
+		configHash := destinationHash(dest)
+		if err == nil && !force && unchanged(ds, hash, configHash, maxAge, start) {
+			p.Controller.Printf("      |_ Payload unchanged since %s; not sent\n", ds.LastDelivered.Local().Format("2006-01-02 15:04:05"))
+			p.publishDelivery(plugin.DeliveryResult{Destination: name, Endpoint: dest.Endpoint, Status: plugin.ResultSkipped, Bytes: len(body)})
 			continue
 		}
-		fmt.Printf("  |_ Contacting destination: %s (%s)\n", name, dest.Endpoint)
 
-		if err := p.sendDataToDestination(dest, collectionData, config.Hosts); err != nil {
-			fmt.Printf("      !_ Error: %v\n", err)
+		if err == nil {
+			err = p.sendDataToDestination(dest, body, hash)
+		}
+		payloadBytes := len(body)
+		elapsed := time.Since(start)
+
+		ds.LastSend = start
+		if err != nil {
+			ds.ConsecutiveFailures++
+			p.Controller.Printf("      !_ Error: %v\n", err)
+			p.Controller.Warn("api", fmt.Sprintf("destination '%s': %v", name, err))
 		} else {
-			fmt.Println("      |_ Success.")
+			ds.ConsecutiveFailures = 0
+			p.Controller.Println("      |_ Success.")
+		}
+		// Only a 2xx counts as delivered; anything else is sent again next time.
+		if err == nil {
+			ds.ContentHash, ds.ConfigHash, ds.LastDelivered = hash, configHash, start
+		} else {
+			ds.ContentHash, ds.ConfigHash = "", ""
+		}
+		state.Destinations[name] = ds
+
+		result := plugin.DeliveryResult{Destination: name, Endpoint: dest.Endpoint, Status: plugin.ResultOK, Bytes: payloadBytes, DurationMs: elapsed.Milliseconds()}
+		if err != nil {
+			result.Status, result.Error = plugin.ResultError, err.Error()
+		}
+		p.publishDelivery(result)
+
+		records = append(records, deliveryRecords(name, err, elapsed, payloadBytes, ds.ConsecutiveFailures, start)...)
+	}
+
+	if err := saveDeliveryState(state); err != nil {
+		p.Controller.Printf("  !_ Could not save delivery state: %v\n", err)
+	}
+
+	// 4. Record delivery health under the agent's own host entry.
+	if p.Controller.Store != nil && len(records) > 0 {
+		if err := p.Controller.Store.WriteBatch(context.Background(), records); err != nil {
+			p.Controller.Printf("  !_ store: delivery metrics WriteBatch error: %v\n", err)
+		} else {
+			p.Controller.Printf("  |_ store: wrote %d delivery metric records\n", len(records))
 		}
 	}
 
 	return nil
 }
 
-func (p *apiPlugin) sendDataToDestination(dest plugin.Destination, collectionData interface{}, hostsData map[string]plugin.Host) error {
+// publishDelivery reports the outcome of one destination on the controller's event bus.
+func (p *apiPlugin) publishDelivery(result plugin.DeliveryResult) {
+	p.Controller.Publish(plugin.Event{Topic: plugin.EventDelivery, Source: "api", Data: result})
+}
+
+// deliveryRecords builds the sync-health metrics for one destination send.
+// The destination name is used as the metric instance.
+func deliveryRecords(dest string, sendErr error, elapsed time.Duration, payloadBytes, failures int, at time.Time) []store.MetricRecord {
+	status := "up"
+	extra := map[string]interface{}{}
+	if sendErr != nil {
+		status = "down"
+		extra["error"] = sendErr.Error()
+	}
+	durationMs := float64(elapsed.Milliseconds())
+	size := float64(payloadBytes)
+	failCount := float64(failures)
+
+	base := store.MetricRecord{
+		HostKey:     plugin.AgentHostKey,
+		HostName:    plugin.AgentHostName(),
+		HostAddress: plugin.AgentHostAddress,
+		Plugin:      "api",
+		Category:    "sync",
+		Instance:    dest,
+		CollectedAt: at,
+	}
+
+	statusRec := base
+	statusRec.Name = "last_send_status"
+	statusRec.MetricType = "status"
+	statusRec.Value = status
+	statusRec.ValueNum = store.ParseValueNum(status)
+	statusRec.Extra = extra
+
+	durationRec := base
+	durationRec.Name = "last_send_duration_ms"
+	durationRec.MetricType = "gauge"
+	durationRec.Value = fmt.Sprintf("%d", elapsed.Milliseconds())
+	durationRec.ValueNum = &durationMs
+	durationRec.Unit = plugin.UnitMillis
+
+	sizeRec := base
+	sizeRec.Name = "payload_bytes"
+	sizeRec.MetricType = "gauge"
+	sizeRec.Value = fmt.Sprintf("%d", payloadBytes)
+	sizeRec.ValueNum = &size
+	sizeRec.Unit = plugin.UnitBytes
+
+	failRec := base
+	failRec.Name = "consecutive_failures"
+	failRec.MetricType = "gauge"
+	failRec.Value = fmt.Sprintf("%d", failures)
+	failRec.ValueNum = &failCount
+
+	return []store.MetricRecord{statusRec, durationRec, sizeRec, failRec}
+}
+
+// loadDeliveryState reads the persisted delivery counters.
+// A missing or unreadable file yields an empty state.
+func loadDeliveryState() *deliveryState {
+	state := &deliveryState{Destinations: make(map[string]destinationState)}
+	data, err := ioutil.ReadFile(plugin.StateFile(deliveryStateFile))
+	if err != nil {
+		return state
+	}
+	if json.Unmarshal(data, state) != nil || state.Destinations == nil {
+		state.Destinations = make(map[string]destinationState)
+	}
+	return state
+}
+
+// saveDeliveryState writes the delivery counters back to disk.
+func saveDeliveryState(state *deliveryState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return plugin.WriteStateFile(deliveryStateFile, data, 0644)
+}
+
+// agentInfo identifies the sending agent and its build in the payload.
+func agentInfo() map[string]interface{} {
+	build := plugin.Build()
+	return map[string]interface{}{
+		"name":       plugin.AgentHostName(),
+		"version":    build.Version,
+		"commit":     build.Commit,
+		"build_date": build.BuildDate,
+		"go_version": build.GoVersion,
+		"platform":   build.Platform,
+	}
+}
+
+// unchanged reports whether a send can be skipped: the destination accepted
+// the same payload with the same settings less than maxAge ago.
+func unchanged(ds destinationState, hash, configHash string, maxAge time.Duration, now time.Time) bool {
+	if ds.ContentHash == "" || ds.ContentHash != hash || ds.ConfigHash != configHash {
+		return false
+	}
+	return now.Sub(ds.LastDelivered) < maxAge
+}
+
+// destinationHash is the hash of a destination's settings. Changing any of
+// them, the endpoint, token or schema for instance, sends the next payload
+// even if it is unchanged.
+func destinationHash(dest plugin.Destination) string {
+	data, _ := json.Marshal(dest)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// buildPayload encodes the form body sent to a destination and returns it
+// with its SHA-256, hex encoded. The collection is rendered in the
+// destination's schema; flat payloads say so in a "schema" field, legacy
+// ones are left as the PHP server expects them.
+func buildPayload(dest plugin.Destination, results plugin.Results, hostsData map[string]plugin.Host) (string, string, error) {
+	schema, err := plugin.ParseSchema(dest.Schema)
+	if err != nil {
+		return "", "", err
+	}
+
 	// Create the payload as expected by the PHP server
 	payload := make(map[string]interface{})
-	payload["collection"] = collectionData
+	payload["collection"] = results.Render(schema)
+	payload["agent"] = agentInfo()
+	if schema != plugin.SchemaLegacy {
+		payload["schema"] = schema
+	}
 
 	// JSON-encode the payload into a string
 	jsonPayloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal collection payload: %w", err)
+		return "", "", fmt.Errorf("failed to marshal collection payload: %w", err)
 	}
 
 	// JSON-encode the hosts data into a string
 	hostsBytes, err := json.Marshal(hostsData)
 	if err != nil {
-		return fmt.Errorf("failed to marshal hosts payload: %w", err)
+		return "", "", fmt.Errorf("failed to marshal hosts payload: %w", err)
 	}
 
 	// Build the x-www-form-urlencoded data
 	formData := url.Values{}
 	formData.Set("json_payload", string(jsonPayloadBytes))
 	formData.Set("hosts", string(hostsBytes))
+	encoded := formData.Encode()
+	sum := sha256.Sum256([]byte(encoded))
+	return encoded, hex.EncodeToString(sum[:]), nil
+}
 
+// sendDataToDestination posts an encoded payload with its hash in the
+// X-Nord-Content-Hash header. Any status but a 2xx is an error: the payload
+// was not delivered.
+func (p *apiPlugin) sendDataToDestination(dest plugin.Destination, encoded, hash string) error {
 	// Create the request
-	req, err := http.NewRequest("POST", dest.Endpoint, strings.NewReader(formData.Encode()))
+	req, err := http.NewRequest("POST", dest.Endpoint, strings.NewReader(encoded))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -107,6 +329,7 @@ func (p *apiPlugin) sendDataToDestination(dest plugin.Destination, collectionDat
 	// Set headers
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Authorization", "Bearer "+dest.Token)
+	req.Header.Set(contentHashHeader, hash)
 
 	// Send the request
 	client := &http.Client{Timeout: 15 * time.Second}
@@ -122,11 +345,10 @@ func (p *apiPlugin) sendDataToDestination(dest plugin.Destination, collectionDat
 		return fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	fmt.Printf("      |_ Server response: %s\n", string(body))
+	p.Controller.Printf("      |_ Server response: %s\n", string(body))
 
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("server returned error status: %s", resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("server returned status %s", resp.Status)
 	}
-
 	return nil
 }
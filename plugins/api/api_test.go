@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"observer/base"
+	"observer/store"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeStore records what WriteBatch receives; every other method is left to
+// the nil embedded Store and is not expected to be called.
+type fakeStore struct {
+	store.Store
+	batches [][]store.MetricRecord
+}
+
+func (f *fakeStore) WriteBatch(ctx context.Context, records []store.MetricRecord) error {
+	f.batches = append(f.batches, records)
+	return nil
+}
+
+// setup points nord at a temp directory with one destination posting to
+// endpoint and an empty collection, and returns a plugin wired to a fake store.
+func setup(t *testing.T, endpoint string) (*apiPlugin, *fakeStore) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(plugin.EnvDataDir, dir)
+	t.Setenv(plugin.EnvStateDir, dir)
+	oldConfig := plugin.ConfigFile
+	plugin.ConfigFile = filepath.Join(dir, "config.json")
+	t.Cleanup(func() {
+		plugin.ConfigFile = oldConfig
+		plugin.LoadPaths()
+	})
+	plugin.LoadPaths()
+
+	config := `{"remote": {"destinations": {"central": {"endpoint": "` + endpoint + `", "active": true, "max_skip_age": "0s"}}}}`
+	if err := os.WriteFile(plugin.ConfigFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(plugin.DataFile(plugin.ResultsFile), []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeStore{}
+	c := plugin.NewController()
+	c.Store = fake
+	p := &apiPlugin{}
+	p.Controller = c
+	return p, fake
+}
+
+// byName indexes the last batch written by metric name.
+func byName(t *testing.T, fake *fakeStore) map[string]store.MetricRecord {
+	t.Helper()
+	if len(fake.batches) == 0 {
+		t.Fatal("no delivery metrics were written")
+	}
+	out := map[string]store.MetricRecord{}
+	for _, r := range fake.batches[len(fake.batches)-1] {
+		if r.HostKey != plugin.AgentHostKey || r.HostAddress != plugin.AgentHostAddress || r.Plugin != "api" || r.Instance != "central" {
+			t.Errorf("record %s: host %q at %q plugin %q instance %q", r.Name, r.HostKey, r.HostAddress, r.Plugin, r.Instance)
+		}
+		out[r.Name] = r
+	}
+	return out
+}
+
+func TestDeliveryMetricsOnSuccess(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	p, fake := setup(t, srv.URL)
+
+	if err := p.sendRemoteData(false); err != nil {
+		t.Fatal(err)
+	}
+	got := byName(t, fake)
+	if len(got) != 4 {
+		t.Errorf("records = %+v", got)
+	}
+	if r := got["last_send_status"]; r.Value != "up" || r.MetricType != "status" || r.Extra["error"] != nil {
+		t.Errorf("last_send_status = %+v", r)
+	}
+	if r := got["consecutive_failures"]; r.Value != "0" || r.MetricType != "gauge" {
+		t.Errorf("consecutive_failures = %+v", r)
+	}
+	if r := got["payload_bytes"]; r.ValueNum == nil || *r.ValueNum <= 0 || r.Unit != plugin.UnitBytes {
+		t.Errorf("payload_bytes = %+v", r)
+	}
+	if r := got["last_send_duration_ms"]; r.Unit != plugin.UnitMillis || r.MetricType != "gauge" {
+		t.Errorf("last_send_duration_ms = %+v", r)
+	}
+}
+
+func TestDeliveryMetricsOnFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	p, fake := setup(t, srv.URL)
+
+	for i := 0; i < 2; i++ {
+		if err := p.sendRemoteData(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := byName(t, fake)
+	if r := got["last_send_status"]; r.Value != "down" || r.Extra["error"] == nil {
+		t.Errorf("last_send_status = %+v", r)
+	}
+	if r := got["consecutive_failures"]; r.Value != "2" || r.ValueNum == nil || *r.ValueNum != 2 {
+		t.Errorf("consecutive_failures = %+v", r)
+	}
+}
+
+func TestAgentInfo(t *testing.T) {
+	oldVersion, oldCommit := plugin.Version, plugin.Commit
+	plugin.Version, plugin.Commit = "1.2.0", "abc1234"
+	t.Cleanup(func() { plugin.Version, plugin.Commit = oldVersion, oldCommit })
+
+	agent := agentInfo()
+	if agent["version"] != "1.2.0" || agent["commit"] != "abc1234" || agent["go_version"] != runtime.Version() {
+		t.Errorf("agent = %v", agent)
+	}
+	if agent["platform"] != runtime.GOOS+"/"+runtime.GOARCH || agent["name"] == "" {
+		t.Errorf("agent = %v", agent)
+	}
+}
+
+func TestBuildPayloadSchemas(t *testing.T) {
+	results := plugin.Results{"sw1": {
+		Metrics: []plugin.MetricResult{
+			{Label: "if_eth0_status", Plugin: "snmp", Name: "if_status", Value: "up", Type: "status", Instance: "eth0"},
+		},
+		Collections: map[string]plugin.TaskResult{"snmp.interfaces": {Host: "sw1", Task: "snmp.interfaces", Status: plugin.ResultOK, Metrics: 1}},
+		Errors:      []string{},
+	}}
+	// decode returns the json_payload field of a form body.
+	decode := func(encoded string) map[string]interface{} {
+		form, err := url.ParseQuery(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(form.Get("json_payload")), &payload); err != nil {
+			t.Fatal(err)
+		}
+		return payload
+	}
+
+	encoded, _, err := buildPayload(plugin.Destination{}, results, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := decode(encoded)
+	if _, ok := legacy["schema"]; ok {
+		t.Error("legacy payload names its schema")
+	}
+	var m map[string]struct {
+		Metrics struct {
+			Metrics map[string]map[string]interface{}
+		}
+	}
+	data, _ := json.Marshal(legacy["collection"])
+	json.Unmarshal(data, &m)
+	if got := m["sw1"].Metrics.Metrics["if_eth0_status"]; got["value"] != "up" || got["plugin"] != nil || got["instance"] != nil {
+		t.Errorf("legacy collection = %s", data)
+	}
+
+	encoded, _, err = buildPayload(plugin.Destination{Schema: plugin.SchemaFlat}, results, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat := decode(encoded)
+	var r plugin.Results
+	data, _ = json.Marshal(flat["collection"])
+	json.Unmarshal(data, &r)
+	if flat["schema"] != plugin.SchemaFlat || len(r["sw1"].Metrics) != 1 || r["sw1"].Metrics[0].Instance != "eth0" ||
+		r["sw1"].Collections["snmp.interfaces"].Status != plugin.ResultOK {
+		t.Errorf("flat payload = %v", flat)
+	}
+
+	if _, _, err := buildPayload(plugin.Destination{Schema: "nested"}, results, nil); err == nil {
+		t.Error("unknown schema accepted")
+	}
+}
+
+func TestSkipsUnchangedPayload(t *testing.T) {
+	var (
+		requests int
+		hashes   []string
+		status   = http.StatusOK
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if got := r.Header.Get(contentHashHeader); got != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s = %q, not the body's hash", contentHashHeader, got)
+		}
+		requests++
+		hashes = append(hashes, r.Header.Get(contentHashHeader))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	p, fake := setup(t, srv.URL)
+	writeConfig := func(token string) {
+		t.Helper()
+		config := `{"remote": {"destinations": {"central": {"endpoint": "` + srv.URL + `", "token": "` + token + `", "active": true}}}}`
+		if err := os.WriteFile(plugin.ConfigFile, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeResults := func(results string) {
+		t.Helper()
+		if err := os.WriteFile(plugin.DataFile(plugin.ResultsFile), []byte(results), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send := func(force bool, wantRequests int) {
+		t.Helper()
+		if err := p.sendRemoteData(force); err != nil {
+			t.Fatal(err)
+		}
+		if requests != wantRequests {
+			t.Fatalf("%d requests, want %d", requests, wantRequests)
+		}
+	}
+	writeConfig("t1")
+
+	// Two identical sends: the second is skipped.
+	send(false, 1)
+	send(false, 1)
+	if got := byName(t, fake)["last_send_status"]; got.Value != "up" {
+		t.Errorf("after a skip, last_send_status = %+v", got)
+	}
+
+	// A changed payload goes out, with another hash.
+	writeResults(`{"sw1": {"metrics": [{"label": "cpu", "name": "cpu", "value": "12"}]}}`)
+	send(false, 2)
+	if hashes[0] == hashes[1] {
+		t.Error("changed payload sent with the same hash")
+	}
+	send(false, 2)
+
+	// force sends regardless, and so does a changed destination.
+	send(true, 3)
+	writeConfig("t2")
+	send(false, 4)
+	send(false, 4)
+
+	// A payload the server did not accept is sent again.
+	status = http.StatusAccepted
+	writeResults(`{}`)
+	send(false, 5)
+	send(false, 5)
+	status = http.StatusNotModified
+	writeResults(`{"sw1": {"metrics": []}}`)
+	send(false, 6)
+	if got := byName(t, fake)["last_send_status"]; got.Value != "down" {
+		t.Errorf("after a 304, last_send_status = %+v", got)
+	}
+	send(false, 7)
+	if ds := loadDeliveryState().Destinations["central"]; ds.ConsecutiveFailures != 2 {
+		t.Errorf("after two 304s, %d consecutive failures", ds.ConsecutiveFailures)
+	}
+
+	// A bad max_skip_age is a failed send, not an unconditional one.
+	status = http.StatusOK
+	config := `{"remote": {"destinations": {"central": {"endpoint": "` + srv.URL + `", "active": true, "max_skip_age": "soon"}}}}`
+	if err := os.WriteFile(plugin.ConfigFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	send(false, 7)
+	got := byName(t, fake)["last_send_status"]
+	if msg, _ := got.Extra["error"].(string); got.Value != "down" || !strings.Contains(msg, "max_skip_age") {
+		t.Errorf("bad max_skip_age: last_send_status = %+v", got)
+	}
+}
+
+func TestUnchanged(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ds := destinationState{ContentHash: "abc", ConfigHash: "cfg", LastDelivered: now.Add(-30 * time.Minute)}
+	for _, tt := range []struct {
+		name       string
+		ds         destinationState
+		hash, cfg  string
+		maxSkipAge string
+		want       bool
+	}{
+		{"same payload", ds, "abc", "cfg", "", true},
+		{"other payload", ds, "abd", "cfg", "", false},
+		{"other settings", ds, "abc", "cfg2", "", false},
+		{"never delivered", destinationState{}, "", "", "", false},
+		{"older than the default", destinationState{ContentHash: "abc", ConfigHash: "cfg", LastDelivered: now.Add(-2 * time.Hour)}, "abc", "cfg", "", false},
+		{"older than max_skip_age", ds, "abc", "cfg", "10m", false},
+		{"within max_skip_age", ds, "abc", "cfg", "45m", true},
+		{"0s always sends", ds, "abc", "cfg", "0s", false},
+	} {
+		maxAge, err := plugin.Destination{MaxSkipAge: tt.maxSkipAge}.SkipAge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := unchanged(tt.ds, tt.hash, tt.cfg, maxAge, now); got != tt.want {
+			t.Errorf("%s: unchanged = %v", tt.name, got)
+		}
+	}
+
+	a := destinationHash(plugin.Destination{Endpoint: "https://nord.example/api", Token: "t1"})
+	if a == destinationHash(plugin.Destination{Endpoint: "https://nord.example/api", Token: "t2"}) ||
+		a == destinationHash(plugin.Destination{Endpoint: "https://nord.example/api", Token: "t1", Schema: plugin.SchemaFlat}) {
+		t.Error("destination hash does not follow its settings")
+	}
+}
@@ -0,0 +1,221 @@
+// Package backupcheck verifies that backups exist and are recent: collect
+// tasks "backupcheck.check" find the newest backup of each configured target,
+// on this machine, on the host over SSH, or in an S3-compatible bucket, and
+// report its age and size against the target's thresholds.
+package backupcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+)
+
+const defaultTimeout = 60 * time.Second
+
+// backupCheckPlugin checks backup freshness.
+type backupCheckPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&backupCheckPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *backupCheckPlugin) Name() string {
+	return "Backupcheck"
+}
+
+// checkOptions are the per-task options of a backupcheck.check task:
+//
+//	{"metric": "backupcheck.check", "credentials": "backups-s3", "options": {
+//	    "method": "s3", "timeout_s": 60, "targets": [
+//	        {"name": "db", "bucket": "backups", "path": "db/nightly-*.sql.gz",
+//	         "max_age": "26h", "min_size": 1048576}]}}
+//
+// method is "local" (this machine's filesystem), "ssh" (the host, with the
+// task's SSH credential) or "s3" (a credential of type "s3": user is the
+// access key, pass the secret key, host the endpoint, region the signing
+// region). A target may override the task's method.
+type checkOptions struct {
+	Method   string   `json:"method"`
+	TimeoutS float64  `json:"timeout_s"`
+	Targets  []target `json:"targets"`
+}
+
+// target is one backup: the newest match of Path, a glob, must be younger
+// than MaxAge and at least MinSize bytes.
+type target struct {
+	Name    string `json:"name"`
+	Method  string `json:"method"`
+	Bucket  string `json:"bucket"` // s3 only
+	Path    string `json:"path"`
+	MaxAge  string `json:"max_age"`  // Go duration; empty disables the age check
+	MinSize int64  `json:"min_size"` // bytes; 0 disables the size check
+}
+
+// OnCollect checks every target of the task. A target that cannot be
+// checked, e.g. because the host is unreachable, is reported down with the
+// reason rather than failing the task.
+func (p *backupCheckPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	if action, _ := options["action"].(string); action != "check" {
+		return nil, fmt.Errorf("undefined backupcheck action: %s", action)
+	}
+	var opts checkOptions
+	if raw, ok := options["options"]; ok {
+		b, _ := json.Marshal(raw)
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return nil, fmt.Errorf("backupcheck: invalid options: %w", err)
+		}
+	}
+	if len(opts.Targets) == 0 {
+		return nil, errors.New("backupcheck: the task has no targets")
+	}
+	if opts.Method == "" {
+		opts.Method = "local"
+	}
+	timeout := defaultTimeout
+	if opts.TimeoutS > 0 {
+		timeout = time.Duration(opts.TimeoutS * float64(time.Second))
+	}
+	creds, _ := options["credentials"].(map[string]interface{})
+	cred := func(key string) string {
+		s, _ := creds[key].(string)
+		return s
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Connections are opened on first use and shared by the targets.
+	var ssh *sshSession
+	var sshErr error
+	var s3 *s3Client
+	var s3Err error
+	defer func() {
+		if ssh != nil {
+			ssh.close()
+		}
+	}()
+
+	now := time.Now()
+	metrics := make(map[string]interface{})
+	for i, t := range opts.Targets {
+		if t.Name == "" {
+			t.Name = strconv.Itoa(i + 1)
+		}
+		if t.Method == "" {
+			t.Method = opts.Method
+		}
+		var info fileInfo
+		var err error
+		switch t.Method {
+		case "local":
+			info, err = newestLocal(t.Path)
+		case "ssh":
+			if ssh == nil && sshErr == nil {
+				ssh, sshErr = connectSSH(options, cred)
+			}
+			if err = sshErr; err == nil {
+				info, err = ssh.newest(t.Path)
+			}
+		case "s3":
+			if s3 == nil && s3Err == nil {
+				s3, s3Err = newS3Client(cred("host"), cred("region"), cred("user"), cred("pass"), timeout)
+			}
+			if err = s3Err; err == nil {
+				info, err = s3.newest(ctx, t.Bucket, t.Path)
+			}
+		default:
+			err = fmt.Errorf("unknown method %q", t.Method)
+		}
+		for k, v := range targetMetrics(t, info, err, now) {
+			metrics[k] = v
+		}
+	}
+	return map[string]interface{}{"metrics": metrics}, nil
+}
+
+// connectSSH opens a session to the task's host with its SSH credential.
+func connectSSH(options map[string]interface{}, cred func(string) string) (*sshSession, error) {
+	address := cred("host")
+	if address == "" {
+		host, _ := options["host"].(map[string]interface{})
+		address, _ = host["address"].(string)
+	}
+	port, _ := strconv.Atoi(cred("port"))
+	if port == 0 {
+		port = 22
+	}
+	if cred("user") == "" {
+		return nil, errors.New("the ssh method needs credentials")
+	}
+	return dialSSH(cred("user"), cred("pass"), address, port)
+}
+
+// targetMetrics returns backup_age_seconds, backup_size_bytes and the
+// backup_status of one target, evaluated against its thresholds.
+func targetMetrics(t target, info fileInfo, err error, now time.Time) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	status := metric("backup_status", "Backup "+t.Name, t.Name, "status", "up")
+	status["method"] = t.Method
+	status["path"] = t.Path
+	if t.Bucket != "" {
+		status["bucket"] = t.Bucket
+	}
+	metrics["backup_status_"+t.Name] = status
+	if err != nil {
+		reason := err.Error()
+		switch {
+		case errors.Is(err, errNotFound), errors.Is(err, fs.ErrNotExist):
+			reason = "no backup found"
+		case errors.Is(err, fs.ErrPermission):
+			reason = "permission denied"
+		}
+		status["value"] = "down"
+		status["reason"] = reason
+		return metrics
+	}
+
+	age := now.Sub(info.modTime)
+	if age < 0 {
+		age = 0
+	}
+	status["file"] = info.name
+	status["modified"] = info.modTime.UTC().Format(time.RFC3339)
+	metrics["backup_age_"+t.Name] = metric("backup_age_seconds", "Backup Age (s)", t.Name, "gauge", int64(age.Seconds()))
+	metrics["backup_size_"+t.Name] = metric("backup_size_bytes", "Backup Size (bytes)", t.Name, "gauge", info.size)
+
+	if t.MaxAge != "" {
+		maxAge, err := time.ParseDuration(t.MaxAge)
+		if err != nil {
+			status["value"] = "warning"
+			status["reason"] = fmt.Sprintf("invalid max_age %q", t.MaxAge)
+		} else if age > maxAge {
+			status["value"] = "down"
+			status["reason"] = fmt.Sprintf("older than %s", t.MaxAge)
+		}
+	}
+	if t.MinSize > 0 && info.size < t.MinSize && status["value"] != "down" {
+		status["value"] = "down"
+		status["reason"] = fmt.Sprintf("smaller than %d bytes", t.MinSize)
+	}
+	return metrics
+}
+
+func metric(name, label, instance, metricType string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    label,
+		"value":    value,
+		"type":     metricType,
+		"category": "Backups",
+		"instance": instance,
+	}
+}
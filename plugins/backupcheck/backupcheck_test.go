@@ -0,0 +1,369 @@
+package backupcheck
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collect runs a backupcheck.check task.
+func collect(t *testing.T, creds map[string]interface{}, opts map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	res, err := (&backupCheckPlugin{}).OnCollect(map[string]interface{}{
+		"action": "check", "credentials": creds, "options": opts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res["metrics"].(map[string]interface{})
+}
+
+// get returns metric key of metrics, failing the test when it is missing.
+func get(t *testing.T, metrics map[string]interface{}, key string) map[string]interface{} {
+	t.Helper()
+	m, ok := metrics[key].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no metric %s in %v", key, metrics)
+	}
+	return m
+}
+
+// writeFile creates name in dir with size bytes, modified age ago.
+func writeFile(t *testing.T, dir, name string, size int, age time.Duration) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLocalMethod(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "db-2024-04-29.sql.gz", 4096, 50*time.Hour)
+	newest := writeFile(t, dir, "db-2024-04-30.sql.gz", 2048, 2*time.Hour)
+	writeFile(t, dir, "db-latest.tmp", 1, time.Minute) // does not match
+	writeFile(t, dir, "etc.tar", 100, 40*time.Hour)
+
+	metrics := collect(t, nil, map[string]interface{}{"targets": []interface{}{
+		map[string]interface{}{"name": "db", "path": filepath.Join(dir, "db-*.sql.gz"), "max_age": "26h", "min_size": 1024},
+		map[string]interface{}{"name": "etc", "path": filepath.Join(dir, "etc.tar"), "max_age": "26h"},
+		map[string]interface{}{"name": "small", "path": filepath.Join(dir, "db-*.sql.gz"), "min_size": 1 << 20},
+		map[string]interface{}{"name": "missing", "path": filepath.Join(dir, "nothing-*")},
+		map[string]interface{}{"path": filepath.Join(dir, "db-*.sql.gz")},
+	}})
+
+	db := get(t, metrics, "backup_status_db")
+	if db["value"] != "up" || db["file"] != newest || db["method"] != "local" || db["instance"] != "db" || db["type"] != "status" {
+		t.Errorf("db status = %v", db)
+	}
+	if age := get(t, metrics, "backup_age_db")["value"].(int64); age < 7190 || age > 7300 {
+		t.Errorf("db age = %d", age)
+	}
+	if size := get(t, metrics, "backup_size_db"); size["value"] != int64(2048) || size["name"] != "backup_size_bytes" {
+		t.Errorf("db size = %v", size)
+	}
+	if etc := get(t, metrics, "backup_status_etc"); etc["value"] != "down" || etc["reason"] != "older than 26h" {
+		t.Errorf("etc status = %v", etc)
+	}
+	if small := get(t, metrics, "backup_status_small"); small["value"] != "down" || small["reason"] != "smaller than 1048576 bytes" {
+		t.Errorf("small status = %v", small)
+	}
+	missing := get(t, metrics, "backup_status_missing")
+	if missing["value"] != "down" || missing["reason"] != "no backup found" {
+		t.Errorf("missing status = %v", missing)
+	}
+	if _, ok := metrics["backup_age_missing"]; ok {
+		t.Error("age reported for a missing backup")
+	}
+	if unnamed := get(t, metrics, "backup_status_5"); unnamed["instance"] != "5" || unnamed["value"] != "up" {
+		t.Errorf("unnamed target = %v", unnamed)
+	}
+}
+
+func TestLocalPermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads every directory")
+	}
+	dir := filepath.Join(t.TempDir(), "locked")
+	os.Mkdir(dir, 0755)
+	writeFile(t, dir, "a.tar", 1, 0)
+	os.Chmod(dir, 0)
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+	if _, err := newestLocal(filepath.Join(dir, "*.tar")); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	info := fileInfo{name: "b.tar", modTime: now.Add(-3 * time.Hour), size: 500}
+	for _, tc := range []struct {
+		name   string
+		target target
+		info   fileInfo
+		err    error
+		value  string
+		reason string
+	}{
+		{"fresh", target{MaxAge: "4h", MinSize: 500}, info, nil, "up", ""},
+		{"stale", target{MaxAge: "2h"}, info, nil, "down", "older than 2h"},
+		{"too small", target{MinSize: 501}, info, nil, "down", "smaller than 501 bytes"},
+		{"stale and small", target{MaxAge: "1h", MinSize: 1000}, info, nil, "down", "older than 1h"},
+		{"bad max_age", target{MaxAge: "a day"}, info, nil, "warning", `invalid max_age "a day"`},
+		{"bad max_age and small", target{MaxAge: "a day", MinSize: 1000}, info, nil, "down", "smaller than 1000 bytes"},
+		{"future", target{MaxAge: "1h"}, fileInfo{modTime: now.Add(time.Hour)}, nil, "up", ""},
+		{"not found", target{}, fileInfo{}, errNotFound, "down", "no backup found"},
+		{"permission", target{}, fileInfo{}, fs.ErrPermission, "down", "permission denied"},
+		{"other", target{}, fileInfo{}, errors.New("SSH connection failed: timeout"), "down", "SSH connection failed: timeout"},
+	} {
+		tc.target.Name = "t"
+		metrics := targetMetrics(tc.target, tc.info, tc.err, now)
+		status := metrics["backup_status_t"].(map[string]interface{})
+		reason, _ := status["reason"].(string)
+		if status["value"] != tc.value || reason != tc.reason {
+			t.Errorf("%s: %v (%s), want %s (%s)", tc.name, status["value"], reason, tc.value, tc.reason)
+		}
+		if tc.err == nil && metrics["backup_age_t"].(map[string]interface{})["value"].(int64) < 0 {
+			t.Errorf("%s: negative age", tc.name)
+		}
+	}
+}
+
+// s3Object is one object served by fakeS3.
+type s3Object struct {
+	key      string
+	modified time.Time
+	size     int64
+}
+
+// fakeS3 serves ListObjectsV2 for one bucket, pageSize keys at a time.
+type fakeS3 struct {
+	bucket   string
+	objects  []s3Object
+	pageSize int
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		return
+	}
+	if r.URL.Path != "/"+f.bucket {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<Error><Code>NoSuchBucket</Code><Message>The specified bucket does not exist</Message></Error>`)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var keys []s3Object
+	for _, o := range f.objects {
+		if strings.HasPrefix(o.key, q.Get("prefix")) {
+			keys = append(keys, o)
+		}
+	}
+	start := 0
+	if token := q.Get("continuation-token"); token != "" {
+		fmt.Sscan(token, &start)
+	}
+	end := start + f.pageSize
+	truncated := end < len(keys)
+	if !truncated {
+		end = len(keys)
+	}
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	fmt.Fprintf(w, "<Name>%s</Name><IsTruncated>%v</IsTruncated>", f.bucket, truncated)
+	if truncated {
+		fmt.Fprintf(w, "<NextContinuationToken>%d</NextContinuationToken>", end)
+	}
+	for _, o := range keys[start:end] {
+		fmt.Fprint(w, "<Contents><Key>")
+		xml.EscapeText(w, []byte(o.key))
+		fmt.Fprintf(w, "</Key><LastModified>%s</LastModified><Size>%d</Size><StorageClass>STANDARD</StorageClass></Contents>",
+			o.modified.UTC().Format("2006-01-02T15:04:05.000Z"), o.size)
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func TestS3Method(t *testing.T) {
+	now := time.Now()
+	s3 := &fakeS3{bucket: "backups", pageSize: 2, objects: []s3Object{
+		{"db/nightly-2024-04-28.sql.gz", now.Add(-74 * time.Hour), 3 << 20},
+		{"db/nightly-2024-04-30.sql.gz", now.Add(-2 * time.Hour), 2 << 20},
+		{"db/nightly-2024-04-29.sql.gz", now.Add(-26 * time.Hour), 3 << 20},
+		{"db/manual dump.sql.gz", now.Add(-time.Minute), 10},
+		{"web/site.tar", now.Add(-30 * time.Hour), 5 << 20},
+	}}
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+	creds := map[string]interface{}{"host": srv.URL, "region": "eu-west-1", "user": "AKID", "pass": "secret"}
+
+	metrics := collect(t, creds, map[string]interface{}{"method": "s3", "targets": []interface{}{
+		map[string]interface{}{"name": "db", "bucket": "backups", "path": "db/nightly-*.sql.gz", "max_age": "26h", "min_size": 1 << 20},
+		map[string]interface{}{"name": "web", "bucket": "backups", "path": "web/site.tar", "max_age": "26h"},
+		map[string]interface{}{"name": "none", "bucket": "backups", "path": "logs/*"},
+		map[string]interface{}{"name": "gone", "bucket": "archive", "path": "x"},
+	}})
+
+	db := get(t, metrics, "backup_status_db")
+	if db["value"] != "up" || db["file"] != "db/nightly-2024-04-30.sql.gz" || db["bucket"] != "backups" || db["method"] != "s3" {
+		t.Errorf("db status = %v", db)
+	}
+	if size := get(t, metrics, "backup_size_db")["value"]; size != int64(2<<20) {
+		t.Errorf("db size = %v", size)
+	}
+	if web := get(t, metrics, "backup_status_web"); web["value"] != "down" || web["reason"] != "older than 26h" {
+		t.Errorf("web status = %v", web)
+	}
+	if none := get(t, metrics, "backup_status_none"); none["value"] != "down" || none["reason"] != "no backup found" {
+		t.Errorf("none status = %v", none)
+	}
+	if gone := get(t, metrics, "backup_status_gone"); gone["value"] != "down" || gone["reason"] != "s3: NoSuchBucket: The specified bucket does not exist" {
+		t.Errorf("gone status = %v", gone)
+	}
+
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	// Three keys match db/nightly-: two pages; then web/, logs/ and the missing bucket.
+	if len(s3.requests) != 5 {
+		t.Fatalf("%d requests", len(s3.requests))
+	}
+	first := s3.requests[0]
+	if first.URL.RawQuery != "list-type=2&prefix=db%2Fnightly-" || s3.requests[1].URL.Query().Get("continuation-token") != "2" {
+		t.Errorf("queries %q, %q", first.URL.RawQuery, s3.requests[1].URL.RawQuery)
+	}
+	auth := first.Header.Get("Authorization")
+	day := first.Header.Get("X-Amz-Date")
+	if len(day) != 16 || !strings.Contains(auth, "Credential=AKID/"+day[:8]+"/eu-west-1/s3/aws4_request") ||
+		!strings.Contains(auth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date") || first.Header.Get("X-Amz-Content-Sha256") == "" {
+		t.Errorf("Authorization = %q, X-Amz-Date = %q", auth, day)
+	}
+}
+
+func TestS3AccessDenied(t *testing.T) {
+	srv := httptest.NewServer(&fakeS3{bucket: "backups", pageSize: 10})
+	defer srv.Close()
+	creds := map[string]interface{}{"host": srv.URL, "user": "OTHER", "pass": "secret"}
+	metrics := collect(t, creds, map[string]interface{}{"targets": []interface{}{
+		map[string]interface{}{"name": "db", "method": "s3", "bucket": "backups", "path": "db/*"},
+	}})
+	if db := get(t, metrics, "backup_status_db"); db["value"] != "down" || db["reason"] != "s3: AccessDenied: Access Denied" {
+		t.Errorf("db status = %v", db)
+	}
+}
+
+func TestSignatureIsDeterministic(t *testing.T) {
+	c, err := newS3Client("s3.example.com", "", "AKID", "secret", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.endpoint.String() != "https://s3.example.com" || c.region != "us-east-1" {
+		t.Errorf("endpoint %s, region %s", c.endpoint, c.region)
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	sig := func(secret, query string) string {
+		c.secretKey = secret
+		req, _ := http.NewRequest(http.MethodGet, "https://s3.example.com/backups?"+query, nil)
+		c.sign(req, at)
+		return req.Header.Get("Authorization")
+	}
+	a := sig("secret", "list-type=2&prefix=db")
+	if a != sig("secret", "list-type=2&prefix=db") {
+		t.Error("signature not deterministic")
+	}
+	if a == sig("other", "list-type=2&prefix=db") || a == sig("secret", "list-type=2&prefix=web") {
+		t.Error("signature ignores the secret or the query")
+	}
+	if !strings.HasPrefix(a, "AWS4-HMAC-SHA256 Credential=AKID/20240501/us-east-1/s3/aws4_request, ") {
+		t.Errorf("Authorization = %q", a)
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	got := canonicalQuery(map[string][]string{"prefix": {"db/a b+c"}, "list-type": {"2"}, "continuation-token": {"1/x=="}})
+	if want := "continuation-token=1%2Fx%3D%3D&list-type=2&prefix=db%2Fa%20b%2Bc"; got != want {
+		t.Errorf("canonicalQuery = %q, want %q", got, want)
+	}
+	if got := escapePath("/backups/a%20b"); got != "/backups/a%20b" {
+		t.Errorf("escapePath = %q", got)
+	}
+	if escapePath("") != "/" {
+		t.Error("empty path")
+	}
+}
+
+func TestParseStat(t *testing.T) {
+	out := "1714464000 1048576 /backups/db-1.sql.gz\r\n" +
+		"stat: cannot stat '/backups/x': No such file\n" +
+		"1714550400 2097152 /backups/db 2.sql.gz\n"
+	info, err := parseStat(out)
+	if err != nil || info.name != "/backups/db 2.sql.gz" || info.size != 2097152 || info.modTime.Unix() != 1714550400 {
+		t.Errorf("parseStat = %+v, %v", info, err)
+	}
+	if _, err := parseStat("\n"); !errors.Is(err, errNotFound) {
+		t.Errorf("empty listing: %v", err)
+	}
+}
+
+func TestShellGlob(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"/backups/db-*.sql.gz":    "'/backups/db-'*'.sql.gz'",
+		"/srv/it's/db-?.tar":      `'/srv/it'\''s/db-'?'.tar'`,
+		"/plain/file; rm -rf /":   "'/plain/file; rm -rf /'",
+		"/var/backups/$(reboot)*": "'/var/backups/$(reboot)'*",
+	} {
+		if got := shellGlob(pattern); got != want {
+			t.Errorf("shellGlob(%q) = %s, want %s", pattern, got, want)
+		}
+	}
+}
+
+func TestInvalidTasks(t *testing.T) {
+	p := &backupCheckPlugin{}
+	for name, options := range map[string]map[string]interface{}{
+		"unknown action": {"action": "list"},
+		"no targets":     {"action": "check", "options": map[string]interface{}{}},
+		"bad options":    {"action": "check", "options": map[string]interface{}{"targets": "db"}},
+	} {
+		if _, err := p.OnCollect(options); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	metrics := collect(t, nil, map[string]interface{}{"targets": []interface{}{
+		map[string]interface{}{"name": "a", "method": "ftp", "path": "x"},
+		map[string]interface{}{"name": "b", "method": "ssh", "path": "x"},
+		map[string]interface{}{"name": "c", "method": "s3", "path": "x"},
+	}})
+	for name, reason := range map[string]string{
+		"a": `unknown method "ftp"`,
+		"b": "the ssh method needs credentials",
+		"c": "s3 needs an endpoint (the credential's host)",
+	} {
+		if s := get(t, metrics, "backup_status_"+name); s["value"] != "down" || s["reason"] != reason {
+			t.Errorf("%s: %v", name, s)
+		}
+	}
+}
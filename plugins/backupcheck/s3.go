@@ -0,0 +1,222 @@
+package backupcheck
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Client lists objects of an S3-compatible service (AWS, MinIO, Ceph,
+// Wasabi...) with path-style requests signed by AWS Signature Version 4.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	http      *http.Client
+}
+
+func newS3Client(endpoint, region, accessKey, secretKey string, timeout time.Duration) (*s3Client, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("s3 needs an endpoint (the credential's host)")
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint %q: %w", endpoint, err)
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Client{
+		endpoint: u, region: region, accessKey: accessKey, secretKey: secretKey,
+		http: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// listResult is the part of a ListObjectsV2 response the check reads.
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// s3Error is the error document S3 returns with a failed request.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// newest returns the most recently modified object of bucket whose key
+// matches pattern. Only keys under the pattern's literal prefix are listed.
+func (c *s3Client) newest(ctx context.Context, bucket, pattern string) (fileInfo, error) {
+	prefix := pattern
+	if i := strings.IndexAny(pattern, "*?["); i >= 0 {
+		prefix = pattern[:i]
+	}
+	var best fileInfo
+	found := false
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		var res listResult
+		if err := c.get(ctx, "/"+bucket, q, &res); err != nil {
+			return fileInfo{}, err
+		}
+		for _, obj := range res.Contents {
+			if ok, _ := path.Match(pattern, obj.Key); !ok {
+				continue
+			}
+			if !found || obj.LastModified.After(best.modTime) {
+				best = fileInfo{name: obj.Key, modTime: obj.LastModified, size: obj.Size}
+				found = true
+			}
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	if !found {
+		return fileInfo{}, errNotFound
+	}
+	return best, nil
+}
+
+// get sends a signed GET and decodes the XML response into v.
+func (c *s3Client) get(ctx context.Context, p string, q url.Values, v interface{}) error {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawQuery = canonicalQuery(q)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	c.sign(req, time.Now().UTC())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e s3Error
+		if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+			return fmt.Errorf("s3: %s: %s", e.Code, e.Message)
+		}
+		return fmt.Errorf("s3: HTTP %s", resp.Status)
+	}
+	if err := xml.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("s3: malformed response: %w", err)
+	}
+	return nil
+}
+
+// sign adds the Signature Version 4 headers for an empty-bodied request.
+func (c *s3Client) sign(req *http.Request, now time.Time) {
+	const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyHash)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.EscapedPath()),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + emptyHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signed,
+		emptyHash,
+	}, "\n")
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonical)
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), day)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signed, signature))
+}
+
+// canonicalQuery encodes q sorted by key with RFC 3986 escaping, as both the
+// request and its signature need it.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, uriEscape(k)+"="+uriEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEscape escapes everything but the RFC 3986 unreserved characters.
+func uriEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// escapePath re-escapes an already escaped path the way SigV4 expects.
+func escapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		if raw, err := url.PathUnescape(seg); err == nil {
+			segments[i] = uriEscape(raw)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
@@ -0,0 +1,150 @@
+package backupcheck
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"observer/plugins/sshcollect"
+)
+
+// errNotFound reports a target with no matching backup.
+var errNotFound = errors.New("not found")
+
+// fileInfo is the newest backup found for a target.
+type fileInfo struct {
+	name    string
+	modTime time.Time
+	size    int64
+}
+
+// newestLocal returns the newest file on this machine matching pattern.
+func newestLocal(pattern string) (fileInfo, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	if len(matches) == 0 {
+		// Glob hides I/O errors; an unreadable directory would otherwise look empty.
+		if _, err := os.ReadDir(filepath.Dir(pattern)); err != nil && errors.Is(err, fs.ErrPermission) {
+			return fileInfo{}, err
+		}
+		return fileInfo{}, errNotFound
+	}
+	var best fileInfo
+	found := false
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		if !found || info.ModTime().After(best.modTime) {
+			best = fileInfo{name: m, modTime: info.ModTime(), size: info.Size()}
+			found = true
+		}
+	}
+	if !found {
+		return fileInfo{}, errNotFound
+	}
+	return best, nil
+}
+
+// Markers around the remote listing, split so that an echoing shell does not
+// make the command line itself look like the end of the output.
+const (
+	markBegin   = "__NORD_BACKUP_BEGIN__"
+	markEnd     = "__NORD_BACKUP_END__"
+	markEndEcho = `__NORD_BACKUP_"END"__`
+)
+
+// sshSession runs listings on one host over sshcollect's interactive shell,
+// so one connection serves every target of the task.
+type sshSession struct {
+	sess *sshcollect.InteractiveSession
+}
+
+func dialSSH(user, pass, host string, port int) (*sshSession, error) {
+	sess := &sshcollect.InteractiveSession{}
+	if err := sess.Connect(user, pass, host, port); err != nil {
+		return nil, fmt.Errorf("SSH connection failed: %w", err)
+	}
+	if err := sess.Shell(); err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("failed to start shell: %w", err)
+	}
+	return &sshSession{sess: sess}, nil
+}
+
+func (s *sshSession) close() { s.sess.Close() }
+
+// newest stats the files matching pattern on the remote host with
+// `stat -c '%Y %s %n'` and returns the most recently modified one.
+func (s *sshSession) newest(pattern string) (fileInfo, error) {
+	cmd := fmt.Sprintf("echo %s; stat -c '%%Y %%s %%n' -- %s 2>/dev/null; echo %s", markBegin, shellGlob(pattern), markEndEcho)
+	if err := s.sess.Send(cmd); err != nil {
+		return fileInfo{}, err
+	}
+	out, err := s.sess.WaitFor(markEnd + `\r?\n`)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	if i := strings.LastIndex(out, markBegin); i >= 0 {
+		out = out[i+len(markBegin):]
+	}
+	out = strings.TrimSuffix(strings.TrimSpace(out), markEnd)
+	return parseStat(out)
+}
+
+// parseStat reads "mtime size name" lines and returns the newest entry.
+func parseStat(out string) (fileInfo, error) {
+	var best fileInfo
+	found := false
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		mtime, err1 := strconv.ParseInt(fields[0], 10, 64)
+		size, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		t := time.Unix(mtime, 0)
+		if !found || t.After(best.modTime) {
+			best = fileInfo{name: fields[2], modTime: t, size: size}
+			found = true
+		}
+	}
+	if !found {
+		return fileInfo{}, errNotFound
+	}
+	return best, nil
+}
+
+// shellGlob quotes pattern for a POSIX shell, leaving only the glob
+// characters * ? [ ] unquoted so the remote shell expands them.
+func shellGlob(pattern string) string {
+	var b strings.Builder
+	literal := ""
+	flush := func() {
+		if literal != "" {
+			b.WriteString("'" + strings.ReplaceAll(literal, "'", `'\''`) + "'")
+			literal = ""
+		}
+	}
+	for _, r := range pattern {
+		if strings.ContainsRune("*?[]", r) {
+			flush()
+			b.WriteRune(r)
+			continue
+		}
+		literal += string(r)
+	}
+	flush()
+	return b.String()
+}
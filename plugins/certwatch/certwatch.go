@@ -0,0 +1,376 @@
+// Package certwatch inventories the TLS certificates served by each host:
+// collect tasks "certwatch.inventory" probe a list of ports, negotiating
+// STARTTLS where the protocol needs it, and record expiry and chain validity.
+package certwatch
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+)
+
+const (
+	defaultTimeout      = 5 * time.Second
+	defaultWarnDays     = 30
+	defaultCriticalDays = 7
+)
+
+// defaultPorts are probed when a task lists none.
+var defaultPorts = []string{"443", "8443", "25", "993", "636"}
+
+// starttlsPorts negotiate STARTTLS unless the port spec names a protocol.
+var starttlsPorts = map[string]string{
+	"25":  "smtp",
+	"587": "smtp",
+	"143": "imap",
+	"110": "pop3",
+	"389": "ldap",
+}
+
+// certwatchPlugin inventories TLS certificates.
+type certwatchPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&certwatchPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *certwatchPlugin) Name() string {
+	return "Certwatch"
+}
+
+// checkOptions are the per-task options of a certwatch.inventory task:
+//
+//	{"metric": "certwatch.inventory", "options": {
+//	    "ports": ["443", "8443", "25", "2525/smtp", "993", "636"],
+//	    "server_name": "mail.example.com", "timeout_s": 5,
+//	    "warn_days": 30, "critical_days": 7}}
+//
+// A port may name its STARTTLS protocol (smtp, imap, pop3, ldap) or "tls";
+// the well-known STARTTLS ports default to theirs. server_name is sent as SNI
+// and checked against the certificate, defaulting to the host's address.
+type checkOptions struct {
+	Ports        []string `json:"ports"`
+	ServerName   string   `json:"server_name"`
+	TimeoutS     float64  `json:"timeout_s"`
+	WarnDays     int      `json:"warn_days"`
+	CriticalDays int      `json:"critical_days"`
+}
+
+// probe is the outcome of one port.
+type probe struct {
+	port, proto string
+	leaf        *x509.Certificate
+	chainErr    error // nil when the chain verifies against the system roots
+	err         error // connection, STARTTLS or handshake failure
+}
+
+// Actions advertises the expiry report.
+func (p *certwatchPlugin) Actions() []plugin.ActionSpec {
+	return []plugin.ActionSpec{
+		{Action: "expiring", Label: "Certificates expiring soon", Safety: plugin.SafetyRead,
+			Params:  []plugin.ActionParam{{Name: "days", Label: "Within days", Default: "30"}},
+			Applies: func(h plugin.Host) bool { return h.CollectsWith("certwatch") }},
+	}
+}
+
+// OnCommand handles "expiring", which lists stored certificates expiring
+// within days=N (default 30) across all configured hosts, or only host=<key>.
+func (p *certwatchPlugin) OnCommand(args map[string]string) error {
+	switch args["action"] {
+	case "expiring":
+		return p.printExpiring(args["args"])
+	}
+	return fmt.Errorf("unknown command for certwatch plugin: %s", args["action"])
+}
+
+// OnCollect probes every configured port of the host. Closed ports are left out
+// of the inventory; other failures are reported as a down cert_status.
+func (p *certwatchPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	if action, _ := options["action"].(string); action != "inventory" {
+		return nil, fmt.Errorf("undefined certwatch action: %s", action)
+	}
+	var opts checkOptions
+	if raw, ok := options["options"]; ok {
+		b, _ := json.Marshal(raw)
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return nil, fmt.Errorf("certwatch: invalid options: %w", err)
+		}
+	}
+	host, _ := options["host"].(map[string]interface{})
+	address, _ := host["address"].(string)
+	if address == "" {
+		return nil, errors.New("certwatch: host has no address")
+	}
+	if len(opts.Ports) == 0 {
+		opts.Ports = defaultPorts
+	}
+	if opts.ServerName == "" {
+		opts.ServerName = address
+	}
+	if opts.WarnDays <= 0 {
+		opts.WarnDays = defaultWarnDays
+	}
+	if opts.CriticalDays <= 0 {
+		opts.CriticalDays = defaultCriticalDays
+	}
+	timeout := defaultTimeout
+	if opts.TimeoutS > 0 {
+		timeout = time.Duration(opts.TimeoutS * float64(time.Second))
+	}
+
+	now := time.Now()
+	metrics := make(map[string]interface{})
+	var closed []string
+	worst := ""
+	minDays, minPort := math.Inf(1), ""
+	for _, spec := range opts.Ports {
+		pr := probePort(address, spec, opts.ServerName, timeout)
+		if errors.Is(pr.err, syscall.ECONNREFUSED) {
+			closed = append(closed, pr.port)
+			continue
+		}
+		status, days := portMetrics(metrics, pr, opts, now)
+		worst = worse(worst, status)
+		if pr.leaf != nil && days < minDays {
+			minDays, minPort = days, pr.port
+		}
+	}
+
+	if worst != "" {
+		summary := metric("cert_worst", "Certificates", "", "status", worst)
+		if len(closed) > 0 {
+			summary["closed_ports"] = closed
+		}
+		metrics["cert_worst"] = summary
+	}
+	if minPort != "" {
+		m := metric("min_days_to_expiry", "Soonest expiry (days)", "", "gauge", fmt.Sprintf("%.1f", minDays))
+		m["value_num"] = minDays
+		m["port"] = minPort
+		metrics["cert_min_days"] = m
+	}
+	return map[string]interface{}{"metrics": metrics}, nil
+}
+
+// probePort connects to address:port, runs STARTTLS when needed and records
+// the certificate presented. Verification is done separately so that invalid
+// certificates are still inventoried.
+func probePort(address, spec, serverName string, timeout time.Duration) probe {
+	port, proto, _ := strings.Cut(spec, "/")
+	if proto == "" {
+		proto = starttlsPorts[port]
+	}
+	if proto == "" {
+		proto = "tls"
+	}
+	pr := probe{port: port, proto: proto}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, port), timeout)
+	if err != nil {
+		pr.err = err
+		return pr
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if proto != "tls" {
+		if err := starttls(conn, proto); err != nil {
+			pr.err = fmt.Errorf("%s STARTTLS: %w", proto, err)
+			return pr
+		}
+	}
+
+	sni := serverName
+	if net.ParseIP(sni) != nil {
+		sni = "" // SNI carries host names only
+	}
+	tc := tls.Client(conn, &tls.Config{ServerName: sni, InsecureSkipVerify: true})
+	if err := tc.Handshake(); err != nil {
+		pr.err = fmt.Errorf("TLS handshake: %w", err)
+		return pr
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		pr.err = errors.New("no certificate presented")
+		return pr
+	}
+	pr.leaf = certs[0]
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, pr.chainErr = pr.leaf.Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates})
+	return pr
+}
+
+// portMetrics adds the metrics of one probed port, with the port as instance,
+// and returns its status and days to expiry.
+func portMetrics(metrics map[string]interface{}, pr probe, opts checkOptions, now time.Time) (string, float64) {
+	status := metric("cert_status", "Certificate", pr.port, "status", "up")
+	status["protocol"] = pr.proto
+	metrics["cert_status_"+pr.port] = status
+	if pr.err != nil {
+		status["value"] = "down"
+		status["reason"] = pr.err.Error()
+		return "down", 0
+	}
+
+	leaf := pr.leaf
+	days := leaf.NotAfter.Sub(now).Hours() / 24
+	sans := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	fingerprint := sha256.Sum256(leaf.Raw)
+	status["subject"] = leaf.Subject.String()
+	status["issuer"] = leaf.Issuer.String()
+	status["sans"] = sans
+	status["not_before"] = leaf.NotBefore.UTC().Format(time.RFC3339)
+	status["not_after"] = leaf.NotAfter.UTC().Format(time.RFC3339)
+	status["serial"] = leaf.SerialNumber.String()
+	status["signature_algorithm"] = leaf.SignatureAlgorithm.String()
+	status["sha256"] = hex.EncodeToString(fingerprint[:])
+	status["chain_valid"] = pr.chainErr == nil
+	if pr.chainErr != nil {
+		status["chain_error"] = pr.chainErr.Error()
+	}
+
+	value := "up"
+	switch {
+	case days <= 0:
+		value, status["reason"] = "down", "expired"
+	case days <= float64(opts.CriticalDays):
+		value, status["reason"] = "down", fmt.Sprintf("expires in %.0f days", days)
+	case pr.chainErr != nil:
+		value, status["reason"] = "warning", "chain does not verify"
+	case days <= float64(opts.WarnDays):
+		value, status["reason"] = "warning", fmt.Sprintf("expires in %.0f days", days)
+	}
+	status["value"] = value
+
+	d := metric("days_to_expiry", "Days to expiry", pr.port, "gauge", fmt.Sprintf("%.1f", days))
+	d["value_num"] = days
+	metrics["cert_days_"+pr.port] = d
+
+	// A readable summary, shown expanded in the TUI detail view.
+	chain := "valid"
+	if pr.chainErr != nil {
+		chain = pr.chainErr.Error()
+	}
+	info := fmt.Sprintf("Subject: %s\nIssuer: %s\nSANs: %s\nValid: %s to %s\nChain: %s",
+		leaf.Subject, leaf.Issuer, strings.Join(sans, ", "),
+		leaf.NotBefore.UTC().Format("2006-01-02"), leaf.NotAfter.UTC().Format("2006-01-02"), chain)
+	metrics["cert_info_"+pr.port] = metric("cert_info", "Details", pr.port, "text", info)
+	return value, days
+}
+
+// worse returns the more severe of two statuses.
+func worse(a, b string) string {
+	rank := map[string]int{"": 0, "up": 1, "warning": 2, "down": 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// printExpiring lists certificates whose latest days_to_expiry is within the
+// window, soonest first, from the store.
+func (p *certwatchPlugin) printExpiring(argStr string) error {
+	days := float64(defaultWarnDays)
+	only := ""
+	for _, kv := range strings.Fields(argStr) {
+		if v, ok := strings.CutPrefix(kv, "host="); ok {
+			only = v
+		}
+		if v, ok := strings.CutPrefix(kv, "days="); ok {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("certwatch: invalid days %q", v)
+			}
+			days = n
+		}
+	}
+	st := p.Controller.Store
+	if st == nil {
+		return errors.New("certwatch: expiring needs a database (see database.url)")
+	}
+	data, err := plugin.ReadConfigFile()
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("could not parse config file: %w", err)
+	}
+
+	type row struct {
+		host, port, subject string
+		days                float64
+	}
+	var rows []row
+	for key, h := range cfg.Hosts {
+		if !h.CollectsWith("certwatch") || (only != "" && key != only) {
+			continue
+		}
+		records, err := st.LatestMetrics(context.Background(), key)
+		if err != nil {
+			return fmt.Errorf("store: %w", err)
+		}
+		subjects := make(map[string]string)
+		for _, r := range records {
+			if r.Plugin == "certwatch" && r.Name == "cert_status" {
+				subjects[r.Instance], _ = r.Extra["subject"].(string)
+			}
+		}
+		for _, r := range records {
+			if r.Plugin == "certwatch" && r.Name == "days_to_expiry" && r.ValueNum != nil && *r.ValueNum <= days {
+				rows = append(rows, row{host: key, port: r.Instance, subject: subjects[r.Instance], days: *r.ValueNum})
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].days < rows[j].days })
+
+	p.Controller.Printf("--- Certificates expiring within %.0f days ---\n", days)
+	if len(rows) == 0 {
+		p.Controller.Println("  |_ none")
+		return nil
+	}
+	for _, r := range rows {
+		marker := "|_"
+		if r.days <= 0 {
+			marker = "!_"
+		}
+		p.Controller.Printf("  %s %s:%s %.1f days  %s\n", marker, r.host, r.port, r.days, r.subject)
+	}
+	return nil
+}
+
+func metric(name, label, instance, metricType string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    label,
+		"value":    value,
+		"type":     metricType,
+		"category": "certificates",
+		"instance": instance,
+	}
+}
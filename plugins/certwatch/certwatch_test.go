@@ -0,0 +1,435 @@
+package certwatch
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+// testCA signs the certificates served in the tests; it is not a system root,
+// so their chains never verify.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Nord Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// leaf issues a certificate for mail.example.com and 127.0.0.1 expiring after expires.
+func (ca *testCA) leaf(t *testing.T, cn string, expires time.Duration) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-400 * 24 * time.Hour),
+		NotAfter:     time.Now().Add(expires),
+		DNSNames:     []string{"mail.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key}
+}
+
+// tlsServer serves cert on a local port, speaking greet first: the plaintext
+// part of a STARTTLS exchange, or nothing for TLS from the start. It returns
+// the port and a func reporting the SNI names received.
+func tlsServer(t *testing.T, cert tls.Certificate, greet func(net.Conn, *bufio.Reader) bool) (string, func() []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	var names []string
+	cfg := &tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		mu.Lock()
+		names = append(names, hello.ServerName)
+		mu.Unlock()
+		return &cert, nil
+	}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				if greet != nil && !greet(conn, bufio.NewReader(conn)) {
+					return
+				}
+				tls.Server(conn, cfg).Handshake()
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, names...)
+	}
+}
+
+// smtpGreet answers EHLO and then STARTTLS with reply.
+func smtpGreet(reply string) func(net.Conn, *bufio.Reader) bool {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		io.WriteString(conn, "220 mail.example.com ESMTP\r\n")
+		if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "EHLO ") {
+			return false
+		}
+		io.WriteString(conn, "250-mail.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n")
+		if line, _ := r.ReadString('\n'); line != "STARTTLS\r\n" {
+			return false
+		}
+		io.WriteString(conn, reply+"\r\n")
+		return strings.HasPrefix(reply, "220")
+	}
+}
+
+func imapGreet(conn net.Conn, r *bufio.Reader) bool {
+	io.WriteString(conn, "* OK IMAP4rev1 ready\r\n")
+	line, _ := r.ReadString('\n')
+	tag, _, _ := strings.Cut(line, " ")
+	io.WriteString(conn, "* CAPABILITY IMAP4rev1\r\n"+tag+" OK Begin TLS negotiation now\r\n")
+	return true
+}
+
+func pop3Greet(conn net.Conn, r *bufio.Reader) bool {
+	io.WriteString(conn, "+OK POP3 ready\r\n")
+	if line, _ := r.ReadString('\n'); line != "STLS\r\n" {
+		return false
+	}
+	io.WriteString(conn, "+OK Begin TLS\r\n")
+	return true
+}
+
+// ldapGreet answers the StartTLS extended request with resultCode code.
+func ldapGreet(code byte) func(net.Conn, *bufio.Reader) bool {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		req := make([]byte, len(ldapStartTLS))
+		if _, err := io.ReadFull(r, req); err != nil || string(req) != string(ldapStartTLS) {
+			return false
+		}
+		conn.Write([]byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, code, 0x04, 0x00, 0x04, 0x00})
+		return code == 0
+	}
+}
+
+// closedPort returns a local port nothing listens on.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	return port
+}
+
+// inventory runs one certwatch.inventory task against 127.0.0.1.
+func inventory(t *testing.T, opts map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	result, err := (&certwatchPlugin{}).OnCollect(map[string]interface{}{
+		"action":  "inventory",
+		"host":    map[string]interface{}{"address": "127.0.0.1", "name": "mail"},
+		"options": opts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result["metrics"].(map[string]interface{})
+}
+
+func get(t *testing.T, metrics map[string]interface{}, key string) map[string]interface{} {
+	t.Helper()
+	m, ok := metrics[key].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no %s in %v", key, metrics)
+	}
+	return m
+}
+
+func TestInventory(t *testing.T) {
+	ca := newCA(t)
+	https, sni := tlsServer(t, ca.leaf(t, "www", 90*24*time.Hour), nil)
+	smtp, _ := tlsServer(t, ca.leaf(t, "smtp", 5*24*time.Hour), smtpGreet("220 Ready to start TLS"))
+	imap, _ := tlsServer(t, ca.leaf(t, "imap", -48*time.Hour), imapGreet)
+	pop3, _ := tlsServer(t, ca.leaf(t, "pop3", 20*24*time.Hour), pop3Greet)
+	ldap, _ := tlsServer(t, ca.leaf(t, "ldap", 200*24*time.Hour), ldapGreet(0))
+	closed := closedPort(t)
+
+	metrics := inventory(t, map[string]interface{}{
+		"server_name": "mail.example.com",
+		"ports":       []string{https, smtp + "/smtp", imap + "/imap", pop3 + "/pop3", ldap + "/ldap", closed},
+	})
+
+	for port, want := range map[string]struct{ proto, value, reason string }{
+		https: {"tls", "warning", "chain does not verify"},
+		smtp:  {"smtp", "down", "expires in 5 days"},
+		imap:  {"imap", "down", "expired"},
+		pop3:  {"pop3", "warning", "chain does not verify"},
+		ldap:  {"ldap", "warning", "chain does not verify"},
+	} {
+		s := get(t, metrics, "cert_status_"+port)
+		if s["protocol"] != want.proto || s["value"] != want.value || s["reason"] != want.reason || s["instance"] != port {
+			t.Errorf("port %s (%s) = %v", port, want.proto, s)
+		}
+		chainErr := "unknown authority"
+		if port == imap {
+			chainErr = "certificate has expired"
+		}
+		if s["chain_valid"] != false || !strings.Contains(s["chain_error"].(string), chainErr) {
+			t.Errorf("port %s chain = %v, %v", port, s["chain_valid"], s["chain_error"])
+		}
+		if _, ok := metrics["cert_days_"+port]; !ok {
+			t.Errorf("no days_to_expiry for %s", port)
+		}
+	}
+	if _, ok := metrics["cert_status_"+closed]; ok {
+		t.Error("a closed port was inventoried")
+	}
+
+	s := get(t, metrics, "cert_status_"+https)
+	if s["subject"] != "CN=www,O=Example" || s["issuer"] != "CN=Nord Test CA" || strings.Join(s["sans"].([]string), ",") != "mail.example.com,127.0.0.1" {
+		t.Errorf("details = %v", s)
+	}
+	if len(s["sha256"].(string)) != 64 || s["not_after"] == "" || s["serial"] == "" {
+		t.Errorf("details = %v", s)
+	}
+	if names := sni(); len(names) != 1 || names[0] != "mail.example.com" {
+		t.Errorf("SNI = %q", names)
+	}
+	if d := get(t, metrics, "cert_days_"+pop3); d["value_num"].(float64) < 19.9 || d["value_num"].(float64) > 20 || d["type"] != "gauge" {
+		t.Errorf("days = %v", d)
+	}
+	if info := get(t, metrics, "cert_info_"+smtp); !strings.Contains(info["value"].(string), "Subject: CN=smtp,O=Example\nIssuer: CN=Nord Test CA") {
+		t.Errorf("info = %v", info["value"])
+	}
+
+	worst := get(t, metrics, "cert_worst")
+	if worst["value"] != "down" || strings.Join(worst["closed_ports"].([]string), ",") != closed {
+		t.Errorf("worst = %v", worst)
+	}
+	soonest := get(t, metrics, "cert_min_days")
+	if soonest["port"] != imap || soonest["value_num"].(float64) > -1.9 {
+		t.Errorf("soonest = %v", soonest)
+	}
+}
+
+func TestInventoryThresholds(t *testing.T) {
+	ca := newCA(t)
+	port, _ := tlsServer(t, ca.leaf(t, "www", 20*24*time.Hour), nil)
+	metrics := inventory(t, map[string]interface{}{"ports": []string{port}, "critical_days": 21})
+	if s := get(t, metrics, "cert_status_"+port); s["value"] != "down" || s["reason"] != "expires in 20 days" {
+		t.Errorf("status = %v", s)
+	}
+}
+
+func TestInventoryIPAddressSendsNoSNI(t *testing.T) {
+	port, sni := tlsServer(t, newCA(t).leaf(t, "www", 90*24*time.Hour), nil)
+	metrics := inventory(t, map[string]interface{}{"ports": []string{port}})
+	if names := sni(); len(names) != 1 || names[0] != "" {
+		t.Errorf("SNI = %q", names)
+	}
+	// The certificate names 127.0.0.1, so only the issuer fails verification.
+	if s := get(t, metrics, "cert_status_"+port); !strings.Contains(s["chain_error"].(string), "unknown authority") {
+		t.Errorf("chain error = %v", s["chain_error"])
+	}
+}
+
+func TestStarttlsRefused(t *testing.T) {
+	ca := newCA(t)
+	smtp, _ := tlsServer(t, ca.leaf(t, "smtp", 90*24*time.Hour), smtpGreet("454 TLS not available"))
+	ldap, _ := tlsServer(t, ca.leaf(t, "ldap", 90*24*time.Hour), ldapGreet(52))
+	plain, _ := tlsServer(t, ca.leaf(t, "www", 90*24*time.Hour), func(conn net.Conn, r *bufio.Reader) bool {
+		io.WriteString(conn, "SSH-2.0-OpenSSH_9.6\r\n")
+		return false
+	})
+
+	metrics := inventory(t, map[string]interface{}{"ports": []string{smtp + "/smtp", ldap + "/ldap", plain}, "timeout_s": 2})
+	for port, want := range map[string]string{
+		smtp:  `smtp STARTTLS: STARTTLS: unexpected reply "454 TLS not available"`,
+		ldap:  "ldap STARTTLS: StartTLS refused, resultCode 52",
+		plain: "TLS handshake: ",
+	} {
+		s := get(t, metrics, "cert_status_"+port)
+		if s["value"] != "down" || !strings.HasPrefix(s["reason"].(string), want) {
+			t.Errorf("port %s = %v, want reason %q", port, s, want)
+		}
+		if _, ok := metrics["cert_days_"+port]; ok {
+			t.Errorf("days reported for failed port %s", port)
+		}
+	}
+	if get(t, metrics, "cert_worst")["value"] != "down" {
+		t.Error("worst is not down")
+	}
+	if _, ok := metrics["cert_min_days"]; ok {
+		t.Error("soonest expiry without any certificate")
+	}
+}
+
+func TestInventoryAllClosed(t *testing.T) {
+	if metrics := inventory(t, map[string]interface{}{"ports": []string{closedPort(t)}}); len(metrics) != 0 {
+		t.Errorf("metrics = %v", metrics)
+	}
+}
+
+func TestInvalidTasks(t *testing.T) {
+	p := &certwatchPlugin{}
+	for name, options := range map[string]map[string]interface{}{
+		"unknown action": {"action": "scan", "host": map[string]interface{}{"address": "127.0.0.1"}},
+		"no address":     {"action": "inventory"},
+		"bad options":    {"action": "inventory", "host": map[string]interface{}{"address": "127.0.0.1"}, "options": map[string]interface{}{"ports": 443}},
+	} {
+		if _, err := p.OnCollect(options); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+// latestStore serves LatestMetrics from a fixed set of records per host.
+type latestStore struct {
+	store.Store
+	records map[string][]store.MetricRecord
+}
+
+func (s *latestStore) LatestMetrics(ctx context.Context, hostKey string) ([]store.MetricRecord, error) {
+	return s.records[hostKey], nil
+}
+
+func certRecords(port, subject string, days float64) []store.MetricRecord {
+	return []store.MetricRecord{
+		{Plugin: "certwatch", Name: "cert_status", Instance: port, Value: "up", Extra: map[string]interface{}{"subject": subject}},
+		{Plugin: "certwatch", Name: "days_to_expiry", Instance: port, ValueNum: &days},
+	}
+}
+
+func TestPrintExpiring(t *testing.T) {
+	dir := t.TempDir()
+	old := plugin.ConfigFile
+	plugin.ConfigFile = dir + "/config.json"
+	t.Cleanup(func() { plugin.ConfigFile = old })
+	config := `{"config_version": 1, "hosts": {
+		"mail": {"address": "10.0.0.25", "collect": [{"metric": "certwatch.inventory"}]},
+		"web": {"address": "10.0.0.80", "collect": [{"metric": "certwatch.inventory"}]},
+		"db": {"address": "10.0.0.5", "collect": [{"metric": "ping"}]}
+	}}`
+	if err := os.WriteFile(plugin.ConfigFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st := &latestStore{records: map[string][]store.MetricRecord{
+		"mail": append(certRecords("25", "CN=mail", 12.5), certRecords("993", "CN=imap", -3)...),
+		"web":  append(certRecords("443", "CN=www", 80), certRecords("8443", "CN=admin", 29)...),
+		"db":   certRecords("5432", "CN=db", 1),
+	}}
+	c := plugin.NewController()
+	c.Store = st
+	p := &certwatchPlugin{BasePlugin: plugin.BasePlugin{Controller: c}}
+
+	out := captureStdout(t, func() error { return p.OnCommand(map[string]string{"action": "expiring"}) })
+	want := "--- Certificates expiring within 30 days ---\n" +
+		"  !_ mail:993 -3.0 days  CN=imap\n" +
+		"  |_ mail:25 12.5 days  CN=mail\n" +
+		"  |_ web:8443 29.0 days  CN=admin\n"
+	if out != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+
+	out = captureStdout(t, func() error { return p.OnCommand(map[string]string{"action": "expiring", "args": "days=100 host=web"}) })
+	if !strings.Contains(out, "web:443 80.0 days") || strings.Contains(out, "mail:") {
+		t.Errorf("host=web days=100:\n%s", out)
+	}
+	out = captureStdout(t, func() error { return p.OnCommand(map[string]string{"action": "expiring", "args": "days=-10"}) })
+	if !strings.HasSuffix(out, "  |_ none\n") {
+		t.Errorf("days=-10:\n%s", out)
+	}
+
+	if err := p.OnCommand(map[string]string{"action": "expiring", "args": "days=soon"}); err == nil {
+		t.Error("invalid days accepted")
+	}
+	c.Store = nil
+	if err := p.OnCommand(map[string]string{"action": "expiring"}); err == nil || !strings.Contains(err.Error(), "needs a database") {
+		t.Errorf("without a store: %v", err)
+	}
+}
+
+// captureStdout returns what fn prints, failing the test if fn fails.
+func captureStdout(t *testing.T, fn func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	err = fn()
+	os.Stdout = old
+	w.Close()
+	out := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestWorse(t *testing.T) {
+	for _, tc := range [][3]string{{"", "up", "up"}, {"warning", "up", "warning"}, {"warning", "down", "down"}, {"down", "", "down"}} {
+		if got := worse(tc[0], tc[1]); got != tc[2] {
+			t.Errorf("worse(%q, %q) = %q, want %q", tc[0], tc[1], got, tc[2])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package certwatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// starttls upgrades a plaintext connection by speaking proto's STARTTLS
+// exchange up to the point where the TLS handshake starts.
+func starttls(conn net.Conn, proto string) error {
+	switch proto {
+	case "smtp":
+		return starttlsSMTP(conn)
+	case "imap":
+		return starttlsIMAP(conn)
+	case "pop3":
+		return starttlsPOP3(conn)
+	case "ldap":
+		return starttlsLDAP(conn)
+	}
+	return fmt.Errorf("no STARTTLS for %q", proto)
+}
+
+// starttlsSMTP reads the greeting, sends EHLO and STARTTLS (RFC 3207).
+func starttlsSMTP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	if err := smtpReply(r, "220"); err != nil {
+		return fmt.Errorf("greeting: %w", err)
+	}
+	if _, err := io.WriteString(conn, "EHLO nord\r\n"); err != nil {
+		return err
+	}
+	if err := smtpReply(r, "250"); err != nil {
+		return fmt.Errorf("EHLO: %w", err)
+	}
+	if _, err := io.WriteString(conn, "STARTTLS\r\n"); err != nil {
+		return err
+	}
+	if err := smtpReply(r, "220"); err != nil {
+		return fmt.Errorf("STARTTLS: %w", err)
+	}
+	return nil
+}
+
+// smtpReply reads a possibly multi-line reply ("250-...", "250 ...") and
+// checks its code.
+func smtpReply(r *bufio.Reader, code string) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, code) {
+			return fmt.Errorf("unexpected reply %q", strings.TrimSpace(line))
+		}
+		if len(line) < 4 || line[3] != '-' {
+			return nil
+		}
+	}
+}
+
+// starttlsIMAP reads the greeting and sends a tagged STARTTLS (RFC 3501).
+func starttlsIMAP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("greeting: %w", err)
+	}
+	if !strings.HasPrefix(line, "* OK") {
+		return fmt.Errorf("greeting: unexpected %q", strings.TrimSpace(line))
+	}
+	if _, err := io.WriteString(conn, "n1 STARTTLS\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+		if strings.HasPrefix(line, "n1 ") {
+			if !strings.HasPrefix(line, "n1 OK") {
+				return fmt.Errorf("STARTTLS: %s", strings.TrimSpace(line))
+			}
+			return nil
+		}
+	}
+}
+
+// starttlsPOP3 reads the greeting and sends STLS (RFC 2595).
+func starttlsPOP3(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	for _, step := range []string{"", "STLS\r\n"} {
+		if step != "" {
+			if _, err := io.WriteString(conn, step); err != nil {
+				return err
+			}
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "+OK") {
+			return fmt.Errorf("unexpected reply %q", strings.TrimSpace(line))
+		}
+	}
+	return nil
+}
+
+// ldapStartTLS is an LDAP ExtendedRequest (message id 1) for the StartTLS
+// OID 1.3.6.1.4.1.1466.20037, BER encoded (RFC 4511 section 4.14).
+var ldapStartTLS = append([]byte{0x30, 0x1d, 0x02, 0x01, 0x01, 0x77, 0x18, 0x80, 0x16},
+	"1.3.6.1.4.1.1466.20037"...)
+
+// starttlsLDAP sends the StartTLS extended operation and checks that the
+// ExtendedResponse carries resultCode success.
+func starttlsLDAP(conn net.Conn) error {
+	if _, err := conn.Write(ldapStartTLS); err != nil {
+		return err
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	resp := buf[:n]
+	// ExtendedResponse [APPLICATION 24] begins with the ENUMERATED resultCode.
+	i := bytes.IndexByte(resp, 0x78)
+	if i < 0 {
+		return errors.New("no ExtendedResponse in reply")
+	}
+	j := bytes.Index(resp[i:], []byte{0x0a, 0x01})
+	if j < 0 || i+j+2 >= len(resp) {
+		return errors.New("no resultCode in ExtendedResponse")
+	}
+	if code := resp[i+j+2]; code != 0 {
+		return fmt.Errorf("StartTLS refused, resultCode %d", code)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"observer/base"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// budgetStateFile, in the state directory, keeps the tasks a cycle budget
+// deferred and how long each task took when it last ran.
+const budgetStateFile = "collection_budget.json"
+
+// budgetCutoff is the share of the cycle budget after which no task is started.
+const budgetCutoff = 0.8
+
+// errBudgetSpent is the cause of a task abandoned at the end of the cycle budget.
+var errBudgetSpent = errors.New("cycle budget used up")
+
+// defaultBudgetWorkers is how many tasks run at once under a cycle budget
+// when daemon.collect.workers is unset.
+const defaultBudgetWorkers = 16
+
+// pluginPriority is the priority of a task that does not set one, by the
+// plugin name its metric starts with: status checks such as network.ping and
+// http.check first, then cheap polls, then the rest, such as SSH inventory.
+var pluginPriority = map[string]int{
+	"network":     20,
+	"http":        20,
+	"dns":         20,
+	"dbcheck":     20,
+	"certwatch":   20,
+	"backupcheck": 20,
+	"exec":        20,
+	"local":       10,
+	"snmp":        10,
+}
+
+// deferredTask is a task a cycle budget cut.
+type deferredTask struct {
+	Host   string `json:"host"`
+	Metric string `json:"metric"`
+}
+
+// budgetState is the content of budgetStateFile.
+type budgetState struct {
+	Deferred  []deferredTask              `json:"deferred"`
+	Durations map[string]map[string]int64 `json:"duration_ms"` // host → task → milliseconds of its last run
+}
+
+func loadBudgetState() budgetState {
+	var state budgetState
+	if data, err := os.ReadFile(plugin.StateFile(budgetStateFile)); err == nil {
+		json.Unmarshal(data, &state) //nolint:errcheck
+	}
+	if state.Durations == nil {
+		state.Durations = make(map[string]map[string]int64)
+	}
+	return state
+}
+
+func saveBudgetState(state budgetState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return plugin.WriteStateFile(budgetStateFile, data, 0644)
+}
+
+// queuedTask is a task waiting to run under a cycle budget.
+type queuedTask struct {
+	host     string
+	hostData plugin.Host
+	task     plugin.CollectTask
+	deferred bool          // cut by the previous collection
+	priority int           // higher runs first
+	estimate time.Duration // how long it took last time, 0 if unknown
+}
+
+// taskPriority returns the priority of a task: its own, or its plugin's.
+func taskPriority(task plugin.CollectTask) int {
+	if task.Priority != 0 {
+		return task.Priority
+	}
+	name, _, _ := strings.Cut(task.Metric, ".")
+	return pluginPriority[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// budgetQueue lists the tasks of every host in the order they run: those
+// deferred last time first, then by priority, then by host and task.
+func (p *collectionPlugin) budgetQueue(state budgetState) []queuedTask {
+	wasDeferred := make(map[deferredTask]bool, len(state.Deferred))
+	for _, d := range state.Deferred {
+		wasDeferred[d] = true
+	}
+	var queue []queuedTask
+	for hostName, host := range p.config.Hosts {
+		for _, task := range p.hostTasks(hostName, host) {
+			queue = append(queue, queuedTask{
+				host:     hostName,
+				hostData: host,
+				task:     task,
+				deferred: wasDeferred[deferredTask{Host: hostName, Metric: task.Metric}],
+				priority: taskPriority(task),
+				estimate: time.Duration(state.Durations[hostName][task.Metric]) * time.Millisecond,
+			})
+		}
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		a, b := queue[i], queue[j]
+		if a.deferred != b.deferred {
+			return a.deferred
+		}
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		return a.task.Metric < b.task.Metric
+	})
+	return queue
+}
+
+// budgetRun is what runBudgeted did with a queue.
+type budgetRun struct {
+	outcomes map[string][]taskOutcome // by host
+	deferred []queuedTask
+	took     map[deferredTask]time.Duration
+	elapsed  time.Duration
+}
+
+// runBudgeted runs the queue workers tasks at a time, in order. Once 80% of
+// the budget has passed no task is started, and a task whose last run would
+// take it past the budget is skipped, unless it was already deferred once so
+// it cannot be put off forever. A task still running when the budget ends is
+// abandoned. Skipped, unstarted and abandoned tasks are deferred.
+func (p *collectionPlugin) runBudgeted(queue []queuedTask, budget time.Duration, workers int, now func() time.Time) budgetRun {
+	start := now()
+	stopAt := start.Add(time.Duration(float64(budget) * budgetCutoff))
+	end := start.Add(budget)
+
+	run := budgetRun{outcomes: make(map[string][]taskOutcome), took: make(map[deferredTask]time.Duration)}
+	var (
+		mu   sync.Mutex
+		next int
+		wg   sync.WaitGroup
+	)
+	take := func() (queuedTask, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for next < len(queue) {
+			t := now()
+			if !t.Before(stopAt) {
+				return queuedTask{}, false
+			}
+			q := queue[next]
+			next++
+			if !q.deferred && q.estimate > 0 && t.Add(q.estimate).After(end) {
+				run.deferred = append(run.deferred, q)
+				continue
+			}
+			return q, true
+		}
+		return queuedTask{}, false
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				q, ok := take()
+				if !ok {
+					return
+				}
+				began := now()
+				out := make(chan taskOutcome, 1)
+				var taskWg sync.WaitGroup
+				taskWg.Add(1)
+				ctx, cancel := p.taskContext(context.Background())
+				ctx, cancelBudget := context.WithTimeoutCause(ctx, end.Sub(began), errBudgetSpent)
+				p.collectTask(ctx, q.host, q.hostData, q.task, out, &taskWg)
+				cancelBudget()
+				cancel()
+				took := now().Sub(began)
+
+				mu.Lock()
+				run.took[deferredTask{Host: q.host, Metric: q.task.Metric}] = took
+				select {
+				case o := <-out:
+					if o.task.Status == plugin.ResultDeferred {
+						run.deferred = append(run.deferred, q)
+					} else {
+						run.outcomes[q.host] = append(run.outcomes[q.host], o)
+					}
+				default:
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	run.deferred = append(run.deferred, queue[next:]...)
+	run.elapsed = now().Sub(start)
+	return run
+}
+
+// collectBudgeted collects every host within the cycle budget. Deferred
+// tasks show in their host's collections with status "deferred", are
+// counted in the cycle_budget_exceeded and deferred_tasks self-metrics, and
+// run first in the next collection. Hosts are written to the store after the
+// run, one after another, each with a write deadline of its own: which of a
+// host's tasks were deferred is only known once the budget is spent. now is
+// the clock the budget is measured with.
+func (p *collectionPlugin) collectBudgeted(budget time.Duration, workers int, now func() time.Time) []hostCollection {
+	if workers <= 0 {
+		workers = defaultBudgetWorkers
+	}
+	state := loadBudgetState()
+	queue := p.budgetQueue(state)
+
+	var estimate time.Duration
+	for _, q := range queue {
+		estimate += q.estimate
+	}
+	p.Controller.Printf("  |_ cycle budget %s: %d task(s) on %d worker(s), about %s of work known from last time\n",
+		budget, len(queue), workers, (estimate / time.Duration(workers)).Round(time.Second))
+
+	run := p.runBudgeted(queue, budget, workers, now)
+
+	state.Deferred = state.Deferred[:0]
+	for _, q := range run.deferred {
+		state.Deferred = append(state.Deferred, deferredTask{Host: q.host, Metric: q.task.Metric})
+		run.outcomes[q.host] = append(run.outcomes[q.host], taskOutcome{
+			task: plugin.TaskResult{Host: q.host, Task: q.task.Metric, Status: plugin.ResultDeferred, Error: "cycle budget used up"},
+		})
+	}
+	for t, d := range run.took {
+		if state.Durations[t.Host] == nil {
+			state.Durations[t.Host] = make(map[string]int64)
+		}
+		state.Durations[t.Host][t.Metric] = d.Milliseconds()
+	}
+	for host := range state.Durations {
+		if _, ok := p.config.Hosts[host]; !ok {
+			delete(state.Durations, host)
+		}
+	}
+	if err := saveBudgetState(state); err != nil {
+		p.Controller.Printf("  !_ could not save %s: %v\n", budgetStateFile, err)
+	}
+
+	p.Controller.Metrics.Set(plugin.SelfDeferredTasks, int64(len(run.deferred)))
+	if len(run.deferred) > 0 {
+		p.Controller.Metrics.Add(plugin.SelfBudgetExceeded, 1)
+		p.Controller.Printf("  !_ cycle budget %s: %d task(s) deferred to the next collection after %s\n",
+			budget, len(run.deferred), run.elapsed.Round(time.Millisecond))
+	} else {
+		p.Controller.Printf("  |_ cycle budget %s: collection took %s\n", budget, run.elapsed.Round(time.Millisecond))
+	}
+
+	collected := make([]hostCollection, 0, len(p.config.Hosts))
+	for hostName := range p.config.Hosts {
+		hc := p.assembleHost(hostName, run.outcomes[hostName])
+		p.writeHost(context.Background(), hc)
+		collected = append(collected, hc)
+	}
+	return collected
+}
@@ -0,0 +1,305 @@
+package collection
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"observer/base"
+	"observer/plugins"
+	_ "observer/plugins/backupcheck"
+	_ "observer/plugins/certwatch"
+	_ "observer/plugins/dbcheck"
+	_ "observer/plugins/dns"
+	_ "observer/plugins/execcheck"
+	_ "observer/plugins/httpcheck"
+	_ "observer/plugins/local"
+	_ "observer/plugins/network"
+)
+
+// fakeClock is a clock only the fake plugins move.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// slowPlugin takes cost of the fake clock to collect, and records its calls
+// as "action@address".
+type slowPlugin struct {
+	plugin.BasePlugin
+	name  string
+	cost  time.Duration
+	clock *fakeClock
+	calls *[]string
+	mu    *sync.Mutex
+}
+
+func (p *slowPlugin) Name() string { return p.name }
+
+func (p *slowPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	address, _ := options["host"].(map[string]interface{})["address"].(string)
+	p.mu.Lock()
+	*p.calls = append(*p.calls, fmt.Sprintf("%s.%s@%s", p.name, options["action"], address))
+	p.mu.Unlock()
+	p.clock.advance(p.cost)
+	return map[string]interface{}{"metrics": map[string]interface{}{"up": map[string]interface{}{"value": "up"}}}, nil
+}
+
+// budgetSetup returns a collection plugin over three hosts whose tasks run on
+// slow plugins: status checks take 1s, SNMP polls 2s and SSH inventory 5s.
+func budgetSetup(t *testing.T) (*collectionPlugin, *fakeClock, func() []string) {
+	t.Helper()
+	t.Setenv(plugin.EnvStateDir, t.TempDir())
+	plugin.LoadPaths()
+	t.Cleanup(plugin.LoadPaths)
+
+	clock := &fakeClock{t: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	var (
+		calls []string
+		mu    sync.Mutex
+	)
+	c := plugin.NewController()
+	for name, cost := range map[string]time.Duration{"http": time.Second, "snmp": 2 * time.Second, "sshcollect": 5 * time.Second} {
+		c.AddPlugin(&slowPlugin{name: name, cost: cost, clock: clock, calls: &calls, mu: &mu})
+	}
+	p := &collectionPlugin{config: &plugin.Config{Hosts: map[string]plugin.Host{
+		"core": {Address: "192.0.2.1", Collect: []plugin.CollectTask{
+			{Metric: "sshcollect.inventory"}, {Metric: "snmp.system"}, {Metric: "http.check"},
+		}},
+		"edge": {Address: "192.0.2.2", Collect: []plugin.CollectTask{
+			{Metric: "sshcollect.inventory"}, {Metric: "http.check"},
+		}},
+		"nas": {Address: "192.0.2.3", Collect: []plugin.CollectTask{
+			{Metric: "snmp.system", Priority: 30},
+		}},
+	}}}
+	p.Controller = c
+	taken := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		out := calls
+		calls = nil
+		return out
+	}
+	return p, clock, taken
+}
+
+// queueOrder lists a queue as "host metric" entries.
+func queueOrder(queue []queuedTask) []string {
+	out := make([]string, len(queue))
+	for i, q := range queue {
+		out[i] = q.host + " " + q.task.Metric
+	}
+	return out
+}
+
+func TestBudgetQueueOrder(t *testing.T) {
+	p, _, _ := budgetSetup(t)
+	state := budgetState{
+		Deferred:  []deferredTask{{Host: "edge", Metric: "sshcollect.inventory"}, {Host: "gone", Metric: "snmp.system"}},
+		Durations: map[string]map[string]int64{"core": {"sshcollect.inventory": 4500}},
+	}
+	queue := p.budgetQueue(state)
+	want := []string{
+		"edge sshcollect.inventory", // deferred last time
+		"nas snmp.system",           // its own priority, 30
+		"core http.check",       // status checks
+		"edge http.check",
+		"core snmp.system", // polls
+		"core sshcollect.inventory",
+	}
+	if got := queueOrder(queue); !reflect.DeepEqual(got, want) {
+		t.Errorf("queue = %q, want %q", got, want)
+	}
+	if q := queue[5]; q.estimate != 4500*time.Millisecond || q.deferred {
+		t.Errorf("core inventory = %+v", q)
+	}
+
+	for metric, want := range map[string]int{"http.check": 20, "network.ping": 20, "network.ssh": 20, "exec.run": 20, "SNMP.system": 10, "snmp": 10, "sshcollect.inventory": 0, "winrm.services": 0} {
+		if got := taskPriority(plugin.CollectTask{Metric: metric}); got != want {
+			t.Errorf("%s: priority %d, want %d", metric, got, want)
+		}
+	}
+	if got := taskPriority(plugin.CollectTask{Metric: "sshcollect.inventory", Priority: -5}); got != -5 {
+		t.Errorf("own priority ignored: %d", got)
+	}
+}
+
+// TestPluginPriorityNames checks that every plugin given a priority is
+// registered under that name, so its tasks match.
+func TestPluginPriorityNames(t *testing.T) {
+	registered := make(map[string]bool)
+	for _, p := range plugins.All {
+		registered[strings.ToLower(p.Name())] = true
+	}
+	for name := range pluginPriority {
+		if !registered[name] {
+			t.Errorf("pluginPriority has %q, which no plugin is registered as", name)
+		}
+	}
+}
+
+func TestRunBudgetedCutoff(t *testing.T) {
+	p, clock, taken := budgetSetup(t)
+	p.config = &plugin.Config{}
+	host := plugin.Host{Address: "192.0.2.1"}
+	task := func(metric string, estimate time.Duration, deferred bool) queuedTask {
+		return queuedTask{host: "core", hostData: host, task: plugin.CollectTask{Metric: metric}, estimate: estimate, deferred: deferred}
+	}
+	// A 10s budget: nothing starts after 8s, and a task known to take 5s
+	// does not start at 6s. One that was deferred already does.
+	queue := []queuedTask{
+		task("sshcollect.a", 0, false),             // 0s → 5s
+		task("http.b", 0, false),              // 5s → 6s
+		task("sshcollect.c", 5*time.Second, false), // would end at 11s: skipped
+		task("http.d", time.Second, false),    // 6s → 7s
+		task("sshcollect.e", 5*time.Second, true),  // 7s → 12s
+		task("http.f", 0, false),              // 12s: past the cutoff
+		task("http.g", 0, false),
+	}
+	run := p.runBudgeted(queue, 10*time.Second, 1, clock.now)
+
+	want := []string{"sshcollect.a@192.0.2.1", "http.b@192.0.2.1", "http.d@192.0.2.1", "sshcollect.e@192.0.2.1"}
+	if got := taken(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+	if got := queueOrder(run.deferred); !reflect.DeepEqual(got, []string{"core sshcollect.c", "core http.f", "core http.g"}) {
+		t.Errorf("deferred %q", got)
+	}
+	if run.elapsed != 12*time.Second || len(run.outcomes["core"]) != 4 {
+		t.Errorf("elapsed %s, %d outcomes", run.elapsed, len(run.outcomes["core"]))
+	}
+	if d := run.took[deferredTask{Host: "core", Metric: "sshcollect.e"}]; d != 5*time.Second {
+		t.Errorf("sshcollect.e took %s", d)
+	}
+
+	// Within budget nothing is deferred.
+	run = p.runBudgeted(queue[:2], time.Minute, 1, clock.now)
+	if len(run.deferred) != 0 || len(taken()) != 2 {
+		t.Errorf("deferred %q", queueOrder(run.deferred))
+	}
+}
+
+func TestCollectBudgetedDefersToNextCycle(t *testing.T) {
+	p, clock, taken := budgetSetup(t)
+
+	// The first cycle runs by priority and stops starting tasks at 8s of 10s.
+	collected := p.collectBudgeted(10*time.Second, 1, clock.now)
+	want := []string{
+		"snmp.system@192.0.2.3", "http.check@192.0.2.1", "http.check@192.0.2.2",
+		"snmp.system@192.0.2.1", "sshcollect.inventory@192.0.2.1", // starts at 6s
+	}
+	if got := taken(); !reflect.DeepEqual(got, want) {
+		t.Errorf("first cycle ran %q, want %q", got, want)
+	}
+	results := make(map[string]*plugin.HostResult)
+	for _, hc := range collected {
+		results[hc.key] = hc.result
+	}
+	edge := results["edge"]
+	if tr := edge.Collections["sshcollect.inventory"]; tr.Status != plugin.ResultDeferred || tr.Error == "" {
+		t.Errorf("edge inventory = %+v", tr)
+	}
+	if tr := edge.Collections["http.check"]; tr.Status != plugin.ResultOK {
+		t.Errorf("edge http = %+v", tr)
+	}
+	if len(edge.Errors) != 0 {
+		t.Errorf("a deferred task is reported as an error: %q", edge.Errors)
+	}
+	if got := p.Controller.Metrics.Get(plugin.SelfDeferredTasks); got != 1 {
+		t.Errorf("%s = %d", plugin.SelfDeferredTasks, got)
+	}
+	if got := p.Controller.Metrics.Get(plugin.SelfBudgetExceeded); got != 1 {
+		t.Errorf("%s = %d", plugin.SelfBudgetExceeded, got)
+	}
+	state := loadBudgetState()
+	if !reflect.DeepEqual(state.Deferred, []deferredTask{{Host: "edge", Metric: "sshcollect.inventory"}}) {
+		t.Errorf("state deferred = %+v", state.Deferred)
+	}
+	if got := state.Durations["core"]["sshcollect.inventory"]; got != 5000 {
+		t.Errorf("core inventory took %dms", got)
+	}
+
+	// The next cycle starts with the deferred task, then goes by priority.
+	p.collectBudgeted(10*time.Second, 1, clock.now)
+	want = []string{"sshcollect.inventory@192.0.2.2", "snmp.system@192.0.2.3", "http.check@192.0.2.1"}
+	if got := taken(); !reflect.DeepEqual(got, want) {
+		t.Errorf("second cycle ran %q, want %q", got, want)
+	}
+	if got := p.Controller.Metrics.Get(plugin.SelfBudgetExceeded); got != 2 {
+		t.Errorf("%s = %d", plugin.SelfBudgetExceeded, got)
+	}
+
+	// A long enough budget runs everything, the deferred first, and clears the list.
+	p.collectBudgeted(time.Minute, 1, clock.now)
+	if got := taken(); len(got) != 6 || got[0] != "http.check@192.0.2.2" {
+		t.Errorf("third cycle ran %q", got)
+	}
+	if state := loadBudgetState(); len(state.Deferred) != 0 || p.Controller.Metrics.Get(plugin.SelfDeferredTasks) != 0 {
+		t.Errorf("still deferred: %+v", state.Deferred)
+	}
+
+	// Hosts no longer configured are dropped from the durations.
+	delete(p.config.Hosts, "nas")
+	p.collectBudgeted(time.Minute, 1, clock.now)
+	if _, ok := loadBudgetState().Durations["nas"]; ok {
+		t.Error("durations kept for a removed host")
+	}
+	if _, err := os.Stat(plugin.StateFile(budgetStateFile)); err != nil {
+		t.Error(err)
+	}
+}
+
+// stuckPlugin does not return until release is closed.
+type stuckPlugin struct {
+	plugin.BasePlugin
+	release chan struct{}
+}
+
+func (p *stuckPlugin) Name() string { return "sshcollect" }
+
+func (p *stuckPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	<-p.release
+	return map[string]interface{}{}, nil
+}
+
+func TestRunBudgetedAbandonsAtBudgetEnd(t *testing.T) {
+	p, _, _ := budgetSetup(t)
+	p.config = &plugin.Config{}
+	stuck := &stuckPlugin{release: make(chan struct{})}
+	defer close(stuck.release)
+	p.Controller.AddPlugin(stuck)
+
+	queue := []queuedTask{{host: "core", hostData: plugin.Host{Address: "192.0.2.1"}, task: plugin.CollectTask{Metric: "sshcollect.inventory"}}}
+	budget := 100 * time.Millisecond
+	run := p.runBudgeted(queue, budget, 1, time.Now)
+
+	if run.elapsed < budget || run.elapsed > budget+time.Second {
+		t.Errorf("elapsed %s with a %s budget", run.elapsed, budget)
+	}
+	if got := queueOrder(run.deferred); !reflect.DeepEqual(got, []string{"core sshcollect.inventory"}) {
+		t.Errorf("deferred %q", got)
+	}
+	if len(run.outcomes["core"]) != 0 {
+		t.Errorf("an abandoned task is reported: %+v", run.outcomes["core"])
+	}
+	if d := run.took[deferredTask{Host: "core", Metric: "sshcollect.inventory"}]; d < budget {
+		t.Errorf("took %s", d)
+	}
+}
@@ -1,13 +1,16 @@
 package collection
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"observer/base"
 	"observer/plugins"
 	snmpplugin "observer/plugins/snmp"
 	"observer/store"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -21,32 +24,199 @@ type collectionPlugin struct {
 	config           *plugin.Config
 	rawCollect       map[string][]map[string]interface{} // normalized collect per host (fallback by key)
 	rawCollectByAddr map[string][]map[string]interface{} // normalized collect per address (fallback by address)
+
+	runMu    sync.Mutex           // serializes runs: config reloads and collection.json writes
+	flightMu sync.Mutex           // guards inFlight
+	inFlight map[string]*hostCall // per-host collections currently running
+
+	discovered  map[string]plugin.Host      // hosts published by perception in this process, nil if none
+	maintenance *plugin.MaintenanceSchedule // windows of the current run, set by loadHosts
+}
+
+// hostCollection is what collectHost gathered for one host: its typed result
+// plus the interface and link entities, which only go to the store.
+type hostCollection struct {
+	key        string
+	result     *plugin.HostResult
+	interfaces []map[string]interface{}
+	links      []map[string]interface{}
+}
+
+// taskOutcome is what one task handed back to collectHost.
+type taskOutcome struct {
+	task   plugin.TaskResult
+	plugin string                 // plugin name as written in the task
+	result map[string]interface{} // OnCollect's result; nil when the task failed
+}
+
+// hostCall is an in-progress on-demand collection that concurrent callers share.
+type hostCall struct {
+	done chan struct{}
+	err  error
 }
 
 func init() {
 	plugins.Register(&collectionPlugin{})
 }
 
+// Init subscribes to perception results so a combined run can skip perception.json.
+func (p *collectionPlugin) Init(c *plugin.Controller) {
+	p.BasePlugin.Init(c)
+	c.Subscribe(plugin.EventHostsDiscovered, func(e plugin.Event) {
+		if hosts, ok := e.Data.(map[string]plugin.Host); ok {
+			p.runMu.Lock()
+			p.discovered = hosts
+			p.runMu.Unlock()
+		}
+	})
+}
+
 // Name returns the plugin's name.
 func (p *collectionPlugin) Name() string {
 	return "Collection"
 }
 
+// Actions advertises putting a host in maintenance from the UI.
+func (p *collectionPlugin) Actions() []plugin.ActionSpec {
+	return []plugin.ActionSpec{
+		{Action: "maintenance", Label: "Start maintenance (0 ends it)", Safety: plugin.SafetyWrite,
+			Params: []plugin.ActionParam{{Name: "duration", Label: "Duration", Default: "2h"}}},
+	}
+}
+
 // OnCommand handles the primary "collect" action.
+// "collect host=<key>" collects a single host instead of the full inventory.
+// "maintenance host=<key> duration=2h" puts a host in maintenance from now.
+// "import source=csv file=<path>" or "import source=netbox url=<url>" adds
+// hosts from an inventory; it is a dry run without write=true or out=<file>.
 func (p *collectionPlugin) OnCommand(args map[string]string) error {
 	action, ok := args["action"]
+	if ok && action == "maintenance" {
+		return p.setMaintenance(parseArgs(args["args"]), time.Now())
+	}
+	if ok && action == "import" {
+		p.Controller.Println("-- Importing Hosts --")
+		return p.importHosts(parseArgs(args["args"]))
+	}
 	if !ok || action != "collect" {
 		return fmt.Errorf("unknown action for Collection plugin: %v", args)
 	}
 
-	fmt.Println("-- Running Data Collection --")
+	if hostKey := parseArgs(args["args"])["host"]; hostKey != "" {
+		p.Controller.Printf("-- Running Data Collection for %s --\n", hostKey)
+		return p.CollectHost(hostKey)
+	}
+
+	p.Controller.Println("-- Running Data Collection --")
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
 	return p.collectData()
 }
 
+// CollectHost collects a single host in-process, writes its records to the store,
+// and merges its entry into collection.json. Concurrent calls for the same host
+// are coalesced: later callers wait for and share the running call's result.
+func (p *collectionPlugin) CollectHost(hostKey string) error {
+	p.flightMu.Lock()
+	if p.inFlight == nil {
+		p.inFlight = make(map[string]*hostCall)
+	}
+	if call, ok := p.inFlight[hostKey]; ok {
+		p.flightMu.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &hostCall{done: make(chan struct{})}
+	p.inFlight[hostKey] = call
+	p.flightMu.Unlock()
+
+	call.err = p.collectSingleHost(hostKey)
+
+	p.flightMu.Lock()
+	delete(p.inFlight, hostKey)
+	p.flightMu.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// collectSingleHost performs the work behind CollectHost.
+func (p *collectionPlugin) collectSingleHost(hostKey string) error {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+
+	if err := p.loadHosts(); err != nil {
+		return err
+	}
+	host, ok := p.config.Hosts[hostKey]
+	if !ok {
+		return fmt.Errorf("host '%s' not found in config or perception", hostKey)
+	}
+
+	var wg sync.WaitGroup
+	resultsChan := make(chan hostCollection, 1)
+	wg.Add(1)
+	p.collectHost(hostKey, host, resultsChan, &wg)
+	wg.Wait()
+	close(resultsChan)
+	hc := <-resultsChan
+
+	p.publishDone([]hostCollection{hc})
+
+	// Merge into the last results so other hosts' data is kept.
+	merged, err := plugin.ReadResults()
+	if err != nil {
+		merged = make(plugin.Results)
+	}
+	merged[hostKey] = hc.result
+	return p.saveCollection(merged)
+}
+
+// setMaintenance sets an ad-hoc maintenance window of duration= on host=,
+// persisted in the state directory; duration=0 ends it. Without host= it
+// lists the ad-hoc windows in force.
+func (p *collectionPlugin) setMaintenance(args map[string]string, now time.Time) error {
+	hostKey := args["host"]
+	if hostKey == "" {
+		windows := plugin.NewMaintenanceSchedule(nil).AdHoc(now)
+		if len(windows) == 0 {
+			p.Controller.Println("  |_ no ad-hoc maintenance windows")
+		}
+		for _, w := range windows {
+			p.Controller.Printf("  |_ %s\n", w)
+		}
+		return nil
+	}
+	d, err := time.ParseDuration(args["duration"])
+	if err != nil {
+		return fmt.Errorf("maintenance needs duration=<Go duration>, e.g. duration=2h")
+	}
+	if err := plugin.SetMaintenance(hostKey, d, now); err != nil {
+		return fmt.Errorf("could not save maintenance window: %w", err)
+	}
+	if d <= 0 {
+		p.Controller.Printf("  |_ %s: maintenance ended\n", hostKey)
+	} else {
+		p.Controller.Printf("  |_ %s: in maintenance until %s\n", hostKey, now.Add(d).Format(time.RFC3339))
+	}
+	return nil
+}
+
+// parseArgs parses space-separated key=value pairs from the command's args string.
+func parseArgs(argsStr string) map[string]string {
+	result := make(map[string]string)
+	for _, part := range strings.Fields(argsStr) {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			result[kv[0]] = kv[1]
+		}
+	}
+	return result
+}
+
 // loadConfig reads and parses the config.json file.
 func (p *collectionPlugin) loadConfig() error {
 	// Read raw config file
-	configFile, err := ioutil.ReadFile("data/config.json")
+	configFile, err := plugin.ReadConfigFile()
 	if err != nil {
 		return fmt.Errorf("could not read config file: %w", err)
 	}
@@ -149,8 +319,9 @@ func (p *collectionPlugin) loadConfig() error {
 	return nil
 }
 
-// collectTask handles a single task (check) for a host.
-func (p *collectionPlugin) collectTask(hostName string, host plugin.Host, task plugin.CollectTask, taskResultsChan chan<- map[string]interface{}, wg *sync.WaitGroup) {
+// collectTask handles a single task (check) for a host. When ctx is done
+// before the task, the task is abandoned and reported as failed.
+func (p *collectionPlugin) collectTask(ctx context.Context, hostName string, host plugin.Host, task plugin.CollectTask, outcomes chan<- taskOutcome, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	metric := strings.TrimSpace(task.Metric)
@@ -167,12 +338,14 @@ func (p *collectionPlugin) collectTask(hostName string, host plugin.Host, task p
 		action = "all"
 	}
 
-	fmt.Printf("  |_ %s : %s.%s\n", hostName, pluginName, action)
+	p.Controller.Printf("  |_ %s : %s.%s\n", hostName, pluginName, action)
 
 	pluginKey := strings.ToLower(pluginName)
 	targetPlugin, exists := p.Controller.Plugins[pluginKey]
 	if !exists {
-		fmt.Printf("  !_ %s: Plugin '%s' not found.\n", hostName, pluginName)
+		p.Controller.Printf("  !_ %s: Plugin '%s' not found.\n", hostName, pluginName)
+		tr := p.publishTask(hostName, metric, fmt.Errorf("plugin '%s' not found", pluginName), nil)
+		outcomes <- taskOutcome{task: tr, plugin: pluginName}
 		return
 	}
 
@@ -191,42 +364,211 @@ func (p *collectionPlugin) collectTask(hostName string, host plugin.Host, task p
 		},
 	}
 
+	if len(task.Options) > 0 {
+		pluginOptions["options"] = task.Options
+	}
+
 	if c := strings.TrimSpace(task.Credentials); c != "" {
 		pluginOptions["collection"].(map[string]interface{})["credentials"] = c
 		if cred, ok := p.config.Credentials[c]; ok {
 			pluginOptions["credentials"] = map[string]interface{}{
-				"user": cred.User,
-				"pass": cred.Pass,
-				"host": cred.Host,
-				"port": fmt.Sprintf("%d", cred.Port),
-				"type": cred.Type,
+				"user":       cred.User,
+				"pass":       cred.Pass,
+				"host":       cred.Host,
+				"port":       fmt.Sprintf("%d", cred.Port),
+				"type":       cred.Type,
+				"token":      cred.Token,
+				"region":     cred.Region,
+				"insecure":   cred.Insecure,
+				"ca_file":    cred.CAFile,
+				"kubeconfig": cred.Kubeconfig,
+				"context":    cred.Context,
 			}
 		} else {
-			fmt.Printf("          !_ %s | Credentials '%s' not found.\n", hostName, c)
+			p.Controller.Printf("          !_ %s | Credentials '%s' not found.\n", hostName, c)
 		}
 	}
 
-	result, err := targetPlugin.OnCollect(pluginOptions)
+	targets, notes, err := task.Targets(host.Address)
 	if err != nil {
-		fmt.Printf("          !_ %s | Error: %v\n", hostName, err)
+		p.Controller.Printf("          !_ %s | Error: %v\n", hostName, err)
+		tr := p.publishTask(hostName, metric, err, nil)
+		outcomes <- taskOutcome{task: tr, plugin: pluginName}
 		return
 	}
+	for _, note := range notes {
+		p.Controller.Printf("          !_ %s | %s\n", hostName, note)
+	}
+
+	result, err := p.runTargetsContext(ctx, targetPlugin, pluginOptions, targets)
+	tr := p.publishTask(hostName, metric, err, result)
+	if err != nil {
+		p.Controller.Printf("          !_ %s | Error: %v\n", hostName, err)
+		result = nil
+	}
+	outcomes <- taskOutcome{task: tr, plugin: pluginName, result: result}
+}
+
+// runTargetsContext is runTargets, given up on when ctx is done first.
+// OnCollect takes no context, so an abandoned call is left to finish in the
+// background and its result is dropped.
+func (p *collectionPlugin) runTargetsContext(ctx context.Context, target plugin.Plugin, options map[string]interface{}, targets []plugin.Target) (map[string]interface{}, error) {
+	type ran struct {
+		result map[string]interface{}
+		err    error
+	}
+	done := make(chan ran, 1)
+	go func() {
+		result, err := p.runTargets(target, options, targets)
+		done <- ran{result, err}
+	}()
+	select {
+	case r := <-done:
+		return r.result, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("abandoned: %w", context.Cause(ctx))
+	}
+}
+
+// runTargets runs a task once, against the host's address or its single
+// resolved target, or once per target of a "both" task. Then every metric is
+// instanced by its family, "ipv4" or "ipv6" (appended to an instance it
+// already has), and labelled with it, so the families are separate series.
+// A family whose run fails is reported and left out.
+func (p *collectionPlugin) runTargets(target plugin.Plugin, options map[string]interface{}, targets []plugin.Target) (map[string]interface{}, error) {
+	switch len(targets) {
+	case 0:
+		return target.OnCollect(options)
+	case 1:
+		options["target"] = targets[0]
+		return target.OnCollect(options)
+	}
+
+	var merged map[string]interface{}
+	metrics := map[string]interface{}{}
+	var errs []string
+	for _, t := range targets {
+		opts := make(map[string]interface{}, len(options)+1)
+		for k, v := range options {
+			opts[k] = v
+		}
+		opts["target"] = t
+		result, err := target.OnCollect(opts)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", t.Family, err))
+			continue
+		}
+		if merged == nil {
+			merged = result // entity data such as interfaces comes from the first family
+		}
+		familyMetrics, _ := result["metrics"].(map[string]interface{})
+		for label, m := range familyMetrics {
+			mm, ok := m.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			copied := make(map[string]interface{}, len(mm)+1)
+			for k, v := range mm {
+				copied[k] = v
+			}
+			instance, _ := mm["instance"].(string)
+			if instance == "" {
+				copied["instance"] = t.Family
+			} else {
+				copied["instance"] = instance + "/" + t.Family
+			}
+			metrics[label+"_"+t.Family] = copied
+		}
+	}
+	if merged == nil {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	for _, e := range errs {
+		p.Controller.Printf("          !_ %s\n", e)
+	}
+	merged["metrics"] = metrics
+	return merged, nil
+}
 
-	if result != nil {
-		// Tag the result with the plugin name so the store writer can record it.
-		result["__plugin"] = pluginName
-		taskResultsChan <- result
+// publishTask reports the outcome of one task on the controller's event bus
+// and returns it. A task abandoned when the cycle budget ran out is deferred,
+// not failed.
+func (p *collectionPlugin) publishTask(hostName, metric string, err error, result map[string]interface{}) plugin.TaskResult {
+	tr := plugin.TaskResult{Host: hostName, Task: metric, Status: plugin.ResultOK}
+	switch {
+	case errors.Is(err, errBudgetSpent):
+		tr.Status, tr.Error = plugin.ResultDeferred, err.Error()
+	case err != nil:
+		tr.Status, tr.Error = plugin.ResultError, err.Error()
+	}
+	if metrics, ok := result["metrics"].(map[string]interface{}); ok {
+		tr.Metrics = len(metrics)
+	}
+	p.Controller.Publish(plugin.Event{Topic: plugin.EventTaskResult, Source: "collection", Data: tr})
+	return tr
+}
+
+// publishDone reports the hosts just collected, so that consumers of the store
+// such as alert rules can act on the fresh data.
+func (p *collectionPlugin) publishDone(collected []hostCollection) {
+	hosts := make([]string, 0, len(collected))
+	for _, hc := range collected {
+		hosts = append(hosts, hc.key)
 	}
+	sort.Strings(hosts)
+	p.Controller.Publish(plugin.Event{Topic: plugin.EventCollectionDone, Source: "collection", Data: hosts})
 }
 
-// collectHost handles data collection for a single host.
-func (p *collectionPlugin) collectHost(hostName string, host plugin.Host, resultsChan chan<- map[string]interface{}, wg *sync.WaitGroup) {
+// collectHost handles data collection for a single host, then writes what it
+// gathered to the store. The host has a context of its own: its tasks are
+// abandoned once daemon.collect.host_timeout passes, and its writes have their
+// own database.write_timeout, so a slow host holds up neither the other
+// hosts' tasks nor their writes.
+func (p *collectionPlugin) collectHost(hostName string, host plugin.Host, resultsChan chan<- hostCollection, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	fmt.Printf("  |_ %s (%s)\n", hostName, host.Address)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p.Controller.Printf("  |_ %s (%s)\n", hostName, host.Address)
+
+	tasks := p.hostTasks(hostName, host)
+
+	taskCtx, cancelTasks := p.taskContext(ctx)
+	defer cancelTasks()
+
+	var taskWg sync.WaitGroup
+	outcomes := make(chan taskOutcome, len(tasks))
+
+	for _, task := range tasks {
+		taskWg.Add(1)
+		go p.collectTask(taskCtx, hostName, host, task, outcomes, &taskWg)
+	}
+
+	taskWg.Wait()
+	close(outcomes)
+
+	var gathered []taskOutcome
+	for o := range outcomes {
+		gathered = append(gathered, o)
+	}
+	hc := p.assembleHost(hostName, gathered)
+	p.writeHost(ctx, hc)
+	resultsChan <- hc
+}
 
-	hostMetrics := make(map[string]interface{})
+// taskContext returns a child of ctx done after daemon.collect.host_timeout.
+func (p *collectionPlugin) taskContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, err := p.config.Daemon.Collect.HostDeadline()
+	if err != nil {
+		timeout = plugin.DefaultHostTimeout
+	}
+	return context.WithTimeoutCause(ctx, timeout, fmt.Errorf("host_timeout of %s passed", timeout))
+}
 
+// hostTasks returns the tasks of a host: its configured collect entries,
+// then any the raw config cache has for its key or address.
+func (p *collectionPlugin) hostTasks(hostName string, host plugin.Host) []plugin.CollectTask {
 	tasks := make([]plugin.CollectTask, 0, len(host.Collect))
 	metricsSet := map[string]struct{}{}
 
@@ -277,245 +619,293 @@ func (p *collectionPlugin) collectHost(hostName string, host plugin.Host, result
 			metricsSet[m] = struct{}{}
 		}
 	}
+	return tasks
+}
 
-	var taskWg sync.WaitGroup
-	taskResultsChan := make(chan map[string]interface{}, len(tasks))
-
-	for _, task := range tasks {
-		taskWg.Add(1)
-		go p.collectTask(hostName, host, task, taskResultsChan, &taskWg)
+// assembleHost builds the result of a host from the outcomes of its tasks.
+func (p *collectionPlugin) assembleHost(hostName string, outcomes []taskOutcome) hostCollection {
+	hc := hostCollection{
+		key:    hostName,
+		result: &plugin.HostResult{Metrics: []plugin.MetricResult{}, Collections: map[string]plugin.TaskResult{}, Errors: []string{}},
 	}
+	byLabel := make(map[string]plugin.MetricResult)
 
-	taskWg.Wait()
-	close(taskResultsChan)
-
-	var hostInterfaces []map[string]interface{}
-
-	for taskResult := range taskResultsChan {
-		pluginTag, _ := taskResult["__plugin"].(string)
+	for _, o := range outcomes {
+		hc.result.Collections[o.task.Task] = o.task
+		if o.task.Error != "" && o.task.Status != plugin.ResultDeferred {
+			hc.result.Errors = append(hc.result.Errors, o.task.Task+": "+o.task.Error)
+		}
 
-		if metricsAny, ok := taskResult["metrics"]; ok {
-			if metricsMap, ok := metricsAny.(map[string]interface{}); ok {
-				for label, metric := range metricsMap {
-					// Propagate plugin name into each metric map for store writer.
-					if pluginTag != "" {
-						if m, ok := metric.(map[string]interface{}); ok {
-							m["__plugin"] = pluginTag
-						}
-					}
-					hostMetrics[label] = metric
+		if metricsMap, ok := o.result["metrics"].(map[string]interface{}); ok {
+			for label, metric := range metricsMap {
+				if m, ok := metric.(map[string]interface{}); ok {
+					byLabel[label] = plugin.NewMetricResult(label, o.plugin, m)
 				}
 			}
 		}
 
 		// Collect interface entity data returned by SNMP table walks.
-		if ifacesAny, ok := taskResult["interfaces"]; ok {
-			if ifaces, ok := ifacesAny.([]map[string]interface{}); ok {
-				hostInterfaces = append(hostInterfaces, ifaces...)
-			}
+		if ifaces, ok := o.result["interfaces"].([]map[string]interface{}); ok {
+			hc.interfaces = append(hc.interfaces, ifaces...)
+		}
+
+		// Collect LLDP/CDP neighbors returned by snmp.neighbors.
+		if links, ok := o.result["links"].([]map[string]interface{}); ok {
+			hc.links = append(hc.links, links...)
 		}
 	}
 
-	resultsChan <- map[string]interface{}{
-		hostName: map[string]interface{}{
-			"metrics": map[string]interface{}{
-				"metrics": hostMetrics,
-			},
-			"__interfaces": hostInterfaces,
-		},
+	// Metrics collected during maintenance are kept but flagged, so alerts
+	// and availability reports can leave them out.
+	window, inMaintenance := "", false
+	if p.maintenance != nil {
+		window, inMaintenance = p.maintenance.Active(hostName, time.Now())
+	}
+	if inMaintenance {
+		p.Controller.Printf("  |_ %s is in maintenance (%s)\n", hostName, window)
+	}
+	for _, m := range byLabel {
+		if inMaintenance {
+			if m.Extra == nil {
+				m.Extra = make(map[string]interface{})
+			}
+			m.Extra["maintenance"] = window
+		}
+		hc.result.Metrics = append(hc.result.Metrics, m)
 	}
+	hc.result.SortMetrics()
+	sort.Strings(hc.result.Errors)
+
+	return hc
 }
 
-// collectData mimics the logic from the PHP on_collect method.
-func (p *collectionPlugin) collectData() error {
+// loadHosts loads config.json and merges in hosts discovered by perception.
+func (p *collectionPlugin) loadHosts() error {
 	if err := p.loadConfig(); err != nil {
 		return err
 	}
+	if p.config.Hosts == nil {
+		p.config.Hosts = make(map[string]plugin.Host)
+	}
+	p.maintenance = plugin.NewMaintenanceSchedule(p.config)
+
+	// --- Merge hosts from a perception run in this process, if any ---
+	if p.discovered != nil {
+		p.Controller.Println(". |_ Merging hosts discovered in this run")
+		for ip, host := range p.discovered {
+			p.mergeDiscovered(ip, host)
+		}
+		return nil
+	}
 
 	// --- Load and merge hosts from perception.json ---
 	type PerceptionData struct {
 		Hosts map[string]plugin.Host `json:"hosts"`
 	}
-	perceptionFile, err := ioutil.ReadFile("data/perception.json")
+	perceptionFile, err := ioutil.ReadFile(plugin.DataFile("perception.json"))
 	if err == nil {
 		var perceptionData PerceptionData
 		if json.Unmarshal(perceptionFile, &perceptionData) == nil {
-			fmt.Println(". |_ Merging hosts from perception.json")
+			p.Controller.Println(". |_ Merging hosts from perception.json")
 			for ip, host := range perceptionData.Hosts {
-				if _, exists := p.config.Hosts[ip]; !exists {
-					p.config.Hosts[ip] = host
-				}
+				p.mergeDiscovered(ip, host)
 			}
 		}
 	} else {
-		fmt.Println("  |_ perception.json not found, skipping merge.")
+		p.Controller.Println("  |_ perception.json not found, skipping merge.")
 	}
+	return nil
+}
 
-	finalResults := make(map[string]interface{})
+// mergeDiscovered adds a host found by perception unless it is configured,
+// under its address as key or under a name with the same address.
+func (p *collectionPlugin) mergeDiscovered(ip string, host plugin.Host) {
+	if _, exists := p.config.Hosts[ip]; exists {
+		return
+	}
+	if _, configured := p.config.HostByAddress(host.Address); configured {
+		return
+	}
+	p.config.Hosts[ip] = host
+}
 
-	var wg sync.WaitGroup
-	resultsChan := make(chan map[string]interface{}, len(p.config.Hosts))
+// collectData mimics the logic from the PHP on_collect method.
+func (p *collectionPlugin) collectData() error {
+	if err := p.loadHosts(); err != nil {
+		return err
+	}
 
-	for hostName, host := range p.config.Hosts {
-		wg.Add(1)
-		go p.collectHost(hostName, host, resultsChan, &wg)
+	budget, err := p.config.Daemon.Collect.Budget()
+	if err != nil {
+		return fmt.Errorf("daemon.collect: %w", err)
 	}
 
-	wg.Wait()
-	close(resultsChan)
+	var collected []hostCollection
+	if budget > 0 {
+		collected = p.collectBudgeted(budget, p.config.Daemon.Collect.Workers, time.Now)
+	} else {
+		var wg sync.WaitGroup
+		resultsChan := make(chan hostCollection, len(p.config.Hosts))
 
-	for hostResult := range resultsChan {
-		for hostName, metrics := range hostResult {
-			finalResults[hostName] = metrics
+		for hostName, host := range p.config.Hosts {
+			wg.Add(1)
+			go p.collectHost(hostName, host, resultsChan, &wg)
+		}
+
+		wg.Wait()
+		close(resultsChan)
+
+		for hc := range resultsChan {
+			collected = append(collected, hc)
 		}
 	}
 
-	// --- Write to store ---
-	if p.Controller.Store != nil {
-		p.writeToStore(finalResults)
+	results := make(plugin.Results)
+	for _, hc := range collected {
+		results[hc.key] = hc.result
 	}
 
-	// --- Strip internal tags and write JSON ---
-	p.stripInternalTags(finalResults)
+	p.publishDone(collected)
 
-	jsonData, err := json.MarshalIndent(finalResults, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal results to JSON: %w", err)
-	}
+	return p.saveCollection(results)
+}
 
-	err = ioutil.WriteFile("data/collection.json", jsonData, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write collection.json: %w", err)
+// saveCollection writes the results to data/collection.json in the legacy
+// schema and to data/results.json in the flat one.
+func (p *collectionPlugin) saveCollection(results plugin.Results) error {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"collection.json", results.Legacy()},
+		{plugin.ResultsFile, results},
+	}
+	for _, f := range files {
+		jsonData, err := json.MarshalIndent(f.data, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal results to JSON: %w", err)
+		}
+		if err := plugin.WriteDataFile(f.name, jsonData, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
 	}
 
-	fmt.Println("--- Collection finished, results saved to collection.json ---")
+	p.Controller.Println("--- Collection finished, results saved to collection.json ---")
 	return nil
 }
 
-// writeToStore builds MetricRecords and InterfaceRecords from finalResults and persists them.
-func (p *collectionPlugin) writeToStore(finalResults map[string]interface{}) {
+// writeHost builds MetricRecords, InterfaceRecords and LinkRecords from a
+// collected host and persists them. The writes share a deadline of
+// database.write_timeout under ctx, so a hung database cannot hold up the
+// run; a write cut short is rolled back.
+func (p *collectionPlugin) writeHost(ctx context.Context, hc hostCollection) {
+	if p.Controller.Store == nil {
+		return
+	}
+	timeout, err := p.config.Database.WriteDeadline()
+	if err != nil {
+		timeout = plugin.DefaultWriteTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	now := time.Now()
 	var metricRecords []store.MetricRecord
 	var ifaceRecords []store.InterfaceRecord
+	var linkRecords []store.LinkRecord
 
-	for hostKey, hostDataAny := range finalResults {
-		hostDataMap, ok := hostDataAny.(map[string]interface{})
-		if !ok {
-			continue
-		}
+	hostKey := hc.key
 
-		// Look up host inventory info.
-		hostName := hostKey
-		hostAddress := ""
-		if h, ok := p.config.Hosts[hostKey]; ok {
-			if h.Name != "" {
-				hostName = h.Name
-			}
-			hostAddress = h.Address
+	// Look up host inventory info.
+	hostName := hostKey
+	hostAddress := ""
+	if h, ok := p.config.Hosts[hostKey]; ok {
+		if h.Name != "" {
+			hostName = h.Name
 		}
+		hostAddress = h.Address
+	}
 
-		// --- Metric records ---
-		metricsWrapper, ok := hostDataMap["metrics"].(map[string]interface{})
-		if ok {
-			metricsMap, ok := metricsWrapper["metrics"].(map[string]interface{})
-			if ok {
-				for _, metricAny := range metricsMap {
-					m, ok := metricAny.(map[string]interface{})
-					if !ok {
-						continue
-					}
-
-					pluginTag, _ := m["__plugin"].(string)
-					metricName, _ := m["name"].(string)
-					if metricName == "" {
-						metricName, _ = m["label"].(string)
-					}
-					category, _ := m["category"].(string)
-					metricType, _ := m["type"].(string)
-					instance, _ := m["instance"].(string)
-					value := fmt.Sprintf("%v", m["value"])
-
-					// Any non-standard key becomes extra metadata (e.g. "oid").
-					var extra map[string]interface{}
-					for k, v := range m {
-						switch k {
-						case "name", "label", "value", "type", "category", "__plugin", "instance":
-							// standard keys — skip
-						default:
-							if extra == nil {
-								extra = make(map[string]interface{})
-							}
-							extra[k] = v
-						}
-					}
-
-					metricRecords = append(metricRecords, store.MetricRecord{
-						HostKey:     hostKey,
-						HostName:    hostName,
-						HostAddress: hostAddress,
-						Plugin:      pluginTag,
-						Name:        metricName,
-						Category:    category,
-						MetricType:  metricType,
-						Value:       value,
-						ValueNum:    store.ParseValueNum(value),
-						Instance:    instance,
-						Extra:       extra,
-						CollectedAt: now,
-					})
+	// --- Metric records ---
+	for _, m := range hc.result.Metrics {
+		metricName := m.Name
+		if metricName == "" {
+			metricName, _ = m.Extra["label"].(string)
+		}
+		value := fmt.Sprintf("%v", m.Value)
+		valueNum := store.ParseValueNum(value)
+		// A plugin may round the display value and keep full precision in value_num.
+		if n, ok := m.Extra["value_num"].(float64); ok {
+			valueNum = &n
+		}
+		dedup, _ := m.Extra["dedup"].(bool)
+
+		// Any non-standard key becomes extra metadata (e.g. "oid").
+		var extra map[string]interface{}
+		for k, v := range m.Extra {
+			switch k {
+			case "label", "value_num", "dedup":
+				// standard keys — skip
+			default:
+				if extra == nil {
+					extra = make(map[string]interface{})
 				}
+				extra[k] = v
 			}
 		}
 
-		// --- Interface entity records ---
-		if ifacesAny, ok := hostDataMap["__interfaces"]; ok {
-			if ifaces, ok := ifacesAny.([]map[string]interface{}); ok && len(ifaces) > 0 {
-				ifaceRecords = append(ifaceRecords,
-					snmpplugin.InterfaceListToRecords(hostKey, hostName, hostAddress, ifaces)...)
-			}
-		}
+		metricRecords = append(metricRecords, store.MetricRecord{
+			HostKey:     hostKey,
+			HostName:    hostName,
+			HostAddress: hostAddress,
+			Plugin:      m.Plugin,
+			Name:        metricName,
+			Category:    m.Category,
+			MetricType:  m.Type,
+			Value:       value,
+			ValueNum:    valueNum,
+			Values:      store.ValuesFrom(m.Value),
+			Unit:        m.Unit,
+			Instance:    m.Instance,
+			Extra:       extra,
+			CollectedAt: now,
+			Dedup:       dedup,
+		})
+	}
+
+	// --- Interface entity records ---
+	if len(hc.interfaces) > 0 {
+		ifaceRecords = append(ifaceRecords,
+			snmpplugin.InterfaceListToRecords(hostKey, hostName, hostAddress, hc.interfaces)...)
+	}
+
+	// --- Neighbor link records ---
+	if len(hc.links) > 0 {
+		linkRecords = append(linkRecords,
+			snmpplugin.LinkListToRecords(hostKey, hostName, hostAddress, hc.links)...)
 	}
 
 	if len(metricRecords) > 0 {
-		if err := p.Controller.Store.WriteBatch(metricRecords); err != nil {
-			fmt.Printf("  !_ store: WriteBatch error: %v\n", err)
+		if err := p.Controller.Store.WriteBatch(ctx, metricRecords); err != nil {
+			p.Controller.Printf("  !_ store: %s: WriteBatch error: %v\n", hostKey, err)
 		} else {
-			fmt.Printf("  |_ store: wrote %d metric records\n", len(metricRecords))
+			p.Controller.Printf("  |_ store: %s: wrote %d metric records\n", hostKey, len(metricRecords))
 		}
 	}
 
 	if len(ifaceRecords) > 0 {
-		if err := p.Controller.Store.UpsertInterfaces(ifaceRecords); err != nil {
-			fmt.Printf("  !_ store: UpsertInterfaces error: %v\n", err)
+		if err := p.Controller.Store.UpsertInterfaces(ctx, ifaceRecords); err != nil {
+			p.Controller.Printf("  !_ store: %s: UpsertInterfaces error: %v\n", hostKey, err)
 		} else {
-			fmt.Printf("  |_ store: upserted %d interface records\n", len(ifaceRecords))
+			p.Controller.Printf("  |_ store: %s: upserted %d interface records\n", hostKey, len(ifaceRecords))
 		}
 	}
-}
-
-// stripInternalTags removes internal keys before JSON marshalling.
-func (p *collectionPlugin) stripInternalTags(finalResults map[string]interface{}) {
-	for _, hostDataAny := range finalResults {
-		hostDataMap, ok := hostDataAny.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		// Remove the interfaces slice — it is not part of collection.json output.
-		delete(hostDataMap, "__interfaces")
 
-		metricsWrapper, ok := hostDataMap["metrics"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-		metricsMap, ok := metricsWrapper["metrics"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-		for _, metricAny := range metricsMap {
-			if m, ok := metricAny.(map[string]interface{}); ok {
-				delete(m, "__plugin")
-				delete(m, "instance")
-			}
+	if len(linkRecords) > 0 {
+		if err := p.Controller.Store.UpsertLinks(ctx, linkRecords); err != nil {
+			p.Controller.Printf("  !_ store: %s: UpsertLinks error: %v\n", hostKey, err)
+		} else {
+			p.Controller.Printf("  |_ store: %s: upserted %d link records\n", hostKey, len(linkRecords))
 		}
 	}
 }
@@ -0,0 +1,131 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"observer/base"
+)
+
+// familyPlugin reports the address it ran against, and fails on the
+// families in fail.
+type familyPlugin struct {
+	plugin.BasePlugin
+	fail  map[string]bool
+	calls []string
+}
+
+func (p *familyPlugin) Name() string { return "httpcheck" }
+
+func (p *familyPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	address := plugin.TargetAddress(options)
+	p.calls = append(p.calls, address)
+	if t, _ := options["target"].(plugin.Target); p.fail[t.Family] {
+		return nil, errors.New("connection refused")
+	}
+	return map[string]interface{}{
+		"metrics": map[string]interface{}{
+			"http":     map[string]interface{}{"name": "http", "value": "up", "address": address},
+			"cert_eth": map[string]interface{}{"name": "cert_days", "value": 30, "instance": "eth0"},
+		},
+		"interfaces": []string{"from " + address},
+	}, nil
+}
+
+// describeMetrics lists metrics as "label instance value" lines, sorted.
+func describeMetrics(result map[string]interface{}) []string {
+	var out []string
+	for label, m := range result["metrics"].(map[string]interface{}) {
+		mm := m.(map[string]interface{})
+		out = append(out, fmt.Sprintf("%s %v %v", label, mm["instance"], mm["value"]))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestRunTargets(t *testing.T) {
+	old := plugin.LookupIP
+	plugin.LookupIP = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		switch {
+		case host == "web.example" && network == "ip4":
+			return []net.IP{net.ParseIP("192.0.2.80")}, nil
+		case host == "web.example" && network == "ip6":
+			return []net.IP{net.ParseIP("2001:db8::80")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	t.Cleanup(func() { plugin.LookupIP = old })
+	options := func() map[string]interface{} {
+		return map[string]interface{}{"action": "http", "host": map[string]interface{}{"address": "web.example"}}
+	}
+
+	c := &collectionPlugin{}
+
+	// Both families: a run each, series split by family.
+	p := &familyPlugin{}
+	targets, _, err := plugin.CollectTask{Metric: "httpcheck.http", Prefer: plugin.FamilyBoth}.Targets("web.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.runTargets(p, options(), targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"192.0.2.80", "2001:db8::80"}; !reflect.DeepEqual(p.calls, want) {
+		t.Errorf("calls = %v, want %v", p.calls, want)
+	}
+	want := []string{
+		"cert_eth_ipv4 eth0/ipv4 30",
+		"cert_eth_ipv6 eth0/ipv6 30",
+		"http_ipv4 ipv4 up",
+		"http_ipv6 ipv6 up",
+	}
+	if got := describeMetrics(result); !reflect.DeepEqual(got, want) {
+		t.Errorf("metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if m := result["metrics"].(map[string]interface{})["http_ipv6"].(map[string]interface{}); m["address"] != "2001:db8::80" {
+		t.Errorf("ipv6 series from %v", m["address"])
+	}
+	// Entity data comes from the first family only.
+	if got := result["interfaces"]; !reflect.DeepEqual(got, []string{"from 192.0.2.80"}) {
+		t.Errorf("interfaces = %v", got)
+	}
+
+	// One family failing leaves the other's series.
+	p = &familyPlugin{fail: map[string]bool{plugin.FamilyIPv6: true}}
+	result, err = c.runTargets(p, options(), targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := describeMetrics(result); !reflect.DeepEqual(got, []string{"cert_eth_ipv4 eth0/ipv4 30", "http_ipv4 ipv4 up"}) {
+		t.Errorf("with ipv6 failing: %q", got)
+	}
+	// Both failing fails the task with each family's error.
+	p = &familyPlugin{fail: map[string]bool{plugin.FamilyIPv4: true, plugin.FamilyIPv6: true}}
+	if _, err := c.runTargets(p, options(), targets); err == nil || err.Error() != "ipv4: connection refused; ipv6: connection refused" {
+		t.Errorf("both failing: %v", err)
+	}
+
+	// A single target runs once, unsuffixed, against the resolved address.
+	p = &familyPlugin{}
+	targets, _, _ = plugin.CollectTask{Metric: "httpcheck.http", Require: plugin.FamilyIPv6}.Targets("web.example")
+	result, err = c.runTargets(p, options(), targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := describeMetrics(result); !reflect.DeepEqual(got, []string{"cert_eth eth0 30", "http <nil> up"}) || p.calls[0] != "2001:db8::80" {
+		t.Errorf("single target: %q, calls %v", got, p.calls)
+	}
+
+	// Without a family option the configured address is used as is.
+	p = &familyPlugin{}
+	if _, err := c.runTargets(p, options(), nil); err != nil || p.calls[0] != "web.example" {
+		t.Errorf("no family: calls %v, err %v", p.calls, err)
+	}
+}
@@ -0,0 +1,391 @@
+package collection
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"observer/base"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// netboxTimeout bounds each request to the NetBox API.
+const netboxTimeout = 30 * time.Second
+
+// defaultColumns are the CSV headers read for each host field when columns=
+// does not name one, matched without case or surrounding space.
+var defaultColumns = map[string][]string{
+	"key":     {"key", "hostname"},
+	"address": {"address", "ip", "ip_address"},
+	"name":    {"name", "description"},
+	"groups":  {"groups", "group", "site"},
+}
+
+// importedHost is a host read from an inventory source.
+type importedHost struct {
+	key  string
+	host plugin.Host
+}
+
+// importPlan is what importing a list of hosts would change in the config.
+// Hosts already configured are only reported: their collect lists, groups and
+// credentials may have been set by hand and are left as they are.
+type importPlan struct {
+	adds      []importedHost
+	updates   []string // existing hosts whose address or name differs
+	conflicts []string
+	unchanged int
+}
+
+// importHosts reads hosts from source=csv or source=netbox and reports what
+// adding them to the config would change. Nothing is written unless
+// write=true (new hosts go into config.json) or out=<file> (a hosts fragment).
+func (p *collectionPlugin) importHosts(args map[string]string) error {
+	var hosts []importedHost
+	var warnings []string
+	var err error
+	switch args["source"] {
+	case "csv":
+		if args["file"] == "" {
+			return errors.New("import source=csv needs file=<path>")
+		}
+		hosts, warnings, err = readCSVHosts(args["file"], args["columns"])
+	case "netbox":
+		if args["url"] == "" {
+			return errors.New("import source=netbox needs url=<NetBox URL>")
+		}
+		token := args["token"]
+		if token == "" {
+			token = os.Getenv("NETBOX_TOKEN")
+		}
+		hosts, warnings, err = fetchNetBoxHosts(args["url"], token, args["filter"])
+	default:
+		return fmt.Errorf("import needs source=csv or source=netbox")
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := plugin.ReadConfigFile()
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("could not parse config file: %w", err)
+	}
+
+	tasks := []plugin.CollectTask{{Metric: "network.ping"}}
+	if list := splitList(args["collect"], ","); len(list) > 0 {
+		tasks = nil
+		for _, m := range list {
+			tasks = append(tasks, plugin.CollectTask{Metric: m})
+		}
+	}
+	credentials := splitList(args["credentials"], ",")
+	if credentials == nil {
+		credentials = []string{}
+	}
+	for i := range hosts {
+		hosts[i].host.Collect = tasks
+		hosts[i].host.Credentials = credentials
+	}
+
+	plan := planImport(&cfg, hosts)
+	for _, w := range warnings {
+		p.Controller.Printf("  !_ %s\n", w)
+	}
+	for _, h := range plan.adds {
+		p.Controller.Printf("  |_ add %s (%s)\n", h.key, h.host.Address)
+	}
+	for _, u := range plan.updates {
+		p.Controller.Printf("  |_ update %s (not applied)\n", u)
+	}
+	for _, c := range plan.conflicts {
+		p.Controller.Printf("  !_ conflict: %s\n", c)
+	}
+	p.Controller.Printf("  |_ %d to add, %d to update, %d conflicts, %d unchanged\n",
+		len(plan.adds), len(plan.updates), len(plan.conflicts), plan.unchanged)
+
+	write := args["write"] == "true"
+	if out := args["out"]; out != "" {
+		if err := writeHostFragment(out, plan.adds); err != nil {
+			return err
+		}
+		p.Controller.Printf("  |_ wrote %d hosts to %s\n", len(plan.adds), out)
+	}
+	if write {
+		for _, h := range plan.adds {
+			if err := plugin.AddConfigHost(h.key, h.host); err != nil {
+				return fmt.Errorf("could not add host %q: %w", h.key, err)
+			}
+		}
+		p.Controller.Printf("  |_ added %d hosts to %s\n", len(plan.adds), plugin.ConfigFile)
+	}
+	if !write && args["out"] == "" && len(plan.adds) > 0 {
+		p.Controller.Println("  |_ dry run: add write=true to update the config, or out=<file> for a hosts fragment")
+	}
+	return nil
+}
+
+// planImport sorts hosts into those to add, configured hosts that differ,
+// and conflicts: a key given twice, or an address another host already has.
+func planImport(cfg *plugin.Config, hosts []importedHost) importPlan {
+	var plan importPlan
+	seenKeys := make(map[string]bool)
+	seenAddrs := make(map[string]string) // of hosts to add
+	for _, h := range hosts {
+		addr := strings.ToLower(h.host.Address)
+		if seenKeys[h.key] {
+			plan.conflicts = append(plan.conflicts, fmt.Sprintf("%s appears more than once in the source", h.key))
+			continue
+		}
+		seenKeys[h.key] = true
+
+		if existing, ok := cfg.Hosts[h.key]; ok {
+			var changes []string
+			if !strings.EqualFold(strings.TrimSpace(existing.Address), h.host.Address) {
+				changes = append(changes, fmt.Sprintf("address %s -> %s", existing.Address, h.host.Address))
+			}
+			if h.host.Name != "" && existing.Name != h.host.Name {
+				changes = append(changes, fmt.Sprintf("name %q -> %q", existing.Name, h.host.Name))
+			}
+			if len(changes) == 0 {
+				plan.unchanged++
+			} else {
+				plan.updates = append(plan.updates, h.key+": "+strings.Join(changes, ", "))
+			}
+			continue
+		}
+		if other, ok := cfg.HostByAddress(h.host.Address); ok {
+			plan.conflicts = append(plan.conflicts, fmt.Sprintf("%s: %s is already host %q", h.key, h.host.Address, other))
+			continue
+		}
+		if other, ok := seenAddrs[addr]; ok {
+			plan.conflicts = append(plan.conflicts, fmt.Sprintf("%s: %s is also %q in the source", h.key, h.host.Address, other))
+			continue
+		}
+		seenAddrs[addr] = h.key
+		plan.adds = append(plan.adds, h)
+	}
+	return plan
+}
+
+// writeHostFragment writes hosts as a config fragment, {"hosts": {...}}.
+func writeHostFragment(path string, hosts []importedHost) error {
+	fragment := struct {
+		Hosts map[string]plugin.Host `json:"hosts"`
+	}{Hosts: make(map[string]plugin.Host, len(hosts))}
+	for _, h := range hosts {
+		fragment.Hosts[h.key] = h.host
+	}
+	data, err := json.MarshalIndent(fragment, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return nil
+}
+
+// readCSVHosts reads hosts from a CSV file with a header row. columns maps
+// host fields to headers, as "key:hostname,address:ip"; fields it leaves out
+// use defaultColumns. Groups may hold several values separated by ';' or '|'.
+func readCSVHosts(path, columns string) ([]importedHost, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open %s: %w", path, err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read the header of %s: %w", path, err)
+	}
+
+	names := make(map[string][]string, len(defaultColumns))
+	for field, headers := range defaultColumns {
+		names[field] = headers
+	}
+	for _, pair := range splitList(columns, ",") {
+		field, column, ok := strings.Cut(pair, ":")
+		if _, known := defaultColumns[field]; !ok || !known {
+			return nil, nil, fmt.Errorf("columns: %q is not field:header (fields are key, address, name and groups)", pair)
+		}
+		names[field] = []string{column}
+	}
+	index := make(map[string]int)
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		for field, headers := range names {
+			if _, found := index[field]; found {
+				continue
+			}
+			for _, want := range headers {
+				if h == strings.ToLower(strings.TrimSpace(want)) {
+					index[field] = i
+				}
+			}
+		}
+	}
+	if _, ok := index["address"]; !ok {
+		return nil, nil, fmt.Errorf("%s has no address column (looked for %s)", path, strings.Join(names["address"], ", "))
+	}
+
+	var hosts []importedHost
+	var warnings []string
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		get := func(field string) string {
+			if i, ok := index[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		h := importedHost{key: get("key"), host: plugin.Host{Address: stripPrefixLen(get("address")), Name: get("name")}}
+		if h.host.Address == "" {
+			warnings = append(warnings, fmt.Sprintf("%s line %d: no address, skipped", path, line))
+			continue
+		}
+		h.host.Groups = splitList(strings.ReplaceAll(get("groups"), "|", ";"), ";")
+		if h.key == "" {
+			h.key = h.host.Name
+		}
+		if h.key == "" {
+			h.key = h.host.Address
+		}
+		if h.host.Name == "" {
+			h.host.Name = h.key
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, warnings, nil
+}
+
+// netboxPage is a page of a NetBox list endpoint, of devices or virtual
+// machines alike.
+type netboxPage struct {
+	Next    string `json:"next"`
+	Results []struct {
+		Name      string `json:"name"`
+		PrimaryIP *struct {
+			Address string `json:"address"`
+		} `json:"primary_ip"`
+		Site *struct {
+			Slug string `json:"slug"`
+		} `json:"site"`
+		Role *struct {
+			Slug string `json:"slug"`
+		} `json:"role"`
+		DeviceRole *struct { // NetBox before 3.6
+			Slug string `json:"slug"`
+		} `json:"device_role"`
+	} `json:"results"`
+}
+
+// fetchNetBoxHosts reads the devices and virtual machines of a NetBox
+// instance, narrowed by filter, a query string such as
+// "site=ams1&status=active". Each becomes a host keyed by its name at its
+// primary IP address, in groups named after its site and role. Those without
+// a name or primary IP are skipped with a warning.
+func fetchNetBoxHosts(baseURL, token, filter string) ([]importedHost, []string, error) {
+	query, err := url.ParseQuery(filter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("filter: %w", err)
+	}
+	query.Set("limit", "100")
+	client := &http.Client{Timeout: netboxTimeout}
+
+	var hosts []importedHost
+	var warnings []string
+	for _, endpoint := range []string{"/api/dcim/devices/", "/api/virtualization/virtual-machines/"} {
+		next := strings.TrimRight(baseURL, "/") + endpoint + "?" + query.Encode()
+		for next != "" {
+			var page netboxPage
+			if err := getNetBox(client, next, token, &page); err != nil {
+				return nil, nil, err
+			}
+			for _, r := range page.Results {
+				if r.Name == "" || r.PrimaryIP == nil || r.PrimaryIP.Address == "" {
+					warnings = append(warnings, fmt.Sprintf("netbox: %q has no name or primary IP, skipped", r.Name))
+					continue
+				}
+				h := importedHost{key: r.Name, host: plugin.Host{Address: stripPrefixLen(r.PrimaryIP.Address), Name: r.Name}}
+				if r.Site != nil && r.Site.Slug != "" {
+					h.host.Groups = append(h.host.Groups, r.Site.Slug)
+				}
+				if r.Role == nil {
+					r.Role = r.DeviceRole
+				}
+				if r.Role != nil && r.Role.Slug != "" {
+					h.host.Groups = append(h.host.Groups, r.Role.Slug)
+				}
+				hosts = append(hosts, h)
+			}
+			next = page.Next
+		}
+	}
+	sort.SliceStable(hosts, func(i, j int) bool { return hosts[i].key < hosts[j].key })
+	return hosts, warnings, nil
+}
+
+// getNetBox fetches one page of the NetBox API into v.
+func getNetBox(client *http.Client, pageURL, token string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, pageURL, nil)
+	if err != nil {
+		return fmt.Errorf("netbox: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("netbox: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("netbox: %s: %s %s", pageURL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("netbox: %s: %w", pageURL, err)
+	}
+	return nil
+}
+
+// stripPrefixLen returns the address of "10.0.0.1/24", or addr unchanged
+// when it is not in CIDR notation.
+func stripPrefixLen(addr string) string {
+	if ip, _, err := net.ParseCIDR(addr); err == nil {
+		return ip.String()
+	}
+	return addr
+}
+
+// splitList splits s at sep, dropping empty and surrounding space.
+func splitList(s, sep string) []string {
+	var list []string
+	for _, part := range strings.Split(s, sep) {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	return list
+}
@@ -0,0 +1,319 @@
+package collection
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"observer/base"
+)
+
+// writeCSV writes content to a CSV file in a temporary directory.
+func writeCSV(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hosts.csv")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// describeHosts lists hosts as "key name address groups" lines.
+func describeHosts(hosts []importedHost) []string {
+	var out []string
+	for _, h := range hosts {
+		out = append(out, fmt.Sprintf("%s %s %s %v", h.key, h.host.Name, h.host.Address, h.host.Groups))
+	}
+	return out
+}
+
+func TestReadCSVHosts(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		csv      string
+		columns  string
+		hosts    []string
+		warnings int
+	}{
+		{
+			name:  "default headers",
+			csv:   "hostname,ip,description,site\ncore,192.0.2.1,Core router,ams1\n",
+			hosts: []string{"core Core router 192.0.2.1 [ams1]"},
+		},
+		{
+			// A byte order mark, case and space in the header; quoted fields.
+			name:  "spreadsheet export",
+			csv:   "\ufeff Address ,KEY,Name,Groups\n\"192.0.2.2/24\",edge,\"Edge, rack 2\",core|wan; lab\n",
+			hosts: []string{"edge Edge, rack 2 192.0.2.2 [core wan lab]"},
+		},
+		{
+			// key falls back to the name, then to the address; name to the key.
+			name:  "fallbacks",
+			csv:   "ip,name\n192.0.2.3,nas\n192.0.2.4,\n",
+			hosts: []string{"nas nas 192.0.2.3 []", "192.0.2.4 192.0.2.4 192.0.2.4 []"},
+		},
+		{
+			name:     "rows without an address",
+			csv:      "key,address\ncore,192.0.2.1\nedge,\nshort\n",
+			hosts:    []string{"core core 192.0.2.1 []"},
+			warnings: 2,
+		},
+		{
+			// columns= replaces the defaults of the fields it names only.
+			name:    "column mapping",
+			csv:     "asset,mgmt,ip,dns\nSRV-1,10.0.0.1,192.0.2.9,srv1.example\n",
+			columns: "key:dns, address:MGMT",
+			hosts:   []string{"srv1.example srv1.example 10.0.0.1 []"},
+		},
+		{
+			// The first matching header wins when several are present.
+			name:  "repeated headers",
+			csv:   "ip,address,ip_address\n192.0.2.5,192.0.2.6,192.0.2.7\n",
+			hosts: []string{"192.0.2.5 192.0.2.5 192.0.2.5 []"},
+		},
+		{name: "header only", csv: "key,address\n"},
+	} {
+		hosts, warnings, err := readCSVHosts(writeCSV(t, tt.csv), tt.columns)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := describeHosts(hosts); !reflect.DeepEqual(got, tt.hosts) {
+			t.Errorf("%s: hosts\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.hosts, "\n"))
+		}
+		if len(warnings) != tt.warnings {
+			t.Errorf("%s: warnings %q", tt.name, warnings)
+		}
+	}
+}
+
+func TestReadCSVHostsErrors(t *testing.T) {
+	for _, tt := range []struct {
+		csv, columns, msg string
+	}{
+		{"key,name\ncore,Core\n", "", "has no address column (looked for address, ip, ip_address)"},
+		{"key,ip\ncore,192.0.2.1\n", "address:mgmt", "has no address column (looked for mgmt)"},
+		{"key,ip\n", "address", `columns: "address" is not field:header`},
+		{"key,ip\n", "site:dc", `columns: "site:dc" is not field:header`},
+		{"", "", "could not read the header"},
+		{"key,ip\ncore,\"192.0.2.1\n", "", "extraneous or missing"},
+	} {
+		if _, _, err := readCSVHosts(writeCSV(t, tt.csv), tt.columns); err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%q columns=%q: err = %v, want %q", tt.csv, tt.columns, err, tt.msg)
+		}
+	}
+	if _, _, err := readCSVHosts(filepath.Join(t.TempDir(), "missing.csv"), ""); err == nil {
+		t.Error("missing file read")
+	}
+}
+
+// fakeNetBox serves devices and virtual machines two to a page, and records
+// the queries it was sent.
+func fakeNetBox(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	devices := []string{
+		`{"name": "core", "primary_ip": {"address": "192.0.2.1/24"}, "site": {"slug": "ams1"}, "role": {"slug": "router"}}`,
+		`{"name": "edge", "primary_ip": {"address": "2001:db8::2/64"}, "site": {"slug": "ams1"}, "device_role": {"slug": "firewall"}}`,
+		`{"name": "patch-panel", "primary_ip": null, "site": {"slug": "ams1"}}`,
+	}
+	vms := []string{
+		`{"name": "app", "primary_ip": {"address": "192.0.2.10/24"}, "site": null, "role": null}`,
+		`{"name": "", "primary_ip": {"address": "192.0.2.11/24"}}`,
+	}
+	var queries []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			http.Error(w, `{"detail": "Invalid token"}`, http.StatusForbidden)
+			return
+		}
+		queries = append(queries, r.URL.Path+"?"+r.URL.RawQuery)
+		var all []string
+		switch r.URL.Path {
+		case "/netbox/api/dcim/devices/":
+			all = devices
+		case "/netbox/api/virtualization/virtual-machines/":
+			all = vms
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		offset := 0
+		fmt.Sscan(r.URL.Query().Get("offset"), &offset)
+		end := offset + 2
+		if end > len(all) {
+			end = len(all)
+		}
+		next := "null"
+		if end < len(all) {
+			q := r.URL.Query()
+			q.Set("offset", fmt.Sprint(end))
+			next = fmt.Sprintf("%q", srv.URL+r.URL.Path+"?"+q.Encode())
+		}
+		fmt.Fprintf(w, `{"count": %d, "next": %s, "results": [%s]}`, len(all), next, strings.Join(all[offset:end], ","))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &queries
+}
+
+func TestFetchNetBoxHosts(t *testing.T) {
+	srv, queries := fakeNetBox(t)
+	hosts, warnings, err := fetchNetBoxHosts(srv.URL+"/netbox/", "secret", "site=ams1&status=active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"app app 192.0.2.10 []",
+		"core core 192.0.2.1 [ams1 router]",
+		"edge edge 2001:db8::2 [ams1 firewall]",
+	}
+	if got := describeHosts(hosts); !reflect.DeepEqual(got, want) {
+		t.Errorf("hosts\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], `"patch-panel" has no name or primary IP`) {
+		t.Errorf("warnings %q", warnings)
+	}
+	// Every page carries the filter; the second page of devices follows next.
+	wantQueries := []string{
+		"/netbox/api/dcim/devices/?limit=100&site=ams1&status=active",
+		"/netbox/api/dcim/devices/?limit=100&offset=2&site=ams1&status=active",
+		"/netbox/api/virtualization/virtual-machines/?limit=100&site=ams1&status=active",
+	}
+	if !reflect.DeepEqual(*queries, wantQueries) {
+		t.Errorf("queries\n%s\nwant\n%s", strings.Join(*queries, "\n"), strings.Join(wantQueries, "\n"))
+	}
+
+	if _, _, err := fetchNetBoxHosts(srv.URL+"/netbox", "wrong", ""); err == nil || !strings.Contains(err.Error(), "403 Forbidden") {
+		t.Errorf("bad token: %v", err)
+	}
+	if _, _, err := fetchNetBoxHosts(srv.URL, "secret", ""); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("wrong base URL: %v", err)
+	}
+	if _, _, err := fetchNetBoxHosts(srv.URL, "secret", "site=%zz"); err == nil || !strings.Contains(err.Error(), "filter") {
+		t.Errorf("bad filter: %v", err)
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	cfg := &plugin.Config{Hosts: map[string]plugin.Host{
+		"core": {Address: "192.0.2.1", Name: "core"},
+		"edge": {Address: "192.0.2.2", Name: "edge"},
+		"nas":  {Address: "192.0.2.3", Name: "nas"},
+	}}
+	plan := planImport(cfg, []importedHost{
+		{key: "core", host: plugin.Host{Address: "192.0.2.1", Name: "core"}},
+		{key: "edge", host: plugin.Host{Address: "192.0.2.20", Name: "Edge"}},
+		{key: "app", host: plugin.Host{Address: "192.0.2.10"}},
+		{key: "app", host: plugin.Host{Address: "192.0.2.11"}},
+		{key: "storage", host: plugin.Host{Address: "192.0.2.3"}},
+		{key: "db", host: plugin.Host{Address: "192.0.2.10"}},
+		{key: "web", host: plugin.Host{Address: "192.0.2.12"}},
+	})
+	var adds []string
+	for _, h := range plan.adds {
+		adds = append(adds, h.key)
+	}
+	if !reflect.DeepEqual(adds, []string{"app", "web"}) {
+		t.Errorf("adds %q", adds)
+	}
+	if want := []string{`edge: address 192.0.2.2 -> 192.0.2.20, name "edge" -> "Edge"`}; !reflect.DeepEqual(plan.updates, want) {
+		t.Errorf("updates %q", plan.updates)
+	}
+	want := []string{
+		"app appears more than once in the source",
+		`storage: 192.0.2.3 is already host "nas"`,
+		`db: 192.0.2.10 is also "app" in the source`,
+	}
+	if !reflect.DeepEqual(plan.conflicts, want) {
+		t.Errorf("conflicts\n%s\nwant\n%s", strings.Join(plan.conflicts, "\n"), strings.Join(want, "\n"))
+	}
+	if plan.unchanged != 1 {
+		t.Errorf("unchanged %d", plan.unchanged)
+	}
+}
+
+func TestImportHosts(t *testing.T) {
+	dir := t.TempDir()
+	oldConfig := plugin.ConfigFile
+	plugin.ConfigFile = filepath.Join(dir, "config.json")
+	t.Cleanup(func() { plugin.ConfigFile = oldConfig })
+	config := `{
+    "config_version": 1,
+    "hosts": {
+        "core": {"address": "192.0.2.1", "collect": [{"metric": "snmp.system"}], "credentials": ["snmp-ro"]}
+    }
+}
+`
+	if err := os.WriteFile(plugin.ConfigFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file := writeCSV(t, "key,address,groups\ncore,192.0.2.100,wan\nedge,192.0.2.2,wan\n")
+	p := &collectionPlugin{}
+
+	// A dry run writes nothing.
+	if err := p.importHosts(map[string]string{"source": "csv", "file": file}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(plugin.ConfigFile); string(data) != config {
+		t.Errorf("dry run wrote the config:\n%s", data)
+	}
+
+	out := filepath.Join(dir, "hosts.d", "imported.json")
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := p.importHosts(map[string]string{"source": "csv", "file": file, "write": "true", "out": out,
+		"collect": "network.ping, httpcheck.http", "credentials": "ssh-admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(plugin.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	// core keeps its address and hand-set collect list; edge is added.
+	core, edge := cfg.Hosts["core"], cfg.Hosts["edge"]
+	if core.Address != "192.0.2.1" || len(core.Collect) != 1 || core.Collect[0].Metric != "snmp.system" ||
+		!reflect.DeepEqual(core.Credentials, []string{"snmp-ro"}) {
+		t.Errorf("core changed: %+v", core)
+	}
+	if edge.Address != "192.0.2.2" || len(edge.Collect) != 2 || edge.Collect[1].Metric != "httpcheck.http" ||
+		!reflect.DeepEqual(edge.Groups, []string{"wan"}) || !reflect.DeepEqual(edge.Credentials, []string{"ssh-admin"}) {
+		t.Errorf("edge = %+v", edge)
+	}
+
+	var fragment struct {
+		Hosts map[string]plugin.Host `json:"hosts"`
+	}
+	data, err = os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &fragment); err != nil {
+		t.Fatal(err)
+	}
+	if len(fragment.Hosts) != 1 || fragment.Hosts["edge"].Address != "192.0.2.2" {
+		t.Errorf("fragment %s", data)
+	}
+
+	for _, args := range []map[string]string{
+		{},
+		{"source": "ldap"},
+		{"source": "csv"},
+		{"source": "netbox"},
+	} {
+		if err := p.importHosts(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package dbcheck checks database servers: collect tasks "dbcheck.mysql",
+// "dbcheck.postgres" and "dbcheck.redis" connect with the task's credential,
+// time the connection and a health query, and turn configured queries into metrics.
+package dbcheck
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// defaultPorts are used when the credential has no port.
+var defaultPorts = map[string]string{
+	"mysql":    "3306",
+	"postgres": "5432",
+	"redis":    "6379",
+}
+
+// dbcheckPlugin checks database server health.
+type dbcheckPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&dbcheckPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *dbcheckPlugin) Name() string {
+	return "Dbcheck"
+}
+
+// checkOptions are the per-task options of a dbcheck task:
+//
+//	{"metric": "dbcheck.postgres", "credentials": "pg_main", "options": {
+//	    "database": "app", "sslmode": "require", "timeout_s": 3,
+//	    "queries": {"connections": "SELECT count(*) FROM pg_stat_activity"}}}
+//
+// For redis, each query names an INFO field, e.g. {"clients": "connected_clients"}.
+type checkOptions struct {
+	Database string            `json:"database"`
+	SSLMode  string            `json:"sslmode"` // postgres only; default disable
+	TimeoutS float64           `json:"timeout_s"`
+	Queries  map[string]string `json:"queries"` // metric name -> query returning one number
+}
+
+// target is where and as whom to connect.
+type target struct {
+	kind, host, port, user, pass string
+}
+
+// checkResult is what one check measured.
+type checkResult struct {
+	err     error // connection or health query failure
+	connect time.Duration
+	query   time.Duration
+	values  map[string]float64
+	failed  map[string]string // named queries that failed, with the reason
+}
+
+// OnCollect runs one check. A server that cannot be reached is reported as a
+// down status metric rather than a task error, so its history is continuous.
+func (p *dbcheckPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	kind, _ := options["action"].(string)
+	if _, ok := defaultPorts[kind]; !ok {
+		return nil, fmt.Errorf("undefined dbcheck action: %s (mysql, postgres or redis)", kind)
+	}
+	var opts checkOptions
+	if raw, ok := options["options"]; ok {
+		b, _ := json.Marshal(raw)
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return nil, fmt.Errorf("dbcheck: invalid options: %w", err)
+		}
+	}
+	t := resolveTarget(kind, options)
+	if t.host == "" {
+		return nil, fmt.Errorf("dbcheck: no address for %s", kind)
+	}
+
+	timeout := defaultTimeout
+	if opts.TimeoutS > 0 {
+		timeout = time.Duration(opts.TimeoutS * float64(time.Second))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var res checkResult
+	if kind == "redis" {
+		res = checkRedis(ctx, t, opts)
+	} else {
+		res = checkSQL(ctx, t, opts)
+	}
+	return map[string]interface{}{"metrics": checkMetrics(kind, net.JoinHostPort(t.host, t.port), res)}, nil
+}
+
+// resolveTarget takes the address from the credential, falling back to the host's.
+func resolveTarget(kind string, options map[string]interface{}) target {
+	creds, _ := options["credentials"].(map[string]interface{})
+	host, _ := options["host"].(map[string]interface{})
+	t := target{kind: kind}
+	t.host, _ = creds["host"].(string)
+	t.port, _ = creds["port"].(string)
+	t.user, _ = creds["user"].(string)
+	t.pass, _ = creds["pass"].(string)
+	if t.host == "" {
+		t.host, _ = host["address"].(string)
+	}
+	if t.port == "" || t.port == "0" {
+		t.port = defaultPorts[kind]
+	}
+	return t
+}
+
+// checkSQL connects to MySQL or PostgreSQL, runs SELECT 1 and the named queries.
+func checkSQL(ctx context.Context, t target, opts checkOptions) checkResult {
+	var res checkResult
+	db, err := sql.Open(driverName(t.kind), dsn(t, opts))
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	start := time.Now()
+	if err := db.PingContext(ctx); err != nil {
+		res.err = err
+		return res
+	}
+	res.connect = time.Since(start)
+
+	start = time.Now()
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		res.err = fmt.Errorf("health query: %w", err)
+		return res
+	}
+	res.query = time.Since(start)
+
+	for _, name := range sortedNames(opts.Queries) {
+		var raw sql.NullString
+		if err := db.QueryRowContext(ctx, opts.Queries[name]).Scan(&raw); err != nil {
+			res.fail(name, err.Error())
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(raw.String), 64)
+		if !raw.Valid || err != nil {
+			res.fail(name, fmt.Sprintf("result %q is not a number", raw.String))
+			continue
+		}
+		res.set(name, v)
+	}
+	return res
+}
+
+func driverName(kind string) string {
+	if kind == "postgres" {
+		return "postgres"
+	}
+	return "mysql"
+}
+
+// dsn builds the driver connection string for t.
+func dsn(t target, opts checkOptions) string {
+	addr := net.JoinHostPort(t.host, t.port)
+	if t.kind == "postgres" {
+		sslmode := opts.SSLMode
+		if sslmode == "" {
+			sslmode = "disable"
+		}
+		database := opts.Database
+		if database == "" {
+			database = "postgres"
+		}
+		u := url.URL{Scheme: "postgres", Host: addr, Path: "/" + database,
+			RawQuery: url.Values{"sslmode": {sslmode}}.Encode()}
+		if t.user != "" {
+			u.User = url.UserPassword(t.user, t.pass)
+		}
+		return u.String()
+	}
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s", t.user, t.pass, addr, opts.Database)
+}
+
+func (r *checkResult) set(name string, v float64) {
+	if r.values == nil {
+		r.values = make(map[string]float64)
+	}
+	r.values[name] = v
+}
+
+func (r *checkResult) fail(name, reason string) {
+	if r.failed == nil {
+		r.failed = make(map[string]string)
+	}
+	r.failed[name] = reason
+}
+
+// checkMetrics builds the metrics of one check, with the server address as instance.
+func checkMetrics(kind, instance string, res checkResult) map[string]interface{} {
+	status := metric(kind, "status", "Status", instance, "status", "up")
+	if res.err != nil {
+		status["value"] = "down"
+		status["reason"] = res.err.Error()
+	} else if len(res.failed) > 0 {
+		status["value"] = "warning"
+		status["failed_queries"] = res.failed
+	}
+	metrics := map[string]interface{}{kind + "_status_" + instance: status}
+	if res.err != nil {
+		return metrics
+	}
+	metrics[kind+"_connect_"+instance] = msMetric(kind, "connect_ms", instance, res.connect)
+	metrics[kind+"_query_"+instance] = msMetric(kind, "query_ms", instance, res.query)
+	for name, v := range res.values {
+		metrics[kind+"_"+name+"_"+instance] = metric(kind, name, name, instance, "gauge", v)
+	}
+	return metrics
+}
+
+func msMetric(kind, name, instance string, d time.Duration) map[string]interface{} {
+	ms := float64(d.Microseconds()) / 1000
+	m := metric(kind, name, strings.TrimSuffix(name, "_ms")+" (ms)", instance, "gauge", fmt.Sprintf("%.1f", ms))
+	m["value_num"] = ms
+	return m
+}
+
+func metric(kind, name, label, instance, metricType string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    label,
+		"value":    value,
+		"type":     metricType,
+		"category": kind,
+		"instance": instance,
+	}
+}
+
+// sortedNames returns the query names in order, so queries run in a stable order.
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
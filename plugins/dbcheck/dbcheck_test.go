@@ -0,0 +1,354 @@
+package dbcheck
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// serve accepts connections on a local listener and hands each to handle.
+func serve(t *testing.T, handle func(net.Conn)) (host, port string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	host, port, _ = net.SplitHostPort(ln.Addr().String())
+	return host, port
+}
+
+// closedPort returns a local port nothing listens on.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	return port
+}
+
+// redisServer is a fake Redis that answers AUTH, PING and INFO.
+type redisServer struct {
+	pass string
+	info string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func (s *redisServer) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	authed := s.pass == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(args, " "))
+		s.mu.Unlock()
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			if args[len(args)-1] != s.pass {
+				io.WriteString(conn, "-WRONGPASS invalid username-password pair\r\n")
+				continue
+			}
+			authed = true
+			io.WriteString(conn, "+OK\r\n")
+		case !authed:
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+		case cmd == "PING":
+			io.WriteString(conn, "+PONG\r\n")
+		case cmd == "INFO":
+			io.WriteString(conn, "$"+strconv.Itoa(len(s.info))+"\r\n"+s.info+"\r\n")
+		default:
+			io.WriteString(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+// readCommand reads one RESP array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil { // $len
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimRight(arg, "\r\n")
+	}
+	return args, nil
+}
+
+const redisInfo = "# Clients\r\nconnected_clients:12\r\nblocked_clients:0\r\n\r\n# Stats\r\nkeyspace_hits:900\r\nredis_mode:standalone\r\n"
+
+// pgServer is a fake PostgreSQL that accepts any login and answers the simple
+// queries it knows with a single text value.
+type pgServer struct {
+	results map[string]string // query -> value; missing queries fail
+}
+
+func (s *pgServer) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	var size int32
+	if binary.Read(r, binary.BigEndian, &size) != nil {
+		return
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(size-4)); err != nil { // startup parameters
+		return
+	}
+	send(conn, 'R', u32(0)) // AuthenticationOk
+	send(conn, 'Z', []byte("I"))
+	for {
+		typ, err := r.ReadByte()
+		if err != nil || binary.Read(r, binary.BigEndian, &size) != nil {
+			return
+		}
+		body := make([]byte, size-4)
+		if _, err := io.ReadFull(r, body); err != nil || typ != 'Q' {
+			return
+		}
+		query := strings.TrimRight(string(body), "\x00")
+		switch value, ok := s.results[query]; {
+		case query == ";":
+			send(conn, 'I', nil)
+		case !ok:
+			send(conn, 'E', []byte("SERROR\x00C42P01\x00Mrelation does not exist\x00\x00"))
+		default:
+			// One text column: name, table oid, column, type oid 25, size -1, modifier -1, text format.
+			desc := append(u16(1), "v\x00"...)
+			desc = append(desc, u32(0)...)
+			desc = append(desc, u16(0)...)
+			desc = append(desc, u32(25)...)
+			desc = append(desc, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0)
+			send(conn, 'T', desc)
+			row := append(u16(1), u32(uint32(len(value)))...)
+			send(conn, 'D', append(row, value...))
+			send(conn, 'C', []byte("SELECT 1\x00"))
+		}
+		send(conn, 'Z', []byte("I"))
+	}
+}
+
+func send(w io.Writer, typ byte, body []byte) {
+	msg := append([]byte{typ}, u32(uint32(len(body)+4))...)
+	w.Write(append(msg, body...))
+}
+
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+
+// collect runs one dbcheck task and returns its metrics.
+func collect(t *testing.T, kind string, creds, opts map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	options := map[string]interface{}{
+		"action":      kind,
+		"credentials": creds,
+		"host":        map[string]interface{}{"address": "db1.example.com", "name": "db1"},
+	}
+	if opts != nil {
+		options["options"] = opts
+	}
+	result, err := (&dbcheckPlugin{}).OnCollect(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result["metrics"].(map[string]interface{})
+}
+
+func TestRedisCheck(t *testing.T) {
+	srv := &redisServer{pass: "s3cret", info: redisInfo}
+	host, port := serve(t, srv.handle)
+	instance := net.JoinHostPort(host, port)
+
+	metrics := collect(t, "redis", map[string]interface{}{"host": host, "port": port, "pass": "s3cret"},
+		map[string]interface{}{"queries": map[string]interface{}{
+			"clients": "connected_clients",
+			"hits":    "keyspace_hits",
+			"mode":    "redis_mode",
+			"missing": "no_such_field",
+		}})
+
+	status := metrics["redis_status_"+instance].(map[string]interface{})
+	if status["value"] != "warning" {
+		t.Errorf("status = %v", status)
+	}
+	failed, _ := status["failed_queries"].(map[string]string)
+	if len(failed) != 2 || !strings.Contains(failed["mode"], "not a number") || !strings.Contains(failed["missing"], "no INFO field") {
+		t.Errorf("failed_queries = %v", failed)
+	}
+	for name, want := range map[string]float64{"clients": 12, "hits": 900} {
+		m, _ := metrics["redis_"+name+"_"+instance].(map[string]interface{})
+		if m["value"] != want || m["instance"] != instance || m["category"] != "redis" {
+			t.Errorf("%s = %v", name, m)
+		}
+	}
+	for _, name := range []string{"connect", "query"} {
+		m, _ := metrics["redis_"+name+"_"+instance].(map[string]interface{})
+		if _, ok := m["value_num"].(float64); !ok {
+			t.Errorf("%s = %v", name, m)
+		}
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if got := strings.Join(srv.commands, ", "); got != "AUTH s3cret, PING, INFO" {
+		t.Errorf("commands = %s", got)
+	}
+}
+
+func TestRedisCheckACLUser(t *testing.T) {
+	srv := &redisServer{pass: "s3cret"}
+	host, port := serve(t, srv.handle)
+
+	metrics := collect(t, "redis", map[string]interface{}{"host": host, "port": port, "user": "monitor", "pass": "s3cret"}, nil)
+	status := metrics["redis_status_"+net.JoinHostPort(host, port)].(map[string]interface{})
+	if status["value"] != "up" || len(metrics) != 3 {
+		t.Errorf("metrics = %v", metrics)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if got := strings.Join(srv.commands, ", "); got != "AUTH monitor s3cret, PING" {
+		t.Errorf("commands = %s, want no INFO without queries", got)
+	}
+}
+
+func TestRedisCheckWrongPassword(t *testing.T) {
+	host, port := serve(t, (&redisServer{pass: "s3cret"}).handle)
+
+	metrics := collect(t, "redis", map[string]interface{}{"host": host, "port": port, "pass": "wrong"}, nil)
+	status := metrics["redis_status_"+net.JoinHostPort(host, port)].(map[string]interface{})
+	if status["value"] != "down" || status["reason"] != "auth: WRONGPASS invalid username-password pair" {
+		t.Errorf("status = %v", status)
+	}
+	if len(metrics) != 1 {
+		t.Errorf("a failed check reported timings: %v", metrics)
+	}
+}
+
+func TestPostgresCheck(t *testing.T) {
+	srv := &pgServer{results: map[string]string{
+		"SELECT 1":                              "1",
+		"SELECT count(*) FROM pg_stat_activity": "17",
+		"SELECT pg_is_in_recovery()":            "f",
+	}}
+	host, port := serve(t, srv.handle)
+	instance := net.JoinHostPort(host, port)
+
+	metrics := collect(t, "postgres", map[string]interface{}{"host": host, "port": port, "user": "monitor", "pass": "pw"},
+		map[string]interface{}{"timeout_s": 3, "queries": map[string]interface{}{
+			"connections": "SELECT count(*) FROM pg_stat_activity",
+			"recovery":    "SELECT pg_is_in_recovery()",
+			"lag":         "SELECT replication_lag FROM nowhere",
+		}})
+
+	status := metrics["postgres_status_"+instance].(map[string]interface{})
+	failed, _ := status["failed_queries"].(map[string]string)
+	if status["value"] != "warning" || len(failed) != 2 || failed["recovery"] != `result "f" is not a number` ||
+		!strings.Contains(failed["lag"], "relation does not exist") {
+		t.Errorf("status = %v", status)
+	}
+	if m, _ := metrics["postgres_connections_"+instance].(map[string]interface{}); m["value"] != 17.0 || m["type"] != "gauge" {
+		t.Errorf("connections = %v", m)
+	}
+	if _, ok := metrics["postgres_connect_"+instance]; !ok {
+		t.Errorf("no connect time in %v", metrics)
+	}
+}
+
+func TestPostgresCheckHealthQueryFails(t *testing.T) {
+	host, port := serve(t, (&pgServer{}).handle)
+
+	metrics := collect(t, "postgres", map[string]interface{}{"host": host, "port": port}, nil)
+	status := metrics["postgres_status_"+net.JoinHostPort(host, port)].(map[string]interface{})
+	if status["value"] != "down" || !strings.HasPrefix(status["reason"].(string), "health query: ") {
+		t.Errorf("status = %v", status)
+	}
+}
+
+func TestUnreachableServerIsDown(t *testing.T) {
+	for _, kind := range []string{"mysql", "postgres", "redis"} {
+		port := closedPort(t)
+		metrics := collect(t, kind, map[string]interface{}{"host": "127.0.0.1", "port": port}, map[string]interface{}{"timeout_s": 2})
+		status, _ := metrics[kind+"_status_127.0.0.1:"+port].(map[string]interface{})
+		if status["value"] != "down" || status["reason"] == "" || len(metrics) != 1 {
+			t.Errorf("%s: metrics = %v", kind, metrics)
+		}
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	host := map[string]interface{}{"address": "db1.example.com"}
+	got := resolveTarget("mysql", map[string]interface{}{"host": host, "credentials": map[string]interface{}{"user": "root", "port": "0"}})
+	if got.host != "db1.example.com" || got.port != "3306" || got.user != "root" {
+		t.Errorf("from the host: %+v", got)
+	}
+	got = resolveTarget("redis", map[string]interface{}{"host": host, "credentials": map[string]interface{}{"host": "cache", "port": "6380"}})
+	if got.host != "cache" || got.port != "6380" {
+		t.Errorf("from the credential: %+v", got)
+	}
+}
+
+func TestDSN(t *testing.T) {
+	for _, tc := range []struct {
+		t    target
+		opts checkOptions
+		want string
+	}{
+		{target{kind: "mysql", host: "db1", port: "3306", user: "root", pass: "pw"}, checkOptions{Database: "app"}, "root:pw@tcp(db1:3306)/app"},
+		{target{kind: "postgres", host: "db1", port: "5432"}, checkOptions{}, "postgres://db1:5432/postgres?sslmode=disable"},
+		{target{kind: "postgres", host: "::1", port: "5432", user: "mon", pass: "p@ss"}, checkOptions{Database: "app", SSLMode: "require"},
+			"postgres://mon:p%40ss@[::1]:5432/app?sslmode=require"},
+	} {
+		if got := dsn(tc.t, tc.opts); got != tc.want {
+			t.Errorf("dsn(%+v) = %s, want %s", tc.t, got, tc.want)
+		}
+	}
+}
+
+func TestParseInfo(t *testing.T) {
+	fields := parseInfo(redisInfo)
+	if len(fields) != 4 || fields["connected_clients"] != "12" || fields["redis_mode"] != "standalone" {
+		t.Errorf("fields = %v", fields)
+	}
+}
+
+func TestInvalidTasks(t *testing.T) {
+	p := &dbcheckPlugin{}
+	for name, options := range map[string]map[string]interface{}{
+		"unknown kind": {"action": "oracle", "host": map[string]interface{}{"address": "db1"}},
+		"no address":   {"action": "mysql"},
+		"bad options":  {"action": "mysql", "host": map[string]interface{}{"address": "db1"}, "options": map[string]interface{}{"timeout_s": "soon"}},
+	} {
+		if _, err := p.OnCollect(options); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
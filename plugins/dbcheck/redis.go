@@ -0,0 +1,147 @@
+package dbcheck
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkRedis connects, authenticates when the credential has a password, sends
+// PING and reads the INFO fields named by the queries.
+func checkRedis(ctx context.Context, t target, opts checkOptions) checkResult {
+	var res checkResult
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(t.host, t.port))
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c := &respConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if t.pass != "" {
+		args := []string{"AUTH", t.pass}
+		if t.user != "" {
+			args = []string{"AUTH", t.user, t.pass} // Redis 6 ACL users
+		}
+		if _, err := c.do(args...); err != nil {
+			res.err = fmt.Errorf("auth: %w", err)
+			return res
+		}
+	}
+	res.connect = time.Since(start)
+
+	start = time.Now()
+	pong, err := c.do("PING")
+	if err != nil {
+		res.err = fmt.Errorf("ping: %w", err)
+		return res
+	}
+	if pong != "PONG" {
+		res.err = fmt.Errorf("ping: unexpected reply %q", pong)
+		return res
+	}
+	res.query = time.Since(start)
+
+	if len(opts.Queries) == 0 {
+		return res
+	}
+	info, err := c.do("INFO")
+	if err != nil {
+		for name := range opts.Queries {
+			res.fail(name, "info: "+err.Error())
+		}
+		return res
+	}
+	fields := parseInfo(info)
+	for _, name := range sortedNames(opts.Queries) {
+		field := opts.Queries[name]
+		raw, ok := fields[field]
+		if !ok {
+			res.fail(name, fmt.Sprintf("no INFO field %q", field))
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			res.fail(name, fmt.Sprintf("INFO field %q is %q, not a number", field, raw))
+			continue
+		}
+		res.set(name, v)
+	}
+	return res
+}
+
+// parseInfo reads the "field:value" lines of an INFO reply.
+func parseInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// respConn speaks just enough of the Redis protocol (RESP) for the check:
+// commands out as arrays of bulk strings, and simple, error, integer and bulk
+// string replies back.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// do sends one command and returns its reply as a string.
+func (c *respConn) do(args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		return "", err
+	}
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", errors.New(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("bad bulk length %q", line[1:])
+		}
+		if n < 0 {
+			return "", nil // nil bulk string
+		}
+		buf := make([]byte, n+2) // payload and CRLF
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	default:
+		return "", fmt.Errorf("unsupported reply %q", line)
+	}
+}
@@ -55,7 +55,7 @@ func (p *devicePlugin) ShowPage(params map[string]string) (string, error) {
 
 func (p *devicePlugin) hostListPage() (string, error) {
 	// Load config
-	configData, err := os.ReadFile("data/config.json")
+	configData, err := plugin.ReadConfigFile()
 	if err != nil {
 		return "", err
 	}
@@ -63,7 +63,7 @@ func (p *devicePlugin) hostListPage() (string, error) {
 	json.Unmarshal(configData, &config)
 
 	// Load collections
-	collectionsData, err := os.ReadFile("data/collection.json")
+	collectionsData, err := os.ReadFile(plugin.DataFile("collection.json"))
 	if err != nil {
 		return "", err
 	}
@@ -71,7 +71,7 @@ func (p *devicePlugin) hostListPage() (string, error) {
 	json.Unmarshal(collectionsData, &collections)
 
 	// Load perception
-	perceptionData, _ := os.ReadFile("data/perception.json")
+	perceptionData, _ := os.ReadFile(plugin.DataFile("perception.json"))
 	var perception map[string]interface{}
 	json.Unmarshal(perceptionData, &perception)
 
@@ -94,7 +94,7 @@ func (p *devicePlugin) hostListPage() (string, error) {
 	if remote, ok := config["remote"].(map[string]interface{}); ok {
 		if tokens, ok := remote["tokens"].(map[string]interface{}); ok {
 			for idx, token := range tokens {
-				remoteData, _ := os.ReadFile(fmt.Sprintf("data/remote_%s.json", idx))
+				remoteData, _ := os.ReadFile(plugin.DataFile(fmt.Sprintf("remote_%s.json", idx)))
 				var remoteJSON map[string]interface{}
 				if json.Unmarshal(remoteData, &remoteJSON) == nil {
 					remoteGroup := ""
@@ -194,7 +194,7 @@ func (p *devicePlugin) showDevicePage(params map[string]string) (string, error)
 	}
 
 	// Load collections
-	collectionsData, err := os.ReadFile("data/collection.json")
+	collectionsData, err := os.ReadFile(plugin.DataFile("collection.json"))
 	if err != nil {
 		return "", err
 	}
@@ -207,7 +207,7 @@ func (p *devicePlugin) showDevicePage(params map[string]string) (string, error)
 	}
 
 	// Load config
-	configData, _ := os.ReadFile("data/config.json")
+	configData, _ := plugin.ReadConfigFile()
 	var config map[string]interface{}
 	json.Unmarshal(configData, &config)
 
@@ -0,0 +1,266 @@
+// Package dns monitors DNS servers: collect tasks "dns.check" query the host
+// for configured records, compare SOA serials across a zone's authoritative
+// servers and check DNSSEC validation.
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+)
+
+const defaultTimeout = 2 * time.Second
+
+// dnsPlugin checks DNS servers.
+type dnsPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&dnsPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *dnsPlugin) Name() string {
+	return "Dns"
+}
+
+// checkOptions are the per-task options of a dns.check task:
+//
+//	{"metric": "dns.check", "options": {
+//	    "server": "", "port": 53, "timeout_s": 2, "dnssec": true,
+//	    "records": [{"name": "www.example.com", "type": "A", "expected": ["192.0.2.10"]}],
+//	    "zone": "example.com", "authoritative": ["ns1.example.com", "192.0.2.53:53"]}}
+//
+// The server defaults to the host's address. A record without expected values
+// only has to resolve.
+type checkOptions struct {
+	Server        string        `json:"server"`
+	Port          int           `json:"port"`
+	TimeoutS      float64       `json:"timeout_s"`
+	DNSSEC        bool          `json:"dnssec"`
+	Records       []recordCheck `json:"records"`
+	Zone          string        `json:"zone"`
+	Authoritative []string      `json:"authoritative"`
+}
+
+// recordCheck is one record to query and the answers expected.
+type recordCheck struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Expected []string `json:"expected"`
+}
+
+// OnCollect runs the configured record, zone serial and DNSSEC checks.
+// Failures are status metrics with the reason, not task errors.
+func (p *dnsPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	if action, _ := options["action"].(string); action != "check" {
+		return nil, fmt.Errorf("undefined dns action: %s", action)
+	}
+	var opts checkOptions
+	if raw, ok := options["options"]; ok {
+		b, _ := json.Marshal(raw)
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return nil, fmt.Errorf("dns: invalid options: %w", err)
+		}
+	}
+	if len(opts.Records) == 0 && opts.Zone == "" {
+		return nil, errors.New("dns: no records or zone to check")
+	}
+	host, _ := options["host"].(map[string]interface{})
+	if opts.Server == "" {
+		opts.Server, _ = host["address"].(string)
+	}
+	if opts.Port == 0 {
+		opts.Port = 53
+	}
+	timeout := defaultTimeout
+	if opts.TimeoutS > 0 {
+		timeout = time.Duration(opts.TimeoutS * float64(time.Second))
+	}
+
+	metrics := make(map[string]interface{})
+	if len(opts.Records) > 0 {
+		if opts.Server == "" {
+			return nil, errors.New("dns: no server to query")
+		}
+		server := net.JoinHostPort(opts.Server, strconv.Itoa(opts.Port))
+		for _, rc := range opts.Records {
+			for k, v := range checkRecord(server, rc, timeout, opts.DNSSEC) {
+				metrics[k] = v
+			}
+		}
+	}
+	if opts.Zone != "" && len(opts.Authoritative) > 0 {
+		for k, v := range checkZoneSerial(opts.Zone, opts.Authoritative, timeout) {
+			metrics[k] = v
+		}
+	}
+	return map[string]interface{}{"metrics": metrics}, nil
+}
+
+// checkRecord queries one record; the instance is "name/type".
+func checkRecord(server string, rc recordCheck, timeout time.Duration, dnssec bool) map[string]interface{} {
+	rtype := strings.ToUpper(rc.Type)
+	if rtype == "" {
+		rtype = "A"
+	}
+	instance := trimDot(rc.Name) + "/" + rtype
+	status := metric("record_status", "Record", instance, "status", "down")
+	metrics := map[string]interface{}{"dns_status_" + instance: status}
+
+	qtype, ok := recordTypes[rtype]
+	if !ok {
+		status["reason"] = fmt.Sprintf("unsupported record type %q", rc.Type)
+		return metrics
+	}
+
+	resp, err := query(server, rc.Name, qtype, timeout, dnssec)
+	if resp != nil {
+		metrics["dns_latency_"+instance] = msMetric("latency_ms", instance, resp.rtt)
+		if resp.tcp {
+			status["transport"] = "tcp"
+		}
+	}
+	if err != nil {
+		status["reason"] = reason(err)
+		return metrics
+	}
+
+	got := answers(resp.msg, qtype)
+	status["answers"] = got
+	switch {
+	case len(got) == 0:
+		status["reason"] = "no answer"
+	case len(rc.Expected) > 0 && !sameSet(got, rc.Expected):
+		status["reason"] = "mismatch"
+		status["expected"] = rc.Expected
+	default:
+		status["value"] = "up"
+	}
+
+	if dnssec {
+		ad := metric("dnssec", "DNSSEC", instance, "status", "down")
+		if resp.msg.Header.AuthenticData {
+			ad["value"] = "up"
+		} else {
+			ad["reason"] = "answer not validated (AD flag not set)"
+		}
+		metrics["dns_dnssec_"+instance] = ad
+	}
+	return metrics
+}
+
+// checkZoneSerial asks each authoritative server for the zone's SOA and reports
+// whether they agree. The instance of the per-server serials is "zone/server".
+func checkZoneSerial(zone string, servers []string, timeout time.Duration) map[string]interface{} {
+	zone = trimDot(zone)
+	metrics := make(map[string]interface{})
+	serials := make(map[string]uint32)
+	failures := make(map[string]string)
+	for _, s := range servers {
+		addr := s
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			addr = net.JoinHostPort(s, "53")
+		}
+		resp, err := query(addr, zone, recordTypes["SOA"], timeout, false)
+		if err != nil {
+			failures[s] = reason(err)
+			continue
+		}
+		serial, ok := soaSerial(resp.msg)
+		if !ok {
+			failures[s] = "no SOA in answer"
+			continue
+		}
+		serials[s] = serial
+		metrics["dns_serial_"+zone+"/"+s] = metric("zone_serial", "Serial", zone+"/"+s, "gauge", serial)
+	}
+
+	status := metric("zone_serial_mismatch", "Zone serials", zone, "status", "up")
+	distinct := make(map[uint32]bool)
+	for _, serial := range serials {
+		distinct[serial] = true
+	}
+	switch {
+	case len(serials) == 0:
+		status["value"] = "down"
+		status["reason"] = "no authoritative server answered"
+	case len(distinct) > 1:
+		status["value"] = "down"
+		status["reason"] = "serials differ"
+	case len(failures) > 0:
+		status["value"] = "warning"
+		status["reason"] = "some servers did not answer"
+	}
+	if len(serials) > 0 {
+		status["serials"] = serials
+	}
+	if len(failures) > 0 {
+		status["failures"] = failures
+	}
+	metrics["dns_zone_serial_"+zone] = status
+	return metrics
+}
+
+// reason names a query failure: "timeout", the response code ("servfail",
+// "nameerror", ...) or the network error.
+func reason(err error) string {
+	var rc *rcodeError
+	switch {
+	case errors.Is(err, errTimeout):
+		return "timeout"
+	case errors.As(err, &rc):
+		return rc.Error()
+	default:
+		return err.Error()
+	}
+}
+
+// sameSet compares answers ignoring order and case.
+func sameSet(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	norm := func(in []string) []string {
+		out := make([]string, len(in))
+		for i, s := range in {
+			out[i] = strings.ToLower(trimDot(strings.TrimSpace(s)))
+		}
+		sort.Strings(out)
+		return out
+	}
+	g, w := norm(got), norm(want)
+	for i := range g {
+		if g[i] != w[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func msMetric(name, instance string, d time.Duration) map[string]interface{} {
+	ms := float64(d.Microseconds()) / 1000
+	m := metric(name, "Latency (ms)", instance, "gauge", fmt.Sprintf("%.1f", ms))
+	m["value_num"] = ms
+	return m
+}
+
+func metric(name, label, instance, metricType string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    label,
+		"value":    value,
+		"type":     metricType,
+		"category": "dns",
+		"instance": instance,
+	}
+}
@@ -0,0 +1,331 @@
+package dns
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testServer is an in-process DNS server answering over UDP and TCP on the
+// same port. Names under example.com. behave as their first label says:
+//
+//	www     two A records
+//	big     a TXT answer truncated over UDP, complete over TCP
+//	secure  an A record with the AD flag set when the query asked for it
+//	broken  SERVFAIL
+//	slow    no answer at all
+//
+// The zone apex answers SOA with serial; anything else is NXDOMAIN.
+type testServer struct {
+	serial  uint32
+	tcpUsed atomic.Int32
+}
+
+// start runs s and returns its address.
+func (s *testServer) start(t *testing.T) string {
+	t.Helper()
+	var udp net.PacketConn
+	var tcp net.Listener
+	for i := 0; tcp == nil; i++ {
+		var err error
+		if udp, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if tcp, err = net.Listen("tcp", udp.LocalAddr().String()); err != nil {
+			udp.Close()
+			if i == 10 {
+				t.Fatal(err)
+			}
+		}
+	}
+	t.Cleanup(func() { udp.Close(); tcp.Close() })
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply := s.answer(buf[:n], false); reply != nil {
+				udp.WriteTo(reply, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			s.tcpUsed.Add(1)
+			go func() {
+				defer conn.Close()
+				var size [2]byte
+				if _, err := io.ReadFull(conn, size[:]); err != nil {
+					return
+				}
+				q := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err := io.ReadFull(conn, q); err != nil {
+					return
+				}
+				reply := s.answer(q, true)
+				conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+			}()
+		}
+	}()
+	return udp.LocalAddr().String()
+}
+
+func (s *testServer) answer(q []byte, tcp bool) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(q); err != nil || len(msg.Questions) != 1 {
+		return nil
+	}
+	question := msg.Questions[0]
+	name := question.Name.String()
+	label, _, _ := strings.Cut(name, ".")
+	h := dnsmessage.Header{ID: msg.Header.ID, Response: true, Authoritative: true, RecursionDesired: msg.Header.RecursionDesired}
+	rr := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 300}
+	var bodies []dnsmessage.ResourceBody
+
+	switch {
+	case name == "example.com." && question.Type == dnsmessage.TypeSOA:
+		bodies = append(bodies, &dnsmessage.SOAResource{
+			NS: dnsmessage.MustNewName("ns1.example.com."), MBox: dnsmessage.MustNewName("hostmaster.example.com."),
+			Serial: s.serial, Refresh: 3600, Retry: 600, Expire: 86400, MinTTL: 300,
+		})
+	case label == "www":
+		bodies = append(bodies, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 10}}, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 11}})
+	case label == "secure":
+		h.AuthenticData = msg.Header.AuthenticData
+		bodies = append(bodies, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 20}})
+	case label == "big" && !tcp:
+		h.Truncated = true
+	case label == "big":
+		bodies = append(bodies, &dnsmessage.TXTResource{TXT: []string{"v=spf1 ", "-all"}})
+	case label == "broken":
+		h.RCode = dnsmessage.RCodeServerFailure
+	case label == "slow":
+		return nil
+	default:
+		h.RCode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(nil, h)
+	b.StartQuestions()
+	b.Question(question)
+	b.StartAnswers()
+	for _, body := range bodies {
+		switch body := body.(type) {
+		case *dnsmessage.AResource:
+			b.AResource(rr, *body)
+		case *dnsmessage.TXTResource:
+			b.TXTResource(rr, *body)
+		case *dnsmessage.SOAResource:
+			b.SOAResource(rr, *body)
+		}
+	}
+	reply, _ := b.Finish()
+	return reply
+}
+
+// check runs one dns.check task against server (host:port) and returns its metrics.
+func check(t *testing.T, server string, opts map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(server)
+	opts["port"], _ = strconv.Atoi(port)
+	if _, ok := opts["timeout_s"]; !ok {
+		opts["timeout_s"] = 0.3
+	}
+	result, err := (&dnsPlugin{}).OnCollect(map[string]interface{}{
+		"action":  "check",
+		"host":    map[string]interface{}{"address": host, "name": "ns1"},
+		"options": opts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result["metrics"].(map[string]interface{})
+}
+
+func record(name, rtype string, expected ...string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "type": rtype, "expected": expected}
+}
+
+// status returns the metric named key, failing when it is missing.
+func status(t *testing.T, metrics map[string]interface{}, key string) map[string]interface{} {
+	t.Helper()
+	m, ok := metrics[key].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no %s in %v", key, metrics)
+	}
+	return m
+}
+
+func TestRecordChecks(t *testing.T) {
+	srv := &testServer{}
+	metrics := check(t, srv.start(t), map[string]interface{}{"records": []interface{}{
+		record("www.example.com.", "a", "192.0.2.11", "192.0.2.10"),
+		record("missing.example.com", "A"),
+		record("broken.example.com", "A"),
+		record("slow.example.com", "A"),
+		record("www.example.com", "HINFO"),
+	}})
+
+	for key, want := range map[string]string{
+		"dns_status_www.example.com/A":     "",
+		"dns_status_missing.example.com/A": "nxdomain",
+		"dns_status_broken.example.com/A":  "servfail",
+		"dns_status_slow.example.com/A":    "timeout",
+		"dns_status_www.example.com/HINFO": `unsupported record type "HINFO"`,
+	} {
+		m := status(t, metrics, key)
+		wantValue := "down"
+		if want == "" {
+			wantValue = "up"
+		}
+		if reason, _ := m["reason"].(string); m["value"] != wantValue || reason != want {
+			t.Errorf("%s = %v, want reason %q", key, m, want)
+		}
+		if m["instance"] != strings.TrimPrefix(key, "dns_status_") || m["category"] != "dns" {
+			t.Errorf("%s = %v", key, m)
+		}
+	}
+	l := status(t, metrics, "dns_latency_www.example.com/A")
+	if _, ok := l["value_num"].(float64); !ok || l["name"] != "latency_ms" {
+		t.Errorf("latency = %v", l)
+	}
+}
+
+func TestRecordMismatch(t *testing.T) {
+	srv := &testServer{}
+	metrics := check(t, srv.start(t), map[string]interface{}{"records": []interface{}{
+		record("www.example.com", "A", "192.0.2.99"),
+		record("www.example.com", "TXT"),
+	}})
+	m := status(t, metrics, "dns_status_www.example.com/A")
+	if m["value"] != "down" || m["reason"] != "mismatch" || strings.Join(m["answers"].([]string), ",") != "192.0.2.10,192.0.2.11" {
+		t.Errorf("mismatch = %v", m)
+	}
+	// www answers A records whatever the question, so a TXT query finds none.
+	if m := status(t, metrics, "dns_status_www.example.com/TXT"); m["value"] != "down" || m["reason"] != "no answer" {
+		t.Errorf("no answer = %v", m)
+	}
+}
+
+func TestFailuresAreDistinct(t *testing.T) {
+	srv := &testServer{}
+	addr := srv.start(t)
+	metrics := check(t, addr, map[string]interface{}{"records": []interface{}{
+		record("broken.example.com", "A"),
+		record("slow.example.com", "A"),
+	}})
+	// A SERVFAIL is an answer, with a latency; a timeout is not.
+	if _, ok := metrics["dns_latency_broken.example.com/A"]; !ok {
+		t.Errorf("no latency for the SERVFAIL answer: %v", metrics)
+	}
+	if _, ok := metrics["dns_latency_slow.example.com/A"]; ok {
+		t.Errorf("latency reported for a timeout: %v", metrics)
+	}
+}
+
+func TestTruncatedAnswerRetriesOverTCP(t *testing.T) {
+	srv := &testServer{}
+	metrics := check(t, srv.start(t), map[string]interface{}{"records": []interface{}{record("big.example.com", "TXT", "v=spf1 -all")}})
+	m := status(t, metrics, "dns_status_big.example.com/TXT")
+	if m["value"] != "up" || m["transport"] != "tcp" {
+		t.Errorf("status = %v", m)
+	}
+	if srv.tcpUsed.Load() != 1 {
+		t.Errorf("%d TCP connections, want 1", srv.tcpUsed.Load())
+	}
+}
+
+func TestDNSSEC(t *testing.T) {
+	srv := &testServer{}
+	metrics := check(t, srv.start(t), map[string]interface{}{"dnssec": true, "records": []interface{}{
+		record("secure.example.com", "A"),
+		record("www.example.com", "A"),
+	}})
+	if m := status(t, metrics, "dns_dnssec_secure.example.com/A"); m["value"] != "up" {
+		t.Errorf("validated answer = %v", m)
+	}
+	if m := status(t, metrics, "dns_dnssec_www.example.com/A"); m["value"] != "down" || !strings.Contains(m["reason"].(string), "AD flag") {
+		t.Errorf("unvalidated answer = %v", m)
+	}
+
+	metrics = check(t, srv.start(t), map[string]interface{}{"records": []interface{}{record("secure.example.com", "A")}})
+	if _, ok := metrics["dns_dnssec_secure.example.com/A"]; ok {
+		t.Error("DNSSEC checked without dnssec set")
+	}
+}
+
+func TestZoneSerial(t *testing.T) {
+	a := (&testServer{serial: 2024050101}).start(t)
+	b := (&testServer{serial: 2024050101}).start(t)
+	stale := (&testServer{serial: 2024043001}).start(t)
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	zone := func(servers ...string) map[string]interface{} {
+		t.Helper()
+		list := make([]interface{}, len(servers))
+		for i, s := range servers {
+			list[i] = s
+		}
+		metrics := check(t, a, map[string]interface{}{"zone": "example.com.", "authoritative": list})
+		return status(t, metrics, "dns_zone_serial_example.com")
+	}
+
+	if m := zone(a, b); m["value"] != "up" || len(m["serials"].(map[string]uint32)) != 2 {
+		t.Errorf("agreeing servers = %v", m)
+	}
+	if m := zone(a, stale); m["value"] != "down" || m["reason"] != "serials differ" {
+		t.Errorf("stale server = %v", m)
+	}
+	m := zone(a, silent.LocalAddr().String())
+	if m["value"] != "warning" || m["failures"].(map[string]string)[silent.LocalAddr().String()] != "timeout" {
+		t.Errorf("silent server = %v", m)
+	}
+	if m := zone(silent.LocalAddr().String()); m["value"] != "down" || m["reason"] != "no authoritative server answered" {
+		t.Errorf("no answers = %v", m)
+	}
+
+	metrics := check(t, a, map[string]interface{}{"zone": "example.com", "authoritative": []interface{}{a}})
+	if s := status(t, metrics, "dns_serial_example.com/"+a); s["value"] != uint32(2024050101) || s["type"] != "gauge" {
+		t.Errorf("serial = %v", s)
+	}
+}
+
+func TestInvalidTasks(t *testing.T) {
+	p := &dnsPlugin{}
+	for name, options := range map[string]map[string]interface{}{
+		"unknown action": {"action": "lookup", "options": map[string]interface{}{"zone": "example.com"}},
+		"nothing to do":  {"action": "check", "options": map[string]interface{}{}},
+		"no server":      {"action": "check", "options": map[string]interface{}{"records": []interface{}{record("www.example.com", "A")}}},
+		"bad options":    {"action": "check", "options": map[string]interface{}{"records": "www"}},
+	} {
+		if _, err := p.OnCollect(options); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestSameSet(t *testing.T) {
+	if !sameSet([]string{"ns1.Example.com.", "ns2.example.com"}, []string{"NS2.example.com", " ns1.example.com"}) {
+		t.Error("order, case, space and trailing dots should not matter")
+	}
+	if sameSet([]string{"a", "a"}, []string{"a", "b"}) || sameSet([]string{"a"}, nil) {
+		t.Error("different sets compared equal")
+	}
+}
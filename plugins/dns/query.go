@@ -0,0 +1,239 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// errTimeout reports a server that did not answer in time, as distinct from
+// one that answered with an error code.
+var errTimeout = errors.New("timeout")
+
+// rcodeError is a response with a code other than NOERROR, e.g. SERVFAIL.
+type rcodeError struct{ rcode dnsmessage.RCode }
+
+func (e *rcodeError) Error() string {
+	switch e.rcode {
+	case dnsmessage.RCodeServerFailure:
+		return "servfail"
+	case dnsmessage.RCodeNameError:
+		return "nxdomain"
+	case dnsmessage.RCodeRefused:
+		return "refused"
+	default:
+		return strings.ToLower(strings.TrimPrefix(e.rcode.String(), "RCode"))
+	}
+}
+
+// response is a parsed answer and how it was obtained.
+type response struct {
+	msg *dnsmessage.Message
+	rtt time.Duration
+	tcp bool // the UDP answer was truncated and the query was retried over TCP
+}
+
+// query asks server (host:port) one question over UDP, retrying over TCP when
+// the answer is truncated. With dnssec set, the query carries the DO and AD
+// bits so a validating resolver reports whether it validated the answer.
+func query(server, name string, qtype dnsmessage.Type, timeout time.Duration, dnssec bool) (*response, error) {
+	q, id, err := buildQuery(name, qtype, dnssec)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	raw, err := exchangeUDP(server, q, timeout)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := parseReply(raw, id)
+	if err != nil {
+		return nil, err
+	}
+	resp := &response{msg: msg}
+	if msg.Header.Truncated {
+		raw, err = exchangeTCP(server, q, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("tcp retry: %w", err)
+		}
+		if msg, err = parseReply(raw, id); err != nil {
+			return nil, fmt.Errorf("tcp retry: %w", err)
+		}
+		resp.msg, resp.tcp = msg, true
+	}
+	resp.rtt = time.Since(start)
+
+	if msg.Header.RCode != dnsmessage.RCodeSuccess {
+		return resp, &rcodeError{rcode: msg.Header.RCode}
+	}
+	return resp, nil
+}
+
+// buildQuery packs a recursive question and returns it with its message id.
+func buildQuery(name string, qtype dnsmessage.Type, dnssec bool) ([]byte, uint16, error) {
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid name %q: %w", name, err)
+	}
+	id := uint16(rand.Intn(1 << 16))
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true, AuthenticData: dnssec})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	if dnssec {
+		if err := b.StartAdditionals(); err != nil {
+			return nil, 0, err
+		}
+		var opt dnsmessage.ResourceHeader
+		if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, true); err != nil {
+			return nil, 0, err
+		}
+		if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+			return nil, 0, err
+		}
+	}
+	msg, err := b.Finish()
+	return msg, id, err
+}
+
+func parseReply(raw []byte, id uint16) (*dnsmessage.Message, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(raw); err != nil {
+		return nil, fmt.Errorf("malformed reply: %w", err)
+	}
+	if msg.Header.ID != id {
+		return nil, errors.New("reply id does not match the query")
+	}
+	return &msg, nil
+}
+
+func exchangeUDP(server string, q []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(q); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, netError(err)
+	}
+	return buf[:n], nil
+}
+
+// exchangeTCP sends q with the two-byte length prefix DNS uses over TCP.
+func exchangeTCP(server string, q []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return nil, netError(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	framed := make([]byte, 2+len(q))
+	binary.BigEndian.PutUint16(framed, uint16(len(q)))
+	copy(framed[2:], q)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, netError(err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, netError(err)
+	}
+	return buf, nil
+}
+
+// netError maps a deadline expiry to errTimeout.
+func netError(err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return errTimeout
+	}
+	return err
+}
+
+// answers formats the answer records of the queried type for comparison with
+// the expected values: addresses, names without the trailing dot, "pref host"
+// for MX and the joined strings of TXT.
+func answers(msg *dnsmessage.Message, qtype dnsmessage.Type) []string {
+	var out []string
+	for _, rr := range msg.Answers {
+		if rr.Header.Type != qtype {
+			continue
+		}
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			out = append(out, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			out = append(out, net.IP(body.AAAA[:]).String())
+		case *dnsmessage.CNAMEResource:
+			out = append(out, trimDot(body.CNAME.String()))
+		case *dnsmessage.NSResource:
+			out = append(out, trimDot(body.NS.String()))
+		case *dnsmessage.PTRResource:
+			out = append(out, trimDot(body.PTR.String()))
+		case *dnsmessage.MXResource:
+			out = append(out, fmt.Sprintf("%d %s", body.Pref, trimDot(body.MX.String())))
+		case *dnsmessage.TXTResource:
+			out = append(out, strings.Join(body.TXT, ""))
+		case *dnsmessage.SRVResource:
+			out = append(out, fmt.Sprintf("%d %d %d %s", body.Priority, body.Weight, body.Port, trimDot(body.Target.String())))
+		case *dnsmessage.SOAResource:
+			out = append(out, fmt.Sprintf("%d", body.Serial))
+		}
+	}
+	return out
+}
+
+// soaSerial returns the serial of the SOA record in msg.
+func soaSerial(msg *dnsmessage.Message) (uint32, bool) {
+	for _, rr := range msg.Answers {
+		if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+			return soa.Serial, true
+		}
+	}
+	return 0, false
+}
+
+// recordTypes are the query types accepted in the config.
+var recordTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func trimDot(name string) string {
+	return strings.TrimSuffix(name, ".")
+}
@@ -0,0 +1,290 @@
+// Package execcheck runs local scripts and records their output as metrics:
+// collect tasks "exec.run" name a script from an allowlisted directory and
+// the format its output is in.
+package execcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+)
+
+const (
+	defaultTimeout   = 30 * time.Second
+	defaultMaxOutput = 4096
+	parseLimit       = 1 << 20 // output read for parsing; only maxOutput is stored
+)
+
+// execPlugin runs allowlisted scripts.
+type execPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&execPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *execPlugin) Name() string {
+	return "Exec"
+}
+
+// runOptions are the per-task options of an exec.run task:
+//
+//	{"metric": "exec.run", "options": {
+//	    "name": "backup", "command": "check_backup", "args": ["--max-age", "26h"],
+//	    "timeout_s": 30, "format": "nagios"}}
+//
+// command is a file name looked up in the exec.dirs of the configuration; it
+// is run directly, without a shell, so args are passed through as they are.
+// The string "{address}" in args is replaced with the host's address.
+// format is "nagios" (the default), "json" or "lines"; see parseOutput.
+type runOptions struct {
+	Name     string   `json:"name"`
+	Command  string   `json:"command"`
+	Args     []string `json:"args"`
+	TimeoutS float64  `json:"timeout_s"`
+	Format   string   `json:"format"`
+}
+
+// runResult is what a finished (or killed) command left behind.
+type runResult struct {
+	exitCode       int
+	duration       time.Duration
+	stdout, stderr string
+	truncated      bool
+	timedOut       bool
+}
+
+// OnCollect runs the task's command and maps its exit code and output to
+// metrics. A command that cannot be started is a task error; one that fails
+// is a status metric.
+func (p *execPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	if action, _ := options["action"].(string); action != "run" {
+		return nil, fmt.Errorf("undefined exec action: %s", action)
+	}
+	var opts runOptions
+	if raw, ok := options["options"]; ok {
+		b, _ := json.Marshal(raw)
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return nil, fmt.Errorf("exec: invalid options: %w", err)
+		}
+	}
+	if opts.Command == "" {
+		return nil, errors.New("exec: no command")
+	}
+	if opts.Name == "" {
+		opts.Name = opts.Command
+	}
+	if opts.Format == "" {
+		opts.Format = "nagios"
+	}
+	if opts.Format != "nagios" && opts.Format != "json" && opts.Format != "lines" {
+		return nil, fmt.Errorf("exec: unknown format %q", opts.Format)
+	}
+	timeout := defaultTimeout
+	if opts.TimeoutS > 0 {
+		timeout = time.Duration(opts.TimeoutS * float64(time.Second))
+	}
+
+	cfg := loadExecConfig()
+	path, err := resolveCommand(cfg.Dirs, opts.Command)
+	if err != nil {
+		return nil, err
+	}
+	maxOutput := cfg.MaxOutputBytes
+	if maxOutput <= 0 {
+		maxOutput = defaultMaxOutput
+	}
+	host, _ := options["host"].(map[string]interface{})
+	address, _ := host["address"].(string)
+	args := make([]string, len(opts.Args))
+	for i, a := range opts.Args {
+		args[i] = strings.ReplaceAll(a, "{address}", address)
+	}
+
+	res, err := run(path, args, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("exec: %s: %w", opts.Command, err)
+	}
+	return map[string]interface{}{"metrics": resultMetrics(opts, res, maxOutput)}, nil
+}
+
+// loadExecConfig reads the "exec" section of the configuration file.
+func loadExecConfig() plugin.ExecConfig {
+	var cfg struct {
+		Exec plugin.ExecConfig `json:"exec"`
+	}
+	if data, err := plugin.ReadConfigFile(); err == nil {
+		_ = json.Unmarshal(data, &cfg)
+	}
+	return cfg.Exec
+}
+
+// resolveCommand finds name in the allowlisted directories. Names with a path
+// separator are refused, and symlinks must resolve inside the directory.
+func resolveCommand(dirs []string, name string) (string, error) {
+	if len(dirs) == 0 {
+		return "", errors.New("exec: no script directories allowed (set exec.dirs in the configuration)")
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("exec: command %q must be a file name, not a path", name)
+	}
+	for _, dir := range dirs {
+		root, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		path, err := filepath.EvalSymlinks(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, path); err != nil || strings.HasPrefix(rel, "..") {
+			return "", fmt.Errorf("exec: %s resolves outside %s", name, dir)
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("exec: command %q not found in %s", name, strings.Join(dirs, ", "))
+}
+
+// run executes path with args, without a shell, and kills it when the timeout
+// expires. A non-zero exit is a result, not an error.
+func run(path string, args []string, timeout time.Duration) (runResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = filepath.Dir(path)
+	// Grandchildren holding the pipes open must not outlive the kill.
+	cmd.WaitDelay = time.Second
+	stdout := &limitedBuffer{max: parseLimit}
+	stderr := &limitedBuffer{max: parseLimit}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	res := runResult{
+		duration:  time.Since(start),
+		stdout:    stdout.String(),
+		stderr:    stderr.String(),
+		truncated: stdout.truncated || stderr.truncated,
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		res.timedOut, res.exitCode = true, -1
+	case errors.As(err, &exitErr):
+		res.exitCode = exitErr.ExitCode()
+	case err != nil && !errors.Is(err, exec.ErrWaitDelay):
+		return res, err
+	}
+	return res, nil
+}
+
+// limitedBuffer keeps the first max bytes written to it and discards the rest,
+// so a chatty script cannot grow memory or the store.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// resultMetrics maps a run to its status, exit code and duration, plus the
+// metrics parsed from its output. The instance of all of them is the task name.
+// At most maxOutput bytes of stdout and stderr are kept in the status metric.
+func resultMetrics(opts runOptions, res runResult, maxOutput int) map[string]interface{} {
+	name := opts.Name
+	status := metric("exec_status", "Status", name, "status", "up")
+	status["command"] = opts.Command
+	status["format"] = opts.Format
+	status["exit_code"] = res.exitCode
+	truncated := res.truncated
+	for key, out := range map[string]string{"stdout": res.stdout, "stderr": res.stderr} {
+		if len(out) > maxOutput {
+			out, truncated = out[:maxOutput], true
+		}
+		if out != "" {
+			status[key] = out
+		}
+	}
+	if truncated {
+		status["truncated"] = true
+	}
+
+	metrics := map[string]interface{}{"exec_status_" + name: status}
+	ms := float64(res.duration.Microseconds()) / 1000
+	d := metric("duration_ms", "Duration (ms)", name, "gauge", fmt.Sprintf("%.1f", ms))
+	d["value_num"] = ms
+	metrics["exec_duration_"+name] = d
+
+	if res.timedOut {
+		status["value"] = "down"
+		status["reason"] = "timed out"
+		return metrics
+	}
+	metrics["exec_exit_code_"+name] = metric("exit_code", "Exit code", name, "gauge", res.exitCode)
+
+	parsed, err := parseOutput(opts.Format, res)
+	if err != nil {
+		status["value"] = "down"
+		status["reason"] = err.Error()
+		return metrics
+	}
+	status["value"] = parsed.status
+	switch {
+	case parsed.message != "":
+		status["reason"] = parsed.message
+	case res.exitCode != 0:
+		status["reason"] = fmt.Sprintf("exit code %d", res.exitCode)
+	}
+	for _, pm := range parsed.metrics {
+		m := metric(pm.name, pm.label, name, pm.metricType, pm.value)
+		if pm.num != nil {
+			m["value_num"] = *pm.num
+		}
+		for k, v := range pm.extra {
+			m[k] = v
+		}
+		metrics["exec_"+name+"_"+pm.name] = m
+	}
+	return metrics
+}
+
+func metric(name, label, instance, metricType string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    label,
+		"value":    value,
+		"type":     metricType,
+		"category": "exec",
+		"instance": instance,
+	}
+}
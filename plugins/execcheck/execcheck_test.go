@@ -0,0 +1,321 @@
+package execcheck
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+)
+
+// useScripts writes the fixture scripts into an allowlisted directory and
+// points the configuration at it, with maxOutput bytes of output kept.
+func useScripts(t *testing.T, maxOutput int, scripts map[string]string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fixture scripts are shell scripts")
+	}
+	dir := t.TempDir()
+	scriptDir := filepath.Join(dir, "scripts")
+	if err := os.Mkdir(scriptDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range scripts {
+		if err := os.WriteFile(filepath.Join(scriptDir, name), []byte("#!/bin/sh\n"+body), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, _ := json.Marshal(map[string]interface{}{
+		"config_version": 1,
+		"exec":           map[string]interface{}{"dirs": []string{scriptDir}, "max_output_bytes": maxOutput},
+	})
+	old := plugin.ConfigFile
+	plugin.ConfigFile = filepath.Join(dir, "config.json")
+	t.Cleanup(func() { plugin.ConfigFile = old })
+	if err := os.WriteFile(plugin.ConfigFile, cfg, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return scriptDir
+}
+
+// runTask runs one exec.run task on a host at 10.0.0.5.
+func runTask(opts map[string]interface{}) (map[string]interface{}, error) {
+	result, err := (&execPlugin{}).OnCollect(map[string]interface{}{
+		"action":  "run",
+		"host":    map[string]interface{}{"address": "10.0.0.5", "name": "app1"},
+		"options": opts,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result["metrics"].(map[string]interface{}), nil
+}
+
+func mustRun(t *testing.T, opts map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	metrics, err := runTask(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metrics
+}
+
+func get(t *testing.T, metrics map[string]interface{}, key string) map[string]interface{} {
+	t.Helper()
+	m, ok := metrics[key].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no %s in %v", key, metrics)
+	}
+	return m
+}
+
+func TestNagiosFormat(t *testing.T) {
+	useScripts(t, 0, map[string]string{
+		"check_disk": `echo "DISK WARNING - 85% used | '/ used'=85%;80;90;0;100 inodes=1200"
+echo "more detail|load=0.50;;;0 bad=x"
+echo "disk is filling up" >&2
+exit 1
+`,
+		"check_ok":      "echo OK\n",
+		"check_crit":    "echo CRITICAL - gone\nexit 2\n",
+		"check_unknown": "echo UNKNOWN - no data\nexit 3\n",
+	})
+
+	metrics := mustRun(t, map[string]interface{}{"name": "disk", "command": "check_disk"})
+	s := get(t, metrics, "exec_status_disk")
+	if s["value"] != "warning" || s["reason"] != "DISK WARNING - 85% used" || s["exit_code"] != 1 || s["format"] != "nagios" {
+		t.Errorf("status = %v", s)
+	}
+	if s["stderr"] != "disk is filling up\n" || !strings.HasPrefix(s["stdout"].(string), "DISK WARNING") {
+		t.Errorf("output = %q, %q", s["stdout"], s["stderr"])
+	}
+	used := get(t, metrics, "exec_disk___used")
+	if used["label"] != "/ used" || used["value"] != "85" || used["value_num"] != 85.0 || used["unit"] != "%" ||
+		used["warn"] != "80" || used["crit"] != "90" || used["min"] != "0" || used["max"] != "100" || used["instance"] != "disk" {
+		t.Errorf("/ used = %v", used)
+	}
+	if m := get(t, metrics, "exec_disk_inodes"); m["value_num"] != 1200.0 || m["unit"] != nil {
+		t.Errorf("inodes = %v", m)
+	}
+	if m := get(t, metrics, "exec_disk_load"); m["value"] != "0.50" || m["min"] != "0" || m["warn"] != nil {
+		t.Errorf("load = %v", m)
+	}
+	if _, ok := metrics["exec_disk_bad"]; ok {
+		t.Error("unparsable perfdata became a metric")
+	}
+	if m := get(t, metrics, "exec_exit_code_disk"); m["value"] != 1 {
+		t.Errorf("exit code = %v", m)
+	}
+
+	for command, want := range map[string]string{"check_ok": "up", "check_crit": "down", "check_unknown": "unknown"} {
+		s := get(t, mustRun(t, map[string]interface{}{"command": command}), "exec_status_"+command)
+		if s["value"] != want {
+			t.Errorf("%s = %v, want %s", command, s, want)
+		}
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	useScripts(t, 0, map[string]string{
+		"queue": `cat <<'EOF'
+{"status": "warning", "message": "queue backing up", "metrics": [
+  {"name": "Queue Depth", "value": 120, "unit": "msgs"},
+  {"name": "rate", "value": 3.5, "type": "counter", "label": "Rate"},
+  {"name": "leader", "value": "node2"}]}
+EOF
+`,
+		"plain":     `echo '{"metrics": [{"name": "x", "value": 1}]}'; exit 2`,
+		"garbage":   "echo not json\n",
+		"crashed":   "echo Traceback >&2\nexit 2\n",
+		"badstatus": `echo '{"status": "fine"}'`,
+		"noname":    `echo '{"metrics": [{"value": 1}]}'`,
+	})
+
+	metrics := mustRun(t, map[string]interface{}{"command": "queue", "format": "json"})
+	if s := get(t, metrics, "exec_status_queue"); s["value"] != "warning" || s["reason"] != "queue backing up" {
+		t.Errorf("status = %v", s)
+	}
+	if m := get(t, metrics, "exec_queue_queue_depth"); m["value"] != "120" || m["value_num"] != 120.0 || m["type"] != "gauge" || m["unit"] != "msgs" || m["label"] != "Queue Depth" {
+		t.Errorf("queue depth = %v", m)
+	}
+	if m := get(t, metrics, "exec_queue_rate"); m["type"] != "counter" || m["label"] != "Rate" {
+		t.Errorf("rate = %v", m)
+	}
+	if m := get(t, metrics, "exec_queue_leader"); m["value"] != "node2" || m["type"] != "text" || m["value_num"] != nil {
+		t.Errorf("leader = %v", m)
+	}
+
+	for command, want := range map[string]string{
+		"plain":     "exit code 2",
+		"garbage":   "output is not JSON: ",
+		"crashed":   "exit code 2",
+		"badstatus": `invalid status "fine"`,
+		"noname":    "metric without a name",
+	} {
+		s := get(t, mustRun(t, map[string]interface{}{"command": command, "format": "json"}), "exec_status_"+command)
+		if s["value"] != "down" || !strings.HasPrefix(s["reason"].(string), want) {
+			t.Errorf("%s = %v, want reason %q", command, s, want)
+		}
+	}
+}
+
+func TestLinesFormat(t *testing.T) {
+	useScripts(t, 0, map[string]string{
+		"facts": `printf '# facts\n\nversion = 2.4.1\nworkers=8\nnot a pair\n=orphan\nLast Run=12.5\n'`,
+		"fails": "echo errors=3\nexit 4\n",
+	})
+
+	metrics := mustRun(t, map[string]interface{}{"name": "app", "command": "facts", "format": "lines"})
+	if s := get(t, metrics, "exec_status_app"); s["value"] != "up" || s["reason"] != nil {
+		t.Errorf("status = %v", s)
+	}
+	if m := get(t, metrics, "exec_app_version"); m["value"] != "2.4.1" || m["type"] != "text" {
+		t.Errorf("version = %v", m)
+	}
+	if m := get(t, metrics, "exec_app_workers"); m["value_num"] != 8.0 || m["type"] != "gauge" {
+		t.Errorf("workers = %v", m)
+	}
+	if m := get(t, metrics, "exec_app_last_run"); m["label"] != "Last Run" || m["value_num"] != 12.5 {
+		t.Errorf("last run = %v", m)
+	}
+	// status, duration, exit code and the three pairs
+	if len(metrics) != 6 {
+		t.Errorf("metrics = %v", metrics)
+	}
+
+	metrics = mustRun(t, map[string]interface{}{"command": "fails", "format": "lines"})
+	if s := get(t, metrics, "exec_status_fails"); s["value"] != "down" || s["reason"] != "exit code 4" {
+		t.Errorf("status = %v", s)
+	}
+	if m := get(t, metrics, "exec_fails_errors"); m["value_num"] != 3.0 {
+		t.Errorf("errors = %v", m)
+	}
+}
+
+func TestTimeoutKillsCommand(t *testing.T) {
+	useScripts(t, 0, map[string]string{
+		"hang":       "echo started\nexec sleep 30\n",
+		"hang_child": "sleep 30\necho never\n", // the shell is killed, sleep keeps the pipe open
+	})
+	for _, command := range []string{"hang", "hang_child"} {
+		start := time.Now()
+		metrics := mustRun(t, map[string]interface{}{"command": command, "timeout_s": 0.2})
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: returned after %v", command, elapsed)
+		}
+		s := get(t, metrics, "exec_status_"+command)
+		if s["value"] != "down" || s["reason"] != "timed out" || s["exit_code"] != -1 {
+			t.Errorf("%s: status = %v", command, s)
+		}
+		if _, ok := metrics["exec_exit_code_"+command]; ok {
+			t.Errorf("%s: exit code reported for a killed command", command)
+		}
+		if d := get(t, metrics, "exec_duration_"+command); d["value_num"].(float64) < 200 {
+			t.Errorf("%s: duration = %v", command, d)
+		}
+	}
+	if s := get(t, mustRun(t, map[string]interface{}{"command": "hang", "timeout_s": 0.2}), "exec_status_hang"); s["stdout"] != "started\n" {
+		t.Errorf("output before the kill = %q", s["stdout"])
+	}
+}
+
+func TestArgsAreNotInterpreted(t *testing.T) {
+	dir := useScripts(t, 0, map[string]string{
+		"args": `for a in "$@"; do printf 'arg=%s\n' "$a"; done; echo "count=$#"`,
+	})
+	metrics := mustRun(t, map[string]interface{}{"command": "args", "format": "lines",
+		"args": []string{"--host", "{address}", "$(touch pwned); echo hi", "a b"}})
+	s := get(t, metrics, "exec_status_args")
+	want := "arg=--host\narg=10.0.0.5\narg=$(touch pwned); echo hi\narg=a b\ncount=4\n"
+	if s["stdout"] != want {
+		t.Errorf("stdout = %q, want %q", s["stdout"], want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Error("an argument was run by a shell")
+	}
+}
+
+func TestOutputTruncated(t *testing.T) {
+	useScripts(t, 16, map[string]string{
+		"chatty": "i=0; while [ $i -lt 100 ]; do echo line $i; i=$((i+1)); done\n",
+		"quiet":  "echo ok\n",
+	})
+	s := get(t, mustRun(t, map[string]interface{}{"command": "chatty"}), "exec_status_chatty")
+	if s["stdout"] != "line 0\nline 1\nli" || s["truncated"] != true {
+		t.Errorf("status = %v", s)
+	}
+	if s := get(t, mustRun(t, map[string]interface{}{"command": "quiet"}), "exec_status_quiet"); s["truncated"] != nil {
+		t.Errorf("status = %v", s)
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 5}
+	for _, s := range []string{"abc", "def", "ghi"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Errorf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	if b.String() != "abcde" || !b.truncated {
+		t.Errorf("buffer = %q, truncated %v", b.String(), b.truncated)
+	}
+}
+
+func TestCommandAllowlist(t *testing.T) {
+	dir := useScripts(t, 0, map[string]string{"ok": "echo ok\n"})
+	outside := filepath.Join(filepath.Dir(dir), "outside")
+	if err := os.WriteFile(outside, []byte("#!/bin/sh\necho escaped\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for command, want := range map[string]string{
+		"../outside": "must be a file name",
+		"/bin/sh":    "must be a file name",
+		"..":         "must be a file name",
+		"link":       "resolves outside",
+		"subdir":     "not found",
+		"missing":    "not found",
+	} {
+		if _, err := runTask(map[string]interface{}{"command": command}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", command, err, want)
+		}
+	}
+	if _, err := resolveCommand(nil, "ok"); err == nil || !strings.Contains(err.Error(), "no script directories allowed") {
+		t.Errorf("without dirs: %v", err)
+	}
+	if path, err := resolveCommand([]string{filepath.Join(dir, "nope"), dir}, "ok"); err != nil || filepath.Base(path) != "ok" {
+		t.Errorf("second dir: %s, %v", path, err)
+	}
+}
+
+func TestInvalidTasks(t *testing.T) {
+	useScripts(t, 0, map[string]string{"ok": "echo ok\n"})
+	for name, options := range map[string]map[string]interface{}{
+		"unknown action": {"action": "shell", "options": map[string]interface{}{"command": "ok"}},
+		"no command":     {"action": "run", "options": map[string]interface{}{}},
+		"unknown format": {"action": "run", "options": map[string]interface{}{"command": "ok", "format": "xml"}},
+		"bad options":    {"action": "run", "options": map[string]interface{}{"command": "ok", "args": "-v"}},
+	} {
+		if _, err := (&execPlugin{}).OnCollect(options); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	for in, want := range map[string]string{"Queue Depth": "queue_depth", " /var used ": "_var_used", "rtt_ms": "rtt_ms"} {
+		if got := metricName(in); got != want {
+			t.Errorf("metricName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package execcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsedOutput is the status and metrics read from a command's output.
+type parsedOutput struct {
+	status  string // "up", "warning", "down" or "unknown"
+	message string
+	metrics []parsedMetric
+}
+
+// parsedMetric is one metric reported by a command.
+type parsedMetric struct {
+	name, label, metricType string
+	value                   interface{}
+	num                     *float64
+	extra                   map[string]interface{}
+}
+
+// parseOutput reads res.stdout in the given format:
+//
+//   - "nagios": the plugin convention. Exit code 0 is up, 1 warning, 2 down
+//     (critical), anything else unknown. The first line is the message; the
+//     performance data after "|", on any line, becomes gauges:
+//     label=value[UOM];[warn];[crit];[min];[max].
+//   - "json": {"status": "warning", "message": "...", "metrics": [{"name":
+//     "queue", "value": 12, "label": "Queue", "type": "gauge", "unit": "msgs"}]}.
+//     status and every metric field but name and value are optional.
+//   - "lines": one key=value per line; numeric values are gauges and the rest
+//     text. Blank lines and lines starting with # are skipped.
+//
+// Outside nagios, an omitted status is up on exit code 0 and down otherwise.
+func parseOutput(format string, res runResult) (parsedOutput, error) {
+	switch format {
+	case "nagios":
+		return parseNagios(res), nil
+	case "json":
+		return parseJSON(res)
+	case "lines":
+		return parseLines(res), nil
+	}
+	return parsedOutput{}, fmt.Errorf("unknown format %q", format)
+}
+
+func exitStatus(code int) string {
+	if code == 0 {
+		return "up"
+	}
+	return "down"
+}
+
+func parseNagios(res runResult) parsedOutput {
+	out := parsedOutput{status: "unknown"}
+	switch res.exitCode {
+	case 0:
+		out.status = "up"
+	case 1:
+		out.status = "warning"
+	case 2:
+		out.status = "down"
+	}
+	for i, line := range strings.Split(strings.TrimRight(res.stdout, "\n"), "\n") {
+		text, perf, _ := strings.Cut(line, "|")
+		if i == 0 {
+			out.message = strings.TrimSpace(text)
+		}
+		out.metrics = append(out.metrics, parsePerfdata(perf)...)
+	}
+	return out
+}
+
+// parsePerfdata reads space-separated label=value[UOM];warn;crit;min;max
+// items. Labels may be single-quoted to contain spaces; items that do not
+// parse are skipped.
+func parsePerfdata(perf string) []parsedMetric {
+	var metrics []parsedMetric
+	for _, item := range splitPerfdata(perf) {
+		label, rest, ok := strings.Cut(item, "=")
+		if !ok {
+			continue
+		}
+		label = strings.Trim(label, "'")
+		fields := strings.Split(rest, ";")
+		num, uom := splitUOM(fields[0])
+		v, err := strconv.ParseFloat(num, 64)
+		if label == "" || err != nil {
+			continue
+		}
+		m := parsedMetric{
+			name: metricName(label), label: label, metricType: "gauge",
+			value: num, num: &v, extra: map[string]interface{}{},
+		}
+		if uom != "" {
+			m.extra["unit"] = uom
+		}
+		for i, key := range []string{"warn", "crit", "min", "max"} {
+			if i+1 < len(fields) && fields[i+1] != "" {
+				m.extra[key] = fields[i+1]
+			}
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// splitPerfdata splits on spaces outside single quotes.
+func splitPerfdata(s string) []string {
+	var items []string
+	var b strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			b.WriteRune(r)
+		case r == ' ' && !quoted:
+			if b.Len() > 0 {
+				items = append(items, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() > 0 {
+		items = append(items, b.String())
+	}
+	return items
+}
+
+// splitUOM separates "12.5ms" into "12.5" and "ms".
+func splitUOM(v string) (string, string) {
+	i := len(v)
+	for i > 0 && strings.IndexByte("0123456789.", v[i-1]) < 0 {
+		i--
+	}
+	return v[:i], v[i:]
+}
+
+func parseJSON(res runResult) (parsedOutput, error) {
+	var doc struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Metrics []struct {
+			Name  string      `json:"name"`
+			Value interface{} `json:"value"`
+			Label string      `json:"label"`
+			Type  string      `json:"type"`
+			Unit  string      `json:"unit"`
+		} `json:"metrics"`
+	}
+	if err := json.Unmarshal([]byte(res.stdout), &doc); err != nil {
+		if res.exitCode != 0 {
+			return parsedOutput{}, fmt.Errorf("exit code %d", res.exitCode)
+		}
+		return parsedOutput{}, fmt.Errorf("output is not JSON: %v", err)
+	}
+	out := parsedOutput{status: doc.Status, message: doc.Message}
+	switch out.status {
+	case "":
+		out.status = exitStatus(res.exitCode)
+	case "up", "warning", "down", "unknown":
+	default:
+		return parsedOutput{}, fmt.Errorf("invalid status %q", doc.Status)
+	}
+	for _, m := range doc.Metrics {
+		if m.Name == "" {
+			return parsedOutput{}, errors.New("metric without a name")
+		}
+		pm := parsedMetric{name: metricName(m.Name), label: m.Label, metricType: m.Type, value: m.Value}
+		if pm.label == "" {
+			pm.label = m.Name
+		}
+		if v, ok := m.Value.(float64); ok {
+			pm.num = &v
+			pm.value = strconv.FormatFloat(v, 'f', -1, 64)
+			if pm.metricType == "" {
+				pm.metricType = "gauge"
+			}
+		}
+		if pm.metricType == "" {
+			pm.metricType = "text"
+		}
+		if m.Unit != "" {
+			pm.extra = map[string]interface{}{"unit": m.Unit}
+		}
+		out.metrics = append(out.metrics, pm)
+	}
+	return out, nil
+}
+
+func parseLines(res runResult) parsedOutput {
+	out := parsedOutput{status: exitStatus(res.exitCode)}
+	for _, line := range strings.Split(res.stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" {
+			continue
+		}
+		pm := parsedMetric{name: metricName(k), label: k, metricType: "text", value: v}
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			pm.metricType, pm.num = "gauge", &f
+		}
+		out.metrics = append(out.metrics, pm)
+	}
+	return out
+}
+
+// metricName lowercases a reported name and replaces anything outside
+// [a-z0-9_] with "_".
+func metricName(s string) string {
+	b := []byte(strings.ToLower(strings.TrimSpace(s)))
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,288 @@
+package flow
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+
+	"github.com/EdgeCast/vflow/ipfix"
+	netflow9 "github.com/EdgeCast/vflow/netflow/v9"
+	"github.com/EdgeCast/vflow/packet"
+	"github.com/EdgeCast/vflow/sflow"
+)
+
+// defaultTopN is how many top talkers are written per exporter and interval
+// when daemon.flow.top_n is unset.
+const defaultTopN = 10
+
+// Information elements read from IPFIX and NetFlow v9 records; both number
+// them the same.
+const (
+	ieOctets  = 1
+	iePackets = 2
+	ieSrcIPv4 = 8
+	ieDstIPv4 = 12
+	ieSrcIPv6 = 27
+	ieDstIPv6 = 28
+)
+
+// talkerKey is a source and destination pair seen by an exporter.
+type talkerKey struct {
+	src, dst string
+}
+
+// talkerCount is what a pair sent during an interval.
+type talkerCount struct {
+	bytes, packets uint64
+}
+
+// Talker is a source and destination pair among an interval's top talkers.
+// SrcName and DstName are set by enrichment, empty when unknown.
+type Talker struct {
+	Exporter string
+	Src      string
+	Dst      string
+	SrcName  string
+	DstName  string
+	Bytes    uint64
+	Packets  uint64
+}
+
+// aggregator sums bytes and packets per exporter and pair between takes.
+type aggregator struct {
+	mu    sync.Mutex
+	flows map[string]map[talkerKey]*talkerCount // by exporter
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{flows: make(map[string]map[talkerKey]*talkerCount)}
+}
+
+func (a *aggregator) add(exporter string, s flowSample) {
+	if s.src == "" || s.dst == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pairs := a.flows[exporter]
+	if pairs == nil {
+		pairs = make(map[talkerKey]*talkerCount)
+		a.flows[exporter] = pairs
+	}
+	k := talkerKey{src: s.src, dst: s.dst}
+	c := pairs[k]
+	if c == nil {
+		c = &talkerCount{}
+		pairs[k] = c
+	}
+	c.bytes += s.bytes
+	c.packets += s.packets
+}
+
+// take returns the counts gathered since the last take and starts over.
+func (a *aggregator) take() map[string]map[talkerKey]*talkerCount {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	flows := a.flows
+	a.flows = make(map[string]map[talkerKey]*talkerCount)
+	return flows
+}
+
+// topTalkers returns the n pairs of each exporter that sent the most bytes,
+// ordered by exporter then rank.
+func topTalkers(flows map[string]map[talkerKey]*talkerCount, n int) []Talker {
+	exporters := make([]string, 0, len(flows))
+	for e := range flows {
+		exporters = append(exporters, e)
+	}
+	sort.Strings(exporters)
+
+	var talkers []Talker
+	for _, e := range exporters {
+		var ranked []Talker
+		for k, c := range flows[e] {
+			ranked = append(ranked, Talker{Exporter: e, Src: k.src, Dst: k.dst, Bytes: c.bytes, Packets: c.packets})
+		}
+		sort.Slice(ranked, func(i, j int) bool {
+			a, b := ranked[i], ranked[j]
+			if a.Bytes != b.Bytes {
+				return a.Bytes > b.Bytes
+			}
+			if a.Packets != b.Packets {
+				return a.Packets > b.Packets
+			}
+			if a.Src != b.Src {
+				return a.Src < b.Src
+			}
+			return a.Dst < b.Dst
+		})
+		if len(ranked) > n {
+			ranked = ranked[:n]
+		}
+		talkers = append(talkers, ranked...)
+	}
+	return talkers
+}
+
+// enrich names the endpoints of talkers through r, in rank order so the
+// lookup budget goes to the biggest talkers first.
+func enrich(talkers []Talker, r *Resolver) {
+	if r == nil {
+		return
+	}
+	order := make([]int, len(talkers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return talkers[order[i]].Bytes > talkers[order[j]].Bytes })
+	for _, i := range order {
+		talkers[i].SrcName = r.Name(talkers[i].Src)
+		talkers[i].DstName = r.Name(talkers[i].Dst)
+	}
+}
+
+// talkerRecords returns the top-talker metrics of an interval: one per pair,
+// on the exporter's host, valued in bytes. Names are only added to Extra.
+func talkerRecords(talkers []Talker, interval time.Duration, at time.Time) []store.MetricRecord {
+	records := make([]store.MetricRecord, 0, len(talkers))
+	rank := 0
+	for i, t := range talkers {
+		if i == 0 || talkers[i-1].Exporter != t.Exporter {
+			rank = 0
+		}
+		rank++
+		bytes := float64(t.Bytes)
+		extra := map[string]interface{}{
+			"src":              t.Src,
+			"dst":              t.Dst,
+			"packets":          t.Packets,
+			"rank":             rank,
+			"interval_seconds": interval.Seconds(),
+		}
+		if t.SrcName != "" {
+			extra["src_name"] = t.SrcName
+		}
+		if t.DstName != "" {
+			extra["dst_name"] = t.DstName
+		}
+		records = append(records, store.MetricRecord{
+			HostKey:     t.Exporter,
+			HostName:    t.Exporter,
+			HostAddress: t.Exporter,
+			Plugin:      "flow",
+			Name:        "top_talker",
+			Category:    "flow",
+			MetricType:  "gauge",
+			Value:       strconv.FormatUint(t.Bytes, 10),
+			ValueNum:    &bytes,
+			Unit:        plugin.UnitBytes,
+			Instance:    t.Src + " > " + t.Dst,
+			Extra:       extra,
+			CollectedAt: at,
+		})
+	}
+	return records
+}
+
+// flowSample is the endpoints and counters of one flow record.
+type flowSample struct {
+	src, dst       string
+	bytes, packets uint64
+}
+
+// set fills in the field of s an information element holds.
+func (s *flowSample) set(id uint16, value interface{}) {
+	switch id {
+	case ieOctets:
+		s.bytes, _ = toUint64(value)
+	case iePackets:
+		s.packets, _ = toUint64(value)
+	case ieSrcIPv4, ieSrcIPv6:
+		s.src = fmt.Sprint(value)
+	case ieDstIPv4, ieDstIPv6:
+		s.dst = fmt.Sprint(value)
+	}
+}
+
+func toUint64(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case int:
+		return uint64(v), v >= 0
+	}
+	return 0, false
+}
+
+// ipfixSamples returns the flows of an IPFIX message. Enterprise-specific
+// elements are ignored.
+func ipfixSamples(msg *ipfix.Message) []flowSample {
+	samples := make([]flowSample, 0, len(msg.DataSets))
+	for _, set := range msg.DataSets {
+		var s flowSample
+		for _, f := range set {
+			if f.EnterpriseNo == 0 {
+				s.set(f.ID, f.Value)
+			}
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// netflowSamples returns the flows of a NetFlow v9 message.
+func netflowSamples(msg *netflow9.Message) []flowSample {
+	samples := make([]flowSample, 0, len(msg.DataSets))
+	for _, set := range msg.DataSets {
+		var s flowSample
+		for _, f := range set {
+			s.set(f.ID, f.Value)
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// sflowSamples returns the flows of the packet samples in an sFlow datagram,
+// scaled up by their sampling rate.
+func sflowSamples(d *sflow.SFDatagram) []flowSample {
+	var samples []flowSample
+	for _, sample := range d.Samples {
+		fs, ok := sample.(*sflow.FlowSample)
+		if !ok {
+			continue
+		}
+		p, ok := fs.Records["RawHeader"].(*packet.Packet)
+		if !ok || p == nil {
+			continue
+		}
+		rate := uint64(fs.SamplingRate)
+		if rate == 0 {
+			rate = 1
+		}
+		var s flowSample
+		switch l3 := p.L3.(type) {
+		case packet.IPv4Header:
+			s = flowSample{src: l3.Src, dst: l3.Dst, bytes: uint64(l3.TotalLen)}
+		case packet.IPv6Header:
+			s = flowSample{src: l3.Src, dst: l3.Dst, bytes: uint64(l3.PayloadLen) + packet.IPv6HLen}
+		default:
+			continue
+		}
+		s.bytes *= rate
+		s.packets = rate
+		samples = append(samples, s)
+	}
+	return samples
+}
@@ -0,0 +1,192 @@
+package flow
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	plugin "observer/base"
+)
+
+const (
+	defaultDNSCacheSize = 4096
+	defaultDNSBudget    = 50
+)
+
+// lookupFunc returns the names of an address, like net.Resolver.LookupAddr.
+type lookupFunc func(ctx context.Context, addr string) ([]string, error)
+
+// Resolver names flow endpoints for the top-talker metrics. A static mapping
+// from the config is used first; other addresses go to reverse DNS through
+// an LRU cache that also remembers addresses without a name (negative
+// caching). At most budget lookups are made per interval: an address over the
+// budget stays unnamed until a later interval, so a flood of new addresses
+// cannot hold up the aggregation.
+type Resolver struct {
+	mu          sync.Mutex
+	static      map[string]string
+	lookup      lookupFunc
+	now         func() time.Time
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	timeout     time.Duration
+	budget      int
+
+	entries *list.List               // of *cacheEntry, most recently used first
+	byAddr  map[string]*list.Element // into entries
+	used    int                      // lookups made this interval
+	stats   ResolverStats
+}
+
+// cacheEntry is a cached lookup; name is empty when the address has none.
+type cacheEntry struct {
+	addr    string
+	name    string
+	expires time.Time
+}
+
+// ResolverStats counts what a Resolver did during one interval.
+type ResolverStats struct {
+	Static     int // names from the static mapping
+	Hits       int // answered from the cache, named or not
+	Lookups    int // DNS queries made
+	OverBudget int // addresses left unnamed because the budget was used up
+}
+
+// NewResolver returns a resolver for cfg using the system resolver.
+func NewResolver(cfg plugin.FlowDNSConfig) (*Resolver, error) {
+	ttl, negativeTTL, timeout, err := cfg.Durations()
+	if err != nil {
+		return nil, err
+	}
+	static := make(map[string]string)
+	if cfg.HostsFile != "" {
+		if static, err = readHostsFile(cfg.HostsFile); err != nil {
+			return nil, err
+		}
+	}
+	for addr, name := range cfg.Hosts {
+		static[normalizeAddr(addr)] = name
+	}
+	return newResolver(net.DefaultResolver.LookupAddr, static, cfg.CacheSize, cfg.Budget, ttl, negativeTTL, timeout), nil
+}
+
+func newResolver(lookup lookupFunc, static map[string]string, size, budget int, ttl, negativeTTL, timeout time.Duration) *Resolver {
+	if size <= 0 {
+		size = defaultDNSCacheSize
+	}
+	if budget <= 0 {
+		budget = defaultDNSBudget
+	}
+	return &Resolver{
+		static:      static,
+		lookup:      lookup,
+		now:         time.Now,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		timeout:     timeout,
+		budget:      budget,
+		entries:     list.New(),
+		byAddr:      make(map[string]*list.Element),
+	}
+}
+
+// Name returns the name of addr, or "" when it has none or was not looked up.
+func (r *Resolver) Name(addr string) string {
+	addr = normalizeAddr(addr)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if name, ok := r.static[addr]; ok {
+		r.stats.Static++
+		return name
+	}
+	now := r.now()
+	if el, ok := r.byAddr[addr]; ok {
+		e := el.Value.(*cacheEntry)
+		if now.Before(e.expires) {
+			r.entries.MoveToFront(el)
+			r.stats.Hits++
+			return e.name
+		}
+		r.entries.Remove(el)
+		delete(r.byAddr, addr)
+	}
+	if r.used >= r.budget {
+		r.stats.OverBudget++
+		return ""
+	}
+	r.used++
+	r.stats.Lookups++
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	names, err := r.lookup(ctx, addr)
+	cancel()
+	e := &cacheEntry{addr: addr, expires: now.Add(r.negativeTTL)}
+	if err == nil && len(names) > 0 {
+		e.name = strings.TrimSuffix(names[0], ".")
+		e.expires = now.Add(r.ttl)
+	}
+	r.byAddr[addr] = r.entries.PushFront(e)
+	for r.entries.Len() > r.size {
+		oldest := r.entries.Back()
+		r.entries.Remove(oldest)
+		delete(r.byAddr, oldest.Value.(*cacheEntry).addr)
+	}
+	return e.name
+}
+
+// NextInterval refills the lookup budget and returns the stats of the
+// interval that ended.
+func (r *Resolver) NextInterval() ResolverStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats
+	r.used, r.stats = 0, ResolverStats{}
+	return stats
+}
+
+// readHostsFile reads an /etc/hosts style file: an address, then one or more
+// names, of which the first is used. Comments start with '#'.
+func readHostsFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("flow dns hosts_file: %w", err)
+	}
+	defer f.Close()
+	hosts := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			continue
+		}
+		addr := normalizeAddr(fields[0])
+		if _, seen := hosts[addr]; !seen {
+			hosts[addr] = fields[1]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("flow dns hosts_file: %w", err)
+	}
+	return hosts, nil
+}
+
+// normalizeAddr returns addr in its canonical form, so "::ffff:10.0.0.1" and
+// "10.0.0.1" or differently written IPv6 addresses share a cache entry.
+func normalizeAddr(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
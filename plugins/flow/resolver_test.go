@@ -0,0 +1,264 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+)
+
+// fakeDNS answers reverse lookups from names and counts them by address.
+type fakeDNS struct {
+	names   map[string]string
+	lookups map[string]int
+}
+
+func newFakeDNS(names map[string]string) *fakeDNS {
+	return &fakeDNS{names: names, lookups: make(map[string]int)}
+}
+
+func (f *fakeDNS) lookup(ctx context.Context, addr string) ([]string, error) {
+	f.lookups[addr]++
+	if name, ok := f.names[addr]; ok {
+		return []string{name + ".", "alias.example."}, nil
+	}
+	return nil, errors.New("no PTR record")
+}
+
+// fakeClock is a settable time for cache expiry.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func testResolver(dns *fakeDNS, static map[string]string, size, budget int) (*Resolver, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	r := newResolver(dns.lookup, static, size, budget, time.Hour, 10*time.Minute, time.Second)
+	r.now = clock.now
+	return r, clock
+}
+
+func TestResolverCache(t *testing.T) {
+	dns := newFakeDNS(map[string]string{"192.0.2.1": "gw.example", "2001:db8::1": "v6.example"})
+	r, clock := testResolver(dns, nil, 3, 100)
+
+	for i := 0; i < 3; i++ {
+		if name := r.Name("192.0.2.1"); name != "gw.example" {
+			t.Fatalf("name = %q", name)
+		}
+	}
+	// Addresses are cached in their canonical form.
+	if name := r.Name("::ffff:192.0.2.1"); name != "gw.example" {
+		t.Errorf("mapped address = %q", name)
+	}
+	if name := r.Name("2001:DB8:0::1"); name != "v6.example" {
+		t.Errorf("long IPv6 = %q", name)
+	}
+	// An address without a name is remembered too.
+	for i := 0; i < 3; i++ {
+		if name := r.Name("192.0.2.99"); name != "" {
+			t.Fatalf("unnamed address = %q", name)
+		}
+	}
+	if want := map[string]int{"192.0.2.1": 1, "2001:db8::1": 1, "192.0.2.99": 1}; !reflect.DeepEqual(dns.lookups, want) {
+		t.Errorf("lookups = %v, want %v", dns.lookups, want)
+	}
+	if s := r.NextInterval(); s != (ResolverStats{Hits: 5, Lookups: 3}) {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// The negative entry expires first, the name an hour after it was found.
+	clock.t = clock.t.Add(10 * time.Minute)
+	r.Name("192.0.2.99")
+	r.Name("192.0.2.1")
+	if dns.lookups["192.0.2.99"] != 2 || dns.lookups["192.0.2.1"] != 1 {
+		t.Errorf("after 10m: %v", dns.lookups)
+	}
+	clock.t = clock.t.Add(50 * time.Minute)
+	r.Name("192.0.2.1")
+	if dns.lookups["192.0.2.1"] != 2 {
+		t.Errorf("after 1h: %v", dns.lookups)
+	}
+
+	// The least recently used address leaves a full cache.
+	r.Name("2001:db8::1") // expired too, looked up again: 99, 1, ::1 in the cache
+	r.Name("192.0.2.99")  // used, so 192.0.2.1 is the oldest
+	r.Name("192.0.2.50")  // evicts 192.0.2.1
+	if r.entries.Len() != 3 || len(r.byAddr) != 3 {
+		t.Errorf("cache holds %d entries, %d indexed", r.entries.Len(), len(r.byAddr))
+	}
+	before := dns.lookups["192.0.2.99"]
+	r.Name("192.0.2.99")
+	r.Name("192.0.2.1")
+	if dns.lookups["192.0.2.99"] != before || dns.lookups["192.0.2.1"] != 3 {
+		t.Errorf("after eviction: %v", dns.lookups)
+	}
+}
+
+func TestResolverBudget(t *testing.T) {
+	dns := newFakeDNS(map[string]string{"192.0.2.1": "a.example", "192.0.2.2": "b.example"})
+	r, _ := testResolver(dns, map[string]string{"192.0.2.100": "static.example"}, 100, 2)
+
+	var names []string
+	for _, addr := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.1", "192.0.2.100"} {
+		names = append(names, r.Name(addr))
+	}
+	// Cache hits and the static mapping are not lookups; 3 and 4 wait.
+	if want := []string{"a.example", "b.example", "", "", "a.example", "static.example"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+	if len(dns.lookups) != 2 {
+		t.Errorf("lookups = %v", dns.lookups)
+	}
+	if s := r.NextInterval(); s != (ResolverStats{Static: 1, Hits: 1, Lookups: 2, OverBudget: 2}) {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// Left unnamed over budget is not a negative entry: the next interval
+	// looks them up.
+	r.Name("192.0.2.3")
+	r.Name("192.0.2.4")
+	r.Name("192.0.2.5")
+	if dns.lookups["192.0.2.3"] != 1 || dns.lookups["192.0.2.4"] != 1 || dns.lookups["192.0.2.5"] != 0 {
+		t.Errorf("second interval: %v", dns.lookups)
+	}
+	if s := r.NextInterval(); s != (ResolverStats{Lookups: 2, OverBudget: 1}) {
+		t.Errorf("second interval stats = %+v", s)
+	}
+}
+
+func TestResolverTimeout(t *testing.T) {
+	slow := func(ctx context.Context, addr string) ([]string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	r := newResolver(slow, nil, 10, 10, time.Hour, time.Minute, 10*time.Millisecond)
+	start := time.Now()
+	if name := r.Name("192.0.2.1"); name != "" {
+		t.Errorf("name = %q", name)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("lookup took %v", elapsed)
+	}
+	// Cached as unnamed.
+	r.Name("192.0.2.1")
+	if r.NextInterval().Hits != 1 {
+		t.Error("timed-out lookup not cached")
+	}
+}
+
+func TestResolverPrecedence(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	content := strings.Join([]string{
+		"# flow endpoints",
+		"192.0.2.1   router.lan router",
+		"192.0.2.2   nas.lan        # the file server",
+		"192.0.2.2   second.lan",
+		"::ffff:192.0.2.3 mapped.lan",
+		"not-an-ip   junk.lan",
+		"192.0.2.4",
+	}, "\n")
+	if err := os.WriteFile(hostsFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResolver(plugin.FlowDNSConfig{
+		Enabled:   true,
+		HostsFile: hostsFile,
+		Hosts:     map[string]string{"192.0.2.2": "storage", "2001:DB8::7": "printer"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns := newFakeDNS(map[string]string{
+		"192.0.2.1": "dns-router.example", "192.0.2.2": "dns-nas.example",
+		"192.0.2.4": "dns-four.example", "2001:db8::7": "dns-printer.example",
+	})
+	r.lookup = dns.lookup
+
+	for addr, want := range map[string]string{
+		"192.0.2.1":   "router.lan",       // hosts_file, first name
+		"192.0.2.2":   "storage",          // hosts over hosts_file
+		"192.0.2.3":   "mapped.lan",       // canonical form of the file's address
+		"2001:db8::7": "printer",          // canonical form of the config's address
+		"192.0.2.4":   "dns-four.example", // a line without a name is skipped
+	} {
+		if got := r.Name(addr); got != want {
+			t.Errorf("%s = %q, want %q", addr, got, want)
+		}
+	}
+	if want := map[string]int{"192.0.2.4": 1}; !reflect.DeepEqual(dns.lookups, want) {
+		t.Errorf("lookups = %v, want %v", dns.lookups, want)
+	}
+
+	if _, err := NewResolver(plugin.FlowDNSConfig{HostsFile: filepath.Join(t.TempDir(), "missing")}); err == nil ||
+		!strings.Contains(err.Error(), "flow dns hosts_file") {
+		t.Errorf("missing hosts file: %v", err)
+	}
+	if _, err := NewResolver(plugin.FlowDNSConfig{TTL: "soon"}); err == nil {
+		t.Error("bad ttl accepted")
+	}
+}
+
+func TestEnrichTopTalkers(t *testing.T) {
+	agg := newAggregator()
+	for _, s := range []flowSample{
+		{src: "192.0.2.1", dst: "198.51.100.1", bytes: 100, packets: 1},
+		{src: "192.0.2.2", dst: "198.51.100.2", bytes: 5000, packets: 5},
+		{src: "192.0.2.3", dst: "198.51.100.3", bytes: 900, packets: 3},
+		{src: "192.0.2.1", dst: "198.51.100.1", bytes: 100, packets: 1},
+		{src: "", dst: "198.51.100.9", bytes: 1 << 20},
+	} {
+		agg.add("edge", s)
+	}
+	agg.add("core", flowSample{src: "192.0.2.9", dst: "198.51.100.9", bytes: 300, packets: 2})
+
+	talkers := topTalkers(agg.take(), 2)
+	dns := newFakeDNS(map[string]string{
+		"192.0.2.2": "big.example", "198.51.100.2": "big-peer.example",
+		"192.0.2.3": "mid.example", "198.51.100.3": "mid-peer.example",
+		"192.0.2.9": "core-src.example",
+	})
+	// The budget covers the edge exporter's biggest pair and the next source.
+	r, _ := testResolver(dns, nil, 100, 3)
+	enrich(talkers, r)
+
+	var got []string
+	for _, tk := range talkers {
+		got = append(got, tk.Exporter+" "+tk.Src+"="+tk.SrcName+" "+tk.Dst+"="+tk.DstName)
+	}
+	want := []string{
+		"core 192.0.2.9= 198.51.100.9=",
+		"edge 192.0.2.2=big.example 198.51.100.2=big-peer.example",
+		"edge 192.0.2.3=mid.example 198.51.100.3=",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("talkers:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Names go to Extra only; the series stays keyed by address.
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := talkerRecords(talkers, time.Minute, at)
+	big := records[1]
+	if big.Instance != "192.0.2.2 > 198.51.100.2" || big.Value != "5000" || big.Unit != plugin.UnitBytes ||
+		big.Extra["src_name"] != "big.example" || big.Extra["dst_name"] != "big-peer.example" || big.Extra["rank"] != 1 {
+		t.Errorf("edge top talker = %+v", big)
+	}
+	if _, ok := records[2].Extra["dst_name"]; ok || records[2].Extra["rank"] != 2 {
+		t.Errorf("unnamed destination = %+v", records[2].Extra)
+	}
+	if _, ok := records[0].Extra["src_name"]; ok || records[0].Extra["rank"] != 1 {
+		t.Errorf("core talker = %+v", records[0].Extra)
+	}
+
+	// Without a resolver nothing is named.
+	talkers = topTalkers(map[string]map[talkerKey]*talkerCount{"edge": {{src: "192.0.2.2", dst: "198.51.100.2"}: {bytes: 1}}}, 10)
+	enrich(talkers, nil)
+	if talkers[0].SrcName != "" || talkers[0].DstName != "" {
+		t.Errorf("named without a resolver: %+v", talkers[0])
+	}
+}
@@ -2,13 +2,17 @@ package flow
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"sync"
 
 	"time"
 
+	plugin "observer/base"
 	"observer/store"
 
 	"github.com/EdgeCast/vflow/ipfix"
@@ -29,6 +33,18 @@ type IPFlowCollector struct {
 	netflowCache netflow9.MemCache
 
 	db store.Store
+
+	// Metrics, when set, counts received packets and decode failures.
+	Metrics *plugin.Metrics
+
+	// Every Interval the flows received are summed per exporter and the TopN
+	// source and destination pairs by bytes written as top_talker metrics,
+	// their addresses named by Resolver when it is set.
+	Interval time.Duration
+	TopN     int
+	Resolver *Resolver
+
+	agg *aggregator
 }
 
 // NewCollector creates a new flow listener configuration
@@ -43,152 +59,254 @@ func NewCollector(st store.Store) *IPFlowCollector {
 		netflowCache: netflow9.GetCache("netflow_templates.cache"),
 
 		db: st,
+
+		Interval: plugin.DefaultFlowInterval,
+		TopN:     defaultTopN,
+		agg:      newAggregator(),
+	}
+}
+
+// Configure applies the daemon.flow settings: the aggregation interval, the
+// number of top talkers and, when enabled, DNS names for their addresses.
+func (c *IPFlowCollector) Configure(cfg plugin.DaemonFlowConfig) error {
+	interval, err := cfg.AggregateInterval()
+	if err != nil {
+		return fmt.Errorf("daemon.flow: %w", err)
+	}
+	c.Interval = interval
+	if cfg.TopN > 0 {
+		c.TopN = cfg.TopN
 	}
+	if cfg.DNS.Enabled {
+		r, err := NewResolver(cfg.DNS)
+		if err != nil {
+			return fmt.Errorf("daemon.flow.dns: %w", err)
+		}
+		c.Resolver = r
+	}
+	return nil
 }
 
 // Start launches the UDP listeners simultaneously
 func (c *IPFlowCollector) Start() {
-	var wg sync.WaitGroup
+	if err := c.Serve(context.Background()); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
 
-	wg.Add(3)
-	go c.listenIPFIX(&wg)
-	go c.listenNetFlow(&wg)
-	go c.listenSFlow(&wg)
+// Serve binds all three listeners and receives flows until ctx is cancelled,
+// then closes the sockets and returns. A port that cannot be bound is an error.
+func (c *IPFlowCollector) Serve(ctx context.Context) error {
+	ports := []struct {
+		label string
+		port  int
+		read  func(*net.UDPConn, *sync.WaitGroup)
+	}{
+		{"IPFIX", c.IPFIXPort, c.listenIPFIX},
+		{"NetFlow v9", c.NetFlowPort, c.listenNetFlow},
+		{"sFlow", c.SFlowPort, c.listenSFlow},
+	}
+
+	conns := make([]*net.UDPConn, 0, len(ports))
+	closeAll := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	for _, lp := range ports {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: lp.port})
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("%s listen error: %w", lp.label, err)
+		}
+		log.Printf("Listening for %s on UDP :%d", lp.label, lp.port)
+		conns = append(conns, conn)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(ports))
+	for i, lp := range ports {
+		go lp.read(conns[i], &wg)
+	}
+	aggDone := make(chan struct{})
+	go func() {
+		defer close(aggDone)
+		c.aggregate(ctx)
+	}()
 
 	log.Println("Nord IPFlow Collector running. Waiting for telemetry...")
+	<-ctx.Done()
+	closeAll()
 	wg.Wait()
+	<-aggDone
+	return nil
 }
 
-func (c *IPFlowCollector) listenIPFIX(wg *sync.WaitGroup) {
-	defer wg.Done()
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: c.IPFIXPort})
-	if err != nil {
-		log.Fatalf("IPFIX Listen Error: %v", err)
+// aggregate writes the top talkers every Interval until ctx is cancelled.
+// Names are resolved here, not in the listeners, so slow DNS never holds up
+// the reception of flows.
+func (c *IPFlowCollector) aggregate(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.flushTalkers(ctx, now)
+		}
+	}
+}
+
+// flushTalkers writes the top talkers of the interval ending at now.
+func (c *IPFlowCollector) flushTalkers(ctx context.Context, now time.Time) {
+	talkers := topTalkers(c.agg.take(), c.TopN)
+	enrich(talkers, c.Resolver)
+	if c.Resolver != nil {
+		if s := c.Resolver.NextInterval(); s.OverBudget > 0 {
+			log.Printf("[flow] DNS budget used up: %d lookups, %d addresses left unnamed until a later interval", s.Lookups, s.OverBudget)
+		}
+	}
+	if len(talkers) == 0 {
+		return
 	}
-	defer conn.Close()
+	records := talkerRecords(talkers, c.Interval, now)
+	if c.db == nil {
+		for _, r := range records {
+			log.Printf("[flow] (No DB) %s top talker %s: %s bytes", r.HostKey, r.Instance, r.Value)
+		}
+		return
+	}
+	if err := c.db.WriteBatch(ctx, records); err != nil {
+		log.Printf("[flow] could not write top talkers: %v", err)
+	}
+}
+
+func (c *IPFlowCollector) listenIPFIX(conn *net.UDPConn, wg *sync.WaitGroup) {
+	defer wg.Done()
 
-	log.Printf("Listening for IPFIX on UDP :%d", c.IPFIXPort)
 	buf := make([]byte, 65535)
 	jsonBuf := new(bytes.Buffer)
 
 	for {
 		n, raddr, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			continue
 		}
+		c.Metrics.Add(plugin.SelfFlowPackets, 1)
 
 		// Fast decode using vflow
 		decoder := ipfix.NewDecoder(raddr.IP, buf[:n])
 		msg, err := decoder.Decode(c.ipfixCache)
 		if err != nil || msg == nil {
+			c.Metrics.Add(plugin.SelfFlowDecodeFails, 1)
 			continue
 		}
 
 		if len(msg.DataSets) > 0 {
+			c.addSamples(raddr.IP.String(), ipfixSamples(msg))
 			jsonBuf.Reset()
 			b, _ := msg.JSONMarshal(jsonBuf)
 
-			if c.db != nil {
-				c.db.WriteFlows([]store.FlowRecord{{
-					HostKey:     raddr.IP.String(),
-					HostName:    raddr.IP.String(),
-					HostAddress: raddr.IP.String(),
-					FlowType:    "ipfix",
-					Payload:     b,
-					CollectedAt: time.Now(),
-				}})
-			} else {
-				log.Printf("[IPFIX] (No DB) %s", string(b))
-			}
+			c.writeFlow(raddr.IP, "ipfix", "IPFIX", b)
 		}
 	}
 }
 
-func (c *IPFlowCollector) listenNetFlow(wg *sync.WaitGroup) {
+func (c *IPFlowCollector) listenNetFlow(conn *net.UDPConn, wg *sync.WaitGroup) {
 	defer wg.Done()
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: c.NetFlowPort})
-	if err != nil {
-		log.Fatalf("NetFlow Listen Error: %v", err)
-	}
-	defer conn.Close()
 
-	log.Printf("Listening for NetFlow v9 on UDP :%d", c.NetFlowPort)
 	buf := make([]byte, 65535)
 	jsonBuf := new(bytes.Buffer)
 
 	for {
 		n, raddr, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			continue
 		}
+		c.Metrics.Add(plugin.SelfFlowPackets, 1)
 
 		decoder := netflow9.NewDecoder(raddr.IP, buf[:n])
 		msg, err := decoder.Decode(c.netflowCache)
 		if err != nil || msg == nil {
+			c.Metrics.Add(plugin.SelfFlowDecodeFails, 1)
 			continue
 		}
 
 		if len(msg.DataSets) > 0 {
+			c.addSamples(raddr.IP.String(), netflowSamples(msg))
 			jsonBuf.Reset()
 			b, _ := msg.JSONMarshal(jsonBuf)
 
-			if c.db != nil {
-				c.db.WriteFlows([]store.FlowRecord{{
-					HostKey:     raddr.IP.String(),
-					HostName:    raddr.IP.String(),
-					HostAddress: raddr.IP.String(),
-					FlowType:    "netflow9",
-					Payload:     b,
-					CollectedAt: time.Now(),
-				}})
-			} else {
-				log.Printf("[NetFlow] (No DB) %s", string(b))
-			}
+			c.writeFlow(raddr.IP, "netflow9", "NetFlow", b)
 		}
 	}
 }
 
-func (c *IPFlowCollector) listenSFlow(wg *sync.WaitGroup) {
+func (c *IPFlowCollector) listenSFlow(conn *net.UDPConn, wg *sync.WaitGroup) {
 	defer wg.Done()
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: c.SFlowPort})
-	if err != nil {
-		log.Fatalf("sFlow Listen Error: %v", err)
-	}
-	defer conn.Close()
 
-	log.Printf("Listening for sFlow on UDP :%d", c.SFlowPort)
 	buf := make([]byte, 65535)
 
 	for {
 		n, raddr, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			continue
 		}
+		c.Metrics.Add(plugin.SelfFlowPackets, 1)
 
 		reader := bytes.NewReader(buf[:n])
 		decoder := sflow.NewSFDecoder(reader, nil)
 		datagram, err := decoder.SFDecode()
 		if err != nil || datagram == nil {
+			c.Metrics.Add(plugin.SelfFlowDecodeFails, 1)
 			continue
 		}
 
 		// sFlow records
 		if len(datagram.Samples) > 0 {
+			c.addSamples(raddr.IP.String(), sflowSamples(datagram))
 			b, _ := json.Marshal(datagram)
 
-			if c.db != nil {
-				c.db.WriteFlows([]store.FlowRecord{{
-					// We capture the sender IP (switch/router), not the internal sampled hosts here
-					HostKey:     raddr.IP.String(),
-					HostName:    raddr.IP.String(),
-					HostAddress: raddr.IP.String(),
-					FlowType:    "sflow",
-					Payload:     b,
-					CollectedAt: time.Now(),
-				}})
-			} else {
-				log.Printf("[sFlow] (No DB) %s", string(b))
-			}
+			c.writeFlow(raddr.IP, "sflow", "sFlow", b)
 		}
 	}
 }
+
+// addSamples counts the flows of one packet from exporter towards its top talkers.
+func (c *IPFlowCollector) addSamples(exporter string, samples []flowSample) {
+	for _, s := range samples {
+		c.agg.add(exporter, s)
+	}
+}
+
+// writeFlow stores the decoded payload of one packet from exporter, or logs
+// it when no database is configured. Flows are stored under the exporter
+// (the switch or router), not the sampled hosts. A failed write is logged and
+// the listener goes on.
+func (c *IPFlowCollector) writeFlow(exporter net.IP, flowType, label string, payload []byte) {
+	if c.db == nil {
+		log.Printf("[%s] (No DB) %s", label, string(payload))
+		return
+	}
+	addr := exporter.String()
+	if err := c.db.WriteFlows(context.Background(), []store.FlowRecord{{
+		HostKey:     addr,
+		HostName:    addr,
+		HostAddress: addr,
+		FlowType:    flowType,
+		Payload:     payload,
+		CollectedAt: time.Now(),
+	}}); err != nil {
+		log.Printf("[%s] could not store flow from %s: %v", label, addr, err)
+	}
+}
@@ -0,0 +1,394 @@
+// Package httpcheck monitors web endpoints: collect tasks "http.check" send one
+// request per task and assert on its status, body and timing.
+package httpcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+)
+
+const (
+	defaultURL          = "http://{address}/"
+	defaultTimeout      = 10 * time.Second
+	defaultMaxRedirects = 10
+	maxBodyBytes        = 1 << 20 // read for assertions; larger bodies are still counted
+)
+
+// httpPlugin checks web endpoints.
+type httpPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&httpPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *httpPlugin) Name() string {
+	return "Http"
+}
+
+// checkOptions are the per-task options of an http.check task:
+//
+//	{"metric": "http.check", "credentials": "api", "options": {
+//	    "url": "https://{address}/health", "method": "GET",
+//	    "headers": {"Accept": "application/json"}, "body": "",
+//	    "expect_status": [200], "body_regex": "ok",
+//	    "json": {"status": "ok", "checks.0.healthy": true},
+//	    "follow_redirects": true, "max_redirects": 5,
+//	    "auth": "basic|bearer", "timeout_s": 5, "insecure": false,
+//	    "name": "api-health"}}
+type checkOptions struct {
+	Name            string                 `json:"name"` // metric instance; defaults to the URL
+	URL             string                 `json:"url"`  // {address} and {name} are replaced from the host
+	Method          string                 `json:"method"`
+	Headers         map[string]string      `json:"headers"`
+	Body            string                 `json:"body"`
+	ExpectStatus    []int                  `json:"expect_status"` // default: any status below 400
+	BodyRegex       string                 `json:"body_regex"`
+	JSON            map[string]interface{} `json:"json"` // dotted path -> expected value
+	FollowRedirects *bool                  `json:"follow_redirects"`
+	MaxRedirects    int                    `json:"max_redirects"`
+	Auth            string                 `json:"auth"`
+	TimeoutS        float64                `json:"timeout_s"`
+	Insecure        bool                   `json:"insecure"`
+}
+
+// timings is the request phase breakdown recorded through httptrace.
+type timings struct {
+	dns, connect, tls, ttfb, total time.Duration
+}
+
+// OnCollect runs one check. Failed requests and assertions are reported in the
+// status metric, not as a task error, so the endpoint's history is continuous.
+func (p *httpPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	if action, _ := options["action"].(string); action != "check" {
+		return nil, fmt.Errorf("undefined http action: %s", action)
+	}
+	host, _ := options["host"].(map[string]interface{})
+	opts, err := parseOptions(options["options"], host)
+	if err != nil {
+		return nil, err
+	}
+	creds, _ := options["credentials"].(map[string]interface{})
+
+	req, err := buildRequest(opts, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	target, _ := options["target"].(plugin.Target)
+	client := newClient(opts, target.Address)
+	code, size, body, t, reqErr := do(client, req)
+
+	var failures []string
+	if reqErr != nil {
+		failures = append(failures, reqErr.Error())
+	} else {
+		failures = assert(opts, code, body)
+	}
+	return map[string]interface{}{"metrics": checkMetrics(opts, code, size, t, failures)}, nil
+}
+
+// parseOptions decodes the task options and fills in defaults from the host.
+func parseOptions(raw interface{}, host map[string]interface{}) (checkOptions, error) {
+	var opts checkOptions
+	if raw != nil {
+		b, err := json.Marshal(raw)
+		if err != nil {
+			return opts, fmt.Errorf("http: invalid options: %w", err)
+		}
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return opts, fmt.Errorf("http: invalid options: %w", err)
+		}
+	}
+	address, _ := host["address"].(string)
+	name, _ := host["name"].(string)
+	if opts.URL == "" {
+		opts.URL = defaultURL
+	}
+	if address == "" && strings.Contains(opts.URL, "{address}") {
+		return opts, fmt.Errorf("http: host has no address for %q", opts.URL)
+	}
+	opts.URL = strings.NewReplacer("{address}", address, "{name}", name).Replace(opts.URL)
+	if opts.Method == "" {
+		opts.Method = http.MethodGet
+	}
+	if opts.Name == "" {
+		opts.Name = opts.URL
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = defaultMaxRedirects
+	}
+	if opts.BodyRegex != "" {
+		if _, err := regexp.Compile(opts.BodyRegex); err != nil {
+			return opts, fmt.Errorf("http: invalid body_regex: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// buildRequest creates the request with headers, body and auth.
+func buildRequest(opts checkOptions, creds map[string]interface{}) (*http.Request, error) {
+	var body io.Reader
+	if opts.Body != "" {
+		body = strings.NewReader(opts.Body)
+	}
+	req, err := http.NewRequest(strings.ToUpper(opts.Method), opts.URL, body)
+	if err != nil {
+		return nil, fmt.Errorf("http: %w", err)
+	}
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "nord/"+plugin.Build().Version)
+	}
+
+	user, _ := creds["user"].(string)
+	pass, _ := creds["pass"].(string)
+	token, _ := creds["token"].(string)
+	switch strings.ToLower(opts.Auth) {
+	case "":
+	case "basic":
+		if creds == nil {
+			return nil, errors.New("http: basic auth needs a credential")
+		}
+		req.SetBasicAuth(user, pass)
+	case "bearer":
+		if token == "" {
+			token = pass
+		}
+		if token == "" {
+			return nil, errors.New("http: bearer auth needs a credential with a token")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	default:
+		return nil, fmt.Errorf("http: unknown auth %q (basic or bearer)", opts.Auth)
+	}
+	return req, nil
+}
+
+// newClient returns a client applying the timeout, TLS and redirect policy.
+// With an address, connections to the URL's host go to it rather than to
+// what the host resolves to; the host is still sent as Host and for TLS, and
+// redirects elsewhere are resolved as usual.
+func newClient(opts checkOptions, address string) *http.Client {
+	timeout := defaultTimeout
+	if opts.TimeoutS > 0 {
+		timeout = time.Duration(opts.TimeoutS * float64(time.Second))
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true // every check measures a fresh connection
+	if opts.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if u, err := url.Parse(opts.URL); err == nil && address != "" {
+		pinned := u.Hostname()
+		dialer := &net.Dialer{Timeout: timeout}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err == nil && host == pinned {
+				addr = net.JoinHostPort(address, port)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: redirectPolicy(opts),
+	}
+}
+
+// redirectPolicy stops at the first response when redirects are not followed,
+// and fails after MaxRedirects hops.
+func redirectPolicy(opts checkOptions) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if opts.FollowRedirects != nil && !*opts.FollowRedirects {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > opts.MaxRedirects {
+			return fmt.Errorf("stopped after %d redirects", opts.MaxRedirects)
+		}
+		return nil
+	}
+}
+
+// do sends req, tracing the connection phases. It returns the final status
+// code, the full body size and up to maxBodyBytes of the body.
+func do(client *http.Client, req *http.Request) (int, int64, []byte, timings, error) {
+	var t timings
+	var dnsStart, connStart, tlsStart time.Time
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.dns += time.Since(dnsStart) },
+		ConnectStart:      func(string, string) { connStart = time.Now() },
+		ConnectDone:       func(string, string, error) { t.connect += time.Since(connStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.tls += time.Since(tlsStart) },
+		GotFirstResponseByte: func() {
+			t.ttfb = time.Since(start)
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(context.Background(), trace))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.total = time.Since(start)
+		return 0, 0, nil, t, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	size := int64(len(body))
+	if err == nil {
+		rest, _ := io.Copy(io.Discard, resp.Body)
+		size += rest
+	}
+	t.total = time.Since(start)
+	if err != nil {
+		return resp.StatusCode, size, body, t, fmt.Errorf("reading body: %w", err)
+	}
+	return resp.StatusCode, size, body, t, nil
+}
+
+// assert checks the response against the configured expectations and returns
+// one message per failed assertion.
+func assert(opts checkOptions, code int, body []byte) []string {
+	var failures []string
+	if len(opts.ExpectStatus) > 0 {
+		ok := false
+		for _, want := range opts.ExpectStatus {
+			ok = ok || code == want
+		}
+		if !ok {
+			failures = append(failures, fmt.Sprintf("status %d, expected one of %v", code, opts.ExpectStatus))
+		}
+	} else if code >= 400 {
+		failures = append(failures, fmt.Sprintf("status %d", code))
+	}
+
+	if opts.BodyRegex != "" && !regexp.MustCompile(opts.BodyRegex).Match(body) {
+		failures = append(failures, fmt.Sprintf("body does not match %q", opts.BodyRegex))
+	}
+
+	if len(opts.JSON) > 0 {
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return append(failures, fmt.Sprintf("body is not JSON: %v", err))
+		}
+		for _, path := range sortedPaths(opts.JSON) {
+			want := opts.JSON[path]
+			got, ok := lookup(doc, path)
+			switch {
+			case !ok:
+				failures = append(failures, fmt.Sprintf("%s: missing", path))
+			case fmt.Sprint(got) != fmt.Sprint(want):
+				failures = append(failures, fmt.Sprintf("%s: got %v, expected %v", path, got, want))
+			}
+		}
+	}
+	return failures
+}
+
+// lookup follows a dotted path ("items.0.name") through decoded JSON;
+// numeric segments index arrays.
+func lookup(doc interface{}, path string) (interface{}, bool) {
+	cur := doc
+	for _, seg := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ok := v[seg]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// sortedPaths returns the JSON assertion paths in order, so failures are stable.
+func sortedPaths(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkMetrics builds the metrics of one check, keyed by the check name so
+// several checks on a host do not collide.
+func checkMetrics(opts checkOptions, code int, size int64, t timings, failures []string) map[string]interface{} {
+	status := metric("status", "HTTP", opts.Name, "status", "up")
+	status["url"] = opts.URL
+	status["status_code"] = code
+	if len(failures) > 0 {
+		status["value"] = "down"
+		status["failures"] = failures
+	}
+
+	metrics := map[string]interface{}{
+		"http_status_" + opts.Name: status,
+		"http_total_" + opts.Name:  msMetric("total_time_ms", opts.Name, t.total),
+	}
+	if code == 0 {
+		return metrics // no response: the phase timings and size are meaningless
+	}
+	metrics["http_code_"+opts.Name] = metric("status_code", "Status code", opts.Name, "gauge", code)
+	metrics["http_size_"+opts.Name] = metric("response_bytes", "Size", opts.Name, "gauge", size)
+	metrics["http_ttfb_"+opts.Name] = msMetric("ttfb_ms", opts.Name, t.ttfb)
+	metrics["http_connect_"+opts.Name] = msMetric("connect_ms", opts.Name, t.connect)
+	if t.dns > 0 { // no lookup for an IP address
+		metrics["http_dns_"+opts.Name] = msMetric("dns_ms", opts.Name, t.dns)
+	}
+	if t.tls > 0 {
+		metrics["http_tls_"+opts.Name] = msMetric("tls_ms", opts.Name, t.tls)
+	}
+	return metrics
+}
+
+func msMetric(name, instance string, d time.Duration) map[string]interface{} {
+	ms := float64(d.Microseconds()) / 1000
+	m := metric(name, strings.TrimSuffix(name, "_ms")+" (ms)", instance, "gauge", fmt.Sprintf("%.1f", ms))
+	m["value_num"] = ms
+	return m
+}
+
+func metric(name, label, instance, metricType string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    label,
+		"value":    value,
+		"type":     metricType,
+		"category": "http",
+		"instance": instance,
+	}
+}
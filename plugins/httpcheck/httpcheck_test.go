@@ -0,0 +1,326 @@
+package httpcheck
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+)
+
+// check runs one http.check task with opts against a host at address.
+func check(t *testing.T, address string, opts, creds map[string]interface{}) (map[string]interface{}, error) {
+	t.Helper()
+	options := map[string]interface{}{
+		"action":  "check",
+		"host":    map[string]interface{}{"address": address, "name": "web1"},
+		"options": opts,
+		"target":  plugin.Target{Family: plugin.FamilyIPv4, Address: address},
+	}
+	if creds != nil {
+		options["credentials"] = creds
+	}
+	result, err := (&httpPlugin{}).OnCollect(options)
+	if err != nil {
+		return nil, err
+	}
+	return result["metrics"].(map[string]interface{}), nil
+}
+
+// status returns the status metric of the check named name.
+func status(t *testing.T, metrics map[string]interface{}, name string) map[string]interface{} {
+	t.Helper()
+	m, ok := metrics["http_status_"+name].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no status metric for %s in %v", name, metrics)
+	}
+	return m
+}
+
+// failures returns the assertion failures of a status metric.
+func failures(m map[string]interface{}) string {
+	f, _ := m["failures"].([]string)
+	return strings.Join(f, "; ")
+}
+
+func TestCheckSuccess(t *testing.T) {
+	var got *http.Request
+	var gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"status": "ok", "checks": [{"healthy": true}], "version": 3}`)
+	}))
+	defer srv.Close()
+
+	metrics, err := check(t, "127.0.0.1", map[string]interface{}{
+		"name":       "api",
+		"url":        srv.URL + "/health",
+		"method":     "post",
+		"headers":    map[string]interface{}{"X-Probe": "nord"},
+		"body":       `{"ping": 1}`,
+		"body_regex": `"status":\s*"ok"`,
+		"json":       map[string]interface{}{"status": "ok", "checks.0.healthy": true, "version": 3},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Method != http.MethodPost || got.URL.Path != "/health" || got.Header.Get("X-Probe") != "nord" || gotBody != `{"ping": 1}` {
+		t.Errorf("request: %s %s %v body %q", got.Method, got.URL, got.Header, gotBody)
+	}
+	if !strings.HasPrefix(got.Header.Get("User-Agent"), "nord/") {
+		t.Errorf("User-Agent = %q", got.Header.Get("User-Agent"))
+	}
+
+	st := status(t, metrics, "api")
+	if st["value"] != "up" || st["status_code"] != 200 || st["failures"] != nil || st["instance"] != "api" || st["category"] != "http" {
+		t.Errorf("status = %v", st)
+	}
+	for _, key := range []string{"http_total_api", "http_ttfb_api", "http_connect_api", "http_code_api", "http_size_api"} {
+		if _, ok := metrics[key]; !ok {
+			t.Errorf("no %s metric", key)
+		}
+	}
+	if _, ok := metrics["http_dns_api"]; ok {
+		t.Error("a DNS time was reported for an IP address")
+	}
+	if size := metrics["http_size_api"].(map[string]interface{}); size["value"] != int64(61) {
+		t.Errorf("size = %v", size["value"])
+	}
+	ttfb := metrics["http_ttfb_api"].(map[string]interface{})
+	if n, ok := ttfb["value_num"].(float64); !ok || n <= 0 || ttfb["name"] != "ttfb_ms" {
+		t.Errorf("ttfb = %v", ttfb)
+	}
+}
+
+func TestCheckAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); ok && user == "ops" && pass == "s3cret" {
+			return
+		}
+		if r.Header.Get("Authorization") == "Bearer tok123" {
+			return
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name  string
+		auth  string
+		creds map[string]interface{}
+		want  string
+	}{
+		{"basic", "basic", map[string]interface{}{"user": "ops", "pass": "s3cret"}, "up"},
+		{"basic-wrong", "basic", map[string]interface{}{"user": "ops", "pass": "guess"}, "down"},
+		{"bearer", "bearer", map[string]interface{}{"token": "tok123"}, "up"},
+		{"bearer-pass", "bearer", map[string]interface{}{"pass": "tok123"}, "up"},
+		{"none", "", nil, "down"},
+	} {
+		metrics, err := check(t, "127.0.0.1", map[string]interface{}{"name": tc.name, "url": srv.URL, "auth": tc.auth}, tc.creds)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if st := status(t, metrics, tc.name); st["value"] != tc.want {
+			t.Errorf("%s: status %v (%s)", tc.name, st["value"], failures(st))
+		}
+	}
+
+	for auth, msg := range map[string]string{
+		"basic":  "basic auth needs a credential",
+		"bearer": "bearer auth needs a credential with a token",
+		"digest": `unknown auth "digest"`,
+	} {
+		if _, err := check(t, "127.0.0.1", map[string]interface{}{"url": srv.URL, "auth": auth}, nil); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s without credentials: err = %v", auth, err)
+		}
+	}
+}
+
+func TestCheckRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, "/new", http.StatusFound) })
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "moved here") })
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, "/loop", http.StatusFound) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	metrics, err := check(t, "127.0.0.1", map[string]interface{}{"name": "follow", "url": srv.URL + "/old", "body_regex": "moved here"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := status(t, metrics, "follow"); st["value"] != "up" || st["status_code"] != 200 {
+		t.Errorf("followed: %v", st)
+	}
+
+	metrics, _ = check(t, "127.0.0.1", map[string]interface{}{"name": "stay", "url": srv.URL + "/old", "follow_redirects": false, "expect_status": []int{302}}, nil)
+	if st := status(t, metrics, "stay"); st["value"] != "up" || st["status_code"] != 302 {
+		t.Errorf("not followed: %v (%s)", st, failures(st))
+	}
+
+	metrics, _ = check(t, "127.0.0.1", map[string]interface{}{"name": "loop", "url": srv.URL + "/loop", "max_redirects": 2}, nil)
+	st := status(t, metrics, "loop")
+	if st["value"] != "down" || !strings.Contains(failures(st), "stopped after 2 redirects") {
+		t.Errorf("loop: %v", st)
+	}
+	if _, ok := metrics["http_code_loop"]; ok {
+		t.Error("a status code was reported without a response")
+	}
+}
+
+func TestCheckAssertionFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/text":
+			io.WriteString(w, "maintenance")
+		case "/error":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		default:
+			io.WriteString(w, `{"status": "degraded", "items": []}`)
+		}
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name string
+		opts map[string]interface{}
+		want []string
+	}{
+		{"code", map[string]interface{}{"expect_status": []int{201, 204}}, []string{"status 200, expected one of [201 204]"}},
+		{"error", map[string]interface{}{"url": srv.URL + "/error"}, []string{"status 500"}},
+		{"regex", map[string]interface{}{"url": srv.URL + "/text", "body_regex": "^ok$"}, []string{`body does not match "^ok$"`}},
+		{"json", map[string]interface{}{"json": map[string]interface{}{"status": "ok", "items.0.id": 1}}, []string{
+			"items.0.id: missing", "status: got degraded, expected ok",
+		}},
+		{"notjson", map[string]interface{}{"url": srv.URL + "/text", "json": map[string]interface{}{"status": "ok"}}, []string{"body is not JSON"}},
+	} {
+		tc.opts["name"] = tc.name
+		if tc.opts["url"] == nil {
+			tc.opts["url"] = srv.URL + "/health"
+		}
+		metrics, err := check(t, "127.0.0.1", tc.opts, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		st := status(t, metrics, tc.name)
+		got := failures(st)
+		if st["value"] != "down" {
+			t.Errorf("%s: status %v", tc.name, st["value"])
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: failures %q lack %q", tc.name, got, want)
+			}
+		}
+		// A response arrived, so its code and size are still reported.
+		if _, ok := metrics["http_code_"+tc.name]; !ok {
+			t.Errorf("%s: no status code metric", tc.name)
+		}
+	}
+}
+
+func TestCheckUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	metrics, err := check(t, "127.0.0.1", map[string]interface{}{"name": "gone", "url": "http://" + addr + "/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := status(t, metrics, "gone")
+	if st["value"] != "down" || st["status_code"] != 0 || !strings.Contains(failures(st), "refused") {
+		t.Errorf("status = %v", st)
+	}
+	if len(metrics) != 2 {
+		t.Errorf("metrics without a response: %v", metrics)
+	}
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer slow.Close()
+	metrics, _ = check(t, "127.0.0.1", map[string]interface{}{"name": "slow", "url": slow.URL, "timeout_s": 0.1}, nil)
+	if st := status(t, metrics, "slow"); st["value"] != "down" || !strings.Contains(failures(st), "Timeout") {
+		t.Errorf("timeout: %v", st)
+	}
+}
+
+func TestCheckAddressTemplateAndPinning(t *testing.T) {
+	var host string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	// {address} comes from the host.
+	metrics, err := check(t, "127.0.0.1", map[string]interface{}{"name": "tmpl", "url": "http://{address}:" + port + "/{name}"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := status(t, metrics, "tmpl"); st["value"] != "up" || st["url"] != "http://127.0.0.1:"+port+"/web1" {
+		t.Errorf("template: %v", st)
+	}
+
+	// A virtual host name is dialled at the target address and still sent as Host.
+	metrics, _ = check(t, "127.0.0.1", map[string]interface{}{"name": "vhost", "url": "http://app.example.invalid:" + port + "/"}, nil)
+	if st := status(t, metrics, "vhost"); st["value"] != "up" || host != "app.example.invalid:"+port {
+		t.Errorf("vhost: %v, Host %q", st, host)
+	}
+
+	if _, err := check(t, "", map[string]interface{}{"url": "http://{address}/"}, nil); err == nil || !strings.Contains(err.Error(), "no address") {
+		t.Errorf("no address: err = %v", err)
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	metrics, err := check(t, "127.0.0.1", map[string]interface{}{"name": "tls", "url": srv.URL, "insecure": true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := status(t, metrics, "tls"); st["value"] != "up" {
+		t.Errorf("insecure: %v", st)
+	}
+	if _, ok := metrics["http_tls_tls"]; !ok {
+		t.Error("no TLS handshake time")
+	}
+
+	metrics, _ = check(t, "127.0.0.1", map[string]interface{}{"name": "verify", "url": srv.URL}, nil)
+	if st := status(t, metrics, "verify"); st["value"] != "down" || !strings.Contains(failures(st), "certificate") {
+		t.Errorf("self-signed: %v", st)
+	}
+}
+
+func TestCheckInvalidTasks(t *testing.T) {
+	if _, err := (&httpPlugin{}).OnCollect(map[string]interface{}{"action": "ping"}); err == nil || err.Error() != "undefined http action: ping" {
+		t.Errorf("unknown action: err = %v", err)
+	}
+	for _, tc := range []struct {
+		opts map[string]interface{}
+		msg  string
+	}{
+		{map[string]interface{}{"body_regex": "("}, "invalid body_regex"},
+		{map[string]interface{}{"expect_status": "200"}, "invalid options"},
+		{map[string]interface{}{"url": "http://127.0.0.1/\x7f"}, "invalid"},
+	} {
+		if _, err := check(t, "127.0.0.1", tc.opts, nil); err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%v: err = %v", tc.opts, err)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// pageSize bounds each list call; larger results are read in pages.
+const pageSize = 500
+
+// podPhases are reported for every namespace with pods, zero included, so
+// each series continues when its last pod goes away.
+var podPhases = []corev1.PodPhase{corev1.PodRunning, corev1.PodPending, corev1.PodSucceeded, corev1.PodFailed, corev1.PodUnknown}
+
+// pressureConditions are the node conditions that are bad when True.
+var pressureConditions = []corev1.NodeConditionType{
+	corev1.NodeMemoryPressure, corev1.NodeDiskPressure, corev1.NodePIDPressure, corev1.NodeNetworkUnavailable,
+}
+
+// collect reads the cluster through cs; it takes the interface so that it
+// runs the same against a fake clientset.
+func collect(ctx context.Context, cs clientset.Interface, opts healthOptions) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	apiStatus := func(resource string, err error) {
+		m := metric("k8s_api_status", "API "+resource, resource, "status", "up", "Cluster")
+		if err != nil {
+			m["value"] = "down"
+			m["reason"] = err.Error()
+		}
+		metrics["k8s_api_status_"+resource] = m
+	}
+
+	version, err := cs.Discovery().ServerVersion()
+	apiStatus("server", err)
+	if err == nil {
+		metrics["k8s_api_status_server"].(map[string]interface{})["version"] = version.GitVersion
+	}
+
+	nodes, err := listNodes(ctx, cs, opts.NodeSelector)
+	apiStatus("nodes", err)
+	if err == nil {
+		nodeMetrics(metrics, nodes)
+	}
+
+	namespaces := opts.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var pods []corev1.Pod
+	var pvcs []corev1.PersistentVolumeClaim
+	var podErr, pvcErr error
+	for _, ns := range namespaces {
+		if podErr == nil {
+			var list []corev1.Pod
+			list, podErr = listPods(ctx, cs, ns, opts.PodSelector)
+			pods = append(pods, list...)
+		}
+		if pvcErr == nil {
+			var list []corev1.PersistentVolumeClaim
+			list, pvcErr = listPVCs(ctx, cs, ns)
+			pvcs = append(pvcs, list...)
+		}
+	}
+	apiStatus("pods", podErr)
+	if podErr == nil {
+		podMetrics(metrics, pods)
+	}
+	apiStatus("pvcs", pvcErr)
+	if pvcErr == nil {
+		pvcMetrics(metrics, pvcs)
+		if opts.PVC && len(nodes) > 0 {
+			err := volumeMetrics(ctx, cs, metrics, nodes, pvcs)
+			apiStatus("volume_stats", err)
+			if err != nil {
+				// Usage is optional; without nodes/proxy access the rest still holds.
+				metrics["k8s_api_status_volume_stats"].(map[string]interface{})["value"] = "warning"
+			}
+		}
+	}
+	return metrics
+}
+
+func listNodes(ctx context.Context, cs clientset.Interface, selector string) ([]corev1.Node, error) {
+	var out []corev1.Node
+	opts := metav1.ListOptions{LabelSelector: selector, Limit: pageSize}
+	for {
+		list, err := cs.CoreV1().Nodes().List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, list.Items...)
+		if opts.Continue = list.Continue; opts.Continue == "" {
+			return out, nil
+		}
+	}
+}
+
+func listPods(ctx context.Context, cs clientset.Interface, ns, selector string) ([]corev1.Pod, error) {
+	var out []corev1.Pod
+	opts := metav1.ListOptions{LabelSelector: selector, Limit: pageSize}
+	for {
+		list, err := cs.CoreV1().Pods(ns).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, list.Items...)
+		if opts.Continue = list.Continue; opts.Continue == "" {
+			return out, nil
+		}
+	}
+}
+
+func listPVCs(ctx context.Context, cs clientset.Interface, ns string) ([]corev1.PersistentVolumeClaim, error) {
+	var out []corev1.PersistentVolumeClaim
+	opts := metav1.ListOptions{Limit: pageSize}
+	for {
+		list, err := cs.CoreV1().PersistentVolumeClaims(ns).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, list.Items...)
+		if opts.Continue = list.Continue; opts.Continue == "" {
+			return out, nil
+		}
+	}
+}
+
+// nodeMetrics reports node_ready and node_pressure per node, and the ready
+// and total node counts.
+func nodeMetrics(metrics map[string]interface{}, nodes []corev1.Node) {
+	ready := 0
+	for _, n := range nodes {
+		m := metric("node_ready", "Node Ready", n.Name, "status", "down", "Nodes")
+		m["kubelet_version"] = n.Status.NodeInfo.KubeletVersion
+		m["unschedulable"] = n.Spec.Unschedulable
+		if roles := nodeRoles(n); roles != "" {
+			m["roles"] = roles
+		}
+		m["reason"] = "no Ready condition"
+		var pressure []string
+		for _, c := range n.Status.Conditions {
+			if c.Type == corev1.NodeReady {
+				if c.Status == corev1.ConditionTrue {
+					m["value"] = "up"
+					delete(m, "reason")
+					ready++
+				} else {
+					m["reason"] = strings.TrimSpace(fmt.Sprintf("Ready=%s %s %s", c.Status, c.Reason, c.Message))
+				}
+				continue
+			}
+			for _, pc := range pressureConditions {
+				if c.Type == pc && c.Status == corev1.ConditionTrue {
+					pressure = append(pressure, string(c.Type))
+				}
+			}
+		}
+		metrics["k8s_node_ready_"+n.Name] = m
+
+		pm := metric("node_pressure", "Node Pressure", n.Name, "status", "up", "Nodes")
+		if len(pressure) > 0 {
+			pm["value"] = "warning"
+			pm["reason"] = strings.Join(pressure, ", ")
+		}
+		metrics["k8s_node_pressure_"+n.Name] = pm
+	}
+	metrics["k8s_nodes_total"] = metric("nodes_total", "Nodes", "", "gauge", len(nodes), "Nodes")
+	metrics["k8s_nodes_ready"] = metric("nodes_ready", "Nodes Ready", "", "gauge", ready, "Nodes")
+}
+
+func nodeRoles(n corev1.Node) string {
+	var roles []string
+	for label := range n.Labels {
+		if role, ok := strings.CutPrefix(label, "node-role.kubernetes.io/"); ok && role != "" {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return strings.Join(roles, ",")
+}
+
+// podMetrics reports per namespace the pods in each phase, their container
+// restarts and how many containers are in CrashLoopBackOff, and one
+// container_crashloop metric per such container (instance
+// namespace/pod/container).
+func podMetrics(metrics map[string]interface{}, pods []corev1.Pod) {
+	type nsCounts struct {
+		phases    map[corev1.PodPhase]int
+		restarts  int32
+		crashLoop int
+	}
+	byNS := make(map[string]*nsCounts)
+	for _, pod := range pods {
+		c := byNS[pod.Namespace]
+		if c == nil {
+			c = &nsCounts{phases: make(map[corev1.PodPhase]int)}
+			byNS[pod.Namespace] = c
+		}
+		c.phases[pod.Status.Phase]++
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			c.restarts += cs.RestartCount
+			if w := cs.State.Waiting; w == nil || w.Reason != "CrashLoopBackOff" {
+				continue
+			}
+			c.crashLoop++
+			instance := pod.Namespace + "/" + pod.Name + "/" + cs.Name
+			m := metric("container_crashloop", "CrashLoopBackOff", instance, "status", "down", "Pods")
+			m["restarts"] = cs.RestartCount
+			m["reason"] = strings.TrimSpace(cs.State.Waiting.Message)
+			if t := cs.LastTerminationState.Terminated; t != nil {
+				m["last_exit_code"] = t.ExitCode
+				m["last_reason"] = t.Reason
+			}
+			metrics["k8s_crashloop_"+instance] = m
+		}
+	}
+	for ns, c := range byNS {
+		for _, phase := range podPhases {
+			name := "pods_" + strings.ToLower(string(phase))
+			metrics["k8s_"+name+"_"+ns] = metric(name, "Pods "+string(phase), ns, "gauge", c.phases[phase], "Pods")
+		}
+		metrics["k8s_pod_restarts_"+ns] = metric("pod_restarts", "Container Restarts", ns, "counter", c.restarts, "Pods")
+		metrics["k8s_crashloop_containers_"+ns] = metric("crashloop_containers", "Containers in CrashLoopBackOff", ns, "gauge", c.crashLoop, "Pods")
+	}
+}
+
+// pvcMetrics reports each claim's phase and requested capacity (instance
+// namespace/name).
+func pvcMetrics(metrics map[string]interface{}, pvcs []corev1.PersistentVolumeClaim) {
+	for _, pvc := range pvcs {
+		instance := pvc.Namespace + "/" + pvc.Name
+		value := "up"
+		switch pvc.Status.Phase {
+		case corev1.ClaimPending:
+			value = "warning"
+		case corev1.ClaimLost:
+			value = "down"
+		}
+		m := metric("pvc_status", "PVC", instance, "status", value, "Storage")
+		m["phase"] = string(pvc.Status.Phase)
+		m["volume"] = pvc.Spec.VolumeName
+		if pvc.Spec.StorageClassName != nil {
+			m["storage_class"] = *pvc.Spec.StorageClassName
+		}
+		metrics["k8s_pvc_status_"+instance] = m
+		if q, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+			metrics["k8s_pvc_capacity_"+instance] = metric("pvc_capacity_bytes", "PVC Capacity (bytes)", instance, "gauge", q.Value(), "Storage")
+		}
+	}
+}
+
+// statsSummary is the part of the kubelet's /stats/summary the plugin reads.
+type statsSummary struct {
+	Pods []struct {
+		Volume []struct {
+			CapacityBytes *uint64 `json:"capacityBytes"`
+			UsedBytes     *uint64 `json:"usedBytes"`
+			PVCRef        *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef"`
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+// volumeMetrics reports pvc_used_bytes and pvc_used_percent for the listed
+// claims from each node's kubelet stats, read through the API server proxy.
+// It returns the first error; nodes that answered are still reported.
+func volumeMetrics(ctx context.Context, cs clientset.Interface, metrics map[string]interface{}, nodes []corev1.Node, pvcs []corev1.PersistentVolumeClaim) error {
+	rc, ok := cs.CoreV1().RESTClient().(*rest.RESTClient)
+	if !ok || rc == nil {
+		return fmt.Errorf("no REST client for node stats")
+	}
+	wanted := make(map[string]bool, len(pvcs))
+	for _, pvc := range pvcs {
+		wanted[pvc.Namespace+"/"+pvc.Name] = true
+	}
+	var firstErr error
+	for _, n := range nodes {
+		raw, err := rc.Get().AbsPath("/api/v1/nodes", n.Name, "proxy", "stats", "summary").DoRaw(ctx)
+		if err == nil {
+			var summary statsSummary
+			if err = json.Unmarshal(raw, &summary); err == nil {
+				for _, pod := range summary.Pods {
+					for _, v := range pod.Volume {
+						if v.PVCRef == nil || v.UsedBytes == nil {
+							continue
+						}
+						instance := v.PVCRef.Namespace + "/" + v.PVCRef.Name
+						if !wanted[instance] {
+							continue
+						}
+						metrics["k8s_pvc_used_"+instance] = metric("pvc_used_bytes", "PVC Used (bytes)", instance, "gauge", *v.UsedBytes, "Storage")
+						if v.CapacityBytes != nil && *v.CapacityBytes > 0 {
+							pct := float64(*v.UsedBytes) / float64(*v.CapacityBytes) * 100
+							metrics["k8s_pvc_used_percent_"+instance] = metric("pvc_used_percent", "PVC Used (%)", instance, "gauge", fmt.Sprintf("%.1f", pct), "Storage")
+						}
+					}
+				}
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("node %s: %w", n.Name, err)
+		}
+	}
+	return firstErr
+}
+
+func metric(name, label, instance, metricType string, value interface{}, category string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    label,
+		"value":    value,
+		"type":     metricType,
+		"category": category,
+		"instance": instance,
+	}
+}
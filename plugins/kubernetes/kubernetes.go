@@ -0,0 +1,119 @@
+// Package kubernetes reports the health of a Kubernetes cluster: collect
+// tasks "kubernetes.health" read node conditions, pod phases and restarts per
+// namespace, containers in CrashLoopBackOff and persistent volume claims.
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// kubernetesPlugin collects cluster health.
+type kubernetesPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&kubernetesPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *kubernetesPlugin) Name() string {
+	return "Kubernetes"
+}
+
+// healthOptions are the per-task options of a kubernetes.health task:
+//
+//	{"metric": "kubernetes.health", "credentials": "k8s", "options": {
+//	    "namespaces": ["default", "prod"], "pod_selector": "tier=backend",
+//	    "node_selector": "", "pvc": true, "timeout_s": 30}}
+//
+// The selectors are Kubernetes label selectors; they and namespaces (all by
+// default) bound how many pods and nodes are listed and reported. pvc reads
+// volume usage from each node's kubelet through the API server proxy.
+type healthOptions struct {
+	Namespaces   []string `json:"namespaces"`
+	PodSelector  string   `json:"pod_selector"`
+	NodeSelector string   `json:"node_selector"`
+	PVC          bool     `json:"pvc"`
+	TimeoutS     float64  `json:"timeout_s"`
+}
+
+// OnCollect reads the cluster. Each resource type is read on its own; one
+// the credential may not list, or that fails, is reported by its
+// k8s_api_status metric while the others are still collected.
+func (p *kubernetesPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	if action, _ := options["action"].(string); action != "health" {
+		return nil, fmt.Errorf("undefined kubernetes action: %s", action)
+	}
+	var opts healthOptions
+	if raw, ok := options["options"]; ok {
+		b, _ := json.Marshal(raw)
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return nil, fmt.Errorf("kubernetes: invalid options: %w", err)
+		}
+	}
+	timeout := defaultTimeout
+	if opts.TimeoutS > 0 {
+		timeout = time.Duration(opts.TimeoutS * float64(time.Second))
+	}
+	creds, _ := options["credentials"].(map[string]interface{})
+	cfg, err := restConfig(creds)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %w", err)
+	}
+	cfg.Timeout = timeout
+	cs, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return map[string]interface{}{"metrics": collect(ctx, cs, opts)}, nil
+}
+
+// restConfig builds the client configuration from a credential of type
+// "kubernetes": an API server URL (host) and bearer token, a kubeconfig file
+// and context, or, with neither, the pod's in-cluster service account.
+func restConfig(creds map[string]interface{}) (*rest.Config, error) {
+	cred := func(key string) string {
+		s, _ := creds[key].(string)
+		return s
+	}
+	insecure, _ := creds["insecure"].(bool)
+	switch {
+	case cred("host") != "" && cred("token") != "":
+		return &rest.Config{
+			Host:            cred("host"),
+			BearerToken:     cred("token"),
+			TLSClientConfig: rest.TLSClientConfig{Insecure: insecure, CAFile: cred("ca_file")},
+		}, nil
+	case cred("kubeconfig") != "":
+		cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: cred("kubeconfig")},
+			&clientcmd.ConfigOverrides{CurrentContext: cred("context")},
+		).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: %w", cred("kubeconfig"), err)
+		}
+		return cfg, nil
+	}
+	cfg, err := rest.InClusterConfig()
+	if errors.Is(err, rest.ErrNotInCluster) {
+		return nil, errors.New("the credential needs host and token or a kubeconfig when nord runs outside the cluster")
+	}
+	return cfg, err
+}
@@ -0,0 +1,347 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func node(name string, labels map[string]string, conditions ...corev1.NodeCondition) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status:     corev1.NodeStatus{Conditions: conditions, NodeInfo: corev1.NodeSystemInfo{KubeletVersion: "v1.30.2"}},
+	}
+}
+
+func condition(t corev1.NodeConditionType, s corev1.ConditionStatus, reason string) corev1.NodeCondition {
+	return corev1.NodeCondition{Type: t, Status: s, Reason: reason}
+}
+
+func pod(ns, name string, labels map[string]string, phase corev1.PodPhase, containers ...corev1.ContainerStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, Labels: labels},
+		Status:     corev1.PodStatus{Phase: phase, ContainerStatuses: containers},
+	}
+}
+
+func crashLooping(name string, restarts int32) corev1.ContainerStatus {
+	return corev1.ContainerStatus{
+		Name:         name,
+		RestartCount: restarts,
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+			Reason: "CrashLoopBackOff", Message: "back-off 5m0s restarting failed container",
+		}},
+		LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}},
+	}
+}
+
+func pvc(ns, name string, phase corev1.PersistentVolumeClaimPhase, capacity string) *corev1.PersistentVolumeClaim {
+	class := "fast-ssd"
+	c := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-" + name, StorageClassName: &class},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: phase},
+	}
+	if capacity != "" {
+		c.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)}
+	}
+	return c
+}
+
+// cluster is a small cluster: a control-plane node, a ready worker under
+// memory pressure and a worker that is not ready; pods in two namespaces,
+// one crash-looping; and three claims.
+func cluster() *fake.Clientset {
+	cs := fake.NewClientset(
+		node("cp1", map[string]string{"node-role.kubernetes.io/control-plane": "", "pool": "system"},
+			condition(corev1.NodeReady, corev1.ConditionTrue, "KubeletReady")),
+		node("worker1", map[string]string{"pool": "apps"},
+			condition(corev1.NodeReady, corev1.ConditionTrue, "KubeletReady"),
+			condition(corev1.NodeMemoryPressure, corev1.ConditionTrue, "KubeletHasInsufficientMemory"),
+			condition(corev1.NodeDiskPressure, corev1.ConditionFalse, "KubeletHasNoDiskPressure")),
+		node("worker2", map[string]string{"pool": "apps"},
+			condition(corev1.NodeReady, corev1.ConditionUnknown, "NodeStatusUnknown")),
+		pod("prod", "api-1", map[string]string{"tier": "backend"}, corev1.PodRunning,
+			corev1.ContainerStatus{Name: "api", RestartCount: 2}),
+		pod("prod", "api-2", map[string]string{"tier": "backend"}, corev1.PodRunning,
+			crashLooping("api", 14), corev1.ContainerStatus{Name: "sidecar", RestartCount: 1}),
+		pod("prod", "web-1", map[string]string{"tier": "frontend"}, corev1.PodPending),
+		pod("batch", "report-28", nil, corev1.PodSucceeded),
+		pod("batch", "report-29", nil, corev1.PodFailed),
+		pvc("prod", "db-data", corev1.ClaimBound, "20Gi"),
+		pvc("prod", "cache", corev1.ClaimPending, ""),
+		pvc("batch", "scratch", corev1.ClaimLost, "1Gi"),
+	)
+	cs.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.30.2"}
+	return cs
+}
+
+func value(t *testing.T, metrics map[string]interface{}, key string) interface{} {
+	t.Helper()
+	m, ok := metrics[key].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no metric %s", key)
+	}
+	return m["value"]
+}
+
+func TestCollect(t *testing.T) {
+	metrics := collect(context.Background(), cluster(), healthOptions{PVC: true})
+
+	for key, want := range map[string]interface{}{
+		"k8s_api_status_server":          "up",
+		"k8s_api_status_nodes":           "up",
+		"k8s_api_status_pods":            "up",
+		"k8s_api_status_pvcs":            "up",
+		"k8s_node_ready_cp1":             "up",
+		"k8s_node_ready_worker1":         "up",
+		"k8s_node_ready_worker2":         "down",
+		"k8s_node_pressure_cp1":          "up",
+		"k8s_node_pressure_worker1":      "warning",
+		"k8s_nodes_total":                3,
+		"k8s_nodes_ready":                2,
+		"k8s_pods_running_prod":          2,
+		"k8s_pods_pending_prod":          1,
+		"k8s_pods_failed_prod":           0,
+		"k8s_pods_succeeded_batch":       1,
+		"k8s_pods_failed_batch":          1,
+		"k8s_pods_unknown_batch":         0,
+		"k8s_pod_restarts_prod":          int32(17),
+		"k8s_crashloop_containers_prod":  1,
+		"k8s_crashloop_containers_batch": 0,
+		"k8s_crashloop_prod/api-2/api":   "down",
+		"k8s_pvc_status_prod/db-data":    "up",
+		"k8s_pvc_status_prod/cache":      "warning",
+		"k8s_pvc_status_batch/scratch":   "down",
+		"k8s_pvc_capacity_prod/db-data":  int64(20 << 30),
+		// The fake clientset has no REST client for the kubelet proxy.
+		"k8s_api_status_volume_stats": "warning",
+	} {
+		if got := value(t, metrics, key); got != want {
+			t.Errorf("%s = %v (%T), want %v (%T)", key, got, got, want, want)
+		}
+	}
+	if _, ok := metrics["k8s_pvc_capacity_prod/cache"]; ok {
+		t.Error("capacity reported for a pending claim")
+	}
+
+	server := metrics["k8s_api_status_server"].(map[string]interface{})
+	if server["version"] != "v1.30.2" {
+		t.Errorf("server = %v", server)
+	}
+	cp := metrics["k8s_node_ready_cp1"].(map[string]interface{})
+	if cp["roles"] != "control-plane" || cp["kubelet_version"] != "v1.30.2" || cp["reason"] != nil {
+		t.Errorf("cp1 = %v", cp)
+	}
+	if w2 := metrics["k8s_node_ready_worker2"].(map[string]interface{}); w2["reason"] != "Ready=Unknown NodeStatusUnknown" {
+		t.Errorf("worker2 = %v", w2)
+	}
+	if p := metrics["k8s_node_pressure_worker1"].(map[string]interface{}); p["reason"] != "MemoryPressure" {
+		t.Errorf("worker1 pressure = %v", p)
+	}
+	crash := metrics["k8s_crashloop_prod/api-2/api"].(map[string]interface{})
+	if crash["restarts"] != int32(14) || crash["last_exit_code"] != int32(137) || crash["last_reason"] != "OOMKilled" ||
+		crash["instance"] != "prod/api-2/api" || !strings.HasPrefix(crash["reason"].(string), "back-off") {
+		t.Errorf("crashloop = %v", crash)
+	}
+	if s := metrics["k8s_pvc_status_prod/db-data"].(map[string]interface{}); s["storage_class"] != "fast-ssd" || s["volume"] != "pv-db-data" {
+		t.Errorf("pvc = %v", s)
+	}
+}
+
+func TestSelectorsBoundCardinality(t *testing.T) {
+	cs := cluster()
+	metrics := collect(context.Background(), cs, healthOptions{
+		Namespaces: []string{"prod"}, PodSelector: "tier=backend", NodeSelector: "pool=apps",
+	})
+	if value(t, metrics, "k8s_nodes_total") != 2 || value(t, metrics, "k8s_pods_running_prod") != 2 || value(t, metrics, "k8s_pods_pending_prod") != 0 {
+		t.Errorf("selected: %v nodes, %v running, %v pending",
+			value(t, metrics, "k8s_nodes_total"), value(t, metrics, "k8s_pods_running_prod"), value(t, metrics, "k8s_pods_pending_prod"))
+	}
+	for key := range metrics {
+		if strings.Contains(key, "cp1") || strings.HasSuffix(key, "_batch") || strings.Contains(key, "batch/") {
+			t.Errorf("%s reported outside the selectors", key)
+		}
+	}
+	if _, ok := metrics["k8s_api_status_volume_stats"]; ok {
+		t.Error("volume stats read without pvc")
+	}
+
+	var selectors []string
+	for _, a := range cs.Actions() {
+		if l, ok := a.(k8stesting.ListActionImpl); ok {
+			selectors = append(selectors, fmt.Sprintf("%s %s %q limit=%d", l.GetResource().Resource, l.GetNamespace(), l.ListOptions.LabelSelector, l.ListOptions.Limit))
+		}
+	}
+	want := []string{
+		`nodes  "pool=apps" limit=500`,
+		`pods prod "tier=backend" limit=500`,
+		`persistentvolumeclaims prod "" limit=500`,
+	}
+	if strings.Join(selectors, "\n") != strings.Join(want, "\n") {
+		t.Errorf("list calls:\n%s", strings.Join(selectors, "\n"))
+	}
+}
+
+func TestAPIErrorsDegradeToPartialResults(t *testing.T) {
+	cs := cluster()
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "nodes"}, "", fmt.Errorf("RBAC: access denied"))
+	cs.PrependReactor("list", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, forbidden
+	})
+	cs.PrependReactor("list", "persistentvolumeclaims", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewServiceUnavailable("etcd timeout")
+	})
+	metrics := collect(context.Background(), cs, healthOptions{PVC: true})
+
+	nodes := metrics["k8s_api_status_nodes"].(map[string]interface{})
+	if nodes["value"] != "down" || !strings.Contains(nodes["reason"].(string), "forbidden") {
+		t.Errorf("nodes api = %v", nodes)
+	}
+	if value(t, metrics, "k8s_api_status_pvcs") != "down" || value(t, metrics, "k8s_api_status_pods") != "up" {
+		t.Error("pvcs and pods api status")
+	}
+	if value(t, metrics, "k8s_pods_running_prod") != 2 {
+		t.Error("pods not collected alongside the failures")
+	}
+	for key := range metrics {
+		if strings.HasPrefix(key, "k8s_node") || strings.HasPrefix(key, "k8s_pvc") {
+			t.Errorf("%s reported for a resource that failed", key)
+		}
+	}
+	if _, ok := metrics["k8s_api_status_volume_stats"]; ok {
+		t.Error("volume stats read without claims")
+	}
+}
+
+func TestPaging(t *testing.T) {
+	cs := fake.NewClientset()
+	pages := map[string]*corev1.PodList{
+		"":   {ListMeta: metav1.ListMeta{Continue: "p2"}, Items: []corev1.Pod{*pod("prod", "a", nil, corev1.PodRunning)}},
+		"p2": {ListMeta: metav1.ListMeta{Continue: "p3"}, Items: []corev1.Pod{*pod("prod", "b", nil, corev1.PodRunning)}},
+		"p3": {Items: []corev1.Pod{*pod("prod", "c", nil, corev1.PodFailed)}},
+	}
+	cs.PrependReactor("list", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		return true, pages[a.(k8stesting.ListActionImpl).ListOptions.Continue], nil
+	})
+	pods, err := listPods(context.Background(), cs, "prod", "")
+	if err != nil || len(pods) != 3 || pods[2].Name != "c" {
+		t.Errorf("listPods = %d pods, %v", len(pods), err)
+	}
+}
+
+// kubeletServer answers the API server's node proxy for stats/summary.
+func kubeletServer(t *testing.T, summaries map[string]string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/nodes/"), "/proxy/stats/summary")
+		body, ok := summaries[name]
+		if !ok || r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVolumeMetrics(t *testing.T) {
+	srv := kubeletServer(t, map[string]string{
+		"worker1": `{"pods": [
+			{"volume": [
+				{"name": "data", "capacityBytes": 21474836480, "usedBytes": 5368709120, "pvcRef": {"name": "db-data", "namespace": "prod"}},
+				{"name": "tmp", "usedBytes": 4096},
+				{"name": "other", "capacityBytes": 100, "usedBytes": 50, "pvcRef": {"name": "other", "namespace": "dev"}}]}]}`,
+	})
+	cs, err := clientset.NewForConfig(&rest.Config{Host: srv.URL, BearerToken: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := make(map[string]interface{})
+	nodes := []corev1.Node{*node("worker1", nil), *node("worker2", nil)}
+	err = volumeMetrics(context.Background(), cs, metrics, nodes, []corev1.PersistentVolumeClaim{*pvc("prod", "db-data", corev1.ClaimBound, "20Gi")})
+	if err == nil || !strings.HasPrefix(err.Error(), "node worker2: ") {
+		t.Errorf("err = %v", err)
+	}
+	if got := value(t, metrics, "k8s_pvc_used_prod/db-data"); got != uint64(5<<30) {
+		t.Errorf("used = %v", got)
+	}
+	if got := value(t, metrics, "k8s_pvc_used_percent_prod/db-data"); got != "25.0" {
+		t.Errorf("used percent = %v", got)
+	}
+	if len(metrics) != 2 {
+		t.Errorf("metrics = %v", metrics)
+	}
+}
+
+func TestRestConfig(t *testing.T) {
+	cfg, err := restConfig(map[string]interface{}{"host": "https://k8s.example.com:6443", "token": "t", "insecure": true})
+	if err != nil || cfg.Host != "https://k8s.example.com:6443" || cfg.BearerToken != "t" || !cfg.Insecure {
+		t.Errorf("host and token: %+v, %v", cfg, err)
+	}
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: lab
+  cluster: {server: "https://lab.example.com:6443"}
+- name: prod
+  cluster: {server: "https://prod.example.com:6443"}
+users:
+- name: admin
+  user: {token: abc}
+contexts:
+- name: lab
+  context: {cluster: lab, user: admin}
+- name: prod
+  context: {cluster: prod, user: admin}
+current-context: lab
+`), 0600)
+	for context, want := range map[string]string{"": "https://lab.example.com:6443", "prod": "https://prod.example.com:6443"} {
+		cfg, err := restConfig(map[string]interface{}{"kubeconfig": kubeconfig, "context": context})
+		if err != nil || cfg.Host != want || cfg.BearerToken != "abc" {
+			t.Errorf("context %q: %+v, %v", context, cfg, err)
+		}
+	}
+	if _, err := restConfig(map[string]interface{}{"kubeconfig": filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("missing kubeconfig accepted")
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+	if _, err := restConfig(nil); err == nil || !strings.Contains(err.Error(), "outside the cluster") {
+		t.Errorf("outside the cluster: %v", err)
+	}
+}
+
+func TestInvalidTasks(t *testing.T) {
+	p := &kubernetesPlugin{}
+	for name, options := range map[string]map[string]interface{}{
+		"unknown action": {"action": "events"},
+		"bad options":    {"action": "health", "options": map[string]interface{}{"namespaces": "prod"}},
+		"bad kubeconfig": {"action": "health", "credentials": map[string]interface{}{"kubeconfig": "/nonexistent/config"}},
+	} {
+		if _, err := p.OnCollect(options); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
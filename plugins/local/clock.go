@@ -0,0 +1,147 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+)
+
+const (
+	defaultNTPServer = "pool.ntp.org"
+	defaultClockWarn = 500.0 // milliseconds
+	ntpTimeout       = 2 * time.Second
+	ntpEpochOffset   = 2208988800 // seconds from 1900-01-01 to 1970-01-01
+)
+
+// toNTP converts a time to the 64-bit NTP timestamp format.
+func toNTP(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+// fromNTP converts a 64-bit NTP timestamp to a time.
+func fromNTP(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset
+	nanos := int64((ts & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(secs, nanos)
+}
+
+// sntpOffset performs a single SNTP exchange and returns the local clock's offset
+// from the server (positive when the local clock is behind).
+func sntpOffset(server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, ntpTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ntpTimeout))
+
+	req := make([]byte, 48)
+	req[0] = 0x1B // LI 0, version 3, mode 3 (client)
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTP(t1))
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	t4 := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 {
+		return 0, errors.New("short NTP response")
+	}
+	if resp[1] == 0 {
+		return 0, errors.New("NTP server sent kiss-of-death")
+	}
+
+	t2 := fromNTP(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTP(binary.BigEndian.Uint64(resp[40:]))
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
+
+// parseTimedatectl reports whether `timedatectl show` claims the clock is synchronized.
+func parseTimedatectl(out []byte) (synced bool, found bool) {
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if key, value, ok := strings.Cut(sc.Text(), "="); ok && key == "NTPSynchronized" {
+			return value == "yes", true
+		}
+	}
+	return false, false
+}
+
+// getClock returns clock_offset_ms with its status and, where timedatectl exists,
+// whether the local time daemon reports synchronization.
+func (p *localPlugin) getClock(cfg plugin.LocalClockConfig) map[string]interface{} {
+	if !cfg.Enabled {
+		return nil
+	}
+	metrics := make(map[string]interface{})
+
+	if !cfg.LocalOnly {
+		server := cfg.Server
+		if server == "" {
+			server = defaultNTPServer
+		}
+		warn := cfg.WarningMs
+		if warn <= 0 {
+			warn = defaultClockWarn
+		}
+
+		offset, err := sntpOffset(server)
+		if err != nil {
+			metrics["clock_offset_ms"] = p.errorMetric("Clock Offset", "clock", fmt.Errorf("query %s: %w", server, err))
+		} else {
+			ms := float64(offset) / float64(time.Millisecond)
+			metrics["clock_offset_ms"] = map[string]interface{}{
+				"name":      "clock_offset_ms",
+				"label":     "Clock Offset (ms)",
+				"value":     fmt.Sprintf("%.1f", ms),
+				"value_num": ms,
+				"type":      "gauge",
+				"category":  "clock",
+				"unit":      plugin.UnitMillis,
+				"server":    server,
+			}
+			status := "up"
+			if math.Abs(ms) > warn {
+				status = "warning"
+			}
+			metrics["clock_status"] = map[string]interface{}{"name": "clock", "label": "Clock", "value": status, "type": "status", "category": "clock"}
+		}
+	}
+
+	runner := p.runner
+	if runner == nil {
+		if _, err := exec.LookPath("timedatectl"); err != nil {
+			return metrics
+		}
+		runner = execRunner{}
+	}
+	if out, err := runner.Run("timedatectl", "show"); err == nil {
+		if synced, ok := parseTimedatectl(out); ok {
+			status := "down"
+			if synced {
+				status = "up"
+			}
+			metrics["clock_synchronized"] = map[string]interface{}{"name": "clock_synchronized", "label": "Clock Synchronized", "value": status, "type": "status", "category": "clock"}
+		}
+	}
+	return metrics
+}
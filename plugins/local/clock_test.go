@@ -0,0 +1,162 @@
+package local
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+)
+
+// fakeNTP answers SNTP requests on a loopback UDP port with a clock running
+// skew ahead of the local one. A zero stratum sends a kiss-of-death.
+func fakeNTP(t *testing.T, skew time.Duration, stratum byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 48 || buf[0]&0x7 != 3 {
+				continue // not a client request
+			}
+			resp := make([]byte, 48)
+			resp[0] = 0x1C // version 3, mode 4 (server)
+			resp[1] = stratum
+			copy(resp[24:32], buf[40:48]) // originate = client transmit
+			now := toNTP(time.Now().Add(skew))
+			binary.BigEndian.PutUint64(resp[32:], now)
+			binary.BigEndian.PutUint64(resp[40:], now)
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestNTPTimestamps(t *testing.T) {
+	at := time.Date(2024, 5, 1, 14, 30, 5, 250_000_000, time.UTC)
+	if got := fromNTP(toNTP(at)); got.Sub(at).Abs() > time.Microsecond {
+		t.Errorf("round trip %v, want %v", got, at)
+	}
+	if secs := toNTP(time.Unix(0, 0)) >> 32; secs != ntpEpochOffset {
+		t.Errorf("unix epoch in NTP seconds = %d", secs)
+	}
+}
+
+func TestSNTPOffset(t *testing.T) {
+	for _, skew := range []time.Duration{0, 2 * time.Second, -750 * time.Millisecond} {
+		offset, err := sntpOffset(fakeNTP(t, skew, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (offset - skew).Abs() > 50*time.Millisecond {
+			t.Errorf("skew %v: offset %v", skew, offset)
+		}
+	}
+
+	if _, err := sntpOffset(fakeNTP(t, 0, 0)); err == nil || !strings.Contains(err.Error(), "kiss-of-death") {
+		t.Errorf("kiss-of-death: err = %v", err)
+	}
+}
+
+func TestParseTimedatectl(t *testing.T) {
+	for _, tc := range []struct {
+		out           string
+		synced, found bool
+	}{
+		{"Timezone=UTC\nNTP=yes\nNTPSynchronized=yes\n", true, true},
+		{"NTP=yes\nNTPSynchronized=no\n", false, true},
+		{"Timezone=UTC\n", false, false},
+	} {
+		synced, found := parseTimedatectl([]byte(tc.out))
+		if synced != tc.synced || found != tc.found {
+			t.Errorf("%q: synced %v found %v", tc.out, synced, found)
+		}
+	}
+}
+
+func TestGetClock(t *testing.T) {
+	runner := &fakeRunner{out: map[string]string{"timedatectl show": "NTP=yes\nNTPSynchronized=yes\n"}}
+	p := &localPlugin{runner: runner}
+
+	if metrics := p.getClock(plugin.LocalClockConfig{}); metrics != nil {
+		t.Errorf("disabled: %v", metrics)
+	}
+
+	for _, tc := range []struct {
+		skew   time.Duration
+		warnMs float64
+		status string
+	}{
+		{0, 0, "up"},
+		{2 * time.Second, 0, "warning"}, // default 500ms
+		{-2 * time.Second, 0, "warning"},
+		{2 * time.Second, 5000, "up"},
+	} {
+		server := fakeNTP(t, tc.skew, 2)
+		metrics := p.getClock(plugin.LocalClockConfig{Enabled: true, Server: server, WarningMs: tc.warnMs})
+
+		offset := metrics["clock_offset_ms"].(map[string]interface{})
+		ms := offset["value_num"].(float64)
+		if math.Abs(ms-float64(tc.skew/time.Millisecond)) > 50 || offset["unit"] != plugin.UnitMillis || offset["server"] != server {
+			t.Errorf("skew %v: offset = %v", tc.skew, offset)
+		}
+		if _, err := strconv.ParseFloat(offset["value"].(string), 64); err != nil {
+			t.Errorf("skew %v: value %q", tc.skew, offset["value"])
+		}
+		if got := metrics["clock_status"].(map[string]interface{})["value"]; got != tc.status {
+			t.Errorf("skew %v, warning %vms: status %v, want %s", tc.skew, tc.warnMs, got, tc.status)
+		}
+		if got := metrics["clock_synchronized"].(map[string]interface{})["value"]; got != "up" {
+			t.Errorf("synchronized = %v", got)
+		}
+	}
+}
+
+func TestGetClockLocalOnly(t *testing.T) {
+	runner := &fakeRunner{out: map[string]string{"timedatectl show": "NTPSynchronized=no\n"}}
+	p := &localPlugin{runner: runner}
+
+	metrics := p.getClock(plugin.LocalClockConfig{Enabled: true, LocalOnly: true, Server: "127.0.0.1:1"})
+	if len(metrics) != 1 || metrics["clock_synchronized"].(map[string]interface{})["value"] != "down" {
+		t.Errorf("local only: %v", metrics)
+	}
+
+	// A host without timedatectl output reports nothing locally.
+	p.runner = &fakeRunner{errs: map[string]error{"timedatectl show": errors.New("exit status 1")}}
+	if metrics := p.getClock(plugin.LocalClockConfig{Enabled: true, LocalOnly: true}); len(metrics) != 0 {
+		t.Errorf("no timedatectl: %v", metrics)
+	}
+}
+
+func TestGetClockUnreachable(t *testing.T) {
+	// Grab a free port and close it so nothing answers.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := conn.LocalAddr().String()
+	conn.Close()
+
+	p := &localPlugin{runner: &fakeRunner{}}
+	metrics := p.getClock(plugin.LocalClockConfig{Enabled: true, Server: server})
+	m, ok := metrics["clock_offset_ms"].(map[string]interface{})
+	if !ok || m["type"] != "text" || !strings.Contains(m["value"].(string), "query "+server) {
+		t.Errorf("unreachable: %v", metrics)
+	}
+	if _, ok := metrics["clock_status"]; ok {
+		t.Error("status reported without an offset")
+	}
+}
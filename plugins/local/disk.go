@@ -0,0 +1,171 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/disk"
+
+	plugin "observer/base"
+)
+
+// defaultSkipFSTypes are pseudo and in-memory filesystems that never fill a disk.
+var defaultSkipFSTypes = []string{
+	"tmpfs", "devtmpfs", "overlay", "squashfs", "proc", "sysfs", "cgroup", "cgroup2",
+	"devpts", "mqueue", "debugfs", "tracefs", "securityfs", "pstore", "bpf", "configfs",
+	"fusectl", "hugetlbfs", "autofs", "nsfs", "ramfs", "efivarfs", "binfmt_misc",
+}
+
+const (
+	defaultDiskWarning  = 85.0
+	defaultDiskCritical = 95.0
+)
+
+// diskProvider lists mounted filesystems and their usage; gopsutil in production.
+type diskProvider interface {
+	Partitions(all bool) ([]disk.PartitionStat, error)
+	Usage(path string) (*disk.UsageStat, error)
+}
+
+type gopsutilDisks struct{}
+
+func (gopsutilDisks) Partitions(all bool) ([]disk.PartitionStat, error) { return disk.Partitions(all) }
+func (gopsutilDisks) Usage(path string) (*disk.UsageStat, error)        { return disk.Usage(path) }
+
+// loadLocalConfig reads the "local" section of the configuration file. A missing
+// or unreadable file yields the zero config, which means all defaults.
+func loadLocalConfig() plugin.LocalConfig {
+	var cfg struct {
+		Local plugin.LocalConfig `json:"local"`
+	}
+	if data, err := plugin.ReadConfigFile(); err == nil {
+		_ = json.Unmarshal(data, &cfg)
+	}
+	return cfg.Local
+}
+
+// diskThresholds returns the warning and critical percentages, applying defaults.
+func diskThresholds(cfg plugin.LocalDiskConfig) (float64, float64) {
+	warn, crit := cfg.WarningPercent, cfg.CriticalPercent
+	if warn <= 0 {
+		warn = defaultDiskWarning
+	}
+	if crit <= 0 {
+		crit = defaultDiskCritical
+	}
+	return warn, crit
+}
+
+// realPartitions drops skipped filesystem types and deduplicates bind mounts
+// and devices mounted more than once, keeping the shortest mountpoint.
+func realPartitions(parts []disk.PartitionStat, skipTypes []string) []disk.PartitionStat {
+	skip := make(map[string]bool, len(skipTypes))
+	for _, t := range skipTypes {
+		skip[strings.ToLower(t)] = true
+	}
+
+	sorted := make([]disk.PartitionStat, len(parts))
+	copy(sorted, parts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Mountpoint) < len(sorted[j].Mountpoint)
+	})
+
+	seen := make(map[string]bool)
+	var out []disk.PartitionStat
+	for _, p := range sorted {
+		if skip[strings.ToLower(p.Fstype)] || isBindMount(p) {
+			continue
+		}
+		key := p.Device
+		if key == "" || key == "none" {
+			key = p.Mountpoint
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Mountpoint < out[j].Mountpoint })
+	return out
+}
+
+// isBindMount reports whether the mount options mark a bind mount.
+func isBindMount(p disk.PartitionStat) bool {
+	for _, o := range p.Opts {
+		if o == "bind" || o == "rbind" {
+			return true
+		}
+	}
+	return false
+}
+
+// diskStatus maps the fuller of space and inode usage to up/warning/down.
+func diskStatus(usedPct, inodePct, warn, crit float64) string {
+	worst := usedPct
+	if inodePct > worst {
+		worst = inodePct
+	}
+	switch {
+	case worst >= crit:
+		return "down"
+	case worst >= warn:
+		return "warning"
+	default:
+		return "up"
+	}
+}
+
+// getDisks returns per-filesystem usage metrics keyed uniquely by mountpoint.
+func (p *localPlugin) getDisks(cfg plugin.LocalDiskConfig) (map[string]interface{}, error) {
+	provider := p.disks
+	if provider == nil {
+		provider = gopsutilDisks{}
+	}
+	parts, err := provider.Partitions(false)
+	if err != nil {
+		return nil, err
+	}
+	skip := cfg.SkipFSTypes
+	if len(skip) == 0 {
+		skip = defaultSkipFSTypes
+	}
+	warn, crit := diskThresholds(cfg)
+
+	metrics := make(map[string]interface{})
+	for _, part := range realPartitions(parts, skip) {
+		usage, err := provider.Usage(part.Mountpoint)
+		if err != nil {
+			p.Controller.Printf("          !_ local: disk usage %s: %v\n", part.Mountpoint, err)
+			continue
+		}
+		mp := part.Mountpoint
+		metrics["disk_used_percent_"+mp] = diskMetric("used_percent", mp, "gauge", plugin.UnitPercent, fmt.Sprintf("%.2f", usage.UsedPercent))
+		metrics["disk_free_bytes_"+mp] = diskMetric("free_bytes", mp, "gauge", plugin.UnitBytes, usage.Free)
+
+		var inodePct float64
+		if usage.InodesTotal > 0 {
+			inodePct = usage.InodesUsedPercent
+			metrics["disk_inode_used_percent_"+mp] = diskMetric("inode_used_percent", mp, "gauge", plugin.UnitPercent, fmt.Sprintf("%.2f", inodePct))
+		}
+		metrics["disk_status_"+mp] = diskMetric("disk", mp, "status", "", diskStatus(usage.UsedPercent, inodePct, warn, crit))
+	}
+	return metrics, nil
+}
+
+func diskMetric(name, mountpoint, metricType, unit string, value interface{}) map[string]interface{} {
+	m := map[string]interface{}{
+		"name":     name,
+		"label":    fmt.Sprintf("%s %s", name, mountpoint),
+		"value":    value,
+		"type":     metricType,
+		"category": "disk",
+		"instance": mountpoint,
+	}
+	if unit != "" {
+		m["unit"] = unit
+	}
+	return m
+}
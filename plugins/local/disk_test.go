@@ -0,0 +1,167 @@
+package local
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+
+	plugin "observer/base"
+)
+
+// fakeDisks serves fixture partitions and usage by mountpoint.
+type fakeDisks struct {
+	parts []disk.PartitionStat
+	usage map[string]*disk.UsageStat
+	err   error
+}
+
+func (f fakeDisks) Partitions(all bool) ([]disk.PartitionStat, error) { return f.parts, f.err }
+
+func (f fakeDisks) Usage(path string) (*disk.UsageStat, error) {
+	if u, ok := f.usage[path]; ok {
+		return u, nil
+	}
+	return nil, errors.New("permission denied")
+}
+
+func fixtureDisks() fakeDisks {
+	return fakeDisks{
+		parts: []disk.PartitionStat{
+			{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4"},
+			{Device: "/dev/sda2", Mountpoint: "/var/lib/docker/overlay", Fstype: "overlay"},
+			{Device: "tmpfs", Mountpoint: "/run", Fstype: "tmpfs"},
+			{Device: "/dev/sda1", Mountpoint: "/var/lib/kubelet/pods/x", Fstype: "ext4"}, // same device again
+			{Device: "/dev/sdb1", Mountpoint: "/srv/data", Fstype: "xfs", Opts: []string{"rw", "bind"}},
+			{Device: "/dev/sdc1", Mountpoint: "/data", Fstype: "XFS"},
+			{Device: "/dev/sdd1", Mountpoint: "/backup", Fstype: "ext4"},
+			{Device: "/dev/sde1", Mountpoint: "/mnt/nfs-stale", Fstype: "nfs"},
+		},
+		usage: map[string]*disk.UsageStat{
+			"/":       {UsedPercent: 42.123, Free: 1 << 30, InodesTotal: 1000, InodesUsedPercent: 90},
+			"/data":   {UsedPercent: 96, Free: 4096},
+			"/backup": {UsedPercent: 10, Free: 1 << 40, InodesTotal: 10, InodesUsedPercent: 1},
+			"/run":    {UsedPercent: 1},
+		},
+	}
+}
+
+func mountpoints(parts []disk.PartitionStat) string {
+	out := make([]string, len(parts))
+	for i, p := range parts {
+		out[i] = p.Mountpoint
+	}
+	return strings.Join(out, " ")
+}
+
+func TestRealPartitions(t *testing.T) {
+	parts := fixtureDisks().parts
+	if got := mountpoints(realPartitions(parts, defaultSkipFSTypes)); got != "/ /backup /data /mnt/nfs-stale" {
+		t.Errorf("default skip list: %s", got)
+	}
+	// A custom list replaces the defaults, case-insensitively.
+	if got := mountpoints(realPartitions(parts, []string{"xfs", "NFS"})); got != "/ /backup /run /var/lib/docker/overlay" {
+		t.Errorf("custom skip list: %s", got)
+	}
+	// Pseudo devices are told apart by mountpoint.
+	pseudo := []disk.PartitionStat{{Device: "none", Mountpoint: "/a", Fstype: "zfs"}, {Device: "none", Mountpoint: "/b", Fstype: "zfs"}}
+	if got := mountpoints(realPartitions(pseudo, nil)); got != "/a /b" {
+		t.Errorf("pseudo devices: %s", got)
+	}
+}
+
+func TestDiskStatus(t *testing.T) {
+	for _, tc := range []struct {
+		used, inodes float64
+		want         string
+	}{
+		{50, 10, "up"},
+		{84.99, 0, "up"},
+		{85, 0, "warning"},
+		{10, 90, "warning"}, // inodes count as much as space
+		{95, 0, "down"},
+		{10, 99, "down"},
+	} {
+		if got := diskStatus(tc.used, tc.inodes, 85, 95); got != tc.want {
+			t.Errorf("diskStatus(%v, %v) = %s, want %s", tc.used, tc.inodes, got, tc.want)
+		}
+	}
+}
+
+func TestDiskThresholds(t *testing.T) {
+	if w, c := diskThresholds(plugin.LocalDiskConfig{}); w != defaultDiskWarning || c != defaultDiskCritical {
+		t.Errorf("defaults %v, %v", w, c)
+	}
+	if w, c := diskThresholds(plugin.LocalDiskConfig{WarningPercent: 70, CriticalPercent: -1}); w != 70 || c != defaultDiskCritical {
+		t.Errorf("partial override %v, %v", w, c)
+	}
+}
+
+func TestGetDisks(t *testing.T) {
+	p := &localPlugin{disks: fixtureDisks()}
+	metrics, err := p.getDisks(plugin.LocalDiskConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := func(key string) interface{} {
+		m, ok := metrics[key].(map[string]interface{})
+		if !ok {
+			t.Errorf("no metric %s", key)
+			return nil
+		}
+		return m["value"]
+	}
+
+	if v := value("disk_used_percent_/"); v != "42.12" {
+		t.Errorf("used_percent / = %v", v)
+	}
+	if v := value("disk_free_bytes_/"); v != uint64(1<<30) {
+		t.Errorf("free_bytes / = %v", v)
+	}
+	if v := value("disk_inode_used_percent_/"); v != "90.00" {
+		t.Errorf("inode_used_percent / = %v", v)
+	}
+	for mp, want := range map[string]string{"/": "warning", "/data": "down", "/backup": "up"} {
+		if v := value("disk_status_" + mp); v != want {
+			t.Errorf("status %s = %v, want %s", mp, v, want)
+		}
+	}
+	// No inode metric when the filesystem has no inode table.
+	if _, ok := metrics["disk_inode_used_percent_/data"]; ok {
+		t.Error("inode metric for a filesystem without inodes")
+	}
+	// The stale NFS mount is skipped, not reported as an error.
+	for key := range metrics {
+		if strings.Contains(key, "nfs") || strings.Contains(key, "/run") || strings.Contains(key, "kubelet") {
+			t.Errorf("unexpected metric %s", key)
+		}
+	}
+	m := metrics["disk_status_/data"].(map[string]interface{})
+	if _, ok := m["unit"]; m["instance"] != "/data" || m["category"] != "disk" || m["type"] != "status" || ok {
+		t.Errorf("status metric = %v", m)
+	}
+	// Sizes are in bytes, levels in percent.
+	for key, want := range map[string]string{
+		"disk_free_bytes_/":         plugin.UnitBytes,
+		"disk_used_percent_/":       plugin.UnitPercent,
+		"disk_inode_used_percent_/": plugin.UnitPercent,
+	} {
+		if unit := metrics[key].(map[string]interface{})["unit"]; unit != want {
+			t.Errorf("%s unit = %v, want %s", key, unit, want)
+		}
+	}
+
+	// Custom thresholds.
+	metrics, _ = p.getDisks(plugin.LocalDiskConfig{WarningPercent: 5, CriticalPercent: 99})
+	if v := value("disk_status_/backup"); v != "warning" {
+		t.Errorf("custom threshold status = %v", v)
+	}
+}
+
+func TestGetDisksPartitionError(t *testing.T) {
+	p := &localPlugin{disks: fakeDisks{err: errors.New("no /proc/mounts")}}
+	if _, err := p.getDisks(plugin.LocalDiskConfig{}); err == nil {
+		t.Error("partition error swallowed")
+	}
+}
@@ -0,0 +1,228 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	plugin "observer/base"
+)
+
+const (
+	defaultDockerSocket  = "/var/run/docker.sock"
+	defaultMaxContainers = 50
+	dockerTimeout        = 5 * time.Second
+)
+
+// dockerContainer is the subset of GET /containers/json used here.
+type dockerContainer struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"`
+	Image string   `json:"Image"`
+	State string   `json:"State"`
+}
+
+// name returns the container name without the leading slash.
+func (c dockerContainer) name() string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	if len(c.ID) > 12 {
+		return c.ID[:12]
+	}
+	return c.ID
+}
+
+// dockerInspect is the subset of GET /containers/{id}/json used here.
+type dockerInspect struct {
+	RestartCount int `json:"RestartCount"`
+}
+
+// dockerStats is the subset of GET /containers/{id}/stats used here.
+type dockerStats struct {
+	CPUStats    dockerCPUStats `json:"cpu_stats"`
+	PreCPUStats dockerCPUStats `json:"precpu_stats"`
+	MemoryStats struct {
+		Usage uint64 `json:"usage"`
+	} `json:"memory_stats"`
+}
+
+type dockerCPUStats struct {
+	CPUUsage struct {
+		TotalUsage uint64 `json:"total_usage"`
+	} `json:"cpu_usage"`
+	SystemUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs  int    `json:"online_cpus"`
+}
+
+// cpuPercent computes utilization the way `docker stats` does.
+func (s dockerStats) cpuPercent() float64 {
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	sysDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || sysDelta <= 0 {
+		return 0
+	}
+	cpus := s.CPUStats.OnlineCPUs
+	if cpus == 0 {
+		cpus = 1
+	}
+	return cpuDelta / sysDelta * float64(cpus) * 100
+}
+
+// dockerClient speaks the minimal Docker Engine API subset over HTTP.
+type dockerClient struct {
+	http    *http.Client
+	baseURL string
+}
+
+// newSocketClient returns a client that dials the Docker unix socket.
+func newSocketClient(socket string) *dockerClient {
+	return &dockerClient{
+		http: &http.Client{
+			Timeout: dockerTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+		baseURL: "http://docker",
+	}
+}
+
+func (c *dockerClient) get(path string, out interface{}) error {
+	resp, err := c.http.Get(c.baseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker API %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// containerMetrics returns status, cpu_percent, mem_usage_bytes and restart_count per
+// container, capped at max containers (running ones first, then by name). Notes
+// on what was left out are printed to progress.
+func (c *dockerClient) containerMetrics(max int, progress io.Writer) (map[string]interface{}, error) {
+	var containers []dockerContainer
+	if err := c.get("/containers/json?all=1", &containers); err != nil {
+		return nil, err
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		ri, rj := containers[i].State == "running", containers[j].State == "running"
+		if ri != rj {
+			return ri
+		}
+		return containers[i].name() < containers[j].name()
+	})
+
+	metrics := make(map[string]interface{})
+	if len(containers) > max {
+		fmt.Fprintf(progress, "          !_ local: reporting %d of %d containers\n", max, len(containers))
+		containers = containers[:max]
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, ctr := range containers {
+		wg.Add(1)
+		go func(ctr dockerContainer) {
+			defer wg.Done()
+			m := c.oneContainer(ctr, progress)
+			mu.Lock()
+			for k, v := range m {
+				metrics[k] = v
+			}
+			mu.Unlock()
+		}(ctr)
+	}
+	wg.Wait()
+	return metrics, nil
+}
+
+// oneContainer gathers the metrics for a single container. Stats are skipped for
+// stopped containers, which have none.
+func (c *dockerClient) oneContainer(ctr dockerContainer, progress io.Writer) map[string]interface{} {
+	name := ctr.name()
+	status := "exited"
+	if ctr.State == "running" {
+		status = "up"
+	}
+	metrics := map[string]interface{}{
+		"container_status_" + name: containerMetric("status", name, ctr.Image, "status", status),
+	}
+
+	var inspect dockerInspect
+	if err := c.get("/containers/"+ctr.ID+"/json", &inspect); err == nil {
+		metrics["container_restarts_"+name] = containerMetric("restart_count", name, ctr.Image, "counter", inspect.RestartCount)
+	}
+
+	if ctr.State != "running" {
+		return metrics
+	}
+	var stats dockerStats
+	if err := c.get("/containers/"+ctr.ID+"/stats?stream=false", &stats); err != nil {
+		fmt.Fprintf(progress, "          !_ local: docker stats %s: %v\n", name, err)
+		return metrics
+	}
+	metrics["container_cpu_"+name] = containerMetric("cpu_percent", name, ctr.Image, "percent", fmt.Sprintf("%.2f", stats.cpuPercent()))
+	metrics["container_mem_"+name] = containerMetric("mem_usage_bytes", name, ctr.Image, "gauge", stats.MemoryStats.Usage)
+	return metrics
+}
+
+func containerMetric(name, container, image, metricType string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    fmt.Sprintf("%s %s", name, container),
+		"value":    value,
+		"type":     metricType,
+		"category": "docker",
+		"instance": container,
+		"image":    image, // non-standard key, stored in Extra
+	}
+}
+
+// getDocker collects container metrics when the Docker socket is present. A host
+// without Docker gets no metrics; an unreadable socket gets a single error metric.
+func (p *localPlugin) getDocker(cfg plugin.LocalDockerConfig) map[string]interface{} {
+	if cfg.Disabled {
+		return nil
+	}
+	socket := cfg.Socket
+	if socket == "" {
+		socket = defaultDockerSocket
+	}
+	if _, err := os.Stat(socket); err != nil {
+		return nil
+	}
+	max := cfg.MaxContainers
+	if max <= 0 {
+		max = defaultMaxContainers
+	}
+
+	client := p.docker
+	if client == nil {
+		client = newSocketClient(socket)
+	}
+	metrics, err := client.containerMetrics(max, p.Controller.Out())
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			err = fmt.Errorf("permission denied on %s (add the user to the docker group)", socket)
+		}
+		return map[string]interface{}{"docker": p.errorMetric("Docker", "docker", err)}
+	}
+	return metrics
+}
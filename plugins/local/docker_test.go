@@ -0,0 +1,176 @@
+package local
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	plugin "observer/base"
+)
+
+// fakeDocker speaks the Docker Engine API subset the plugin uses: list, inspect
+// and one-shot stats. Requests are recorded by path.
+type fakeDocker struct {
+	containers string
+	restarts   map[string]int
+	mu         sync.Mutex
+	paths      []string
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.paths = append(f.paths, r.URL.RequestURI())
+	f.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/containers/json":
+		if r.URL.Query().Get("all") != "1" {
+			http.Error(w, "want all=1", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, f.containers)
+	case len(parts) == 3 && parts[2] == "json":
+		fmt.Fprintf(w, `{"Id": %q, "RestartCount": %d}`, parts[1], f.restarts[parts[1]])
+	case len(parts) == 3 && parts[2] == "stats":
+		if parts[1] == "flaky" {
+			http.Error(w, "container is restarting", http.StatusConflict)
+			return
+		}
+		fmt.Fprint(w, `{
+		  "cpu_stats":    {"cpu_usage": {"total_usage": 1400000000}, "system_cpu_usage": 20000000000, "online_cpus": 4},
+		  "precpu_stats": {"cpu_usage": {"total_usage": 1000000000}, "system_cpu_usage": 18000000000},
+		  "memory_stats": {"usage": 52428800}
+		}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+const dockerFixture = `[
+  {"Id": "aaa111", "Names": ["/web"], "Image": "nginx:1.25", "State": "running"},
+  {"Id": "bbb222", "Names": ["/backup"], "Image": "restic:latest", "State": "exited"},
+  {"Id": "flaky", "Names": ["/queue"], "Image": "redis:7", "State": "running"},
+  {"Id": "0123456789abcdef", "Names": [], "Image": "busybox", "State": "running"}
+]`
+
+// dockerSetup serves f over HTTP and returns a plugin talking to it, along with
+// a config whose socket path exists so collection is not skipped.
+func dockerSetup(t *testing.T, f *fakeDocker) (*localPlugin, plugin.LocalDockerConfig) {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	if err := os.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := &localPlugin{docker: &dockerClient{http: srv.Client(), baseURL: srv.URL}}
+	return p, plugin.LocalDockerConfig{Socket: socket}
+}
+
+func TestGetDocker(t *testing.T) {
+	f := &fakeDocker{containers: dockerFixture, restarts: map[string]int{"aaa111": 3}}
+	p, cfg := dockerSetup(t, f)
+
+	metrics := p.getDocker(cfg)
+	for key, want := range map[string]interface{}{
+		"container_status_web":          "up",
+		"container_status_backup":       "exited",
+		"container_status_queue":        "up",
+		"container_status_0123456789ab": "up",
+		"container_restarts_web":        3,
+		"container_restarts_backup":     0,
+		"container_cpu_web":             "80.00", // 0.4s of 2s across 4 CPUs
+		"container_mem_web":             uint64(50 << 20),
+	} {
+		m, ok := metrics[key].(map[string]interface{})
+		if !ok || m["value"] != want {
+			t.Errorf("%s = %v, want %v", key, metrics[key], want)
+		}
+	}
+	if m := metrics["container_cpu_web"].(map[string]interface{}); m["instance"] != "web" || m["image"] != "nginx:1.25" || m["category"] != "docker" {
+		t.Errorf("web cpu metric = %v", m)
+	}
+	// Stopped containers have no stats; a failed stats call keeps the status.
+	for _, key := range []string{"container_cpu_backup", "container_mem_backup", "container_cpu_queue"} {
+		if _, ok := metrics[key]; ok {
+			t.Errorf("unexpected %s", key)
+		}
+	}
+	for _, path := range f.paths {
+		if strings.HasPrefix(path, "/containers/bbb222/stats") {
+			t.Error("stats requested for a stopped container")
+		}
+		if strings.Contains(path, "/stats") && !strings.HasSuffix(path, "stream=false") {
+			t.Errorf("streaming stats requested: %s", path)
+		}
+	}
+}
+
+func TestGetDockerCap(t *testing.T) {
+	p, cfg := dockerSetup(t, &fakeDocker{containers: dockerFixture})
+	cfg.MaxContainers = 2
+
+	metrics := p.getDocker(cfg)
+	var statuses []string
+	for key := range metrics {
+		if strings.HasPrefix(key, "container_status_") {
+			statuses = append(statuses, strings.TrimPrefix(key, "container_status_"))
+		}
+	}
+	// Running containers come first, by name.
+	if len(statuses) != 2 || metrics["container_status_0123456789ab"] == nil || metrics["container_status_queue"] == nil {
+		t.Errorf("reported %v", statuses)
+	}
+}
+
+func TestGetDockerSkipped(t *testing.T) {
+	f := &fakeDocker{containers: dockerFixture}
+	p, cfg := dockerSetup(t, f)
+
+	disabled := cfg
+	disabled.Disabled = true
+	missing := plugin.LocalDockerConfig{Socket: filepath.Join(t.TempDir(), "absent.sock")}
+	for name, c := range map[string]plugin.LocalDockerConfig{"disabled": disabled, "no socket": missing} {
+		if metrics := p.getDocker(c); metrics != nil {
+			t.Errorf("%s: metrics = %v", name, metrics)
+		}
+	}
+	if len(f.paths) != 0 {
+		t.Errorf("API called: %v", f.paths)
+	}
+}
+
+// deniedTransport fails every request the way dialing a socket without
+// permission does.
+type deniedTransport struct{}
+
+func (deniedTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, &os.SyscallError{Syscall: "connect", Err: fs.ErrPermission}
+}
+
+func TestGetDockerErrors(t *testing.T) {
+	p, cfg := dockerSetup(t, &fakeDocker{})
+	p.docker.http = &http.Client{Transport: deniedTransport{}}
+
+	metrics := p.getDocker(cfg)
+	if len(metrics) != 1 {
+		t.Fatalf("metrics = %v", metrics)
+	}
+	m := metrics["docker"].(map[string]interface{})
+	if v := m["value"].(string); !strings.Contains(v, "permission denied on "+cfg.Socket) || !strings.Contains(v, "docker group") {
+		t.Errorf("permission error = %q", v)
+	}
+
+	p, cfg = dockerSetup(t, &fakeDocker{containers: `not json`})
+	metrics = p.getDocker(cfg)
+	if len(metrics) != 1 || metrics["docker"].(map[string]interface{})["type"] != "text" {
+		t.Errorf("bad response: metrics = %v", metrics)
+	}
+}
@@ -0,0 +1,120 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// dmiDir holds the firmware identification files; the serial is usually root-only.
+var dmiDir = "/sys/class/dmi/id"
+
+// hostInfoProvider reads host identification; gopsutil in production.
+type hostInfoProvider interface {
+	Info() (*host.InfoStat, error)
+}
+
+type gopsutilHost struct{}
+
+func (gopsutilHost) Info() (*host.InfoStat, error) { return host.Info() }
+
+// readDMI returns the DMI fields that are readable on this host.
+func readDMI(dir string) map[string]string {
+	dmi := make(map[string]string)
+	for _, f := range []string{"product_serial", "product_name", "sys_vendor"} {
+		data, err := os.ReadFile(filepath.Join(dir, f))
+		if err != nil {
+			continue
+		}
+		if v := strings.TrimSpace(string(data)); v != "" {
+			dmi[f] = v
+		}
+	}
+	return dmi
+}
+
+// factMetrics turns host info into text metrics in the "facts" category. They are
+// flagged dedup so the store only records a new row when a fact changes, and the
+// numeric uptime_seconds rides along without the flag.
+func factMetrics(info *host.InfoStat, dmi map[string]string) map[string]interface{} {
+	facts := []struct {
+		name  string
+		value string
+	}{
+		{"hostname", info.Hostname},
+		{"os", info.OS},
+		{"platform", info.Platform},
+		{"platform_version", info.PlatformVersion},
+		{"kernel_version", info.KernelVersion},
+		{"kernel_arch", info.KernelArch},
+		{"virtualization_system", info.VirtualizationSystem},
+		{"virtualization_role", info.VirtualizationRole},
+		{"boot_time", time.Unix(int64(info.BootTime), 0).UTC().Format(time.RFC3339)},
+	}
+
+	metrics := make(map[string]interface{})
+	for _, f := range facts {
+		if f.value == "" {
+			continue
+		}
+		metrics["fact_"+f.name] = map[string]interface{}{
+			"name":     f.name,
+			"label":    f.name,
+			"value":    f.value,
+			"type":     "text",
+			"category": "facts",
+			"dedup":    true,
+		}
+	}
+	if platform, ok := metrics["fact_platform"].(map[string]interface{}); ok {
+		for k, v := range dmi {
+			platform[k] = v // non-standard keys, stored in Extra
+		}
+	}
+
+	metrics["uptime_seconds"] = map[string]interface{}{
+		"name":     "uptime_seconds",
+		"label":    "Uptime (s)",
+		"value":    info.Uptime,
+		"type":     "gauge",
+		"category": "system",
+		"unit":     plugin.UnitSeconds,
+	}
+	return metrics
+}
+
+// versionFact reports the nord build doing the collecting, so a central server
+// can tell which agent versions are deployed.
+func versionFact(build plugin.BuildInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"name":       "nord_version",
+		"label":      "nord_version",
+		"value":      build.Version,
+		"type":       "text",
+		"category":   "facts",
+		"dedup":      true,
+		"commit":     build.Commit,
+		"build_date": build.BuildDate,
+		"go_version": build.GoVersion,
+	}
+}
+
+// getFacts returns the host inventory facts.
+func (p *localPlugin) getFacts() (map[string]interface{}, error) {
+	provider := p.hostInfo
+	if provider == nil {
+		provider = gopsutilHost{}
+	}
+	info, err := provider.Info()
+	if err != nil {
+		return nil, err
+	}
+	metrics := factMetrics(info, readDMI(dmiDir))
+	metrics["fact_nord_version"] = versionFact(plugin.Build())
+	return metrics, nil
+}
@@ -0,0 +1,145 @@
+package local
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/host"
+
+	plugin "observer/base"
+)
+
+type fakeHostInfo struct {
+	info *host.InfoStat
+	err  error
+}
+
+func (f fakeHostInfo) Info() (*host.InfoStat, error) { return f.info, f.err }
+
+func fixtureHostInfo() *host.InfoStat {
+	return &host.InfoStat{
+		Hostname:             "nas",
+		Uptime:               93784,
+		BootTime:             1714521600, // 2024-05-01T00:00:00Z
+		OS:                   "linux",
+		Platform:             "debian",
+		PlatformVersion:      "12.5",
+		KernelVersion:        "6.1.0-21-amd64",
+		KernelArch:           "x86_64",
+		VirtualizationSystem: "kvm",
+		VirtualizationRole:   "guest",
+	}
+}
+
+// useDMIDir points dmiDir at a temp directory holding files.
+func useDMIDir(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := dmiDir
+	dmiDir = dir
+	t.Cleanup(func() { dmiDir = old })
+}
+
+func TestGetFacts(t *testing.T) {
+	useDMIDir(t, map[string]string{
+		"product_serial": "SN-1234\n",
+		"product_name":   "Standard PC (Q35 + ICH9, 2009)\n",
+		"sys_vendor":     "  \n", // blank fields are left out
+	})
+	p := &localPlugin{hostInfo: fakeHostInfo{info: fixtureHostInfo()}}
+
+	metrics, err := p.getFacts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"hostname":              "nas",
+		"os":                    "linux",
+		"platform":              "debian",
+		"platform_version":      "12.5",
+		"kernel_version":        "6.1.0-21-amd64",
+		"kernel_arch":           "x86_64",
+		"virtualization_system": "kvm",
+		"virtualization_role":   "guest",
+		"boot_time":             "2024-05-01T00:00:00Z",
+	}
+	for name, value := range want {
+		m, ok := metrics["fact_"+name].(map[string]interface{})
+		if !ok {
+			t.Errorf("no %s fact", name)
+			continue
+		}
+		if m["value"] != value || m["type"] != "text" || m["category"] != "facts" || m["dedup"] != true {
+			t.Errorf("%s = %v", name, m)
+		}
+	}
+	if len(metrics) != len(want)+2 { // plus uptime_seconds and nord_version
+		t.Errorf("%d metrics: %v", len(metrics), metrics)
+	}
+
+	platform := metrics["fact_platform"].(map[string]interface{})
+	if platform["product_serial"] != "SN-1234" || platform["product_name"] != "Standard PC (Q35 + ICH9, 2009)" {
+		t.Errorf("DMI fields = %v", platform)
+	}
+	if _, ok := platform["sys_vendor"]; ok {
+		t.Error("blank sys_vendor reported")
+	}
+
+	uptime := metrics["uptime_seconds"].(map[string]interface{})
+	if uptime["value"] != uint64(93784) || uptime["type"] != "gauge" || uptime["unit"] != plugin.UnitSeconds || uptime["dedup"] != nil {
+		t.Errorf("uptime_seconds = %v", uptime)
+	}
+
+	version := metrics["fact_nord_version"].(map[string]interface{})
+	if version["value"] != plugin.Version || version["dedup"] != true {
+		t.Errorf("nord_version = %v", version)
+	}
+
+	// The dedup flag and DMI fields survive into the result's Extra.
+	mr := plugin.NewMetricResult("platform", "local", platform)
+	if mr.Extra["dedup"] != true || mr.Extra["product_serial"] != "SN-1234" {
+		t.Errorf("result extra = %v", mr.Extra)
+	}
+}
+
+func TestFactMetricsSkipsEmpty(t *testing.T) {
+	info := fixtureHostInfo()
+	info.VirtualizationSystem, info.VirtualizationRole = "", ""
+	metrics := factMetrics(info, nil)
+	for _, name := range []string{"fact_virtualization_system", "fact_virtualization_role"} {
+		if _, ok := metrics[name]; ok {
+			t.Errorf("empty %s reported", name)
+		}
+	}
+	if _, ok := metrics["fact_platform"].(map[string]interface{})["product_serial"]; ok {
+		t.Error("serial reported without DMI")
+	}
+}
+
+func TestGetFactsUnreadable(t *testing.T) {
+	useDMIDir(t, nil)
+	p := &localPlugin{hostInfo: fakeHostInfo{err: errors.New("no /etc/os-release")}}
+	if _, err := p.getFacts(); err == nil {
+		t.Error("host info error not returned")
+	}
+}
+
+func TestVersionFact(t *testing.T) {
+	m := versionFact(plugin.BuildInfo{Version: "1.2.0", Commit: "abc1234", BuildDate: "2024-05-01T12:00:00Z", GoVersion: "go1.22.3"})
+	mr := plugin.NewMetricResult("nord_version", "local", m)
+	if mr.Value != "1.2.0" || mr.Category != "facts" {
+		t.Errorf("result = %+v", mr)
+	}
+	for key, want := range map[string]interface{}{"commit": "abc1234", "build_date": "2024-05-01T12:00:00Z", "go_version": "go1.22.3", "dedup": true} {
+		if mr.Extra[key] != want {
+			t.Errorf("extra %s = %v, want %v", key, mr.Extra[key], want)
+		}
+	}
+}
@@ -3,8 +3,10 @@ package local
 
 import (
 	"fmt"
+	"math"
 	"observer/base"
 	"observer/plugins"
+	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/host"
@@ -15,6 +17,15 @@ import (
 // localPlugin collects metrics from the local machine.
 type localPlugin struct {
 	plugin.BasePlugin
+	disks   diskProvider   // nil uses gopsutil
+	nets    netProvider    // nil uses gopsutil
+	procs   procLister     // nil uses gopsutil
+	sensors sensorProvider // nil uses gopsutil
+	docker  *dockerClient  // nil dials the configured socket
+	runner  commandRunner  // nil runs systemctl or timedatectl when present
+	top     topSampler     // nil reads /proc
+
+	hostInfo hostInfoProvider // nil uses gopsutil
 }
 
 func init() {
@@ -43,9 +54,9 @@ func (p *localPlugin) OnCollect(options map[string]interface{}) (map[string]inte
 	if (err != nil) {
 		metrics["mem_total"] = p.errorMetric("Total Memory", "memory", err)
 	} else {
-		metrics["mem_total"] = map[string]interface{}{"name": "Total Memory", "label": "Total", "value": memTotal, "type": "text", "category": "memory"}
-		metrics["mem_free"] = map[string]interface{}{"name": "Free Memory", "label": "Free", "value": memFree, "type": "text", "category": "memory"}
-		metrics["swap"] = map[string]interface{}{"name": "Swap", "label": "Swap", "value": swapPct, "type": "percent", "category": "memory"}
+		metrics["mem_total"] = map[string]interface{}{"name": "Total Memory", "label": "Total", "value": memTotal, "type": "text", "category": "memory", "unit": plugin.UnitMegabytes}
+		metrics["mem_free"] = map[string]interface{}{"name": "Free Memory", "label": "Free", "value": memFree, "type": "text", "category": "memory", "unit": plugin.UnitMegabytes}
+		metrics["swap"] = map[string]interface{}{"name": "Swap", "label": "Swap", "value": swapPct, "type": "percent", "category": "memory", "unit": plugin.UnitPercent}
 	}
 
 	// Load
@@ -53,10 +64,104 @@ func (p *localPlugin) OnCollect(options map[string]interface{}) (map[string]inte
 	if (err != nil) {
 		metrics["load"] = p.errorMetric("Load", "system", err)
 	} else {
-		metrics["load"] = load
+		for k, v := range load {
+			metrics[k] = v
+		}
+	}
+
+	// Facts
+	facts, err := p.getFacts()
+	if err != nil {
+		metrics["facts"] = p.errorMetric("Facts", "facts", err)
 	}
+	for k, v := range facts {
+		metrics[k] = v
+	}
+
+	// Pressure stall information
+	for k, v := range p.getPressure() {
+		metrics[k] = v
+	}
+
+	cfg := loadLocalConfig()
 
-	return map[string]interface{}{"metrics": metrics}, nil
+	// CPU
+	cpuMetrics, err := p.getCPU(cfg.CPU.PerCore)
+	if err != nil {
+		metrics["cpu_percent"] = p.errorMetric("CPU", "system", err)
+	} else {
+		for k, v := range cpuMetrics {
+			metrics[k] = v
+		}
+	}
+
+	// Disks
+	disks, err := p.getDisks(cfg.Disk)
+	if err != nil {
+		metrics["disk"] = p.errorMetric("Disk", "disk", err)
+	} else {
+		for k, v := range disks {
+			metrics[k] = v
+		}
+	}
+
+	// Processes
+	procMetrics, err := p.getProcesses(cfg.Processes)
+	if err != nil {
+		metrics["processes"] = p.errorMetric("Processes", "process", err)
+	} else {
+		for k, v := range procMetrics {
+			metrics[k] = v
+		}
+	}
+
+	// Top processes
+	topProcs, err := p.getTop(cfg.Top)
+	if err != nil {
+		metrics["top"] = p.errorMetric("Top Processes", "process", err)
+	}
+	for k, v := range topProcs {
+		metrics[k] = v
+	}
+
+	// Temperatures
+	for k, v := range p.getSensors(cfg.Sensors) {
+		metrics[k] = v
+	}
+
+	// Path checks
+	for k, v := range p.getPaths(cfg.Paths, time.Now()) {
+		metrics[k] = v
+	}
+
+	// Clock
+	for k, v := range p.getClock(cfg.Clock) {
+		metrics[k] = v
+	}
+
+	// Systemd units
+	for k, v := range p.getSystemdUnits(cfg.SystemdUnits) {
+		metrics[k] = v
+	}
+
+	// Containers
+	for k, v := range p.getDocker(cfg.Docker) {
+		metrics[k] = v
+	}
+
+	// Network interfaces
+	result := map[string]interface{}{"metrics": metrics}
+	nets, ifaces, err := p.getNetwork(cfg.Net, time.Now())
+	if err != nil {
+		metrics["network"] = p.errorMetric("Network", "network", err)
+	} else {
+		for k, v := range nets {
+			metrics[k] = v
+		}
+		result["interfaces"] = ifaces
+	}
+
+	return result, nil
 }
 
 func (p *localPlugin) getUptime() (map[string]interface{}, error) {
@@ -96,6 +201,7 @@ func (p *localPlugin) getMemory() (total uint64, free uint64, swapPercent float6
 	return v.Total / 1024 / 1024, v.Free / 1024 / 1024, swapPercent, nil
 }
 
+// getLoad returns the load histogram plus load1/load5/load15 as separate numeric metrics.
 func (p *localPlugin) getLoad() (map[string]interface{}, error) {
 	_, err := cpu.Counts(true)
 	if err != nil {
@@ -105,15 +211,87 @@ func (p *localPlugin) getLoad() (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	return loadMetrics(avg), nil
+}
 
+// loadMetrics keeps the averages as floats: two decimals for display, full precision in value_num.
+func loadMetrics(avg *load.AvgStat) map[string]interface{} {
 	// gopsutil load avg is already normalized for CPU count, so we just format it.
-	utilization := []int{
-		int(avg.Load1),
-		int(avg.Load5),
-		int(avg.Load15),
+	utilization := []float64{
+		math.Round(avg.Load1*100) / 100,
+		math.Round(avg.Load5*100) / 100,
+		math.Round(avg.Load15*100) / 100,
+	}
+
+	metrics := map[string]interface{}{
+		"load": map[string]interface{}{"category": "system", "type": "histogram", "label": "Load", "value": utilization},
+	}
+	for _, l := range []struct {
+		name  string
+		value float64
+	}{{"load1", avg.Load1}, {"load5", avg.Load5}, {"load15", avg.Load15}} {
+		metrics[l.name] = map[string]interface{}{
+			"name":      l.name,
+			"label":     l.name,
+			"value":     fmt.Sprintf("%.2f", l.value),
+			"value_num": l.value,
+			"type":      "gauge",
+			"category":  "system",
+		}
+	}
+	return metrics
+}
+
+// cpuSampleWindow is how long cpu.Percent measures; it is added to every collection.
+const cpuSampleWindow = 500 * time.Millisecond
+
+// getCPU returns overall CPU utilization and, when perCore is set, one metric per core.
+// Both come from a single per-core sample so the window is only paid once.
+func (p *localPlugin) getCPU(perCore bool) (map[string]interface{}, error) {
+	percents, err := cpu.Percent(cpuSampleWindow, perCore)
+	if err != nil {
+		return nil, err
+	}
+	return cpuMetrics(percents, perCore), nil
+}
+
+// cpuMetrics builds cpu_percent from overall or per-core percentages. Per-core
+// metrics carry instance "cpu<N>" and the overall value is their mean.
+func cpuMetrics(percents []float64, perCore bool) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	if len(percents) == 0 {
+		return metrics
 	}
 
-	return map[string]interface{}{"category": "system", "type": "histogram", "label": "Load", "value": utilization}, nil
+	overall := percents[0]
+	if perCore {
+		var sum float64
+		for i, pct := range percents {
+			sum += pct
+			instance := fmt.Sprintf("cpu%d", i)
+			metrics["cpu_percent_"+instance] = cpuMetric(pct, instance)
+		}
+		overall = sum / float64(len(percents))
+	}
+	metrics["cpu_percent"] = cpuMetric(overall, "")
+	return metrics
+}
+
+func cpuMetric(pct float64, instance string) map[string]interface{} {
+	m := map[string]interface{}{
+		"name":      "cpu_percent",
+		"label":     "CPU",
+		"value":     fmt.Sprintf("%.2f", pct),
+		"value_num": pct,
+		"type":      "percent",
+		"category":  "system",
+		"unit":      plugin.UnitPercent,
+	}
+	if instance != "" {
+		m["label"] = "CPU " + instance
+		m["instance"] = instance
+	}
+	return m
 }
 
 func (p *localPlugin) errorMetric(label, category string, err error) map[string]interface{} {
@@ -0,0 +1,72 @@
+package local
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/load"
+
+	plugin "observer/base"
+)
+
+func TestLoadMetricsKeepFloats(t *testing.T) {
+	metrics := loadMetrics(&load.AvgStat{Load1: 0.85, Load5: 0.123456, Load15: 2.5})
+
+	hist := metrics["load"].(map[string]interface{})
+	values, ok := hist["value"].([]float64)
+	if !ok || len(values) != 3 || values[0] != 0.85 || values[1] != 0.12 || values[2] != 2.5 {
+		t.Errorf("load histogram = %v", hist["value"])
+	}
+
+	for name, want := range map[string]struct {
+		display string
+		num     float64
+	}{
+		"load1":  {"0.85", 0.85},
+		"load5":  {"0.12", 0.123456},
+		"load15": {"2.50", 2.5},
+	} {
+		m, ok := metrics[name].(map[string]interface{})
+		if !ok {
+			t.Errorf("no %s metric", name)
+			continue
+		}
+		if m["value"] != want.display || m["value_num"] != want.num || m["type"] != "gauge" {
+			t.Errorf("%s = %v", name, m)
+		}
+		// Sub-1 loads survive the trip into a result.
+		mr := plugin.NewMetricResult(name, "local", m)
+		if mr.Extra["value_num"] != want.num || mr.Name != name {
+			t.Errorf("%s result = %+v", name, mr)
+		}
+	}
+}
+
+func TestCPUMetrics(t *testing.T) {
+	overall := cpuMetrics([]float64{37.5}, false)
+	if len(overall) != 1 {
+		t.Fatalf("overall only = %v", overall)
+	}
+	m := overall["cpu_percent"].(map[string]interface{})
+	if m["value"] != "37.50" || m["value_num"] != 37.5 || m["unit"] != plugin.UnitPercent || m["instance"] != nil {
+		t.Errorf("cpu_percent = %v", m)
+	}
+
+	perCore := cpuMetrics([]float64{10, 20, 30, 41}, true)
+	if len(perCore) != 5 {
+		t.Fatalf("per core = %v", perCore)
+	}
+	for i, want := range []float64{10, 20, 30, 41} {
+		instance := []string{"cpu0", "cpu1", "cpu2", "cpu3"}[i]
+		m, ok := perCore["cpu_percent_"+instance].(map[string]interface{})
+		if !ok || m["instance"] != instance || m["name"] != "cpu_percent" || m["label"] != "CPU "+instance || m["value_num"] != want {
+			t.Errorf("%s = %v", instance, m)
+		}
+	}
+	if m := perCore["cpu_percent"].(map[string]interface{}); m["value_num"] != 25.25 || m["instance"] != nil {
+		t.Errorf("overall from cores = %v", m)
+	}
+
+	if got := cpuMetrics(nil, true); len(got) != 0 {
+		t.Errorf("no samples = %v", got)
+	}
+}
@@ -0,0 +1,233 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+
+	plugin "observer/base"
+)
+
+// netStateFile, in the state directory, persists the previous counter sample so rates survive between runs.
+const netStateFile = "local_state.json"
+
+// defaultNetExclude hides loopback and container plumbing unless overridden.
+var defaultNetExclude = []string{"lo", "docker*", "veth*"}
+
+// netProvider lists interfaces and their counters; gopsutil in production.
+type netProvider interface {
+	Interfaces() (net.InterfaceStatList, error)
+	IOCounters(pernic bool) ([]net.IOCountersStat, error)
+}
+
+type gopsutilNet struct{}
+
+func (gopsutilNet) Interfaces() (net.InterfaceStatList, error) { return net.Interfaces() }
+func (gopsutilNet) IOCounters(pernic bool) ([]net.IOCountersStat, error) {
+	return net.IOCounters(pernic)
+}
+
+// netSample is one interface's counters at a point in time.
+type netSample struct {
+	BytesRecv   uint64 `json:"bytes_recv"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	PacketsRecv uint64 `json:"packets_recv"`
+	PacketsSent uint64 `json:"packets_sent"`
+	ErrIn       uint64 `json:"err_in"`
+	ErrOut      uint64 `json:"err_out"`
+	DropIn      uint64 `json:"drop_in"`
+	DropOut     uint64 `json:"drop_out"`
+}
+
+// netState is the persisted previous sample for every reported interface.
+type netState struct {
+	SampledAt  time.Time            `json:"sampled_at"`
+	Interfaces map[string]netSample `json:"interfaces"`
+}
+
+// counters returns the sample's values keyed by metric name, in a fixed order.
+func (s netSample) counters() []struct {
+	name  string
+	value uint64
+} {
+	return []struct {
+		name  string
+		value uint64
+	}{
+		{"bytes_recv", s.BytesRecv},
+		{"bytes_sent", s.BytesSent},
+		{"packets_recv", s.PacketsRecv},
+		{"packets_sent", s.PacketsSent},
+		{"errors_in", s.ErrIn},
+		{"errors_out", s.ErrOut},
+		{"drops_in", s.DropIn},
+		{"drops_out", s.DropOut},
+	}
+}
+
+// netRates returns per-second rates between two samples taken elapsed apart.
+// Counters that went backwards (reboot or wrap) are left out rather than reported negative.
+func netRates(prev, cur netSample, elapsed time.Duration) map[string]float64 {
+	rates := make(map[string]float64)
+	secs := elapsed.Seconds()
+	if secs <= 0 {
+		return rates
+	}
+	before := prev.counters()
+	for i, c := range cur.counters() {
+		if c.value < before[i].value {
+			continue
+		}
+		rates[c.name] = float64(c.value-before[i].value) / secs
+	}
+	return rates
+}
+
+// excludedInterface reports whether name matches any of the glob patterns.
+func excludedInterface(name string, patterns []string) bool {
+	for _, pat := range patterns {
+		if ok, err := path.Match(pat, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadNetState reads the previous sample. A missing or unreadable file yields an empty state.
+func loadNetState() *netState {
+	state := &netState{Interfaces: make(map[string]netSample)}
+	data, err := ioutil.ReadFile(plugin.StateFile(netStateFile))
+	if err != nil {
+		return state
+	}
+	if json.Unmarshal(data, state) != nil || state.Interfaces == nil {
+		state.Interfaces = make(map[string]netSample)
+	}
+	return state
+}
+
+// saveNetState writes the current sample back to disk.
+func saveNetState(state *netState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return plugin.WriteStateFile(netStateFile, data, 0644)
+}
+
+// hasFlag reports whether an interface carries the given flag.
+func hasFlag(iface net.InterfaceStat, flag string) bool {
+	for _, f := range iface.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// getNetwork returns per-interface counter, rate and link-state metrics, plus
+// the interface entity rows for the interfaces table.
+func (p *localPlugin) getNetwork(cfg plugin.LocalNetConfig, now time.Time) (map[string]interface{}, []map[string]interface{}, error) {
+	provider := p.nets
+	if provider == nil {
+		provider = gopsutilNet{}
+	}
+	ifaces, err := provider.Interfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+	counters, err := provider.IOCounters(true)
+	if err != nil {
+		return nil, nil, err
+	}
+	exclude := cfg.Exclude
+	if len(exclude) == 0 {
+		exclude = defaultNetExclude
+	}
+
+	samples := make(map[string]netSample, len(counters))
+	for _, c := range counters {
+		samples[c.Name] = netSample{
+			BytesRecv: c.BytesRecv, BytesSent: c.BytesSent,
+			PacketsRecv: c.PacketsRecv, PacketsSent: c.PacketsSent,
+			ErrIn: c.Errin, ErrOut: c.Errout,
+			DropIn: c.Dropin, DropOut: c.Dropout,
+		}
+	}
+
+	prev := loadNetState()
+	elapsed := now.Sub(prev.SampledAt)
+	next := &netState{SampledAt: now, Interfaces: make(map[string]netSample)}
+
+	metrics := make(map[string]interface{})
+	var rows []map[string]interface{}
+	for _, iface := range ifaces {
+		name := iface.Name
+		if excludedInterface(name, exclude) {
+			continue
+		}
+
+		oper := "down"
+		if hasFlag(iface, "up") {
+			oper = "up"
+		}
+		metrics["net_oper_"+name] = netMetric("oper_status", name, "status", oper)
+
+		ifType := 6 // ethernetCsmacd
+		if hasFlag(iface, "loopback") {
+			ifType = 24
+		}
+		rows = append(rows, map[string]interface{}{
+			"if_index":     strconv.Itoa(iface.Index),
+			"name":         name,
+			"type":         ifType,
+			"mac_address":  iface.HardwareAddr,
+			"admin_status": oper,
+			"oper_status":  oper,
+		})
+
+		sample, ok := samples[name]
+		if !ok {
+			continue
+		}
+		next.Interfaces[name] = sample
+		for _, c := range sample.counters() {
+			metrics["net_"+c.name+"_"+name] = netMetric(c.name, name, "counter", c.value)
+		}
+		if last, ok := prev.Interfaces[name]; ok {
+			for counter, rate := range netRates(last, sample, elapsed) {
+				metrics["net_"+counter+"_rate_"+name] = netMetric(counter+"_rate", name, "gauge", fmt.Sprintf("%.2f", rate))
+			}
+		}
+	}
+
+	if err := saveNetState(next); err != nil {
+		p.Controller.Printf("          !_ local: could not save network state: %v\n", err)
+	}
+	return metrics, rows, nil
+}
+
+func netMetric(name, iface, metricType string, value interface{}) map[string]interface{} {
+	m := map[string]interface{}{
+		"name":     name,
+		"label":    fmt.Sprintf("%s %s", name, iface),
+		"value":    value,
+		"type":     metricType,
+		"category": "network",
+		"instance": iface,
+	}
+	// Byte counters and their rates; packet, error and drop counts have no unit.
+	switch {
+	case strings.HasPrefix(name, "bytes_") && strings.HasSuffix(name, "_rate"):
+		m["unit"] = plugin.UnitBytesPerSec
+	case strings.HasPrefix(name, "bytes_"):
+		m["unit"] = plugin.UnitBytes
+	}
+	return m
+}
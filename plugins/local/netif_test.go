@@ -0,0 +1,137 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+// fakeNet serves fixture interfaces and counters.
+type fakeNet struct {
+	ifaces   net.InterfaceStatList
+	counters []net.IOCountersStat
+}
+
+func (f *fakeNet) Interfaces() (net.InterfaceStatList, error)           { return f.ifaces, nil }
+func (f *fakeNet) IOCounters(pernic bool) ([]net.IOCountersStat, error) { return f.counters, nil }
+
+func TestNetRates(t *testing.T) {
+	prev := netSample{BytesRecv: 1000, BytesSent: 500, PacketsRecv: 10, ErrIn: 5}
+	cur := netSample{BytesRecv: 3000, BytesSent: 400, PacketsRecv: 30, ErrIn: 5}
+	rates := netRates(prev, cur, 10*time.Second)
+	if rates["bytes_recv"] != 200 || rates["packets_recv"] != 2 || rates["errors_in"] != 0 {
+		t.Errorf("rates = %v", rates)
+	}
+	// A counter that went backwards (reboot, wrap) has no rate.
+	if _, ok := rates["bytes_sent"]; ok {
+		t.Errorf("bytes_sent rate %v after a reset", rates["bytes_sent"])
+	}
+	if rates := netRates(prev, cur, 0); len(rates) != 0 {
+		t.Errorf("rates over no time = %v", rates)
+	}
+}
+
+func TestExcludedInterface(t *testing.T) {
+	for name, want := range map[string]bool{
+		"lo":          true,
+		"docker0":     true,
+		"veth1a2b3c":  true,
+		"eth0":        false,
+		"lo0":         false, // patterns match the whole name
+		"br-docker":   false,
+		"wlp3s0":      false,
+		"enp0s31f6":   false,
+		"dockerish0":  true,
+		"vethernet42": true,
+	} {
+		if got := excludedInterface(name, defaultNetExclude); got != want {
+			t.Errorf("excludedInterface(%q) = %v", name, got)
+		}
+	}
+	if !excludedInterface("wlp3s0", []string{"wl*"}) || excludedInterface("lo", []string{"wl*"}) {
+		t.Error("custom patterns not applied")
+	}
+	if excludedInterface("eth0", []string{"[bad"}) {
+		t.Error("a malformed pattern excluded an interface")
+	}
+}
+
+func TestGetNetworkRatesAcrossRuns(t *testing.T) {
+	testenv.UseTempDirs(t)
+	provider := &fakeNet{
+		ifaces: net.InterfaceStatList{
+			{Index: 1, Name: "lo", Flags: []string{"up", "loopback"}},
+			{Index: 2, Name: "eth0", HardwareAddr: "aa:bb:cc:dd:ee:ff", Flags: []string{"up", "broadcast"}},
+			{Index: 3, Name: "eth1", Flags: []string{"broadcast"}},
+		},
+		counters: []net.IOCountersStat{
+			{Name: "lo", BytesRecv: 1},
+			{Name: "eth0", BytesRecv: 1000, BytesSent: 2000, PacketsRecv: 10},
+			{Name: "eth1"},
+		},
+	}
+	p := &localPlugin{nets: provider}
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	metrics, rows, err := p.getNetwork(plugin.LocalNetConfig{}, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := metrics["net_bytes_recv_rate_eth0"]; ok {
+		t.Error("rate on the first sample")
+	}
+	if m := metrics["net_bytes_recv_eth0"].(map[string]interface{}); m["value"] != uint64(1000) || m["type"] != "counter" || m["unit"] != plugin.UnitBytes {
+		t.Errorf("bytes_recv = %v", m)
+	}
+	if _, ok := metrics["net_oper_lo"]; ok {
+		t.Error("lo not excluded")
+	}
+	if m := metrics["net_oper_eth1"].(map[string]interface{}); m["value"] != "down" || m["type"] != "status" {
+		t.Errorf("eth1 oper = %v", m)
+	}
+	if len(rows) != 2 || rows[0]["name"] != "eth0" || rows[0]["if_index"] != "2" || rows[0]["mac_address"] != "aa:bb:cc:dd:ee:ff" || rows[1]["oper_status"] != "down" {
+		t.Errorf("rows = %v", rows)
+	}
+
+	// Second run a minute later, from the persisted state.
+	provider.counters[1] = net.IOCountersStat{Name: "eth0", BytesRecv: 7000, BytesSent: 1000, PacketsRecv: 130}
+	metrics, _, err = p.getNetwork(plugin.LocalNetConfig{}, t0.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rate := func(key string) interface{} {
+		m, ok := metrics[key].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		return m["value"]
+	}
+	if v := rate("net_bytes_recv_rate_eth0"); v != "100.00" {
+		t.Errorf("bytes_recv_rate = %v", v)
+	}
+	if v := rate("net_packets_recv_rate_eth0"); v != "2.00" {
+		t.Errorf("packets_recv_rate = %v", v)
+	}
+	if v := rate("net_bytes_sent_rate_eth0"); v != nil {
+		t.Errorf("bytes_sent_rate after a counter reset = %v", v)
+	}
+	if m := metrics["net_bytes_recv_rate_eth0"].(map[string]interface{}); m["unit"] != plugin.UnitBytesPerSec || m["instance"] != "eth0" {
+		t.Errorf("rate metric = %v", m)
+	}
+}
+
+func TestNetStateSurvivesCorruption(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	if err := os.WriteFile(filepath.Join(dir, netStateFile), []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if state := loadNetState(); state.Interfaces == nil || len(state.Interfaces) != 0 {
+		t.Errorf("state = %+v", state)
+	}
+}
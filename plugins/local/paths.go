@@ -0,0 +1,123 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	plugin "observer/base"
+)
+
+// statPath returns the file named by check, or the newest match when it is a glob.
+// Permission errors are returned as fs.ErrPermission so they are not mistaken for absence.
+func statPath(check plugin.PathCheck) (fs.FileInfo, error) {
+	if !check.Glob {
+		return os.Stat(check.Path)
+	}
+
+	matches, err := filepath.Glob(check.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		// Glob hides I/O errors; an unreadable directory would otherwise look empty.
+		if _, err := os.ReadDir(filepath.Dir(check.Path)); err != nil && errors.Is(err, fs.ErrPermission) {
+			return nil, err
+		}
+		return nil, fs.ErrNotExist
+	}
+
+	var newest fs.FileInfo
+	var firstErr error
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if newest == nil || info.ModTime().After(newest.ModTime()) {
+			newest = info
+		}
+	}
+	if newest == nil {
+		return nil, firstErr
+	}
+	return newest, nil
+}
+
+// checkPath returns the exists, age_seconds, size_bytes and combined status metrics for one path.
+func checkPath(check plugin.PathCheck, now time.Time) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	path := check.Path
+
+	info, err := statPath(check)
+	if err != nil {
+		reason := err.Error()
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			reason = "not found"
+		case errors.Is(err, fs.ErrPermission):
+			reason = "permission denied"
+		}
+		exists := pathMetric("exists", path, "status", "down")
+		exists["reason"] = reason
+		metrics["path_exists_"+path] = exists
+		status := pathMetric("path", path, "status", "down")
+		status["reason"] = reason
+		metrics["path_status_"+path] = status
+		return metrics
+	}
+
+	age := now.Sub(info.ModTime())
+	metrics["path_exists_"+path] = pathMetric("exists", path, "status", "up")
+	metrics["path_age_"+path] = pathMetric("age_seconds", path, "gauge", int64(age.Seconds()))
+	metrics["path_size_"+path] = pathMetric("size_bytes", path, "gauge", info.Size())
+
+	status := pathMetric("path", path, "status", "up")
+	if check.MaxAge != "" {
+		maxAge, err := time.ParseDuration(check.MaxAge)
+		if err != nil {
+			status["value"] = "warning"
+			status["reason"] = fmt.Sprintf("invalid max_age %q", check.MaxAge)
+		} else if age > maxAge {
+			status["value"] = "down"
+			status["reason"] = fmt.Sprintf("older than %s", check.MaxAge)
+		}
+	}
+	if check.MinSize > 0 && info.Size() < check.MinSize && status["value"] != "down" {
+		status["value"] = "down"
+		status["reason"] = fmt.Sprintf("smaller than %d bytes", check.MinSize)
+	}
+	metrics["path_status_"+path] = status
+	return metrics
+}
+
+// getPaths runs every configured path check.
+func (p *localPlugin) getPaths(checks []plugin.PathCheck, now time.Time) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	for _, check := range checks {
+		if check.Path == "" {
+			continue
+		}
+		for k, v := range checkPath(check, now) {
+			metrics[k] = v
+		}
+	}
+	return metrics
+}
+
+func pathMetric(name, path, metricType string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    fmt.Sprintf("%s %s", name, path),
+		"value":    value,
+		"type":     metricType,
+		"category": "paths",
+		"instance": path,
+	}
+}
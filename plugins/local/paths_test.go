@@ -0,0 +1,145 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+)
+
+// writeAged creates path with size bytes and an mtime age before now.
+func writeAged(t *testing.T, path string, size int, age time.Duration, now time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	at := now.Add(-age)
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	dump := filepath.Join(dir, "db.dump")
+	writeAged(t, dump, 2048, 2*time.Hour, now)
+
+	for _, tc := range []struct {
+		name   string
+		check  plugin.PathCheck
+		status string
+		reason string
+	}{
+		{"no thresholds", plugin.PathCheck{Path: dump}, "up", ""},
+		{"fresh and big enough", plugin.PathCheck{Path: dump, MaxAge: "24h", MinSize: 1024}, "up", ""},
+		{"too old", plugin.PathCheck{Path: dump, MaxAge: "1h"}, "down", "older than 1h"},
+		{"too small", plugin.PathCheck{Path: dump, MinSize: 4096}, "down", "smaller than 4096 bytes"},
+		{"old and small", plugin.PathCheck{Path: dump, MaxAge: "1h", MinSize: 4096}, "down", "older than 1h"},
+		{"bad max_age", plugin.PathCheck{Path: dump, MaxAge: "a day"}, "warning", `invalid max_age "a day"`},
+		{"bad max_age and small", plugin.PathCheck{Path: dump, MaxAge: "a day", MinSize: 4096}, "down", "smaller than 4096 bytes"},
+	} {
+		metrics := checkPath(tc.check, now)
+		status := metrics["path_status_"+dump].(map[string]interface{})
+		reason, _ := status["reason"].(string)
+		if status["value"] != tc.status || reason != tc.reason {
+			t.Errorf("%s: status %v (%q), want %s (%q)", tc.name, status["value"], reason, tc.status, tc.reason)
+		}
+		if m := metrics["path_exists_"+dump].(map[string]interface{}); m["value"] != "up" || m["instance"] != dump {
+			t.Errorf("%s: exists = %v", tc.name, m)
+		}
+		if age := metrics["path_age_"+dump].(map[string]interface{})["value"].(int64); age < 7199 || age > 7201 {
+			t.Errorf("%s: age_seconds = %d", tc.name, age)
+		}
+		if size := metrics["path_size_"+dump].(map[string]interface{})["value"]; size != int64(2048) {
+			t.Errorf("%s: size_bytes = %v", tc.name, size)
+		}
+	}
+}
+
+func TestCheckPathMissing(t *testing.T) {
+	dir := t.TempDir()
+	for _, check := range []plugin.PathCheck{
+		{Path: filepath.Join(dir, "absent.dump")},
+		{Path: filepath.Join(dir, "*.dump"), Glob: true},
+	} {
+		metrics := checkPath(check, time.Now())
+		if len(metrics) != 2 {
+			t.Errorf("%s: metrics = %v", check.Path, metrics)
+		}
+		for _, key := range []string{"path_exists_", "path_status_"} {
+			m := metrics[key+check.Path].(map[string]interface{})
+			if m["value"] != "down" || m["reason"] != "not found" {
+				t.Errorf("%s%s = %v", key, check.Path, m)
+			}
+		}
+	}
+}
+
+func TestCheckPathGlobNewest(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeAged(t, filepath.Join(dir, "db-1.dump"), 5000, 72*time.Hour, now)
+	writeAged(t, filepath.Join(dir, "db-3.dump"), 100, 30*time.Minute, now)
+	writeAged(t, filepath.Join(dir, "db-2.dump"), 5000, 48*time.Hour, now)
+	writeAged(t, filepath.Join(dir, "notes.txt"), 1, 0, now)
+
+	pattern := filepath.Join(dir, "db-*.dump")
+	metrics := checkPath(plugin.PathCheck{Path: pattern, Glob: true, MaxAge: "24h", MinSize: 1000}, now)
+	// The newest dump is recent but truncated.
+	if size := metrics["path_size_"+pattern].(map[string]interface{})["value"]; size != int64(100) {
+		t.Errorf("size of newest match = %v", size)
+	}
+	status := metrics["path_status_"+pattern].(map[string]interface{})
+	if status["value"] != "down" || status["reason"] != "smaller than 1000 bytes" {
+		t.Errorf("status = %v", status)
+	}
+
+	// Without Glob the pattern is a literal file name.
+	if m := checkPath(plugin.PathCheck{Path: pattern}, now)["path_exists_"+pattern].(map[string]interface{}); m["value"] != "down" {
+		t.Errorf("literal pattern = %v", m)
+	}
+}
+
+func TestCheckPathPermission(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root bypasses directory permissions")
+	}
+	dir := filepath.Join(t.TempDir(), "private")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeAged(t, filepath.Join(dir, "db.dump"), 10, 0, time.Now())
+	if err := os.Chmod(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+
+	for _, check := range []plugin.PathCheck{
+		{Path: filepath.Join(dir, "db.dump")},
+		{Path: filepath.Join(dir, "*.dump"), Glob: true},
+	} {
+		m := checkPath(check, time.Now())["path_exists_"+check.Path].(map[string]interface{})
+		if m["value"] != "down" || m["reason"] != "permission denied" {
+			t.Errorf("%s = %v", check.Path, m)
+		}
+	}
+}
+
+func TestGetPaths(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeAged(t, a, 1, 0, now)
+
+	p := &localPlugin{}
+	metrics := p.getPaths([]plugin.PathCheck{{Path: a}, {Path: b}, {}}, now)
+	if len(metrics) != 6 {
+		t.Errorf("metrics = %v", metrics)
+	}
+	if metrics["path_status_"+a].(map[string]interface{})["value"] != "up" || metrics["path_status_"+b].(map[string]interface{})["value"] != "down" {
+		t.Errorf("statuses = %v, %v", metrics["path_status_"+a], metrics["path_status_"+b])
+	}
+}
@@ -0,0 +1,169 @@
+package local
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	plugin "observer/base"
+)
+
+// procInfo is the cheap per-process data read for every process on the host.
+type procInfo struct {
+	PID     int32
+	Cmdline string
+	Zombie  bool
+}
+
+// procLister lists processes and, for the few that match a watch, reads their usage.
+// gopsutil in production.
+type procLister interface {
+	List() ([]procInfo, error)
+	Usage(pid int32) (rss uint64, cpuPercent float64, err error)
+}
+
+type gopsutilProcs struct{}
+
+func (gopsutilProcs) List() ([]procInfo, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]procInfo, 0, len(procs))
+	for _, proc := range procs {
+		info := procInfo{PID: proc.Pid}
+		if cmd, err := proc.Cmdline(); err == nil && cmd != "" {
+			info.Cmdline = cmd
+		} else if name, err := proc.Name(); err == nil {
+			info.Cmdline = name // kernel threads have no command line
+		}
+		if status, err := proc.Status(); err == nil && len(status) > 0 {
+			info.Zombie = status[0] == process.Zombie
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+func (gopsutilProcs) Usage(pid int32) (uint64, float64, error) {
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+	mem, err := proc.MemoryInfo()
+	if err != nil {
+		return 0, 0, err
+	}
+	cpuPct, err := proc.CPUPercent()
+	if err != nil {
+		return 0, 0, err
+	}
+	return mem.RSS, cpuPct, nil
+}
+
+// procMatcher is a compiled ProcessWatch.
+type procMatcher struct {
+	watch plugin.ProcessWatch
+	re    *regexp.Regexp
+}
+
+func (m procMatcher) matches(cmdline string) bool {
+	if m.re != nil {
+		return m.re.MatchString(cmdline)
+	}
+	return strings.Contains(cmdline, m.watch.Name)
+}
+
+// procTotals accumulates one watch's matches.
+type procTotals struct {
+	count int
+	rss   uint64
+	cpu   float64
+}
+
+// getProcesses returns host-wide process totals plus count, RSS, CPU and status
+// per watched entry. The process table is walked once for all watches.
+func (p *localPlugin) getProcesses(watches []plugin.ProcessWatch) (map[string]interface{}, error) {
+	lister := p.procs
+	if lister == nil {
+		lister = gopsutilProcs{}
+	}
+	procs, err := lister.List()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make(map[string]interface{})
+	var matchers []procMatcher
+	for _, w := range watches {
+		if w.Name == "" {
+			continue
+		}
+		m := procMatcher{watch: w}
+		if w.Match != "" {
+			re, err := regexp.Compile(w.Match)
+			if err != nil {
+				metrics["proc_status_"+w.Name] = p.errorMetric("Process "+w.Name, "process", err)
+				continue
+			}
+			m.re = re
+		}
+		matchers = append(matchers, m)
+	}
+
+	totals := make([]procTotals, len(matchers))
+	zombies := 0
+	for _, proc := range procs {
+		if proc.Zombie {
+			zombies++
+			continue
+		}
+		for i, m := range matchers {
+			if !m.matches(proc.Cmdline) {
+				continue
+			}
+			totals[i].count++
+			if rss, cpuPct, err := lister.Usage(proc.PID); err == nil {
+				totals[i].rss += rss
+				totals[i].cpu += cpuPct
+			}
+		}
+	}
+
+	metrics["processes_total"] = procMetric("processes_total", "", "gauge", len(procs))
+	metrics["processes_zombie"] = procMetric("processes_zombie", "", "gauge", zombies)
+
+	for i, m := range matchers {
+		name, t := m.watch.Name, totals[i]
+		minCount := m.watch.MinCount
+		if minCount <= 0 {
+			minCount = 1
+		}
+		status := "up"
+		if t.count < minCount {
+			status = "down"
+		}
+		metrics["proc_count_"+name] = procMetric("count", name, "gauge", t.count)
+		metrics["proc_rss_"+name] = procMetric("rss_bytes", name, "gauge", t.rss)
+		metrics["proc_cpu_"+name] = procMetric("cpu_percent", name, "percent", fmt.Sprintf("%.2f", t.cpu))
+		metrics["proc_status_"+name] = procMetric("process", name, "status", status)
+	}
+	return metrics, nil
+}
+
+func procMetric(name, instance, metricType string, value interface{}) map[string]interface{} {
+	m := map[string]interface{}{
+		"name":     name,
+		"label":    name,
+		"value":    value,
+		"type":     metricType,
+		"category": "process",
+	}
+	if instance != "" {
+		m["label"] = fmt.Sprintf("%s %s", name, instance)
+		m["instance"] = instance
+	}
+	return m
+}
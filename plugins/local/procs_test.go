@@ -0,0 +1,168 @@
+package local
+
+import (
+	"errors"
+	"testing"
+
+	plugin "observer/base"
+)
+
+// fakeProcs serves a fixture process table and per-PID usage; Usage calls are
+// counted so tests can check only matched processes are inspected.
+type fakeProcs struct {
+	procs  []procInfo
+	usage  map[int32][2]float64 // rss, cpu
+	err    error
+	usages int
+}
+
+func (f *fakeProcs) List() ([]procInfo, error) { return f.procs, f.err }
+
+func (f *fakeProcs) Usage(pid int32) (uint64, float64, error) {
+	f.usages++
+	u, ok := f.usage[pid]
+	if !ok {
+		return 0, 0, errors.New("process exited")
+	}
+	return uint64(u[0]), u[1], nil
+}
+
+func fixtureProcs() *fakeProcs {
+	return &fakeProcs{
+		procs: []procInfo{
+			{PID: 1, Cmdline: "/sbin/init"},
+			{PID: 100, Cmdline: "nginx: master process /usr/sbin/nginx -g daemon on;"},
+			{PID: 101, Cmdline: "nginx: worker process"},
+			{PID: 102, Cmdline: "nginx: worker process"},
+			{PID: 200, Cmdline: "/usr/bin/python3 /opt/app/worker.py --queue=mail"},
+			{PID: 201, Cmdline: "/usr/bin/python3 /opt/app/worker.py --queue=sms"},
+			{PID: 300, Cmdline: "[kworker/0:1]"},
+			{PID: 400, Cmdline: "nginx: worker process", Zombie: true},
+			{PID: 401, Cmdline: "defunct", Zombie: true},
+		},
+		usage: map[int32][2]float64{
+			100: {10 << 20, 0.5},
+			101: {20 << 20, 12.25},
+			// 102 exits between listing and reading usage
+			200: {50 << 20, 3},
+			201: {60 << 20, 4},
+		},
+	}
+}
+
+func TestGetProcesses(t *testing.T) {
+	procs := fixtureProcs()
+	p := &localPlugin{procs: procs}
+	metrics, err := p.getProcesses([]plugin.ProcessWatch{
+		{Name: "nginx", MinCount: 2},
+		{Name: "mailq", Match: `worker\.py .*--queue=mail\b`},
+		{Name: "postgres"},
+		{Name: "bad", Match: "("},
+		{}, // unnamed entries are ignored
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := func(key string) interface{} {
+		t.Helper()
+		m, ok := metrics[key].(map[string]interface{})
+		if !ok {
+			t.Fatalf("no %s metric in %v", key, metrics)
+		}
+		return m["value"]
+	}
+	if got := value("processes_total"); got != 9 {
+		t.Errorf("processes_total = %v", got)
+	}
+	if got := value("processes_zombie"); got != 2 {
+		t.Errorf("processes_zombie = %v", got)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		count  int
+		rss    uint64
+		cpu    string
+		status string
+	}{
+		{"nginx", 3, 30 << 20, "12.75", "up"}, // the zombie worker is not counted
+		{"mailq", 1, 50 << 20, "3.00", "up"},  // the sms worker does not match
+		{"postgres", 0, 0, "0.00", "down"},
+	} {
+		if got := value("proc_count_" + tc.name); got != tc.count {
+			t.Errorf("%s count = %v, want %d", tc.name, got, tc.count)
+		}
+		if got := value("proc_rss_" + tc.name); got != tc.rss {
+			t.Errorf("%s rss = %v, want %d", tc.name, got, tc.rss)
+		}
+		if got := value("proc_cpu_" + tc.name); got != tc.cpu {
+			t.Errorf("%s cpu = %v, want %s", tc.name, got, tc.cpu)
+		}
+		if got := value("proc_status_" + tc.name); got != tc.status {
+			t.Errorf("%s status = %v, want %s", tc.name, got, tc.status)
+		}
+		if m := metrics["proc_status_"+tc.name].(map[string]interface{}); m["instance"] != tc.name || m["type"] != "status" {
+			t.Errorf("%s status metric = %v", tc.name, m)
+		}
+	}
+	if m := metrics["proc_status_bad"].(map[string]interface{}); m["type"] != "text" {
+		t.Errorf("invalid regex = %v", m)
+	}
+	if _, ok := metrics["proc_count_bad"]; ok {
+		t.Error("invalid regex still produced a count")
+	}
+	// Only the four matching live processes had their usage read.
+	if procs.usages != 4 {
+		t.Errorf("Usage called %d times, want 4", procs.usages)
+	}
+}
+
+func TestGetProcessesMinCount(t *testing.T) {
+	p := &localPlugin{procs: fixtureProcs()}
+	for _, tc := range []struct {
+		minCount int
+		want     string
+	}{
+		{0, "up"}, // defaults to 1
+		{3, "up"},
+		{4, "down"},
+	} {
+		metrics, err := p.getProcesses([]plugin.ProcessWatch{{Name: "nginx", MinCount: tc.minCount}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := metrics["proc_status_nginx"].(map[string]interface{})["value"]; got != tc.want {
+			t.Errorf("min_count %d: status %v, want %s", tc.minCount, got, tc.want)
+		}
+	}
+}
+
+func TestGetProcessesLargeTable(t *testing.T) {
+	procs := &fakeProcs{usage: map[int32][2]float64{}}
+	for i := int32(0); i < 5000; i++ {
+		cmd := "/usr/lib/systemd/systemd-userdbd"
+		if i%1000 == 0 {
+			cmd = "/usr/sbin/sshd -D"
+		}
+		procs.procs = append(procs.procs, procInfo{PID: i, Cmdline: cmd})
+	}
+	p := &localPlugin{procs: procs}
+	metrics, err := p.getProcesses([]plugin.ProcessWatch{{Name: "sshd"}, {Name: "cron"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := metrics["proc_count_sshd"].(map[string]interface{})["value"]; got != 5 {
+		t.Errorf("sshd count = %v", got)
+	}
+	if procs.usages != 5 {
+		t.Errorf("Usage called %d times, want 5", procs.usages)
+	}
+}
+
+func TestGetProcessesListError(t *testing.T) {
+	p := &localPlugin{procs: &fakeProcs{err: errors.New("/proc not mounted")}}
+	if _, err := p.getProcesses([]plugin.ProcessWatch{{Name: "nginx"}}); err == nil {
+		t.Error("expected the list error")
+	}
+}
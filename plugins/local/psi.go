@@ -0,0 +1,67 @@
+package local
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	plugin "observer/base"
+)
+
+// psiDir holds the pressure stall files on Linux 4.20+.
+var psiDir = "/proc/pressure"
+
+// psiResources are the files read from psiDir; each becomes a metric instance.
+var psiResources = []string{"cpu", "memory", "io"}
+
+// parsePSI parses one pressure file into values keyed "some_avg10", "full_avg60", ….
+// Older kernels have no "full" line for cpu; it is simply absent from the result.
+func parsePSI(content string) map[string]float64 {
+	values := make(map[string]float64)
+	sc := bufio.NewScanner(strings.NewReader(content))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		kind := fields[0] // "some" or "full"
+		for _, f := range fields[1:] {
+			key, value, ok := strings.Cut(f, "=")
+			if !ok || !strings.HasPrefix(key, "avg") {
+				continue
+			}
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				values[kind+"_"+key] = n
+			}
+		}
+	}
+	return values
+}
+
+// getPressure returns PSI averages per resource. Kernels or platforms without
+// PSI produce no metrics.
+func (p *localPlugin) getPressure() map[string]interface{} {
+	metrics := make(map[string]interface{})
+	for _, res := range psiResources {
+		data, err := os.ReadFile(filepath.Join(psiDir, res))
+		if err != nil {
+			continue
+		}
+		for name, v := range parsePSI(string(data)) {
+			metrics["psi_"+name+"_"+res] = map[string]interface{}{
+				"name":      "psi_" + name,
+				"label":     fmt.Sprintf("psi_%s %s", name, res),
+				"value":     fmt.Sprintf("%.2f", v),
+				"value_num": v,
+				"type":      "gauge",
+				"category":  "pressure",
+				"unit":      plugin.UnitPercent,
+				"instance":  res,
+			}
+		}
+	}
+	return metrics
+}
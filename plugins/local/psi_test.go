@@ -0,0 +1,80 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	plugin "observer/base"
+)
+
+const (
+	psiCPU = "some avg10=1.52 avg60=0.87 avg300=0.31 total=123456789\n"
+	psiMem = "some avg10=0.00 avg60=0.12 avg300=0.05 total=4567\n" +
+		"full avg10=0.00 avg60=0.03 avg300=0.01 total=1234\n"
+)
+
+func TestParsePSI(t *testing.T) {
+	got := parsePSI(psiMem)
+	want := map[string]float64{
+		"some_avg10": 0, "some_avg60": 0.12, "some_avg300": 0.05,
+		"full_avg10": 0, "full_avg60": 0.03, "full_avg300": 0.01,
+	}
+	if len(got) != len(want) {
+		t.Errorf("parsed %v", got)
+	}
+	for k, v := range want {
+		if n, ok := got[k]; !ok || n != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+
+	// Kernels before 5.13 print no "full" line for cpu.
+	cpu := parsePSI(psiCPU)
+	if len(cpu) != 3 || cpu["some_avg10"] != 1.52 {
+		t.Errorf("cpu parsed %v", cpu)
+	}
+	if _, ok := cpu["full_avg10"]; ok {
+		t.Error("full line invented for cpu")
+	}
+
+	if got := parsePSI("some avg10=bogus total=1\n\n"); len(got) != 0 {
+		t.Errorf("malformed parsed %v", got)
+	}
+}
+
+// usePSIDir points psiDir at a temp directory holding files.
+func usePSIDir(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := psiDir
+	psiDir = dir
+	t.Cleanup(func() { psiDir = old })
+}
+
+func TestGetPressure(t *testing.T) {
+	usePSIDir(t, map[string]string{"cpu": psiCPU, "memory": psiMem}) // no io file
+	metrics := (&localPlugin{}).getPressure()
+	if len(metrics) != 9 {
+		t.Fatalf("metrics = %v", metrics)
+	}
+	m := metrics["psi_some_avg10_cpu"].(map[string]interface{})
+	if m["value"] != "1.52" || m["value_num"] != 1.52 || m["instance"] != "cpu" || m["name"] != "psi_some_avg10" ||
+		m["unit"] != plugin.UnitPercent || m["type"] != "gauge" {
+		t.Errorf("cpu some avg10 = %v", m)
+	}
+	if m := metrics["psi_full_avg60_memory"].(map[string]interface{}); m["value_num"] != 0.03 || m["instance"] != "memory" {
+		t.Errorf("memory full avg60 = %v", m)
+	}
+
+	// Kernels and platforms without PSI produce nothing.
+	usePSIDir(t, nil)
+	if metrics := (&localPlugin{}).getPressure(); len(metrics) != 0 {
+		t.Errorf("no PSI: %v", metrics)
+	}
+}
@@ -0,0 +1,101 @@
+package local
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/shirou/gopsutil/v3/host"
+
+	plugin "observer/base"
+)
+
+const defaultWarningCelsius = 80.0
+
+// sensorProvider reads hardware temperatures; gopsutil in production.
+type sensorProvider interface {
+	Temperatures() ([]host.TemperatureStat, error)
+}
+
+type gopsutilSensors struct{}
+
+func (gopsutilSensors) Temperatures() ([]host.TemperatureStat, error) {
+	return host.SensorsTemperatures()
+}
+
+// getSensors returns per-sensor temperatures, the hottest reading, and a status
+// against the warning threshold. Hosts without readable sensors (VMs, containers,
+// unsupported platforms) get no metrics at all rather than an error every cycle.
+func (p *localPlugin) getSensors(cfg plugin.LocalSensorsConfig) map[string]interface{} {
+	provider := p.sensors
+	if provider == nil {
+		provider = gopsutilSensors{}
+	}
+	// gopsutil reports partial reads as warnings alongside the readings it did get.
+	temps, _ := provider.Temperatures()
+	if len(temps) == 0 {
+		return nil
+	}
+
+	warn := cfg.WarningCelsius
+	if warn <= 0 {
+		warn = defaultWarningCelsius
+	}
+
+	// Several chips expose the same label (e.g. one "acpitz" per zone); keep the hottest.
+	byKey := make(map[string]float64)
+	for _, t := range temps {
+		if t.SensorKey == "" {
+			continue
+		}
+		if cur, ok := byKey[t.SensorKey]; !ok || t.Temperature > cur {
+			byKey[t.SensorKey] = t.Temperature
+		}
+	}
+	if len(byKey) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	metrics := make(map[string]interface{})
+	maxTemp := byKey[keys[0]]
+	for _, k := range keys {
+		t := byKey[k]
+		if t > maxTemp {
+			maxTemp = t
+		}
+		metrics["temperature_"+k] = sensorMetric("temperature", k, "gauge", t)
+	}
+
+	status := "up"
+	if maxTemp >= warn {
+		status = "warning"
+	}
+	metrics["max_temperature"] = sensorMetric("max_temperature", "", "gauge", maxTemp)
+	metrics["temperature_status"] = sensorMetric("temperature", "", "status", status)
+	return metrics
+}
+
+func sensorMetric(name, instance, metricType string, value interface{}) map[string]interface{} {
+	m := map[string]interface{}{
+		"name":     name,
+		"label":    name,
+		"value":    value,
+		"type":     metricType,
+		"category": "sensors",
+	}
+	if t, ok := value.(float64); ok {
+		m["value"] = fmt.Sprintf("%.1f", t)
+		m["value_num"] = t
+		m["unit"] = plugin.UnitCelsius
+	}
+	if instance != "" {
+		m["label"] = fmt.Sprintf("%s %s", name, instance)
+		m["instance"] = instance
+	}
+	return m
+}
@@ -0,0 +1,91 @@
+package local
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/host"
+
+	plugin "observer/base"
+)
+
+type fakeSensors struct {
+	temps []host.TemperatureStat
+	err   error
+}
+
+func (f fakeSensors) Temperatures() ([]host.TemperatureStat, error) { return f.temps, f.err }
+
+func TestGetSensors(t *testing.T) {
+	p := &localPlugin{sensors: fakeSensors{temps: []host.TemperatureStat{
+		{SensorKey: "coretemp_core_0", Temperature: 48},
+		{SensorKey: "coretemp_core_1", Temperature: 51.25},
+		{SensorKey: "acpitz", Temperature: 27.8},
+		{SensorKey: "acpitz", Temperature: 40}, // same label from a second zone
+		{SensorKey: "", Temperature: 99},       // unlabelled readings are dropped
+	}}}
+
+	metrics := p.getSensors(plugin.LocalSensorsConfig{})
+	if len(metrics) != 5 {
+		t.Fatalf("metrics = %v", metrics)
+	}
+	for key, want := range map[string]string{
+		"temperature_coretemp_core_0": "48.0",
+		"temperature_coretemp_core_1": "51.2",
+		"temperature_acpitz":          "40.0",
+		"max_temperature":             "51.2",
+		"temperature_status":          "up",
+	} {
+		m, ok := metrics[key].(map[string]interface{})
+		if !ok || m["value"] != want {
+			t.Errorf("%s = %v, want %s", key, metrics[key], want)
+		}
+	}
+	m := metrics["temperature_acpitz"].(map[string]interface{})
+	if m["instance"] != "acpitz" || m["value_num"] != 40.0 || m["unit"] != plugin.UnitCelsius || m["type"] != "gauge" {
+		t.Errorf("acpitz = %v", m)
+	}
+	if m := metrics["temperature_status"].(map[string]interface{}); m["type"] != "status" || m["unit"] != nil {
+		t.Errorf("status = %v", m)
+	}
+}
+
+func TestGetSensorsThreshold(t *testing.T) {
+	temps := []host.TemperatureStat{{SensorKey: "cpu_thermal", Temperature: 82}}
+	for _, tc := range []struct {
+		warn float64
+		want string
+	}{
+		{0, "warning"}, // default 80
+		{82, "warning"},
+		{85, "up"},
+	} {
+		p := &localPlugin{sensors: fakeSensors{temps: temps}}
+		metrics := p.getSensors(plugin.LocalSensorsConfig{WarningCelsius: tc.warn})
+		if got := metrics["temperature_status"].(map[string]interface{})["value"]; got != tc.want {
+			t.Errorf("warning_celsius %v: status %v, want %s", tc.warn, got, tc.want)
+		}
+	}
+}
+
+func TestGetSensorsUnavailable(t *testing.T) {
+	for name, provider := range map[string]fakeSensors{
+		"unsupported platform": {err: errors.New("not implemented yet")},
+		"no sensors":           {},
+		"no labels":            {temps: []host.TemperatureStat{{Temperature: 30}}},
+	} {
+		p := &localPlugin{sensors: provider}
+		if metrics := p.getSensors(plugin.LocalSensorsConfig{}); metrics != nil {
+			t.Errorf("%s: metrics = %v", name, metrics)
+		}
+	}
+
+	// Partial reads come back as a warning alongside the readings that worked.
+	p := &localPlugin{sensors: fakeSensors{
+		temps: []host.TemperatureStat{{SensorKey: "nvme_composite", Temperature: 38}},
+		err:   errors.New("number of warnings: 1"),
+	}}
+	if metrics := p.getSensors(plugin.LocalSensorsConfig{}); metrics["temperature_nvme_composite"] == nil {
+		t.Errorf("partial read dropped: %v", metrics)
+	}
+}
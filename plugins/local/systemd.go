@@ -0,0 +1,133 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	plugin "observer/base"
+)
+
+// commandRunner runs an external command and returns its standard output.
+type commandRunner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+// systemdRunning reports whether the host was booted with systemd.
+func systemdRunning() bool {
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return false
+	}
+	_, err := exec.LookPath("systemctl")
+	return err == nil
+}
+
+// unitState is the parsed output of `systemctl show` for one unit.
+type unitState struct {
+	LoadState   string
+	ActiveState string
+	SubState    string
+	NRestarts   int
+}
+
+// parseUnitShow reads the key=value lines printed by `systemctl show -p ...`.
+// Keys are matched by name because systemctl does not keep the -p order.
+func parseUnitShow(out []byte) unitState {
+	var st unitState
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "LoadState":
+			st.LoadState = value
+		case "ActiveState":
+			st.ActiveState = value
+		case "SubState":
+			st.SubState = value
+		case "NRestarts":
+			st.NRestarts, _ = strconv.Atoi(value)
+		}
+	}
+	return st
+}
+
+// unitStatus maps a systemd ActiveState to up/warning/down.
+func unitStatus(active string) string {
+	switch active {
+	case "active", "reloading":
+		return "up"
+	case "activating", "deactivating":
+		return "warning"
+	default: // failed, inactive
+		return "down"
+	}
+}
+
+// getSystemdUnits returns a status and restart count per configured unit. Hosts
+// without systemd get no metrics; a unit that cannot be queried or does not exist
+// is reported down with the reason, leaving the other units unaffected. On
+// Windows the units are service names checked with `sc query` instead.
+func (p *localPlugin) getSystemdUnits(units []string) map[string]interface{} {
+	if len(units) == 0 {
+		return nil
+	}
+	runner := p.runner
+	if plugin.GOOS == "windows" {
+		if runner == nil {
+			runner = execRunner{}
+		}
+		return getWindowsServices(runner, units)
+	}
+	if runner == nil {
+		if !systemdRunning() {
+			return nil
+		}
+		runner = execRunner{}
+	}
+
+	metrics := make(map[string]interface{})
+	for _, unit := range units {
+		out, err := runner.Run("systemctl", "show", "-p", "LoadState,ActiveState,SubState,NRestarts", unit)
+		if err != nil {
+			status := unitMetric("unit", unit, "status", "down")
+			status["reason"] = fmt.Sprintf("systemctl show failed: %v", err)
+			metrics["unit_status_"+unit] = status
+			continue
+		}
+
+		st := parseUnitShow(out)
+		status := unitMetric("unit", unit, "status", unitStatus(st.ActiveState))
+		status["sub_state"] = st.SubState
+		if st.LoadState == "not-found" {
+			status["value"] = "down"
+			status["reason"] = "unit not found"
+		}
+		metrics["unit_status_"+unit] = status
+		metrics["unit_restarts_"+unit] = unitMetric("restart_count", unit, "counter", st.NRestarts)
+	}
+	return metrics
+}
+
+func unitMetric(name, unit, metricType string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    fmt.Sprintf("%s %s", name, unit),
+		"value":    value,
+		"type":     metricType,
+		"category": "systemd",
+		"instance": unit,
+	}
+}
@@ -0,0 +1,120 @@
+package local
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	plugin "observer/base"
+)
+
+// fakeRunner answers commands from fixture output keyed by the full command
+// line; anything else fails like a missing binary. Commands run are recorded.
+type fakeRunner struct {
+	out  map[string]string
+	errs map[string]error
+	ran  []string
+}
+
+func (f *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	f.ran = append(f.ran, cmd)
+	if err, ok := f.errs[cmd]; ok {
+		return []byte(f.out[cmd]), err
+	}
+	out, ok := f.out[cmd]
+	if !ok {
+		return nil, errors.New("exec: " + name + ": not found")
+	}
+	return []byte(out), nil
+}
+
+// useGOOS pretends the plugin runs on os for the rest of the test.
+func useGOOS(t *testing.T, os string) {
+	t.Helper()
+	old := plugin.GOOS
+	plugin.GOOS = os
+	t.Cleanup(func() { plugin.GOOS = old })
+}
+
+const showProps = "systemctl show -p LoadState,ActiveState,SubState,NRestarts "
+
+func TestParseUnitShow(t *testing.T) {
+	st := parseUnitShow([]byte("SubState=running\nNRestarts=4\nLoadState=loaded\nActiveState=active\nnoise\n"))
+	if st != (unitState{LoadState: "loaded", ActiveState: "active", SubState: "running", NRestarts: 4}) {
+		t.Errorf("parsed %+v", st)
+	}
+}
+
+func TestUnitStatus(t *testing.T) {
+	for active, want := range map[string]string{
+		"active":       "up",
+		"reloading":    "up",
+		"activating":   "warning",
+		"deactivating": "warning",
+		"failed":       "down",
+		"inactive":     "down",
+		"":             "down",
+	} {
+		if got := unitStatus(active); got != want {
+			t.Errorf("unitStatus(%q) = %q, want %q", active, got, want)
+		}
+	}
+}
+
+func TestGetSystemdUnits(t *testing.T) {
+	useGOOS(t, "linux")
+	runner := &fakeRunner{
+		out: map[string]string{
+			showProps + "nginx.service":    "LoadState=loaded\nActiveState=active\nSubState=running\nNRestarts=2\n",
+			showProps + "backup.service":   "LoadState=loaded\nActiveState=failed\nSubState=failed\nNRestarts=0\n",
+			showProps + "postgres.service": "LoadState=loaded\nActiveState=activating\nSubState=start-pre\nNRestarts=0\n",
+			showProps + "nope.service":     "LoadState=not-found\nActiveState=inactive\nSubState=dead\nNRestarts=0\n",
+		},
+		errs: map[string]error{showProps + "broken.service": errors.New("exit status 1")},
+	}
+	p := &localPlugin{runner: runner}
+
+	metrics := p.getSystemdUnits([]string{"nginx.service", "backup.service", "postgres.service", "nope.service", "broken.service"})
+	for unit, want := range map[string]struct{ status, sub, reason string }{
+		"nginx.service":    {"up", "running", ""},
+		"backup.service":   {"down", "failed", ""},
+		"postgres.service": {"warning", "start-pre", ""},
+		"nope.service":     {"down", "dead", "unit not found"},
+		"broken.service":   {"down", "", "systemctl show failed: exit status 1"},
+	} {
+		m, ok := metrics["unit_status_"+unit].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: no status metric", unit)
+			continue
+		}
+		if m["value"] != want.status || m["instance"] != unit || m["type"] != "status" {
+			t.Errorf("%s: status metric %v, want %s", unit, m, want.status)
+		}
+		if want.sub != "" && m["sub_state"] != want.sub {
+			t.Errorf("%s: sub_state %v, want %s", unit, m["sub_state"], want.sub)
+		}
+		if reason, _ := m["reason"].(string); reason != want.reason {
+			t.Errorf("%s: reason %q, want %q", unit, reason, want.reason)
+		}
+	}
+
+	if m := metrics["unit_restarts_nginx.service"].(map[string]interface{}); m["value"] != 2 || m["type"] != "counter" {
+		t.Errorf("restarts = %v", m)
+	}
+	if _, ok := metrics["unit_restarts_broken.service"]; ok {
+		t.Error("restart count reported for a unit that could not be queried")
+	}
+	if len(runner.ran) != 5 {
+		t.Errorf("ran %q", runner.ran)
+	}
+}
+
+func TestGetSystemdUnitsNone(t *testing.T) {
+	useGOOS(t, "linux")
+	runner := &fakeRunner{}
+	p := &localPlugin{runner: runner}
+	if metrics := p.getSystemdUnits(nil); metrics != nil || len(runner.ran) != 0 {
+		t.Errorf("no units configured: metrics %v, ran %q", metrics, runner.ran)
+	}
+}
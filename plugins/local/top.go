@@ -0,0 +1,207 @@
+package local
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/mem"
+
+	plugin "observer/base"
+)
+
+const (
+	defaultTopN    = 5
+	topSampleDelay = 250 * time.Millisecond // between the two /proc reads
+	pfKthread      = 0x00200000             // PF_KTHREAD in /proc/<pid>/stat flags
+)
+
+// procSample is one process's usage over the sampling window.
+type procSample struct {
+	PID        int
+	Comm       string
+	Kernel     bool
+	CPUPercent float64 // of one core, as top shows it
+	RSS        uint64
+	MemPercent float64
+}
+
+// topSampler measures every process's CPU and memory; /proc in production.
+type topSampler interface {
+	Sample() ([]procSample, error)
+}
+
+// procStat is the part of /proc/<pid>/stat needed for sampling.
+type procStat struct {
+	comm   string
+	kernel bool
+	ticks  uint64 // utime + stime
+	rss    uint64 // pages
+}
+
+// parseProcStat parses a /proc/<pid>/stat line. The command name is taken from
+// between the first "(" and the last ")" since it may itself contain either.
+func parseProcStat(line string) (procStat, bool) {
+	open, end := strings.IndexByte(line, '('), strings.LastIndexByte(line, ')')
+	if open < 0 || end < open {
+		return procStat{}, false
+	}
+	fields := strings.Fields(line[end+1:])
+	if len(fields) < 22 {
+		return procStat{}, false
+	}
+	flags, _ := strconv.ParseUint(fields[6], 10, 64)
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	rss, _ := strconv.ParseUint(fields[21], 10, 64)
+	return procStat{
+		comm:   line[open+1 : end],
+		kernel: flags&pfKthread != 0,
+		ticks:  utime + stime,
+		rss:    rss,
+	}, true
+}
+
+// procfsSampler reads /proc twice, topSampleDelay apart.
+type procfsSampler struct{}
+
+func (procfsSampler) Sample() ([]procSample, error) {
+	before, totalBefore, err := readProcStats()
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(topSampleDelay)
+	after, totalAfter, err := readProcStats()
+	if err != nil {
+		return nil, err
+	}
+
+	var memTotal uint64
+	if v, err := mem.VirtualMemory(); err == nil {
+		memTotal = v.Total
+	}
+	pageSize := uint64(os.Getpagesize())
+	// Total ticks cover every core; divide to express usage as a share of one.
+	perCore := float64(totalAfter-totalBefore) / float64(runtime.NumCPU())
+
+	samples := make([]procSample, 0, len(after))
+	for pid, st := range after {
+		s := procSample{PID: pid, Comm: st.comm, Kernel: st.kernel, RSS: st.rss * pageSize}
+		if prev, ok := before[pid]; ok && perCore > 0 && st.ticks >= prev.ticks {
+			s.CPUPercent = float64(st.ticks-prev.ticks) / perCore * 100
+		}
+		if memTotal > 0 {
+			s.MemPercent = float64(s.RSS) / float64(memTotal) * 100
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// readProcStats returns per-pid stats and the host's total CPU ticks.
+func readProcStats() (map[int]procStat, uint64, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return nil, 0, err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	var total uint64
+	for _, f := range strings.Fields(line)[1:] {
+		n, _ := strconv.ParseUint(f, 10, 64)
+		total += n
+	}
+
+	paths, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil, 0, err
+	}
+	stats := make(map[int]procStat, len(paths))
+	for _, path := range paths {
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil {
+			continue
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			continue // exited between the glob and the read
+		}
+		if st, ok := parseProcStat(string(raw)); ok {
+			stats[pid] = st
+		}
+	}
+	return stats, total, nil
+}
+
+// topMetrics ranks samples by CPU and by RSS and emits instances cpu:1..cpu:N and
+// mem:1..mem:N. The value names the process; value_num keeps the percentage or bytes.
+func topMetrics(samples []procSample, n int, includeKernel bool) map[string]interface{} {
+	procs := make([]procSample, 0, len(samples))
+	for _, s := range samples {
+		if s.Kernel && !includeKernel {
+			continue
+		}
+		procs = append(procs, s)
+	}
+
+	metrics := make(map[string]interface{})
+	rank := func(kind string, less func(a, b procSample) bool, value func(procSample) (string, float64)) {
+		sort.SliceStable(procs, func(i, j int) bool {
+			if less(procs[i], procs[j]) {
+				return true
+			}
+			if less(procs[j], procs[i]) {
+				return false
+			}
+			return procs[i].PID < procs[j].PID
+		})
+		for i := 0; i < n && i < len(procs); i++ {
+			instance := fmt.Sprintf("%s:%d", kind, i+1)
+			text, num := value(procs[i])
+			metrics["top_"+instance] = map[string]interface{}{
+				"name":      "top_" + kind,
+				"label":     "Top " + instance,
+				"value":     text,
+				"value_num": num,
+				"type":      "text",
+				"category":  "process",
+				"instance":  instance,
+			}
+		}
+	}
+
+	rank("cpu", func(a, b procSample) bool { return a.CPUPercent > b.CPUPercent }, func(s procSample) (string, float64) {
+		return fmt.Sprintf("%d %s %.1f%%", s.PID, s.Comm, s.CPUPercent), s.CPUPercent
+	})
+	rank("mem", func(a, b procSample) bool { return a.RSS > b.RSS }, func(s procSample) (string, float64) {
+		return fmt.Sprintf("%d %s %.1f%%", s.PID, s.Comm, s.MemPercent), float64(s.RSS)
+	})
+	return metrics
+}
+
+// getTop samples processes and returns the top-N lists when enabled.
+func (p *localPlugin) getTop(cfg plugin.LocalTopConfig) (map[string]interface{}, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	sampler := p.top
+	if sampler == nil {
+		if plugin.GOOS != "linux" {
+			return nil, fmt.Errorf("top: %w on %s (reads /proc)", errNotSupported, plugin.GOOS)
+		}
+		sampler = procfsSampler{}
+	}
+	samples, err := sampler.Sample()
+	if err != nil {
+		return nil, err
+	}
+	n := cfg.N
+	if n <= 0 {
+		n = defaultTopN
+	}
+	return topMetrics(samples, n, cfg.IncludeKernel), nil
+}
@@ -0,0 +1,111 @@
+package local
+
+import (
+	"errors"
+	"testing"
+
+	plugin "observer/base"
+)
+
+type fakeTop struct {
+	samples []procSample
+	err     error
+}
+
+func (f fakeTop) Sample() ([]procSample, error) { return f.samples, f.err }
+
+func topFixture() []procSample {
+	return []procSample{
+		{PID: 10, Comm: "postgres", CPUPercent: 35.5, RSS: 800 << 20, MemPercent: 20},
+		{PID: 2, Comm: "kswapd0", Kernel: true, CPUPercent: 90},
+		{PID: 30, Comm: "nginx", CPUPercent: 5, RSS: 40 << 20, MemPercent: 1},
+		{PID: 20, Comm: "java", CPUPercent: 35.5, RSS: 2 << 30, MemPercent: 51.24},
+		{PID: 40, Comm: "bash", RSS: 4 << 20, MemPercent: 0.1},
+	}
+}
+
+func TestParseProcStat(t *testing.T) {
+	// The comm contains both a space and a ")" and the flags mark a kernel thread.
+	line := "42 (tmux: server) (x)) S 1 42 42 0 -1 2097216 100 0 0 0 150 50 0 0 20 0 1 0 1000 1234567 321 18446744073709551615"
+	st, ok := parseProcStat(line)
+	if !ok {
+		t.Fatal("not parsed")
+	}
+	if st.comm != "tmux: server) (x)" || !st.kernel || st.ticks != 200 || st.rss != 321 {
+		t.Errorf("parsed %+v", st)
+	}
+	if _, ok := parseProcStat("42 (short) S 1 2"); ok {
+		t.Error("truncated line parsed")
+	}
+	if _, ok := parseProcStat("garbage"); ok {
+		t.Error("line without a comm parsed")
+	}
+}
+
+func TestTopMetrics(t *testing.T) {
+	metrics := topMetrics(topFixture(), 3, false)
+	if len(metrics) != 6 {
+		t.Fatalf("metrics = %v", metrics)
+	}
+	for _, tc := range []struct {
+		instance string
+		value    string
+		num      float64
+	}{
+		// Ties on CPU go to the lower PID; the kernel thread is excluded.
+		{"cpu:1", "10 postgres 35.5%", 35.5},
+		{"cpu:2", "20 java 35.5%", 35.5},
+		{"cpu:3", "30 nginx 5.0%", 5},
+		{"mem:1", "20 java 51.2%", 2 << 30},
+		{"mem:2", "10 postgres 20.0%", 800 << 20},
+		{"mem:3", "30 nginx 1.0%", 40 << 20},
+	} {
+		m, ok := metrics["top_"+tc.instance].(map[string]interface{})
+		if !ok {
+			t.Errorf("no %s", tc.instance)
+			continue
+		}
+		if m["value"] != tc.value || m["value_num"] != tc.num || m["instance"] != tc.instance {
+			t.Errorf("%s = %v, want %q (%v)", tc.instance, m, tc.value, tc.num)
+		}
+	}
+	if m := metrics["top_cpu:1"].(map[string]interface{}); m["name"] != "top_cpu" || m["label"] != "Top cpu:1" {
+		t.Errorf("cpu:1 naming = %v", m)
+	}
+
+	withKernel := topMetrics(topFixture(), 1, true)
+	if m := withKernel["top_cpu:1"].(map[string]interface{}); m["value"] != "2 kswapd0 90.0%" {
+		t.Errorf("with kernel threads cpu:1 = %v", m["value"])
+	}
+
+	// Fewer processes than N just shortens the lists.
+	if got := topMetrics(topFixture()[:1], 5, false); len(got) != 2 {
+		t.Errorf("short list = %v", got)
+	}
+}
+
+func TestGetTop(t *testing.T) {
+	p := &localPlugin{top: fakeTop{samples: topFixture()}}
+	if metrics, err := p.getTop(plugin.LocalTopConfig{}); metrics != nil || err != nil {
+		t.Errorf("disabled: %v, %v", metrics, err)
+	}
+
+	metrics, err := p.getTop(plugin.LocalTopConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 8 { // 4 user processes, both lists
+		t.Errorf("default N: %d metrics", len(metrics))
+	}
+
+	p.top = fakeTop{err: errors.New("/proc unreadable")}
+	if _, err := p.getTop(plugin.LocalTopConfig{Enabled: true}); err == nil {
+		t.Error("sampler error not returned")
+	}
+
+	useGOOS(t, "darwin")
+	p.top = nil
+	if _, err := p.getTop(plugin.LocalTopConfig{Enabled: true}); !errors.Is(err, errNotSupported) {
+		t.Errorf("darwin: err = %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errNotSupported is returned by collectors that have no equivalent on this OS.
+var errNotSupported = errors.New("not supported")
+
+// parseScQuery returns the state name from `sc query <service>` output,
+// e.g. "RUNNING" from "STATE : 4  RUNNING".
+func parseScQuery(out []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ":")
+		if !ok || strings.TrimSpace(key) != "STATE" {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) >= 2 {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// serviceStatus maps a Windows service state to up/warning/down.
+func serviceStatus(state string) string {
+	switch state {
+	case "RUNNING":
+		return "up"
+	case "START_PENDING", "STOP_PENDING", "CONTINUE_PENDING", "PAUSE_PENDING", "PAUSED":
+		return "warning"
+	default: // STOPPED, or unknown
+		return "down"
+	}
+}
+
+// getWindowsServices reports the configured systemd_units as Windows service
+// names, queried with `sc query`. Windows keeps no restart count, so only the
+// status metric is produced.
+func getWindowsServices(runner commandRunner, services []string) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	for _, name := range services {
+		status := unitMetric("unit", name, "status", "down")
+		status["category"] = "service"
+
+		out, err := runner.Run("sc", "query", name)
+		state := parseScQuery(out)
+		switch {
+		case state != "":
+			status["value"] = serviceStatus(state)
+			status["sub_state"] = strings.ToLower(state)
+		case err != nil:
+			// sc exits 1060 for a service that is not installed
+			status["reason"] = fmt.Sprintf("sc query failed: %v", err)
+		default:
+			status["reason"] = "service state not reported"
+		}
+		metrics["unit_status_"+name] = status
+	}
+	return metrics
+}
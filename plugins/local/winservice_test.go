@@ -0,0 +1,84 @@
+package local
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const scRunning = `
+SERVICE_NAME: Spooler
+        TYPE               : 110  WIN32_OWN_PROCESS  (interactive)
+        STATE              : 4  RUNNING
+                                (STOPPABLE, NOT_PAUSABLE, ACCEPTS_SHUTDOWN)
+        WIN32_EXIT_CODE    : 0  (0x0)
+`
+
+const scStopped = `
+SERVICE_NAME: wuauserv
+        TYPE               : 20  WIN32_SHARE_PROCESS
+        STATE              : 1  STOPPED
+        WIN32_EXIT_CODE    : 0  (0x0)
+`
+
+func TestParseScQuery(t *testing.T) {
+	for out, want := range map[string]string{
+		scRunning: "RUNNING",
+		scStopped: "STOPPED",
+		"[SC] EnumQueryServicesStatus:OpenService FAILED 1060:\n\nThe specified service does not exist as an installed service.\n": "",
+		"": "",
+	} {
+		if got := parseScQuery([]byte(out)); got != want {
+			t.Errorf("parseScQuery(%q) = %q, want %q", out, got, want)
+		}
+	}
+}
+
+func TestServiceStatus(t *testing.T) {
+	for state, want := range map[string]string{
+		"RUNNING":       "up",
+		"START_PENDING": "warning",
+		"PAUSED":        "warning",
+		"STOPPED":       "down",
+		"":              "down",
+	} {
+		if got := serviceStatus(state); got != want {
+			t.Errorf("serviceStatus(%q) = %q, want %q", state, got, want)
+		}
+	}
+}
+
+func TestGetSystemdUnitsOnWindows(t *testing.T) {
+	useGOOS(t, "windows")
+	runner := &fakeRunner{
+		out: map[string]string{
+			"sc query Spooler":  scRunning,
+			"sc query wuauserv": scStopped,
+			"sc query nope":     "[SC] OpenService FAILED 1060:\n",
+		},
+		errs: map[string]error{"sc query nope": errors.New("exit status 1060")},
+	}
+	p := &localPlugin{runner: runner}
+
+	metrics := p.getSystemdUnits([]string{"Spooler", "wuauserv", "nope"})
+	if len(metrics) != 3 {
+		t.Fatalf("metrics = %v", metrics)
+	}
+	for name, want := range map[string]string{"Spooler": "up", "wuauserv": "down", "nope": "down"} {
+		m, _ := metrics["unit_status_"+name].(map[string]interface{})
+		if m["value"] != want || m["category"] != "service" {
+			t.Errorf("%s = %v", name, m)
+		}
+	}
+	if m := metrics["unit_status_Spooler"].(map[string]interface{}); m["sub_state"] != "running" {
+		t.Errorf("Spooler sub_state = %v", m["sub_state"])
+	}
+	if m := metrics["unit_status_nope"].(map[string]interface{}); m["reason"] != "sc query failed: exit status 1060" {
+		t.Errorf("nope reason = %v", m["reason"])
+	}
+	for _, cmd := range runner.ran {
+		if !strings.HasPrefix(cmd, "sc query ") {
+			t.Errorf("ran %q on Windows", cmd)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package mail
+
+import "os"
+
+// fileInode is unavailable on Windows; rotation is detected by truncation only.
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
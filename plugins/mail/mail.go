@@ -2,12 +2,13 @@
 package mail
 
 import (
-	"bytes"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 	
 	plugin "observer/base"
 	"observer/plugins"
@@ -17,9 +18,10 @@ import (
 //go:embed templates/*
 var templates embed.FS
 
-// mailPlugin interacts with a local Postfix mail server.
+// mailPlugin interacts with the local mail server (Postfix, Exim or OpenSMTPD).
 type mailPlugin struct {
 	plugin.BasePlugin
+	runner commandRunner // nil runs the real commands
 }
 
 func init() {
@@ -68,77 +70,110 @@ func (p *mailPlugin) ShowPage(params map[string]string) (string, error) {
 	}
 }
 
-// OnCollect gathers metrics from the Postfix mail server.
+// OnCollect gathers metrics from the mail server.
 func (p *mailPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
 	metrics := make(map[string]interface{})
+	cfg := loadMailConfig()
+
+	// Remote hosts are checked over SMTP; the local MTA's tools only see this machine.
+	host, _ := options["host"].(map[string]interface{})
+	address, _ := host["address"].(string)
+	if action, _ := options["action"].(string); action == "smtp" || !isLocalAddress(address) {
+		return map[string]interface{}{"metrics": p.collectRemote(address, cfg.SMTP)}, nil
+	}
+
+	if plugin.GOOS == "windows" {
+		return nil, fmt.Errorf("mail: local MTA collection is %w on %s; use the smtp action", errNotSupported, plugin.GOOS)
+	}
+	server := p.mta(cfg)
 
 	// Get queue size
 	queue, err := p.getQueue()
 	if err != nil {
 		metrics["queue"] = p.errorMetric("Queue", err)
 	} else {
-		metrics["queue"] = map[string]interface{}{"name": "Queue", "label": "Queue", "value": fmt.Sprintf("%d", len(queue)), "category": "Mail", "type": "text"}
+		metrics["queue"] = map[string]interface{}{"name": "Queue", "label": "Queue", "value": len(queue), "category": "mail", "type": "gauge"}
+		for k, v := range queueMetrics(queue, time.Now()) {
+			metrics[k] = v
+		}
 	}
 
 	// Get delivery status
-	isPaused, err := p.isDeliveryPaused()
-	if err != nil {
+	isPaused, err := server.Paused()
+	switch {
+	case errors.Is(err, errNotSupported):
+		// the MTA has no delivery switch to report
+	case err != nil:
 		metrics["delivery"] = p.errorMetric("Delivery", err)
-	} else {
-		deliveryStatus := "On"
+	default:
+		deliveryStatus := "up"
 		if isPaused {
-			deliveryStatus = "Off"
+			deliveryStatus = "down"
 		}
-		metrics["delivery"] = map[string]interface{}{"name": "Delivery", "label": "Send", "value": deliveryStatus, "category": "Mail", "type": "text"}
+		metrics["delivery"] = map[string]interface{}{"name": "Delivery", "label": "Send", "value": deliveryStatus, "category": "mail", "type": "status"}
 	}
 
 	// Get service status
-	isRunning, err := p.isServiceRunning()
+	isRunning, err := server.Running()
 	if err != nil {
 		metrics["service"] = p.errorMetric("Service", err)
 	} else {
-		serviceStatus := "Down"
+		serviceStatus := "down"
 		if isRunning {
-			serviceStatus = "Up"
+			serviceStatus = "up"
+		}
+		metrics["service"] = map[string]interface{}{"name": "Service", "label": "Server", "value": serviceStatus, "category": "mail", "type": "status"}
+	}
+
+	// Delivery counts from the mail log
+	if cfg.Log.Enabled {
+		deliveries, err := p.getDeliveries(cfg.Log, time.Now())
+		if err != nil {
+			metrics["deliveries"] = p.errorMetric("Deliveries", err)
+		}
+		for k, v := range deliveries {
+			metrics[k] = v
 		}
-		metrics["service"] = map[string]interface{}{"name": "Service", "label": "Server", "value": serviceStatus, "category": "Mail", "type": "text"}
 	}
 
 	return map[string]interface{}{"metrics": metrics}, nil
 }
 
-// OnCommand handles control actions for the Postfix service.
-func (p *mailPlugin) OnCommand(args map[string]string) error {
-	action := args["action"]
-	var cmd *exec.Cmd
-
-	switch action {
-	case "pause":
-		// This requires two commands
-		cmd1 := exec.Command("sudo", "postconf", "-e", "defer_transports=smtp")
-		cmd2 := exec.Command("sudo", "postfix", "reload")
-		return runCommands(cmd1, cmd2)
-	case "unpause":
-		cmd1 := exec.Command("sudo", "postconf", "-e", "defer_transports=")
-		cmd2 := exec.Command("sudo", "postfix", "reload")
-		cmd3 := exec.Command("sudo", "postfix", "flush")
-		return runCommands(cmd1, cmd2, cmd3)
-	case "start":
-		cmd = exec.Command("sudo", "systemctl", "start", "postfix")
-	case "stop":
-		cmd = exec.Command("sudo", "systemctl", "stop", "postfix")
-	default:
-		return fmt.Errorf("unknown action for mail plugin: %s", action)
+// Actions advertises the mail server controls for hosts that collect mail metrics.
+// MTAs without an equivalent reject the action from OnCommand.
+func (p *mailPlugin) Actions() []plugin.ActionSpec {
+	collectsMail := func(h plugin.Host) bool { return h.CollectsWith("mail") }
+	return []plugin.ActionSpec{
+		{Action: "pause", Label: "Pause mail delivery", Safety: plugin.SafetyDestructive, Applies: collectsMail},
+		{Action: "unpause", Label: "Resume mail delivery and flush", Safety: plugin.SafetyWrite, Applies: collectsMail},
+		{Action: "start", Label: "Start mail server", Safety: plugin.SafetyWrite, Applies: collectsMail},
+		{Action: "stop", Label: "Stop mail server", Safety: plugin.SafetyDestructive, Applies: collectsMail},
+		{Action: "flush", Label: "Flush mail queue", Safety: plugin.SafetyWrite, Applies: collectsMail},
+		{Action: "hold", Label: "Hold queued message", Safety: plugin.SafetyWrite, Params: []plugin.ActionParam{{Name: "id", Label: "Queue id"}}, Applies: collectsMail},
+		{Action: "release", Label: "Release held message", Safety: plugin.SafetyWrite, Params: []plugin.ActionParam{{Name: "id", Label: "Queue id"}}, Applies: collectsMail},
+		{Action: "delete", Label: "Delete queued message", Safety: plugin.SafetyDestructive, Params: []plugin.ActionParam{{Name: "id", Label: "Queue id"}}, Applies: collectsMail},
 	}
+}
+
+// OnCommand handles control actions for the mail service.
+func (p *mailPlugin) OnCommand(args map[string]string) error {
+	return mtaAction(p.mta(loadMailConfig()), args["action"], parseArgs(args["args"]))
+}
 
-	return cmd.Run()
+// mta returns the configured or detected mail transfer agent.
+func (p *mailPlugin) mta(cfg plugin.MailConfig) mta {
+	run := p.runner
+	if run == nil {
+		run = execRunner{}
+	}
+	return detectMTA(cfg.MTA, run, exec.LookPath)
 }
 
-// getQueue executes `postqueue -j` and parses the JSON output.
+// getQueue returns the MTA's queue in `postqueue -j` form.
 func (p *mailPlugin) getQueue() ([]interface{}, error) {
 	// Check if fake_queue is enabled in config
 	if p.Controller != nil {
-		configData, err := os.ReadFile("data/config.json")
+		configData, err := plugin.ReadConfigFile()
 		if err == nil {
 			var config map[string]interface{}
 			if json.Unmarshal(configData, &config) == nil {
@@ -149,62 +184,13 @@ func (p *mailPlugin) getQueue() ([]interface{}, error) {
 		}
 	}
 
-	cmd := exec.Command("postqueue", "-j")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
-		return nil, err
-	}
-
-	var queue []interface{}
-	// The output is a stream of JSON objects, one per line. We need to decode them one by one.
-	decoder := json.NewDecoder(&out)
-	for decoder.More() {
-		var entry interface{}
-		if err := decoder.Decode(&entry); err != nil {
-			continue // Or handle error
-		}
-		queue = append(queue, entry)
-	}
-
-	return queue, nil
-}
-
-// isDeliveryPaused checks if mail delivery is paused in Postfix.
-func (p *mailPlugin) isDeliveryPaused() (bool, error) {
-	cmd := exec.Command("postconf", "-h", "defer_transports")
-	out, err := cmd.Output()
-	if err != nil {
-		return false, err
-	}
-	return strings.TrimSpace(string(out)) == "smtp", nil
-}
-
-// isServiceRunning checks if the postfix process is active.
-func (p *mailPlugin) isServiceRunning() (bool, error) {
-	// A simple check, similar to the PHP version.
-	cmd := exec.Command("ps", "aux")
-	out, err := cmd.Output()
-	if err != nil {
-		return false, err
-	}
-	return strings.Contains(string(out), "postfix/"), nil
-}
-
-// runCommands executes a series of commands in order.
-func runCommands(cmds ...*exec.Cmd) error {
-	for _, cmd := range cmds {
-		if err := cmd.Run(); err != nil {
-			return err
-		}
-	}
-	return nil
+	return p.mta(loadMailConfig()).Queue()
 }
 
 func (p *mailPlugin) errorMetric(label string, err error) map[string]interface{} {
 	return map[string]interface{}{
 		"type":     "text",
-		"category": "Mail",
+		"category": "mail",
 		"label":    label,
 		"value":    fmt.Sprintf("Error: %v", err),
 	}
@@ -412,15 +398,5 @@ func (p *mailPlugin) getFakeQueue() ([]interface{}, error) {
 		return nil, err
 	}
 
-	var queue []interface{}
-	decoder := json.NewDecoder(bytes.NewReader(data))
-	for decoder.More() {
-		var entry interface{}
-		if err := decoder.Decode(&entry); err != nil {
-			continue
-		}
-		queue = append(queue, entry)
-	}
-
-	return queue, nil
+	return parseQueue(data), nil
 }
@@ -0,0 +1,83 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+	"observer/store"
+)
+
+func TestCollectMetricShapes(t *testing.T) {
+	useGOOS(t, "linux")
+	testenv.UseTempDirs(t)
+	if err := os.WriteFile(plugin.ConfigFile, []byte(`{"config_version": 1, "mail": {"mta": "postfix"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run := &fakeRunner{out: map[string]string{
+		"postqueue -j": `{"queue_name": "active", "queue_id": "A1", "arrival_time": 1714564000}
+{"queue_name": "deferred", "queue_id": "A2", "arrival_time": 1714564100}
+{"queue_name": "deferred", "queue_id": "A3", "arrival_time": 1714564200}`,
+		"postconf -h defer_transports": "\n",
+		"ps aux":                       "root 1 /sbin/init\n",
+	}}
+	p := &mailPlugin{runner: run}
+
+	result, err := p.OnCollect(map[string]interface{}{"host": map[string]interface{}{"address": "localhost"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := result["metrics"].(map[string]interface{})
+
+	for key, want := range map[string]struct {
+		label, metricType string
+		value             interface{}
+		num               float64
+	}{
+		"queue":    {"Queue", "gauge", 3, 3},
+		"delivery": {"Send", "status", "up", 1},
+		"service":  {"Server", "status", "down", 0}, // no postfix/ worker process listed
+	} {
+		m, ok := metrics[key].(map[string]interface{})
+		if !ok {
+			t.Errorf("no %s metric", key)
+			continue
+		}
+		if m["label"] != want.label || m["type"] != want.metricType || m["value"] != want.value || m["category"] != "mail" {
+			t.Errorf("%s = %v", key, m)
+		}
+		// The value graphs once it reaches the store.
+		value := fmt.Sprintf("%v", plugin.NewMetricResult(key, "mail", m).Value)
+		if n := store.ParseValueNum(value); n == nil || *n != want.num {
+			t.Errorf("%s: value %q parses to %v, want %v", key, value, n, want.num)
+		}
+	}
+}
+
+func TestCollectPausedAndErrors(t *testing.T) {
+	useGOOS(t, "linux")
+	testenv.UseTempDirs(t)
+	run := &fakeRunner{out: map[string]string{
+		"postconf -h defer_transports": "smtp\n",
+		"ps aux":                       "postfix 901 postfix/qmgr -l -t unix -u\n",
+	}}
+	p := &mailPlugin{runner: run}
+
+	result, err := p.OnCollect(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := result["metrics"].(map[string]interface{})
+	if m := metrics["delivery"].(map[string]interface{}); m["value"] != "down" {
+		t.Errorf("paused delivery = %v", m)
+	}
+	if m := metrics["service"].(map[string]interface{}); m["value"] != "up" {
+		t.Errorf("service = %v", m)
+	}
+	// postqueue is missing: the queue becomes a text error, the rest still reports.
+	if m := metrics["queue"].(map[string]interface{}); m["type"] != "text" || m["category"] != "mail" {
+		t.Errorf("queue error = %v", m)
+	}
+}
@@ -0,0 +1,253 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+)
+
+const (
+	defaultMailLog  = "/var/log/mail.log"
+	mailStateFile   = "mail_state.json"
+	maxBounceReason = 5
+)
+
+// mailLogState remembers where the previous run stopped reading.
+type mailLogState struct {
+	Path   string    `json:"path"`
+	Inode  uint64    `json:"inode"`
+	Offset int64     `json:"offset"`
+	Cursor string    `json:"cursor"` // journal cursor when reading from journalctl
+	ReadAt time.Time `json:"read_at"`
+}
+
+// deliveryCounts accumulates delivery outcomes from log lines.
+type deliveryCounts struct {
+	Status  map[string]int            // sent/deferred/bounced → count
+	Relay   map[string]map[string]int // relay → status → count
+	Bounces map[string]int            // bounce reason → count
+}
+
+func newDeliveryCounts() *deliveryCounts {
+	return &deliveryCounts{
+		Status:  map[string]int{"sent": 0, "deferred": 0, "bounced": 0},
+		Relay:   make(map[string]map[string]int),
+		Bounces: make(map[string]int),
+	}
+}
+
+// statusLabels are the display labels for each delivery status.
+var statusLabels = map[string]string{"sent": "Sent", "deferred": "Deferred", "bounced": "Bounced"}
+
+var (
+	statusRe = regexp.MustCompile(`\bstatus=(sent|deferred|bounced)\b(?:\s+\((.*)\))?`)
+	relayRe  = regexp.MustCompile(`\brelay=([^,\s]+)`)
+)
+
+// addLine counts one Postfix delivery line; other lines are ignored.
+func (c *deliveryCounts) addLine(line string) {
+	m := statusRe.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	status := m[1]
+	c.Status[status]++
+
+	relay := "none"
+	if r := relayRe.FindStringSubmatch(line); r != nil {
+		relay = r[1]
+	}
+	if c.Relay[relay] == nil {
+		c.Relay[relay] = make(map[string]int)
+	}
+	c.Relay[relay][status]++
+
+	if status == "bounced" && m[2] != "" {
+		c.Bounces[normalizeReason(m[2])]++
+	}
+}
+
+// parseLog counts every complete line in r.
+func (c *deliveryCounts) parseLog(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		c.addLine(sc.Text())
+	}
+}
+
+// readLogFile reads path from the saved offset, starting over when the file was
+// rotated (new inode) or truncated. Only whole lines are consumed; a partial last
+// line is left for the next run.
+func readLogFile(path string, state *mailLogState, counts *deliveryCounts) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	inode := fileInode(fi)
+	if state.Path != path || state.Inode != inode || fi.Size() < state.Offset {
+		state.Offset = 0
+	}
+	state.Path, state.Inode = path, inode
+
+	if _, err := f.Seek(state.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	counts.parseLog(bytes.NewReader(data[:end]))
+	state.Offset += int64(end)
+	return nil
+}
+
+// readJournal reads postfix journal entries after the saved cursor.
+func readJournal(state *mailLogState, counts *deliveryCounts) error {
+	args := []string{"-u", "postfix", "-o", "cat", "--no-pager", "--show-cursor"}
+	if state.Cursor != "" {
+		args = append(args, "--after-cursor="+state.Cursor)
+	} else {
+		args = append(args, "--since=-5min")
+	}
+	out, err := exec.Command("journalctl", args...).Output()
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if cursor, ok := strings.CutPrefix(line, "-- cursor: "); ok {
+			state.Cursor = cursor
+			continue
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	counts.parseLog(&body)
+	return nil
+}
+
+func loadMailLogState() *mailLogState {
+	state := &mailLogState{}
+	if data, err := os.ReadFile(plugin.StateFile(mailStateFile)); err == nil {
+		_ = json.Unmarshal(data, state)
+	}
+	return state
+}
+
+func saveMailLogState(state *mailLogState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return plugin.WriteStateFile(mailStateFile, data, 0644)
+}
+
+// deliveryMetrics turns counts over elapsed into per-status and per-relay counts
+// and per-minute rates; the top bounce reasons ride on the bounced metric.
+func deliveryMetrics(c *deliveryCounts, elapsed time.Duration) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	minutes := elapsed.Minutes()
+
+	for status, n := range c.Status {
+		m := map[string]interface{}{"name": "delivered_" + status, "label": statusLabels[status], "value": n, "category": "mail", "type": "gauge"}
+		if status == "bounced" && len(c.Bounces) > 0 {
+			m["bounce_reasons"] = topReasons(c.Bounces, maxBounceReason)
+		}
+		metrics["delivered_"+status] = m
+		if minutes > 0 {
+			rate := float64(n) / minutes
+			metrics["delivery_rate_"+status] = map[string]interface{}{"name": "delivery_rate_" + status, "label": statusLabels[status] + "/min", "value": fmt.Sprintf("%.2f", rate), "value_num": rate, "category": "mail", "type": "gauge"}
+		}
+	}
+	for relay, byStatus := range c.Relay {
+		for status, n := range byStatus {
+			metrics["delivered_"+status+"_"+relay] = map[string]interface{}{"name": "delivered_" + status, "label": fmt.Sprintf("%s %s", statusLabels[status], relay), "value": n, "category": "mail", "type": "gauge", "instance": relay}
+		}
+	}
+	return metrics
+}
+
+// topReasons returns the n most frequent reasons, most frequent first.
+func topReasons(reasons map[string]int, n int) []map[string]interface{} {
+	keys := make([]string, 0, len(reasons))
+	for r := range reasons {
+		keys = append(keys, r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if reasons[keys[i]] != reasons[keys[j]] {
+			return reasons[keys[i]] > reasons[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	out := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, map[string]interface{}{"reason": k, "count": reasons[k]})
+	}
+	return out
+}
+
+// getDeliveries reads the mail log since the previous run and returns delivery metrics.
+// The first run only records the position, since there is no interval to rate over.
+func (p *mailPlugin) getDeliveries(cfg plugin.MailLogConfig, now time.Time) (map[string]interface{}, error) {
+	state := loadMailLogState()
+	first := state.ReadAt.IsZero()
+	counts := newDeliveryCounts()
+
+	var err error
+	if cfg.Journal {
+		err = readJournal(state, counts)
+	} else {
+		path := cfg.Path
+		if path == "" {
+			path = defaultMailLog
+		}
+		err = readLogFile(path, state, counts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	elapsed := now.Sub(state.ReadAt)
+	state.ReadAt = now
+	if err := saveMailLogState(state); err != nil {
+		p.Controller.Printf("  !_ mail: could not save log state: %v\n", err)
+	}
+	if first {
+		return nil, nil
+	}
+	return deliveryMetrics(counts, elapsed), nil
+}
+
+// loadMailConfig reads the "mail" section of the configuration file.
+func loadMailConfig() plugin.MailConfig {
+	var cfg struct {
+		Mail plugin.MailConfig `json:"mail"`
+	}
+	if data, err := plugin.ReadConfigFile(); err == nil {
+		_ = json.Unmarshal(data, &cfg)
+	}
+	return cfg.Mail
+}
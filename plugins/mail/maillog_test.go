@@ -0,0 +1,176 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+const (
+	logSegment1 = `May  1 12:00:01 mx postfix/smtp[100]: 4VXY1: to=<a@gmail.com>, relay=gmail-smtp-in.l.google.com[142.250.1.26]:25, delay=0.9, dsn=2.0.0, status=sent (250 2.0.0 OK)
+May  1 12:00:02 mx postfix/smtp[100]: 4VXY2: to=<b@gmail.com>, relay=gmail-smtp-in.l.google.com[142.250.1.26]:25, delay=1.1, dsn=2.0.0, status=sent (250 2.0.0 OK)
+May  1 12:00:03 mx postfix/qmgr[90]: 4VXY3: from=<app@example.com>, size=2048, nrcpt=1 (queue active)
+May  1 12:00:04 mx postfix/smtp[101]: 4VXY4: to=<c@example.net>, relay=none, delay=30, dsn=4.4.1, status=deferred (connect to mx.example.net[203.0.113.5]:25: Connection timed out)
+`
+	logSegment2 = `May  1 12:05:01 mx postfix/smtp[102]: 4VXY5: to=<d@example.org>, relay=mx.example.org[198.51.100.7]:25, delay=0.4, dsn=5.1.1, status=bounced (host mx.example.org said: 550 5.1.1 User unknown)
+May  1 12:05:02 mx postfix/smtp[102]: 4VXY6: to=<e@example.org>, relay=mx.example.org[198.51.100.7]:25, delay=0.4, dsn=5.1.1, status=bounced (host mx.example.org said: 550 5.1.1   User unknown)
+May  1 12:05:03 mx postfix/smtp[102]: 4VXY7: to=<f@example.org>, relay=mx.example.org[198.51.100.7]:25, delay=0.4, dsn=5.2.2, status=bounced (host mx.example.org said: 552 Mailbox full)
+`
+)
+
+func TestDeliveryCountsAddLine(t *testing.T) {
+	c := newDeliveryCounts()
+	c.parseLog(strings.NewReader(logSegment1 + logSegment2))
+
+	for status, want := range map[string]int{"sent": 2, "deferred": 1, "bounced": 3} {
+		if c.Status[status] != want {
+			t.Errorf("%s = %d, want %d", status, c.Status[status], want)
+		}
+	}
+	if n := c.Relay["gmail-smtp-in.l.google.com[142.250.1.26]:25"]["sent"]; n != 2 {
+		t.Errorf("gmail sent = %d", n)
+	}
+	if n := c.Relay["none"]["deferred"]; n != 1 {
+		t.Errorf("relay=none deferred = %d", n)
+	}
+	if n := c.Bounces["host mx.example.org said: 550 5.1.1 User unknown"]; n != 2 {
+		t.Errorf("bounces = %v", c.Bounces)
+	}
+}
+
+func TestReadLogFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	state := &mailLogState{}
+
+	// A partial last line waits for the next read.
+	partial := "May  1 12:00:05 mx postfix/smtp[101]: 4VXY8: to=<g@gmail.com>, relay=gmail"
+	if err := os.WriteFile(path, []byte(logSegment1+partial), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newDeliveryCounts()
+	if err := readLogFile(path, state, c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Status["sent"] != 2 || state.Offset != int64(len(logSegment1)) {
+		t.Errorf("first read: sent %d, offset %d", c.Status["sent"], state.Offset)
+	}
+
+	rest := "-smtp-in.l.google.com[142.250.1.26]:25, status=sent (250 OK)\n"
+	appendLog(t, path, rest)
+	c = newDeliveryCounts()
+	if err := readLogFile(path, state, c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Status["sent"] != 1 || c.Status["deferred"] != 0 {
+		t.Errorf("appended read: %v", c.Status)
+	}
+
+	// logrotate moves the file away and a new one starts at offset zero; the
+	// new file is larger than the old offset so only the inode gives it away.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	rotated := strings.Repeat(logSegment2, 40)
+	if err := os.WriteFile(path, []byte(rotated), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = newDeliveryCounts()
+	if err := readLogFile(path, state, c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Status["bounced"] != 120 || c.Status["sent"] != 0 {
+		t.Errorf("after rotation: %v", c.Status)
+	}
+
+	// copytruncate keeps the inode but shrinks the file.
+	if err := os.WriteFile(path, []byte(logSegment1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = newDeliveryCounts()
+	if err := readLogFile(path, state, c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Status["sent"] != 2 || c.Status["bounced"] != 0 {
+		t.Errorf("after truncation: %v", c.Status)
+	}
+
+	c = newDeliveryCounts()
+	if err := readLogFile(path, state, c); err != nil || c.Status["sent"] != 0 {
+		t.Errorf("nothing new: %v, %v", c.Status, err)
+	}
+}
+
+func appendLog(t *testing.T, path, text string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetDeliveries(t *testing.T) {
+	testenv.UseTempDirs(t)
+	path := filepath.Join(t.TempDir(), "mail.log")
+	if err := os.WriteFile(path, []byte(logSegment1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := &mailPlugin{}
+	cfg := plugin.MailLogConfig{Enabled: true, Path: path}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// The first run only records where it stopped.
+	metrics, err := p.getDeliveries(cfg, start)
+	if err != nil || metrics != nil {
+		t.Fatalf("first run: %v, %v", metrics, err)
+	}
+	if _, err := os.Stat(plugin.StateFile(mailStateFile)); err != nil {
+		t.Fatalf("state not saved: %v", err)
+	}
+
+	appendLog(t, path, logSegment2)
+	metrics, err = p.getDeliveries(cfg, start.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounced := metrics["delivered_bounced"].(map[string]interface{})
+	if bounced["value"] != 3 {
+		t.Errorf("bounced = %v", bounced)
+	}
+	reasons := bounced["bounce_reasons"].([]map[string]interface{})
+	if len(reasons) != 2 || reasons[0]["count"] != 2 || reasons[0]["reason"] != "host mx.example.org said: 550 5.1.1 User unknown" {
+		t.Errorf("bounce reasons = %v", reasons)
+	}
+	if rate := metrics["delivery_rate_bounced"].(map[string]interface{}); rate["value_num"] != 1.5 || rate["value"] != "1.50" {
+		t.Errorf("bounce rate = %v", rate)
+	}
+	if sent := metrics["delivered_sent"].(map[string]interface{}); sent["value"] != 0 || sent["bounce_reasons"] != nil {
+		t.Errorf("sent = %v", sent)
+	}
+	if relay := metrics["delivered_bounced_mx.example.org[198.51.100.7]:25"].(map[string]interface{}); relay["value"] != 3 || relay["instance"] != "mx.example.org[198.51.100.7]:25" {
+		t.Errorf("relay = %v", relay)
+	}
+
+	if _, err := p.getDeliveries(plugin.MailLogConfig{Path: filepath.Join(t.TempDir(), "absent.log")}, start); err == nil {
+		t.Error("missing log not reported")
+	}
+}
+
+func TestTopReasons(t *testing.T) {
+	got := topReasons(map[string]int{"b": 2, "a": 2, "c": 5, "d": 1}, 3)
+	var order []string
+	for _, r := range got {
+		order = append(order, r["reason"].(string))
+	}
+	if strings.Join(order, ",") != "c,a,b" {
+		t.Errorf("order = %v", order)
+	}
+}
@@ -0,0 +1,100 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// queueIDRe matches Postfix, Exim and OpenSMTPD queue ids.
+var queueIDRe = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// parseArgs turns the "key=value key=value" string from -args into a map.
+func parseArgs(argsStr string) map[string]string {
+	result := make(map[string]string)
+	for _, part := range strings.Fields(argsStr) {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			result[kv[0]] = kv[1]
+		}
+	}
+	return result
+}
+
+// queueIDs returns the queue_id of every entry.
+func queueIDs(queue []interface{}) []string {
+	var ids []string
+	for _, entry := range queue {
+		if m, ok := entry.(map[string]interface{}); ok {
+			if id, ok := m["queue_id"].(string); ok && id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// messageID validates the id argument of hold/release/delete. A single id must be
+// in the current queue; id=ALL must be confirmed with confirm=yes.
+func messageID(m mta, args map[string]string) (string, error) {
+	id := args["id"]
+	switch {
+	case id == "":
+		return "", errors.New("mail: missing id=<queue-id> (or id=ALL confirm=yes)")
+	case strings.EqualFold(id, allMessages):
+		if args["confirm"] != "yes" {
+			return "", errors.New("mail: id=ALL affects every queued message; add confirm=yes")
+		}
+		return allMessages, nil
+	case !queueIDRe.MatchString(id):
+		return "", fmt.Errorf("mail: invalid queue id %q", id)
+	}
+
+	queue, err := m.Queue()
+	if err != nil {
+		return "", fmt.Errorf("mail: could not list queue to check %s: %w", id, err)
+	}
+	for _, qid := range queueIDs(queue) {
+		if qid == id {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("mail: queue id %s is not in the queue", id)
+}
+
+// mtaAction runs a control or queue management action, naming the MTA when it has no equivalent.
+func mtaAction(m mta, action string, args map[string]string) error {
+	var err error
+	switch action {
+	case "pause":
+		err = m.Pause()
+	case "unpause":
+		err = m.Unpause()
+	case "start":
+		err = m.Start()
+	case "stop":
+		err = m.Stop()
+	case "flush":
+		err = m.Flush()
+	case "hold", "release", "delete":
+		id, idErr := messageID(m, args)
+		if idErr != nil {
+			return idErr
+		}
+		switch action {
+		case "hold":
+			err = m.Hold(id)
+		case "release":
+			err = m.Release(id)
+		default:
+			err = m.Delete(id)
+		}
+	default:
+		return fmt.Errorf("unknown action for mail plugin: %s", action)
+	}
+	if errors.Is(err, errNotSupported) {
+		return fmt.Errorf("mail: %s is not supported on %s", action, m.Name())
+	}
+	return err
+}
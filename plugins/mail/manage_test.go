@@ -0,0 +1,123 @@
+package mail
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const postfixQueue = `{"queue_name": "deferred", "queue_id": "4VXY1", "arrival_time": 1714564000}
+{"queue_name": "hold", "queue_id": "4VXY2", "arrival_time": 1714564100}
+`
+
+func TestParseArgs(t *testing.T) {
+	got := parseArgs("id=4VXY1 confirm=yes stray key=a=b")
+	if len(got) != 3 || got["id"] != "4VXY1" || got["confirm"] != "yes" || got["key"] != "a=b" {
+		t.Errorf("parseArgs = %v", got)
+	}
+}
+
+func TestPostfixActions(t *testing.T) {
+	for _, tc := range []struct {
+		action string
+		args   map[string]string
+		want   []string
+	}{
+		{"flush", nil, []string{"sudo postqueue -f"}},
+		{"hold", map[string]string{"id": "4VXY1"}, []string{"postqueue -j", "sudo postsuper -h 4VXY1"}},
+		{"release", map[string]string{"id": "4VXY2"}, []string{"postqueue -j", "sudo postsuper -H 4VXY2"}},
+		{"delete", map[string]string{"id": "4VXY1"}, []string{"postqueue -j", "sudo postsuper -d 4VXY1"}},
+		{"delete", map[string]string{"id": "all", "confirm": "yes"}, []string{"sudo postsuper -d ALL"}},
+		{"pause", nil, []string{"sudo postconf -e defer_transports=smtp", "sudo postfix reload"}},
+		{"unpause", nil, []string{"sudo postconf -e defer_transports=", "sudo postfix reload", "sudo postfix flush"}},
+		{"stop", nil, []string{"sudo systemctl stop postfix"}},
+	} {
+		run := &fakeRunner{out: map[string]string{"postqueue -j": postfixQueue}}
+		for _, cmd := range tc.want {
+			if _, ok := run.out[cmd]; !ok {
+				run.out[cmd] = ""
+			}
+		}
+		if err := mtaAction(postfixMTA{run: run}, tc.action, tc.args); err != nil {
+			t.Errorf("%s %v: %v", tc.action, tc.args, err)
+		}
+		if got, want := strings.Join(run.ran, "; "), strings.Join(tc.want, "; "); got != want {
+			t.Errorf("%s %v ran %q, want %q", tc.action, tc.args, got, want)
+		}
+	}
+}
+
+func TestEximActions(t *testing.T) {
+	run := &fakeRunner{out: map[string]string{
+		"exim4 -bp":                       eximFixture,
+		"sudo exim4 -Mf 1tXyZa-000AbC-1D": "",
+		"sudo exim4 -Mrm 1tXyZa-000AbC-1D 1tXyZb-000AbC-2E": "",
+	}}
+	m := eximMTA{run: run, binary: "exim4"}
+
+	if err := mtaAction(m, "hold", map[string]string{"id": "1tXyZa-000AbC-1D"}); err != nil {
+		t.Error(err)
+	}
+	// ALL expands to every id in the queue in a single command.
+	if err := mtaAction(m, "delete", map[string]string{"id": "ALL", "confirm": "yes"}); err != nil {
+		t.Error(err)
+	}
+	want := "exim4 -bp; sudo exim4 -Mf 1tXyZa-000AbC-1D; exim4 -bp; sudo exim4 -Mrm 1tXyZa-000AbC-1D 1tXyZb-000AbC-2E"
+	if got := strings.Join(run.ran, "; "); got != want {
+		t.Errorf("ran %q", got)
+	}
+
+	// An empty queue makes ALL a no-op.
+	empty := &fakeRunner{out: map[string]string{"exim4 -bp": ""}}
+	if err := mtaAction(eximMTA{run: empty, binary: "exim4"}, "release", map[string]string{"id": "ALL", "confirm": "yes"}); err != nil || len(empty.ran) != 1 {
+		t.Errorf("empty queue: ran %q, err %v", empty.ran, err)
+	}
+}
+
+func TestMessageIDRefusals(t *testing.T) {
+	for _, tc := range []struct {
+		args map[string]string
+		want string
+	}{
+		{map[string]string{}, "missing id"},
+		{map[string]string{"id": "ALL"}, "add confirm=yes"},
+		{map[string]string{"id": "all", "confirm": "no"}, "add confirm=yes"},
+		{map[string]string{"id": "4VXY1;rm"}, `invalid queue id "4VXY1;rm"`},
+		{map[string]string{"id": "-d"}, "is not in the queue"},
+		{map[string]string{"id": "4VXY9"}, "queue id 4VXY9 is not in the queue"},
+	} {
+		run := &fakeRunner{out: map[string]string{"postqueue -j": postfixQueue}}
+		err := mtaAction(postfixMTA{run: run}, "delete", tc.args)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: err = %v, want %q", tc.args, err, tc.want)
+		}
+		for _, cmd := range run.ran {
+			if strings.HasPrefix(cmd, "sudo") {
+				t.Errorf("%v: ran %q after refusing", tc.args, cmd)
+			}
+		}
+	}
+
+	run := &fakeRunner{errs: map[string]error{"postqueue -j": errors.New("postqueue: fatal: Queue report unavailable")}}
+	err := mtaAction(postfixMTA{run: run}, "hold", map[string]string{"id": "4VXY1"})
+	if err == nil || !strings.Contains(err.Error(), "could not list queue to check 4VXY1") || !strings.Contains(err.Error(), "Queue report unavailable") {
+		t.Errorf("listing failure: err = %v", err)
+	}
+}
+
+func TestActionFailureKeepsOutput(t *testing.T) {
+	run := &fakeRunner{
+		out:  map[string]string{"postqueue -j": postfixQueue},
+		errs: map[string]error{"sudo postsuper -h 4VXY1": errors.New("sudo postsuper -h 4VXY1: exit status 1: postsuper: fatal: use of this command is reserved for the superuser")},
+	}
+	err := mtaAction(postfixMTA{run: run}, "hold", map[string]string{"id": "4VXY1"})
+	if err == nil || !strings.Contains(err.Error(), "reserved for the superuser") {
+		t.Errorf("err = %v", err)
+	}
+
+	// Later steps do not run after a failed one.
+	run = &fakeRunner{errs: map[string]error{"sudo postconf -e defer_transports=smtp": errors.New("exit status 1")}}
+	if err := mtaAction(postfixMTA{run: run}, "pause", nil); err == nil || len(run.ran) != 1 {
+		t.Errorf("pause: ran %q, err %v", run.ran, err)
+	}
+}
@@ -0,0 +1,398 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+)
+
+// errNotSupported is returned for operations an MTA has no equivalent for.
+var errNotSupported = errors.New("not supported")
+
+// commandRunner runs an external command and returns its standard output.
+type commandRunner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+type execRunner struct{}
+
+// Run returns stdout; on failure the error carries the command line and its stderr.
+func (execRunner) Run(name string, args ...string) ([]byte, error) {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return out, fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return out, fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// mta is one mail transfer agent. Queue entries use the `postqueue -j` shape
+// (queue_name, queue_id, arrival_time, sender, recipients) so metrics and the
+// queue pages are the same whichever MTA is behind them.
+type mta interface {
+	Name() string
+	Queue() ([]interface{}, error)
+	Paused() (bool, error)
+	Running() (bool, error)
+	Pause() error
+	Unpause() error
+	Start() error
+	Stop() error
+
+	// Queue management. id is a queue id from Queue(), or allMessages.
+	Flush() error
+	Hold(id string) error
+	Release(id string) error
+	Delete(id string) error
+}
+
+// allMessages selects every queued message in Hold, Release and Delete.
+const allMessages = "ALL"
+
+// detectMTA picks the MTA named in config, or the first whose tools are installed.
+// Postfix is the fallback so existing setups behave as before.
+func detectMTA(name string, run commandRunner, lookPath func(string) (string, error)) mta {
+	if plugin.GOOS == "windows" {
+		return unsupportedMTA{os: plugin.GOOS}
+	}
+	switch strings.ToLower(name) {
+	case "postfix":
+		return postfixMTA{run: run}
+	case "exim", "exim4":
+		return newEximMTA(run, lookPath)
+	case "opensmtpd", "smtpd":
+		return opensmtpdMTA{run: run}
+	}
+	if _, err := lookPath("postqueue"); err == nil {
+		return postfixMTA{run: run}
+	}
+	if _, err := lookPath("exim4"); err == nil {
+		return newEximMTA(run, lookPath)
+	}
+	if _, err := lookPath("exim"); err == nil {
+		return newEximMTA(run, lookPath)
+	}
+	if _, err := lookPath("smtpctl"); err == nil {
+		return opensmtpdMTA{run: run}
+	}
+	return postfixMTA{run: run}
+}
+
+// --- Unsupported platforms ---
+
+// unsupportedMTA stands in on platforms without the Unix MTA tools (Windows),
+// so every operation fails with errNotSupported rather than an exec error.
+type unsupportedMTA struct{ os string }
+
+func (m unsupportedMTA) Name() string                { return m.os }
+func (unsupportedMTA) Queue() ([]interface{}, error) { return nil, errNotSupported }
+func (unsupportedMTA) Paused() (bool, error)         { return false, errNotSupported }
+func (unsupportedMTA) Running() (bool, error)        { return false, errNotSupported }
+func (unsupportedMTA) Pause() error                  { return errNotSupported }
+func (unsupportedMTA) Unpause() error                { return errNotSupported }
+func (unsupportedMTA) Start() error                  { return errNotSupported }
+func (unsupportedMTA) Stop() error                   { return errNotSupported }
+func (unsupportedMTA) Flush() error                  { return errNotSupported }
+func (unsupportedMTA) Hold(id string) error          { return errNotSupported }
+func (unsupportedMTA) Release(id string) error       { return errNotSupported }
+func (unsupportedMTA) Delete(id string) error        { return errNotSupported }
+
+// runAll runs each command in order, stopping at the first failure.
+func runAll(run commandRunner, cmds ...[]string) error {
+	for _, c := range cmds {
+		if _, err := run.Run(c[0], c[1:]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processRunning reports whether `ps aux` lists a process containing marker.
+func processRunning(run commandRunner, marker string) (bool, error) {
+	out, err := run.Run("ps", "aux")
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(out), marker), nil
+}
+
+// --- Postfix ---
+
+type postfixMTA struct{ run commandRunner }
+
+func (postfixMTA) Name() string { return "postfix" }
+
+func (m postfixMTA) Queue() ([]interface{}, error) {
+	out, err := m.run.Run("postqueue", "-j")
+	if err != nil {
+		return nil, err
+	}
+	return parseQueue(out), nil
+}
+
+func (m postfixMTA) Paused() (bool, error) {
+	out, err := m.run.Run("postconf", "-h", "defer_transports")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(out)) == "smtp", nil
+}
+
+func (m postfixMTA) Running() (bool, error) { return processRunning(m.run, "postfix/") }
+
+func (m postfixMTA) Pause() error {
+	return runAll(m.run,
+		[]string{"sudo", "postconf", "-e", "defer_transports=smtp"},
+		[]string{"sudo", "postfix", "reload"})
+}
+
+func (m postfixMTA) Unpause() error {
+	return runAll(m.run,
+		[]string{"sudo", "postconf", "-e", "defer_transports="},
+		[]string{"sudo", "postfix", "reload"},
+		[]string{"sudo", "postfix", "flush"})
+}
+
+func (m postfixMTA) Flush() error { return runAll(m.run, []string{"sudo", "postqueue", "-f"}) }
+func (m postfixMTA) Hold(id string) error {
+	return runAll(m.run, []string{"sudo", "postsuper", "-h", id})
+}
+func (m postfixMTA) Release(id string) error {
+	return runAll(m.run, []string{"sudo", "postsuper", "-H", id})
+}
+func (m postfixMTA) Delete(id string) error {
+	return runAll(m.run, []string{"sudo", "postsuper", "-d", id})
+}
+
+func (m postfixMTA) Start() error {
+	return runAll(m.run, []string{"sudo", "systemctl", "start", "postfix"})
+}
+func (m postfixMTA) Stop() error {
+	return runAll(m.run, []string{"sudo", "systemctl", "stop", "postfix"})
+}
+
+// --- Exim ---
+
+type eximMTA struct {
+	run    commandRunner
+	binary string // "exim4" on Debian, "exim" elsewhere; also the service name
+}
+
+func newEximMTA(run commandRunner, lookPath func(string) (string, error)) eximMTA {
+	if _, err := lookPath("exim4"); err == nil {
+		return eximMTA{run: run, binary: "exim4"}
+	}
+	return eximMTA{run: run, binary: "exim"}
+}
+
+func (eximMTA) Name() string { return "exim" }
+
+func (m eximMTA) Queue() ([]interface{}, error) {
+	out, err := m.run.Run(m.binary, "-bp")
+	if err != nil {
+		return nil, err
+	}
+	return parseEximQueue(out, time.Now()), nil
+}
+
+// Exim has no global delivery switch comparable to Postfix's defer_transports.
+func (eximMTA) Paused() (bool, error) { return false, errNotSupported }
+func (eximMTA) Pause() error          { return errNotSupported }
+func (eximMTA) Unpause() error        { return errNotSupported }
+
+func (m eximMTA) Running() (bool, error) { return processRunning(m.run, m.binary) }
+func (m eximMTA) Start() error {
+	return runAll(m.run, []string{"sudo", "systemctl", "start", m.binary})
+}
+func (m eximMTA) Stop() error {
+	return runAll(m.run, []string{"sudo", "systemctl", "stop", m.binary})
+}
+
+func (m eximMTA) Flush() error { return runAll(m.run, []string{"sudo", m.binary, "-qff"}) }
+
+// Exim freezes and thaws messages; there is no single "all" id, so ALL expands to the queue.
+func (m eximMTA) Hold(id string) error    { return m.eachMessage("-Mf", id) }
+func (m eximMTA) Release(id string) error { return m.eachMessage("-Mt", id) }
+func (m eximMTA) Delete(id string) error  { return m.eachMessage("-Mrm", id) }
+
+func (m eximMTA) eachMessage(flag, id string) error {
+	ids := []string{id}
+	if id == allMessages {
+		queue, err := m.Queue()
+		if err != nil {
+			return err
+		}
+		ids = queueIDs(queue)
+		if len(ids) == 0 {
+			return nil
+		}
+	}
+	return runAll(m.run, append([]string{"sudo", m.binary, flag}, ids...))
+}
+
+// parseEximAge converts an `exim -bp` age such as "25m", "4h" or "2d".
+func parseEximAge(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil {
+		return 0, false
+	}
+	unit := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}[s[len(s)-1]]
+	if unit == 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// eximHeader reports whether fields are a message header line ("age size id <sender>",
+// with an id like 1abcdE-000123-AB) and returns the message age.
+func eximHeader(fields []string) (time.Duration, bool) {
+	if len(fields) < 4 || strings.Count(fields[2], "-") != 2 {
+		return 0, false
+	}
+	return parseEximAge(fields[0])
+}
+
+// parseEximQueue converts `exim -bp` output into queue entries. Each message is a
+// header line "age size id <sender> [*** frozen ***]" followed by indented
+// recipient lines; recipients already delivered are prefixed with "D".
+func parseEximQueue(out []byte, now time.Time) []interface{} {
+	var queue []interface{}
+	var current map[string]interface{}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if age, ok := eximHeader(fields); ok {
+			queueName := "deferred"
+			if strings.Contains(line, "*** frozen ***") {
+				queueName = "hold"
+			}
+			current = map[string]interface{}{
+				"queue_name":   queueName,
+				"queue_id":     fields[2],
+				"arrival_time": float64(now.Add(-age).Unix()),
+				"sender":       strings.Trim(fields[3], "<>"),
+				"recipients":   []interface{}{},
+			}
+			queue = append(queue, current)
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		rcpt := strings.TrimSpace(line)
+		if strings.HasPrefix(rcpt, "D ") {
+			continue
+		}
+		current["recipients"] = append(current["recipients"].([]interface{}), map[string]interface{}{"address": rcpt})
+	}
+	return queue
+}
+
+// --- OpenSMTPD ---
+
+type opensmtpdMTA struct{ run commandRunner }
+
+func (opensmtpdMTA) Name() string { return "opensmtpd" }
+
+func (m opensmtpdMTA) Queue() ([]interface{}, error) {
+	out, err := m.run.Run("smtpctl", "show", "queue")
+	if err != nil {
+		return nil, err
+	}
+	return parseSmtpctlQueue(out), nil
+}
+
+func (m opensmtpdMTA) Paused() (bool, error) {
+	out, err := m.run.Run("smtpctl", "show", "status")
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(out), "MTA paused"), nil
+}
+
+func (opensmtpdMTA) Pause() error   { return errNotSupported }
+func (opensmtpdMTA) Unpause() error { return errNotSupported }
+
+func (m opensmtpdMTA) Running() (bool, error) { return processRunning(m.run, "smtpd") }
+func (m opensmtpdMTA) Start() error {
+	return runAll(m.run, []string{"sudo", "systemctl", "start", "opensmtpd"})
+}
+func (m opensmtpdMTA) Stop() error {
+	return runAll(m.run, []string{"sudo", "systemctl", "stop", "opensmtpd"})
+}
+
+func (m opensmtpdMTA) Flush() error {
+	return runAll(m.run, []string{"sudo", "smtpctl", "schedule", "all"})
+}
+
+// OpenSMTPD pauses envelopes rather than messages; hold and release are left unsupported.
+func (opensmtpdMTA) Hold(id string) error    { return errNotSupported }
+func (opensmtpdMTA) Release(id string) error { return errNotSupported }
+
+func (m opensmtpdMTA) Delete(id string) error {
+	if id == allMessages {
+		id = "all"
+	}
+	return runAll(m.run, []string{"sudo", "smtpctl", "remove", id})
+}
+
+// parseSmtpctlQueue converts `smtpctl show queue` output, one envelope per line:
+// evpid|type|flags|src|sender|rcpt|dest|creation|expire|lasttry|retry|runstate|error.
+// Envelopes of the same message (the first 8 hex digits of evpid) are merged.
+func parseSmtpctlQueue(out []byte) []interface{} {
+	var queue []interface{}
+	byID := make(map[string]map[string]interface{})
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		f := strings.Split(sc.Text(), "|")
+		if len(f) < 12 || len(f[0]) < 8 {
+			continue
+		}
+		msgID := f[0][:8]
+		created, _ := strconv.ParseInt(f[7], 10, 64)
+		retries, _ := strconv.Atoi(f[10])
+
+		entry, ok := byID[msgID]
+		if !ok {
+			queueName := "active"
+			switch {
+			case f[11] == "held":
+				queueName = "hold"
+			case retries > 0:
+				queueName = "deferred"
+			}
+			entry = map[string]interface{}{
+				"queue_name":   queueName,
+				"queue_id":     msgID,
+				"arrival_time": float64(created),
+				"sender":       f[4],
+				"recipients":   []interface{}{},
+			}
+			byID[msgID] = entry
+			queue = append(queue, entry)
+		}
+		rcpt := map[string]interface{}{"address": f[5]}
+		if len(f) > 12 && f[12] != "" {
+			rcpt["delay_reason"] = f[12]
+		}
+		entry["recipients"] = append(entry["recipients"].([]interface{}), rcpt)
+	}
+	return queue
+}
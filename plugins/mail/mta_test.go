@@ -0,0 +1,266 @@
+package mail
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+// fakeRunner answers commands from fixture output keyed by the full command
+// line; anything else fails like a missing binary. Commands run are recorded.
+type fakeRunner struct {
+	out  map[string]string
+	errs map[string]error
+	ran  []string
+}
+
+func (f *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	f.ran = append(f.ran, cmd)
+	if err, ok := f.errs[cmd]; ok {
+		return []byte(f.out[cmd]), err
+	}
+	out, ok := f.out[cmd]
+	if !ok {
+		return nil, errors.New("exec: " + name + ": not found")
+	}
+	return []byte(out), nil
+}
+
+// useGOOS pretends the plugin runs on name for the rest of the test.
+func useGOOS(t *testing.T, name string) {
+	t.Helper()
+	old := plugin.GOOS
+	plugin.GOOS = name
+	t.Cleanup(func() { plugin.GOOS = old })
+}
+
+// installed returns a lookPath that finds only the named binaries.
+func installed(bins ...string) func(string) (string, error) {
+	return func(name string) (string, error) {
+		for _, b := range bins {
+			if b == name {
+				return "/usr/sbin/" + name, nil
+			}
+		}
+		return "", errors.New("executable file not found in $PATH")
+	}
+}
+
+const eximFixture = `25m  2.9K 1tXyZa-000AbC-1D <app@example.com>
+          a@gmail.com
+        D b@gmail.com
+
+ 4d   12K 1tXyZb-000AbC-2E <> *** frozen ***
+          postmaster@example.net
+
+garbage line
+`
+
+const smtpctlFixture = `1a2b3c4d00000001|mta|bounce|local|app@example.com|a@gmail.com|a@gmail.com|1714564000|1714650400|0|0|pending|
+1a2b3c4d00000002|mta|bounce|local|app@example.com|b@gmail.com|b@gmail.com|1714564000|1714650400|1714564500|3|pending|Connection timed out
+5e6f7a8b00000001|mta||local|ops@example.com|c@example.org|c@example.org|1714500000|1714586400|0|0|held|
+short|line
+`
+
+// mtaFixtures is each MTA's answer to the queue, paused and running queries.
+var mtaFixtures = map[string]map[string]string{
+	"postfix": {
+		"postqueue -j":                 `{"queue_name": "deferred", "queue_id": "4VXY1", "arrival_time": 1714564000, "recipients": [{"address": "a@gmail.com"}]}`,
+		"postconf -h defer_transports": "smtp\n",
+		"ps aux":                       "root 900 master\npostfix 901 postfix/qmgr\n",
+	},
+	"exim": {
+		"exim4 -bp": eximFixture,
+		"exim -bp":  eximFixture, // the binary name depends on what this machine has installed
+		"ps aux":    "Debian-exim 700 /usr/sbin/exim4 -bd -q30m\n",
+	},
+	"opensmtpd": {
+		"smtpctl show queue":  smtpctlFixture,
+		"smtpctl show status": "MDA running\nMTA paused\nSMTP running\n",
+		"ps aux":              "_smtpd 600 smtpd: control\n",
+	},
+}
+
+func TestDetectMTA(t *testing.T) {
+	useGOOS(t, "linux")
+	run := &fakeRunner{}
+	for _, tc := range []struct {
+		name string
+		bins []string
+		want string
+		bin  string // exim binary
+	}{
+		{"", []string{"postqueue", "exim4"}, "postfix", ""},
+		{"", []string{"exim4"}, "exim", "exim4"},
+		{"", []string{"exim"}, "exim", "exim"},
+		{"", []string{"smtpctl"}, "opensmtpd", ""},
+		{"", nil, "postfix", ""},
+		{"Exim", []string{"postqueue", "exim"}, "exim", "exim"},
+		{"smtpd", []string{"postqueue"}, "opensmtpd", ""},
+		{"postfix", []string{"smtpctl"}, "postfix", ""},
+	} {
+		m := detectMTA(tc.name, run, installed(tc.bins...))
+		if m.Name() != tc.want {
+			t.Errorf("detectMTA(%q, %v) = %s, want %s", tc.name, tc.bins, m.Name(), tc.want)
+		}
+		if e, ok := m.(eximMTA); ok && e.binary != tc.bin {
+			t.Errorf("detectMTA(%q, %v): exim binary %s, want %s", tc.name, tc.bins, e.binary, tc.bin)
+		}
+	}
+}
+
+func TestParseEximQueue(t *testing.T) {
+	now := time.Unix(1714564800, 0)
+	queue := parseEximQueue([]byte(eximFixture), now)
+	if len(queue) != 2 {
+		t.Fatalf("queue = %v", queue)
+	}
+	first := queue[0].(map[string]interface{})
+	if first["queue_id"] != "1tXyZa-000AbC-1D" || first["queue_name"] != "deferred" || first["sender"] != "app@example.com" ||
+		first["arrival_time"] != float64(now.Add(-25*time.Minute).Unix()) {
+		t.Errorf("first = %v", first)
+	}
+	if rcpts := first["recipients"].([]interface{}); len(rcpts) != 1 { // b@ was already delivered
+		t.Errorf("first recipients = %v", rcpts)
+	}
+	frozen := queue[1].(map[string]interface{})
+	if frozen["queue_name"] != "hold" || frozen["sender"] != "" || frozen["arrival_time"] != float64(now.Add(-96*time.Hour).Unix()) {
+		t.Errorf("frozen = %v", frozen)
+	}
+
+	for s, want := range map[string]time.Duration{"30s": 30 * time.Second, "2w": 14 * 24 * time.Hour} {
+		if got, ok := parseEximAge(s); !ok || got != want {
+			t.Errorf("parseEximAge(%q) = %v, %v", s, got, ok)
+		}
+	}
+	for _, s := range []string{"", "5", "5y", "xm"} {
+		if _, ok := parseEximAge(s); ok {
+			t.Errorf("parseEximAge(%q) accepted", s)
+		}
+	}
+}
+
+func TestParseSmtpctlQueue(t *testing.T) {
+	queue := parseSmtpctlQueue([]byte(smtpctlFixture))
+	if len(queue) != 2 {
+		t.Fatalf("queue = %v", queue)
+	}
+	// Envelopes of one message merge; the first envelope decides the queue.
+	msg := queue[0].(map[string]interface{})
+	rcpts := msg["recipients"].([]interface{})
+	if msg["queue_id"] != "1a2b3c4d" || msg["queue_name"] != "active" || len(rcpts) != 2 || msg["arrival_time"] != float64(1714564000) {
+		t.Errorf("merged message = %v", msg)
+	}
+	if reason := rcpts[1].(map[string]interface{})["delay_reason"]; reason != "Connection timed out" {
+		t.Errorf("delay_reason = %v", reason)
+	}
+	if held := queue[1].(map[string]interface{}); held["queue_name"] != "hold" || held["sender"] != "ops@example.com" {
+		t.Errorf("held = %v", held)
+	}
+}
+
+// collectWith runs OnCollect for this host with mta configured and the runner answering from fixtures.
+func collectWith(t *testing.T, mtaName string, fixtures map[string]string) map[string]interface{} {
+	t.Helper()
+	testenv.UseTempDirs(t)
+	if err := os.WriteFile(plugin.ConfigFile, []byte(`{"config_version": 1, "mail": {"mta": "`+mtaName+`"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := &mailPlugin{runner: &fakeRunner{out: fixtures}}
+	result, err := p.OnCollect(map[string]interface{}{"host": map[string]interface{}{"address": "127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result["metrics"].(map[string]interface{})
+}
+
+func TestCollectSameMetricsPerMTA(t *testing.T) {
+	useGOOS(t, "linux")
+	for _, tc := range []struct {
+		mta      string
+		queue    int
+		delivery string // "" when the MTA has no pause switch
+	}{
+		{"postfix", 1, "down"},
+		{"exim", 2, ""},
+		{"opensmtpd", 2, "down"},
+	} {
+		metrics := collectWith(t, tc.mta, mtaFixtures[tc.mta])
+		if m := metrics["queue"].(map[string]interface{}); m["value"] != tc.queue {
+			t.Errorf("%s: queue = %v, want %d", tc.mta, m, tc.queue)
+		}
+		if m := metrics["service"].(map[string]interface{}); m["value"] != "up" || m["type"] != "status" {
+			t.Errorf("%s: service = %v", tc.mta, m)
+		}
+		for _, key := range []string{"queue_oldest_age", "queue_count_active", "queue_count_deferred", "queue_count_hold"} {
+			if _, ok := metrics[key]; !ok {
+				t.Errorf("%s: no %s", tc.mta, key)
+			}
+		}
+		delivery, ok := metrics["delivery"].(map[string]interface{})
+		switch {
+		case tc.delivery == "" && ok:
+			t.Errorf("%s: delivery reported without a pause switch: %v", tc.mta, delivery)
+		case tc.delivery != "" && (!ok || delivery["value"] != tc.delivery):
+			t.Errorf("%s: delivery = %v, want %s", tc.mta, delivery, tc.delivery)
+		}
+	}
+}
+
+func TestCommandNotSupported(t *testing.T) {
+	useGOOS(t, "linux")
+	run := &fakeRunner{}
+	for _, tc := range []struct {
+		m      mta
+		action string
+	}{
+		{opensmtpdMTA{run: run}, "pause"},
+		{opensmtpdMTA{run: run}, "unpause"},
+		{eximMTA{run: run, binary: "exim4"}, "pause"},
+		{unsupportedMTA{os: "windows"}, "flush"},
+	} {
+		err := mtaAction(tc.m, tc.action, nil)
+		if err == nil || err.Error() != "mail: "+tc.action+" is not supported on "+tc.m.Name() {
+			t.Errorf("%s on %s: err = %v", tc.action, tc.m.Name(), err)
+		}
+	}
+	if len(run.ran) != 0 {
+		t.Errorf("ran %q", run.ran)
+	}
+	if err := mtaAction(postfixMTA{run: run}, "reboot", nil); err == nil || !strings.Contains(err.Error(), "unknown action") {
+		t.Errorf("unknown action: err = %v", err)
+	}
+}
+
+func TestWindowsHasNoLocalMTA(t *testing.T) {
+	useGOOS(t, "windows")
+	run := &fakeRunner{}
+	for _, name := range []string{"", "postfix", "exim"} {
+		m := detectMTA(name, run, installed("postqueue", "exim"))
+		if _, ok := m.(unsupportedMTA); !ok {
+			t.Errorf("detectMTA(%q) = %T", name, m)
+		}
+		if _, err := m.Queue(); !errors.Is(err, errNotSupported) {
+			t.Errorf("%q: Queue err = %v", name, err)
+		}
+	}
+
+	testenv.UseTempDirs(t)
+	if err := os.WriteFile(plugin.ConfigFile, []byte(`{"config_version": 1, "mail": {"mta": "postfix"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := &mailPlugin{runner: run}
+	_, err := p.OnCollect(map[string]interface{}{"host": map[string]interface{}{"address": "localhost"}})
+	if !errors.Is(err, errNotSupported) || !strings.Contains(err.Error(), "use the smtp action") {
+		t.Errorf("local collection on windows: err = %v", err)
+	}
+	if len(run.ran) != 0 {
+		t.Errorf("ran %q", run.ran)
+	}
+}
@@ -0,0 +1,115 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+)
+
+const (
+	maxDeferralReasons = 5
+	maxReasonLength    = 80
+)
+
+// parseQueue decodes `postqueue -j` output, one JSON object per line.
+// Malformed lines are skipped so one bad entry does not hide the rest.
+func parseQueue(data []byte) []interface{} {
+	var queue []interface{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		queue = append(queue, entry)
+	}
+	return queue
+}
+
+// normalizeReason collapses whitespace and shortens a delay_reason so that
+// equivalent deferrals group together.
+func normalizeReason(reason string) string {
+	reason = strings.Join(strings.Fields(reason), " ")
+	if r := []rune(reason); len(r) > maxReasonLength {
+		reason = string(r[:maxReasonLength-1]) + "…"
+	}
+	return reason
+}
+
+// queueMetrics summarizes the queue: oldest message age, counts per queue name,
+// and the most common deferral reasons.
+func queueMetrics(queue []interface{}, now time.Time) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	perQueue := map[string]int{"active": 0, "deferred": 0, "hold": 0}
+	reasons := make(map[string]int)
+	var oldest int64
+
+	for _, entry := range queue {
+		entryMap, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, ok := entryMap["queue_name"].(string); ok {
+			perQueue[name]++
+		}
+		if arrival, ok := entryMap["arrival_time"].(float64); ok && arrival > 0 {
+			if oldest == 0 || int64(arrival) < oldest {
+				oldest = int64(arrival)
+			}
+		}
+		recipients, _ := entryMap["recipients"].([]interface{})
+		for _, r := range recipients {
+			if rm, ok := r.(map[string]interface{}); ok {
+				if reason, ok := rm["delay_reason"].(string); ok && reason != "" {
+					reasons[normalizeReason(reason)]++
+				}
+			}
+		}
+	}
+
+	var age int64
+	if oldest > 0 {
+		age = now.Unix() - oldest
+		if age < 0 {
+			age = 0
+		}
+	}
+	metrics["queue_oldest_age"] = map[string]interface{}{"name": "queue_oldest_age_seconds", "label": "Oldest", "value": age, "category": "mail", "type": "gauge", "unit": plugin.UnitSeconds}
+
+	for name, count := range perQueue {
+		metrics["queue_count_"+name] = map[string]interface{}{"name": "queue_count", "label": fmt.Sprintf("Queue %s", name), "value": count, "category": "mail", "type": "gauge", "instance": name}
+	}
+
+	type reasonCount struct {
+		reason string
+		count  int
+	}
+	ranked := make([]reasonCount, 0, len(reasons))
+	for r, c := range reasons {
+		ranked = append(ranked, reasonCount{r, c})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].count != ranked[j].count {
+			return ranked[i].count > ranked[j].count
+		}
+		return ranked[i].reason < ranked[j].reason
+	})
+	for i, rc := range ranked {
+		if i == maxDeferralReasons {
+			break
+		}
+		metrics[fmt.Sprintf("deferral_reason_%d", i+1)] = map[string]interface{}{"name": "deferral_reason", "label": fmt.Sprintf("Deferral #%d", i+1), "value": rc.count, "category": "mail", "type": "gauge", "instance": rc.reason}
+	}
+	return metrics
+}
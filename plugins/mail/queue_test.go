@@ -0,0 +1,109 @@
+package mail
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+)
+
+// queueFixture is `postqueue -j` output: three queues, repeated deferral reasons
+// differing only in whitespace, and a truncated line in the middle.
+const queueFixture = `{"queue_name": "deferred", "queue_id": "A1", "arrival_time": 1714219200, "message_size": 2048, "sender": "app@example.com", "recipients": [{"address": "a@gmail.com", "delay_reason": "connect to gmail-smtp-in.l.google.com[142.250.1.26]:25: Connection timed out"}, {"address": "b@gmail.com", "delay_reason": "connect to gmail-smtp-in.l.google.com[142.250.1.26]:25:   Connection timed out"}]}
+{"queue_name": "deferred", "queue_id": "A2", "arrival_time": 1714550400, "recipients": [{"address": "c@example.net", "delay_reason": "host mx.example.net said: 452 4.2.2 Mailbox full"}]}
+{"queue_name": "active", "queue_id": "B1", "arrival_time": 1714564
+{"queue_name": "active", "queue_id": "B2", "arrival_time": 1714564790, "recipients": [{"address": "d@example.org"}]}
+
+{"queue_name": "hold", "queue_id": "C1", "arrival_time": 1714500000, "recipients": [{"address": "e@example.org", "delay_reason": ""}]}
+{"queue_name": "incoming", "queue_id": "D1", "recipients": []}
+`
+
+func TestParseQueue(t *testing.T) {
+	queue := parseQueue([]byte(queueFixture))
+	if len(queue) != 5 {
+		t.Fatalf("parsed %d entries, want 5", len(queue))
+	}
+	if id := queue[2].(map[string]interface{})["queue_id"]; id != "B2" {
+		t.Errorf("entry after the malformed line = %v", id)
+	}
+	if got := parseQueue(nil); len(got) != 0 {
+		t.Errorf("empty queue = %v", got)
+	}
+}
+
+func TestQueueMetrics(t *testing.T) {
+	now := time.Unix(1714564800, 0) // 2024-05-01T12:00:00Z
+	metrics := queueMetrics(parseQueue([]byte(queueFixture)), now)
+
+	oldest := metrics["queue_oldest_age"].(map[string]interface{})
+	if oldest["value"] != int64(345600) || oldest["unit"] != plugin.UnitSeconds { // four days
+		t.Errorf("oldest = %v", oldest)
+	}
+
+	for name, want := range map[string]int{"active": 1, "deferred": 2, "hold": 1, "incoming": 1} {
+		m, ok := metrics["queue_count_"+name].(map[string]interface{})
+		if !ok || m["value"] != want || m["instance"] != name {
+			t.Errorf("queue_count_%s = %v, want %d", name, metrics["queue_count_"+name], want)
+		}
+	}
+
+	first := metrics["deferral_reason_1"].(map[string]interface{})
+	if first["value"] != 2 || first["instance"] != "connect to gmail-smtp-in.l.google.com[142.250.1.26]:25: Connection timed out" {
+		t.Errorf("top reason = %v", first)
+	}
+	if second := metrics["deferral_reason_2"].(map[string]interface{}); second["value"] != 1 || !strings.Contains(second["instance"].(string), "Mailbox full") {
+		t.Errorf("second reason = %v", second)
+	}
+	if _, ok := metrics["deferral_reason_3"]; ok {
+		t.Error("empty delay_reason counted")
+	}
+}
+
+func TestQueueMetricsEmpty(t *testing.T) {
+	metrics := queueMetrics(nil, time.Now())
+	if age := metrics["queue_oldest_age"].(map[string]interface{})["value"]; age != int64(0) {
+		t.Errorf("oldest age of an empty queue = %v", age)
+	}
+	for _, name := range []string{"active", "deferred", "hold"} {
+		if m := metrics["queue_count_"+name].(map[string]interface{}); m["value"] != 0 {
+			t.Errorf("%s = %v", name, m)
+		}
+	}
+}
+
+func TestDeferralReasonsCapped(t *testing.T) {
+	var queue []interface{}
+	for i, reason := range []string{"r-a", "r-b", "r-b", "r-c", "r-d", "r-e", "r-f", "r-f", "r-f"} {
+		queue = append(queue, map[string]interface{}{
+			"queue_name":   "deferred",
+			"arrival_time": float64(1000 + i),
+			"recipients":   []interface{}{map[string]interface{}{"delay_reason": reason}},
+		})
+	}
+	metrics := queueMetrics(queue, time.Unix(2000, 0))
+	// By count, then alphabetically among the single occurrences.
+	for i, want := range []string{"r-f", "r-b", "r-a", "r-c", "r-d"} {
+		m := metrics[fmt.Sprintf("deferral_reason_%d", i+1)].(map[string]interface{})
+		if m["instance"] != want {
+			t.Errorf("reason %d = %v, want %s", i+1, m["instance"], want)
+		}
+	}
+	if _, ok := metrics["deferral_reason_6"]; ok {
+		t.Error("more than five reasons reported")
+	}
+	if age := metrics["queue_oldest_age"].(map[string]interface{})["value"]; age != int64(1000) {
+		t.Errorf("oldest = %v", age)
+	}
+}
+
+func TestNormalizeReason(t *testing.T) {
+	if got := normalizeReason("  host  said:\n 450\tbusy "); got != "host said: 450 busy" {
+		t.Errorf("whitespace collapsed to %q", got)
+	}
+	long := normalizeReason(strings.Repeat("é", 200))
+	if r := []rune(long); len(r) != maxReasonLength || r[len(r)-1] != '…' {
+		t.Errorf("long reason = %d runes, %q", len(r), long)
+	}
+}
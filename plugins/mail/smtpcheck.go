@@ -0,0 +1,231 @@
+package mail
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"time"
+
+	plugin "observer/base"
+)
+
+const defaultSMTPTimeout = 10 * time.Second
+
+// smtpError is a negative SMTP reply; 4xx is transient and 5xx permanent.
+type smtpError struct {
+	step string
+	code int
+	msg  string
+}
+
+func (e *smtpError) Error() string { return fmt.Sprintf("%s: %d %s", e.step, e.code, e.msg) }
+
+// smtpResult is what a remote check observed.
+type smtpResult struct {
+	connectTime time.Duration
+	banner      string
+	certExpiry  time.Time // zero without STARTTLS
+	certErr     error     // chain or name verification failure, reported but not fatal
+}
+
+// isLocalAddress reports whether address names this machine, in which case the
+// mail plugin inspects the local MTA instead of dialling SMTP.
+func isLocalAddress(address string) bool {
+	if address == "" || address == "localhost" {
+		return true
+	}
+	if name, err := os.Hostname(); err == nil && address == name {
+		return true
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// smtpConn is one SMTP session with a per-step deadline.
+type smtpConn struct {
+	conn    net.Conn
+	text    *textproto.Conn
+	timeout time.Duration
+}
+
+// cmd sends a command and reads the reply, returning an smtpError for 4xx/5xx.
+func (c *smtpConn) cmd(step string, expect int, format string, args ...interface{}) (string, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if format != "" {
+		if err := c.text.PrintfLine(format, args...); err != nil {
+			return "", fmt.Errorf("%s: %w", step, err)
+		}
+	}
+	_, msg, err := c.text.ReadResponse(expect)
+	if err != nil {
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) {
+			return "", &smtpError{step: step, code: protoErr.Code, msg: protoErr.Msg}
+		}
+		return "", fmt.Errorf("%s: %w", step, err)
+	}
+	return msg, nil
+}
+
+// checkSMTP runs the SMTP dialogue against addr: banner, EHLO, optional STARTTLS
+// (re-issuing EHLO), optional MAIL FROM/RCPT TO/RSET probe, then QUIT.
+func checkSMTP(addr, serverName string, cfg plugin.MailSMTPConfig, tlsConfig *tls.Config) (smtpResult, error) {
+	var res smtpResult
+	timeout := defaultSMTPTimeout
+	if cfg.TimeoutMs > 0 {
+		timeout = time.Duration(cfg.TimeoutMs) * time.Millisecond
+	}
+	helo := cfg.Helo
+	if helo == "" {
+		helo, _ = os.Hostname()
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return res, fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+	c := &smtpConn{conn: conn, text: textproto.NewConn(conn), timeout: timeout}
+
+	banner, err := c.cmd("banner", 220, "")
+	if err != nil {
+		return res, err
+	}
+	res.connectTime = time.Since(start)
+	res.banner = banner
+
+	if _, err := c.cmd("EHLO", 250, "EHLO %s", helo); err != nil {
+		return res, err
+	}
+
+	if cfg.StartTLS {
+		if _, err := c.cmd("STARTTLS", 220, "STARTTLS"); err != nil {
+			return res, err
+		}
+		if tlsConfig == nil {
+			// Skip verification during the handshake so an expired or self-signed
+			// certificate can still be reported; it is verified separately below.
+			tlsConfig = &tls.Config{ServerName: serverName, InsecureSkipVerify: true}
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return res, fmt.Errorf("STARTTLS handshake: %w", err)
+		}
+		certs := tlsConn.ConnectionState().PeerCertificates
+		if len(certs) > 0 {
+			res.certExpiry = certs[0].NotAfter
+			res.certErr = verifyChain(certs, serverName)
+		}
+		c = &smtpConn{conn: tlsConn, text: textproto.NewConn(tlsConn), timeout: timeout}
+		if _, err := c.cmd("EHLO", 250, "EHLO %s", helo); err != nil {
+			return res, err
+		}
+	}
+
+	if cfg.Probe && cfg.Recipient != "" {
+		from := cfg.From
+		if from == "" {
+			from = "postmaster@" + helo
+		}
+		if _, err := c.cmd("MAIL FROM", 250, "MAIL FROM:<%s>", from); err != nil {
+			return res, err
+		}
+		if _, err := c.cmd("RCPT TO", 25, "RCPT TO:<%s>", cfg.Recipient); err != nil {
+			return res, err
+		}
+		if _, err := c.cmd("RSET", 250, "RSET"); err != nil {
+			return res, err
+		}
+	}
+
+	c.cmd("QUIT", 221, "QUIT") //nolint:errcheck
+	return res, nil
+}
+
+// verifyChain checks the presented chain against the system roots and the server name.
+func verifyChain(certs []*x509.Certificate, serverName string) error {
+	opts := x509.VerifyOptions{DNSName: serverName, Intermediates: x509.NewCertPool()}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// smtpStatus maps a check error to a status: transient replies (4xx) and
+// timeouts are warnings, permanent replies (5xx) and refused connections are down.
+func smtpStatus(err error) string {
+	if err == nil {
+		return "up"
+	}
+	var se *smtpError
+	if errors.As(err, &se) {
+		if se.code >= 400 && se.code < 500 {
+			return "warning"
+		}
+		return "down"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "warning"
+	}
+	return "down"
+}
+
+// smtpMetrics turns a check into connect_time_ms, banner, cert_days_left and a status.
+func smtpMetrics(res smtpResult, err error, now time.Time) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	status := map[string]interface{}{"name": "smtp", "label": "SMTP", "value": smtpStatus(err), "category": "mail", "type": "status"}
+	if err != nil {
+		status["reason"] = err.Error()
+	}
+	metrics["smtp_status"] = status
+
+	if res.banner != "" {
+		ms := float64(res.connectTime) / float64(time.Millisecond)
+		metrics["connect_time_ms"] = map[string]interface{}{"name": "connect_time_ms", "label": "Connect (ms)", "value": fmt.Sprintf("%.1f", ms), "value_num": ms, "category": "mail", "type": "gauge", "unit": plugin.UnitMillis}
+		metrics["banner"] = map[string]interface{}{"name": "banner", "label": "Banner", "value": res.banner, "category": "mail", "type": "text", "dedup": true}
+	}
+	if !res.certExpiry.IsZero() {
+		days := int(res.certExpiry.Sub(now).Hours() / 24)
+		cert := map[string]interface{}{"name": "cert_days_left", "label": "Certificate (days)", "value": days, "category": "mail", "type": "gauge", "unit": plugin.UnitDays, "not_after": res.certExpiry.UTC().Format(time.RFC3339)}
+		if res.certErr != nil {
+			cert["tls_error"] = res.certErr.Error()
+		}
+		metrics["cert_days_left"] = cert
+	}
+	return metrics
+}
+
+// collectRemote checks a remote mail server over SMTP.
+func (p *mailPlugin) collectRemote(address string, cfg plugin.MailSMTPConfig) map[string]interface{} {
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := net.JoinHostPort(address, strconv.Itoa(port))
+	res, err := checkSMTP(addr, address, cfg, nil)
+	return smtpMetrics(res, err, time.Now())
+}
@@ -0,0 +1,276 @@
+package mail
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+// fakeSMTP is an in-process SMTP server. Replies can be overridden per command
+// verb, and STARTTLS upgrades with cert when one is set. Commands received are
+// recorded in order.
+type fakeSMTP struct {
+	addr    string
+	banner  string            // default "220 mx.test ESMTP"
+	replies map[string]string // verb → reply line
+	cert    *tls.Certificate
+	silent  bool // accept connections but never answer
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newFakeSMTP(t *testing.T, f *fakeSMTP) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f.addr = ln.Addr().String()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	if f.silent {
+		time.Sleep(time.Second)
+		return
+	}
+	banner := f.banner
+	if banner == "" {
+		banner = "220 mx.test ESMTP"
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	reply := func(line string) {
+		rw.WriteString(line + "\r\n")
+		rw.Flush()
+	}
+	reply(banner)
+	if !strings.HasPrefix(banner, "220") {
+		return
+	}
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		if strings.HasPrefix(strings.ToUpper(line), "MAIL FROM") {
+			verb = "MAIL"
+		} else if strings.HasPrefix(strings.ToUpper(line), "RCPT TO") {
+			verb = "RCPT"
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, line)
+		f.mu.Unlock()
+
+		if r, ok := f.replies[verb]; ok {
+			reply(r)
+			continue
+		}
+		switch verb {
+		case "EHLO":
+			reply("250-mx.test\r\n250-STARTTLS\r\n250 8BITMIME")
+		case "STARTTLS":
+			reply("220 2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*f.cert}})
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			rw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("250 2.0.0 Ok")
+		}
+	}
+}
+
+func (f *fakeSMTP) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+// selfSigned returns a certificate for mx.test expiring at notAfter.
+func selfSigned(t *testing.T, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mx.test"},
+		DNSNames:     []string{"mx.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCheckSMTPPlain(t *testing.T) {
+	srv := newFakeSMTP(t, &fakeSMTP{})
+	res, err := checkSMTP(srv.addr, "mx.test", plugin.MailSMTPConfig{Helo: "probe.example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.banner != "mx.test ESMTP" || res.connectTime <= 0 || !res.certExpiry.IsZero() {
+		t.Errorf("result = %+v", res)
+	}
+	if got := strings.Join(srv.received(), "; "); got != "EHLO probe.example.com; QUIT" {
+		t.Errorf("commands = %q", got)
+	}
+}
+
+func TestCheckSMTPStartTLSAndProbe(t *testing.T) {
+	expiry := time.Now().Add(30*24*time.Hour + time.Hour).Truncate(time.Second)
+	srv := newFakeSMTP(t, &fakeSMTP{cert: selfSigned(t, expiry)})
+	cfg := plugin.MailSMTPConfig{Helo: "probe.example.com", StartTLS: true, Probe: true, Recipient: "postmaster@mx.test"}
+
+	res, err := checkSMTP(srv.addr, "mx.test", cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.certExpiry.Equal(expiry) {
+		t.Errorf("cert expiry %v, want %v", res.certExpiry, expiry)
+	}
+	// Self-signed: reported, but the check still succeeds.
+	if res.certErr == nil {
+		t.Error("self-signed chain verified")
+	}
+	want := "EHLO probe.example.com; STARTTLS; EHLO probe.example.com; MAIL FROM:<postmaster@probe.example.com>; RCPT TO:<postmaster@mx.test>; RSET; QUIT"
+	if got := strings.Join(srv.received(), "; "); got != want {
+		t.Errorf("commands = %q", got)
+	}
+	for _, cmd := range srv.received() {
+		if cmd == "DATA" {
+			t.Error("probe sent DATA")
+		}
+	}
+
+	metrics := smtpMetrics(res, err, time.Now())
+	cert := metrics["cert_days_left"].(map[string]interface{})
+	if cert["value"] != 30 || cert["tls_error"] == nil || cert["unit"] != plugin.UnitDays {
+		t.Errorf("cert_days_left = %v", cert)
+	}
+	if status := metrics["smtp_status"].(map[string]interface{}); status["value"] != "up" {
+		t.Errorf("status = %v", status)
+	}
+}
+
+func TestCheckSMTPRejections(t *testing.T) {
+	probe := plugin.MailSMTPConfig{Probe: true, Recipient: "nobody@mx.test", From: "monitor@example.com", TimeoutMs: 2000}
+	for _, tc := range []struct {
+		name   string
+		srv    *fakeSMTP
+		cfg    plugin.MailSMTPConfig
+		status string
+		reason string
+	}{
+		{"greylisted", &fakeSMTP{replies: map[string]string{"RCPT": "450 4.2.0 Greylisted, try again later"}}, probe, "warning", "RCPT TO: 450"},
+		{"unknown user", &fakeSMTP{replies: map[string]string{"RCPT": "550 5.1.1 User unknown"}}, probe, "down", "RCPT TO: 550"},
+		{"sender rejected", &fakeSMTP{replies: map[string]string{"MAIL": "553 5.7.1 Sender address rejected"}}, probe, "down", "MAIL FROM: 553"},
+		{"busy banner", &fakeSMTP{banner: "421 4.3.2 Service shutting down"}, probe, "warning", "banner: 421"},
+		{"no STARTTLS", &fakeSMTP{replies: map[string]string{"STARTTLS": "502 5.5.1 Command not implemented"}}, plugin.MailSMTPConfig{StartTLS: true}, "down", "STARTTLS: 502"},
+		{"timeout", &fakeSMTP{silent: true}, plugin.MailSMTPConfig{TimeoutMs: 100}, "warning", "i/o timeout"},
+	} {
+		srv := newFakeSMTP(t, tc.srv)
+		res, err := checkSMTP(srv.addr, "mx.test", tc.cfg, nil)
+		if err == nil {
+			t.Errorf("%s: no error", tc.name)
+			continue
+		}
+		metrics := smtpMetrics(res, err, time.Now())
+		status := metrics["smtp_status"].(map[string]interface{})
+		if status["value"] != tc.status || !strings.Contains(status["reason"].(string), tc.reason) {
+			t.Errorf("%s: status %v (%v), want %s (%s)", tc.name, status["value"], status["reason"], tc.status, tc.reason)
+		}
+	}
+}
+
+func TestCheckSMTPRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	p := &mailPlugin{}
+	host, port, _ := net.SplitHostPort(addr)
+	n, _ := strconv.Atoi(port)
+	metrics := p.collectRemote(host, plugin.MailSMTPConfig{Port: n, TimeoutMs: 500})
+	status := metrics["smtp_status"].(map[string]interface{})
+	if status["value"] != "down" || !strings.HasPrefix(status["reason"].(string), "connect:") {
+		t.Errorf("status = %v", status)
+	}
+	if _, ok := metrics["connect_time_ms"]; ok {
+		t.Error("connect time reported without a banner")
+	}
+}
+
+func TestCollectRoutesToSMTP(t *testing.T) {
+	srv := newFakeSMTP(t, &fakeSMTP{})
+	host, port, _ := net.SplitHostPort(srv.addr)
+	testenv.UseTempDirs(t)
+	if err := os.WriteFile(plugin.ConfigFile, []byte(`{"config_version": 1, "mail": {"smtp": {"port": `+port+`}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run := &fakeRunner{}
+	p := &mailPlugin{runner: run}
+
+	// The smtp action forces a remote check even for this machine's address.
+	result, err := p.OnCollect(map[string]interface{}{"action": "smtp", "host": map[string]interface{}{"address": host}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := result["metrics"].(map[string]interface{})
+	if metrics["banner"].(map[string]interface{})["value"] != "mx.test ESMTP" {
+		t.Errorf("metrics = %v", metrics)
+	}
+	if m := metrics["connect_time_ms"].(map[string]interface{}); m["unit"] != plugin.UnitMillis || m["value_num"].(float64) <= 0 {
+		t.Errorf("connect_time_ms = %v", m)
+	}
+	if len(run.ran) != 0 {
+		t.Errorf("local MTA queried: %q", run.ran)
+	}
+
+	for address, want := range map[string]bool{"": true, "localhost": true, "127.0.0.1": true, "::1": true, "192.0.2.10": false, "mx.example.com": false} {
+		if got := isLocalAddress(address); got != want {
+			t.Errorf("isLocalAddress(%q) = %v", address, got)
+		}
+	}
+}
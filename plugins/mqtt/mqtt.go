@@ -0,0 +1,232 @@
+// Package mqtt publishes the latest stored metrics of every host to an MQTT
+// broker, for Home Assistant, Node-RED and other consumers. Run it with
+// `nord plugin run mqtt send`, or after every daemon cycle by listing "mqtt"
+// in daemon.collect.publish.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+	"observer/store"
+)
+
+const (
+	defaultPrefix          = "nord"
+	defaultDiscoveryPrefix = "homeassistant"
+	flushTimeout           = 30 * time.Second
+)
+
+// mqttPlugin publishes metrics to a broker.
+type mqttPlugin struct {
+	plugin.BasePlugin
+
+	// The connection is kept between sends so the daemon publishes every
+	// cycle over one session; it is replaced when the configuration changes.
+	mu        sync.Mutex
+	pub       *publisher
+	announced map[string]bool // discovery configs already published on pub
+}
+
+func init() {
+	plugins.Register(&mqttPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *mqttPlugin) Name() string {
+	return "Mqtt"
+}
+
+// OnCommand handles "send".
+func (p *mqttPlugin) OnCommand(args map[string]string) error {
+	switch args["action"] {
+	case "send":
+		return p.send()
+	}
+	return fmt.Errorf("unknown command for mqtt plugin: %s", args["action"])
+}
+
+// payload is the JSON body of a metric message.
+type payload struct {
+	Value       string    `json:"value"`
+	ValueNum    *float64  `json:"value_num,omitempty"`
+	Values      []float64 `json:"values,omitempty"`
+	Instance    string    `json:"instance,omitempty"`
+	Unit        string    `json:"unit,omitempty"`
+	CollectedAt string    `json:"collected_at"`
+}
+
+// send publishes the latest metrics of the configured hosts and of nord
+// itself to <prefix>/<host>/<plugin>/<name>[/<instance>].
+func (p *mqttPlugin) send() error {
+	p.Controller.Println("--- Publishing metrics to MQTT ---")
+	data, err := plugin.ReadConfigFile()
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("could not parse config file: %w", err)
+	}
+	mc := cfg.MQTT
+	if mc.Broker == "" {
+		return errors.New("mqtt: no broker configured (set mqtt.broker)")
+	}
+	if p.Controller.Store == nil {
+		return errors.New("mqtt: send needs a database (see database.url)")
+	}
+	if mc.ClientID == "" {
+		mc.ClientID = "nord-" + plugin.AgentHostName()
+	}
+	if mc.TopicPrefix == "" {
+		mc.TopicPrefix = defaultPrefix
+	}
+	if mc.DiscoveryPrefix == "" {
+		mc.DiscoveryPrefix = defaultDiscoveryPrefix
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pub != nil && p.pub.cfg != mc {
+		p.pub.close()
+		p.pub = nil
+	}
+	if p.pub == nil {
+		pub, err := newPublisher(mc)
+		if err != nil {
+			return err
+		}
+		p.pub, p.announced = pub, make(map[string]bool)
+	}
+	pub := p.pub
+
+	keys := []string{plugin.AgentHostKey}
+	for key := range cfg.Hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	start := time.Now()
+	sent, dropped := pub.sent.Load(), pub.dropped.Load()
+	queued := 0
+	for _, key := range keys {
+		records, err := p.Controller.Store.LatestMetrics(context.Background(), key)
+		if err != nil {
+			return fmt.Errorf("store: %w", err)
+		}
+		for _, r := range records {
+			topic := metricTopic(mc.TopicPrefix, key, r)
+			body, _ := json.Marshal(payload{
+				Value: r.Value, ValueNum: r.ValueNum, Values: r.Values, Instance: r.Instance, Unit: r.Unit,
+				CollectedAt: r.CollectedAt.UTC().Format(time.RFC3339),
+			})
+			pub.enqueue(outMsg{topic: topic, payload: body, retain: mc.Retain})
+			queued++
+			if mc.Discovery {
+				if d, ok := discovery(mc.DiscoveryPrefix, key, topic, r); ok && !p.announced[d.topic] {
+					pub.enqueue(d)
+					p.announced[d.topic] = true
+					queued++
+				}
+			}
+		}
+	}
+
+	flushErr := pub.flush(flushTimeout)
+	sent, dropped = pub.sent.Load()-sent, pub.dropped.Load()-dropped
+	result := plugin.DeliveryResult{
+		Destination: "mqtt", Endpoint: mc.Broker, Status: plugin.ResultOK,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	switch {
+	case flushErr != nil:
+		p.Controller.Printf("  !_ mqtt: %s: %v (%d of %d published)\n", mc.Broker, flushErr, sent, queued)
+		result.Status, result.Error = plugin.ResultError, flushErr.Error()
+		p.Controller.Warn("mqtt", fmt.Sprintf("%s: %v", mc.Broker, flushErr))
+	case dropped > 0:
+		p.Controller.Printf("  !_ mqtt: published %d messages to %s, %d dropped (queue full)\n", sent, mc.Broker, dropped)
+		p.Controller.Warn("mqtt", fmt.Sprintf("%d messages dropped", dropped))
+	default:
+		p.Controller.Printf("  |_ mqtt: published %d messages to %s\n", sent, mc.Broker)
+	}
+	p.Controller.Publish(plugin.Event{Topic: plugin.EventDelivery, Source: "mqtt", Data: result})
+	return nil
+}
+
+// metricTopic is <prefix>/<host>/<plugin>/<name>, with /<instance> when set.
+func metricTopic(prefix, hostKey string, r store.MetricRecord) string {
+	parts := []string{prefix, topicSegment(hostKey), topicSegment(r.Plugin), topicSegment(r.Name)}
+	if r.Instance != "" {
+		parts = append(parts, topicSegment(r.Instance))
+	}
+	return strings.Join(parts, "/")
+}
+
+// topicSegment replaces the characters MQTT gives meaning to in topics.
+func topicSegment(s string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(s)
+}
+
+// discovery builds the retained Home Assistant discovery config for a numeric
+// (sensor) or status (binary_sensor) metric; other metrics are not announced.
+func discovery(prefix, hostKey, stateTopic string, r store.MetricRecord) (outMsg, bool) {
+	node := objectID(hostKey)
+	object := objectID(r.Plugin + "_" + r.Name)
+	name := r.Name
+	if r.Instance != "" {
+		object += "_" + objectID(r.Instance)
+		name += " " + r.Instance
+	}
+	cfg := map[string]interface{}{
+		"name":        name,
+		"unique_id":   "nord_" + node + "_" + object,
+		"state_topic": stateTopic,
+		"device": map[string]interface{}{
+			"identifiers":  []string{"nord_" + node},
+			"name":         r.HostName,
+			"manufacturer": "nord",
+		},
+	}
+	var component string
+	switch {
+	case r.MetricType == "status":
+		component = "binary_sensor"
+		cfg["value_template"] = "{{ value_json.value }}"
+		cfg["payload_on"] = "up"
+		cfg["payload_off"] = "down"
+	case (r.MetricType == "gauge" || r.MetricType == "counter") && r.ValueNum != nil:
+		component = "sensor"
+		cfg["value_template"] = "{{ value_json.value_num }}"
+		cfg["state_class"] = "measurement"
+		if r.MetricType == "counter" {
+			cfg["state_class"] = "total_increasing"
+		}
+		if r.Unit != "" {
+			cfg["unit_of_measurement"] = r.Unit
+		}
+	default:
+		return outMsg{}, false
+	}
+	body, _ := json.Marshal(cfg)
+	topic := fmt.Sprintf("%s/%s/%s/%s/config", prefix, component, node, object)
+	return outMsg{topic: topic, payload: body, retain: true}, true
+}
+
+// objectID keeps the characters Home Assistant allows in ids.
+func objectID(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
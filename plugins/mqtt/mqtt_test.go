@@ -0,0 +1,544 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+// session is what a client sent in its CONNECT.
+type session struct {
+	clientID, username, password string
+}
+
+// message is one PUBLISH received by the broker.
+type message struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+}
+
+// broker is a minimal MQTT 3.1.1 broker: it accepts every connection,
+// acknowledges QoS 1 and 2 publishes and records them. While down it hangs
+// up on new connections.
+type broker struct {
+	addr string
+
+	mu       sync.Mutex
+	down     bool
+	sessions []session
+	messages []message
+	conns    []net.Conn
+}
+
+func newBroker(t *testing.T, ln net.Listener) *broker {
+	t.Helper()
+	b := &broker{addr: ln.Addr().String()}
+	t.Cleanup(func() {
+		ln.Close()
+		b.drop()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			if b.down {
+				conn.Close()
+			} else {
+				b.conns = append(b.conns, conn)
+				go b.serve(conn)
+			}
+			b.mu.Unlock()
+		}
+	}()
+	return b
+}
+
+func startBroker(t *testing.T) *broker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newBroker(t, ln)
+}
+
+// drop closes every open client connection.
+func (b *broker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.conns {
+		c.Close()
+	}
+	b.conns = nil
+}
+
+func (b *broker) setDown(down bool) {
+	b.mu.Lock()
+	b.down = down
+	b.mu.Unlock()
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		kind, flags, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch kind {
+		case 1: // CONNECT
+			s, ok := parseConnect(body)
+			if !ok {
+				return
+			}
+			b.mu.Lock()
+			b.sessions = append(b.sessions, s)
+			b.mu.Unlock()
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH
+			m := message{qos: flags >> 1 & 3, retain: flags&1 == 1}
+			n := int(binary.BigEndian.Uint16(body))
+			m.topic, body = string(body[2:2+n]), body[2+n:]
+			var id []byte
+			if m.qos > 0 {
+				id, body = body[:2], body[2:]
+			}
+			m.payload = append([]byte(nil), body...)
+			b.mu.Lock()
+			b.messages = append(b.messages, m)
+			b.mu.Unlock()
+			switch m.qos {
+			case 1:
+				conn.Write(append([]byte{0x40, 2}, id...))
+			case 2:
+				conn.Write(append([]byte{0x50, 2}, id...))
+			}
+		case 6: // PUBREL
+			conn.Write(append([]byte{0x70, 2}, body[:2]...))
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+// readPacket reads one control packet.
+func readPacket(r *bufio.Reader) (kind, flags byte, body []byte, err error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		length |= int(c&0x7f) << shift
+		if c&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	body = make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return first >> 4, first & 0x0f, body, nil
+}
+
+func parseConnect(body []byte) (session, bool) {
+	var s session
+	str := func() string {
+		if len(body) < 2 {
+			return ""
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+n {
+			return ""
+		}
+		v := string(body[2 : 2+n])
+		body = body[2+n:]
+		return v
+	}
+	if str() != "MQTT" || len(body) < 4 {
+		return s, false
+	}
+	flags := body[1]
+	body = body[4:]
+	s.clientID = str()
+	if flags&0x04 != 0 {
+		str()
+		str()
+	}
+	if flags&0x80 != 0 {
+		s.username = str()
+	}
+	if flags&0x40 != 0 {
+		s.password = str()
+	}
+	return s, true
+}
+
+func (b *broker) received() ([]session, []message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]session(nil), b.sessions...), append([]message(nil), b.messages...)
+}
+
+// wait returns the messages once n have arrived; QoS 0 publishes complete
+// before the broker has read them.
+func (b *broker) wait(t *testing.T, n int) []message {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		_, messages := b.received()
+		if len(messages) >= n || time.Now().After(deadline) {
+			return messages
+		}
+	}
+}
+
+// latestStore serves fixed latest metrics per host key.
+type latestStore struct {
+	store.Store
+	latest map[string][]store.MetricRecord
+}
+
+func (s *latestStore) LatestMetrics(ctx context.Context, hostKey string) ([]store.MetricRecord, error) {
+	return s.latest[hostKey], nil
+}
+
+// writeConfig points the configuration file at a temp file holding cfg.
+func writeConfig(t *testing.T, cfg string) {
+	t.Helper()
+	old := plugin.ConfigFile
+	plugin.ConfigFile = filepath.Join(t.TempDir(), "config.json")
+	t.Cleanup(func() { plugin.ConfigFile = old })
+	if err := os.WriteFile(plugin.ConfigFile, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func num(v float64) *float64 { return &v }
+
+var collected = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testStore() *latestStore {
+	return &latestStore{latest: map[string][]store.MetricRecord{
+		"core1": {
+			{HostKey: "core1", HostName: "Core switch", Plugin: "ping", Name: "status", MetricType: "status", Value: "up", ValueNum: num(1), CollectedAt: collected},
+			{HostKey: "core1", HostName: "Core switch", Plugin: "snmp", Name: "if_in_octets", MetricType: "counter", Value: "1200", ValueNum: num(1200), Instance: "Gi0/1", Unit: "bytes", CollectedAt: collected},
+			{HostKey: "core1", HostName: "Core switch", Plugin: "system", Name: "os", MetricType: "info", Value: "IOS", CollectedAt: collected},
+		},
+		plugin.AgentHostKey: {
+			{HostKey: plugin.AgentHostKey, HostName: "nord", Plugin: "nord", Name: "store_write_seconds", MetricType: "gauge", Value: "0.25", ValueNum: num(0.25), Unit: "s", CollectedAt: collected},
+		},
+	}}
+}
+
+func TestSend(t *testing.T) {
+	b := startBroker(t)
+	config := `{"config_version": 1,
+		"hosts": {"core1": {"name": "Core switch", "address": "192.0.2.1"}},
+		"mqtt": {"broker": "tcp://%s", "client_id": "nord-test", "username": "nord", "password": "secret",
+			"topic_prefix": %q, "qos": 1, "discovery": true}}`
+	writeConfig(t, fmt.Sprintf(config, b.addr, "nord"))
+
+	c := plugin.NewController()
+	c.Store = testStore()
+	var deliveries []plugin.DeliveryResult
+	c.Subscribe(plugin.EventDelivery, func(e plugin.Event) { deliveries = append(deliveries, e.Data.(plugin.DeliveryResult)) })
+	p := &mqttPlugin{BasePlugin: plugin.BasePlugin{Controller: c}}
+	t.Cleanup(func() {
+		if p.pub != nil {
+			p.pub.close()
+		}
+	})
+	if err := p.OnCommand(map[string]string{"action": "send"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, messages := b.received()
+	if len(sessions) != 1 || sessions[0] != (session{"nord-test", "nord", "secret"}) {
+		t.Errorf("sessions = %+v", sessions)
+	}
+	var topics []string
+	for _, m := range messages {
+		topics = append(topics, m.topic)
+		if m.qos != 1 || m.retain != strings.HasPrefix(m.topic, "homeassistant/") {
+			t.Errorf("%s: qos %d, retain %v", m.topic, m.qos, m.retain)
+		}
+	}
+	want := []string{
+		"nord/core1/ping/status",
+		"homeassistant/binary_sensor/core1/ping_status/config",
+		"nord/core1/snmp/if_in_octets/Gi0_1",
+		"homeassistant/sensor/core1/snmp_if_in_octets_Gi0_1/config",
+		"nord/core1/system/os",
+		"nord/nord-agent/nord/store_write_seconds",
+		"homeassistant/sensor/nord-agent/nord_store_write_seconds/config",
+	}
+	if strings.Join(topics, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("topics:\n%s\nwant:\n%s", strings.Join(topics, "\n"), strings.Join(want, "\n"))
+	}
+
+	var octets payload
+	if err := json.Unmarshal(messages[2].payload, &octets); err != nil {
+		t.Fatal(err)
+	}
+	if octets.Value != "1200" || octets.ValueNum == nil || *octets.ValueNum != 1200 || octets.Instance != "Gi0/1" ||
+		octets.Unit != "bytes" || octets.CollectedAt != "2024-05-01T12:00:00Z" {
+		t.Errorf("payload = %s", messages[2].payload)
+	}
+	var status, sensor map[string]interface{}
+	json.Unmarshal(messages[1].payload, &status)
+	json.Unmarshal(messages[3].payload, &sensor)
+	if status["state_topic"] != "nord/core1/ping/status" || status["payload_on"] != "up" || status["unique_id"] != "nord_core1_ping_status" {
+		t.Errorf("binary_sensor config = %s", messages[1].payload)
+	}
+	device, _ := sensor["device"].(map[string]interface{})
+	if sensor["name"] != "if_in_octets Gi0/1" || sensor["state_class"] != "total_increasing" || sensor["unit_of_measurement"] != "bytes" ||
+		sensor["value_template"] != "{{ value_json.value_num }}" || device["name"] != "Core switch" {
+		t.Errorf("sensor config = %s", messages[3].payload)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != plugin.ResultOK || deliveries[0].Endpoint != "tcp://"+b.addr {
+		t.Errorf("deliveries = %+v", deliveries)
+	}
+
+	// The next cycle reuses the session and does not announce again.
+	if err := p.send(); err != nil {
+		t.Fatal(err)
+	}
+	sessions, messages = b.received()
+	if len(sessions) != 1 || len(messages) != len(want)+4 {
+		t.Errorf("second send: %d sessions, %d messages", len(sessions), len(messages))
+	}
+	for _, m := range messages[len(want):] {
+		if strings.HasPrefix(m.topic, "homeassistant/") {
+			t.Errorf("discovery published again: %s", m.topic)
+		}
+	}
+
+	// A configuration change reconnects and announces again.
+	writeConfig(t, fmt.Sprintf(config, b.addr, "lab"))
+	if err := p.send(); err != nil {
+		t.Fatal(err)
+	}
+	sessions, messages = b.received()
+	if len(sessions) != 2 || len(messages) != len(want)+4+len(want) {
+		t.Fatalf("after a config change: %d sessions, %d messages", len(sessions), len(messages))
+	}
+	last := messages[len(messages)-len(want):]
+	if last[0].topic != "lab/core1/ping/status" || !strings.Contains(string(last[1].payload), `"state_topic":"lab/core1/ping/status"`) {
+		t.Errorf("after a config change: %s, %s", last[0].topic, last[1].payload)
+	}
+}
+
+func TestRetainAndQoS0(t *testing.T) {
+	b := startBroker(t)
+	writeConfig(t, fmt.Sprintf(`{"config_version": 1, "mqtt": {"broker": "tcp://%s", "retain": true}}`, b.addr))
+	c := plugin.NewController()
+	c.Store = testStore()
+	p := &mqttPlugin{BasePlugin: plugin.BasePlugin{Controller: c}}
+	t.Cleanup(func() {
+		if p.pub != nil {
+			p.pub.close()
+		}
+	})
+	if err := p.send(); err != nil {
+		t.Fatal(err)
+	}
+	messages := b.wait(t, 1)
+	sessions, _ := b.received()
+	if len(sessions) != 1 || !strings.HasPrefix(sessions[0].clientID, "nord-") {
+		t.Errorf("sessions = %+v", sessions)
+	}
+	// Only the agent's own metrics: no hosts are configured, and no discovery.
+	if len(messages) != 1 || messages[0].topic != "nord/nord-agent/nord/store_write_seconds" || !messages[0].retain || messages[0].qos != 0 {
+		t.Errorf("messages = %+v", messages)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	b := startBroker(t)
+	pub, err := newPublisher(plugin.MQTTConfig{Broker: "tcp://" + b.addr, ClientID: "nord-test", QoS: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.close()
+	pub.enqueue(outMsg{topic: "a", payload: []byte("1")})
+	if err := pub.flush(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	b.setDown(true)
+	b.drop()
+	for deadline := time.Now().Add(5 * time.Second); pub.client.IsConnectionOpen(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("connection loss not noticed")
+		}
+	}
+	// Held while the broker is away, then published once the client reconnects.
+	pub.enqueue(outMsg{topic: "b", payload: []byte("2")})
+	if err := pub.flush(200 * time.Millisecond); err == nil {
+		t.Fatal("flush succeeded while the broker was down")
+	}
+	b.setDown(false)
+	if err := pub.flush(15 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	sessions, messages := b.received()
+	if len(sessions) != 2 || len(messages) != 2 || messages[1].topic != "b" {
+		t.Errorf("%d sessions, messages %+v", len(sessions), messages)
+	}
+	if pub.sent.Load() != 2 || pub.dropped.Load() != 0 {
+		t.Errorf("sent %d, dropped %d", pub.sent.Load(), pub.dropped.Load())
+	}
+}
+
+func TestQueueIsBoundedWhileBrokerIsAway(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	pub, err := newPublisher(plugin.MQTTConfig{Broker: "tcp://" + addr, ClientID: "nord-test", QueueSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		pub.enqueue(outMsg{topic: fmt.Sprint(i)})
+	}
+	if err := pub.flush(100 * time.Millisecond); err == nil {
+		t.Error("flush succeeded without a broker")
+	}
+	// The worker holds at most one message besides the queue; close drops the rest.
+	if d := pub.dropped.Load(); d < 2 || d > 3 {
+		t.Errorf("dropped %d while queueing, want 2 or 3", d)
+	}
+	pub.close()
+	if pub.sent.Load() != 0 || pub.dropped.Load() != 5 {
+		t.Errorf("sent %d, dropped %d", pub.sent.Load(), pub.dropped.Load())
+	}
+}
+
+func TestTLSBroker(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "broker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBroker(t, ln)
+
+	pub, err := newPublisher(plugin.MQTTConfig{Broker: "ssl://" + b.addr, ClientID: "nord-test", CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.close()
+	pub.enqueue(outMsg{topic: "t", payload: []byte("x")})
+	if err := pub.flush(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if messages := b.wait(t, 1); len(messages) != 1 {
+		t.Errorf("messages = %+v", messages)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("no certificates here"), 0644)
+	for name, cfg := range map[string]plugin.MQTTConfig{
+		"missing ca":   {CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"empty ca":     {CAFile: empty},
+		"missing cert": {CertFile: empty, KeyFile: empty},
+	} {
+		if _, err := newPublisher(cfg); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	p := &mqttPlugin{BasePlugin: plugin.BasePlugin{Controller: plugin.NewController()}}
+	writeConfig(t, `{"config_version": 1}`)
+	if err := p.send(); err == nil || !strings.Contains(err.Error(), "no broker") {
+		t.Errorf("without a broker: %v", err)
+	}
+	writeConfig(t, `{"config_version": 1, "mqtt": {"broker": "tcp://127.0.0.1:1"}}`)
+	if err := p.send(); err == nil || !strings.Contains(err.Error(), "database") {
+		t.Errorf("without a store: %v", err)
+	}
+	if err := p.OnCommand(map[string]string{"action": "listen"}); err == nil {
+		t.Error("unknown command accepted")
+	}
+}
+
+func TestTopicsAndIDs(t *testing.T) {
+	r := store.MetricRecord{Plugin: "disk", Name: "used#pct", Instance: "/var/+lib"}
+	if got := metricTopic("nord", "web/1", r); got != "nord/web_1/disk/used_pct/_var__lib" {
+		t.Errorf("metricTopic = %q", got)
+	}
+	if got := objectID("web-1.example.com:22"); got != "web-1_example_com_22" {
+		t.Errorf("objectID = %q", got)
+	}
+	for _, r := range []store.MetricRecord{
+		{Plugin: "system", Name: "os", MetricType: "info", Value: "Linux"},
+		{Plugin: "snmp", Name: "uptime", MetricType: "gauge", Value: "n/a"},
+	} {
+		if m, ok := discovery("homeassistant", "h", "nord/h", r); ok {
+			t.Errorf("%s announced as %s", r.Name, m.topic)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	plugin "observer/base"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultQueueSize = 1000
+	publishTimeout   = 10 * time.Second
+	minBackoff       = time.Second
+	maxBackoff       = 30 * time.Second
+)
+
+// outMsg is one message waiting to be published.
+type outMsg struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// publisher owns the broker connection and a bounded queue in front of it.
+// The client reconnects on its own; the worker holds the head of the queue
+// and retries it with backoff while the broker is away, and messages that do
+// not fit in the queue meanwhile are dropped and counted.
+type publisher struct {
+	cfg    plugin.MQTTConfig
+	client paho.Client
+	queue  chan outMsg
+
+	pending sync.WaitGroup // messages queued or in flight
+	sent    atomic.Int64
+	dropped atomic.Int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newPublisher(cfg plugin.MQTTConfig) (*publisher, error) {
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetConnectTimeout(publishTimeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(minBackoff).
+		SetMaxReconnectInterval(maxBackoff).
+		SetCleanSession(true)
+	if cfg.CAFile != "" || cfg.CertFile != "" || cfg.Insecure {
+		tc, err := tlsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tc)
+	}
+
+	size := cfg.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	p := &publisher{
+		cfg:    cfg,
+		client: paho.NewClient(opts),
+		queue:  make(chan outMsg, size),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	// With connect retry set, Connect keeps trying in the background.
+	p.client.Connect()
+	go p.run()
+	return p, nil
+}
+
+func tlsConfig(cfg plugin.MQTTConfig) (*tls.Config, error) {
+	tc := &tls.Config{InsecureSkipVerify: cfg.Insecure}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt: %w", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mqtt: no certificates in %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// enqueue queues m, dropping it when the queue is full.
+func (p *publisher) enqueue(m outMsg) {
+	p.pending.Add(1)
+	select {
+	case p.queue <- m:
+	default:
+		p.pending.Done()
+		p.dropped.Add(1)
+	}
+}
+
+// run publishes queued messages in order until stopped.
+func (p *publisher) run() {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.stop:
+			return
+		case m := <-p.queue:
+			if !p.publish(m) {
+				p.dropped.Add(1)
+			}
+			p.pending.Done()
+		}
+	}
+}
+
+// publish sends m, retrying with exponential backoff until it is
+// acknowledged (as far as its QoS asks) or the publisher stops.
+func (p *publisher) publish(m outMsg) bool {
+	backoff := minBackoff
+	for {
+		if p.client.IsConnectionOpen() {
+			t := p.client.Publish(m.topic, p.cfg.QoS, m.retain, m.payload)
+			if t.WaitTimeout(publishTimeout) && t.Error() == nil {
+				p.sent.Add(1)
+				return true
+			}
+		}
+		select {
+		case <-p.stop:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// flush waits up to timeout for the queue to drain.
+func (p *publisher) flush(timeout time.Duration) error {
+	drained := make(chan struct{})
+	go func() {
+		p.pending.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timed out waiting for the broker")
+	}
+}
+
+// close stops the worker and disconnects; queued messages are dropped.
+func (p *publisher) close() {
+	close(p.stop)
+	<-p.done
+	p.dropped.Add(int64(len(p.queue)))
+	p.client.Disconnect(250)
+}
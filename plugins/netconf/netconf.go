@@ -0,0 +1,428 @@
+// Package netconf reads structured state and configuration from network
+// devices over NETCONF (RFC 6241) on the SSH "netconf" subsystem. Collect
+// tasks "netconf.get" send the <get> and <get-config> requests of a device
+// definition and map leaves of the replies to metrics and interface records;
+// configuration snapshots are stored only when they change.
+package netconf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+)
+
+const (
+	defaultPort       = "830"
+	defaultDefinition = "ietf"
+	defaultTimeout    = 30 * time.Second
+)
+
+// netconfPlugin collects over NETCONF.
+type netconfPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&netconfPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *netconfPlugin) Name() string {
+	return "NETCONF"
+}
+
+// Definition is a device definition: the requests to send, by name.
+type Definition struct {
+	Requests map[string]RequestDef `json:"requests"`
+}
+
+// RequestDef is one <get> or <get-config> and how to read its reply.
+//
+// Path selects the rows in the reply's <data> (see selectPath); Instance is
+// the leaf, relative to a row, that tells rows apart. Without Instance only
+// the first row is read. Metrics maps leaf paths relative to the row to
+// metrics. Interfaces, when set, also turns each row into an interface
+// record. Snapshot stores the whole reply as one text metric, written only
+// when it changed.
+type RequestDef struct {
+	Operation  string               `json:"operation"` // "get" (default) or "get-config"
+	Source     string               `json:"source"`    // get-config datastore; default "running"
+	Filter     string               `json:"filter"`    // subtree filter XML; none reads everything
+	Category   string               `json:"category"`
+	Path       string               `json:"path"`
+	Instance   string               `json:"instance"`
+	Metrics    map[string]MetricDef `json:"metrics"`
+	Interfaces *InterfaceDef        `json:"interfaces"`
+	Snapshot   bool                 `json:"snapshot"`
+}
+
+// MetricDef describes one leaf. Name defaults to the leaf path with "/" and
+// "-" turned into "_"; Map translates values, e.g. "lower-layer-down" to the
+// status "down".
+type MetricDef struct {
+	Name  string            `json:"name"`
+	Label string            `json:"label"`
+	Type  string            `json:"type"`
+	Map   map[string]string `json:"map"`
+}
+
+// InterfaceDef names the leaves, relative to a row, holding the fields of an
+// interface record. Rows without an index are numbered in reply order.
+type InterfaceDef struct {
+	Index       string `json:"index"`
+	Name        string `json:"name"`
+	Alias       string `json:"alias"`
+	Type        string `json:"type"`
+	Speed       string `json:"speed"`
+	MACAddress  string `json:"mac_address"`
+	AdminStatus string `json:"admin_status"`
+	OperStatus  string `json:"oper_status"`
+}
+
+// getOptions are the per-task options of a netconf.get task:
+//
+//	{"metric": "netconf.get", "credentials": "router", "options": {
+//	    "definition": "ietf", "requests": ["interfaces", "config"],
+//	    "port": 830, "timeout_s": 30}}
+//
+// The credential supplies user and pass, so an SSH credential used with
+// sshcollect works as is; the port is the task's, NETCONF's 830 by default.
+// requests selects from the definition, all by default.
+type getOptions struct {
+	Definition string   `json:"definition"`
+	Requests   []string `json:"requests"`
+	Port       int      `json:"port"`
+	TimeoutS   float64  `json:"timeout_s"`
+}
+
+// OnCollect opens one session and sends the selected requests in it. A
+// device that cannot be reached or refuses the credentials is reported by
+// the netconf_status metric; a request the device rejects by its own
+// request_status.
+func (p *netconfPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	if action, _ := options["action"].(string); action != "get" {
+		return nil, fmt.Errorf("undefined netconf action: %s", action)
+	}
+	var opts getOptions
+	if raw, ok := options["options"]; ok {
+		b, _ := json.Marshal(raw)
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return nil, fmt.Errorf("netconf: invalid options: %w", err)
+		}
+	}
+	if opts.Definition == "" {
+		opts.Definition = defaultDefinition
+	}
+	creds, ok := options["credentials"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("netconf: the task needs credentials with user and pass")
+	}
+	user, _ := creds["user"].(string)
+	pass, _ := creds["pass"].(string)
+	address, _ := creds["host"].(string)
+	if address == "" {
+		host, _ := options["host"].(map[string]interface{})
+		address, _ = host["address"].(string)
+	}
+	port := defaultPort
+	if opts.Port > 0 {
+		port = strconv.Itoa(opts.Port)
+	}
+	timeout := defaultTimeout
+	if opts.TimeoutS > 0 {
+		timeout = time.Duration(opts.TimeoutS * float64(time.Second))
+	}
+
+	def, err := loadDefinition(opts.Definition)
+	if err != nil {
+		return nil, err
+	}
+	names, err := selectRequests(def, opts.Requests)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make(map[string]interface{})
+	collections := make(map[string]interface{})
+	var interfaces []map[string]interface{}
+	status := metric("netconf_status", "NETCONF", "", "status", "up", "NETCONF")
+	status["endpoint"] = address + ":" + port
+	metrics["netconf_status"] = status
+
+	s, err := dial(address, port, user, pass, time.Now().Add(timeout))
+	if err != nil {
+		status["value"] = "down"
+		status["error_kind"] = errorKind(err)
+		status["reason"] = err.Error()
+		return map[string]interface{}{"metrics": metrics}, nil
+	}
+	defer s.Close()
+	status["session_id"] = s.id
+	status["framing"] = s.framing()
+	status["capabilities"] = len(s.capabilities)
+
+	for _, name := range names {
+		rd := def.Requests[name]
+		reqStatus := metric("request_status", "Request", name, "status", "up", rd.Category)
+		metrics["netconf_request_"+name] = reqStatus
+		root, data, err := s.rpc(operationXML(rd))
+		if err != nil {
+			if errorKind(err) != "rpc" {
+				// The session went away; the remaining requests would fail the same way.
+				status["value"] = "down"
+				status["error_kind"] = errorKind(err)
+				status["reason"] = err.Error()
+				delete(metrics, "netconf_request_"+name)
+				break
+			}
+			reqStatus["value"] = "down"
+			reqStatus["reason"] = err.Error()
+			continue
+		}
+		dataNode := root
+		for _, c := range root.children {
+			if c.name == "data" {
+				dataNode = c
+			}
+		}
+
+		if rd.Snapshot {
+			sum := sha256.Sum256([]byte(data))
+			source := rd.Source
+			if source == "" {
+				source = "running"
+			}
+			m := metric("config_"+source, "Configuration ("+source+")", "", "text", data, rd.Category)
+			m["dedup"] = true
+			m["bytes"] = len(data)
+			m["sha256"] = hex.EncodeToString(sum[:])
+			metrics["netconf_"+name+"_snapshot"] = m
+			collections[name] = data
+		}
+		for k, v := range rowMetrics(name, rd, dataNode) {
+			metrics[k] = v
+		}
+		if rd.Interfaces != nil {
+			interfaces = append(interfaces, interfaceRows(rd, dataNode)...)
+		}
+	}
+
+	result := map[string]interface{}{"metrics": metrics, "collections": collections}
+	if len(interfaces) > 0 {
+		result["interfaces"] = interfaces
+	}
+	return result, nil
+}
+
+// errorKind classifies a failure as "connection", "auth", "rpc" (the device
+// rejected the request) or "protocol".
+func errorKind(err error) string {
+	var ce *connError
+	var re *rpcError
+	switch {
+	case errors.As(err, &ce):
+		return "connection"
+	case errors.Is(err, errAuth):
+		return "auth"
+	case errors.As(err, &re):
+		return "rpc"
+	}
+	return "protocol"
+}
+
+// operationXML is the body of the <rpc> for a request.
+func operationXML(rd RequestDef) string {
+	filter := ""
+	if strings.TrimSpace(rd.Filter) != "" {
+		filter = `<filter type="subtree">` + rd.Filter + `</filter>`
+	}
+	if rd.Operation == "get-config" {
+		source := rd.Source
+		if source == "" {
+			source = "running"
+		}
+		return "<get-config><source><" + source + "/></source>" + filter + "</get-config>"
+	}
+	return "<get>" + filter + "</get>"
+}
+
+// rowMetrics maps the leaves of the rows selected by rd.Path to metrics.
+func rowMetrics(request string, rd RequestDef, data *node) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	if len(rd.Metrics) == 0 {
+		return metrics
+	}
+	rows := selectPath([]*node{data}, rd.Path)
+	if rd.Instance == "" && len(rows) > 1 {
+		rows = rows[:1]
+	}
+	for _, row := range rows {
+		instance := ""
+		if rd.Instance != "" {
+			if instance = row.text(rd.Instance); instance == "" {
+				continue
+			}
+		}
+		for leaf, md := range rd.Metrics {
+			found := selectPath([]*node{row}, leaf)
+			if len(found) == 0 {
+				continue
+			}
+			value := found[0].value
+			if mapped, ok := md.Map[value]; ok {
+				value = mapped
+			}
+			name := md.Name
+			if name == "" {
+				name = strings.NewReplacer("/", "_", "-", "_").Replace(leaf)
+			}
+			label := md.Label
+			if label == "" {
+				label = name
+			}
+			mtype := md.Type
+			if mtype == "" {
+				mtype = "text"
+			}
+			key := "netconf_" + request + "_" + name
+			if instance != "" {
+				key += "_" + instance
+			}
+			metrics[key] = metric(name, label, instance, mtype, value, rd.Category)
+		}
+	}
+	return metrics
+}
+
+// interfaceRows reads the rows selected by rd.Path as interface records, in
+// the form the collection plugin stores.
+func interfaceRows(rd RequestDef, data *node) []map[string]interface{} {
+	idef := rd.Interfaces
+	var rows []map[string]interface{}
+	for i, row := range selectPath([]*node{data}, rd.Path) {
+		get := func(leaf string) string {
+			if leaf == "" {
+				return ""
+			}
+			return row.text(leaf)
+		}
+		name := get(idef.Name)
+		if name == "" {
+			continue
+		}
+		index := get(idef.Index)
+		if _, err := strconv.Atoi(index); err != nil {
+			index = strconv.Itoa(i + 1)
+		}
+		iface := map[string]interface{}{
+			"if_index":     index,
+			"name":         name,
+			"alias":        get(idef.Alias),
+			"type":         ifType(get(idef.Type)),
+			"mac_address":  strings.ToLower(get(idef.MACAddress)),
+			"admin_status": ifStatus(get(idef.AdminStatus)),
+			"oper_status":  ifStatus(get(idef.OperStatus)),
+		}
+		if speed, err := strconv.ParseUint(get(idef.Speed), 10, 64); err == nil {
+			iface["speed"] = speed
+		}
+		rows = append(rows, iface)
+	}
+	return rows
+}
+
+// ianaIfTypes maps iana-if-type identities to their ifType numbers.
+var ianaIfTypes = map[string]int{
+	"other":                  1,
+	"ethernetCsmacd":         6,
+	"iso88023Csmacd":         7,
+	"propPointToPointSerial": 22,
+	"softwareLoopback":       24,
+	"sonet":                  39,
+	"propVirtual":            53,
+	"ieee80211":              71,
+	"tunnel":                 131,
+	"l2vlan":                 135,
+	"l3ipvlan":               136,
+	"mplsTunnel":             150,
+	"ieee8023adLag":          161,
+	"pos":                    171,
+	"bridge":                 209,
+}
+
+// ifType reads an interface type: an iana-if-type identity, possibly
+// prefixed, or a number. Unknown types are "other".
+func ifType(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	if n, ok := ianaIfTypes[localName(s)]; ok {
+		return n
+	}
+	if s == "" {
+		return 0
+	}
+	return 1
+}
+
+// ifStatus spells YANG interface states the way SNMP's ifOperStatus does,
+// as interface records from both sources are shown together.
+func ifStatus(s string) string {
+	switch s {
+	case "not-present":
+		return "notPresent"
+	case "lower-layer-down":
+		return "lowerLayerDown"
+	}
+	return s
+}
+
+// loadDefinition reads the named device definition.
+func loadDefinition(name string) (*Definition, error) {
+	data, err := os.ReadFile(plugin.DeviceFile("netconf", name))
+	if err != nil {
+		return nil, fmt.Errorf("could not read device definition for '%s': %w", name, err)
+	}
+	var def Definition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("could not parse device definition for '%s': %w", name, err)
+	}
+	return &def, nil
+}
+
+// selectRequests returns the requested request names, or all of them, sorted.
+func selectRequests(def *Definition, want []string) ([]string, error) {
+	if len(want) == 0 {
+		for name := range def.Requests {
+			want = append(want, name)
+		}
+	}
+	for _, name := range want {
+		if _, ok := def.Requests[name]; !ok {
+			return nil, fmt.Errorf("netconf: request '%s' is not in the device definition", name)
+		}
+	}
+	sort.Strings(want)
+	return want, nil
+}
+
+func metric(name, label, instance, metricType, value, category string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    label,
+		"instance": instance,
+		"type":     metricType,
+		"value":    value,
+		"category": category,
+	}
+}
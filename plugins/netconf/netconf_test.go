@@ -0,0 +1,614 @@
+package netconf
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	plugin "observer/base"
+)
+
+const (
+	interfacesData = `<interfaces-state xmlns="urn:ietf:params:xml:ns:yang:ietf-interfaces" xmlns:ianaift="urn:ietf:params:xml:ns:yang:iana-if-type">
+  <interface>
+    <name>ge-0/0/0</name><type>ianaift:ethernetCsmacd</type>
+    <admin-status>up</admin-status><oper-status>up</oper-status>
+    <if-index>513</if-index><phys-address>00:1A:2B:3C:4D:5E</phys-address><speed>1000000000</speed>
+    <statistics><in-octets>123456</in-octets><out-octets>654321</out-octets><in-errors>0</in-errors><out-errors>2</out-errors></statistics>
+  </interface>
+  <interface>
+    <name>ge-0/0/1</name><type>ianaift:ethernetCsmacd</type>
+    <admin-status>up</admin-status><oper-status>lower-layer-down</oper-status>
+    <if-index>514</if-index><phys-address>00:1A:2B:3C:4D:5F</phys-address><speed>1000000000</speed>
+  </interface>
+  <interface>
+    <name>lo0</name><type>ianaift:softwareLoopback</type>
+    <admin-status>up</admin-status><oper-status>up</oper-status>
+  </interface>
+</interfaces-state>`
+
+	systemData = `<system-state xmlns="urn:ietf:params:xml:ns:yang:ietf-system">
+  <platform><os-name>JUNOS</os-name><os-version>23.2R1.14</os-version><machine>mx204</machine></platform>
+</system-state>`
+
+	hardwareData = `<hardware xmlns="urn:ietf:params:xml:ns:yang:ietf-hardware" xmlns:ianahw="urn:ietf:params:xml:ns:yang:iana-hardware">
+  <component><name>FPC 0</name><class>ianahw:module</class><serial-num>FPC123</serial-num></component>
+  <component><name>Chassis</name><class>ianahw:chassis</class><model-name>MX204</model-name><serial-num>JN1234ABC</serial-num><software-rev>23.2R1.14</software-rev></component>
+</hardware>`
+
+	configData = `<configuration xmlns="http://xml.juniper.net/xnm/1.1/xnm"><system><host-name>mx1</host-name></system></configuration>`
+)
+
+// device is an in-process NETCONF server on the SSH "netconf" subsystem.
+type device struct {
+	ln     net.Listener
+	config *ssh.ServerConfig
+	base11 bool // announce base:1.1 and use chunked framing when the client does
+
+	mu          sync.Mutex
+	reject      map[string]string // filter substring → rpc-error tag
+	warn        bool              // add an rpc-error of severity warning to replies
+	noisy       bool              // send a notification and a stale reply before each reply
+	dropOn      string            // close the connection on a request containing this
+	silent      bool              // never send a hello
+	clientHello []string          // capabilities the client announced
+	rpcs        []string
+}
+
+// newDevice starts a device; configure sets its behaviour before it accepts
+// connections.
+func newDevice(t *testing.T, base11 bool, configure ...func(*device)) *device {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &device{base11: base11, reject: make(map[string]string)}
+	d.config = &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "netconf" && string(pass) == "s3cret" {
+				return nil, nil
+			}
+			return nil, errors.New("denied")
+		},
+	}
+	d.config.AddHostKey(signer)
+	for _, c := range configure {
+		c(d)
+	}
+	d.ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.ln.Close() })
+	go d.accept()
+	return d
+}
+
+func (d *device) port() string {
+	_, port, _ := net.SplitHostPort(d.ln.Addr().String())
+	return port
+}
+
+func (d *device) accept() {
+	for {
+		nc, err := d.ln.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(nc)
+	}
+}
+
+func (d *device) handle(nc net.Conn) {
+	defer nc.Close()
+	_, chans, reqs, err := ssh.NewServerConn(nc, d.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nch := range chans {
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, chReqs, err := nch.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range chReqs {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "netconf"
+				req.Reply(ok, nil)
+				if ok {
+					go func() {
+						d.serve(ch)
+						nc.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// serve speaks NETCONF on ch until the client closes the session.
+func (d *device) serve(ch ssh.Channel) {
+	defer ch.Close()
+	f := &framer{r: bufio.NewReader(ch), w: ch}
+	msg, err := f.readMsg()
+	if err != nil {
+		return
+	}
+	hello, _, err := parseReply(msg)
+	if err != nil || hello.name != "hello" {
+		return
+	}
+	d.mu.Lock()
+	clientBase11 := false
+	for _, c := range selectPath([]*node{hello}, "capabilities/capability") {
+		d.clientHello = append(d.clientHello, c.value)
+		clientBase11 = clientBase11 || c.value == base11
+	}
+	silent := d.silent
+	d.mu.Unlock()
+	if silent {
+		io.Copy(io.Discard, ch) // until the client gives up
+		return
+	}
+
+	caps := "<capability>" + base10 + "</capability>"
+	if d.base11 {
+		caps += "<capability>" + base11 + "</capability>"
+	}
+	caps += "<capability>urn:ietf:params:netconf:capability:candidate:1.0</capability>"
+	f.writeMsg([]byte(`<?xml version="1.0" encoding="UTF-8"?><hello xmlns="` + baseNS + `"><capabilities>` + caps +
+		`</capabilities><session-id>4711</session-id></hello>`))
+	f.chunked = d.base11 && clientBase11
+
+	for {
+		msg, err := f.readMsg()
+		if err != nil {
+			return
+		}
+		rpc, _, err := parseReply(msg)
+		if err != nil || rpc.name != "rpc" || len(rpc.children) == 0 {
+			return
+		}
+		id, op := rpc.attr["message-id"], string(msg)
+		d.mu.Lock()
+		d.rpcs = append(d.rpcs, op)
+		reject, noisy, warn, drop := "", d.noisy, d.warn, d.dropOn != "" && strings.Contains(op, d.dropOn)
+		for filter, tag := range d.reject {
+			if strings.Contains(op, filter) {
+				reject = tag
+			}
+		}
+		d.mu.Unlock()
+
+		if drop {
+			return
+		}
+		if rpc.children[0].name == "close-session" {
+			d.write(f, `<rpc-reply message-id="`+id+`" xmlns="`+baseNS+`"><ok/></rpc-reply>`)
+			return
+		}
+		if noisy {
+			d.write(f, `<notification xmlns="urn:ietf:params:xml:ns:netconf:notification:1.0"><eventTime>2024-05-01T12:00:00Z</eventTime><netconf-config-change/></notification>`)
+			d.write(f, `<rpc-reply message-id="0" xmlns="`+baseNS+`"><data><stale/></data></rpc-reply>`)
+		}
+		if reject != "" {
+			d.write(f, `<rpc-reply message-id="`+id+`" xmlns="`+baseNS+`"><rpc-error><error-type>application</error-type>`+
+				`<error-tag>`+reject+`</error-tag><error-severity>error</error-severity>`+
+				`<error-message>not supported on this platform</error-message></rpc-error></rpc-reply>`)
+			continue
+		}
+		data := ""
+		switch {
+		case strings.Contains(op, "<get-config>"):
+			data = configData
+		case strings.Contains(op, "interfaces"):
+			data = interfacesData
+		case strings.Contains(op, "system-state"):
+			data = systemData
+		case strings.Contains(op, "hardware"):
+			data = hardwareData
+		}
+		warning := ""
+		if warn {
+			warning = `<rpc-error><error-type>application</error-type><error-tag>partial-operation</error-tag><error-severity>warning</error-severity></rpc-error>`
+		}
+		d.write(f, `<rpc-reply message-id="`+id+`" xmlns="`+baseNS+`">`+warning+`<data>`+data+`</data></rpc-reply>`)
+	}
+}
+
+// write sends msg, split into 100-byte chunks under chunked framing so the
+// client has to reassemble them.
+func (d *device) write(f *framer, msg string) {
+	if !f.chunked {
+		f.writeMsg([]byte(msg))
+		return
+	}
+	var buf bytes.Buffer
+	for len(msg) > 0 {
+		n := min(100, len(msg))
+		fmt.Fprintf(&buf, "\n#%d\n%s", n, msg[:n])
+		msg = msg[n:]
+	}
+	buf.WriteString("\n##\n")
+	f.w.Write(buf.Bytes())
+}
+
+func (d *device) requests() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.rpcs...)
+}
+
+// useDefinitions points the devices directory at a temp directory holding
+// the shipped ietf definition.
+func useDefinitions(t *testing.T) {
+	t.Helper()
+	def, err := os.ReadFile(filepath.Join("devices", "ietf.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "netconf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "netconf", "ietf.json"), def, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(plugin.LoadPaths)
+	t.Setenv(plugin.EnvDevicesDir, dir)
+	plugin.LoadPaths()
+}
+
+// collect runs one netconf.get task against d.
+func collect(t *testing.T, d *device, pass string, opts map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	port, _ := strconv.Atoi(d.port())
+	if opts == nil {
+		opts = map[string]interface{}{}
+	}
+	opts["port"] = port
+	result, err := (&netconfPlugin{}).OnCollect(map[string]interface{}{
+		"action":      "get",
+		"host":        map[string]interface{}{"address": "127.0.0.1", "name": "mx1"},
+		"credentials": map[string]interface{}{"user": "netconf", "pass": pass},
+		"options":     opts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func get(t *testing.T, metrics map[string]interface{}, key string) map[string]interface{} {
+	t.Helper()
+	m, ok := metrics[key].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no %s in %v", key, metrics)
+	}
+	return m
+}
+
+func TestGet(t *testing.T) {
+	useDefinitions(t)
+	for _, tt := range []struct {
+		base11  bool
+		framing string
+	}{
+		{false, "end-of-message (base:1.0)"},
+		{true, "chunked (base:1.1)"},
+	} {
+		t.Run(tt.framing, func(t *testing.T) {
+			d := newDevice(t, tt.base11)
+			result := collect(t, d, "s3cret", nil)
+			metrics := result["metrics"].(map[string]interface{})
+
+			status := get(t, metrics, "netconf_status")
+			if status["value"] != "up" || status["framing"] != tt.framing || status["session_id"] != "4711" ||
+				status["endpoint"] != "127.0.0.1:"+d.port() {
+				t.Errorf("status = %v", status)
+			}
+			want := 2
+			if tt.base11 {
+				want = 3
+			}
+			if status["capabilities"] != want {
+				t.Errorf("capabilities = %v, want %d", status["capabilities"], want)
+			}
+			for _, name := range []string{"config", "hardware", "interfaces", "system"} {
+				if s := get(t, metrics, "netconf_request_"+name); s["value"] != "up" || s["instance"] != name {
+					t.Errorf("request %s = %v", name, s)
+				}
+			}
+
+			for key, want := range map[string]string{
+				"netconf_interfaces_if_oper_status_ge-0/0/0": "up",
+				"netconf_interfaces_if_oper_status_ge-0/0/1": "down",
+				"netconf_interfaces_if_in_octets_ge-0/0/0":   "123456",
+				"netconf_interfaces_if_out_errors_ge-0/0/0":  "2",
+				"netconf_interfaces_if_admin_status_lo0":     "up",
+				"netconf_system_os_name":                     "JUNOS",
+				"netconf_system_software_version":            "23.2R1.14",
+				"netconf_hardware_serial":                    "JN1234ABC",
+				"netconf_hardware_model":                     "MX204",
+				"netconf_hardware_chassis_software_rev":      "23.2R1.14",
+			} {
+				if got := get(t, metrics, key)["value"]; got != want {
+					t.Errorf("%s = %v, want %s", key, got, want)
+				}
+			}
+			if m := get(t, metrics, "netconf_interfaces_if_oper_status_ge-0/0/1"); m["type"] != "status" || m["instance"] != "ge-0/0/1" || m["category"] != "Interfaces" {
+				t.Errorf("oper status = %v", m)
+			}
+			if _, ok := metrics["netconf_interfaces_if_in_octets_ge-0/0/1"]; ok {
+				t.Error("counter reported for an interface without statistics")
+			}
+
+			snap := get(t, metrics, "netconf_config_snapshot")
+			if snap["value"] != configData || snap["name"] != "config_running" || snap["dedup"] != true || snap["bytes"] != len(configData) {
+				t.Errorf("snapshot = %v", snap)
+			}
+			if result["collections"].(map[string]interface{})["config"] != configData {
+				t.Errorf("collections = %v", result["collections"])
+			}
+
+			ifaces := result["interfaces"].([]map[string]interface{})
+			if len(ifaces) != 3 {
+				t.Fatalf("interfaces = %v", ifaces)
+			}
+			if i := ifaces[0]; i["if_index"] != "513" || i["name"] != "ge-0/0/0" || i["type"] != 6 ||
+				i["mac_address"] != "00:1a:2b:3c:4d:5e" || i["speed"] != uint64(1000000000) || i["oper_status"] != "up" {
+				t.Errorf("ge-0/0/0 = %v", i)
+			}
+			if i := ifaces[1]; i["oper_status"] != "lowerLayerDown" {
+				t.Errorf("ge-0/0/1 = %v", i)
+			}
+			// Without an if-index the row is numbered in reply order.
+			if i := ifaces[2]; i["if_index"] != "3" || i["type"] != 24 || i["speed"] != nil {
+				t.Errorf("lo0 = %v", i)
+			}
+
+			rpcs := d.requests()
+			if len(rpcs) != 4 || !strings.Contains(rpcs[0], "<get-config><source><running/></source></get-config>") ||
+				!strings.Contains(rpcs[1], `<filter type="subtree"><hardware`) {
+				t.Errorf("rpcs = %v", rpcs)
+			}
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if strings.Join(d.clientHello, " ") != base10+" "+base11 {
+				t.Errorf("client hello = %v", d.clientHello)
+			}
+		})
+	}
+}
+
+func TestRequestSelection(t *testing.T) {
+	useDefinitions(t)
+	d := newDevice(t, true)
+	metrics := collect(t, d, "s3cret", map[string]interface{}{"requests": []string{"system"}})["metrics"].(map[string]interface{})
+	if _, ok := metrics["netconf_request_interfaces"]; ok || len(d.requests()) != 1 {
+		t.Errorf("requests sent: %v", d.requests())
+	}
+	if get(t, metrics, "netconf_system_machine")["value"] != "mx204" {
+		t.Error("system not read")
+	}
+}
+
+func TestNotificationsAndStaleRepliesAreSkipped(t *testing.T) {
+	useDefinitions(t)
+	for _, base11 := range []bool{false, true} {
+		d := newDevice(t, base11, func(d *device) { d.noisy, d.warn = true, true })
+		metrics := collect(t, d, "s3cret", map[string]interface{}{"requests": []string{"system"}})["metrics"].(map[string]interface{})
+		if get(t, metrics, "netconf_request_system")["value"] != "up" || get(t, metrics, "netconf_system_os_name")["value"] != "JUNOS" {
+			t.Errorf("base11 %v: metrics = %v", base11, metrics)
+		}
+	}
+}
+
+func TestRPCErrorFailsOnlyItsRequest(t *testing.T) {
+	useDefinitions(t)
+	d := newDevice(t, true, func(d *device) { d.reject["hardware"] = "operation-not-supported" })
+	metrics := collect(t, d, "s3cret", nil)["metrics"].(map[string]interface{})
+	hw := get(t, metrics, "netconf_request_hardware")
+	if hw["value"] != "down" || hw["reason"] != "rpc-error operation-not-supported: not supported on this platform" {
+		t.Errorf("hardware = %v", hw)
+	}
+	if _, ok := metrics["netconf_hardware_serial"]; ok {
+		t.Error("serial reported from a rejected request")
+	}
+	if get(t, metrics, "netconf_status")["value"] != "up" || get(t, metrics, "netconf_request_system")["value"] != "up" {
+		t.Error("other requests failed with the rejected one")
+	}
+}
+
+func TestSessionLost(t *testing.T) {
+	useDefinitions(t)
+	d := newDevice(t, false, func(d *device) { d.dropOn = "<hardware" })
+	metrics := collect(t, d, "s3cret", nil)["metrics"].(map[string]interface{})
+	status := get(t, metrics, "netconf_status")
+	if status["value"] != "down" || status["error_kind"] != "connection" {
+		t.Errorf("status = %v", status)
+	}
+	// config was read before; the rest were never sent.
+	if get(t, metrics, "netconf_request_config")["value"] != "up" {
+		t.Error("config lost")
+	}
+	for _, name := range []string{"hardware", "interfaces", "system"} {
+		if _, ok := metrics["netconf_request_"+name]; ok {
+			t.Errorf("request %s reported after the session was lost", name)
+		}
+	}
+}
+
+func TestConnectionFailures(t *testing.T) {
+	useDefinitions(t)
+	d := newDevice(t, true)
+	metrics := collect(t, d, "wrong", nil)["metrics"].(map[string]interface{})
+	if s := get(t, metrics, "netconf_status"); s["value"] != "down" || s["error_kind"] != "auth" {
+		t.Errorf("wrong password: %v", s)
+	}
+
+	silent := newDevice(t, true, func(d *device) { d.silent = true })
+	metrics = collect(t, silent, "s3cret", map[string]interface{}{"timeout_s": 0.3})["metrics"].(map[string]interface{})
+	if s := get(t, metrics, "netconf_status"); s["value"] != "down" || s["error_kind"] != "connection" ||
+		!strings.Contains(s["reason"].(string), "reading hello") {
+		t.Errorf("no hello: %v", s)
+	}
+
+	closed := newDevice(t, true)
+	closed.ln.Close()
+	metrics = collect(t, closed, "s3cret", nil)["metrics"].(map[string]interface{})
+	if s := get(t, metrics, "netconf_status"); s["value"] != "down" || s["error_kind"] != "connection" {
+		t.Errorf("refused: %v", s)
+	}
+}
+
+func TestInvalidTasks(t *testing.T) {
+	useDefinitions(t)
+	p := &netconfPlugin{}
+	creds := map[string]interface{}{"user": "netconf", "pass": "s3cret", "host": "127.0.0.1"}
+	for name, options := range map[string]map[string]interface{}{
+		"unknown action":     {"action": "edit-config", "credentials": creds},
+		"no credentials":     {"action": "get"},
+		"bad options":        {"action": "get", "credentials": creds, "options": map[string]interface{}{"requests": "system"}},
+		"unknown definition": {"action": "get", "credentials": creds, "options": map[string]interface{}{"definition": "vendorx"}},
+		"unknown request":    {"action": "get", "credentials": creds, "options": map[string]interface{}{"requests": []string{"routes"}}},
+	} {
+		if _, err := p.OnCollect(options); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestFramer(t *testing.T) {
+	read := func(chunked bool, in string) (string, error) {
+		f := &framer{r: bufio.NewReader(strings.NewReader(in)), chunked: chunked}
+		msg, err := f.readMsg()
+		return string(msg), err
+	}
+	for in, want := range map[string]string{
+		"<ok/>]]>]]>":      "<ok/>",
+		"<a>]]></a>]]>]]>": "<a>]]></a>",
+		"]]>]]>":           "",
+	} {
+		if got, err := read(false, in); err != nil || got != want {
+			t.Errorf("eom %q = %q, %v", in, got, err)
+		}
+	}
+	if got, err := read(true, "\n#4\n<rpc\n#17\n message-id=\"1\"/>\n##\n"); err != nil || got != `<rpc message-id="1"/>` {
+		t.Errorf("chunks = %q, %v", got, err)
+	}
+	for _, in := range []string{
+		"\n#0\n\n##\n",
+		"\n#012\n<rpc-reply/>\n##\n",
+		"\n#x\n",
+		"\n#4294967296\n",
+		"#4\n<ok/\n##\n",
+		"\n#5\n<ok/>\n#",
+		"\n#10\n<ok/>",
+	} {
+		if _, err := read(true, in); err == nil {
+			t.Errorf("chunked %q accepted", in)
+		}
+	}
+	if _, err := read(false, "<ok/>]]>"); err == nil {
+		t.Error("truncated eom accepted")
+	}
+
+	var buf bytes.Buffer
+	f := &framer{w: &buf, chunked: true}
+	f.writeMsg([]byte("<ok/>"))
+	if buf.String() != "\n#5\n<ok/>\n##\n" {
+		t.Errorf("chunked write = %q", buf.String())
+	}
+}
+
+func TestSelectPath(t *testing.T) {
+	root, data, err := parseReply([]byte(`<rpc-reply xmlns="` + baseNS + `" message-id="7"><data>` + hardwareData + interfacesData + `</data></rpc-reply>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.attr["message-id"] != "7" || !strings.HasPrefix(data, "<hardware") || !strings.HasSuffix(data, "</interfaces-state>") {
+		t.Errorf("root %v, data %q", root.attr, data)
+	}
+	dataNode := root.children[0]
+	for path, want := range map[string]string{
+		"hardware/component":                               "FPC 0,Chassis",
+		"hardware/component[class=chassis]":                "Chassis",
+		"hardware/component[class='ianahw:module']":        "FPC 0",
+		"hw:hardware/hw:component[model-name]":             "Chassis",
+		"hardware/*[serial-num=FPC123]":                    "FPC 0",
+		"interfaces/interface|interfaces-state/interface":  "ge-0/0/0,ge-0/0/1,lo0",
+		"interfaces-state/interface[oper-status=up]":       "ge-0/0/0,lo0",
+		"/interfaces-state/interface[if-index=514]/":       "ge-0/0/1",
+		"hardware/component[class=fan]|hardware/component": "FPC 0,Chassis",
+		"routing": "",
+	} {
+		var names []string
+		for _, n := range selectPath([]*node{dataNode}, path) {
+			names = append(names, n.text("name"))
+		}
+		if got := strings.Join(names, ","); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	if got := dataNode.text("interfaces-state/interface/statistics/in-octets"); got != "123456" {
+		t.Errorf("text = %q", got)
+	}
+
+	for _, bad := range []string{"", "<rpc-reply><data>", "<a></b>", "just text"} {
+		if _, _, err := parseReply([]byte(bad)); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	if got := operationXML(RequestDef{Operation: "get-config", Source: "candidate", Filter: "<system/>"}); got != `<get-config><source><candidate/></source><filter type="subtree"><system/></filter></get-config>` {
+		t.Errorf("get-config = %s", got)
+	}
+	if got := operationXML(RequestDef{Filter: "  "}); got != "<get></get>" {
+		t.Errorf("get = %s", got)
+	}
+	for s, want := range map[string]int{"ianaift:ethernetCsmacd": 6, "ieee8023adLag": 161, "117": 117, "x:bogus": 1, "": 0} {
+		if got := ifType(s); got != want {
+			t.Errorf("ifType(%q) = %d, want %d", s, got, want)
+		}
+	}
+	for s, want := range map[string]string{"not-present": "notPresent", "lower-layer-down": "lowerLayerDown", "dormant": "dormant"} {
+		if got := ifStatus(s); got != want {
+			t.Errorf("ifStatus(%q) = %s", s, got)
+		}
+	}
+	for err, want := range map[error]string{
+		&connError{errors.New("eof")}: "connection",
+		errAuth:                       "auth",
+		fmt.Errorf("request: %w", &rpcError{Tag: "x"}): "rpc",
+		errors.New("bad hello"):                        "protocol",
+	} {
+		if got := errorKind(err); got != want {
+			t.Errorf("errorKind(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
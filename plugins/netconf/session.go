@@ -0,0 +1,305 @@
+package netconf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// NETCONF base namespace and the capabilities selecting the framing.
+const (
+	baseNS = "urn:ietf:params:xml:ns:netconf:base:1.0"
+	base10 = "urn:ietf:params:netconf:base:1.0"
+	base11 = "urn:ietf:params:netconf:base:1.1"
+
+	eom        = "]]>]]>"
+	maxMessage = 64 << 20 // largest reply read, e.g. a full configuration
+	maxChunk   = 4294967295
+)
+
+// errAuth reports credentials the device refused.
+var errAuth = errors.New("authentication failed")
+
+// connError wraps failures to reach the device or keep the session open.
+type connError struct{ err error }
+
+func (e *connError) Error() string { return e.err.Error() }
+func (e *connError) Unwrap() error { return e.err }
+
+// rpcError is an <rpc-error> of severity error in a reply.
+type rpcError struct {
+	Tag, Message string
+}
+
+func (e *rpcError) Error() string {
+	if e.Message != "" {
+		return "rpc-error " + e.Tag + ": " + e.Message
+	}
+	return "rpc-error " + e.Tag
+}
+
+// framer reads and writes NETCONF messages (RFC 6242): end-of-message
+// delimited for base:1.0 and the hello exchange, chunked once both peers
+// announced base:1.1.
+type framer struct {
+	r       *bufio.Reader
+	w       io.Writer
+	chunked bool
+}
+
+func (f *framer) writeMsg(msg []byte) error {
+	var buf bytes.Buffer
+	if f.chunked {
+		fmt.Fprintf(&buf, "\n#%d\n", len(msg))
+		buf.Write(msg)
+		buf.WriteString("\n##\n")
+	} else {
+		buf.Write(msg)
+		buf.WriteString(eom)
+	}
+	_, err := f.w.Write(buf.Bytes())
+	return err
+}
+
+func (f *framer) readMsg() ([]byte, error) {
+	if f.chunked {
+		return f.readChunked()
+	}
+	return f.readEOM()
+}
+
+// readEOM reads up to the ]]>]]> delimiter.
+func (f *framer) readEOM() ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(b)
+		if b == '>' && bytes.HasSuffix(buf.Bytes(), []byte(eom)) {
+			return buf.Bytes()[:buf.Len()-len(eom)], nil
+		}
+		if buf.Len() > maxMessage {
+			return nil, fmt.Errorf("message larger than %d bytes", maxMessage)
+		}
+	}
+}
+
+// readChunked reads chunks ("\n#<size>\n<data>") up to the end-of-chunks
+// marker "\n##\n".
+func (f *framer) readChunked() ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		if err := f.expect("\n#"); err != nil {
+			return nil, err
+		}
+		b, err := f.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '#' {
+			if err := f.expect("\n"); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}
+		f.r.UnreadByte()
+		line, err := f.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		digits := strings.TrimSuffix(line, "\n")
+		size, err := strconv.ParseUint(digits, 10, 64)
+		if err != nil || size == 0 || size > maxChunk || digits[0] == '0' {
+			return nil, fmt.Errorf("bad chunk size %q", digits)
+		}
+		if uint64(buf.Len())+size > maxMessage {
+			return nil, fmt.Errorf("message larger than %d bytes", maxMessage)
+		}
+		if _, err := io.CopyN(&buf, f.r, int64(size)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *framer) expect(s string) error {
+	for i := 0; i < len(s); i++ {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != s[i] {
+			return fmt.Errorf("bad chunk framing: got %q, want %q", b, s[i])
+		}
+	}
+	return nil
+}
+
+// session is an open NETCONF session over the SSH "netconf" subsystem.
+type session struct {
+	client       *ssh.Client
+	ssh          *ssh.Session
+	f            *framer
+	id           string
+	capabilities []string
+	nextID       atomic.Int64
+}
+
+// dial connects, starts the netconf subsystem and exchanges hellos. The
+// deadline bounds the whole session: the connection is closed when it passes,
+// which fails any read or write in progress.
+func dial(address, port, user, pass string, deadline time.Time) (*session, error) {
+	cfg := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(pass),
+			ssh.KeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = pass
+				}
+				return answers, nil
+			}),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Until(deadline),
+	}
+	addr := net.JoinHostPort(address, port)
+	conn, err := net.DialTimeout("tcp", addr, time.Until(deadline))
+	if err != nil {
+		return nil, &connError{err}
+	}
+	conn.SetDeadline(deadline)
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		conn.Close()
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return nil, errAuth
+		}
+		return nil, &connError{err}
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	s := &session{client: client}
+	if err := s.start(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *session) start() error {
+	sess, err := s.client.NewSession()
+	if err != nil {
+		return &connError{err}
+	}
+	s.ssh = sess
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return &connError{err}
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return &connError{err}
+	}
+	if err := sess.RequestSubsystem("netconf"); err != nil {
+		return &connError{fmt.Errorf("netconf subsystem: %w", err)}
+	}
+	s.f = &framer{r: bufio.NewReaderSize(stdout, 64<<10), w: stdin}
+
+	hello := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<hello xmlns="` + baseNS + `"><capabilities>` +
+		`<capability>` + base10 + `</capability>` +
+		`<capability>` + base11 + `</capability>` +
+		`</capabilities></hello>`
+	if err := s.f.writeMsg([]byte(hello)); err != nil {
+		return &connError{err}
+	}
+	msg, err := s.f.readMsg()
+	if err != nil {
+		return &connError{fmt.Errorf("reading hello: %w", err)}
+	}
+	var h struct {
+		XMLName      xml.Name `xml:"hello"`
+		Capabilities []string `xml:"capabilities>capability"`
+		SessionID    string   `xml:"session-id"`
+	}
+	if err := xml.Unmarshal(msg, &h); err != nil {
+		return fmt.Errorf("bad hello: %w", err)
+	}
+	for _, c := range h.Capabilities {
+		c = strings.TrimSpace(c)
+		s.capabilities = append(s.capabilities, c)
+		if c == base11 {
+			s.f.chunked = true
+		}
+	}
+	s.id = strings.TrimSpace(h.SessionID)
+	return nil
+}
+
+// framing names the negotiated framing for the status metric.
+func (s *session) framing() string {
+	if s.f.chunked {
+		return "chunked (base:1.1)"
+	}
+	return "end-of-message (base:1.0)"
+}
+
+// rpc sends one operation and returns the reply's tree and, for replies
+// carrying <data>, that element's inner XML. An <rpc-error> of severity
+// error is returned as *rpcError.
+func (s *session) rpc(operation string) (*node, string, error) {
+	id := strconv.FormatInt(s.nextID.Add(1), 10)
+	msg := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<rpc message-id="` + id + `" xmlns="` + baseNS + `">` + operation + `</rpc>`
+	if err := s.f.writeMsg([]byte(msg)); err != nil {
+		return nil, "", &connError{err}
+	}
+	for {
+		reply, err := s.f.readMsg()
+		if err != nil {
+			return nil, "", &connError{err}
+		}
+		root, data, err := parseReply(reply)
+		if err != nil {
+			return nil, "", err
+		}
+		if root.name == "notification" {
+			continue // not ours; sent on a subscription
+		}
+		if root.name != "rpc-reply" {
+			return nil, "", fmt.Errorf("unexpected <%s> in reply", root.name)
+		}
+		if got := root.attr["message-id"]; got != "" && got != id {
+			continue // a late reply to an earlier, timed out request
+		}
+		for _, e := range root.children {
+			if e.name == "rpc-error" && e.text("error-severity") != "warning" {
+				return nil, "", &rpcError{Tag: e.text("error-tag"), Message: e.text("error-message")}
+			}
+		}
+		return root, data, nil
+	}
+}
+
+// Close ends the session politely and closes the connection.
+func (s *session) Close() {
+	if s.f != nil {
+		s.f.writeMsg([]byte(`<rpc message-id="close" xmlns="` + baseNS + `"><close-session/></rpc>`))
+	}
+	if s.ssh != nil {
+		s.ssh.Close()
+	}
+	s.client.Close()
+}
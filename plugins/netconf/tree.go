@@ -0,0 +1,148 @@
+package netconf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// node is an element of a reply. Names are local names: device definitions
+// address elements without namespace prefixes.
+type node struct {
+	name     string
+	attr     map[string]string
+	value    string // character data of a leaf, trimmed
+	children []*node
+}
+
+// parseReply builds the tree of a reply message and returns the inner XML of
+// its top-level <data> element, if any.
+func parseReply(msg []byte) (*node, string, error) {
+	d := xml.NewDecoder(bytes.NewReader(msg))
+	var (
+		root      *node
+		stack     []*node
+		text      []*strings.Builder
+		dataStart int64 = -1
+		data      string
+	)
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("bad reply: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name.Local}
+			for _, a := range t.Attr {
+				if n.attr == nil {
+					n.attr = make(map[string]string)
+				}
+				n.attr[a.Name.Local] = a.Value
+			}
+			if len(stack) == 0 {
+				root = n
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+			text = append(text, &strings.Builder{})
+			if len(stack) == 2 && n.name == "data" {
+				dataStart = d.InputOffset()
+			}
+		case xml.CharData:
+			if len(text) > 0 {
+				text[len(text)-1].Write(t)
+			}
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, "", fmt.Errorf("bad reply: unbalanced </%s>", t.Name.Local)
+			}
+			n := stack[len(stack)-1]
+			if len(n.children) == 0 {
+				n.value = strings.TrimSpace(text[len(text)-1].String())
+			}
+			if len(stack) == 2 && n.name == "data" && dataStart >= 0 {
+				data = strings.TrimSpace(string(msg[dataStart:offset]))
+			}
+			stack = stack[:len(stack)-1]
+			text = text[:len(text)-1]
+		}
+	}
+	if root == nil {
+		return nil, "", fmt.Errorf("bad reply: no element")
+	}
+	return root, data, nil
+}
+
+// text returns the value of the first leaf at the relative path, or "".
+func (n *node) text(path string) string {
+	if found := selectPath([]*node{n}, path); len(found) > 0 {
+		return found[0].value
+	}
+	return ""
+}
+
+// selectPath evaluates an XPath-like path below each of the context nodes.
+// Steps are separated by "/" and name elements by local name, any namespace
+// prefix being ignored; "*" matches any element. A step may carry one
+// predicate, [leaf=value], keeping elements whose leaf has that value; an
+// identity value also matches without its prefix ("chassis" matches
+// "ianahw:chassis"). Alternatives separated by "|" are tried in order and the
+// first that selects anything wins, so one definition can cover devices
+// putting the same data in different places.
+func selectPath(context []*node, path string) []*node {
+	for _, alt := range strings.Split(path, "|") {
+		nodes := context
+		for _, step := range strings.Split(strings.Trim(strings.TrimSpace(alt), "/"), "/") {
+			if step == "" {
+				continue
+			}
+			name, pred, _ := strings.Cut(step, "[")
+			name = localName(name)
+			var next []*node
+			for _, n := range nodes {
+				for _, c := range n.children {
+					if (name == "*" || c.name == name) && matches(c, strings.TrimSuffix(pred, "]")) {
+						next = append(next, c)
+					}
+				}
+			}
+			nodes = next
+		}
+		if len(nodes) > 0 {
+			return nodes
+		}
+	}
+	return nil
+}
+
+// matches tests a predicate "leaf=value" (the value optionally quoted), or
+// "leaf" alone for the leaf's presence.
+func matches(n *node, pred string) bool {
+	if pred == "" {
+		return true
+	}
+	leaf, want, ok := strings.Cut(pred, "=")
+	if !ok {
+		return len(selectPath([]*node{n}, strings.TrimSpace(leaf))) > 0
+	}
+	want = strings.Trim(strings.TrimSpace(want), `'"`)
+	got := n.text(strings.TrimSpace(leaf))
+	return got == want || localName(got) == want
+}
+
+// localName strips a namespace prefix: "if:interfaces" is "interfaces".
+func localName(s string) string {
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
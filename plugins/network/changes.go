@@ -0,0 +1,256 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+const (
+	// perceptionStateFile, in the state directory, remembers the hosts seen by
+	// earlier perception runs so each run can report what changed.
+	perceptionStateFile = "perception_state.json"
+	// defaultMisses is how many scans in a row a host may be missing before
+	// it is reported gone, so one lost ping does not raise an event.
+	defaultMisses = 3
+)
+
+// seenHost is a host as perception last saw it.
+type seenHost struct {
+	Services  []string  `json:"services"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Misses    int       `json:"misses"` // complete scans in a row the host was missing from
+}
+
+// perceptionState is the content of perceptionStateFile, keyed by canonical address.
+type perceptionState struct {
+	Hosts map[string]*seenHost `json:"hosts"`
+}
+
+// canonicalAddress returns the form addresses are compared in: IPv6 compressed
+// and lower case, IPv4-mapped IPv6 as IPv4. Anything else is trimmed and
+// lower-cased.
+func canonicalAddress(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if ip, err := netip.ParseAddr(addr); err == nil {
+		return ip.Unmap().String()
+	}
+	return strings.ToLower(addr)
+}
+
+// diffScan compares a scan, canonical address to detected services, with the
+// state left by earlier runs, updates the state and returns the changes.
+// A host missing from a complete scan is only reported gone after misses such
+// scans in a row; an incomplete scan, where an environment failed, counts no
+// misses. A state without hosts is a first run: it records a baseline and
+// reports nothing.
+func diffScan(state *perceptionState, scan map[string][]string, complete bool, misses int, now time.Time) []plugin.HostChange {
+	if misses <= 0 {
+		misses = defaultMisses
+	}
+	baseline := state.Hosts == nil
+	if baseline {
+		state.Hosts = make(map[string]*seenHost)
+	}
+
+	var changes []plugin.HostChange
+	for addr, services := range scan {
+		services = sortedServices(services)
+		h, known := state.Hosts[addr]
+		if !known {
+			state.Hosts[addr] = &seenHost{Services: services, FirstSeen: now, LastSeen: now}
+			if !baseline {
+				changes = append(changes, plugin.HostChange{Kind: plugin.HostAppeared, Address: addr, Services: services, At: now})
+			}
+			continue
+		}
+		if added, removed := serviceDiff(h.Services, services); len(added) > 0 || len(removed) > 0 {
+			changes = append(changes, plugin.HostChange{
+				Kind: plugin.HostServicesChanged, Address: addr, Services: services,
+				Added: added, Removed: removed, At: now,
+			})
+		}
+		h.Services, h.LastSeen, h.Misses = services, now, 0
+	}
+
+	if complete {
+		for addr, h := range state.Hosts {
+			if _, ok := scan[addr]; ok {
+				continue
+			}
+			h.Misses++
+			if h.Misses >= misses {
+				changes = append(changes, plugin.HostChange{Kind: plugin.HostDisappeared, Address: addr, Services: h.Services, At: now})
+				delete(state.Hosts, addr)
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Address < changes[j].Address
+	})
+	return changes
+}
+
+// sortedServices returns services sorted, without duplicates, never nil.
+func sortedServices(services []string) []string {
+	out := make([]string, 0, len(services))
+	seen := make(map[string]bool, len(services))
+	for _, s := range services {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// serviceDiff returns the services in now but not before, and the reverse.
+func serviceDiff(before, now []string) (added, removed []string) {
+	had := make(map[string]bool, len(before))
+	for _, s := range before {
+		had[s] = true
+	}
+	has := make(map[string]bool, len(now))
+	for _, s := range now {
+		has[s] = true
+		if !had[s] {
+			added = append(added, s)
+		}
+	}
+	for _, s := range before {
+		if !has[s] {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+// loadPerceptionState reads the state of earlier runs; Hosts is nil when there
+// is none. Addresses are canonicalized, so a state written before that was
+// done still matches.
+func loadPerceptionState() *perceptionState {
+	state := &perceptionState{}
+	data, err := os.ReadFile(plugin.StateFile(perceptionStateFile))
+	if err != nil {
+		return state
+	}
+	var saved perceptionState
+	if json.Unmarshal(data, &saved) != nil || saved.Hosts == nil {
+		return state
+	}
+	state.Hosts = make(map[string]*seenHost, len(saved.Hosts))
+	for addr, h := range saved.Hosts {
+		state.Hosts[canonicalAddress(addr)] = h
+	}
+	return state
+}
+
+// savePerceptionState writes the state back to disk.
+func savePerceptionState(state *perceptionState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return plugin.WriteStateFile(perceptionStateFile, data, 0644)
+}
+
+// reportChanges diffs a scan against earlier runs, saves the new state, logs
+// and publishes each change and stores it as an event metric of the host.
+func (p *networkPlugin) reportChanges(discoveredHosts map[string]interface{}, complete bool, misses int) {
+	scan := make(map[string][]string, len(discoveredHosts))
+	for ip, hostAny := range discoveredHosts {
+		hostMap, _ := hostAny.(map[string]interface{})
+		services, _ := hostMap["collect"].([]string)
+		scan[canonicalAddress(ip)] = services
+	}
+
+	state := loadPerceptionState()
+	baseline := state.Hosts == nil
+	if baseline && !complete {
+		p.Controller.Println("  !_ perception: a scan failed; change detection starts after a complete run")
+		return
+	}
+	now := time.Now()
+	changes := diffScan(state, scan, complete, misses, now)
+	if err := savePerceptionState(state); err != nil {
+		p.Controller.Printf("  !_ perception: could not save state: %v\n", err)
+	}
+	if baseline {
+		p.Controller.Printf("  |_ perception: recorded %d hosts as the baseline for change detection\n", len(state.Hosts))
+		return
+	}
+	if !complete {
+		p.Controller.Println("  !_ perception: a scan failed; missing hosts are not counted as gone this run")
+	}
+
+	var records []store.MetricRecord
+	for _, c := range changes {
+		p.Controller.Printf("  |_ perception: %s\n", describeChange(c))
+		p.Controller.Publish(plugin.Event{Topic: plugin.EventHostChange, Source: "network", Data: c})
+		records = append(records, changeRecord(c))
+	}
+	if p.Controller.Store != nil && len(records) > 0 {
+		if err := p.Controller.Store.WriteBatch(context.Background(), records); err != nil {
+			p.Controller.Printf("  !_ store: perception change WriteBatch error: %v\n", err)
+		}
+	}
+}
+
+// describeChange is the one-line description of a change used in logs.
+func describeChange(c plugin.HostChange) string {
+	switch c.Kind {
+	case plugin.HostAppeared:
+		if len(c.Services) == 0 {
+			return fmt.Sprintf("host %s appeared", c.Address)
+		}
+		return fmt.Sprintf("host %s appeared (%s)", c.Address, strings.Join(c.Services, ", "))
+	case plugin.HostDisappeared:
+		return fmt.Sprintf("host %s disappeared", c.Address)
+	}
+	var parts []string
+	if len(c.Added) > 0 {
+		parts = append(parts, "+"+strings.Join(c.Added, " +"))
+	}
+	if len(c.Removed) > 0 {
+		parts = append(parts, "-"+strings.Join(c.Removed, " -"))
+	}
+	return fmt.Sprintf("host %s services changed (%s)", c.Address, strings.Join(parts, " "))
+}
+
+// changeRecord is the event metric stored for a change: network/host_change
+// with the kind as its value.
+func changeRecord(c plugin.HostChange) store.MetricRecord {
+	extra := map[string]interface{}{"services": c.Services}
+	if len(c.Added) > 0 {
+		extra["added"] = c.Added
+	}
+	if len(c.Removed) > 0 {
+		extra["removed"] = c.Removed
+	}
+	return store.MetricRecord{
+		HostKey:     c.Address,
+		HostName:    c.Address,
+		HostAddress: c.Address,
+		Plugin:      "network",
+		Name:        "host_change",
+		Category:    "discovery",
+		MetricType:  "event",
+		Value:       c.Kind,
+		Extra:       extra,
+		CollectedAt: c.At,
+	}
+}
@@ -0,0 +1,236 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// describe lists changes as "kind address +added -removed" lines.
+func describe(changes []plugin.HostChange) []string {
+	var out []string
+	for _, c := range changes {
+		line := c.Kind + " " + c.Address
+		for _, s := range c.Added {
+			line += " +" + s
+		}
+		for _, s := range c.Removed {
+			line += " -" + s
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func TestDiffScan(t *testing.T) {
+	state := &perceptionState{}
+	first := map[string][]string{
+		"192.0.2.10": {"network.ping", "network.ssh"},
+		"192.0.2.11": {"network.ping"},
+		"192.0.2.12": {"network.ping", "network.http"},
+	}
+	// The first run is the baseline.
+	if changes := diffScan(state, first, true, 0, t0); len(changes) != 0 {
+		t.Errorf("baseline reported %v", describe(changes))
+	}
+	if len(state.Hosts) != 3 {
+		t.Fatalf("state = %v", state.Hosts)
+	}
+
+	second := map[string][]string{
+		"192.0.2.10": {"network.ssh", "network.ping"}, // same services, other order
+		"192.0.2.12": {"network.ping", "network.https", "network.ping"},
+		"192.0.2.20": {"network.ping", "snmp.system"},
+	}
+	changes := diffScan(state, second, true, 1, t0.Add(time.Hour))
+	want := []string{
+		"appeared 192.0.2.20",
+		"disappeared 192.0.2.11",
+		"services_changed 192.0.2.12 +network.https -network.http",
+	}
+	if got := describe(changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %q, want %q", got, want)
+	}
+	if c := changes[0]; !reflect.DeepEqual(c.Services, []string{"network.ping", "snmp.system"}) || !c.At.Equal(t0.Add(time.Hour)) {
+		t.Errorf("appeared = %+v", c)
+	}
+	if c := changes[1]; !reflect.DeepEqual(c.Services, []string{"network.ping"}) {
+		t.Errorf("disappeared host's last services = %v", c.Services)
+	}
+	if _, ok := state.Hosts["192.0.2.11"]; ok {
+		t.Error("disappeared host kept in the state")
+	}
+	if h := state.Hosts["192.0.2.10"]; !h.FirstSeen.Equal(t0) || !h.LastSeen.Equal(t0.Add(time.Hour)) {
+		t.Errorf("192.0.2.10 = %+v", h)
+	}
+}
+
+func TestMissedScansBeforeDisappearing(t *testing.T) {
+	state := &perceptionState{}
+	both := map[string][]string{"192.0.2.10": {"network.ping"}, "192.0.2.11": {"network.ping"}}
+	one := map[string][]string{"192.0.2.10": {"network.ping"}}
+	diffScan(state, both, true, 3, t0)
+
+	// Missed twice, back, then missed three times.
+	for i, tt := range []struct {
+		scan     map[string][]string
+		complete bool
+		want     []string
+	}{
+		{one, true, nil},
+		{one, true, nil},
+		{both, true, nil},
+		{one, true, nil},
+		{one, false, nil}, // an environment failed: not a miss
+		{one, true, nil},
+		{one, true, []string{"disappeared 192.0.2.11"}},
+		{both, true, []string{"appeared 192.0.2.11"}},
+	} {
+		changes := diffScan(state, tt.scan, tt.complete, 3, t0.Add(time.Duration(i+1)*time.Hour))
+		if got := describe(changes); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("scan %d: %q, want %q", i+1, got, tt.want)
+		}
+	}
+
+	// The default tolerates defaultMisses scans.
+	state = &perceptionState{}
+	diffScan(state, both, true, 0, t0)
+	for i := 1; i < defaultMisses; i++ {
+		if changes := diffScan(state, one, true, 0, t0); len(changes) != 0 {
+			t.Fatalf("miss %d: %q", i, describe(changes))
+		}
+	}
+	if changes := diffScan(state, one, true, 0, t0); len(changes) != 1 {
+		t.Errorf("miss %d: %q", defaultMisses, describe(changes))
+	}
+}
+
+func TestCanonicalAddress(t *testing.T) {
+	for in, want := range map[string]string{
+		" 192.0.2.10 ":              "192.0.2.10",
+		"::ffff:192.0.2.10":         "192.0.2.10",
+		"2001:DB8:0:0:0:0:0:1":      "2001:db8::1",
+		"2001:0db8:0000::0001":      "2001:db8::1",
+		"Printer.Office.Example":    "printer.office.example",
+		"fe80::1%eth0":              "fe80::1%eth0",
+		"2001:db8::0:0:1":           "2001:db8::1",
+		"not an address, just text": "not an address, just text",
+	} {
+		if got := canonicalAddress(in); got != want {
+			t.Errorf("%q = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// useStateDir points the state directory at a temporary one for the test.
+func useStateDir(t *testing.T) {
+	t.Helper()
+	t.Setenv(plugin.EnvStateDir, t.TempDir())
+	plugin.LoadPaths()
+	t.Cleanup(plugin.LoadPaths)
+}
+
+// discovered builds perception's discovered hosts from address and services pairs.
+func discovered(hosts map[string][]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(hosts))
+	for addr, services := range hosts {
+		out[addr] = map[string]interface{}{"address": addr, "collect": services}
+	}
+	return out
+}
+
+func TestReportChanges(t *testing.T) {
+	useStateDir(t)
+	st, err := store.Open("sqlite://" + filepath.Join(t.TempDir(), "nord.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	c := plugin.NewController()
+	c.Store = st
+	p := &networkPlugin{}
+	p.Controller = c
+	var events []plugin.HostChange
+	c.Subscribe(plugin.EventHostChange, func(e plugin.Event) { events = append(events, e.Data.(plugin.HostChange)) })
+
+	// A failed first run records no baseline.
+	p.reportChanges(discovered(map[string][]string{"192.0.2.10": {"network.ping"}}), false, 1)
+	if state := loadPerceptionState(); state.Hosts != nil {
+		t.Fatalf("baseline from an incomplete scan: %v", state.Hosts)
+	}
+
+	// A complete one does, without events.
+	p.reportChanges(discovered(map[string][]string{"192.0.2.10": {"network.ping"}}), true, 1)
+	if state := loadPerceptionState(); len(state.Hosts) != 1 || len(events) != 0 {
+		t.Fatalf("baseline: state %v, events %v", state.Hosts, events)
+	}
+
+	// A state saved before addresses were canonicalized still matches.
+	saved := `{"hosts": {
+		"192.0.2.10": {"services": ["network.ping"], "first_seen": "2024-05-01T12:00:00Z"},
+		"2001:DB8:0:0:0:0:0:5": {"services": ["network.ping", "network.ssh"]}}}`
+	if err := plugin.WriteStateFile(perceptionStateFile, []byte(saved), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p.reportChanges(discovered(map[string][]string{
+		"::ffff:192.0.2.10": {"network.ping", "network.http"},
+		"2001:DB8::5":       {"network.ping", "network.ssh"},
+		"192.0.2.30":        {"network.ping"},
+	}), true, 1)
+	want := []string{"appeared 192.0.2.30", "services_changed 192.0.2.10 +network.http"}
+	if got := describe(events); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+
+	ctx := context.Background()
+	records, err := st.LatestMetrics(ctx, "192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("records = %+v", records)
+	}
+	r := records[0]
+	if r.Plugin != "network" || r.Name != "host_change" || r.MetricType != "event" || r.Value != plugin.HostServicesChanged ||
+		r.Category != "discovery" || fmt.Sprint(r.Extra["added"]) != "[network.http]" {
+		t.Errorf("record = %+v", r)
+	}
+	if _, ok := r.Extra["removed"]; ok {
+		t.Errorf("removed recorded without removals: %v", r.Extra)
+	}
+
+	// One missed scan is enough here; the host is gone.
+	events = nil
+	p.reportChanges(discovered(map[string][]string{"192.0.2.10": {"network.ping", "network.http"}, "192.0.2.30": {"network.ping"}}), true, 1)
+	if got := strings.Join(describe(events), "|"); got != "disappeared 2001:db8::5" {
+		t.Errorf("events = %q", got)
+	}
+}
+
+func TestDescribeChange(t *testing.T) {
+	for _, tt := range []struct {
+		c    plugin.HostChange
+		want string
+	}{
+		{plugin.HostChange{Kind: plugin.HostAppeared, Address: "192.0.2.1"}, "host 192.0.2.1 appeared"},
+		{plugin.HostChange{Kind: plugin.HostAppeared, Address: "192.0.2.1", Services: []string{"network.ping", "snmp.system"}},
+			"host 192.0.2.1 appeared (network.ping, snmp.system)"},
+		{plugin.HostChange{Kind: plugin.HostDisappeared, Address: "192.0.2.1", Services: []string{"network.ping"}}, "host 192.0.2.1 disappeared"},
+		{plugin.HostChange{Kind: plugin.HostServicesChanged, Address: "192.0.2.1", Added: []string{"a", "b"}, Removed: []string{"c"}},
+			"host 192.0.2.1 services changed (+a +b -c)"},
+	} {
+		if got := describeChange(tt.c); got != tt.want {
+			t.Errorf("%q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	plugin "observer/base"
+)
+
+// roleRulesFile is the device definition, under the network plugin's devices
+// directory, holding the rules that label discovered hosts with a role.
+const roleRulesFile = "roles"
+
+// Scalar OIDs of the SNMP system group read back from the store.
+const (
+	oidSysDescr    = ".1.3.6.1.2.1.1.1.0"
+	oidSysObjectID = ".1.3.6.1.2.1.1.2.0"
+)
+
+// probeTimeout bounds each TCP connect of the port profile; probeWorkers is
+// how many hosts are probed at once.
+const (
+	probeTimeout = time.Second
+	probeWorkers = 16
+)
+
+// roleRule adds Score to Role when every condition it sets holds. Lists match
+// when any of their items does, except Services, which all have to be
+// detected. A rule setting no condition never matches.
+type roleRule struct {
+	Name          string   `json:"name"`
+	Role          string   `json:"role"`
+	Score         int      `json:"score"`          // default 1
+	Services      []string `json:"services"`       // detected services, e.g. network.ssh
+	Ports         []int    `json:"ports"`          // open TCP ports
+	Vendors       []string `json:"vendors"`        // substrings of the MAC vendor, case-insensitive
+	OUI           []string `json:"oui"`            // MAC prefixes, e.g. 00:1b:17
+	SysObjectID   []string `json:"sys_object_id"`  // sysObjectID prefixes
+	SysDescr      string   `json:"sys_descr"`      // regex on sysDescr
+	SNMP          *bool    `json:"snmp"`           // whether SNMP data is stored for the host
+	MinInterfaces int      `json:"min_interfaces"` // stored interface count
+
+	sysDescr *regexp.Regexp
+}
+
+// roleRules is the content of the rules file. A role needs MinScore points,
+// default 2, to be assigned; ties go to the role whose rule comes first.
+type roleRules struct {
+	MinScore int        `json:"min_score"`
+	Rules    []roleRule `json:"rules"`
+}
+
+// loadRoleRules reads and checks the rules file.
+func loadRoleRules() (*roleRules, error) {
+	filename := plugin.DeviceFile("network", roleRulesFile)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read role rules %s: %w", filename, err)
+	}
+	var rules roleRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("could not parse role rules %s: %w", filename, err)
+	}
+	if err := rules.compile(); err != nil {
+		return nil, fmt.Errorf("role rules %s: %w", filename, err)
+	}
+	return &rules, nil
+}
+
+// compile fills in defaults and compiles the regexes.
+func (rs *roleRules) compile() error {
+	if rs.MinScore <= 0 {
+		rs.MinScore = 2
+	}
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		if r.Role == "" {
+			return fmt.Errorf("rule %d (%s) has no role", i, r.Name)
+		}
+		if r.Name == "" {
+			r.Name = r.Role
+		}
+		if r.Score == 0 {
+			r.Score = 1
+		}
+		for j, p := range r.OUI {
+			r.OUI[j] = normalizeMAC(p)
+		}
+		if r.SysDescr != "" {
+			re, err := regexp.Compile(r.SysDescr)
+			if err != nil {
+				return fmt.Errorf("rule %s: sys_descr: %w", r.Name, err)
+			}
+			r.sysDescr = re
+		}
+	}
+	return nil
+}
+
+// ports returns the TCP ports any rule looks at, sorted.
+func (rs *roleRules) ports() []int {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, r := range rs.Rules {
+		for _, p := range r.Ports {
+			if !seen[p] {
+				seen[p] = true
+				ports = append(ports, p)
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// evidence is what is known about a discovered host when its role is guessed:
+// the scan's findings plus what the store holds about it.
+type evidence struct {
+	MAC         string   // lower case, colon separated
+	Vendor      string   // from nmap's OUI lookup
+	Services    []string // detected by perception
+	OpenPorts   []int    // of the rules' ports
+	SNMP        bool     // snmp metrics are stored for the host
+	SysObjectID string
+	SysDescr    string
+	Interfaces  int    // stored interface rows
+	KnownAs     string // configured host the address or MAC belongs to
+}
+
+// match reports whether every condition of the rule holds for ev.
+func (r *roleRule) match(ev evidence) bool {
+	conditions := 0
+	if len(r.Services) > 0 {
+		conditions++
+		for _, s := range r.Services {
+			if !slices.Contains(ev.Services, s) {
+				return false
+			}
+		}
+	}
+	if len(r.Ports) > 0 {
+		conditions++
+		if !slices.ContainsFunc(r.Ports, func(p int) bool { return slices.Contains(ev.OpenPorts, p) }) {
+			return false
+		}
+	}
+	if len(r.Vendors) > 0 {
+		conditions++
+		vendor := strings.ToLower(ev.Vendor)
+		if vendor == "" || !slices.ContainsFunc(r.Vendors, func(v string) bool { return strings.Contains(vendor, strings.ToLower(v)) }) {
+			return false
+		}
+	}
+	if len(r.OUI) > 0 {
+		conditions++
+		if ev.MAC == "" || !slices.ContainsFunc(r.OUI, func(p string) bool { return strings.HasPrefix(ev.MAC, p) }) {
+			return false
+		}
+	}
+	if len(r.SysObjectID) > 0 {
+		conditions++
+		oid := strings.TrimPrefix(ev.SysObjectID, ".")
+		if oid == "" || !slices.ContainsFunc(r.SysObjectID, func(p string) bool { return strings.HasPrefix(oid, strings.TrimPrefix(p, ".")) }) {
+			return false
+		}
+	}
+	if r.sysDescr != nil {
+		conditions++
+		if !r.sysDescr.MatchString(ev.SysDescr) {
+			return false
+		}
+	}
+	if r.SNMP != nil {
+		conditions++
+		if *r.SNMP != ev.SNMP {
+			return false
+		}
+	}
+	if r.MinInterfaces > 0 {
+		conditions++
+		if ev.Interfaces < r.MinInterfaces {
+			return false
+		}
+	}
+	return conditions > 0
+}
+
+// classify scores each role by the rules matching ev and returns the best one
+// reaching MinScore with the names of its rules, or "" when none does.
+func (rs *roleRules) classify(ev evidence) (string, []string) {
+	scores := make(map[string]int)
+	matched := make(map[string][]string)
+	var order []string
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		if !r.match(ev) {
+			continue
+		}
+		if _, seen := scores[r.Role]; !seen {
+			order = append(order, r.Role)
+		}
+		scores[r.Role] += r.Score
+		matched[r.Role] = append(matched[r.Role], r.Name)
+	}
+	best := ""
+	for _, role := range order {
+		if scores[role] >= rs.MinScore && (best == "" || scores[role] > scores[best]) {
+			best = role
+		}
+	}
+	if best == "" {
+		return "", nil
+	}
+	return best, matched[best]
+}
+
+// identifyHosts guesses the role of each discovered host from the scan, a
+// port profile and the store, and writes it into the host's entry with the
+// rules that matched, the configured host it is, and the MAC address and
+// vendor when only the store knew them.
+func (p *networkPlugin) identifyHosts(discoveredHosts map[string]interface{}, config plugin.Config) {
+	rules, err := loadRoleRules()
+	if err != nil {
+		p.Controller.Printf("  !_ perception: %v; hosts are not identified\n", err)
+		return
+	}
+	known := p.storedIdentities(config)
+	openPorts := probePorts(discoveredHosts, rules.ports())
+
+	for ip, hostAny := range discoveredHosts {
+		entry, ok := hostAny.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ev := evidence{OpenPorts: openPorts[ip]}
+		ev.Services, _ = entry["collect"].([]string)
+		ev.MAC, _ = entry["mac"].(string)
+		ev.Vendor, _ = entry["vendor"].(string)
+		p.addStoredEvidence(&ev, ip, known)
+
+		role, reasons := rules.classify(ev)
+		if ev.MAC != "" {
+			entry["mac"] = ev.MAC
+		}
+		if ev.Vendor != "" {
+			entry["vendor"] = ev.Vendor
+		}
+		if ev.KnownAs != "" {
+			entry["known_as"] = ev.KnownAs
+		}
+		if role != "" {
+			entry["role"] = role
+			entry["role_evidence"] = reasons
+			p.Controller.Printf("        |_ %s looks like a %s (%s)\n", ip, role, strings.Join(reasons, ", "))
+		}
+	}
+}
+
+// storedIdentities maps the addresses of configured hosts and the MAC
+// addresses of their stored interfaces to the host key.
+type storedIdentities struct {
+	byAddress map[string]string
+	byMAC     map[string]string
+}
+
+func (p *networkPlugin) storedIdentities(config plugin.Config) storedIdentities {
+	ids := storedIdentities{byAddress: make(map[string]string), byMAC: make(map[string]string)}
+	keys := make([]string, 0, len(config.Hosts))
+	for key := range config.Hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // the first key wins when hosts share an address
+	for _, key := range keys {
+		if addr := config.Hosts[key].Address; addr != "" {
+			if _, dup := ids.byAddress[canonicalAddress(addr)]; !dup {
+				ids.byAddress[canonicalAddress(addr)] = key
+			}
+		}
+		if p.Controller.Store == nil {
+			continue
+		}
+		ifaces, err := p.Controller.Store.GetInterfaces(context.Background(), key)
+		if err != nil {
+			p.Controller.Printf("  !_ store: interfaces of %s: %v\n", key, err)
+			continue
+		}
+		for _, i := range ifaces {
+			if mac := normalizeMAC(i.MACAddress); mac != "" && mac != "00:00:00:00:00:00" {
+				if _, dup := ids.byMAC[mac]; !dup {
+					ids.byMAC[mac] = key
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// addStoredEvidence completes ev from the store: the configured host the
+// address or MAC belongs to, with its SNMP system group and interface count,
+// and the MAC and vendor recorded by an earlier run when this scan saw none.
+func (p *networkPlugin) addStoredEvidence(ev *evidence, ip string, known storedIdentities) {
+	if p.Controller.Store == nil {
+		return
+	}
+	if ev.MAC == "" {
+		if latest, err := p.Controller.Store.LatestMetrics(context.Background(), ip); err == nil {
+			for _, r := range latest {
+				if r.Category != "discovery" {
+					continue
+				}
+				if mac, _ := r.Extra["mac"].(string); mac != "" {
+					ev.MAC = mac
+					ev.Vendor, _ = r.Extra["vendor"].(string)
+					break
+				}
+			}
+		}
+	}
+
+	key := known.byAddress[canonicalAddress(ip)]
+	if key == "" && ev.MAC != "" {
+		key = known.byMAC[ev.MAC]
+	}
+	if key == "" {
+		return
+	}
+	ev.KnownAs = key
+	if ifaces, err := p.Controller.Store.GetInterfaces(context.Background(), key); err == nil {
+		ev.Interfaces = len(ifaces)
+	}
+	latest, err := p.Controller.Store.LatestMetrics(context.Background(), key)
+	if err != nil {
+		return
+	}
+	for _, r := range latest {
+		if r.Plugin != "snmp" {
+			continue
+		}
+		ev.SNMP = true
+		switch oid, _ := r.Extra["oid"].(string); {
+		case oid == oidSysObjectID || r.Name == "System Object ID":
+			ev.SysObjectID = r.Value
+		case oid == oidSysDescr || r.Name == "System Description":
+			ev.SysDescr = r.Value
+		}
+	}
+}
+
+// probePorts checks which of ports are open on each discovered host, a few
+// hosts at a time and every port of a host at once.
+func probePorts(discoveredHosts map[string]interface{}, ports []int) map[string][]int {
+	open := make(map[string][]int)
+	if len(ports) == 0 {
+		return open
+	}
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, probeWorkers)
+	)
+	for ip := range discoveredHosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ip string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			found := make([]bool, len(ports))
+			var hostWG sync.WaitGroup
+			for i, port := range ports {
+				hostWG.Add(1)
+				go func(i, port int) {
+					defer hostWG.Done()
+					conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), probeTimeout)
+					if err == nil {
+						conn.Close()
+						found[i] = true
+					}
+				}(i, port)
+			}
+			hostWG.Wait()
+			var list []int
+			for i, ok := range found {
+				if ok {
+					list = append(list, ports[i])
+				}
+			}
+			mu.Lock()
+			open[ip] = list
+			mu.Unlock()
+		}(ip)
+	}
+	wg.Wait()
+	return open
+}
+
+// nmapMAC returns the MAC address, lower case, and vendor nmap reported for a
+// host. nmap only sees them on a directly attached network, as root.
+func nmapMAC(host Host) (mac, vendor string) {
+	for _, addr := range host.Addresses {
+		if addr.AddrType == "mac" {
+			return normalizeMAC(addr.Addr), addr.Vendor
+		}
+	}
+	return "", ""
+}
+
+// normalizeMAC lower-cases a MAC address or prefix and uses colons.
+func normalizeMAC(mac string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(mac)), "-", ":")
+}
+
+// discoveryExtra is the identification of a discovered host stored in the
+// extra of its discovery metrics.
+func discoveryExtra(entry map[string]interface{}) map[string]interface{} {
+	extra := make(map[string]interface{})
+	for _, k := range []string{"role", "role_evidence", "mac", "vendor", "known_as"} {
+		if v, ok := entry[k]; ok {
+			extra[k] = v
+		}
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	return extra
+}
@@ -0,0 +1,231 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+// shippedRules loads the rules file the plugin ships with.
+func shippedRules(t *testing.T) *roleRules {
+	t.Helper()
+	data, err := os.ReadFile("devices/" + roleRulesFile + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules roleRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		t.Fatal(err)
+	}
+	if err := rules.compile(); err != nil {
+		t.Fatal(err)
+	}
+	return &rules
+}
+
+func TestClassifyShippedRules(t *testing.T) {
+	rules := shippedRules(t)
+	for _, tt := range []struct {
+		name    string
+		ev      evidence
+		role    string
+		reasons []string
+	}{
+		{"laser printer", evidence{SNMP: true, SysDescr: "HP LaserJet M404dn", OpenPorts: []int{9100, 631}},
+			"printer", []string{"printer sysDescr", "printing ports"}},
+		{"printer by vendor and ports", evidence{Vendor: "Brother Industries", OpenPorts: []int{515}},
+			"printer", []string{"printing ports", "printer vendor"}},
+		{"catalyst", evidence{SNMP: true, SysDescr: "Cisco IOS Software, Catalyst L3 Switch", Interfaces: 52},
+			"switch", []string{"switch sysDescr", "many interfaces"}},
+		{"switch by enterprise oid", evidence{SNMP: true, SysObjectID: ".1.3.6.1.4.1.674.10895.3031"},
+			"switch", []string{"switch enterprise"}},
+		{"mikrotik", evidence{Vendor: "Routerboard.com (MikroTik)", OpenPorts: []int{53, 8291}},
+			"router", []string{"winbox", "network vendor", "dns"}},
+		{"junos", evidence{SNMP: true, SysObjectID: "1.3.6.1.4.1.2636.1.1.1.2.21", SysDescr: "Juniper Networks, Inc. srx300 JUNOS 21.4R3"},
+			"router", []string{"router sysDescr", "router enterprise"}},
+		{"linux server", evidence{Services: []string{"network.ping", "network.ssh"}, OpenPorts: []int{5432}},
+			"server", []string{"ssh", "service ports"}},
+		{"virtual machine", evidence{Vendor: "QEMU virtual NIC", Services: []string{"network.ssh"}},
+			"server", []string{"ssh", "server vendor"}},
+		// One weak hint is not enough.
+		{"ssh only", evidence{Services: []string{"network.ssh"}}, "", nil},
+		{"dns with snmp", evidence{SNMP: true, OpenPorts: []int{53}}, "", nil},
+		{"nothing", evidence{}, "", nil},
+		// The highest score wins: a Linux box serving a print queue.
+		{"print server", evidence{SNMP: true, SysDescr: "Linux print01 6.1.0", Services: []string{"network.ssh"}, OpenPorts: []int{631, 3306}},
+			"server", []string{"server sysDescr", "ssh", "service ports"}},
+	} {
+		role, reasons := rules.classify(tt.ev)
+		if role != tt.role || !reflect.DeepEqual(reasons, tt.reasons) {
+			t.Errorf("%s: %q %q, want %q %q", tt.name, role, reasons, tt.role, tt.reasons)
+		}
+	}
+	if got := rules.ports(); !reflect.DeepEqual(got, []int{25, 53, 110, 143, 445, 515, 631, 1433, 3306, 3389, 5432, 6379, 8080, 8291, 9100}) {
+		t.Errorf("ports = %v", got)
+	}
+}
+
+func TestRoleRuleMatch(t *testing.T) {
+	yes := true
+	rules := &roleRules{Rules: []roleRule{
+		{Name: "empty", Role: "server"},
+		{Role: "switch", OUI: []string{"00-1B-17"}},
+		{Role: "switch", SysObjectID: []string{".1.3.6.1.4.1.9."}},
+		{Name: "all services", Role: "server", Services: []string{"network.ssh", "network.http"}},
+		{Name: "snmp and ports", Role: "router", SNMP: &yes, Ports: []int{179}},
+	}}
+	if err := rules.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if rules.MinScore != 2 || rules.Rules[1].Name != "switch" || rules.Rules[1].Score != 1 || rules.Rules[1].OUI[0] != "00:1b:17" {
+		t.Fatalf("compiled = %+v", rules)
+	}
+	for i, tt := range []struct {
+		rule int
+		ev   evidence
+		want bool
+	}{
+		{0, evidence{Services: []string{"network.ssh"}}, false}, // no condition: never matches
+		{1, evidence{MAC: "00:1b:17:aa:bb:cc"}, true},
+		{1, evidence{MAC: "00:1b:18:aa:bb:cc"}, false},
+		{1, evidence{}, false},
+		{2, evidence{SysObjectID: "1.3.6.1.4.1.9.1.2494"}, true},
+		{2, evidence{SysObjectID: ".1.3.6.1.4.1.99.1"}, false},
+		{3, evidence{Services: []string{"network.http", "network.ssh"}}, true},
+		{3, evidence{Services: []string{"network.ssh"}}, false},
+		{4, evidence{SNMP: true, OpenPorts: []int{22, 179}}, true},
+		{4, evidence{OpenPorts: []int{179}}, false},
+	} {
+		if got := rules.Rules[tt.rule].match(tt.ev); got != tt.want {
+			t.Errorf("case %d: %s matches %+v = %v", i, rules.Rules[tt.rule].Name, tt.ev, got)
+		}
+	}
+
+	// Ties go to the role whose rule comes first.
+	tie := &roleRules{Rules: []roleRule{
+		{Name: "a", Role: "router", Score: 2, Ports: []int{22}},
+		{Name: "b", Role: "server", Score: 2, Ports: []int{22}},
+	}}
+	if err := tie.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := tie.classify(evidence{OpenPorts: []int{22}}); role != "router" {
+		t.Errorf("tie went to %q", role)
+	}
+
+	for _, tt := range []struct {
+		rule roleRule
+		msg  string
+	}{
+		{roleRule{Name: "nameless"}, "rule 0 (nameless) has no role"},
+		{roleRule{Name: "bad", Role: "printer", SysDescr: "(laserjet"}, "rule bad: sys_descr"},
+	} {
+		rs := &roleRules{Rules: []roleRule{tt.rule}}
+		if err := rs.compile(); err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%+v: %v, want %q", tt.rule, err, tt.msg)
+		}
+	}
+}
+
+func TestIdentifyHosts(t *testing.T) {
+	// A port to find open, and rules looking at it, the stored switch and an OUI.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	devices := t.TempDir()
+	t.Setenv(plugin.EnvDevicesDir, devices)
+	plugin.LoadPaths()
+	t.Cleanup(plugin.LoadPaths)
+	rulesFile := filepath.Join(devices, "network", roleRulesFile+".json")
+	if err := os.MkdirAll(filepath.Dir(rulesFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rules := fmt.Sprintf(`{"rules": [
+		{"name": "admin port", "role": "router", "score": 2, "ports": [%d]},
+		{"name": "catalyst", "role": "switch", "score": 3, "sys_descr": "(?i)catalyst"},
+		{"name": "lab printers", "role": "printer", "score": 2, "oui": ["00-00-5E"]}
+	]}`, port)
+	if err := os.WriteFile(rulesFile, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := store.Open("sqlite://" + filepath.Join(t.TempDir(), "nord.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	at := time.Now()
+	// The configured switch "core", known by the MAC of one of its interfaces.
+	if err := st.UpsertInterfaces(ctx, []store.InterfaceRecord{
+		{HostKey: "core", IfIndex: 1, Name: "Gi0/1", MACAddress: "00:1B:17:00:00:01"},
+		{HostKey: "core", IfIndex: 2, Name: "Gi0/2", MACAddress: "00:00:00:00:00:00"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.WriteBatch(ctx, []store.MetricRecord{
+		{HostKey: "core", Plugin: "snmp", Name: "System Description", Value: "Cisco IOS Software, Catalyst 9300",
+			Extra: map[string]interface{}{"oid": oidSysDescr}, CollectedAt: at},
+		// An earlier perception run saw 127.0.0.2's MAC.
+		{HostKey: "127.0.0.2", Plugin: "network", Name: "ping", Category: "discovery", Value: "up",
+			Extra: map[string]interface{}{"mac": "00:1b:17:00:00:01", "vendor": "Cisco Systems"}, CollectedAt: at},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := plugin.NewController()
+	c.Store = st
+	p := &networkPlugin{}
+	p.Controller = c
+	config := plugin.Config{Hosts: map[string]plugin.Host{"core": {Address: "192.0.2.1"}}}
+	hosts := discovered(map[string][]string{
+		"127.0.0.1": {"network.ping"},
+		"127.0.0.2": {"network.ping"},
+		"127.0.0.3": {"network.ping"},
+		"127.0.0.4": {"network.ping"},
+	})
+	hosts["127.0.0.3"].(map[string]interface{})["mac"] = "00:00:5e:00:53:01"
+
+	p.identifyHosts(hosts, config)
+	got := make(map[string]string)
+	for ip, e := range hosts {
+		extra := discoveryExtra(e.(map[string]interface{}))
+		delete(extra, "role_evidence")
+		got[ip] = fmt.Sprint(extra)
+	}
+	want := map[string]string{
+		"127.0.0.1": "map[role:router]",
+		"127.0.0.2": "map[known_as:core mac:00:1b:17:00:00:01 role:switch vendor:Cisco Systems]",
+		"127.0.0.3": "map[mac:00:00:5e:00:53:01 role:printer]",
+		"127.0.0.4": "map[]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("identified:\n%v\nwant:\n%v", got, want)
+	}
+	if reasons := hosts["127.0.0.2"].(map[string]interface{})["role_evidence"]; !reflect.DeepEqual(reasons, []string{"catalyst"}) {
+		t.Errorf("evidence = %v", reasons)
+	}
+}
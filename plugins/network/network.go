@@ -3,16 +3,17 @@ package network
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"observer/base"
 	"observer/plugins"
 	"observer/store"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -36,6 +37,7 @@ type Status struct {
 type Address struct {
 	Addr     string `xml:"addr,attr"`
 	AddrType string `xml:"addrtype,attr"`
+	Vendor   string `xml:"vendor,attr"` // OUI vendor of a mac address
 }
 
 type Hostname struct {
@@ -47,6 +49,7 @@ type Hostname struct {
 // networkPlugin performs network-related checks.
 type networkPlugin struct {
 	plugin.BasePlugin
+	perceptionMu sync.Mutex // serializes perception runs, which share perception_state.json
 }
 
 func init() {
@@ -58,20 +61,72 @@ func (p *networkPlugin) Name() string {
 	return "Network"
 }
 
+// Actions advertises the reachability checks that can be run against a host on demand.
+func (p *networkPlugin) Actions() []plugin.ActionSpec {
+	hasAddress := func(h plugin.Host) bool { return h.Address != "" }
+	return []plugin.ActionSpec{
+		{Action: "ping", Label: "Ping (TCP 80/22)", Safety: plugin.SafetyRead, Applies: hasAddress},
+		{Action: "portcheck", Label: "Check a TCP port", Safety: plugin.SafetyRead, Applies: hasAddress,
+			Params: []plugin.ActionParam{{Name: "port", Label: "Port", Default: "22"}}},
+	}
+}
+
 // OnCommand handles actions for the network plugin, including perception.
 func (p *networkPlugin) OnCommand(args map[string]string) error {
 	action := args["action"]
-	if action == "perception" {
+	switch action {
+	case "perception":
 		return p.runPerception()
+	case "ping", "portcheck":
+		return p.runCheck(action, parseArgs(args["args"]))
 	}
 	return fmt.Errorf("unknown command for Network plugin: %s", action)
 }
 
+// runCheck runs a one-off reachability check against address=<addr> and reports the result.
+func (p *networkPlugin) runCheck(action string, params map[string]string) error {
+	address := params["address"]
+	if address == "" {
+		return fmt.Errorf("%s: address is required", action)
+	}
+
+	var up bool
+	label := action
+	if action == "portcheck" {
+		port := params["port"]
+		if port == "" {
+			port = "22"
+		}
+		label = fmt.Sprintf("port %s", port)
+		up = p.isPortOpen(address, port)
+	} else {
+		up = p.isPortOpen(address, "80") || p.isPortOpen(address, "22")
+	}
+
+	if !up {
+		return fmt.Errorf("%s %s: down", address, label)
+	}
+	p.Controller.Printf("  |_ %s %s: up\n", address, label)
+	return nil
+}
+
+// parseArgs parses "key=value key2=value2" into a map.
+func parseArgs(argsStr string) map[string]string {
+	result := make(map[string]string)
+	for _, part := range strings.Fields(argsStr) {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			result[kv[0]] = kv[1]
+		}
+	}
+	return result
+}
+
 // OnCollect handles data collection for the network plugin.
 func (p *networkPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
 	action, _ := options["action"].(string)
 	host, _ := options["host"].(map[string]interface{})
-	address, _ := host["address"].(string)
+	address := plugin.TargetAddress(options)
 
 	var status bool
 	var label, category string
@@ -112,6 +167,15 @@ func (p *networkPlugin) OnCollect(options map[string]interface{}) (map[string]in
 	return map[string]interface{}{"metrics": map[string]interface{}{label: metric}}, nil
 }
 
+// privileged prefixes a command with sudo. Windows has no sudo; nmap there
+// needs an elevated nord instead.
+func privileged(cmd []string) []string {
+	if plugin.GOOS == "windows" {
+		return cmd
+	}
+	return append([]string{"sudo"}, cmd...)
+}
+
 // isPortOpen checks if a TCP port is open at the given host.
 func (p *networkPlugin) isPortOpen(host, port string) bool {
 	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), 2*time.Second)
@@ -124,10 +188,12 @@ func (p *networkPlugin) isPortOpen(host, port string) bool {
 
 // runPerception is the main logic for the network discovery feature.
 func (p *networkPlugin) runPerception() error {
-	fmt.Println("--- Starting Network Perception ---")
+	p.perceptionMu.Lock()
+	defer p.perceptionMu.Unlock()
+	p.Controller.Println("--- Starting Network Perception ---")
 
 	// 1. Load Config
-	configFile, err := ioutil.ReadFile("data/config.json")
+	configFile, err := plugin.ReadConfigFile()
 	if err != nil {
 		return fmt.Errorf("could not read config file: %w", err)
 	}
@@ -137,33 +203,38 @@ func (p *networkPlugin) runPerception() error {
 	}
 
 	discoveredHosts := make(map[string]interface{})
+	complete, scanned := true, false
 
 	// 2. Iterate through perception environments
 	for name, env := range config.Perception {
 		if !env.Enabled {
-			fmt.Printf("    |_ Skipping environment '%s' (disabled)\n", name)
+			p.Controller.Printf("    |_ Skipping environment '%s' (disabled)\n", name)
 			continue
 		}
-		fmt.Printf("    |_ Scanning environment: %s\n", name)
+		p.Controller.Printf("    |_ Scanning environment: %s\n", name)
+		scanned = true
 
 		if env.Method == "nmap" {
 			// 3. Run Nmap
-			fmt.Printf("        |_ Running nmap on ranges: %s\n", strings.Join(env.Ranges, " "))
+			p.Controller.Printf("        |_ Running nmap on ranges: %s\n", strings.Join(env.Ranges, " "))
 			nmapArgs := []string{"nmap", "-sn", "-oX", "-"} // -sn: Ping Scan, -oX -: XML output to stdout
 			nmapArgs = append(nmapArgs, env.Ranges...)
-			cmd := exec.Command("sudo", nmapArgs...)
+			argv := privileged(nmapArgs)
+			cmd := exec.Command(argv[0], argv[1:]...)
 
 			var out bytes.Buffer
 			cmd.Stdout = &out
 			if err := cmd.Run(); err != nil {
-				fmt.Printf("          !_ nmap command failed: %v\n", err)
+				p.Controller.Printf("          !_ nmap command failed: %v\n", err)
+				complete = false
 				continue
 			}
 
 			// 4. Parse Nmap XML
 			var nmapResult NmapRun
 			if err := xml.Unmarshal(out.Bytes(), &nmapResult); err != nil {
-				fmt.Printf("          !_ Failed to parse nmap XML: %v\n", err)
+				p.Controller.Printf("          !_ Failed to parse nmap XML: %v\n", err)
+				complete = false
 				continue
 			}
 
@@ -183,39 +254,79 @@ func (p *networkPlugin) runPerception() error {
 					continue
 				}
 
-				fmt.Printf("        |_ Found host: %s\n", ip)
+				p.Controller.Printf("        |_ Found host: %s\n", ip)
 				validServices := p.testHost(ip, env.Detection)
-				discoveredHosts[ip] = map[string]interface{}{
+				entry := map[string]interface{}{
 					"address": ip,
 					"collect": validServices,
 				}
+				if len(host.Hostnames) > 0 && host.Hostnames[0].Name != "" {
+					entry["hostname"] = host.Hostnames[0].Name
+				}
+				if mac, vendor := nmapMAC(host); mac != "" {
+					entry["mac"] = mac
+					if vendor != "" {
+						entry["vendor"] = vendor
+					}
+				}
+				discoveredHosts[ip] = entry
 			}
 		}
 	}
 
-	// 6. Save results
+	// 6. Label likely device roles from the scan, a port profile and stored data.
+	if len(discoveredHosts) > 0 {
+		p.identifyHosts(discoveredHosts, config)
+	}
+
+	// 7. Save results
 	finalOutput := map[string]interface{}{"hosts": discoveredHosts}
 	jsonData, err := json.MarshalIndent(finalOutput, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal perception results: %w", err)
 	}
-	if err := ioutil.WriteFile("data/perception.json", jsonData, 0644); err != nil {
+	if err := plugin.WriteDataFile("perception.json", jsonData, 0644); err != nil {
 		return fmt.Errorf("failed to write perception.json: %w", err)
 	}
 
-	// 7. Persist discovered hosts and their detected services to the store.
+	// 8. Persist discovered hosts and their detected services to the store.
 	if p.Controller.Store != nil {
 		p.writePerceptionToStore(discoveredHosts)
 	}
 
-	fmt.Println("--- Network Perception Finished ---")
+	// 9. Share the results with later stages of the same process (nord run).
+	p.Controller.Publish(plugin.Event{Topic: plugin.EventHostsDiscovered, Source: "network", Data: perceivedHosts(discoveredHosts)})
+
+	// 10. Report hosts that appeared, disappeared or changed services since earlier runs.
+	p.reportChanges(discoveredHosts, complete && scanned, config.Daemon.Perception.Misses)
+
+	p.Controller.Println("--- Network Perception Finished ---")
 	return nil
 }
 
+// perceivedHosts converts perception results to typed hosts, one collect task per detected service.
+func perceivedHosts(discoveredHosts map[string]interface{}) map[string]plugin.Host {
+	hosts := make(map[string]plugin.Host, len(discoveredHosts))
+	for key, v := range discoveredHosts {
+		entry, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		host := plugin.Host{}
+		host.Address, _ = entry["address"].(string)
+		services, _ := entry["collect"].([]string)
+		for _, svc := range services {
+			host.Collect = append(host.Collect, plugin.CollectTask{Metric: svc})
+		}
+		hosts[key] = host
+	}
+	return hosts
+}
+
 // writePerceptionToStore persists each discovered host and its detected services.
 // Each detected service (e.g. "network.ping") is recorded as a status=up metric
 // under category "discovery" so the hosts table is populated and detection history
-// is queryable.
+// is queryable. The host's role, MAC address and vendor, when known, are in extra.
 func (p *networkPlugin) writePerceptionToStore(discoveredHosts map[string]interface{}) {
 	now := time.Now()
 	var records []store.MetricRecord
@@ -226,6 +337,7 @@ func (p *networkPlugin) writePerceptionToStore(discoveredHosts map[string]interf
 			continue
 		}
 		services, _ := hostMap["collect"].([]string)
+		extra := discoveryExtra(hostMap)
 
 		for _, svc := range services {
 			parts := strings.SplitN(svc, ".", 2)
@@ -245,6 +357,7 @@ func (p *networkPlugin) writePerceptionToStore(discoveredHosts map[string]interf
 				MetricType:  "status",
 				Value:       "up",
 				ValueNum:    &v,
+				Extra:       extra,
 				CollectedAt: now,
 			})
 		}
@@ -253,16 +366,16 @@ func (p *networkPlugin) writePerceptionToStore(discoveredHosts map[string]interf
 	if len(records) == 0 {
 		return
 	}
-	if err := p.Controller.Store.WriteBatch(records); err != nil {
-		fmt.Printf("  !_ store: perception WriteBatch error: %v\n", err)
+	if err := p.Controller.Store.WriteBatch(context.Background(), records); err != nil {
+		p.Controller.Printf("  !_ store: perception WriteBatch error: %v\n", err)
 	} else {
-		fmt.Printf("  |_ store: wrote %d perception records\n", len(records))
+		p.Controller.Printf("  |_ store: wrote %d perception records\n", len(records))
 	}
 }
 
 // testHost runs detection tests on a given IP.
 func (p *networkPlugin) testHost(ip string, tests []string) []string {
-	fmt.Printf("            |_ Testing services on %s...\n", ip)
+	p.Controller.Printf("            |_ Testing services on %s...\n", ip)
 	validServices := []string{}
 	for _, test := range tests {
 		parts := strings.Split(test, ".")
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+func TestPerceptionRecordsLand(t *testing.T) {
+	st, err := store.Open("sqlite://" + filepath.Join(t.TempDir(), "nord.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	c := plugin.NewController()
+	c.Store = st
+	p := &networkPlugin{}
+	p.Controller = c
+
+	p.writePerceptionToStore(map[string]interface{}{
+		"192.0.2.10": map[string]interface{}{
+			"address": "192.0.2.10",
+			"collect": []string{"network.ping", "snmp.system"},
+			"role":    "switch",
+			"mac":     "00:11:22:33:44:55",
+		},
+		"192.0.2.20": map[string]interface{}{
+			"address": "192.0.2.20",
+			"collect": []string{}, // nothing detected: nothing to record
+		},
+	})
+
+	ctx := context.Background()
+	hosts, err := st.ListHosts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].Key != "192.0.2.10" || hosts[0].Address != "192.0.2.10" {
+		t.Fatalf("hosts = %+v", hosts)
+	}
+
+	records, err := st.LatestMetrics(ctx, "192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]store.MetricRecord{}
+	for _, r := range records {
+		got[r.Plugin+"."+r.Name] = r
+	}
+	if len(got) != 2 {
+		t.Fatalf("records = %+v", records)
+	}
+	ping := got["network.ping"]
+	if ping.Category != "discovery" || ping.MetricType != "status" || ping.Value != "up" || ping.ValueNum == nil || *ping.ValueNum != 1 {
+		t.Errorf("ping = %+v", ping)
+	}
+	if ping.Extra["role"] != "switch" || ping.Extra["mac"] != "00:11:22:33:44:55" {
+		t.Errorf("extra = %v", ping.Extra)
+	}
+	if _, ok := got["snmp.system"]; !ok {
+		t.Error("snmp.system not recorded")
+	}
+}
+
+func TestPerceivedHosts(t *testing.T) {
+	hosts := perceivedHosts(map[string]interface{}{
+		"192.0.2.10": map[string]interface{}{"address": "192.0.2.10", "collect": []string{"network.ping", "snmp.system"}},
+		"bogus":      "not a host",
+	})
+	h, ok := hosts["192.0.2.10"]
+	if len(hosts) != 1 || !ok || h.Address != "192.0.2.10" || len(h.Collect) != 2 || h.Collect[1].Metric != "snmp.system" {
+		t.Errorf("hosts = %+v", hosts)
+	}
+}
+
+func TestPrivileged(t *testing.T) {
+	old := plugin.GOOS
+	t.Cleanup(func() { plugin.GOOS = old })
+	nmap := []string{"nmap", "-sn", "-oX", "-", "10.0.0.0/24"}
+
+	plugin.GOOS = "linux"
+	if got := strings.Join(privileged(nmap), " "); got != "sudo nmap -sn -oX - 10.0.0.0/24" {
+		t.Errorf("linux: %s", got)
+	}
+	plugin.GOOS = "windows"
+	if got := strings.Join(privileged(nmap), " "); got != "nmap -sn -oX - 10.0.0.0/24" {
+		t.Errorf("windows: %s", got)
+	}
+}
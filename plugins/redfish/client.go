@@ -0,0 +1,220 @@
+package redfish
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	plugin "observer/base"
+)
+
+// errUnreachable wraps failures to reach the Redfish service at all, which
+// is when the ipmitool fallback is tried.
+type errUnreachable struct{ err error }
+
+func (e *errUnreachable) Error() string { return e.err.Error() }
+func (e *errUnreachable) Unwrap() error { return e.err }
+
+// errAuth reports credentials the BMC refused.
+var errAuth = errors.New("authentication failed")
+
+const sessionStateFile = "redfish_sessions.json"
+
+// sessions caches session tokens by endpoint and user, so collections reuse
+// one BMC session instead of logging in each time: BMCs allow only a handful
+// of concurrent sessions and keep abandoned ones for many minutes. The cache
+// is kept in the state directory so one-shot runs share it too.
+var sessions = struct {
+	sync.Mutex
+	tokens map[string]string // nil until loaded
+}{}
+
+// sessionToken returns the cached token for key.
+func sessionToken(key string) string {
+	sessions.Lock()
+	defer sessions.Unlock()
+	loadSessions()
+	return sessions.tokens[key]
+}
+
+// setSessionToken caches token for key, or forgets key when token is empty.
+// A cache that cannot be saved is reported on progress.
+func setSessionToken(key, token string, progress io.Writer) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	loadSessions()
+	if token == "" {
+		delete(sessions.tokens, key)
+	} else {
+		sessions.tokens[key] = token
+	}
+	data, _ := json.MarshalIndent(sessions.tokens, "", "  ")
+	if err := plugin.WriteStateFile(sessionStateFile, data, 0600); err != nil {
+		fmt.Fprintf(progress, "          !_ redfish: could not save sessions: %v\n", err)
+	}
+}
+
+func loadSessions() {
+	if sessions.tokens != nil {
+		return
+	}
+	sessions.tokens = make(map[string]string)
+	if data, err := os.ReadFile(plugin.StateFile(sessionStateFile)); err == nil {
+		_ = json.Unmarshal(data, &sessions.tokens)
+	}
+}
+
+// client reads resources from one Redfish service.
+type client struct {
+	base       *url.URL
+	user, pass string
+	http       *http.Client
+	token      string
+	basic      bool      // the service has no session support; use basic auth
+	progress   io.Writer // where warnings are printed
+}
+
+// tlsOptions are a credential's certificate verification settings. BMCs
+// commonly ship self-signed certificates, hence the per-credential choice.
+type tlsOptions struct {
+	Insecure bool
+	CAFile   string
+}
+
+func newClient(address, port, user, pass string, tlsOpts tlsOptions, timeout time.Duration, progress io.Writer) (*client, error) {
+	base := "https://" + address
+	if port != "" && port != "0" && port != "443" {
+		base += ":" + port
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{InsecureSkipVerify: tlsOpts.Insecure}
+	if tlsOpts.CAFile != "" {
+		pem, err := os.ReadFile(tlsOpts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", tlsOpts.CAFile)
+		}
+	}
+	c := &client{
+		base: u, user: user, pass: pass, progress: progress,
+		http: &http.Client{Timeout: timeout, Transport: &http.Transport{TLSClientConfig: tc}},
+	}
+	c.token = sessionToken(c.sessionKey())
+	return c, nil
+}
+
+func (c *client) sessionKey() string {
+	return c.base.Host + "|" + c.user
+}
+
+// login creates a session and caches its token. Services without a session
+// service fall back to basic authentication.
+func (c *client) login(ctx context.Context) error {
+	body, _ := json.Marshal(map[string]string{"UserName": c.user, "Password": c.pass})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/redfish/v1/SessionService/Sessions"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &errUnreachable{err}
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return errAuth
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		c.basic = true
+		return nil
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("session login: HTTP %s", resp.Status)
+	}
+	token := resp.Header.Get("X-Auth-Token")
+	if token == "" {
+		c.basic = true
+		return nil
+	}
+	c.token = token
+	setSessionToken(c.sessionKey(), token, c.progress)
+	return nil
+}
+
+// get reads the resource at path (an @odata.id) into v, logging in first if
+// there is no session and once more if the cached session has expired.
+func (c *client) get(ctx context.Context, path string, v interface{}) error {
+	if c.token == "" && !c.basic {
+		if err := c.login(ctx); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(path), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if c.basic {
+			req.SetBasicAuth(c.user, c.pass)
+		} else {
+			req.Header.Set("X-Auth-Token", c.token)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return &errUnreachable{err}
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+		resp.Body.Close()
+		if err != nil {
+			return &errUnreachable{err}
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			if attempt == 0 && !c.basic {
+				c.forget()
+				if err := c.login(ctx); err != nil {
+					return err
+				}
+				continue
+			}
+			return errAuth
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: HTTP %s", path, resp.Status)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	}
+}
+
+// forget drops the cached session after the BMC refused it.
+func (c *client) forget() {
+	c.token = ""
+	setSessionToken(c.sessionKey(), "", c.progress)
+}
+
+func (c *client) url(path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return c.base.String() + path
+}
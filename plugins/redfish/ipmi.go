@@ -0,0 +1,148 @@
+package redfish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IPMI entity IDs of the components mapped to metrics.
+const (
+	entityPowerSupply = "10"
+	entityDisk        = "4"
+	entityDiskBay     = "26"
+)
+
+// collectIPMI reads the BMC with ipmitool: the chassis power state and the
+// sensor data repository. The password is passed in the environment (-E)
+// rather than on the command line.
+func collectIPMI(ctx context.Context, h *hardware, address, port, user, pass, iface string) error {
+	if _, err := exec.LookPath("ipmitool"); err != nil {
+		return errors.New("ipmitool is not installed")
+	}
+	if iface == "" {
+		iface = "lanplus"
+	}
+	args := []string{"-I", iface, "-H", address, "-U", user, "-E"}
+	if port != "" && port != "0" {
+		args = append(args, "-p", port)
+	}
+
+	out, err := runIPMI(ctx, pass, append(args, "chassis", "power", "status"))
+	if err != nil {
+		return err
+	}
+	state := "down"
+	if strings.Contains(strings.ToLower(out), "is on") {
+		state = "up"
+	}
+	h.add("power_state", "Power State", "chassis", "status", state, "System")["power_state"] = strings.TrimSpace(out)
+	if state != "up" {
+		h.problem("down", "chassis is powered off")
+	}
+
+	out, err = runIPMI(ctx, pass, append(args, "sdr", "elist"))
+	if err != nil {
+		return err
+	}
+	parseSDR(h, out)
+	return nil
+}
+
+func runIPMI(ctx context.Context, pass string, args []string) (string, error) {
+	cmd := exec.CommandContext(ctx, "ipmitool", args...)
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+pass)
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
+			return "", &errUnreachable{fmt.Errorf("ipmitool: %s", strings.TrimSpace(string(ee.Stderr)))}
+		}
+		return "", &errUnreachable{fmt.Errorf("ipmitool: %w", err)}
+	}
+	return string(out), nil
+}
+
+// parseSDR maps `ipmitool sdr elist` lines, "name | id | status | entity |
+// reading", to metrics: temperatures, fans and power readings as gauges, power
+// supplies and drives as status metrics. Sensors without a reading are skipped.
+func parseSDR(h *hardware, out string) {
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Split(line, "|")
+		if len(f) < 5 {
+			continue
+		}
+		for i := range f {
+			f[i] = strings.TrimSpace(f[i])
+		}
+		name, code, entity, reading := f[0], f[2], f[3], f[4]
+		entityID, _, _ := strings.Cut(entity, ".")
+		status, ok := sdrStatus(code)
+		if !ok {
+			continue
+		}
+		value, unit := sdrReading(reading)
+
+		switch {
+		case unit == "degrees C":
+			m := h.add("temperature_celsius", "Temperature (°C)", h.instance("temperature", name, entity), "gauge", value, "Thermal")
+			m["health"] = code
+			h.problem(status, fmt.Sprintf("temperature %s at %s (%s)", name, reading, code))
+		case unit == "RPM" || unit == "percent" && strings.Contains(strings.ToLower(name), "fan"):
+			m := h.add("fan_speed", "Fan Speed", h.instance("fan", name, entity), "gauge", value, "Thermal")
+			m["unit"], m["health"] = unit, code
+			h.problem(status, "fan "+name+" "+code)
+		case unit == "Watts" && entityID == entityPowerSupply:
+			h.add("psu_output_watts", "PSU Output (W)", h.instance("psu_watts", name, entity), "gauge", value, "Power")
+		case unit == "Watts":
+			h.add("power_consumed_watts", "Power Consumed (W)", h.instance("power", name, entity), "gauge", value, "Power")
+		case unit == "" && entityID == entityPowerSupply:
+			if strings.Contains(strings.ToLower(reading), "failure") || strings.Contains(strings.ToLower(reading), "lost") {
+				status = "down"
+			}
+			m := h.add("psu_status", "Power Supply", h.instance("psu", name, entity), "status", status, "Power")
+			m["state"] = reading
+			h.problem(status, "power supply "+name+": "+reading)
+		case unit == "" && (entityID == entityDisk || entityID == entityDiskBay):
+			if strings.Contains(strings.ToLower(reading), "fault") {
+				status = "down"
+			}
+			m := h.add("drive_status", "Drive", h.instance("drive", name, entity), "status", status, "Storage")
+			m["state"] = reading
+			h.problem(status, "drive "+name+": "+reading)
+		}
+	}
+}
+
+// sdrStatus maps an SDR status code; ok is false for sensors with no reading.
+func sdrStatus(code string) (string, bool) {
+	switch code {
+	case "ok":
+		return "up", true
+	case "nc", "lnc", "unc":
+		return "warning", true
+	case "cr", "nr", "lcr", "lnr", "ucr", "unr":
+		return "down", true
+	}
+	return "", false
+}
+
+// sdrReading splits an analog reading such as "45 degrees C" into its number
+// and unit; discrete readings ("Presence detected") return no unit.
+func sdrReading(reading string) (float64, string) {
+	num, unit, ok := strings.Cut(reading, " ")
+	if !ok {
+		return 0, ""
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, ""
+	}
+	return v, unit
+}
@@ -0,0 +1,193 @@
+// Package redfish reports server hardware health: collect tasks
+// "redfish.health" read power state, temperatures, fans, power supplies and
+// drives from the BMC's Redfish API, or from ipmitool for BMCs without one,
+// and roll them up into one hardware_status per host.
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+)
+
+const defaultTimeout = 60 * time.Second
+
+// redfishPlugin collects hardware health from BMCs.
+type redfishPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&redfishPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *redfishPlugin) Name() string {
+	return "Redfish"
+}
+
+// healthOptions are the per-task options of a redfish.health task:
+//
+//	{"metric": "redfish.health", "credentials": "idrac", "options": {
+//	    "method": "redfish", "ipmi_fallback": true, "timeout_s": 60}}
+//
+// The credential (type "redfish") supplies user and pass, optionally host
+// (the BMC address when it differs from the host's) and port, and its
+// insecure/ca_file settings govern certificate verification. method "ipmi"
+// uses ipmitool only; ipmi_fallback tries ipmitool when the Redfish service
+// cannot be reached.
+type healthOptions struct {
+	Method        string  `json:"method"`
+	IPMIFallback  bool    `json:"ipmi_fallback"`
+	IPMIInterface string  `json:"ipmi_interface"` // ipmitool -I; default "lanplus"
+	TimeoutS      float64 `json:"timeout_s"`
+}
+
+// OnCollect reads the BMC. A BMC that cannot be reached or refuses the
+// credentials is reported by hardware_status rather than failing the task.
+func (p *redfishPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	if action, _ := options["action"].(string); action != "health" {
+		return nil, fmt.Errorf("undefined redfish action: %s", action)
+	}
+	var opts healthOptions
+	if raw, ok := options["options"]; ok {
+		b, _ := json.Marshal(raw)
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return nil, fmt.Errorf("redfish: invalid options: %w", err)
+		}
+	}
+	creds, ok := options["credentials"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("redfish: the task needs credentials of type redfish")
+	}
+	user, _ := creds["user"].(string)
+	pass, _ := creds["pass"].(string)
+	address, _ := creds["host"].(string)
+	port, _ := creds["port"].(string)
+	insecure, _ := creds["insecure"].(bool)
+	caFile, _ := creds["ca_file"].(string)
+	if address == "" {
+		host, _ := options["host"].(map[string]interface{})
+		address, _ = host["address"].(string)
+	}
+	timeout := defaultTimeout
+	if opts.TimeoutS > 0 {
+		timeout = time.Duration(opts.TimeoutS * float64(time.Second))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	h := newHardware()
+	ipmi := func() error {
+		h.source = "ipmi"
+		return collectIPMI(ctx, h, address, port, user, pass, opts.IPMIInterface)
+	}
+	var err error
+	switch opts.Method {
+	case "", "redfish":
+		h.source = "redfish"
+		var c *client
+		c, err = newClient(address, port, user, pass, tlsOptions{Insecure: insecure, CAFile: caFile}, timeout, p.Controller.Out())
+		if err == nil {
+			err = walk(ctx, c, h)
+		}
+		var unreachable *errUnreachable
+		if err != nil && opts.IPMIFallback && errors.As(err, &unreachable) {
+			p.Controller.Printf("          !_ redfish: %s: %v, trying ipmitool\n", address, err)
+			h = newHardware()
+			err = ipmi()
+		}
+	case "ipmi":
+		err = ipmi()
+	default:
+		return nil, fmt.Errorf("redfish: unknown method %q", opts.Method)
+	}
+	return map[string]interface{}{"metrics": h.result(err)}, nil
+}
+
+// hardware accumulates the metrics of one BMC and the worst status seen.
+type hardware struct {
+	source    string
+	metrics   map[string]interface{}
+	instances map[string]bool
+	worst     string
+	problems  []string
+	errors    []string
+}
+
+func newHardware() *hardware {
+	return &hardware{metrics: make(map[string]interface{}), instances: make(map[string]bool), worst: "up"}
+}
+
+// add records a metric; name and instance must be unique together.
+func (h *hardware) add(name, label, instance, metricType string, value interface{}, category string) map[string]interface{} {
+	m := map[string]interface{}{
+		"name":     name,
+		"label":    label,
+		"value":    value,
+		"type":     metricType,
+		"category": category,
+		"instance": instance,
+	}
+	h.metrics["redfish_"+name+"_"+instance] = m
+	return m
+}
+
+// instance returns name, qualified by parent when another component of the
+// same kind already uses it (e.g. "Fan 1" in two chassis).
+func (h *hardware) instance(kind, name, parent string) string {
+	if h.instances[kind+"|"+name] {
+		name = parent + "/" + name
+	}
+	h.instances[kind+"|"+name] = true
+	return name
+}
+
+// problem folds a component status into the roll-up, remembering why it is
+// not up.
+func (h *hardware) problem(status, reason string) {
+	if status == "up" || status == "" {
+		return
+	}
+	h.problems = append(h.problems, reason)
+	if status == "down" || h.worst == "up" {
+		h.worst = status
+	}
+}
+
+// readError notes a resource that could not be read.
+func (h *hardware) readError(path string, err error) {
+	h.errors = append(h.errors, fmt.Sprintf("%s: %v", path, err))
+}
+
+// result returns the metrics with the hardware_status roll-up: down when the
+// BMC could not be read, else the worst component status.
+func (h *hardware) result(err error) map[string]interface{} {
+	status := h.add("hardware_status", "Hardware", "", "status", h.worst, "Hardware")
+	status["source"] = h.source
+	if err != nil {
+		status["value"] = "down"
+		status["reason"] = err.Error()
+		if errors.Is(err, errAuth) {
+			status["error_kind"] = "auth"
+		} else if errors.As(err, new(*errUnreachable)) {
+			status["error_kind"] = "connection"
+		}
+		return h.metrics
+	}
+	if len(h.problems) > 0 {
+		sort.Strings(h.problems)
+		status["reason"] = strings.Join(h.problems, "; ")
+	}
+	if len(h.errors) > 0 {
+		status["read_errors"] = strings.Join(h.errors, "; ")
+	}
+	return h.metrics
+}
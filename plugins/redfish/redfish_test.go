@@ -0,0 +1,435 @@
+package redfish
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	plugin "observer/base"
+)
+
+// fixtures is a Dell-like Redfish tree: one system with three drives (one
+// predicting failure, one unreadable) and two chassis, the first with a
+// failed power supply.
+var fixtures = map[string]string{
+	"/redfish/v1/Systems": `{"Members": [{"@odata.id": "/redfish/v1/Systems/System.Embedded.1"}]}`,
+	"/redfish/v1/Systems/System.Embedded.1": `{"Id": "System.Embedded.1", "Manufacturer": "Dell Inc.", "Model": "PowerEdge R740",
+		"SerialNumber": "7XYZ123", "PowerState": "On", "Status": {"State": "Enabled", "Health": "OK"},
+		"Storage": {"@odata.id": "/redfish/v1/Systems/System.Embedded.1/Storage"}}`,
+	"/redfish/v1/Systems/System.Embedded.1/Storage": `{"Members": [{"@odata.id": "/redfish/v1/Systems/System.Embedded.1/Storage/RAID.Integrated.1-1"}]}`,
+	"/redfish/v1/Systems/System.Embedded.1/Storage/RAID.Integrated.1-1": `{"Drives": [
+		{"@odata.id": "/redfish/v1/Systems/System.Embedded.1/Storage/Drives/Disk.Bay.0"},
+		{"@odata.id": "/redfish/v1/Systems/System.Embedded.1/Storage/Drives/Disk.Bay.1"},
+		{"@odata.id": "/redfish/v1/Systems/System.Embedded.1/Storage/Drives/Disk.Bay.2"}]}`,
+	"/redfish/v1/Systems/System.Embedded.1/Storage/Drives/Disk.Bay.0": `{"Id": "Disk.Bay.0", "Name": "Disk 0", "Model": "ST2000NM",
+		"MediaType": "HDD", "Protocol": "SAS", "CapacityBytes": 2000398934016, "FailurePredicted": false, "Status": {"State": "Enabled", "Health": "OK"}}`,
+	"/redfish/v1/Systems/System.Embedded.1/Storage/Drives/Disk.Bay.1": `{"Id": "Disk.Bay.1", "Name": "Disk 1", "Model": "ST2000NM",
+		"MediaType": "HDD", "Protocol": "SAS", "FailurePredicted": true, "Status": {"State": "Enabled", "Health": "OK"}}`,
+	"/redfish/v1/Chassis": `{"Members": [{"@odata.id": "/redfish/v1/Chassis/System.Embedded.1"}, {"@odata.id": "/redfish/v1/Chassis/Enclosure.1"}]}`,
+	"/redfish/v1/Chassis/System.Embedded.1": `{"Id": "System.Embedded.1", "Status": {"State": "Enabled", "Health": "Warning"},
+		"Thermal": {"@odata.id": "/redfish/v1/Chassis/System.Embedded.1/Thermal"},
+		"Power": {"@odata.id": "/redfish/v1/Chassis/System.Embedded.1/Power"}}`,
+	"/redfish/v1/Chassis/System.Embedded.1/Thermal": `{
+		"Temperatures": [
+			{"Name": "System Board Inlet Temp", "ReadingCelsius": 24, "UpperThresholdNonCritical": 42, "UpperThresholdCritical": 47, "Status": {"State": "Enabled", "Health": "OK"}},
+			{"Name": "CPU1 Temp", "ReadingCelsius": 61, "Status": {"State": "Enabled", "Health": "OK"}},
+			{"Name": "CPU2 Temp", "ReadingCelsius": null, "Status": {"State": "Absent"}}],
+		"Fans": [
+			{"Name": "Fan 1", "Reading": 5400, "ReadingUnits": "RPM", "Status": {"State": "Enabled", "Health": "OK"}},
+			{"FanName": "Fan 2", "Reading": 5520, "ReadingUnits": "RPM", "Status": {"State": "Enabled", "Health": "OK"}}]}`,
+	"/redfish/v1/Chassis/System.Embedded.1/Power": `{
+		"PowerControl": [{"Name": "System Power Control", "PowerConsumedWatts": 312}],
+		"PowerSupplies": [
+			{"Name": "PSU 1", "Model": "PWR SPLY,750W", "SerialNumber": "CN1", "PowerCapacityWatts": 750, "LastPowerOutputWatts": 312, "LineInputVoltage": 230, "Status": {"State": "Enabled", "Health": "OK"}},
+			{"Name": "PSU 2", "Model": "PWR SPLY,750W", "SerialNumber": "CN2", "PowerCapacityWatts": 750, "Status": {"State": "UnavailableOffline", "Health": "Critical"}},
+			{"Name": "PSU 3", "Status": {"State": "Absent"}}]}`,
+	"/redfish/v1/Chassis/Enclosure.1": `{"Id": "Enclosure.1", "Status": {"State": "Enabled", "Health": "OK"},
+		"Thermal": {"@odata.id": "/redfish/v1/Chassis/Enclosure.1/Thermal"}}`,
+	"/redfish/v1/Chassis/Enclosure.1/Thermal": `{"Fans": [{"Name": "Fan 1", "Reading": 40, "ReadingUnits": "Percent", "Status": {"State": "Enabled", "Health": "OK"}}]}`,
+}
+
+// bmc serves fixtures behind session or basic authentication.
+type bmc struct {
+	*httptest.Server
+	tree       map[string]string
+	noSessions bool // the session service is missing: basic auth only
+
+	mu     sync.Mutex
+	logins int
+	tokens map[string]bool
+}
+
+func newBMC(t *testing.T, tree map[string]string) *bmc {
+	b := &bmc{tree: tree, tokens: make(map[string]bool)}
+	b.Server = httptest.NewUnstartedServer(b)
+	b.Config.ErrorLog = log.New(io.Discard, "", 0) // handshakes refused by unverified clients
+	b.StartTLS()
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *bmc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.URL.Path == "/redfish/v1/SessionService/Sessions" {
+		if b.noSessions {
+			http.NotFound(w, r)
+			return
+		}
+		var creds struct{ UserName, Password string }
+		json.NewDecoder(r.Body).Decode(&creds)
+		if r.Method != http.MethodPost || creds.UserName != "root" || creds.Password != "calvin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		b.logins++
+		token := fmt.Sprintf("token-%d", b.logins)
+		b.tokens[token] = true
+		w.Header().Set("X-Auth-Token", token)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	user, pass, basic := r.BasicAuth()
+	switch {
+	case b.noSessions && basic && user == "root" && pass == "calvin":
+	case !b.noSessions && b.tokens[r.Header.Get("X-Auth-Token")]:
+	default:
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, ok := b.tree[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, body)
+}
+
+// expire drops every session, as a BMC does after its session timeout.
+func (b *bmc) expire() {
+	b.mu.Lock()
+	b.tokens = make(map[string]bool)
+	b.mu.Unlock()
+}
+
+func (b *bmc) loginCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.logins
+}
+
+// useStateDir gives the session cache a fresh state directory.
+func useStateDir(t *testing.T) string {
+	dir := t.TempDir()
+	t.Cleanup(func() {
+		plugin.LoadPaths()
+		sessions.tokens = nil
+	})
+	t.Setenv(plugin.EnvStateDir, dir)
+	plugin.LoadPaths()
+	sessions.tokens = nil
+	return dir
+}
+
+// credentials addresses srv with the given password and TLS settings.
+func credentials(srv *httptest.Server, pass string, extra map[string]interface{}) map[string]interface{} {
+	u, _ := url.Parse(srv.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	creds := map[string]interface{}{"user": "root", "pass": pass, "host": host, "port": port, "insecure": true}
+	for k, v := range extra {
+		creds[k] = v
+	}
+	return creds
+}
+
+func health(t *testing.T, creds map[string]interface{}, opts map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	res, err := (&redfishPlugin{}).OnCollect(map[string]interface{}{"action": "health", "credentials": creds, "options": opts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res["metrics"].(map[string]interface{})
+}
+
+func get(t *testing.T, metrics map[string]interface{}, name, instance string) map[string]interface{} {
+	t.Helper()
+	m, ok := metrics["redfish_"+name+"_"+instance].(map[string]interface{})
+	if !ok {
+		keys := make([]string, 0, len(metrics))
+		for k := range metrics {
+			keys = append(keys, k)
+		}
+		t.Fatalf("no %s %q in %v", name, instance, keys)
+	}
+	return m
+}
+
+func TestHealth(t *testing.T) {
+	dir := useStateDir(t)
+	b := newBMC(t, fixtures)
+	metrics := health(t, credentials(b.Server, "calvin", nil), nil)
+
+	status := get(t, metrics, "hardware_status", "")
+	wantReason := "chassis System.Embedded.1 health Warning; drive Disk 1 failure predicted; power supply PSU 2 health Critical"
+	if status["value"] != "down" || status["reason"] != wantReason || status["source"] != "redfish" {
+		t.Errorf("hardware_status = %v", status)
+	}
+	if !strings.Contains(status["read_errors"].(string), "Disk.Bay.2: HTTP 404") {
+		t.Errorf("read_errors = %v", status["read_errors"])
+	}
+
+	for _, tc := range []struct {
+		name, instance string
+		value          interface{}
+	}{
+		{"power_state", "System.Embedded.1", "up"},
+		{"system_health", "System.Embedded.1", "up"},
+		{"drive_status", "Disk 0", "up"},
+		{"drive_status", "Disk 1", "warning"},
+		{"chassis_health", "System.Embedded.1", "warning"},
+		{"chassis_health", "Enclosure.1", "up"},
+		{"temperature_celsius", "System Board Inlet Temp", 24.0},
+		{"temperature_celsius", "CPU1 Temp", 61.0},
+		{"fan_speed", "Fan 1", 5400.0},
+		{"fan_speed", "Fan 2", 5520.0},
+		{"fan_speed", "Enclosure.1/Fan 1", 40.0},
+		{"power_consumed_watts", "System.Embedded.1", 312.0},
+		{"psu_status", "PSU 1", "up"},
+		{"psu_status", "PSU 2", "down"},
+		{"psu_output_watts", "PSU 1", 312.0},
+	} {
+		if m := get(t, metrics, tc.name, tc.instance); m["value"] != tc.value {
+			t.Errorf("%s %s = %v, want %v", tc.name, tc.instance, m["value"], tc.value)
+		}
+	}
+	for _, absent := range []string{"temperature_celsius_CPU2 Temp", "psu_status_PSU 3", "psu_output_watts_PSU 2", "drive_status_Disk.Bay.2"} {
+		if _, ok := metrics["redfish_"+absent]; ok {
+			t.Errorf("%s reported", absent)
+		}
+	}
+	if m := get(t, metrics, "system_health", "System.Embedded.1"); m["model"] != "PowerEdge R740" || m["serial"] != "7XYZ123" {
+		t.Errorf("system_health = %v", m)
+	}
+	if m := get(t, metrics, "drive_status", "Disk 0"); m["capacity_bytes"] != int64(2000398934016) || m["media_type"] != "HDD" {
+		t.Errorf("drive_status = %v", m)
+	}
+	if m := get(t, metrics, "temperature_celsius", "System Board Inlet Temp"); m["upper_warning"] != 42.0 || m["upper_critical"] != 47.0 {
+		t.Errorf("temperature = %v", m)
+	}
+	if m := get(t, metrics, "psu_status", "PSU 1"); m["capacity_watts"] != 750.0 || m["input_volts"] != 230.0 {
+		t.Errorf("psu_status = %v", m)
+	}
+
+	// The session is cached in the state directory and reused by later
+	// collections, including a fresh process.
+	sessions.tokens = nil
+	health(t, credentials(b.Server, "calvin", nil), nil)
+	if n := b.loginCount(); n != 1 {
+		t.Errorf("%d logins, want 1", n)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, sessionStateFile))
+	if err != nil || !strings.Contains(string(data), `"token-1"`) {
+		t.Errorf("session state = %s (%v)", data, err)
+	}
+}
+
+func TestExpiredSessionLogsInAgain(t *testing.T) {
+	useStateDir(t)
+	b := newBMC(t, fixtures)
+	creds := credentials(b.Server, "calvin", nil)
+	health(t, creds, nil)
+	b.expire()
+	metrics := health(t, creds, nil)
+	if get(t, metrics, "hardware_status", "")["error_kind"] != nil || b.loginCount() != 2 {
+		t.Errorf("after expiry: %d logins, status %v", b.loginCount(), get(t, metrics, "hardware_status", ""))
+	}
+	if tok := sessionToken(b.Listener.Addr().String() + "|root"); tok != "token-2" {
+		t.Errorf("cached token = %q", tok)
+	}
+}
+
+func TestBasicAuthWithoutSessionService(t *testing.T) {
+	useStateDir(t)
+	b := newBMC(t, fixtures)
+	b.noSessions = true
+	metrics := health(t, credentials(b.Server, "calvin", nil), nil)
+	if v := get(t, metrics, "psu_status", "PSU 2")["value"]; v != "down" {
+		t.Errorf("psu_status PSU 2 = %v", v)
+	}
+	if _, err := os.Stat(plugin.StateFile(sessionStateFile)); err == nil {
+		t.Error("a session was cached for a basic-auth service")
+	}
+}
+
+func TestAuthFailure(t *testing.T) {
+	useStateDir(t)
+	b := newBMC(t, fixtures)
+	metrics := health(t, credentials(b.Server, "wrong", nil), map[string]interface{}{"ipmi_fallback": true})
+	status := get(t, metrics, "hardware_status", "")
+	if status["value"] != "down" || status["error_kind"] != "auth" || status["source"] != "redfish" || len(metrics) != 1 {
+		t.Errorf("metrics = %v", metrics)
+	}
+}
+
+func TestTLSVerification(t *testing.T) {
+	useStateDir(t)
+	b := newBMC(t, fixtures)
+	status := get(t, health(t, credentials(b.Server, "calvin", map[string]interface{}{"insecure": false}), nil), "hardware_status", "")
+	if status["error_kind"] != "connection" || !strings.Contains(status["reason"].(string), "certificate") {
+		t.Errorf("unverified certificate: %v", status)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "bmc.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b.Certificate().Raw}), 0644)
+	status = get(t, health(t, credentials(b.Server, "calvin", map[string]interface{}{"insecure": false, "ca_file": caFile}), nil), "hardware_status", "")
+	if status["error_kind"] != nil {
+		t.Errorf("with ca_file: %v", status)
+	}
+}
+
+// fakeIPMITool puts an ipmitool on PATH that prints canned output and logs
+// its arguments and password to callLog.
+func fakeIPMITool(t *testing.T, sdr string) (callLog string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	dir := t.TempDir()
+	callLog = filepath.Join(dir, "calls")
+	os.WriteFile(filepath.Join(dir, "sdr.txt"), []byte(sdr), 0644)
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$* pass=$IPMI_PASSWORD" >> %q
+case "$*" in
+*"chassis power status") echo "Chassis Power is on" ;;
+*"sdr elist") cat %q ;;
+*) echo "unexpected: $*" >&2; exit 1 ;;
+esac
+`, callLog, filepath.Join(dir, "sdr.txt"))
+	os.WriteFile(filepath.Join(dir, "ipmitool"), []byte(script), 0755)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return callLog
+}
+
+const sdrFixture = `Inlet Temp       | 04h | ok  |  7.1 | 23 degrees C
+Exhaust Temp     | 01h | ok  |  7.1 | 38 degrees C
+Temp             | 0Eh | ok  |  3.1 | 55 degrees C
+Temp             | 0Fh | ok  |  3.2 | 57 degrees C
+Fan1             | 30h | ok  |  7.1 | 5400 RPM
+Fan2             | 31h | cr  |  7.1 | 0 RPM
+Pwr Consumption  | 77h | ok  |  7.1 | 168 Watts
+PS1 Status       | 62h | ok  | 10.1 | Presence detected
+PS2 Status       | 63h | ok  | 10.2 | Presence detected, Power Supply AC lost
+PS1 Output       | 64h | ok  | 10.1 | 150 Watts
+Drive 0          | 80h | ok  | 26.1 | Drive Present
+Drive 1          | 81h | ok  | 26.2 | Drive Present, Drive Fault
+Intrusion        | 73h | ns  |  7.1 | No Reading
+`
+
+func TestIPMIFallback(t *testing.T) {
+	useStateDir(t)
+	callLog := fakeIPMITool(t, sdrFixture)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	creds := map[string]interface{}{"user": "root", "pass": "calvin", "host": "127.0.0.1", "port": port}
+	metrics := health(t, creds, map[string]interface{}{"ipmi_fallback": true, "ipmi_interface": "lan"})
+	status := get(t, metrics, "hardware_status", "")
+	want := "drive Drive 1: Drive Present, Drive Fault; fan Fan2 cr; power supply PS2 Status: Presence detected, Power Supply AC lost"
+	if status["value"] != "down" || status["source"] != "ipmi" || status["reason"] != want {
+		t.Errorf("hardware_status = %v", status)
+	}
+	for _, tc := range []struct {
+		name, instance string
+		value          interface{}
+	}{
+		{"power_state", "chassis", "up"},
+		{"temperature_celsius", "Inlet Temp", 23.0},
+		{"temperature_celsius", "Temp", 55.0},
+		{"temperature_celsius", "3.2/Temp", 57.0},
+		{"fan_speed", "Fan1", 5400.0},
+		{"fan_speed", "Fan2", 0.0},
+		{"power_consumed_watts", "Pwr Consumption", 168.0},
+		{"psu_status", "PS1 Status", "up"},
+		{"psu_status", "PS2 Status", "down"},
+		{"psu_output_watts", "PS1 Output", 150.0},
+		{"drive_status", "Drive 0", "up"},
+		{"drive_status", "Drive 1", "down"},
+	} {
+		if m := get(t, metrics, tc.name, tc.instance); m["value"] != tc.value {
+			t.Errorf("%s %s = %v, want %v", tc.name, tc.instance, m["value"], tc.value)
+		}
+	}
+	if _, ok := metrics["redfish_temperature_celsius_Intrusion"]; ok {
+		t.Error("a sensor without a reading was reported")
+	}
+
+	calls, _ := os.ReadFile(callLog)
+	lines := strings.Split(strings.TrimSpace(string(calls)), "\n")
+	wantArgs := "-I lan -H 127.0.0.1 -U root -E -p " + port
+	if len(lines) != 2 || lines[0] != wantArgs+" chassis power status pass=calvin" || lines[1] != wantArgs+" sdr elist pass=calvin" {
+		t.Errorf("ipmitool calls:\n%s", calls)
+	}
+}
+
+func TestIPMIMethodFailure(t *testing.T) {
+	useStateDir(t)
+	fakeIPMITool(t, "")
+	// An empty sensor repository leaves only the power state.
+	metrics := health(t, map[string]interface{}{"user": "root", "pass": "x", "host": "192.0.2.1"}, map[string]interface{}{"method": "ipmi"})
+	if status := get(t, metrics, "hardware_status", ""); status["value"] != "up" || status["source"] != "ipmi" || len(metrics) != 2 {
+		t.Errorf("metrics = %v", metrics)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	status := get(t, health(t, map[string]interface{}{"user": "root", "pass": "x", "host": "192.0.2.1"}, map[string]interface{}{"method": "ipmi"}), "hardware_status", "")
+	if status["value"] != "down" || status["reason"] != "ipmitool is not installed" {
+		t.Errorf("without ipmitool: %v", status)
+	}
+}
+
+func TestSDRHelpers(t *testing.T) {
+	for code, want := range map[string]string{"ok": "up", "unc": "warning", "lnc": "warning", "ucr": "down", "nr": "down"} {
+		if got, ok := sdrStatus(code); !ok || got != want {
+			t.Errorf("sdrStatus(%s) = %s", code, got)
+		}
+	}
+	if _, ok := sdrStatus("ns"); ok {
+		t.Error("ns has a status")
+	}
+	if v, unit := sdrReading("12.5 Volts"); v != 12.5 || unit != "Volts" {
+		t.Errorf("sdrReading = %v %q", v, unit)
+	}
+	if _, unit := sdrReading("Presence detected"); unit != "" {
+		t.Errorf("discrete reading unit = %q", unit)
+	}
+}
+
+func TestInvalidTasks(t *testing.T) {
+	p := &redfishPlugin{}
+	for name, options := range map[string]map[string]interface{}{
+		"unknown action": {"action": "inventory", "credentials": map[string]interface{}{}},
+		"no credentials": {"action": "health"},
+		"bad options":    {"action": "health", "credentials": map[string]interface{}{}, "options": map[string]interface{}{"timeout_s": "slow"}},
+		"unknown method": {"action": "health", "credentials": map[string]interface{}{}, "options": map[string]interface{}{"method": "snmp"}},
+	} {
+		if _, err := p.OnCollect(options); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
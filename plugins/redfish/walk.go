@@ -0,0 +1,296 @@
+package redfish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The subset of the Redfish schema the plugin reads. Resources link to each
+// other by @odata.id; collections list their members the same way.
+type link struct {
+	ID string `json:"@odata.id"`
+}
+
+type memberList struct {
+	Members []link `json:"Members"`
+}
+
+type resourceStatus struct {
+	State  string `json:"State"`
+	Health string `json:"Health"`
+}
+
+type system struct {
+	ID           string         `json:"Id"`
+	Name         string         `json:"Name"`
+	Manufacturer string         `json:"Manufacturer"`
+	Model        string         `json:"Model"`
+	SerialNumber string         `json:"SerialNumber"`
+	PowerState   string         `json:"PowerState"`
+	Status       resourceStatus `json:"Status"`
+	Storage      link           `json:"Storage"`
+}
+
+type chassis struct {
+	ID      string         `json:"Id"`
+	Name    string         `json:"Name"`
+	Status  resourceStatus `json:"Status"`
+	Thermal link           `json:"Thermal"`
+	Power   link           `json:"Power"`
+}
+
+type thermal struct {
+	Temperatures []struct {
+		Name                      string         `json:"Name"`
+		ReadingCelsius            *float64       `json:"ReadingCelsius"`
+		UpperThresholdNonCritical *float64       `json:"UpperThresholdNonCritical"`
+		UpperThresholdCritical    *float64       `json:"UpperThresholdCritical"`
+		Status                    resourceStatus `json:"Status"`
+	} `json:"Temperatures"`
+	Fans []struct {
+		Name         string         `json:"Name"`
+		FanName      string         `json:"FanName"` // before schema 1.1
+		Reading      *float64       `json:"Reading"`
+		ReadingUnits string         `json:"ReadingUnits"`
+		Status       resourceStatus `json:"Status"`
+	} `json:"Fans"`
+}
+
+type power struct {
+	PowerControl []struct {
+		Name               string   `json:"Name"`
+		PowerConsumedWatts *float64 `json:"PowerConsumedWatts"`
+	} `json:"PowerControl"`
+	PowerSupplies []struct {
+		Name                 string         `json:"Name"`
+		Model                string         `json:"Model"`
+		SerialNumber         string         `json:"SerialNumber"`
+		PowerCapacityWatts   *float64       `json:"PowerCapacityWatts"`
+		LastPowerOutputWatts *float64       `json:"LastPowerOutputWatts"`
+		LineInputVoltage     *float64       `json:"LineInputVoltage"`
+		Status               resourceStatus `json:"Status"`
+	} `json:"PowerSupplies"`
+}
+
+type storage struct {
+	Drives []link `json:"Drives"`
+}
+
+type drive struct {
+	ID               string         `json:"Id"`
+	Name             string         `json:"Name"`
+	Model            string         `json:"Model"`
+	SerialNumber     string         `json:"SerialNumber"`
+	MediaType        string         `json:"MediaType"`
+	Protocol         string         `json:"Protocol"`
+	CapacityBytes    *int64         `json:"CapacityBytes"`
+	FailurePredicted bool           `json:"FailurePredicted"`
+	Status           resourceStatus `json:"Status"`
+}
+
+// healthStatus maps a Redfish Status to a metric status; ok is false for
+// components that are absent or report no health.
+func healthStatus(s resourceStatus) (string, bool) {
+	if s.State == "Absent" {
+		return "", false
+	}
+	switch s.Health {
+	case "OK":
+		return "up", true
+	case "Warning":
+		return "warning", true
+	case "Critical":
+		return "down", true
+	}
+	return "", false
+}
+
+// walk reads the systems and chassis of the service into h. A sub-resource
+// that cannot be read is noted on the roll-up without failing the walk; only
+// a failure to read the service's collections is returned.
+func walk(ctx context.Context, c *client, h *hardware) error {
+	var systems memberList
+	if err := c.get(ctx, "/redfish/v1/Systems", &systems); err != nil {
+		return err
+	}
+	for _, m := range systems.Members {
+		var s system
+		if err := c.get(ctx, m.ID, &s); err != nil {
+			h.readError(m.ID, err)
+			continue
+		}
+		walkSystem(ctx, c, h, s)
+	}
+
+	var chassisList memberList
+	if err := c.get(ctx, "/redfish/v1/Chassis", &chassisList); err != nil {
+		if isAuth(err) {
+			return err
+		}
+		h.readError("/redfish/v1/Chassis", err)
+		return nil
+	}
+	for _, m := range chassisList.Members {
+		var ch chassis
+		if err := c.get(ctx, m.ID, &ch); err != nil {
+			h.readError(m.ID, err)
+			continue
+		}
+		walkChassis(ctx, c, h, ch)
+	}
+	return nil
+}
+
+func walkSystem(ctx context.Context, c *client, h *hardware, s system) {
+	id := firstNonEmpty(s.ID, s.Name)
+	state := "down"
+	if strings.EqualFold(s.PowerState, "On") {
+		state = "up"
+	}
+	ps := h.add("power_state", "Power State", id, "status", state, "System")
+	ps["power_state"] = s.PowerState
+	if state != "up" {
+		h.problem("down", "system "+id+" is powered "+strings.ToLower(s.PowerState))
+	}
+	if v, ok := healthStatus(s.Status); ok {
+		m := h.add("system_health", "System Health", id, "status", v, "System")
+		m["manufacturer"], m["model"], m["serial"] = s.Manufacturer, s.Model, s.SerialNumber
+		h.problem(v, "system "+id+" health "+s.Status.Health)
+	}
+
+	if s.Storage.ID == "" {
+		return
+	}
+	var controllers memberList
+	if err := c.get(ctx, s.Storage.ID, &controllers); err != nil {
+		h.readError(s.Storage.ID, err)
+		return
+	}
+	for _, m := range controllers.Members {
+		var st storage
+		if err := c.get(ctx, m.ID, &st); err != nil {
+			h.readError(m.ID, err)
+			continue
+		}
+		for _, dl := range st.Drives {
+			var d drive
+			if err := c.get(ctx, dl.ID, &d); err != nil {
+				h.readError(dl.ID, err)
+				continue
+			}
+			v, ok := healthStatus(d.Status)
+			if !ok {
+				continue
+			}
+			if d.FailurePredicted && v == "up" {
+				v = "warning"
+			}
+			name := h.instance("drive", firstNonEmpty(d.Name, d.ID), id)
+			dm := h.add("drive_status", "Drive", name, "status", v, "Storage")
+			dm["model"], dm["serial"], dm["media_type"], dm["protocol"] = d.Model, d.SerialNumber, d.MediaType, d.Protocol
+			dm["failure_predicted"] = d.FailurePredicted
+			if d.CapacityBytes != nil {
+				dm["capacity_bytes"] = *d.CapacityBytes
+			}
+			reason := "drive " + name + " health " + d.Status.Health
+			if d.FailurePredicted {
+				reason = "drive " + name + " failure predicted"
+			}
+			h.problem(v, reason)
+		}
+	}
+}
+
+func walkChassis(ctx context.Context, c *client, h *hardware, ch chassis) {
+	id := firstNonEmpty(ch.ID, ch.Name)
+	if v, ok := healthStatus(ch.Status); ok {
+		h.add("chassis_health", "Chassis Health", id, "status", v, "Chassis")
+		h.problem(v, "chassis "+id+" health "+ch.Status.Health)
+	}
+
+	if ch.Thermal.ID != "" {
+		var t thermal
+		if err := c.get(ctx, ch.Thermal.ID, &t); err != nil {
+			h.readError(ch.Thermal.ID, err)
+		}
+		for _, s := range t.Temperatures {
+			if s.ReadingCelsius == nil || s.Status.State == "Absent" {
+				continue
+			}
+			name := h.instance("temperature", s.Name, id)
+			m := h.add("temperature_celsius", "Temperature (°C)", name, "gauge", *s.ReadingCelsius, "Thermal")
+			m["health"] = s.Status.Health
+			if s.UpperThresholdNonCritical != nil {
+				m["upper_warning"] = *s.UpperThresholdNonCritical
+			}
+			if s.UpperThresholdCritical != nil {
+				m["upper_critical"] = *s.UpperThresholdCritical
+			}
+			if v, ok := healthStatus(s.Status); ok {
+				h.problem(v, fmt.Sprintf("temperature %s at %g°C (%s)", name, *s.ReadingCelsius, s.Status.Health))
+			}
+		}
+		for _, f := range t.Fans {
+			if f.Reading == nil || f.Status.State == "Absent" {
+				continue
+			}
+			name := h.instance("fan", firstNonEmpty(f.Name, f.FanName), id)
+			m := h.add("fan_speed", "Fan Speed", name, "gauge", *f.Reading, "Thermal")
+			m["unit"], m["health"] = f.ReadingUnits, f.Status.Health
+			if v, ok := healthStatus(f.Status); ok {
+				h.problem(v, "fan "+name+" health "+f.Status.Health)
+			}
+		}
+	}
+
+	if ch.Power.ID != "" {
+		var p power
+		if err := c.get(ctx, ch.Power.ID, &p); err != nil {
+			h.readError(ch.Power.ID, err)
+		}
+		for i, pc := range p.PowerControl {
+			if pc.PowerConsumedWatts == nil {
+				continue
+			}
+			instance := id
+			if i > 0 {
+				instance = h.instance("power", firstNonEmpty(pc.Name, fmt.Sprint(i)), id)
+			}
+			h.add("power_consumed_watts", "Power Consumed (W)", instance, "gauge", *pc.PowerConsumedWatts, "Power")
+		}
+		for _, ps := range p.PowerSupplies {
+			v, ok := healthStatus(ps.Status)
+			if !ok {
+				continue
+			}
+			name := h.instance("psu", ps.Name, id)
+			m := h.add("psu_status", "Power Supply", name, "status", v, "Power")
+			m["model"], m["serial"] = ps.Model, ps.SerialNumber
+			if ps.PowerCapacityWatts != nil {
+				m["capacity_watts"] = *ps.PowerCapacityWatts
+			}
+			if ps.LineInputVoltage != nil {
+				m["input_volts"] = *ps.LineInputVoltage
+			}
+			if ps.LastPowerOutputWatts != nil {
+				h.add("psu_output_watts", "PSU Output (W)", name, "gauge", *ps.LastPowerOutputWatts, "Power")
+			}
+			h.problem(v, "power supply "+name+" health "+ps.Status.Health)
+		}
+	}
+}
+
+func isAuth(err error) bool {
+	return errors.Is(err, errAuth)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
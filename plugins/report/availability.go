@@ -0,0 +1,152 @@
+package report
+
+import (
+	"sort"
+	"time"
+
+	"observer/store"
+)
+
+// state is a status sample's meaning for availability. Higher is worse, so a
+// host's state at an instant is the highest state among its series;
+// maintenance outranks them all.
+type state int
+
+const (
+	stateUnknown state = iota
+	stateUp
+	stateDegraded
+	stateDown
+	stateMaintenance
+)
+
+// sampleState maps a status sample: 1 is up, 0.5 degraded (warning) and 0
+// down. Values with no numeric meaning are unknown. A sample collected during
+// a maintenance window, flagged in extra "maintenance", is maintenance
+// whatever its value.
+func sampleState(r store.MetricRecord) state {
+	if window, _ := r.Extra["maintenance"].(string); window != "" {
+		return stateMaintenance
+	}
+	v := r.ValueNum
+	if v == nil {
+		v = store.ParseValueNum(r.Value)
+	}
+	switch {
+	case v == nil:
+		return stateUnknown
+	case *v >= 1:
+		return stateUp
+	case *v > 0:
+		return stateDegraded
+	}
+	return stateDown
+}
+
+// span is a stretch of time in one state.
+type span struct {
+	from, to time.Time
+	state    state
+}
+
+// seriesSpans turns one series' samples, oldest first, into spans within
+// [from, to). A sample holds until the next one, but for no longer than gap:
+// after that the series was not collected and its state is unknown.
+func seriesSpans(samples []store.MetricRecord, from, to time.Time, gap time.Duration) []span {
+	var spans []span
+	for i, r := range samples {
+		start := r.CollectedAt
+		end := start.Add(gap)
+		if i+1 < len(samples) && samples[i+1].CollectedAt.Before(end) {
+			end = samples[i+1].CollectedAt
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if !end.After(start) {
+			continue
+		}
+		spans = append(spans, span{from: start, to: end, state: sampleState(r)})
+	}
+	return spans
+}
+
+// tally accumulates time per state and counts failures, a failure being a
+// change to down from a known state other than down. Maintenance time is
+// kept apart and does not break a run of one state, so a host down before
+// and after a window fails once.
+type tally struct {
+	up, degraded, down, unknown time.Duration
+	maintenance                 time.Duration
+	failures                    int
+	last                        state
+}
+
+func (t *tally) add(s state, d time.Duration) {
+	switch s {
+	case stateMaintenance:
+		t.maintenance += d
+		return
+	case stateUp:
+		t.up += d
+	case stateDegraded:
+		t.degraded += d
+	case stateDown:
+		t.down += d
+	default:
+		t.unknown += d
+		return
+	}
+	if s == stateDown && t.last != stateDown && t.last != stateUnknown {
+		t.failures++
+	}
+	t.last = s
+}
+
+// hostTimeline combines the spans of a host's series: at each instant the
+// host is in the worst state any of its series is in, and unknown when no
+// series has a known state. Time not covered by any span is unknown.
+func hostTimeline(series [][]span, from, to time.Time) *tally {
+	bounds := []time.Time{from, to}
+	for _, spans := range series {
+		for _, s := range spans {
+			bounds = append(bounds, s.from, s.to)
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Before(bounds[j]) })
+
+	t := &tally{}
+	next := make([]int, len(series)) // per series, the first span not yet passed
+	for i := 0; i+1 < len(bounds); i++ {
+		a, b := bounds[i], bounds[i+1]
+		if !b.After(a) {
+			continue
+		}
+		worst := stateUnknown
+		for k, spans := range series {
+			for next[k] < len(spans) && !spans[next[k]].to.After(a) {
+				next[k]++
+			}
+			if next[k] < len(spans) && !spans[next[k]].from.After(a) && spans[next[k]].state > worst {
+				worst = spans[next[k]].state
+			}
+		}
+		t.add(worst, b.Sub(a))
+	}
+	return t
+}
+
+// seriesTally sums one series' spans; time outside them is unknown.
+func seriesTally(spans []span, from, to time.Time) *tally {
+	t := &tally{}
+	covered := time.Duration(0)
+	for _, s := range spans {
+		t.add(s.state, s.to.Sub(s.from))
+		covered += s.to.Sub(s.from)
+	}
+	t.unknown += to.Sub(from) - covered
+	return t
+}
@@ -0,0 +1,183 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"observer/store"
+)
+
+var start = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+// history is a series sampled every interval from start+offset; an empty
+// value is a cycle in which nothing was collected, "maint" a sample taken
+// during a maintenance window.
+func history(host, name string, offset, interval time.Duration, values ...string) []store.MetricRecord {
+	var out []store.MetricRecord
+	for i, v := range values {
+		if v == "" {
+			continue
+		}
+		r := store.MetricRecord{
+			HostKey: host, HostName: host, Plugin: "ping", Name: name, MetricType: "status",
+			Value: v, CollectedAt: start.Add(offset + time.Duration(i)*interval),
+		}
+		if v == "maint" {
+			r.Value = "down"
+			r.Extra = map[string]interface{}{"maintenance": "patching"}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func minutes(d time.Duration) float64 { return d.Minutes() }
+
+func TestSampleState(t *testing.T) {
+	for value, want := range map[string]state{
+		"up": stateUp, "1": stateUp, "ok": stateUp,
+		"warning": stateDegraded, "0.5": stateDegraded,
+		"down": stateDown, "0": stateDown, "critical": stateDown,
+		"n/a": stateUnknown, "": stateUnknown,
+	} {
+		if got := sampleState(store.MetricRecord{Value: value}); got != want {
+			t.Errorf("%q = %d, want %d", value, got, want)
+		}
+	}
+	r := store.MetricRecord{Value: "up", Extra: map[string]interface{}{"maintenance": "patching"}}
+	if sampleState(r) != stateMaintenance {
+		t.Error("maintenance sample not maintenance")
+	}
+}
+
+func TestFlaps(t *testing.T) {
+	end := start.Add(time.Hour)
+	samples := history("sw1", "status", 0, 5*time.Minute,
+		"up", "up", "down", "up", "down", "down", "up", "up", "up", "up", "up", "up")
+	got := seriesTally(seriesSpans(samples, start, end, 15*time.Minute), start, end)
+
+	if minutes(got.up) != 45 || minutes(got.down) != 15 || got.unknown != 0 {
+		t.Errorf("up %v down %v unknown %v", got.up, got.down, got.unknown)
+	}
+	if got.failures != 2 {
+		t.Errorf("failures = %d, want 2", got.failures)
+	}
+	if a := availability(got); a == nil || *a != 75 {
+		t.Errorf("availability = %v", a)
+	}
+}
+
+func TestGapsAreUnknown(t *testing.T) {
+	end := start.Add(time.Hour)
+	for _, tt := range []struct {
+		name              string
+		values            []string
+		up, down, unknown float64
+		failures          int
+		availability      float64
+	}{
+		{
+			// The agent stops after 10m: the last sample holds for the
+			// 15m gap, the rest is unknown until collection resumes.
+			name:   "agent down",
+			values: []string{"up", "up", "up", "", "", "", "", "", "up", "up", "up", "up"},
+			up:     45, unknown: 15, availability: 100,
+		},
+		{
+			name:   "down before the gap",
+			values: []string{"up", "up", "down", "", "", "", "", "", "", "", "", "up"},
+			up:     15, down: 15, unknown: 30, failures: 1, availability: 50,
+		},
+		{
+			// Down on both sides of a gap is one failure.
+			name:   "down across a gap",
+			values: []string{"up", "down", "", "", "", "", "down", "up", "up", "up", "up", "up"},
+			up:     30, down: 20, unknown: 10, failures: 1, availability: 60,
+		},
+		{
+			name:   "late start",
+			values: []string{"", "", "", "", "", "", "up", "up", "up", "up", "up", "up"},
+			up:     30, unknown: 30, availability: 100,
+		},
+	} {
+		got := seriesTally(seriesSpans(history("sw1", "status", 0, 5*time.Minute, tt.values...), start, end, 15*time.Minute), start, end)
+		if minutes(got.up) != tt.up || minutes(got.down) != tt.down || minutes(got.unknown) != tt.unknown {
+			t.Errorf("%s: up %v down %v unknown %v", tt.name, got.up, got.down, got.unknown)
+		}
+		if got.failures != tt.failures {
+			t.Errorf("%s: failures = %d, want %d", tt.name, got.failures, tt.failures)
+		}
+		if a := availability(got); a == nil || *a != tt.availability {
+			t.Errorf("%s: availability = %v, want %v", tt.name, a, tt.availability)
+		}
+	}
+
+	if a := availability(seriesTally(nil, start, end)); a != nil {
+		t.Errorf("availability without samples = %v", *a)
+	}
+}
+
+func TestSamplesAtTheEdges(t *testing.T) {
+	end := start.Add(30 * time.Minute)
+	// Down 10m before the period holds for its first 5m; the sample at its
+	// end is outside it.
+	samples := append(history("sw1", "status", -10*time.Minute, 15*time.Minute, "down", "up", "up"),
+		history("sw1", "status", 30*time.Minute, 0, "down")...)
+	spans := seriesSpans(samples, start, end, 15*time.Minute)
+	if len(spans) != 3 || !spans[0].from.Equal(start) || !spans[0].to.Equal(start.Add(5*time.Minute)) ||
+		!spans[2].to.Equal(end) {
+		t.Fatalf("spans = %v", spans)
+	}
+	got := seriesTally(spans, start, end)
+	if minutes(got.down) != 5 || minutes(got.up) != 25 || got.failures != 0 {
+		t.Errorf("up %v down %v failures %d", got.up, got.down, got.failures)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	end := start.Add(time.Hour)
+	samples := history("sw1", "status", 0, 10*time.Minute, "up", "down", "maint", "maint", "down", "up")
+	got := seriesTally(seriesSpans(samples, start, end, 15*time.Minute), start, end)
+	if minutes(got.maintenance) != 20 || minutes(got.down) != 20 || minutes(got.up) != 20 {
+		t.Errorf("up %v down %v maintenance %v", got.up, got.down, got.maintenance)
+	}
+	// Down on both sides of the window is one failure.
+	if got.failures != 1 {
+		t.Errorf("failures = %d, want 1", got.failures)
+	}
+	if a := availability(got); a == nil || *a != 50 {
+		t.Errorf("availability = %v", a)
+	}
+}
+
+func TestHostTimeline(t *testing.T) {
+	end := start.Add(time.Hour)
+	gap := 15 * time.Minute
+	series := [][]span{
+		// ping answers throughout.
+		seriesSpans(history("sw1", "status", 0, 10*time.Minute, "up", "up", "up", "up", "up", "up"), start, end, gap),
+		// The HTTP check degrades, goes down and is not collected after
+		// 20m; its last sample holds for the gap.
+		seriesSpans(history("sw1", "http", 0, 10*time.Minute, "up", "warning", "down", ""), start, end, gap),
+		// A third series that was never collected does not hide the others.
+		nil,
+	}
+	got := hostTimeline(series, start, end)
+	if minutes(got.up) != 35 || minutes(got.degraded) != 10 || minutes(got.down) != 15 || got.unknown != 0 {
+		t.Errorf("up %v degraded %v down %v unknown %v", got.up, got.degraded, got.down, got.unknown)
+	}
+	if got.failures != 1 {
+		t.Errorf("failures = %d, want 1", got.failures)
+	}
+
+	// With no series answering the host is unknown, not up.
+	got = hostTimeline([][]span{
+		seriesSpans(history("sw1", "status", 0, 0, "up"), start, end, gap),
+	}, start, end)
+	if minutes(got.up) != 15 || minutes(got.unknown) != 45 {
+		t.Errorf("up %v unknown %v", got.up, got.unknown)
+	}
+	if got := hostTimeline(nil, start, end); got.unknown != time.Hour {
+		t.Errorf("no series: unknown %v", got.unknown)
+	}
+}
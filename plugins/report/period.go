@@ -0,0 +1,103 @@
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayouts are the accepted forms of the from= and to= arguments, read in
+// local time unless they carry a zone.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("report: invalid date %q (use YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339)", s)
+}
+
+// namedPeriod returns the [from, to) range of a named period relative to now:
+// today, yesterday, this-week, last-week, this-month, last-month, or
+// last-<N>d / last-<N>h for the N days or hours up to now. Weeks start on
+// Monday.
+func namedPeriod(name string, now time.Time) (time.Time, time.Time, error) {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	week := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	switch name {
+	case "today":
+		return day, now, nil
+	case "yesterday":
+		return day.AddDate(0, 0, -1), day, nil
+	case "this-week":
+		return week, now, nil
+	case "last-week":
+		return week.AddDate(0, 0, -7), week, nil
+	case "this-month":
+		return month, now, nil
+	case "last-month":
+		return month.AddDate(0, -1, 0), month, nil
+	}
+	if rest, ok := strings.CutPrefix(name, "last-"); ok && len(rest) > 1 {
+		n, err := strconv.Atoi(rest[:len(rest)-1])
+		if err == nil && n > 0 {
+			switch rest[len(rest)-1] {
+			case 'd':
+				return now.AddDate(0, 0, -n), now, nil
+			case 'h':
+				return now.Add(-time.Duration(n) * time.Hour), now, nil
+			}
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("report: unknown period %q", name)
+}
+
+// schedulePeriods maps a report.schedule to the period reported on each run.
+var schedulePeriods = map[string]string{
+	"monthly": "last-month",
+	"weekly":  "last-week",
+	"daily":   "yesterday",
+}
+
+// runDelay is how long after a period ends its scheduled report is written,
+// so the collection cycle running at midnight is included.
+const runDelay = 10 * time.Minute
+
+// nextRun returns the first time after now at which a report for schedule is
+// due: runDelay after the start of the next day, week or month.
+func nextRun(schedule string, now time.Time) time.Time {
+	period := schedulePeriods[schedule]
+	_, end, _ := namedPeriod(period, now)
+	if due := end.Add(runDelay); due.After(now) {
+		return due
+	}
+	switch schedule {
+	case "daily":
+		return end.AddDate(0, 0, 1).Add(runDelay)
+	case "weekly":
+		return end.AddDate(0, 0, 7).Add(runDelay)
+	}
+	return end.AddDate(0, 1, 0).Add(runDelay)
+}
+
+// periodLabel names a report's files after its range.
+func periodLabel(from, to time.Time) string {
+	layout := "2006-01-02"
+	if !isMidnight(from) || !isMidnight(to) {
+		layout = "2006-01-02T1504"
+	}
+	return from.Format(layout) + "_" + to.Format(layout)
+}
+
+func isMidnight(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+}
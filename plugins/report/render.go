@@ -0,0 +1,164 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	plugin "observer/base"
+)
+
+// writeReport writes rep as <base>.json, <base>.csv and <base>.html in dir,
+// replacing an earlier report of the same period.
+func writeReport(rep availabilityReport, dir, base string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("report: %w", err)
+	}
+	var files []string
+	for _, f := range []struct {
+		ext    string
+		render func(availabilityReport) ([]byte, error)
+	}{
+		{".json", renderJSON},
+		{".csv", renderCSV},
+		{".html", renderHTML},
+	} {
+		data, err := f.render(rep)
+		if err != nil {
+			return files, fmt.Errorf("report: render %s: %w", f.ext, err)
+		}
+		path := filepath.Join(dir, base+f.ext)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return files, fmt.Errorf("report: %w", err)
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+func renderJSON(rep availabilityReport) ([]byte, error) {
+	return json.MarshalIndent(rep, "", "  ")
+}
+
+// renderCSV writes one row per host; the worst offenders are only in the
+// JSON and HTML reports.
+func renderCSV(rep availabilityReport) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{
+		"host", "name", "address", "availability_pct", "coverage_pct",
+		"up_seconds", "degraded_seconds", "down_seconds", "unknown_seconds", "maintenance_seconds",
+		"failures", "mtbf_seconds", "mttr_seconds",
+	})
+	for _, h := range rep.Hosts {
+		w.Write([]string{
+			h.Host, h.Name, h.Address, optFloat(h.Availability, 4), formatFloat(h.Coverage, 2),
+			formatFloat(h.UpSeconds, 0), formatFloat(h.DegradedSeconds, 0),
+			formatFloat(h.DownSeconds, 0), formatFloat(h.UnknownSeconds, 0), formatFloat(h.MaintenanceSeconds, 0),
+			strconv.Itoa(h.Failures), optFloat(h.MTBFSeconds, 0), optFloat(h.MTTRSeconds, 0),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func formatFloat(v float64, prec int) string {
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+func optFloat(v *float64, prec int) string {
+	if v == nil {
+		return ""
+	}
+	return formatFloat(*v, prec)
+}
+
+func renderHTML(rep availabilityReport) ([]byte, error) {
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, rep)
+	return buf.Bytes(), err
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+	"pct":  func(v *float64) string { return optFloat(v, 3) },
+	"dur":  plugin.HumanDuration,
+	"optdur": func(v *float64) string {
+		if v == nil {
+			return "—"
+		}
+		return plugin.HumanDuration(*v)
+	},
+	// share is the width of a bar segment, in percent of the period.
+	"share": func(secs float64, rep availabilityReport) string {
+		total := rep.To.Sub(rep.From).Seconds()
+		return formatFloat(100*secs/total, 3)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Availability {{date .From}} to {{date .To}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: left; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+.bar { display: flex; width: 240px; height: 12px; background: #ccc; }
+.up { background: #2e9e4f; } .degraded { background: #e0a020; } .down { background: #c62828; }
+.legend span { display: inline-block; width: 10px; height: 10px; margin: 0 4px 0 12px; }
+.unknown { background: #ccc; } .maintenance { background: #5c7cba; }
+</style>
+</head>
+<body>
+<h1>Availability report</h1>
+<p>{{date .From}} to {{date .To}}{{if .Plugin}}, {{.Plugin}} status metrics{{end}}. Generated {{date .GeneratedAt}}.
+A status sample counts for {{dur .GapSeconds}} at most; time without a sample is unknown, not up.
+Maintenance windows are left out of availability.</p>
+<p class="legend"><span class="up"></span>up<span class="degraded"></span>degraded<span class="down"></span>down<span class="maintenance"></span>maintenance<span class="unknown"></span>unknown</p>
+<h2>Hosts</h2>
+<table>
+<tr><th>Host</th><th>Address</th><th>Availability %</th><th></th><th>Down</th><th>Unknown</th><th>Maintenance</th><th>Failures</th><th>MTBF</th><th>MTTR</th></tr>
+{{- range .Hosts}}
+<tr>
+<td>{{.Host}}{{if and .Name (ne .Name .Host)}} ({{.Name}}){{end}}</td>
+<td>{{.Address}}</td>
+<td class="num">{{with .Availability}}{{pct .}}{{else}}—{{end}}</td>
+<td><div class="bar"><div class="up" style="width: {{share .UpSeconds $}}%"></div><div class="degraded" style="width: {{share .DegradedSeconds $}}%"></div><div class="down" style="width: {{share .DownSeconds $}}%"></div><div class="maintenance" style="width: {{share .MaintenanceSeconds $}}%"></div></div></td>
+<td class="num">{{dur .DownSeconds}}</td>
+<td class="num">{{dur .UnknownSeconds}}</td>
+<td class="num">{{dur .MaintenanceSeconds}}</td>
+<td class="num">{{.Failures}}</td>
+<td class="num">{{optdur .MTBFSeconds}}</td>
+<td class="num">{{optdur .MTTRSeconds}}</td>
+</tr>
+{{- end}}
+</table>
+<h2>Worst offenders</h2>
+{{- if .WorstOffenders}}
+<table>
+<tr><th>Host</th><th>Metric</th><th>Instance</th><th>Availability %</th><th>Down</th><th>Degraded</th><th>Failures</th></tr>
+{{- range .WorstOffenders}}
+<tr>
+<td>{{.Host}}</td>
+<td>{{.Plugin}}/{{.Metric}}</td>
+<td>{{.Instance}}</td>
+<td class="num">{{with .Availability}}{{pct .}}{{else}}—{{end}}</td>
+<td class="num">{{dur .DownSeconds}}</td>
+<td class="num">{{dur .DegradedSeconds}}</td>
+<td class="num">{{.Failures}}</td>
+</tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No status metric was down or degraded in the period.</p>
+{{- end}}
+</body>
+</html>
+`))
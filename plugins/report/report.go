@@ -0,0 +1,390 @@
+// Package report writes availability reports from the status metrics in the
+// store: per host, the share of a period it was up, degraded, down or not
+// collected at all, with mean time between failures and the series that were
+// down longest. Reports are written as JSON, CSV and a self-contained HTML
+// page under data/reports/, on demand with `nord plugin run report generate`
+// or on a schedule as a daemon service ("services": ["report"]).
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+	"observer/store"
+)
+
+const (
+	defaultGap      = 15 * time.Minute
+	defaultTop      = 10
+	defaultSchedule = "monthly"
+	reportsDir      = "reports"
+)
+
+// reportPlugin generates availability reports.
+type reportPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&reportPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *reportPlugin) Name() string {
+	return "Report"
+}
+
+// OnCommand handles "generate", which reports on a period given as
+// period=<name> (default last-month) or from=<date> [to=<date>]. gap= and
+// top= override the report config; plugin= considers only the status
+// metrics of one plugin.
+func (p *reportPlugin) OnCommand(args map[string]string) error {
+	switch args["action"] {
+	case "generate":
+		req, err := parseArgs(args["args"], time.Now())
+		if err != nil {
+			return err
+		}
+		_, err = p.generate(req)
+		return err
+	}
+	return fmt.Errorf("unknown command for report plugin: %s", args["action"])
+}
+
+// Serve writes the report for the previous period each time report.schedule
+// comes round, until ctx is cancelled. A failed report is logged and retried
+// at the next scheduled time.
+func (p *reportPlugin) Serve(ctx context.Context) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	schedule := cfg.Report.Schedule
+	if schedule == "" {
+		schedule = defaultSchedule
+	}
+	if _, ok := schedulePeriods[schedule]; !ok {
+		return fmt.Errorf("report: unknown schedule %q (use monthly, weekly or daily)", schedule)
+	}
+	for {
+		due := nextRun(schedule, time.Now())
+		p.Controller.Printf("  |_ report: next %s report at %s\n", schedule, due.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		req, err := parseArgs("period="+schedulePeriods[schedule], time.Now())
+		if err == nil {
+			_, err = p.generate(req)
+		}
+		if err != nil {
+			p.Controller.Printf("  !_ report: %v\n", err)
+		}
+	}
+}
+
+// request is what a report covers.
+type request struct {
+	from, to time.Time
+	gap      time.Duration
+	top      int
+	plugin   string // only status metrics of this plugin; all when empty
+}
+
+// parseArgs reads the space-separated key=value arguments of "generate".
+func parseArgs(argStr string, now time.Time) (request, error) {
+	var req request
+	var period, from, to, gap, top string
+	for _, kv := range strings.Fields(argStr) {
+		k, v, _ := strings.Cut(kv, "=")
+		switch k {
+		case "period":
+			period = v
+		case "from":
+			from = v
+		case "to":
+			to = v
+		case "gap":
+			gap = v
+		case "top":
+			top = v
+		case "plugin":
+			req.plugin = strings.ToLower(v)
+		default:
+			return req, fmt.Errorf("report: unknown argument %q", kv)
+		}
+	}
+
+	var err error
+	switch {
+	case from != "" && period != "":
+		return req, errors.New("report: give either period= or from=/to=, not both")
+	case from != "":
+		if req.from, err = parseDate(from); err != nil {
+			return req, err
+		}
+		req.to = now
+		if to != "" {
+			if req.to, err = parseDate(to); err != nil {
+				return req, err
+			}
+		}
+	case to != "":
+		return req, errors.New("report: to= needs from=")
+	default:
+		if period == "" {
+			period = "last-month"
+		}
+		if req.from, req.to, err = namedPeriod(period, now); err != nil {
+			return req, err
+		}
+	}
+	if !req.to.After(req.from) {
+		return req, fmt.Errorf("report: empty period %s to %s", req.from.Format(time.RFC3339), req.to.Format(time.RFC3339))
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return req, err
+	}
+	req.gap = defaultGap
+	if gap == "" {
+		gap = cfg.Report.Gap
+	}
+	if gap != "" {
+		d, err := time.ParseDuration(gap)
+		if err != nil || d <= 0 {
+			return req, fmt.Errorf("report: gap %q is not a positive duration", gap)
+		}
+		req.gap = d
+	}
+	req.top = defaultTop
+	if cfg.Report.Top > 0 {
+		req.top = cfg.Report.Top
+	}
+	if top != "" {
+		n, err := strconv.Atoi(top)
+		if err != nil || n < 0 {
+			return req, fmt.Errorf("report: invalid top %q", top)
+		}
+		req.top = n
+	}
+	return req, nil
+}
+
+// loadConfig reads the configuration file; only hosts and the report section are used.
+func loadConfig() (*plugin.Config, error) {
+	data, err := plugin.ReadConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse config file: %w", err)
+	}
+	return &cfg, nil
+}
+
+// availabilityReport is the report as written to JSON. Durations are in seconds.
+type availabilityReport struct {
+	From           time.Time      `json:"from"`
+	To             time.Time      `json:"to"`
+	GeneratedAt    time.Time      `json:"generated_at"`
+	GapSeconds     float64        `json:"gap_seconds"`
+	Plugin         string         `json:"plugin,omitempty"`
+	Hosts          []hostReport   `json:"hosts"`
+	WorstOffenders []seriesReport `json:"worst_offenders"`
+}
+
+// hostReport is one host's line of the report. Availability is the share of
+// the time the host's state was known that it was up or degraded; it is nil
+// when nothing was collected in the period. Coverage is the share known of
+// the time outside maintenance windows.
+type hostReport struct {
+	Host               string   `json:"host"`
+	Name               string   `json:"name"`
+	Address            string   `json:"address"`
+	Availability       *float64 `json:"availability_pct"`
+	Coverage           float64  `json:"coverage_pct"`
+	UpSeconds          float64  `json:"up_seconds"`
+	DegradedSeconds    float64  `json:"degraded_seconds"`
+	DownSeconds        float64  `json:"down_seconds"`
+	UnknownSeconds     float64  `json:"unknown_seconds"`
+	MaintenanceSeconds float64  `json:"maintenance_seconds"` // left out of availability and coverage
+	Failures           int      `json:"failures"`
+	MTBFSeconds        *float64 `json:"mtbf_seconds"` // available time per failure; nil without failures
+	MTTRSeconds        *float64 `json:"mttr_seconds"` // down time per failure; nil without failures
+}
+
+// seriesReport is one status series among the worst offenders.
+type seriesReport struct {
+	Host            string   `json:"host"`
+	Plugin          string   `json:"plugin"`
+	Metric          string   `json:"metric"`
+	Instance        string   `json:"instance,omitempty"`
+	Availability    *float64 `json:"availability_pct"`
+	DownSeconds     float64  `json:"down_seconds"`
+	DegradedSeconds float64  `json:"degraded_seconds"`
+	Failures        int      `json:"failures"`
+}
+
+// generate builds the report for req from the store, prints a summary and
+// writes the report files, returning their paths.
+func (p *reportPlugin) generate(req request) ([]string, error) {
+	st := p.Controller.Store
+	if st == nil {
+		return nil, errors.New("report: generate needs a database (see database.url)")
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{plugin.AgentHostKey}
+	for key := range cfg.Hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	p.Controller.Printf("--- Availability report %s to %s ---\n", req.from.Format(time.RFC3339), req.to.Format(time.RFC3339))
+	rep := availabilityReport{
+		From: req.from, To: req.to, GeneratedAt: time.Now(),
+		GapSeconds: req.gap.Seconds(), Plugin: req.plugin,
+		Hosts: []hostReport{}, WorstOffenders: []seriesReport{},
+	}
+	for _, key := range keys {
+		// Samples up to gap before the period still hold at its start.
+		records, err := st.StatusHistory(context.Background(), key, req.from.Add(-req.gap), req.to)
+		if err != nil {
+			return nil, err
+		}
+		hr := hostReport{Host: key, Name: cfg.Hosts[key].Name, Address: cfg.Hosts[key].Address}
+		series, names := groupSeries(records, req.plugin)
+		var spans [][]span
+		for i, samples := range series {
+			if hr.Name == "" {
+				hr.Name, hr.Address = samples[0].HostName, samples[0].HostAddress
+			}
+			s := seriesSpans(samples, req.from, req.to, req.gap)
+			spans = append(spans, s)
+			t := seriesTally(s, req.from, req.to)
+			if t.down > 0 || t.degraded > 0 {
+				rep.WorstOffenders = append(rep.WorstOffenders, seriesReport{
+					Host: key, Plugin: names[i].Plugin, Metric: names[i].Name, Instance: names[i].Instance,
+					Availability:    availability(t),
+					DownSeconds:     t.down.Seconds(),
+					DegradedSeconds: t.degraded.Seconds(),
+					Failures:        t.failures,
+				})
+			}
+		}
+		t := hostTimeline(spans, req.from, req.to)
+		hr.Availability = availability(t)
+		if outside := req.to.Sub(req.from) - t.maintenance; outside > 0 {
+			hr.Coverage = 100 * float64(outside-t.unknown) / float64(outside)
+		}
+		hr.UpSeconds, hr.DegradedSeconds = t.up.Seconds(), t.degraded.Seconds()
+		hr.DownSeconds, hr.UnknownSeconds = t.down.Seconds(), t.unknown.Seconds()
+		hr.MaintenanceSeconds = t.maintenance.Seconds()
+		hr.Failures = t.failures
+		if t.failures > 0 {
+			mtbf := (t.up + t.degraded).Seconds() / float64(t.failures)
+			mttr := t.down.Seconds() / float64(t.failures)
+			hr.MTBFSeconds, hr.MTTRSeconds = &mtbf, &mttr
+		}
+		rep.Hosts = append(rep.Hosts, hr)
+	}
+
+	// Least available first; hosts without data last.
+	sort.SliceStable(rep.Hosts, func(i, j int) bool {
+		a, b := rep.Hosts[i].Availability, rep.Hosts[j].Availability
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return *a < *b
+	})
+	sort.SliceStable(rep.WorstOffenders, func(i, j int) bool {
+		a, b := rep.WorstOffenders[i], rep.WorstOffenders[j]
+		if a.DownSeconds != b.DownSeconds {
+			return a.DownSeconds > b.DownSeconds
+		}
+		return a.DegradedSeconds > b.DegradedSeconds
+	})
+	if len(rep.WorstOffenders) > req.top {
+		rep.WorstOffenders = rep.WorstOffenders[:req.top]
+	}
+
+	for _, h := range rep.Hosts {
+		switch {
+		case h.Availability == nil:
+			p.Controller.Printf("  !_ %s: no status metrics in the period\n", h.Host)
+		case h.DownSeconds > 0:
+			p.Controller.Printf("  !_ %s: %.3f%% available, %d failures, %.1f%% unknown\n", h.Host, *h.Availability, h.Failures, 100-h.Coverage)
+		default:
+			p.Controller.Printf("  |_ %s: %.3f%% available, %.1f%% unknown\n", h.Host, *h.Availability, 100-h.Coverage)
+		}
+	}
+
+	base := "availability-" + periodLabel(req.from, req.to)
+	if req.plugin != "" {
+		base = "availability-" + req.plugin + "-" + periodLabel(req.from, req.to)
+	}
+	files, err := writeReport(rep, plugin.DataFile(reportsDir), base)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		p.Controller.Printf("  |_ written %s\n", f)
+	}
+	return files, nil
+}
+
+// seriesName identifies a status series of a host.
+type seriesName struct {
+	Plugin, Name, Instance string
+}
+
+// groupSeries splits a host's samples, oldest first, by series. Alert
+// metrics are left out: they restate other series' states. onlyPlugin, when
+// set, keeps just that plugin's series.
+func groupSeries(records []store.MetricRecord, onlyPlugin string) ([][]store.MetricRecord, []seriesName) {
+	index := make(map[seriesName]int)
+	var series [][]store.MetricRecord
+	var names []seriesName
+	for _, r := range records {
+		if r.Plugin == "alert" || (onlyPlugin != "" && r.Plugin != onlyPlugin) {
+			continue
+		}
+		n := seriesName{r.Plugin, r.Name, r.Instance}
+		i, ok := index[n]
+		if !ok {
+			i = len(series)
+			index[n] = i
+			series = append(series, nil)
+			names = append(names, n)
+		}
+		series[i] = append(series[i], r)
+	}
+	return series, names
+}
+
+// availability is the percentage of known time spent up or degraded, or nil
+// when no time is known.
+func availability(t *tally) *float64 {
+	known := t.up + t.degraded + t.down
+	if known <= 0 {
+		return nil
+	}
+	pct := 100 * float64(t.up+t.degraded) / float64(known)
+	return &pct
+}
@@ -0,0 +1,283 @@
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+// historyStore serves status histories per host.
+type historyStore struct {
+	store.Store
+	records map[string][]store.MetricRecord
+}
+
+func (s *historyStore) StatusHistory(ctx context.Context, hostKey string, from, to time.Time) ([]store.MetricRecord, error) {
+	var out []store.MetricRecord
+	for _, r := range s.records[hostKey] {
+		if !r.CollectedAt.Before(from) && r.CollectedAt.Before(to) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// useConfig points the configuration file and the data directory at a temp
+// directory and writes cfg.
+func useConfig(t *testing.T, cfg string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(plugin.EnvStateDir, dir)
+	t.Setenv(plugin.EnvDataDir, dir) // reports go under the data dir
+	old := plugin.ConfigFile
+	plugin.ConfigFile = filepath.Join(dir, "config.json")
+	t.Cleanup(func() {
+		plugin.ConfigFile = old
+		plugin.LoadPaths()
+	})
+	plugin.LoadPaths()
+	if err := os.WriteFile(plugin.ConfigFile, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	useConfig(t, `{"config_version": 1, "hosts": {
+		"sw1": {"address": "10.0.0.2", "name": "core-sw1"},
+		"sw2": {"address": "10.0.0.3", "name": "core-sw2"},
+		"ap1": {"address": "10.0.0.9", "name": "lobby-ap"}}}`)
+	flapping := history("sw1", "status", 0, 5*time.Minute,
+		"up", "up", "down", "up", "down", "down", "up", "up", "up", "up", "up", "up")
+	for i := range flapping {
+		flapping[i].HostName, flapping[i].HostAddress = "stale-name", "10.9.9.9"
+	}
+	alerts := history("sw1", "status", 0, 5*time.Minute, "down", "down")
+	for i := range alerts {
+		alerts[i].Plugin = "alert"
+	}
+	http := history("sw2", "status", 0, 5*time.Minute, "up", "warning", "up")
+	for i := range http {
+		http[i].Plugin, http[i].Instance = "httpcheck", "https://intranet"
+	}
+	st := &historyStore{records: map[string][]store.MetricRecord{
+		"sw1": append(flapping, alerts...),
+		"sw2": append(history("sw2", "status", 0, 5*time.Minute, "up", "up", "", "", "", "", "", "", "up", "up", "up", "up"), http...),
+		// The agent reports on itself without a hosts entry.
+		plugin.AgentHostKey: history(plugin.AgentHostKey, "status", -10*time.Minute, 30*time.Minute, "up", "up", "up"),
+	}}
+	c := plugin.NewController()
+	c.Store = st
+	p := &reportPlugin{BasePlugin: plugin.BasePlugin{Controller: c}}
+
+	files, err := p.generate(request{from: start, to: start.Add(time.Hour), gap: 15 * time.Minute, top: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := plugin.DataFile(reportsDir)
+	base := filepath.Join(dir, "availability-2024-05-01T0000_2024-05-01T0100")
+	if strings.Join(files, " ") != base+".json "+base+".csv "+base+".html" {
+		t.Fatalf("files = %v", files)
+	}
+
+	data, err := os.ReadFile(base + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep availabilityReport
+	if err := json.Unmarshal(data, &rep); err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	hosts := make(map[string]hostReport)
+	for _, h := range rep.Hosts {
+		order = append(order, h.Host)
+		hosts[h.Host] = h
+	}
+	// Least available first, hosts without data last.
+	if strings.Join(order, " ") != "sw1 nord-agent sw2 ap1" {
+		t.Errorf("hosts = %v", order)
+	}
+
+	sw1 := hosts["sw1"]
+	if sw1.Name != "core-sw1" || sw1.Address != "10.0.0.2" {
+		t.Errorf("sw1 named %q %q", sw1.Name, sw1.Address)
+	}
+	// The alert plugin's restatement of the same failure is left out.
+	if sw1.Availability == nil || *sw1.Availability != 75 || sw1.DownSeconds != 900 || sw1.Failures != 2 || sw1.Coverage != 100 {
+		t.Errorf("sw1 = %+v", sw1)
+	}
+	if sw1.MTBFSeconds == nil || *sw1.MTBFSeconds != 1350 || sw1.MTTRSeconds == nil || *sw1.MTTRSeconds != 450 {
+		t.Errorf("sw1 mtbf %v mttr %v", sw1.MTBFSeconds, sw1.MTTRSeconds)
+	}
+
+	// sw2 was not collected for 15m: that time is unknown, not up, and its
+	// degraded HTTP check still counts as available.
+	sw2 := hosts["sw2"]
+	if sw2.Availability == nil || *sw2.Availability != 100 || sw2.UnknownSeconds != 900 || sw2.Coverage != 75 ||
+		sw2.DegradedSeconds != 300 || sw2.MTBFSeconds != nil {
+		t.Errorf("sw2 = %+v", sw2)
+	}
+
+	agent := hosts[plugin.AgentHostKey]
+	if agent.Availability == nil || agent.UnknownSeconds != 1800 || agent.Name != plugin.AgentHostKey {
+		t.Errorf("agent = %+v", agent)
+	}
+	if ap1 := hosts["ap1"]; ap1.Availability != nil || ap1.Coverage != 0 || ap1.UnknownSeconds != 3600 {
+		t.Errorf("ap1 = %+v", ap1)
+	}
+
+	if len(rep.WorstOffenders) != 2 || rep.WorstOffenders[0].Host != "sw1" || rep.WorstOffenders[0].Plugin != "ping" ||
+		rep.WorstOffenders[1].Instance != "https://intranet" || rep.WorstOffenders[1].DegradedSeconds != 300 {
+		t.Errorf("worst offenders = %+v", rep.WorstOffenders)
+	}
+
+	f, err := os.Open(base + ".csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 || rows[0][3] != "availability_pct" || strings.Join(rows[1][:5], ",") != "sw1,core-sw1,10.0.0.2,75.0000,100.00" ||
+		rows[4][3] != "" || rows[4][11] != "" {
+		t.Errorf("csv = %v", rows)
+	}
+
+	html, err := os.ReadFile(base + ".html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"sw1 (core-sw1)", "75.000", "ping/status", "https://intranet", `style="width: 25.000%"`} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("html lacks %q", want)
+		}
+	}
+
+	// plugin= keeps one plugin's series, and writes its own files.
+	files, err = p.generate(request{from: start, to: start.Add(time.Hour), gap: 15 * time.Minute, top: 1, plugin: "httpcheck"})
+	if err != nil || filepath.Base(files[0]) != "availability-httpcheck-2024-05-01T0000_2024-05-01T0100.json" {
+		t.Fatalf("plugin report: %v, %v", files, err)
+	}
+	data, _ = os.ReadFile(files[0])
+	rep = availabilityReport{}
+	json.Unmarshal(data, &rep)
+	if rep.Plugin != "httpcheck" || len(rep.WorstOffenders) != 1 || rep.Hosts[0].Host != "sw2" || rep.Hosts[0].UnknownSeconds != 2100 {
+		t.Errorf("plugin report = %+v", rep)
+	}
+}
+
+func TestGenerateNeedsStore(t *testing.T) {
+	useConfig(t, `{"config_version": 1}`)
+	p := &reportPlugin{BasePlugin: plugin.BasePlugin{Controller: plugin.NewController()}}
+	if err := p.OnCommand(map[string]string{"action": "generate", "args": "period=yesterday"}); err == nil || !strings.Contains(err.Error(), "database") {
+		t.Errorf("err = %v", err)
+	}
+	if err := p.OnCommand(map[string]string{"action": "purge"}); err == nil {
+		t.Error("unknown command accepted")
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	useConfig(t, `{"config_version": 1, "report": {"gap": "10m", "top": 3}}`)
+	now := time.Date(2024, 6, 12, 15, 30, 0, 0, time.Local)
+
+	req, err := parseArgs("", now)
+	if err != nil || !req.from.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)) || !req.to.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)) ||
+		req.gap != 10*time.Minute || req.top != 3 {
+		t.Errorf("defaults = %+v, %v", req, err)
+	}
+	req, err = parseArgs("from=2024-06-01 gap=1h top=0 plugin=SNMP", now)
+	if err != nil || !req.from.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)) || !req.to.Equal(now) ||
+		req.gap != time.Hour || req.top != 0 || req.plugin != "snmp" {
+		t.Errorf("from = %+v, %v", req, err)
+	}
+	req, err = parseArgs("from=2024-06-01T08:00 to=2024-06-02T08:00:00Z", now)
+	if err != nil || !req.to.Equal(time.Date(2024, 6, 2, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("from and to = %+v, %v", req, err)
+	}
+
+	for _, args := range []string{
+		"period=last-month from=2024-06-01",
+		"to=2024-06-01",
+		"from=June",
+		"from=2024-06-12 to=2024-06-01",
+		"period=fortnight",
+		"gap=-5m",
+		"gap=soon",
+		"top=many",
+		"format=pdf",
+	} {
+		if _, err := parseArgs(args, now); err == nil {
+			t.Errorf("%q accepted", args)
+		}
+	}
+}
+
+func TestNamedPeriods(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2024, 3, 13, 15, 30, 0, 0, time.UTC)
+	day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC) }
+	for name, want := range map[string][2]time.Time{
+		"today":      {day(3, 13), now},
+		"yesterday":  {day(3, 12), day(3, 13)},
+		"this-week":  {day(3, 11), now},
+		"last-week":  {day(3, 4), day(3, 11)},
+		"this-month": {day(3, 1), now},
+		"last-month": {day(2, 1), day(3, 1)},
+		"last-7d":    {now.AddDate(0, 0, -7), now},
+		"last-36h":   {now.Add(-36 * time.Hour), now},
+	} {
+		from, to, err := namedPeriod(name, now)
+		if err != nil || !from.Equal(want[0]) || !to.Equal(want[1]) {
+			t.Errorf("%s = %v to %v, %v", name, from, to, err)
+		}
+	}
+	// On a Sunday the week started six days before.
+	if from, _, _ := namedPeriod("this-week", time.Date(2024, 3, 17, 9, 0, 0, 0, time.UTC)); !from.Equal(day(3, 11)) {
+		t.Errorf("this-week on Sunday from %v", from)
+	}
+	for _, name := range []string{"last-0d", "last-d", "last-3w", "last-year"} {
+		if _, _, err := namedPeriod(name, now); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	at := func(month time.Month, d, h, m int) time.Time { return time.Date(2024, month, d, h, m, 0, 0, time.UTC) }
+	for _, tt := range []struct {
+		schedule  string
+		now, want time.Time
+	}{
+		{"monthly", at(3, 13, 15, 30), at(4, 1, 0, 10)},
+		// Just after midnight the previous month's report is still due.
+		{"monthly", at(4, 1, 0, 5), at(4, 1, 0, 10)},
+		{"monthly", at(4, 1, 0, 10), at(5, 1, 0, 10)},
+		{"weekly", at(3, 13, 15, 30), at(3, 18, 0, 10)},
+		{"daily", at(3, 13, 15, 30), at(3, 14, 0, 10)},
+		{"daily", at(3, 13, 0, 1), at(3, 13, 0, 10)},
+	} {
+		if got := nextRun(tt.schedule, tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s at %v = %v, want %v", tt.schedule, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestPeriodLabel(t *testing.T) {
+	if got := periodLabel(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)); got != "2024-05-01_2024-06-01" {
+		t.Errorf("month = %s", got)
+	}
+	if got := periodLabel(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 14, 5, 0, 0, time.UTC)); got != "2024-05-01T0000_2024-05-01T1405" {
+		t.Errorf("partial day = %s", got)
+	}
+}
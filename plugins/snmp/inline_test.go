@@ -0,0 +1,157 @@
+package snmp
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	plugin "observer/base"
+)
+
+// edgeDevice is a device file for an edge router whose temperature OID is
+// wrong on newer firmware.
+const edgeDevice = `{
+    "category": "system",
+    "oids": [
+        {"oid": ".1.3.6.1.2.1.1.1.0", "name": "System Description", "format": "string", "type": "text"},
+        {"oid": ".1.3.6.1.2.1.1.3.0", "name": "Up Time", "format": "timeticks", "type": "text"},
+        {"oid": ".1.3.6.1.4.1.9.9.13.1.3.1.3.1", "name": "Temperature", "format": "integer", "unit": "°C"}
+    ],
+    "tables": [
+        {"base_oid": "1.3.6.1.2.1.2.2.1", "type": "interface",
+         "columns": [{"sub_oid": "2", "name": "ifDescr", "format": "string", "role": "name"}]}
+    ]
+}`
+
+// edgeTask is the options of a host's snmp task, as decoded from config.json.
+const edgeTask = `{"oids": [
+    {"oid": ".1.3.6.1.4.1.9.9.13.1.3.1.3.1006", "name": "Temperature", "format": "integer", "scale": 0.1, "unit": "°C"},
+    {"oid": ".1.3.6.1.4.1.9.9.13.1.4.1.3.1", "name": "Fan", "format": "integer", "type": "status", "map": {"1": "up", "*": "down"}},
+    {"oid": ".1.3.6.1.2.1.1.5.0", "name": "System Name", "format": "string", "type": "text"}
+]}`
+
+const edgeWalk = `
+.1.3.6.1.2.1.1.1.0 = STRING: "Cisco IOS XE"
+.1.3.6.1.2.1.1.3.0 = Timeticks: (360000) 1:00:00.00
+.1.3.6.1.2.1.1.5.0 = STRING: "edge-1"
+.1.3.6.1.4.1.9.9.13.1.3.1.3.1 = INTEGER: 0
+.1.3.6.1.4.1.9.9.13.1.3.1.3.1006 = INTEGER: 415
+.1.3.6.1.4.1.9.9.13.1.4.1.3.1 = INTEGER: 3
+`
+
+// useDeviceFile writes a device definition under a temporary devices directory.
+func useDeviceFile(t *testing.T, deviceType, content string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "snmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "snmp", deviceType+".json"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(plugin.EnvDevicesDir, dir)
+	plugin.LoadPaths()
+	t.Cleanup(plugin.LoadPaths)
+}
+
+// taskOptions builds OnCollect's options around a task's decoded options.
+func taskOptions(t *testing.T, task string) map[string]interface{} {
+	t.Helper()
+	var opts map[string]interface{}
+	if err := json.Unmarshal([]byte(task), &opts); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{"options": opts}
+}
+
+func TestTaskDefinition(t *testing.T) {
+	useDeviceFile(t, "edge", edgeDevice)
+	p := &snmpPlugin{}
+
+	def, err := p.taskDefinition("edge", taskOptions(t, edgeTask))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The override keeps its place; additions follow in task order.
+	var set []string
+	for _, o := range def.OIDs {
+		set = append(set, o.Name+" "+o.OID)
+	}
+	want := []string{
+		"System Description .1.3.6.1.2.1.1.1.0",
+		"Up Time .1.3.6.1.2.1.1.3.0",
+		"Temperature .1.3.6.1.4.1.9.9.13.1.3.1.3.1006",
+		"Fan .1.3.6.1.4.1.9.9.13.1.4.1.3.1",
+		"System Name .1.3.6.1.2.1.1.5.0",
+	}
+	if strings.Join(set, "\n") != strings.Join(want, "\n") {
+		t.Errorf("query set:\n%s\nwant:\n%s", strings.Join(set, "\n"), strings.Join(want, "\n"))
+	}
+	if len(def.Tables) != 1 || def.Tables[0].BaseOID != "1.3.6.1.2.1.2.2.1" {
+		t.Errorf("tables = %+v", def.Tables)
+	}
+
+	got := summarize(decodeDevice(t, def, edgeWalk))
+	wantMetrics := []string{
+		"Fan: down status",
+		"System_Description: Cisco IOS XE text",
+		"System_Name: edge-1 text",
+		"Temperature: 41.5 °C gauge",
+		"Up_Time: 0d 1h 0m 0s text",
+	}
+	if strings.Join(got, "\n") != strings.Join(wantMetrics, "\n") {
+		t.Errorf("metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(wantMetrics, "\n"))
+	}
+
+	// The device file is read afresh for every task: the next one without
+	// inline OIDs gets the file's set.
+	plain, err := p.taskDefinition("edge", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain.OIDs) != 3 || plain.OIDs[2].OID != ".1.3.6.1.4.1.9.9.13.1.3.1.3.1" || plain.OIDs[2].Scale != 0 {
+		t.Errorf("without inline OIDs: %+v", plain.OIDs)
+	}
+}
+
+func TestTaskDefinitionErrors(t *testing.T) {
+	useDeviceFile(t, "edge", edgeDevice)
+	p := &snmpPlugin{}
+	for _, tt := range []struct {
+		deviceType string
+		options    map[string]interface{}
+		msg        string
+	}{
+		{"missing", nil, "SNMP: failed to load device definition"},
+		{"edge", taskOptions(t, `{"oids": "1.3.6.1.2.1.1.5.0"}`), "SNMP: invalid options"},
+		{"edge", taskOptions(t, `{"oids": [{"oid": ".1.3.6.1.2.1.1.5.0", "scale": "ten"}]}`), "SNMP: invalid options"},
+	} {
+		if _, err := p.taskDefinition(tt.deviceType, tt.options); err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s %v: err = %v, want %q", tt.deviceType, tt.options, err, tt.msg)
+		}
+	}
+}
+
+func TestMergeOIDs(t *testing.T) {
+	def := &DeviceDefinition{OIDs: []OIDDefinition{
+		{OID: ".1.1", Name: "a", Format: "string"},
+		{OID: ".1.2", Name: "b", Format: "integer"},
+	}}
+	notes := def.mergeOIDs([]OIDDefinition{
+		{OID: ".1.3", Name: "c"},
+		{OID: ".1.20", Name: "b", Format: "gauge"},
+		{OID: ".1.30", Name: "c", Format: "counter"}, // the last of a name wins
+	})
+	var got []string
+	for _, o := range def.OIDs {
+		got = append(got, o.Name+"="+o.OID+"/"+o.Format)
+	}
+	if strings.Join(got, " ") != "a=.1.1/string b=.1.20/gauge c=.1.30/counter" {
+		t.Errorf("merged %v", got)
+	}
+	if want := `b: format "gauge" from the task overrides "integer"|c: format "counter" from the task overrides ""`; strings.Join(notes, "|") != want {
+		t.Errorf("notes %q", notes)
+	}
+}
@@ -0,0 +1,473 @@
+package snmp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"observer/store"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// Tables read by the "neighbors" action.
+const (
+	oidIfDescr     = "1.3.6.1.2.1.2.2.1"          // ifTable; column 2 is ifDescr
+	oidIfName      = "1.3.6.1.2.1.31.1.1.1"       // ifXTable; column 1 is ifName
+	oidLldpLocPort = "1.0.8802.1.1.2.1.3.7.1"     // lldpLocPortTable
+	oidLldpRem     = "1.0.8802.1.1.2.1.4.1.1"     // lldpRemTable
+	oidLldpRemAddr = "1.0.8802.1.1.2.1.4.2.1"     // lldpRemManAddrTable
+	oidCdpCache    = "1.3.6.1.4.1.9.9.23.1.2.1.1" // CISCO-CDP-MIB cdpCacheTable
+)
+
+// neighborOptions are the per-task options of an snmp.neighbors task:
+//
+//	{"metric": "snmp.neighbors", "credentials": "snmp", "options": {"cdp": true}}
+//
+// LLDP is always walked; cdp also walks the Cisco CDP cache.
+type neighborOptions struct {
+	CDP bool `json:"cdp"`
+}
+
+// neighbor is one LLDP or CDP neighbor of a local port.
+type neighbor struct {
+	Protocol        string
+	LocalIfIndex    int
+	LocalPort       string
+	RemoteChassisID string
+	RemotePort      string
+	RemotePortDesc  string
+	RemoteSysName   string
+	RemoteAddress   string
+	Platform        string // CDP only
+	timeMark        int64
+}
+
+// queryNeighbors walks the LLDP (and optionally CDP) neighbor tables and
+// returns the neighbors as links, for the store's links table, and as one
+// text metric per neighbor, instanced by local port, so that changes of
+// neighbor show in the metric history.
+func (p *snmpPlugin) queryNeighbors(host string, port uint16, community, version string, opts neighborOptions) (map[string]interface{}, error) {
+	client, err := p.connect(host, port, community, version)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Conn.Close()
+
+	walk := func(base string, cols ...string) map[string]map[string]gosnmp.SnmpPDU {
+		table := TableDefinition{BaseOID: base}
+		for _, c := range cols {
+			table.Columns = append(table.Columns, TableColumnDef{SubOID: c})
+		}
+		rows, err := p.walkTable(client, table)
+		if err != nil {
+			p.Controller.Printf("          !_ SNMP: table walk %s failed: %v\n", base, err)
+		}
+		return rows
+	}
+
+	names := newIfNames(walk(oidIfName, "1"), walk(oidIfDescr, "2"))
+	neighbors := decodeLLDP(
+		walk(oidLldpLocPort, "2", "3", "4"),
+		walk(oidLldpRem, "4", "5", "6", "7", "8", "9"),
+		walk(oidLldpRemAddr, "3"),
+		names,
+	)
+	if opts.CDP {
+		neighbors = append(neighbors, decodeCDP(walk(oidCdpCache, "3", "4", "6", "7", "8"), names)...)
+	}
+	return p.neighborResult(neighbors, opts.CDP), nil
+}
+
+// neighborResult builds the result of the neighbors action: a text metric
+// per neighbor, instanced by local port (with "#2", "#3"… for further
+// neighbors on the same port), a count per protocol walked, and the links.
+func (p *snmpPlugin) neighborResult(neighbors []neighbor, cdp bool) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	links := make([]map[string]interface{}, 0, len(neighbors))
+	counts := map[string]int{"lldp": 0}
+	if cdp {
+		counts["cdp"] = 0
+	}
+	perPort := make(map[string]int)
+	for _, n := range neighbors {
+		counts[n.Protocol]++
+		instance := n.LocalPort
+		portKey := n.Protocol + "\x00" + n.LocalPort
+		if k := perPort[portKey]; k > 0 {
+			instance = fmt.Sprintf("%s#%d", n.LocalPort, k+1)
+		}
+		perPort[portKey]++
+
+		remote := firstNonEmpty(n.RemoteSysName, n.RemoteChassisID)
+		if n.RemotePort != "" {
+			remote += " " + n.RemotePort
+		}
+		m := map[string]interface{}{
+			"category":          "Topology",
+			"name":              n.Protocol + "_neighbor",
+			"label":             strings.ToUpper(n.Protocol) + " Neighbor",
+			"value":             remote,
+			"type":              "text",
+			"instance":          instance,
+			"dedup":             true,
+			"local_if_index":    n.LocalIfIndex,
+			"remote_chassis_id": n.RemoteChassisID,
+			"remote_port":       n.RemotePort,
+			"remote_sys_name":   n.RemoteSysName,
+		}
+		if n.RemotePortDesc != "" {
+			m["remote_port_desc"] = n.RemotePortDesc
+		}
+		if n.RemoteAddress != "" {
+			m["remote_address"] = n.RemoteAddress
+		}
+		if n.Platform != "" {
+			m["platform"] = n.Platform
+		}
+		metrics[n.Protocol+"_neighbor_"+instance] = m
+		p.Controller.Printf("          |_ SNMP %s: %s -> %s\n", strings.ToUpper(n.Protocol), n.LocalPort, remote)
+
+		links = append(links, map[string]interface{}{
+			"protocol":          n.Protocol,
+			"local_if_index":    n.LocalIfIndex,
+			"local_port":        n.LocalPort,
+			"remote_chassis_id": n.RemoteChassisID,
+			"remote_port":       n.RemotePort,
+			"remote_port_desc":  n.RemotePortDesc,
+			"remote_sys_name":   n.RemoteSysName,
+			"remote_address":    n.RemoteAddress,
+		})
+	}
+	for proto, count := range counts {
+		metrics[proto+"_neighbors"] = map[string]interface{}{
+			"category": "Topology",
+			"name":     proto + "_neighbors",
+			"label":    strings.ToUpper(proto) + " Neighbors",
+			"value":    strconv.Itoa(count),
+			"type":     "gauge",
+		}
+	}
+
+	result := map[string]interface{}{"metrics": metrics}
+	if len(links) > 0 {
+		result["links"] = links
+	}
+	return result
+}
+
+// ifNames resolves local ports to ifIndex and name.
+type ifNames struct {
+	name    map[int]string // ifName, else ifDescr, by ifIndex
+	byLabel map[string]int // ifIndex by ifName and by ifDescr
+}
+
+func newIfNames(ifName, ifDescr map[string]map[string]gosnmp.SnmpPDU) ifNames {
+	n := ifNames{name: make(map[int]string), byLabel: make(map[string]int)}
+	for _, src := range []struct {
+		rows map[string]map[string]gosnmp.SnmpPDU
+		col  string
+	}{{ifDescr, "2"}, {ifName, "1"}} { // ifName wins as the display name
+		for index, row := range src.rows {
+			idx, err := strconv.Atoi(index)
+			if err != nil {
+				continue
+			}
+			label := octetString(row[src.col])
+			if label == "" {
+				continue
+			}
+			n.name[idx] = label
+			n.byLabel[label] = idx
+		}
+	}
+	return n
+}
+
+// decodeLLDP turns walked LLDP-MIB rows into neighbors.
+//
+// lldpRemTable is indexed by lldpRemTimeMark.lldpRemLocalPortNum.lldpRemIndex.
+// Agents do not agree on the time mark: some re-index a neighbor under a new
+// mark on every change and leave the old row until it ages out, some use 0
+// throughout, and a few omit it. Rows are therefore keyed by local port and
+// remote index, and then by neighbor identity, keeping the row with the
+// latest time mark.
+func decodeLLDP(loc, rem, manAddr map[string]map[string]gosnmp.SnmpPDU, names ifNames) []neighbor {
+	// Local port number → (ifIndex, name).
+	type localPort struct {
+		ifIndex int
+		name    string
+	}
+	ports := make(map[string]localPort)
+	for portNum, row := range loc {
+		id := octetString(row["3"])
+		desc := octetString(row["4"])
+		lp := localPort{}
+		for _, label := range []string{id, desc} {
+			if idx, ok := names.byLabel[label]; ok && label != "" {
+				lp.ifIndex = idx
+				break
+			}
+		}
+		if lp.ifIndex == 0 {
+			// LLDP-MIB recommends numbering local ports by ifIndex.
+			if idx, err := strconv.Atoi(portNum); err == nil && names.name[idx] != "" {
+				lp.ifIndex = idx
+			}
+		}
+		lp.name = names.name[lp.ifIndex]
+		if lp.name == "" {
+			lp.name = firstNonEmpty(printable(row["3"].Value), desc)
+		}
+		ports[portNum] = lp
+	}
+
+	addrs := decodeManAddrs(manAddr)
+
+	byIndex := make(map[string]neighbor)
+	for index, row := range rem {
+		tm, portNum, remIdx, ok := splitRemIndex(index)
+		if !ok {
+			continue
+		}
+		n := neighbor{
+			Protocol:        "lldp",
+			RemoteChassisID: lldpID(row["4"], row["5"], 4),
+			RemotePort:      lldpID(row["6"], row["7"], 3),
+			RemotePortDesc:  octetString(row["8"]),
+			RemoteSysName:   octetString(row["9"]),
+			RemoteAddress:   addrs[portNum+"."+remIdx],
+			timeMark:        tm,
+		}
+		lp, ok := ports[portNum]
+		if !ok {
+			// No lldpLocPortTable row: assume the port number is the ifIndex.
+			idx, _ := strconv.Atoi(portNum)
+			lp = localPort{ifIndex: idx, name: names.name[idx]}
+			if lp.name == "" {
+				lp = localPort{name: "port " + portNum}
+			}
+		}
+		n.LocalIfIndex, n.LocalPort = lp.ifIndex, lp.name
+		key := portNum + "." + remIdx
+		if prev, ok := byIndex[key]; !ok || tm > prev.timeMark {
+			byIndex[key] = n
+		}
+	}
+
+	// A neighbor re-indexed under a new remote index leaves stale rows too.
+	byIdentity := make(map[string]neighbor)
+	for _, n := range byIndex {
+		key := n.LocalPort + "\x00" + n.RemoteChassisID + "\x00" + n.RemotePort
+		if prev, ok := byIdentity[key]; !ok || n.timeMark > prev.timeMark {
+			byIdentity[key] = n
+		}
+	}
+	return sortNeighbors(byIdentity)
+}
+
+// splitRemIndex reads an lldpRemTable index, with or without the time mark.
+func splitRemIndex(index string) (tm int64, portNum, remIdx string, ok bool) {
+	parts := strings.Split(index, ".")
+	switch len(parts) {
+	case 3:
+		tm, _ = strconv.ParseInt(parts[0], 10, 64)
+		return tm, parts[1], parts[2], true
+	case 2:
+		return 0, parts[0], parts[1], true
+	}
+	return 0, "", "", false
+}
+
+// decodeManAddrs reads the management addresses of lldpRemManAddrTable,
+// whose index ends in the address itself: [timeMark.]localPortNum.remIndex.
+// addrSubtype.addrLen.addr... The result is keyed by "localPortNum.remIndex";
+// IPv4 addresses are preferred over IPv6.
+func decodeManAddrs(rows map[string]map[string]gosnmp.SnmpPDU) map[string]string {
+	addrs := make(map[string]string)
+	for index := range rows {
+		parts := strings.Split(index, ".")
+		for _, prefix := range []int{3, 2} {
+			if len(parts) < prefix+2 {
+				continue
+			}
+			subtype, n := parts[prefix], atoiOr(parts[prefix+1], -1)
+			if n < 0 || len(parts) != prefix+2+n {
+				continue
+			}
+			octets := make([]byte, n)
+			for i := range octets {
+				octets[i] = byte(atoiOr(parts[prefix+2+i], 0))
+			}
+			key := parts[prefix-2] + "." + parts[prefix-1]
+			switch {
+			case subtype == "1" && n == 4:
+				addrs[key] = net.IP(octets).String()
+			case subtype == "2" && n == 16 && addrs[key] == "":
+				addrs[key] = net.IP(octets).String()
+			}
+			break
+		}
+	}
+	return addrs
+}
+
+// decodeCDP turns walked cdpCacheTable rows, indexed by
+// cdpCacheIfIndex.cdpCacheDeviceIndex, into neighbors.
+func decodeCDP(rows map[string]map[string]gosnmp.SnmpPDU, names ifNames) []neighbor {
+	byKey := make(map[string]neighbor)
+	for index, row := range rows {
+		ifIndex, _, ok := strings.Cut(index, ".")
+		if !ok {
+			continue
+		}
+		idx := atoiOr(ifIndex, 0)
+		deviceID := octetString(row["6"])
+		n := neighbor{
+			Protocol:        "cdp",
+			LocalIfIndex:    idx,
+			LocalPort:       firstNonEmpty(names.name[idx], "ifIndex "+ifIndex),
+			RemoteChassisID: deviceID,
+			RemotePort:      octetString(row["7"]),
+			Platform:        octetString(row["8"]),
+		}
+		// NX-OS appends the serial number: "switch1(FOX1234ABCD)".
+		n.RemoteSysName, _, _ = strings.Cut(deviceID, "(")
+		if pdu, ok := row["4"]; ok && toInt(row["3"]) == 1 {
+			if b, ok := pdu.Value.([]byte); ok && len(b) == 4 {
+				n.RemoteAddress = net.IP(b).String()
+			}
+		}
+		byKey[n.LocalPort+"\x00"+n.RemoteChassisID+"\x00"+n.RemotePort] = n
+	}
+	return sortNeighbors(byKey)
+}
+
+func sortNeighbors(m map[string]neighbor) []neighbor {
+	list := make([]neighbor, 0, len(m))
+	for _, n := range m {
+		list = append(list, n)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.LocalIfIndex != b.LocalIfIndex {
+			return a.LocalIfIndex < b.LocalIfIndex
+		}
+		if a.LocalPort != b.LocalPort {
+			return a.LocalPort < b.LocalPort
+		}
+		return a.RemoteChassisID+a.RemotePort < b.RemoteChassisID+b.RemotePort
+	})
+	return list
+}
+
+// lldpID formats an LLDP chassis or port ID by its subtype: MAC addresses
+// (chassis subtype 4, port subtype macSubtype) as xx:xx:…, network addresses
+// (subtype 5 for chassis, 4 for port) as IP addresses, and everything else
+// as text when printable, hex otherwise.
+func lldpID(subtypePDU, idPDU gosnmp.SnmpPDU, macSubtype int) string {
+	b, _ := idPDU.Value.([]byte)
+	subtype := toInt(subtypePDU)
+	addrSubtype := 5
+	if macSubtype == 3 {
+		addrSubtype = 4
+	}
+	switch {
+	case subtype == macSubtype && len(b) == 6:
+		return hexBytes(b)
+	case subtype == addrSubtype && len(b) == 5 && b[0] == 1: // IANA family 1: IPv4
+		return net.IP(b[1:]).String()
+	case subtype == addrSubtype && len(b) == 17 && b[0] == 2: // IANA family 2: IPv6
+		return net.IP(b[1:]).String()
+	}
+	return printable(idPDU.Value)
+}
+
+// printable returns an octet string value as text, or as hex when it is not
+// printable text.
+func printable(v interface{}) string {
+	b, ok := v.([]byte)
+	if !ok {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+	s := strings.TrimRight(string(b), "\x00")
+	if !utf8.ValidString(s) {
+		return hexBytes(b)
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return hexBytes(b)
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+func octetString(pdu gosnmp.SnmpPDU) string {
+	return printable(pdu.Value)
+}
+
+func hexBytes(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02x", c)
+	}
+	return strings.Join(parts, ":")
+}
+
+func toInt(pdu gosnmp.SnmpPDU) int {
+	if pdu.Value == nil {
+		return 0
+	}
+	return int(gosnmp.ToBigInt(pdu.Value).Int64())
+}
+
+func atoiOr(s string, def int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// LinkListToRecords converts the links returned by the neighbors action to
+// store.LinkRecord. Called by the collection plugin.
+func LinkListToRecords(hostKey, hostName, hostAddress string, links []map[string]interface{}) []store.LinkRecord {
+	records := make([]store.LinkRecord, 0, len(links))
+	for _, l := range links {
+		str := func(k string) string {
+			s, _ := l[k].(string)
+			return s
+		}
+		idx, _ := l["local_if_index"].(int)
+		records = append(records, store.LinkRecord{
+			HostKey:         hostKey,
+			HostName:        hostName,
+			HostAddress:     hostAddress,
+			Protocol:        str("protocol"),
+			LocalIfIndex:    idx,
+			LocalPort:       str("local_port"),
+			RemoteChassisID: str("remote_chassis_id"),
+			RemotePort:      str("remote_port"),
+			RemotePortDesc:  str("remote_port_desc"),
+			RemoteSysName:   str("remote_sys_name"),
+			RemoteAddress:   str("remote_address"),
+		})
+	}
+	return records
+}
@@ -0,0 +1,307 @@
+package snmp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+
+	"observer/store"
+)
+
+// accessSwitch is a walk of an access switch in snmpwalk -On form. Its LLDP
+// agent keeps stale rows: port 2's neighbor was re-indexed under new time
+// marks and a new remote index, and one neighbor has no time mark at all.
+const accessSwitch = `
+.1.3.6.1.2.1.31.1.1.1.1.10101 = STRING: "Gi1/0/1"
+.1.3.6.1.2.1.31.1.1.1.1.10102 = STRING: "Gi1/0/2"
+.1.3.6.1.2.1.31.1.1.1.1.10103 = STRING: "Gi1/0/3"
+.1.3.6.1.2.1.31.1.1.1.1.10148 = STRING: "Te1/1/1"
+.1.3.6.1.2.1.2.2.1.2.10101 = STRING: "GigabitEthernet1/0/1"
+.1.3.6.1.2.1.2.2.1.2.10102 = STRING: "GigabitEthernet1/0/2"
+.1.3.6.1.2.1.2.2.1.2.10103 = STRING: "GigabitEthernet1/0/3"
+.1.3.6.1.2.1.2.2.1.2.10148 = STRING: "TenGigabitEthernet1/1/1"
+.1.3.6.1.2.1.2.2.1.2.10200 = STRING: "Vlan1"
+
+.1.0.8802.1.1.2.1.3.7.1.2.1 = INTEGER: 5
+.1.0.8802.1.1.2.1.3.7.1.3.1 = STRING: "Gi1/0/1"
+.1.0.8802.1.1.2.1.3.7.1.4.1 = STRING: "uplink"
+.1.0.8802.1.1.2.1.3.7.1.2.2 = INTEGER: 3
+.1.0.8802.1.1.2.1.3.7.1.3.2 = Hex-STRING: 70 10 6F 00 00 02
+.1.0.8802.1.1.2.1.3.7.1.4.2 = STRING: "GigabitEthernet1/0/2"
+.1.0.8802.1.1.2.1.3.7.1.2.3 = INTEGER: 7
+.1.0.8802.1.1.2.1.3.7.1.3.3 = STRING: "mgmt0"
+.1.0.8802.1.1.2.1.3.7.1.4.3 = STRING: ""
+
+.1.0.8802.1.1.2.1.4.1.1.4.0.1.1 = INTEGER: 4
+.1.0.8802.1.1.2.1.4.1.1.5.0.1.1 = Hex-STRING: 00 1A 2B 3C 4D 5E
+.1.0.8802.1.1.2.1.4.1.1.6.0.1.1 = INTEGER: 5
+.1.0.8802.1.1.2.1.4.1.1.7.0.1.1 = STRING: "Te1/0/1"
+.1.0.8802.1.1.2.1.4.1.1.8.0.1.1 = STRING: "to access-sw1"
+.1.0.8802.1.1.2.1.4.1.1.9.0.1.1 = STRING: "core-sw1.example.net"
+.1.0.8802.1.1.2.1.4.1.1.4.100.2.3 = INTEGER: 5
+.1.0.8802.1.1.2.1.4.1.1.5.100.2.3 = Hex-STRING: 01 0A 00 00 1E
+.1.0.8802.1.1.2.1.4.1.1.6.100.2.3 = INTEGER: 3
+.1.0.8802.1.1.2.1.4.1.1.7.100.2.3 = Hex-STRING: 70 10 6F AA BB CC
+.1.0.8802.1.1.2.1.4.1.1.9.100.2.3 = STRING: "ap-old"
+.1.0.8802.1.1.2.1.4.1.1.4.5000.2.3 = INTEGER: 5
+.1.0.8802.1.1.2.1.4.1.1.5.5000.2.3 = Hex-STRING: 01 0A 00 00 1E
+.1.0.8802.1.1.2.1.4.1.1.6.5000.2.3 = INTEGER: 3
+.1.0.8802.1.1.2.1.4.1.1.7.5000.2.3 = Hex-STRING: 70 10 6F AA BB CC
+.1.0.8802.1.1.2.1.4.1.1.9.5000.2.3 = STRING: "ap-lobby"
+.1.0.8802.1.1.2.1.4.1.1.4.200.2.2 = INTEGER: 5
+.1.0.8802.1.1.2.1.4.1.1.5.200.2.2 = Hex-STRING: 01 0A 00 00 1E
+.1.0.8802.1.1.2.1.4.1.1.6.200.2.2 = INTEGER: 3
+.1.0.8802.1.1.2.1.4.1.1.7.200.2.2 = Hex-STRING: 70 10 6F AA BB CC
+.1.0.8802.1.1.2.1.4.1.1.9.200.2.2 = STRING: "ap-reindexed"
+.1.0.8802.1.1.2.1.4.1.1.4.0.3.1 = INTEGER: 7
+.1.0.8802.1.1.2.1.4.1.1.5.0.3.1 = STRING: "oob-sw"
+.1.0.8802.1.1.2.1.4.1.1.6.0.3.1 = INTEGER: 7
+.1.0.8802.1.1.2.1.4.1.1.7.0.3.1 = STRING: "17"
+.1.0.8802.1.1.2.1.4.1.1.4.10148.7 = INTEGER: 7
+.1.0.8802.1.1.2.1.4.1.1.5.10148.7 = STRING: "SRV-42"
+.1.0.8802.1.1.2.1.4.1.1.6.10148.7 = INTEGER: 7
+.1.0.8802.1.1.2.1.4.1.1.7.10148.7 = Hex-STRING: 01 02 FF
+.1.0.8802.1.1.2.1.4.1.1.9.10148.7 = STRING: "srv42"
+.1.0.8802.1.1.2.1.4.1.1.4.0.77.1 = INTEGER: 4
+.1.0.8802.1.1.2.1.4.1.1.5.0.77.1 = Hex-STRING: 00 1A 2B 00 00 77
+.1.0.8802.1.1.2.1.4.1.1.9.bogus = STRING: "unreadable index"
+
+.1.0.8802.1.1.2.1.4.2.1.3.0.1.1.2.16.32.1.13.184.0.0.0.0.0.0.0.0.0.0.0.1 = INTEGER: 2
+.1.0.8802.1.1.2.1.4.2.1.3.0.1.1.1.4.10.0.0.1 = INTEGER: 2
+.1.0.8802.1.1.2.1.4.2.1.3.10148.7.1.4.192.168.1.50 = INTEGER: 2
+.1.0.8802.1.1.2.1.4.2.1.3.0.3.1.2.16.32.1.13.184.0.0.0.0.0.0.0.0.0.0.0.9 = INTEGER: 2
+
+.1.3.6.1.4.1.9.9.23.1.2.1.1.3.10103.1 = INTEGER: 1
+.1.3.6.1.4.1.9.9.23.1.2.1.1.4.10103.1 = Hex-STRING: 0A 00 00 02
+.1.3.6.1.4.1.9.9.23.1.2.1.1.6.10103.1 = STRING: "core-nx1(FOX1234ABCD)"
+.1.3.6.1.4.1.9.9.23.1.2.1.1.7.10103.1 = STRING: "Ethernet1/5"
+.1.3.6.1.4.1.9.9.23.1.2.1.1.8.10103.1 = STRING: "N9K-C93180YC-EX"
+.1.3.6.1.4.1.9.9.23.1.2.1.1.6.10103.2 = STRING: "phone-1001"
+.1.3.6.1.4.1.9.9.23.1.2.1.1.7.10103.2 = STRING: "Port 1"
+.1.3.6.1.4.1.9.9.23.1.2.1.1.6.10199.4 = STRING: "ghost"
+`
+
+// parseWalk reads snmpwalk output into PDUs.
+func parseWalk(t *testing.T, walk string) []gosnmp.SnmpPDU {
+	t.Helper()
+	var pdus []gosnmp.SnmpPDU
+	for _, line := range strings.Split(walk, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		oid, typed, ok := strings.Cut(line, " = ")
+		kind, value, ok2 := strings.Cut(typed, ": ")
+		if !ok || !ok2 {
+			t.Fatalf("bad walk line %q", line)
+		}
+		pdu := gosnmp.SnmpPDU{Name: oid}
+		switch kind {
+		case "STRING":
+			pdu.Type, pdu.Value = gosnmp.OctetString, []byte(strings.Trim(value, `"`))
+		case "Hex-STRING":
+			b, err := hex.DecodeString(strings.ReplaceAll(value, " ", ""))
+			if err != nil {
+				t.Fatalf("bad walk line %q: %v", line, err)
+			}
+			pdu.Type, pdu.Value = gosnmp.OctetString, b
+		case "INTEGER":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				t.Fatalf("bad walk line %q: %v", line, err)
+			}
+			pdu.Type, pdu.Value = gosnmp.Integer, n
+		case "Gauge32", "Counter32":
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				t.Fatalf("bad walk line %q: %v", line, err)
+			}
+			pdu.Type, pdu.Value = gosnmp.Gauge32, uint(n)
+			if kind == "Counter32" {
+				pdu.Type = gosnmp.Counter32
+			}
+		case "Timeticks":
+			// Timeticks: (270000) 0:45:00.00
+			ticks, _, _ := strings.Cut(strings.TrimPrefix(value, "("), ")")
+			n, err := strconv.ParseUint(ticks, 10, 32)
+			if err != nil {
+				t.Fatalf("bad walk line %q: %v", line, err)
+			}
+			pdu.Type, pdu.Value = gosnmp.TimeTicks, uint32(n)
+		default:
+			t.Fatalf("bad walk line %q", line)
+		}
+		pdus = append(pdus, pdu)
+	}
+	return pdus
+}
+
+// table groups the PDUs under base into rows the way walkTable does.
+func table(pdus []gosnmp.SnmpPDU, base string, cols ...string) map[string]map[string]gosnmp.SnmpPDU {
+	wanted := make(map[string]bool)
+	for _, c := range cols {
+		wanted[c] = true
+	}
+	rows := make(map[string]map[string]gosnmp.SnmpPDU)
+	for _, pdu := range pdus {
+		suffix, ok := strings.CutPrefix(strings.TrimPrefix(pdu.Name, "."), base+".")
+		if !ok {
+			continue
+		}
+		col, index, ok := strings.Cut(suffix, ".")
+		if !ok || !wanted[col] {
+			continue
+		}
+		if rows[index] == nil {
+			rows[index] = make(map[string]gosnmp.SnmpPDU)
+		}
+		rows[index][col] = pdu
+	}
+	return rows
+}
+
+// decodeWalk decodes a walk as queryNeighbors does.
+func decodeWalk(t *testing.T, walk string, cdp bool) []neighbor {
+	pdus := parseWalk(t, walk)
+	names := newIfNames(table(pdus, oidIfName, "1"), table(pdus, oidIfDescr, "2"))
+	neighbors := decodeLLDP(
+		table(pdus, oidLldpLocPort, "2", "3", "4"),
+		table(pdus, oidLldpRem, "4", "5", "6", "7", "8", "9"),
+		table(pdus, oidLldpRemAddr, "3"),
+		names,
+	)
+	if cdp {
+		neighbors = append(neighbors, decodeCDP(table(pdus, oidCdpCache, "3", "4", "6", "7", "8"), names)...)
+	}
+	return neighbors
+}
+
+func describe(n neighbor) string {
+	return fmt.Sprintf("%s %d %q -> %q %q desc=%q name=%q addr=%q platform=%q",
+		n.Protocol, n.LocalIfIndex, n.LocalPort, n.RemoteChassisID, n.RemotePort,
+		n.RemotePortDesc, n.RemoteSysName, n.RemoteAddress, n.Platform)
+}
+
+func TestDecodeNeighbors(t *testing.T) {
+	var got []string
+	for _, n := range decodeWalk(t, accessSwitch, true) {
+		got = append(got, describe(n))
+	}
+	want := []string{
+		// lldpLocPortId matches no interface: the port is named after it.
+		`lldp 0 "mgmt0" -> "oob-sw" "17" desc="" name="" addr="2001:db8::9" platform=""`,
+		// Neither a lldpLocPortTable row nor an interface.
+		`lldp 0 "port 77" -> "00:1a:2b:00:00:77" "" desc="" name="" addr="" platform=""`,
+		// Resolved through ifName; IPv4 preferred over the IPv6 address.
+		`lldp 10101 "Gi1/0/1" -> "00:1a:2b:3c:4d:5e" "Te1/0/1" desc="to access-sw1" name="core-sw1.example.net" addr="10.0.0.1" platform=""`,
+		// Resolved through ifDescr; the latest time mark wins and the
+		// re-indexed row of the same neighbor is dropped.
+		`lldp 10102 "Gi1/0/2" -> "10.0.0.30" "70:10:6f:aa:bb:cc" desc="" name="ap-lobby" addr="" platform=""`,
+		// No time mark, no lldpLocPortTable row: the port number is the ifIndex.
+		`lldp 10148 "Te1/1/1" -> "SRV-42" "01:02:ff" desc="" name="srv42" addr="192.168.1.50" platform=""`,
+		`cdp 10103 "Gi1/0/3" -> "core-nx1(FOX1234ABCD)" "Ethernet1/5" desc="" name="core-nx1" addr="10.0.0.2" platform="N9K-C93180YC-EX"`,
+		`cdp 10103 "Gi1/0/3" -> "phone-1001" "Port 1" desc="" name="phone-1001" addr="" platform=""`,
+		`cdp 10199 "ifIndex 10199" -> "ghost" "" desc="" name="ghost" addr="" platform=""`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("neighbors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if n := decodeWalk(t, accessSwitch, false); len(n) != 5 {
+		t.Errorf("%d neighbors without cdp, want 5", len(n))
+	}
+}
+
+func TestNeighborResultToLinkRecords(t *testing.T) {
+	p := &snmpPlugin{}
+	result := p.neighborResult(decodeWalk(t, accessSwitch, true), true)
+	metrics := result["metrics"].(map[string]interface{})
+	for key, want := range map[string]string{
+		"lldp_neighbors":             "5",
+		"cdp_neighbors":              "3",
+		"lldp_neighbor_Gi1/0/1":      "core-sw1.example.net Te1/0/1",
+		"lldp_neighbor_port 77":      "00:1a:2b:00:00:77",
+		"cdp_neighbor_Gi1/0/3":       "core-nx1 Ethernet1/5",
+		"cdp_neighbor_Gi1/0/3#2":     "phone-1001 Port 1",
+		"cdp_neighbor_ifIndex 10199": "ghost",
+	} {
+		m, ok := metrics[key].(map[string]interface{})
+		if !ok || m["value"] != want {
+			t.Errorf("%s = %v, want %q", key, metrics[key], want)
+		}
+	}
+	if m := metrics["lldp_neighbor_Gi1/0/1"].(map[string]interface{}); m["dedup"] != true || m["instance"] != "Gi1/0/1" ||
+		m["remote_address"] != "10.0.0.1" || m["local_if_index"] != 10101 {
+		t.Errorf("Gi1/0/1 = %v", m)
+	}
+	if m := metrics["cdp_neighbor_Gi1/0/3"].(map[string]interface{}); m["platform"] != "N9K-C93180YC-EX" {
+		t.Errorf("Gi1/0/3 = %v", m)
+	}
+
+	records := LinkListToRecords("access-sw1", "Access 1", "10.0.0.11", result["links"].([]map[string]interface{}))
+	if len(records) != 8 {
+		t.Fatalf("%d link records, want 8", len(records))
+	}
+	want := store.LinkRecord{
+		HostKey: "access-sw1", HostName: "Access 1", HostAddress: "10.0.0.11",
+		Protocol: "lldp", LocalIfIndex: 10101, LocalPort: "Gi1/0/1",
+		RemoteChassisID: "00:1a:2b:3c:4d:5e", RemotePort: "Te1/0/1", RemotePortDesc: "to access-sw1",
+		RemoteSysName: "core-sw1.example.net", RemoteAddress: "10.0.0.1",
+	}
+	if records[2] != want {
+		t.Errorf("record = %+v\nwant %+v", records[2], want)
+	}
+	if r := records[5]; r.Protocol != "cdp" || r.RemoteSysName != "core-nx1" || r.RemoteAddress != "10.0.0.2" {
+		t.Errorf("cdp record = %+v", r)
+	}
+
+	// A device without neighbors still reports its counts, and no links.
+	empty := p.neighborResult(nil, false)
+	if _, ok := empty["links"]; ok {
+		t.Error("links without neighbors")
+	}
+	if m := empty["metrics"].(map[string]interface{}); len(m) != 1 || m["lldp_neighbors"].(map[string]interface{})["value"] != "0" {
+		t.Errorf("empty = %v", m)
+	}
+}
+
+func TestLLDPID(t *testing.T) {
+	pdu := func(v interface{}) gosnmp.SnmpPDU { return gosnmp.SnmpPDU{Value: v} }
+	for _, tt := range []struct {
+		subtype    int
+		id         []byte
+		macSubtype int
+		want       string
+	}{
+		{4, []byte{0, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}, 4, "00:1a:2b:3c:4d:5e"},
+		{5, []byte{1, 192, 0, 2, 1}, 4, "192.0.2.1"},
+		{5, append([]byte{2}, make([]byte, 16)...), 4, "::"},
+		{3, []byte{0, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}, 3, "00:1a:2b:3c:4d:5e"},
+		{4, []byte{1, 10, 0, 0, 1}, 3, "10.0.0.1"},
+		// A chassis "MAC" of the wrong length is shown as it is.
+		{4, []byte("abc"), 4, "abc"},
+		{7, []byte("Gi0/1\x00"), 3, "Gi0/1"},
+		{7, []byte{0xff, 0xfe}, 3, "ff:fe"},
+	} {
+		if got := lldpID(pdu(tt.subtype), pdu(tt.id), tt.macSubtype); got != tt.want {
+			t.Errorf("lldpID(%d, %x, %d) = %q, want %q", tt.subtype, tt.id, tt.macSubtype, got, tt.want)
+		}
+	}
+}
+
+func TestSplitRemIndex(t *testing.T) {
+	for index, want := range map[string]string{
+		"12345.3.1": "12345 3 1 true",
+		"3.1":       "0 3 1 true",
+		"1":         "0   false",
+		"1.2.3.4":   "0   false",
+	} {
+		tm, port, rem, ok := splitRemIndex(index)
+		if got := fmt.Sprint(tm, " ", port, " ", rem, " ", ok); got != want {
+			t.Errorf("%s = %q, want %q", index, got, want)
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	plugin "observer/base"
 	"observer/plugins"
 	"observer/store"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -19,8 +18,9 @@ import (
 
 // DeviceDefinition defines the structure for SNMP device JSON files.
 type DeviceDefinition struct {
-	OIDs   []OIDDefinition   `json:"oids"`
-	Tables []TableDefinition `json:"tables"`
+	Category string            `json:"category"` // category of the device's metrics; default "snmp"
+	OIDs     []OIDDefinition   `json:"oids"`
+	Tables   []TableDefinition `json:"tables"`
 }
 
 // OIDDefinition defines a single scalar OID to query.
@@ -28,21 +28,35 @@ type OIDDefinition struct {
 	OID    string `json:"oid"`
 	Name   string `json:"name"`
 	Format string `json:"format"` // string, timeticks, integer, counter, gauge
+	ValueOptions
 }
 
 // TableDefinition describes an SNMP table to walk (e.g. ifTable).
 type TableDefinition struct {
-	BaseOID string            `json:"base_oid"` // e.g. "1.3.6.1.2.1.2.2.1"
-	Type    string            `json:"type"`     // "interface" → populates interfaces table
-	Columns []TableColumnDef  `json:"columns"`
+	BaseOID  string           `json:"base_oid"` // e.g. "1.3.6.1.2.1.2.2.1"
+	Type     string           `json:"type"`     // "interface" → populates interfaces table; "metric" (default) → one metric per row and metric column
+	Instance string           `json:"instance"` // metric tables: instance template with {index} and {<label column name>}; default the first label
+	Columns  []TableColumnDef `json:"columns"`
 }
 
 // TableColumnDef maps a column sub-OID to its name, format, and role.
 type TableColumnDef struct {
-	SubOID string `json:"sub_oid"` // numeric suffix after base_oid, e.g. "2" for ifDescr
-	Name   string `json:"name"`
-	Format string `json:"format"`
-	Role   string `json:"role"` // "name", "alias", "type", "speed", "mac", "admin_status", "oper_status", "metric"
+	SubOID    string `json:"sub_oid"` // numeric suffix after base_oid, e.g. "2" for ifDescr
+	Name      string `json:"name"`
+	Format    string `json:"format"`
+	Role      string `json:"role"`       // "name", "alias", "type", "speed", "mac", "admin_status", "oper_status", "metric", or "label" in metric tables
+	PercentOf string `json:"percent_of"` // metric: sub-OID of the row's maximum; the value is reported as a percentage of it
+	Lookup    string `json:"lookup"`     // label: column OID the value is an index into; its entry is the label
+	ValueOptions
+}
+
+// ValueOptions turn a polled value into the reported one. A value found in
+// Map is replaced; otherwise a numeric value is multiplied by Scale.
+type ValueOptions struct {
+	Type  string            `json:"type"`  // metric type: gauge (default), counter, status or text
+	Map   map[string]string `json:"map"`   // raw value → reported value, e.g. {"5": "warning"}; "*" matches any other
+	Scale float64           `json:"scale"` // e.g. 0.1 for a value in tenths of a volt
+	Unit  string            `json:"unit"`  // unit of the reported value, e.g. "V", "s" or "bytes"; not set on mapped values
 }
 
 // --- Plugin Implementation ---
@@ -79,9 +93,7 @@ func (p *snmpPlugin) OnCollect(options map[string]interface{}) (map[string]inter
 	host, _ := credentials["host"].(string)
 	if host == "" {
 		// Fallback to host address if credentials don't specify host
-		if hostMap, ok := options["host"].(map[string]interface{}); ok {
-			host, _ = hostMap["address"].(string)
-		}
+		host = plugin.TargetAddress(options)
 	}
 
 	portFloat, _ := credentials["port"].(float64)
@@ -101,13 +113,24 @@ func (p *snmpPlugin) OnCollect(options map[string]interface{}) (map[string]inter
 		deviceType = "generic"
 	}
 
-	fmt.Printf("          |_ SNMP: Querying %s:%d (community: %s, version: %s, type: %s)\n",
+	if action, _ := options["action"].(string); action == "neighbors" {
+		var opts neighborOptions
+		if raw, ok := options["options"]; ok {
+			b, _ := json.Marshal(raw)
+			if err := json.Unmarshal(b, &opts); err != nil {
+				return nil, fmt.Errorf("SNMP: invalid options: %w", err)
+			}
+		}
+		p.Controller.Printf("          |_ SNMP: Walking neighbors of %s:%d\n", host, port)
+		return p.queryNeighbors(host, port, community, version, opts)
+	}
+
+	p.Controller.Printf("          |_ SNMP: Querying %s:%d (community: %s, version: %s, type: %s)\n",
 		host, port, community, version, deviceType)
 
-	// Load device definition
-	deviceDef, err := p.loadDeviceDefinition(deviceType)
+	deviceDef, err := p.taskDefinition(deviceType, options)
 	if err != nil {
-		return nil, fmt.Errorf("SNMP: failed to load device definition: %w", err)
+		return nil, err
 	}
 
 	// Perform SNMP queries
@@ -121,7 +144,7 @@ func (p *snmpPlugin) OnCollect(options map[string]interface{}) (map[string]inter
 
 // loadDeviceDefinition loads the SNMP device definition from JSON.
 func (p *snmpPlugin) loadDeviceDefinition(deviceType string) (*DeviceDefinition, error) {
-	filename := filepath.Join("plugins", "snmp", "devices", deviceType+".json")
+	filename := plugin.DeviceFile("snmp", deviceType)
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("could not read device file %s: %w", filename, err)
@@ -135,8 +158,53 @@ func (p *snmpPlugin) loadDeviceDefinition(deviceType string) (*DeviceDefinition,
 	return &deviceDef, nil
 }
 
-// querySNMP connects to the device, queries scalar OIDs, and walks any tables.
-func (p *snmpPlugin) querySNMP(host string, port uint16, community, version string, deviceDef *DeviceDefinition) (map[string]interface{}, error) {
+// taskDefinition loads the device definition of deviceType with the task's
+// inline OIDs, from options.oids, merged over it.
+func (p *snmpPlugin) taskDefinition(deviceType string, options map[string]interface{}) (*DeviceDefinition, error) {
+	deviceDef, err := p.loadDeviceDefinition(deviceType)
+	if err != nil {
+		return nil, fmt.Errorf("SNMP: failed to load device definition: %w", err)
+	}
+	if raw, ok := options["options"]; ok {
+		var opts struct {
+			OIDs []OIDDefinition `json:"oids"`
+		}
+		b, _ := json.Marshal(raw)
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return nil, fmt.Errorf("SNMP: invalid options: %w", err)
+		}
+		for _, note := range deviceDef.mergeOIDs(opts.OIDs) {
+			p.Controller.Printf("          |_ SNMP: %s\n", note)
+		}
+	}
+	return deviceDef, nil
+}
+
+// mergeOIDs merges a task's inline OIDs over the definition's scalar OIDs: an
+// inline OID with the name of one of them replaces it, any other is added.
+// It returns a note for each replacement that changes the format.
+func (def *DeviceDefinition) mergeOIDs(inline []OIDDefinition) (notes []string) {
+	index := make(map[string]int, len(def.OIDs))
+	for i, o := range def.OIDs {
+		index[o.Name] = i
+	}
+	for _, o := range inline {
+		i, ok := index[o.Name]
+		if !ok {
+			index[o.Name] = len(def.OIDs)
+			def.OIDs = append(def.OIDs, o)
+			continue
+		}
+		if base := def.OIDs[i]; base.Format != o.Format {
+			notes = append(notes, fmt.Sprintf("%s: format %q from the task overrides %q", o.Name, o.Format, base.Format))
+		}
+		def.OIDs[i] = o
+	}
+	return notes
+}
+
+// connect returns a client connected to the device.
+func (p *snmpPlugin) connect(host string, port uint16, community, version string) (*gosnmp.GoSNMP, error) {
 	snmpClient := &gosnmp.GoSNMP{
 		Target:    host,
 		Port:      port,
@@ -150,15 +218,28 @@ func (p *snmpPlugin) querySNMP(host string, port uint16, community, version stri
 	if err != nil {
 		return nil, fmt.Errorf("SNMP connect failed: %w", err)
 	}
+	return snmpClient, nil
+}
+
+// querySNMP connects to the device, queries scalar OIDs, and walks any tables.
+func (p *snmpPlugin) querySNMP(host string, port uint16, community, version string, deviceDef *DeviceDefinition) (map[string]interface{}, error) {
+	snmpClient, err := p.connect(host, port, community, version)
+	if err != nil {
+		return nil, err
+	}
 	defer snmpClient.Conn.Close()
 
 	metrics := make(map[string]interface{})
+	category := deviceDef.Category
+	if category == "" {
+		category = "snmp"
+	}
 
 	// --- Scalar OID queries ---
 	for _, oidDef := range deviceDef.OIDs {
 		result, err := snmpClient.Get([]string{oidDef.OID})
 		if err != nil {
-			fmt.Printf("          !_ SNMP: Failed to query OID %s (%s): %v\n", oidDef.OID, oidDef.Name, err)
+			p.Controller.Printf("          !_ SNMP: Failed to query OID %s (%s): %v\n", oidDef.OID, oidDef.Name, err)
 			continue
 		}
 
@@ -166,19 +247,14 @@ func (p *snmpPlugin) querySNMP(host string, port uint16, community, version stri
 			continue
 		}
 
-		variable := result.Variables[0]
-		value := p.formatValue(variable, oidDef.Format)
-
-		metricKey := strings.ReplaceAll(oidDef.Name, " ", "_")
-		metrics[metricKey] = map[string]interface{}{
-			"category": "snmp",
-			"name":     oidDef.Name,
-			"value":    value,
-			"type":     "gauge",
-			"oid":      oidDef.OID,
+		m, ok := p.scalarMetric(oidDef, result.Variables[0], category)
+		if !ok {
+			p.Controller.Printf("          !_ SNMP: %s (%s) is not supported by the device\n", oidDef.Name, oidDef.OID)
+			continue
 		}
+		metrics[strings.ReplaceAll(oidDef.Name, " ", "_")] = m
 
-		fmt.Printf("          |_ SNMP: %s = %v\n", oidDef.Name, value)
+		p.Controller.Printf("          |_ SNMP: %s = %v\n", oidDef.Name, m["value"])
 	}
 
 	// --- Table walks ---
@@ -187,7 +263,7 @@ func (p *snmpPlugin) querySNMP(host string, port uint16, community, version stri
 	for _, tableDef := range deviceDef.Tables {
 		rows, err := p.walkTable(snmpClient, tableDef)
 		if err != nil {
-			fmt.Printf("          !_ SNMP: table walk %s failed: %v\n", tableDef.BaseOID, err)
+			p.Controller.Printf("          !_ SNMP: table walk %s failed: %v\n", tableDef.BaseOID, err)
 			continue
 		}
 
@@ -198,6 +274,11 @@ func (p *snmpPlugin) querySNMP(host string, port uint16, community, version stri
 			for k, v := range ifMetrics {
 				metrics[k] = v
 			}
+		case "", "metric":
+			lookups := p.walkLookups(snmpClient, tableDef)
+			for k, v := range p.processMetricTable(rows, tableDef, lookups, category) {
+				metrics[k] = v
+			}
 		}
 	}
 
@@ -208,6 +289,26 @@ func (p *snmpPlugin) querySNMP(host string, port uint16, community, version stri
 	return result, nil
 }
 
+// scalarMetric is the metric for the value polled from a scalar OID, or
+// false when the device does not support the OID.
+func (p *snmpPlugin) scalarMetric(oidDef OIDDefinition, variable gosnmp.SnmpPDU, category string) (map[string]interface{}, bool) {
+	if variable.Type == gosnmp.NoSuchObject || variable.Type == gosnmp.NoSuchInstance {
+		return nil, false
+	}
+	value, mapped := oidDef.apply(p.formatValue(variable, oidDef.Format))
+	m := map[string]interface{}{
+		"category": category,
+		"name":     oidDef.Name,
+		"value":    value,
+		"type":     oidDef.metricType(),
+		"oid":      oidDef.OID,
+	}
+	if !mapped && oidDef.Unit != "" {
+		m["unit"] = oidDef.Unit
+	}
+	return m, true
+}
+
 // walkTable performs a BulkWalk on the table's base OID and groups PDUs by row index.
 // Returns map[rowIndex]map[subOID]SnmpPDU.
 func (p *snmpPlugin) walkTable(client *gosnmp.GoSNMP, table TableDefinition) (map[string]map[string]gosnmp.SnmpPDU, error) {
@@ -226,6 +327,9 @@ func (p *snmpPlugin) walkTable(client *gosnmp.GoSNMP, table TableDefinition) (ma
 	wantedCols := make(map[string]bool, len(table.Columns))
 	for _, col := range table.Columns {
 		wantedCols[col.SubOID] = true
+		if col.PercentOf != "" {
+			wantedCols[col.PercentOf] = true
+		}
 	}
 
 	rows := make(map[string]map[string]gosnmp.SnmpPDU)
@@ -308,7 +412,7 @@ func (p *snmpPlugin) processInterfaceTable(
 				// Time-series counter — stored in metrics with instance=ifName.
 				// Key includes instance so multiple interfaces don't collide.
 				metricKey := fmt.Sprintf("%s_%s", col.Name, rowIndex)
-				metrics[metricKey] = map[string]interface{}{
+				m := map[string]interface{}{
 					"category": "snmp",
 					"name":     col.Name,
 					"value":    fmt.Sprintf("%v", value),
@@ -316,11 +420,15 @@ func (p *snmpPlugin) processInterfaceTable(
 					"oid":      pdu.Name,
 					"instance": ifName,
 				}
-				fmt.Printf("          |_ SNMP: %s[%s] = %v\n", col.Name, ifName, value)
+				if col.Unit != "" {
+					m["unit"] = col.Unit
+				}
+				metrics[metricKey] = m
+				p.Controller.Printf("          |_ SNMP: %s[%s] = %v\n", col.Name, ifName, value)
 			}
 		}
 
-		fmt.Printf("          |_ SNMP interface: idx=%s name=%v admin=%v oper=%v\n",
+		p.Controller.Printf("          |_ SNMP interface: idx=%s name=%v admin=%v oper=%v\n",
 			rowIndex, iface["name"], iface["admin_status"], iface["oper_status"])
 		interfaces = append(interfaces, iface)
 	}
@@ -0,0 +1,211 @@
+package snmp
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+
+	plugin "observer/base"
+)
+
+// metricType returns the metric type the options declare, "gauge" by default.
+func (o ValueOptions) metricType() string {
+	if o.Type == "" {
+		return "gauge"
+	}
+	return o.Type
+}
+
+// apply maps or scales a formatted value. It reports whether Map replaced it.
+func (o ValueOptions) apply(value interface{}) (interface{}, bool) {
+	raw := fmt.Sprint(value)
+	if mapped, ok := o.Map[raw]; ok {
+		return mapped, true
+	}
+	if mapped, ok := o.Map["*"]; ok {
+		return mapped, true
+	}
+	if o.Scale != 0 {
+		if f, ok := toFloat(value); ok {
+			return round6(f * o.Scale), false
+		}
+	}
+	return value, false
+}
+
+// toFloat returns a formatted numeric value, or a numeric string, as a float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// round6 rounds to six decimals, dropping float noise such as 23.400000000000002.
+func round6(f float64) float64 {
+	return math.Round(f*1e6) / 1e6
+}
+
+// walkLookups walks the columns that label columns of table look their values
+// up in, once each, and returns them keyed by column OID, then index.
+func (p *snmpPlugin) walkLookups(client *gosnmp.GoSNMP, table TableDefinition) map[string]map[string]string {
+	lookups := make(map[string]map[string]string)
+	for _, col := range table.Columns {
+		oid := strings.Trim(col.Lookup, ".")
+		if oid == "" || lookups[oid] != nil {
+			continue
+		}
+		pdus, err := client.BulkWalkAll(oid)
+		if err != nil {
+			pdus, err = client.WalkAll(oid)
+		}
+		if err != nil {
+			p.Controller.Printf("          !_ SNMP: lookup walk %s failed: %v\n", oid, err)
+		}
+		lookups[oid] = p.lookupEntries(oid, pdus)
+	}
+	return lookups
+}
+
+// lookupEntries returns the string values of a walked lookup column keyed by
+// their index under oid.
+func (p *snmpPlugin) lookupEntries(oid string, pdus []gosnmp.SnmpPDU) map[string]string {
+	entries := make(map[string]string, len(pdus))
+	for _, pdu := range pdus {
+		index, ok := strings.CutPrefix(strings.TrimPrefix(pdu.Name, "."), oid+".")
+		if ok {
+			entries[index] = fmt.Sprint(p.formatValue(pdu, "string"))
+		}
+	}
+	return entries
+}
+
+// lookupLabel finds value as an index of a lookup column: first under the
+// row's parent index, all of rowIndex but its last component (Printer-MIB
+// tables are all indexed by hrDeviceIndex first), then on its own.
+func lookupLabel(entries map[string]string, rowIndex, value string) (string, bool) {
+	if dot := strings.LastIndex(rowIndex, "."); dot >= 0 {
+		if label, ok := entries[rowIndex[:dot]+"."+value]; ok {
+			return label, true
+		}
+	}
+	label, ok := entries[value]
+	return label, ok
+}
+
+// processMetricTable converts walked rows of a metric table into one metric
+// per row and metric column. The row's label columns name its instance and are
+// attached to each of its metrics under their column names.
+func (p *snmpPlugin) processMetricTable(
+	rows map[string]map[string]gosnmp.SnmpPDU,
+	table TableDefinition,
+	lookups map[string]map[string]string,
+	category string,
+) map[string]interface{} {
+	metrics := make(map[string]interface{})
+
+	indexes := make([]string, 0, len(rows))
+	for rowIndex := range rows {
+		indexes = append(indexes, rowIndex)
+	}
+	sort.Strings(indexes)
+
+	for _, rowIndex := range indexes {
+		colPDUs := rows[rowIndex]
+
+		// --- Labels ---
+		labels := make(map[string]string)
+		instance := ""
+		for _, col := range table.Columns {
+			pdu, ok := colPDUs[col.SubOID]
+			if col.Role != "label" || !ok {
+				continue
+			}
+			label := fmt.Sprint(p.formatValue(pdu, col.Format))
+			if col.Lookup != "" {
+				// An index with no entry, such as colorant 0 for "none", names nothing.
+				l, ok := lookupLabel(lookups[strings.Trim(col.Lookup, ".")], rowIndex, label)
+				if !ok {
+					continue
+				}
+				label = l
+			}
+			if mapped, ok := col.apply(label); ok {
+				label = fmt.Sprint(mapped)
+			}
+			labels[col.Name] = strings.TrimSpace(label)
+			if instance == "" {
+				instance = labels[col.Name]
+			}
+		}
+		if table.Instance != "" {
+			instance = table.Instance
+			for name, label := range labels {
+				instance = strings.ReplaceAll(instance, "{"+name+"}", label)
+			}
+			instance = strings.ReplaceAll(instance, "{index}", rowIndex)
+		}
+		if instance == "" {
+			instance = rowIndex
+		}
+
+		// --- Metrics ---
+		for _, col := range table.Columns {
+			pdu, ok := colPDUs[col.SubOID]
+			if col.Role != "metric" || !ok {
+				continue
+			}
+			value, mapped := col.apply(p.formatValue(pdu, col.Format))
+			unit := col.Unit
+			if col.PercentOf != "" && !mapped {
+				level, ok1 := toFloat(value)
+				maxPDU, ok2 := colPDUs[col.PercentOf]
+				capacity, ok3 := toFloat(p.formatValue(maxPDU, "integer"))
+				if !ok1 || !ok2 || !ok3 || level < 0 || capacity <= 0 {
+					p.Controller.Printf("          !_ SNMP: %s[%s] = %v of %v, no percentage\n", col.Name, instance, value, p.formatValue(maxPDU, "integer"))
+					continue
+				}
+				value = math.Round(level/capacity*1000) / 10
+				unit = plugin.UnitPercent
+			}
+
+			m := map[string]interface{}{
+				"category": category,
+				"name":     col.Name,
+				"value":    fmt.Sprintf("%v", value),
+				"type":     col.metricType(),
+				"oid":      pdu.Name,
+				"instance": instance,
+			}
+			if !mapped && unit != "" {
+				m["unit"] = unit
+			}
+			for name, label := range labels {
+				if _, taken := m[name]; !taken {
+					m[name] = label
+				}
+			}
+			metrics[fmt.Sprintf("%s_%s", strings.ReplaceAll(col.Name, " ", "_"), rowIndex)] = m
+			p.Controller.Printf("          |_ SNMP: %s[%s] = %v\n", col.Name, instance, value)
+		}
+	}
+	return metrics
+}
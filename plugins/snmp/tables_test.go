@@ -0,0 +1,294 @@
+package snmp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// officePrinter is a walk of a colour laser printer. The colorant of each
+// supply is an index into prtMarkerColorantTable; the drum and the waste
+// toner box have none (0). The drum's capacity is unknown (-2), and the
+// yellow cartridge only reports "some remaining" (-3).
+const officePrinter = `
+.1.3.6.1.2.1.1.1.0 = STRING: "HP Color LaserJet Pro M454dw"
+.1.3.6.1.2.1.1.3.0 = Timeticks: (9000000) 1 day, 1:00:00.00
+.1.3.6.1.2.1.1.5.0 = STRING: "office-printer"
+.1.3.6.1.2.1.25.3.2.1.5.1 = INTEGER: 3
+.1.3.6.1.2.1.25.3.5.1.1.1 = INTEGER: 4
+.1.3.6.1.2.1.43.10.2.1.4.1.1 = Counter32: 48213
+
+.1.3.6.1.2.1.43.11.1.1.3.1.1 = INTEGER: 1
+.1.3.6.1.2.1.43.11.1.1.3.1.2 = INTEGER: 2
+.1.3.6.1.2.1.43.11.1.1.3.1.3 = INTEGER: 3
+.1.3.6.1.2.1.43.11.1.1.3.1.4 = INTEGER: 4
+.1.3.6.1.2.1.43.11.1.1.3.1.5 = INTEGER: 0
+.1.3.6.1.2.1.43.11.1.1.3.1.6 = INTEGER: 0
+.1.3.6.1.2.1.43.11.1.1.4.1.1 = INTEGER: 4
+.1.3.6.1.2.1.43.11.1.1.5.1.1 = INTEGER: 21
+.1.3.6.1.2.1.43.11.1.1.5.1.2 = INTEGER: 21
+.1.3.6.1.2.1.43.11.1.1.5.1.3 = INTEGER: 21
+.1.3.6.1.2.1.43.11.1.1.5.1.4 = INTEGER: 21
+.1.3.6.1.2.1.43.11.1.1.5.1.5 = INTEGER: 9
+.1.3.6.1.2.1.43.11.1.1.5.1.6 = INTEGER: 4
+.1.3.6.1.2.1.43.11.1.1.6.1.1 = STRING: "Black Cartridge HP W2030A"
+.1.3.6.1.2.1.43.11.1.1.6.1.2 = STRING: "Cyan Cartridge HP W2031A"
+.1.3.6.1.2.1.43.11.1.1.6.1.3 = STRING: "Magenta Cartridge HP W2033A"
+.1.3.6.1.2.1.43.11.1.1.6.1.4 = STRING: "Yellow Cartridge HP W2032A"
+.1.3.6.1.2.1.43.11.1.1.6.1.5 = STRING: "Imaging Drum"
+.1.3.6.1.2.1.43.11.1.1.6.1.6 = STRING: "Toner Collection Unit "
+.1.3.6.1.2.1.43.11.1.1.8.1.1 = INTEGER: 2400
+.1.3.6.1.2.1.43.11.1.1.8.1.2 = INTEGER: 2100
+.1.3.6.1.2.1.43.11.1.1.8.1.3 = INTEGER: 2100
+.1.3.6.1.2.1.43.11.1.1.8.1.4 = INTEGER: 2100
+.1.3.6.1.2.1.43.11.1.1.8.1.5 = INTEGER: -2
+.1.3.6.1.2.1.43.11.1.1.8.1.6 = INTEGER: 100
+.1.3.6.1.2.1.43.11.1.1.9.1.1 = INTEGER: 600
+.1.3.6.1.2.1.43.11.1.1.9.1.2 = INTEGER: 2100
+.1.3.6.1.2.1.43.11.1.1.9.1.3 = INTEGER: 105
+.1.3.6.1.2.1.43.11.1.1.9.1.4 = INTEGER: -3
+.1.3.6.1.2.1.43.11.1.1.9.1.5 = INTEGER: 80
+.1.3.6.1.2.1.43.11.1.1.9.1.6 = INTEGER: 40
+
+.1.3.6.1.2.1.43.12.1.1.4.1.1 = STRING: "black"
+.1.3.6.1.2.1.43.12.1.1.4.1.2 = STRING: "cyan"
+.1.3.6.1.2.1.43.12.1.1.4.1.3 = STRING: "magenta"
+.1.3.6.1.2.1.43.12.1.1.4.1.4 = STRING: "yellow"
+`
+
+// rackUPS is a walk of a UPS-MIB agent two minutes into a power cut: the
+// input is gone and the battery temperature is not supported.
+const rackUPS = `
+.1.3.6.1.2.1.33.1.1.2.0 = STRING: "Eaton 5PX 1500"
+.1.3.6.1.2.1.33.1.2.1.0 = INTEGER: 2
+.1.3.6.1.2.1.33.1.4.1.0 = INTEGER: 5
+.1.3.6.1.2.1.33.1.2.4.0 = INTEGER: 87
+.1.3.6.1.2.1.33.1.2.3.0 = INTEGER: 34
+.1.3.6.1.2.1.33.1.2.2.0 = INTEGER: 125
+.1.3.6.1.2.1.33.1.2.5.0 = INTEGER: 273
+
+.1.3.6.1.2.1.33.1.3.3.1.2.1 = INTEGER: 0
+.1.3.6.1.2.1.33.1.3.3.1.3.1 = INTEGER: 0
+.1.3.6.1.2.1.33.1.4.4.1.2.1 = INTEGER: 230
+.1.3.6.1.2.1.33.1.4.4.1.5.1 = INTEGER: 42
+`
+
+// apcUPS is a walk of an APC PowerNet agent on line power. Its high
+// precision values are in tenths, the runtime in timeticks.
+const apcUPS = `
+.1.3.6.1.4.1.318.1.1.1.1.1.1.0 = STRING: "Smart-UPS X 2200"
+.1.3.6.1.4.1.318.1.1.1.2.1.1.0 = INTEGER: 2
+.1.3.6.1.4.1.318.1.1.1.4.1.1.0 = INTEGER: 2
+.1.3.6.1.4.1.318.1.1.1.2.3.1.0 = Gauge32: 1000
+.1.3.6.1.4.1.318.1.1.1.2.2.3.0 = Timeticks: (270000) 0:45:00.00
+.1.3.6.1.4.1.318.1.1.1.2.2.2.0 = Gauge32: 24
+.1.3.6.1.4.1.318.1.1.1.3.3.1.0 = Gauge32: 2301
+.1.3.6.1.4.1.318.1.1.1.4.3.1.0 = Gauge32: 2298
+.1.3.6.1.4.1.318.1.1.1.4.3.3.0 = Gauge32: 215
+`
+
+// loadDefinition reads a device definition shipped in devices/.
+func loadDefinition(t *testing.T, name string) *DeviceDefinition {
+	t.Helper()
+	data, err := os.ReadFile("devices/" + name + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var def DeviceDefinition
+	if err := json.Unmarshal(data, &def); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return &def
+}
+
+// decodeDevice decodes a walk against a definition as querySNMP does: an OID
+// missing from the walk is not supported by the device.
+func decodeDevice(t *testing.T, def *DeviceDefinition, walk string) map[string]interface{} {
+	t.Helper()
+	p := &snmpPlugin{}
+	pdus := parseWalk(t, walk)
+	byOID := make(map[string]gosnmp.SnmpPDU, len(pdus))
+	for _, pdu := range pdus {
+		byOID[pdu.Name] = pdu
+	}
+	category := def.Category
+	if category == "" {
+		category = "snmp"
+	}
+
+	metrics := make(map[string]interface{})
+	for _, o := range def.OIDs {
+		variable, ok := byOID[o.OID]
+		if !ok {
+			variable = gosnmp.SnmpPDU{Name: o.OID, Type: gosnmp.NoSuchObject}
+		}
+		if m, ok := p.scalarMetric(o, variable, category); ok {
+			metrics[strings.ReplaceAll(o.Name, " ", "_")] = m
+		}
+	}
+	for _, td := range def.Tables {
+		var cols []string
+		lookups := make(map[string]map[string]string)
+		for _, c := range td.Columns {
+			cols = append(cols, c.SubOID)
+			if c.PercentOf != "" {
+				cols = append(cols, c.PercentOf)
+			}
+			if oid := strings.Trim(c.Lookup, "."); oid != "" {
+				lookups[oid] = p.lookupEntries(oid, pdus)
+			}
+		}
+		rows := table(pdus, strings.Trim(td.BaseOID, "."), cols...)
+		for k, v := range p.processMetricTable(rows, td, lookups, category) {
+			metrics[k] = v
+		}
+	}
+	return metrics
+}
+
+// summarize lists metrics as "key: value unit [instance] label=value..."
+// lines, sorted by key.
+func summarize(metrics map[string]interface{}) []string {
+	var out []string
+	for key, mAny := range metrics {
+		m := mAny.(map[string]interface{})
+		line := fmt.Sprintf("%s: %v", key, m["value"])
+		if u, ok := m["unit"]; ok {
+			line += " " + fmt.Sprint(u)
+		}
+		line += " " + fmt.Sprint(m["type"])
+		if inst, ok := m["instance"]; ok {
+			line += fmt.Sprintf(" [%v]", inst)
+		}
+		var labels []string
+		for k, v := range m {
+			switch k {
+			case "category", "name", "value", "type", "oid", "instance", "unit":
+				continue
+			}
+			labels = append(labels, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(labels)
+		if len(labels) > 0 {
+			line += " " + strings.Join(labels, " ")
+		}
+		out = append(out, line)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestDeviceDefinitions(t *testing.T) {
+	for _, tt := range []struct {
+		definition, walk string
+		want             []string
+	}{
+		{"printer", officePrinter, []string{
+			"Device_Status: warning status",
+			"Page_Count: 48213 counter",
+			"Printer_Status: printing text",
+			"Supply_Level_1.1: 25 % gauge [Black Cartridge HP W2030A] colorant=black description=Black Cartridge HP W2030A supply_type=tonerCartridge",
+			"Supply_Level_1.2: 100 % gauge [Cyan Cartridge HP W2031A] colorant=cyan description=Cyan Cartridge HP W2031A supply_type=tonerCartridge",
+			"Supply_Level_1.3: 5 % gauge [Magenta Cartridge HP W2033A] colorant=magenta description=Magenta Cartridge HP W2033A supply_type=tonerCartridge",
+			// A special level is mapped, and no percentage of anything.
+			"Supply_Level_1.4: some remaining gauge [Yellow Cartridge HP W2032A] colorant=yellow description=Yellow Cartridge HP W2032A supply_type=tonerCartridge",
+			// The drum's unknown capacity gives no level at all; the
+			// waste box has no colorant.
+			"Supply_Level_1.6: 40 % gauge [Toner Collection Unit] description=Toner Collection Unit supply_type=wasteToner",
+			"System_Description: HP Color LaserJet Pro M454dw text",
+			"System_Name: office-printer text",
+			"Up_Time: 1d 1h 0m 0s text",
+		}},
+		{"ups", rackUPS, []string{
+			"Battery_Charge: 87 % percent",
+			"Battery_Status: up status",
+			"Battery_Voltage: 27.3 V gauge",
+			"Input_Frequency_1: 0 Hz gauge [input 1]",
+			"Input_Voltage_1: 0 V gauge [input 1]",
+			"Output_Load_1: 42 % percent [output 1]",
+			"Output_Source: warning status",
+			"Output_Voltage_1: 230 V gauge [output 1]",
+			"Runtime_Remaining_(min): 34 min gauge",
+			"Seconds_On_Battery: 125 s gauge",
+			"UPS_Model: Eaton 5PX 1500 text",
+		}},
+		{"ups-apc", apcUPS, []string{
+			"Battery_Charge: 100 % percent",
+			"Battery_Status: up status",
+			"Battery_Temperature: 24 °C gauge",
+			"Input_Voltage: 230.1 V gauge",
+			"Output_Load: 21.5 % percent",
+			"Output_Status: up status",
+			"Output_Voltage: 229.8 V gauge",
+			"Runtime_Remaining_(min): 45 min gauge",
+			"UPS_Model: Smart-UPS X 2200 text",
+		}},
+	} {
+		def := loadDefinition(t, tt.definition)
+		got := summarize(decodeDevice(t, def, tt.walk))
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s:\n%s\nwant:\n%s", tt.definition, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestDeviceCategories(t *testing.T) {
+	for name, want := range map[string]string{"printer": "printer", "ups": "power", "ups-apc": "power", "generic": "snmp"} {
+		metrics := decodeDevice(t, loadDefinition(t, name), officePrinter+rackUPS+apcUPS)
+		if len(metrics) == 0 {
+			t.Errorf("%s: no metrics", name)
+		}
+		for key, m := range metrics {
+			if c := m.(map[string]interface{})["category"]; c != want {
+				t.Errorf("%s %s: category %v, want %s", name, key, c, want)
+			}
+		}
+	}
+}
+
+func TestLookupLabel(t *testing.T) {
+	entries := map[string]string{"1.1": "black", "1.2": "cyan", "3": "spot"}
+	for _, tt := range []struct {
+		rowIndex, value, want string
+		ok                    bool
+	}{
+		{"1.4", "1", "black", true}, // under the row's parent index
+		{"1.4", "3", "spot", true},  // on its own
+		{"4", "2", "", false},       // a row index without a parent
+		{"1.4", "0", "", false},
+	} {
+		if got, ok := lookupLabel(entries, tt.rowIndex, tt.value); got != tt.want || ok != tt.ok {
+			t.Errorf("%s %s = %q, %v", tt.rowIndex, tt.value, got, ok)
+		}
+	}
+}
+
+func TestValueOptions(t *testing.T) {
+	for _, tt := range []struct {
+		opts   ValueOptions
+		value  interface{}
+		want   string
+		mapped bool
+	}{
+		{ValueOptions{Map: map[string]string{"2": "up", "*": "warning"}}, 2, "up", true},
+		{ValueOptions{Map: map[string]string{"2": "up", "*": "warning"}}, 7, "warning", true},
+		{ValueOptions{Map: map[string]string{"-3": "some remaining"}, Scale: 0.1}, 500, "50", false},
+		{ValueOptions{Scale: 0.1}, uint(2301), "230.1", false},
+		{ValueOptions{Scale: 0.1}, "234", "23.4", false},
+		{ValueOptions{Scale: 0.1}, "n/a", "n/a", false},
+		{ValueOptions{}, 42, "42", false},
+	} {
+		got, mapped := tt.opts.apply(tt.value)
+		if fmt.Sprint(got) != tt.want || mapped != tt.mapped {
+			t.Errorf("%+v %v = %v, %v", tt.opts, tt.value, got, mapped)
+		}
+	}
+	if (ValueOptions{}).metricType() != "gauge" || (ValueOptions{Type: "status"}).metricType() != "status" {
+		t.Error("metricType")
+	}
+}
@@ -0,0 +1,192 @@
+package sshcollect
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// shell is an interactive shell commands are sent to; InteractiveSession is one.
+type shell interface {
+	Send(cmd string) error
+	WaitFor(pattern string) (string, error)
+	Close()
+}
+
+// orderedCommands returns the names of a group's commands in the order they
+// run: highest priority first, then by name, so the order is the same on
+// every run and cheap commands can be put before slow ones.
+func orderedCommands(group map[string]CommandDef) []string {
+	names := make([]string, 0, len(group))
+	for name := range group {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := group[names[i]], group[names[j]]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// runCommand sends one command and waits for its prompt. A timeout only
+// warns: whatever was read is the command's output, and the next command
+// still runs. Exit and logout commands are not waited for and have no output.
+func (p *sshCollectPlugin) runCommand(sh shell, name string, cmd CommandDef, label string) (output string, keep bool, err error) {
+	p.Controller.Printf("  |_ %s: Running SSH command: %s\n", label, cmd.Command)
+	if err := sh.Send(cmd.Command); err != nil {
+		return "", false, err
+	}
+	// For exit and logout commands, do not wait for a prompt as the session will close.
+	if name == "exit" || name == "logout" {
+		return "", false, nil
+	}
+	output, err = sh.WaitFor(cmd.WaitFor)
+	if err != nil {
+		p.Controller.Printf("            !_ %s | Warning: %v\n", label, err)
+	}
+	return output, true, nil
+}
+
+// runGroup runs a group's commands in order on one shell, adding their output to results.
+func (p *sshCollectPlugin) runGroup(sh shell, group map[string]CommandDef, label string, results map[string]string) error {
+	for _, name := range orderedCommands(group) {
+		output, keep, err := p.runCommand(sh, name, group[name], label)
+		if err != nil {
+			return err
+		}
+		if keep {
+			results[name] = output
+		}
+	}
+	return nil
+}
+
+// runCommandGroups runs the prelude, info and outro commands of a device and
+// returns the raw output per command name. With parallel_sessions above 1 it
+// opens that many shells in all with openShell, each running the prelude,
+// and the info commands are shared out among them: every shell takes the
+// next command in order as soon as it is free, so a slow command does not
+// hold the others up. Results are keyed by command name, so they merge the
+// same however the commands were shared out. Shells the device refuses are
+// done without; if a shell fails mid-run its command goes back to the others.
+func (p *sshCollectPlugin) runCommandGroups(sess shell, openShell func() (shell, error), def *DeviceDef, hostLabel string) (map[string]string, error) {
+	results := make(map[string]string)
+	if err := p.runGroup(sess, def.Prelude, hostLabel, results); err != nil {
+		return nil, err
+	}
+
+	shells := []shell{sess}
+	for i := 1; i < def.ParallelSessions; i++ {
+		label := fmt.Sprintf("%s (session %d)", hostLabel, i+1)
+		sh, err := openShell()
+		if err != nil {
+			p.Controller.Printf("            !_ %s | extra SSH channel refused (%v); running on %d session(s)\n", hostLabel, err, len(shells))
+			break
+		}
+		if err := p.runGroup(sh, def.Prelude, label, make(map[string]string)); err != nil {
+			p.Controller.Printf("            !_ %s | prelude failed (%v); not using this session\n", label, err)
+			sh.Close()
+			continue
+		}
+		shells = append(shells, sh)
+	}
+
+	if len(shells) == 1 {
+		if err := p.runGroup(sess, def.Info, hostLabel, results); err != nil {
+			return nil, err
+		}
+	} else if err := p.runParallel(shells, def.Info, hostLabel, results); err != nil {
+		return nil, err
+	}
+
+	// Leave the extra shells first; the outro of the first one may close the connection.
+	for i := len(shells) - 1; i >= 1; i-- {
+		label := fmt.Sprintf("%s (session %d)", hostLabel, i+1)
+		if err := p.runGroup(shells[i], def.Outro, label, make(map[string]string)); err != nil {
+			p.Controller.Printf("            !_ %s | Warning: %v\n", label, err)
+		}
+		shells[i].Close()
+	}
+	if err := p.runGroup(sess, def.Outro, hostLabel, results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// runParallel shares the commands of group out among shells. A shell whose
+// Send fails stops taking commands and its command is run by another; the
+// error is only returned when no shell is left to run it.
+func (p *sshCollectPlugin) runParallel(shells []shell, group map[string]CommandDef, hostLabel string, results map[string]string) error {
+	var (
+		mu      sync.Mutex
+		queue   = orderedCommands(group)
+		failed  = make([]bool, len(shells))
+		lastErr error
+		wg      sync.WaitGroup
+	)
+	next := func() (string, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(queue) == 0 {
+			return "", false
+		}
+		name := queue[0]
+		queue = queue[1:]
+		return name, true
+	}
+
+	for i, sh := range shells {
+		label := hostLabel
+		if i > 0 {
+			label = fmt.Sprintf("%s (session %d)", hostLabel, i+1)
+		}
+		wg.Add(1)
+		go func(i int, sh shell, label string) {
+			defer wg.Done()
+			for {
+				name, ok := next()
+				if !ok {
+					return
+				}
+				output, keep, err := p.runCommand(sh, name, group[name], label)
+				mu.Lock()
+				if err != nil {
+					failed[i], lastErr = true, err
+					queue = append([]string{name}, queue...)
+					mu.Unlock()
+					p.Controller.Printf("            !_ %s | session failed (%v); its commands go to the others\n", label, err)
+					return
+				}
+				if keep {
+					results[name] = output
+				}
+				mu.Unlock()
+			}
+		}(i, sh, label)
+	}
+	wg.Wait()
+
+	// The other shells may have finished before a failed one gave its
+	// command back: run what is left on the first shell still working.
+	if len(queue) == 0 {
+		return nil
+	}
+	for i, sh := range shells {
+		if failed[i] {
+			continue
+		}
+		left := make(map[string]CommandDef, len(queue))
+		for _, name := range queue {
+			left[name] = group[name]
+		}
+		label := hostLabel
+		if i > 0 {
+			label = fmt.Sprintf("%s (session %d)", hostLabel, i+1)
+		}
+		return p.runGroup(sh, left, label, results)
+	}
+	return lastErr
+}
@@ -0,0 +1,242 @@
+package sshcollect
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeShell answers every command with "<command> output". A command in
+// block waits for its channel to close; with failAfter set, Send fails once
+// that many commands were sent.
+type fakeShell struct {
+	mu        sync.Mutex
+	sent      []string
+	last      string
+	block     map[string]chan struct{}
+	failAfter int
+	failing   bool
+	closed    bool
+}
+
+func (s *fakeShell) Send(cmd string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failAfter > 0 && len(s.sent) >= s.failAfter {
+		s.failing = true
+		return errors.New("channel closed")
+	}
+	s.sent = append(s.sent, cmd)
+	s.last = cmd
+	return nil
+}
+
+func (s *fakeShell) WaitFor(pattern string) (string, error) {
+	s.mu.Lock()
+	cmd, wait := s.last, s.block[s.last]
+	s.mu.Unlock()
+	if wait != nil {
+		select {
+		case <-wait:
+		case <-time.After(5 * time.Second):
+			return cmd + " partial", fmt.Errorf("timeout waiting for %q", pattern)
+		}
+	}
+	if cmd == "show slow" {
+		return cmd + " partial", fmt.Errorf("timeout waiting for %q", pattern)
+	}
+	return cmd + " output", nil
+}
+
+func (s *fakeShell) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+func (s *fakeShell) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+// testDevice has a prelude, five info commands with priorities and an outro.
+func testDevice(parallel int) *DeviceDef {
+	cmd := func(c string, priority int) CommandDef {
+		return CommandDef{Command: c, WaitFor: "#", Priority: priority}
+	}
+	return &DeviceDef{
+		Prelude: map[string]CommandDef{"paging": cmd("terminal length 0", 0)},
+		Info: map[string]CommandDef{
+			"tech":    cmd("show tech", -1),
+			"version": cmd("show version", 10),
+			"uptime":  cmd("show uptime", 10),
+			"ifaces":  cmd("show interfaces", 0),
+			"env":     cmd("show environment", 0),
+		},
+		Outro:            map[string]CommandDef{"exit": cmd("exit", 0)},
+		ParallelSessions: parallel,
+	}
+}
+
+// wantResults is the output of testDevice's commands, however they were run.
+var wantResults = map[string]string{
+	"paging":  "terminal length 0 output",
+	"tech":    "show tech output",
+	"version": "show version output",
+	"uptime":  "show uptime output",
+	"ifaces":  "show interfaces output",
+	"env":     "show environment output",
+}
+
+func TestOrderedCommands(t *testing.T) {
+	got := orderedCommands(testDevice(1).Info)
+	if want := []string{"uptime", "version", "env", "ifaces", "tech"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %q, want %q", got, want)
+	}
+}
+
+func TestRunCommandGroupsOneSession(t *testing.T) {
+	for _, parallel := range []int{0, 1} {
+		sh := &fakeShell{}
+		opened := 0
+		open := func() (shell, error) { opened++; return &fakeShell{}, nil }
+		p := &sshCollectPlugin{}
+		results, err := p.runCommandGroups(sh, open, testDevice(parallel), "sw1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(results, wantResults) {
+			t.Errorf("parallel_sessions %d: results = %v", parallel, results)
+		}
+		want := []string{"terminal length 0", "show uptime", "show version", "show environment", "show interfaces", "show tech", "exit"}
+		if got := sh.commands(); !reflect.DeepEqual(got, want) || opened != 0 {
+			t.Errorf("parallel_sessions %d: sent %q on %d extra shells", parallel, got, opened)
+		}
+	}
+}
+
+func TestTimeoutKeepsPartialOutput(t *testing.T) {
+	def := testDevice(1)
+	def.Info["slow"] = CommandDef{Command: "show slow", WaitFor: "#", Priority: 5}
+	sh := &fakeShell{}
+	results, err := (&sshCollectPlugin{}).runCommandGroups(sh, nil, def, "sw1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The slow command's output so far is kept, and the commands after it still run.
+	if results["slow"] != "show slow partial" || results["tech"] != "show tech output" {
+		t.Errorf("results = %v", results)
+	}
+}
+
+func TestRunCommandGroupsParallel(t *testing.T) {
+	// "show tech" holds its shell until every other command has run on the other one.
+	release := make(chan struct{})
+	first := &fakeShell{block: map[string]chan struct{}{"show tech": release}}
+	second := &fakeShell{block: map[string]chan struct{}{"show tech": release}}
+	def := testDevice(2)
+	def.Info["tech"] = CommandDef{Command: "show tech", WaitFor: "#", Priority: 20}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if len(first.commands())+len(second.commands()) == 2+5 {
+				break
+			}
+		}
+		close(release)
+	}()
+
+	opened := 0
+	open := func() (shell, error) { opened++; return second, nil }
+	results, err := (&sshCollectPlugin{}).runCommandGroups(first, open, def, "sw1")
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("results = %v", results)
+	}
+	if opened != 1 || !second.closed || first.closed {
+		t.Errorf("opened %d extra shells; closed: first %v, second %v", opened, first.closed, second.closed)
+	}
+
+	// Each shell ran the prelude and outro; the info commands were shared out
+	// in order, the cheap ones on the shell "show tech" did not hold.
+	a, b := first.commands(), second.commands()
+	if a[0] != "terminal length 0" || b[0] != "terminal length 0" || a[len(a)-1] != "exit" || b[len(b)-1] != "exit" {
+		t.Fatalf("first sent %q, second %q", a, b)
+	}
+	slow, other := a, b
+	if a[1] != "show tech" {
+		slow, other = b, a
+	}
+	if len(slow) != 3 || slow[1] != "show tech" {
+		t.Errorf("shell running show tech sent %q", slow)
+	}
+	if want := []string{"show uptime", "show version", "show environment", "show interfaces"}; !reflect.DeepEqual(other[1:len(other)-1], want) {
+		t.Errorf("other shell ran %q, want %q", other[1:len(other)-1], want)
+	}
+}
+
+func TestExtraChannelRefused(t *testing.T) {
+	sh := &fakeShell{}
+	attempts := 0
+	open := func() (shell, error) {
+		attempts++
+		return nil, errors.New("administratively prohibited: open failed")
+	}
+	results, err := (&sshCollectPlugin{}).runCommandGroups(sh, open, testDevice(4), "sw1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One refusal is enough to stop asking; everything runs on the first shell.
+	if attempts != 1 || !reflect.DeepEqual(results, wantResults) || len(sh.commands()) != 7 {
+		t.Errorf("%d attempts, results %v, sent %q", attempts, results, sh.commands())
+	}
+}
+
+func TestParallelSessionFails(t *testing.T) {
+	// The second shell dies on the first info command it is given, while the
+	// first one is held by "show tech" if it took it.
+	release := make(chan struct{})
+	first := &fakeShell{block: map[string]chan struct{}{"show tech": release}}
+	second := &fakeShell{failAfter: 1}
+	def := testDevice(2)
+	def.Info["tech"] = CommandDef{Command: "show tech", WaitFor: "#", Priority: 20}
+	go func() {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			second.mu.Lock()
+			failing := second.failing
+			second.mu.Unlock()
+			if failing {
+				break
+			}
+		}
+		close(release)
+	}()
+
+	results, err := (&sshCollectPlugin{}).runCommandGroups(first, func() (shell, error) { return second, nil }, def, "sw1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("results = %v", results)
+	}
+	// The failed shell's command and the rest went to the first one.
+	if got := strings.Join(first.commands(), ", "); got != "terminal length 0, show tech, show uptime, show version, show environment, show interfaces, exit" {
+		t.Errorf("first shell sent %s", got)
+	}
+
+	// With no shell left, the error comes back.
+	broken := &fakeShell{failAfter: 1}
+	if _, err := (&sshCollectPlugin{}).runCommandGroups(broken, nil, testDevice(1), "sw1"); err == nil {
+		t.Error("no error from a dead session")
+	}
+}
@@ -18,6 +18,7 @@ type InteractiveSession struct {
 	Session *ssh.Session
 	Stdin   io.WriteCloser
 	Stdout  io.Reader
+	shared  bool // a channel of another session's connection, left open by Close
 }
 
 // Connect establishes an SSH connection.
@@ -61,12 +62,24 @@ func (s *InteractiveSession) Shell() error {
 	return session.Shell()
 }
 
-// Close cleans up the session and client connection.
+// Channel starts another shell on the session's connection, as a new SSH
+// channel. Servers limit channels per connection, so this can be refused.
+func (s *InteractiveSession) Channel() (*InteractiveSession, error) {
+	ch := &InteractiveSession{Client: s.Client, shared: true}
+	if err := ch.Shell(); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	return ch, nil
+}
+
+// Close cleans up the session and, unless it is a Channel of another
+// session, the client connection.
 func (s *InteractiveSession) Close() {
 	if s.Session != nil {
 		s.Session.Close()
 	}
-	if s.Client != nil {
+	if s.Client != nil && !s.shared {
 		s.Client.Close()
 	}
 }
@@ -15,6 +15,10 @@ type DeviceDef struct {
 	Prelude map[string]CommandDef `json:"prelude"`
 	Info    map[string]CommandDef `json:"info"`
 	Outro   map[string]CommandDef `json:"outro"`
+	// ParallelSessions splits the info commands across this many shells on
+	// the one SSH connection, for devices that accept several channels.
+	// Default 1: one shell runs everything.
+	ParallelSessions int `json:"parallel_sessions"`
 }
 
 type CommandDef struct {
@@ -24,6 +28,7 @@ type CommandDef struct {
 	Category     string            `json:"category"`
 	Replacements map[string]string `json:"replacements"`
 	Delimiter    string            `json:"delimiter"`
+	Priority     int               `json:"priority"` // higher runs earlier in its group; ties run by name
 }
 
 // --- Plugin Implementation ---
@@ -101,8 +106,18 @@ func (p *sshCollectPlugin) OnCollect(options map[string]interface{}) (map[string
 
 	_, _ = sess.WaitFor("#|>") // Clear banner
 
+	// Extra shells for parallel_sessions share the connection.
+	openShell := func() (shell, error) {
+		ch, err := sess.Channel()
+		if err != nil {
+			return nil, err
+		}
+		_, _ = ch.WaitFor("#|>")
+		return ch, nil
+	}
+
 	// Pass hostLabel so logs are prefixed with the host identity
-	commandResults, err := p.runCommandGroups(sess, deviceDef, hostLabel)
+	commandResults, err := p.runCommandGroups(sess, openShell, deviceDef, hostLabel)
 	if err != nil {
 		return nil, fmt.Errorf("error during command execution: %w", err)
 	}
@@ -110,37 +125,6 @@ func (p *sshCollectPlugin) OnCollect(options map[string]interface{}) (map[string
 	return p.parseCollection(commandResults, deviceDef), nil
 }
 
-func (p *sshCollectPlugin) runCommandGroups(sess *InteractiveSession, def *DeviceDef, hostLabel string) (map[string]string, error) {
-	results := make(map[string]string)
-	commandGroups := []map[string]CommandDef{def.Prelude, def.Info, def.Outro}
-
-	for _, group := range commandGroups {
-		for name, cmd := range group {
-			// Prefix each SSH command with the host label for clarity
-			fmt.Printf("  |_ %s: Running SSH command: %s\n", hostLabel, cmd.Command)
-			if err := sess.Send(cmd.Command); err != nil {
-				return nil, err
-			}
-
-			// For exit and logout commands, do not wait for a prompt as the session will close.
-			if name == "exit" || name == "logout" {
-				continue
-			}
-
-			output, err := sess.WaitFor(cmd.WaitFor)
-			if err != nil {
-				// Prefix warning with the host label
-				fmt.Printf("            !_ %s | Warning: %v\n", hostLabel, err)
-			}
-			// Store raw output for parsing later
-			if name != "exit" && name != "logout" { // Don't store output of logout commands
-				results[name] = output
-			}
-		}
-	}
-	return results, nil
-}
-
 // parseCollection processes the raw command output into structured metrics.
 func (p *sshCollectPlugin) parseCollection(results map[string]string, def *DeviceDef) map[string]interface{} {
 	metrics := make(map[string]interface{})
@@ -213,7 +197,7 @@ func (p *sshCollectPlugin) parseCollection(results map[string]string, def *Devic
 // --- Helper Functions ---
 
 func (p *sshCollectPlugin) loadAppConfig() (*plugin.Config, error) {
-	configFile, err := ioutil.ReadFile("data/config.json")
+	configFile, err := plugin.ReadConfigFile()
 	if err != nil {
 		return nil, err
 	}
@@ -223,7 +207,7 @@ func (p *sshCollectPlugin) loadAppConfig() (*plugin.Config, error) {
 }
 
 func (p *sshCollectPlugin) loadDeviceDef(deviceType string) (*DeviceDef, error) {
-	defFile, err := ioutil.ReadFile(fmt.Sprintf("plugins/sshcollect/devices/%s.json", deviceType))
+	defFile, err := ioutil.ReadFile(plugin.DeviceFile("sshcollect", deviceType))
 	if err != nil {
 		return nil, fmt.Errorf("could not read device definition for '%s': %w", deviceType, err)
 	}
@@ -250,7 +234,7 @@ func (p *sshCollectPlugin) ShowPage(params map[string]string) (string, error) {
 
 func (p *sshCollectPlugin) showConfig() (string, error) {
 	// Load router.json data
-	routerData, err := ioutil.ReadFile("data/router.json")
+	routerData, err := ioutil.ReadFile(plugin.DataFile("router.json"))
 	if err != nil {
 		return "<h1>System Information</h1><p>No router configuration data available</p>", nil
 	}
@@ -0,0 +1,555 @@
+// Package storeadmin runs the `nord store <action>` maintenance actions
+// against the configured database. The plugin is named "store", so the
+// actions are also available as `nord plugin run store <action>`.
+package storeadmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+	"observer/store"
+)
+
+// backupsDir, in the data directory, receives backups made without dest=.
+const backupsDir = "backups"
+
+// storePlugin holds the store actions.
+type storePlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&storePlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *storePlugin) Name() string {
+	return "Store"
+}
+
+// ActionSafety marks "search", "catalog", "hosts" and "check" read-only and
+// "restore", "prune", "repair" and "migrate-down", which discard stored data,
+// destructive. A repair dry run is classed with the repair; "check" reports
+// the same problems read-only.
+func (p *storePlugin) ActionSafety(action string) plugin.Safety {
+	switch action {
+	case "search", "catalog", "hosts", "check":
+		return plugin.SafetyRead
+	case "restore", "prune", "repair", "migrate-down":
+		return plugin.SafetyDestructive
+	}
+	return plugin.SafetyWrite
+}
+
+// OnCommand handles "search", "catalog", "hosts", "backup", "restore", "prune",
+// "prune-recent", "check", "repair" and "migrate-down".
+func (p *storePlugin) OnCommand(args map[string]string) error {
+	if p.Controller.Store == nil {
+		return errors.New("store: no database configured (see database.url)")
+	}
+	switch args["action"] {
+	case "search":
+		return p.search(args["args"], time.Now())
+	case "catalog":
+		return p.catalog(parseArgs(args["args"]))
+	case "hosts":
+		return p.hosts(parseArgs(args["args"]))
+	case "backup":
+		return p.backup(parseArgs(args["args"]), time.Now())
+	case "restore":
+		return p.restore(parseArgs(args["args"]))
+	case "prune":
+		return p.prune(parseArgs(args["args"]), time.Now())
+	case "prune-recent":
+		return p.pruneRecent(parseArgs(args["args"]), time.Now())
+	case "check":
+		return p.check()
+	case "repair":
+		return p.repair(parseArgs(args["args"]), time.Now())
+	case "migrate-down":
+		return p.migrateDown(parseArgs(args["args"]))
+	}
+	return fmt.Errorf("unknown command for store plugin: %s", args["action"])
+}
+
+// search prints the samples matching a query, newest first. Words that are
+// not key=value arguments make up the query; host=, plugin=, name=,
+// since=<duration>, from=/to=<date> and limit= narrow it.
+func (p *storePlugin) search(argStr string, now time.Time) error {
+	var (
+		words  []string
+		filter store.MetricFilter
+		err    error
+	)
+	for _, arg := range strings.Fields(argStr) {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok || strings.HasPrefix(arg, `"`) {
+			words = append(words, arg)
+			continue
+		}
+		switch k {
+		case "host":
+			filter.HostKey = v
+		case "plugin":
+			filter.Plugin = strings.ToLower(v)
+		case "name":
+			filter.Name = v
+		case "since":
+			d, err := parseSince(v)
+			if err != nil {
+				return err
+			}
+			filter.From = now.Add(-d)
+		case "from":
+			if filter.From, err = parseDate(v); err != nil {
+				return err
+			}
+		case "to":
+			if filter.To, err = parseDate(v); err != nil {
+				return err
+			}
+		case "limit":
+			if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+				return fmt.Errorf("store: invalid limit %q", v)
+			}
+		default:
+			words = append(words, arg)
+		}
+	}
+	query := strings.Join(words, " ")
+	if strings.TrimSpace(query) == "" {
+		return errors.New(`store: search needs a query, e.g. nord store search firmware 7.0.2 since=7d`)
+	}
+
+	records, err := p.Controller.Store.SearchMetrics(context.Background(), query, filter)
+	if err != nil {
+		return err
+	}
+	p.Controller.Printf("--- Store search: %s ---\n", query)
+	for _, r := range records {
+		series := r.Plugin + "." + r.Name
+		if r.Instance != "" {
+			series += "[" + r.Instance + "]"
+		}
+		p.Controller.Printf("  |_ %s  %s  %s: %s\n",
+			r.CollectedAt.Local().Format("2006-01-02 15:04:05"), r.HostKey, series, excerpt(r, query))
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	if len(records) == limit {
+		p.Controller.Printf("%d matches shown; narrow with host=, plugin=, since= or raise limit=\n", len(records))
+	} else {
+		p.Controller.Printf("%d matches\n", len(records))
+	}
+	return nil
+}
+
+// catalog prints the metrics stored for host=<key>, with their category,
+// type, instance count and first and last collection, as a table or, with
+// format=json, as JSON.
+func (p *storePlugin) catalog(args map[string]string) error {
+	host := args["host"]
+	if host == "" {
+		return errors.New("store: catalog needs host=<host key>")
+	}
+	format := args["format"]
+	if format != "" && format != "table" && format != "json" {
+		return fmt.Errorf("store: invalid format %q (use table or json)", format)
+	}
+
+	entries, err := p.Controller.Store.MetricCatalog(context.Background(), host)
+	truncated := errors.Is(err, store.ErrCatalogTruncated)
+	if err != nil && !truncated {
+		return err
+	}
+	warning := fmt.Sprintf("  !_ %s has more than %d metrics; only the first %d are listed", host, store.CatalogLimit, store.CatalogLimit)
+
+	if format == "json" {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		p.Controller.Println(string(data))
+		if truncated {
+			fmt.Fprintln(os.Stderr, warning)
+		}
+		return nil
+	}
+
+	p.Controller.Printf("--- Metric catalog: %s ---\n", host)
+	p.Controller.Printf("  %-12s %-32s %-12s %-10s %9s  %-19s  %s\n", "Plugin", "Name", "Category", "Type", "Instances", "First seen", "Last seen")
+	for _, e := range entries {
+		p.Controller.Printf("  %-12s %-32s %-12s %-10s %9d  %-19s  %s\n", e.Plugin, e.Name, e.Category, e.MetricType, e.Instances,
+			e.FirstSeen.Local().Format("2006-01-02 15:04:05"), e.LastSeen.Local().Format("2006-01-02 15:04:05"))
+	}
+	if truncated {
+		p.Controller.Println(warning)
+	} else {
+		p.Controller.Printf("%d metrics\n", len(entries))
+	}
+	return nil
+}
+
+// hosts lists the hosts the store has rows of, as a table or format=json.
+func (p *storePlugin) hosts(args map[string]string) error {
+	format := args["format"]
+	if format != "" && format != "table" && format != "json" {
+		return fmt.Errorf("store: invalid format %q (use table or json)", format)
+	}
+	hosts, err := p.Controller.Store.ListHosts(context.Background())
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		data, err := json.MarshalIndent(hosts, "", "  ")
+		if err != nil {
+			return err
+		}
+		p.Controller.Println(string(data))
+		return nil
+	}
+
+	p.Controller.Println("--- Stored hosts ---")
+	p.Controller.Printf("  %-24s %-24s %-20s %-19s  %-19s  %s\n", "Key", "Name", "Address", "First seen", "Last seen", "Samples 24h")
+	for _, h := range hosts {
+		p.Controller.Printf("  %-24s %-24s %-20s %-19s  %-19s  %d\n", h.Key, h.Name, h.Address,
+			h.FirstSeen.Local().Format("2006-01-02 15:04:05"), h.LastSeen.Local().Format("2006-01-02 15:04:05"), h.RecentMetrics)
+	}
+	p.Controller.Printf("%d hosts\n", len(hosts))
+	return nil
+}
+
+// backup copies the database to dest=<path>, by default
+// backups/nord-<time>.db in the data directory, and checks the copy.
+func (p *storePlugin) backup(args map[string]string, now time.Time) error {
+	dest := args["dest"]
+	if dest == "" {
+		dest = plugin.DataFile(filepath.Join(backupsDir, "nord-"+now.Format("20060102-150405")+".db"))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("store: backup: %w", err)
+		}
+	}
+	p.Controller.Printf("--- Backing up the store to %s ---\n", dest)
+	start := time.Now()
+	if err := p.Controller.Store.Backup(context.Background(), dest); err != nil {
+		return err
+	}
+	info, err := store.CheckBackup(dest)
+	if err != nil {
+		return err
+	}
+	p.Controller.Printf("  |_ backup complete in %s: schema v%d, %d hosts, %d metric samples\n",
+		time.Since(start).Round(time.Millisecond), info.SchemaVersion, info.Hosts, info.Metrics)
+	return nil
+}
+
+// runLocked runs fn, the destructive action what, holding the run lock. It
+// waits up to wait=<duration> (default none) for a running collection; a
+// running daemon has to be stopped first.
+func (p *storePlugin) runLocked(args map[string]string, what string, fn func() error) error {
+	var wait time.Duration
+	if v := args["wait"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("store: invalid wait %q", v)
+		}
+		wait = d
+	}
+
+	lock, err := plugin.AcquireRunLock(wait)
+	if err != nil {
+		return fmt.Errorf("store: %s: %w; stop the daemon or wait for the run to finish", what, err)
+	}
+	defer lock.Release()
+	return fn()
+}
+
+// restore replaces the database with the backup at src=<path>, under the run
+// lock (see runLocked).
+func (p *storePlugin) restore(args map[string]string) error {
+	src := args["src"]
+	if src == "" {
+		return errors.New("store: restore needs src=<backup file>")
+	}
+	return p.runLocked(args, "restore", func() error {
+		p.Controller.Printf("--- Restoring the store from %s ---\n", src)
+		if err := p.Controller.Store.Restore(context.Background(), src); err != nil {
+			return err
+		}
+		p.Controller.Println("  |_ restore complete")
+		return nil
+	})
+}
+
+// migrateDown undoes the schema migrations above version=<n>, for going back
+// to an older release, under the run lock (see runLocked).
+func (p *storePlugin) migrateDown(args map[string]string) error {
+	version, err := strconv.Atoi(args["version"])
+	if err != nil {
+		return errors.New("store: migrate-down needs version=<schema version to keep>")
+	}
+	return p.runLocked(args, "migrate-down", func() error {
+		p.Controller.Printf("--- Migrating the store down to schema v%d ---\n", version)
+		if err := p.Controller.Store.MigrateDown(context.Background(), version); err != nil {
+			return err
+		}
+		p.Controller.Println("  |_ done; start the older release now, as running this one migrates the store up again")
+		return nil
+	})
+}
+
+// prune deletes the metric samples older than older=<duration>, e.g. 720h
+// or 30d. Hosts and interfaces are kept.
+func (p *storePlugin) prune(args map[string]string, now time.Time) error {
+	v := args["older"]
+	if v == "" {
+		return errors.New("store: prune needs older=<duration>, e.g. older=30d")
+	}
+	older, err := parseSince(v)
+	if err != nil {
+		return fmt.Errorf("store: invalid older %q (use e.g. 720h or 30d)", v)
+	}
+
+	cutoff := now.Add(-older)
+	p.Controller.Printf("--- Pruning samples collected before %s ---\n", cutoff.Local().Format("2006-01-02 15:04:05"))
+	start := time.Now()
+	deleted, err := p.Controller.Store.PruneMetrics(context.Background(), cutoff)
+	if err != nil {
+		if deleted > 0 {
+			p.Controller.Printf("  !_ deleted %d samples before the error\n", deleted)
+		}
+		return err
+	}
+	p.Controller.Printf("  |_ deleted %d samples in %s\n", deleted, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// pruneRecent rolls the metrics_recent samples older than keep=<duration>,
+// by default database.recent.retention, up into per-minute samples, as the
+// daemon does every database.recent.interval.
+func (p *storePlugin) pruneRecent(args map[string]string, now time.Time) error {
+	var cfg struct {
+		Database plugin.DatabaseConfig `json:"database"`
+	}
+	if data, err := plugin.ReadConfigFile(); err == nil {
+		json.Unmarshal(data, &cfg) //nolint:errcheck
+	}
+	keep, _, err := cfg.Database.Recent.Durations()
+	if err != nil {
+		return fmt.Errorf("store: database.recent: %w", err)
+	}
+	if v := args["keep"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("store: invalid keep %q", v)
+		}
+		keep = d
+	}
+
+	p.Controller.Printf("--- Pruning recent samples older than %s ---\n", keep)
+	rolled, pruned, err := p.Controller.Store.PruneRecent(context.Background(), now.Add(-keep))
+	if err != nil {
+		return err
+	}
+	p.Controller.Printf("  |_ rolled %d samples up into %d per-minute samples\n", pruned, rolled)
+	return nil
+}
+
+// check prints what the integrity check finds: rows of hosts that no longer
+// exist, host keys differing only by case or surrounding space, and NULLs
+// left by older schemas.
+func (p *storePlugin) check() error {
+	p.Controller.Println("--- Checking store integrity ---")
+	report, err := p.Controller.Store.CheckIntegrity(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, table := range sortedKeys(report.Orphans) {
+		p.Controller.Printf("  !_ %s: %d rows of missing hosts\n", table, report.Orphans[table])
+	}
+	for _, d := range report.Duplicates {
+		p.Controller.Printf("  !_ duplicate host keys: %s\n", quoteKeys(d.Keys))
+	}
+	for _, column := range sortedKeys(report.Nulls) {
+		p.Controller.Printf("  !_ %s: %d NULLs\n", column, report.Nulls[column])
+	}
+	if report.Clean() {
+		p.Controller.Println("  |_ no problems found")
+	} else {
+		p.Controller.Println("Run `nord store repair dry-run=true` to see what a repair would change")
+	}
+	return nil
+}
+
+// repair fixes what check finds: it deletes rows of missing hosts older than
+// older=<duration> (default 24h), merges duplicate hosts into the configured
+// key, or the trimmed one, and replaces NULLs. With dry-run=true it reports
+// the changes and rolls them back. Otherwise it runs under the run lock (see
+// runLocked).
+func (p *storePlugin) repair(args map[string]string, now time.Time) error {
+	older := 24 * time.Hour
+	if v := args["older"]; v != "" {
+		d, err := parseSince(v)
+		if err != nil {
+			return fmt.Errorf("store: invalid older %q", v)
+		}
+		older = d
+	}
+	dryRun := false
+	if v := args["dry-run"]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("store: invalid dry-run %q", v)
+		}
+		dryRun = b
+	}
+	if dryRun {
+		return p.repairStore(older, true, now)
+	}
+	return p.runLocked(args, "repair", func() error {
+		return p.repairStore(older, false, now)
+	})
+}
+
+// repairStore runs a repair with the hosts of config.json as preferred keys.
+func (p *storePlugin) repairStore(older time.Duration, dryRun bool, now time.Time) error {
+	var cfg struct {
+		Hosts map[string]plugin.Host `json:"hosts"`
+	}
+	if data, err := plugin.ReadConfigFile(); err == nil {
+		json.Unmarshal(data, &cfg) //nolint:errcheck
+	}
+
+	opts := store.RepairOptions{OrphansBefore: now.Add(-older), DryRun: dryRun}
+	for key := range cfg.Hosts {
+		opts.PreferKeys = append(opts.PreferKeys, key)
+	}
+	if dryRun {
+		p.Controller.Println("--- Repairing the store (dry run) ---")
+	} else {
+		p.Controller.Println("--- Repairing the store ---")
+	}
+	r, err := p.Controller.Store.RepairIntegrity(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	for _, table := range sortedKeys(r.Orphans) {
+		p.Controller.Printf("  |_ %s: deleted %d rows of missing hosts older than %s\n", table, r.Orphans[table], older)
+	}
+	if r.MergedHosts > 0 {
+		p.Controller.Printf("  |_ merged %d duplicate hosts: moved %d rows, dropped %d the kept host already had\n",
+			r.MergedHosts, r.MovedRows, r.DroppedRows)
+	}
+	if r.NullsReplaced > 0 {
+		p.Controller.Printf("  |_ replaced %d NULLs with empty strings\n", r.NullsReplaced)
+	}
+	if len(r.Orphans) == 0 && r.MergedHosts == 0 && r.NullsReplaced == 0 {
+		p.Controller.Println("  |_ nothing to repair")
+	} else if dryRun {
+		p.Controller.Println("  |_ dry run: nothing was changed")
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of counts in order.
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// quoteKeys lists host keys quoted, so surrounding space shows.
+func quoteKeys(keys []string) string {
+	quoted := make([]string, len(keys))
+	for i, k := range keys {
+		quoted[i] = strconv.Quote(k)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// parseArgs splits "key=value key=value" into a map.
+func parseArgs(argStr string) map[string]string {
+	args := make(map[string]string)
+	for _, kv := range strings.Fields(argStr) {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			args[k] = v
+		}
+	}
+	return args
+}
+
+// excerptWidth is the number of characters shown around a match.
+const excerptWidth = 100
+
+// excerpt returns the part of a sample's value, or of its extra metadata when
+// the value does not contain the query's first word, around the match, on
+// one line.
+func excerpt(r store.MetricRecord, query string) string {
+	first := strings.ToLower(strings.Trim(strings.Fields(query)[0], `"`))
+	text := r.Value
+	if !strings.Contains(strings.ToLower(text), first) && len(r.Extra) > 0 {
+		var parts []string
+		for k, v := range r.Extra {
+			parts = append(parts, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(parts)
+		if joined := strings.Join(parts, " "); strings.Contains(strings.ToLower(joined), first) {
+			text = joined
+		}
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= excerptWidth {
+		return text
+	}
+	start := 0
+	if i := strings.Index(strings.ToLower(text), first); i >= 0 {
+		start = len([]rune(text[:i])) - excerptWidth/3
+	}
+	start = max(0, min(start, len(runes)-excerptWidth))
+	out := string(runes[start : start+excerptWidth])
+	if start > 0 {
+		out = "…" + out
+	}
+	if start+excerptWidth < len(runes) {
+		out += "…"
+	}
+	return out
+}
+
+// parseSince reads a lookback such as 90m, 24h or 7d.
+func parseSince(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("store: invalid since %q (use e.g. 90m, 24h or 7d)", s)
+}
+
+// parseDate reads YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339, in local time.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("store: invalid date %q (use YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339)", s)
+}
@@ -0,0 +1,220 @@
+package syslog
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// message is one parsed syslog message.
+type message struct {
+	facility, severity int
+	timestamp          time.Time // zero when the message carries none that parses
+	hostname           string
+	app, procID, msgID string
+	structured         map[string]map[string]string // RFC 5424 SD-ID -> params
+	text               string
+	format             string // "rfc5424" or "rfc3164"
+}
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+func facilityName(f int) string {
+	if f >= 0 && f < len(facilityNames) {
+		return facilityNames[f]
+	}
+	return strconv.Itoa(f)
+}
+
+func severityName(s int) string {
+	if s >= 0 && s < len(severityNames) {
+		return severityNames[s]
+	}
+	return strconv.Itoa(s)
+}
+
+// parse reads an RFC 5424 message, or failing that an RFC 3164 one. Devices
+// are lax about RFC 3164, so after the priority anything that does not look
+// like a timestamp, host and tag is kept as the message text.
+func parse(raw string, now time.Time) (message, error) {
+	raw = strings.TrimRight(raw, "\r\n\x00")
+	if !strings.HasPrefix(raw, "<") {
+		return message{}, errors.New("no priority")
+	}
+	end := strings.IndexByte(raw, '>')
+	if end < 2 || end > 4 {
+		return message{}, errors.New("malformed priority")
+	}
+	pri, err := strconv.Atoi(raw[1:end])
+	if err != nil || pri > 191 {
+		return message{}, errors.New("malformed priority")
+	}
+	m := message{facility: pri / 8, severity: pri % 8}
+	rest := raw[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		if err := parse5424(&m, rest[2:]); err == nil {
+			return m, nil
+		}
+		m = message{facility: pri / 8, severity: pri % 8}
+	}
+	parse3164(&m, rest, now)
+	return m, nil
+}
+
+// parse5424 reads TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG,
+// where "-" is a nil value.
+func parse5424(m *message, s string) error {
+	m.format = "rfc5424"
+	fields := make([]string, 5)
+	for i := range fields {
+		var ok bool
+		fields[i], s, ok = strings.Cut(s, " ")
+		if !ok && i < 4 {
+			return errors.New("truncated header")
+		}
+	}
+	nilValue := func(v string) string {
+		if v == "-" {
+			return ""
+		}
+		return v
+	}
+	if ts := nilValue(fields[0]); ts != "" {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return err
+		}
+		m.timestamp = t
+	}
+	m.hostname, m.app, m.procID, m.msgID = nilValue(fields[1]), nilValue(fields[2]), nilValue(fields[3]), nilValue(fields[4])
+
+	switch {
+	case strings.HasPrefix(s, "-"):
+		s = strings.TrimPrefix(s[1:], " ")
+	case strings.HasPrefix(s, "["):
+		sd, rest, err := parseStructured(s)
+		if err != nil {
+			return err
+		}
+		m.structured, s = sd, strings.TrimPrefix(rest, " ")
+	default:
+		if s != "" {
+			return errors.New("missing structured data")
+		}
+	}
+	m.text = strings.TrimPrefix(s, "\ufeff") // BOM marking UTF-8
+	return nil
+}
+
+// parseStructured reads one or more [SD-ID name="value" ...] elements and
+// returns them with the remainder of s. Values may escape ", \ and ].
+func parseStructured(s string) (map[string]map[string]string, string, error) {
+	sd := make(map[string]map[string]string)
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		id, rest, _ := strings.Cut(s, " ")
+		if i := strings.IndexByte(id, ']'); i >= 0 {
+			id, rest = id[:i], s[i:]
+		}
+		params := make(map[string]string)
+		s = rest
+		for {
+			s = strings.TrimLeft(s, " ")
+			if strings.HasPrefix(s, "]") {
+				s = s[1:]
+				break
+			}
+			name, v, ok := strings.Cut(s, "=\"")
+			if !ok {
+				return nil, "", errors.New("malformed structured data")
+			}
+			var b strings.Builder
+			i := 0
+			for ; i < len(v); i++ {
+				if v[i] == '\\' && i+1 < len(v) {
+					i++
+					b.WriteByte(v[i])
+					continue
+				}
+				if v[i] == '"' {
+					break
+				}
+				b.WriteByte(v[i])
+			}
+			if i == len(v) {
+				return nil, "", errors.New("unterminated structured data value")
+			}
+			params[name] = b.String()
+			s = v[i+1:]
+		}
+		sd[id] = params
+	}
+	return sd, s, nil
+}
+
+// rfc3164Layouts are the BSD timestamps seen in practice, with and without
+// fractions and a year, longest first.
+var rfc3164Layouts = []string{"2006 " + time.Stamp, time.StampMicro, time.StampMilli, time.Stamp, time.RFC3339Nano}
+
+// parse3164 reads [TIMESTAMP HOSTNAME ]TAG[PID]: MSG. A timestamp without a
+// year is placed in the year that makes it closest to now.
+func parse3164(m *message, s string, now time.Time) {
+	m.format = "rfc3164"
+	s = strings.TrimLeft(s, " ")
+	if ts, rest, ok := cut3164Time(s, now); ok {
+		m.timestamp = ts
+		s = rest
+		// A hostname follows the timestamp unless the next word is the tag.
+		if word, after, ok := strings.Cut(s, " "); ok && !strings.HasSuffix(word, ":") && !strings.Contains(word, "[") {
+			m.hostname, s = word, after
+		}
+	}
+	// Cisco's "%FACILITY-SEV-MNEMONIC:" and sequence numbers are not tags.
+	if word, after, ok := strings.Cut(s, " "); ok && strings.HasSuffix(word, ":") && !strings.HasPrefix(word, "%") && !isNumber(strings.TrimSuffix(word, ":")) {
+		tag := strings.TrimSuffix(word, ":")
+		if name, pid, ok := strings.Cut(tag, "["); ok {
+			m.app, m.procID = name, strings.TrimSuffix(pid, "]")
+		} else {
+			m.app = tag
+		}
+		s = after
+	}
+	m.text = s
+}
+
+func cut3164Time(s string, now time.Time) (time.Time, string, bool) {
+	for _, layout := range rfc3164Layouts {
+		// RFC 3339 timestamps vary in length; they end at the next space.
+		n := len(layout)
+		if layout == time.RFC3339Nano {
+			n = strings.IndexByte(s, ' ')
+		}
+		if n < 0 || len(s) < n {
+			continue
+		}
+		t, err := time.ParseInLocation(layout, s[:n], now.Location())
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.Sub(now) > 24*time.Hour*31 {
+				t = t.AddDate(-1, 0, 0)
+			}
+		}
+		return t, strings.TrimLeft(s[n:], " "), true
+	}
+	return time.Time{}, s, false
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
@@ -0,0 +1,98 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRFC5424(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	raw := `<165>1 2024-05-01T11:59:58.123Z fw1.example.com ifmgr 2311 LINK [origin ip="192.0.2.1" software="fw\"OS\]"][meta sequenceId="42"] ` + "\ufeffGi0/1 went down\r\n"
+	m, err := parse(raw, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.format != "rfc5424" || m.facility != 20 || m.severity != 5 {
+		t.Errorf("format %s, facility %d, severity %d", m.format, m.facility, m.severity)
+	}
+	if !m.timestamp.Equal(time.Date(2024, 5, 1, 11, 59, 58, 123e6, time.UTC)) {
+		t.Errorf("timestamp = %v", m.timestamp)
+	}
+	if m.hostname != "fw1.example.com" || m.app != "ifmgr" || m.procID != "2311" || m.msgID != "LINK" {
+		t.Errorf("header = %q %q %q %q", m.hostname, m.app, m.procID, m.msgID)
+	}
+	if m.structured["origin"]["ip"] != "192.0.2.1" || m.structured["origin"]["software"] != `fw"OS]` || m.structured["meta"]["sequenceId"] != "42" {
+		t.Errorf("structured data = %v", m.structured)
+	}
+	if m.text != "Gi0/1 went down" {
+		t.Errorf("text = %q", m.text)
+	}
+
+	m, err = parse("<14>1 - - - - - -", now)
+	if err != nil || m.format != "rfc5424" || !m.timestamp.IsZero() || m.hostname != "" || m.text != "" || m.structured != nil {
+		t.Errorf("nil values: %+v, %v", m, err)
+	}
+	m, err = parse("<14>1 - host app - - [x@1]", now)
+	if err != nil || len(m.structured) != 1 || m.structured["x@1"] == nil || m.text != "" {
+		t.Errorf("empty element: %+v, %v", m, err)
+	}
+}
+
+func TestParseRFC3164(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		raw                      string
+		facility, severity       int
+		ts                       time.Time
+		hostname, app, pid, text string
+	}{
+		{"<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed for lonvick on /dev/pts/8", 4, 2,
+			time.Date(2023, 10, 11, 22, 14, 15, 0, time.UTC), "mymachine", "su", "230", "'su root' failed for lonvick on /dev/pts/8"},
+		{"<13>Jan  2 11:00:00 sshd: session opened", 1, 5,
+			time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC), "", "sshd", "", "session opened"},
+		{"<189>Jan  1 23:59:59.250 core1 %LINK-3-UPDOWN: Interface Gi0/1, changed state to down", 23, 5,
+			time.Date(2024, 1, 1, 23, 59, 59, 250e6, time.UTC), "core1", "", "", "%LINK-3-UPDOWN: Interface Gi0/1, changed state to down"},
+		{"<187>42: core1: %SYS-5-CONFIG_I: Configured from console", 23, 3,
+			time.Time{}, "", "", "", "42: core1: %SYS-5-CONFIG_I: Configured from console"},
+		{"<30>2024-01-02T10:00:00+01:00 nas1 smartd[77]: Device: /dev/sda, 8 Currently unreadable sectors", 3, 6,
+			time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC), "nas1", "smartd", "77", "Device: /dev/sda, 8 Currently unreadable sectors"},
+		{"<0>kernel panic", 0, 0, time.Time{}, "", "", "", "kernel panic"},
+	} {
+		m, err := parse(tc.raw, now)
+		if err != nil {
+			t.Errorf("%s: %v", tc.raw, err)
+			continue
+		}
+		if m.format != "rfc3164" || m.facility != tc.facility || m.severity != tc.severity || !m.timestamp.Equal(tc.ts) {
+			t.Errorf("%s: format %s, facility %d, severity %d, timestamp %v", tc.raw, m.format, m.facility, m.severity, m.timestamp)
+		}
+		if m.hostname != tc.hostname || m.app != tc.app || m.procID != tc.pid || m.text != tc.text {
+			t.Errorf("%s: host %q, app %q, pid %q, text %q", tc.raw, m.hostname, m.app, m.procID, m.text)
+		}
+	}
+}
+
+func TestParseFallsBackToRFC3164(t *testing.T) {
+	// "1 " after the priority, but not a valid RFC 5424 header.
+	m, err := parse("<14>1 not-a-timestamp host app - - - text", time.Now())
+	if err != nil || m.format != "rfc3164" || m.text != "1 not-a-timestamp host app - - - text" {
+		t.Errorf("%+v, %v", m, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, raw := range []string{"", "no priority", "<>x", "<1234>x", "<abc>x", "<192>too high"} {
+		if _, err := parse(raw, time.Now()); err == nil {
+			t.Errorf("parse(%q) accepted", raw)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	if facilityName(4) != "auth" || facilityName(23) != "local7" || facilityName(30) != "30" {
+		t.Error("facility names")
+	}
+	if severityName(3) != "err" || severityName(7) != "debug" || severityName(9) != "9" {
+		t.Error("severity names")
+	}
+}
@@ -0,0 +1,452 @@
+// Package syslog receives syslog messages from network devices over UDP and
+// TCP and stores them as "event" metrics of the sending host. It runs as a
+// daemon service ("services": ["syslog"]) or in the foreground with
+// `nord plugin run syslog listen`.
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+	"observer/store"
+)
+
+const (
+	defaultAddr      = ":514"
+	defaultRateLimit = 50
+	defaultBurst     = 200
+	maxMessage       = 64 << 10
+	flushInterval    = time.Second
+	maxPending       = 10000 // records held while the store is slow; more are dropped
+)
+
+// syslogPlugin is the syslog receiver.
+type syslogPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&syslogPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *syslogPlugin) Name() string {
+	return "Syslog"
+}
+
+// OnCommand handles "listen", which receives messages until interrupted.
+func (p *syslogPlugin) OnCommand(args map[string]string) error {
+	switch args["action"] {
+	case "listen":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return p.Serve(ctx)
+	}
+	return fmt.Errorf("unknown command for syslog plugin: %s", args["action"])
+}
+
+// Serve listens on the configured addresses until ctx is cancelled.
+func (p *syslogPlugin) Serve(ctx context.Context) error {
+	if p.Controller.Store == nil {
+		return errors.New("syslog: no database configured (see database.url)")
+	}
+	cfg, hosts, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	r, err := newReceiver(cfg, hosts, p.Controller.Store, p.Controller)
+	if err != nil {
+		return err
+	}
+	return r.serve(ctx, cfg)
+}
+
+// loadConfig reads the "syslog" section and maps host addresses to host keys.
+func loadConfig() (plugin.SyslogConfig, map[string]hostRef, error) {
+	var cfg plugin.Config
+	data, err := plugin.ReadConfigFile()
+	if err != nil {
+		return cfg.Syslog, nil, fmt.Errorf("could not read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg.Syslog, nil, fmt.Errorf("could not parse config file: %w", err)
+	}
+	hosts := make(map[string]hostRef)
+	for key, h := range cfg.Hosts {
+		if ip := net.ParseIP(h.Address); ip != nil {
+			hosts[ip.String()] = hostRef{key: key, name: h.Name, address: h.Address}
+		}
+	}
+	return cfg.Syslog, hosts, nil
+}
+
+// hostRef is the configured host a source address belongs to.
+type hostRef struct {
+	key, name, address string
+}
+
+// rule is a compiled SyslogRule.
+type rule struct {
+	name, status string
+	match, clear *regexp.Regexp
+}
+
+// receiver parses messages, applies the per-source rate limit and batches
+// records for the store.
+type receiver struct {
+	st      store.Store
+	c       *plugin.Controller // prints progress; may be nil
+	metrics *plugin.Metrics
+	rules   []rule
+	hosts   map[string]hostRef
+	rate    float64
+	burst   float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	dropped map[string]int64 // per source, since start
+	changed map[string]bool  // sources whose drop count is not yet stored
+	pending []store.MetricRecord
+}
+
+// bucket is a token bucket refilled at the receiver's rate.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newReceiver(cfg plugin.SyslogConfig, hosts map[string]hostRef, st store.Store, c *plugin.Controller) (*receiver, error) {
+	r := &receiver{
+		st: st, c: c, hosts: hosts,
+		rate: cfg.RateLimit, burst: float64(cfg.Burst),
+		buckets: make(map[string]*bucket),
+		dropped: make(map[string]int64),
+		changed: make(map[string]bool),
+	}
+	if c != nil {
+		r.metrics = c.Metrics
+	}
+	if r.rate <= 0 {
+		r.rate = defaultRateLimit
+	}
+	if r.burst <= 0 {
+		r.burst = defaultBurst
+	}
+	for _, rc := range cfg.Rules {
+		if rc.Name == "" || rc.Match == "" {
+			return nil, errors.New("syslog: every rule needs a name and a match")
+		}
+		ru := rule{name: rc.Name, status: rc.Status}
+		if ru.status == "" {
+			ru.status = "warning"
+		}
+		var err error
+		if ru.match, err = regexp.Compile(rc.Match); err != nil {
+			return nil, fmt.Errorf("syslog: rule %s: %w", rc.Name, err)
+		}
+		if rc.Clear != "" {
+			if ru.clear, err = regexp.Compile(rc.Clear); err != nil {
+				return nil, fmt.Errorf("syslog: rule %s: %w", rc.Name, err)
+			}
+		}
+		r.rules = append(r.rules, ru)
+	}
+	return r, nil
+}
+
+// serve binds the listeners, then receives and flushes until ctx is done.
+func (r *receiver) serve(ctx context.Context, cfg plugin.SyslogConfig) error {
+	udpAddr, tcpAddr := listenAddr(cfg.UDP), listenAddr(cfg.TCP)
+	if udpAddr == "" && tcpAddr == "" {
+		return errors.New("syslog: both listeners are off")
+	}
+
+	var udp net.PacketConn
+	var tcp net.Listener
+	var err error
+	if udpAddr != "" {
+		if udp, err = net.ListenPacket("udp", udpAddr); err != nil {
+			return fmt.Errorf("syslog: %w", err)
+		}
+		defer udp.Close()
+		r.c.Printf("  |_ syslog: listening on UDP %s\n", udp.LocalAddr())
+	}
+	if tcpAddr != "" {
+		if tcp, err = net.Listen("tcp", tcpAddr); err != nil {
+			return fmt.Errorf("syslog: %w", err)
+		}
+		defer tcp.Close()
+		r.c.Printf("  |_ syslog: listening on TCP %s\n", tcp.Addr())
+	}
+
+	var wg sync.WaitGroup
+	if udp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.readUDP(udp)
+		}()
+	}
+	if tcp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.acceptTCP(ctx, tcp)
+		}()
+	}
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if udp != nil {
+				udp.Close()
+			}
+			if tcp != nil {
+				tcp.Close()
+			}
+			wg.Wait()
+			r.flush()
+			return nil
+		case <-ticker.C:
+			r.flush()
+		}
+	}
+}
+
+// listenAddr applies the default address and "off".
+func listenAddr(addr string) string {
+	switch addr {
+	case "":
+		return defaultAddr
+	case "off":
+		return ""
+	}
+	return addr
+}
+
+func (r *receiver) readUDP(conn net.PacketConn) {
+	buf := make([]byte, maxMessage)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		r.receive(addr.(*net.UDPAddr).IP, string(buf[:n]))
+	}
+}
+
+func (r *receiver) acceptTCP(ctx context.Context, ln net.Listener) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.readTCP(ctx, conn)
+		}()
+	}
+}
+
+// readTCP reads messages framed by octet counting ("LEN message", RFC 6587)
+// or, when a frame does not start with a digit, terminated by a newline.
+func (r *receiver) readTCP(ctx context.Context, conn net.Conn) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+	ip := conn.RemoteAddr().(*net.TCPAddr).IP
+	br := bufio.NewReaderSize(conn, maxMessage)
+	for {
+		first, err := br.Peek(1)
+		if err != nil {
+			return
+		}
+		var frame string
+		if first[0] >= '0' && first[0] <= '9' {
+			length, err := br.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(length))
+			if err != nil || n <= 0 || n > maxMessage {
+				return
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return
+			}
+			frame = string(buf)
+		} else {
+			line, err := br.ReadString('\n')
+			if err != nil && line == "" {
+				return
+			}
+			frame = line
+		}
+		if strings.TrimSpace(frame) != "" {
+			r.receive(ip, frame)
+		}
+	}
+}
+
+// receive parses one message and queues its records, unless the source is
+// over its rate or the message does not parse.
+func (r *receiver) receive(ip net.IP, raw string) {
+	now := time.Now()
+	src := ip.String()
+	r.metrics.Add(plugin.SelfSyslogMessages, 1)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.allow(src, now) || len(r.pending) >= maxPending {
+		r.drop(src)
+		return
+	}
+	m, err := parse(raw, now)
+	if err != nil {
+		r.drop(src)
+		return
+	}
+	r.pending = append(r.pending, r.records(src, m, now)...)
+}
+
+// allow takes a token from src's bucket.
+func (r *receiver) allow(src string, now time.Time) bool {
+	b, ok := r.buckets[src]
+	if !ok {
+		b = &bucket{tokens: r.burst, last: now}
+		r.buckets[src] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * r.rate
+	if b.tokens > r.burst {
+		b.tokens = r.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (r *receiver) drop(src string) {
+	r.dropped[src]++
+	r.changed[src] = true
+	r.metrics.Add(plugin.SelfSyslogDropped, 1)
+}
+
+// host returns the configured host for src, or one keyed by the address.
+func (r *receiver) host(src string) hostRef {
+	if h, ok := r.hosts[src]; ok {
+		return h
+	}
+	return hostRef{key: src, name: src, address: src}
+}
+
+// records maps a message to its event record and any rule status records.
+// ValueNum is the severity, 0 (emerg) to 7 (debug), so counting events with
+// ValueNum <= 3 gives errors per host.
+func (r *receiver) records(src string, m message, now time.Time) []store.MetricRecord {
+	h := r.host(src)
+	sev := float64(m.severity)
+	extra := map[string]interface{}{
+		"facility": facilityName(m.facility),
+		"severity": severityName(m.severity),
+		"format":   m.format,
+		"source":   src,
+	}
+	for k, v := range map[string]string{"hostname": m.hostname, "app": m.app, "procid": m.procID, "msgid": m.msgID} {
+		if v != "" {
+			extra[k] = v
+		}
+	}
+	if !m.timestamp.IsZero() {
+		extra["timestamp"] = m.timestamp.Format(time.RFC3339Nano)
+	}
+	if len(m.structured) > 0 {
+		extra["structured_data"] = m.structured
+	}
+	records := []store.MetricRecord{{
+		HostKey: h.key, HostName: h.name, HostAddress: h.address,
+		Plugin: "syslog", Name: "message", Category: "syslog", MetricType: "event",
+		Value: m.text, ValueNum: &sev, Instance: m.app, Extra: extra, CollectedAt: now,
+	}}
+
+	for _, ru := range r.rules {
+		status, match := "", ru.match.FindStringSubmatch(m.text)
+		switch {
+		case match != nil:
+			status = ru.status
+		case ru.clear != nil:
+			if match = ru.clear.FindStringSubmatch(m.text); match != nil {
+				status = "up"
+			}
+		}
+		if status == "" {
+			continue
+		}
+		instance := ""
+		if len(match) > 1 {
+			instance = match[1]
+		}
+		records = append(records, store.MetricRecord{
+			HostKey: h.key, HostName: h.name, HostAddress: h.address,
+			Plugin: "syslog", Name: ru.name, Category: "syslog", MetricType: "status",
+			Value: status, ValueNum: store.ParseValueNum(status), Instance: instance,
+			Extra: map[string]interface{}{"reason": m.text}, CollectedAt: now,
+		})
+	}
+	return records
+}
+
+// flush writes the queued records and the drop counters that changed.
+func (r *receiver) flush() {
+	r.mu.Lock()
+	batch := r.pending
+	r.pending = nil
+	now := time.Now()
+	for src := range r.changed {
+		h := r.host(src)
+		n := float64(r.dropped[src])
+		batch = append(batch, store.MetricRecord{
+			HostKey: h.key, HostName: h.name, HostAddress: h.address,
+			Plugin: "syslog", Name: "dropped", Category: "syslog", MetricType: "counter",
+			Value: strconv.FormatInt(r.dropped[src], 10), ValueNum: &n, CollectedAt: now,
+		})
+	}
+	r.changed = make(map[string]bool)
+	r.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+	if err := r.st.WriteBatch(context.Background(), batch); err != nil {
+		r.c.Printf("  !_ store: syslog WriteBatch error: %v\n", err)
+	}
+}
@@ -0,0 +1,265 @@
+package syslog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+// recordingStore keeps every record written to it.
+type recordingStore struct {
+	store.Store
+	mu      sync.Mutex
+	records []store.MetricRecord
+}
+
+func (s *recordingStore) WriteBatch(ctx context.Context, records []store.MetricRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, records...)
+	return nil
+}
+
+// named returns the records called name, in the order written.
+func (s *recordingStore) named(name string) []store.MetricRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []store.MetricRecord
+	for _, r := range s.records {
+		if r.Name == name {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// freePort returns a loopback address on a port that was free for network.
+func freePort(t *testing.T, network string) string {
+	t.Helper()
+	var addr string
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = conn.LocalAddr().String()
+		conn.Close()
+	} else {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = ln.Addr().String()
+		ln.Close()
+	}
+	return addr
+}
+
+// writeConfig points the configuration file at a temp file holding cfg.
+func writeConfig(t *testing.T, cfg string) {
+	t.Helper()
+	old := plugin.ConfigFile
+	plugin.ConfigFile = filepath.Join(t.TempDir(), "config.json")
+	t.Cleanup(func() { plugin.ConfigFile = old })
+	if err := os.WriteFile(plugin.ConfigFile, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeOverLoopback(t *testing.T) {
+	udpAddr, tcpAddr := freePort(t, "udp"), freePort(t, "tcp")
+	writeConfig(t, fmt.Sprintf(`{"config_version": 1,
+		"hosts": {"core1": {"name": "Core switch", "address": "127.0.0.1"}},
+		"syslog": {"udp": %q, "tcp": %q, "rules": [
+			{"name": "link", "match": "%%LINK-3-UPDOWN: Interface (\\S+), changed state to down", "clear": "%%LINK-3-UPDOWN: Interface (\\S+), changed state to up", "status": "down"},
+			{"name": "config_changed", "match": "%%SYS-5-CONFIG_I"}]}}`, udpAddr, tcpAddr))
+
+	st := &recordingStore{}
+	c := plugin.NewController()
+	c.Store = st
+	p := &syslogPlugin{BasePlugin: plugin.BasePlugin{Controller: c}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- p.Serve(ctx) }()
+
+	// The TCP listener is bound after the UDP one, so once it accepts both are up.
+	var tcp net.Conn
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if tcp, err = net.Dial("tcp", tcpAddr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	udp, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	udp.Write([]byte(`<165>1 2024-05-01T11:59:58Z core1 ifmgr 12 LINK [origin ip="192.0.2.1"] Interface check done`))
+	udp.Write([]byte("<187>Jan  1 12:00:00 core1 %LINK-3-UPDOWN: Interface Gi0/1, changed state to down"))
+	// One octet-counted frame, then newline-terminated ones.
+	framed := "<189>Jan  1 12:00:05 core1 %LINK-3-UPDOWN: Interface Gi0/1, changed state to up"
+	fmt.Fprintf(tcp, "%d %s", len(framed), framed)
+	fmt.Fprint(tcp, "<189>core1: %SYS-5-CONFIG_I: Configured from console by admin\n\n<13>hello\n")
+
+	for deadline := time.Now().Add(5 * time.Second); c.Metrics.Get(plugin.SelfSyslogMessages) < 5; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d messages, want 5", c.Metrics.Get(plugin.SelfSyslogMessages))
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	messages := st.named("message")
+	if len(messages) != 5 {
+		t.Fatalf("stored %d messages, want 5", len(messages))
+	}
+	var rfc5424 *store.MetricRecord
+	for i, m := range messages {
+		if m.HostKey != "core1" || m.HostName != "Core switch" || m.Plugin != "syslog" || m.MetricType != "event" || m.ValueNum == nil {
+			t.Errorf("message %d = %+v", i, m)
+		}
+		if m.Extra["format"] == "rfc5424" {
+			rfc5424 = &messages[i]
+		}
+	}
+	if rfc5424 == nil {
+		t.Fatal("no RFC 5424 message stored")
+	}
+	sd, _ := rfc5424.Extra["structured_data"].(map[string]map[string]string)
+	if rfc5424.Value != "Interface check done" || *rfc5424.ValueNum != 5 || rfc5424.Instance != "ifmgr" ||
+		rfc5424.Extra["facility"] != "local4" || rfc5424.Extra["severity"] != "notice" || rfc5424.Extra["msgid"] != "LINK" ||
+		rfc5424.Extra["source"] != "127.0.0.1" || sd["origin"]["ip"] != "192.0.2.1" {
+		t.Errorf("RFC 5424 message = %+v", rfc5424)
+	}
+
+	// UDP and TCP are read concurrently, so only the link changes within TCP are ordered.
+	links := st.named("link")
+	if len(links) != 2 {
+		t.Fatalf("link records = %+v", links)
+	}
+	values := map[string]bool{}
+	for _, l := range links {
+		if l.Instance != "Gi0/1" || l.MetricType != "status" || !strings.Contains(l.Extra["reason"].(string), "changed state") {
+			t.Errorf("link = %+v", l)
+		}
+		values[l.Value] = true
+	}
+	if !values["down"] || !values["up"] {
+		t.Errorf("link values = %v", values)
+	}
+	if cfg := st.named("config_changed"); len(cfg) != 1 || cfg[0].Value != "warning" || *cfg[0].ValueNum != 0.5 || cfg[0].Instance != "" {
+		t.Errorf("config_changed = %+v", cfg)
+	}
+	if d := st.named("dropped"); len(d) != 0 {
+		t.Errorf("dropped = %+v", d)
+	}
+}
+
+func TestRateLimitAndDrops(t *testing.T) {
+	st := &recordingStore{}
+	c := plugin.NewController()
+	m := c.Metrics
+	r, err := newReceiver(plugin.SyslogConfig{RateLimit: 0.001, Burst: 3}, nil, st, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storm := net.ParseIP("192.0.2.7")
+	quiet := net.ParseIP("192.0.2.8")
+	for i := 0; i < 10; i++ {
+		r.receive(storm, fmt.Sprintf("<11>app: error %d", i))
+	}
+	r.receive(quiet, "<14>app: fine")
+	r.receive(quiet, "not syslog")
+	r.flush()
+
+	if got := len(st.named("message")); got != 4 {
+		t.Errorf("stored %d messages, want 4", got)
+	}
+	dropped := map[string]string{}
+	for _, d := range st.named("dropped") {
+		dropped[d.HostKey] = d.Value
+		if d.MetricType != "counter" || d.HostAddress != d.HostKey {
+			t.Errorf("dropped = %+v", d)
+		}
+	}
+	if dropped["192.0.2.7"] != "7" || dropped["192.0.2.8"] != "1" {
+		t.Errorf("dropped = %v", dropped)
+	}
+	if m.Get(plugin.SelfSyslogMessages) != 12 || m.Get(plugin.SelfSyslogDropped) != 8 {
+		t.Errorf("self metrics: %d messages, %d dropped", m.Get(plugin.SelfSyslogMessages), m.Get(plugin.SelfSyslogDropped))
+	}
+
+	// Counters are written again only when they change, and keep counting.
+	r.flush()
+	if got := len(st.named("dropped")); got != 2 {
+		t.Errorf("%d dropped records after an idle flush, want 2", got)
+	}
+	r.receive(storm, "<11>app: still failing")
+	r.flush()
+	if d := st.named("dropped"); len(d) != 3 || d[2].Value != "8" {
+		t.Errorf("dropped = %+v", d)
+	}
+}
+
+func TestRateLimitRefills(t *testing.T) {
+	r, err := newReceiver(plugin.SyslogConfig{RateLimit: 10, Burst: 1}, nil, &recordingStore{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if !r.allow("a", now) || r.allow("a", now) {
+		t.Error("burst of 1 not enforced")
+	}
+	if !r.allow("a", now.Add(150*time.Millisecond)) {
+		t.Error("bucket did not refill at 10/s")
+	}
+	if !r.allow("b", now) {
+		t.Error("sources share a bucket")
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for name, rules := range map[string][]plugin.SyslogRule{
+		"no name":     {{Match: "x"}},
+		"no match":    {{Name: "x"}},
+		"bad match":   {{Name: "x", Match: "("}},
+		"bad clear":   {{Name: "x", Match: "a", Clear: "["}},
+		"second rule": {{Name: "x", Match: "a"}, {Name: "y"}},
+	} {
+		if _, err := newReceiver(plugin.SyslogConfig{Rules: rules}, nil, nil, nil); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestServeNeedsStoreAndListener(t *testing.T) {
+	p := &syslogPlugin{BasePlugin: plugin.BasePlugin{Controller: plugin.NewController()}}
+	if err := p.Serve(context.Background()); err == nil || !strings.Contains(err.Error(), "no database") {
+		t.Errorf("without a store: %v", err)
+	}
+	writeConfig(t, `{"config_version": 1, "syslog": {"udp": "off", "tcp": "off"}}`)
+	p.Controller.Store = &recordingStore{}
+	if err := p.Serve(context.Background()); err == nil || !strings.Contains(err.Error(), "both listeners are off") {
+		t.Errorf("both off: %v", err)
+	}
+	if listenAddr("") != ":514" || listenAddr("off") != "" || listenAddr(":1514") != ":1514" {
+		t.Error("listenAddr")
+	}
+}
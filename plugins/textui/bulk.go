@@ -0,0 +1,148 @@
+package textui
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// bulkConcurrency bounds how many hosts a bulk operation works on at once.
+const bulkConcurrency = 4
+
+// bulkResultMsg reports one host's outcome of a bulk operation.
+type bulkResultMsg struct {
+	op      string
+	hostKey string
+	err     error
+}
+
+// toggleSelected flips the selection of the device under the cursor.
+func (m *model) toggleSelected() {
+	d := m.currentDevice()
+	if d == nil {
+		return
+	}
+	if m.selected[d.Key] {
+		delete(m.selected, d.Key)
+	} else {
+		m.selected[d.Key] = true
+	}
+}
+
+// selectVisible adds every currently visible device to the selection.
+// Selected devices hidden by the filter stay selected.
+func (m *model) selectVisible() {
+	for _, di := range m.visible {
+		m.selected[m.devices[di].Key] = true
+	}
+}
+
+// selectedDevices returns the selected devices in load order, including hidden ones.
+func (m *model) selectedDevices() []device {
+	var devs []device
+	for _, d := range m.devices {
+		if m.selected[d.Key] {
+			devs = append(devs, d)
+		}
+	}
+	return devs
+}
+
+// bulkCmd runs fn for every device concurrently, at most bulkConcurrency at a time,
+// producing one bulkResultMsg per device.
+func bulkCmd(op string, devs []device, fn func(d device) error) tea.Cmd {
+	sem := make(chan struct{}, bulkConcurrency)
+	cmds := make([]tea.Cmd, 0, len(devs))
+	for _, d := range devs {
+		d := d
+		cmds = append(cmds, func() tea.Msg {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			return bulkResultMsg{op: op, hostKey: d.Key, err: fn(d)}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// startBulk dispatches a bulk operation over the selection.
+func (m *model) startBulk(op string) tea.Cmd {
+	devs := m.selectedDevices()
+	if len(devs) == 0 {
+		m.statusMsg = "no hosts selected (space to select)"
+		return nil
+	}
+
+	var fn func(d device) error
+	switch op {
+	case "collect":
+		if m.collector == nil {
+			m.statusMsg = "collection is not available"
+			return nil
+		}
+		collector := m.collector
+		fn = func(d device) error { return collector.CollectHost(d.Key) }
+	case "ping":
+		if m.actions == nil {
+			m.statusMsg = "ping is not available"
+			return nil
+		}
+		actions := m.actions
+		fn = func(d device) error {
+			return actions.OnCommand("network", map[string]string{
+				"action": "ping",
+				"args":   fmt.Sprintf("host=%s address=%s", d.Key, d.Address),
+			})
+		}
+	default:
+		return nil
+	}
+
+	m.bulkPending += len(devs)
+	m.statusMsg = fmt.Sprintf("%s: %d hosts…", op, len(devs))
+	return bulkCmd(op, devs, fn)
+}
+
+// finishBulk logs one host's result and requests a refresh once the last result is in.
+func (m *model) finishBulk(msg bulkResultMsg) tea.Cmd {
+	entry := logEntry{At: time.Now(), Level: levelInfo, Text: fmt.Sprintf("%s %s: ok", msg.op, msg.hostKey)}
+	if msg.err != nil {
+		entry.Level = levelError
+		entry.Text = fmt.Sprintf("%s %s failed: %v", msg.op, msg.hostKey, msg.err)
+		m.bulkFailed++
+	}
+	if m.logs != nil {
+		m.logs.Add(entry)
+	}
+
+	m.bulkPending--
+	if m.bulkPending > 0 {
+		return nil
+	}
+	m.statusMsg = fmt.Sprintf("%s finished, %d failed", msg.op, m.bulkFailed)
+	m.bulkFailed = 0
+	return m.requestRefresh()
+}
+
+// exportSelection writes the selected devices and their statuses as JSON.
+func (m *model) exportSelection() {
+	devs := m.selectedDevices()
+	if len(devs) == 0 {
+		m.statusMsg = "no hosts selected (space to select)"
+		return
+	}
+	rows := statusRows(devs, time.Now())
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Key < rows[j].Key })
+
+	data, err := json.MarshalIndent(rows, "", "  ")
+	if err == nil {
+		var path string
+		if path, err = writeExport(fmt.Sprintf("selection-%s.json", time.Now().Format(exportStamp)), data); err == nil {
+			m.statusMsg = fmt.Sprintf("exported %d hosts to %s", len(rows), path)
+			return
+		}
+	}
+	m.statusMsg = fmt.Sprintf("export failed: %v", err)
+}
@@ -0,0 +1,198 @@
+package textui
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+// slowCollector records the most collections it saw running at once.
+type slowCollector struct {
+	mu           sync.Mutex
+	running, max int
+	hosts        []string
+	fail         map[string]bool
+}
+
+func (c *slowCollector) CollectHost(hostKey string) error {
+	c.mu.Lock()
+	c.running++
+	c.max = max(c.max, c.running)
+	c.hosts = append(c.hosts, hostKey)
+	c.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	if c.fail[hostKey] {
+		return errors.New("unreachable")
+	}
+	return nil
+}
+
+// runConcurrently runs a batch command's parts at once, as Bubble Tea does,
+// and returns their messages.
+func runConcurrently(t *testing.T, cmd tea.Cmd) []tea.Msg {
+	t.Helper()
+	batch, ok := cmd().(tea.BatchMsg)
+	if !ok {
+		t.Fatal("bulk command is not a batch")
+	}
+	msgs := make([]tea.Msg, len(batch))
+	var wg sync.WaitGroup
+	for i, c := range batch {
+		wg.Add(1)
+		go func(i int, c tea.Cmd) {
+			defer wg.Done()
+			msgs[i] = c()
+		}(i, c)
+	}
+	wg.Wait()
+	return msgs
+}
+
+func TestSelectionSurvivesFiltering(t *testing.T) {
+	m := newModel(fleet(), nil, nil)
+	m = press(t, m, " ") // core-1, cursor moves on to core-2
+	m = press(t, m, "/", "e", "d", "g", "enter")
+	if len(m.visible) != 1 {
+		t.Fatalf("visible %s", keysOf(m.devices, m.visible))
+	}
+	m = press(t, m, "a") // select everything shown: edge
+	if !m.selected["core-1"] || !m.selected["edge"] || len(m.selected) != 2 {
+		t.Errorf("selected %v", m.selected)
+	}
+	if !strings.Contains(m.View(), "2 selected") {
+		t.Error("header lacks the selection count")
+	}
+
+	// Hidden devices stay selected and are still acted on.
+	var keys []string
+	for _, d := range m.selectedDevices() {
+		keys = append(keys, d.Key)
+	}
+	if strings.Join(keys, " ") != "edge core-1" {
+		t.Errorf("selected devices %v", keys)
+	}
+
+	// Toggling deselects; u clears everything, hidden or not.
+	m = press(t, m, " ")
+	if m.selected["edge"] || !m.selected["core-1"] {
+		t.Errorf("after toggle %v", m.selected)
+	}
+	m = press(t, m, "u")
+	if len(m.selected) != 0 {
+		t.Errorf("after clear %v", m.selected)
+	}
+}
+
+func TestBulkCollectFansOut(t *testing.T) {
+	collector := &slowCollector{fail: map[string]bool{"h3": true, "h7": true}}
+	m := newModel(manyDevices(10), &statusSource{}, collector)
+	m.logs = newLogBuffer(20)
+	for i := range m.devices {
+		m.devices[i].Key = strings.Replace(m.devices[i].Key, "host-0", "h", 1)
+	}
+	m = press(t, m, "a")
+
+	m, cmd := send(t, m, keyMsg("C"))
+	if m.bulkPending != 10 || m.statusMsg != "collect: 10 hosts…" {
+		t.Fatalf("pending %d, status %q", m.bulkPending, m.statusMsg)
+	}
+	msgs := runConcurrently(t, cmd)
+	if collector.max > bulkConcurrency || collector.max < 2 {
+		t.Errorf("%d collections ran at once, limit %d", collector.max, bulkConcurrency)
+	}
+	if len(collector.hosts) != 10 {
+		t.Errorf("collected %v", collector.hosts)
+	}
+
+	var refresh tea.Cmd
+	for i, msg := range msgs {
+		m, refresh = send(t, m, msg)
+		if last := i == len(msgs)-1; (refresh != nil) != last {
+			t.Errorf("result %d: refresh requested %v", i, refresh != nil)
+		}
+	}
+	if m.bulkPending != 0 || m.statusMsg != "collect finished, 2 failed" {
+		t.Errorf("pending %d, status %q", m.bulkPending, m.statusMsg)
+	}
+	entries, _ := m.logs.Entries()
+	failed := 0
+	for _, e := range entries {
+		if e.Level == levelError {
+			failed++
+			if !strings.HasSuffix(e.Text, "failed: unreachable") {
+				t.Errorf("log %q", e.Text)
+			}
+		}
+	}
+	if len(entries) != 10 || failed != 2 {
+		t.Errorf("%d log entries, %d failed", len(entries), failed)
+	}
+}
+
+func TestBulkPingUsesNetworkPlugin(t *testing.T) {
+	network := &actionPlugin{name: "network"}
+	c := plugin.NewController()
+	c.AddPlugin(network)
+	devs := devicesFor("a", "b")
+	devs[1].Address = "10.0.0.2"
+	m := newModel(devs, nil, nil)
+	m.actions = c
+
+	m = press(t, m, "down", " ")
+	_, cmd := send(t, m, keyMsg("P"))
+	run(cmd)
+	if len(network.runs) != 1 || network.runs[0]["action"] != "ping" || network.runs[0]["args"] != "host=b address=10.0.0.2" {
+		t.Errorf("runs = %v", network.runs)
+	}
+}
+
+func TestBulkWithoutSelection(t *testing.T) {
+	m := newModel(devicesFor("a"), nil, &fakeCollector{})
+	for _, key := range []string{"C", "P", "E"} {
+		m, cmd := send(t, m, keyMsg(key))
+		if cmd != nil || m.statusMsg != "no hosts selected (space to select)" {
+			t.Errorf("%s: status %q", key, m.statusMsg)
+		}
+	}
+	m = press(t, m, " ", "P")
+	if m.statusMsg != "ping is not available" {
+		t.Errorf("status %q", m.statusMsg)
+	}
+}
+
+func TestExportSelection(t *testing.T) {
+	testenv.UseTempDirs(t)
+	m := newModel(fleet(), nil, nil)
+	m = press(t, m, " ", " ", "/", "z", "z", "enter") // hide both selected hosts
+	m = press(t, m, "E")
+
+	path := strings.TrimPrefix(m.statusMsg, "exported 2 hosts to ")
+	if path == m.statusMsg || !strings.HasPrefix(path, plugin.DataFile(exportDir)) || !strings.Contains(path, "selection-") {
+		t.Fatalf("status %q", m.statusMsg)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []statusRow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Key != "core-1" || rows[1].Key != "core-2" || rows[0].Status != "warning" {
+		t.Errorf("rows = %+v", rows)
+	}
+}
@@ -0,0 +1,62 @@
+package textui
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// hostCollector runs one host's collect tasks in-process.
+// *plugin.Controller satisfies it; tests can substitute a fake.
+type hostCollector interface {
+	CollectHost(hostKey string) error
+}
+
+// collectDoneMsg reports the end of an on-demand collection.
+type collectDoneMsg struct {
+	hostKey string
+	err     error
+}
+
+// collectCmd runs the collection off the UI goroutine and reports back with a collectDoneMsg.
+func collectCmd(c hostCollector, hostKey string) tea.Cmd {
+	return func() tea.Msg {
+		return collectDoneMsg{hostKey: hostKey, err: c.CollectHost(hostKey)}
+	}
+}
+
+// startCollect begins collecting a host unless a collection for it is already running.
+// Returns the commands to run: the collection itself, plus the spinner tick when it was idle.
+func (m *model) startCollect(hostKey string) tea.Cmd {
+	if m.collector == nil {
+		m.statusMsg = "collection is not available"
+		return nil
+	}
+	if m.collecting[hostKey] {
+		return nil // coalesce repeated triggers for the same host
+	}
+	if m.collecting == nil {
+		m.collecting = make(map[string]bool)
+	}
+	wasIdle := len(m.collecting) == 0
+	m.collecting[hostKey] = true
+	m.statusMsg = fmt.Sprintf("collecting %s…", hostKey)
+
+	cmds := []tea.Cmd{collectCmd(m.collector, hostKey)}
+	if wasIdle {
+		cmds = append(cmds, m.spinner.Tick)
+	}
+	return tea.Batch(cmds...)
+}
+
+// finishCollect applies a collectDoneMsg: clears the in-flight marker, reports the
+// outcome in the status bar, and requests a refresh of statuses and the open detail view.
+func (m *model) finishCollect(msg collectDoneMsg) tea.Cmd {
+	delete(m.collecting, msg.hostKey)
+	if msg.err != nil {
+		m.statusMsg = fmt.Sprintf("collect %s failed: %v", msg.hostKey, msg.err)
+		return nil
+	}
+	m.statusMsg = fmt.Sprintf("collected %s", msg.hostKey)
+	return m.requestRefresh()
+}
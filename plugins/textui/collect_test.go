@@ -0,0 +1,125 @@
+package textui
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// fakeCollector stands in for the controller; CollectHost counts calls per
+// host and returns err.
+type fakeCollector struct {
+	mu    sync.Mutex
+	calls map[string]int
+	err   error
+}
+
+func (f *fakeCollector) CollectHost(hostKey string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[hostKey]++
+	return f.err
+}
+
+// collectDone picks the collectDoneMsg out of msgs.
+func collectDone(t *testing.T, msgs []tea.Msg) collectDoneMsg {
+	t.Helper()
+	for _, msg := range msgs {
+		if done, ok := msg.(collectDoneMsg); ok {
+			return done
+		}
+	}
+	t.Fatalf("no collectDoneMsg in %v", msgs)
+	return collectDoneMsg{}
+}
+
+func TestCollectSelectedDevice(t *testing.T) {
+	fake := &fakeCollector{}
+	writeCollection(t, collectionFixture)
+	source := &statusSource{}
+	m := newModel(devicesFor("filehost", "other"), source, fake)
+
+	m, cmd := send(t, m, keyMsg("c"))
+	if cmd == nil || !m.collecting["filehost"] || m.statusMsg != "collecting filehost…" {
+		t.Fatalf("after c: collecting %v, status %q", m.collecting, m.statusMsg)
+	}
+	if !strings.Contains(m.View(), "collecting…") {
+		t.Error("the row shows no collecting indicator")
+	}
+
+	// A second trigger while the first is in flight is coalesced.
+	m, again := send(t, m, keyMsg("c"))
+	if again != nil {
+		t.Error("a second collection of the same host was started")
+	}
+
+	done := collectDone(t, run(cmd))
+	if done.hostKey != "filehost" || done.err != nil || fake.calls["filehost"] != 1 {
+		t.Fatalf("done %+v, calls %v", done, fake.calls)
+	}
+
+	m, cmd = send(t, m, done)
+	if len(m.collecting) != 0 || m.statusMsg != "collected filehost" {
+		t.Errorf("after done: collecting %v, status %q", m.collecting, m.statusMsg)
+	}
+	msgs := run(cmd)
+	if len(msgs) != 1 {
+		t.Fatalf("refresh after collect: %v", msgs)
+	}
+	m, _ = send(t, m, msgs[0])
+	if m.devices[0].Status != "up" || m.lastRefresh.IsZero() {
+		t.Errorf("after refresh: status %q, last refresh %v", m.devices[0].Status, m.lastRefresh)
+	}
+
+	// Once finished, the same host can be collected again.
+	if _, cmd := send(t, m, keyMsg("c")); cmd == nil {
+		t.Error("could not collect the host again")
+	}
+}
+
+func TestCollectFromDetailRefreshesMetrics(t *testing.T) {
+	fake := &fakeCollector{}
+	writeCollection(t, metricsFixture)
+	source := &statusSource{}
+	m := press(t, newModel(devicesFor("filehost"), source, fake), "enter")
+	m.metrics = nil // stale until the refresh lands
+
+	m, cmd := send(t, m, keyMsg("c"))
+	m, cmd = send(t, m, collectDone(t, run(cmd)))
+	for _, msg := range run(cmd) {
+		m, _ = send(t, m, msg)
+	}
+	if len(m.metrics) != 4 {
+		t.Errorf("detail metrics after collect: %+v", m.metrics)
+	}
+}
+
+func TestCollectFailureSurfacesInStatusBar(t *testing.T) {
+	fake := &fakeCollector{err: errors.New("ssh: handshake failed")}
+	m := newModel(devicesFor("a"), &statusSource{}, fake)
+
+	m, cmd := send(t, m, keyMsg("c"))
+	m, cmd = send(t, m, collectDone(t, run(cmd)))
+	if cmd != nil {
+		t.Error("a failed collection requested a refresh")
+	}
+	if len(m.collecting) != 0 || m.statusMsg != "collect a failed: ssh: handshake failed" {
+		t.Errorf("collecting %v, status %q", m.collecting, m.statusMsg)
+	}
+	if !strings.Contains(m.View(), "handshake failed") {
+		t.Error("the error is not in the status bar")
+	}
+}
+
+func TestCollectWithoutCollector(t *testing.T) {
+	m, cmd := send(t, newModel(devicesFor("a"), nil, nil), keyMsg("c"))
+	if cmd != nil || m.statusMsg != "collection is not available" {
+		t.Errorf("status %q", m.statusMsg)
+	}
+}
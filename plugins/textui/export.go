@@ -0,0 +1,179 @@
+package textui
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	plugin "observer/base"
+)
+
+// exportDir, under the data directory, is where exports of the current view and selection are written.
+const exportDir = "exports"
+
+// exportStamp is the timestamp layout used in export file names.
+const exportStamp = "20060102-150405"
+
+// unsafeFileChars matches characters replaced when a host key becomes part of a file name.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// listExport is the JSON shape of an exported device list.
+type listExport struct {
+	ExportedAt  time.Time   `json:"exported_at"`
+	RefreshedAt time.Time   `json:"refreshed_at"` // when the statuses were last re-read
+	Filter      string      `json:"filter,omitempty"`
+	Group       string      `json:"group"`
+	Sort        string      `json:"sort"`
+	Devices     []statusRow `json:"devices"`
+}
+
+// metricExport is one metric of an exported host detail.
+type metricExport struct {
+	Category    string     `json:"category"`
+	Name        string     `json:"name"`
+	Instance    string     `json:"instance,omitempty"`
+	Type        string     `json:"type"`
+	Value       string     `json:"value"`
+	ValueNum    *float64   `json:"value_num,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	CollectedAt *time.Time `json:"collected_at"` // null when unknown
+}
+
+// detailExport is the JSON shape of an exported host detail.
+type detailExport struct {
+	ExportedAt  time.Time      `json:"exported_at"`
+	RefreshedAt time.Time      `json:"refreshed_at"`
+	Host        statusRow      `json:"host"`
+	Metrics     []metricExport `json:"metrics"`
+}
+
+// exportView writes what is on screen — the filtered list or the open host's
+// metrics — as format ("json" or "csv") and returns the file path.
+func (m *model) exportView(format string, now time.Time) (string, error) {
+	var (
+		name string
+		data []byte
+		err  error
+	)
+	if m.selectedDevice != nil && m.mode != modeList {
+		name = "host-" + unsafeFileChars.ReplaceAllString(m.selectedDevice.Key, "_")
+		data, err = m.encodeDetail(format, now)
+	} else {
+		name = "devices"
+		data, err = m.encodeList(format, now)
+	}
+	if err != nil {
+		return "", err
+	}
+	return writeExport(fmt.Sprintf("%s-%s.%s", name, now.Format(exportStamp), format), data)
+}
+
+// encodeList encodes the visible devices in their current order.
+func (m *model) encodeList(format string, now time.Time) ([]byte, error) {
+	devs := make([]device, 0, len(m.visible))
+	for _, di := range m.visible {
+		devs = append(devs, m.devices[di])
+	}
+	rows := statusRows(devs, now)
+
+	if format == "json" {
+		return json.MarshalIndent(listExport{
+			ExportedAt:  now,
+			RefreshedAt: m.lastRefresh,
+			Filter:      m.filterQuery(),
+			Group:       m.selectedGroup(),
+			Sort:        m.sortOrder.String(),
+			Devices:     rows,
+		}, "", "  ")
+	}
+
+	records := [][]string{{"KEY", "NAME", "ADDRESS", "TYPE", "STATUS", "COLLECTED_AT", "REFRESHED_AT"}}
+	for _, r := range rows {
+		records = append(records, []string{r.Key, r.Name, r.Address, r.Type, r.Status, formatStamp(r.CollectedAt), m.lastRefresh.Format(time.RFC3339)})
+	}
+	return encodeCSV(records)
+}
+
+// encodeDetail encodes the open host's metrics.
+func (m *model) encodeDetail(format string, now time.Time) ([]byte, error) {
+	metrics := make([]metricExport, 0, len(m.metrics))
+	for _, r := range m.metrics {
+		e := metricExport{Category: r.Category, Name: r.Name, Instance: r.Instance, Type: r.Type, Value: r.Value, ValueNum: r.ValueNum, Unit: r.Unit}
+		if !r.At.IsZero() {
+			at := r.At
+			e.CollectedAt = &at
+		}
+		metrics = append(metrics, e)
+	}
+
+	if format == "json" {
+		return json.MarshalIndent(detailExport{
+			ExportedAt:  now,
+			RefreshedAt: m.lastRefresh,
+			Host:        statusRows([]device{*m.selectedDevice}, now)[0],
+			Metrics:     metrics,
+		}, "", "  ")
+	}
+
+	records := [][]string{{"CATEGORY", "NAME", "INSTANCE", "TYPE", "VALUE", "UNIT", "COLLECTED_AT", "REFRESHED_AT"}}
+	for _, e := range metrics {
+		records = append(records, []string{e.Category, e.Name, e.Instance, e.Type, e.Value, e.Unit, formatStamp(e.CollectedAt), m.lastRefresh.Format(time.RFC3339)})
+	}
+	return encodeCSV(records)
+}
+
+func encodeCSV(records [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// formatStamp renders an optional time as RFC 3339, or empty when unknown.
+func formatStamp(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// writeExport writes data to name under exportDir, creating the directory if needed.
+func writeExport(name string, data []byte) (string, error) {
+	dir := plugin.DataFile(exportDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// updateExportPrompt handles the format prompt shown after the export key.
+func (m *model) updateExportPrompt(key string) {
+	m.exportPrompt = false
+	var format string
+	switch key {
+	case "j", "J":
+		format = "json"
+	case "c", "C":
+		format = "csv"
+	default:
+		m.statusMsg = "export cancelled"
+		return
+	}
+	path, err := m.exportView(format, time.Now())
+	if err != nil {
+		m.statusMsg = fmt.Sprintf("export failed: %v", err)
+		return
+	}
+	m.statusMsg = "exported to " + path
+}
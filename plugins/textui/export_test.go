@@ -0,0 +1,128 @@
+package textui
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+var exportNow = time.Date(2024, 5, 1, 14, 30, 5, 0, time.UTC)
+
+func TestExportListJSON(t *testing.T) {
+	testenv.UseTempDirs(t)
+	m := newModel(fleet(), nil, nil)
+	m.lastRefresh = exportNow.Add(-time.Minute)
+	m = press(t, m, "/", "c", "o", "r", "e", "enter", "s")
+
+	path, err := m.exportView("json", exportNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(plugin.DataFile(exportDir), "devices-20240501-143005.json"); path != want {
+		t.Errorf("path %s, want %s", path, want)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got listExport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.ExportedAt.Equal(exportNow) || !got.RefreshedAt.Equal(m.lastRefresh) {
+		t.Errorf("stamps: exported %v, refreshed %v", got.ExportedAt, got.RefreshedAt)
+	}
+	if got.Filter != "core" || got.Group != allGroups || got.Sort != "status" {
+		t.Errorf("view: %+v", got)
+	}
+	// Only the visible devices, in the order shown: warning before up.
+	if len(got.Devices) != 2 || got.Devices[0].Key != "core-1" || got.Devices[1].Key != "core-2" || got.Devices[0].CollectedAt == nil {
+		t.Errorf("devices = %+v", got.Devices)
+	}
+}
+
+func TestExportDetailCSV(t *testing.T) {
+	testenv.UseTempDirs(t)
+	devs := devicesFor("sw/1:core")
+	m := press(t, newModel(devs, nil, nil), "enter")
+	m.lastRefresh = exportNow
+	at := exportNow.Add(-time.Hour)
+	num := 3.0
+	m.metrics = []metricRow{
+		{Category: "system", Name: "load", Type: "gauge", Value: "3", ValueNum: &num, At: at},
+		{Name: "motd", Type: "string", Value: "hello, \"world\""},
+	}
+
+	path, err := m.exportView("csv", exportNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "host-sw_1_core-20240501-143005.csv" {
+		t.Errorf("file name %s", filepath.Base(path))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"CATEGORY", "NAME", "INSTANCE", "TYPE", "VALUE", "UNIT", "COLLECTED_AT", "REFRESHED_AT"},
+		{"system", "load", "", "gauge", "3", "", "2024-05-01T13:30:05Z", "2024-05-01T14:30:05Z"},
+		{"", "motd", "", "string", `hello, "world"`, "", "", "2024-05-01T14:30:05Z"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("records = %q", records)
+	}
+	for i := range want {
+		if strings.Join(records[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("row %d = %q, want %q", i, records[i], want[i])
+		}
+	}
+}
+
+func TestExportPrompt(t *testing.T) {
+	testenv.UseTempDirs(t)
+	m := newModel(fleet(), nil, nil)
+
+	m = press(t, m, "e")
+	if !m.exportPrompt || !strings.Contains(m.statusMsg, "[j]son or [c]sv") {
+		t.Fatalf("prompt %v, status %q", m.exportPrompt, m.statusMsg)
+	}
+	// The prompt takes the next key, even one bound to an action.
+	m = press(t, m, "q")
+	if m.exportPrompt || m.statusMsg != "export cancelled" {
+		t.Errorf("status %q", m.statusMsg)
+	}
+
+	m = press(t, m, "e", "c")
+	path := strings.TrimPrefix(m.statusMsg, "exported to ")
+	if !strings.HasSuffix(path, ".csv") || !strings.Contains(path, "devices-") {
+		t.Fatalf("status %q", m.statusMsg)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportWriteError(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	// A file where the exports directory should be.
+	if err := os.WriteFile(filepath.Join(dir, exportDir), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := press(t, newModel(fleet(), nil, nil), "e", "j")
+	if !strings.HasPrefix(m.statusMsg, "export failed: create ") {
+		t.Errorf("status %q", m.statusMsg)
+	}
+}
@@ -0,0 +1,150 @@
+package textui
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+)
+
+// sortOrder selects how the device list is ordered.
+type sortOrder int
+
+const (
+	sortByName sortOrder = iota
+	sortByStatus
+	sortByAge
+	sortByAddress
+	sortOrderCount // number of orders, for cycling
+)
+
+// String returns the label shown in the list header.
+func (o sortOrder) String() string {
+	switch o {
+	case sortByStatus:
+		return "status"
+	case sortByAge:
+		return "last seen"
+	case sortByAddress:
+		return "address"
+	default:
+		return "name"
+	}
+}
+
+// statusSeverity ranks statuses so the most urgent sort first.
+func statusSeverity(status string) int {
+	switch status {
+	case "down":
+		return 0
+	case "warning":
+		return 1
+	case "unknown":
+		return 2
+	default: // "up"
+		return 3
+	}
+}
+
+// matchesFilter reports whether a device matches the case-insensitive query
+// against its name, address, type, and key.
+func matchesFilter(d device, query string) bool {
+	q := strings.ToLower(strings.TrimSpace(query))
+	if q == "" {
+		return true
+	}
+	for _, field := range []string{d.Name, d.Address, d.Type, d.Key} {
+		if strings.Contains(strings.ToLower(field), q) {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleDevices returns the indices of devices in group matching query, ordered by order.
+// Ties always fall back to name then key so the order is stable across refreshes.
+func visibleDevices(devs []device, query, group string, order sortOrder) []int {
+	idx := make([]int, 0, len(devs))
+	for i, d := range devs {
+		if inGroup(d, group) && matchesFilter(d, query) {
+			idx = append(idx, i)
+		}
+	}
+
+	sort.SliceStable(idx, func(a, b int) bool {
+		da, db := devs[idx[a]], devs[idx[b]]
+		switch order {
+		case sortByStatus:
+			if sa, sb := statusSeverity(da.Status), statusSeverity(db.Status); sa != sb {
+				return sa < sb
+			}
+		case sortByAge:
+			// Stalest first; devices that were never seen sort before everything.
+			if !da.StatusAt.Equal(db.StatusAt) {
+				return da.StatusAt.Before(db.StatusAt)
+			}
+		case sortByAddress:
+			if c := compareAddresses(da.Address, db.Address); c != 0 {
+				return c < 0
+			}
+		}
+		if na, nb := strings.ToLower(da.Name), strings.ToLower(db.Name); na != nb {
+			return na < nb
+		}
+		return da.Key < db.Key
+	})
+	return idx
+}
+
+// compareAddresses orders IP addresses numerically and anything else lexically after them.
+func compareAddresses(a, b string) int {
+	ipa, ipb := net.ParseIP(a), net.ParseIP(b)
+	switch {
+	case ipa != nil && ipb != nil:
+		return bytes.Compare(ipa.To16(), ipb.To16())
+	case ipa != nil:
+		return -1
+	case ipb != nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// currentDevice returns the device under the cursor, or nil when nothing is visible.
+func (m *model) currentDevice() *device {
+	if m.cursor < 0 || m.cursor >= len(m.visible) {
+		return nil
+	}
+	return &m.devices[m.visible[m.cursor]]
+}
+
+// applyView recounts the groups and recomputes the visible set, keeping the cursor on the same device
+// when it is still visible and clamping it into range otherwise.
+func (m *model) applyView() {
+	var keepKey string
+	if d := m.currentDevice(); d != nil {
+		keepKey = d.Key
+	}
+
+	m.rebuildGroups()
+	m.visible = visibleDevices(m.devices, m.filterQuery(), m.selectedGroup(), m.sortOrder)
+
+	for i, di := range m.visible {
+		if m.devices[di].Key == keepKey {
+			m.cursor = i
+			return
+		}
+	}
+	if m.cursor >= len(m.visible) {
+		m.cursor = len(m.visible) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+}
+
+// filterQuery returns the active filter text.
+func (m *model) filterQuery() string {
+	return m.filter.Value()
+}
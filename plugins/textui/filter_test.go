@@ -0,0 +1,135 @@
+package textui
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fleet is a fixture with name ties, equal statuses, and a non-IP address.
+func fleet() []device {
+	now := time.Now()
+	mk := func(key, name, addr, typ, status string, age time.Duration) device {
+		d := device{Key: key, Type: typ, Status: status}
+		d.Name, d.Address = name, addr
+		if age > 0 {
+			d.StatusAt = now.Add(-age)
+		}
+		return d
+	}
+	return []device{
+		mk("core-2", "core", "10.0.0.10", "ssh", "up", time.Minute),
+		mk("edge", "Edge", "10.0.0.9", "snmp", "down", time.Hour),
+		mk("core-1", "core", "10.0.0.2", "ssh", "warning", 2*time.Hour),
+		mk("nas", "nas", "nas.lan", "ssh", "up", 0),
+		mk("printer", "printer", "192.168.1.5", "snmp", "unknown", 10*time.Minute),
+	}
+}
+
+// keysOf lists the device keys at idx.
+func keysOf(devs []device, idx []int) string {
+	keys := make([]string, len(idx))
+	for i, di := range idx {
+		keys[i] = devs[di].Key
+	}
+	return strings.Join(keys, " ")
+}
+
+func TestVisibleDevicesSort(t *testing.T) {
+	devs := fleet()
+	for _, tc := range []struct {
+		order sortOrder
+		want  string
+	}{
+		// Ties on name fall back to the key.
+		{sortByName, "core-1 core-2 edge nas printer"},
+		{sortByStatus, "edge core-1 printer core-2 nas"},
+		// Never-seen first, then stalest.
+		{sortByAge, "nas core-1 edge printer core-2"},
+		// Numeric, not lexical; names after addresses.
+		{sortByAddress, "core-1 edge core-2 printer nas"},
+	} {
+		if got := keysOf(devs, visibleDevices(devs, "", allGroups, tc.order)); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.order, got, tc.want)
+		}
+	}
+}
+
+func TestVisibleDevicesFilter(t *testing.T) {
+	devs := fleet()
+	for query, want := range map[string]string{
+		"":          "core-1 core-2 edge nas printer",
+		"CORE":      "core-1 core-2",
+		"10.0.0.1":  "core-2",
+		"snmp":      "edge printer",
+		" nas ":     "nas",
+		"core-1":    "core-1",
+		"no-match":  "",
+		"192.168.1": "printer",
+	} {
+		if got := keysOf(devs, visibleDevices(devs, query, allGroups, sortByName)); got != want {
+			t.Errorf("filter %q: %q, want %q", query, got, want)
+		}
+	}
+	if got := keysOf(devs, visibleDevices(devs, "", "SNMP", sortByName)); got != "edge printer" {
+		t.Errorf("group snmp: %q", got)
+	}
+}
+
+func TestFilterKeepsCursor(t *testing.T) {
+	m := newModel(fleet(), nil, nil)
+	m = press(t, m, "down", "down") // edge
+	if d := m.currentDevice(); d == nil || d.Key != "edge" {
+		t.Fatalf("cursor on %+v", d)
+	}
+
+	m = press(t, m, "/", "e")
+	if !m.filtering || m.filterQuery() != "e" {
+		t.Fatalf("filtering %v, query %q", m.filtering, m.filterQuery())
+	}
+	if d := m.currentDevice(); d == nil || d.Key != "edge" {
+		t.Errorf("cursor left edge: %+v", d)
+	}
+	if !strings.Contains(m.View(), `filter: "e"  4/5 hosts`) {
+		t.Errorf("header:\n%s", m.View())
+	}
+
+	// Filtering edge away clamps the cursor into the smaller set.
+	m = press(t, m, "x", "x")
+	if len(m.visible) != 0 || m.cursor != 0 || m.currentDevice() != nil {
+		t.Errorf("visible %v, cursor %d", m.visible, m.cursor)
+	}
+	if !strings.Contains(m.View(), "No hosts match the filter.") {
+		t.Error("empty result has no message")
+	}
+
+	m = press(t, m, "backspace", "backspace", "backspace", "p", "r", "enter")
+	if m.filtering || m.filterQuery() != "pr" || keysOf(m.devices, m.visible) != "printer" {
+		t.Errorf("after enter: filtering %v, query %q, visible %s", m.filtering, m.filterQuery(), keysOf(m.devices, m.visible))
+	}
+
+	// Esc in the list clears a kept filter.
+	m = press(t, m, "esc")
+	if m.filterQuery() != "" || len(m.visible) != 5 {
+		t.Errorf("after esc: query %q, %d visible", m.filterQuery(), len(m.visible))
+	}
+	if d := m.currentDevice(); d == nil || d.Key != "printer" {
+		t.Errorf("cursor after clearing: %+v", d)
+	}
+}
+
+func TestSortKeyCyclesAndKeepsCursor(t *testing.T) {
+	m := newModel(fleet(), nil, nil)
+	m = press(t, m, "down") // core-2
+	var orders []string
+	for i := 0; i < int(sortOrderCount); i++ {
+		m = press(t, m, "s")
+		orders = append(orders, m.sortOrder.String())
+		if d := m.currentDevice(); d == nil || d.Key != "core-2" {
+			t.Errorf("sort %s moved the cursor to %+v", m.sortOrder, d)
+		}
+	}
+	if got := strings.Join(orders, ","); got != "status,last seen,address,name" {
+		t.Errorf("sort cycle = %s", got)
+	}
+}
@@ -0,0 +1,437 @@
+package textui
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"observer/store"
+)
+
+// flowQueryLimit bounds the top-talker samples read per refresh: enough for
+// two intervals of a few dozen exporters at the default top_n.
+const flowQueryLimit = 2000
+
+// flowSort selects what the top-talkers table is ranked by.
+type flowSort int
+
+const (
+	flowByBytes flowSort = iota
+	flowByPackets
+	flowSortCount // number of orders, for cycling
+)
+
+// String returns the label shown in the table header.
+func (s flowSort) String() string {
+	if s == flowByPackets {
+		return "packets"
+	}
+	return "bytes"
+}
+
+// flowTalker is a top talker of an exporter's latest interval, read back
+// from the flow plugin's top_talker metrics.
+type flowTalker struct {
+	Exporter string
+	Src      string
+	Dst      string
+	SrcName  string // empty when DNS enrichment is off or found no name
+	DstName  string
+	Bytes    uint64
+	Packets  uint64
+	Interval time.Duration // the aggregation interval the counts cover
+	At       time.Time     // end of the interval
+}
+
+// key identifies a talker across intervals, for pinning.
+func (t flowTalker) key() string {
+	return t.Exporter + "\x00" + t.Src + "\x00" + t.Dst
+}
+
+// flowView is the open top-talkers screen.
+type flowView struct {
+	talkers   []flowTalker          // latest interval of every exporter
+	exporters []string              // exporters in talkers, sorted
+	exporter  int                   // 0 shows every exporter, i selects exporters[i-1]
+	sortBy    flowSort              // ranking of the rows
+	pinned    map[string]flowTalker // pinned talkers, as last seen
+	cursor    int
+	offset    int
+	err       error
+	at        time.Time // when talkers were read
+	loading   bool
+	gen       int // tick generation; ticks of an earlier opening are dropped
+}
+
+// flowsMsg carries the talkers read by flowsCmd.
+type flowsMsg struct {
+	talkers []flowTalker
+	err     error
+	at      time.Time
+}
+
+// flowTickMsg fires once per flow interval while the view is open.
+type flowTickMsg struct{ gen int }
+
+// flowTalkers returns the latest interval's top talkers of every exporter
+// that wrote some since the given time, or nil when no store is configured.
+func (s *statusSource) flowTalkers(since time.Time) ([]flowTalker, error) {
+	if s == nil || s.store == nil {
+		return nil, nil
+	}
+	records, err := s.store.QueryMetrics(context.Background(), store.MetricFilter{
+		Plugin: "flow", Name: "top_talker", From: since, Limit: flowQueryLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return latestTalkers(records), nil
+}
+
+// latestTalkers keeps, per exporter, the records of its newest interval and
+// turns them into talkers ordered by exporter then bytes.
+func latestTalkers(records []store.MetricRecord) []flowTalker {
+	newest := make(map[string]time.Time)
+	for _, r := range records {
+		if r.CollectedAt.After(newest[r.HostKey]) {
+			newest[r.HostKey] = r.CollectedAt
+		}
+	}
+	var talkers []flowTalker
+	for _, r := range records {
+		if !r.CollectedAt.Equal(newest[r.HostKey]) {
+			continue
+		}
+		t := flowTalker{
+			Exporter: r.HostKey,
+			Src:      extraString(r.Extra, "src"),
+			Dst:      extraString(r.Extra, "dst"),
+			SrcName:  extraString(r.Extra, "src_name"),
+			DstName:  extraString(r.Extra, "dst_name"),
+			Packets:  uint64(extraNum(r.Extra, "packets")),
+			Interval: time.Duration(extraNum(r.Extra, "interval_seconds") * float64(time.Second)),
+			At:       r.CollectedAt,
+		}
+		if t.Src == "" || t.Dst == "" {
+			t.Src, t.Dst, _ = strings.Cut(r.Instance, " > ")
+		}
+		if r.ValueNum != nil && *r.ValueNum > 0 {
+			t.Bytes = uint64(*r.ValueNum)
+		}
+		talkers = append(talkers, t)
+	}
+	sort.SliceStable(talkers, func(i, j int) bool {
+		a, b := talkers[i], talkers[j]
+		if a.Exporter != b.Exporter {
+			return a.Exporter < b.Exporter
+		}
+		return a.Bytes > b.Bytes
+	})
+	return talkers
+}
+
+// extraString returns a string field of a metric's extra, "" when absent.
+func extraString(extra map[string]interface{}, key string) string {
+	s, _ := extra[key].(string)
+	return s
+}
+
+// extraNum returns a numeric field of a metric's extra, 0 when absent. Extra
+// read back from the store holds float64; records not yet stored may hold
+// integers.
+func extraNum(extra map[string]interface{}, key string) float64 {
+	switch v := extra[key].(type) {
+	case float64:
+		return v
+	case uint64:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+// flowsCmd reads the top talkers off the UI goroutine. Exporters that wrote
+// nothing for two intervals are taken as no longer reporting.
+func flowsCmd(source *statusSource, interval time.Duration) tea.Cmd {
+	return func() tea.Msg {
+		now := time.Now()
+		talkers, err := source.flowTalkers(now.Add(-2 * interval))
+		return flowsMsg{talkers: talkers, err: err, at: now}
+	}
+}
+
+// flowTickCmd schedules the next reload of the open view.
+func flowTickCmd(d time.Duration, gen int) tea.Cmd {
+	return tea.Tick(d, func(time.Time) tea.Msg { return flowTickMsg{gen: gen} })
+}
+
+// openFlows switches to the top-talkers view and starts reloading it every
+// flow interval. Pins and the sort order survive closing the view.
+func (m *model) openFlows() tea.Cmd {
+	if m.flows == nil {
+		m.flows = &flowView{pinned: make(map[string]flowTalker)}
+	}
+	m.flows.gen++
+	m.flows.loading = true
+	m.mode = modeFlows
+	return tea.Batch(flowsCmd(m.source, m.flowInterval), flowTickCmd(m.flowInterval, m.flows.gen))
+}
+
+// apply installs freshly read talkers, refreshing the pinned ones that
+// are still among them and keeping the exporter filter on the same exporter.
+func (fv *flowView) apply(msg flowsMsg) {
+	selected := fv.selectedExporter()
+	fv.loading = false
+	fv.err = msg.err
+	fv.at = msg.at
+	fv.talkers = msg.talkers
+
+	fv.exporters = fv.exporters[:0]
+	for i, t := range fv.talkers {
+		if i == 0 || fv.talkers[i-1].Exporter != t.Exporter {
+			fv.exporters = append(fv.exporters, t.Exporter)
+		}
+		if _, ok := fv.pinned[t.key()]; ok {
+			fv.pinned[t.key()] = t
+		}
+	}
+	fv.exporter = 0
+	for i, e := range fv.exporters {
+		if e == selected {
+			fv.exporter = i + 1
+		}
+	}
+	fv.cursor = max(min(fv.cursor, len(fv.rows())-1), 0)
+}
+
+// selectedExporter is the exporter the view is filtered to, "" for all.
+func (fv *flowView) selectedExporter() string {
+	if fv.exporter == 0 || fv.exporter > len(fv.exporters) {
+		return ""
+	}
+	return fv.exporters[fv.exporter-1]
+}
+
+// flowRow is a line of the table: a talker and whether it is pinned and in
+// the latest interval.
+type flowRow struct {
+	flowTalker
+	pinned  bool
+	current bool
+}
+
+// rows returns the talkers shown: pinned ones first, then the rest, each
+// ranked by the sort order. A pinned talker missing from the latest interval
+// stays listed with its last counts.
+func (fv *flowView) rows() []flowRow {
+	exporter := fv.selectedExporter()
+	seen := make(map[string]bool, len(fv.talkers))
+	var rows []flowRow
+	for _, t := range fv.talkers {
+		if exporter != "" && t.Exporter != exporter {
+			continue
+		}
+		_, pinned := fv.pinned[t.key()]
+		seen[t.key()] = true
+		rows = append(rows, flowRow{flowTalker: t, pinned: pinned, current: true})
+	}
+	for k, t := range fv.pinned {
+		if seen[k] || (exporter != "" && t.Exporter != exporter) {
+			continue
+		}
+		rows = append(rows, flowRow{flowTalker: t, pinned: true})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.pinned != b.pinned {
+			return a.pinned
+		}
+		if a.current != b.current {
+			return a.current
+		}
+		if fv.sortBy == flowByPackets && a.Packets != b.Packets {
+			return a.Packets > b.Packets
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Exporter != b.Exporter {
+			return a.Exporter < b.Exporter
+		}
+		return a.key() < b.key()
+	})
+	return rows
+}
+
+// updateFlows handles actions in the top-talkers view.
+func (m model) updateFlows(act action) (tea.Model, tea.Cmd) {
+	fv := m.flows
+	rows := fv.rows()
+	switch act {
+	case actUp:
+		if fv.cursor > 0 {
+			fv.cursor--
+		}
+	case actDown:
+		if fv.cursor < len(rows)-1 {
+			fv.cursor++
+		}
+	case actPageUp:
+		fv.cursor = max(fv.cursor-max(m.listHeight(), 1), 0)
+	case actPageDown:
+		fv.cursor = max(min(fv.cursor+max(m.listHeight(), 1), len(rows)-1), 0)
+	case actTop:
+		fv.cursor = 0
+	case actBottom:
+		fv.cursor = max(len(rows)-1, 0)
+	case actSort:
+		fv.sortBy = (fv.sortBy + 1) % flowSortCount
+	case actFocus:
+		// Step through the exporters, back to all of them after the last.
+		fv.exporter = (fv.exporter + 1) % (len(fv.exporters) + 1)
+		fv.cursor = 0
+	case actSelect:
+		if fv.cursor >= len(rows) {
+			return m, nil
+		}
+		t := rows[fv.cursor].flowTalker
+		if _, ok := fv.pinned[t.key()]; ok {
+			delete(fv.pinned, t.key())
+			m.statusMsg = fmt.Sprintf("unpinned %s > %s", t.Src, t.Dst)
+		} else {
+			fv.pinned[t.key()] = t
+			m.statusMsg = fmt.Sprintf("pinned %s > %s", t.Src, t.Dst)
+		}
+	case actRefresh:
+		if !fv.loading {
+			fv.loading = true
+			return m, flowsCmd(m.source, m.flowInterval)
+		}
+	case actBack:
+		m.mode = modeList
+	}
+	return m, nil
+}
+
+// viewFlows renders the top-talkers table.
+func (m *model) viewFlows() string {
+	fv := m.flows
+	var s strings.Builder
+	s.WriteString(titleStyle.Render("Top Talkers") + "\n\n")
+	rows := fv.rows()
+	switch {
+	case fv.err != nil:
+		s.WriteString(downStyle.Render(fmt.Sprintf("Could not load top talkers: %v", fv.err)) + "\n")
+	case len(rows) == 0 && (m.source == nil || m.source.store == nil):
+		s.WriteString("No database is configured, so there are no top talkers to show.\n")
+		s.WriteString(m.help("Top talkers are read from the store the flow collector writes them to.") + "\n")
+	case len(rows) == 0 && fv.loading:
+		s.WriteString("Loading top talkers…\n")
+	case len(rows) == 0:
+		s.WriteString(fmt.Sprintf("No top talkers in the last %s: the flow collector is not running or receives no flows.\n", 2*m.flowInterval))
+		s.WriteString(m.help("Start `nord flow`, or enable daemon.flow in the config and run `nord daemon`, and point exporters at it.") + "\n")
+	default:
+		exporter := fv.selectedExporter()
+		if exporter == "" {
+			exporter = fmt.Sprintf("all (%d)", len(fv.exporters))
+		}
+		s.WriteString(helpStyle.Render(fmt.Sprintf("exporter: %s  sort: %s  %d pinned  interval ending %s",
+			exporter, fv.sortBy, len(fv.pinned), latestInterval(rows).Format("15:04:05"))) + "\n")
+		// Indent the header like the rows under it.
+		s.WriteString(clipLine(strings.Repeat(" ", itemStyle.GetPaddingLeft())+flowHeader, m.rowWidth()) + "\n")
+		lines := make([]string, len(rows))
+		for i, r := range rows {
+			style := itemStyle
+			if i == fv.cursor {
+				style = selectedItemStyle
+			}
+			style = style.UnsetWidth()
+			lines[i] = style.Render(clipLine(formatFlowRow(r), m.rowWidth()-style.GetHorizontalPadding()))
+		}
+		fv.offset = scrollWindow(fv.offset, fv.cursor, len(lines), m.listHeight()-1)
+		s.WriteString(windowLines(lines, fv.offset, m.listHeight()-1) + "\n")
+	}
+	s.WriteString("\n" + m.help("Press "+m.keys.helpText(
+		helpItem{actSelect, "to pin or unpin"}, helpItem{actFocus, "to switch exporter"}, helpItem{actSort, "to sort by bytes or packets"},
+		helpItem{actRefresh, "to reload"}, helpItem{actBack, "to go back to list"}, helpItem{actQuit, "to quit"})) + "\n")
+	return s.String()
+}
+
+// latestInterval returns the end of the newest interval among rows.
+func latestInterval(rows []flowRow) time.Time {
+	var at time.Time
+	for _, r := range rows {
+		if r.At.After(at) {
+			at = r.At
+		}
+	}
+	return at
+}
+
+// flowHeader labels the columns of formatFlowRow.
+var flowHeader = fmt.Sprintf("  %-15s %-28s %-28s %10s %10s %9s", "EXPORTER", "SOURCE", "DESTINATION", "RATE", "PACKETS/S", "BYTES")
+
+// formatFlowRow renders one talker with its rates over the interval. Pinned
+// talkers are marked "*"; one missing from the latest interval shows when it
+// was last seen.
+func formatFlowRow(r flowRow) string {
+	marker := "  "
+	if r.pinned {
+		marker = "* "
+	}
+	pad := func(s string, w int) string { return fmt.Sprintf("%-*s", w, truncateText(s, w)) }
+	row := fmt.Sprintf("%s%s %s %s %10s %10s %9s", marker, pad(r.Exporter, 15),
+		pad(endpointLabel(r.Src, r.SrcName), 28), pad(endpointLabel(r.Dst, r.DstName), 28),
+		formatBitRate(r.Bytes, r.Interval), formatPacketRate(r.Packets, r.Interval), formatBytes(r.Bytes))
+	if !r.current {
+		row += "  (last seen " + r.At.Local().Format("15:04:05") + ")"
+	}
+	return row
+}
+
+// endpointLabel shows an address by its DNS name when one is known.
+func endpointLabel(addr, name string) string {
+	if name == "" {
+		return addr
+	}
+	return name + " (" + addr + ")"
+}
+
+// formatBitRate renders bytes sent over an interval as a bit rate, e.g. "12.5 Mbps".
+func formatBitRate(bytes uint64, interval time.Duration) string {
+	if interval <= 0 {
+		return "-"
+	}
+	bps := int64(float64(bytes) * 8 / interval.Seconds())
+	return formatSpeed(&bps)
+}
+
+// formatPacketRate renders packets sent over an interval per second.
+func formatPacketRate(packets uint64, interval time.Duration) string {
+	if interval <= 0 {
+		return "-"
+	}
+	return formatNum(float64(packets)/interval.Seconds()) + " pps"
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n uint64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, unit := float64(n)/1024, "KiB"
+	for _, next := range []string{"MiB", "GiB", "TiB"} {
+		if v < 1024 {
+			break
+		}
+		v, unit = v/1024, next
+	}
+	return fmt.Sprintf("%.1f %s", v, unit)
+}
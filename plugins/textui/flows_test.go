@@ -0,0 +1,247 @@
+package textui
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"observer/store"
+)
+
+// flowStore serves top_talker records as the flow plugin stores them; tests
+// swap snapshot between intervals.
+type flowStore struct {
+	store.Store
+	snapshot []store.MetricRecord
+	filter   store.MetricFilter // of the last QueryMetrics call
+	err      error
+}
+
+func (f *flowStore) QueryMetrics(ctx context.Context, filter store.MetricFilter) ([]store.MetricRecord, error) {
+	f.filter = filter
+	var out []store.MetricRecord
+	for _, r := range f.snapshot {
+		if !r.CollectedAt.Before(filter.From) {
+			out = append(out, r)
+		}
+	}
+	return out, f.err
+}
+
+// talker builds a top_talker record of a one-minute interval ending at, as
+// read back from the store. names holds the source and destination DNS
+// names, when known.
+func talker(exporter, src, dst string, bytes, packets float64, at time.Time, names ...string) store.MetricRecord {
+	extra := map[string]interface{}{"src": src, "dst": dst, "packets": packets, "interval_seconds": 60.0}
+	if len(names) > 0 {
+		extra["src_name"] = names[0]
+	}
+	if len(names) > 1 {
+		extra["dst_name"] = names[1]
+	}
+	return store.MetricRecord{
+		HostKey: exporter, Plugin: "flow", Name: "top_talker", Instance: src + " > " + dst,
+		Value: strconv.FormatFloat(bytes, 'f', -1, 64), ValueNum: &bytes, Extra: extra, CollectedAt: at,
+	}
+}
+
+// talkerRows lists the view's rows as "exporter src>dst", pinned ones
+// marked "*".
+func talkerRows(fv *flowView) string {
+	var rows []string
+	for _, r := range fv.rows() {
+		row := r.Exporter + " " + r.Src + ">" + r.Dst
+		if r.pinned {
+			row = "*" + row
+		}
+		rows = append(rows, row)
+	}
+	return strings.Join(rows, ", ")
+}
+
+// loadFlows reads the talkers as the view's reload does and feeds them
+// through Update.
+func loadFlows(t *testing.T, m model) model {
+	t.Helper()
+	m, _ = send(t, m, flowsCmd(m.source, m.flowInterval)())
+	return m
+}
+
+func TestLatestTalkers(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	zero := 0.0
+	records := []store.MetricRecord{
+		talker("edge", "192.0.2.7", "198.51.100.7", 99e6, 1, at.Add(-time.Minute)), // an older interval
+		talker("edge", "192.0.2.3", "198.51.100.3", 900, 9, at),
+		talker("edge", "192.0.2.2", "198.51.100.2", 5000, 5, at, "big.example"),
+		talker("core", "192.0.2.9", "198.51.100.9", 300, 2, at.Add(-time.Minute)),
+		// Not yet stored: integer extras, and no src or dst fields.
+		{HostKey: "lab", Instance: "10.0.0.1 > 10.0.0.2", Value: "0", ValueNum: &zero,
+			Extra: map[string]interface{}{"packets": uint64(4), "interval_seconds": 30}, CollectedAt: at},
+	}
+	talkers := latestTalkers(records)
+	var got []string
+	for _, tk := range talkers {
+		got = append(got, tk.Exporter+" "+tk.Src+">"+tk.Dst+" "+tk.SrcName)
+	}
+	want := "core 192.0.2.9>198.51.100.9 |edge 192.0.2.2>198.51.100.2 big.example|edge 192.0.2.3>198.51.100.3 |lab 10.0.0.1>10.0.0.2 "
+	if strings.Join(got, "|") != want {
+		t.Errorf("talkers %q", got)
+	}
+	if lab := talkers[3]; lab.Packets != 4 || lab.Interval != 30*time.Second || lab.Bytes != 0 {
+		t.Errorf("lab = %+v", lab)
+	}
+	if edge := talkers[1]; edge.Bytes != 5000 || edge.Packets != 5 || edge.Interval != time.Minute || !edge.At.Equal(at) {
+		t.Errorf("edge = %+v", edge)
+	}
+}
+
+func TestFlowsView(t *testing.T) {
+	t0 := time.Now().Add(-30 * time.Second)
+	fake := &flowStore{snapshot: []store.MetricRecord{
+		talker("edge", "192.0.2.7", "198.51.100.7", 99e6, 1, t0.Add(-time.Minute)),
+		talker("edge", "192.0.2.2", "198.51.100.2", 7.5e6, 6000, t0, "big.example", "peer.example"),
+		talker("edge", "192.0.2.3", "198.51.100.3", 900e3, 9000, t0),
+		talker("core", "192.0.2.9", "198.51.100.9", 300, 2, t0),
+	}}
+	m := newModel(devicesFor("a"), &statusSource{store: fake}, nil)
+	m, _ = send(t, m, tea.WindowSizeMsg{Width: 160, Height: 40})
+	m, cmd := send(t, m, keyMsg("F"))
+	if m.mode != modeFlows || cmd == nil || !m.flows.loading {
+		t.Fatalf("mode %v, loading %v", m.mode, m.flows != nil && m.flows.loading)
+	}
+	if view := m.View(); !strings.Contains(view, "Loading top talkers") {
+		t.Errorf("before the first load:\n%s", view)
+	}
+
+	m = loadFlows(t, m)
+	if fake.filter.Plugin != "flow" || fake.filter.Name != "top_talker" || fake.filter.Limit != flowQueryLimit ||
+		time.Since(fake.filter.From) < 2*time.Minute-time.Second {
+		t.Errorf("query %+v", fake.filter)
+	}
+	fv := m.flows
+	if got := talkerRows(fv); got != "edge 192.0.2.2>198.51.100.2, edge 192.0.2.3>198.51.100.3, core 192.0.2.9>198.51.100.9" {
+		t.Errorf("by bytes: %s", got)
+	}
+	view := m.View()
+	for _, want := range []string{
+		"exporter: all (2)  sort: bytes  0 pinned",
+		"big.example (192.0.2.2)",
+		"peer.example (198.51.100.2)",
+		"1 Mbps", "100 pps", "7.2 MiB", // 7.5 MB over a minute
+	} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view lacks %q:\n%s", want, view)
+		}
+	}
+
+	m = press(t, m, "s")
+	if got := talkerRows(fv); !strings.HasPrefix(got, "edge 192.0.2.3>") || !strings.Contains(m.View(), "sort: packets") {
+		t.Errorf("by packets: %s", got)
+	}
+	m = press(t, m, "s", "tab")
+	if got := talkerRows(fv); got != "core 192.0.2.9>198.51.100.9" {
+		t.Errorf("core only: %s", got)
+	}
+	m = press(t, m, "tab", "enter")
+	if m.statusMsg != "pinned 192.0.2.2 > 198.51.100.2" || !strings.Contains(m.View(), "exporter: edge  sort: bytes  1 pinned") {
+		t.Errorf("status %q:\n%s", m.statusMsg, m.View())
+	}
+
+	// The next interval: the pinned talker dropped out, the filter stays on edge.
+	t1 := t0.Add(time.Minute)
+	fake.snapshot = []store.MetricRecord{
+		talker("edge", "192.0.2.3", "198.51.100.3", 2e6, 100, t1),
+		talker("edge", "192.0.2.4", "198.51.100.4", 1e6, 50, t1),
+		talker("core", "192.0.2.9", "198.51.100.9", 300, 2, t1),
+	}
+	m = loadFlows(t, m)
+	if got := talkerRows(fv); got != "*edge 192.0.2.2>198.51.100.2, edge 192.0.2.3>198.51.100.3, edge 192.0.2.4>198.51.100.4" {
+		t.Errorf("after an interval: %s", got)
+	}
+	if view := m.View(); !strings.Contains(view, "* edge") || !strings.Contains(view, "(last seen "+t0.Local().Format("15:04:05")+")") {
+		t.Errorf("pinned row:\n%s", view)
+	}
+	// It comes back with fresh counts.
+	fake.snapshot = append(fake.snapshot, talker("edge", "192.0.2.2", "198.51.100.2", 3e6, 10, t1))
+	m = loadFlows(t, m)
+	if p := fv.pinned["edge\x00192.0.2.2\x00198.51.100.2"]; p.Bytes != 3e6 || !p.At.Equal(t1) {
+		t.Errorf("pinned talker = %+v", p)
+	}
+
+	// An exporter that stops reporting drops the filter, not the pins.
+	fake.snapshot = fake.snapshot[2:3]
+	m = loadFlows(t, m)
+	if fv.selectedExporter() != "" || talkerRows(fv) != "*edge 192.0.2.2>198.51.100.2, core 192.0.2.9>198.51.100.9" {
+		t.Errorf("edge gone: exporter %q, rows %s", fv.selectedExporter(), talkerRows(fv))
+	}
+	m = press(t, m, "enter")
+	if m.statusMsg != "unpinned 192.0.2.2 > 198.51.100.2" || len(fv.pinned) != 0 {
+		t.Errorf("status %q, pinned %v", m.statusMsg, fv.pinned)
+	}
+
+	// Ticks reload while the view is open, and only those of this opening.
+	if _, cmd := send(t, m, flowTickMsg{gen: fv.gen - 1}); cmd != nil {
+		t.Error("stale tick reloaded")
+	}
+	fv.loading = false
+	if m, cmd := send(t, m, flowTickMsg{gen: fv.gen}); cmd == nil || !m.flows.loading {
+		t.Error("tick did not reload")
+	}
+	m = press(t, m, "s", "esc")
+	if _, cmd := send(t, m, flowTickMsg{gen: fv.gen}); m.mode != modeList || cmd != nil {
+		t.Errorf("closed view: mode %v, reloaded %v", m.mode, cmd != nil)
+	}
+	m = press(t, m, "F")
+	if m.flows != fv || fv.sortBy != flowByPackets || fv.gen != 2 {
+		t.Errorf("reopened: %+v", m.flows)
+	}
+}
+
+func TestFlowsViewUnavailable(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		source *statusSource
+		want   string
+	}{
+		{"no store", &statusSource{}, "No database is configured"},
+		{"no flows", &statusSource{store: &flowStore{}}, "No top talkers in the last 2m0s: the flow collector is not running"},
+		{"store error", &statusSource{store: &flowStore{err: errors.New("database is locked")}}, "Could not load top talkers: database is locked"},
+	} {
+		m := newModel(devicesFor("a"), tt.source, nil)
+		m = loadFlows(t, press(t, m, "F"))
+		if view := m.View(); !strings.Contains(view, tt.want) {
+			t.Errorf("%s:\n%s", tt.name, view)
+		}
+		// Keys on an empty table do nothing.
+		m = press(t, m, "enter", "down", "tab", "s")
+		if m.mode != modeFlows || len(m.flows.pinned) != 0 {
+			t.Errorf("%s: mode %v, pinned %v", tt.name, m.mode, m.flows.pinned)
+		}
+	}
+}
+
+func TestFlowFormatting(t *testing.T) {
+	for _, tt := range []struct{ got, want string }{
+		{formatBitRate(7500000, time.Minute), "1 Mbps"},
+		{formatBitRate(1, time.Minute), "0 bps"},
+		{formatBitRate(1000, 0), "-"},
+		{formatPacketRate(90, time.Minute), "1.5 pps"},
+		{formatPacketRate(90, 0), "-"},
+		{formatBytes(1023), "1023 B"},
+		{formatBytes(1536), "1.5 KiB"},
+		{formatBytes(5 << 40), "5.0 TiB"},
+		{formatBytes(3 << 50), "3072.0 TiB"},
+		{endpointLabel("192.0.2.1", ""), "192.0.2.1"},
+		{endpointLabel("192.0.2.1", "gw.example"), "gw.example (192.0.2.1)"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package textui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// allGroups is the pseudo-group that shows every device.
+const allGroups = "All"
+
+// groupPaneWidth is the width of the group pane, including its right margin.
+const groupPaneWidth = 26
+
+// group is one entry of the group pane with per-status device counts.
+type group struct {
+	Name                       string
+	Up, Warning, Down, Unknown int
+}
+
+// Total returns the number of devices in the group.
+func (g group) Total() int {
+	return g.Up + g.Warning + g.Down + g.Unknown
+}
+
+func (g *group) count(status string) {
+	switch status {
+	case "up":
+		g.Up++
+	case "warning":
+		g.Warning++
+	case "down":
+		g.Down++
+	default:
+		g.Unknown++
+	}
+}
+
+// deviceGroups returns the groups a device belongs to: its configured groups,
+// or its credential type when none are configured.
+func deviceGroups(d device) []string {
+	if len(d.Groups) > 0 {
+		return d.Groups
+	}
+	return []string{d.Type}
+}
+
+// inGroup reports whether a device belongs to name; every device is in allGroups.
+func inGroup(d device, name string) bool {
+	if name == "" || name == allGroups {
+		return true
+	}
+	for _, g := range deviceGroups(d) {
+		if strings.EqualFold(g, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildGroups returns the "All" entry followed by every group sorted by name.
+func buildGroups(devs []device) []group {
+	all := group{Name: allGroups}
+	byName := make(map[string]*group)
+	var names []string
+	for _, d := range devs {
+		all.count(d.Status)
+		for _, name := range deviceGroups(d) {
+			key := strings.ToLower(name)
+			g, ok := byName[key]
+			if !ok {
+				g = &group{Name: name}
+				byName[key] = g
+				names = append(names, key)
+			}
+			g.count(d.Status)
+		}
+	}
+	sort.Strings(names)
+
+	groups := make([]group, 0, len(names)+1)
+	groups = append(groups, all)
+	for _, key := range names {
+		groups = append(groups, *byName[key])
+	}
+	return groups
+}
+
+// selectedGroup returns the name of the group under the group cursor.
+func (m *model) selectedGroup() string {
+	if m.groupCursor < 0 || m.groupCursor >= len(m.groups) {
+		return allGroups
+	}
+	return m.groups[m.groupCursor].Name
+}
+
+// rebuildGroups recounts the groups, keeping the cursor on the same group when it still exists.
+func (m *model) rebuildGroups() {
+	current := m.selectedGroup()
+	m.groups = buildGroups(m.devices)
+	m.groupCursor = 0
+	for i, g := range m.groups {
+		if strings.EqualFold(g.Name, current) {
+			m.groupCursor = i
+			break
+		}
+	}
+}
+
+// renderGroupPane renders the group list with colored up/warning/down counts.
+func (m *model) renderGroupPane() string {
+	lines := make([]string, 0, len(m.groups))
+	for i, g := range m.groups {
+		marker := "  "
+		if i == m.groupCursor {
+			marker = "> "
+		}
+		name := truncateText(g.Name, 12)
+		counts := fmt.Sprintf("%s/%s/%s",
+			upStyle.Render(fmt.Sprint(g.Up)),
+			warningStyle.Render(fmt.Sprint(g.Warning)),
+			downStyle.Render(fmt.Sprint(g.Down)))
+		line := fmt.Sprintf("%s%-12s %s", marker, name, counts)
+		if i == m.groupCursor && m.focusGroups {
+			line = selectedItemStyle.Copy().Width(0).PaddingLeft(0).Render(fmt.Sprintf("%s%-12s", marker, name)) + " " + counts
+		}
+		lines = append(lines, line)
+	}
+	height := m.listHeight()
+	offset := scrollWindow(0, m.groupCursor, len(lines), height)
+	return windowLines(lines, offset, height)
+}
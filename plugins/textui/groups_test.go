@@ -0,0 +1,111 @@
+package textui
+
+import (
+	"testing"
+	"time"
+
+	"observer/store"
+)
+
+// groupedFleet has configured groups, a device in two groups, and
+// devices that fall back to their credential type.
+func groupedFleet() []device {
+	mk := func(key, typ, status string, groups ...string) device {
+		d := device{Key: key, Type: typ, Status: status}
+		d.Name, d.Groups = key, groups
+		return d
+	}
+	return []device{
+		mk("core", "ssh", "up", "Network", "Critical"),
+		mk("edge", "snmp", "down", "network"),
+		mk("db", "ssh", "warning", "critical"),
+		mk("nas", "ssh", "unknown"),
+		mk("printer", "snmp", "up"),
+	}
+}
+
+func TestBuildGroups(t *testing.T) {
+	groups := buildGroups(groupedFleet())
+	want := []group{
+		{Name: allGroups, Up: 2, Warning: 1, Down: 1, Unknown: 1},
+		{Name: "Critical", Up: 1, Warning: 1},
+		{Name: "Network", Up: 1, Down: 1}, // "network" merges case-insensitively
+		{Name: "snmp", Up: 1},
+		{Name: "ssh", Unknown: 1},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("groups = %+v", groups)
+	}
+	for i := range want {
+		if groups[i] != want[i] {
+			t.Errorf("group %d = %+v, want %+v", i, groups[i], want[i])
+		}
+	}
+	if groups[0].Total() != 5 {
+		t.Errorf("All total %d", groups[0].Total())
+	}
+}
+
+func TestInGroup(t *testing.T) {
+	devs := groupedFleet()
+	for _, tc := range []struct {
+		dev   int
+		group string
+		want  bool
+	}{
+		{0, "", true},
+		{0, allGroups, true},
+		{0, "critical", true},
+		{0, "ssh", false}, // configured groups replace the credential type
+		{3, "SSH", true},
+		{4, "network", false},
+	} {
+		if got := inGroup(devs[tc.dev], tc.group); got != tc.want {
+			t.Errorf("inGroup(%s, %q) = %v", devs[tc.dev].Key, tc.group, got)
+		}
+	}
+}
+
+func TestGroupPaneFiltersList(t *testing.T) {
+	m := newModel(groupedFleet(), nil, nil)
+	if m.selectedGroup() != allGroups || len(m.visible) != 5 {
+		t.Fatalf("group %q, %d visible", m.selectedGroup(), len(m.visible))
+	}
+
+	m = press(t, m, "tab", "down", "down")
+	if !m.focusGroups || m.selectedGroup() != "Network" || keysOf(m.devices, m.visible) != "core edge" {
+		t.Errorf("group %q, visible %s", m.selectedGroup(), keysOf(m.devices, m.visible))
+	}
+
+	// Back in the list, the filter text narrows the group further.
+	m = press(t, m, "tab", "/", "e", "d", "enter")
+	if m.focusGroups || keysOf(m.devices, m.visible) != "edge" {
+		t.Errorf("focus %v, visible %s", m.focusGroups, keysOf(m.devices, m.visible))
+	}
+	m = press(t, m, "esc", "tab", "up", "up", "up", "enter")
+	if m.selectedGroup() != allGroups || len(m.visible) != 5 || m.mode != modeList {
+		t.Errorf("group %q, %d visible, mode %v", m.selectedGroup(), len(m.visible), m.mode)
+	}
+}
+
+func TestGroupCountsUpdateOnRefresh(t *testing.T) {
+	now := time.Now()
+	fake := &fakeStore{latest: map[string][]store.MetricRecord{
+		"core": {statusRecord("ping", "down", now)},
+		"edge": {statusRecord("ping", "up", now)},
+	}}
+	m := newModel(groupedFleet(), &statusSource{store: fake}, nil)
+	m = press(t, m, "tab", "down", "down", "tab")
+
+	m, cmd := send(t, m, keyMsg("r"))
+	m, _ = send(t, m, run(cmd)[0])
+	if m.selectedGroup() != "Network" {
+		t.Fatalf("refresh moved the group cursor to %q", m.selectedGroup())
+	}
+	if g := m.groups[m.groupCursor]; g.Up != 1 || g.Down != 1 || g.Warning != 0 {
+		t.Errorf("Network after refresh = %+v", g)
+	}
+	if all := m.groups[0]; all.Unknown != 3 || all.Up != 1 || all.Down != 1 {
+		t.Errorf("All after refresh = %+v", all)
+	}
+}
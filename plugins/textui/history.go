@@ -0,0 +1,212 @@
+package textui
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"observer/store"
+)
+
+// historyRanges are the selectable sparkline time ranges, stepped with +/-.
+var historyRanges = []time.Duration{
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+}
+
+// defaultHistoryRange indexes historyRanges.
+const defaultHistoryRange = 1
+
+// sparkBlocks are the sparkline levels, lowest first.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// defaultSparkWidth is the sparkline width before the terminal size is known.
+const defaultSparkWidth = 60
+
+// historyPoint is one numeric sample of a series.
+type historyPoint struct {
+	At    time.Time
+	Value float64
+}
+
+// metricHistory returns the numeric samples of a series since the given time,
+// or nil when no store is configured.
+func (s *statusSource) metricHistory(hostKey string, r metricRow, since time.Time) ([]historyPoint, error) {
+	if s == nil || s.store == nil {
+		return nil, nil
+	}
+	records, err := s.store.MetricHistory(context.Background(), hostKey, r.Plugin, r.Name, r.Instance, since)
+	if err != nil {
+		return nil, err
+	}
+	return historyPoints(records), nil
+}
+
+// historyPoints keeps the samples that carry a numeric value.
+func historyPoints(records []store.MetricRecord) []historyPoint {
+	points := make([]historyPoint, 0, len(records))
+	for _, r := range records {
+		if r.ValueNum == nil {
+			continue
+		}
+		points = append(points, historyPoint{At: r.CollectedAt, Value: *r.ValueNum})
+	}
+	return points
+}
+
+// bucketPoints averages points into width equal time buckets spanning [from, to).
+// Buckets without samples are NaN so they render as gaps.
+func bucketPoints(points []historyPoint, from, to time.Time, width int) []float64 {
+	buckets := make([]float64, width)
+	counts := make([]int, width)
+	span := to.Sub(from)
+	for _, p := range points {
+		if p.At.Before(from) || !p.At.Before(to) || span <= 0 {
+			continue
+		}
+		i := int(int64(p.At.Sub(from)) * int64(width) / int64(span))
+		buckets[i] += p.Value
+		counts[i]++
+	}
+	for i := range buckets {
+		if counts[i] == 0 {
+			buckets[i] = math.NaN()
+		} else {
+			buckets[i] /= float64(counts[i])
+		}
+	}
+	return buckets
+}
+
+// sparkline renders values scaled between their min and max. NaN values are
+// gaps; a flat series renders at mid height.
+func sparkline(values []float64) string {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		switch {
+		case math.IsNaN(v):
+			b.WriteRune(' ')
+		case hi == lo:
+			b.WriteRune(sparkBlocks[len(sparkBlocks)/2-1])
+		default:
+			level := int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+			b.WriteRune(sparkBlocks[level])
+		}
+	}
+	return b.String()
+}
+
+// openHistory loads the history of the metric under the cursor and switches to the sparkline view.
+func (m *model) openHistory() {
+	if m.metricCursor >= len(m.metrics) {
+		return
+	}
+	row := m.metrics[m.metricCursor]
+	if row.ValueNum == nil && m.source != nil && m.source.store != nil {
+		m.statusMsg = fmt.Sprintf("%s is not numeric", row.Label())
+		return
+	}
+	m.historyRow = row
+	m.loadHistory()
+	m.mode = modeHistory
+}
+
+// loadHistory (re)queries the open series for the current range.
+func (m *model) loadHistory() {
+	m.historyAt = time.Now()
+	since := m.historyAt.Add(-historyRanges[m.historyRange])
+	m.history, m.historyErr = m.source.metricHistory(m.selectedDevice.Key, m.historyRow, since)
+}
+
+// updateHistory handles actions in the sparkline view.
+func (m model) updateHistory(act action) (tea.Model, tea.Cmd) {
+	switch act {
+	case actRangeUp:
+		if m.historyRange < len(historyRanges)-1 {
+			m.historyRange++
+			m.loadHistory()
+		}
+
+	case actRangeDown:
+		if m.historyRange > 0 {
+			m.historyRange--
+			m.loadHistory()
+		}
+
+	case actRefresh:
+		m.loadHistory()
+
+	case actBack:
+		m.mode = modeDetail
+		m.history = nil
+	}
+	return m, nil
+}
+
+// viewHistory renders the sparkline with min/max/current annotations.
+func (m *model) viewHistory() string {
+	var s strings.Builder
+	s.WriteString(titleStyle.Render(m.selectedDevice.Name+" / "+m.historyRow.Label()) + "\n\n")
+
+	rng := historyRanges[m.historyRange]
+	switch {
+	case m.source == nil || m.source.store == nil:
+		s.WriteString("No history (no database configured).\n")
+	case m.historyErr != nil:
+		s.WriteString(downStyle.Render(fmt.Sprintf("Could not load history: %v", m.historyErr)) + "\n")
+	case len(m.history) == 0:
+		s.WriteString(fmt.Sprintf("No numeric samples in the last %s.\n", formatRange(rng)))
+	default:
+		width := defaultSparkWidth
+		if w := m.rowWidth(); w > 0 {
+			width = w
+		}
+		from := m.historyAt.Add(-rng)
+		// The upper bound is exclusive; nudge it so a sample taken "now" still lands in the last bucket.
+		to := m.historyAt.Add(time.Nanosecond)
+		s.WriteString(sparkline(bucketPoints(m.history, from, to, width)) + "\n")
+		s.WriteString(helpStyle.Render(fmt.Sprintf("%-*s%s", width-3, "-"+formatRange(rng), "now")) + "\n\n")
+
+		lo, hi := m.history[0].Value, m.history[0].Value
+		for _, p := range m.history {
+			lo, hi = math.Min(lo, p.Value), math.Max(hi, p.Value)
+		}
+		last := m.history[len(m.history)-1]
+		s.WriteString(fmt.Sprintf("min %s   max %s   current %s (%s)\n",
+			formatNum(lo), formatNum(hi), formatNum(last.Value), formatAge(last.At, time.Now())))
+		s.WriteString(helpStyle.Render(fmt.Sprintf("%d samples in the last %s", len(m.history), formatRange(rng))) + "\n")
+	}
+
+	s.WriteString("\n" + m.help(m.keys.helpText(
+		helpItem{actRangeUp, "for a longer range"}, helpItem{actRangeDown, "for a shorter one"},
+		helpItem{actRefresh, "to reload"}, helpItem{actBack, "to go back"})) + "\n")
+	return s.String()
+}
+
+// formatRange renders a history range as e.g. "6h" or "7d".
+func formatRange(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+	return fmt.Sprintf("%dh", int(d.Hours()))
+}
+
+// formatNum renders a sample value without trailing zeros.
+func formatNum(v float64) string {
+	return fmt.Sprintf("%.4g", v)
+}
@@ -0,0 +1,146 @@
+package textui
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"observer/store"
+)
+
+func TestSparkline(t *testing.T) {
+	nan := math.NaN()
+	for _, tc := range []struct {
+		name   string
+		values []float64
+		want   string
+	}{
+		{"flat", []float64{5, 5, 5, 5}, "▄▄▄▄"},
+		{"ramp", []float64{0, 1, 2, 3, 4, 5, 6, 7}, "▁▂▃▄▅▆▇█"},
+		{"spike", []float64{1, 1, 100, 1, 1}, "▁▁█▁▁"},
+		{"negative", []float64{-10, 0, 10}, "▁▄█"},
+		{"gaps", []float64{nan, 0, nan, nan, 7, nan}, " ▁  █ "},
+		{"flat with gaps", []float64{3, nan, 3}, "▄ ▄"},
+		{"all gaps", []float64{nan, nan}, "  "},
+		{"empty", nil, ""},
+	} {
+		if got := sparkline(tc.values); got != tc.want {
+			t.Errorf("%s: sparkline = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBucketPoints(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(4 * time.Hour)
+	at := func(minutes int) time.Time { return from.Add(time.Duration(minutes) * time.Minute) }
+	points := []historyPoint{
+		{at(-1), 1000},  // before the range
+		{at(0), 2},      // bucket 0
+		{at(59), 4},     // bucket 0
+		{at(150), 10},   // bucket 2
+		{at(240), 1000}, // at the exclusive end
+	}
+	got := bucketPoints(points, from, to, 4)
+	if got[0] != 3 || !math.IsNaN(got[1]) || got[2] != 10 || !math.IsNaN(got[3]) {
+		t.Errorf("buckets = %v", got)
+	}
+	// Gaps stay gaps rather than being bridged.
+	if line := sparkline(got); line != "▁ █ " {
+		t.Errorf("sparkline = %q", line)
+	}
+	if got := bucketPoints(points, to, to, 3); !math.IsNaN(got[0]) {
+		t.Errorf("empty span buckets = %v", got)
+	}
+}
+
+func TestHistoryPointsSkipsText(t *testing.T) {
+	one := 1.0
+	points := historyPoints([]store.MetricRecord{{Value: "n/a"}, {Value: "1", ValueNum: &one}})
+	if len(points) != 1 || points[0].Value != 1 {
+		t.Errorf("points = %+v", points)
+	}
+}
+
+func TestHistoryView(t *testing.T) {
+	now := time.Now()
+	num := func(v float64) *float64 { return &v }
+	fake := &fakeStore{
+		latest: map[string][]store.MetricRecord{"a": {
+			{Plugin: "local", Name: "kernel", MetricType: "string", Value: "6.1", CollectedAt: now},
+			{Plugin: "local", Name: "load", MetricType: "gauge", Value: "2", ValueNum: num(2), CollectedAt: now},
+		}},
+		history: []store.MetricRecord{
+			{ValueNum: num(7), CollectedAt: now.Add(-3 * time.Hour)},
+			{ValueNum: num(1), CollectedAt: now.Add(-2 * time.Hour)},
+			{ValueNum: num(2), CollectedAt: now.Add(-time.Minute)},
+		},
+	}
+	m := press(t, newModel(devicesFor("a"), &statusSource{store: fake}, nil), "enter")
+
+	// Text metrics have no history.
+	m = press(t, m, "enter")
+	if m.mode != modeDetail || m.statusMsg != "kernel is not numeric" {
+		t.Errorf("mode %v, status %q", m.mode, m.statusMsg)
+	}
+
+	m = press(t, m, "down", "enter")
+	if m.mode != modeHistory || m.historyRow.Name != "load" || len(m.history) != 3 {
+		t.Fatalf("mode %v, row %+v, history %+v", m.mode, m.historyRow, m.history)
+	}
+	view := m.View()
+	if !strings.Contains(view, "min 1   max 7   current 2 (1m ago)") || !strings.Contains(view, "3 samples in the last 6h") {
+		t.Errorf("view:\n%s", view)
+	}
+	if !strings.Contains(view, "█") || !strings.Contains(view, "▁") {
+		t.Errorf("no sparkline:\n%s", view)
+	}
+
+	m = press(t, m, "-")
+	if got := m.historyAt.Sub(fake.since); got != time.Hour || len(m.history) != 1 {
+		t.Errorf("after -: range %v, %d samples", got, len(m.history))
+	}
+	m = press(t, m, "-") // already the shortest
+	if m.historyRange != 0 {
+		t.Errorf("range index %d", m.historyRange)
+	}
+	m = press(t, m, "+", "+", "+", "+", "+", "+")
+	if m.historyRange != len(historyRanges)-1 || !strings.Contains(m.View(), "in the last 30d") {
+		t.Errorf("range index %d:\n%s", m.historyRange, m.View())
+	}
+
+	fake.history = nil
+	m = press(t, m, "r")
+	if !strings.Contains(m.View(), "No numeric samples in the last 30d.") {
+		t.Errorf("empty view:\n%s", m.View())
+	}
+
+	m = press(t, m, "esc")
+	if m.mode != modeDetail || m.history != nil {
+		t.Errorf("back: mode %v", m.mode)
+	}
+}
+
+func TestHistoryWithoutStore(t *testing.T) {
+	writeCollection(t, collectionFixture)
+	source := &statusSource{}
+	m := press(t, newModel(devicesFor("filehost"), source, nil), "enter", "enter")
+	if m.mode != modeHistory || !strings.Contains(m.View(), "No history (no database configured).") {
+		t.Errorf("mode %v:\n%s", m.mode, m.View())
+	}
+}
+
+func TestFormatRange(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		time.Hour:           "1h",
+		6 * time.Hour:       "6h",
+		36 * time.Hour:      "36h",
+		7 * 24 * time.Hour:  "7d",
+		30 * 24 * time.Hour: "30d",
+	} {
+		if got := formatRange(d); got != want {
+			t.Errorf("formatRange(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
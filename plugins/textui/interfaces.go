@@ -0,0 +1,257 @@
+package textui
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"observer/store"
+)
+
+// ifaceColumn selects the column the interfaces table is sorted by.
+type ifaceColumn int
+
+const (
+	ifaceByIndex ifaceColumn = iota
+	ifaceByName
+	ifaceByStatus
+	ifaceBySpeed
+	ifaceByLastSeen
+	ifaceColumnCount // number of columns, for cycling
+)
+
+// String returns the label shown in the table header.
+func (c ifaceColumn) String() string {
+	switch c {
+	case ifaceByName:
+		return "name"
+	case ifaceByStatus:
+		return "status"
+	case ifaceBySpeed:
+		return "speed"
+	case ifaceByLastSeen:
+		return "last seen"
+	default:
+		return "index"
+	}
+}
+
+// ifacePageSize is the number of rows per page before the terminal size is known.
+const ifacePageSize = 50
+
+// ifaceChrome counts the fixed lines around the table: padding, title, header, help, footer.
+const ifaceChrome = 11
+
+// hostInterfaces returns a host's interface rows, or nil when no store is configured.
+func (s *statusSource) hostInterfaces(hostKey string) ([]store.InterfaceRecord, error) {
+	if s == nil || s.store == nil {
+		return nil, nil
+	}
+	return s.store.GetInterfaces(context.Background(), hostKey)
+}
+
+// portAlert reports a port that is administratively up but operationally down.
+func portAlert(r store.InterfaceRecord) bool {
+	return strings.EqualFold(r.AdminStatus, "up") && !strings.EqualFold(r.OperStatus, "up")
+}
+
+// ifaceRank orders ports by urgency for the status sort: alerting ports first,
+// then up, then administratively down.
+func ifaceRank(r store.InterfaceRecord) int {
+	switch {
+	case portAlert(r):
+		return 0
+	case strings.EqualFold(r.OperStatus, "up"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// sortInterfaces orders records by col, falling back to ifIndex for ties.
+func sortInterfaces(recs []store.InterfaceRecord, col ifaceColumn, desc bool) {
+	sort.SliceStable(recs, func(i, j int) bool {
+		a, b := recs[i], recs[j]
+		if desc {
+			a, b = b, a
+		}
+		switch col {
+		case ifaceByName:
+			if na, nb := strings.ToLower(a.Name), strings.ToLower(b.Name); na != nb {
+				return na < nb
+			}
+		case ifaceByStatus:
+			if ra, rb := ifaceRank(a), ifaceRank(b); ra != rb {
+				return ra < rb
+			}
+		case ifaceBySpeed:
+			if sa, sb := speedOf(a), speedOf(b); sa != sb {
+				return sa < sb
+			}
+		case ifaceByLastSeen:
+			if !a.LastSeen.Equal(b.LastSeen) {
+				return a.LastSeen.Before(b.LastSeen)
+			}
+		}
+		return a.IfIndex < b.IfIndex
+	})
+}
+
+// speedOf returns the interface speed, or -1 when unknown so those sort first.
+func speedOf(r store.InterfaceRecord) int64 {
+	if r.Speed == nil {
+		return -1
+	}
+	return *r.Speed
+}
+
+// formatSpeed renders an ifSpeed in bps as e.g. "100 Mbps" or "2.5 Gbps".
+func formatSpeed(bps *int64) string {
+	if bps == nil {
+		return "-"
+	}
+	v := float64(*bps)
+	for _, unit := range []struct {
+		div  float64
+		name string
+	}{{1e12, "Tbps"}, {1e9, "Gbps"}, {1e6, "Mbps"}, {1e3, "Kbps"}} {
+		if v >= unit.div {
+			return strings.TrimSuffix(strings.TrimSuffix(fmt.Sprintf("%.1f", v/unit.div), "0"), ".") + " " + unit.name
+		}
+	}
+	return fmt.Sprintf("%d bps", *bps)
+}
+
+// ifStatusStyle colors an admin/oper status: up green, down red, anything else yellow.
+func ifStatusStyle(status string) lipgloss.Style {
+	switch strings.ToLower(status) {
+	case "up":
+		return upStyle
+	case "down":
+		return downStyle
+	default:
+		return warningStyle
+	}
+}
+
+// formatInterfaceRow renders one table row. Statuses are colored and
+// alerting ports are flagged with "!".
+func formatInterfaceRow(r store.InterfaceRecord, now time.Time) string {
+	marker := "  "
+	if portAlert(r) {
+		marker = downStyle.Render("! ")
+	}
+	pad := func(s string, w int) string { return fmt.Sprintf("%-*s", w, truncateText(s, w)) }
+	return fmt.Sprintf("%s%5d  %s %s %s %s %9s  %s",
+		marker, r.IfIndex, pad(r.Name, 18), pad(r.Alias, 16),
+		ifStatusStyle(r.AdminStatus).Render(pad(r.AdminStatus, 6)),
+		ifStatusStyle(r.OperStatus).Render(pad(r.OperStatus, 6)),
+		formatSpeed(r.Speed), formatAge(r.LastSeen, now))
+}
+
+// interfaceHeader is the column header matching formatInterfaceRow.
+var interfaceHeader = fmt.Sprintf("  %5s  %-18s %-16s %-6s %-6s %9s  %s", "Index", "Name", "Alias", "Admin", "Oper", "Speed", "Seen")
+
+// openInterfaces loads the selected device's interfaces and switches to the table.
+func (m *model) openInterfaces() {
+	m.interfaces, m.ifaceErr = m.source.hostInterfaces(m.selectedDevice.Key)
+	sortInterfaces(m.interfaces, m.ifaceSort, m.ifaceDesc)
+	m.ifacePage = 0
+	m.mode = modeInterfaces
+}
+
+// ifaceRowsPerPage is the number of table rows that fit on one page.
+func (m *model) ifaceRowsPerPage() int {
+	if m.height == 0 {
+		return ifacePageSize
+	}
+	return max(m.bodyHeight()-ifaceChrome, minWindowHeight)
+}
+
+// ifacePages returns the number of pages in the interfaces table (at least 1).
+func (m *model) ifacePages() int {
+	per := m.ifaceRowsPerPage()
+	return max((len(m.interfaces)+per-1)/per, 1)
+}
+
+// updateInterfaces handles actions in the interfaces table.
+func (m model) updateInterfaces(act action) (tea.Model, tea.Cmd) {
+	switch act {
+	case actSort:
+		m.ifaceSort = (m.ifaceSort + 1) % ifaceColumnCount
+		sortInterfaces(m.interfaces, m.ifaceSort, m.ifaceDesc)
+		m.ifacePage = 0
+
+	case actReverse:
+		m.ifaceDesc = !m.ifaceDesc
+		sortInterfaces(m.interfaces, m.ifaceSort, m.ifaceDesc)
+		m.ifacePage = 0
+
+	case actPageDown:
+		if m.ifacePage < m.ifacePages()-1 {
+			m.ifacePage++
+		}
+
+	case actPageUp:
+		if m.ifacePage > 0 {
+			m.ifacePage--
+		}
+
+	case actRefresh:
+		page := m.ifacePage
+		m.openInterfaces()
+		m.ifacePage = min(page, m.ifacePages()-1)
+
+	case actBack:
+		m.mode = modeDetail
+		m.interfaces = nil
+	}
+	return m, nil
+}
+
+// viewInterfaces renders the current page of the interfaces table.
+func (m *model) viewInterfaces() string {
+	var s strings.Builder
+	s.WriteString(titleStyle.Render(m.selectedDevice.Name+" / interfaces") + "\n\n")
+
+	switch {
+	case m.ifaceErr != nil:
+		s.WriteString(downStyle.Render(fmt.Sprintf("Could not load interfaces: %v", m.ifaceErr)) + "\n")
+	case len(m.interfaces) == 0:
+		s.WriteString("No interface data for this host.\n")
+		s.WriteString(m.help("Add an SNMP collect task whose device definition walks an \"interface\" table (e.g. the generic ifTable) and collect again.") + "\n")
+	default:
+		order := "asc"
+		if m.ifaceDesc {
+			order = "desc"
+		}
+		alerts := 0
+		for _, r := range m.interfaces {
+			if portAlert(r) {
+				alerts++
+			}
+		}
+		s.WriteString(helpStyle.Render(fmt.Sprintf("%d interfaces, %d admin-up but down  sort: %s %s  page %d/%d",
+			len(m.interfaces), alerts, m.ifaceSort, order, m.ifacePage+1, m.ifacePages())) + "\n")
+		s.WriteString(clipLine(interfaceHeader, m.rowWidth()) + "\n")
+
+		per := m.ifaceRowsPerPage()
+		start := min(m.ifacePage*per, len(m.interfaces))
+		end := min(start+per, len(m.interfaces))
+		now := time.Now()
+		for _, r := range m.interfaces[start:end] {
+			s.WriteString(clipLine(formatInterfaceRow(r, now), m.rowWidth()) + "\n")
+		}
+	}
+
+	s.WriteString("\n" + m.help(m.keys.helpText(
+		helpItem{actSort, "to change sort column"}, helpItem{actReverse, "to reverse"},
+		helpItem{actPageUp, "and"}, helpItem{actPageDown, "to page"},
+		helpItem{actRefresh, "to reload"}, helpItem{actBack, "to go back"})) + "\n")
+	return s.String()
+}
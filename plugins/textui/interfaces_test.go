@@ -0,0 +1,169 @@
+package textui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"observer/store"
+)
+
+func speed(bps int64) *int64 { return &bps }
+
+// ports is a fixture with an alerting port, an admin-down port, an unknown speed, and a long name.
+func ports(now time.Time) []store.InterfaceRecord {
+	return []store.InterfaceRecord{
+		{IfIndex: 3, Name: "ge-0/0/2", AdminStatus: "up", OperStatus: "down", Speed: speed(1e9), LastSeen: now.Add(-time.Minute)},
+		{IfIndex: 1, Name: "GigabitEthernet0/0/0/uplink-to-core", Alias: "uplink", AdminStatus: "up", OperStatus: "up", Speed: speed(10e9), LastSeen: now.Add(-time.Hour)},
+		{IfIndex: 2, Name: "eth1", AdminStatus: "down", OperStatus: "down", Speed: speed(100e6), LastSeen: now},
+		{IfIndex: 4, Name: "lo", AdminStatus: "up", OperStatus: "up", LastSeen: now.Add(-2 * time.Hour)},
+	}
+}
+
+func indexes(recs []store.InterfaceRecord) string {
+	out := make([]string, len(recs))
+	for i, r := range recs {
+		out[i] = fmt.Sprint(r.IfIndex)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestSortInterfaces(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		col  ifaceColumn
+		desc bool
+		want string
+	}{
+		{ifaceByIndex, false, "1 2 3 4"},
+		{ifaceByIndex, true, "4 3 2 1"},
+		{ifaceByName, false, "2 3 1 4"},
+		{ifaceByStatus, false, "3 1 4 2"}, // alerting, up (by index), admin down
+		{ifaceBySpeed, false, "4 2 3 1"},  // unknown speed first
+		{ifaceBySpeed, true, "1 3 2 4"},
+		{ifaceByLastSeen, false, "4 1 3 2"},
+	} {
+		recs := ports(now)
+		sortInterfaces(recs, tc.col, tc.desc)
+		if got := indexes(recs); got != tc.want {
+			t.Errorf("%s desc=%v: %s, want %s", tc.col, tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestFormatSpeed(t *testing.T) {
+	for _, tc := range []struct {
+		bps  *int64
+		want string
+	}{
+		{nil, "-"},
+		{speed(0), "0 bps"},
+		{speed(64000), "64 Kbps"},
+		{speed(100e6), "100 Mbps"},
+		{speed(2500e6), "2.5 Gbps"},
+		{speed(1e12), "1 Tbps"},
+	} {
+		if got := formatSpeed(tc.bps); got != tc.want {
+			t.Errorf("formatSpeed(%v) = %q, want %q", tc.bps, got, tc.want)
+		}
+	}
+}
+
+func TestFormatInterfaceRow(t *testing.T) {
+	now := time.Now()
+	recs := ports(now)
+
+	alert := formatInterfaceRow(recs[0], now)
+	if !strings.HasPrefix(alert, "! ") || !strings.Contains(alert, "1 Gbps") || !strings.Contains(alert, "1m ago") {
+		t.Errorf("alerting row: %q", alert)
+	}
+	long := formatInterfaceRow(recs[1], now)
+	if !strings.HasPrefix(long, "  ") || !strings.Contains(long, "GigabitEthernet0/…") || !strings.Contains(long, "uplink") {
+		t.Errorf("long name row: %q", long)
+	}
+	if down := formatInterfaceRow(recs[2], now); strings.HasPrefix(down, "!") {
+		t.Errorf("admin-down port flagged: %q", down)
+	}
+	if unknown := formatInterfaceRow(recs[3], now); !strings.Contains(unknown, "        -") {
+		t.Errorf("unknown speed row: %q", unknown)
+	}
+	// Rows line up with the header.
+	if len(formatInterfaceRow(recs[2], now)) < len(interfaceHeader)-len("Seen") {
+		t.Errorf("row shorter than the header:\n%s\n%s", interfaceHeader, formatInterfaceRow(recs[2], now))
+	}
+}
+
+func TestInterfacesView(t *testing.T) {
+	now := time.Now()
+	fake := &fakeStore{interfaces: map[string][]store.InterfaceRecord{"sw": ports(now)}}
+	m := press(t, newModel(devicesFor("bare", "sw"), &statusSource{store: fake}, nil), "enter", "i")
+	if m.mode != modeInterfaces {
+		t.Fatalf("mode %v", m.mode)
+	}
+	if view := m.View(); !strings.Contains(view, "No interface data") || !strings.Contains(view, "collect again") {
+		t.Errorf("empty view:\n%s", view)
+	}
+
+	m = press(t, m, "esc", "esc", "down", "enter", "i")
+	if got := indexes(m.interfaces); got != "1 2 3 4" {
+		t.Fatalf("interfaces %s", got)
+	}
+	m = press(t, m, "s", "s")
+	if m.ifaceSort != ifaceByStatus || indexes(m.interfaces) != "3 1 4 2" {
+		t.Errorf("sort %s: %s", m.ifaceSort, indexes(m.interfaces))
+	}
+	if view := m.View(); !strings.Contains(view, "4 interfaces, 1 admin-up but down  sort: status asc  page 1/1") {
+		t.Errorf("view:\n%s", view)
+	}
+	m = press(t, m, "S")
+	if !m.ifaceDesc || indexes(m.interfaces) != "2 4 1 3" {
+		t.Errorf("reversed: %s", indexes(m.interfaces))
+	}
+
+	m = press(t, m, "esc")
+	if m.mode != modeDetail || m.interfaces != nil {
+		t.Errorf("back: mode %v", m.mode)
+	}
+}
+
+func TestInterfacesPagination(t *testing.T) {
+	var recs []store.InterfaceRecord
+	for i := 1; i <= 520; i++ {
+		recs = append(recs, store.InterfaceRecord{IfIndex: i, Name: fmt.Sprintf("port%03d", i), AdminStatus: "up", OperStatus: "up"})
+	}
+	fake := &fakeStore{interfaces: map[string][]store.InterfaceRecord{"sw": recs}}
+	m := press(t, newModel(devicesFor("sw"), &statusSource{store: fake}, nil), "enter", "i")
+
+	if m.ifacePages() != 11 {
+		t.Fatalf("%d pages of %d", m.ifacePages(), m.ifaceRowsPerPage())
+	}
+	m = press(t, m, "n", "n")
+	view := m.View()
+	if !strings.Contains(view, "page 3/11") || !strings.Contains(view, "port101") || strings.Contains(view, "port100 ") || strings.Contains(view, "port151") {
+		t.Errorf("page 3:\n%s", view)
+	}
+	for i := 0; i < 20; i++ {
+		m = press(t, m, "n")
+	}
+	if m.ifacePage != 10 || !strings.Contains(m.View(), "port520") {
+		t.Errorf("last page %d", m.ifacePage)
+	}
+	// Re-sorting returns to the first page.
+	if m = press(t, m, "S"); m.ifacePage != 0 || !strings.Contains(m.View(), "port520") {
+		t.Errorf("after reverse: page %d", m.ifacePage)
+	}
+}
+
+func TestInterfacesLoadError(t *testing.T) {
+	fake := &fakeStore{err: errors.New("no such table: interfaces")}
+	m := press(t, newModel(devicesFor("sw"), &statusSource{store: fake}, nil), "i")
+	if m.mode == modeInterfaces {
+		t.Fatal("i opened interfaces from the list")
+	}
+	m = press(t, m, "enter", "i")
+	if !strings.Contains(m.View(), "Could not load interfaces: no such table") {
+		t.Errorf("view:\n%s", m.View())
+	}
+}
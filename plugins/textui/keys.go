@@ -0,0 +1,166 @@
+package textui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// action is a named TUI command that keys are bound to.
+type action string
+
+const (
+	actNone        action = ""
+	actQuit        action = "quit"
+	actUp          action = "up"
+	actDown        action = "down"
+	actPageUp      action = "page_up"
+	actPageDown    action = "page_down"
+	actTop         action = "top"
+	actBottom      action = "bottom"
+	actSelect      action = "select"
+	actBack        action = "back"
+	actFocus       action = "focus"
+	actFilter      action = "filter"
+	actSort        action = "sort"
+	actReverse     action = "reverse"
+	actRefresh     action = "refresh"
+	actCollect     action = "collect"
+	actExpand      action = "expand"
+	actInterfaces  action = "interfaces"
+	actRangeUp     action = "range_up"
+	actRangeDown   action = "range_down"
+	actLog         action = "log"
+	actLogUp       action = "log_up"
+	actLogDown     action = "log_down"
+	actLogFollow   action = "log_follow"
+	actPalette     action = "palette"
+	actToggle      action = "toggle_select"
+	actSelectAll   action = "select_all"
+	actClearSel    action = "clear_selection"
+	actBulkCollect action = "bulk_collect"
+	actBulkPing    action = "bulk_ping"
+	actExport      action = "export"
+	actExportView  action = "export_view"
+	actDiscovered  action = "discovered"
+	actPromote     action = "promote"
+	actFlows       action = "flows"
+)
+
+// defaultKeys binds every action to its default keys, named as tea.KeyMsg.String() reports them.
+// ctrl+c always quits and is not rebindable.
+var defaultKeys = map[action][]string{
+	actQuit:        {"q"},
+	actUp:          {"up", "k"},
+	actDown:        {"down", "j"},
+	actPageUp:      {"pgup", "left", "p"},
+	actPageDown:    {"pgdown", "right", "n"},
+	actTop:         {"home", "g"},
+	actBottom:      {"end", "G"},
+	actSelect:      {"enter"},
+	actBack:        {"esc"},
+	actFocus:       {"tab"},
+	actFilter:      {"/"},
+	actSort:        {"s"},
+	actReverse:     {"S"},
+	actRefresh:     {"r"},
+	actCollect:     {"c"},
+	actExpand:      {"x"},
+	actInterfaces:  {"i"},
+	actRangeUp:     {"+", "="},
+	actRangeDown:   {"-", "_"},
+	actLog:         {"l"},
+	actLogUp:       {"[", "shift+up"},
+	actLogDown:     {"]", "shift+down"},
+	actLogFollow:   {"}"},
+	actPalette:     {":"},
+	actToggle:      {" "},
+	actSelectAll:   {"a"},
+	actClearSel:    {"u"},
+	actBulkCollect: {"C"},
+	actBulkPing:    {"P"},
+	actExport:      {"E"},
+	actExportView:  {"e"},
+	actDiscovered:  {"D"},
+	actPromote:     {"A"},
+	actFlows:       {"F"},
+}
+
+// keyMap resolves pressed keys to actions.
+type keyMap struct {
+	byKey    map[string]action
+	byAction map[action][]string
+}
+
+// newKeyMap applies the configured bindings over the defaults. Each configured
+// action replaces that action's default keys. Unknown actions, empty key names,
+// and keys bound to more than one action are rejected.
+func newKeyMap(overrides map[string][]string) (keyMap, error) {
+	km := keyMap{
+		byKey:    make(map[string]action),
+		byAction: make(map[action][]string, len(defaultKeys)),
+	}
+	for act, keys := range defaultKeys {
+		km.byAction[act] = keys
+	}
+	for name, keys := range overrides {
+		act := action(strings.ToLower(strings.TrimSpace(name)))
+		if _, ok := defaultKeys[act]; !ok {
+			return keyMap{}, fmt.Errorf("textui keys: unknown action %q", name)
+		}
+		for _, k := range keys {
+			if strings.TrimSpace(k) == "" {
+				return keyMap{}, fmt.Errorf("textui keys: empty key for action %q", name)
+			}
+		}
+		km.byAction[act] = keys
+	}
+
+	// Walk actions in a fixed order so a conflict is always reported the same way.
+	acts := make([]string, 0, len(km.byAction))
+	for act := range km.byAction {
+		acts = append(acts, string(act))
+	}
+	sort.Strings(acts)
+	for _, a := range acts {
+		act := action(a)
+		for _, k := range km.byAction[act] {
+			if k == "ctrl+c" {
+				return keyMap{}, fmt.Errorf("textui keys: %q is reserved for quitting", k)
+			}
+			if prev, dup := km.byKey[k]; dup && prev != act {
+				return keyMap{}, fmt.Errorf("textui keys: %q is bound to both %q and %q", k, prev, act)
+			}
+			km.byKey[k] = act
+		}
+	}
+	return km, nil
+}
+
+// action returns the action bound to key, or actNone.
+func (km keyMap) action(key string) action {
+	return km.byKey[key]
+}
+
+// key returns the first key bound to act, for help text.
+func (km keyMap) key(act action) string {
+	if keys := km.byAction[act]; len(keys) > 0 {
+		return keys[0]
+	}
+	return "(unbound)"
+}
+
+// helpItem pairs an action with the help text shown after its key.
+type helpItem struct {
+	act  action
+	text string
+}
+
+// helpText renders items as "'q' to quit, 'r' to refresh." using the bound keys.
+func (km keyMap) helpText(items ...helpItem) string {
+	parts := make([]string, len(items))
+	for i, it := range items {
+		parts[i] = fmt.Sprintf("'%s' %s", km.key(it.act), it.text)
+	}
+	return strings.Join(parts, ", ") + "."
+}
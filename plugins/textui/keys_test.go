@@ -0,0 +1,88 @@
+package textui
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewKeyMapDefaults(t *testing.T) {
+	km, err := newKeyMap(nil)
+	if err != nil {
+		t.Fatalf("default bindings conflict: %v", err)
+	}
+	for act, keys := range defaultKeys {
+		for _, k := range keys {
+			if got := km.action(k); got != act {
+				t.Errorf("%q → %q, want %q", k, got, act)
+			}
+		}
+	}
+	if km.action("ctrl+x") != actNone {
+		t.Error("an unbound key resolved to an action")
+	}
+}
+
+func TestNewKeyMapOverrides(t *testing.T) {
+	km, err := newKeyMap(map[string][]string{
+		" Up ": {"w"},
+		"down": {"s", "ctrl+n"},
+		"sort": {"o"}, // frees "s" for down
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]action{
+		"w": actUp, "s": actDown, "ctrl+n": actDown, "o": actSort,
+		"up": actNone, "k": actNone, // an override replaces the defaults
+		"q": actQuit,
+	} {
+		if got := km.action(key); got != want {
+			t.Errorf("%q → %q, want %q", key, got, want)
+		}
+	}
+	if got := km.helpText(helpItem{actUp, "to go up"}, helpItem{actSort, "to sort"}); got != "'w' to go up, 'o' to sort." {
+		t.Errorf("help = %q", got)
+	}
+	if km, _ := newKeyMap(map[string][]string{"flows": {}}); km.key(actFlows) != "(unbound)" {
+		t.Errorf("unbound action shows %q", km.key(actFlows))
+	}
+}
+
+func TestNewKeyMapErrors(t *testing.T) {
+	for _, tc := range []struct {
+		overrides map[string][]string
+		want      string
+	}{
+		{map[string][]string{"jump": {"J"}}, `unknown action "jump"`},
+		{map[string][]string{"up": {"w", " "}}, `empty key for action "up"`},
+		{map[string][]string{"quit": {"ctrl+c"}}, `"ctrl+c" is reserved`},
+		// "s" stays bound to sort by default.
+		{map[string][]string{"down": {"s"}}, `"s" is bound to both "down" and "sort"`},
+		{map[string][]string{"up": {"x"}, "down": {"x"}, "expand": {"e"}, "export_view": {"v"}}, `"x" is bound to both "down" and "up"`},
+	} {
+		_, err := newKeyMap(tc.overrides)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("newKeyMap(%v) = %v, want %s", tc.overrides, err, tc.want)
+		}
+	}
+}
+
+func TestReboundKeysDriveUpdate(t *testing.T) {
+	km, err := newKeyMap(map[string][]string{"down": {"J"}, "select": {"o"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newModel(devicesFor("a", "b"), nil, nil)
+	m.keys = km
+	m = press(t, m, "down", "J")
+	if m.cursor != 1 {
+		t.Errorf("cursor %d", m.cursor)
+	}
+	m = press(t, m, "enter")
+	if m.mode != modeList {
+		t.Error("enter still selects after rebinding")
+	}
+	if m = press(t, m, "o"); m.mode != modeDetail || m.selectedDevice.Key != "b" {
+		t.Errorf("mode %v", m.mode)
+	}
+}
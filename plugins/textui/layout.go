@@ -0,0 +1,164 @@
+package textui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Lines used by fixed parts of each screen: app padding, title, help, and status bar.
+// Scroll indicators always reserve their lines so the layout does not jump while scrolling.
+const (
+	listChrome   = 13 // padding 2, title 2, header 1, indicators 2, help 3, footer 2, wrap 1
+	detailChrome = 14 // padding 2, title 2, border 2, inner padding 2, indicators 2, help 2, footer 2
+	expandChrome = 8  // padding 2, title 2, help 2, footer 2
+
+	defaultDetailWidth = 60 // detail pane width before the terminal size is known
+	minPaneWidth       = 20
+	minWindowHeight    = 3
+)
+
+// resize applies a new terminal size to every size-dependent part of the model.
+func (m *model) resize(width, height int) {
+	m.width, m.height = width, height
+
+	m.viewport.Width = max(width-4, minPaneWidth)
+	m.viewport.Height = max(m.bodyHeight()-expandChrome, minWindowHeight)
+
+	m.keepCursorVisible()
+}
+
+// keepCursorVisible scrolls the list and detail windows so their cursors stay on screen.
+// It also clamps the offsets after a resize or a change in the number of rows.
+func (m *model) keepCursorVisible() {
+	m.listOffset = scrollWindow(m.listOffset, m.cursor, len(m.visible), m.listHeight())
+
+	lines, cursorLine := metricLines(m.metrics, m.metricCursor, m.lastRefresh)
+	m.detailOffset = scrollWindow(m.detailOffset, cursorLine, len(lines), m.metricsHeight())
+}
+
+// scrollWindow returns the first row to show so that cursor lies within
+// [offset, offset+height). A height of zero or less means everything fits.
+func scrollWindow(offset, cursor, total, height int) int {
+	if height <= 0 || total <= height {
+		return 0
+	}
+	if cursor < offset {
+		offset = cursor
+	}
+	if cursor >= offset+height {
+		offset = cursor - height + 1
+	}
+	if offset > total-height {
+		offset = total - height
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return offset
+}
+
+// bodyHeight is the terminal height left for the current screen once the log panel is drawn.
+func (m *model) bodyHeight() int {
+	if m.showLog {
+		return m.height - logPanelLines - 1
+	}
+	return m.height
+}
+
+// listHeight is the number of device rows that fit, or 0 before the size is known.
+func (m *model) listHeight() int {
+	if m.height == 0 {
+		return 0
+	}
+	h := m.bodyHeight() - listChrome
+	if m.filtering {
+		h--
+	}
+	return max(h, 1)
+}
+
+// metricsHeight is the number of metric lines that fit below the device summary
+// in the detail pane, or 0 before the size is known.
+func (m *model) metricsHeight() int {
+	if m.height == 0 {
+		return 0
+	}
+	h := m.bodyHeight() - detailChrome - m.detailInfoLines()
+	return max(h, minWindowHeight)
+}
+
+// detailInfoLines counts the summary lines rendered above the metrics panel.
+func (m *model) detailInfoLines() int {
+	n := 11 // fixed fields, "Collect Tasks:" and the blank line plus "Metrics:"
+	if m.selectedDevice != nil {
+		n += len(m.selectedDevice.Collect)
+	}
+	return n
+}
+
+// rowWidth is the width available to a list row, or 0 when unconstrained.
+func (m *model) rowWidth() int {
+	if m.width == 0 {
+		return 0
+	}
+	return max(m.width-4, minPaneWidth)
+}
+
+// detailWidth is the detail pane width, excluding its border.
+func (m *model) detailWidth() int {
+	if m.width == 0 {
+		return defaultDetailWidth
+	}
+	return max(m.width-6, minPaneWidth)
+}
+
+// help renders help text, wrapped to the terminal width once it is known.
+func (m *model) help(text string) string {
+	if w := m.rowWidth(); w > 0 {
+		return helpStyle.Width(w).Render(text)
+	}
+	return helpStyle.Render(text)
+}
+
+// windowLines returns lines[offset:offset+height] with "↑ n more" / "↓ n more"
+// indicators. Each indicator line is blank when there is nothing in that direction.
+func windowLines(lines []string, offset, height int) string {
+	if height <= 0 || len(lines) <= height {
+		return strings.Join(lines, "\n")
+	}
+	end := min(offset+height, len(lines))
+
+	var b strings.Builder
+	if offset > 0 {
+		b.WriteString(helpStyle.Render(fmt.Sprintf("↑ %d more", offset)))
+	}
+	b.WriteString("\n")
+	b.WriteString(strings.Join(lines[offset:end], "\n"))
+	b.WriteString("\n")
+	if rest := len(lines) - end; rest > 0 {
+		b.WriteString(helpStyle.Render(fmt.Sprintf("↓ %d more", rest)))
+	}
+	return b.String()
+}
+
+// truncateText shortens plain text to width runes, marking the cut with an ellipsis.
+func truncateText(s string, width int) string {
+	r := []rune(s)
+	if width <= 0 || len(r) <= width {
+		return s
+	}
+	if width == 1 {
+		return "…"
+	}
+	return string(r[:width-1]) + "…"
+}
+
+// clipLine cuts a styled line to width cells without breaking escape sequences.
+func clipLine(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+	return lipgloss.NewStyle().MaxWidth(width).Render(s)
+}
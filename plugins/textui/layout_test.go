@@ -0,0 +1,152 @@
+package textui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+func TestScrollWindow(t *testing.T) {
+	for _, tc := range []struct {
+		offset, cursor, total, height, want int
+	}{
+		{0, 5, 10, 0, 0},  // size unknown: everything shows
+		{3, 2, 5, 10, 0},  // everything fits
+		{0, 4, 20, 5, 0},  // cursor on the last visible row
+		{0, 5, 20, 5, 1},  // one past: scroll by one
+		{10, 3, 20, 5, 3}, // above the window: scroll up to it
+		{18, 19, 20, 5, 15},
+		{30, 0, 20, 5, 0}, // stale offset after rows went away
+	} {
+		if got := scrollWindow(tc.offset, tc.cursor, tc.total, tc.height); got != tc.want {
+			t.Errorf("scrollWindow(%d, %d, %d, %d) = %d, want %d", tc.offset, tc.cursor, tc.total, tc.height, got, tc.want)
+		}
+	}
+}
+
+func TestWindowLines(t *testing.T) {
+	lines := []string{"a", "b", "c", "d", "e"}
+	if got := windowLines(lines, 0, 10); got != "a\nb\nc\nd\ne" {
+		t.Errorf("fits: %q", got)
+	}
+	got := windowLines(lines, 1, 2)
+	if !strings.Contains(got, "↑ 1 more") || !strings.Contains(got, "b\nc") || !strings.Contains(got, "↓ 2 more") {
+		t.Errorf("window: %q", got)
+	}
+	if got := windowLines(lines, 0, 2); strings.Contains(got, "↑") || strings.Count(got, "\n") != 3 {
+		t.Errorf("top window keeps its indicator lines: %q", got)
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	for _, tc := range []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"router", 0, "router"},
+		{"router", 6, "router"},
+		{"router", 4, "rou…"},
+		{"router", 1, "…"},
+		{"résumé", 3, "ré…"},
+	} {
+		if got := truncateText(tc.s, tc.width); got != tc.want {
+			t.Errorf("truncateText(%q, %d) = %q, want %q", tc.s, tc.width, got, tc.want)
+		}
+	}
+}
+
+// manyDevices returns n devices named host-00, host-01, …
+func manyDevices(n int) []device {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("host-%02d", i)
+	}
+	return devicesFor(keys...)
+}
+
+func TestListWindowFollowsResize(t *testing.T) {
+	m := newModel(manyDevices(50), nil, nil)
+	m, _ = send(t, m, tea.WindowSizeMsg{Width: 80, Height: 20})
+	if h := m.listHeight(); h != 20-listChrome {
+		t.Fatalf("list height %d", h)
+	}
+
+	for i := 0; i < 10; i++ {
+		m = press(t, m, "down")
+	}
+	if m.listOffset != 10-m.listHeight()+1 {
+		t.Errorf("offset %d with cursor 10 and height %d", m.listOffset, m.listHeight())
+	}
+	view := m.View()
+	if !strings.Contains(view, "host-10") || strings.Contains(view, "host-02 ") || !strings.Contains(view, "↓ 39 more") {
+		t.Errorf("view:\n%s", view)
+	}
+
+	// Growing keeps the cursor in view without jumping; shrinking scrolls to it.
+	m, _ = send(t, m, tea.WindowSizeMsg{Width: 80, Height: 40})
+	if m.listOffset != 4 {
+		t.Errorf("offset %d after growing", m.listOffset)
+	}
+	m, _ = send(t, m, tea.WindowSizeMsg{Width: 60, Height: 5})
+	if m.listHeight() != 1 || m.listOffset != 10 {
+		t.Errorf("tiny terminal: height %d, offset %d", m.listHeight(), m.listOffset)
+	}
+	for _, line := range strings.Split(m.View(), "\n") {
+		if strings.Contains(line, "host-10") && lipgloss.Width(line) > 60 {
+			t.Errorf("row of %d cells in a 60-column terminal: %q", lipgloss.Width(line), line)
+		}
+	}
+
+	// Everything fits again: no scrolling.
+	m, _ = send(t, m, tea.WindowSizeMsg{Width: 120, Height: 100})
+	if m.listOffset != 0 {
+		t.Errorf("offset %d when every row fits", m.listOffset)
+	}
+}
+
+func TestResizeInEveryModeDoesNotPanic(t *testing.T) {
+	rows := make([]metricRow, 40)
+	for i := range rows {
+		rows[i] = metricRow{Category: fmt.Sprintf("c%d", i%4), Name: fmt.Sprintf("m%02d", i), Value: strings.Repeat("v", i*3)}
+	}
+	sizes := []tea.WindowSizeMsg{{Width: 0, Height: 0}, {Width: 1, Height: 1}, {Width: 10, Height: 3}, {Width: 200, Height: 60}, {Width: 80, Height: 24}}
+	for _, mode := range []mode{modeList, modeDetail, modeExpand} {
+		m := newModel(manyDevices(5), nil, nil)
+		if mode != modeList {
+			m = press(t, m, "enter")
+			m.metrics = rows
+			m.metricCursor = 39
+		}
+		if mode == modeExpand {
+			m = press(t, m, "x")
+		}
+		for _, size := range sizes {
+			m, _ = send(t, m, size)
+			_ = m.View()
+		}
+	}
+}
+
+func TestDetailWindowScrollsMetrics(t *testing.T) {
+	m := press(t, newModel(manyDevices(1), nil, nil), "enter")
+	m.metrics = make([]metricRow, 60)
+	for i := range m.metrics {
+		m.metrics[i] = metricRow{Name: fmt.Sprintf("metric-%02d", i), Value: "1"}
+	}
+	m, _ = send(t, m, tea.WindowSizeMsg{Width: 80, Height: 40})
+	height := m.metricsHeight()
+	for i := 0; i < 30; i++ {
+		m = press(t, m, "down")
+	}
+	// Line 0 is the [uncategorized] header, so metric n is line n+1.
+	if m.detailOffset != 31-height+1 {
+		t.Errorf("detail offset %d with height %d", m.detailOffset, height)
+	}
+	if view := m.View(); !strings.Contains(view, "> metric-30") || strings.Contains(view, "metric-00") {
+		t.Errorf("view:\n%s", view)
+	}
+}
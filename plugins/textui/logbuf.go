@@ -0,0 +1,264 @@
+package textui
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	plugin "observer/base"
+)
+
+// logCapacity is the number of log entries kept while the TUI runs.
+const logCapacity = 500
+
+// logPanelLines is the height of the log panel, including its title line.
+const logPanelLines = 8
+
+// logLevel is the severity of a captured log line.
+type logLevel int
+
+const (
+	levelInfo logLevel = iota
+	levelWarn
+	levelError
+)
+
+func (l logLevel) String() string {
+	switch l {
+	case levelWarn:
+		return "WARN"
+	case levelError:
+		return "ERROR"
+	default:
+		return "INFO"
+	}
+}
+
+// logEntry is one captured output line.
+type logEntry struct {
+	At    time.Time
+	Level logLevel
+	Text  string
+}
+
+// classifyLine infers a severity from the repo's log conventions:
+// "  !_ " marks failures, and warnings are spelled out.
+func classifyLine(text string) logLevel {
+	t := strings.ToLower(strings.TrimSpace(text))
+	switch {
+	case strings.HasPrefix(t, "!_"), strings.HasPrefix(t, "error"), strings.Contains(t, " error:"), strings.Contains(t, "failed"):
+		return levelError
+	case strings.HasPrefix(t, "warning"), strings.Contains(t, "warning:"):
+		return levelWarn
+	default:
+		return levelInfo
+	}
+}
+
+// logBuffer is a capped ring of log entries, safe for concurrent use.
+// Listeners are woken through notify whenever entries are added.
+type logBuffer struct {
+	mu      sync.Mutex
+	entries []logEntry
+	start   int // index of the oldest entry once the ring is full
+	total   int // entries ever added
+	cap     int
+	notify  chan struct{}
+}
+
+func newLogBuffer(capacity int) *logBuffer {
+	return &logBuffer{cap: capacity, notify: make(chan struct{}, 1)}
+}
+
+// Add appends an entry, evicting the oldest once the buffer is full.
+func (b *logBuffer) Add(e logEntry) {
+	b.mu.Lock()
+	if len(b.entries) < b.cap {
+		b.entries = append(b.entries, e)
+	} else {
+		b.entries[b.start] = e
+		b.start = (b.start + 1) % b.cap
+	}
+	b.total++
+	b.mu.Unlock()
+
+	select {
+	case b.notify <- struct{}{}:
+	default: // a wake-up is already pending
+	}
+}
+
+// Entries returns the buffered entries, oldest first, and the number ever added.
+func (b *logBuffer) Entries() ([]logEntry, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]logEntry, 0, len(b.entries))
+	out = append(out, b.entries[b.start:]...)
+	out = append(out, b.entries[:b.start]...)
+	return out, b.total
+}
+
+// WriteTo writes every buffered entry as plain text, noting any that were evicted.
+func (b *logBuffer) WriteTo(w io.Writer) (int64, error) {
+	entries, total := b.Entries()
+	var n int64
+	if dropped := total - len(entries); dropped > 0 {
+		c, err := fmt.Fprintf(w, "(%d older log entries dropped)\n", dropped)
+		n += int64(c)
+		if err != nil {
+			return n, err
+		}
+	}
+	for _, e := range entries {
+		c, err := fmt.Fprintf(w, "%s %-5s %s\n", e.At.Format("15:04:05"), e.Level, e.Text)
+		n += int64(c)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// logWriter feeds each complete line written to it into a log buffer.
+type logWriter struct {
+	mu      sync.Mutex
+	buf     *logBuffer
+	partial []byte // an unterminated last line
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.add(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush buffers an unterminated last line.
+func (w *logWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.add(string(w.partial))
+	w.partial = nil
+}
+
+func (w *logWriter) add(text string) {
+	text = strings.TrimRight(text, " \r")
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	w.buf.Add(logEntry{At: time.Now(), Level: classifyLine(text), Text: text})
+}
+
+// captureOutput points the controller's output and logger at buf, so plugin
+// output does not paint over the TUI. The process-wide os.Stdout, os.Stderr
+// and standard logger are left alone. The returned function restores the
+// controller once every captured line has been buffered.
+func captureOutput(c *plugin.Controller, buf *logBuffer) func() {
+	w := &logWriter{buf: buf}
+	stdout, logger := c.Stdout, c.Logger
+	c.Stdout, c.Logger = w, log.New(w, "", log.LstdFlags)
+	return func() {
+		c.Stdout, c.Logger = stdout, logger
+		w.Flush()
+	}
+}
+
+// logMsg reports that new entries were added to the log buffer.
+type logMsg struct{}
+
+// waitLogCmd blocks until the buffer has new entries.
+func waitLogCmd(buf *logBuffer) tea.Cmd {
+	return func() tea.Msg {
+		<-buf.notify
+		return logMsg{}
+	}
+}
+
+// applyLog accounts for new entries. While the user is scrolled up the view
+// stays pinned to the same entries; at the bottom it follows new output.
+func (m *model) applyLog() tea.Cmd {
+	_, total := m.logs.Entries()
+	if m.logScroll > 0 {
+		m.logScroll += total - m.logSeen
+	}
+	m.logSeen = total
+	m.clampLogScroll()
+	return waitLogCmd(m.logs)
+}
+
+// clampLogScroll keeps the scroll position within the buffered entries.
+func (m *model) clampLogScroll() {
+	entries, _ := m.logs.Entries()
+	limit := max(len(entries)-(logPanelLines-1), 0)
+	m.logScroll = min(max(m.logScroll, 0), limit)
+}
+
+// updateLogScroll handles the log panel scroll actions and reports whether the action was consumed.
+func (m *model) updateLogScroll(act action) bool {
+	if m.logs == nil || !m.showLog {
+		return false
+	}
+	switch act {
+	case actLogUp:
+		m.logScroll++
+	case actLogDown:
+		m.logScroll--
+	case actLogFollow:
+		m.logScroll = 0
+	default:
+		return false
+	}
+	m.clampLogScroll()
+	return true
+}
+
+// logLevelStyle colors an entry by severity.
+func logLevelStyle(l logLevel) lipgloss.Style {
+	switch l {
+	case levelError:
+		return downStyle
+	case levelWarn:
+		return warningStyle
+	default:
+		return helpStyle
+	}
+}
+
+// renderLogPanel renders the newest entries that fit, offset by the scroll position.
+func (m *model) renderLogPanel() string {
+	entries, _ := m.logs.Entries()
+	rows := logPanelLines - 1
+	end := max(len(entries)-m.logScroll, 0)
+	start := max(end-rows, 0)
+
+	title := fmt.Sprintf("Log (%d)", len(entries))
+	if m.logScroll > 0 {
+		title += fmt.Sprintf("  paused, %d newer ('%s' to follow)", m.logScroll, m.keys.key(actLogFollow))
+	}
+
+	var b strings.Builder
+	b.WriteString(helpStyle.Render(title) + "\n")
+	for _, e := range entries[start:end] {
+		line := fmt.Sprintf("%s %-5s %s", e.At.Format("15:04:05"), e.Level, strings.TrimSpace(e.Text))
+		b.WriteString(clipLine(logLevelStyle(e.Level).Render(line), m.rowWidth()) + "\n")
+	}
+	for i := end - start; i < rows; i++ {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
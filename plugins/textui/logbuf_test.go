@@ -0,0 +1,184 @@
+package textui
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+)
+
+func addLines(b *logBuffer, from, to int) {
+	for i := from; i < to; i++ {
+		b.Add(logEntry{At: time.Now(), Text: fmt.Sprintf("line %d", i)})
+	}
+}
+
+func texts(entries []logEntry) string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.Text
+	}
+	return strings.Join(out, ",")
+}
+
+func TestLogBufferCapping(t *testing.T) {
+	b := newLogBuffer(3)
+	addLines(b, 0, 2)
+	if entries, total := b.Entries(); texts(entries) != "line 0,line 1" || total != 2 {
+		t.Errorf("entries %s, total %d", texts(entries), total)
+	}
+	addLines(b, 2, 7)
+	entries, total := b.Entries()
+	if texts(entries) != "line 4,line 5,line 6" || total != 7 {
+		t.Errorf("entries %s, total %d", texts(entries), total)
+	}
+
+	var out bytes.Buffer
+	if _, err := b.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || lines[0] != "(4 older log entries dropped)" || !strings.HasSuffix(lines[3], "INFO  line 6") {
+		t.Errorf("flushed:\n%s", out.String())
+	}
+}
+
+func TestLogBufferNotifies(t *testing.T) {
+	b := newLogBuffer(10)
+	addLines(b, 0, 3) // several adds coalesce into one pending wake-up
+	select {
+	case <-b.notify:
+	default:
+		t.Fatal("no wake-up after Add")
+	}
+	select {
+	case <-b.notify:
+		t.Error("a second wake-up was queued")
+	default:
+	}
+}
+
+func TestClassifyLine(t *testing.T) {
+	for text, want := range map[string]logLevel{
+		"  |_ Contacting destination: central":  levelInfo,
+		"  !_ store: WriteBatch error: locked":  levelError,
+		"Error: could not read config":          levelError,
+		"ssh: handshake failed":                 levelError,
+		"Warning: could not parse perception":   levelWarn,
+		"config warning: unknown key \"foo\"":   levelWarn,
+		"      |_ Server response: no warnings": levelInfo,
+	} {
+		if got := classifyLine(text); got != want {
+			t.Errorf("classifyLine(%q) = %s, want %s", text, got, want)
+		}
+	}
+}
+
+// logModel returns a model with an open log panel over a buffer of n entries.
+func logModel(t *testing.T, n int) model {
+	m := newModel(devicesFor("a"), nil, nil)
+	m.logs = newLogBuffer(logCapacity)
+	addLines(m.logs, 0, n)
+	m, _ = send(t, m, logMsg{})
+	return press(t, m, "l")
+}
+
+func TestLogPanelPausesWhileScrolledUp(t *testing.T) {
+	m := logModel(t, 20)
+	if !m.showLog || m.logScroll != 0 {
+		t.Fatalf("show %v, scroll %d", m.showLog, m.logScroll)
+	}
+	if view := m.View(); !strings.Contains(view, "line 19") || strings.Contains(view, "line 12") {
+		t.Errorf("following view:\n%s", view)
+	}
+
+	m = press(t, m, "[", "[")
+	if m.logScroll != 2 || !strings.Contains(m.View(), "paused, 2 newer") {
+		t.Fatalf("scroll %d", m.logScroll)
+	}
+
+	// New output while paused keeps the same entries on screen.
+	addLines(m.logs, 20, 25)
+	m, cmd := send(t, m, logMsg{})
+	if cmd == nil {
+		t.Error("applyLog did not wait for the next entries")
+	}
+	if m.logScroll != 7 {
+		t.Errorf("scroll %d after 5 new entries, want 7", m.logScroll)
+	}
+	if view := m.View(); !strings.Contains(view, "line 17") || strings.Contains(view, "line 18") {
+		t.Errorf("paused view moved:\n%s", view)
+	}
+
+	m = press(t, m, "}")
+	if m.logScroll != 0 || !strings.Contains(m.View(), "line 24") {
+		t.Errorf("follow: scroll %d", m.logScroll)
+	}
+
+	// At the bottom, new output scrolls into view.
+	addLines(m.logs, 25, 26)
+	m, _ = send(t, m, logMsg{})
+	if m.logScroll != 0 || !strings.Contains(m.View(), "line 25") {
+		t.Errorf("following after new entry: scroll %d", m.logScroll)
+	}
+}
+
+func TestLogScrollClamps(t *testing.T) {
+	m := logModel(t, 10)
+	for i := 0; i < 20; i++ {
+		m = press(t, m, "[")
+	}
+	if want := 10 - (logPanelLines - 1); m.logScroll != want {
+		t.Errorf("scroll %d, want %d", m.logScroll, want)
+	}
+	for i := 0; i < 20; i++ {
+		m = press(t, m, "]")
+	}
+	if m.logScroll != 0 {
+		t.Errorf("scroll %d", m.logScroll)
+	}
+
+	// Closed, the scroll keys do nothing.
+	m = press(t, m, "l", "[")
+	if m.showLog || m.logScroll != 0 {
+		t.Errorf("closed panel scrolled: %d", m.logScroll)
+	}
+}
+
+func TestCaptureOutput(t *testing.T) {
+	buf := newLogBuffer(10)
+	c := plugin.NewController()
+	var before bytes.Buffer
+	c.Stdout = &before
+	stdout := os.Stdout
+	restore := captureOutput(c, buf)
+	if os.Stdout != stdout {
+		t.Error("os.Stdout was swapped")
+	}
+	c.Printf("  |_ collected router\n")
+	c.Printf("  !_ ssh: ")
+	c.Printf("timeout\n")
+	c.Logf("Warning: slow host")
+	c.Printf("   \n")
+	c.Printf("unterminated")
+	restore()
+
+	entries, _ := buf.Entries()
+	if len(entries) != 4 {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if entries[1].Text != "  !_ ssh: timeout" || entries[3].Text != "unterminated" {
+		t.Errorf("texts = %q", texts(entries))
+	}
+	if entries[0].Level != levelInfo || entries[1].Level != levelError || entries[2].Level != levelWarn {
+		t.Errorf("levels = %s %s %s", entries[0].Level, entries[1].Level, entries[2].Level)
+	}
+	c.Printf("after\n")
+	if before.String() != "after\n" || c.Logger != nil {
+		t.Errorf("controller not restored: %q", before.String())
+	}
+}
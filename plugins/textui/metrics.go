@@ -0,0 +1,167 @@
+package textui
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+)
+
+// maxInlineValue is the longest value rendered inline in the metrics panel.
+// Longer or multi-line values are truncated and can be expanded into a viewport.
+const maxInlineValue = 40
+
+// metricRow is one collected metric prepared for display in the detail view.
+type metricRow struct {
+	Plugin   string // empty for rows read from collection.json
+	Category string
+	Name     string
+	Instance string
+	Type     string
+	Value    string
+	ValueNum *float64
+	Unit     string    // empty when unknown
+	At       time.Time // zero when the collection time is unknown
+}
+
+// Label returns the metric name, qualified by its instance when present.
+func (r metricRow) Label() string {
+	if r.Instance != "" {
+		return fmt.Sprintf("%s[%s]", r.Name, r.Instance)
+	}
+	return r.Name
+}
+
+// Display returns the value as shown, scaled and suffixed by its unit.
+func (r metricRow) Display() string {
+	return plugin.FormatUnit(r.Value, r.Unit)
+}
+
+// Long reports whether the value is too long or multi-line to show inline.
+func (r metricRow) Long() bool {
+	v := r.Display()
+	return len(v) > maxInlineValue || strings.Contains(v, "\n")
+}
+
+// Inline returns the value truncated to fit a single row.
+func (r metricRow) Inline() string {
+	v := r.Display()
+	if i := strings.Index(v, "\n"); i >= 0 {
+		v = v[:i]
+	}
+	if len(v) > maxInlineValue {
+		v = v[:maxInlineValue-1]
+	}
+	if r.Long() {
+		v += "…"
+	}
+	return v
+}
+
+// hostMetrics returns the latest metrics for a host, from the store when
+// configured or from collection.json otherwise, sorted by category then label.
+func (s *statusSource) hostMetrics(hostKey string) []metricRow {
+	var rows []metricRow
+
+	if s.store != nil {
+		if records, err := s.store.LatestMetrics(context.Background(), hostKey); err == nil {
+			for _, r := range records {
+				rows = append(rows, metricRow{
+					Plugin:   r.Plugin,
+					Category: r.Category,
+					Name:     r.Name,
+					Instance: r.Instance,
+					Type:     r.MetricType,
+					Value:    r.Value,
+					ValueNum: r.ValueNum,
+					Unit:     r.Unit,
+					At:       r.CollectedAt,
+				})
+			}
+		}
+	}
+
+	if len(rows) == 0 {
+		results, fileTime := loadResults()
+		if h, ok := results[hostKey]; ok {
+			rows = rowsFromResult(h, fileTime)
+		}
+	}
+
+	sortMetricRows(rows)
+	return rows
+}
+
+// rowsFromResult converts a host of the last collection to display rows.
+func rowsFromResult(h *plugin.HostResult, at time.Time) []metricRow {
+	rows := make([]metricRow, 0, len(h.Metrics))
+	for _, m := range h.Metrics {
+		name := m.Name
+		if name == "" {
+			name, _ = m.Extra["label"].(string)
+		}
+		if name == "" {
+			name = m.Label
+		}
+		rows = append(rows, metricRow{
+			Plugin:   m.Plugin,
+			Category: m.Category,
+			Name:     name,
+			Instance: m.Instance,
+			Type:     m.Type,
+			Value:    fmt.Sprintf("%v", m.Value),
+			Unit:     m.Unit,
+			At:       at,
+		})
+	}
+	return rows
+}
+
+// sortMetricRows orders rows by category, then label, so groups render contiguously.
+func sortMetricRows(rows []metricRow) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		ci, cj := strings.ToLower(rows[i].Category), strings.ToLower(rows[j].Category)
+		if ci != cj {
+			return ci < cj
+		}
+		return rows[i].Label() < rows[j].Label()
+	})
+}
+
+// metricLines renders the metrics panel one line per entry, with a [category]
+// header before each group. It also returns the line index of the cursor row.
+func metricLines(rows []metricRow, cursor int, now time.Time) ([]string, int) {
+	if len(rows) == 0 {
+		return []string{"No data collected yet for this host."}, 0
+	}
+
+	lines := make([]string, 0, len(rows)+8)
+	cursorLine := 0
+	category := "\x00"
+	for i, r := range rows {
+		if !strings.EqualFold(r.Category, category) {
+			category = r.Category
+			title := category
+			if title == "" {
+				title = "uncategorized"
+			}
+			lines = append(lines, fmt.Sprintf("[%s]", title))
+		}
+
+		marker := "  "
+		if i == cursor {
+			marker = "> "
+			cursorLine = len(lines)
+		}
+
+		value := r.Inline()
+		if r.Type == "status" {
+			value = statusStyle(r.Value).Render(value)
+		}
+		lines = append(lines, fmt.Sprintf("%s%-24s %s  %s", marker, r.Label(), value, helpStyle.Render(formatAge(r.At, now))))
+	}
+	return lines, cursorLine
+}
@@ -0,0 +1,147 @@
+package textui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"observer/internal/testenv"
+	"observer/store"
+)
+
+const metricsFixture = `{
+  "filehost": {"metrics": {"metrics": {
+    "ping":     {"type": "status", "value": "up", "category": "network"},
+    "kernel":   {"type": "string", "value": "6.1.0", "category": "system"},
+    "packages": {"type": "collection", "value": "` + "a\\nb\\nc" + `", "category": "system"},
+    "disk":     {"label": "disk_used", "type": "gauge", "value": 50, "unit": "%", "instance": "/"}
+  }}}
+}`
+
+func TestHostMetricsFromStore(t *testing.T) {
+	at := time.Now().Add(-time.Minute)
+	fake := &fakeStore{latest: map[string][]store.MetricRecord{
+		"storehost": {
+			{Plugin: "local", Category: "system", Name: "uptime", MetricType: "gauge", Value: "90", Unit: "s", CollectedAt: at},
+			{Plugin: "network", Category: "network", Name: "ping", MetricType: "status", Value: "up", CollectedAt: at},
+			{Plugin: "local", Category: "system", Name: "kernel", MetricType: "string", Value: "6.1", CollectedAt: at},
+		},
+	}}
+	writeCollection(t, metricsFixture)
+	source := &statusSource{store: fake}
+
+	rows := source.hostMetrics("storehost")
+	var labels []string
+	for _, r := range rows {
+		labels = append(labels, r.Category+"/"+r.Label())
+	}
+	if got, want := strings.Join(labels, " "), "network/ping system/kernel system/uptime"; got != want {
+		t.Errorf("rows = %s, want %s", got, want)
+	}
+	if rows[2].Unit != "s" || !rows[2].At.Equal(at) || rows[2].Plugin != "local" {
+		t.Errorf("uptime row = %+v", rows[2])
+	}
+}
+
+func TestHostMetricsFromCollection(t *testing.T) {
+	writeCollection(t, metricsFixture)
+	source := &statusSource{store: &fakeStore{}}
+
+	rows := source.hostMetrics("filehost")
+	if len(rows) != 4 {
+		t.Fatalf("rows = %+v", rows)
+	}
+	// Uncategorized sorts first, then network, then system.
+	if rows[0].Label() != "disk_used[/]" || rows[0].Display() != "50%" {
+		t.Errorf("first row = %+v (%s)", rows[0], rows[0].Display())
+	}
+	if rows[1].Name != "ping" || rows[1].Type != "status" {
+		t.Errorf("second row = %+v", rows[1])
+	}
+	if rows[3].Name != "packages" || !rows[3].Long() || rows[3].Inline() != "a…" {
+		t.Errorf("packages row = %+v, inline %q", rows[3], rows[3].Inline())
+	}
+	for _, r := range rows {
+		if r.At.IsZero() {
+			t.Errorf("%s: no age from the collection file", r.Name)
+		}
+	}
+
+	if rows := source.hostMetrics("nohost"); len(rows) != 0 {
+		t.Errorf("nohost rows = %+v", rows)
+	}
+}
+
+func TestMetricLines(t *testing.T) {
+	now := time.Now()
+	rows := []metricRow{
+		{Name: "load", Value: "0.5", At: now.Add(-2 * time.Minute)},
+		{Category: "network", Name: "ping", Type: "status", Value: "up", At: now},
+		{Category: "network", Name: "traffic", Instance: "eth0", Value: strings.Repeat("x", 60), At: now},
+	}
+	lines, cursorLine := metricLines(rows, 2, now)
+	if len(lines) != 5 {
+		t.Fatalf("lines = %q", lines)
+	}
+	if lines[0] != "[uncategorized]" || lines[2] != "[network]" {
+		t.Errorf("headers = %q, %q", lines[0], lines[2])
+	}
+	if !strings.Contains(lines[1], "2m ago") {
+		t.Errorf("load line lacks its age: %q", lines[1])
+	}
+	if cursorLine != 4 || !strings.HasPrefix(lines[4], "> traffic[eth0]") || !strings.Contains(lines[4], "…") {
+		t.Errorf("cursor line %d: %q", cursorLine, lines[cursorLine])
+	}
+
+	lines, _ = metricLines(nil, 0, now)
+	if len(lines) != 1 || !strings.Contains(lines[0], "No data collected yet") {
+		t.Errorf("empty lines = %q", lines)
+	}
+}
+
+func TestDetailViewPopulation(t *testing.T) {
+	writeCollection(t, metricsFixture)
+	source := &statusSource{}
+	devs := devicesFor("emptyhost", "filehost")
+	m := newModel(devs, source, nil)
+
+	m = press(t, m, "enter")
+	if m.mode != modeDetail || m.selectedDevice == nil || m.selectedDevice.Key != "emptyhost" {
+		t.Fatalf("mode %v, device %+v", m.mode, m.selectedDevice)
+	}
+	if len(m.metrics) != 0 || !strings.Contains(m.View(), "No data collected yet") {
+		t.Errorf("emptyhost detail: %d metrics", len(m.metrics))
+	}
+
+	m = press(t, m, "esc", "down", "enter")
+	if m.selectedDevice == nil || m.selectedDevice.Key != "filehost" || len(m.metrics) != 4 {
+		t.Fatalf("filehost detail: device %+v, %d metrics", m.selectedDevice, len(m.metrics))
+	}
+
+	// "x" on a short value does nothing; on the truncated collection it expands.
+	m = press(t, m, "x")
+	if m.mode != modeDetail {
+		t.Errorf("expanded a short value: mode %v", m.mode)
+	}
+	m = press(t, m, "down", "down", "down", "x")
+	if m.mode != modeExpand || !strings.Contains(m.viewport.View(), "c") {
+		t.Errorf("mode %v, viewport %q", m.mode, m.viewport.View())
+	}
+	m = press(t, m, "esc")
+	if m.mode != modeDetail {
+		t.Errorf("esc from expand: mode %v", m.mode)
+	}
+	m = press(t, m, "esc")
+	if m.mode != modeList || m.selectedDevice != nil || m.metrics != nil {
+		t.Errorf("esc from detail: mode %v, device %+v", m.mode, m.selectedDevice)
+	}
+}
+
+func TestDetailWithoutCollectionFile(t *testing.T) {
+	testenv.UseTempDirs(t)
+	source := &statusSource{}
+	m := press(t, newModel(devicesFor("a"), source, nil), "enter")
+	if m.mode != modeDetail || len(m.metrics) != 0 {
+		t.Errorf("mode %v, metrics %+v", m.mode, m.metrics)
+	}
+}
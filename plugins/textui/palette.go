@@ -0,0 +1,266 @@
+package textui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	plugin "observer/base"
+)
+
+// actionSource lists and runs the plugin actions offered in the command palette.
+// *plugin.Controller satisfies it.
+type actionSource interface {
+	HostActions(host plugin.Host) []plugin.HostAction
+	OnCommand(pluginName string, args map[string]string) error
+}
+
+// paletteStage is the step the command palette is at.
+type paletteStage int
+
+const (
+	stagePick    paletteStage = iota // choosing an action
+	stageParam                       // prompting for an action parameter
+	stageConfirm                     // waiting for y/n on a destructive action
+)
+
+// commandPalette is the open command palette for one device.
+type commandPalette struct {
+	device  device
+	actions []plugin.HostAction
+	matches []int // indices into actions matching the typed query
+	cursor  int
+	stage   paletteStage
+	input   textinput.Model
+
+	chosen   plugin.HostAction
+	values   []string // parameter values collected so far
+	errorMsg string
+}
+
+// actionDoneMsg reports the outcome of a palette action.
+type actionDoneMsg struct {
+	hostKey string
+	label   string
+	err     error
+}
+
+func newCommandPalette(d device, actions []plugin.HostAction) *commandPalette {
+	input := textinput.New()
+	input.Prompt = ": "
+	input.Placeholder = "type to filter actions"
+	input.Focus()
+	p := &commandPalette{device: d, actions: actions, input: input}
+	p.refilter()
+	return p
+}
+
+// refilter narrows the action list to those whose label or plugin contains the query.
+func (p *commandPalette) refilter() {
+	q := strings.ToLower(strings.TrimSpace(p.input.Value()))
+	p.matches = p.matches[:0]
+	for i, a := range p.actions {
+		if q == "" || strings.Contains(strings.ToLower(a.Label), q) || strings.Contains(a.Plugin, q) {
+			p.matches = append(p.matches, i)
+		}
+	}
+	p.cursor = min(p.cursor, max(len(p.matches)-1, 0))
+}
+
+// promptParam sets up the input for the next parameter of the chosen action.
+func (p *commandPalette) promptParam() {
+	param := p.chosen.Params[len(p.values)]
+	label := param.Label
+	if label == "" {
+		label = param.Name
+	}
+	p.stage = stageParam
+	p.input.Prompt = label + ": "
+	p.input.Placeholder = param.Default
+	p.input.SetValue("")
+}
+
+// args builds the OnCommand arguments for the chosen action.
+func (p *commandPalette) args() map[string]string {
+	parts := []string{"host=" + p.device.Key}
+	if p.device.Address != "" {
+		parts = append(parts, "address="+p.device.Address)
+	}
+	for i, param := range p.chosen.Params {
+		parts = append(parts, param.Name+"="+p.values[i])
+	}
+	return map[string]string{"action": p.chosen.Action, "args": strings.Join(parts, " ")}
+}
+
+// openPalette opens the command palette for the device under the cursor (list)
+// or the open device (detail).
+func (m *model) openPalette() {
+	d := m.selectedDevice
+	if m.mode == modeList {
+		d = m.currentDevice()
+	}
+	if d == nil {
+		return
+	}
+	if m.actions == nil {
+		m.statusMsg = "no plugin actions are available"
+		return
+	}
+	actions := m.actions.HostActions(d.Host)
+	if len(actions) == 0 {
+		m.statusMsg = fmt.Sprintf("no actions apply to %s", d.Name)
+		return
+	}
+	m.palette = newCommandPalette(*d, actions)
+}
+
+// updatePalette drives the palette: pick an action, fill in its parameters,
+// confirm if destructive, then run it in the background.
+func (m model) updatePalette(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	p := m.palette
+	if msg.String() == "esc" {
+		m.palette = nil
+		return m, nil
+	}
+
+	switch p.stage {
+	case stagePick:
+		switch msg.String() {
+		case "up":
+			if p.cursor > 0 {
+				p.cursor--
+			}
+			return m, nil
+		case "down":
+			if p.cursor < len(p.matches)-1 {
+				p.cursor++
+			}
+			return m, nil
+		case "enter":
+			if len(p.matches) == 0 {
+				return m, nil
+			}
+			p.chosen = p.actions[p.matches[p.cursor]]
+			p.values = nil
+			return m.advancePalette()
+		}
+
+	case stageParam:
+		if msg.String() == "enter" {
+			v := strings.TrimSpace(p.input.Value())
+			if v == "" {
+				v = p.chosen.Params[len(p.values)].Default
+			}
+			if v == "" || strings.ContainsAny(v, " \t") {
+				p.errorMsg = "enter a value without spaces"
+				return m, nil
+			}
+			p.errorMsg = ""
+			p.values = append(p.values, v)
+			return m.advancePalette()
+		}
+
+	case stageConfirm:
+		if strings.EqualFold(msg.String(), "y") {
+			return m.runPaletteAction()
+		}
+		m.palette = nil
+		m.statusMsg = fmt.Sprintf("%s cancelled", p.chosen.Label)
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	p.input, cmd = p.input.Update(msg)
+	if p.stage == stagePick {
+		p.refilter()
+	}
+	return m, cmd
+}
+
+// advancePalette moves to the next parameter, the confirmation, or runs the action.
+func (m model) advancePalette() (tea.Model, tea.Cmd) {
+	p := m.palette
+	switch {
+	case len(p.values) < len(p.chosen.Params):
+		p.promptParam()
+		return m, nil
+	case p.chosen.Safety == plugin.SafetyDestructive:
+		p.stage = stageConfirm
+		p.input.Blur()
+		return m, nil
+	default:
+		return m.runPaletteAction()
+	}
+}
+
+// runPaletteAction closes the palette and runs the chosen action off the UI goroutine.
+func (m model) runPaletteAction() (tea.Model, tea.Cmd) {
+	p := m.palette
+	m.palette = nil
+	m.statusMsg = fmt.Sprintf("running %s on %s…", p.chosen.Label, p.device.Name)
+
+	src, pluginName, args := m.actions, p.chosen.Plugin, p.args()
+	done := actionDoneMsg{hostKey: p.device.Key, label: p.chosen.Label}
+	return m, func() tea.Msg {
+		done.err = src.OnCommand(pluginName, args)
+		return done
+	}
+}
+
+// finishAction reports an action's outcome in the status bar and the log panel.
+func (m *model) finishAction(msg actionDoneMsg) {
+	entry := logEntry{At: time.Now(), Level: levelInfo}
+	if msg.err != nil {
+		entry.Level = levelError
+		entry.Text = fmt.Sprintf("%s on %s failed: %v", msg.label, msg.hostKey, msg.err)
+	} else {
+		entry.Text = fmt.Sprintf("%s on %s: ok", msg.label, msg.hostKey)
+	}
+	m.statusMsg = entry.Text
+	if m.logs != nil {
+		m.logs.Add(entry)
+	}
+}
+
+// viewPalette renders the open palette below the current screen.
+func (m *model) viewPalette() string {
+	p := m.palette
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Actions for "+p.device.Name) + "\n")
+
+	switch p.stage {
+	case stagePick:
+		b.WriteString(p.input.View() + "\n")
+		if len(p.matches) == 0 {
+			b.WriteString(helpStyle.Render("no matching actions") + "\n")
+		}
+		for i, idx := range p.matches {
+			a := p.actions[idx]
+			marker := "  "
+			if i == p.cursor {
+				marker = "> "
+			}
+			line := fmt.Sprintf("%s%-32s %s", marker, a.Label, helpStyle.Render(a.Plugin+"."+a.Action))
+			if a.Safety == plugin.SafetyDestructive {
+				line += " " + warningStyle.Render("(confirm)")
+			}
+			b.WriteString(clipLine(line, m.rowWidth()) + "\n")
+		}
+		b.WriteString(helpStyle.Render("enter to choose, esc to close") + "\n")
+
+	case stageParam:
+		b.WriteString(p.chosen.Label + "\n")
+		b.WriteString(p.input.View() + "\n")
+		if p.errorMsg != "" {
+			b.WriteString(downStyle.Render(p.errorMsg) + "\n")
+		}
+		b.WriteString(helpStyle.Render("enter to accept (empty keeps the default), esc to cancel") + "\n")
+
+	case stageConfirm:
+		b.WriteString(warningStyle.Render(fmt.Sprintf("Run %q on %s? [y/N]", p.chosen.Label, p.device.Name)) + "\n")
+	}
+	return detailStyle.Width(m.detailWidth()).Render(b.String()) + "\n"
+}
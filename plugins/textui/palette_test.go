@@ -0,0 +1,202 @@
+package textui
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	plugin "observer/base"
+)
+
+// actionPlugin advertises specs and records the arguments of every command it runs.
+type actionPlugin struct {
+	plugin.BasePlugin
+	name  string
+	specs []plugin.ActionSpec
+	runs  []map[string]string
+	err   error
+}
+
+func (p *actionPlugin) Name() string                 { return p.name }
+func (p *actionPlugin) Actions() []plugin.ActionSpec { return p.specs }
+
+func (p *actionPlugin) OnCommand(args map[string]string) error {
+	p.runs = append(p.runs, args)
+	return p.err
+}
+
+// paletteFixture is a controller with a service plugin (one destructive
+// action, one only for hosts collecting over ssh) and a network plugin with
+// a parameterised action.
+func paletteFixture(t *testing.T) (model, *actionPlugin, *actionPlugin) {
+	svc := &actionPlugin{name: "svc", specs: []plugin.ActionSpec{
+		{Action: "restart", Label: "Restart service", Safety: plugin.SafetyDestructive},
+		{Action: "tail", Label: "Tail logs", Safety: plugin.SafetyRead,
+			Applies: func(h plugin.Host) bool { return h.CollectsWith("ssh") }},
+	}}
+	network := &actionPlugin{name: "network", specs: []plugin.ActionSpec{
+		{Action: "port", Label: "Check port", Safety: plugin.SafetyRead, Params: []plugin.ActionParam{
+			{Name: "port", Label: "Port", Default: "22"},
+			{Name: "proto"},
+		}},
+	}}
+	c := plugin.NewController()
+	c.AddPlugin(svc)
+	c.AddPlugin(network)
+
+	devs := devicesFor("web")
+	devs[0].Address = "10.0.0.1"
+	m := newModel(devs, nil, nil)
+	m.actions = c
+	return m, svc, network
+}
+
+// typeText sends each rune of s as a key.
+func typeText(t *testing.T, m model, s string) model {
+	for _, r := range s {
+		m = press(t, m, string(r))
+	}
+	return m
+}
+
+// runAction executes the palette's command and feeds the result back.
+func runAction(t *testing.T, m model, cmd tea.Cmd) model {
+	t.Helper()
+	msgs := run(cmd)
+	if len(msgs) != 1 {
+		t.Fatalf("action command returned %v", msgs)
+	}
+	done, ok := msgs[0].(actionDoneMsg)
+	if !ok {
+		t.Fatalf("action command returned %T", msgs[0])
+	}
+	m, _ = send(t, m, done)
+	return m
+}
+
+func TestPaletteListsApplicableActions(t *testing.T) {
+	m, _, _ := paletteFixture(t)
+	m = press(t, m, ":")
+	if m.palette == nil {
+		t.Fatal("palette did not open")
+	}
+	var labels []string
+	for _, a := range m.palette.actions {
+		labels = append(labels, a.Plugin+"."+a.Action)
+	}
+	// Tail logs does not apply: the host does not collect over ssh.
+	if got := strings.Join(labels, " "); got != "network.port svc.restart" {
+		t.Errorf("actions = %s", got)
+	}
+
+	m = typeText(t, m, "rest")
+	if len(m.palette.matches) != 1 || m.palette.actions[m.palette.matches[0]].Action != "restart" {
+		t.Errorf("matches for %q = %v", m.palette.input.Value(), m.palette.matches)
+	}
+	m = typeText(t, m, "zzz")
+	if len(m.palette.matches) != 0 || !strings.Contains(m.View(), "no matching actions") {
+		t.Errorf("matches = %v", m.palette.matches)
+	}
+	if m, cmd := send(t, m, keyMsg("enter")); cmd != nil || m.palette == nil {
+		t.Error("enter with no match closed the palette or ran something")
+	}
+	if m = press(t, m, "esc"); m.palette != nil {
+		t.Error("esc did not close the palette")
+	}
+}
+
+func TestPaletteConfirmsDestructiveActions(t *testing.T) {
+	m, svc, _ := paletteFixture(t)
+	m = press(t, m, ":", "down", "enter")
+	if m.palette == nil || m.palette.stage != stageConfirm {
+		t.Fatalf("palette %+v", m.palette)
+	}
+	if !strings.Contains(m.View(), `Run "Restart service" on web? [y/N]`) {
+		t.Errorf("view:\n%s", m.View())
+	}
+
+	// Anything but y cancels.
+	m = press(t, m, "n")
+	if m.palette != nil || m.statusMsg != "Restart service cancelled" || len(svc.runs) != 0 {
+		t.Errorf("palette %v, status %q, runs %v", m.palette, m.statusMsg, svc.runs)
+	}
+
+	m = press(t, m, ":", "down", "enter")
+	m, cmd := send(t, m, keyMsg("y"))
+	if m.palette != nil || m.statusMsg != "running Restart service on web…" {
+		t.Errorf("status %q", m.statusMsg)
+	}
+	m = runAction(t, m, cmd)
+	if len(svc.runs) != 1 || svc.runs[0]["action"] != "restart" || svc.runs[0]["args"] != "host=web address=10.0.0.1" {
+		t.Errorf("runs = %v", svc.runs)
+	}
+	if m.statusMsg != "Restart service on web: ok" {
+		t.Errorf("status %q", m.statusMsg)
+	}
+}
+
+func TestPalettePromptsForParams(t *testing.T) {
+	m, _, network := paletteFixture(t)
+	m.logs = newLogBuffer(10)
+	m = press(t, m, ":", "enter")
+	if p := m.palette; p == nil || p.stage != stageParam || p.input.Prompt != "Port: " || p.input.Placeholder != "22" {
+		t.Fatalf("palette %+v", m.palette)
+	}
+
+	// Empty keeps the default; the second param has none and is required.
+	m = press(t, m, "enter")
+	if p := m.palette; p.input.Prompt != "proto: " || strings.Join(p.values, ",") != "22" {
+		t.Fatalf("after default: prompt %q, values %v", p.input.Prompt, p.values)
+	}
+	m = press(t, m, "enter")
+	if m.palette == nil || m.palette.errorMsg != "enter a value without spaces" {
+		t.Fatalf("empty required param accepted: %+v", m.palette)
+	}
+	m = typeText(t, m, "t cp")
+	if m = press(t, m, "enter"); m.palette == nil || m.palette.errorMsg == "" {
+		t.Fatal("a value with spaces was accepted")
+	}
+	for range "t cp" {
+		m = press(t, m, "backspace")
+	}
+	m = typeText(t, m, "tcp")
+	m, cmd := send(t, m, keyMsg("enter"))
+	if m.palette != nil || cmd == nil {
+		t.Fatal("read-only action did not run straight away")
+	}
+
+	network.err = errors.New("connection refused")
+	m = runAction(t, m, cmd)
+	if got := network.runs[0]["args"]; got != "host=web address=10.0.0.1 port=22 proto=tcp" {
+		t.Errorf("args = %q", got)
+	}
+	if m.statusMsg != "Check port on web failed: connection refused" {
+		t.Errorf("status %q", m.statusMsg)
+	}
+	entries, _ := m.logs.Entries()
+	if len(entries) != 1 || entries[0].Level != levelError || entries[0].Text != m.statusMsg {
+		t.Errorf("log = %+v", entries)
+	}
+}
+
+func TestPaletteRespectsSafetyLevel(t *testing.T) {
+	m, _, _ := paletteFixture(t)
+	m.actions.(*plugin.Controller).Safety = plugin.SafetyRead
+	m = press(t, m, ":")
+	if len(m.palette.actions) != 1 || m.palette.actions[0].Action != "port" {
+		t.Errorf("read-only actions = %+v", m.palette.actions)
+	}
+}
+
+func TestPaletteWithoutActions(t *testing.T) {
+	m := press(t, newModel(devicesFor("a"), nil, nil), ":")
+	if m.palette != nil || m.statusMsg != "no plugin actions are available" {
+		t.Errorf("status %q", m.statusMsg)
+	}
+	m.actions = plugin.NewController()
+	if m = press(t, m, ":"); m.palette != nil || m.statusMsg != "no actions apply to a" {
+		t.Errorf("status %q", m.statusMsg)
+	}
+}
@@ -0,0 +1,453 @@
+package textui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	plugin "observer/base"
+)
+
+// perceptionStateFile is the network plugin's record of when it first and
+// last saw each host, keyed by canonical address.
+const perceptionStateFile = "perception_state.json"
+
+// perceivedHost is a host found by perception.
+type perceivedHost struct {
+	Key          string // key in perception.json, the address
+	Address      string
+	Hostname     string
+	Role         string
+	Services     []string // detected collect tasks, e.g. "network.ping"
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	ConfiguredAs string // key of the configured host with this address, if any
+}
+
+// perceptionView is the open discovered-hosts browser.
+type perceptionView struct {
+	hosts  []perceivedHost
+	cursor int
+	offset int
+	cfg    *plugin.Config // config.json as read when the view opened, plus promoted hosts
+	err    error
+	form   *promoteForm // open promotion form, nil when closed
+}
+
+// loadPerceived reads perception.json and the network plugin's state, and
+// marks the hosts cfg already has. Hosts are sorted by address.
+func loadPerceived(cfg *plugin.Config) ([]perceivedHost, error) {
+	data, err := os.ReadFile(plugin.DataFile("perception.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var perception struct {
+		Hosts map[string]struct {
+			Address  string   `json:"address"`
+			Hostname string   `json:"hostname"`
+			Collect  []string `json:"collect"`
+			Role     string   `json:"role"`
+		} `json:"hosts"`
+	}
+	if err := json.Unmarshal(data, &perception); err != nil {
+		return nil, fmt.Errorf("could not parse perception.json: %w", err)
+	}
+	var state struct {
+		Hosts map[string]struct {
+			FirstSeen time.Time `json:"first_seen"`
+			LastSeen  time.Time `json:"last_seen"`
+		} `json:"hosts"`
+	}
+	if data, err := os.ReadFile(plugin.StateFile(perceptionStateFile)); err == nil {
+		json.Unmarshal(data, &state) //nolint:errcheck
+	}
+
+	hosts := make([]perceivedHost, 0, len(perception.Hosts))
+	for key, e := range perception.Hosts {
+		h := perceivedHost{
+			Key: key, Address: e.Address, Hostname: e.Hostname,
+			Role: e.Role, Services: e.Collect,
+		}
+		if h.Address == "" {
+			h.Address = key
+		}
+		addr := strings.ToLower(strings.TrimSpace(h.Address))
+		if ip, err := netip.ParseAddr(addr); err == nil {
+			addr = ip.Unmap().String()
+		}
+		if seen, ok := state.Hosts[addr]; ok {
+			h.FirstSeen, h.LastSeen = seen.FirstSeen, seen.LastSeen
+		}
+		if cfg != nil {
+			if _, ok := cfg.Hosts[key]; ok {
+				h.ConfiguredAs = key
+			} else {
+				h.ConfiguredAs, _ = cfg.HostByAddress(h.Address)
+			}
+		}
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return addressLess(hosts[i].Address, hosts[j].Address) })
+	return hosts, nil
+}
+
+// addressLess orders IP addresses numerically and anything else after them by text.
+func addressLess(a, b string) bool {
+	ia, errA := netip.ParseAddr(a)
+	ib, errB := netip.ParseAddr(b)
+	switch {
+	case errA == nil && errB == nil:
+		return ia.Less(ib)
+	case errA == nil:
+		return true
+	case errB == nil:
+		return false
+	}
+	return a < b
+}
+
+// openPerception loads the discovered hosts and switches to their browser.
+func (m *model) openPerception() {
+	pv := &perceptionView{}
+	data, err := plugin.ReadConfigFile()
+	if err == nil {
+		var cfg plugin.Config
+		if err = json.Unmarshal(data, &cfg); err == nil {
+			pv.cfg = &cfg
+		}
+	}
+	if err == nil {
+		pv.hosts, err = loadPerceived(pv.cfg)
+	}
+	pv.err = err
+	m.perception = pv
+	m.mode = modePerception
+}
+
+// updatePerception handles actions in the discovered-hosts browser.
+func (m model) updatePerception(act action) (tea.Model, tea.Cmd) {
+	pv := m.perception
+	switch act {
+	case actUp:
+		if pv.cursor > 0 {
+			pv.cursor--
+		}
+	case actDown:
+		if pv.cursor < len(pv.hosts)-1 {
+			pv.cursor++
+		}
+	case actPageUp:
+		pv.cursor = max(pv.cursor-max(m.listHeight(), 1), 0)
+	case actPageDown:
+		pv.cursor = max(min(pv.cursor+max(m.listHeight(), 1), len(pv.hosts)-1), 0)
+	case actTop:
+		pv.cursor = 0
+	case actBottom:
+		pv.cursor = max(len(pv.hosts)-1, 0)
+	case actRefresh:
+		cursor := pv.cursor
+		m.openPerception()
+		m.perception.cursor = min(cursor, max(len(m.perception.hosts)-1, 0))
+	case actPromote, actSelect:
+		if pv.cursor >= len(pv.hosts) || pv.cfg == nil {
+			return m, nil
+		}
+		h := pv.hosts[pv.cursor]
+		if h.ConfiguredAs != "" {
+			m.statusMsg = fmt.Sprintf("%s is already configured as %s", h.Address, h.ConfiguredAs)
+			return m, nil
+		}
+		pv.form = newPromoteForm(h, pv.cfg)
+		return m, textinput.Blink
+	case actBack:
+		m.perception = nil
+		m.mode = modeList
+	}
+	return m, nil
+}
+
+// viewPerception renders the discovered-hosts table.
+func (m *model) viewPerception() string {
+	pv := m.perception
+	var s strings.Builder
+	s.WriteString(titleStyle.Render("Discovered Hosts") + "\n\n")
+	switch {
+	case pv.err != nil:
+		s.WriteString(downStyle.Render(fmt.Sprintf("Could not load discovered hosts: %v", pv.err)) + "\n")
+	case len(pv.hosts) == 0:
+		s.WriteString("No hosts in perception.json.\n")
+		s.WriteString(m.help("Run `nord perceive` with a perception range in the config to discover hosts.") + "\n")
+	default:
+		configured := 0
+		for _, h := range pv.hosts {
+			if h.ConfiguredAs != "" {
+				configured++
+			}
+		}
+		s.WriteString(helpStyle.Render(fmt.Sprintf("%d discovered, %d configured", len(pv.hosts), configured)) + "\n")
+		s.WriteString(clipLine(perceivedHeader, m.rowWidth()) + "\n")
+		now := time.Now()
+		rows := make([]string, len(pv.hosts))
+		for i, h := range pv.hosts {
+			style := itemStyle
+			if i == pv.cursor {
+				style = selectedItemStyle
+			}
+			// One terminal line per host: the row is clipped, not wrapped to the style's width.
+			style = style.UnsetWidth()
+			rows[i] = style.Render(clipLine(formatPerceivedRow(h, now), m.rowWidth()-style.GetHorizontalPadding()))
+		}
+		pv.offset = scrollWindow(pv.offset, pv.cursor, len(rows), m.listHeight()-1)
+		s.WriteString(windowLines(rows, pv.offset, m.listHeight()-1) + "\n")
+	}
+	if pv.form != nil {
+		s.WriteString("\n" + pv.form.view())
+		return s.String()
+	}
+	s.WriteString("\n" + m.help("Press "+m.keys.helpText(
+		helpItem{actPromote, "to add the host to the config"}, helpItem{actRefresh, "to reload"},
+		helpItem{actBack, "to go back to list"}, helpItem{actQuit, "to quit"})) + "\n")
+	return s.String()
+}
+
+// perceivedHeader labels the columns of formatPerceivedRow.
+var perceivedHeader = fmt.Sprintf("%-16s %-24s %-8s %-10s %-10s %s", "ADDRESS", "HOSTNAME", "ROLE", "FIRST", "LAST", "SERVICES")
+
+// formatPerceivedRow renders one discovered host as a table row.
+func formatPerceivedRow(h perceivedHost, now time.Time) string {
+	first, last := "-", "-"
+	if !h.FirstSeen.IsZero() {
+		first = h.FirstSeen.Local().Format("2006-01-02")
+		last = formatAge(h.LastSeen, now)
+	}
+	name := h.Hostname
+	if name == "" {
+		name = "-"
+	}
+	role := h.Role
+	if role == "" {
+		role = "-"
+	}
+	row := fmt.Sprintf("%-16s %-24s %-8s %-10s %-10s %s", h.Address, name, role, first, last, strings.Join(h.Services, ", "))
+	if h.ConfiguredAs != "" {
+		row += "  (configured as " + h.ConfiguredAs + ")"
+	}
+	return row
+}
+
+// Fields of the promotion form.
+const (
+	promoteName = iota
+	promoteCredential
+	promoteTasks
+	promoteFieldCount
+)
+
+// promoteForm asks how a discovered host goes into the config: its name, a
+// configured credential, and collect tasks beyond the detected services.
+type promoteForm struct {
+	host   perceivedHost
+	cfg    *plugin.Config
+	inputs [promoteFieldCount]textinput.Model
+	focus  int
+	err    string
+}
+
+func newPromoteForm(h perceivedHost, cfg *plugin.Config) *promoteForm {
+	f := &promoteForm{host: h, cfg: cfg}
+	for i := range f.inputs {
+		in := textinput.New()
+		in.CharLimit = 256
+		f.inputs[i] = in
+	}
+	f.inputs[promoteName].Prompt = "Name: "
+	f.inputs[promoteName].SetValue(suggestHostKey(h))
+	f.inputs[promoteCredential].Prompt = "Credential: "
+	f.inputs[promoteCredential].SetValue("none")
+	f.inputs[promoteCredential].Placeholder = strings.Join(append([]string{"none"}, credentialNames(cfg)...), ", ")
+	f.inputs[promoteTasks].Prompt = "Extra collect tasks: "
+	f.inputs[promoteTasks].Placeholder = "snmp.generic, sshcollect.info"
+	f.inputs[promoteName].Focus()
+	return f
+}
+
+// suggestHostKey proposes a config key: the first label of the host name, or
+// the address.
+func suggestHostKey(h perceivedHost) string {
+	if label, _, _ := strings.Cut(h.Hostname, "."); label != "" {
+		return strings.ToLower(label)
+	}
+	return h.Address
+}
+
+// credentialNames returns the configured credential names, sorted.
+func credentialNames(cfg *plugin.Config) []string {
+	names := make([]string, 0, len(cfg.Credentials))
+	for name := range cfg.Credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// build checks the form against the config and returns the host entry to
+// add. The detected services come first; extra tasks use the credential.
+func (f *promoteForm) build() (string, plugin.Host, error) {
+	key := strings.TrimSpace(f.inputs[promoteName].Value())
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return "", plugin.Host{}, errors.New("the name is required and has no spaces")
+	}
+	if _, taken := f.cfg.Hosts[key]; taken {
+		return "", plugin.Host{}, fmt.Errorf("a host named %q is already configured", key)
+	}
+	if other, ok := f.cfg.HostByAddress(f.host.Address); ok {
+		return "", plugin.Host{}, fmt.Errorf("%s is already configured as %q", f.host.Address, other)
+	}
+
+	cred := strings.TrimSpace(f.inputs[promoteCredential].Value())
+	if cred == "none" {
+		cred = ""
+	}
+	if _, ok := f.cfg.Credentials[cred]; cred != "" && !ok {
+		return "", plugin.Host{}, fmt.Errorf("credential %q is not configured", cred)
+	}
+
+	host := plugin.Host{Address: f.host.Address}
+	if f.host.Hostname != "" && f.host.Hostname != key {
+		host.Name = f.host.Hostname
+	}
+	if cred != "" {
+		host.Credentials = []string{cred}
+	}
+	seen := make(map[string]bool)
+	for _, svc := range f.host.Services {
+		if !seen[svc] {
+			seen[svc] = true
+			host.Collect = append(host.Collect, plugin.CollectTask{Metric: svc})
+		}
+	}
+	extra := strings.FieldsFunc(f.inputs[promoteTasks].Value(), func(r rune) bool { return r == ',' || r == ' ' })
+	for _, task := range extra {
+		if plug, action, ok := strings.Cut(task, "."); !ok || plug == "" || action == "" {
+			return "", plugin.Host{}, fmt.Errorf("collect task %q is not plugin.action", task)
+		}
+		if !seen[task] {
+			seen[task] = true
+			host.Collect = append(host.Collect, plugin.CollectTask{Metric: task, Credentials: cred})
+		}
+	}
+	return key, host, nil
+}
+
+// updatePromote drives the promotion form: tab or enter moves between
+// fields, enter on the last one or ctrl+s saves, esc cancels.
+func (m model) updatePromote(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	pv := m.perception
+	f := pv.form
+	switch msg.String() {
+	case "esc":
+		pv.form = nil
+		return m, nil
+	case "tab", "down":
+		f.move(1)
+		return m, nil
+	case "shift+tab", "up":
+		f.move(-1)
+		return m, nil
+	case "enter":
+		if f.focus < promoteFieldCount-1 {
+			f.move(1)
+			return m, nil
+		}
+		m.promote()
+		return m, nil
+	case "ctrl+s":
+		m.promote()
+		return m, nil
+	}
+	var cmd tea.Cmd
+	f.inputs[f.focus], cmd = f.inputs[f.focus].Update(msg)
+	return m, cmd
+}
+
+// move focuses the next (+1) or previous (-1) field, wrapping around.
+func (f *promoteForm) move(dir int) {
+	f.inputs[f.focus].Blur()
+	f.focus = (f.focus + dir + promoteFieldCount) % promoteFieldCount
+	f.inputs[f.focus].Focus()
+}
+
+// promote writes the form's host into config.json. On success the host joins
+// the device list under its new key, replacing its perception entry; on an
+// error the form stays open with the reason.
+func (m *model) promote() {
+	pv := m.perception
+	f := pv.form
+	key, host, err := f.build()
+	if err == nil {
+		err = plugin.AddConfigHost(key, host)
+	}
+	if err != nil {
+		f.err = err.Error()
+		return
+	}
+
+	if pv.cfg.Hosts == nil {
+		pv.cfg.Hosts = make(map[string]plugin.Host)
+	}
+	pv.cfg.Hosts[key] = host
+	pv.hosts[pv.cursor].ConfiguredAs = key
+	pv.form = nil
+
+	d := device{Host: host, Key: key, Type: "unknown", Status: "unknown"}
+	if len(host.Credentials) > 0 {
+		d.Credential = pv.cfg.Credentials[host.Credentials[0]]
+		d.Type = d.Credential.Type
+	}
+	if d.Name == "" {
+		d.Name = key
+	}
+	devices := make([]device, 0, len(m.devices)+1)
+	for _, existing := range m.devices {
+		if existing.Key != f.host.Key {
+			devices = append(devices, existing)
+		}
+	}
+	devices = append(devices, d)
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	m.devices = devices
+	m.applyView()
+	m.statusMsg = fmt.Sprintf("added %s (%s) to %s", key, host.Address, plugin.ConfigFile)
+}
+
+// view renders the promotion form below the table.
+func (f *promoteForm) view() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Add "+f.host.Address+" to the config") + "\n")
+	if len(f.host.Services) > 0 {
+		b.WriteString(helpStyle.Render("Detected: "+strings.Join(f.host.Services, ", ")) + "\n")
+	}
+	for i := range f.inputs {
+		marker := "  "
+		if i == f.focus {
+			marker = "> "
+		}
+		b.WriteString(marker + f.inputs[i].View() + "\n")
+	}
+	if f.err != "" {
+		b.WriteString(downStyle.Render(f.err) + "\n")
+	}
+	b.WriteString(helpStyle.Render("tab/enter next • enter on the last field or ctrl+s to save • esc to cancel") + "\n")
+	return b.String()
+}
@@ -0,0 +1,236 @@
+package textui
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+const perceptionConfig = `{
+  "config_version": 1,
+  "credentials": {
+    "lab": {"user": "admin", "type": "generic_snmp"}
+  },
+  "hosts": {
+    "core": {"address": "192.0.2.1", "collect": [{"metric": "network.ping"}]}
+  }
+}
+`
+
+const perceptionFixture = `{"hosts": {
+	"192.0.2.10": {"address": "192.0.2.10", "hostname": "printer.office.example", "role": "printer",
+		"collect": ["network.ping", "snmp.system", "network.ping"]},
+	"192.0.2.1":  {"collect": ["network.ping"]},
+	"192.0.2.9":  {"address": "192.0.2.9", "collect": ["network.ping", "network.ssh"]},
+	"nas.example": {"address": "nas.example", "collect": ["network.ping"]}
+}}`
+
+const perceptionStateFixture = `{"hosts": {
+	"192.0.2.10": {"first_seen": "2024-05-01T12:00:00Z", "last_seen": "2024-05-02T12:00:00Z"}
+}}`
+
+// usePerception writes the fixture config, perception.json and state.
+func usePerception(t *testing.T) string {
+	t.Helper()
+	dir := testenv.UseTempDirs(t)
+	for name, content := range map[string]string{
+		plugin.ConfigFile:                       perceptionConfig,
+		filepath.Join(dir, "perception.json"):   perceptionFixture,
+		filepath.Join(dir, perceptionStateFile): perceptionStateFixture,
+	} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadPerceived(t *testing.T) {
+	usePerception(t)
+	cfg := &plugin.Config{Hosts: map[string]plugin.Host{"core": {Address: "192.0.2.1"}, "nas": {Address: "NAS.example"}}}
+	hosts, err := loadPerceived(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, h := range hosts {
+		got = append(got, h.Address+"="+h.ConfiguredAs)
+	}
+	want := []string{"192.0.2.1=core", "192.0.2.9=", "192.0.2.10=", "nas.example=nas"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hosts = %q, want %q", got, want)
+	}
+	if h := hosts[2]; h.Hostname != "printer.office.example" || h.Role != "printer" ||
+		!h.FirstSeen.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) || !h.LastSeen.Equal(time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("192.0.2.10 = %+v", h)
+	}
+
+	// No perception run yet is not an error.
+	if err := os.Remove(plugin.DataFile("perception.json")); err != nil {
+		t.Fatal(err)
+	}
+	if hosts, err := loadPerceived(cfg); err != nil || len(hosts) != 0 {
+		t.Errorf("without perception.json: %v, %v", hosts, err)
+	}
+}
+
+// perceptionModel opens the discovered-hosts view with the cursor on address.
+func perceptionModel(t *testing.T, address string) model {
+	t.Helper()
+	m := newModel([]device{{Key: "core", Host: plugin.Host{Address: "192.0.2.1", Name: "core"}}}, nil, nil)
+	m = press(t, m, "D")
+	if m.mode != modePerception || m.perception.err != nil {
+		t.Fatalf("mode %v, err %v", m.mode, m.perception.err)
+	}
+	for m.perception.hosts[m.perception.cursor].Address != address {
+		m = press(t, m, "down")
+	}
+	return m
+}
+
+// promoteKeys is press with the form's control keys.
+func promoteKeys(t *testing.T, m model, keys ...string) model {
+	t.Helper()
+	for _, k := range keys {
+		msg, ok := wizardKeys[k]
+		if !ok {
+			msg = keyMsg(k)
+		}
+		m, _ = send(t, m, msg)
+	}
+	return m
+}
+
+func TestPromoteHost(t *testing.T) {
+	usePerception(t)
+	m := perceptionModel(t, "192.0.2.10")
+	m = press(t, m, "A")
+	f := m.perception.form
+	if f == nil {
+		t.Fatal("no promotion form")
+	}
+	if name := f.inputs[promoteName].Value(); name != "printer" {
+		t.Errorf("suggested name %q", name)
+	}
+	if !strings.Contains(m.View(), "Add 192.0.2.10 to the config") {
+		t.Error("form not shown")
+	}
+
+	// The credential, then extra tasks; enter on the last field saves.
+	m = promoteKeys(t, m, "enter", "ctrl+u")
+	m = press(t, m, strings.Split("lab", "")...)
+	m = press(t, m, "enter")
+	m = press(t, m, strings.Split("snmp.generic, snmp.system", "")...)
+	m = press(t, m, "enter")
+	if m.perception.form != nil {
+		t.Fatalf("form still open: %s", m.perception.form.err)
+	}
+	if !strings.HasPrefix(m.statusMsg, "added printer (192.0.2.10) to ") {
+		t.Errorf("status %q", m.statusMsg)
+	}
+
+	data, err := os.ReadFile(plugin.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file keeps its layout; the host is appended to hosts.
+	if !strings.HasPrefix(string(data), perceptionConfig[:strings.Index(perceptionConfig, "}]}")+3]+",\n    \"printer\": {") {
+		t.Errorf("config:\n%s", data)
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := plugin.Host{
+		Address: "192.0.2.10", Name: "printer.office.example", Credentials: []string{"lab"},
+		Collect: []plugin.CollectTask{{Metric: "network.ping"}, {Metric: "snmp.system"}, {Metric: "snmp.generic", Credentials: "lab"}},
+	}
+	if got := cfg.Hosts["printer"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("printer = %+v", got)
+	}
+
+	// The host joins the device list and shows as configured.
+	var keys []string
+	for _, d := range m.devices {
+		keys = append(keys, d.Key)
+	}
+	if strings.Join(keys, " ") != "core printer" {
+		t.Errorf("devices %v", keys)
+	}
+	if d := m.devices[1]; d.Name != "printer.office.example" || d.Type != "generic_snmp" {
+		t.Errorf("device %+v", d)
+	}
+	if h := m.perception.hosts[m.perception.cursor]; h.ConfiguredAs != "printer" {
+		t.Errorf("perceived %+v", h)
+	}
+	m = press(t, m, "A")
+	if m.perception.form != nil || m.statusMsg != "192.0.2.10 is already configured as printer" {
+		t.Errorf("second promotion: form %v, status %q", m.perception.form != nil, m.statusMsg)
+	}
+}
+
+func TestPromoteFormErrors(t *testing.T) {
+	usePerception(t)
+	m := perceptionModel(t, "192.0.2.9")
+	m = press(t, m, "A")
+	if name := m.perception.form.inputs[promoteName].Value(); name != "192.0.2.9" {
+		t.Errorf("suggested name %q", name)
+	}
+	before, err := os.ReadFile(plugin.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, credential, tasks, err string
+	}{
+		{"", "none", "", "the name is required and has no spaces"},
+		{"nine again", "none", "", "the name is required and has no spaces"},
+		{"core", "none", "", `a host named "core" is already configured`},
+		{"nine", "backup", "", `credential "backup" is not configured`},
+		{"nine", "lab", "snmp", `collect task "snmp" is not plugin.action`},
+		{"nine", "lab", "snmp.generic .info", `collect task ".info" is not plugin.action`},
+	} {
+		f := m.perception.form
+		f.inputs[promoteName].SetValue(tt.name)
+		f.inputs[promoteCredential].SetValue(tt.credential)
+		f.inputs[promoteTasks].SetValue(tt.tasks)
+		m = promoteKeys(t, m, "ctrl+s")
+		if m.perception.form == nil || m.perception.form.err != tt.err {
+			t.Errorf("%q %q %q: err %q, want %q", tt.name, tt.credential, tt.tasks, f.err, tt.err)
+		}
+		if !strings.Contains(m.View(), tt.err) {
+			t.Errorf("%q not shown", tt.err)
+		}
+	}
+	if after, _ := os.ReadFile(plugin.ConfigFile); string(after) != string(before) {
+		t.Errorf("config changed:\n%s", after)
+	}
+
+	// Another writer added the address since the view opened.
+	m.perception.form.inputs[promoteTasks].SetValue("")
+	if err := plugin.AddConfigHost("nine", plugin.Host{Address: "192.0.2.9"}); err != nil {
+		t.Fatal(err)
+	}
+	m = promoteKeys(t, m, "ctrl+s")
+	if f := m.perception.form; f == nil || !strings.Contains(f.err, plugin.ErrHostExists.Error()) {
+		t.Errorf("conflict on write: %+v", f)
+	}
+
+	// esc closes the form, then the view.
+	m = press(t, m, "esc")
+	if m.perception.form != nil || m.mode != modePerception {
+		t.Errorf("after esc: mode %v", m.mode)
+	}
+	m = press(t, m, "esc")
+	if m.mode != modeList || m.perception != nil {
+		t.Errorf("after second esc: mode %v", m.mode)
+	}
+}
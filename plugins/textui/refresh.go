@@ -0,0 +1,97 @@
+package textui
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// defaultRefreshInterval applies when the config does not set textui.refresh_interval.
+const defaultRefreshInterval = 30 * time.Second
+
+// parseRefreshInterval converts the configured interval, falling back to the default
+// for empty, invalid, or non-positive values.
+func parseRefreshInterval(s string) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return defaultRefreshInterval
+	}
+	return d
+}
+
+// tickMsg fires when the auto-refresh interval elapses.
+type tickMsg time.Time
+
+// deviceStatus is the refreshed status of a single device.
+type deviceStatus struct {
+	Status string
+	At     time.Time
+}
+
+// refreshMsg carries the results of a background refresh.
+type refreshMsg struct {
+	statuses  map[string]deviceStatus // by device key
+	detailKey string                  // host whose metrics were reloaded, if any
+	metrics   []metricRow
+	at        time.Time
+}
+
+// tickCmd schedules the next auto-refresh tick.
+func tickCmd(d time.Duration) tea.Cmd {
+	return tea.Tick(d, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+// refreshCmd re-reads statuses (and the open host's metrics) off the UI goroutine.
+// It works on a copy of the devices so the model is only touched when the message is applied.
+func refreshCmd(source *statusSource, devs []device, detailKey string) tea.Cmd {
+	snapshot := make([]device, len(devs))
+	copy(snapshot, devs)
+	return func() tea.Msg {
+		source.evaluate(snapshot)
+		msg := refreshMsg{
+			statuses:  make(map[string]deviceStatus, len(snapshot)),
+			detailKey: detailKey,
+			at:        time.Now(),
+		}
+		for _, d := range snapshot {
+			msg.statuses[d.Key] = deviceStatus{Status: d.Status, At: d.StatusAt}
+		}
+		if detailKey != "" {
+			msg.metrics = source.hostMetrics(detailKey)
+		}
+		return msg
+	}
+}
+
+// requestRefresh starts a background refresh unless one is already running.
+func (m *model) requestRefresh() tea.Cmd {
+	if m.source == nil || m.refreshing {
+		return nil
+	}
+	m.refreshing = true
+	detailKey := ""
+	if m.selectedDevice != nil {
+		detailKey = m.selectedDevice.Key
+	}
+	return refreshCmd(m.source, m.devices, detailKey)
+}
+
+// applyRefresh updates rows in place from a refreshMsg. Metrics are only applied
+// when the same host is still open in the detail view.
+func (m *model) applyRefresh(msg refreshMsg) {
+	m.refreshing = false
+	m.lastRefresh = msg.at
+	for i := range m.devices {
+		if st, ok := msg.statuses[m.devices[i].Key]; ok {
+			m.devices[i].Status = st.Status
+			m.devices[i].StatusAt = st.At
+		}
+	}
+	m.applyView()
+	if msg.detailKey != "" && m.selectedDevice != nil && m.selectedDevice.Key == msg.detailKey {
+		m.metrics = msg.metrics
+		if m.metricCursor >= len(m.metrics) {
+			m.metricCursor = 0
+		}
+	}
+}
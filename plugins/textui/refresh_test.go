@@ -0,0 +1,143 @@
+package textui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"observer/store"
+)
+
+func TestParseRefreshInterval(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"":      defaultRefreshInterval,
+		"junk":  defaultRefreshInterval,
+		"-5s":   defaultRefreshInterval,
+		"0s":    defaultRefreshInterval,
+		"10s":   10 * time.Second,
+		"2m30s": 150 * time.Second,
+	} {
+		if got := parseRefreshInterval(in); got != want {
+			t.Errorf("parseRefreshInterval(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+// refreshOf picks the refreshMsg out of msgs and counts the tickMsgs beside it.
+func refreshOf(t *testing.T, msgs []tea.Msg) (refreshMsg, int) {
+	t.Helper()
+	var refresh *refreshMsg
+	ticks := 0
+	for _, msg := range msgs {
+		switch msg := msg.(type) {
+		case refreshMsg:
+			refresh = &msg
+		case tickMsg:
+			ticks++
+		}
+	}
+	if refresh == nil {
+		t.Fatalf("no refreshMsg in %v", msgs)
+	}
+	return *refresh, ticks
+}
+
+func TestTickRefreshesStatuses(t *testing.T) {
+	now := time.Now()
+	fake := &fakeStore{latest: map[string][]store.MetricRecord{
+		"a": {statusRecord("ping", "up", now)},
+	}}
+	m := newModel(devicesFor("a", "b"), &statusSource{store: fake}, nil)
+	m.refreshInterval = time.Millisecond // so the rescheduled tick can run here
+
+	m, cmd := send(t, m, tickMsg(now))
+	if !m.refreshing {
+		t.Fatal("tick did not start a refresh")
+	}
+	// The model is untouched until the result is applied.
+	if m.devices[0].Status != "up" || m.devices[1].Status != "up" {
+		t.Errorf("statuses changed before the refresh landed: %+v", m.devices)
+	}
+
+	// A tick while a refresh is in flight only reschedules.
+	_, second := send(t, m, tickMsg(now))
+	for _, msg := range run(second) {
+		if _, ok := msg.(refreshMsg); ok {
+			t.Error("a second refresh ran concurrently")
+		}
+	}
+
+	fake.latest["a"] = []store.MetricRecord{statusRecord("ping", "down", now)}
+	refresh, ticks := refreshOf(t, run(cmd))
+	if ticks != 1 {
+		t.Errorf("%d ticks rescheduled, want 1", ticks)
+	}
+	m, _ = send(t, m, refresh)
+	if m.refreshing || !m.lastRefresh.Equal(refresh.at) {
+		t.Errorf("refreshing %v, last refresh %v", m.refreshing, m.lastRefresh)
+	}
+	if m.devices[0].Status != "down" || !m.devices[0].StatusAt.Equal(now) || m.devices[1].Status != "unknown" {
+		t.Errorf("statuses after refresh: %+v", m.devices)
+	}
+	if !strings.Contains(m.View(), "last refreshed "+m.lastRefresh.Format("15:04:05")) {
+		t.Error("footer lacks the last refreshed time")
+	}
+}
+
+func TestManualRefresh(t *testing.T) {
+	fake := &fakeStore{latest: map[string][]store.MetricRecord{}}
+	m := newModel(devicesFor("a"), &statusSource{store: fake}, nil)
+
+	m, cmd := send(t, m, keyMsg("r"))
+	if cmd == nil || !m.refreshing {
+		t.Fatal("r did not start a refresh")
+	}
+	if _, again := send(t, m, keyMsg("r")); again != nil {
+		t.Error("r started a second refresh while one was running")
+	}
+	fake.latest["a"] = []store.MetricRecord{statusRecord("ssh", "up", time.Now())}
+	m, _ = send(t, m, run(cmd)[0])
+	if m.devices[0].Status != "up" {
+		t.Errorf("status %q after r", m.devices[0].Status)
+	}
+}
+
+func TestRefreshReloadsOpenDetail(t *testing.T) {
+	at := time.Now()
+	fake := &fakeStore{latest: map[string][]store.MetricRecord{
+		"a": {{Name: "load", MetricType: "gauge", Value: "1", CollectedAt: at}},
+	}}
+	m := press(t, newModel(devicesFor("a", "b"), &statusSource{store: fake}, nil), "enter")
+	if len(m.metrics) != 1 {
+		t.Fatalf("metrics = %+v", m.metrics)
+	}
+	m.metricCursor = 0
+
+	fake.latest["a"] = append(fake.latest["a"], store.MetricRecord{Name: "uptime", MetricType: "gauge", Value: "5", CollectedAt: at})
+	m, cmd := send(t, m, keyMsg("r"))
+	refresh := run(cmd)[0].(refreshMsg)
+	if refresh.detailKey != "a" {
+		t.Fatalf("detail key %q", refresh.detailKey)
+	}
+
+	// Metrics for a host that is no longer open are dropped.
+	closed := press(t, m, "esc", "down", "enter")
+	closed, _ = send(t, closed, refresh)
+	if closed.selectedDevice.Key != "b" || len(closed.metrics) != 0 {
+		t.Errorf("host b shows %+v", closed.metrics)
+	}
+
+	m, _ = send(t, m, refresh)
+	if len(m.metrics) != 2 {
+		t.Errorf("metrics after refresh = %+v", m.metrics)
+	}
+}
+
+func TestRefreshWithoutSource(t *testing.T) {
+	m := newModel(devicesFor("a"), nil, nil)
+	if m, cmd := send(t, m, keyMsg("r")); cmd != nil || m.refreshing {
+		t.Error("refresh started with no status source")
+	}
+}
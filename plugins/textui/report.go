@@ -0,0 +1,154 @@
+package textui
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+)
+
+// maxColumnWidth caps a status table column; longer values are truncated.
+const maxColumnWidth = 32
+
+// statusColumns are the columns of the non-interactive status output.
+var statusColumns = []string{"NAME", "ADDRESS", "TYPE", "STATUS", "AGE"}
+
+// statusRow is one device in the non-interactive status output.
+type statusRow struct {
+	Key         string     `json:"key"`
+	Name        string     `json:"name"`
+	Address     string     `json:"address"`
+	Type        string     `json:"type"`
+	Status      string     `json:"status"`
+	CollectedAt *time.Time `json:"collected_at"` // null when no data was collected
+	Age         string     `json:"age"`
+}
+
+func (r statusRow) cells() []string {
+	return []string{r.Name, r.Address, r.Type, r.Status, r.Age}
+}
+
+// statusRows converts evaluated devices to output rows.
+func statusRows(devs []device, now time.Time) []statusRow {
+	rows := make([]statusRow, 0, len(devs))
+	for _, d := range devs {
+		r := statusRow{
+			Key:     d.Key,
+			Name:    d.Name,
+			Address: d.Address,
+			Type:    d.Type,
+			Status:  d.Status,
+			Age:     formatAge(d.StatusAt, now),
+		}
+		if !d.StatusAt.IsZero() {
+			at := d.StatusAt
+			r.CollectedAt = &at
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// writeStatus renders rows in the given format: "table" (default), "json", or "csv".
+func writeStatus(w io.Writer, rows []statusRow, format string) error {
+	switch strings.ToLower(format) {
+	case "", "table":
+		return writeStatusTable(w, rows)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(append([]string{"KEY"}, statusColumns...)) //nolint:errcheck
+		for _, r := range rows {
+			cw.Write(append([]string{r.Key}, r.cells()...)) //nolint:errcheck
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown output format %q (supported: table, json, csv)", format)
+	}
+}
+
+// writeStatusTable writes an aligned table whose columns fit their content, up to maxColumnWidth.
+func writeStatusTable(w io.Writer, rows []statusRow) error {
+	widths := make([]int, len(statusColumns))
+	for i, h := range statusColumns {
+		widths[i] = len(h)
+	}
+	for _, r := range rows {
+		for i, c := range r.cells() {
+			widths[i] = min(max(widths[i], len([]rune(c))), maxColumnWidth)
+		}
+	}
+
+	line := func(cells []string) string {
+		parts := make([]string, len(cells))
+		for i, c := range cells {
+			parts[i] = fmt.Sprintf("%-*s", widths[i], truncateText(c, widths[i]))
+		}
+		return strings.TrimRight(strings.Join(parts, "  "), " ") + "\n"
+	}
+
+	if _, err := io.WriteString(w, line(statusColumns)); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if _, err := io.WriteString(w, line(r.cells())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusExitCode maps the worst device status to an exit code:
+// 0 when every device is up, 2 when any is down, 1 otherwise
+// (warnings, or devices without data).
+func statusExitCode(devs []device) int {
+	code := 0
+	for _, d := range devs {
+		switch d.Status {
+		case "up":
+		case "down":
+			return 2
+		default:
+			code = 1
+		}
+	}
+	return code
+}
+
+// printStatus evaluates every device once and writes the status report to w.
+// A non-zero worst status is returned as a *plugin.ExitError.
+func (p *textuiPlugin) printStatus(w io.Writer, format string) error {
+	devices, _, err := p.loadDevices()
+	if err != nil {
+		return fmt.Errorf("failed to load devices: %w", err)
+	}
+	newStatusSource(p.controller.Store).evaluate(devices)
+
+	if err := writeStatus(w, statusRows(devices, time.Now()), format); err != nil {
+		return err
+	}
+	if code := statusExitCode(devices); code != 0 {
+		return &plugin.ExitError{Code: code}
+	}
+	return nil
+}
+
+// parseArgs parses "key=value key2=value2" into a map.
+func parseArgs(argsStr string) map[string]string {
+	result := make(map[string]string)
+	for _, part := range strings.Fields(argsStr) {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			result[kv[0]] = kv[1]
+		}
+	}
+	return result
+}
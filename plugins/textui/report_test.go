@@ -0,0 +1,148 @@
+package textui
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+// reportFleet has one device per status, a long name, and one never seen.
+func reportFleet(now time.Time) []device {
+	mk := func(key, name, addr, status string, at time.Time) device {
+		d := device{Key: key, Type: "ssh", Status: status, StatusAt: at}
+		d.Name, d.Address = name, addr
+		return d
+	}
+	return []device{
+		mk("web", "web", "10.0.0.1", "up", now.Add(-time.Minute)),
+		mk("db", "database-primary-with-a-rather-long-name", "10.0.0.2", "warning", now.Add(-time.Hour)),
+		mk("nas", "nas", "nas.lan", "unknown", time.Time{}),
+	}
+}
+
+func TestWriteStatusTable(t *testing.T) {
+	now := time.Now()
+	var buf bytes.Buffer
+	if err := writeStatus(&buf, statusRows(reportFleet(now), now), "table"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("table:\n%s", buf.String())
+	}
+	// The name column is capped at maxColumnWidth, the others fit their content.
+	wantHeader := "NAME" + strings.Repeat(" ", maxColumnWidth-4+2) + "ADDRESS   TYPE  STATUS   AGE"
+	if lines[0] != wantHeader {
+		t.Errorf("header:\n%q\nwant\n%q", lines[0], wantHeader)
+	}
+	if !strings.HasPrefix(lines[2], "database-primary-with-a-rather-…  10.0.0.2") {
+		t.Errorf("truncated row: %q", lines[2])
+	}
+	if !strings.HasSuffix(lines[1], "up       1m ago") || !strings.HasSuffix(lines[3], "unknown  no data") {
+		t.Errorf("rows:\n%s\n%s", lines[1], lines[3])
+	}
+	for _, l := range lines {
+		if strings.HasSuffix(l, " ") {
+			t.Errorf("trailing space: %q", l)
+		}
+	}
+}
+
+func TestWriteStatusJSON(t *testing.T) {
+	now := time.Now()
+	var buf bytes.Buffer
+	if err := writeStatus(&buf, statusRows(reportFleet(now), now), "JSON"); err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("%v:\n%s", err, buf.String())
+	}
+	if len(rows) != 3 || rows[1]["name"] != "database-primary-with-a-rather-long-name" || rows[0]["status"] != "up" {
+		t.Errorf("rows = %v", rows)
+	}
+	if rows[0]["collected_at"] == nil || rows[2]["collected_at"] != nil || rows[2]["age"] != "no data" {
+		t.Errorf("collected_at: %v, %v", rows[0], rows[2])
+	}
+}
+
+func TestWriteStatusCSV(t *testing.T) {
+	now := time.Now()
+	var buf bytes.Buffer
+	if err := writeStatus(&buf, statusRows(reportFleet(now), now), "csv"); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || strings.Join(records[0], ",") != "KEY,NAME,ADDRESS,TYPE,STATUS,AGE" {
+		t.Fatalf("records = %q", records)
+	}
+	if strings.Join(records[3], ",") != "nas,nas,nas.lan,ssh,unknown,no data" {
+		t.Errorf("nas = %q", records[3])
+	}
+}
+
+func TestWriteStatusUnknownFormat(t *testing.T) {
+	if err := writeStatus(&bytes.Buffer{}, nil, "yaml"); err == nil || !strings.Contains(err.Error(), `"yaml"`) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestStatusExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		statuses []string
+		want     int
+	}{
+		{nil, 0},
+		{[]string{"up", "up"}, 0},
+		{[]string{"up", "warning"}, 1},
+		{[]string{"up", "unknown"}, 1},
+		{[]string{"warning", "down", "up"}, 2},
+		{[]string{"down"}, 2},
+	} {
+		devs := make([]device, len(tc.statuses))
+		for i, s := range tc.statuses {
+			devs[i].Status = s
+		}
+		if got := statusExitCode(devs); got != tc.want {
+			t.Errorf("statusExitCode(%q) = %d, want %d", tc.statuses, got, tc.want)
+		}
+	}
+}
+
+func TestPrintStatusFromCollection(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	config := `{"hosts": {
+		"filehost": {"address": "10.0.0.5"},
+		"downhost": {"address": "10.0.0.6"}
+	}}`
+	if err := os.WriteFile(plugin.ConfigFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, collectionFile), []byte(collectionFixture), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &textuiPlugin{controller: plugin.NewController()}
+	var buf bytes.Buffer
+	err := p.printStatus(&buf, "csv")
+	var exit *plugin.ExitError
+	if !errors.As(err, &exit) || exit.Code != 2 {
+		t.Errorf("err = %v, want exit status 2", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "downhost,downhost,10.0.0.6,unknown,down,") || !strings.Contains(out, "filehost,filehost,10.0.0.5,unknown,up,") {
+		t.Errorf("output:\n%s", out)
+	}
+}
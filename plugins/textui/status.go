@@ -0,0 +1,162 @@
+package textui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+// collectionFile is the collection plugin's JSON output in the legacy shape,
+// read through plugin.ReadResults when there is no results.json.
+const collectionFile = "collection.json"
+
+// statusChecks lists the network actions whose status metrics drive a device's overall status.
+var statusChecks = map[string]bool{"ping": true, "ssh": true, "url": true}
+
+// statusSource derives device statuses from collected data.
+// The store is preferred; the last collection's files are the fallback.
+type statusSource struct {
+	store store.Store // nil when no database is configured
+}
+
+func newStatusSource(st store.Store) *statusSource {
+	return &statusSource{store: st}
+}
+
+// evaluate fills in Status and StatusAt for every device.
+// Devices without any status data are marked "unknown".
+func (s *statusSource) evaluate(devs []device) {
+	results, fileTime := loadResults()
+
+	for i := range devs {
+		d := &devs[i]
+		d.Status, d.StatusAt = "unknown", time.Time{}
+
+		if s.store != nil {
+			records, err := s.store.LatestMetrics(context.Background(), d.Key)
+			if err == nil {
+				if status, at, ok := statusFromRecords(records); ok {
+					d.Status, d.StatusAt = status, at
+					continue
+				}
+			}
+		}
+
+		if h, ok := results[d.Key]; ok {
+			if status, ok := statusFromResult(h); ok {
+				d.Status, d.StatusAt = status, fileTime
+			}
+		}
+	}
+}
+
+// loadResults returns the last collection, through plugin.ReadResults, and
+// the modification time of the file it came from, which is the best
+// available data age. Both are empty when there is no collection.
+func loadResults() (plugin.Results, time.Time) {
+	results, err := plugin.ReadResults()
+	if err != nil {
+		return nil, time.Time{}
+	}
+	for _, name := range []string{plugin.ResultsFile, collectionFile} {
+		if info, err := os.Stat(plugin.DataFile(name)); err == nil {
+			return results, info.ModTime()
+		}
+	}
+	return results, time.Time{}
+}
+
+// statusFromRecords combines the host's ping/ssh/url status metrics.
+// The returned time is the oldest sample that contributed, so the age shown is conservative.
+func statusFromRecords(records []store.MetricRecord) (string, time.Time, bool) {
+	var values []string
+	var oldest time.Time
+	for _, r := range records {
+		if r.MetricType != "status" || !isStatusCheck(r.Name) {
+			continue
+		}
+		values = append(values, r.Value)
+		if oldest.IsZero() || r.CollectedAt.Before(oldest) {
+			oldest = r.CollectedAt
+		}
+	}
+	if len(values) == 0 {
+		return "", time.Time{}, false
+	}
+	return combineStatuses(values), oldest, true
+}
+
+// statusFromResult combines the ping/ssh/url status metrics of a host in the
+// last collection. A metric without a name goes by its label.
+func statusFromResult(h *plugin.HostResult) (string, bool) {
+	var values []string
+	for _, m := range h.Metrics {
+		name := m.Name
+		if name == "" {
+			name = m.Label
+		}
+		if m.Type != "status" || !isStatusCheck(name) {
+			continue
+		}
+		values = append(values, fmt.Sprintf("%v", m.Value))
+	}
+	if len(values) == 0 {
+		return "", false
+	}
+	return combineStatuses(values), true
+}
+
+// isStatusCheck reports whether a metric name is one of the reachability checks.
+// Names such as "SSH-22" are matched by their prefix.
+func isStatusCheck(name string) bool {
+	n := strings.ToLower(name)
+	if i := strings.Index(n, "-"); i > 0 {
+		n = n[:i]
+	}
+	return statusChecks[n]
+}
+
+// combineStatuses reduces individual check results to a device status:
+// all up → "up", all down → "down", anything mixed or degraded → "warning".
+func combineStatuses(values []string) string {
+	up, down := 0, 0
+	for _, v := range values {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "up", "ok":
+			up++
+		case "down", "critical", "error":
+			down++
+		}
+	}
+	switch {
+	case up == len(values):
+		return "up"
+	case down == len(values):
+		return "down"
+	default:
+		return "warning"
+	}
+}
+
+// formatAge renders how long ago a status was collected, e.g. "3m ago".
+func formatAge(at time.Time, now time.Time) string {
+	if at.IsZero() {
+		return "no data"
+	}
+	d := now.Sub(at)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
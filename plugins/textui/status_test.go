@@ -0,0 +1,203 @@
+package textui
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+	"observer/store"
+)
+
+// fakeStore serves canned latest metrics per host key; the embedded nil
+// Store panics on anything a test did not expect to be called.
+type fakeStore struct {
+	store.Store
+	latest     map[string][]store.MetricRecord
+	interfaces map[string][]store.InterfaceRecord
+	history    []store.MetricRecord
+	since      time.Time // of the last MetricHistory call
+	err        error
+}
+
+func (f *fakeStore) LatestMetrics(ctx context.Context, hostKey string) ([]store.MetricRecord, error) {
+	return f.latest[hostKey], f.err
+}
+
+func (f *fakeStore) GetInterfaces(ctx context.Context, hostKey string) ([]store.InterfaceRecord, error) {
+	return f.interfaces[hostKey], f.err
+}
+
+func (f *fakeStore) MetricHistory(ctx context.Context, hostKey, pluginName, name, instance string, since time.Time) ([]store.MetricRecord, error) {
+	f.since = since
+	var out []store.MetricRecord
+	for _, r := range f.history {
+		if !r.CollectedAt.Before(since) {
+			out = append(out, r)
+		}
+	}
+	return out, f.err
+}
+
+func statusRecord(name, value string, at time.Time) store.MetricRecord {
+	return store.MetricRecord{Name: name, MetricType: "status", Value: value, CollectedAt: at}
+}
+
+// collectionFixture is a collection.json in the legacy shape the collection plugin writes.
+const collectionFixture = `{
+  "filehost": {"metrics": {"metrics": {
+    "ping":     {"type": "status", "value": "up"},
+    "SSH-22":   {"type": "status", "value": "ok"},
+    "url_ipv6": {"name": "url", "type": "status", "value": "up"},
+    "smtp":     {"type": "status", "value": "down"},
+    "uptime":   {"type": "gauge", "value": "12"}
+  }}},
+  "downhost": {"metrics": {"metrics": {
+    "ping": {"type": "status", "value": "down"}
+  }}},
+  "storehost": {"metrics": {"metrics": {
+    "ping": {"type": "status", "value": "down"}
+  }}},
+  "gaugehost": {"metrics": {"metrics": {
+    "load": {"type": "gauge", "value": "0.5"}
+  }}}
+}`
+
+// writeCollection writes data as collection.json in a temp data directory.
+func writeCollection(t *testing.T, data string) {
+	t.Helper()
+	testenv.UseTempDirs(t)
+	if err := os.WriteFile(plugin.DataFile(collectionFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func devicesFor(keys ...string) []device {
+	devs := make([]device, len(keys))
+	for i, k := range keys {
+		devs[i] = device{Key: k, Status: "up"}
+		devs[i].Name = k
+	}
+	return devs
+}
+
+func TestEvaluateStatuses(t *testing.T) {
+	now := time.Now()
+	older := now.Add(-10 * time.Minute)
+	fake := &fakeStore{latest: map[string][]store.MetricRecord{
+		"storehost": {
+			statusRecord("ping", "up", now),
+			statusRecord("url", "up", older),
+			{Name: "uptime", MetricType: "gauge", Value: "1", CollectedAt: now.Add(-time.Hour)},
+		},
+		"mixedhost": {statusRecord("ping", "up", now), statusRecord("ssh", "down", now)},
+		"otherhost": {statusRecord("smtp", "down", now)}, // not a reachability check
+	}}
+	writeCollection(t, collectionFixture)
+	source := &statusSource{store: fake}
+
+	devs := devicesFor("storehost", "mixedhost", "filehost", "downhost", "otherhost", "gaugehost", "nohost")
+	source.evaluate(devs)
+
+	want := map[string]string{
+		"storehost": "up", // the store wins over the file's "down"
+		"mixedhost": "warning",
+		"filehost":  "up", // smtp is not a reachability check
+		"downhost":  "down",
+		"otherhost": "unknown",
+		"gaugehost": "unknown",
+		"nohost":    "unknown",
+	}
+	for _, d := range devs {
+		if d.Status != want[d.Key] {
+			t.Errorf("%s: status %q, want %q", d.Key, d.Status, want[d.Key])
+		}
+	}
+	if !devs[0].StatusAt.Equal(older) {
+		t.Errorf("storehost: StatusAt %v, want the oldest contributing sample %v", devs[0].StatusAt, older)
+	}
+	if devs[2].StatusAt.IsZero() {
+		t.Error("filehost: StatusAt not taken from the collection file")
+	}
+	if !devs[6].StatusAt.IsZero() {
+		t.Errorf("nohost: StatusAt %v, want zero", devs[6].StatusAt)
+	}
+}
+
+func TestEvaluateFallsBackWithoutStore(t *testing.T) {
+	writeCollection(t, collectionFixture)
+	for name, st := range map[string]store.Store{
+		"no store":    nil,
+		"store error": &fakeStore{err: errors.New("database is locked")},
+	} {
+		source := &statusSource{store: st}
+		devs := devicesFor("storehost", "nohost")
+		source.evaluate(devs)
+		if devs[0].Status != "down" || devs[1].Status != "unknown" {
+			t.Errorf("%s: statuses %q, %q", name, devs[0].Status, devs[1].Status)
+		}
+	}
+}
+
+func TestEvaluateMissingCollection(t *testing.T) {
+	testenv.UseTempDirs(t)
+	source := &statusSource{}
+	devs := devicesFor("filehost")
+	source.evaluate(devs)
+	if devs[0].Status != "unknown" {
+		t.Errorf("status %q, want unknown", devs[0].Status)
+	}
+}
+
+func TestEvaluatePrefersResultsFile(t *testing.T) {
+	writeCollection(t, collectionFixture)
+	results := `{"filehost": {"metrics": [{"label": "ping", "plugin": "network", "name": "ping", "type": "status", "value": "down"}]}}`
+	if err := os.WriteFile(plugin.DataFile(plugin.ResultsFile), []byte(results), 0644); err != nil {
+		t.Fatal(err)
+	}
+	devs := devicesFor("filehost", "downhost")
+	(&statusSource{}).evaluate(devs)
+	if devs[0].Status != "down" || devs[0].StatusAt.IsZero() {
+		t.Errorf("filehost: %q at %v, want down from %s", devs[0].Status, devs[0].StatusAt, plugin.ResultsFile)
+	}
+	if devs[1].Status != "unknown" {
+		t.Errorf("downhost: %q, only in collection.json", devs[1].Status)
+	}
+}
+
+func TestCombineStatuses(t *testing.T) {
+	for _, tc := range []struct {
+		values []string
+		want   string
+	}{
+		{[]string{"up", "OK "}, "up"},
+		{[]string{"down", "critical", "error"}, "down"},
+		{[]string{"up", "down"}, "warning"},
+		{[]string{"degraded"}, "warning"},
+	} {
+		if got := combineStatuses(tc.values); got != tc.want {
+			t.Errorf("combineStatuses(%q) = %q, want %q", tc.values, got, tc.want)
+		}
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Time{}, "no data"},
+		{now.Add(-5 * time.Second), "5s ago"},
+		{now.Add(-3 * time.Minute), "3m ago"},
+		{now.Add(-5 * time.Hour), "5h ago"},
+		{now.Add(-72 * time.Hour), "3d ago"},
+	} {
+		if got := formatAge(tc.at, now); got != tc.want {
+			t.Errorf("formatAge(%v) = %q, want %q", tc.at, got, tc.want)
+		}
+	}
+}
@@ -6,12 +6,17 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss" // Re-add lipgloss
 
 	plugin "observer/base" // Correct import for the base package
 	"observer/plugins"
+	"observer/store"
 )
 
 // Ensure textuiPlugin implements the plugin.Plugin interface.
@@ -33,22 +38,73 @@ func (p *textuiPlugin) Init(c *plugin.Controller) {
 	p.controller = c
 }
 
+// ActionSafety marks the UI and the status report as read-only; collections
+// and plugin actions run from the UI are checked on their own, so read-only
+// mode refuses them there.
+func (p *textuiPlugin) ActionSafety(action string) plugin.Safety {
+	return plugin.SafetyRead
+}
+
 // OnCommand handles commands for the textui plugin.
 func (p *textuiPlugin) OnCommand(args map[string]string) error {
 	// This is the entry point for our TUI
 	if args["action"] == "start" {
+		// A new user has no config yet: create one with the first-run form.
+		if _, err := plugin.ReadConfigFile(); os.IsNotExist(err) {
+			saved, err := runWizard(p.controller)
+			if err != nil || !saved {
+				return err
+			}
+		}
+
 		// Load devices here
-		devices, err := p.loadDevices()
+		devices, cfg, err := p.loadDevices()
 		if err != nil {
 			return fmt.Errorf("failed to load devices: %w", err)
 		}
 
-		initialModel := newModel(devices)
-		if _, err := tea.NewProgram(initialModel).Run(); err != nil {
-			return fmt.Errorf("failed to start TUI: %w", err)
+		keys, err := newKeyMap(cfg.TextUI.Keys)
+		if err != nil {
+			return err
+		}
+		pal, err := resolvePalette(cfg.TextUI, os.Getenv("NO_COLOR") != "")
+		if err != nil {
+			return err
+		}
+		applyPalette(pal)
+
+		source := newStatusSource(p.controller.Store)
+		source.evaluate(devices)
+
+		initialModel := newModel(devices, source, p.controller)
+		initialModel.keys = keys
+		initialModel.actions = p.controller
+		initialModel.refreshInterval = parseRefreshInterval(cfg.TextUI.RefreshInterval)
+		if d, err := cfg.Daemon.Flow.AggregateInterval(); err == nil {
+			initialModel.flowInterval = d
+		}
+		initialModel.lastRefresh = time.Now()
+
+		// Capture plugin output into the log panel instead of letting it paint over the screen.
+		logs := newLogBuffer(logCapacity)
+		restore := captureOutput(p.controller, logs)
+		initialModel.logs = logs
+		_, runErr := tea.NewProgram(initialModel).Run()
+		restore()
+		logs.WriteTo(p.controller.Out()) //nolint:errcheck
+		if runErr != nil {
+			return fmt.Errorf("failed to start TUI: %w", runErr)
 		}
 		return nil
 	}
+	if args["action"] == "status" {
+		// One-shot status report for scripts: "-o json" or "format=json".
+		format := args["output"]
+		if f := parseArgs(args["args"])["format"]; f != "" {
+			format = f
+		}
+		return p.printStatus(p.controller.Out(), format)
+	}
 	return fmt.Errorf("unknown command for textui plugin: %s", args["action"])
 }
 
@@ -64,50 +120,94 @@ func (p *textuiPlugin) OnCollect(options map[string]interface{}) (map[string]int
 
 // --- TUI Model, Update, View ---
 
-// Styles for the TUI
+// Styles for the TUI. Everything but appStyle is built from the active palette by applyPalette.
 var (
 	appStyle = lipgloss.NewStyle().Padding(1, 2)
-	titleStyle = lipgloss.NewStyle().
-				Foreground(lipgloss.Color("#FFFDF5")).
-				Background(lipgloss.Color("#25A065")).
-				Padding(0, 1)
+
+	titleStyle lipgloss.Style
 
 	// Status styles
-	upStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))  // Green
-	downStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))   // Red
-	warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("226")) // Yellow
-
-	itemStyle = lipgloss.NewStyle().PaddingLeft(2).Width(40)
-	selectedItemStyle = lipgloss.NewStyle().
-						PaddingLeft(2). // Consistent padding
-						Foreground(lipgloss.Color("170")). // Green
-						Background(lipgloss.Color("236")). // Dark Gray
-						Bold(true).
-						Reverse(true). // Indicate selection by reversing colors
-						Width(40)
-	detailStyle = lipgloss.NewStyle().
-				Border(lipgloss.NormalBorder(), true).
-				BorderForeground(lipgloss.Color("63")). // Blue
-				Padding(1, 2).
-				Width(60)
-	helpStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	upStyle      lipgloss.Style
+	downStyle    lipgloss.Style
+	warningStyle lipgloss.Style
+
+	itemStyle         lipgloss.Style
+	selectedItemStyle lipgloss.Style
+	detailStyle       lipgloss.Style
+	helpStyle         lipgloss.Style
+	statusBarStyle    lipgloss.Style
 )
 
 // device represents a simplified device for TUI display.
 type device struct {
-	plugin.Host // Embed the full Host struct
+	plugin.Host                   // Embed the full Host struct
+	Key         string            // Config/store host key
 	Credential  plugin.Credential // Store the associated credential for details
 	Type        string            // Redundant but useful for quick display
-	Status      string            // Operational status: "up", "down", "warning"
+	Status      string            // Operational status: "up", "down", "warning", "unknown"
+	StatusAt    time.Time         // When the data behind Status was collected; zero when unknown
 }
 
 // model is the Bubble Tea application model.
 type model struct {
-	devices        []device
+	devices        []device // all devices, in load order
+	visible        []int    // indices into devices after filtering and sorting
+	filter         textinput.Model
+	filtering      bool // the filter input has focus
+	sortOrder      sortOrder
+	groups         []group // group pane entries, "All" first
+	groupCursor    int
+	focusGroups    bool            // the group pane has keyboard focus
+	selected       map[string]bool // multi-selected device keys; survives filtering
+	bulkPending    int             // bulk results still outstanding
+	bulkFailed     int
+	exportPrompt   bool // waiting for the export format key
+	source         *statusSource
 	cursor         int
 	selectedDevice *device
-	mode           mode
-	err            error
+	metrics        []metricRow    // latest metrics of selectedDevice
+	metricCursor   int            // selected row in the metrics panel
+	viewport       viewport.Model // scrollable view of an expanded metric value
+	collector      hostCollector
+	collecting     map[string]bool // host keys with an on-demand collection in flight
+	spinner        spinner.Model
+	statusMsg      string // status bar text: last action outcome or error
+
+	refreshInterval time.Duration // auto-refresh period
+	refreshing      bool          // a background refresh is in flight
+	lastRefresh     time.Time
+
+	interfaces []store.InterfaceRecord // selected device's interfaces, in table order
+	ifaceErr   error
+	ifaceSort  ifaceColumn
+	ifaceDesc  bool
+	ifacePage  int
+
+	historyRow   metricRow // metric whose history is shown
+	history      []historyPoint
+	historyErr   error
+	historyRange int       // index into historyRanges
+	historyAt    time.Time // end of the loaded range
+
+	perception *perceptionView // discovered-hosts browser; nil when closed
+
+	flows        *flowView     // top-talkers view; nil until first opened
+	flowInterval time.Duration // flow aggregation interval the view reloads at
+
+	keys      keyMap
+	actions   actionSource    // plugin actions for the command palette; nil when unavailable
+	palette   *commandPalette // open command palette, nil when closed
+	logs      *logBuffer      // captured plugin output; nil when output is not captured
+	showLog   bool
+	logScroll int // entries scrolled up from the newest; 0 follows new output
+	logSeen   int // entries accounted for, to keep a paused view pinned
+
+	width, height int // terminal size; zero until the first WindowSizeMsg
+	listOffset    int // first visible row of the device list
+	detailOffset  int // first visible line of the metrics panel
+
+	mode mode
+	err  error
 }
 
 type mode int
@@ -115,62 +215,391 @@ type mode int
 const (
 	modeList mode = iota
 	modeDetail
+	modeExpand     // full text of a long metric value in a viewport
+	modeInterfaces // interfaces table of the selected device
+	modeHistory    // sparkline of a numeric metric's history
+	modePerception // hosts found by perception, to add to the config
+	modeFlows      // top talkers of the flow collector's latest interval
 )
 
-func newModel(devs []device) model {
-	return model{
-		devices: devs,
-		cursor:  0,
-		mode:    modeList,
+func newModel(devs []device, source *statusSource, collector hostCollector) model {
+	keys, _ := newKeyMap(nil) // the defaults never conflict
+
+	filter := textinput.New()
+	filter.Prompt = "/"
+	filter.Placeholder = "filter by name, address, type"
+
+	m := model{
+		devices:    devs,
+		keys:       keys,
+		filter:     filter,
+		source:     source,
+		collector:  collector,
+		collecting: make(map[string]bool),
+		selected:   make(map[string]bool),
+		spinner:    spinner.New(spinner.WithSpinner(spinner.Dot)),
+
+		refreshInterval: defaultRefreshInterval,
+		historyRange:    defaultHistoryRange,
+		flowInterval:    plugin.DefaultFlowInterval,
+
+		cursor:   0,
+		mode:     modeList,
+		viewport: viewport.New(76, 20),
 	}
+	m.applyView()
+	return m
 }
 
 // Init is the first function that will be called. It returns an optional
 // initial command. To not perform an initial command, return nil.
 func (m model) Init() tea.Cmd {
-	return nil
+	if m.logs != nil {
+		return tea.Batch(tickCmd(m.refreshInterval), waitLogCmd(m.logs))
+	}
+	return tickCmd(m.refreshInterval)
 }
 
 // Update is called when messages are received. The function returns a new model
 // and an optional command.
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	next, cmd := m.update(msg)
+	if nm, ok := next.(model); ok {
+		// Cursors may have moved or rows changed; keep both scroll windows valid.
+		nm.keepCursorVisible()
+		return nm, cmd
+	}
+	return next, cmd
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.resize(msg.Width, msg.Height)
+		return m, nil
+
+	case collectDoneMsg:
+		return m, m.finishCollect(msg)
+
+	case logMsg:
+		return m, m.applyLog()
+
+	case bulkResultMsg:
+		return m, m.finishBulk(msg)
+
+	case actionDoneMsg:
+		m.finishAction(msg)
+		return m, m.requestRefresh()
+
+	case tickMsg:
+		return m, tea.Batch(m.requestRefresh(), tickCmd(m.refreshInterval))
+
+	case refreshMsg:
+		m.applyRefresh(msg)
+		return m, nil
+
+	case flowsMsg:
+		if m.flows != nil {
+			m.flows.apply(msg)
+		}
+		return m, nil
+
+	case flowTickMsg:
+		// Reload while the view is open; a tick of an earlier opening stops here.
+		if m.mode != modeFlows || m.flows == nil || msg.gen != m.flows.gen {
+			return m, nil
+		}
+		m.flows.loading = true
+		return m, tea.Batch(flowsCmd(m.source, m.flowInterval), flowTickCmd(m.flowInterval, msg.gen))
+
+	case spinner.TickMsg:
+		// Keep the spinner animating only while collections are running.
+		if len(m.collecting) == 0 {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "q":
+		if msg.String() == "ctrl+c" {
 			return m, tea.Quit
+		}
+		if m.palette != nil {
+			return m.updatePalette(msg)
+		}
+		if m.exportPrompt {
+			m.updateExportPrompt(msg.String())
+			return m, nil
+		}
+		if m.mode == modePerception && m.perception != nil && m.perception.form != nil {
+			return m.updatePromote(msg)
+		}
+		if m.filtering {
+			return m.updateFilter(msg)
+		}
+		act := m.keys.action(msg.String())
+		if act == actQuit {
+			return m, tea.Quit
+		}
+		if act == actLog && m.logs != nil {
+			m.showLog = !m.showLog
+			m.resize(m.width, m.height)
+			return m, nil
+		}
+		if m.updateLogScroll(act) {
+			return m, nil
+		}
+		if act == actPalette && (m.mode == modeList || m.mode == modeDetail) {
+			m.openPalette()
+			return m, nil
+		}
+		if act == actExportView && (m.mode == modeList || m.mode == modeDetail) {
+			m.exportPrompt = true
+			m.statusMsg = "export as [j]son or [c]sv? (any other key cancels)"
+			return m, nil
+		}
 
-		case "up", "k":
-			if m.mode == modeList {
-				if m.cursor > 0 {
-					m.cursor--
-				}
-			}
+		switch m.mode {
+		case modeExpand:
+			return m.updateExpand(msg, act)
+		case modeInterfaces:
+			return m.updateInterfaces(act)
+		case modeHistory:
+			return m.updateHistory(act)
+		case modePerception:
+			return m.updatePerception(act)
+		case modeFlows:
+			return m.updateFlows(act)
+		case modeDetail:
+			return m.updateDetail(act)
+		default:
+			return m.updateList(act)
+		}
+	}
 
-		case "down", "j":
-			if m.mode == modeList {
-				if m.cursor < len(m.devices)-1 {
-					m.cursor++
-				}
-			}
+	return m, nil
+}
 
-		case "enter":
-			if m.mode == modeList && len(m.devices) > 0 {
-				m.selectedDevice = &m.devices[m.cursor]
-				m.mode = modeDetail
-			}
+// updateList handles actions in the device list.
+func (m model) updateList(act action) (tea.Model, tea.Cmd) {
+	if m.focusGroups && m.updateGroups(act) {
+		return m, nil
+	}
 
-		case "esc":
-			if m.mode == modeDetail {
-				m.mode = modeList
-				m.selectedDevice = nil
-			}
+	switch act {
+	case actFocus:
+		m.focusGroups = true
+
+	case actUp:
+		if m.cursor > 0 {
+			m.cursor--
+		}
+
+	case actDown:
+		if m.cursor < len(m.visible)-1 {
+			m.cursor++
+		}
+
+	case actPageUp:
+		m.cursor = max(m.cursor-max(m.listHeight(), 1), 0)
+
+	case actPageDown:
+		m.cursor = max(min(m.cursor+max(m.listHeight(), 1), len(m.visible)-1), 0)
+
+	case actTop:
+		m.cursor = 0
+
+	case actBottom:
+		m.cursor = max(len(m.visible)-1, 0)
+
+	case actFilter:
+		m.filtering = true
+		return m, m.filter.Focus()
+
+	case actSort:
+		m.sortOrder = (m.sortOrder + 1) % sortOrderCount
+		m.applyView()
+
+	case actBack:
+		if m.filter.Value() != "" {
+			m.filter.SetValue("")
+			m.applyView()
+		}
+
+	case actRefresh:
+		// Re-evaluate statuses from the store / collection.json right away.
+		return m, m.requestRefresh()
+
+	case actCollect:
+		if d := m.currentDevice(); d != nil {
+			return m, m.startCollect(d.Key)
+		}
+
+	case actToggle:
+		m.toggleSelected()
+		if m.cursor < len(m.visible)-1 {
+			m.cursor++
+		}
+
+	case actSelectAll:
+		m.selectVisible()
+
+	case actClearSel:
+		m.selected = make(map[string]bool)
+
+	case actBulkCollect:
+		return m, m.startBulk("collect")
+
+	case actBulkPing:
+		return m, m.startBulk("ping")
+
+	case actExport:
+		m.exportSelection()
+
+	case actDiscovered:
+		m.openPerception()
+
+	case actFlows:
+		return m, m.openFlows()
+
+	case actSelect:
+		if d := m.currentDevice(); d != nil {
+			m.selectedDevice = d
+			m.metricCursor, m.detailOffset = 0, 0
+			m.loadDetail()
+			m.mode = modeDetail
 		}
 	}
+	return m, nil
+}
+
+// updateGroups handles navigation while the group pane has focus and
+// reports whether the action was consumed. Moving the cursor filters the list.
+func (m *model) updateGroups(act action) bool {
+	switch act {
+	case actUp:
+		if m.groupCursor > 0 {
+			m.groupCursor--
+			m.applyView()
+		}
+	case actDown:
+		if m.groupCursor < len(m.groups)-1 {
+			m.groupCursor++
+			m.applyView()
+		}
+	case actFocus, actSelect, actBack:
+		m.focusGroups = false
+	default:
+		return false
+	}
+	return true
+}
+
+// updateFilter feeds keys to the filter input, re-filtering on every keystroke.
+// Enter keeps the filter and returns to the list; esc clears it.
+func (m model) updateFilter(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "enter":
+		m.filtering = false
+		m.filter.Blur()
+		return m, nil
+	case "esc":
+		m.filtering = false
+		m.filter.Blur()
+		m.filter.SetValue("")
+		m.applyView()
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.filter, cmd = m.filter.Update(msg)
+	m.applyView()
+	return m, cmd
+}
+
+// updateDetail handles actions in the device detail view.
+func (m model) updateDetail(act action) (tea.Model, tea.Cmd) {
+	switch act {
+	case actUp:
+		if m.metricCursor > 0 {
+			m.metricCursor--
+		}
+
+	case actDown:
+		if m.metricCursor < len(m.metrics)-1 {
+			m.metricCursor++
+		}
+
+	case actPageUp:
+		m.metricCursor = max(m.metricCursor-max(m.metricsHeight(), 1), 0)
+
+	case actPageDown:
+		m.metricCursor = max(min(m.metricCursor+max(m.metricsHeight(), 1), len(m.metrics)-1), 0)
+
+	case actRefresh:
+		return m, m.requestRefresh()
+
+	case actCollect:
+		return m, m.startCollect(m.selectedDevice.Key)
+
+	case actInterfaces:
+		m.openInterfaces()
+
+	case actSelect:
+		m.openHistory()
 
+	case actExpand:
+		// Expand a truncated value into the scrollable viewport.
+		if m.metricCursor < len(m.metrics) && m.metrics[m.metricCursor].Long() {
+			m.viewport.SetContent(m.metrics[m.metricCursor].Value)
+			m.viewport.GotoTop()
+			m.mode = modeExpand
+		}
+
+	case actBack:
+		m.mode = modeList
+		m.selectedDevice = nil
+		m.metrics = nil
+	}
 	return m, nil
 }
 
+// updateExpand scrolls the expanded value; back returns to the detail view.
+func (m model) updateExpand(msg tea.KeyMsg, act action) (tea.Model, tea.Cmd) {
+	if act == actBack {
+		m.mode = modeDetail
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+// loadDetail (re)loads the selected device's metrics, keeping the cursor in range.
+func (m *model) loadDetail() {
+	m.metrics = nil
+	if m.selectedDevice != nil && m.source != nil {
+		m.metrics = m.source.hostMetrics(m.selectedDevice.Key)
+	}
+	if m.metricCursor >= len(m.metrics) {
+		m.metricCursor = 0
+	}
+}
+
+// statusStyle returns the color style for a status value.
+func statusStyle(status string) lipgloss.Style {
+	switch strings.ToLower(status) {
+	case "down":
+		return downStyle
+	case "warning":
+		return warningStyle
+	case "up":
+		return upStyle
+	default:
+		return lipgloss.NewStyle() // No specific color if status is unknown
+	}
+}
+
 // View renders the program's UI, which is just a string.
 func (m model) View() string {
 	s := strings.Builder{}
@@ -181,20 +610,36 @@ func (m model) View() string {
 
 	if m.mode == modeList {
 		s.WriteString(titleStyle.Render("Device List") + "\n\n")
-		for i, d := range m.devices {
-			row := fmt.Sprintf("%s (%s) - %s", d.Name, d.Type, d.Address)
-			
-			var statusColorStyle lipgloss.Style
-			switch d.Status {
-			case "down":
-				statusColorStyle = downStyle
-			case "warning":
-				statusColorStyle = warningStyle
-			case "up":
-				statusColorStyle = upStyle
-			default:
-				statusColorStyle = lipgloss.NewStyle() // No specific color if status is unknown
+		header := fmt.Sprintf("%d/%d hosts  group: %s  sort: %s", len(m.visible), len(m.devices), m.selectedGroup(), m.sortOrder)
+		if n := len(m.selected); n > 0 {
+			header += fmt.Sprintf("  %d selected", n)
+		}
+		if q := m.filterQuery(); q != "" {
+			header = fmt.Sprintf("filter: %q  ", q) + header
+		}
+		s.WriteString(helpStyle.Render(header) + "\n")
+		if m.filtering {
+			s.WriteString(m.filter.View() + "\n")
+		}
+		var list strings.Builder
+		if len(m.visible) == 0 {
+			list.WriteString(itemStyle.Render("No hosts match the filter.") + "\n")
+		}
+		rows := make([]string, 0, len(m.visible))
+		for i, di := range m.visible {
+			d := m.devices[di]
+			row := fmt.Sprintf("%s (%s) - %s  [%s, %s]", d.Name, d.Type, d.Address, d.Status, formatAge(d.StatusAt, time.Now()))
+			if m.selected[d.Key] {
+				row = "* " + row
+			} else if len(m.selected) > 0 {
+				row = "  " + row
 			}
+			collecting := ""
+			if m.collecting[d.Key] {
+				collecting = " " + m.spinner.View() + " collecting…"
+			}
+
+			statusColorStyle := statusStyle(d.Status)
 
 			var finalStyle lipgloss.Style
 			if m.cursor == i {
@@ -204,15 +649,35 @@ func (m model) View() string {
 				// Start with itemStyle, then apply the status color
 				finalStyle = itemStyle.Copy().Foreground(statusColorStyle.GetForeground())
 			}
-			s.WriteString(finalStyle.Render(row) + "\n")
+			if w := m.rowWidth(); w > 0 {
+				w -= groupPaneWidth
+				// Fit the row (and its collecting marker) on one terminal line.
+				w = max(w-lipgloss.Width(collecting), minPaneWidth)
+				row = truncateText(row, w-finalStyle.GetHorizontalPadding())
+				finalStyle = finalStyle.Width(w)
+			}
+			rows = append(rows, finalStyle.Render(row)+collecting)
+		}
+		if len(rows) > 0 {
+			list.WriteString(windowLines(rows, m.listOffset, m.listHeight()) + "\n")
 		}
-		s.WriteString(helpStyle.Render("\nPress 'q' to quit, 'enter' to view details.") + "\n")
+		pane := lipgloss.NewStyle().Width(groupPaneWidth).Render(m.renderGroupPane())
+		s.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, pane, list.String()) + "\n")
+		s.WriteString("\n" + m.help("Press "+m.keys.helpText(
+			helpItem{actQuit, "to quit"}, helpItem{actSelect, "to view details"}, helpItem{actFocus, "to switch to groups"},
+			helpItem{actFilter, "to filter"}, helpItem{actSort, "to sort"}, helpItem{actPageDown, "to page"},
+			helpItem{actCollect, "to collect now"}, helpItem{actRefresh, "to refresh"}, helpItem{actPalette, "for actions"}, helpItem{actExportView, "to export"}, helpItem{actLog, "for the log"})) + "\n")
+		s.WriteString(m.help(m.keys.helpText(
+			helpItem{actToggle, "to select"}, helpItem{actSelectAll, "to select all shown"}, helpItem{actClearSel, "to clear"},
+			helpItem{actBulkCollect, "to collect selected"}, helpItem{actBulkPing, "to ping selected"}, helpItem{actExport, "to export selected"},
+			helpItem{actDiscovered, "for discovered hosts"}, helpItem{actFlows, "for top talkers"})) + "\n")
 	} else if m.mode == modeDetail && m.selectedDevice != nil {
 		s.WriteString(titleStyle.Render("Device Details") + "\n\n")
 		detailContent := strings.Builder{}
 		detailContent.WriteString(fmt.Sprintf("Name:        %s\n", m.selectedDevice.Name))
 		detailContent.WriteString(fmt.Sprintf("Address:     %s\n", m.selectedDevice.Address))
 		detailContent.WriteString(fmt.Sprintf("Type:        %s\n", m.selectedDevice.Type))
+		detailContent.WriteString(fmt.Sprintf("Status:      %s (%s)\n", m.selectedDevice.Status, formatAge(m.selectedDevice.StatusAt, time.Now())))
 		detailContent.WriteString(fmt.Sprintf("User:        %s\n", m.selectedDevice.Credential.User))
 		detailContent.WriteString(fmt.Sprintf("Port:        %d\n", m.selectedDevice.Credential.Port))
 		detailContent.WriteString(fmt.Sprintf("Community:   %s\n", m.selectedDevice.Credential.Community))
@@ -221,41 +686,90 @@ func (m model) View() string {
 		for _, task := range m.selectedDevice.Collect {
 			detailContent.WriteString(fmt.Sprintf("  - Metric: %s, Credentials: %s\n", task.Metric, task.Credentials))
 		}
-		// Add more details from plugin.Host and plugin.Credential as needed
-		s.WriteString(detailStyle.Render(detailContent.String()) + "\n")
-		s.WriteString(helpStyle.Render("\nPress 'esc' to go back to list, 'q' to quit.") + "\n")
+		detailContent.WriteString("\nMetrics:\n")
+		lines, _ := metricLines(m.metrics, m.metricCursor, time.Now())
+		inner := m.detailWidth() - detailStyle.GetHorizontalPadding()
+		for i := range lines {
+			lines[i] = clipLine(lines[i], inner)
+		}
+		detailContent.WriteString(windowLines(lines, m.detailOffset, m.metricsHeight()))
+		s.WriteString(detailStyle.Width(m.detailWidth()).Render(detailContent.String()) + "\n")
+		if m.collecting[m.selectedDevice.Key] {
+			s.WriteString(m.spinner.View() + " collecting…\n")
+		}
+		s.WriteString("\n" + m.help("Press "+m.keys.helpText(
+			helpItem{actBack, "to go back to list"}, helpItem{actSelect, "for history"}, helpItem{actInterfaces, "for interfaces"},
+			helpItem{actExpand, "to expand a value"}, helpItem{actCollect, "to collect now"}, helpItem{actRefresh, "to reload"}, helpItem{actPalette, "for actions"}, helpItem{actExportView, "to export"},
+			helpItem{actQuit, "to quit"})) + "\n")
+	} else if m.mode == modePerception && m.perception != nil {
+		s.WriteString(m.viewPerception())
+	} else if m.mode == modeFlows && m.flows != nil {
+		s.WriteString(m.viewFlows())
+	} else if m.mode == modeHistory && m.selectedDevice != nil {
+		s.WriteString(m.viewHistory())
+	} else if m.mode == modeInterfaces && m.selectedDevice != nil {
+		s.WriteString(m.viewInterfaces())
+	} else if m.mode == modeExpand && m.selectedDevice != nil {
+		title := m.selectedDevice.Name
+		if m.metricCursor < len(m.metrics) {
+			title += " / " + m.metrics[m.metricCursor].Label()
+		}
+		s.WriteString(titleStyle.Render(title) + "\n\n")
+		s.WriteString(m.viewport.View() + "\n")
+		s.WriteString("\n" + m.help(fmt.Sprintf("%3.f%%  up/down to scroll, '%s' to go back.", m.viewport.ScrollPercent()*100, m.keys.key(actBack))) + "\n")
+	}
+
+	if m.palette != nil {
+		s.WriteString("\n" + m.viewPalette())
+	}
+	if m.showLog {
+		s.WriteString("\n" + m.renderLogPanel())
+	}
+
+	footer := fmt.Sprintf("last refreshed %s", m.lastRefresh.Format("15:04:05"))
+	if m.refreshing {
+		footer += " (refreshing…)"
 	}
+	if m.statusMsg != "" {
+		footer = m.statusMsg + "  |  " + footer
+	}
+	s.WriteString("\n" + statusBarStyle.Render(footer) + "\n")
 
 	return appStyle.Render(s.String())
 }
 
 // loadDevices loads device configuration from data/config.json and data/perception.json.
-func (p *textuiPlugin) loadDevices() ([]device, error) {
+// The parsed config is returned alongside so callers can read the textui settings.
+func (p *textuiPlugin) loadDevices() ([]device, *plugin.Config, error) {
 	// This logic is adapted from plugins/collection/collection.go and plugins/api/api.go
 	// to load the config and then extract hosts.
 
 	// 1. Load Config
-	configFile, err := os.ReadFile("data/config.json")
+	configFile, err := plugin.ReadConfigFile()
 	if err != nil {
-		return nil, fmt.Errorf("could not read config file: %w", err)
+		return nil, nil, fmt.Errorf("could not read config file: %w", err)
 	}
 
 	var cfg plugin.Config // Use plugin.Config
 	if err := json.Unmarshal(configFile, &cfg); err != nil {
-		return nil, fmt.Errorf("could not parse config file: %w", err)
+		return nil, nil, fmt.Errorf("could not parse config file: %w", err)
 	}
 
 	// 2. Load and merge hosts from perception.json
-	perceptionFile, err := os.ReadFile("data/perception.json")
+	perceptionFile, err := os.ReadFile(plugin.DataFile("perception.json"))
 	if err == nil { // Only try to unmarshal if file exists
 		var perceptionData struct {
 			Hosts map[string]plugin.Host `json:"hosts"`
 		}
 		if err := json.Unmarshal(perceptionFile, &perceptionData); err == nil {
 			for ip, host := range perceptionData.Hosts {
-				if _, exists := cfg.Hosts[ip]; !exists {
-					cfg.Hosts[ip] = host // Add the host if it doesn't already exist
+				if _, exists := cfg.Hosts[ip]; exists {
+					continue
+				}
+				if _, promoted := cfg.HostByAddress(host.Address); promoted {
+					continue // configured under a name
 				}
+				cfg.Hosts[ip] = host // Add the host if it doesn't already exist
 			}
 		} else {
 			fmt.Fprintf(os.Stderr, "Warning: could not parse perception.json: %v\n", err)
@@ -265,9 +779,7 @@ func (p *textuiPlugin) loadDevices() ([]device, error) {
 	}
 
 	var loadedDevices []device
-	statusCycle := []string{"up", "down", "warning"}
-	statusIndex := 0
-	for _, host := range cfg.Hosts {
+	for key, host := range cfg.Hosts {
 		deviceType := "unknown"
 		var cred plugin.Credential
 		if len(host.Credentials) > 0 {
@@ -277,13 +789,17 @@ func (p *textuiPlugin) loadDevices() ([]device, error) {
 			}
 		}
 
+		if host.Name == "" {
+			host.Name = key
+		}
+
 		loadedDevices = append(loadedDevices, device{
 			Host:       host, // Embed the full host
+			Key:        key,
 			Credential: cred,
 			Type:       deviceType,
-			Status:     statusCycle[statusIndex%len(statusCycle)], // Assign alternating status
+			Status:     "unknown", // Filled in by statusSource.evaluate
 		})
-		statusIndex++
 	}
 
 	// Sort devices by name for consistent display
@@ -291,10 +807,11 @@ func (p *textuiPlugin) loadDevices() ([]device, error) {
 		return loadedDevices[i].Name < loadedDevices[j].Name
 	})
 
-	return loadedDevices, nil
+	return loadedDevices, &cfg, nil
 }
 
 // init function to register the plugin
 func init() {
+	applyPalette(palettes["dark"])
 	plugins.Register(&textuiPlugin{})
 }
@@ -0,0 +1,73 @@
+package textui
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// keyMsg builds the tea.KeyMsg whose String() is key.
+func keyMsg(key string) tea.KeyMsg {
+	switch key {
+	case "enter":
+		return tea.KeyMsg{Type: tea.KeyEnter}
+	case "esc":
+		return tea.KeyMsg{Type: tea.KeyEsc}
+	case "tab":
+		return tea.KeyMsg{Type: tea.KeyTab}
+	case "up":
+		return tea.KeyMsg{Type: tea.KeyUp}
+	case "down":
+		return tea.KeyMsg{Type: tea.KeyDown}
+	case "backspace":
+		return tea.KeyMsg{Type: tea.KeyBackspace}
+	case " ":
+		return tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+}
+
+// send feeds msg through Update and returns the resulting model and command.
+func send(t *testing.T, m model, msg tea.Msg) (model, tea.Cmd) {
+	t.Helper()
+	next, cmd := m.Update(msg)
+	nm, ok := next.(model)
+	if !ok {
+		t.Fatalf("Update returned %T", next)
+	}
+	return nm, cmd
+}
+
+// run executes cmd and any batch it expands to, returning the messages in
+// order. Only use it on commands that do not sleep, i.e. not tickCmd.
+func run(cmd tea.Cmd) []tea.Msg {
+	if cmd == nil {
+		return nil
+	}
+	msg := cmd()
+	if batch, ok := msg.(tea.BatchMsg); ok {
+		var msgs []tea.Msg
+		for _, c := range batch {
+			msgs = append(msgs, run(c)...)
+		}
+		return msgs
+	}
+	return []tea.Msg{msg}
+}
+
+// press sends each key in turn, discarding the commands.
+func press(t *testing.T, m model, keys ...string) model {
+	t.Helper()
+	for _, k := range keys {
+		m, _ = send(t, m, keyMsg(k))
+	}
+	return m
+}
+
+func TestKeyMsgNames(t *testing.T) {
+	for _, k := range []string{"enter", "esc", "tab", "up", "down", "backspace", " ", "x", "/"} {
+		if got := keyMsg(k).String(); got != k {
+			t.Errorf("keyMsg(%q).String() = %q", k, got)
+		}
+	}
+}
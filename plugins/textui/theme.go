@@ -0,0 +1,112 @@
+package textui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	plugin "observer/base"
+)
+
+// palette holds the colors every TUI style is built from.
+type palette struct {
+	Up, Down, Warning      lipgloss.TerminalColor
+	Selection, SelectionBg lipgloss.TerminalColor
+	Title, TitleBg         lipgloss.TerminalColor
+	Help, Border           lipgloss.TerminalColor
+	StatusBar, StatusBarBg lipgloss.TerminalColor
+	Mono                   bool // no colors: statuses are told apart by weight and underline
+}
+
+// palettes are the named themes selectable with textui.theme.
+var palettes = map[string]palette{
+	"dark": {
+		Up: lipgloss.Color("10"), Down: lipgloss.Color("9"), Warning: lipgloss.Color("226"),
+		Selection: lipgloss.Color("170"), SelectionBg: lipgloss.Color("236"),
+		Title: lipgloss.Color("#FFFDF5"), TitleBg: lipgloss.Color("#25A065"),
+		Help: lipgloss.Color("241"), Border: lipgloss.Color("63"),
+		StatusBar: lipgloss.Color("252"), StatusBarBg: lipgloss.Color("236"),
+	},
+	"light": {
+		Up: lipgloss.Color("28"), Down: lipgloss.Color("160"), Warning: lipgloss.Color("130"),
+		Selection: lipgloss.Color("90"), SelectionBg: lipgloss.Color("254"),
+		Title: lipgloss.Color("#FFFFFF"), TitleBg: lipgloss.Color("#1B7A4A"),
+		Help: lipgloss.Color("243"), Border: lipgloss.Color("25"),
+		StatusBar: lipgloss.Color("235"), StatusBarBg: lipgloss.Color("252"),
+	},
+	"mono": {
+		Up: lipgloss.NoColor{}, Down: lipgloss.NoColor{}, Warning: lipgloss.NoColor{},
+		Selection: lipgloss.NoColor{}, SelectionBg: lipgloss.NoColor{},
+		Title: lipgloss.NoColor{}, TitleBg: lipgloss.NoColor{},
+		Help: lipgloss.NoColor{}, Border: lipgloss.NoColor{},
+		StatusBar: lipgloss.NoColor{}, StatusBarBg: lipgloss.NoColor{},
+		Mono: true,
+	},
+}
+
+// resolvePalette picks the configured theme and applies color overrides.
+// noColor (the NO_COLOR convention) forces the monochrome palette and ignores overrides.
+func resolvePalette(cfg plugin.TextUIConfig, noColor bool) (palette, error) {
+	if noColor {
+		return palettes["mono"], nil
+	}
+	name := strings.ToLower(strings.TrimSpace(cfg.Theme))
+	if name == "" {
+		name = "dark"
+	}
+	p, ok := palettes[name]
+	if !ok {
+		return palette{}, fmt.Errorf("textui: unknown theme %q (supported: dark, light, mono)", cfg.Theme)
+	}
+	for _, o := range []struct {
+		value string
+		dst   *lipgloss.TerminalColor
+	}{
+		{cfg.Colors.Up, &p.Up},
+		{cfg.Colors.Down, &p.Down},
+		{cfg.Colors.Warning, &p.Warning},
+		{cfg.Colors.Selection, &p.Selection},
+	} {
+		if v := strings.TrimSpace(o.value); v != "" {
+			*o.dst = lipgloss.Color(v)
+		}
+	}
+	return p, nil
+}
+
+// applyPalette rebuilds the package styles from p.
+func applyPalette(p palette) {
+	titleStyle = lipgloss.NewStyle().
+		Foreground(p.Title).
+		Background(p.TitleBg).
+		Padding(0, 1)
+
+	upStyle = lipgloss.NewStyle().Foreground(p.Up)
+	downStyle = lipgloss.NewStyle().Foreground(p.Down)
+	warningStyle = lipgloss.NewStyle().Foreground(p.Warning)
+	if p.Mono {
+		titleStyle = titleStyle.Reverse(true)
+		downStyle = downStyle.Bold(true)
+		warningStyle = warningStyle.Underline(true)
+	}
+
+	itemStyle = lipgloss.NewStyle().PaddingLeft(2).Width(40)
+	selectedItemStyle = lipgloss.NewStyle().
+		PaddingLeft(2).
+		Foreground(p.Selection).
+		Background(p.SelectionBg).
+		Bold(true).
+		Reverse(true). // Indicate selection by reversing colors
+		Width(40)
+	detailStyle = lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder(), true).
+		BorderForeground(p.Border).
+		Padding(1, 2).
+		Width(60)
+	helpStyle = lipgloss.NewStyle().Foreground(p.Help)
+	statusBarStyle = lipgloss.NewStyle().Foreground(p.StatusBar).Background(p.StatusBarBg).Padding(0, 1)
+	if p.Mono {
+		statusBarStyle = statusBarStyle.Reverse(true)
+	}
+}
@@ -0,0 +1,63 @@
+package textui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+
+	plugin "observer/base"
+)
+
+func TestResolvePalette(t *testing.T) {
+	p, err := resolvePalette(plugin.TextUIConfig{}, false)
+	if err != nil || p != palettes["dark"] {
+		t.Errorf("default palette = %+v, %v", p, err)
+	}
+	p, err = resolvePalette(plugin.TextUIConfig{Theme: " Light "}, false)
+	if err != nil || p != palettes["light"] {
+		t.Errorf("light palette = %+v, %v", p, err)
+	}
+	if _, err := resolvePalette(plugin.TextUIConfig{Theme: "solarized"}, false); err == nil || !strings.Contains(err.Error(), `"solarized"`) {
+		t.Errorf("unknown theme: %v", err)
+	}
+
+	cfg := plugin.TextUIConfig{Theme: "light", Colors: plugin.TextUIColors{Up: "#00ff00", Selection: "201"}}
+	p, err = resolvePalette(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Up != lipgloss.Color("#00ff00") || p.Selection != lipgloss.Color("201") || p.Down != palettes["light"].Down {
+		t.Errorf("overridden palette = %+v", p)
+	}
+
+	// NO_COLOR wins over the theme and its overrides.
+	if p, _ := resolvePalette(cfg, true); !p.Mono || p.Up != (lipgloss.NoColor{}) {
+		t.Errorf("NO_COLOR palette = %+v", p)
+	}
+}
+
+func TestApplyPaletteChangesStyles(t *testing.T) {
+	t.Cleanup(func() { applyPalette(palettes["dark"]) })
+
+	custom := palettes["dark"]
+	custom.Up, custom.Down, custom.Selection = lipgloss.Color("#00ff00"), lipgloss.Color("#ff0000"), lipgloss.Color("201")
+	applyPalette(custom)
+	if upStyle.GetForeground() != lipgloss.Color("#00ff00") || downStyle.GetForeground() != lipgloss.Color("#ff0000") {
+		t.Errorf("status colors: up %v, down %v", upStyle.GetForeground(), downStyle.GetForeground())
+	}
+	if selectedItemStyle.GetForeground() != lipgloss.Color("201") || statusStyle("up").GetForeground() != lipgloss.Color("#00ff00") {
+		t.Errorf("selection %v", selectedItemStyle.GetForeground())
+	}
+	if downStyle.GetBold() || titleStyle.GetReverse() {
+		t.Error("color palette uses the monochrome attributes")
+	}
+
+	applyPalette(palettes["mono"])
+	if upStyle.GetForeground() != (lipgloss.NoColor{}) {
+		t.Errorf("mono up color %v", upStyle.GetForeground())
+	}
+	if !downStyle.GetBold() || !warningStyle.GetUnderline() || !titleStyle.GetReverse() || !statusBarStyle.GetReverse() {
+		t.Error("mono palette does not tell statuses apart without color")
+	}
+}
@@ -0,0 +1,326 @@
+package textui
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	plugin "observer/base"
+)
+
+// wizardField is one input of the first-run form.
+type wizardField struct {
+	label    string
+	input    textinput.Model
+	cred     string              // only shown for this credential type; "" always, "any" for snmp and ssh
+	validate func(string) string // returns the inline error for a value, "" when it is fine
+	err      string
+}
+
+// Indexes of the wizard's fields.
+const (
+	fieldHost = iota
+	fieldAddress
+	fieldCredType
+	fieldCommunity
+	fieldSNMPVersion
+	fieldSSHUser
+	fieldSSHPass
+	fieldSSHPort
+	fieldDeviceType
+	fieldRange
+)
+
+// wizardModel is the form shown by `nord ui` when there is no config file.
+// It creates the first host, an optional credential and an optional
+// perception range. Update has no side effects: once saved, cfg holds the
+// validated config and the caller writes it.
+type wizardModel struct {
+	fields    []*wizardField
+	focus     int
+	err       string // why the last save failed
+	cfg       *plugin.Config
+	cancelled bool
+}
+
+func newWizard() *wizardModel {
+	field := func(label, value, cred string, validate func(string) string) *wizardField {
+		in := textinput.New()
+		in.Prompt = ""
+		in.SetValue(value)
+		in.CharLimit = 256
+		return &wizardField{label: label, input: in, cred: cred, validate: validate}
+	}
+	w := &wizardModel{fields: []*wizardField{
+		fieldHost:        field("Host name", "router", "", validateWord),
+		fieldAddress:     field("Address", "", "", validateWord),
+		fieldCredType:    field("Credential (snmp, ssh, none)", "none", "", validateCredType),
+		fieldCommunity:   field("SNMP community", "public", "snmp", validateRequired),
+		fieldSNMPVersion: field("SNMP version", "2c", "snmp", validateSNMPVersion),
+		fieldSSHUser:     field("SSH user", "admin", "ssh", validateWord),
+		fieldSSHPass:     field("SSH password", "", "ssh", nil),
+		fieldSSHPort:     field("SSH port", "22", "ssh", validatePort),
+		fieldDeviceType:  field("Device type", "generic", "any", validateWord),
+		fieldRange:       field("Perception range (CIDR, optional)", "", "", validateRange),
+	}}
+	w.fields[fieldAddress].input.Placeholder = "192.168.1.1"
+	w.fields[fieldRange].input.Placeholder = "192.168.1.0/24"
+	w.fields[fieldSSHPass].input.EchoMode = textinput.EchoPassword
+	w.fields[w.focus].input.Focus()
+	return w
+}
+
+func validateRequired(v string) string {
+	if v == "" {
+		return "required"
+	}
+	return ""
+}
+
+func validateWord(v string) string {
+	if v == "" {
+		return "required"
+	}
+	if strings.ContainsAny(v, " \t") {
+		return "no spaces"
+	}
+	return ""
+}
+
+func validateCredType(v string) string {
+	switch v {
+	case "snmp", "ssh", "none":
+		return ""
+	}
+	return "snmp, ssh or none"
+}
+
+func validateSNMPVersion(v string) string {
+	switch v {
+	case "1", "2c", "3":
+		return ""
+	}
+	return "1, 2c or 3"
+}
+
+func validatePort(v string) string {
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 {
+		return "a port from 1 to 65535"
+	}
+	return ""
+}
+
+func validateRange(v string) string {
+	if v == "" {
+		return ""
+	}
+	if _, _, err := net.ParseCIDR(v); err != nil {
+		return "a CIDR range such as 192.168.1.0/24"
+	}
+	return ""
+}
+
+// value returns a field's trimmed input.
+func (w *wizardModel) value(i int) string {
+	return strings.TrimSpace(w.fields[i].input.Value())
+}
+
+// visible reports whether field i applies to the credential type entered.
+func (w *wizardModel) visible(i int) bool {
+	switch cred := w.fields[i].cred; cred {
+	case "":
+		return true
+	case "any":
+		return w.value(fieldCredType) == "snmp" || w.value(fieldCredType) == "ssh"
+	default:
+		return w.value(fieldCredType) == cred
+	}
+}
+
+// check validates field i and records its inline error. It reports whether the value is fine.
+func (w *wizardModel) check(i int) bool {
+	f := w.fields[i]
+	f.err = ""
+	if f.validate != nil {
+		f.err = f.validate(w.value(i))
+	}
+	return f.err == ""
+}
+
+// move focuses the next (+1) or previous (-1) visible field, checking the one left.
+// It reports false when there is no field that way.
+func (w *wizardModel) move(dir int) bool {
+	for i := w.focus + dir; i >= 0 && i < len(w.fields); i += dir {
+		if !w.visible(i) {
+			continue
+		}
+		w.check(w.focus)
+		w.fields[w.focus].input.Blur()
+		w.focus = i
+		w.fields[i].input.Focus()
+		return true
+	}
+	return false
+}
+
+// answers collects the form into the answers `nord init` would ask for.
+func (w *wizardModel) answers() plugin.StarterAnswers {
+	a := plugin.StarterAnswers{
+		HostKey:     w.value(fieldHost),
+		HostAddress: w.value(fieldAddress),
+		CredType:    w.value(fieldCredType),
+		DatabaseURL: plugin.StarterDatabaseURL(), // as with `nord init`
+		ScanRange:   w.value(fieldRange),
+	}
+	switch a.CredType {
+	case "snmp":
+		a.Community, a.SNMPVersion = w.value(fieldCommunity), w.value(fieldSNMPVersion)
+	case "ssh":
+		a.SSHUser, a.SSHPort = w.value(fieldSSHUser), w.value(fieldSSHPort)
+		a.SSHPass = w.fields[fieldSSHPass].input.Value()
+	}
+	if a.CredType != "none" {
+		a.DeviceType = w.value(fieldDeviceType)
+	}
+	if a.ScanRange == "" {
+		a.ScanRange = "none"
+	}
+	return a
+}
+
+// save validates every visible field and builds the config. On an error the
+// first invalid field takes the focus and the form stays open.
+func (w *wizardModel) save() (tea.Model, tea.Cmd) {
+	first := -1
+	for i := range w.fields {
+		if w.visible(i) && !w.check(i) && first < 0 {
+			first = i
+		}
+	}
+	if first >= 0 {
+		w.fields[w.focus].input.Blur()
+		w.focus = first
+		w.fields[first].input.Focus()
+		w.err = "fix the highlighted fields"
+		return w, nil
+	}
+	cfg, err := plugin.StarterConfig(w.answers())
+	if err != nil {
+		w.err = err.Error()
+		return w, nil
+	}
+	w.cfg, w.err = cfg, ""
+	return w, tea.Quit
+}
+
+func (w *wizardModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (w *wizardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return w, nil
+	}
+	switch key.String() {
+	case "esc", "ctrl+c":
+		w.cancelled = true
+		return w, tea.Quit
+	case "ctrl+s":
+		return w.save()
+	case "tab", "down":
+		w.move(1)
+		return w, nil
+	case "shift+tab", "up":
+		w.move(-1)
+		return w, nil
+	case "enter":
+		if !w.move(1) {
+			return w.save()
+		}
+		return w, nil
+	}
+	var cmd tea.Cmd
+	f := w.fields[w.focus]
+	f.input, cmd = f.input.Update(msg)
+	if f.err != "" {
+		w.check(w.focus)
+	}
+	return w, cmd
+}
+
+func (w *wizardModel) View() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Welcome to nord") + "\n\n")
+	b.WriteString(fmt.Sprintf("There is no %s yet. Describe a first host to create it.\n\n", plugin.ConfigFile))
+	for i, f := range w.fields {
+		if !w.visible(i) {
+			continue
+		}
+		marker := "  "
+		if i == w.focus {
+			marker = "> "
+		}
+		line := fmt.Sprintf("%s%-34s %s", marker, f.label, f.input.View())
+		if f.err != "" {
+			line += "  " + downStyle.Render(f.err)
+		}
+		b.WriteString(line + "\n")
+	}
+	if w.err != "" {
+		b.WriteString("\n" + downStyle.Render(w.err) + "\n")
+	}
+	b.WriteString("\n" + helpStyle.Render("tab/enter next • shift+tab previous • enter on the last field or ctrl+s to save • esc to cancel") + "\n")
+	return appStyle.Render(b.String())
+}
+
+// runWizard shows the first-run form and writes the config it produces to
+// plugin.ConfigFile, then says so through c. It reports false when the user
+// cancelled; nothing is written then.
+func runWizard(c *plugin.Controller) (bool, error) {
+	pal, err := resolvePalette(plugin.TextUIConfig{}, os.Getenv("NO_COLOR") != "")
+	if err != nil {
+		return false, err
+	}
+	applyPalette(pal)
+
+	final, err := tea.NewProgram(newWizard()).Run()
+	if err != nil {
+		return false, fmt.Errorf("failed to start setup form: %w", err)
+	}
+	w := final.(*wizardModel)
+	if w.cancelled || w.cfg == nil {
+		return false, nil
+	}
+	data, err := plugin.StarterJSON(w.cfg)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(plugin.ConfigFile), 0755); err != nil {
+		return false, fmt.Errorf("could not create %s: %w", filepath.Dir(plugin.ConfigFile), err)
+	}
+	// O_EXCL: never replace a config that appeared while the form was open.
+	f, err := os.OpenFile(plugin.ConfigFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600) // credentials live in the config
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return false, fmt.Errorf("%s was created while the form was open; not overwriting it", plugin.ConfigFile)
+		}
+		return false, err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+	c.Printf("Wrote %s\n", plugin.ConfigFile)
+	return true, nil
+}
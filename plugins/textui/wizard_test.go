@@ -0,0 +1,168 @@
+package textui
+
+import (
+	"reflect"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	plugin "observer/base"
+)
+
+// wizardKeys are the form's control keys that keyMsg does not build.
+var wizardKeys = map[string]tea.KeyMsg{
+	"ctrl+s":    {Type: tea.KeyCtrlS},
+	"ctrl+u":    {Type: tea.KeyCtrlU},
+	"shift+tab": {Type: tea.KeyShiftTab},
+}
+
+// fill sends each key or text to the form in turn and returns the last command.
+func fill(t *testing.T, w *wizardModel, keys ...string) tea.Cmd {
+	t.Helper()
+	var cmd tea.Cmd
+	for _, k := range keys {
+		msg, ok := wizardKeys[k]
+		if !ok {
+			msg = keyMsg(k)
+		}
+		next, c := w.Update(msg)
+		if next != w {
+			t.Fatalf("Update returned %T", next)
+		}
+		cmd = c
+	}
+	return cmd
+}
+
+// quits reports whether cmd is tea.Quit.
+func quits(cmd tea.Cmd) bool {
+	if cmd == nil {
+		return false
+	}
+	_, ok := cmd().(tea.QuitMsg)
+	return ok
+}
+
+func TestWizardSNMP(t *testing.T) {
+	w := newWizard()
+	cmd := fill(t, w,
+		"ctrl+u", "core-sw", "tab", // host
+		"192.0.2.1", "enter", // address
+		"ctrl+u", "snmp", "tab", // credential
+		"ctrl+u", "n0rd", "tab", // community
+		"tab",                      // version stays 2c
+		"ctrl+u", "juniper", "tab", // device type; the SSH fields are skipped
+		"192.0.2.0/24", "enter", // range, the last field
+	)
+	if !quits(cmd) || w.cancelled || w.err != "" {
+		t.Fatalf("not saved: err %q, cancelled %v", w.err, w.cancelled)
+	}
+	want, err := plugin.StarterConfig(plugin.StarterAnswers{
+		HostKey: "core-sw", HostAddress: "192.0.2.1", CredType: "snmp",
+		Community: "n0rd", SNMPVersion: "2c", DeviceType: "juniper",
+		DatabaseURL: plugin.StarterDatabaseURL(), ScanRange: "192.0.2.0/24",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(w.cfg, want) {
+		t.Errorf("config = %+v, want %+v", w.cfg, want)
+	}
+	if cred := w.cfg.Credentials["core-sw_snmp"]; cred.Community != "n0rd" || cred.Port != 161 || cred.Type != "juniper" {
+		t.Errorf("credential = %+v", cred)
+	}
+	if env := w.cfg.Perception["local_network"]; !reflect.DeepEqual(env.Ranges, []string{"192.0.2.0/24"}) {
+		t.Errorf("perception = %+v", w.cfg.Perception)
+	}
+}
+
+func TestWizardSSH(t *testing.T) {
+	w := newWizard()
+	fill(t, w, "tab", "192.0.2.5", "tab", "ctrl+u", "ssh", "tab")
+	if w.focus != fieldSSHUser {
+		t.Fatalf("focus on %d after choosing ssh, want the SSH user", w.focus)
+	}
+	cmd := fill(t, w, "tab", "s3cret pass", "tab", "ctrl+u", "2222", "ctrl+s")
+	if !quits(cmd) {
+		t.Fatalf("not saved: %q", w.err)
+	}
+	cred := w.cfg.Credentials["router_ssh"]
+	if cred.User != "admin" || cred.Pass != "s3cret pass" || cred.Port != 2222 || cred.Type != "generic" {
+		t.Errorf("credential = %+v", cred)
+	}
+	if h := w.cfg.Hosts["router"]; h.Address != "192.0.2.5" || len(h.Collect) != 2 || h.Collect[1].Metric != "sshcollect" {
+		t.Errorf("host = %+v", h)
+	}
+	if len(w.cfg.Perception) != 0 || w.cfg.Database.URL != plugin.StarterDatabaseURL() {
+		t.Errorf("perception %v, database %q", w.cfg.Perception, w.cfg.Database.URL)
+	}
+}
+
+func TestWizardDefaults(t *testing.T) {
+	// Only the address is required; everything else has a default.
+	w := newWizard()
+	cmd := fill(t, w, "tab", "192.0.2.9", "ctrl+s")
+	if !quits(cmd) {
+		t.Fatalf("not saved: %q", w.err)
+	}
+	if len(w.cfg.Credentials) != 0 || len(w.cfg.Perception) != 0 {
+		t.Errorf("config = %+v", w.cfg)
+	}
+	if h := w.cfg.Hosts["router"]; h.Address != "192.0.2.9" || len(h.Collect) != 1 || h.Collect[0].Metric != "network.ping" {
+		t.Errorf("host = %+v", h)
+	}
+}
+
+func TestWizardInlineErrors(t *testing.T) {
+	w := newWizard()
+	// Saving with no address focuses it and keeps the form open.
+	if cmd := fill(t, w, "ctrl+s"); cmd != nil || w.cfg != nil {
+		t.Fatal("saved without an address")
+	}
+	if w.focus != fieldAddress || w.fields[fieldAddress].err != "required" || w.err != "fix the highlighted fields" {
+		t.Fatalf("focus %d, field error %q, form error %q", w.focus, w.fields[fieldAddress].err, w.err)
+	}
+	// Typing re-checks a field that shows an error.
+	fill(t, w, "192.0.2.1")
+	if w.fields[fieldAddress].err != "" {
+		t.Errorf("error kept after typing: %q", w.fields[fieldAddress].err)
+	}
+
+	// Leaving a field checks it.
+	fill(t, w, "tab", "ctrl+u", "telnet", "tab")
+	if got := w.fields[fieldCredType].err; got != "snmp, ssh or none" {
+		t.Errorf("credential error = %q", got)
+	}
+	fill(t, w, "shift+tab", "ctrl+u", "ssh", "tab", "tab", "tab", "ctrl+u", "70000", "tab")
+	if got := w.fields[fieldSSHPort].err; got != "a port from 1 to 65535" {
+		t.Errorf("port error = %q", got)
+	}
+	fill(t, w, "tab", "192.0.2.0/33")
+	if cmd := fill(t, w, "enter"); cmd != nil {
+		t.Fatal("saved with errors")
+	}
+	// The first invalid field takes the focus.
+	if w.focus != fieldSSHPort || w.fields[fieldRange].err == "" {
+		t.Errorf("focus %d, range error %q", w.focus, w.fields[fieldRange].err)
+	}
+
+	// Fixing them saves.
+	fill(t, w, "ctrl+u", "22", "tab", "tab", "backspace", "backspace", "24")
+	if cmd := fill(t, w, "enter"); !quits(cmd) {
+		t.Fatalf("not saved: %q, range error %q", w.err, w.fields[fieldRange].err)
+	}
+	if got := w.cfg.Perception["local_network"].Ranges; !reflect.DeepEqual(got, []string{"192.0.2.0/24"}) {
+		t.Errorf("range = %v", got)
+	}
+}
+
+func TestWizardCancel(t *testing.T) {
+	for _, key := range []tea.KeyMsg{{Type: tea.KeyEsc}, {Type: tea.KeyCtrlC}} {
+		w := newWizard()
+		fill(t, w, "tab", "192.0.2.1")
+		next, cmd := w.Update(key)
+		if w := next.(*wizardModel); !w.cancelled || w.cfg != nil || !quits(cmd) {
+			t.Errorf("%s: cancelled %v, config %v", key, w.cancelled, w.cfg)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -41,7 +40,7 @@ func (p *wasmPlugin) Init(controller *plugin.Controller) {
 	p.pluginsDir = "plugins/wasm/modules"
 	
 	// Load configuration if available
-	if cfgData, err := os.ReadFile("data/config.json"); err == nil {
+	if cfgData, err := plugin.ReadConfigFile(); err == nil {
 		var cfg struct {
 			Wasm struct {
 				PluginsDir string `json:"plugins_dir"`
@@ -60,13 +59,13 @@ func (p *wasmPlugin) Init(controller *plugin.Controller) {
 // loadPluginsFromDirectory scans the plugins directory and loads all .wasm files
 func (p *wasmPlugin) loadPluginsFromDirectory() {
 	if _, err := os.Stat(p.pluginsDir); os.IsNotExist(err) {
-		log.Printf("WASM plugins directory does not exist: %s", p.pluginsDir)
+		p.Controller.Logf("WASM plugins directory does not exist: %s", p.pluginsDir)
 		return
 	}
 	
 	entries, err := os.ReadDir(p.pluginsDir)
 	if err != nil {
-		log.Printf("Failed to read WASM plugins directory: %v", err)
+		p.Controller.Logf("Failed to read WASM plugins directory: %v", err)
 		return
 	}
 	
@@ -80,20 +79,28 @@ func (p *wasmPlugin) loadPluginsFromDirectory() {
 		
 		wasmBytes, err := os.ReadFile(pluginPath)
 		if err != nil {
-			log.Printf("Failed to read WASM plugin %s: %v", pluginName, err)
+			p.Controller.Logf("Failed to read WASM plugin %s: %v", pluginName, err)
 			continue
 		}
 		
 		if err := p.engine.Load(p.ctx, pluginName, wasmBytes); err != nil {
-			log.Printf("Failed to load WASM plugin %s: %v", pluginName, err)
+			p.Controller.Logf("Failed to load WASM plugin %s: %v", pluginName, err)
 			continue
 		}
 		
 		p.loadedPlugins[pluginName] = true
-		log.Printf("Loaded WASM plugin: %s", pluginName)
+		p.Controller.Logf("Loaded WASM plugin: %s", pluginName)
 	}
 }
 
+// ActionSafety marks "list" read-only; loading and running modules is a write.
+func (p *wasmPlugin) ActionSafety(action string) plugin.Safety {
+	if action == "list" {
+		return plugin.SafetyRead
+	}
+	return plugin.SafetyWrite
+}
+
 func (p *wasmPlugin) OnCommand(args map[string]string) error {
 	action := args["action"]
 	
@@ -145,13 +152,13 @@ func parseArgs(argsStr string) map[string]string {
 }
 
 func (p *wasmPlugin) listPlugins() error {
-	fmt.Println("Loaded WASM Plugins:")
+	p.Controller.Println("Loaded WASM Plugins:")
 	if len(p.loadedPlugins) == 0 {
-		fmt.Println("  (none)")
+		p.Controller.Println("  (none)")
 		return nil
 	}
 	for name := range p.loadedPlugins {
-		fmt.Printf("  - %s\n", name)
+		p.Controller.Printf("  - %s\n", name)
 	}
 	return nil
 }
@@ -169,7 +176,7 @@ func (p *wasmPlugin) loadPlugin(pluginPath string) error {
 	}
 	
 	p.loadedPlugins[pluginName] = true
-	fmt.Printf("Successfully loaded WASM plugin: %s\n", pluginName)
+	p.Controller.Printf("Successfully loaded WASM plugin: %s\n", pluginName)
 	return nil
 }
 
@@ -196,21 +203,26 @@ func (p *wasmPlugin) executePlugin(pluginName, action string, args map[string]st
 		return fmt.Errorf("execution failed: %w", err)
 	}
 	
-	fmt.Printf("Plugin Response:\n")
-	fmt.Printf("  Status: %s\n", resp.Status)
+	p.Controller.Printf("Plugin Response:\n")
+	p.Controller.Printf("  Status: %s\n", resp.Status)
 	if resp.Error != "" {
-		fmt.Printf("  Error: %s\n", resp.Error)
+		p.Controller.Printf("  Error: %s\n", resp.Error)
 	}
 	if len(resp.Data) > 0 {
-		fmt.Printf("  Data:\n")
+		p.Controller.Printf("  Data:\n")
 		for k, v := range resp.Data {
-			fmt.Printf("    %s: %s\n", k, v)
+			p.Controller.Printf("    %s: %s\n", k, v)
 		}
 	}
 	
 	return nil
 }
 
+// Reload rescans the modules directory; the daemon calls it on SIGHUP.
+func (p *wasmPlugin) Reload() error {
+	return p.reloadPlugins()
+}
+
 func (p *wasmPlugin) reloadPlugins() error {
 	// Clear loaded plugins
 	p.loadedPlugins = make(map[string]bool)
@@ -218,7 +230,7 @@ func (p *wasmPlugin) reloadPlugins() error {
 	// Reload from directory
 	p.loadPluginsFromDirectory()
 	
-	fmt.Printf("Reloaded %d WASM plugins\n", len(p.loadedPlugins))
+	p.Controller.Printf("Reloaded %d WASM plugins\n", len(p.loadedPlugins))
 	return nil
 }
 
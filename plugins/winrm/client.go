@@ -0,0 +1,287 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/Azure/go-ntlmssp"
+)
+
+// WS-Management URIs used by the remote shell protocol (MS-WSMV).
+const (
+	uriShell     = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+	actCreate    = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	actDelete    = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	actCommand   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	actReceive   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	actSignal    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+	sigTerminate = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+	stateDone    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+
+	// wsmanTimedOut is the fault a Receive returns when the command produced
+	// no output within the operation timeout; the client simply asks again.
+	wsmanTimedOut = "2150858793"
+)
+
+// errAuth reports credentials the server refused.
+var errAuth = errors.New("authentication failed")
+
+// connError wraps failures to reach the WinRM endpoint at all, so they can be
+// told apart from authentication failures and script errors.
+type connError struct{ err error }
+
+func (e *connError) Error() string { return e.err.Error() }
+func (e *connError) Unwrap() error { return e.err }
+
+// client speaks just enough WS-Management to run a command in a remote
+// shell: Create a shell, run Command, Receive its output until done, Signal
+// terminate and Delete the shell.
+type client struct {
+	url        string
+	user, pass string
+	http       *http.Client
+}
+
+// clientOptions are the transport settings of a task.
+type clientOptions struct {
+	HTTPS    bool
+	Insecure bool
+	Auth     string // "ntlm" (default) or "basic"
+	Timeout  time.Duration
+}
+
+func newClient(host, port, user, pass string, opts clientOptions) *client {
+	scheme := "http"
+	if opts.HTTPS {
+		scheme = "https"
+	}
+	var rt http.RoundTripper = &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.Insecure},
+		// NTLM authenticates the connection, so it must be kept alive.
+		MaxIdleConnsPerHost: 1,
+	}
+	if opts.Auth != "basic" {
+		rt = ntlmssp.Negotiator{RoundTripper: rt}
+	}
+	return &client{
+		url:  fmt.Sprintf("%s://%s:%s/wsman", scheme, host, port),
+		user: user, pass: pass,
+		http: &http.Client{Transport: rt, Timeout: opts.Timeout},
+	}
+}
+
+// runResult is the output of one remote command.
+type runResult struct {
+	stdout, stderr string
+	exitCode       int
+}
+
+// runPowerShell runs script with powershell.exe -EncodedCommand in the shell.
+func (c *client) runPowerShell(ctx context.Context, shellID, script string) (runResult, error) {
+	args := "-NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand " + encodeCommand(script)
+	body := "<rsp:CommandLine><rsp:Command>powershell.exe</rsp:Command><rsp:Arguments>" +
+		html.EscapeString(args) + "</rsp:Arguments></rsp:CommandLine>"
+	options := `<w:OptionSet><w:Option Name="WINRS_CONSOLEMODE_STDIN">TRUE</w:Option><w:Option Name="WINRS_SKIP_CMD_SHELL">FALSE</w:Option></w:OptionSet>`
+	resp, err := c.call(ctx, actCommand, shellID, options, body)
+	if err != nil {
+		return runResult{}, err
+	}
+	commandID := resp.Body.CommandID
+	if commandID == "" {
+		return runResult{}, errors.New("no CommandId in response")
+	}
+	defer c.call(context.Background(), actSignal, shellID, "",
+		`<rsp:Signal CommandId="`+commandID+`"><rsp:Code>`+sigTerminate+`</rsp:Code></rsp:Signal>`)
+
+	var res runResult
+	var stdout, stderr bytes.Buffer
+	receive := `<rsp:Receive><rsp:DesiredStream CommandId="` + commandID + `">stdout stderr</rsp:DesiredStream></rsp:Receive>`
+	for {
+		resp, err := c.call(ctx, actReceive, shellID, "", receive)
+		var f *faultError
+		if errors.As(err, &f) && f.code == wsmanTimedOut {
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		for _, s := range resp.Body.Streams {
+			if s.CommandID != "" && s.CommandID != commandID {
+				continue
+			}
+			chunk, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s.Data))
+			if err != nil {
+				return res, fmt.Errorf("bad %s stream: %w", s.Name, err)
+			}
+			if s.Name == "stderr" {
+				stderr.Write(chunk)
+			} else {
+				stdout.Write(chunk)
+			}
+		}
+		if st := resp.Body.State; st.State == stateDone {
+			res.exitCode = st.ExitCode
+			break
+		}
+	}
+	res.stdout, res.stderr = stdout.String(), stderr.String()
+	return res, nil
+}
+
+func (c *client) createShell(ctx context.Context) (string, error) {
+	options := `<w:OptionSet><w:Option Name="WINRS_NOPROFILE">TRUE</w:Option><w:Option Name="WINRS_CODEPAGE">65001</w:Option></w:OptionSet>`
+	body := `<rsp:Shell><rsp:InputStreams>stdin</rsp:InputStreams><rsp:OutputStreams>stdout stderr</rsp:OutputStreams></rsp:Shell>`
+	resp, err := c.call(ctx, actCreate, "", options, body)
+	if err != nil {
+		return "", err
+	}
+	if id := resp.Body.ShellID; id != "" {
+		return id, nil
+	}
+	for _, s := range resp.Body.Selectors {
+		if s.Name == "ShellId" {
+			return s.Value, nil
+		}
+	}
+	return "", errors.New("no ShellId in response")
+}
+
+func (c *client) deleteShell(shellID string) {
+	c.call(context.Background(), actDelete, shellID, "", "")
+}
+
+// envelope is the part of a response the client reads.
+type envelope struct {
+	Body struct {
+		ShellID   string `xml:"Shell>ShellId"`
+		Selectors []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"ResourceCreated>ReferenceParameters>SelectorSet>Selector"`
+		CommandID string `xml:"CommandResponse>CommandId"`
+		Streams   []struct {
+			Name      string `xml:"Name,attr"`
+			CommandID string `xml:"CommandId,attr"`
+			Data      string `xml:",chardata"`
+		} `xml:"ReceiveResponse>Stream"`
+		State struct {
+			State    string `xml:"State,attr"`
+			ExitCode int    `xml:"ExitCode"`
+		} `xml:"ReceiveResponse>CommandState"`
+		Fault struct {
+			Reason string `xml:"Reason>Text"`
+			Detail struct {
+				Code    string `xml:"Code,attr"`
+				Message string `xml:"Message"`
+			} `xml:"Detail>WSManFault"`
+		} `xml:"Fault"`
+	}
+}
+
+// faultError is a SOAP fault returned by the server.
+type faultError struct{ code, msg string }
+
+func (e *faultError) Error() string {
+	if e.code != "" {
+		return fmt.Sprintf("WS-Management fault %s: %s", e.code, e.msg)
+	}
+	return "WS-Management fault: " + e.msg
+}
+
+// call posts one request and parses the response, turning HTTP and SOAP
+// failures into errors.
+func (c *client) call(ctx context.Context, action, shellID, options, body string) (*envelope, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(c.request(action, shellID, options, body)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	req.SetBasicAuth(c.user, c.pass)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, &connError{err}
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, &connError{err}
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errAuth
+	}
+
+	var env envelope
+	if err := xml.Unmarshal(raw, &env); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP %s", resp.Status)
+		}
+		return nil, fmt.Errorf("malformed response: %w", err)
+	}
+	if f := env.Body.Fault; f.Reason != "" || f.Detail.Code != "" {
+		msg := strings.TrimSpace(f.Detail.Message)
+		if msg == "" {
+			msg = strings.TrimSpace(f.Reason)
+		}
+		return nil, &faultError{code: f.Detail.Code, msg: msg}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return &env, nil
+}
+
+// request builds the SOAP envelope for action, addressed to the shell when
+// shellID is set.
+func (c *client) request(action, shellID, options, body string) string {
+	var b strings.Builder
+	b.WriteString(`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" ` +
+		`xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" ` +
+		`xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" ` +
+		`xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><env:Header>`)
+	fmt.Fprintf(&b, `<a:To>%s</a:To>`, html.EscapeString(c.url))
+	b.WriteString(`<a:ReplyTo><a:Address env:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>`)
+	fmt.Fprintf(&b, `<w:ResourceURI env:mustUnderstand="true">%s</w:ResourceURI>`, uriShell)
+	fmt.Fprintf(&b, `<a:Action env:mustUnderstand="true">%s</a:Action>`, action)
+	b.WriteString(`<w:MaxEnvelopeSize env:mustUnderstand="true">153600</w:MaxEnvelopeSize>`)
+	fmt.Fprintf(&b, `<a:MessageID>uuid:%s</a:MessageID>`, newUUID())
+	b.WriteString(`<w:Locale xml:lang="en-US" env:mustUnderstand="false"/><w:OperationTimeout>PT20S</w:OperationTimeout>`)
+	if shellID != "" {
+		fmt.Fprintf(&b, `<w:SelectorSet><w:Selector Name="ShellId">%s</w:Selector></w:SelectorSet>`, html.EscapeString(shellID))
+	}
+	b.WriteString(options)
+	b.WriteString(`</env:Header><env:Body>`)
+	b.WriteString(body)
+	b.WriteString(`</env:Body></env:Envelope>`)
+	return b.String()
+}
+
+// encodeCommand encodes script for -EncodedCommand: base64 of UTF-16LE.
+func encodeCommand(script string) string {
+	units := utf16.Encode([]rune(script))
+	buf := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(buf[2*i:], u)
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func newUUID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
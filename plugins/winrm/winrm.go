@@ -0,0 +1,306 @@
+// Package winrm collects from Windows servers over WinRM: collect tasks
+// "winrm.collect" run the PowerShell scripts of a device definition
+// (plugins/winrm/devices/<definition>.json) and read their JSON output as
+// metrics.
+package winrm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "observer/base"
+	"observer/plugins"
+)
+
+const defaultTimeout = 60 * time.Second
+
+// winrmPlugin runs PowerShell over WinRM.
+type winrmPlugin struct {
+	plugin.BasePlugin
+}
+
+func init() {
+	plugins.Register(&winrmPlugin{})
+}
+
+// Name returns the plugin's name.
+func (p *winrmPlugin) Name() string {
+	return "Winrm"
+}
+
+// Definition is a WinRM device definition: named scripts whose output is
+// JSON, one object or an array of them.
+type Definition struct {
+	Scripts map[string]ScriptDef `json:"scripts"`
+}
+
+// ScriptDef is one PowerShell snippet and how to read its output. Instance
+// names the field that tells rows apart (a drive, a service); Metrics maps
+// the fields to record, and fields not listed are ignored.
+type ScriptDef struct {
+	Script   string               `json:"script"`
+	Category string               `json:"category"`
+	Instance string               `json:"instance"`
+	Metrics  map[string]MetricDef `json:"metrics"`
+}
+
+// MetricDef describes one output field. Map translates values, e.g. a
+// service state "Running" to the status "up".
+type MetricDef struct {
+	Label string            `json:"label"`
+	Type  string            `json:"type"`
+	Map   map[string]string `json:"map"`
+}
+
+// collectOptions are the per-task options of a winrm.collect task:
+//
+//	{"metric": "winrm.collect", "credentials": "win", "options": {
+//	    "definition": "windows", "scripts": ["cpu", "disk"],
+//	    "https": true, "insecure": false, "auth": "ntlm", "timeout_s": 60}}
+//
+// The credential (type "winrm") supplies user, pass and port; port 5986
+// implies https. scripts selects from the definition, all by default.
+type collectOptions struct {
+	Definition string   `json:"definition"`
+	Scripts    []string `json:"scripts"`
+	HTTPS      bool     `json:"https"`
+	Insecure   bool     `json:"insecure"`
+	Auth       string   `json:"auth"`
+	TimeoutS   float64  `json:"timeout_s"`
+}
+
+// OnCollect opens one remote shell and runs the selected scripts in it. A
+// host that cannot be reached or refuses the credentials is reported by the
+// winrm_status metric; a failing script by its own script_status.
+func (p *winrmPlugin) OnCollect(options map[string]interface{}) (map[string]interface{}, error) {
+	if action, _ := options["action"].(string); action != "collect" {
+		return nil, fmt.Errorf("undefined winrm action: %s", action)
+	}
+	var opts collectOptions
+	if raw, ok := options["options"]; ok {
+		b, _ := json.Marshal(raw)
+		if err := json.Unmarshal(b, &opts); err != nil {
+			return nil, fmt.Errorf("winrm: invalid options: %w", err)
+		}
+	}
+	if opts.Definition == "" {
+		opts.Definition = "windows"
+	}
+	creds, ok := options["credentials"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("winrm: the task needs credentials of type winrm")
+	}
+	user, _ := creds["user"].(string)
+	pass, _ := creds["pass"].(string)
+	address, _ := creds["host"].(string)
+	port, _ := creds["port"].(string)
+	if address == "" {
+		host, _ := options["host"].(map[string]interface{})
+		address, _ = host["address"].(string)
+	}
+	if port == "" || port == "0" {
+		port = "5985"
+		if opts.HTTPS {
+			port = "5986"
+		}
+	}
+	if port == "5986" {
+		opts.HTTPS = true
+	}
+	timeout := defaultTimeout
+	if opts.TimeoutS > 0 {
+		timeout = time.Duration(opts.TimeoutS * float64(time.Second))
+	}
+
+	def, err := loadDefinition(opts.Definition)
+	if err != nil {
+		return nil, err
+	}
+	names, err := selectScripts(def, opts.Scripts)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newClient(address, port, user, pass, clientOptions{
+		HTTPS: opts.HTTPS, Insecure: opts.Insecure, Auth: opts.Auth, Timeout: timeout,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	metrics := make(map[string]interface{})
+	status := metric("winrm_status", "WinRM", "", "status", "up", "WinRM")
+	status["endpoint"] = c.url
+	metrics["winrm_status"] = status
+
+	shellID, err := c.createShell(ctx)
+	if err != nil {
+		status["value"] = "down"
+		status["error_kind"] = errorKind(err)
+		status["reason"] = err.Error()
+		return map[string]interface{}{"metrics": metrics}, nil
+	}
+	defer c.deleteShell(shellID)
+
+	for _, name := range names {
+		sd := def.Scripts[name]
+		res, err := c.runPowerShell(ctx, shellID, sd.Script)
+		if err != nil && errorKind(err) != "script" {
+			// The connection went away mid-run; the remaining scripts would fail the same way.
+			status["value"] = "down"
+			status["error_kind"] = errorKind(err)
+			status["reason"] = err.Error()
+			break
+		}
+		for k, v := range scriptMetrics(name, sd, res, err) {
+			metrics[k] = v
+		}
+	}
+	return map[string]interface{}{"metrics": metrics}, nil
+}
+
+// errorKind classifies a failure as "connection", "auth" or "script".
+func errorKind(err error) string {
+	var ce *connError
+	switch {
+	case errors.As(err, &ce):
+		return "connection"
+	case errors.Is(err, errAuth):
+		return "auth"
+	}
+	return "script"
+}
+
+// loadDefinition reads the named device definition.
+func loadDefinition(name string) (*Definition, error) {
+	data, err := os.ReadFile(plugin.DeviceFile("winrm", name))
+	if err != nil {
+		return nil, fmt.Errorf("could not read device definition for '%s': %w", name, err)
+	}
+	var def Definition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("could not parse device definition for '%s': %w", name, err)
+	}
+	return &def, nil
+}
+
+// selectScripts returns the requested script names, or all of them, sorted.
+func selectScripts(def *Definition, want []string) ([]string, error) {
+	if len(want) == 0 {
+		for name := range def.Scripts {
+			want = append(want, name)
+		}
+	}
+	for _, name := range want {
+		if _, ok := def.Scripts[name]; !ok {
+			return nil, fmt.Errorf("winrm: no script %q in the device definition", name)
+		}
+	}
+	sort.Strings(want)
+	return want, nil
+}
+
+// scriptMetrics maps one script's run to its script_status and the metrics
+// read from its JSON output. Rows are told apart by the Instance field.
+func scriptMetrics(name string, sd ScriptDef, res runResult, runErr error) map[string]interface{} {
+	status := metric("script_status", "Script", name, "status", "up", sd.Category)
+	metrics := map[string]interface{}{"winrm_script_" + name: status}
+	fail := func(reason string) map[string]interface{} {
+		status["value"] = "down"
+		status["error_kind"] = "script"
+		status["reason"] = reason
+		if stderr := strings.TrimSpace(res.stderr); stderr != "" {
+			status["stderr"] = truncate(stderr, 1024)
+		}
+		return metrics
+	}
+	switch {
+	case runErr != nil:
+		return fail(runErr.Error())
+	case res.exitCode != 0:
+		return fail(fmt.Sprintf("exit code %d", res.exitCode))
+	}
+
+	rows, err := parseRows(res.stdout)
+	if err != nil {
+		return fail(err.Error())
+	}
+	for _, row := range rows {
+		instance := ""
+		if sd.Instance != "" {
+			instance = fmt.Sprint(row[sd.Instance])
+		}
+		for field, md := range sd.Metrics {
+			v, ok := row[field]
+			if !ok || v == nil {
+				continue
+			}
+			value := fmt.Sprint(v)
+			if f, ok := v.(float64); ok {
+				value = strconv.FormatFloat(f, 'f', -1, 64)
+			}
+			if mapped, ok := md.Map[value]; ok {
+				value = mapped
+			}
+			label := md.Label
+			if label == "" {
+				label = field
+			}
+			mtype := md.Type
+			if mtype == "" {
+				mtype = "text"
+			}
+			key := "winrm_" + name + "_" + field
+			if instance != "" {
+				key += "_" + instance
+			}
+			metrics[key] = metric(field, label, instance, mtype, value, sd.Category)
+		}
+	}
+	return metrics
+}
+
+// parseRows reads ConvertTo-Json output: one object, an array of objects, or
+// nothing when a query matched no rows.
+func parseRows(out string) ([]map[string]interface{}, error) {
+	out = strings.TrimSpace(strings.TrimPrefix(out, "\ufeff"))
+	if out == "" {
+		return nil, nil
+	}
+	var rows []map[string]interface{}
+	if strings.HasPrefix(out, "[") {
+		if err := json.Unmarshal([]byte(out), &rows); err != nil {
+			return nil, fmt.Errorf("output is not JSON: %v", err)
+		}
+		return rows, nil
+	}
+	var row map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &row); err != nil {
+		return nil, fmt.Errorf("output is not JSON: %v", err)
+	}
+	return append(rows, row), nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func metric(name, label, instance, metricType, value, category string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"label":    label,
+		"value":    value,
+		"type":     metricType,
+		"category": category,
+		"instance": instance,
+	}
+}
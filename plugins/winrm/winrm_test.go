@@ -0,0 +1,390 @@
+package winrm
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf16"
+
+	plugin "observer/base"
+)
+
+// fixture is the canned output of one script.
+type fixture struct {
+	stdout, stderr string
+	exitCode       int
+	fault          string // a WS-Management fault code returned for the Command instead
+	hangUp         bool   // drop the connection instead of answering the Command
+}
+
+// fakeWinRM answers the WS-Management remote shell calls with fixtures keyed
+// by the PowerShell script a Command runs.
+type fakeWinRM struct {
+	user, pass string
+	scripts    map[string]fixture
+
+	mu       sync.Mutex
+	actions  []string
+	commands map[string]fixture // command id -> its fixture
+	timedOut bool               // the first Receive has returned the operation timeout fault
+	ntlm     []string           // NTLM negotiation headers received
+}
+
+var (
+	actionRe = regexp.MustCompile(`<a:Action[^>]*>([^<]+)</a:Action>`)
+	argsRe   = regexp.MustCompile(`-EncodedCommand ([A-Za-z0-9+/=]+)`)
+	cmdIDRe  = regexp.MustCompile(`CommandId="([^"]+)"`)
+)
+
+func (s *fakeWinRM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Negotiate ") {
+		s.mu.Lock()
+		s.ntlm = append(s.ntlm, auth)
+		s.mu.Unlock()
+	}
+	if user, pass, ok := r.BasicAuth(); !ok || user != s.user || pass != s.pass {
+		// Offer NTLM as a Windows host does; the fake never completes it.
+		w.Header().Add("WWW-Authenticate", "Negotiate")
+		w.Header().Add("WWW-Authenticate", "Basic realm=\"WSMAN\"")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	raw, _ := io.ReadAll(r.Body)
+	body := string(raw)
+	action := actionRe.FindStringSubmatch(body)[1]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions = append(s.actions, action[strings.LastIndex(action, "/")+1:])
+
+	switch action {
+	case actCreate:
+		reply(w, `<rsp:Shell><rsp:ShellId>11111111-2222-3333-4444-555555555555</rsp:ShellId></rsp:Shell>`)
+	case actCommand:
+		script := decodeCommand(argsRe.FindStringSubmatch(html.UnescapeString(body))[1])
+		f, ok := s.scripts[script]
+		switch {
+		case !ok:
+			fault(w, "2150858770", "unknown script "+script)
+		case f.hangUp:
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case f.fault != "":
+			fault(w, f.fault, "The WS-Management service cannot process the request.")
+		default:
+			id := fmt.Sprintf("CMD-%d", len(s.commands)+1)
+			s.commands[id] = f
+			reply(w, `<rsp:CommandResponse><rsp:CommandId>`+id+`</rsp:CommandId></rsp:CommandResponse>`)
+		}
+	case actReceive:
+		if !s.timedOut {
+			s.timedOut = true
+			fault(w, wsmanTimedOut, "The WS-Management service cannot complete the operation within the time specified in OperationTimeout.")
+			return
+		}
+		id := cmdIDRe.FindStringSubmatch(body)[1]
+		f := s.commands[id]
+		var streams strings.Builder
+		// Output arrives in chunks; split stdout in two to check they are joined.
+		half := len(f.stdout) / 2
+		for _, chunk := range []struct{ name, data string }{{"stdout", f.stdout[:half]}, {"stdout", f.stdout[half:]}, {"stderr", f.stderr}} {
+			fmt.Fprintf(&streams, `<rsp:Stream Name="%s" CommandId="%s">%s</rsp:Stream>`, chunk.name, id, base64.StdEncoding.EncodeToString([]byte(chunk.data)))
+		}
+		reply(w, `<rsp:ReceiveResponse>`+streams.String()+
+			`<rsp:CommandState CommandId="`+id+`" State="`+stateDone+`"><rsp:ExitCode>`+fmt.Sprint(f.exitCode)+`</rsp:ExitCode></rsp:CommandState></rsp:ReceiveResponse>`)
+	default: // Signal, Delete
+		reply(w, "")
+	}
+}
+
+// calls returns the number of times each action was called.
+func (s *fakeWinRM) calls() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := map[string]int{}
+	for _, a := range s.actions {
+		n[a]++
+	}
+	return n
+}
+
+func reply(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	io.WriteString(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Body>`+body+`</s:Body></s:Envelope>`)
+}
+
+func fault(w http.ResponseWriter, code, msg string) {
+	w.WriteHeader(http.StatusInternalServerError)
+	io.WriteString(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault"><s:Body><s:Fault>`+
+		`<s:Reason><s:Text xml:lang="en-US">`+msg+`</s:Text></s:Reason>`+
+		`<s:Detail><f:WSManFault Code="`+code+`"><f:Message>`+msg+`</f:Message></f:WSManFault></s:Detail></s:Fault></s:Body></s:Envelope>`)
+}
+
+// decodeCommand reverses encodeCommand.
+func decodeCommand(s string) string {
+	b, _ := base64.StdEncoding.DecodeString(s)
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// testDefinition is written as the "lab" device definition.
+const testDefinition = `{"scripts": {
+	"cpu": {"category": "CPU", "script": "Get-Cpu", "metrics": {
+		"cpu_percent": {"label": "CPU Usage (%)", "type": "gauge"},
+		"cores": {"type": "gauge"}}},
+	"disk": {"category": "Disk", "instance": "drive", "script": "Get-Disk", "metrics": {
+		"used_percent": {"label": "Used (%)", "type": "gauge"}}},
+	"services": {"category": "Services", "instance": "name", "script": "Get-Services", "metrics": {
+		"state": {"label": "Service", "type": "status", "map": {"Running": "up", "Stopped": "down"}}}},
+	"broken": {"category": "System", "script": "Get-Broken", "metrics": {}},
+	"garbled": {"category": "System", "script": "Get-Garbled", "metrics": {}},
+	"faulty": {"category": "System", "script": "Get-Faulty", "metrics": {}},
+	"idle": {"category": "System", "instance": "name", "script": "Get-Nothing", "metrics": {"state": {}}},
+	"zz_hangup": {"category": "System", "script": "Hang-Up", "metrics": {}}
+}}`
+
+var testFixtures = map[string]fixture{
+	"Get-Cpu":      {stdout: "\ufeff" + `{"cpu_percent": 12.5, "cores": 8, "ignored": "x"}`},
+	"Get-Disk":     {stdout: `[{"drive": "C:", "used_percent": 71.2}, {"drive": "D:", "used_percent": 3}]`},
+	"Get-Services": {stdout: `[{"name": "Spooler", "state": "Running"}, {"name": "wuauserv", "state": "Stopped"}, {"name": "W32Time", "state": "Paused"}]`},
+	"Get-Broken":   {stderr: "Get-CimInstance : Access denied\r\n", exitCode: 1},
+	"Get-Garbled":  {stdout: "WARNING: something\r\n"},
+	"Get-Faulty":   {fault: "2150858843"},
+	"Get-Nothing":  {},
+	"Hang-Up":      {hangUp: true},
+}
+
+// useDefinitions points the devices directory at a temp directory holding
+// the "lab" definition.
+func useDefinitions(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "winrm"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "winrm", "lab.json"), []byte(testDefinition), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(plugin.LoadPaths)
+	t.Setenv(plugin.EnvDevicesDir, dir)
+	plugin.LoadPaths()
+}
+
+// collect runs one winrm.collect task against the server at url.
+func collect(t *testing.T, url, pass string, opts map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(strings.TrimPrefix(url, "http://"), "https://"))
+	opts["definition"] = "lab"
+	if _, ok := opts["auth"]; !ok {
+		opts["auth"] = "basic"
+	}
+	result, err := (&winrmPlugin{}).OnCollect(map[string]interface{}{
+		"action":      "collect",
+		"host":        map[string]interface{}{"address": host, "name": "win1"},
+		"credentials": map[string]interface{}{"user": "monitor", "pass": pass, "port": port},
+		"options":     opts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result["metrics"].(map[string]interface{})
+}
+
+func get(t *testing.T, metrics map[string]interface{}, key string) map[string]interface{} {
+	t.Helper()
+	m, ok := metrics[key].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no %s in %v", key, metrics)
+	}
+	return m
+}
+
+func TestCollect(t *testing.T) {
+	useDefinitions(t)
+	srv := &fakeWinRM{user: "monitor", pass: "s3cret", scripts: testFixtures, commands: map[string]fixture{}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	metrics := collect(t, ts.URL, "s3cret", map[string]interface{}{
+		"scripts": []string{"services", "cpu", "disk", "broken", "garbled", "faulty", "idle"},
+	})
+
+	if s := get(t, metrics, "winrm_status"); s["value"] != "up" || s["endpoint"] != ts.URL+"/wsman" {
+		t.Errorf("winrm_status = %v", s)
+	}
+	if m := get(t, metrics, "winrm_cpu_cpu_percent"); m["value"] != "12.5" || m["type"] != "gauge" || m["category"] != "CPU" || m["instance"] != "" {
+		t.Errorf("cpu_percent = %v", m)
+	}
+	if m := get(t, metrics, "winrm_cpu_cores"); m["value"] != "8" || m["label"] != "cores" {
+		t.Errorf("cores = %v", m)
+	}
+	if _, ok := metrics["winrm_cpu_ignored"]; ok {
+		t.Error("a field not in the definition was recorded")
+	}
+	for key, want := range map[string]string{
+		"winrm_disk_used_percent_C:":    "71.2",
+		"winrm_disk_used_percent_D:":    "3",
+		"winrm_services_state_Spooler":  "up",
+		"winrm_services_state_wuauserv": "down",
+		"winrm_services_state_W32Time":  "Paused",
+	} {
+		if m := get(t, metrics, key); m["value"] != want || m["instance"] == "" {
+			t.Errorf("%s = %v, want %s", key, m, want)
+		}
+	}
+
+	for script, want := range map[string]string{
+		"cpu":     "",
+		"idle":    "",
+		"broken":  "exit code 1",
+		"garbled": "output is not JSON: ",
+		"faulty":  "WS-Management fault 2150858843: ",
+	} {
+		s := get(t, metrics, "winrm_script_"+script)
+		if want == "" {
+			if s["value"] != "up" {
+				t.Errorf("%s = %v", script, s)
+			}
+			continue
+		}
+		if s["value"] != "down" || s["error_kind"] != "script" || !strings.HasPrefix(s["reason"].(string), want) {
+			t.Errorf("%s = %v, want reason %q", script, s, want)
+		}
+	}
+	if s := get(t, metrics, "winrm_script_broken"); s["stderr"] != "Get-CimInstance : Access denied" {
+		t.Errorf("stderr = %q", s["stderr"])
+	}
+
+	// One shell for the run; every command that started is terminated.
+	calls := srv.calls()
+	if calls["Create"] != 1 || calls["Delete"] != 1 || calls["Command"] != 7 || calls["Signal"] != 6 || calls["Receive"] != 7 {
+		t.Errorf("calls = %v", calls)
+	}
+}
+
+func TestCollectAllScriptsStopsWhenTheConnectionDrops(t *testing.T) {
+	useDefinitions(t)
+	srv := &fakeWinRM{user: "monitor", pass: "s3cret", scripts: testFixtures, commands: map[string]fixture{}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// Scripts run in name order, so the connection drops at the last one.
+	metrics := collect(t, ts.URL, "s3cret", map[string]interface{}{})
+	s := get(t, metrics, "winrm_status")
+	if s["value"] != "down" || s["error_kind"] != "connection" {
+		t.Errorf("winrm_status = %v", s)
+	}
+	if _, ok := metrics["winrm_script_zz_hangup"]; ok {
+		t.Error("a lost connection was reported as a script failure")
+	}
+	if get(t, metrics, "winrm_script_services")["value"] != "up" {
+		t.Error("scripts before the drop were lost")
+	}
+}
+
+func TestAuthAndConnectionFailures(t *testing.T) {
+	useDefinitions(t)
+	srv := &fakeWinRM{user: "monitor", pass: "s3cret", scripts: testFixtures, commands: map[string]fixture{}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	metrics := collect(t, ts.URL, "wrong", map[string]interface{}{})
+	if s := get(t, metrics, "winrm_status"); s["value"] != "down" || s["error_kind"] != "auth" || s["reason"] != "authentication failed" || len(metrics) != 1 {
+		t.Errorf("wrong password: %v", metrics)
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	metrics = collect(t, closed.URL, "s3cret", map[string]interface{}{"timeout_s": 2})
+	if s := get(t, metrics, "winrm_status"); s["value"] != "down" || s["error_kind"] != "connection" || len(metrics) != 1 {
+		t.Errorf("closed port: %v", metrics)
+	}
+
+	// Without "basic", the client negotiates NTLM and does not fall back to
+	// sending the password in the clear.
+	metrics = collect(t, ts.URL, "s3cret", map[string]interface{}{"auth": "ntlm"})
+	if s := get(t, metrics, "winrm_status"); s["value"] != "down" || s["error_kind"] != "auth" {
+		t.Errorf("ntlm: %v", s)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.ntlm) == 0 {
+		t.Error("no NTLM negotiation")
+	}
+}
+
+func TestCollectOverHTTPS(t *testing.T) {
+	useDefinitions(t)
+	srv := &fakeWinRM{user: "monitor", pass: "s3cret", scripts: testFixtures, commands: map[string]fixture{}}
+	ts := httptest.NewUnstartedServer(srv)
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0) // the refused handshake
+	ts.StartTLS()
+	defer ts.Close()
+
+	metrics := collect(t, ts.URL, "s3cret", map[string]interface{}{"https": true, "scripts": []string{"cpu"}})
+	if s := get(t, metrics, "winrm_status"); s["value"] != "down" || s["error_kind"] != "connection" || !strings.HasPrefix(s["endpoint"].(string), "https://") {
+		t.Errorf("unverified certificate: %v", s)
+	}
+	metrics = collect(t, ts.URL, "s3cret", map[string]interface{}{"https": true, "insecure": true, "scripts": []string{"cpu"}})
+	if get(t, metrics, "winrm_script_cpu")["value"] != "up" {
+		t.Errorf("insecure: %v", metrics)
+	}
+}
+
+func TestInvalidTasks(t *testing.T) {
+	useDefinitions(t)
+	creds := map[string]interface{}{"user": "u", "pass": "p", "host": "127.0.0.1"}
+	for name, options := range map[string]map[string]interface{}{
+		"unknown action":     {"action": "run", "credentials": creds},
+		"no credentials":     {"action": "collect"},
+		"unknown definition": {"action": "collect", "credentials": creds, "options": map[string]interface{}{"definition": "nope"}},
+		"unknown script":     {"action": "collect", "credentials": creds, "options": map[string]interface{}{"definition": "lab", "scripts": []string{"gpu"}}},
+		"bad options":        {"action": "collect", "credentials": creds, "options": map[string]interface{}{"scripts": "cpu"}},
+	} {
+		if _, err := (&winrmPlugin{}).OnCollect(options); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestEncodeCommand(t *testing.T) {
+	script := `Get-Service | ? {$_.Name -eq "Spooler"} # é`
+	if got := decodeCommand(encodeCommand(script)); got != script {
+		t.Errorf("round trip = %q", got)
+	}
+	if encodeCommand("ab") != "YQBiAA==" {
+		t.Errorf("encodeCommand(ab) = %s", encodeCommand("ab"))
+	}
+}
+
+func TestShippedDefinition(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("devices", "windows.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var def Definition
+	if err := json.Unmarshal(data, &def); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cpu", "memory", "disk", "services", "pending_reboot"} {
+		sd, ok := def.Scripts[name]
+		if !ok || sd.Script == "" || len(sd.Metrics) == 0 || !strings.Contains(sd.Script, "ConvertTo-Json") {
+			t.Errorf("script %s = %+v", name, sd)
+		}
+	}
+}
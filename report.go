@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	plugin "observer/base"
+)
+
+// commandReport is the single JSON document written by a one-shot command run
+// with -o json. Sections a command does not produce are omitted.
+type commandReport struct {
+	Command      string                  `json:"command"`
+	Status       string                  `json:"status"` // ok, warning or failed
+	Error        string                  `json:"error,omitempty"`
+	DurationMs   int64                   `json:"duration_ms"`
+	Hosts        map[string]plugin.Host  `json:"hosts,omitempty"`        // perceive
+	Tasks        []plugin.TaskResult     `json:"tasks,omitempty"`        // collect
+	Destinations []plugin.DeliveryResult `json:"destinations,omitempty"` // send
+	Stages       []stageReport           `json:"stages,omitempty"`       // run
+	Warnings     []string                `json:"warnings,omitempty"`
+	Self         map[string]int64        `json:"self,omitempty"` // nord's own metrics
+
+	mu sync.Mutex
+}
+
+// stageReport is one pipeline stage in a run report.
+type stageReport struct {
+	Name       string   `json:"name"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	DurationMs int64    `json:"duration_ms"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// Report statuses.
+const (
+	reportOK      = "ok"
+	reportWarning = "warning" // completed, but a task, destination or stage had a soft failure
+	reportFailed  = "failed"
+)
+
+// withReport runs fn. With -o json it points the controller's output, which
+// plugins print their progress to, at stderr, gathers what the plugins publish
+// on the event bus, and writes one JSON report to stdout; a failure is carried
+// in the report, so only the exit code reflects it. The output is not pointed
+// back: a task abandoned by fn may still be printing once the report is out.
+func withReport(env *cliEnv, command string, fn func(r *commandReport) error) error {
+	if env.output != "json" {
+		return fn(nil)
+	}
+
+	r := &commandReport{Command: command}
+	c := env.controller
+	c.Subscribe(plugin.EventHostsDiscovered, func(e plugin.Event) {
+		if hosts, ok := e.Data.(map[string]plugin.Host); ok {
+			r.mu.Lock()
+			r.Hosts = hosts
+			r.mu.Unlock()
+		}
+	})
+	c.Subscribe(plugin.EventTaskResult, func(e plugin.Event) {
+		if tr, ok := e.Data.(plugin.TaskResult); ok {
+			r.mu.Lock()
+			r.Tasks = append(r.Tasks, tr)
+			r.mu.Unlock()
+		}
+	})
+	c.Subscribe(plugin.EventDelivery, func(e plugin.Event) {
+		if dr, ok := e.Data.(plugin.DeliveryResult); ok {
+			r.mu.Lock()
+			r.Destinations = append(r.Destinations, dr)
+			r.mu.Unlock()
+		}
+	})
+	c.Subscribe(plugin.EventWarning, func(e plugin.Event) {
+		r.mu.Lock()
+		r.Warnings = append(r.Warnings, fmt.Sprint(e.Data))
+		r.mu.Unlock()
+	})
+
+	c.Stdout = env.stderr
+	start := time.Now()
+	err := fn(r)
+
+	r.mu.Lock()
+	r.DurationMs = time.Since(start).Milliseconds()
+	r.Status = r.status(err)
+	r.Self = make(map[string]int64)
+	for _, m := range c.Metrics.Snapshot() {
+		r.Self[m.Name] = m.Value
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	data, merr := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if merr != nil {
+		return fmt.Errorf("failed to marshal report: %w", merr)
+	}
+	fmt.Fprintln(env.stdout, string(data))
+
+	if err != nil {
+		return &plugin.ExitError{Code: exitFailure}
+	}
+	return nil
+}
+
+// status summarizes the report; callers hold r.mu.
+func (r *commandReport) status(err error) string {
+	if err != nil {
+		return reportFailed
+	}
+	if len(r.Warnings) > 0 {
+		return reportWarning
+	}
+	for _, t := range r.Tasks {
+		if t.Status == plugin.ResultError {
+			return reportWarning
+		}
+	}
+	for _, d := range r.Destinations {
+		if d.Status == plugin.ResultError {
+			return reportWarning
+		}
+	}
+	for _, s := range r.Stages {
+		if s.Status == stageWarning {
+			return reportWarning
+		}
+	}
+	return reportOK
+}
+
+// setStages records pipeline results in the report.
+func (r *commandReport) setStages(results []stageResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, res := range results {
+		s := stageReport{Name: res.stage.name, Status: res.status, DurationMs: res.elapsed.Milliseconds(), Warnings: res.warnings}
+		if res.err != nil {
+			s.Error = res.err.Error()
+		}
+		r.Stages = append(r.Stages, s)
+	}
+}
+
+// wantsJSON reports whether -o json was given, globally or after the subcommand.
+func wantsJSON(global string, args []string) bool {
+	if global == "json" {
+		return true
+	}
+	for i, a := range args {
+		switch a {
+		case "-o", "--o":
+			if i+1 < len(args) && args[i+1] == "json" {
+				return true
+			}
+		case "-o=json", "--o=json":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+// publishingPlugin stands in for collection, network or api: it prints its
+// usual tree to the controller's output and publishes events for the report.
+type publishingPlugin struct {
+	plugin.BasePlugin
+	name   string
+	events []plugin.Event
+	err    error
+}
+
+func (p *publishingPlugin) Name() string { return p.name }
+
+func (p *publishingPlugin) OnCommand(args map[string]string) error {
+	p.Controller.Printf("--- Running %s ---\n  |_ done\n", p.name)
+	for _, e := range p.events {
+		p.Controller.Publish(e)
+	}
+	return p.err
+}
+
+// jsonEnv returns an -o json environment whose controller holds only p.
+func jsonEnv(t *testing.T, p *publishingPlugin) (*cliEnv, *strings.Builder) {
+	t.Helper()
+	testenv.UseTempDirs(t)
+	env, _, _ := testEnv()
+	env.controller.AddPlugin(p)
+	env.output = "json"
+	var stdout strings.Builder
+	env.stdout = &stdout
+	return env, &stdout
+}
+
+// decodeReport parses stdout, which must hold exactly one JSON document.
+func decodeReport(t *testing.T, stdout string) map[string]interface{} {
+	t.Helper()
+	var report map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(stdout))
+	if err := dec.Decode(&report); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, stdout)
+	}
+	if dec.More() {
+		t.Fatalf("stdout holds more than one document:\n%s", stdout)
+	}
+	return report
+}
+
+func taskEvent(host, task, status, msg string, metrics int) plugin.Event {
+	return plugin.Event{Topic: plugin.EventTaskResult, Source: "collection", Data: plugin.TaskResult{
+		Host: host, Task: task, Status: status, Error: msg, Metrics: metrics,
+	}}
+}
+
+func TestCollectJSONReport(t *testing.T) {
+	collection := &publishingPlugin{name: "collection", events: []plugin.Event{
+		taskEvent("web1", "ping", plugin.ResultOK, "", 1),
+		taskEvent("web1", "snmp", plugin.ResultError, "timeout", 0),
+		taskEvent("db1", "ping", plugin.ResultOK, "", 1),
+	}}
+	env, stdout := jsonEnv(t, collection)
+
+	cmd, _ := findCommand("collect")
+	if err := cmd.run(env, nil); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	report := decodeReport(t, stdout.String())
+	if report["command"] != "collect" || report["status"] != reportWarning || report["error"] != nil {
+		t.Errorf("report = %v", report)
+	}
+	tasks, _ := report["tasks"].([]interface{})
+	if len(tasks) != 3 {
+		t.Fatalf("tasks = %v", report["tasks"])
+	}
+	failed := tasks[1].(map[string]interface{})
+	if failed["host"] != "web1" || failed["task"] != "snmp" || failed["status"] != plugin.ResultError || failed["error"] != "timeout" {
+		t.Errorf("failed task = %v", failed)
+	}
+	if tasks[0].(map[string]interface{})["metrics"] != 1.0 {
+		t.Errorf("first task = %v", tasks[0])
+	}
+	for _, key := range []string{"hosts", "destinations", "stages"} {
+		if _, ok := report[key]; ok {
+			t.Errorf("collect report has %s: %v", key, report[key])
+		}
+	}
+	if self, _ := report["self"].(map[string]interface{}); self["tasks_run"] != 3.0 || self["task_errors"] != 1.0 {
+		t.Errorf("self = %v", report["self"])
+	}
+}
+
+func TestCollectJSONReportAllOK(t *testing.T) {
+	collection := &publishingPlugin{name: "collection", events: []plugin.Event{taskEvent("web1", "ping", plugin.ResultOK, "", 1)}}
+	env, stdout := jsonEnv(t, collection)
+	if err := pluginCommand("collect", "collection", "collect", "Error during collection")(env, nil); err != nil {
+		t.Fatal(err)
+	}
+	if report := decodeReport(t, stdout.String()); report["status"] != reportOK {
+		t.Errorf("status = %v", report["status"])
+	}
+}
+
+func TestCollectJSONReportFailure(t *testing.T) {
+	collection := &publishingPlugin{name: "collection", err: errors.New("no hosts configured")}
+	env, stdout := jsonEnv(t, collection)
+
+	err := pluginCommand("collect", "collection", "collect", "Error during collection")(env, nil)
+	var exit *plugin.ExitError
+	if !errors.As(err, &exit) || exit.Code != exitFailure {
+		t.Errorf("err = %v, want exit %d", err, exitFailure)
+	}
+	report := decodeReport(t, stdout.String())
+	if report["status"] != reportFailed || report["error"] != "Error during collection: no hosts configured" {
+		t.Errorf("report = %v", report)
+	}
+}
+
+func TestJSONReportPluginOutput(t *testing.T) {
+	collection := &publishingPlugin{name: "collection"}
+	env, stdout := jsonEnv(t, collection)
+	var stderr strings.Builder
+	env.stderr = &stderr
+	if err := pluginCommand("collect", "collection", "collect", "Error during collection")(env, nil); err != nil {
+		t.Fatal(err)
+	}
+	decodeReport(t, stdout.String())
+	if stderr.String() != "--- Running collection ---\n  |_ done\n" {
+		t.Errorf("stderr %q", stderr.String())
+	}
+}
+
+func TestPerceiveAndSendJSONReports(t *testing.T) {
+	hosts := map[string]plugin.Host{"10.0.0.5": {Address: "10.0.0.5", Name: "printer"}}
+	network := &publishingPlugin{name: "network", events: []plugin.Event{
+		{Topic: plugin.EventHostsDiscovered, Source: "network", Data: hosts},
+		{Topic: plugin.EventWarning, Source: "network", Data: "arp table unreadable"},
+	}}
+	env, stdout := jsonEnv(t, network)
+	if err := pluginCommand("perceive", "network", "perception", "Error during perception")(env, nil); err != nil {
+		t.Fatal(err)
+	}
+	report := decodeReport(t, stdout.String())
+	host, _ := report["hosts"].(map[string]interface{})["10.0.0.5"].(map[string]interface{})
+	if report["command"] != "perceive" || host["name"] != "printer" {
+		t.Errorf("report = %v", report)
+	}
+	if w, _ := report["warnings"].([]interface{}); report["status"] != reportWarning || len(w) != 1 {
+		t.Errorf("status %v, warnings %v", report["status"], report["warnings"])
+	}
+
+	api := &publishingPlugin{name: "api", events: []plugin.Event{
+		{Topic: plugin.EventDelivery, Source: "api", Data: plugin.DeliveryResult{Destination: "central", Endpoint: "https://example.net/ingest", Status: plugin.ResultOK, Bytes: 512}},
+	}}
+	env, stdout = jsonEnv(t, api)
+	if err := runSend(env, nil); err != nil {
+		t.Fatal(err)
+	}
+	report = decodeReport(t, stdout.String())
+	dests, _ := report["destinations"].([]interface{})
+	if report["command"] != "send" || report["status"] != reportOK || len(dests) != 1 {
+		t.Fatalf("report = %v", report)
+	}
+	if d := dests[0].(map[string]interface{}); d["destination"] != "central" || d["bytes"] != 512.0 {
+		t.Errorf("destination = %v", d)
+	}
+}
+
+func TestTextOutputHasNoReport(t *testing.T) {
+	collection := &publishingPlugin{name: "collection", events: []plugin.Event{taskEvent("web1", "ping", plugin.ResultOK, "", 1)}}
+	env, stdout := jsonEnv(t, collection)
+	env.output = "table"
+	var got *commandReport
+	err := withReport(env, "collect", func(r *commandReport) error {
+		got = r
+		return env.controller.OnCommand("collection", map[string]string{"action": "collect"})
+	})
+	if err != nil || got != nil || stdout.Len() != 0 {
+		t.Errorf("err %v, report %v, stdout %q", err, got, stdout.String())
+	}
+}
+
+func TestWantsJSON(t *testing.T) {
+	for _, tc := range []struct {
+		global string
+		args   []string
+		want   bool
+	}{
+		{"json", nil, true},
+		{"table", nil, false},
+		{"", []string{"run", "local", "disk", "-o", "json"}, true},
+		{"", []string{"-o=json"}, true},
+		{"", []string{"--o", "csv"}, false},
+		{"", []string{"-o"}, false},
+	} {
+		if got := wantsJSON(tc.global, tc.args); got != tc.want {
+			t.Errorf("wantsJSON(%q, %q) = %v", tc.global, tc.args, got)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	plugin "observer/base"
+	"observer/store"
+)
+
+// Selftest check outcomes.
+const (
+	checkPass = "PASS"
+	checkFail = "FAIL"
+	checkSkip = "SKIP"
+)
+
+// selftestTimeout bounds each network probe.
+const selftestTimeout = 3 * time.Second
+
+// checkResult is the outcome of one selftest check.
+type checkResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+func pass(name, format string, args ...interface{}) checkResult {
+	return checkResult{Name: name, Status: checkPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func fail(name, format string, args ...interface{}) checkResult {
+	return checkResult{Name: name, Status: checkFail, Detail: fmt.Sprintf(format, args...)}
+}
+
+func skip(name, format string, args ...interface{}) checkResult {
+	return checkResult{Name: name, Status: checkSkip, Detail: fmt.Sprintf(format, args...)}
+}
+
+// selftest runs every check that applies to the configuration. It only reads:
+// no metrics are written and no collection runs.
+func selftest(st store.Store) []checkResult {
+	data, err := plugin.ReadConfigFile()
+	if err != nil {
+		return []checkResult{fail("config", "%v", err)}
+	}
+	var cfg plugin.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return []checkResult{fail("config", "%s: %v", plugin.ConfigFile, err)}
+	}
+
+	var results []checkResult
+	if err := cfg.Validate(); err != nil {
+		results = append(results, fail("config", "%s: %s", plugin.ConfigFile, strings.ReplaceAll(err.Error(), "\n", "; ")))
+	} else {
+		results = append(results, pass("config", "%s parsed and valid", plugin.ConfigFile))
+	}
+
+	results = append(results, checkStore(st, cfg.Database.URL))
+	results = append(results, checkBinaries(&cfg)...)
+	results = append(results, checkDeviceDefinitions(&cfg)...)
+	results = append(results, checkHosts(&cfg)...)
+	results = append(results, checkDestinations(&cfg)...)
+	return results
+}
+
+// checkStore pings the open store, or reports why it could not be opened.
+func checkStore(st store.Store, url string) checkResult {
+	if st == nil {
+		if url == "" {
+			return skip("store", "no database configured")
+		}
+		// main only warns when the configured store fails; open it again for the error.
+		opened, err := store.Open(url)
+		if err != nil {
+			return fail("store", "%v", err)
+		}
+		defer opened.Close()
+		st = opened
+	}
+	if err := st.Ping(context.Background()); err != nil {
+		return fail("store", "ping: %v", err)
+	}
+	return pass("store", "database reachable")
+}
+
+// checkBinaries looks for the external tools the configured features call.
+func checkBinaries(cfg *plugin.Config) []checkResult {
+	var results []checkResult
+	find := func(reason string, names ...string) {
+		for _, name := range names {
+			if path, err := exec.LookPath(name); err == nil {
+				results = append(results, pass("binary "+name, "%s (%s)", path, reason))
+				return
+			}
+		}
+		results = append(results, fail("binary "+strings.Join(names, "|"), "not found in PATH (%s)", reason))
+	}
+
+	nmap := false
+	for _, env := range cfg.Perception {
+		nmap = nmap || (env.Enabled && env.Method == "nmap")
+	}
+	if nmap {
+		find("perception", "nmap")
+	}
+
+	// The local MTA tools and sudo only exist on Unix; on Windows the mail
+	// plugin checks remote servers over SMTP and nmap runs without sudo.
+	mail := usesPlugin(cfg, "mail") && runtime.GOOS != "windows"
+	if mail {
+		switch strings.ToLower(cfg.Mail.MTA) {
+		case "postfix":
+			find("mail plugin", "postqueue")
+		case "exim", "exim4":
+			find("mail plugin", "exim4", "exim")
+		case "opensmtpd", "smtpd":
+			find("mail plugin", "smtpctl")
+		default:
+			find("mail plugin", "postqueue", "exim4", "exim", "smtpctl")
+		}
+	}
+	if (nmap || mail) && runtime.GOOS != "windows" {
+		find("nmap and MTA commands run through it", "sudo")
+	}
+	return results
+}
+
+// usesPlugin reports whether any host has a collect task for the named plugin.
+func usesPlugin(cfg *plugin.Config, name string) bool {
+	for _, host := range cfg.Hosts {
+		for _, task := range host.Collect {
+			if strings.EqualFold(strings.SplitN(strings.TrimSpace(task.Metric), ".", 2)[0], name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkDeviceDefinitions loads the device definition file each credential names.
+func checkDeviceDefinitions(cfg *plugin.Config) []checkResult {
+	var results []checkResult
+	for _, key := range sortedNames(cfg.Credentials) {
+		cred := cfg.Credentials[key]
+		if cred.Type == "" {
+			continue
+		}
+		path := plugin.DeviceFile("sshcollect", cred.Type)
+		if cred.Community != "" || cred.Version != "" {
+			path = plugin.DeviceFile("snmp", cred.Type)
+		}
+		name := "device " + key
+		data, err := os.ReadFile(path)
+		if err != nil {
+			results = append(results, fail(name, "%v", err))
+			continue
+		}
+		var def interface{}
+		if err := json.Unmarshal(data, &def); err != nil {
+			results = append(results, fail(name, "%s: %v", path, err))
+			continue
+		}
+		results = append(results, pass(name, "%s loads", path))
+	}
+	return results
+}
+
+// checkHosts resolves each host address and probes it over TCP, in parallel.
+func checkHosts(cfg *plugin.Config) []checkResult {
+	keys := sortedNames(cfg.Hosts)
+	results := make([]checkResult, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			results[i] = checkHost(key, cfg.Hosts[key], hostPorts(cfg, cfg.Hosts[key]))
+		}(i, key)
+	}
+	wg.Wait()
+	return results
+}
+
+// hostPorts lists the TCP ports worth probing on a host: the ports of its SSH
+// credentials, else 22 and 80 as the network plugin's ping does.
+func hostPorts(cfg *plugin.Config, host plugin.Host) []string {
+	seen := map[string]bool{}
+	var ports []string
+	for _, task := range host.Collect {
+		cred, ok := cfg.Credentials[task.Credentials]
+		if !ok || cred.Community != "" || cred.Version != "" {
+			continue // SNMP is UDP
+		}
+		port := "22"
+		if cred.Port > 0 {
+			port = fmt.Sprint(cred.Port)
+		}
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) == 0 {
+		ports = []string{"22", "80"}
+	}
+	return ports
+}
+
+// checkHost resolves the address and passes when any of ports accepts a connection.
+func checkHost(key string, host plugin.Host, ports []string) checkResult {
+	name := "host " + key
+	address := strings.TrimSpace(host.Address)
+	if address == "" {
+		return fail(name, "no address")
+	}
+	addrs, err := net.LookupHost(address)
+	if err != nil {
+		return fail(name, "resolve %s: %v", address, err)
+	}
+	for _, port := range ports {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(addrs[0], port), selftestTimeout)
+		if err == nil {
+			conn.Close()
+			return pass(name, "%s (%s) accepts TCP %s", address, addrs[0], port)
+		}
+	}
+	return fail(name, "%s (%s) resolves but no answer on TCP %s", address, addrs[0], strings.Join(ports, ", "))
+}
+
+// checkDestinations sends a HEAD request to each active remote destination.
+// Any response below 500 shows the server is there, even if it rejects HEAD.
+func checkDestinations(cfg *plugin.Config) []checkResult {
+	client := &http.Client{Timeout: selftestTimeout}
+	var results []checkResult
+	for _, key := range sortedNames(cfg.Remote.Destinations) {
+		dest := cfg.Remote.Destinations[key]
+		name := "destination " + key
+		if !dest.Active {
+			results = append(results, skip(name, "inactive"))
+			continue
+		}
+		resp, err := client.Head(dest.Endpoint)
+		if err != nil {
+			results = append(results, fail(name, "%v", err))
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			results = append(results, fail(name, "%s answered %s", dest.Endpoint, resp.Status))
+			continue
+		}
+		results = append(results, pass(name, "%s answered %s", dest.Endpoint, resp.Status))
+	}
+	return results
+}
+
+// sortedNames returns the keys of m in order.
+func sortedNames[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// printChecks writes one line per check and the totals.
+func printChecks(w io.Writer, results []checkResult) {
+	width := 0
+	for _, r := range results {
+		width = max(width, len(r.Name))
+	}
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(w, "%s  %-*s  %s\n", r.Status, width, r.Name, r.Detail)
+	}
+	fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n", counts[checkPass], counts[checkFail], counts[checkSkip])
+}
+
+func runSelftest(env *cliEnv, args []string) error {
+	rest, err := interspersed(commandFlags(env, "selftest"), args)
+	if err != nil {
+		return &usageError{msg: err.Error()}
+	}
+	if len(rest) > 0 {
+		return &usageError{msg: fmt.Sprintf("unexpected argument %q", rest[0])}
+	}
+
+	results := selftest(env.controller.Store)
+	if env.output == "json" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal results: %w", err)
+		}
+		fmt.Fprintln(env.stdout, string(data))
+	} else {
+		printChecks(env.stdout, results)
+	}
+
+	for _, r := range results {
+		if r.Status == checkFail {
+			return &plugin.ExitError{Code: exitFailure}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+	"observer/store"
+)
+
+// listenPort returns a port that accepts connections for the rest of the test.
+func listenPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort returns a port nothing listens on.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
+
+// writeDevice writes a device definition under the devices directory.
+func writeDevice(t *testing.T, dir, pluginName, deviceType, data string) {
+	t.Helper()
+	path := filepath.Join(dir, pluginName, deviceType+".json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// selftestEnv points nord at a temp directory with devices, an empty PATH
+// and config, and returns the directory.
+func selftestEnv(t *testing.T, config string) string {
+	t.Helper()
+	dir := testenv.UseTempDirs(t)
+	devices := filepath.Join(dir, "devices")
+	t.Setenv(plugin.EnvDevicesDir, devices)
+	plugin.LoadPaths()
+	writeDevice(t, devices, "sshcollect", "good", `{"commands": {}}`)
+	writeDevice(t, devices, "snmp", "broken", `{"oids": [`)
+	t.Setenv("PATH", filepath.Join(dir, "bin"))
+	writeConfig(t, config)
+	return dir
+}
+
+// checksByName indexes results by check name.
+func checksByName(results []checkResult) map[string]checkResult {
+	out := map[string]checkResult{}
+	for _, r := range results {
+		out[r.Name] = r
+	}
+	return out
+}
+
+func TestSelftestBrokenConfig(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	open, closed := listenPort(t), closedPort(t)
+	dir := t.TempDir()
+	notADir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notADir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf(`{
+  "config_version": 1,
+  "database": {"url": "sqlite://%s/nord.db"},
+  "hosts": {
+    "blank":    {"address": "", "collect": [{"metric": "network.ping"}]},
+    "open":     {"address": "127.0.0.1", "collect": [{"metric": "sshcollect", "credentials": "open_ssh"}]},
+    "closed":   {"address": "127.0.0.1", "collect": [{"metric": "sshcollect", "credentials": "closed_ssh"}]},
+    "mailhost": {"address": "127.0.0.1", "collect": [{"metric": "mail.queue"}, {"metric": "sshcollect", "credentials": "open_ssh"}]}
+  },
+  "credentials": {
+    "open_ssh":   {"user": "ops", "port": %d, "type": "good"},
+    "closed_ssh": {"user": "ops", "port": %d, "type": "missing"},
+    "core_snmp":  {"community": "public", "version": "2c", "type": "broken"}
+  },
+  "perception": {"lan": {"enabled": true, "method": "nmap", "ranges": ["10.0.0.0/24"]}},
+  "remote": {"destinations": {
+    "up":   {"endpoint": %q, "active": true},
+    "down": {"endpoint": %q, "active": true},
+    "gone": {"endpoint": "http://127.0.0.1:%d/ingest", "active": true},
+    "off":  {"endpoint": "http://example.invalid/", "active": false}
+  }}
+}`, notADir, open, closed, up.URL, down.URL, closed)
+	selftestEnv(t, config)
+
+	env, stdout, _ := testEnv()
+	err := runSelftest(env, nil)
+	var exit *plugin.ExitError
+	if !errors.As(err, &exit) || exit.Code != exitFailure {
+		t.Errorf("err = %v, want exit %d", err, exitFailure)
+	}
+
+	out := stdout.String()
+	lines := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && (fields[0] == checkPass || fields[0] == checkFail || fields[0] == checkSkip) {
+			name := fields[1]
+			if fields[1] == "binary" || fields[1] == "device" || fields[1] == "host" || fields[1] == "destination" {
+				name += " " + fields[2]
+			}
+			lines[name] = line
+		}
+	}
+	for name, want := range map[string]string{
+		"config":                              checkFail + ".*hosts.blank: address is empty",
+		"store":                               checkFail + ".*not a directory",
+		"binary nmap":                         checkFail + ".*not found in PATH \\(perception\\)",
+		"binary postqueue|exim4|exim|smtpctl": checkFail + ".*mail plugin",
+		"binary sudo":                         checkFail,
+		"device closed_ssh":                   checkFail + ".*missing.json",
+		"device core_snmp":                    checkFail + ".*broken.json",
+		"device open_ssh":                     checkPass + ".*good.json loads",
+		"host blank":                          checkFail + ".*no address",
+		"host closed":                         checkFail + fmt.Sprintf(".*no answer on TCP %d", closed),
+		"host open":                           checkPass + fmt.Sprintf(".*accepts TCP %d", open),
+		"destination up":                      checkPass + ".*405",
+		"destination down":                    checkFail + ".*502",
+		"destination gone":                    checkFail,
+		"destination off":                     checkSkip + ".*inactive",
+	} {
+		line, ok := lines[name]
+		if !ok {
+			t.Errorf("no %q check in:\n%s", name, out)
+			continue
+		}
+		if !regexp.MustCompile(want).MatchString(line) {
+			t.Errorf("%s: %q does not match %q", name, line, want)
+		}
+	}
+	if !strings.Contains(out, "4 passed, 11 failed, 1 skipped") {
+		t.Errorf("totals:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(notADir, "nord.db")); err == nil {
+		t.Error("selftest created a database")
+	}
+}
+
+func TestSelftestPasses(t *testing.T) {
+	open := listenPort(t)
+	dir := selftestEnv(t, "")
+	dbPath := filepath.Join(dir, "nord.db")
+	writeConfig(t, fmt.Sprintf(`{
+  "config_version": 1,
+  "database": {"url": "sqlite://%s"},
+  "hosts": {"open": {"address": "127.0.0.1", "collect": [{"metric": "sshcollect", "credentials": "ssh"}]}},
+  "credentials": {"ssh": {"user": "ops", "port": %d, "type": "good"}}
+}`, dbPath, open))
+	st, err := store.Open("sqlite://" + dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	env, stdout, _ := testEnv()
+	env.controller.Store = st
+	env.output = "json"
+	if err := runSelftest(env, nil); err != nil {
+		t.Fatalf("err = %v\n%s", err, stdout)
+	}
+	var results []checkResult
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, stdout)
+	}
+	checks := checksByName(results)
+	if len(results) != 4 || checks["config"].Status != checkPass || checks["store"].Status != checkPass || checks["host open"].Status != checkPass {
+		t.Errorf("results = %+v", results)
+	}
+
+	// Checks only read: nothing lands in the store.
+	hosts, err := st.ListHosts(context.Background())
+	if err != nil || len(hosts) != 0 {
+		t.Errorf("hosts written: %+v, %v", hosts, err)
+	}
+}
+
+func TestSelftestUnreadableConfig(t *testing.T) {
+	selftestEnv(t, `{"config_version": 1, "hosts": [`)
+	results := selftest(nil)
+	if len(results) != 1 || results[0].Name != "config" || results[0].Status != checkFail {
+		t.Errorf("results = %+v", results)
+	}
+}
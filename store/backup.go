@@ -0,0 +1,240 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Online backups copy backupStepPages pages at a time and pause between
+// steps, so the database is only locked briefly and writers carry on.
+const (
+	backupStepPages = 256
+	backupPause     = 10 * time.Millisecond
+	backupTimeout   = 30 * time.Minute
+)
+
+// ToolError is returned by Backup and Restore for MySQL and Postgres, which
+// are backed up with their own tools. Command is the equivalent invocation.
+type ToolError struct {
+	Op      string // "backup" or "restore"
+	Dialect string
+	Command string
+	Note    string // how the tool gets the password
+}
+
+func (e *ToolError) Error() string {
+	msg := fmt.Sprintf("store: %s: %s databases are handled by their own tools; run:\n  %s", e.Op, e.Dialect, e.Command)
+	if e.Note != "" {
+		msg += "\n" + e.Note
+	}
+	return msg
+}
+
+// sqliteBackuper is implemented by modernc.org/sqlite connections.
+type sqliteBackuper interface {
+	NewBackup(dstURI string) (*sqlite.Backup, error)
+	NewRestore(srcURI string) (*sqlite.Backup, error)
+}
+
+// Backup copies the SQLite database to dest with the online backup API.
+// dest must not exist. CheckBackup verifies the copy.
+func (s *sqlStore) Backup(ctx context.Context, dest string) error {
+	if s.d != dialectSQLite {
+		return s.toolError("backup", dest)
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("store: backup: %s already exists", dest)
+	}
+	if err := s.copyDB(ctx, dest, false); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("store: backup to %s: %w", dest, err)
+	}
+	return nil
+}
+
+// Restore replaces the SQLite database's contents with the backup at src,
+// after checking it with CheckBackup, then applies any migrations the backup
+// predates. The current contents are first saved next to the database file
+// as <file>.pre-restore-<time>. The caller must keep other nord processes
+// from writing, i.e. hold the run lock.
+func (s *sqlStore) Restore(ctx context.Context, src string) error {
+	if s.d != dialectSQLite {
+		return s.toolError("restore", src)
+	}
+	if _, err := CheckBackup(src); err != nil {
+		return err
+	}
+	if s.path != ":memory:" {
+		saved := s.path + ".pre-restore-" + time.Now().Format("20060102-150405")
+		if err := s.Backup(ctx, saved); err != nil {
+			return err
+		}
+		logf("  |_ store: saved the current database to %s\n", saved)
+	}
+	if err := s.copyDB(ctx, src, true); err != nil {
+		return fmt.Errorf("store: restore from %s: %w", src, err)
+	}
+
+	s.mu.Lock()
+	s.hostCache = make(map[string]int64)
+	s.noFTS = false
+	s.mu.Unlock()
+	return s.migrate()
+}
+
+// copyDB runs an online backup from the database to file, or with restore
+// from file to the database, retrying steps that find the database locked.
+func (s *sqlStore) copyDB(ctx context.Context, file string, restore bool) error {
+	ctx, cancel := context.WithTimeout(ctx, backupTimeout)
+	defer cancel()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(dc interface{}) error {
+		bc, ok := dc.(sqliteBackuper)
+		if !ok {
+			return errors.New("the SQLite driver does not support online backup")
+		}
+		var (
+			b   *sqlite.Backup
+			err error
+		)
+		if restore {
+			b, err = bc.NewRestore(file)
+		} else {
+			b, err = bc.NewBackup(file)
+		}
+		if err != nil {
+			return err
+		}
+		for {
+			more, err := b.Step(backupStepPages)
+			if err != nil && !isBusy(err) {
+				b.Finish()
+				return err
+			}
+			if err == nil && !more {
+				return b.Finish()
+			}
+			if ctx.Err() != nil {
+				b.Finish()
+				return fmt.Errorf("database stayed busy for %s", backupTimeout)
+			}
+			time.Sleep(backupPause)
+		}
+	})
+}
+
+// isBusy reports whether a backup step failed only because another
+// connection holds a lock; the step can be retried.
+func isBusy(err error) bool {
+	var e *sqlite.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	code := e.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// BackupInfo describes a checked SQLite backup.
+type BackupInfo struct {
+	SchemaVersion int
+	Hosts         int64
+	Metrics       int64
+}
+
+// CheckBackup opens an SQLite file read-only and verifies that it is a nord
+// database this version can use: PRAGMA integrity_check passes and
+// schema_migrations exists and is not newer than this binary's schema.
+func CheckBackup(path string) (BackupInfo, error) {
+	var info BackupInfo
+	fail := func(format string, args ...interface{}) (BackupInfo, error) {
+		return info, fmt.Errorf("store: %s is not a usable backup: "+format, append([]interface{}{path}, args...)...)
+	}
+	if st, err := os.Stat(path); err != nil {
+		return fail("%v", err)
+	} else if st.IsDir() {
+		return fail("is a directory")
+	}
+
+	db, err := sql.Open("sqlite", "file:"+(&url.URL{Path: path}).EscapedPath()+"?mode=ro")
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fail("%v", err)
+	}
+	if result != "ok" {
+		return fail("integrity check: %s", result)
+	}
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&info.SchemaVersion); err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return fail("no schema_migrations table")
+		}
+		return fail("%v", err)
+	}
+	if latest := len(migrations(dialectSQLite)); info.SchemaVersion > latest {
+		return fail("schema v%d is newer than this nord's v%d", info.SchemaVersion, latest)
+	}
+	if err := db.QueryRow(`SELECT (SELECT COUNT(*) FROM hosts), (SELECT COUNT(*) FROM metrics)`).Scan(&info.Hosts, &info.Metrics); err != nil {
+		return fail("%v", err)
+	}
+	return info, nil
+}
+
+// toolError builds the pg_dump/mysqldump (or restore) command matching the
+// server URL.
+func (s *sqlStore) toolError(op, file string) error {
+	e := &ToolError{Op: op}
+	u := s.server
+	if u == nil {
+		u = &url.URL{}
+	}
+	host, port, user := u.Hostname(), u.Port(), u.User.Username()
+	db := strings.TrimPrefix(u.Path, "/")
+	switch s.d {
+	case dialectPostgres:
+		e.Dialect = "Postgres"
+		conn := fmt.Sprintf("--host=%s --port=%s --username=%s", host, port, user)
+		if op == "backup" {
+			e.Command = fmt.Sprintf("pg_dump %s --format=custom --file=%s %s", conn, file, db)
+		} else {
+			e.Command = fmt.Sprintf("pg_restore %s --clean --if-exists --dbname=%s %s", conn, db, file)
+		}
+		e.Note = "The password is read from PGPASSWORD or ~/.pgpass."
+	case dialectMySQL:
+		e.Dialect = "MySQL"
+		conn := fmt.Sprintf("--host=%s --port=%s --user=%s -p", host, port, user)
+		if op == "backup" {
+			e.Command = fmt.Sprintf("mysqldump %s --single-transaction %s > %s", conn, db, file)
+		} else {
+			e.Command = fmt.Sprintf("mysql %s %s < %s", conn, db, file)
+		}
+		e.Note = "-p prompts for the password."
+	case dialectClickHouse:
+		e.Dialect = "ClickHouse"
+		conn := fmt.Sprintf("--host=%s --port=%s --user=%s --ask-password", host, port, user)
+		if op == "backup" {
+			e.Command = fmt.Sprintf(`clickhouse-client %s --query "BACKUP DATABASE %s TO File('%s')"`, conn, db, file)
+		} else {
+			e.Command = fmt.Sprintf(`clickhouse-client %s --query "RESTORE DATABASE %s FROM File('%s')"`, conn, db, file)
+		}
+		e.Note = "File() is relative to a path the server's backups.allowed_path allows."
+	}
+	return e
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// CatalogLimit caps the entries MetricCatalog returns for one host.
+const CatalogLimit = 5000
+
+// ErrCatalogTruncated is returned, with the first CatalogLimit entries, when
+// a host has more distinct metrics than that.
+var ErrCatalogTruncated = errors.New("store: metric catalog truncated")
+
+// CatalogEntry describes one metric a host has samples of: its plugin, name,
+// category and type, the number of distinct instances, and when it was first
+// and last collected.
+type CatalogEntry struct {
+	Plugin     string    `json:"plugin"`
+	Name       string    `json:"name"`
+	Category   string    `json:"category"`
+	MetricType string    `json:"metric_type"`
+	Instances  int       `json:"instance_count"` // 1 for a scalar metric
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// MetricCatalog lists the distinct metrics stored for a host, in metrics or
+// metrics_recent, ordered by plugin and name. It is one GROUP BY per table,
+// answered from idx_metrics_catalog for metrics. The instance count is the
+// larger of the two tables'; samples in the ring buffer mostly repeat
+// instances already in metrics. An unknown host yields an empty slice.
+func (s *sqlStore) MetricCatalog(ctx context.Context, hostKey string) ([]CatalogEntry, error) {
+	hostID, ok, err := s.lookupHostID(ctx, hostKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []CatalogEntry{}, nil
+	}
+
+	entries := []CatalogEntry{}
+	index := make(map[string]int)
+	for _, table := range metricTables {
+		found, err := s.catalogIn(ctx, table, hostID)
+		if err != nil {
+			return nil, fmt.Errorf("store: metric catalog %q: %w", hostKey, err)
+		}
+		for _, e := range found {
+			k := e.Plugin + "\x00" + e.Name + "\x00" + e.Category + "\x00" + e.MetricType
+			i, seen := index[k]
+			if !seen {
+				index[k] = len(entries)
+				entries = append(entries, e)
+				continue
+			}
+			m := &entries[i]
+			m.Instances = max(m.Instances, e.Instances)
+			if e.FirstSeen.Before(m.FirstSeen) {
+				m.FirstSeen = e.FirstSeen
+			}
+			if e.LastSeen.After(m.LastSeen) {
+				m.LastSeen = e.LastSeen
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Plugin != b.Plugin {
+			return a.Plugin < b.Plugin
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Category+a.MetricType < b.Category+b.MetricType
+	})
+	if len(entries) > CatalogLimit {
+		return entries[:CatalogLimit], ErrCatalogTruncated
+	}
+	return entries, nil
+}
+
+// catalogIn groups a host's samples in table by metric. It reads at most
+// CatalogLimit+1 groups, enough for MetricCatalog to tell it truncated.
+func (s *sqlStore) catalogIn(ctx context.Context, table string, hostID int64) ([]CatalogEntry, error) {
+	q := `SELECT plugin, name, category, metric_type,
+			COUNT(DISTINCT COALESCE(instance, '')), MIN(collected_at), MAX(collected_at)
+		FROM ` + table + `
+		WHERE host_id = ` + s.ph(1) + `
+		GROUP BY plugin, name, category, metric_type
+		ORDER BY plugin, name, category, metric_type
+		LIMIT ` + strconv.Itoa(CatalogLimit+1)
+
+	rows, err := s.db.QueryContext(ctx, q, hostID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []CatalogEntry
+	for rows.Next() {
+		var (
+			e           CatalogEntry
+			first, last scanTime
+		)
+		if err := rows.Scan(&e.Plugin, &e.Name, &e.Category, &e.MetricType, &e.Instances, &first, &last); err != nil {
+			return nil, err
+		}
+		e.FirstSeen, e.LastSeen = first.Time, last.Time
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
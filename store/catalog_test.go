@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// catalogLine summarizes an entry as "plugin/name category type instances first-last".
+func catalogLine(e CatalogEntry) string {
+	return fmt.Sprintf("%s/%s %s %s %d %s-%s", e.Plugin, e.Name, e.Category, e.MetricType, e.Instances,
+		e.FirstSeen.UTC().Format("15:04"), e.LastSeen.UTC().Format("15:04"))
+}
+
+func TestMetricCatalog(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	metric := func(plugin, name, category, metricType, instance, value string, at time.Time) MetricRecord {
+		r := sample("sw1", name, instance, value, at)
+		r.Plugin, r.Category, r.MetricType = plugin, category, metricType
+		return r
+	}
+	var records []MetricRecord
+	for i := 0; i < 3; i++ {
+		at := at.Add(time.Duration(i) * time.Hour)
+		records = append(records,
+			metric("snmp", "cpu", "system", "gauge", "", "12", at),
+			metric("snmp", "if_status", "interfaces", "status", "eth0", "up", at),
+			metric("snmp", "if_status", "interfaces", "status", "eth1", "up", at),
+			metric("local", "firmware", "system", "text", "", "JunOS 7.0.2", at))
+	}
+	// An instance seen once still counts.
+	records = append(records, metric("snmp", "if_status", "interfaces", "status", "eth2", "down", at.Add(30*time.Minute)))
+	// Another host's metrics are not listed.
+	records = append(records, sample("nas", "disk_used", "/", "40", at))
+	if err := s.WriteBatch(ctx, records); err != nil {
+		t.Fatal(err)
+	}
+	// Sub-minute samples: one metric only in the buffer, one in both tables.
+	if err := s.WriteBatchRecent(ctx, []MetricRecord{
+		metric("quality", "jitter", "quality", "gauge", "", "0.4", at.Add(3*time.Hour)),
+		metric("snmp", "cpu", "system", "gauge", "", "15", at.Add(4*time.Hour)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := s.MetricCatalog(ctx, "sw1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, catalogLine(e))
+	}
+	want := []string{
+		"local/firmware system text 1 12:00-14:00",
+		"quality/jitter quality gauge 1 15:00-15:00",
+		"snmp/cpu system gauge 1 12:00-16:00",
+		"snmp/if_status interfaces status 3 12:00-14:00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("catalog:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A metric whose type changed is listed once per type.
+	if err := s.WriteBatch(ctx, []MetricRecord{metric("snmp", "cpu", "system", "counter", "", "3", at.Add(5*time.Hour))}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = s.MetricCatalog(ctx, "sw1"); err != nil || len(entries) != 5 || entries[2].MetricType != "counter" {
+		t.Errorf("after a type change: %+v, %v", entries, err)
+	}
+
+	if entries, err := s.MetricCatalog(ctx, "unknown"); err != nil || entries == nil || len(entries) != 0 {
+		t.Errorf("unknown host: %#v, %v", entries, err)
+	}
+}
+
+func TestMetricCatalogTruncated(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := make([]MetricRecord, CatalogLimit+1)
+	for i := range records {
+		records[i] = sample("big", fmt.Sprintf("sensor_%05d", i), "", "1", at)
+	}
+	if err := s.WriteBatch(ctx, records); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := s.MetricCatalog(ctx, "big")
+	if !errors.Is(err, ErrCatalogTruncated) {
+		t.Fatalf("err = %v", err)
+	}
+	if len(entries) != CatalogLimit || entries[0].Name != "sensor_00000" || entries[CatalogLimit-1].Name != fmt.Sprintf("sensor_%05d", CatalogLimit-1) {
+		t.Errorf("%d entries, %s to %s", len(entries), entries[0].Name, entries[len(entries)-1].Name)
+	}
+}
+
+func TestMetricCatalogUsesIndex(t *testing.T) {
+	s := openTestStore(t)
+	rows, err := s.db.Query(`EXPLAIN QUERY PLAN SELECT plugin, name, category, metric_type,
+			COUNT(DISTINCT COALESCE(instance, '')), MIN(collected_at), MAX(collected_at)
+		FROM metrics WHERE host_id = 1
+		GROUP BY plugin, name, category, metric_type
+		ORDER BY plugin, name, category, metric_type`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+	// The rows come grouped and ordered from the index; only COUNT(DISTINCT) sorts.
+	got := strings.Join(plan, "; ")
+	if !strings.Contains(got, "COVERING INDEX idx_metrics_catalog") || strings.Contains(got, "GROUP BY") || strings.Contains(got, "ORDER BY") {
+		t.Errorf("plan = %s", got)
+	}
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClickHouseMigrations(t *testing.T) {
+	all := migrations(dialectClickHouse)
+	if len(all) != len(migrations(dialectSQLite)) {
+		t.Fatalf("ClickHouse has %d migrations, SQLite %d", len(all), len(migrations(dialectSQLite)))
+	}
+	for _, m := range all {
+		if m.version > 1 && m.down == nil {
+			t.Errorf("v%d has no down statements", m.version)
+		}
+		for _, stmt := range append(append([]string{}, m.up...), m.down...) {
+			for _, foreign := range []string{"AUTO_INCREMENT", "SERIAL", "REFERENCES", "CREATE INDEX", "TEXT", "JSON"} {
+				if strings.Contains(stmt, foreign) {
+					t.Errorf("v%d statement uses %s:\n%s", m.version, foreign, stmt)
+				}
+			}
+			if strings.HasPrefix(strings.TrimSpace(stmt), "CREATE TABLE") && !strings.Contains(stmt, "ENGINE =") {
+				t.Errorf("v%d table has no engine:\n%s", m.version, stmt)
+			}
+		}
+	}
+	if ddl := schemaMigrationsDDL(dialectClickHouse); !strings.Contains(ddl, "ENGINE = MergeTree") {
+		t.Errorf("schema_migrations DDL:\n%s", ddl)
+	}
+}
+
+func TestClickHouseHostID(t *testing.T) {
+	a, b := clickhouseHostID("router"), clickhouseHostID("switch")
+	if a != clickhouseHostID("router") {
+		t.Error("host id is not stable")
+	}
+	if a == b {
+		t.Errorf("router and switch share id %d", a)
+	}
+	if a < 0 || b < 0 {
+		t.Errorf("negative ids %d, %d", a, b)
+	}
+}
+
+func TestBackslashLikeEscaper(t *testing.T) {
+	if got, want := backslashLikeEscaper.Replace(`50%_a\b`), `50\%\_a\\b`; got != want {
+		t.Errorf("escaped = %q, want %q", got, want)
+	}
+}
+
+func TestOpenClickHouseUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	st, err := Open("clickhouse://default@" + addr + "/nord?dial_timeout=1s")
+	if err == nil {
+		st.Close()
+		t.Fatal("Open succeeded with no server")
+	}
+	if !strings.Contains(err.Error(), "store: connect clickhouse") {
+		t.Errorf("Open error = %v", err)
+	}
+}
+
+// TestClickHouseStore runs against the server NORD_TEST_CLICKHOUSE names,
+// e.g. clickhouse://default@localhost:9000/nord_test, whose database it
+// fills; it is skipped without one.
+func TestClickHouseStore(t *testing.T) {
+	rawURL := os.Getenv("NORD_TEST_CLICKHOUSE")
+	if rawURL == "" {
+		t.Skip("NORD_TEST_CLICKHOUSE not set")
+	}
+	st, err := Open(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	host := "ch-test-" + time.Now().Format("150405.000000")
+	at := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	record := func(name, value string, dedup bool, at time.Time) MetricRecord {
+		return MetricRecord{HostKey: host, HostName: host, Plugin: "local", Name: name,
+			MetricType: "gauge", Value: value, ValueNum: ParseValueNum(value), Unit: "s",
+			CollectedAt: at, Dedup: dedup}
+	}
+	err = st.WriteBatch(ctx, []MetricRecord{
+		record("uptime", "10", false, at),
+		record("kernel", "6.1", true, at),
+		record("uptime", "20", false, at.Add(time.Minute)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.WriteBatch(ctx, []MetricRecord{record("kernel", "6.1", true, at.Add(2*time.Minute))}); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := st.LatestMetrics(ctx, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 2 || latest[0].Name != "kernel" || !latest[0].CollectedAt.Equal(at) ||
+		latest[1].Value != "20" || latest[1].Unit != "s" {
+		t.Errorf("latest = %+v", latest)
+	}
+
+	speed := int64(1e9)
+	iface := InterfaceRecord{HostKey: host, HostName: host, IfIndex: 1, Name: "eth0", Speed: &speed}
+	if err := st.UpsertInterfaces(ctx, []InterfaceRecord{iface}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := st.GetInterfaces(ctx, host)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("interfaces = %+v, %v", first, err)
+	}
+	iface.Alias = "uplink"
+	if err := st.UpsertInterfaces(ctx, []InterfaceRecord{iface}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := st.GetInterfaces(ctx, host)
+	if err != nil || len(second) != 1 || second[0].Alias != "uplink" || !second[0].FirstSeen.Equal(first[0].FirstSeen) {
+		t.Errorf("interfaces after upsert = %+v, %v; first_seen was %v", second, err, first[0].FirstSeen)
+	}
+
+	hosts, err := st.ListHosts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, h := range hosts {
+		if h.Key == host {
+			found = h.RecentMetrics == 3
+		}
+	}
+	if !found {
+		t.Errorf("ListHosts lacks %s with 3 samples: %+v", host, hosts)
+	}
+
+	if n, err := st.PruneMetrics(ctx, at.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("pruned %d rows before the first sample", n)
+	}
+}
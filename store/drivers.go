@@ -1,9 +1,10 @@
 package store
 
 // Import database drivers as side effects so they register themselves
-// with database/sql. All three are pure Go — no CGO required.
+// with database/sql. All four are pure Go — no CGO required.
 import (
-	_ "github.com/go-sql-driver/mysql" // mysql://
-	_ "github.com/lib/pq"              // postgres://
-	_ "modernc.org/sqlite"             // sqlite://
+	_ "github.com/ClickHouse/clickhouse-go/v2" // clickhouse://
+	_ "github.com/go-sql-driver/mysql"         // mysql://
+	_ "github.com/lib/pq"                      // postgres://
+	_ "modernc.org/sqlite"                     // sqlite://
 )
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// recentWindow is how far back HostRecord.RecentMetrics counts samples.
+const recentWindow = 24 * time.Hour
+
+// HostRecord is a row of the hosts table: a host the store has samples,
+// interfaces or links of, whether or not it is still configured.
+type HostRecord struct {
+	ID            int64     `json:"id"`
+	Key           string    `json:"key"`
+	Name          string    `json:"name"`
+	Address       string    `json:"address"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
+	RecentMetrics int       `json:"recent_metrics"` // samples collected in the last 24h
+}
+
+// ListHosts returns every host the store knows, ordered by name then key.
+// The sample counts are correlated subqueries answered from the host and
+// time indexes of metrics and metrics_recent.
+func (s *sqlStore) ListHosts(ctx context.Context) ([]HostRecord, error) {
+	q := `SELECT h.id, h.` + s.quotedKey() + `, h.name, h.address, h.first_seen, h.last_seen,
+			(SELECT COUNT(*) FROM metrics m WHERE m.host_id = h.id AND m.collected_at >= ` + s.ph(1) + `),
+			(SELECT COUNT(*) FROM metrics_recent r WHERE r.host_id = h.id AND r.collected_at >= ` + s.ph(2) + `)
+		FROM hosts h
+		ORDER BY h.name, h.` + s.quotedKey()
+
+	since := time.Now().Add(-recentWindow)
+	rows, err := s.db.QueryContext(ctx, q, since, since)
+	if err != nil {
+		return nil, fmt.Errorf("store: list hosts: %w", err)
+	}
+	defer rows.Close()
+
+	hosts := []HostRecord{}
+	for rows.Next() {
+		var (
+			h           HostRecord
+			first, last scanTime
+			recent      int
+		)
+		if err := rows.Scan(&h.ID, &h.Key, &h.Name, &h.Address, &first, &last, &h.RecentMetrics, &recent); err != nil {
+			return nil, fmt.Errorf("store: list hosts: %w", err)
+		}
+		h.FirstSeen, h.LastSeen = first.Time, last.Time
+		h.RecentMetrics += recent
+		hosts = append(hosts, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: list hosts: %w", err)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,349 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// hostTables are the tables whose rows belong to a host through host_id, with
+// the column that dates a row.
+var hostTables = []struct{ name, dated string }{
+	{"metrics", "collected_at"},
+	{"metrics_recent", "collected_at"},
+	{"interfaces", "last_seen"},
+	{"links", "last_seen"},
+	{"data_flows_raw", "collected_at"},
+}
+
+// notNullColumns are the text columns the schema declares NOT NULL but that
+// databases created by older builds, or edited by hand, may hold NULL in.
+var notNullColumns = []struct{ table, column string }{
+	{"hosts", "name"},
+	{"hosts", "address"},
+	{"metrics", "plugin"},
+	{"metrics", "name"},
+	{"metrics", "category"},
+	{"metrics", "metric_type"},
+	{"metrics", "value"},
+	{"metrics", "unit"},
+	{"metrics_recent", "plugin"},
+	{"metrics_recent", "name"},
+	{"metrics_recent", "category"},
+	{"metrics_recent", "metric_type"},
+	{"metrics_recent", "value"},
+	{"metrics_recent", "unit"},
+	{"interfaces", "name"},
+	{"interfaces", "alias"},
+	{"interfaces", "mac_address"},
+	{"interfaces", "admin_status"},
+	{"interfaces", "oper_status"},
+	{"links", "local_port"},
+	{"links", "remote_chassis_id"},
+	{"links", "remote_port"},
+	{"links", "remote_port_desc"},
+	{"links", "remote_sys_name"},
+	{"links", "remote_address"},
+}
+
+// IntegrityReport is what CheckIntegrity found. Orphans and Nulls are keyed by
+// "table" and "table.column" and only list non-zero counts.
+type IntegrityReport struct {
+	Orphans    map[string]int  // rows whose host_id has no hosts row
+	Duplicates []HostDuplicate // host keys that differ only by case or surrounding space
+	Nulls      map[string]int  // NULLs in columns meant to be NOT NULL
+}
+
+// HostDuplicate is a set of hosts rows whose keys are the same once trimmed
+// and lower-cased, ordered by id.
+type HostDuplicate struct {
+	Keys []string
+	IDs  []int64
+}
+
+// Clean reports whether the check found nothing to repair.
+func (r IntegrityReport) Clean() bool {
+	return len(r.Orphans) == 0 && len(r.Duplicates) == 0 && len(r.Nulls) == 0
+}
+
+// RepairOptions controls RepairIntegrity.
+type RepairOptions struct {
+	// OrphansBefore limits orphan deletion to rows dated before it, so rows
+	// a writer is still adding are left alone. Zero deletes every orphan.
+	OrphansBefore time.Time
+	// PreferKeys are the host keys to keep when merging duplicates, normally
+	// those in the config. Otherwise a key without surrounding space wins,
+	// then the oldest row.
+	PreferKeys []string
+	// DryRun rolls the repair back after counting what it would change.
+	DryRun bool
+}
+
+// IntegrityRepair counts what RepairIntegrity changed, or would have with
+// DryRun.
+type IntegrityRepair struct {
+	Orphans       map[string]int // rows deleted per table
+	MergedHosts   int            // duplicate hosts rows folded into another
+	MovedRows     int            // rows re-pointed to the kept host
+	DroppedRows   int            // interfaces and links rows the kept host already had
+	NullsReplaced int            // NULLs set to empty strings
+}
+
+// CheckIntegrity looks for rows pointing at missing hosts, host keys that
+// differ only by case or surrounding space, and NULLs in columns meant to be
+// NOT NULL. It only reads.
+func (s *sqlStore) CheckIntegrity(ctx context.Context) (IntegrityReport, error) {
+	report := IntegrityReport{Orphans: map[string]int{}, Nulls: map[string]int{}}
+	for _, t := range hostTables {
+		var n int
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+t.name+` WHERE `+s.orphanCond(t.name)).Scan(&n); err != nil {
+			return report, fmt.Errorf("store: check orphans in %s: %w", t.name, err)
+		}
+		if n > 0 {
+			report.Orphans[t.name] = n
+		}
+	}
+
+	hosts, err := s.readHostKeys(ctx, s.db)
+	if err != nil {
+		return report, err
+	}
+	report.Duplicates = duplicateHosts(hosts)
+
+	for _, c := range notNullColumns {
+		var n int
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+c.table+` WHERE `+c.column+` IS NULL`).Scan(&n); err != nil {
+			return report, fmt.Errorf("store: check NULLs in %s.%s: %w", c.table, c.column, err)
+		}
+		if n > 0 {
+			report.Nulls[c.table+"."+c.column] = n
+		}
+	}
+	return report, nil
+}
+
+// RepairIntegrity fixes what CheckIntegrity finds, in one transaction: it
+// deletes orphans dated before opts.OrphansBefore, merges each set of
+// duplicate hosts into one, and replaces NULLs with empty strings. Merging moves the
+// other hosts' rows to the kept host, dropping interfaces and links rows the
+// kept host already has, then deletes the other hosts rows.
+func (s *sqlStore) RepairIntegrity(ctx context.Context, opts RepairOptions) (IntegrityRepair, error) {
+	repair := IntegrityRepair{Orphans: map[string]int{}}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return repair, fmt.Errorf("store: begin tx (repair): %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, t := range hostTables {
+		q := `DELETE FROM ` + t.name + ` WHERE ` + s.orphanCond(t.name)
+		var args []interface{}
+		if !opts.OrphansBefore.IsZero() {
+			q += ` AND ` + t.dated + ` < ` + s.ph(1)
+			args = append(args, opts.OrphansBefore)
+		}
+		res, err := tx.ExecContext(ctx, q, args...)
+		if err != nil {
+			return repair, fmt.Errorf("store: delete orphans in %s: %w", t.name, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			repair.Orphans[t.name] = int(n)
+		}
+	}
+
+	hosts, err := s.readHostKeys(ctx, tx)
+	if err != nil {
+		return repair, err
+	}
+	prefer := make(map[string]bool, len(opts.PreferKeys))
+	for _, k := range opts.PreferKeys {
+		prefer[k] = true
+	}
+	for _, dup := range duplicateHosts(hosts) {
+		keep := keptHost(dup, prefer)
+		for i, id := range dup.IDs {
+			if i == keep {
+				continue
+			}
+			moved, dropped, err := s.mergeHost(ctx, tx, dup.IDs[keep], id)
+			if err != nil {
+				return repair, fmt.Errorf("store: merge host %q into %q: %w", dup.Keys[i], dup.Keys[keep], err)
+			}
+			repair.MergedHosts++
+			repair.MovedRows += moved
+			repair.DroppedRows += dropped
+		}
+	}
+
+	for _, c := range notNullColumns {
+		res, err := tx.ExecContext(ctx, `UPDATE `+c.table+` SET `+c.column+` = '' WHERE `+c.column+` IS NULL`)
+		if err != nil {
+			return repair, fmt.Errorf("store: replace NULLs in %s.%s: %w", c.table, c.column, err)
+		}
+		n, _ := res.RowsAffected()
+		repair.NullsReplaced += int(n)
+	}
+
+	if opts.DryRun {
+		return repair, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return repair, fmt.Errorf("store: commit repair: %w", err)
+	}
+	if repair.MergedHosts > 0 {
+		// Cached ids may be of hosts rows that no longer exist.
+		s.mu.Lock()
+		s.hostCache = make(map[string]int64)
+		s.mu.Unlock()
+	}
+	return repair, nil
+}
+
+// orphanCond selects the rows of table whose host is missing. ClickHouse
+// has no correlated subqueries.
+func (s *sqlStore) orphanCond(table string) string {
+	if s.d == dialectClickHouse {
+		return table + `.host_id NOT IN (SELECT id FROM hosts)`
+	}
+	return `NOT EXISTS (SELECT 1 FROM hosts h WHERE h.id = ` + table + `.host_id)`
+}
+
+// hostKey is a hosts row as the integrity checks read it.
+type hostKey struct {
+	id  int64
+	key string
+}
+
+// readHostKeys returns every hosts row, ordered by id.
+func (s *sqlStore) readHostKeys(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}) ([]hostKey, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id, `+s.quotedKey()+` FROM hosts ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("store: read hosts: %w", err)
+	}
+	defer rows.Close()
+	var hosts []hostKey
+	for rows.Next() {
+		var h hostKey
+		if err := rows.Scan(&h.id, &h.key); err != nil {
+			return nil, fmt.Errorf("store: read hosts: %w", err)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, rows.Err()
+}
+
+// duplicateHosts groups hosts whose keys are equal once trimmed and
+// lower-cased, keeping the groups of more than one, in order of first id.
+func duplicateHosts(hosts []hostKey) []HostDuplicate {
+	groups := make(map[string]*HostDuplicate)
+	var order []string
+	for _, h := range hosts {
+		norm := strings.ToLower(strings.TrimSpace(h.key))
+		g := groups[norm]
+		if g == nil {
+			g = &HostDuplicate{}
+			groups[norm] = g
+			order = append(order, norm)
+		}
+		g.Keys = append(g.Keys, h.key)
+		g.IDs = append(g.IDs, h.id)
+	}
+	var dups []HostDuplicate
+	for _, norm := range order {
+		if g := groups[norm]; len(g.IDs) > 1 {
+			dups = append(dups, *g)
+		}
+	}
+	return dups
+}
+
+// keptHost returns the index in dup of the host the others are merged into.
+func keptHost(dup HostDuplicate, prefer map[string]bool) int {
+	for i, k := range dup.Keys {
+		if prefer[k] {
+			return i
+		}
+	}
+	for i, k := range dup.Keys {
+		if k == strings.TrimSpace(k) {
+			return i
+		}
+	}
+	return 0
+}
+
+// mergeHost moves the rows of host from to host into, drops those of from's
+// interfaces and links rows that would collide with into's, widens into's
+// first and last seen to cover from's, and deletes from.
+func (s *sqlStore) mergeHost(ctx context.Context, tx *sql.Tx, into, from int64) (moved, dropped int, err error) {
+	collisions := []struct{ table, match string }{
+		{"interfaces", "k.if_index = d.if_index"},
+		{"links", "k.protocol = d.protocol AND k.local_port = d.local_port AND " +
+			"k.remote_chassis_id = d.remote_chassis_id AND k.remote_port = d.remote_port"},
+	}
+	for _, c := range collisions {
+		// Collect the ids first: MySQL cannot delete from a table it is
+		// reading in a subquery.
+		rows, err := tx.QueryContext(ctx, `SELECT d.id FROM `+c.table+` d JOIN `+c.table+` k ON `+c.match+
+			` WHERE d.host_id = `+s.ph(1)+` AND k.host_id = `+s.ph(2), from, into)
+		if err != nil {
+			return moved, dropped, err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return moved, dropped, err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return moved, dropped, err
+		}
+		for _, id := range ids {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+c.table+` WHERE id = `+s.ph(1), id); err != nil {
+				return moved, dropped, err
+			}
+			dropped++
+		}
+	}
+
+	for _, t := range hostTables {
+		res, err := tx.ExecContext(ctx, `UPDATE `+t.name+` SET host_id = `+s.ph(1)+` WHERE host_id = `+s.ph(2), into, from)
+		if err != nil {
+			return moved, dropped, err
+		}
+		n, _ := res.RowsAffected()
+		moved += int(n)
+	}
+
+	var first, last, fromFirst, fromLast time.Time
+	q := `SELECT first_seen, last_seen FROM hosts WHERE id = ` + s.ph(1)
+	if err := tx.QueryRowContext(ctx, q, into).Scan(&first, &last); err != nil {
+		return moved, dropped, err
+	}
+	if err := tx.QueryRowContext(ctx, q, from).Scan(&fromFirst, &fromLast); err != nil {
+		return moved, dropped, err
+	}
+	if fromFirst.Before(first) {
+		first = fromFirst
+	}
+	if fromLast.After(last) {
+		last = fromLast
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE hosts SET first_seen = `+s.ph(1)+`, last_seen = `+s.ph(2)+` WHERE id = `+s.ph(3),
+		first, last, into); err != nil {
+		return moved, dropped, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM hosts WHERE id = `+s.ph(1), from); err != nil {
+		return moved, dropped, err
+	}
+	return moved, dropped, nil
+}
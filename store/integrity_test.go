@@ -0,0 +1,228 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// corruptedStore builds a database the way an older build, a crash or a
+// hand edit can leave it, and opens a store over it:
+//   - core (id 1) and edge (id 2) are healthy;
+//   - "Core " and "CORE" are duplicates of core, with their own metrics and
+//     interfaces, one of which collides with core's ifIndex 1;
+//   - metrics, metrics_recent, interfaces, links and data_flows_raw rows point
+//     at hosts 98 and 99, which do not exist, one of them written recently;
+//   - hosts.address and interfaces.alias lost NOT NULL and hold NULLs.
+func corruptedStore(t *testing.T) *sqlStore {
+	t.Helper()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nord.db")
+	st, err := Open("sqlite://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := st.WriteBatch(ctx, []MetricRecord{sample("core", "cpu", "", "10", old), sample("edge", "cpu", "", "20", old)}); err != nil {
+		t.Fatal(err)
+	}
+	err = st.UpsertInterfaces(ctx, []InterfaceRecord{
+		{HostKey: "core", IfIndex: 1, Name: "eth0"},
+		{HostKey: "core", IfIndex: 2, Name: "eth1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	// exec runs statements on a connection of its own, so the next one
+	// reads the schema as the previous left it.
+	exec := func(stmts ...string) {
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		for _, stmt := range stmts {
+			if _, err := db.Exec(stmt); err != nil {
+				t.Fatalf("%s: %v", stmt, err)
+			}
+		}
+	}
+	// Loosen two columns as an older schema had them.
+	exec(`PRAGMA writable_schema = ON`,
+		`UPDATE sqlite_schema SET sql = replace(sql, 'address    TEXT NOT NULL', 'address    TEXT') WHERE name = 'hosts'`,
+		`UPDATE sqlite_schema SET sql = replace(sql, 'alias        TEXT NOT NULL', 'alias        TEXT') WHERE name = 'interfaces'`,
+		`PRAGMA writable_schema = OFF`)
+	oldAt, recentAt := "'2026-01-01 00:00:00'", "'"+time.Now().UTC().Format("2006-01-02 15:04:05")+"'"
+	exec(
+		`INSERT INTO hosts (id, key, name, address, first_seen, last_seen) VALUES
+			(3, 'Core ', 'core', NULL, '2025-12-30 00:00:00', '2025-12-31 00:00:00'),
+			(4, 'CORE', 'core', '192.0.2.1', '2026-01-01 00:00:00', '2026-01-03 00:00:00')`,
+		`INSERT INTO metrics (host_id, plugin, name, value, collected_at) VALUES (3, 'local', 'cpu', '11', `+oldAt+`), (4, 'local', 'cpu', '12', `+oldAt+`)`,
+		`INSERT INTO interfaces (host_id, if_index, name, alias) VALUES (3, 1, 'eth0', NULL), (4, 3, 'eth2', NULL)`,
+
+		`INSERT INTO metrics (host_id, plugin, name, value, collected_at) VALUES (98, 'local', 'cpu', '1', `+oldAt+`), (99, 'local', 'cpu', '1', `+recentAt+`)`,
+		`INSERT INTO metrics_recent (host_id, plugin, name, category, metric_type, value, collected_at) VALUES (98, 'local', 'cpu', '', '', '1', `+oldAt+`)`,
+		`INSERT INTO interfaces (host_id, if_index, alias, last_seen) VALUES (98, 1, '', `+oldAt+`)`,
+		`INSERT INTO links (host_id, protocol, last_seen) VALUES (99, 'lldp', `+oldAt+`)`,
+		`INSERT INTO data_flows_raw (host_id, flow_type, payload, collected_at) VALUES (99, 'ipfix', '{}', `+oldAt+`)`)
+
+	st, err = Open("sqlite://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st.(*sqlStore)
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	ctx := context.Background()
+	if r, err := openTestStore(t).CheckIntegrity(ctx); err != nil || !r.Clean() {
+		t.Errorf("new store: %+v, %v", r, err)
+	}
+
+	s := corruptedStore(t)
+	r, err := s.CheckIntegrity(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Clean() {
+		t.Fatal("corruption not found")
+	}
+	if want := map[string]int{"metrics": 2, "metrics_recent": 1, "interfaces": 1, "links": 1, "data_flows_raw": 1}; !reflect.DeepEqual(r.Orphans, want) {
+		t.Errorf("orphans = %v, want %v", r.Orphans, want)
+	}
+	if want := []HostDuplicate{{Keys: []string{"core", "Core ", "CORE"}, IDs: []int64{1, 3, 4}}}; !reflect.DeepEqual(r.Duplicates, want) {
+		t.Errorf("duplicates = %+v", r.Duplicates)
+	}
+	if want := map[string]int{"hosts.address": 1, "interfaces.alias": 2}; !reflect.DeepEqual(r.Nulls, want) {
+		t.Errorf("nulls = %v, want %v", r.Nulls, want)
+	}
+}
+
+func TestRepairIntegrity(t *testing.T) {
+	ctx := context.Background()
+	s := corruptedStore(t)
+	before, err := s.CheckIntegrity(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run counts, and changes nothing.
+	opts := RepairOptions{OrphansBefore: time.Now().Add(-time.Hour), DryRun: true}
+	dry, err := s.RepairIntegrity(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := s.CheckIntegrity(ctx); !reflect.DeepEqual(after, before) {
+		t.Errorf("dry run changed the store: %+v", after)
+	}
+
+	opts.DryRun = false
+	repair, err := s.RepairIntegrity(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(repair, dry) {
+		t.Errorf("repair %+v, dry run %+v", repair, dry)
+	}
+	// The orphan written within the hour is left for now.
+	want := IntegrityRepair{
+		Orphans:     map[string]int{"metrics": 1, "metrics_recent": 1, "interfaces": 1, "links": 1, "data_flows_raw": 1},
+		MergedHosts: 2,
+		MovedRows:   3, // two metrics and eth2; eth0 of "Core " collided
+		DroppedRows: 1,
+		// hosts.address went with "Core "; the alias of eth2 moved with it.
+		NullsReplaced: 1,
+	}
+	if !reflect.DeepEqual(repair, want) {
+		t.Errorf("repair = %+v, want %+v", repair, want)
+	}
+
+	r, err := s.CheckIntegrity(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (IntegrityReport{Orphans: map[string]int{"metrics": 1}, Nulls: map[string]int{}}); !reflect.DeepEqual(r, want) {
+		t.Errorf("after repair: %+v", r)
+	}
+	if _, err := s.RepairIntegrity(ctx, RepairOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := s.CheckIntegrity(ctx); err != nil || !r.Clean() {
+		t.Errorf("after a full repair: %+v, %v", r, err)
+	}
+
+	// core kept its key and gained the duplicates' rows and time span.
+	var hosts []string
+	rows, err := s.db.Query(`SELECT key, first_seen, last_seen FROM hosts ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var key string
+		var first, last time.Time
+		if err := rows.Scan(&key, &first, &last); err != nil {
+			t.Fatal(err)
+		}
+		// core was written just now; "Core " was seen first.
+		if key == "core" && (!first.Equal(time.Date(2025, 12, 30, 0, 0, 0, 0, time.UTC)) || last.Before(time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC))) {
+			t.Errorf("core seen %v to %v", first, last)
+		}
+		hosts = append(hosts, key)
+	}
+	rows.Close()
+	if strings.Join(hosts, " ") != "core edge" {
+		t.Errorf("hosts %q", hosts)
+	}
+	latest, err := s.QueryMetrics(ctx, MetricFilter{HostKey: "core", Name: "cpu"})
+	if err != nil || len(latest) != 3 {
+		t.Errorf("core cpu samples: %d, %v", len(latest), err)
+	}
+	ifaces, err := s.GetInterfaces(ctx, "core")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, i := range ifaces {
+		names = append(names, i.Name)
+	}
+	if strings.Join(names, " ") != "eth0 eth1 eth2" {
+		t.Errorf("core interfaces %q", names)
+	}
+	// The store writes to the merged host after the repair.
+	if err := s.WriteBatch(ctx, []MetricRecord{sample("core", "cpu", "", "13", time.Now())}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, s, "hosts"); n != 2 {
+		t.Errorf("%d hosts after a write", n)
+	}
+}
+
+func TestKeptHost(t *testing.T) {
+	dup := HostDuplicate{Keys: []string{" web", "WEB", "web"}, IDs: []int64{1, 2, 3}}
+	for _, tt := range []struct {
+		prefer []string
+		want   int
+	}{
+		{nil, 1},             // the first key without surrounding space
+		{[]string{"web"}, 2}, // a configured key wins
+		{[]string{"other"}, 1},
+	} {
+		prefer := make(map[string]bool)
+		for _, k := range tt.prefer {
+			prefer[k] = true
+		}
+		if got := keptHost(dup, prefer); got != tt.want {
+			t.Errorf("prefer %q: kept %d, want %d", tt.prefer, got, tt.want)
+		}
+	}
+	if got := keptHost(HostDuplicate{Keys: []string{" a", "a "}, IDs: []int64{1, 2}}, nil); got != 0 {
+		t.Errorf("all spaced: kept %d, want the oldest", got)
+	}
+}
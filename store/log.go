@@ -0,0 +1,14 @@
+package store
+
+import (
+	"fmt"
+	"os"
+)
+
+// logf writes a progress or warning line, such as an applied migration or a
+// skipped row, to standard error. The store never writes to standard output,
+// which belongs to the command: `nord status -o json` must stay parseable
+// even when opening the store migrates it.
+func logf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, args...)
+}
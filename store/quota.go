@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quotaWindow is the period MaxPerHour counts records over.
+const quotaWindow = time.Hour
+
+// Quota bounds the metric records one plugin may write. Zero leaves a bound
+// off.
+type Quota struct {
+	MaxPerBatch int `json:"max_per_batch"` // records of the plugin kept from one WriteBatch
+	MaxPerHour  int `json:"max_per_hour"`  // records of the plugin kept per clock hour
+}
+
+// WithQuotas returns st with quotas, keyed by plugin name, enforced on
+// WriteBatch. Records over a quota are dropped; for each host and plugin that
+// lost records a quota_exceeded metric of plugin "nord" is written in their
+// place, instanced by the plugin, with the number dropped as its value.
+// Counts are kept in memory and start over every hour. Without quotas st is
+// returned as it is.
+func WithQuotas(st Store, quotas map[string]Quota) Store {
+	if st == nil || len(quotas) == 0 {
+		return st
+	}
+	q := &quotaStore{Store: st, quotas: make(map[string]Quota, len(quotas)), used: make(map[string]int), now: time.Now}
+	for name, quota := range quotas {
+		q.quotas[strings.ToLower(name)] = quota
+	}
+	return q
+}
+
+// quotaStore enforces per-plugin write quotas on the store it embeds.
+type quotaStore struct {
+	Store
+	quotas map[string]Quota
+	now    func() time.Time
+
+	mu     sync.Mutex
+	window time.Time      // start of the hour used counts
+	used   map[string]int // records kept this hour, by plugin
+}
+
+func (s *quotaStore) WriteBatch(ctx context.Context, records []MetricRecord) error {
+	return s.Store.WriteBatch(ctx, s.apply(records))
+}
+
+// quotaDrop is what a quota cost one host's records of a plugin.
+type quotaDrop struct {
+	host    MetricRecord // a dropped record, for the host's key, name and address
+	plugin  string
+	dropped int
+	quota   string // "max_per_batch" or "max_per_hour"
+	limit   int
+}
+
+// apply returns the records within quota, followed by a quota_exceeded
+// record for each host and plugin that had records dropped.
+func (s *quotaStore) apply(records []MetricRecord) []MetricRecord {
+	now := s.now()
+	s.mu.Lock()
+	if hour := now.Truncate(quotaWindow); !hour.Equal(s.window) {
+		s.window = hour
+		s.used = make(map[string]int)
+	}
+
+	kept := make([]MetricRecord, 0, len(records))
+	inBatch := make(map[string]int)
+	drops := make(map[string]*quotaDrop)
+	var order []string
+	for _, r := range records {
+		plugin := strings.ToLower(r.Plugin)
+		quota, limited := s.quotas[plugin]
+		if !limited {
+			kept = append(kept, r)
+			continue
+		}
+		name, limit := "", 0
+		switch {
+		case quota.MaxPerBatch > 0 && inBatch[plugin] >= quota.MaxPerBatch:
+			name, limit = "max_per_batch", quota.MaxPerBatch
+		case quota.MaxPerHour > 0 && s.used[plugin] >= quota.MaxPerHour:
+			name, limit = "max_per_hour", quota.MaxPerHour
+		default:
+			inBatch[plugin]++
+			s.used[plugin]++
+			kept = append(kept, r)
+			continue
+		}
+		k := r.HostKey + "\x00" + plugin
+		d := drops[k]
+		if d == nil {
+			d = &quotaDrop{host: r, plugin: plugin}
+			drops[k] = d
+			order = append(order, k)
+		}
+		d.dropped++
+		d.quota, d.limit = name, limit
+	}
+	s.mu.Unlock()
+
+	if len(order) == 0 {
+		return records
+	}
+	sort.Strings(order)
+	for _, k := range order {
+		d := drops[k]
+		logf("  !_ store: %s: dropped %d %s records over its %s quota of %d\n",
+			d.host.HostKey, d.dropped, d.plugin, d.quota, d.limit)
+		dropped := float64(d.dropped)
+		kept = append(kept, MetricRecord{
+			HostKey:     d.host.HostKey,
+			HostName:    d.host.HostName,
+			HostAddress: d.host.HostAddress,
+			Plugin:      "nord",
+			Name:        "quota_exceeded",
+			Category:    "self",
+			MetricType:  "gauge",
+			Value:       strconv.Itoa(d.dropped),
+			ValueNum:    &dropped,
+			Instance:    d.plugin,
+			Extra:       map[string]interface{}{"quota": d.quota, "limit": d.limit},
+			CollectedAt: now,
+		})
+	}
+	return kept
+}
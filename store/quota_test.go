@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// recordingStore keeps what WriteBatch was given.
+type recordingStore struct {
+	Store
+	written [][]MetricRecord
+}
+
+func (r *recordingStore) WriteBatch(ctx context.Context, records []MetricRecord) error {
+	r.written = append(r.written, records)
+	return nil
+}
+
+func TestQuotas(t *testing.T) {
+	rec := &recordingStore{}
+	now := time.Date(2026, 3, 1, 12, 10, 0, 0, time.UTC)
+	st := WithQuotas(rec, map[string]Quota{"SNMP": {MaxPerBatch: 2, MaxPerHour: 3}}).(*quotaStore)
+	st.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	snmp := func(host string) MetricRecord {
+		r := sample(host, "if_in", "", "1", now)
+		r.Plugin = "snmp"
+		return r
+	}
+	write := func(records ...MetricRecord) []MetricRecord {
+		t.Helper()
+		if err := st.WriteBatch(ctx, records); err != nil {
+			t.Fatal(err)
+		}
+		return rec.written[len(rec.written)-1]
+	}
+	// exceeded returns the quota_exceeded records of got, by host.
+	exceeded := func(got []MetricRecord) map[string]MetricRecord {
+		out := make(map[string]MetricRecord)
+		for _, r := range got {
+			if r.Plugin == "nord" && r.Name == "quota_exceeded" {
+				out[r.HostKey] = r
+			}
+		}
+		return out
+	}
+
+	// Five snmp records of two hosts: two kept, three dropped by the batch quota.
+	got := write(snmp("a"), snmp("a"), snmp("a"), snmp("b"), snmp("b"), sample("a", "load_1", "", "1", now))
+	if len(got) != 5 {
+		t.Fatalf("first write kept %d records, want 2 snmp, 1 local and 2 quota_exceeded: %+v", len(got), got)
+	}
+	ex := exceeded(got)
+	if a := ex["a"]; a.Value != "1" || a.Instance != "snmp" || a.Extra["quota"] != "max_per_batch" || a.Extra["limit"] != 2 {
+		t.Errorf("quota_exceeded of a = %+v", a)
+	}
+	if b := ex["b"]; b.Value != "2" || *b.ValueNum != 2 || !b.CollectedAt.Equal(now) {
+		t.Errorf("quota_exceeded of b = %+v", b)
+	}
+
+	// One more snmp record fits the hour's three; the next two do not.
+	got = write(snmp("a"), snmp("a"), snmp("a"))
+	if len(got) != 2 {
+		t.Fatalf("second write = %+v, want 1 snmp and 1 quota_exceeded", got)
+	}
+	if a := exceeded(got)["a"]; a.Value != "2" || a.Extra["quota"] != "max_per_hour" || a.Extra["limit"] != 3 {
+		t.Errorf("quota_exceeded of a = %+v", a)
+	}
+
+	// The next hour starts over.
+	now = now.Add(time.Hour)
+	got = write(snmp("a"), snmp("a"))
+	if len(got) != 2 || len(exceeded(got)) != 0 {
+		t.Errorf("write in the next hour = %+v, want both records kept", got)
+	}
+
+	// Without quotas the store is returned as it is.
+	if WithQuotas(rec, nil) != Store(rec) {
+		t.Error("WithQuotas without quotas wrapped the store")
+	}
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// DefaultRecentRetention is how much sub-minute data metrics_recent keeps when
+// the configuration does not say.
+const DefaultRecentRetention = 6 * time.Hour
+
+// WriteBatchRecent persists sub-minute samples, such as flow aggregates or
+// probes every few seconds, to the metrics_recent ring buffer instead of
+// metrics. Reads see both tables; PruneRecent keeps the buffer bounded.
+func (s *sqlStore) WriteBatchRecent(ctx context.Context, records []MetricRecord) error {
+	return s.writeMetrics(ctx, "metrics_recent", records)
+}
+
+// recentSample is a metrics_recent row as PruneRecent reads it.
+type recentSample struct {
+	hostID int64
+	MetricRecord
+}
+
+// PruneRecent rolls the metrics_recent samples collected before the minute
+// of before up into metrics, one sample per series and minute, and deletes
+// them from metrics_recent, all in one transaction. Numeric series are
+// averaged, with min, max and the sample count in extra; other series keep
+// the minute's last value. It returns the number of rollup samples written and
+// of samples deleted.
+func (s *sqlStore) PruneRecent(ctx context.Context, before time.Time) (rolled, pruned int, err error) {
+	cutoff := before.Truncate(time.Minute)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("store: begin tx (prune recent): %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	samples, err := s.readRecent(ctx, tx, cutoff)
+	if err != nil {
+		return 0, 0, fmt.Errorf("store: prune recent: %w", err)
+	}
+	if len(samples) == 0 {
+		return 0, 0, nil
+	}
+
+	insertQ := "INSERT INTO metrics " +
+		"(host_id, plugin, name, category, metric_type, value, value_num, instance, extra, value_list, unit, collected_at) " +
+		"VALUES (" + s.ph(1) + ", " + s.ph(2) + ", " + s.ph(3) + ", " + s.ph(4) + ", " + s.ph(5) + ", " +
+		s.ph(6) + ", " + s.ph(7) + ", " + s.ph(8) + ", " + s.ph(9) + ", " + s.ph(10) + ", " + s.ph(11) + ", " + s.ph(12) + ")"
+	stmt, err := tx.PrepareContext(ctx, insertQ)
+	if err != nil {
+		return 0, 0, fmt.Errorf("store: prepare rollup insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range rollupMinutes(samples) {
+		var instance interface{} = nil
+		if r.Instance != "" {
+			instance = r.Instance
+		}
+		if _, err := stmt.ExecContext(ctx,
+			r.hostID, r.Plugin, r.Name, r.Category, r.MetricType,
+			r.Value, r.ValueNum, instance, marshalExtra(r.Extra), marshalValues(r.Values), r.Unit, r.CollectedAt,
+		); err != nil {
+			return 0, 0, fmt.Errorf("store: insert rollup %s/%s: %w", r.Plugin, r.Name, err)
+		}
+		rolled++
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM metrics_recent WHERE collected_at < `+s.ph(1), cutoff)
+	if err != nil {
+		return 0, 0, fmt.Errorf("store: prune recent: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("store: prune recent: %w", err)
+	}
+	return rolled, int(n), nil
+}
+
+// readRecent returns the metrics_recent samples collected before cutoff,
+// oldest first.
+func (s *sqlStore) readRecent(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+}, cutoff time.Time) ([]recentSample, error) {
+	rows, err := q.QueryContext(ctx, `SELECT host_id, plugin, name, category, metric_type, value, value_num,
+			instance, extra, value_list, unit, collected_at
+		FROM metrics_recent
+		WHERE collected_at < `+s.ph(1)+`
+		ORDER BY collected_at, id`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecentRows(rows)
+}
+
+// scanRecentRows reads the rows of PruneRecent's query. Host names are not
+// needed, so only the host id is kept.
+func scanRecentRows(rows *sql.Rows) ([]recentSample, error) {
+	var samples []recentSample
+	for rows.Next() {
+		var (
+			r        recentSample
+			valueNum sql.NullFloat64
+			instance sql.NullString
+			extra    sql.NullString
+			values   sql.NullString
+			unit     sql.NullString
+			at       scanTime
+		)
+		if err := rows.Scan(
+			&r.hostID, &r.Plugin, &r.Name, &r.Category, &r.MetricType, &r.Value, &valueNum,
+			&instance, &extra, &values, &unit, &at,
+		); err != nil {
+			return nil, err
+		}
+		if valueNum.Valid {
+			v := valueNum.Float64
+			r.ValueNum = &v
+		}
+		r.Instance = instance.String
+		r.Extra = unmarshalExtra(extra.String)
+		r.Values = unmarshalValues(values.String)
+		r.Unit = unit.String
+		r.CollectedAt = at.Time
+		samples = append(samples, r)
+	}
+	return samples, rows.Err()
+}
+
+// rollupMinutes aggregates samples, oldest first, into one sample per host,
+// series and minute, stamped with the start of the minute, in the order the
+// minutes first appear.
+func rollupMinutes(samples []recentSample) []recentSample {
+	type bucket struct {
+		last           recentSample
+		count, numeric int
+		sum, min, max  float64
+	}
+	var order []string
+	buckets := make(map[string]*bucket)
+	for _, r := range samples {
+		minute := r.CollectedAt.Truncate(time.Minute)
+		k := strconv.FormatInt(r.hostID, 10) + "\x00" + seriesKey(r.MetricRecord) + "\x00" + strconv.FormatInt(minute.Unix(), 10)
+		b, ok := buckets[k]
+		if !ok {
+			b = &bucket{min: math.Inf(1), max: math.Inf(-1)}
+			buckets[k] = b
+			order = append(order, k)
+		}
+		b.last = r
+		b.count++
+		if r.ValueNum != nil {
+			v := *r.ValueNum
+			b.numeric++
+			b.sum += v
+			b.min = math.Min(b.min, v)
+			b.max = math.Max(b.max, v)
+		}
+	}
+
+	out := make([]recentSample, 0, len(order))
+	for _, k := range order {
+		b := buckets[k]
+		r := b.last
+		extra := make(map[string]interface{}, len(r.Extra)+4)
+		for ek, ev := range r.Extra {
+			extra[ek] = ev
+		}
+		extra["rollup"] = "1m"
+		extra["samples"] = b.count
+		if b.numeric == b.count {
+			avg := round6(b.sum / float64(b.numeric))
+			r.ValueNum = &avg
+			r.Value = strconv.FormatFloat(avg, 'f', -1, 64)
+			extra["min"], extra["max"] = b.min, b.max
+		}
+		r.Extra = extra
+		r.CollectedAt = r.CollectedAt.Truncate(time.Minute)
+		out = append(out, r)
+	}
+	return out
+}
+
+// round6 rounds to six decimals, dropping float noise from averaging.
+func round6(f float64) float64 {
+	return math.Round(f*1e6) / 1e6
+}
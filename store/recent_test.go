@@ -0,0 +1,183 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// probes returns one sample of host's rtt every step in [from, to), with
+// values cycling through 1, 2, 3 and 4.
+func probes(host string, from, to time.Time, step time.Duration) []MetricRecord {
+	var records []MetricRecord
+	for at, i := from, 0; at.Before(to); at, i = at.Add(step), i+1 {
+		records = append(records, sample(host, "rtt", "", []string{"1", "2", "3", "4"}[i%4], at))
+	}
+	return records
+}
+
+func TestPruneRecentKeepsBound(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	retention := 30 * time.Minute
+	step := 5 * time.Second
+
+	// Three hours of 5 second probes, pruned every ten minutes as the daemon does.
+	for now := start.Add(10 * time.Minute); !now.After(start.Add(3 * time.Hour)); now = now.Add(10 * time.Minute) {
+		if err := s.WriteBatchRecent(ctx, probes("edge", now.Add(-10*time.Minute), now, step)); err != nil {
+			t.Fatal(err)
+		}
+		cutoff := now.Add(-retention)
+		if _, _, err := s.PruneRecent(ctx, cutoff); err != nil {
+			t.Fatal(err)
+		}
+		// The buffer never holds more than the retention.
+		if n := countRows(t, s, "metrics_recent"); n > int(retention/step) {
+			t.Fatalf("at %s: %d samples kept, bound is %d", now.Format("15:04"), n, int(retention/step))
+		}
+		var oldest scanTime
+		if err := s.db.QueryRow(`SELECT MIN(collected_at) FROM metrics_recent`).Scan(&oldest); err != nil {
+			t.Fatal(err)
+		}
+		if cutoff.After(start) && oldest.Time.Before(cutoff) {
+			t.Fatalf("at %s: sample of %s kept", now.Format("15:04"), oldest.Time.Format("15:04:05"))
+		}
+	}
+
+	// Everything pruned went to metrics as one sample per minute.
+	if n := countRows(t, s, "metrics"); n != 150 {
+		t.Errorf("%d rollups for 150 minutes", n)
+	}
+	// A second run over the same cutoff has nothing to do.
+	rolled, pruned, err := s.PruneRecent(ctx, start.Add(150*time.Minute))
+	if err != nil || rolled != 0 || pruned != 0 {
+		t.Errorf("pruning again: rolled %d, pruned %d, %v", rolled, pruned, err)
+	}
+}
+
+func TestPruneRecentRollsUpMinutes(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	records := probes("edge", start, start.Add(3*time.Minute), 15*time.Second)
+	for i, v := range []string{"auto", "auto", "forced", "auto", "forced"} {
+		r := sample("edge", "duplex_mode", "eth0", v, start.Add(time.Duration(i)*20*time.Second))
+		r.MetricType, r.ValueNum, r.Extra = "text", nil, map[string]interface{}{"speed": "1G"}
+		records = append(records, r)
+	}
+	if err := s.WriteBatchRecent(ctx, records); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cutoff is rounded down to the minute: 12:02:30 prunes the first two minutes.
+	rolled, pruned, err := s.PruneRecent(ctx, start.Add(2*time.Minute+30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rolled != 4 || pruned != 8+5 {
+		t.Errorf("rolled %d, pruned %d", rolled, pruned)
+	}
+	if n := countRows(t, s, "metrics_recent"); n != 4 {
+		t.Errorf("%d samples left of the third minute", n)
+	}
+
+	rtt, err := s.MetricHistory(ctx, "edge", "local", "rtt", "", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rtt) != 2+4 {
+		t.Fatalf("rtt = %+v", rtt)
+	}
+	for i, r := range rtt[:2] {
+		want := map[string]interface{}{"rollup": "1m", "samples": 4.0, "min": 1.0, "max": 4.0}
+		if r.Value != "2.5" || *r.ValueNum != 2.5 || !r.CollectedAt.Equal(start.Add(time.Duration(i)*time.Minute)) ||
+			!reflect.DeepEqual(r.Extra, want) {
+			t.Errorf("rollup %d = %+v, extra %v", i, r, r.Extra)
+		}
+	}
+
+	// Text series keep the last value of each minute and their extra.
+	mode, err := s.MetricHistory(ctx, "edge", "local", "duplex_mode", "eth0", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range mode {
+		got = append(got, r.CollectedAt.Format("15:04:05")+" "+r.Value)
+		if r.Extra["speed"] != "1G" || r.Extra["rollup"] != "1m" {
+			t.Errorf("extra = %v", r.Extra)
+		}
+		if _, ok := r.Extra["min"]; ok {
+			t.Errorf("min on a text series: %v", r.Extra)
+		}
+	}
+	if want := []string{"12:00:00 forced", "12:01:00 forced"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("duplex_mode = %q, want %q", got, want)
+	}
+}
+
+func TestReadsSpanBothTables(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// An hour of per-minute samples, then ten minutes of 5 second ones.
+	if err := s.WriteBatch(ctx, probes("edge", start, start.Add(time.Hour), time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteBatchRecent(ctx, probes("edge", start.Add(time.Hour), start.Add(70*time.Minute), 5*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// Roll the first five minutes of the buffer up.
+	if _, _, err := s.PruneRecent(ctx, start.Add(65*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// History is one series, in order, across both tables and the rollup boundary.
+	history, err := s.MetricHistory(ctx, "edge", "local", "rtt", "", start.Add(50*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 10 + 5 + 5*12; len(history) != want {
+		t.Fatalf("%d samples, want %d", len(history), want)
+	}
+	for i := 1; i < len(history); i++ {
+		gap := history[i].CollectedAt.Sub(history[i-1].CollectedAt)
+		if gap <= 0 || gap > time.Minute {
+			t.Fatalf("sample %d at %s follows %s", i, history[i].CollectedAt, history[i-1].CollectedAt)
+		}
+	}
+	if last := history[len(history)-1]; !last.CollectedAt.Equal(start.Add(70*time.Minute - 5*time.Second)) {
+		t.Errorf("last sample at %s", last.CollectedAt)
+	}
+
+	// A range straddling the tables, limited.
+	span, err := s.MetricRange(ctx, "edge", "local", "rtt", "", start.Add(63*time.Minute), start.Add(66*time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(span) != 10 || !span[0].CollectedAt.Equal(start.Add(63*time.Minute)) || span[2].CollectedAt.Sub(span[1].CollectedAt) != time.Minute {
+		t.Errorf("range = %d samples from %s", len(span), span[0].CollectedAt)
+	}
+
+	// The latest sample is the buffer's.
+	latest, err := s.LatestMetrics(ctx, "edge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 1 || !latest[0].CollectedAt.Equal(start.Add(70*time.Minute-5*time.Second)) {
+		t.Errorf("latest = %+v", latest)
+	}
+
+	// QueryMetrics merges newest first.
+	newest, err := s.QueryMetrics(ctx, MetricFilter{HostKey: "edge", Name: "rtt", Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newest) != 3 || !newest[0].CollectedAt.After(newest[1].CollectedAt) || !newest[1].CollectedAt.After(newest[2].CollectedAt) {
+		t.Errorf("query = %+v", newest)
+	}
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// pruneBatch is how many metrics rows PruneMetrics deletes per statement on
+// SQLite and MySQL, so the table is not locked, nor the WAL grown, for the
+// whole expiry.
+const pruneBatch = 10000
+
+// PruneMetrics deletes the metrics rows collected before olderThan and
+// returns how many it deleted. SQLite and MySQL delete pruneBatch rows per
+// statement, each its own transaction, so writers get in between; PostgreSQL,
+// whose writers are not blocked by a long DELETE, uses a single statement.
+// Hosts, interfaces, links and metrics_recent, which PruneRecent keeps
+// bounded, are left alone.
+func (s *sqlStore) PruneMetrics(ctx context.Context, olderThan time.Time) (int64, error) {
+	var q string
+	switch s.d {
+	case dialectPostgres:
+		q = `DELETE FROM metrics WHERE collected_at < $1`
+	case dialectMySQL:
+		q = `DELETE FROM metrics WHERE collected_at < ? LIMIT ` + strconv.Itoa(pruneBatch)
+	default: // SQLite, usually built without DELETE ... LIMIT
+		q = `DELETE FROM metrics WHERE id IN (
+			SELECT id FROM metrics WHERE collected_at < ? LIMIT ` + strconv.Itoa(pruneBatch) + `)`
+	}
+
+	var deleted int64
+	for {
+		res, err := s.db.ExecContext(ctx, q, olderThan)
+		if err != nil {
+			return deleted, fmt.Errorf("store: prune metrics: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, fmt.Errorf("store: prune metrics: %w", err)
+		}
+		deleted += n
+		if s.d == dialectPostgres || n < pruneBatch {
+			return deleted, nil
+		}
+	}
+}
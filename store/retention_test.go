@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPruneMetrics(t *testing.T) {
+	cutoff := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, old := range []int{0, 1, pruneBatch - 1, pruneBatch, pruneBatch + 1} {
+		t.Run(fmt.Sprint(old), func(t *testing.T) {
+			s := openTestStore(t)
+			ctx := context.Background()
+
+			records := make([]MetricRecord, 0, old+2)
+			for i := 0; i < old; i++ {
+				records = append(records, sample("db", "load_1", "", "0.5", cutoff.Add(-time.Duration(i+1)*time.Second)))
+			}
+			// A sample at the cutoff and one after it are kept.
+			records = append(records,
+				sample("db", "load_1", "", "0.6", cutoff),
+				sample("db", "load_1", "", "0.7", cutoff.Add(time.Minute)))
+			if err := s.WriteBatch(ctx, records); err != nil {
+				t.Fatal(err)
+			}
+			speed := int64(1000)
+			if err := s.UpsertInterfaces(ctx, []InterfaceRecord{{HostKey: "db", IfIndex: 1, Name: "eth0", Speed: &speed}}); err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := s.PruneMetrics(ctx, cutoff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(old) {
+				t.Errorf("PruneMetrics = %d, want %d", n, old)
+			}
+			if got := countRows(t, s, "metrics"); got != 2 {
+				t.Errorf("%d metrics rows left, want 2", got)
+			}
+			if got := countRows(t, s, "hosts"); got != 1 {
+				t.Errorf("%d hosts rows left, want 1", got)
+			}
+			if got := countRows(t, s, "interfaces"); got != 1 {
+				t.Errorf("%d interfaces rows left, want 1", got)
+			}
+		})
+	}
+}
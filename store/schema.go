@@ -6,15 +6,18 @@ const (
 	dialectSQLite dialect = iota
 	dialectPostgres
 	dialectMySQL
+	dialectClickHouse
 )
 
 // migration represents a single, ordered schema change.
 // Migrations are applied exactly once and recorded in schema_migrations.
-// To add a new migration: append an entry to the migrations() slice.
+// To add a new migration: append an entry to the migrations() slice, with the
+// down statements that undo it.
 type migration struct {
 	version     int
 	description string
 	up          []string // SQL statements to apply, run in a single transaction
+	down        []string // SQL statements that undo up, run in a single transaction; nil when it cannot be undone
 }
 
 // migrations returns all migrations in ascending version order for the given dialect.
@@ -25,21 +28,61 @@ func migrations(d dialect) []migration {
 			version:     1,
 			description: "initial schema: hosts and metrics tables",
 			up:          v1Schema(d),
+			// No down: undoing v1 would drop every table.
 		},
 		{
 			version:     2,
 			description: "add extra JSON column to metrics",
 			up:          v2Schema(d),
+			down:        v2Down(d),
 		},
 		{
 			version:     3,
 			description: "add instance column to metrics; add interfaces entity table",
 			up:          v3Schema(d),
+			down:        v3Down(d),
 		},
 		{
 			version:     4,
 			description: "add data_flows_raw table for IP flow collection",
 			up:          v4Schema(d),
+			down:        v4Down(d),
+		},
+		{
+			version:     5,
+			description: "add links table for LLDP/CDP neighbors",
+			up:          v5Schema(d),
+			down:        v5Down(d),
+		},
+		{
+			version:     6,
+			description: "add full-text index over metric values and extra",
+			up:          v6Schema(d),
+			down:        v6Down(d),
+		},
+		{
+			version:     7,
+			description: "add metrics_recent ring buffer for sub-minute samples",
+			up:          v7Schema(d),
+			down:        v7Down(d),
+		},
+		{
+			version:     8,
+			description: "add covering index for the metric catalog",
+			up:          v8Schema(d),
+			down:        v8Down(d),
+		},
+		{
+			version:     9,
+			description: "add value_list JSON column for multi-value metrics",
+			up:          v9Schema(d),
+			down:        v9Down(d),
+		},
+		{
+			version:     10,
+			description: "add unit column to metrics",
+			up:          v10Schema(d),
+			down:        v10Down(d),
 		},
 	}
 }
@@ -61,6 +104,12 @@ func schemaMigrationsDDL(d dialect) string {
 			description VARCHAR(255) NOT NULL DEFAULT '',
 			applied_at  DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP
 		)`
+	case dialectClickHouse:
+		return `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version     UInt32,
+			description String DEFAULT '',
+			applied_at  DateTime DEFAULT now()
+		) ENGINE = MergeTree ORDER BY version`
 	default: // SQLite
 		return `CREATE TABLE IF NOT EXISTS schema_migrations (
 			version     INTEGER PRIMARY KEY,
@@ -136,6 +185,42 @@ func v1Schema(d dialect) []string {
 			"CREATE INDEX idx_metrics_host_name ON metrics (host_id, plugin, name(100), collected_at)",
 		}
 
+	case dialectClickHouse:
+		// Notes:
+		//   - ClickHouse has no auto-increment nor per-row upsert. A host's id
+		//     is derived from its key (see clickhouseHostID) and ensureHost
+		//     inserts a new hosts row on each first write of a run; the
+		//     ReplacingMergeTree keeps the one with the latest last_seen when
+		//     parts merge, and reads see only that one through the session's
+		//     final setting.
+		//   - A metrics row's id is its insert time in nanoseconds, which only
+		//     orders samples sharing a timestamp, as the other dialects' ids do.
+		//   - Samples are sorted by (host_id, name, collected_at), what reads of
+		//     a host's series filter and order on, and partitioned by month.
+		return []string{
+			`CREATE TABLE IF NOT EXISTS hosts (
+				id         Int64,
+				key        String,
+				name       String DEFAULT '',
+				address    String DEFAULT '',
+				first_seen DateTime64(3) DEFAULT now64(3),
+				last_seen  DateTime64(3) DEFAULT now64(3)
+			) ENGINE = ReplacingMergeTree(last_seen) ORDER BY key`,
+			`CREATE TABLE IF NOT EXISTS metrics (
+				id           Int64 DEFAULT toUnixTimestamp64Nano(now64(9)),
+				host_id      Int64,
+				plugin       LowCardinality(String) DEFAULT '',
+				name         String DEFAULT '',
+				category     LowCardinality(String) DEFAULT '',
+				metric_type  LowCardinality(String) DEFAULT '',
+				value        String DEFAULT '',
+				value_num    Nullable(Float64),
+				collected_at DateTime64(3) DEFAULT now64(3)
+			) ENGINE = MergeTree
+			PARTITION BY toYYYYMM(collected_at)
+			ORDER BY (host_id, name, collected_at)`,
+		}
+
 	default: // SQLite
 		return []string{
 			`CREATE TABLE IF NOT EXISTS hosts (
@@ -171,6 +256,8 @@ func v2Schema(d dialect) []string {
 		return []string{`ALTER TABLE metrics ADD COLUMN extra JSONB`}
 	case dialectMySQL:
 		return []string{`ALTER TABLE metrics ADD COLUMN extra JSON`}
+	case dialectClickHouse:
+		return []string{`ALTER TABLE metrics ADD COLUMN extra Nullable(String)`}
 	default: // SQLite
 		return []string{`ALTER TABLE metrics ADD COLUMN extra TEXT`}
 	}
@@ -222,6 +309,25 @@ func v3Schema(d dialect) []string {
 				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
 			"CREATE INDEX idx_interfaces_host ON interfaces (host_id)",
 		}
+	case dialectClickHouse:
+		// One row per (host, ifIndex) once parts merge; an upsert is an insert
+		// of the row with a later last_seen.
+		return []string{
+			`ALTER TABLE metrics ADD COLUMN instance Nullable(String)`,
+			`CREATE TABLE IF NOT EXISTS interfaces (
+				host_id      Int64,
+				if_index     Int32,
+				name         String DEFAULT '',
+				alias        String DEFAULT '',
+				type         Int32 DEFAULT 0,
+				speed        Nullable(Int64),
+				mac_address  String DEFAULT '',
+				admin_status String DEFAULT '',
+				oper_status  String DEFAULT '',
+				first_seen   DateTime64(3) DEFAULT now64(3),
+				last_seen    DateTime64(3) DEFAULT now64(3)
+			) ENGINE = ReplacingMergeTree(last_seen) ORDER BY (host_id, if_index)`,
+		}
 	default: // SQLite
 		return []string{
 			`ALTER TABLE metrics ADD COLUMN instance TEXT`,
@@ -272,6 +378,17 @@ func v4Schema(d dialect) []string {
 				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
 			"CREATE INDEX idx_data_flows_time ON data_flows_raw (host_id, collected_at)",
 		}
+	case dialectClickHouse:
+		return []string{
+			`CREATE TABLE IF NOT EXISTS data_flows_raw (
+				host_id             Int64,
+				flow_type           LowCardinality(String),
+				payload             String,
+				collected_at        DateTime64(3) DEFAULT now64(3)
+			) ENGINE = MergeTree
+			PARTITION BY toYYYYMM(collected_at)
+			ORDER BY (host_id, collected_at)`,
+		}
 	default: // SQLite
 		return []string{
 			`CREATE TABLE IF NOT EXISTS data_flows_raw (
@@ -285,3 +402,344 @@ func v4Schema(d dialect) []string {
 		}
 	}
 }
+
+// v5Schema creates the links table: the LLDP/CDP neighbors seen on each host's
+// ports. One row per (host, protocol, local port, remote chassis, remote port);
+// last_seen tells current neighbors from ones that have gone away.
+func v5Schema(d dialect) []string {
+	switch d {
+	case dialectPostgres:
+		return []string{
+			`CREATE TABLE IF NOT EXISTS links (
+				id                 BIGSERIAL PRIMARY KEY,
+				host_id            BIGINT NOT NULL REFERENCES hosts(id),
+				protocol           TEXT NOT NULL,
+				local_if_index     INTEGER NOT NULL DEFAULT 0,
+				local_port         TEXT NOT NULL DEFAULT '',
+				remote_chassis_id  TEXT NOT NULL DEFAULT '',
+				remote_port        TEXT NOT NULL DEFAULT '',
+				remote_port_desc   TEXT NOT NULL DEFAULT '',
+				remote_sys_name    TEXT NOT NULL DEFAULT '',
+				remote_address     TEXT NOT NULL DEFAULT '',
+				first_seen         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				last_seen          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				UNIQUE(host_id, protocol, local_port, remote_chassis_id, remote_port)
+			)`,
+			`CREATE INDEX idx_links_host ON links (host_id)`,
+		}
+	case dialectMySQL:
+		// The unique key's VARCHAR lengths keep it under InnoDB's 3072-byte limit.
+		return []string{
+			"CREATE TABLE IF NOT EXISTS links (" +
+				"  id                 BIGINT AUTO_INCREMENT PRIMARY KEY," +
+				"  host_id            BIGINT NOT NULL," +
+				"  protocol           VARCHAR(10)  NOT NULL," +
+				"  local_if_index     INT NOT NULL DEFAULT 0," +
+				"  local_port         VARCHAR(128) NOT NULL DEFAULT ''," +
+				"  remote_chassis_id  VARCHAR(128) NOT NULL DEFAULT ''," +
+				"  remote_port        VARCHAR(128) NOT NULL DEFAULT ''," +
+				"  remote_port_desc   VARCHAR(255) NOT NULL DEFAULT ''," +
+				"  remote_sys_name    VARCHAR(255) NOT NULL DEFAULT ''," +
+				"  remote_address     VARCHAR(64)  NOT NULL DEFAULT ''," +
+				"  first_seen         DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP," +
+				"  last_seen          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP," +
+				"  CONSTRAINT fk_links_host FOREIGN KEY (host_id) REFERENCES hosts(id)," +
+				"  UNIQUE KEY uk_links (host_id, protocol, local_port, remote_chassis_id, remote_port)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+			"CREATE INDEX idx_links_host ON links (host_id)",
+		}
+	case dialectClickHouse:
+		return []string{
+			`CREATE TABLE IF NOT EXISTS links (
+				host_id            Int64,
+				protocol           String,
+				local_if_index     Int32 DEFAULT 0,
+				local_port         String DEFAULT '',
+				remote_chassis_id  String DEFAULT '',
+				remote_port        String DEFAULT '',
+				remote_port_desc   String DEFAULT '',
+				remote_sys_name    String DEFAULT '',
+				remote_address     String DEFAULT '',
+				first_seen         DateTime64(3) DEFAULT now64(3),
+				last_seen          DateTime64(3) DEFAULT now64(3)
+			) ENGINE = ReplacingMergeTree(last_seen)
+			ORDER BY (host_id, protocol, local_port, remote_chassis_id, remote_port)`,
+		}
+	default: // SQLite
+		return []string{
+			`CREATE TABLE IF NOT EXISTS links (
+				id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+				host_id            INTEGER NOT NULL REFERENCES hosts(id),
+				protocol           TEXT NOT NULL,
+				local_if_index     INTEGER NOT NULL DEFAULT 0,
+				local_port         TEXT NOT NULL DEFAULT '',
+				remote_chassis_id  TEXT NOT NULL DEFAULT '',
+				remote_port        TEXT NOT NULL DEFAULT '',
+				remote_port_desc   TEXT NOT NULL DEFAULT '',
+				remote_sys_name    TEXT NOT NULL DEFAULT '',
+				remote_address     TEXT NOT NULL DEFAULT '',
+				first_seen         DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				last_seen          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE(host_id, protocol, local_port, remote_chassis_id, remote_port)
+			)`,
+			`CREATE INDEX idx_links_host ON links (host_id)`,
+		}
+	}
+}
+
+// v6Schema adds the full-text index SearchMetrics narrows its scans with.
+// SQLite gets an FTS5 table over metrics kept in sync by triggers, Postgres a
+// GIN index on a tsvector expression, and MySQL a FULLTEXT index, which needs
+// a stored text copy of the JSON extra column. The expressions here must match
+// the ones in ftsCondition for the indexes to be used. ClickHouse gets a token
+// bloom filter on value, which lets its LIKE search skip granules.
+func v6Schema(d dialect) []string {
+	switch d {
+	case dialectPostgres:
+		return []string{
+			`CREATE INDEX idx_metrics_fts ON metrics USING GIN (to_tsvector('simple', value || ' ' || COALESCE(extra::text, '')))`,
+		}
+	case dialectMySQL:
+		return []string{
+			"ALTER TABLE metrics ADD COLUMN extra_text LONGTEXT AS (CAST(extra AS CHAR)) STORED",
+			"CREATE FULLTEXT INDEX ft_metrics_search ON metrics (value, extra_text)",
+		}
+	case dialectClickHouse:
+		return []string{
+			`ALTER TABLE metrics ADD INDEX idx_metrics_fts value TYPE tokenbf_v1(32768, 3, 0) GRANULARITY 4`,
+		}
+	default: // SQLite
+		return []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS metrics_fts USING fts5(value, extra, content='metrics', content_rowid='id')`,
+			`CREATE TRIGGER metrics_fts_insert AFTER INSERT ON metrics BEGIN
+				INSERT INTO metrics_fts(rowid, value, extra) VALUES (new.id, new.value, new.extra);
+			END`,
+			`CREATE TRIGGER metrics_fts_delete AFTER DELETE ON metrics BEGIN
+				INSERT INTO metrics_fts(metrics_fts, rowid, value, extra) VALUES ('delete', old.id, old.value, old.extra);
+			END`,
+			`CREATE TRIGGER metrics_fts_update AFTER UPDATE ON metrics BEGIN
+				INSERT INTO metrics_fts(metrics_fts, rowid, value, extra) VALUES ('delete', old.id, old.value, old.extra);
+				INSERT INTO metrics_fts(rowid, value, extra) VALUES (new.id, new.value, new.extra);
+			END`,
+			`INSERT INTO metrics_fts(metrics_fts) VALUES ('rebuild')`,
+		}
+	}
+}
+
+// v7Schema creates metrics_recent, the ring buffer WriteBatchRecent writes
+// sub-minute samples to. It has the columns of metrics but only the indexes
+// reads by host and PruneRecent's scan by time need, and no full-text index.
+func v7Schema(d dialect) []string {
+	switch d {
+	case dialectPostgres:
+		return []string{
+			`CREATE TABLE IF NOT EXISTS metrics_recent (
+				id           BIGSERIAL PRIMARY KEY,
+				host_id      BIGINT NOT NULL REFERENCES hosts(id),
+				plugin       TEXT NOT NULL DEFAULT '',
+				name         TEXT NOT NULL DEFAULT '',
+				category     TEXT NOT NULL DEFAULT '',
+				metric_type  TEXT NOT NULL DEFAULT '',
+				value        TEXT NOT NULL DEFAULT '',
+				value_num    DOUBLE PRECISION,
+				instance     TEXT,
+				extra        JSONB,
+				collected_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)`,
+			`CREATE INDEX idx_metrics_recent_host_time ON metrics_recent (host_id, collected_at DESC)`,
+			`CREATE INDEX idx_metrics_recent_time ON metrics_recent (collected_at)`,
+		}
+	case dialectMySQL:
+		return []string{
+			"CREATE TABLE IF NOT EXISTS metrics_recent (" +
+				"  id           BIGINT AUTO_INCREMENT PRIMARY KEY," +
+				"  host_id      BIGINT NOT NULL," +
+				"  plugin       VARCHAR(100) NOT NULL DEFAULT ''," +
+				"  name         VARCHAR(255) NOT NULL DEFAULT ''," +
+				"  category     VARCHAR(100) NOT NULL DEFAULT ''," +
+				"  metric_type  VARCHAR(50)  NOT NULL DEFAULT ''," +
+				"  value        TEXT         NOT NULL," +
+				"  value_num    DOUBLE," +
+				"  instance     VARCHAR(255)," +
+				"  extra        JSON," +
+				"  collected_at DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP," +
+				"  CONSTRAINT fk_metrics_recent_host FOREIGN KEY (host_id) REFERENCES hosts(id)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+			"CREATE INDEX idx_metrics_recent_host_time ON metrics_recent (host_id, collected_at)",
+			"CREATE INDEX idx_metrics_recent_time ON metrics_recent (collected_at)",
+		}
+	case dialectClickHouse:
+		return []string{
+			`CREATE TABLE IF NOT EXISTS metrics_recent (
+				id           Int64 DEFAULT toUnixTimestamp64Nano(now64(9)),
+				host_id      Int64,
+				plugin       LowCardinality(String) DEFAULT '',
+				name         String DEFAULT '',
+				category     LowCardinality(String) DEFAULT '',
+				metric_type  LowCardinality(String) DEFAULT '',
+				value        String DEFAULT '',
+				value_num    Nullable(Float64),
+				instance     Nullable(String),
+				extra        Nullable(String),
+				collected_at DateTime64(3) DEFAULT now64(3)
+			) ENGINE = MergeTree ORDER BY (host_id, collected_at)`,
+		}
+	default: // SQLite
+		return []string{
+			`CREATE TABLE IF NOT EXISTS metrics_recent (
+				id           INTEGER PRIMARY KEY AUTOINCREMENT,
+				host_id      INTEGER NOT NULL REFERENCES hosts(id),
+				plugin       TEXT NOT NULL DEFAULT '',
+				name         TEXT NOT NULL DEFAULT '',
+				category     TEXT NOT NULL DEFAULT '',
+				metric_type  TEXT NOT NULL DEFAULT '',
+				value        TEXT NOT NULL DEFAULT '',
+				value_num    REAL,
+				instance     TEXT,
+				extra        TEXT,
+				collected_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX idx_metrics_recent_host_time ON metrics_recent (host_id, collected_at DESC)`,
+			`CREATE INDEX idx_metrics_recent_time ON metrics_recent (collected_at)`,
+		}
+	}
+}
+
+// v8Schema adds the index MetricCatalog groups by: with every grouped column
+// and collected_at in it, a host's catalog is read from the index alone.
+// ClickHouse reads a host's rows through the metrics sorting key and needs
+// no index.
+func v8Schema(d dialect) []string {
+	switch d {
+	case dialectClickHouse:
+		return []string{}
+	case dialectMySQL:
+		return []string{
+			"CREATE INDEX idx_metrics_catalog ON metrics " +
+				"(host_id, plugin, name(100), category, metric_type, instance(100), collected_at)",
+		}
+	default: // SQLite, Postgres
+		return []string{
+			`CREATE INDEX idx_metrics_catalog ON metrics (host_id, plugin, name, category, metric_type, instance, collected_at)`,
+		}
+	}
+}
+
+// v9Schema adds value_list to metrics and metrics_recent: the values of a
+// multi-value metric, such as a histogram, as a JSON array. It is NULL for
+// single-value metrics.
+func v9Schema(d dialect) []string {
+	var typ string
+	switch d {
+	case dialectPostgres:
+		typ = "JSONB"
+	case dialectMySQL:
+		typ = "JSON"
+	case dialectClickHouse:
+		typ = "Nullable(String)"
+	default: // SQLite
+		typ = "TEXT"
+	}
+	return []string{
+		`ALTER TABLE metrics ADD COLUMN value_list ` + typ,
+		`ALTER TABLE metrics_recent ADD COLUMN value_list ` + typ,
+	}
+}
+
+// v10Schema adds unit to metrics and metrics_recent: what a value is measured
+// in, such as "bytes" or "s". Existing rows get an empty unit: unknown.
+func v10Schema(d dialect) []string {
+	typ := "TEXT NOT NULL DEFAULT ''"
+	switch d {
+	case dialectMySQL:
+		typ = "VARCHAR(20) NOT NULL DEFAULT ''"
+	case dialectClickHouse:
+		typ = "LowCardinality(String) DEFAULT ''"
+	}
+	return []string{
+		`ALTER TABLE metrics ADD COLUMN unit ` + typ,
+		`ALTER TABLE metrics_recent ADD COLUMN unit ` + typ,
+	}
+}
+
+// The vNDown functions undo their migration. Each runs after the ones of
+// every later migration, so nothing it drops is still used by a later index,
+// trigger or table. Dropping a column or table discards its data.
+// SQLite has DROP COLUMN since 3.35; the bundled driver is newer.
+
+// v2Down drops the extra column.
+func v2Down(d dialect) []string {
+	return []string{`ALTER TABLE metrics DROP COLUMN extra`}
+}
+
+// v3Down drops the interfaces table and the instance column.
+func v3Down(d dialect) []string {
+	return []string{
+		`DROP TABLE interfaces`,
+		`ALTER TABLE metrics DROP COLUMN instance`,
+	}
+}
+
+// v4Down drops the data_flows_raw table.
+func v4Down(d dialect) []string {
+	return []string{`DROP TABLE data_flows_raw`}
+}
+
+// v5Down drops the links table.
+func v5Down(d dialect) []string {
+	return []string{`DROP TABLE links`}
+}
+
+// v6Down drops the full-text index and what keeps it up to date.
+func v6Down(d dialect) []string {
+	switch d {
+	case dialectPostgres:
+		return []string{`DROP INDEX idx_metrics_fts`}
+	case dialectMySQL:
+		return []string{
+			"DROP INDEX ft_metrics_search ON metrics",
+			"ALTER TABLE metrics DROP COLUMN extra_text",
+		}
+	case dialectClickHouse:
+		return []string{`ALTER TABLE metrics DROP INDEX idx_metrics_fts`}
+	default: // SQLite
+		return []string{
+			`DROP TRIGGER metrics_fts_insert`,
+			`DROP TRIGGER metrics_fts_delete`,
+			`DROP TRIGGER metrics_fts_update`,
+			`DROP TABLE metrics_fts`,
+		}
+	}
+}
+
+// v7Down drops metrics_recent, with the samples not yet rolled up.
+func v7Down(d dialect) []string {
+	return []string{`DROP TABLE metrics_recent`}
+}
+
+// v8Down drops the metric catalog index.
+func v8Down(d dialect) []string {
+	switch d {
+	case dialectMySQL:
+		return []string{"DROP INDEX idx_metrics_catalog ON metrics"}
+	case dialectClickHouse:
+		return []string{}
+	}
+	return []string{`DROP INDEX idx_metrics_catalog`}
+}
+
+// v9Down drops value_list from metrics and metrics_recent.
+func v9Down(d dialect) []string {
+	return []string{
+		`ALTER TABLE metrics DROP COLUMN value_list`,
+		`ALTER TABLE metrics_recent DROP COLUMN value_list`,
+	}
+}
+
+// v10Down drops unit from metrics and metrics_recent.
+func v10Down(d dialect) []string {
+	return []string{
+		`ALTER TABLE metrics DROP COLUMN unit`,
+		`ALTER TABLE metrics_recent DROP COLUMN unit`,
+	}
+}
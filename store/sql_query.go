@@ -0,0 +1,504 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// quotedKey returns the hosts.key column name for the dialect.
+// `key` is a reserved word in MySQL and must be back-tick quoted.
+func (s *sqlStore) quotedKey() string {
+	if s.d == dialectMySQL {
+		return "`key`"
+	}
+	return "key"
+}
+
+// lookupHostID returns the id for a host key, or ok=false when the key is unknown.
+func (s *sqlStore) lookupHostID(ctx context.Context, key string) (int64, bool, error) {
+	s.mu.Lock()
+	id, cached := s.hostCache[key]
+	s.mu.Unlock()
+	if cached {
+		return id, true, nil
+	}
+
+	q := "SELECT id FROM hosts WHERE " + s.quotedKey() + " = " + s.ph(1)
+	err := s.db.QueryRowContext(ctx, q, key).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("store: query host id %q: %w", key, err)
+	}
+	return id, true, nil
+}
+
+// LatestMetrics returns the most recent sample of every (plugin, name, instance)
+// recorded for a host, in metrics or metrics_recent. An unknown host yields an
+// empty slice.
+func (s *sqlStore) LatestMetrics(ctx context.Context, hostKey string) ([]MetricRecord, error) {
+	hostID, ok, err := s.lookupHostID(ctx, hostKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []MetricRecord{}, nil
+	}
+
+	latest := []MetricRecord{}
+	index := make(map[string]int)
+	for _, table := range metricTables {
+		records, err := s.latestIn(ctx, table, hostID)
+		if err != nil {
+			return nil, fmt.Errorf("store: latest metrics %q: %w", hostKey, err)
+		}
+		for _, r := range records {
+			k := seriesKey(r)
+			if i, seen := index[k]; !seen {
+				index[k] = len(latest)
+				latest = append(latest, r)
+			} else if r.CollectedAt.After(latest[i].CollectedAt) {
+				latest[i] = r
+			}
+		}
+	}
+	sort.SliceStable(latest, func(i, j int) bool {
+		a, b := latest[i], latest[j]
+		if a.Plugin != b.Plugin {
+			return a.Plugin < b.Plugin
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Instance < b.Instance
+	})
+	return latest, nil
+}
+
+// metricTables are the tables samples are read from: metrics, then the
+// metrics_recent ring buffer.
+var metricTables = []string{"metrics", "metrics_recent"}
+
+// seriesKey identifies the series of a sample within its host.
+func seriesKey(r MetricRecord) string {
+	return r.Plugin + "\x00" + r.Name + "\x00" + r.Instance
+}
+
+// latestIn returns the most recent sample per series of a host in table.
+func (s *sqlStore) latestIn(ctx context.Context, table string, hostID int64) ([]MetricRecord, error) {
+	q := `SELECT h.` + s.quotedKey() + `, h.name, h.address,
+			m.plugin, m.name, m.category, m.metric_type, m.value, m.value_num,
+			m.instance, m.extra, m.value_list, m.unit, m.collected_at
+		FROM ` + table + ` m
+		JOIN hosts h ON h.id = m.host_id
+		JOIN (
+			SELECT plugin, name, COALESCE(instance, '') AS inst, MAX(collected_at) AS latest
+			FROM ` + table + `
+			WHERE host_id = ` + s.ph(1) + `
+			GROUP BY plugin, name, COALESCE(instance, '')
+		) l ON l.plugin = m.plugin AND l.name = m.name
+			AND l.inst = COALESCE(m.instance, '') AND l.latest = m.collected_at
+		WHERE m.host_id = ` + s.ph(2) + `
+		ORDER BY m.plugin, m.name, m.instance, m.id DESC`
+
+	rows, err := s.db.QueryContext(ctx, q, hostID, hostID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records, err := scanMetricRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Two rows can share a timestamp when a batch was written twice;
+	// keep only the first (highest id) per series.
+	seen := make(map[string]bool, len(records))
+	latest := make([]MetricRecord, 0, len(records))
+	for _, r := range records {
+		k := seriesKey(r)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		latest = append(latest, r)
+	}
+	return latest, nil
+}
+
+// queryBoth runs the query built by q for metrics and for metrics_recent and
+// returns the rows of both, oldest first.
+func (s *sqlStore) queryBoth(ctx context.Context, q func(table string) string, args ...interface{}) ([]MetricRecord, error) {
+	records := []MetricRecord{}
+	for _, table := range metricTables {
+		rows, err := s.db.QueryContext(ctx, q(table), args...)
+		if err != nil {
+			return nil, err
+		}
+		scanned, err := scanMetricRows(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, scanned...)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].CollectedAt.Before(records[j].CollectedAt) })
+	return records, nil
+}
+
+// scanMetricRows reads rows produced by a metrics query selecting, in order:
+// host key, host name, host address, plugin, name, category, metric_type,
+// value, value_num, instance, extra, value_list, unit, collected_at.
+func scanMetricRows(rows *sql.Rows) ([]MetricRecord, error) {
+	var records []MetricRecord
+	for rows.Next() {
+		var (
+			r        MetricRecord
+			valueNum sql.NullFloat64
+			instance sql.NullString
+			extra    sql.NullString
+			values   sql.NullString
+			unit     sql.NullString
+			at       scanTime
+		)
+		if err := rows.Scan(
+			&r.HostKey, &r.HostName, &r.HostAddress,
+			&r.Plugin, &r.Name, &r.Category, &r.MetricType, &r.Value, &valueNum,
+			&instance, &extra, &values, &unit, &at,
+		); err != nil {
+			return nil, err
+		}
+		if valueNum.Valid {
+			v := valueNum.Float64
+			r.ValueNum = &v
+		}
+		r.Instance = instance.String
+		r.Extra = unmarshalExtra(extra.String)
+		r.Values = unmarshalValues(values.String)
+		r.Unit = unit.String
+		r.CollectedAt = at.Time
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// unmarshalExtra decodes the JSON extra column. Empty or invalid JSON yields nil.
+func unmarshalExtra(raw string) map[string]interface{} {
+	if raw == "" {
+		return nil
+	}
+	var extra map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &extra); err != nil {
+		return nil
+	}
+	return extra
+}
+
+// scanTime is a sql.Scanner for timestamp columns that copes with each
+// driver's representation: time.Time (pq, sqlite), or text/bytes when the
+// MySQL DSN lacks parseTime=true.
+type scanTime struct {
+	Time time.Time
+}
+
+// timeLayouts lists the textual timestamp formats seen across drivers.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+func (t *scanTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	default:
+		return fmt.Errorf("unsupported timestamp type %T", src)
+	}
+}
+
+func (t *scanTime) parse(s string) error {
+	// Go's time.String() output may carry a monotonic clock suffix ("m=+0.12").
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	for _, layout := range timeLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognised timestamp %q", s)
+}
+
+// GetInterfaces returns the interface entity rows for a host ordered by ifIndex.
+// An unknown host yields an empty slice.
+func (s *sqlStore) GetInterfaces(ctx context.Context, hostKey string) ([]InterfaceRecord, error) {
+	hostID, ok, err := s.lookupHostID(ctx, hostKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []InterfaceRecord{}, nil
+	}
+
+	q := `SELECT h.` + s.quotedKey() + `, h.name, h.address,
+			i.if_index, i.name, i.alias, i.type, i.speed, i.mac_address,
+			i.admin_status, i.oper_status, i.first_seen, i.last_seen
+		FROM interfaces i
+		JOIN hosts h ON h.id = i.host_id
+		WHERE i.host_id = ` + s.ph(1) + `
+		ORDER BY i.if_index`
+
+	rows, err := s.db.QueryContext(ctx, q, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("store: interfaces %q: %w", hostKey, err)
+	}
+	defer rows.Close()
+
+	records := []InterfaceRecord{}
+	for rows.Next() {
+		var (
+			r                   InterfaceRecord
+			speed               sql.NullInt64
+			firstSeen, lastSeen scanTime
+		)
+		if err := rows.Scan(
+			&r.HostKey, &r.HostName, &r.HostAddress,
+			&r.IfIndex, &r.Name, &r.Alias, &r.Type, &speed, &r.MACAddress,
+			&r.AdminStatus, &r.OperStatus, &firstSeen, &lastSeen,
+		); err != nil {
+			return nil, fmt.Errorf("store: interfaces %q: %w", hostKey, err)
+		}
+		if speed.Valid {
+			v := speed.Int64
+			r.Speed = &v
+		}
+		r.FirstSeen = firstSeen.Time
+		r.LastSeen = lastSeen.Time
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: interfaces %q: %w", hostKey, err)
+	}
+	return records, nil
+}
+
+// MetricHistory returns the samples of one series for a host collected at or
+// after since, oldest first, from metrics and metrics_recent. An unknown host
+// yields an empty slice.
+func (s *sqlStore) MetricHistory(ctx context.Context, hostKey, plugin, name, instance string, since time.Time) ([]MetricRecord, error) {
+	hostID, ok, err := s.lookupHostID(ctx, hostKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []MetricRecord{}, nil
+	}
+
+	q := func(table string) string {
+		return `SELECT h.` + s.quotedKey() + `, h.name, h.address,
+			m.plugin, m.name, m.category, m.metric_type, m.value, m.value_num,
+			m.instance, m.extra, m.value_list, m.unit, m.collected_at
+		FROM ` + table + ` m
+		JOIN hosts h ON h.id = m.host_id
+		WHERE m.host_id = ` + s.ph(1) + `
+			AND m.plugin = ` + s.ph(2) + `
+			AND m.name = ` + s.ph(3) + `
+			AND COALESCE(m.instance, '') = ` + s.ph(4) + `
+			AND m.collected_at >= ` + s.ph(5) + `
+		ORDER BY m.collected_at, m.id`
+	}
+
+	records, err := s.queryBoth(ctx, q, hostID, plugin, name, instance, since)
+	if err != nil {
+		return nil, fmt.Errorf("store: metric history %q %s/%s: %w", hostKey, plugin, name, err)
+	}
+	return records, nil
+}
+
+// MetricRange returns the samples of a host's metric collected in [from, to),
+// oldest first, from metrics and metrics_recent, using idx_metrics_host_name.
+// An empty instance selects every instance. Each table is asked for at most
+// limit rows (default 100) and the merged result is cut to limit. An unknown
+// host yields an empty slice.
+func (s *sqlStore) MetricRange(ctx context.Context, hostKey, plugin, name, instance string, from, to time.Time, limit int) ([]MetricRecord, error) {
+	hostID, ok, err := s.lookupHostID(ctx, hostKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []MetricRecord{}, nil
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	args := []interface{}{hostID, plugin, name, from, to}
+	cond := ""
+	if instance != "" {
+		args = append(args, instance)
+		cond = ` AND m.instance = ` + s.ph(len(args))
+	}
+	q := func(table string) string {
+		return `SELECT h.` + s.quotedKey() + `, h.name, h.address,
+			m.plugin, m.name, m.category, m.metric_type, m.value, m.value_num,
+			m.instance, m.extra, m.value_list, m.unit, m.collected_at
+		FROM ` + table + ` m
+		JOIN hosts h ON h.id = m.host_id
+		WHERE m.host_id = ` + s.ph(1) + `
+			AND m.plugin = ` + s.ph(2) + `
+			AND m.name = ` + s.ph(3) + `
+			AND m.collected_at >= ` + s.ph(4) + `
+			AND m.collected_at < ` + s.ph(5) + cond + `
+		ORDER BY m.collected_at, m.id
+		LIMIT ` + fmt.Sprint(limit)
+	}
+
+	records, err := s.queryBoth(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("store: metric range %q %s/%s: %w", hostKey, plugin, name, err)
+	}
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+// StatusHistory returns the status-type samples of every series of a host
+// collected in [from, to), oldest first, from metrics and metrics_recent. An
+// unknown host yields an empty slice.
+func (s *sqlStore) StatusHistory(ctx context.Context, hostKey string, from, to time.Time) ([]MetricRecord, error) {
+	hostID, ok, err := s.lookupHostID(ctx, hostKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []MetricRecord{}, nil
+	}
+
+	q := func(table string) string {
+		return `SELECT h.` + s.quotedKey() + `, h.name, h.address,
+			m.plugin, m.name, m.category, m.metric_type, m.value, m.value_num,
+			m.instance, m.extra, m.value_list, m.unit, m.collected_at
+		FROM ` + table + ` m
+		JOIN hosts h ON h.id = m.host_id
+		WHERE m.host_id = ` + s.ph(1) + `
+			AND m.metric_type = 'status'
+			AND m.collected_at >= ` + s.ph(2) + `
+			AND m.collected_at < ` + s.ph(3) + `
+		ORDER BY m.collected_at, m.id`
+	}
+
+	records, err := s.queryBoth(ctx, q, hostID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("store: status history %q: %w", hostKey, err)
+	}
+	return records, nil
+}
+
+// QueryMetrics returns the samples matching filter, newest first, from
+// metrics and metrics_recent. Each table is asked for at most Limit rows
+// (default 100) and the merged result is cut to Limit.
+func (s *sqlStore) QueryMetrics(ctx context.Context, filter MetricFilter) ([]MetricRecord, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultSearchLimit
+	}
+	where, args := s.filterConditions(filter)
+	cond := ""
+	if len(where) > 0 {
+		cond = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	records := []MetricRecord{}
+	for _, table := range metricTables {
+		q := `SELECT h.` + s.quotedKey() + `, h.name, h.address,
+				m.plugin, m.name, m.category, m.metric_type, m.value, m.value_num,
+				m.instance, m.extra, m.value_list, m.unit, m.collected_at
+			FROM ` + table + ` m
+			JOIN hosts h ON h.id = m.host_id
+			` + cond + `
+			ORDER BY m.collected_at DESC, m.id DESC
+			LIMIT ` + fmt.Sprint(filter.Limit)
+		rows, err := s.db.QueryContext(ctx, q, args...)
+		if err != nil {
+			return nil, fmt.Errorf("store: query metrics: %w", err)
+		}
+		scanned, err := scanMetricRows(rows)
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("store: query metrics: %w", err)
+		}
+		records = append(records, scanned...)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].CollectedAt.After(records[j].CollectedAt) })
+	if len(records) > filter.Limit {
+		records = records[:filter.Limit]
+	}
+	return records, nil
+}
+
+// GetLinks returns the LLDP/CDP neighbor rows for a host ordered by local
+// port. An unknown host yields an empty slice.
+func (s *sqlStore) GetLinks(ctx context.Context, hostKey string) ([]LinkRecord, error) {
+	hostID, ok, err := s.lookupHostID(ctx, hostKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []LinkRecord{}, nil
+	}
+
+	q := `SELECT h.` + s.quotedKey() + `, h.name, h.address,
+			l.protocol, l.local_if_index, l.local_port, l.remote_chassis_id, l.remote_port,
+			l.remote_port_desc, l.remote_sys_name, l.remote_address, l.first_seen, l.last_seen
+		FROM links l
+		JOIN hosts h ON h.id = l.host_id
+		WHERE l.host_id = ` + s.ph(1) + `
+		ORDER BY l.local_if_index, l.local_port, l.protocol, l.remote_chassis_id`
+
+	rows, err := s.db.QueryContext(ctx, q, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("store: links %q: %w", hostKey, err)
+	}
+	defer rows.Close()
+
+	records := []LinkRecord{}
+	for rows.Next() {
+		var (
+			r                   LinkRecord
+			firstSeen, lastSeen scanTime
+		)
+		if err := rows.Scan(
+			&r.HostKey, &r.HostName, &r.HostAddress,
+			&r.Protocol, &r.LocalIfIndex, &r.LocalPort, &r.RemoteChassisID, &r.RemotePort,
+			&r.RemotePortDesc, &r.RemoteSysName, &r.RemoteAddress, &firstSeen, &lastSeen,
+		); err != nil {
+			return nil, fmt.Errorf("store: links %q: %w", hostKey, err)
+		}
+		r.FirstSeen = firstSeen.Time
+		r.LastSeen = lastSeen.Time
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: links %q: %w", hostKey, err)
+	}
+	return records, nil
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLatestMetricsUnknownHost(t *testing.T) {
+	s := openTestStore(t)
+	got, err := s.LatestMetrics(context.Background(), "nowhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || len(got) != 0 {
+		t.Errorf("LatestMetrics = %#v, want an empty slice", got)
+	}
+}
+
+func TestLatestMetrics(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	err := s.WriteBatch(ctx, []MetricRecord{
+		sample("db", "load_1", "", "0.5", t0),
+		sample("db", "load_1", "", "0.9", t0.Add(2*time.Minute)),
+		sample("db", "load_1", "", "0.7", t0.Add(time.Minute)),
+		sample("db", "disk_used", "/", "40", t0),
+		sample("db", "disk_used", "/", "41", t0.Add(time.Minute)),
+		sample("db", "disk_used", "/var", "70", t0),
+		sample("web", "load_1", "", "3.0", t0.Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A newer sample in the ring buffer wins over metrics; an older one does not.
+	err = s.WriteBatchRecent(ctx, []MetricRecord{
+		sample("db", "disk_used", "/var", "75", t0.Add(30*time.Second)),
+		sample("db", "load_1", "", "0.1", t0.Add(-time.Minute)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.LatestMetrics(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name, instance, value string
+		at                    time.Time
+	}{
+		{"disk_used", "/", "41", t0.Add(time.Minute)},
+		{"disk_used", "/var", "75", t0.Add(30 * time.Second)},
+		{"load_1", "", "0.9", t0.Add(2 * time.Minute)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("LatestMetrics = %+v, want %d records", got, len(want))
+	}
+	for i, w := range want {
+		r := got[i]
+		if r.Name != w.name || r.Instance != w.instance || r.Value != w.value || !r.CollectedAt.Equal(w.at) {
+			t.Errorf("record %d = %s/%s %s at %v, want %s/%s %s at %v",
+				i, r.Name, r.Instance, r.Value, r.CollectedAt, w.name, w.instance, w.value, w.at)
+		}
+		if r.HostKey != "db" || r.Plugin != "local" {
+			t.Errorf("record %d is of %s/%s", i, r.HostKey, r.Plugin)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// defaultSearchLimit caps SearchMetrics when the filter sets no limit.
+const defaultSearchLimit = 100
+
+// SearchMetrics finds samples whose value or extra column contain every term
+// of query. Each term is checked with a case-insensitive LIKE, which is what
+// decides a match; the dialect's full-text index (migration v6) only narrows
+// the rows LIKE has to scan, so with it a term must also be made of whole
+// words. A version such as "7.0.2" is matched as the adjacent words 7, 0 and
+// 2 and then confirmed by LIKE, so it does not match "7.0.20".
+//
+// When the full-text query fails (an FTS5-less SQLite build, a MySQL without
+// the FULLTEXT index) the search is retried, and later searches are run, with
+// LIKE alone.
+func (s *sqlStore) SearchMetrics(ctx context.Context, query string, filter MetricFilter) ([]MetricRecord, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, errors.New("store: search: empty query")
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultSearchLimit
+	}
+
+	s.mu.Lock()
+	useFTS := !s.noFTS
+	s.mu.Unlock()
+	if useFTS {
+		records, ftsErr := s.searchMetrics(ctx, terms, filter, true)
+		if ftsErr == nil {
+			return records, nil
+		}
+		records, err := s.searchMetrics(ctx, terms, filter, false)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.noFTS = true
+		s.mu.Unlock()
+		logf("  !_ store: full-text search unavailable, using LIKE: %v\n", ftsErr)
+		return records, nil
+	}
+	return s.searchMetrics(ctx, terms, filter, false)
+}
+
+func (s *sqlStore) searchMetrics(ctx context.Context, terms []string, f MetricFilter, useFTS bool) ([]MetricRecord, error) {
+	where, args := s.filterConditions(f)
+	// add appends a condition, numbering its "?" placeholders for the dialect.
+	add := func(cond string, values ...interface{}) {
+		for _, v := range values {
+			args = append(args, v)
+			cond = strings.Replace(cond, "?", s.ph(len(args)), 1)
+		}
+		where = append(where, cond)
+	}
+
+	if useFTS {
+		if cond, arg := s.ftsCondition(terms); cond != "" {
+			add(cond, arg)
+		}
+	}
+	like, extra, escape, escaper := "LIKE", "COALESCE(m.extra, '')", " ESCAPE '!'", likeEscaper
+	switch s.d {
+	case dialectPostgres:
+		like, extra = "ILIKE", "COALESCE(m.extra::text, '')"
+	case dialectMySQL:
+		extra = "COALESCE(CAST(m.extra AS CHAR), '')"
+	case dialectClickHouse:
+		// ClickHouse has no ESCAPE clause: its LIKE escapes with a backslash.
+		like, escape, escaper = "ILIKE", "", backslashLikeEscaper
+	}
+	for _, t := range terms {
+		pattern := "%" + escaper.Replace(t) + "%"
+		add("(m.value "+like+" ?"+escape+" OR "+extra+" "+like+" ?"+escape+")", pattern, pattern)
+	}
+
+	q := `SELECT h.` + s.quotedKey() + `, h.name, h.address,
+			m.plugin, m.name, m.category, m.metric_type, m.value, m.value_num,
+			m.instance, m.extra, m.value_list, m.unit, m.collected_at
+		FROM metrics m
+		JOIN hosts h ON h.id = m.host_id
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY m.collected_at DESC, m.id DESC
+		LIMIT ` + fmt.Sprint(f.Limit)
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("store: search: %w", err)
+	}
+	defer rows.Close()
+
+	records, err := scanMetricRows(rows)
+	if err != nil {
+		return nil, fmt.Errorf("store: search: %w", err)
+	}
+	if records == nil {
+		records = []MetricRecord{}
+	}
+	return records, nil
+}
+
+// filterConditions returns the WHERE conditions, over metrics aliased m
+// joined to hosts aliased h, and their arguments for the fields f sets.
+func (s *sqlStore) filterConditions(f MetricFilter) ([]string, []interface{}) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	add := func(cond string, value interface{}) {
+		args = append(args, value)
+		where = append(where, cond+" "+s.ph(len(args)))
+	}
+	if f.HostKey != "" {
+		add("h."+s.quotedKey()+" =", f.HostKey)
+	}
+	if f.Plugin != "" {
+		add("m.plugin =", f.Plugin)
+	}
+	if f.Name != "" {
+		add("m.name =", f.Name)
+	}
+	if !f.From.IsZero() {
+		add("m.collected_at >=", f.From)
+	}
+	if !f.To.IsZero() {
+		add("m.collected_at <", f.To)
+	}
+	return where, args
+}
+
+// ftsCondition returns the dialect's full-text condition for the terms, with
+// its single parameter written as "?", or "" when no term has a word the
+// index can look up.
+func (s *sqlStore) ftsCondition(terms []string) (string, string) {
+	switch s.d {
+	case dialectPostgres:
+		// plainto_tsquery runs the terms through the same parser as the
+		// index, which keeps versions and host names whole.
+		var parts []string
+		for _, t := range terms {
+			if len(termWords(t)) > 0 {
+				parts = append(parts, t)
+			}
+		}
+		if len(parts) == 0 {
+			return "", ""
+		}
+		return "to_tsvector('simple', m.value || ' ' || COALESCE(m.extra::text, '')) @@ plainto_tsquery('simple', ?)",
+			strings.Join(parts, " ")
+
+	case dialectMySQL:
+		// InnoDB does not index words shorter than innodb_ft_min_token_size
+		// (3) nor its stopwords; a required one would never match.
+		var parts []string
+		for _, t := range terms {
+			for _, w := range termWords(t) {
+				if len(w) >= 3 && !mysqlStopwords[strings.ToLower(w)] {
+					parts = append(parts, "+"+w)
+				}
+			}
+		}
+		if len(parts) == 0 {
+			return "", ""
+		}
+		return "MATCH(m.value, m.extra_text) AGAINST (? IN BOOLEAN MODE)", strings.Join(parts, " ")
+
+	case dialectClickHouse:
+		// The token bloom filter on value is consulted by LIKE itself.
+		return "", ""
+
+	default: // SQLite
+		// FTS5's unicode61 tokenizer splits on everything but letters and
+		// digits, as termWords does; each term becomes a phrase.
+		var parts []string
+		for _, t := range terms {
+			if words := termWords(t); len(words) > 0 {
+				parts = append(parts, `"`+strings.Join(words, " ")+`"`)
+			}
+		}
+		if len(parts) == 0 {
+			return "", ""
+		}
+		return "m.id IN (SELECT rowid FROM metrics_fts WHERE metrics_fts MATCH ?)", strings.Join(parts, " AND ")
+	}
+}
+
+// searchTerms splits a query on whitespace, keeping "double-quoted phrases"
+// together.
+func searchTerms(query string) []string {
+	var terms []string
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			if p := strings.TrimSpace(part); p != "" {
+				terms = append(terms, p)
+			}
+			continue
+		}
+		terms = append(terms, strings.Fields(part)...)
+	}
+	return terms
+}
+
+// termWords returns the runs of letters and digits in a term.
+func termWords(term string) []string {
+	return strings.FieldsFunc(term, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// likeEscaper escapes LIKE wildcards for ESCAPE '!'.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// backslashLikeEscaper escapes LIKE wildcards for a LIKE without ESCAPE
+// that escapes with a backslash, as ClickHouse's does.
+var backslashLikeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// mysqlStopwords is InnoDB's default full-text stopword list.
+var mysqlStopwords = map[string]bool{
+	"a": true, "about": true, "an": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "com": true, "de": true, "en": true, "for": true,
+	"from": true, "how": true, "i": true, "in": true, "is": true, "it": true,
+	"la": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "what": true, "when": true, "where": true,
+	"who": true, "will": true, "with": true, "und": true, "www": true,
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// searchStore holds firmware and log lines of three hosts, one sample a
+// minute from t0.
+func searchStore(t *testing.T) (*sqlStore, time.Time) {
+	t.Helper()
+	s := openTestStore(t)
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := []MetricRecord{
+		sample("sw1", "firmware", "", "JunOS 7.0.2", t0),
+		sample("sw2", "firmware", "", "JunOS 7.0.20", t0.Add(time.Minute)),
+		sample("sw3", "firmware", "", "IOS-XE 17.0.2", t0.Add(2*time.Minute)),
+		sample("sw1", "syslog", "", "Interface ge-0/0/1 link down", t0.Add(3*time.Minute)),
+		sample("sw2", "syslog", "", "down: link flap on eth10", t0.Add(4*time.Minute)),
+		sample("sw3", "disk", "/var", "100% used", t0.Add(5*time.Minute)),
+		sample("sw3", "disk", "/tmp", "1000 used", t0.Add(6*time.Minute)),
+	}
+	// The version is only in extra here.
+	ap := sample("ap1", "facts", "", "ok", t0.Add(7*time.Minute))
+	ap.Plugin = "snmp"
+	ap.Extra = map[string]interface{}{"firmware": "7.0.2", "model": "AP-515"}
+	records = append(records, ap)
+	if err := s.WriteBatch(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+	return s, t0
+}
+
+// hits describes search results as "host value" lines, newest first.
+func hits(t *testing.T, s *sqlStore, query string, f MetricFilter) []string {
+	t.Helper()
+	records, err := s.SearchMetrics(context.Background(), query, f)
+	if err != nil {
+		t.Fatalf("search %q: %v", query, err)
+	}
+	var out []string
+	for _, r := range records {
+		out = append(out, r.HostKey+" "+r.Value)
+	}
+	return out
+}
+
+func TestSearchTokenization(t *testing.T) {
+	s, _ := searchStore(t)
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		// A version is the words 7, 0 and 2 in a row, confirmed by LIKE: not
+		// 7.0.20, and with the index not 17.0.2 either, as 17 is another word.
+		{query: "7.0.2", want: []string{"ap1 ok", "sw1 JunOS 7.0.2"}},
+		{query: "7.0.20", want: []string{"sw2 JunOS 7.0.20"}},
+		{query: "junos 7.0", want: []string{"sw2 JunOS 7.0.20", "sw1 JunOS 7.0.2"}},
+		{query: "IOS-XE", want: []string{"sw3 IOS-XE 17.0.2"}},
+		// Terms match anywhere in any order; a quoted phrase only as written.
+		{query: "down link", want: []string{"sw2 down: link flap on eth10", "sw1 Interface ge-0/0/1 link down"}},
+		{query: `"link down"`, want: []string{"sw1 Interface ge-0/0/1 link down"}},
+		{query: "ge-0/0/1", want: []string{"sw1 Interface ge-0/0/1 link down"}},
+		// LIKE wildcards are literal.
+		{query: "100%", want: []string{"sw3 100% used"}},
+		{query: "eth_0", want: nil},
+		{query: "%", want: []string{"sw3 100% used"}},
+		{query: "AP-515", want: []string{"ap1 ok"}},
+		// The index holds whole words only, so a word's prefix is not found.
+		{query: "Inter", want: nil},
+	} {
+		if got := hits(t, s, tt.query, MetricFilter{}); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// LIKE alone matches substrings, 7.0.20 and 17.0.2 included.
+	s.noFTS = true
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"7.0.2", []string{"ap1 ok", "sw3 IOS-XE 17.0.2", "sw2 JunOS 7.0.20", "sw1 JunOS 7.0.2"}},
+		{"7.0.20", []string{"sw2 JunOS 7.0.20"}},
+		{"Inter", []string{"sw1 Interface ge-0/0/1 link down"}},
+		{"eth_0", nil},
+	} {
+		if got := hits(t, s, tt.query, MetricFilter{}); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("LIKE %s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	s, t0 := searchStore(t)
+	for _, tt := range []struct {
+		name string
+		f    MetricFilter
+		want []string
+	}{
+		{"host", MetricFilter{HostKey: "sw1"}, []string{"sw1 JunOS 7.0.2"}},
+		{"plugin", MetricFilter{Plugin: "snmp"}, []string{"ap1 ok"}},
+		{"name", MetricFilter{Name: "firmware"}, []string{"sw1 JunOS 7.0.2"}},
+		{"from", MetricFilter{From: t0.Add(time.Minute)}, []string{"ap1 ok"}},
+		{"to", MetricFilter{To: t0.Add(time.Minute)}, []string{"sw1 JunOS 7.0.2"}},
+		{"limit", MetricFilter{Limit: 1}, []string{"ap1 ok"}},
+	} {
+		if got := hits(t, s, "7.0.2", tt.f); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := s.SearchMetrics(context.Background(), ` " " `, MetricFilter{}); err == nil {
+		t.Error("empty query accepted")
+	}
+}
+
+func TestSearchIndexFollowsWrites(t *testing.T) {
+	s, _ := searchStore(t)
+	if _, err := s.db.Exec(`UPDATE metrics SET value = 'JunOS 7.1.1' WHERE value = 'JunOS 7.0.2'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM metrics WHERE value = 'IOS-XE 17.0.2'`); err != nil {
+		t.Fatal(err)
+	}
+	if got := hits(t, s, "7.1.1", MetricFilter{}); len(got) != 1 {
+		t.Errorf("updated value: %q", got)
+	}
+	if got := hits(t, s, "7.0.2", MetricFilter{}); strings.Join(got, "|") != "ap1 ok" {
+		t.Errorf("old value still found: %q", got)
+	}
+	if got := hits(t, s, "IOS-XE", MetricFilter{}); got != nil {
+		t.Errorf("deleted row found: %q", got)
+	}
+}
+
+func TestSearchFallsBackToLike(t *testing.T) {
+	s, _ := searchStore(t)
+	// Without the FTS5 table the full-text query fails.
+	for _, stmt := range []string{
+		`DROP TRIGGER metrics_fts_insert`, `DROP TRIGGER metrics_fts_delete`,
+		`DROP TRIGGER metrics_fts_update`, `DROP TABLE metrics_fts`,
+	} {
+		if _, err := s.db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "ap1 ok|sw3 IOS-XE 17.0.2|sw2 JunOS 7.0.20|sw1 JunOS 7.0.2"
+	if got := hits(t, s, "7.0.2", MetricFilter{}); strings.Join(got, "|") != want {
+		t.Errorf("fallback = %q", got)
+	}
+	if !s.noFTS {
+		t.Error("later searches still try the full-text index")
+	}
+}
+
+func TestFTSCondition(t *testing.T) {
+	terms := searchTerms(`firmware 7.0.2 "link down" the a-b %`)
+	if strings.Join(terms, "|") != "firmware|7.0.2|link down|the|a-b|%" {
+		t.Fatalf("terms = %q", terms)
+	}
+	for d, want := range map[dialect]string{
+		dialectSQLite:     `"firmware" AND "7 0 2" AND "link down" AND "the" AND "a b"`,
+		dialectPostgres:   "firmware 7.0.2 link down the a-b",
+		dialectMySQL:      "+firmware +link +down", // short words and stopwords are not indexed
+		dialectClickHouse: "",
+	} {
+		cond, arg := (&sqlStore{d: d}).ftsCondition(terms)
+		if arg != want || (want == "") != (cond == "") {
+			t.Errorf("dialect %d: %q, %q; want argument %q", d, cond, arg, want)
+		}
+	}
+	if cond, _ := (&sqlStore{}).ftsCondition([]string{"%", "--"}); cond != "" {
+		t.Errorf("condition without words: %s", cond)
+	}
+}
@@ -1,30 +1,57 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 )
 
 type sqlStore struct {
 	db        *sql.DB
 	d         dialect
+	path      string   // for SQLite, the database file
+	server    *url.URL // for MySQL and Postgres, the server URL
 	mu        sync.Mutex
-	hostCache map[string]int64 // key → id, populated on first write per run
+	hostCache map[string]int64     // key → id, populated on first write per run
+	hostSeen  map[string]time.Time // key → when its last_seen was last written
+	noFTS     bool                 // a full-text query failed; SearchMetrics uses LIKE only
+	batchSize int                  // records per metric write transaction; 0 means DefaultBatchSize
 }
 
-func openSQL(driver, dsn string, d dialect) (Store, error) {
+// hostSeenInterval is how often writes to a cached host refresh its
+// last_seen. A long-running store, such as the daemon's, would otherwise
+// keep the time of a host's first write.
+var hostSeenInterval = time.Minute
+
+func openSQL(driver, dsn string, d dialect, server *url.URL) (Store, error) {
 	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("store: open %s: %w", driver, err)
 	}
+	s, err := newSQLStore(db, driver, d, server)
+	if err != nil {
+		return nil, err
+	}
+	if d == dialectSQLite {
+		s.path, _, _ = strings.Cut(dsn, "?")
+	}
+	return s, nil
+}
+
+// newSQLStore connects db and migrates its schema, closing db on failure.
+func newSQLStore(db *sql.DB, driver string, d dialect, server *url.URL) (*sqlStore, error) {
 	if err := db.Ping(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("store: connect %s: %w", driver, err)
 	}
 
-	s := &sqlStore{db: db, d: d, hostCache: make(map[string]int64)}
+	s := &sqlStore{db: db, d: d, server: server, hostCache: make(map[string]int64), hostSeen: make(map[string]time.Time)}
 	if err := s.migrate(); err != nil {
 		db.Close()
 		return nil, err
@@ -41,35 +68,101 @@ func (s *sqlStore) migrate() error {
 		return fmt.Errorf("store: create schema_migrations: %w", err)
 	}
 
-	// Load already-applied versions.
+	applied, err := s.appliedMigrations(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// Apply pending migrations.
+	for _, m := range migrations(s.d) {
+		if applied[m.version] {
+			continue
+		}
+		if err := s.applyMigration(m); err != nil {
+			return fmt.Errorf("store: migration v%d %q: %w", m.version, m.description, err)
+		}
+		logf("  |_ store: applied migration v%d: %s\n", m.version, m.description)
+	}
+	return nil
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations.
+func (s *sqlStore) appliedMigrations(ctx context.Context) (map[int]bool, error) {
 	applied := make(map[int]bool)
-	rows, err := s.db.Query(`SELECT version FROM schema_migrations`)
+	rows, err := s.db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
 	if err != nil {
-		return fmt.Errorf("store: query schema_migrations: %w", err)
+		return nil, fmt.Errorf("store: query schema_migrations: %w", err)
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var v int
 		if err := rows.Scan(&v); err != nil {
-			return fmt.Errorf("store: scan schema_migrations: %w", err)
+			return nil, fmt.Errorf("store: scan schema_migrations: %w", err)
 		}
 		applied[v] = true
 	}
-	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: query schema_migrations: %w", err)
+	}
+	return applied, nil
+}
 
-	// Apply pending migrations.
-	for _, m := range migrations(s.d) {
-		if applied[m.version] {
+// MigrateDown undoes the applied migrations above version, newest first.
+// Each runs its down statements and removes its schema_migrations row in
+// its own transaction, so a failure leaves the ones already undone undone.
+// Nothing is undone unless every migration to undo has down statements.
+// v1 has none: version must be at least 1.
+func (s *sqlStore) MigrateDown(ctx context.Context, version int) error {
+	if version < 1 {
+		return fmt.Errorf("store: cannot migrate below v1, which creates the hosts and metrics tables")
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	var undo []migration
+	all := migrations(s.d)
+	for i := len(all) - 1; i >= 0; i-- {
+		m := all[i]
+		if m.version <= version || !applied[m.version] {
 			continue
 		}
-		if err := s.applyMigration(m); err != nil {
-			return fmt.Errorf("store: migration v%d %q: %w", m.version, m.description, err)
+		if m.down == nil {
+			return fmt.Errorf("store: migration v%d %q cannot be undone", m.version, m.description)
+		}
+		undo = append(undo, m)
+	}
+	for _, m := range undo {
+		if err := s.applyDown(ctx, m); err != nil {
+			return fmt.Errorf("store: undo migration v%d %q: %w", m.version, m.description, err)
 		}
-		fmt.Printf("  |_ store: applied migration v%d: %s\n", m.version, m.description)
+		logf("  |_ store: undid migration v%d: %s\n", m.version, m.description)
 	}
 	return nil
 }
 
+// applyDown runs a migration's down statements and removes its
+// schema_migrations row in one transaction. MySQL commits DDL as it runs, so
+// there a failed statement can leave the migration partly undone.
+func (s *sqlStore) applyDown(ctx context.Context, m migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, stmt := range m.down {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("statement failed: %w\nSQL: %s", err, stmt)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = `+s.ph(1), m.version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // applyMigration runs a single migration in a transaction.
 // If the schema already exists (upgrading from a pre-versioning binary),
 // v1 is stamped as applied without re-running its DDL so we don't hit
@@ -112,6 +205,10 @@ func (s *sqlStore) schemaExists() bool {
 		err = s.db.QueryRow(`SHOW TABLES LIKE 'hosts'`).Scan(&name)
 		exists = err == nil
 		return exists
+	case dialectClickHouse:
+		err = s.db.QueryRow(
+			`SELECT count() > 0 FROM system.tables WHERE database = currentDatabase() AND name = 'hosts'`,
+		).Scan(&exists)
 	default: // SQLite
 		err = s.db.QueryRow(
 			`SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='hosts')`,
@@ -153,12 +250,22 @@ func (s *sqlStore) ph(n int) string {
 }
 
 // ensureHost upserts a host row and returns its id.
-// Results are cached in hostCache so each key hits the DB at most once per WriteBatch call.
-func (s *sqlStore) ensureHost(key, name, address string) (int64, error) {
+// Results are cached in hostCache, so a key hits the DB at most once per
+// hostSeenInterval: enough to keep its last_seen current.
+func (s *sqlStore) ensureHost(ctx context.Context, key, name, address string) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if id, ok := s.hostCache[key]; ok {
+	if id, ok := s.hostCache[key]; ok && time.Since(s.hostSeen[key]) < hostSeenInterval {
+		return id, nil
+	}
+
+	if s.d == dialectClickHouse {
+		id, err := s.insertClickHouseHost(ctx, key, name, address)
+		if err != nil {
+			return 0, err
+		}
+		s.hostCache[key], s.hostSeen[key] = id, time.Now()
 		return id, nil
 	}
 
@@ -186,84 +293,281 @@ func (s *sqlStore) ensureHost(key, name, address string) (int64, error) {
 		selectQ = `SELECT id FROM hosts WHERE key = ?`
 	}
 
-	if _, err := s.db.Exec(upsertQ, key, name, address); err != nil {
+	if _, err := s.db.ExecContext(ctx, upsertQ, key, name, address); err != nil {
 		return 0, fmt.Errorf("store: upsert host %q: %w", key, err)
 	}
 
 	var id int64
-	if err := s.db.QueryRow(selectQ, key).Scan(&id); err != nil {
+	if err := s.db.QueryRowContext(ctx, selectQ, key).Scan(&id); err != nil {
 		return 0, fmt.Errorf("store: query host id %q: %w", key, err)
 	}
 
-	s.hostCache[key] = id
+	s.hostCache[key], s.hostSeen[key] = id, time.Now()
 	return id, nil
 }
 
-// WriteBatch persists a slice of metric records in a single transaction.
-func (s *sqlStore) WriteBatch(records []MetricRecord) error {
+// WriteBatch persists a slice of metric records, committing every batch
+// size records in a transaction of their own.
+func (s *sqlStore) WriteBatch(ctx context.Context, records []MetricRecord) error {
+	return s.writeMetrics(ctx, "metrics", records)
+}
+
+// DefaultBatchSize is how many records a metric write commits per
+// transaction unless WithBatchSize set another size.
+const DefaultBatchSize = 1000
+
+// WithBatchSize sets how many records st commits per transaction when
+// writing metrics, and returns st. Smaller batches keep a large write under
+// MySQL's max_allowed_packet and hold locks for less time. A size of zero or
+// less, or a store that is not SQL, is left as it is.
+func WithBatchSize(st Store, size int) Store {
+	if size <= 0 {
+		return st
+	}
+	switch s := st.(type) {
+	case *sqlStore:
+		s.batchSize = size
+	case *clickhouseStore:
+		s.batchSize = size
+	}
+	return st
+}
+
+// writeMetrics inserts records into table, metrics or metrics_recent, one
+// transaction per batch of records, several rows per INSERT. A batch that
+// fails is rolled back and the next one is still written. When ctx is done
+// the batch being written is rolled back and the rest are skipped; batches
+// already committed stay written.
+func (s *sqlStore) writeMetrics(ctx context.Context, table string, records []MetricRecord) error {
+	return s.writeMetricsBy(ctx, records, func(batch []MetricRecord, hostIDs map[string]int64) error {
+		return s.writeMetricBatch(ctx, table, batch, hostIDs)
+	})
+}
+
+// writeMetricsBy resolves the host IDs of records once, then hands them to
+// write batch size records at a time. The errors of every failed batch are
+// returned together; when ctx is done the remaining batches are skipped.
+func (s *sqlStore) writeMetricsBy(ctx context.Context, records []MetricRecord, write func(batch []MetricRecord, hostIDs map[string]int64) error) error {
 	if len(records) == 0 {
 		return nil
 	}
 
-	// Resolve all host IDs before opening the transaction.
+	// Resolve all host IDs before opening the first transaction.
 	hostIDs := make(map[string]int64, len(records))
 	for _, r := range records {
 		if _, ok := hostIDs[r.HostKey]; ok {
 			continue
 		}
-		id, err := s.ensureHost(r.HostKey, r.HostName, r.HostAddress)
+		id, err := s.ensureHost(ctx, r.HostKey, r.HostName, r.HostAddress)
+		if ctx.Err() != nil {
+			return fmt.Errorf("store: write: %w", ctx.Err())
+		}
 		if err != nil {
-			fmt.Printf("  !_ store: skip host %q: %v\n", r.HostKey, err)
+			logf("  !_ store: skip host %q: %v\n", r.HostKey, err)
 			continue
 		}
 		hostIDs[r.HostKey] = id
 	}
 
-	tx, err := s.db.Begin()
-	if err != nil {
-		return fmt.Errorf("store: begin tx: %w", err)
+	size := s.batchSize
+	if size <= 0 {
+		size = DefaultBatchSize
 	}
-	defer tx.Rollback() //nolint:errcheck
-
-	var insertQ string
-	if s.d == dialectPostgres {
-		insertQ = "INSERT INTO metrics " +
-			"(host_id, plugin, name, category, metric_type, value, value_num, instance, extra, collected_at) " +
-			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"
-	} else {
-		insertQ = "INSERT INTO metrics " +
-			"(host_id, plugin, name, category, metric_type, value, value_num, instance, extra, collected_at) " +
-			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	var errs []error
+	for start := 0; start < len(records); start += size {
+		end := min(start+size, len(records))
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Errorf("store: write records %d-%d: %w", start+1, len(records), ctx.Err()))
+			break
+		}
+		if err := write(records[start:end], hostIDs); err != nil {
+			errs = append(errs, fmt.Errorf("store: write records %d-%d: %w", start+1, end, err))
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	stmt, err := tx.Prepare(insertQ)
+// writeMetricBatch inserts records into table in a single transaction,
+// skipping records whose host has no ID in hostIDs.
+func (s *sqlStore) writeMetricBatch(ctx context.Context, table string, records []MetricRecord, hostIDs map[string]int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("store: prepare insert: %w", err)
+		return fmt.Errorf("begin tx: %w", err)
 	}
-	defer stmt.Close()
+	defer tx.Rollback() //nolint:errcheck
 
+	latestQ := s.latestValueSQL(table)
+
+	// Rows go out insertRows at a time. A deduplicated record is compared with
+	// the last value queued for its series in this batch, else the stored one.
+	perInsert := s.insertRows()
+	pending := make([]metricRow, 0, min(len(records), perInsert))
+	queued := make(map[string]string)
 	for _, r := range records {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		hostID, ok := hostIDs[r.HostKey]
 		if !ok {
 			continue
 		}
+		series := fmt.Sprintf("%d\x00%s\x00%s\x00%s", hostID, r.Plugin, r.Name, r.Instance)
+		if r.Dedup {
+			last, ok := queued[series]
+			if !ok {
+				ok = tx.QueryRowContext(ctx, latestQ, hostID, r.Plugin, r.Name, r.Instance).Scan(&last) == nil
+			}
+			if ok && last == r.Value {
+				continue
+			}
+		}
+		queued[series] = r.Value
+
 		var instance interface{} = nil
 		if r.Instance != "" {
 			instance = r.Instance
 		}
-		if _, err := stmt.Exec(
+		pending = append(pending, metricRow{r: r, args: []interface{}{
 			hostID, r.Plugin, r.Name, r.Category, r.MetricType,
-			r.Value, r.ValueNum, instance, marshalExtra(r.Extra), r.CollectedAt,
-		); err != nil {
-			fmt.Printf("  !_ store: insert %q/%q: %v\n", r.HostKey, r.Name, err)
+			r.Value, r.ValueNum, instance, marshalExtra(r.Extra), marshalValues(r.Values), r.Unit, r.CollectedAt,
+		}})
+		if len(pending) == perInsert {
+			if err := s.insertMetricRows(ctx, tx, table, pending); err != nil {
+				return err
+			}
+			pending = pending[:0]
 		}
 	}
+	if err := s.insertMetricRows(ctx, tx, table, pending); err != nil {
+		return err
+	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// latestValueSQL returns the query for the last value stored in table for a
+// series, given its host id, plugin, name and instance.
+func (s *sqlStore) latestValueSQL(table string) string {
+	return "SELECT value FROM " + table + " WHERE host_id = " + s.ph(1) +
+		" AND plugin = " + s.ph(2) + " AND name = " + s.ph(3) +
+		" AND COALESCE(instance, '') = " + s.ph(4) +
+		" ORDER BY collected_at DESC LIMIT 1"
+}
+
+// metricColumns are the columns a metric row is inserted with, in the order
+// of metricRow.args.
+const (
+	metricColumns     = "host_id, plugin, name, category, metric_type, value, value_num, instance, extra, value_list, unit, collected_at"
+	metricColumnCount = 12
+)
+
+// maxInsertRows bounds the rows of one multi-row INSERT.
+const maxInsertRows = 500
+
+// metricRow is a record about to be inserted, with its arguments.
+type metricRow struct {
+	r    MetricRecord
+	args []interface{}
+}
+
+// insertRows returns how many metric rows one INSERT carries: maxInsertRows,
+// or fewer where the dialect caps the parameters of a statement. SQLite's
+// default cap is 999; MySQL and PostgreSQL allow 65535.
+func (s *sqlStore) insertRows() int {
+	maxParams := 65535
+	if s.d == dialectSQLite {
+		maxParams = 999
+	}
+	return min(maxInsertRows, maxParams/metricColumnCount)
+}
+
+// insertMetricsSQL returns an INSERT of n metric rows into table.
+func (s *sqlStore) insertMetricsSQL(table string, n int) string {
+	var b strings.Builder
+	b.WriteString("INSERT INTO " + table + " (" + metricColumns + ") VALUES ")
+	p := 1
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for c := 0; c < metricColumnCount; c++ {
+			if c > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(s.ph(p))
+			p++
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// insertMetricRows inserts rows with one statement. When that fails, e.g. on
+// one value the column does not take, the rows are inserted one at a time so
+// only the bad ones are lost; each is reported. It returns an error only when
+// ctx is done.
+func (s *sqlStore) insertMetricRows(ctx context.Context, tx *sql.Tx, table string, rows []metricRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	if len(rows) > 1 {
+		args := make([]interface{}, 0, len(rows)*metricColumnCount)
+		for _, row := range rows {
+			args = append(args, row.args...)
+		}
+		err := s.savepoint(ctx, tx, func() error {
+			_, err := tx.ExecContext(ctx, s.insertMetricsSQL(table, len(rows)), args...)
+			return err
+		})
+		if err == nil {
+			return nil
+		}
+	}
+
+	single := s.insertMetricsSQL(table, 1)
+	for _, row := range rows {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := s.savepoint(ctx, tx, func() error {
+			_, err := tx.ExecContext(ctx, single, row.args...)
+			return err
+		})
+		if err != nil {
+			logf("  !_ store: insert %q/%q: %v\n", row.r.HostKey, row.r.Name, err)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// savepoint runs fn in a savepoint of tx on PostgreSQL, which otherwise
+// aborts the whole transaction when a statement fails; the savepoint is
+// rolled back to then. SQLite and MySQL only undo the failed statement, so
+// fn runs as it is there.
+func (s *sqlStore) savepoint(ctx context.Context, tx *sql.Tx, fn func() error) error {
+	if s.d != dialectPostgres {
+		return fn()
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT write_rows"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT write_rows") //nolint:errcheck
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT write_rows")
+	return err
 }
 
 // WriteFlows persists a slice of flow records in a single transaction.
-func (s *sqlStore) WriteFlows(records []FlowRecord) error {
+func (s *sqlStore) WriteFlows(ctx context.Context, records []FlowRecord) error {
 	if len(records) == 0 {
 		return nil
 	}
@@ -274,15 +578,18 @@ func (s *sqlStore) WriteFlows(records []FlowRecord) error {
 		if _, ok := hostIDs[r.HostKey]; ok {
 			continue
 		}
-		id, err := s.ensureHost(r.HostKey, r.HostName, r.HostAddress)
+		id, err := s.ensureHost(ctx, r.HostKey, r.HostName, r.HostAddress)
+		if ctx.Err() != nil {
+			return fmt.Errorf("store: write flows: %w", ctx.Err())
+		}
 		if err != nil {
-			fmt.Printf("  !_ store: skip host (flow) %q: %v\n", r.HostKey, err)
+			logf("  !_ store: skip host (flow) %q: %v\n", r.HostKey, err)
 			continue
 		}
 		hostIDs[r.HostKey] = id
 	}
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("store: begin tx (flow): %w", err)
 	}
@@ -299,22 +606,25 @@ func (s *sqlStore) WriteFlows(records []FlowRecord) error {
 			"VALUES (?, ?, ?, ?)"
 	}
 
-	stmt, err := tx.Prepare(insertQ)
+	stmt, err := tx.PrepareContext(ctx, insertQ)
 	if err != nil {
 		return fmt.Errorf("store: prepare insert flow: %w", err)
 	}
 	defer stmt.Close()
 
 	for _, r := range records {
+		if ctx.Err() != nil {
+			return fmt.Errorf("store: write flows: %w", ctx.Err())
+		}
 		hostID, ok := hostIDs[r.HostKey]
 		if !ok {
 			continue
 		}
 
-		if _, err := stmt.Exec(
+		if _, err := stmt.ExecContext(ctx,
 			hostID, r.FlowType, string(r.Payload), r.CollectedAt,
 		); err != nil {
-			fmt.Printf("  !_ store: insert flow %q/%q: %v\n", r.HostKey, r.FlowType, err)
+			logf("  !_ store: insert flow %q/%q: %v\n", r.HostKey, r.FlowType, err)
 		}
 	}
 
@@ -323,7 +633,7 @@ func (s *sqlStore) WriteFlows(records []FlowRecord) error {
 
 // UpsertInterfaces upserts interface entity records — one row per (host, ifIndex).
 // Static fields (name, type, speed, MAC) are updated on every call; first_seen is preserved.
-func (s *sqlStore) UpsertInterfaces(records []InterfaceRecord) error {
+func (s *sqlStore) UpsertInterfaces(ctx context.Context, records []InterfaceRecord) error {
 	if len(records) == 0 {
 		return nil
 	}
@@ -334,9 +644,12 @@ func (s *sqlStore) UpsertInterfaces(records []InterfaceRecord) error {
 		if _, ok := hostIDs[r.HostKey]; ok {
 			continue
 		}
-		id, err := s.ensureHost(r.HostKey, r.HostName, r.HostAddress)
+		id, err := s.ensureHost(ctx, r.HostKey, r.HostName, r.HostAddress)
+		if ctx.Err() != nil {
+			return fmt.Errorf("store: upsert interfaces: %w", ctx.Err())
+		}
 		if err != nil {
-			fmt.Printf("  !_ store: skip host %q (interfaces): %v\n", r.HostKey, err)
+			logf("  !_ store: skip host %q (interfaces): %v\n", r.HostKey, err)
 			continue
 		}
 		hostIDs[r.HostKey] = id
@@ -372,19 +685,22 @@ func (s *sqlStore) UpsertInterfaces(records []InterfaceRecord) error {
 				last_seen=CURRENT_TIMESTAMP`
 	}
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("store: begin tx (interfaces): %w", err)
 	}
 	defer tx.Rollback() //nolint:errcheck
 
-	stmt, err := tx.Prepare(upsertQ)
+	stmt, err := tx.PrepareContext(ctx, upsertQ)
 	if err != nil {
 		return fmt.Errorf("store: prepare interface upsert: %w", err)
 	}
 	defer stmt.Close()
 
 	for _, r := range records {
+		if ctx.Err() != nil {
+			return fmt.Errorf("store: upsert interfaces: %w", ctx.Err())
+		}
 		hostID, ok := hostIDs[r.HostKey]
 		if !ok {
 			continue
@@ -397,8 +713,97 @@ func (s *sqlStore) UpsertInterfaces(records []InterfaceRecord) error {
 		if s.d == dialectMySQL {
 			// MySQL upsert includes last_seen=NOW() as a literal — no extra arg needed.
 		}
-		if _, err := stmt.Exec(args...); err != nil {
-			fmt.Printf("  !_ store: upsert interface %q idx %d: %v\n", r.HostKey, r.IfIndex, err)
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			logf("  !_ store: upsert interface %q idx %d: %v\n", r.HostKey, r.IfIndex, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// linkUpsertSQL returns the links upsert for the dialect. A neighbor already
+// known keeps its first_seen; its other fields and last_seen are refreshed.
+func linkUpsertSQL(d dialect) string {
+	switch d {
+	case dialectPostgres:
+		return `INSERT INTO links
+			(host_id, protocol, local_if_index, local_port, remote_chassis_id, remote_port,
+			 remote_port_desc, remote_sys_name, remote_address)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (host_id, protocol, local_port, remote_chassis_id, remote_port) DO UPDATE SET
+				local_if_index=EXCLUDED.local_if_index, remote_port_desc=EXCLUDED.remote_port_desc,
+				remote_sys_name=EXCLUDED.remote_sys_name, remote_address=EXCLUDED.remote_address,
+				last_seen=NOW()`
+	case dialectMySQL:
+		return "INSERT INTO links " +
+			"(host_id, protocol, local_if_index, local_port, remote_chassis_id, remote_port, " +
+			"remote_port_desc, remote_sys_name, remote_address, last_seen) " +
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NOW()) " +
+			"ON DUPLICATE KEY UPDATE " +
+			"local_if_index=VALUES(local_if_index), remote_port_desc=VALUES(remote_port_desc), " +
+			"remote_sys_name=VALUES(remote_sys_name), remote_address=VALUES(remote_address), " +
+			"last_seen=NOW()"
+	default: // SQLite
+		return `INSERT INTO links
+			(host_id, protocol, local_if_index, local_port, remote_chassis_id, remote_port,
+			 remote_port_desc, remote_sys_name, remote_address)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(host_id, protocol, local_port, remote_chassis_id, remote_port) DO UPDATE SET
+				local_if_index=excluded.local_if_index, remote_port_desc=excluded.remote_port_desc,
+				remote_sys_name=excluded.remote_sys_name, remote_address=excluded.remote_address,
+				last_seen=CURRENT_TIMESTAMP`
+	}
+}
+
+// UpsertLinks upserts LLDP/CDP neighbor records — one row per (host, protocol,
+// local port, remote chassis, remote port).
+func (s *sqlStore) UpsertLinks(ctx context.Context, records []LinkRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	// Resolve host IDs.
+	hostIDs := make(map[string]int64, len(records))
+	for _, r := range records {
+		if _, ok := hostIDs[r.HostKey]; ok {
+			continue
+		}
+		id, err := s.ensureHost(ctx, r.HostKey, r.HostName, r.HostAddress)
+		if ctx.Err() != nil {
+			return fmt.Errorf("store: upsert links: %w", ctx.Err())
+		}
+		if err != nil {
+			logf("  !_ store: skip host %q (links): %v\n", r.HostKey, err)
+			continue
+		}
+		hostIDs[r.HostKey] = id
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: begin tx (links): %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmt, err := tx.PrepareContext(ctx, linkUpsertSQL(s.d))
+	if err != nil {
+		return fmt.Errorf("store: prepare link upsert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		if ctx.Err() != nil {
+			return fmt.Errorf("store: upsert links: %w", ctx.Err())
+		}
+		hostID, ok := hostIDs[r.HostKey]
+		if !ok {
+			continue
+		}
+		if _, err := stmt.ExecContext(ctx,
+			hostID, r.Protocol, r.LocalIfIndex, r.LocalPort, r.RemoteChassisID, r.RemotePort,
+			r.RemotePortDesc, r.RemoteSysName, r.RemoteAddress,
+		); err != nil {
+			logf("  !_ store: upsert link %q %s: %v\n", r.HostKey, r.LocalPort, err)
 		}
 	}
 
@@ -418,6 +823,10 @@ func marshalExtra(extra map[string]interface{}) interface{} {
 	return string(b)
 }
 
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 func (s *sqlStore) Close() error {
 	return s.db.Close()
 }
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestStore opens a SQLite store in a temporary directory, closed when
+// the test ends.
+func openTestStore(t testing.TB) *sqlStore {
+	t.Helper()
+	st, err := Open("sqlite://" + filepath.Join(t.TempDir(), "nord.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st.(*sqlStore)
+}
+
+// sample returns a metric record of host from the local plugin.
+func sample(host, name, instance, value string, at time.Time) MetricRecord {
+	return MetricRecord{
+		HostKey: host, HostName: host, Plugin: "local", Name: name, Instance: instance,
+		MetricType: "gauge", Value: value, ValueNum: ParseValueNum(value), CollectedAt: at,
+	}
+}
+
+// countRows returns the number of rows in table.
+func countRows(t testing.TB, s *sqlStore, table string) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestParseValueNum(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  float64
+	}{
+		{"up", 1},
+		{" On ", 1},
+		{"running", 1},
+		{"down", 0},
+		{"OFF", 0},
+		{"inactive", 0},
+		{"warning", 0.5},
+		{"paused", 0.5},
+		{"42", 42},
+		{"3.14", 3.14},
+		{"9%", 9},
+		{"2d 3h 0m 4s", 2*86400 + 3*3600 + 4},
+	} {
+		got := ParseValueNum(tc.value)
+		if got == nil || *got != tc.want {
+			t.Errorf("ParseValueNum(%q) = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+	for _, value := range []string{"", "Error: exit status 1", "debian", "onward"} {
+		if got := ParseValueNum(value); got != nil {
+			t.Errorf("ParseValueNum(%q) = %v, want nil", value, *got)
+		}
+	}
+}
+
+func TestSqlitePath(t *testing.T) {
+	for _, tc := range []struct {
+		rest, want string
+	}{
+		{"data/nord.db", "data/nord.db"},
+		{"/tmp/nord.db", "/tmp/nord.db"},
+		{"/tmp/nord.db?cache=shared", "/tmp/nord.db"},
+		{"/tmp/my%20nord.db", "/tmp/my nord.db"},
+		{"C:/nord/nord.db", "C:/nord/nord.db"},
+		{"/C:/nord/nord.db", "C:/nord/nord.db"},
+		{"/d:/nord.db", "d:/nord.db"},
+		{`C:\nord\nord.db`, `C:\nord\nord.db`},
+		{"/1:/nord.db", "/1:/nord.db"},
+		{"", ":memory:"},
+		{"/", ":memory:"},
+	} {
+		if got := sqlitePath(tc.rest); got != tc.want {
+			t.Errorf("sqlitePath(%q) = %q, want %q", tc.rest, got, tc.want)
+		}
+	}
+}
+
+func TestOpenSQLiteWithQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "nord.db")
+	st, err := Open("sqlite://" + path + "?cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("database not created at %s: %v", path, err)
+	}
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+)
+
+// ValuesFrom returns the numbers of a multi-value metric, such as the local
+// plugin's load histogram, whether it was collected in process ([]float64) or
+// read back from results.json ([]interface{}). Anything else, or a list with
+// a non-numeric element, yields nil.
+func ValuesFrom(v interface{}) []float64 {
+	switch list := v.(type) {
+	case []float64:
+		if len(list) == 0 {
+			return nil
+		}
+		return slices.Clone(list)
+	case []interface{}:
+		if len(list) == 0 {
+			return nil
+		}
+		values := make([]float64, 0, len(list))
+		for _, e := range list {
+			switch n := e.(type) {
+			case float64:
+				values = append(values, n)
+			case int:
+				values = append(values, float64(n))
+			case int64:
+				values = append(values, float64(n))
+			default:
+				return nil
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Samples returns the numbers a record holds: its Values for a multi-value
+// metric, else its ValueNum, else nothing.
+func (r MetricRecord) Samples() []float64 {
+	if len(r.Values) > 0 {
+		return r.Values
+	}
+	if r.ValueNum != nil {
+		return []float64{*r.ValueNum}
+	}
+	return nil
+}
+
+// Percentile returns the p-th percentile (0-100) of values, interpolating
+// linearly between the closest ranks. values is not modified. An empty slice
+// yields NaN, which sparklines draw as a gap.
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return percentileSorted(sorted, p)
+}
+
+// Percentiles returns the percentiles ps of values, sorting them only once.
+func Percentiles(values []float64, ps ...float64) []float64 {
+	out := make([]float64, len(ps))
+	if len(values) == 0 {
+		for i := range out {
+			out[i] = math.NaN()
+		}
+		return out
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	for i, p := range ps {
+		out[i] = percentileSorted(sorted, p)
+	}
+	return out
+}
+
+// HistoryPercentile pools the samples of records, as MetricHistory returns
+// them, and returns their p-th percentile: every value of a multi-value
+// sample counts, so a p95 over a day of latency distributions is the p95 of
+// all their values.
+func HistoryPercentile(records []MetricRecord, p float64) float64 {
+	var pooled []float64
+	for _, r := range records {
+		pooled = append(pooled, r.Samples()...)
+	}
+	return Percentile(pooled, p)
+}
+
+func percentileSorted(sorted []float64, p float64) float64 {
+	p = math.Max(0, math.Min(100, p))
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// marshalValues serialises Values for the value_list column. Returns nil
+// (SQL NULL) for a single-value metric.
+func marshalValues(values []float64) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// unmarshalValues decodes the value_list column. Empty or invalid JSON yields nil.
+func unmarshalValues(raw string) []float64 {
+	if raw == "" {
+		return nil
+	}
+	var values []float64
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return nil
+	}
+	return values
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValuesRoundTrip(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	load := sample("db", "load", "", "0.5, 0.7, 0.9", at)
+	load.MetricType, load.Values = "histogram", []float64{0.5, 0.7, 0.9}
+	latency := sample("db", "latency", "", "", at)
+	latency.Values = []float64{12.5, 3, 1e-3, 250}
+	if err := s.WriteBatch(ctx, []MetricRecord{load, latency, sample("db", "uptime", "", "3600", at)}); err != nil {
+		t.Fatal(err)
+	}
+	recent := sample("db", "rtt", "", "", at)
+	recent.Values = []float64{1, 2, 3}
+	if err := s.WriteBatchRecent(ctx, []MetricRecord{recent}); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := s.LatestMetrics(ctx, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]float64)
+	for _, r := range latest {
+		got[r.Name] = r.Values
+	}
+	want := map[string][]float64{
+		"latency": {12.5, 3, 1e-3, 250},
+		"load":    {0.5, 0.7, 0.9},
+		"rtt":     {1, 2, 3},
+		"uptime":  nil, // a single-value metric stores NULL
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	var null int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM metrics WHERE value_list IS NULL`).Scan(&null); err != nil || null != 1 {
+		t.Errorf("%d NULL value lists, %v", null, err)
+	}
+
+	history, err := s.MetricHistory(ctx, "db", "local", "load", "", at)
+	if err != nil || len(history) != 1 || !reflect.DeepEqual(history[0].Values, load.Values) {
+		t.Errorf("history = %+v, %v", history, err)
+	}
+	found, err := s.QueryMetrics(ctx, MetricFilter{HostKey: "db", Name: "latency"})
+	if err != nil || len(found) != 1 || !reflect.DeepEqual(found[0].Values, latency.Values) {
+		t.Errorf("query = %+v, %v", found, err)
+	}
+
+	// A rollup keeps the values of the minute's last sample.
+	if _, _, err := s.PruneRecent(ctx, at.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	rolled, err := s.MetricHistory(ctx, "db", "local", "rtt", "", at)
+	if err != nil || len(rolled) != 1 || !reflect.DeepEqual(rolled[0].Values, []float64{1, 2, 3}) {
+		t.Errorf("rolled up = %+v, %v", rolled, err)
+	}
+}
+
+func TestValueListSerializations(t *testing.T) {
+	// value_list as each dialect hands it back: SQLite returns the text
+	// written, Postgres' JSONB and MySQL's JSON their own normal form.
+	want := []float64{0.5, 1, 2, 250}
+	for dialect, raw := range map[string]string{
+		"sqlite":   "[0.5,1,2,250]",
+		"postgres": "[0.5, 1, 2, 250]",
+		"mysql":    "[0.5, 1.0, 2.0, 250.0]",
+		"exponent": "[5e-1, 1E0, 2, 2.5e2]",
+	} {
+		values := unmarshalValues(raw)
+		if !reflect.DeepEqual(values, want) {
+			t.Errorf("%s: %q = %v", dialect, raw, values)
+			continue
+		}
+		if p := Percentiles(values, 50, 100); p[0] != 1.5 || p[1] != 250 {
+			t.Errorf("%s: percentiles = %v", dialect, p)
+		}
+	}
+	for _, raw := range []string{"", "null", "[]", "{}", `["a"]`, "[1,"} {
+		if values := unmarshalValues(raw); len(values) != 0 {
+			t.Errorf("%q = %v", raw, values)
+		}
+	}
+
+	if marshalValues(nil) != nil || marshalValues([]float64{}) != nil {
+		t.Error("empty values not stored as NULL")
+	}
+	if got := marshalValues(want); got != "[0.5,1,2,250]" {
+		t.Errorf("marshalValues = %v", got)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	ten := []float64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+	for _, tt := range []struct {
+		p, want float64
+	}{
+		{0, 1}, {50, 5.5}, {90, 9.1}, {95, 9.55}, {100, 10},
+		{-5, 1}, {150, 10}, // clamped
+	} {
+		if got := Percentile(ten, tt.p); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("p%v = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if ten[0] != 10 {
+		t.Error("Percentile sorted its argument")
+	}
+	if got := Percentile([]float64{42}, 99); got != 42 {
+		t.Errorf("one value: %v", got)
+	}
+	if !math.IsNaN(Percentile(nil, 50)) {
+		t.Error("no values: not NaN")
+	}
+	if got := Percentiles(ten, 50, 90); len(got) != 2 || got[0] != 5.5 || math.Abs(got[1]-9.1) > 1e-9 {
+		t.Errorf("Percentiles = %v", got)
+	}
+	if got := Percentiles(nil, 50, 90); len(got) != 2 || !math.IsNaN(got[0]) || !math.IsNaN(got[1]) {
+		t.Errorf("Percentiles of nothing = %v", got)
+	}
+
+	// Multi-value samples are pooled with single-value ones; samples without a number are left out.
+	one := 100.0
+	records := []MetricRecord{
+		{Values: []float64{1, 2, 3}},
+		{Values: []float64{4, 5}},
+		{ValueNum: &one},
+		{Value: "n/a"},
+	}
+	if got := HistoryPercentile(records, 50); got != 3.5 {
+		t.Errorf("pooled median = %v", got)
+	}
+	if got := HistoryPercentile(records, 100); got != 100 {
+		t.Errorf("pooled max = %v", got)
+	}
+}
+
+func TestValuesFrom(t *testing.T) {
+	for _, tt := range []struct {
+		in   interface{}
+		want []float64
+	}{
+		{[]float64{0.5, 0.7}, []float64{0.5, 0.7}},
+		{[]interface{}{0.5, 1, int64(2)}, []float64{0.5, 1, 2}}, // decoded from results.json, or built by hand
+		{[]interface{}{0.5, "high"}, nil},
+		{[]interface{}{}, nil},
+		{[]float64{}, nil},
+		{"0.5, 0.7", nil},
+		{0.5, nil},
+		{nil, nil},
+	} {
+		if got := ValuesFrom(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ValuesFrom(%#v) = %v", tt.in, got)
+		}
+	}
+	// The result does not alias the input.
+	in := []float64{1, 2}
+	ValuesFrom(in)[0] = 9
+	if in[0] != 1 {
+		t.Error("ValuesFrom returned its argument")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// component is a long-running part of the daemon. run blocks until ctx is
+// cancelled; returning earlier, or panicking, counts as a crash.
+type component struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// Component states reported by the supervisor.
+const (
+	componentRunning    = "running"
+	componentRestarting = "restarting" // crashed, waiting out the backoff
+	componentFailed     = "failed"     // crashed more than maxRestarts times
+	componentStopped    = "stopped"    // shut down cleanly
+)
+
+// componentHealth is the supervisor's view of one component.
+type componentHealth struct {
+	Name      string
+	Status    string
+	Restarts  int
+	LastError string
+	Since     time.Time
+}
+
+// supervisor runs components, restarting crashed ones with exponential backoff.
+type supervisor struct {
+	maxRestarts int
+	backoff     time.Duration // delay before the first restart, doubled after each
+	maxBackoff  time.Duration
+	onChange    func(componentHealth) // optional; called on every state change
+
+	mu     sync.Mutex
+	health map[string]*componentHealth
+	order  []string
+}
+
+// newSupervisor returns a supervisor giving each component maxRestarts restarts.
+func newSupervisor(maxRestarts int) *supervisor {
+	return &supervisor{
+		maxRestarts: maxRestarts,
+		backoff:     time.Second,
+		maxBackoff:  time.Minute,
+		health:      make(map[string]*componentHealth),
+	}
+}
+
+// run starts every component and blocks until all of them have stopped or failed.
+func (s *supervisor) run(ctx context.Context, components []component) {
+	var wg sync.WaitGroup
+	for _, c := range components {
+		wg.Add(1)
+		go func(c component) {
+			defer wg.Done()
+			s.supervise(ctx, c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// supervise runs one component until ctx is cancelled or it runs out of restarts.
+func (s *supervisor) supervise(ctx context.Context, c component) {
+	backoff := s.backoff
+	for restarts := 0; ; restarts++ {
+		s.set(c.name, componentRunning, restarts, nil)
+		err := runComponent(ctx, c)
+		if ctx.Err() != nil {
+			s.set(c.name, componentStopped, restarts, nil)
+			return
+		}
+		if err == nil {
+			err = errors.New("exited unexpectedly")
+		}
+		if restarts >= s.maxRestarts {
+			s.set(c.name, componentFailed, restarts, err)
+			return
+		}
+		s.set(c.name, componentRestarting, restarts+1, err)
+
+		select {
+		case <-ctx.Done():
+			s.set(c.name, componentStopped, restarts+1, err)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, s.maxBackoff)
+	}
+}
+
+// runComponent runs c once, turning a panic into an error.
+func runComponent(ctx context.Context, c component) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.run(ctx)
+}
+
+// set records a state change and reports it to onChange.
+func (s *supervisor) set(name, status string, restarts int, err error) {
+	s.mu.Lock()
+	h, ok := s.health[name]
+	if !ok {
+		h = &componentHealth{Name: name}
+		s.health[name] = h
+		s.order = append(s.order, name)
+	}
+	h.Status, h.Restarts, h.Since = status, restarts, time.Now()
+	if err != nil {
+		h.LastError = err.Error()
+	}
+	snapshot := *h
+	s.mu.Unlock()
+
+	if s.onChange != nil {
+		s.onChange(snapshot)
+	}
+}
+
+// snapshot returns the health of every component, in the order they started.
+func (s *supervisor) snapshot() []componentHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]componentHealth, 0, len(s.order))
+	for _, name := range s.order {
+		out = append(out, *s.health[name])
+	}
+	return out
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"observer/internal/testenv"
+)
+
+// testSupervisor returns a supervisor with millisecond backoff that records
+// every state change it reports.
+func testSupervisor(maxRestarts int) (*supervisor, *[]componentHealth) {
+	var mu sync.Mutex
+	var changes []componentHealth
+	s := newSupervisor(maxRestarts)
+	s.backoff, s.maxBackoff = time.Millisecond, 4*time.Millisecond
+	s.onChange = func(h componentHealth) {
+		mu.Lock()
+		changes = append(changes, h)
+		mu.Unlock()
+	}
+	return s, &changes
+}
+
+// runSupervisor runs components and fails the test if they have not all
+// stopped within a few seconds.
+func runSupervisor(t *testing.T, s *supervisor, ctx context.Context, components ...component) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx, components)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor did not return")
+	}
+}
+
+func TestSupervisorRestartsCrashedComponent(t *testing.T) {
+	s, changes := testSupervisor(5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := 0
+	flaky := component{name: "flaky", run: func(ctx context.Context) error {
+		runs++
+		switch runs {
+		case 1:
+			return errors.New("listener closed")
+		case 2:
+			panic("nil map")
+		}
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	runSupervisor(t, s, ctx, flaky)
+
+	if runs != 3 {
+		t.Errorf("ran %d times, want 3", runs)
+	}
+	health := s.snapshot()
+	if len(health) != 1 || health[0].Status != componentStopped || health[0].Restarts != 2 || health[0].LastError != "panic: nil map" {
+		t.Errorf("health = %+v", health)
+	}
+	var states []string
+	for _, h := range *changes {
+		states = append(states, h.Status)
+	}
+	want := "running restarting running restarting running stopped"
+	if got := strings.Join(states, " "); got != want {
+		t.Errorf("states = %s, want %s", got, want)
+	}
+	if (*changes)[1].LastError != "listener closed" {
+		t.Errorf("first crash = %+v", (*changes)[1])
+	}
+}
+
+func TestSupervisorGivesUp(t *testing.T) {
+	s, _ := testSupervisor(2)
+	runs := 0
+	exits := component{name: "exits", run: func(ctx context.Context) error {
+		runs++
+		return nil
+	}}
+	// Nothing cancels the context: run returns because the component failed.
+	runSupervisor(t, s, context.Background(), exits)
+
+	if runs != 3 {
+		t.Errorf("ran %d times, want the first run plus 2 restarts", runs)
+	}
+	health := s.snapshot()
+	if len(health) != 1 || health[0].Status != componentFailed || health[0].Restarts != 2 || health[0].LastError != "exited unexpectedly" {
+		t.Errorf("health = %+v", health)
+	}
+}
+
+func TestSupervisorCleanShutdown(t *testing.T) {
+	s, _ := testSupervisor(5)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var started sync.WaitGroup
+	started.Add(2)
+	blocking := func(name string) component {
+		return component{name: name, run: func(ctx context.Context) error {
+			started.Done()
+			<-ctx.Done()
+			return nil
+		}}
+	}
+	go func() {
+		started.Wait()
+		cancel()
+	}()
+	runSupervisor(t, s, ctx, blocking("collect"), blocking("flow"))
+
+	health := s.snapshot()
+	if len(health) != 2 {
+		t.Fatalf("health = %+v", health)
+	}
+	for _, h := range health {
+		if h.Status != componentStopped || h.Restarts != 0 || h.LastError != "" {
+			t.Errorf("%s: %+v", h.Name, h)
+		}
+	}
+}
+
+func TestSupervisorStopsDuringBackoff(t *testing.T) {
+	s, _ := testSupervisor(5)
+	s.backoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	s.onChange = func(h componentHealth) {
+		if h.Status == componentRestarting {
+			cancel()
+		}
+	}
+	crash := component{name: "crash", run: func(ctx context.Context) error {
+		return errors.New("boom")
+	}}
+	runSupervisor(t, s, ctx, crash)
+
+	if h := s.snapshot()[0]; h.Status != componentStopped || h.Restarts != 1 || h.LastError != "boom" {
+		t.Errorf("health = %+v", h)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for the daemon's concurrent writers.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDaemonNeedsComponents(t *testing.T) {
+	testenv.UseTempDirs(t)
+	writeConfig(t, `{"config_version": 1}`)
+	env, _, _ := testEnv()
+	if err := runDaemon(env, nil); err == nil || !strings.Contains(err.Error(), "no daemon components enabled") {
+		t.Errorf("err = %v", err)
+	}
+
+	writeConfig(t, `{"config_version": 1, "daemon": {"services": ["nope"]}}`)
+	if err := runDaemon(env, nil); err == nil || !strings.Contains(err.Error(), "'nope' not found") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	plugin "observer/base"
+	"observer/internal/testenv"
+)
+
+// servicePlugin is a daemon service: its first Serve crashes, the second
+// checks the pidfile and sends SIGHUP, and Reload asks for shutdown.
+type servicePlugin struct {
+	plugin.BasePlugin
+	pidfile string
+	serves  int
+	reloads int
+	pidSeen bool
+}
+
+func (p *servicePlugin) Name() string { return "svc" }
+
+func (p *servicePlugin) Serve(ctx context.Context) error {
+	p.serves++
+	if p.serves == 1 {
+		return errors.New("bind: address already in use")
+	}
+	_, err := os.Stat(p.pidfile)
+	p.pidSeen = err == nil
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	<-ctx.Done()
+	return nil
+}
+
+func (p *servicePlugin) Reload() error {
+	p.reloads++
+	return syscall.Kill(os.Getpid(), syscall.SIGTERM)
+}
+
+func TestDaemonSupervisesServices(t *testing.T) {
+	dir := testenv.UseTempDirs(t)
+	pidfile := filepath.Join(dir, "nord.pid")
+	writeConfig(t, `{"config_version": 1, "daemon": {"pidfile": "`+pidfile+`", "max_restarts": 2, "services": ["svc"]}}`)
+
+	env, _, _ := testEnv()
+	var stdout syncBuffer
+	env.stdout = &stdout
+	svc := &servicePlugin{pidfile: pidfile}
+	env.controller.AddPlugin(svc)
+
+	if err := runDaemon(env, nil); err != nil {
+		t.Fatalf("runDaemon: %v\n%s", err, stdout.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"--- Starting daemon with 1 components ---",
+		"svc crashed (bind: address already in use), restart 1",
+		"svc: reloaded",
+		"--- Received terminated, shutting down ---",
+		"svc: stopped, 1 restarts, last error: bind: address already in use",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if svc.serves != 2 || svc.reloads != 1 || !svc.pidSeen {
+		t.Errorf("serves %d, reloads %d, pidfile seen %v", svc.serves, svc.reloads, svc.pidSeen)
+	}
+	if _, err := os.Stat(pidfile); !os.IsNotExist(err) {
+		t.Errorf("pidfile left behind: %v", err)
+	}
+}